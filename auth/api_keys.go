@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/juju/errors"
+)
+
+// API keys authenticate the clients of internal deployments without OAuth
+// infrastructure: each client sends its key in the X-API-Key header (or in an
+// "Authorization: ApiKey ..." header), and only the SHA-256 hashes of keys are
+// stored.  Keys are authorized with the SMART system scopes that their
+// permissions map to (see APIKey.Scopes), so access to FHIR resources is
+// authorized as it is for SMART.
+
+// APIKeyHeader is the header in which clients send their API keys
+const APIKeyHeader = "X-API-Key"
+
+// apiKeyPrefix makes API keys recognisable (e.g. by secret scanners)
+const apiKeyPrefix = "fhir_"
+
+// APIKey is a client's API key, without the key itself
+type APIKey struct {
+	ID   string `bson:"_id" json:"id"`
+	Name string `bson:"name" json:"name"`
+	// Hash is the SHA-256 hash of the key (see HashAPIKey)
+	Hash string `bson:"hash" json:"-"`
+	// ReadOnly limits the key to reads and searches
+	ReadOnly bool `bson:"readOnly" json:"readOnly"`
+	// ResourceTypes limits the key to the resources of these types (if it has
+	// any), which also keeps it from the routes that aren't those of a resource
+	// type (e.g. transactions and system-wide searches)
+	ResourceTypes []string `bson:"resourceTypes,omitempty" json:"resourceTypes,omitempty"`
+	// Admin allows the key to manage API keys (and the server's tenants)
+	Admin bool `bson:"admin" json:"admin"`
+	// Tenants are the ids of the tenants whose resources the key can access
+	// (when the server has tenants), or * for all of them
+	Tenants []string  `bson:"tenants,omitempty" json:"tenants,omitempty"`
+	Created time.Time `bson:"created" json:"created"`
+}
+
+// APIKeys provides a server configuration that authenticates clients with API
+// keys, which are looked up by the server, and authorizes access to FHIR
+// resources with the SMART system scopes of their permissions.
+func APIKeys() Config {
+	return Config{Method: AuthTypeAPIKey}
+}
+
+// NewAPIKey generates an API key with its permissions, returning the key itself
+// (which is only stored as its hash, so can't be retrieved later) along with it.
+func NewAPIKey(name string, readOnly bool, resourceTypes []string, admin bool) (*APIKey, string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", errors.Annotate(err, "Couldn't generate an API key")
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	apiKey := &APIKey{
+		ID:            uuid.New().String(),
+		Name:          name,
+		Hash:          HashAPIKey(key),
+		ReadOnly:      readOnly,
+		ResourceTypes: resourceTypes,
+		Admin:         admin,
+		Created:       time.Now().UTC(),
+	}
+	return apiKey, key, nil
+}
+
+// HashAPIKey returns the hash of an API key with which it's stored and looked
+// up.  Keys are long random strings, so they don't need a slow, salted hash.
+func HashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// Scopes returns the SMART system scopes of the key's permissions (e.g.
+// system/*.* or system/Patient.read)
+func (k *APIKey) Scopes() []string {
+	permissions := "*"
+	if k.ReadOnly {
+		permissions = "read"
+	}
+	if len(k.ResourceTypes) == 0 {
+		return []string{"system/*." + permissions}
+	}
+	scopes := make([]string, len(k.ResourceTypes))
+	for i, resourceType := range k.ResourceTypes {
+		scopes[i] = fmt.Sprintf("system/%s.%s", resourceType, permissions)
+	}
+	return scopes
+}
+
+// APIKeyLookup returns the API key with a hash (see HashAPIKey), or nil if
+// there's no such key
+type APIKeyLookup func(ctx context.Context, hash string) (*APIKey, error)
+
+// APIKeyHandler creates a gin.HandlerFunc that authenticates requests with the
+// API keys that lookup finds.
+//
+// Requests without valid keys are aborted with a 401. If a valid key is
+// provided, the gin.Context is augmented by setting the following variables:
+// scopes will be a []string containing the key's scopes (see APIKey.Scopes),
+// subject will be the key's name, clientID will be its id, tenants will be
+// its tenants, and apiKey will be the *APIKey itself.
+func APIKeyHandler(lookup APIKeyLookup) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.Request.Header.Get(APIKeyHeader)
+		if auth := c.Request.Header.Get("Authorization"); key == "" && strings.HasPrefix(auth, "ApiKey ") {
+			key = strings.TrimPrefix(auth, "ApiKey ")
+		}
+		if key == "" {
+			c.Header("WWW-Authenticate", `ApiKey realm="FHIR"`)
+			c.String(http.StatusUnauthorized, "No API key provided in the X-API-Key header")
+			c.Abort()
+			return
+		}
+		apiKey, err := lookup(c.Request.Context(), HashAPIKey(key))
+		if err != nil {
+			c.String(http.StatusInternalServerError, "Couldn't look up the API key: %s", err.Error())
+			c.Abort()
+			return
+		} else if apiKey == nil {
+			c.Header("WWW-Authenticate", `ApiKey realm="FHIR", error="invalid_key"`)
+			c.String(http.StatusUnauthorized, "Provided API key isn't valid")
+			c.Abort()
+			return
+		}
+		c.Set("scopes", apiKey.Scopes())
+		c.Set("subject", apiKey.Name)
+		c.Set("clientID", apiKey.ID)
+		c.Set("tenants", apiKey.Tenants)
+		c.Set("apiKey", apiKey)
+	}
+}
+
+// APIKeyAdminHandler middleware limits the routes that manage API keys to the
+// requests authenticated with admin keys, responding to others with a 403 and
+// an OperationOutcome
+func APIKeyAdminHandler(c *gin.Context) {
+	if apiKey, exists := c.Get("apiKey"); !exists || !apiKey.(*APIKey).Admin {
+		forbidden(c, "Only admin API keys can manage API keys")
+	}
+}
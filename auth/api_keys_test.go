@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+type APIKeysSuite struct {
+	Keys   map[string]*APIKey
+	Engine *gin.Engine
+}
+
+var _ = Suite(&APIKeysSuite{})
+
+func (s *APIKeysSuite) SetUpTest(c *C) {
+	s.Keys = make(map[string]*APIKey)
+	s.Engine = gin.New()
+	s.Engine.Use(APIKeyHandler(func(ctx context.Context, hash string) (*APIKey, error) {
+		return s.Keys[hash], nil
+	}))
+	s.Engine.GET("/Patient", SMARTScopesHandler("Patient"), func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString("subject"))
+	})
+	s.Engine.POST("/Patient", SMARTScopesHandler("Patient"), func(c *gin.Context) {
+		c.Status(http.StatusCreated)
+	})
+	s.Engine.GET("/$api-keys", APIKeyAdminHandler, func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+}
+
+// key generates a key with permissions, which the handler finds
+func (s *APIKeysSuite) key(readOnly bool, resourceTypes []string, admin bool) string {
+	apiKey, key, err := NewAPIKey("reporting", readOnly, resourceTypes, admin)
+	util.CheckErr(err)
+	s.Keys[apiKey.Hash] = apiKey
+	return key
+}
+
+func (s *APIKeysSuite) request(method, path, header, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if key != "" {
+		req.Header.Set(header, key)
+	}
+	recorder := httptest.NewRecorder()
+	s.Engine.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func (s *APIKeysSuite) TestScopes(c *C) {
+	c.Assert((&APIKey{}).Scopes(), DeepEquals, []string{"system/*.*"})
+	c.Assert((&APIKey{ReadOnly: true}).Scopes(), DeepEquals, []string{"system/*.read"})
+	c.Assert((&APIKey{ResourceTypes: []string{"Patient", "Observation"}}).Scopes(), DeepEquals,
+		[]string{"system/Patient.*", "system/Observation.*"})
+}
+
+func (s *APIKeysSuite) TestAuthentication(c *C) {
+	key := s.key(false, nil, false)
+	c.Assert(HashAPIKey(key), Not(Equals), key)
+
+	res := s.request("GET", "/Patient", APIKeyHeader, key)
+	c.Assert(res.Code, Equals, http.StatusOK)
+	c.Assert(res.Body.String(), Equals, "reporting")
+	res = s.request("GET", "/Patient", "Authorization", "ApiKey "+key)
+	c.Assert(res.Code, Equals, http.StatusOK)
+
+	res = s.request("GET", "/Patient", APIKeyHeader, "")
+	c.Assert(res.Code, Equals, http.StatusUnauthorized)
+	c.Assert(res.Header().Get("WWW-Authenticate"), Equals, `ApiKey realm="FHIR"`)
+	res = s.request("GET", "/Patient", APIKeyHeader, key+"x")
+	c.Assert(res.Code, Equals, http.StatusUnauthorized)
+}
+
+func (s *APIKeysSuite) TestPermissions(c *C) {
+	readOnly := s.key(true, nil, false)
+	c.Assert(s.request("GET", "/Patient", APIKeyHeader, readOnly).Code, Equals, http.StatusOK)
+	c.Assert(s.request("POST", "/Patient", APIKeyHeader, readOnly).Code, Equals, http.StatusForbidden)
+
+	observations := s.key(false, []string{"Observation"}, false)
+	c.Assert(s.request("GET", "/Patient", APIKeyHeader, observations).Code, Equals, http.StatusForbidden)
+
+	// Only admin keys can manage keys
+	c.Assert(s.request("GET", "/$api-keys", APIKeyHeader, readOnly).Code, Equals, http.StatusForbidden)
+	admin := s.key(false, nil, true)
+	c.Assert(s.request("GET", "/$api-keys", APIKeyHeader, admin).Code, Equals, http.StatusOK)
+}
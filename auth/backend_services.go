@@ -0,0 +1,327 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/juju/errors"
+)
+
+// The SMART Backend Services profile (http://hl7.org/fhir/uv/bulkdata/authorization/)
+// authorizes systems without users (e.g. those running bulk exports) with the
+// client credentials grant: clients register their public keys (as a JWKS, or
+// the URL of one) and get access tokens for system scopes by authenticating
+// with JWTs signed with their private keys.  The server can act as the
+// authorization server of these clients, issuing access tokens signed with its
+// own key, or accept the tokens of an external authorization server (see
+// SMART).
+
+const (
+	clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+	// How long the access tokens of backend services are valid for
+	backendAccessTokenLifetime = 5 * time.Minute
+	// The longest that client assertions can be valid for
+	maxClientAssertionLifetime = 5 * time.Minute
+	// The key ID of the server's signing key in its JWKS
+	signingKeyID = "fhir-server"
+)
+
+// BackendClient is a client registered for SMART Backend Services, with its
+// public keys (in a JWKS, or at a JWKS URL), the system scopes it can be
+// granted (e.g. "system/*.read") and the tenants its tokens can access (the
+// ids of the server's tenants, or * for all of them)
+type BackendClient struct {
+	ClientID string          `json:"client_id"`
+	JWKSURL  string          `json:"jwks_url,omitempty"`
+	JWKS     json.RawMessage `json:"jwks,omitempty"`
+	Scope    string          `json:"scope"`
+	Tenants  []string        `json:"tenants,omitempty"`
+}
+
+// LoadBackendClients loads the clients registered for SMART Backend Services
+// from a JSON file of an array of BackendClients
+func LoadBackendClients(path string) ([]BackendClient, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Annotate(err, "Couldn't read the backend clients")
+	}
+	var clients []BackendClient
+	if err := json.Unmarshal(contents, &clients); err != nil {
+		return nil, errors.Annotate(err, "Couldn't decode the backend clients")
+	}
+	for _, client := range clients {
+		if client.ClientID == "" {
+			return nil, errors.NotValidf("A backend client without a client_id")
+		} else if (client.JWKSURL == "") == (len(client.JWKS) == 0) {
+			return nil, errors.NotValidf("The backend client %s, which needs a jwks or a jwks_url,", client.ClientID)
+		} else if len(client.JWKS) > 0 {
+			if _, err := parseJWKS(client.JWKS); err != nil {
+				return nil, errors.Annotatef(err, "The JWKS of the backend client %s", client.ClientID)
+			}
+		}
+	}
+	return clients, nil
+}
+
+// LoadSigningKey loads the RSA private key that the server signs access tokens
+// with from a PEM file (of a PKCS #1 or PKCS #8 key), or generates one if the
+// path is empty (whose tokens are only valid until the server restarts)
+func LoadSigningKey(path string) (*rsa.PrivateKey, error) {
+	if path == "" {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		return key, errors.Annotate(err, "Couldn't generate a signing key")
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Annotate(err, "Couldn't read the signing key")
+	}
+	block, _ := pem.Decode(contents)
+	if block == nil {
+		return nil, errors.NotValidf("The signing key's PEM")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Annotate(err, "Couldn't parse the signing key")
+	}
+	rsaKey, isRSA := key.(*rsa.PrivateKey)
+	if !isRSA {
+		return nil, errors.NotSupportedf("Signing keys that aren't RSA keys")
+	}
+	return rsaKey, nil
+}
+
+// BackendServices provides a server configuration that will act as the
+// authorization server of SMART Backend Services clients, issuing access tokens
+// at [serverURL]/auth/token that are signed with signingKey (whose public key
+// is at [serverURL]/auth/jwks).  Access to FHIR resources is authorized with
+// the tokens' SMART scopes, as for SMART.
+//
+// serverURL is the server's base URL, which is the iss and aud of its tokens
+// clients are the registered clients
+func BackendServices(serverURL string, clients []BackendClient, signingKey *rsa.PrivateKey) Config {
+	serverURL = strings.TrimSuffix(serverURL, "/")
+	return Config{Method: AuthTypeSMART, Issuer: serverURL, Audience: serverURL,
+		TokenURL: serverURL + "/auth/token", JWKSURL: serverURL + "/auth/jwks",
+		BackendClients: clients, SigningKey: signingKey}
+}
+
+// SMARTTokenHandler provides a gin.HandlerFunc for the token endpoint of SMART
+// Backend Services, which issues access tokens for the client credentials
+// grant to registered clients that authenticate with client assertions (JWTs
+// signed with their keys, for the token endpoint, that are only used once).
+// They're granted the system scopes they request that are among those they're
+// registered with.  Errors are responded to as in RFC 6749.
+func SMARTTokenHandler(config Config) gin.HandlerFunc {
+	clientKeys := make(map[string]*jwksCache)
+	clients := make(map[string]BackendClient)
+	for _, client := range config.BackendClients {
+		clients[client.ClientID] = client
+		if client.JWKSURL != "" {
+			clientKeys[client.ClientID] = newJWKSCache(client.JWKSURL)
+		} else {
+			keys, _ := parseJWKS(client.JWKS) // checked by LoadBackendClients
+			clientKeys[client.ClientID] = newStaticJWKS(keys)
+		}
+	}
+	usedAssertions := &assertionIDs{ids: make(map[string]time.Time)}
+
+	return func(c *gin.Context) {
+		if c.PostForm("grant_type") != "client_credentials" {
+			tokenError(c, http.StatusBadRequest, "unsupported_grant_type", "Only the client_credentials grant is supported")
+			return
+		}
+		if c.PostForm("client_assertion_type") != clientAssertionType {
+			tokenError(c, http.StatusBadRequest, "invalid_request", "The client_assertion_type must be "+clientAssertionType)
+			return
+		}
+
+		// the client is found by the unverified issuer of the assertion, whose keys then verify it
+		assertion := c.PostForm("client_assertion")
+		unverified := &jwtClaims{}
+		if parts := strings.Split(assertion, "."); len(parts) != 3 || decodeJWTPart(parts[1], unverified) != nil {
+			tokenError(c, http.StatusBadRequest, "invalid_request", "The client_assertion isn't a JWT")
+			return
+		}
+		client, registered := clients[unverified.Issuer]
+		if !registered {
+			tokenError(c, http.StatusUnauthorized, "invalid_client", "The client isn't registered")
+			return
+		}
+		claims := &jwtClaims{}
+		if err := verifyJWT(assertion, clientKeys[client.ClientID], claims); err != nil {
+			tokenError(c, http.StatusUnauthorized, "invalid_client", err.Error())
+			return
+		}
+		if err := checkClientAssertion(claims, client.ClientID, config.TokenURL, usedAssertions); err != nil {
+			tokenError(c, http.StatusUnauthorized, "invalid_client", err.Error())
+			return
+		}
+
+		scopes := grantedScopes(c.PostForm("scope"), client.Scope)
+		if len(scopes) == 0 {
+			tokenError(c, http.StatusBadRequest, "invalid_scope", "None of the requested scopes can be granted")
+			return
+		}
+		now := time.Now()
+		expiry, issuedAt := now.Add(backendAccessTokenLifetime).Unix(), now.Unix()
+		token, err := signJWT(&jwtClaims{
+			Issuer:   config.Issuer,
+			Subject:  client.ClientID,
+			Audience: config.Audience,
+			Expiry:   &expiry,
+			IssuedAt: &issuedAt,
+			ID:       uuid.New().String(),
+			Scope:    strings.Join(scopes, " "),
+			ClientID: client.ClientID,
+			Tenants:  client.Tenants,
+		}, config.SigningKey, signingKeyID)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, gin.H{
+			"access_token": token,
+			"token_type":   "bearer",
+			"expires_in":   int(backendAccessTokenLifetime.Seconds()),
+			"scope":        strings.Join(scopes, " "),
+		})
+	}
+}
+
+// checkClientAssertion checks the claims of a verified client assertion: its
+// issuer and subject must be the client, its audience the token endpoint, and
+// it must be unexpired, valid for at most 5 minutes and not used before
+func checkClientAssertion(claims *jwtClaims, clientID, tokenURL string, used *assertionIDs) error {
+	if claims.Issuer != clientID || claims.Subject != clientID {
+		return errors.Errorf("The client assertion's iss and sub must be the client_id")
+	}
+	if !claims.hasAudience(tokenURL) {
+		return errors.Errorf("The client assertion's audience isn't %s", tokenURL)
+	}
+	if err := claims.checkTimes(); err != nil {
+		return err
+	}
+	expiry := time.Unix(*claims.Expiry, 0)
+	if expiry.After(time.Now().Add(maxClientAssertionLifetime + jwtLeeway)) {
+		return errors.Errorf("The client assertion can't be valid for more than %s", maxClientAssertionLifetime)
+	}
+	if claims.ID == "" {
+		return errors.Errorf("The client assertion has no jti")
+	}
+	if !used.add(clientID+" "+claims.ID, expiry) {
+		return errors.Errorf("The client assertion has already been used")
+	}
+	return nil
+}
+
+// assertionIDs are the IDs of the client assertions that have been used, until
+// they expire
+type assertionIDs struct {
+	sync.Mutex
+	ids map[string]time.Time
+}
+
+// add records the use of an assertion, returning false if it's been used before
+func (a *assertionIDs) add(id string, expiry time.Time) bool {
+	a.Lock()
+	defer a.Unlock()
+	now := time.Now()
+	for usedID, usedExpiry := range a.ids {
+		if usedExpiry.Add(jwtLeeway).Before(now) {
+			delete(a.ids, usedID)
+		}
+	}
+	if _, used := a.ids[id]; used {
+		return false
+	}
+	a.ids[id] = expiry
+	return true
+}
+
+// grantedScopes returns the requested system scopes that are included in those
+// a client is registered with (e.g. system/Patient.read by system/*.read), and
+// the TenantAdminScope if it's registered with it
+func grantedScopes(requested, registered string) []string {
+	var granted []string
+	for _, scope := range strings.Fields(requested) {
+		if scope == TenantAdminScope {
+			for _, registeredScope := range strings.Fields(registered) {
+				if registeredScope == TenantAdminScope {
+					granted = append(granted, scope)
+					break
+				}
+			}
+			continue
+		}
+		requestedScope, ok := ParseSMARTScope(scope)
+		if !ok || requestedScope.Context != "system" {
+			continue
+		}
+		for _, registeredScope := range strings.Fields(registered) {
+			if s, ok := ParseSMARTScope(registeredScope); ok && s.Context == "system" && s.includes(requestedScope) {
+				granted = append(granted, scope)
+				break
+			}
+		}
+	}
+	return granted
+}
+
+// includes returns whether a scope grants all the permissions of another
+func (s SMARTScope) includes(other SMARTScope) bool {
+	return (s.ResourceType == "*" || s.ResourceType == other.ResourceType) &&
+		(s.Create || !other.Create) && (s.Read || !other.Read) && (s.Update || !other.Update) &&
+		(s.Delete || !other.Delete) && (s.Search || !other.Search)
+}
+
+func tokenError(c *gin.Context, status int, code, description string) {
+	c.Header("Cache-Control", "no-store")
+	c.JSON(status, gin.H{"error": code, "error_description": description})
+	c.Abort()
+}
+
+// SMARTJWKSHandler serves the JWKS of the key that the server signs access
+// tokens with, for resource servers that accept them
+func SMARTJWKSHandler(config Config) gin.HandlerFunc {
+	key := config.SigningKey.Public().(*rsa.PublicKey)
+	jwks := gin.H{"keys": []gin.H{{
+		"kty": "RSA",
+		"kid": signingKeyID,
+		"use": "sig",
+		"alg": "RS256",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(bigEndianInt(key.E)),
+	}}}
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, jwks)
+	}
+}
+
+func bigEndianInt(i int) []byte {
+	var bytes []byte
+	for ; i > 0; i >>= 8 {
+		bytes = append([]byte{byte(i)}, bytes...)
+	}
+	return bytes
+}
+
+// signingKeys returns the public key of a server's signing key, as a static JWKS
+func signingKeys(key *rsa.PrivateKey) *jwksCache {
+	return newStaticJWKS(map[string]crypto.PublicKey{signingKeyID: key.Public()})
+}
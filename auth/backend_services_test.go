@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+type BackendServicesSuite struct {
+	ClientKey *rsa.PrivateKey
+	Config    Config
+	Engine    *gin.Engine
+}
+
+var _ = Suite(&BackendServicesSuite{})
+
+func (s *BackendServicesSuite) SetUpSuite(c *C) {
+	var err error
+	s.ClientKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	util.CheckErr(err)
+	jwks := fmt.Sprintf(`{"keys": [{"kty": "RSA", "kid": "client-key", "n": %q, "e": %q}]}`,
+		base64.RawURLEncoding.EncodeToString(s.ClientKey.N.Bytes()),
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.ClientKey.E)).Bytes()))
+	clients := []BackendClient{{ClientID: "bulk-client", JWKS: json.RawMessage(jwks), Scope: "system/*.read system/Patient.write", Tenants: []string{"acme"}}}
+	signingKey, err := LoadSigningKey("")
+	util.CheckErr(err)
+	s.Config = BackendServices("https://fhir.example.com/", clients, signingKey)
+
+	s.Engine = gin.New()
+	s.Engine.POST("/auth/token", SMARTTokenHandler(s.Config))
+	s.Engine.GET("/auth/jwks", SMARTJWKSHandler(s.Config))
+	handler := func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, ctx.Request.URL.RawQuery)
+	}
+	s.Engine.GET("/Patient", SMARTBearerTokenHandler(s.Config), SMARTScopesHandler("Patient"), handler)
+	s.Engine.GET("/$export", SMARTBearerTokenHandler(s.Config), SMARTBulkExportHandler, handler)
+	s.Engine.GET("/tenants", SMARTBearerTokenHandler(s.Config), func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, strings.Join(ctx.GetStringSlice("tenants"), ","))
+	})
+}
+
+// assertion returns a client assertion signed with the client's key
+func (s *BackendServicesSuite) assertion(issuer, audience, jti string, lifetime time.Duration) string {
+	expiry := time.Now().Add(lifetime).Unix()
+	assertion, err := signJWT(&jwtClaims{Issuer: issuer, Subject: issuer, Audience: audience, Expiry: &expiry, ID: jti},
+		s.ClientKey, "client-key")
+	util.CheckErr(err)
+	return assertion
+}
+
+// requestToken requests an access token, returning the response's status and JSON
+func (s *BackendServicesSuite) requestToken(assertion, scope string) (int, map[string]interface{}) {
+	form := url.Values{
+		"grant_type":            {"client_credentials"},
+		"client_assertion_type": {clientAssertionType},
+		"client_assertion":      {assertion},
+		"scope":                 {scope},
+	}
+	r, err := http.NewRequest("POST", "/auth/token", strings.NewReader(form.Encode()))
+	util.CheckErr(err)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rw := httptest.NewRecorder()
+	s.Engine.ServeHTTP(rw, r)
+	var response map[string]interface{}
+	util.CheckErr(json.Unmarshal(rw.Body.Bytes(), &response))
+	return rw.Code, response
+}
+
+func (s *BackendServicesSuite) get(path, token string) *httptest.ResponseRecorder {
+	r, err := http.NewRequest("GET", path, nil)
+	util.CheckErr(err)
+	r.Header.Set("Authorization", "Bearer "+token)
+	rw := httptest.NewRecorder()
+	s.Engine.ServeHTTP(rw, r)
+	return rw
+}
+
+func (s *BackendServicesSuite) TestTokens(c *C) {
+	tokenURL := "https://fhir.example.com/auth/token"
+	status, response := s.requestToken(s.assertion("bulk-client", tokenURL, "1", 4*time.Minute),
+		"system/Patient.read system/Observation.read system/Observation.write launch")
+	c.Assert(status, Equals, http.StatusOK, Commentf("%v", response))
+	c.Assert(response["token_type"], Equals, "bearer")
+	c.Assert(response["scope"], Equals, "system/Patient.read system/Observation.read")
+	token := response["access_token"].(string)
+
+	// The token is accepted by the server, with its scopes
+	c.Assert(s.get("/Patient", token).Code, Equals, http.StatusOK)
+	rr := s.get("/$export", token)
+	c.Assert(rr.Code, Equals, http.StatusOK)
+	c.Assert(rr.Body.String(), Equals, "_type=Patient%2CObservation")
+	c.Assert(s.get("/$export?_type=Patient", token).Code, Equals, http.StatusOK)
+	c.Assert(s.get("/$export?_type=Patient,Condition", token).Code, Equals, http.StatusForbidden)
+	c.Assert(s.get("/tenants", token).Body.String(), Equals, "acme")
+
+	// Invalid assertions
+	status, response = s.requestToken(s.assertion("bulk-client", tokenURL, "1", 4*time.Minute), "system/Patient.read")
+	c.Assert(status, Equals, http.StatusUnauthorized)
+	c.Assert(response["error"], Equals, "invalid_client")
+	for _, invalid := range []string{
+		s.assertion("other-client", tokenURL, "2", 4*time.Minute),
+		s.assertion("bulk-client", "https://other.example.com/token", "3", 4*time.Minute),
+		s.assertion("bulk-client", tokenURL, "4", -time.Hour),
+		s.assertion("bulk-client", tokenURL, "5", time.Hour),
+		s.assertion("bulk-client", tokenURL, "", 4*time.Minute),
+	} {
+		status, response = s.requestToken(invalid, "system/Patient.read")
+		c.Assert(status, Equals, http.StatusUnauthorized)
+		c.Assert(response["error"], Equals, "invalid_client")
+	}
+
+	// Scopes that the client isn't registered with
+	status, response = s.requestToken(s.assertion("bulk-client", tokenURL, "6", 4*time.Minute), "system/Observation.write user/*.read")
+	c.Assert(status, Equals, http.StatusBadRequest)
+	c.Assert(response["error"], Equals, "invalid_scope")
+}
+
+func (s *BackendServicesSuite) TestGrantedScopes(c *C) {
+	c.Assert(grantedScopes("system/Patient.rs system/Patient.cud system/*.read", "system/*.read system/Patient.c"),
+		DeepEquals, []string{"system/Patient.rs", "system/*.read"})
+	c.Assert(grantedScopes("system/Patient.read", "user/*.*"), IsNil)
+	c.Assert(grantedScopes("system/$tenants", "system/*.*"), IsNil)
+	c.Assert(grantedScopes("system/$tenants system/*.read", "system/*.* system/$tenants"),
+		DeepEquals, []string{"system/$tenants", "system/*.read"})
+}
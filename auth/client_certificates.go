@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/juju/errors"
+)
+
+// Mutual TLS authenticates server-to-server integrations with client
+// certificates: the server only accepts certificates issued by the CAs it's
+// configured with (and not by the system's CAs), and maps the subjects of
+// their certificates to client identities with SMART system scopes (see
+// CertificateIdentity), so access to FHIR resources is authorized as it is
+// for SMART.  Client certificates can be required of every request (see
+// MutualTLS), or used alongside bearer tokens or API keys, authenticating
+// the requests with certificates that the others don't.
+
+// CertificateIdentity maps the subject of client certificates (and
+// optionally their issuer) to a client and the system scopes it's granted
+// (e.g. "system/*.read"), and the tenants it can access
+type CertificateIdentity struct {
+	// Subject is the distinguished name of certificates' subject, as in RFC
+	// 2253 (e.g. "CN=lab-system,O=Acme")
+	Subject string `json:"subject"`
+	// Issuer (optional) is the distinguished name that certificates' issuer
+	// must have, if several of the CAs issue certificates to the subject
+	Issuer   string `json:"issuer,omitempty"`
+	ClientID string `json:"client_id"`
+	Scope    string `json:"scope"`
+	// Tenants are the ids of the tenants whose resources the client can
+	// access (when the server has tenants), or * for all of them
+	Tenants []string `json:"tenants,omitempty"`
+}
+
+// matches returns whether the identity is that of a certificate
+func (identity CertificateIdentity) matches(certificate *x509.Certificate) bool {
+	return identity.Subject == certificate.Subject.String() &&
+		(identity.Issuer == "" || identity.Issuer == certificate.Issuer.String())
+}
+
+// MutualTLS provides a server configuration that requires client
+// certificates of every request, which are mapped to client identities, and
+// authorizes access to FHIR resources with the identities' SMART system
+// scopes.  The server must be run with TLS, verifying client certificates
+// with the CAs of LoadCertificatePool.
+func MutualTLS(identities []CertificateIdentity) Config {
+	return Config{Method: AuthTypeMutualTLS, CertificateIdentities: identities}
+}
+
+// LoadCertificateIdentities loads the identities of client certificates from
+// a JSON file of an array of CertificateIdentities
+func LoadCertificateIdentities(path string) ([]CertificateIdentity, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Annotate(err, "Couldn't read the certificate identities")
+	}
+	var identities []CertificateIdentity
+	if err := json.Unmarshal(contents, &identities); err != nil {
+		return nil, errors.Annotate(err, "Couldn't decode the certificate identities")
+	}
+	for _, identity := range identities {
+		if identity.Subject == "" {
+			return nil, errors.NotValidf("A certificate identity without a subject")
+		} else if identity.ClientID == "" {
+			return nil, errors.NotValidf("The certificate identity of %s, which needs a client_id,", identity.Subject)
+		}
+	}
+	return identities, nil
+}
+
+// LoadCertificatePool loads the CAs that client certificates must be issued
+// by from a PEM file of their certificates
+func LoadCertificatePool(path string) (*x509.CertPool, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Annotate(err, "Couldn't read the client CAs")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(contents) {
+		return nil, errors.NotValidf("The client CAs' PEM, which has no certificates,")
+	}
+	return pool, nil
+}
+
+// ClientCertificateHandler creates a gin.HandlerFunc that authenticates
+// requests with verified client certificates (those that TLS verified with
+// the client CAs) with the identities of a configuration.  Requests without
+// certificates are handled by fallback, which authenticates them otherwise
+// (e.g. with SMARTBearerTokenHandler), or are aborted with a 401 if it's nil.
+//
+// Requests whose certificates have no identity are aborted with a 401. If a
+// certificate has one, the gin.Context is augmented by setting the following
+// variables: scopes will be a []string containing the identity's scopes,
+// subject will be the certificate's subject, clientID will be the identity's
+// client, and tenants will be its tenants.
+func ClientCertificateHandler(config Config, fallback gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.TLS == nil || len(c.Request.TLS.VerifiedChains) == 0 {
+			if fallback != nil {
+				fallback(c)
+				return
+			}
+			c.String(http.StatusUnauthorized, "No client certificate provided")
+			c.Abort()
+			return
+		}
+		certificate := c.Request.TLS.VerifiedChains[0][0]
+		for _, identity := range config.CertificateIdentities {
+			if identity.matches(certificate) {
+				c.Set("scopes", strings.Fields(identity.Scope))
+				c.Set("subject", certificate.Subject.String())
+				c.Set("clientID", identity.ClientID)
+				c.Set("tenants", identity.Tenants)
+				return
+			}
+		}
+		c.String(http.StatusUnauthorized, "The client certificate of %s isn't mapped to a client", certificate.Subject.String())
+		c.Abort()
+	}
+}
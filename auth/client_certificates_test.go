@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+type ClientCertificatesSuite struct {
+	CA     *x509.Certificate
+	CAKey  *rsa.PrivateKey
+	Config Config
+}
+
+var _ = Suite(&ClientCertificatesSuite{})
+
+func (s *ClientCertificatesSuite) SetUpSuite(c *C) {
+	var err error
+	s.CAKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	util.CheckErr(err)
+	s.CA = s.certificate(pkix.Name{CommonName: "Acme CA"}, true)
+	s.Config = MutualTLS([]CertificateIdentity{
+		{Subject: "CN=lab-system,O=Acme", ClientID: "lab", Scope: "system/Observation.* system/Patient.read", Tenants: []string{"acme"}},
+		{Subject: "CN=billing,O=Acme", Issuer: "CN=Other CA", ClientID: "billing", Scope: "system/*.*"},
+	})
+}
+
+// certificate returns a certificate of a subject issued by the CA (or the CA's
+// own certificate)
+func (s *ClientCertificatesSuite) certificate(subject pkix.Name, isCA bool) *x509.Certificate {
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               subject,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	parent := s.CA
+	if isCA {
+		parent = template
+		template.KeyUsage = x509.KeyUsageCertSign
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &s.CAKey.PublicKey, s.CAKey)
+	util.CheckErr(err)
+	certificate, err := x509.ParseCertificate(der)
+	util.CheckErr(err)
+	return certificate
+}
+
+// request makes a request with a verified client certificate (if not nil),
+// falling back to a handler that authenticates the others
+func (s *ClientCertificatesSuite) request(path string, certificate *x509.Certificate, fallback gin.HandlerFunc) *httptest.ResponseRecorder {
+	engine := gin.New()
+	engine.Use(ClientCertificateHandler(s.Config, fallback))
+	engine.GET("/Patient", SMARTScopesHandler("Patient"), func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString("clientID"))
+	})
+	engine.GET("/Observation", SMARTScopesHandler("Observation"), func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString("clientID"))
+	})
+	engine.GET("/tenants", func(c *gin.Context) {
+		c.String(http.StatusOK, strings.Join(c.GetStringSlice("tenants"), ","))
+	})
+	req := httptest.NewRequest("GET", path, nil)
+	if certificate != nil {
+		req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{certificate},
+			VerifiedChains: [][]*x509.Certificate{{certificate, s.CA}}}
+	}
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func (s *ClientCertificatesSuite) TestIdentities(c *C) {
+	lab := s.certificate(pkix.Name{CommonName: "lab-system", Organization: []string{"Acme"}}, false)
+	res := s.request("/Patient", lab, nil)
+	c.Assert(res.Code, Equals, http.StatusOK)
+	c.Assert(res.Body.String(), Equals, "lab")
+	c.Assert(s.request("/Observation", lab, nil).Code, Equals, http.StatusOK)
+	c.Assert(s.request("/tenants", lab, nil).Body.String(), Equals, "acme")
+
+	// Certificates without identities, or of other issuers, aren't authenticated
+	unknown := s.certificate(pkix.Name{CommonName: "unknown", Organization: []string{"Acme"}}, false)
+	c.Assert(s.request("/Patient", unknown, nil).Code, Equals, http.StatusUnauthorized)
+	billing := s.certificate(pkix.Name{CommonName: "billing", Organization: []string{"Acme"}}, false)
+	c.Assert(s.request("/Patient", billing, nil).Code, Equals, http.StatusUnauthorized)
+}
+
+func (s *ClientCertificatesSuite) TestFallback(c *C) {
+	c.Assert(s.request("/Patient", nil, nil).Code, Equals, http.StatusUnauthorized)
+
+	bearer := func(c *gin.Context) {
+		c.Set("scopes", []string{"system/Patient.read"})
+		c.Set("clientID", "bearer")
+	}
+	res := s.request("/Patient", nil, bearer)
+	c.Assert(res.Code, Equals, http.StatusOK)
+	c.Assert(res.Body.String(), Equals, "bearer")
+	lab := s.certificate(pkix.Name{CommonName: "lab-system", Organization: []string{"Acme"}}, false)
+	c.Assert(s.request("/Patient", lab, bearer).Body.String(), Equals, "lab")
+}
+
+func (s *ClientCertificatesSuite) TestLoadCertificateIdentities(c *C) {
+	file, err := ioutil.TempFile("", "identities")
+	util.CheckErr(err)
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(`[{"subject": "CN=lab-system,O=Acme", "client_id": "lab", "scope": "system/*.read"}, {"subject": "CN=billing"}]`)
+	util.CheckErr(err)
+	file.Close()
+
+	_, err = LoadCertificateIdentities(file.Name())
+	c.Assert(err, ErrorMatches, ".*CN=billing.*client_id.*")
+}
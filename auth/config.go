@@ -1,5 +1,14 @@
 package auth
 
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
 // What type of authentication and authorization will be used
 type Method int
 
@@ -10,6 +19,14 @@ const (
 	AuthTypeOIDC
 	// HEART profiled OpenID Connect and OAuth 2.0
 	AuthTypeHEART
+	// SMART on FHIR: bearer tokens that are JWTs signed by an authorization server
+	AuthTypeSMART
+	// API keys looked up by the server, authorized with the SMART system scopes
+	// of their permissions
+	AuthTypeAPIKey
+	// Client certificates of mutual TLS, authorized with the SMART system
+	// scopes of their identities
+	AuthTypeMutualTLS
 )
 
 // Config represents configuration information necessary to set up authentication
@@ -25,6 +42,15 @@ type Config struct {
 	JWKPath          string
 	OPURL            string
 	SessionSecret    string
+	Issuer           string
+	Audience         string
+	JWKSURL          string
+	BackendClients   []BackendClient
+	SigningKey       *rsa.PrivateKey
+	// CertificateIdentities are the identities of client certificates, which
+	// authenticate requests with them (alongside the other methods, if they
+	// aren't AuthTypeMutualTLS)
+	CertificateIdentities []CertificateIdentity
 }
 
 // None provides a server config where no authorization or authentication will
@@ -72,3 +98,45 @@ func HEART(clientID, jwkPath, opURL, sessionSecret string) Config {
 	return Config{Method: AuthTypeHEART, ClientID: clientID, JWKPath: jwkPath,
 		OPURL: opURL, SessionSecret: sessionSecret}
 }
+
+// SMART provides a server configuration that will act as a SMART on FHIR
+// resource server, accepting bearer tokens that are JWTs signed by the
+// authorization server with the keys of its JWKS, and authorizing access to
+// FHIR resources with their SMART scopes.
+//
+// issuer is the authorization server, which must be the iss of tokens
+// audience is the aud that tokens must have (usually the server's URL), or empty
+//   if their audiences aren't checked
+// authorizationURL and tokenURL are the authorization server's endpoints that
+//   SMART apps are directed to by the .well-known/smart-configuration document
+// jwksURL is the location of the authorization server's JWKS
+func SMART(issuer, audience, authorizationURL, tokenURL, jwksURL string) Config {
+	return Config{Method: AuthTypeSMART, Issuer: issuer, Audience: audience,
+		AuthorizationURL: authorizationURL, TokenURL: tokenURL, JWKSURL: jwksURL}
+}
+
+// DiscoverSMART provides a SMART configuration for an authorization server
+// that supports OpenID Connect discovery, finding its endpoints and JWKS in its
+// .well-known/openid-configuration document.
+func DiscoverSMART(issuer, audience string) (Config, error) {
+	resp, err := http.Get(strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return Config{}, errors.Annotate(err, "Couldn't connect to the OpenID Connect discovery endpoint")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Config{}, errors.Errorf("The OpenID Connect discovery endpoint responded with %s", resp.Status)
+	}
+	var discovery struct {
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&discovery); err != nil {
+		return Config{}, errors.Annotate(err, "Couldn't decode the OpenID Connect discovery document")
+	}
+	if discovery.JWKSURI == "" {
+		return Config{}, errors.New("The OpenID Connect discovery document has no jwks_uri")
+	}
+	return SMART(issuer, audience, discovery.AuthorizationEndpoint, discovery.TokenEndpoint, discovery.JWKSURI), nil
+}
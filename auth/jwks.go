@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// How long the keys of a JWKS are cached for, and how often it can be fetched
+// again to find a key that isn't in it (e.g. after the authorization server
+// rotates its keys) or after fetching it failed
+const (
+	jwksLifetime        = time.Hour
+	jwksMinRefreshDelay = time.Minute
+	jwksFetchTimeout    = 10 * time.Second
+)
+
+// jwksClient fetches JWKSs, so that a slow endpoint can't hold up requests
+var jwksClient = &http.Client{Timeout: jwksFetchTimeout}
+
+// jwksCache caches the public keys of a JSON Web Key Set by their key IDs.  The
+// JWKS is fetched by one request at a time, without holding the lock of the
+// keys, so that other requests can still use them meanwhile.
+type jwksCache struct {
+	url string
+	sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetched   time.Time
+	attempted time.Time
+	err       error
+	fetching  sync.Mutex
+}
+
+func newJWKSCache(url string) *jwksCache {
+	return &jwksCache{url: url}
+}
+
+// newStaticJWKS returns a cache of keys that are known in advance (e.g. those
+// registered for a client), which is never fetched
+func newStaticJWKS(keys map[string]crypto.PublicKey) *jwksCache {
+	return &jwksCache{keys: keys}
+}
+
+// key returns the public key with an ID (or the only key, if the ID is empty),
+// fetching the JWKS (unless it's static) if it hasn't been, if it's expired or
+// if it doesn't have the key.  It isn't fetched again within
+// jwksMinRefreshDelay of the last attempt, even if that failed.
+func (j *jwksCache) key(kid string) (crypto.PublicKey, error) {
+	if j.url != "" && j.needsFetch(kid) {
+		j.fetching.Lock()
+		// Another request may have fetched it while this one was waiting
+		if j.needsFetch(kid) {
+			j.refresh()
+		}
+		j.fetching.Unlock()
+	}
+
+	j.Lock()
+	defer j.Unlock()
+	key, found := j.cachedKey(kid)
+	if j.err != nil && (!found || time.Since(j.fetched) > jwksLifetime) {
+		return nil, j.err
+	}
+	if !found {
+		return nil, errors.NotFoundf("The key %q", kid)
+	}
+	return key, nil
+}
+
+func (j *jwksCache) needsFetch(kid string) bool {
+	j.Lock()
+	defer j.Unlock()
+	if time.Since(j.attempted) < jwksMinRefreshDelay {
+		return false
+	}
+	_, found := j.cachedKey(kid)
+	return !found || time.Since(j.fetched) > jwksLifetime
+}
+
+func (j *jwksCache) cachedKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(j.keys) == 1 {
+		for _, key := range j.keys {
+			return key, true
+		}
+	}
+	key, found := j.keys[kid]
+	return key, found
+}
+
+// refresh fetches the JWKS, keeping the keys fetched before if it fails
+func (j *jwksCache) refresh() {
+	keys, err := j.fetch()
+	j.Lock()
+	defer j.Unlock()
+	j.attempted = time.Now()
+	j.err = err
+	if err == nil {
+		j.keys = keys
+		j.fetched = j.attempted
+	}
+}
+
+func (j *jwksCache) fetch() (map[string]crypto.PublicKey, error) {
+	resp, err := jwksClient.Get(j.url)
+	if err != nil {
+		return nil, errors.Annotate(err, "Couldn't connect to the JWKS endpoint")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("The JWKS endpoint responded with %s", resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Annotate(err, "Couldn't read the JWKS")
+	}
+	return parseJWKS(body)
+}
+
+// parseJWKS returns the public keys of a JWKS for verifying signatures, by their
+// key IDs.  Keys of other types (e.g. symmetric keys) are ignored.
+func parseJWKS(jwksJSON []byte) (map[string]crypto.PublicKey, error) {
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(jwksJSON, &jwks); err != nil {
+		return nil, errors.Annotate(err, "Couldn't decode the JWKS")
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+// jsonWebKey is an RSA or elliptic curve public key of a JWKS (RFC 7517)
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.NotSupportedf("The curve %q", jwk.Crv)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.NotValidf("The key %q", jwk.Kid)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, errors.NotSupportedf("The key type %q", jwk.Kty)
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil || len(bytes) == 0 {
+		return nil, errors.NotValidf("The key parameter %q", value)
+	}
+	return new(big.Int).SetBytes(bytes), nil
+}
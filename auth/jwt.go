@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512" // for crypto.SHA384 and SHA512
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// The clock skew allowed for the expiry and not-before times of tokens
+const jwtLeeway = time.Minute
+
+// jwtClaims are the claims of a SMART access token or client assertion that's
+// a JWT.  The launch context (patient) and fhirUser aren't standard claims of
+// access tokens, but are included in them by many authorization servers.  The
+// tenants claim (the ids of the tenants that the token can access, or * for
+// all of them) and purpose_of_use claim (the purposes of use that Consents are
+// enforced with, as system|code tokens such as
+// http://hl7.org/fhir/v3/ActReason|TREAT) are the server's own.
+type jwtClaims struct {
+	Issuer       string      `json:"iss,omitempty"`
+	Subject      string      `json:"sub,omitempty"`
+	Audience     interface{} `json:"aud,omitempty"`
+	Expiry       *int64      `json:"exp,omitempty"`
+	NotBefore    *int64      `json:"nbf,omitempty"`
+	IssuedAt     *int64      `json:"iat,omitempty"`
+	ID           string      `json:"jti,omitempty"`
+	Scope        interface{} `json:"scope,omitempty"`
+	Scp          []string    `json:"scp,omitempty"`
+	ClientID     string      `json:"client_id,omitempty"`
+	AZP          string      `json:"azp,omitempty"`
+	Patient      string      `json:"patient,omitempty"`
+	FHIRUser     string      `json:"fhirUser,omitempty"`
+	Tenants      []string    `json:"tenants,omitempty"`
+	PurposeOfUse []string    `json:"purpose_of_use,omitempty"`
+}
+
+// scopes returns the scopes of the token: those of its space-separated scope
+// claim (or of the lists of its scope or scp claims)
+func (claims *jwtClaims) scopes() []string {
+	var scopes []string
+	switch scope := claims.Scope.(type) {
+	case string:
+		scopes = strings.Fields(scope)
+	case []interface{}:
+		for _, s := range scope {
+			if s, ok := s.(string); ok {
+				scopes = append(scopes, s)
+			}
+		}
+	}
+	return append(scopes, claims.Scp...)
+}
+
+func (claims *jwtClaims) hasAudience(audience string) bool {
+	switch aud := claims.Audience.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// verifyAccessToken checks the signature (with the keys of a JWKS) and claims of
+// a JWT access token, returning its claims if it's valid
+func verifyAccessToken(token string, keys *jwksCache, issuer, audience string) (*jwtClaims, error) {
+	claims := &jwtClaims{}
+	if err := verifyJWT(token, keys, claims); err != nil {
+		return nil, err
+	}
+	if err := claims.checkTimes(); err != nil {
+		return nil, err
+	}
+	if claims.Issuer != issuer {
+		return nil, errors.Errorf("The token wasn't issued by %s", issuer)
+	}
+	if audience != "" && !claims.hasAudience(audience) {
+		return nil, errors.Errorf("The token's audience isn't %s", audience)
+	}
+	return claims, nil
+}
+
+// checkTimes checks that a JWT has an expiry time that hasn't passed, and that
+// its not-before time (if any) has
+func (claims *jwtClaims) checkTimes() error {
+	now := time.Now()
+	if claims.Expiry == nil || now.After(time.Unix(*claims.Expiry, 0).Add(jwtLeeway)) {
+		return errors.Errorf("The token has expired")
+	}
+	if claims.NotBefore != nil && now.Add(jwtLeeway).Before(time.Unix(*claims.NotBefore, 0)) {
+		return errors.Errorf("The token isn't valid yet")
+	}
+	return nil
+}
+
+// verifyJWT checks the signature of a JWT with the keys of a JWKS, decoding its
+// claims if it's valid
+func verifyJWT(token string, keys *jwksCache, claims interface{}) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.NotValidf("The token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return err
+	}
+	key, err := keys.key(header.Kid)
+	if err != nil {
+		return err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return errors.NotValidf("The token's signature")
+	}
+	if err := verifyJWTSignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return err
+	}
+	return decodeJWTPart(parts[1], claims)
+}
+
+// signJWT returns a JWT of claims signed with an RSA key (with RS256)
+func signJWT(claims interface{}, key *rsa.PrivateKey, kid string) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": kid, "typ": "JWT"})
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", errors.Annotate(err, "Couldn't sign the token")
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	bytes, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return errors.NotValidf("The token's encoding")
+	}
+	if err := json.Unmarshal(bytes, v); err != nil {
+		return errors.NotValidf("The token's JSON")
+	}
+	return nil
+}
+
+// verifyJWTSignature verifies the signature of a JWS with the RSA (RS256,
+// RS384, RS512, PS256, PS384 and PS512) or ECDSA (ES256, ES384 and ES512)
+// algorithms
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	if len(alg) != 5 {
+		return errors.NotSupportedf("The signature algorithm %q", alg)
+	}
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	}
+	if hash == 0 {
+		return errors.NotSupportedf("The signature algorithm %q", alg)
+	}
+	hasher := hash.New()
+	hasher.Write(signed)
+	digest := hasher.Sum(nil)
+
+	var valid bool
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if alg[:2] == "RS" {
+			valid = rsa.VerifyPKCS1v15(key, hash, digest, signature) == nil
+		} else if alg[:2] == "PS" {
+			valid = rsa.VerifyPSS(key, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if alg[:2] == "ES" && len(signature) == 2*size {
+			r := new(big.Int).SetBytes(signature[:size])
+			s := new(big.Int).SetBytes(signature[size:])
+			valid = ecdsa.Verify(key, digest, r, s)
+		}
+	}
+	if !valid {
+		return errors.Errorf("The token's signature isn't valid")
+	}
+	return nil
+}
@@ -0,0 +1,323 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/eug48/fhir/models"
+	"github.com/eug48/fhir/search"
+	"github.com/gin-gonic/gin"
+)
+
+// SMARTScope is a SMART on FHIR clinical scope
+// (http://hl7.org/fhir/smart-app-launch/scopes-and-launch-context.html) such as
+// patient/Observation.read, user/*.write or system/Patient.rs, granting
+// permissions on the resources of a type (or of all types, if ResourceType is
+// "*").  The permissions of SMART v1 scopes are read (reads and searches),
+// write (creates, updates and deletes) or * (all of them), and those of v2
+// scopes are combinations of c (create), r (read), u (update), d (delete) and s
+// (search).
+type SMARTScope struct {
+	Context      string // patient, user or system
+	ResourceType string
+	Create       bool
+	Read         bool
+	Update       bool
+	Delete       bool
+	Search       bool
+}
+
+var smartScopeRegex = regexp.MustCompile(`^(patient|user|system)/(\*|[A-Z][A-Za-z]+)\.(read|write|\*|c?r?u?d?s?)$`)
+
+// ParseSMARTScope parses a SMART clinical scope, returning false if the scope
+// isn't one (e.g. openid or launch/patient).  v2 scopes with search parameters
+// (e.g. patient/Observation.rs?category=laboratory) aren't supported, and so
+// grant nothing.
+func ParseSMARTScope(scope string) (SMARTScope, bool) {
+	match := smartScopeRegex.FindStringSubmatch(scope)
+	if match == nil || match[3] == "" {
+		return SMARTScope{}, false
+	}
+	s := SMARTScope{Context: match[1], ResourceType: match[2]}
+	switch permissions := match[3]; permissions {
+	case "read":
+		s.Read, s.Search = true, true
+	case "write":
+		s.Create, s.Update, s.Delete = true, true, true
+	case "*":
+		s.Create, s.Read, s.Update, s.Delete, s.Search = true, true, true, true, true
+	default:
+		s.Create = strings.Contains(permissions, "c")
+		s.Read = strings.Contains(permissions, "r")
+		s.Update = strings.Contains(permissions, "u")
+		s.Delete = strings.Contains(permissions, "d")
+		s.Search = strings.Contains(permissions, "s")
+	}
+	return s, true
+}
+
+// grants returns whether the scope grants a permission (c, r, u, d or s) on the
+// resources of a type
+func (s SMARTScope) grants(resourceType string, permission byte) bool {
+	if s.ResourceType != "*" && s.ResourceType != resourceType {
+		return false
+	}
+	switch permission {
+	case 'c':
+		return s.Create
+	case 'r':
+		return s.Read
+	case 'u':
+		return s.Update
+	case 'd':
+		return s.Delete
+	case 's':
+		return s.Search
+	}
+	return false
+}
+
+// requiredPermission returns the SMART permission that a request to the routes
+// of a resource type needs
+func requiredPermission(c *gin.Context) byte {
+	id := c.Param("id")
+	switch c.Request.Method {
+	case "GET", "HEAD":
+		if id == "" || id == "_history" || strings.HasPrefix(id, "$") {
+			return 's'
+		}
+		return 'r'
+	case "POST":
+		if id == "_search" {
+			return 's'
+		} else if id == "$validate" {
+			return 'r'
+		}
+		return 'c'
+	case "PUT", "PATCH":
+		return 'u'
+	case "DELETE":
+		return 'd'
+	}
+	return 0
+}
+
+// SMARTScopesGrant returns whether the SMART scopes in the gin.Context grant a
+// permission (c, r, u, d or s) on the resources of a type: granted is whether
+// user or system scopes do, and grantedForPatient whether patient scopes do,
+// which only grant it on the resources in the compartment of the patient of
+// the launch context.
+func SMARTScopesGrant(c *gin.Context, resourceType string, permission byte) (granted, grantedForPatient bool) {
+	if scopes, exists := c.Get("scopes"); exists {
+		for _, scope := range scopes.([]string) {
+			if s, ok := ParseSMARTScope(scope); ok && s.grants(resourceType, permission) {
+				if s.Context == "patient" {
+					grantedForPatient = true
+				} else {
+					granted = true
+				}
+			}
+		}
+	}
+	return granted, grantedForPatient
+}
+
+// SMARTScopesHandler middleware authorizes requests to the routes of a resource
+// type with the SMART scopes that SMARTBearerTokenHandler set in the
+// gin.Context, responding to others with a 403 and an OperationOutcome.  Access
+// that's only granted by patient scopes is limited to the compartment of the
+// patient of the launch context, which is set as patientCompartment in the
+// gin.Context (the patient's id) for the server to restrict its queries to.
+// The resource type "*" authorizes requests to routes that aren't those of a
+// resource type, which patient scopes don't give access to.
+func SMARTScopesHandler(resourceName string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		granted, grantedForPatient := SMARTScopesGrant(c, resourceName, requiredPermission(c))
+		if !granted && !grantedForPatient {
+			forbidden(c, "You do not have permission to access this resource")
+			return
+		}
+		if granted {
+			return
+		}
+
+		patient := c.GetString("patient")
+		id := c.Param("id")
+		if patient == "" {
+			forbidden(c, "Patient scopes require a patient in the launch context")
+		} else if resourceName == "*" {
+			forbidden(c, "Patient scopes don't give access to the whole system")
+		} else if !isInPatientCompartment(resourceName) {
+			forbidden(c, fmt.Sprintf("%s resources aren't in the patient compartment", resourceName))
+		} else if resourceName == "Patient" && id != "" && id != patient {
+			forbidden(c, "You do not have permission to access this patient")
+		} else {
+			c.Set("patientCompartment", patient)
+		}
+	}
+}
+
+// TenantAdminScope is the scope of the admins that provision and deprovision
+// the server's tenants.  It isn't a clinical scope, so that even scopes for
+// all resource types (e.g. system/*.*) don't grant it.
+const TenantAdminScope = "system/$tenants"
+
+// TenantAdminHandler middleware limits the routes that manage tenants to the
+// requests authenticated with admin API keys, or else with the
+// TenantAdminScope (e.g. of bearer tokens or client certificates' identities),
+// responding to others with a 403 and an OperationOutcome
+func TenantAdminHandler(c *gin.Context) {
+	if apiKey, exists := c.Get("apiKey"); exists {
+		if !apiKey.(*APIKey).Admin {
+			forbidden(c, "Only admin API keys can manage tenants")
+		}
+		return
+	}
+	if scopes, exists := c.Get("scopes"); exists {
+		for _, scope := range scopes.([]string) {
+			if scope == TenantAdminScope {
+				return
+			}
+		}
+	}
+	forbidden(c, fmt.Sprintf("Managing tenants requires the %s scope", TenantAdminScope))
+}
+
+// SMARTBulkExportHandler middleware limits bulk data exports ($export) to the
+// resource types that the user and system scopes in the gin.Context grant read
+// access to: exports without a _type parameter are limited to those types
+// (unless all types are granted), while those with one must only name them.
+func SMARTBulkExportHandler(c *gin.Context) {
+	if !strings.HasSuffix(c.Request.URL.Path, "/$export") {
+		return
+	}
+	readable := make(map[string]bool)
+	var readableTypes []string
+	if scopes, exists := c.Get("scopes"); exists {
+		for _, scope := range scopes.([]string) {
+			if s, ok := ParseSMARTScope(scope); ok && s.Context != "patient" && s.Read {
+				if s.ResourceType == "*" {
+					return
+				} else if !readable[s.ResourceType] {
+					readable[s.ResourceType] = true
+					readableTypes = append(readableTypes, s.ResourceType)
+				}
+			}
+		}
+	}
+	if len(readableTypes) == 0 {
+		forbidden(c, "You do not have permission to export resources")
+		return
+	}
+	types := c.Query("_type")
+	if types == "" {
+		addQueryParameter(c, "_type", strings.Join(readableTypes, ","))
+		return
+	}
+	for _, resourceType := range strings.Split(types, ",") {
+		if !readable[resourceType] {
+			forbidden(c, fmt.Sprintf("You do not have permission to export %s resources", resourceType))
+			return
+		}
+	}
+}
+
+func isInPatientCompartment(resourceType string) bool {
+	for _, compartmentType := range search.CompartmentResourceTypes("Patient") {
+		if compartmentType == resourceType {
+			return true
+		}
+	}
+	return false
+}
+
+// forbidden aborts a request with a 403 and an OperationOutcome
+func forbidden(c *gin.Context, message string) {
+	c.Header("Content-Type", "application/fhir+json; charset=utf-8")
+	c.AbortWithStatusJSON(http.StatusForbidden, models.NewOperationOutcome("error", "forbidden", message))
+}
+
+func addQueryParameter(c *gin.Context, name, value string) {
+	param := url.Values{name: {value}}.Encode()
+	if c.Request.URL.RawQuery == "" {
+		c.Request.URL.RawQuery = param
+	} else {
+		c.Request.URL.RawQuery += "&" + param
+	}
+}
+
+// SMARTBearerTokenHandler creates a gin.HandlerFunc that validates bearer
+// tokens that are JWTs signed by the authorization server of a SMART
+// configuration, with the keys of its JWKS (which are cached).
+//
+// Requests without valid tokens are aborted with a 401 and a WWW-Authenticate
+// header. If a valid token is provided, the gin.Context is augmented by setting
+// the following variables: scopes will be a []string containing the token's
+// scopes, subject will be the user who authorized it, clientID will be the
+// client it was issued to, patient and fhirUser will be its launch context
+// (if any), tenants will be the tenants it can access, and purposeOfUse will
+// be the purposes of use of its purpose_of_use claim (if any).
+func SMARTBearerTokenHandler(config Config) gin.HandlerFunc {
+	keys := newJWKSCache(config.JWKSURL)
+	if config.SigningKey != nil {
+		// the server's own tokens (see BackendServices)
+		keys = signingKeys(config.SigningKey)
+	}
+	return func(c *gin.Context) {
+		auth := c.Request.Header.Get("Authorization")
+		token := strings.TrimPrefix(auth, "Bearer ")
+		if auth == "" || token == auth {
+			c.Header("WWW-Authenticate", `Bearer realm="FHIR"`)
+			c.String(http.StatusUnauthorized, "No bearer token provided in the Authorization header")
+			c.Abort()
+			return
+		}
+		claims, err := verifyAccessToken(token, keys, config.Issuer, config.Audience)
+		if err != nil {
+			c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer realm="FHIR", error="invalid_token", error_description=%q`, err.Error()))
+			c.String(http.StatusUnauthorized, "Provided token isn't valid: %s", err.Error())
+			c.Abort()
+			return
+		}
+		clientID := claims.ClientID
+		if clientID == "" {
+			clientID = claims.AZP
+		}
+		c.Set("scopes", claims.scopes())
+		c.Set("subject", claims.Subject)
+		c.Set("clientID", clientID)
+		c.Set("patient", claims.Patient)
+		c.Set("fhirUser", claims.FHIRUser)
+		c.Set("tenants", claims.Tenants)
+		c.Set("purposeOfUse", claims.PurposeOfUse)
+	}
+}
+
+// SMARTConfigurationHandler serves the .well-known/smart-configuration
+// discovery document of a SMART configuration, which SMART apps use to find the
+// authorization server's endpoints and its capabilities
+func SMARTConfigurationHandler(config Config) gin.HandlerFunc {
+	document := gin.H{
+		"issuer":                                config.Issuer,
+		"jwks_uri":                              config.JWKSURL,
+		"authorization_endpoint":                config.AuthorizationURL,
+		"token_endpoint":                        config.TokenURL,
+		"grant_types_supported":                 []string{"authorization_code", "client_credentials"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "private_key_jwt"},
+		"token_endpoint_auth_signing_alg_values_supported": []string{"RS256", "RS384", "RS512", "PS256", "PS384",
+			"PS512", "ES256", "ES384", "ES512"},
+		"scopes_supported": []string{"openid", "fhirUser", "launch", "launch/patient", "offline_access",
+			"patient/*.read", "patient/*.write", "user/*.read", "user/*.write", "system/*.read", "system/*.write"},
+		"response_types_supported":         []string{"code"},
+		"code_challenge_methods_supported": []string{"S256"},
+		"capabilities": []string{"launch-ehr", "launch-standalone", "client-public", "client-confidential-symmetric",
+			"client-confidential-asymmetric", "context-ehr-patient", "context-standalone-patient", "sso-openid-connect",
+			"permission-patient", "permission-user", "permission-offline"},
+	}
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, document)
+	}
+}
@@ -0,0 +1,253 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+type SMARTSuite struct {
+	Key        *rsa.PrivateKey
+	JWKSServer *httptest.Server
+	Config     Config
+}
+
+var _ = Suite(&SMARTSuite{})
+
+func (s *SMARTSuite) SetUpSuite(c *C) {
+	var err error
+	s.Key, err = rsa.GenerateKey(rand.Reader, 2048)
+	util.CheckErr(err)
+	s.JWKSServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "key1",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(s.Key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.Key.E)).Bytes()),
+		}}})
+	}))
+	s.Config = SMART("https://auth.example.com", "https://fhir.example.com", "https://auth.example.com/authorize",
+		"https://auth.example.com/token", s.JWKSServer.URL)
+}
+
+func (s *SMARTSuite) TearDownSuite(c *C) {
+	s.JWKSServer.Close()
+}
+
+// token returns a JWT with claims, signed with the key of the JWKS
+func (s *SMARTSuite) token(claims map[string]interface{}) string {
+	token, err := signJWT(claims, s.Key, "key1")
+	util.CheckErr(err)
+	return token
+}
+
+func (s *SMARTSuite) claims(scope, patient string) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":       "https://auth.example.com",
+		"aud":       []string{"https://fhir.example.com"},
+		"sub":       "steve",
+		"client_id": "growth-chart",
+		"exp":       time.Now().Add(time.Hour).Unix(),
+		"scope":     scope,
+		"patient":   patient,
+	}
+}
+
+// request makes a request to the routes of a resource type with a bearer token, returning the response and the
+// patient compartment that the request was limited to
+func (s *SMARTSuite) request(method, path, resourceType, token string) (*httptest.ResponseRecorder, string) {
+	e := gin.New()
+	var compartment string
+	handler := func(ctx *gin.Context) {
+		compartment = ctx.GetString("patientCompartment")
+		ctx.String(http.StatusOK, "Hello")
+	}
+	group := e.Group("/"+resourceType, SMARTBearerTokenHandler(s.Config), SMARTScopesHandler(resourceType))
+	group.GET("", handler)
+	group.POST("", handler)
+	group.GET("/:id", handler)
+	group.PUT("/:id", handler)
+
+	r, err := http.NewRequest(method, path, nil)
+	util.CheckErr(err)
+	if token != "" {
+		r.Header.Add("Authorization", "Bearer "+token)
+	}
+	rw := httptest.NewRecorder()
+	e.ServeHTTP(rw, r)
+	return rw, compartment
+}
+
+func (s *SMARTSuite) TestParseSMARTScope(c *C) {
+	scope, ok := ParseSMARTScope("patient/Observation.read")
+	c.Assert(ok, Equals, true)
+	c.Assert(scope, DeepEquals, SMARTScope{Context: "patient", ResourceType: "Observation", Read: true, Search: true})
+	scope, ok = ParseSMARTScope("user/*.*")
+	c.Assert(ok, Equals, true)
+	c.Assert(scope, DeepEquals, SMARTScope{Context: "user", ResourceType: "*", Create: true, Read: true, Update: true, Delete: true, Search: true})
+	scope, ok = ParseSMARTScope("system/Patient.rs")
+	c.Assert(ok, Equals, true)
+	c.Assert(scope, DeepEquals, SMARTScope{Context: "system", ResourceType: "Patient", Read: true, Search: true})
+
+	for _, notClinical := range []string{"openid", "launch/patient", "patient/Observation.", "patient/Observation.sr",
+		"patient/Observation.rs?category=laboratory", "admin/Patient.read"} {
+		_, ok := ParseSMARTScope(notClinical)
+		c.Assert(ok, Equals, false, Commentf(notClinical))
+	}
+}
+
+func (s *SMARTSuite) TestBearerTokens(c *C) {
+	rr, _ := s.request("GET", "/Patient/123", "Patient", s.token(s.claims("user/Patient.read", "")))
+	c.Assert(rr.Code, Equals, http.StatusOK)
+	c.Assert(rr.Body.String(), Equals, "Hello")
+
+	rr, _ = s.request("GET", "/Patient/123", "Patient", "")
+	c.Assert(rr.Code, Equals, http.StatusUnauthorized)
+	c.Assert(rr.Header().Get("WWW-Authenticate"), Matches, "Bearer .*")
+
+	expired := s.claims("user/Patient.read", "")
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	otherIssuer := s.claims("user/Patient.read", "")
+	otherIssuer["iss"] = "https://evil.example.com"
+	otherAudience := s.claims("user/Patient.read", "")
+	otherAudience["aud"] = "https://other.example.com"
+	tampered := s.token(s.claims("user/Patient.read", ""))
+	tampered = tampered[:len(tampered)-4] + "AAAA"
+	for _, invalid := range []string{s.token(expired), s.token(otherIssuer), s.token(otherAudience), tampered, "not.a.jwt"} {
+		rr, _ = s.request("GET", "/Patient/123", "Patient", invalid)
+		c.Assert(rr.Code, Equals, http.StatusUnauthorized, Commentf(invalid))
+		c.Assert(rr.Header().Get("WWW-Authenticate"), Matches, `Bearer .*error="invalid_token".*`)
+	}
+}
+
+func (s *SMARTSuite) TestScopes(c *C) {
+	// v1 and v2 permissions
+	rr, _ := s.request("PUT", "/Observation/123", "Observation", s.token(s.claims("user/Observation.read", "")))
+	c.Assert(rr.Code, Equals, http.StatusForbidden)
+	rr, _ = s.request("PUT", "/Observation/123", "Observation", s.token(s.claims("user/*.write", "")))
+	c.Assert(rr.Code, Equals, http.StatusOK)
+	rr, _ = s.request("GET", "/Observation/123", "Observation", s.token(s.claims("system/Observation.s", "")))
+	c.Assert(rr.Code, Equals, http.StatusForbidden)
+	rr, _ = s.request("GET", "/Observation", "Observation", s.token(s.claims("system/Observation.s", "")))
+	c.Assert(rr.Code, Equals, http.StatusOK)
+
+	// Patient scopes are limited to the compartment of the patient of the launch context
+	patientToken := s.token(s.claims("launch/patient patient/*.read", "123"))
+	rr, compartment := s.request("GET", "/Patient/123", "Patient", patientToken)
+	c.Assert(rr.Code, Equals, http.StatusOK)
+	c.Assert(compartment, Equals, "123")
+	rr, _ = s.request("GET", "/Patient/456", "Patient", patientToken)
+	c.Assert(rr.Code, Equals, http.StatusForbidden)
+	c.Assert(rr.Header().Get("Content-Type"), Matches, "application/fhir\\+json.*")
+	rr, compartment = s.request("GET", "/Patient?name=smith", "Patient", patientToken)
+	c.Assert(rr.Code, Equals, http.StatusOK)
+	c.Assert(compartment, Equals, "123")
+	rr, compartment = s.request("GET", "/Observation", "Observation", patientToken)
+	c.Assert(rr.Code, Equals, http.StatusOK)
+	c.Assert(compartment, Equals, "123")
+	rr, _ = s.request("GET", "/Medication", "Medication", patientToken)
+	c.Assert(rr.Code, Equals, http.StatusForbidden)
+	rr, _ = s.request("GET", "/Observation", "Observation", s.token(s.claims("patient/*.read", "")))
+	c.Assert(rr.Code, Equals, http.StatusForbidden)
+
+	var outcome map[string]interface{}
+	util.CheckErr(json.Unmarshal(rr.Body.Bytes(), &outcome))
+	c.Assert(outcome["resourceType"], Equals, "OperationOutcome")
+
+	// Other scopes aren't
+	rr, compartment = s.request("GET", "/Observation", "Observation", s.token(s.claims("patient/*.read user/Observation.read", "123")))
+	c.Assert(rr.Code, Equals, http.StatusOK)
+	c.Assert(compartment, Equals, "")
+}
+
+func (s *SMARTSuite) TestTenantAdmins(c *C) {
+	request := func(authenticate gin.HandlerFunc) int {
+		e := gin.New()
+		e.DELETE("/$tenants/:id", authenticate, TenantAdminHandler, func(ctx *gin.Context) {
+			ctx.Status(http.StatusNoContent)
+		})
+		rw := httptest.NewRecorder()
+		r, err := http.NewRequest("DELETE", "/$tenants/acme", nil)
+		util.CheckErr(err)
+		e.ServeHTTP(rw, r)
+		return rw.Code
+	}
+	scopes := func(scopes ...string) gin.HandlerFunc {
+		return func(ctx *gin.Context) { ctx.Set("scopes", scopes) }
+	}
+	apiKey := func(admin bool) gin.HandlerFunc {
+		return func(ctx *gin.Context) {
+			ctx.Set("scopes", []string{TenantAdminScope})
+			ctx.Set("apiKey", &APIKey{Admin: admin})
+		}
+	}
+
+	// Scopes for all resource types don't grant the management of tenants
+	c.Assert(request(scopes("system/*.*")), Equals, http.StatusForbidden)
+	c.Assert(request(scopes("user/*.write")), Equals, http.StatusForbidden)
+	c.Assert(request(scopes("system/*.read", TenantAdminScope)), Equals, http.StatusNoContent)
+
+	// API keys must be admin keys
+	c.Assert(request(apiKey(false)), Equals, http.StatusForbidden)
+	c.Assert(request(apiKey(true)), Equals, http.StatusNoContent)
+}
+
+func (s *SMARTSuite) TestSMARTConfiguration(c *C) {
+	e := gin.New()
+	e.GET("/.well-known/smart-configuration", SMARTConfigurationHandler(s.Config))
+	r, err := http.NewRequest("GET", "/.well-known/smart-configuration", nil)
+	util.CheckErr(err)
+	rw := httptest.NewRecorder()
+	e.ServeHTTP(rw, r)
+	c.Assert(rw.Code, Equals, http.StatusOK)
+
+	var document map[string]interface{}
+	util.CheckErr(json.Unmarshal(rw.Body.Bytes(), &document))
+	c.Assert(document["authorization_endpoint"], Equals, "https://auth.example.com/authorize")
+	c.Assert(document["token_endpoint"], Equals, "https://auth.example.com/token")
+	c.Assert(document["capabilities"], NotNil)
+}
+
+func (s *SMARTSuite) TestJWKSFetchesBackOff(c *C) {
+	var fetches int
+	failing := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		if failing {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		s.JWKSServer.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	keys := newJWKSCache(server.URL)
+
+	// A failing endpoint isn't fetched again for every token
+	_, err := keys.key("key1")
+	c.Assert(err, ErrorMatches, ".*502 Bad Gateway")
+	_, err = keys.key("key1")
+	c.Assert(err, ErrorMatches, ".*502 Bad Gateway")
+	c.Assert(fetches, Equals, 1)
+
+	// Nor is it fetched for every unknown key
+	failing = false
+	keys.attempted = time.Now().Add(-jwksMinRefreshDelay)
+	key, err := keys.key("key1")
+	util.CheckErr(err)
+	c.Assert(key, NotNil)
+	_, err = keys.key("key2")
+	c.Assert(err, ErrorMatches, ".*not found")
+	_, err = keys.key("key2")
+	c.Assert(err, ErrorMatches, ".*not found")
+	c.Assert(fetches, Equals, 2)
+}
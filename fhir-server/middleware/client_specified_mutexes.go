@@ -5,7 +5,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"go.opencensus.io/trace"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type lockId string
@@ -83,8 +83,8 @@ func ClientSpecifiedMutexesMiddleware() gin.HandlerFunc {
 
 		} else if mutexName != "" {
 
-			_, span := trace.StartSpan(c.Request.Context(), "locking mutex")
-			span.AddAttributes(trace.StringAttribute("X-Mutex-Name", mutexName))
+			_, span := tracer.Start(c.Request.Context(), "locking mutex")
+			span.SetAttributes(attribute.String("X-Mutex-Name", mutexName))
 			lockRequest := &lockRequest{mutexName: mutexName, gateChannel: make(chan lockId)}
 			lockRequests <- lockRequest
 			lockId := <-lockRequest.gateChannel
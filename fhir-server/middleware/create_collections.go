@@ -8,18 +8,19 @@ import (
 	"github.com/golang/glog"
 
 	"github.com/eug48/fhir/models2"
+	"github.com/eug48/fhir/utils"
 	"github.com/gin-gonic/gin"
-	mongowrapper "github.com/opencensus-integrations/gomongowrapper"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"go.opencensus.io/trace"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // Pre-create collections as required by MongoDB transactions
 func PrecreateCollectionsMiddleware(mongoDBuri string) gin.HandlerFunc {
 
-	client, err := mongowrapper.Connect(context.Background(), options.Client().ApplyURI(mongoDBuri))
+	client, err := mongo.Connect(context.Background(), utils.MongoClientOptions(mongoDBuri))
 	if err != nil {
 		panic(errors.Wrap(err, "PrecreateCollectionsMiddleware can't connect to MongoDB"))
 	}
@@ -39,8 +40,8 @@ func PrecreateCollectionsMiddleware(mongoDBuri string) gin.HandlerFunc {
 		_, done := dbsAlreadyDone.Load(dbName)
 
 		if !done {
-			ctx, span := trace.StartSpan(c.Request.Context(), "create_collections")
-			span.AddAttributes(trace.StringAttribute("db", dbName))
+			ctx, span := tracer.Start(c.Request.Context(), "create_collections")
+			span.SetAttributes(attribute.String("db", dbName))
 			defer span.End()
 
 			err := CreateCollections(ctx, collectionsToCreate, dbName, client)
@@ -54,7 +55,7 @@ func PrecreateCollectionsMiddleware(mongoDBuri string) gin.HandlerFunc {
 	}
 }
 
-func CreateCollections(ctx context.Context, collectionsToCreate []string, dbName string, client *mongowrapper.WrappedClient) error {
+func CreateCollections(ctx context.Context, collectionsToCreate []string, dbName string, client *mongo.Client) error {
 
 	db := client.Database(dbName)
 
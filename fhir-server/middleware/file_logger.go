@@ -14,10 +14,15 @@ import (
 
 	"github.com/DataDog/zstd"
 	"github.com/pkg/errors"
-	"go.opencensus.io/trace"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sys/unix"
 )
 
+// tracer traces the middleware with OpenTelemetry, as spans of the requests' spans
+var tracer = otel.Tracer("github.com/eug48/fhir/fhir-server/middleware")
+
 type responseTeeWriter struct {
 	http.ResponseWriter
 	statusCode int
@@ -71,7 +76,7 @@ func FileLoggerMiddleware(outputDirectory string, dumpHttpGET bool, httpHandler
 			return
 		}
 
-		_, span := trace.StartSpan(req.Context(), "file_logger")
+		_, span := tracer.Start(req.Context(), "file_logger")
 		defer span.End()
 
 		mutexName := req.Header.Get("X-Mutex-Name")
@@ -114,7 +119,7 @@ func FileLoggerMiddleware(outputDirectory string, dumpHttpGET bool, httpHandler
 				return
 			}
 
-			span.Annotate([]trace.Attribute{trace.StringAttribute("filename", filename)}, "request written")
+			span.AddEvent("request written", trace.WithAttributes(attribute.String("filename", filename)))
 			requestWritten <- nil
 		}()
 
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"log"
@@ -8,17 +10,22 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"strings"
 	"time"
 
-	"contrib.go.opencensus.io/exporter/jaeger"
-	"contrib.go.opencensus.io/exporter/stackdriver"
-	stackdriverPropagation "contrib.go.opencensus.io/exporter/stackdriver/propagation"
-	"go.opencensus.io/plugin/ochttp"
-	"go.opencensus.io/stats/view"
-	"go.opencensus.io/trace"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 
 	"github.com/eug48/fhir/auth"
 	"github.com/eug48/fhir/fhir-server/middleware"
+	"github.com/eug48/fhir/search"
 	"github.com/eug48/fhir/server"
 	"github.com/golang/glog"
 )
@@ -27,32 +34,107 @@ var gitCommit string
 
 func main() {
 	port := flag.Int("port", 3001, "Port to listen on")
+	serverURL := flag.String("serverURL", "", "Base URL of the server used in Location headers, bundle fullUrls and paging links, e.g. https://fhir.example.com/ (optional, otherwise derived from the request's Host, X-Forwarded-Host and X-Forwarded-Proto headers)")
 	reqLog := flag.Bool("reqlog", false, "Enables request logging -- use with caution in production")
 	mongodbURI := flag.String("mongodbURI", "mongodb://mongo:27017/?replicaSet=rs0", "MongoDB connection URI - a replica set is required for transactions support")
 	databaseName := flag.String("databaseName", "fhir", "MongoDB database name to use by default")
 	enableMultiDB := flag.Bool("enableMultiDB", false, "Allow request to specify a specific Mongo database instead of the default, e.g. http://fhir-server/db/test4_fhir/Patient?name=alex")
+	enableTenancy := flag.Bool("enableTenancy", false, "Store the resources of each tenant in a database of its own, selected by the tenant's id in the path (e.g. http://fhir-server/tenants/acme/Patient) or in the -tenantHeader, and provision tenants with the /$tenants API")
+	tenantHeader := flag.String("tenantHeader", "X-Tenant-ID", "The header with the id of the tenant of requests without one in their path (with -enableTenancy)")
 	enableHistory := flag.Bool("enableHistory", true, "Keep previous versions of every resource")
+	conditionalDeleteMultiple := flag.Bool("conditionalDeleteMultiple", true, "Make conditional deletes delete every matching resource (otherwise they fail with 412 Precondition Failed if several match)")
+	requireIfMatch := flag.Bool("requireIfMatch", false, "Require updates of existing resources to have an If-Match header with their current version (otherwise they fail with 412 Precondition Failed)")
+	validateResources := flag.Bool("validateResources", false, "Validate the cardinalities, datatypes and required codes of resources before storing them (otherwise they fail with 422 Unprocessable Entity)")
+	profilePackages := flag.String("profilePackages", "", "Comma-separated paths of FHIR NPM packages (package.tgz files, e.g. of US Core) whose profiles resources are validated against with -validateResources (when in their meta.profile) and $validate")
+	enforceReferences := flag.Bool("enforceReferences", false, "Reject creates and updates of resources that refer to resources on the server that don't exist (with 422 Unprocessable Entity)")
+	referencedDeletes := flag.String("referencedDeletes", server.ReferencedDeletesAllow, "What happens when resources that other resources refer to are deleted: 'allow', 'warn' (listing the references in an OperationOutcome), 'block' (failing with 409 Conflict), 'nullify' (removing the references) or 'cascade' (deleting the referring resources too)")
+	referencedDeletesByType := flag.String("referencedDeletesByType", "", "Comma-separated resource types and what happens when those of each type that other resources refer to are deleted (overriding -referencedDeletes), e.g. 'Patient=cascade,Practitioner=nullify'")
+	lowercaseSearchFields := flag.Bool("lowercaseSearchFields", false, "Make case-insensitive searches match the lowercase copies of fields stored with resources, which can use indexes (only once all resources have been stored with them)")
+	precomputedCompartments := flag.Bool("precomputedCompartments", false, "Make compartment searches match the compartments stored with resources, which use a single index (only once all resources have been stored with them)")
+	collation := flag.String("collation", "", "ICU locale used to sort strings, e.g. 'fr' (optional, new collections are created with it as their default)")
+	defaultTimezone := flag.String("defaultTimezone", "", "IANA timezone of dates without a time when storing and searching, e.g. 'Australia/Sydney' (optional, defaults to the server's local timezone)")
+	collationStrength := flag.Int("collationStrength", 0, "ICU comparison level of the -collation (1 or 2 to also use it for case-insensitive searches, 0 for MongoDB's default of 3)")
 	tokenParametersCaseSensitive := flag.Bool("tokenParametersCaseSensitive", false, "Whether token-type search parameters should be case sensitive (faster and R4 leans towards case-sensitive, whereas STU3 text suggests case-insensitive)")
+	maxChainDepth := flag.Int("maxChainDepth", search.DefaultMaxChainDepth, "Maximum number of references a chained search parameter may traverse (e.g. subject.organization.name has a depth of 2)")
+	maxIncludeDepth := flag.Int("maxIncludeDepth", search.DefaultMaxIncludeDepth, "Maximum number of times _include:iterate and _revinclude:iterate are applied to included resources")
+	defaultCount := flag.Int("defaultCount", search.DefaultCount, "Number of results per page of searches that don't specify a _count")
+	maxCount := flag.Int("maxCount", 0, "Maximum _count of searches, larger values are reduced with a warning (0 for no maximum)")
+	maxIncludes := flag.Int("maxIncludes", 0, "Maximum number of resources included in a page of search results by _include and _revinclude (0 for no maximum)")
+	databaseOpTimeout := flag.Duration("databaseOpTimeout", 90*time.Second, "Maximum time a search may spend on each database query before it is interrupted as too costly (0 for no limit)")
+	indexHints := flag.String("indexHints", "", "Path to an index_hints.conf file pinning searches using particular search parameters to particular indexes (optional)")
+	keysetPaging := flag.Bool("keysetPaging", false, "Use an opaque _cursor rather than _offset in the next links of search results (faster and consistent for deep pages)")
+	resultSetLifetime := flag.Duration("resultSetLifetime", 0, "Page through a snapshot of the results of searches, which lasts this long, so that later pages are consistent with the first (0 to disable)")
+	resultSetMaxResults := flag.Int("resultSetMaxResults", search.DefaultResultSetMaxResults, "Maximum number of results stored in a search results snapshot, searches with more are paged without one")
 	batchConcurrency := flag.Int("batchConcurrency", 1, "Number of concurrent database operations to do during batch bundle processing (1 to disable)")
 	databaseSuffix := flag.String("databaseSuffix", "", "Request-specific MongoDB database name has to end with this (optional, e.g. '_fhir')")
 	dontCreateIndexes := flag.Bool("dontCreateIndexes", false, "Don't create indexes for the 'fhr' database on startup")
+	dontCreateTextIndexes := flag.Bool("dontCreateTextIndexes", false, "Don't create the text indexes needed by _text and _content searches on startup")
+	createSearchIndexes := flag.Bool("createSearchIndexes", false, "Create indexes for the token, date and reference search parameters of every resource on startup (see config/search_indexes.conf)")
+	searchParametersDir := flag.String("searchParametersDir", "", "Directory of JSON files with custom SearchParameter resources (or Bundles of them) to register on startup")
+	cacheSearchCounts := flag.Bool("cacheSearchCounts", false, "Cache the totals of searches, invalidating them when resources change (always enabled in read-only mode)")
+	countCacheTTL := flag.Duration("countCacheTTL", 10*time.Minute, "How long cached search totals are used for (0 for no expiry)")
+	countCacheMaxEntries := flag.Int("countCacheMaxEntries", 10000, "Maximum number of search totals cached per database, evicting the oldest (0 for no maximum)")
+	countCacheRedisURL := flag.String("countCacheRedisURL", "", "URL of a Redis server in which to cache search totals, e.g. redis://localhost:6379/0 (shared by several servers, optional)")
 	disableSearchTotals := flag.Bool("disableSearchTotals", false, "Don't query for all results of a search to return Bundle.total, only do paging")
+	enableExplain := flag.Bool("enableExplain", false, "Enable the /$explain?resource=...&query=... endpoint which shows how searches are run (for diagnosing slow searches)")
+	enableBulkExport := flag.Bool("enableBulkExport", false, "Enable the bulk data $export operations, which export resources to NDJSON files in the background")
+	bulkExportDestination := flag.String("bulkExportDestination", "", "Directory in which to store the files of bulk exports (default fhir-bulk-export in the temporary directory), or an S3 bucket and prefix, e.g. s3://my-bucket/exports (using the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_REGION and optional AWS_S3_ENDPOINT environment variables)")
+	bulkExportLifetime := flag.Duration("bulkExportLifetime", 24*time.Hour, "How long the files of completed bulk exports are kept for")
+	enableBulkImport := flag.Bool("enableBulkImport", false, "Enable the POST /$import operation, which imports NDJSON files downloaded from the given URLs in the background")
+	enableAsync := flag.Bool("enableAsync", false, "Run searches and operations with a Prefer: respond-async header in the background, polling for their responses")
+	asyncWorkers := flag.Int("asyncWorkers", 2, "How many asynchronous requests are run at once")
+	asyncJobLifetime := flag.Duration("asyncJobLifetime", 24*time.Hour, "How long the responses of asynchronous requests are kept for")
+	enableSubscriptions := flag.Bool("enableSubscriptions", false, "Support rest-hook Subscriptions, notifying their endpoints of created and updated resources matching their criteria")
+	subscriptionRetries := flag.Int("subscriptionRetries", 3, "How many times failed notifications of Subscriptions are retried before they're put in the error state")
+	subscriptionRetryDelay := flag.Duration("subscriptionRetryDelay", time.Second, "How long the first retry of a failed notification of a Subscription is delayed (doubled for each further retry)")
+	subscriptionEventLifetime := flag.Duration("subscriptionEventLifetime", 24*time.Hour, "How long the events of topic-based Subscriptions are kept for the $events operation")
+	eventBusURL := flag.String("eventBusURL", "", "Publish the events of created, updated and deleted resources to a Kafka cluster (kafka://broker1:9092,broker2:9092) or NATS server (nats://host:4222)")
+	eventTopic := flag.String("eventTopic", "fhir.resources", "The Kafka topic (or the prefix of the NATS subjects) that the events of resources are published to")
+	eventPayloads := flag.Bool("eventPayloads", false, "Include the created and updated resources in their events")
+	enableChangeFeed := flag.Bool("enableChangeFeed", false, "Stream the changes to the resources of each type as Server-Sent Events (GET /[type]/$changes)")
+	smartIssuer := flag.String("smartIssuer", "", "Require SMART on FHIR bearer tokens issued by this OpenID Connect authorization server (whose endpoints and JWKS are found by discovery)")
+	smartAudience := flag.String("smartAudience", "", "The audience (aud) that SMART on FHIR bearer tokens must have, usually the server's URL (optional)")
+	smartBackendClients := flag.String("smartBackendClients", "", "Issue SMART Backend Services access tokens (at /auth/token) to the clients registered in this JSON file, with their client_id, jwks or jwks_url, and allowed system scopes (requires -serverURL)")
+	smartSigningKey := flag.String("smartSigningKey", "", "A PEM file of the RSA private key that SMART Backend Services access tokens are signed with (otherwise a key is generated, and tokens are only valid until the server restarts)")
+	enableAPIKeys := flag.Bool("enableAPIKeys", false, "Require API keys (in the X-API-Key header), which are read-only or read-write and optionally limited to some resource types, and are managed with the /$api-keys API by clients with admin keys (the first of which is created with 'fhir-server apikey <name>')")
+	tlsCert := flag.String("tlsCert", "", "Serve HTTPS with the certificate (and intermediate certificates) in this PEM file (requires -tlsKey)")
+	tlsKey := flag.String("tlsKey", "", "A PEM file of the private key of the -tlsCert")
+	clientCAs := flag.String("clientCAs", "", "Verify client certificates with the CAs in this PEM file (and no others), requiring them of every request unless -smartIssuer, -smartBackendClients or -enableAPIKeys authenticates those without them (requires -tlsCert and -clientCertIdentities)")
+	clientCertIdentities := flag.String("clientCertIdentities", "", "Authenticate the client certificates with the identities in this JSON file, which map their subject (and optionally issuer) distinguished names to a client_id and allowed system scopes")
+	opaURL := flag.String("opaURL", "", "Decide whether each interaction is allowed with this Open Policy Agent decision (e.g. http://localhost:8181/v1/data/fhir/authz)")
+	enforceConsents := flag.Bool("enforceConsents", false, "Filter the resources that patients' active Consents deny access to from searches and reads (auditing the denials as AuditEvents)")
+	securityLabelPolicy := flag.String("securityLabelPolicy", "", "Filter resources by their security labels with the clearances of callers configured in this JSON file (see server.SecurityLabelPolicy)")
+	enableAudit := flag.Bool("enableAudit", false, "Record an AuditEvent for every interaction (written in the background)")
+	clientRateLimit := flag.Float64("clientRateLimit", 0, "Limit each client (by its authenticated client id or API key, or else its IP address) to this many requests per second, failing the others with 429 Too Many Requests (0 for no limit)")
+	clientRateBurst := flag.Int("clientRateBurst", 0, "Allow clients bursts of up to this many requests (with -clientRateLimit, 0 for a second's requests)")
+	tenantRateLimit := flag.Float64("tenantRateLimit", 0, "Limit the clients of each tenant together to this many requests per second (with -enableTenancy, 0 for no limit)")
+	tenantRateBurst := flag.Int("tenantRateBurst", 0, "Allow tenants bursts of up to this many requests (with -tenantRateLimit, 0 for a second's requests)")
 	enableXML := flag.Bool("enableXML", false, "Enable support for the FHIR XML encoding")
 	validatorURL := flag.String("validatorURL", "", "A FHIR validation endpoint to proxy validation requests to")
 	failedRequestsDir := flag.String("failedRequestsDir", "", "Directory where to dump failed requests (e.g. with malformed json)")
 	requestsDumpDir := flag.String("requestsDumpDir", "", "Directory where to dump all requests and responses")
 	requestsDumpGET := flag.Bool("requestsDumpGET", true, "Whether to dump HTTP GET requests")
-	enableStackdriverTracing := flag.Bool("enableStackdriverTracing", false, "Enable OpenCensus tracing to StackDriver")
-	enableJaegerTracing := flag.Bool("enableJaegerTracing", false, "Enable OpenCensus tracing to Jaeger")
+	enableTracing := flag.Bool("enableTracing", false, "Enable OpenTelemetry tracing of requests, searches, bundles and MongoDB commands, exported with OTLP to the collector of the OTEL_EXPORTER_OTLP_ENDPOINT environment variable (default localhost:4317)")
+	enableMetrics := flag.Bool("enableMetrics", false, "Enable OpenTelemetry metrics (e.g. of the requests checked by rate limits), exported with OTLP to the collector of the OTEL_EXPORTER_OTLP_ENDPOINT environment variable (default localhost:4317)")
 	startMongod := flag.Bool("startMongod", false, "Run mongod (for 'getting started' docker images - development only)")
 
 	onlyInitDB := false
+	onlyImport := false
+	onlyCreateAPIKey := false
 	if os.Args[1] == "initdb" {
 		// collections are now created automatically using PrecreateCollectionsMiddleware
 		// but this also creates indices and allows for cases when PrecreateCollectionsMiddleware
 		// doesn't have permissions to create collections
 		onlyInitDB = true
 		flag.CommandLine.Parse(os.Args[2:])
+	} else if os.Args[1] == "import" {
+		// imports the NDJSON files (or directories of them) following the flags, for initial loads
+		onlyImport = true
+		flag.CommandLine.Parse(os.Args[2:])
+	} else if os.Args[1] == "apikey" {
+		// creates an admin API key with the name following the flags, with which the /$api-keys API can be used
+		onlyCreateAPIKey = true
+		flag.CommandLine.Parse(os.Args[2:])
 	} else {
 		flag.CommandLine.Parse(os.Args[1:])
 	}
@@ -70,55 +152,196 @@ func main() {
 	}
 	glog.Infof("MongoDB URI is %s\n", *mongodbURI)
 
-	if *enableStackdriverTracing {
-		gcloudProject := os.Getenv("GCLOUD_PROJECT")
-		if gcloudProject == "" {
-			log.Fatal("GCLOUD_PROJECT not specified")
+	if *enableTracing {
+		// the exporter and resource are also configured by the OTEL_EXPORTER_OTLP_* and OTEL_RESOURCE_ATTRIBUTES
+		// environment variables
+		ctx := context.Background()
+		exporter, err := otlptracegrpc.New(ctx)
+		if err != nil {
+			log.Fatalf("Failed to create the OTLP trace exporter: %v", err)
+		}
+		res, err := resource.New(ctx, resource.WithAttributes(attribute.String("service.name", "gofhir")), resource.WithFromEnv())
+		if err != nil {
+			log.Fatalf("Failed to create the OpenTelemetry resource: %v", err)
+		}
+		tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+		defer tracerProvider.Shutdown(ctx)
+		otel.SetTracerProvider(tracerProvider)
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	}
+	if *enableMetrics {
+		// as for traces, the exporter and resource are also configured by the OTEL_EXPORTER_OTLP_* and
+		// OTEL_RESOURCE_ATTRIBUTES environment variables
+		ctx := context.Background()
+		exporter, err := otlpmetricgrpc.New(ctx)
+		if err != nil {
+			log.Fatalf("Failed to create the OTLP metric exporter: %v", err)
+		}
+		res, err := resource.New(ctx, resource.WithAttributes(attribute.String("service.name", "gofhir")), resource.WithFromEnv())
+		if err != nil {
+			log.Fatalf("Failed to create the OpenTelemetry resource: %v", err)
+		}
+		meterProvider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter)), sdkmetric.WithResource(res))
+		defer meterProvider.Shutdown(ctx)
+		otel.SetMeterProvider(meterProvider)
+	}
+
+	if !validReferencedDeletes(*referencedDeletes) {
+		log.Fatalf("-referencedDeletes must be allow, warn, block, nullify or cascade (not %q)", *referencedDeletes)
+	}
+	referencedDeletesOfTypes := make(map[string]string)
+	if *referencedDeletesByType != "" {
+		for _, typeAndPolicy := range strings.Split(*referencedDeletesByType, ",") {
+			parts := strings.SplitN(typeAndPolicy, "=", 2)
+			if _, known := search.SearchParameters()[parts[0]]; !known || len(parts) != 2 || !validReferencedDeletes(parts[1]) {
+				log.Fatalf("-referencedDeletesByType must be resource types and allow, warn, block, nullify or cascade, e.g. Patient=cascade (not %q)", typeAndPolicy)
+			}
+			referencedDeletesOfTypes[parts[0]] = parts[1]
+		}
+	}
+
+	authConfig := auth.None()
+	if *smartIssuer != "" && *smartBackendClients != "" {
+		log.Fatal("-smartIssuer and -smartBackendClients can't both be used")
+	} else if *enableAPIKeys && (*smartIssuer != "" || *smartBackendClients != "") {
+		log.Fatal("-enableAPIKeys can't be used with -smartIssuer or -smartBackendClients")
+	} else if *enableAPIKeys {
+		authConfig = auth.APIKeys()
+	} else if *smartIssuer != "" {
+		var err error
+		if authConfig, err = auth.DiscoverSMART(*smartIssuer, *smartAudience); err != nil {
+			log.Fatalf("Failed to discover the SMART authorization server: %v", err)
+		}
+	} else if *smartBackendClients != "" {
+		if *serverURL == "" {
+			log.Fatal("-smartBackendClients requires -serverURL, which is the issuer and audience of access tokens")
 		}
-		sde, err := stackdriver.NewExporter(stackdriver.Options{
-			ProjectID:    gcloudProject,
-			MetricPrefix: "gofhir",
-		})
+		clients, err := auth.LoadBackendClients(*smartBackendClients)
 		if err != nil {
-			log.Fatalf("Failed to create Stackdriver exporter: %v", err)
+			log.Fatalf("Failed to load the SMART Backend Services clients: %v", err)
 		}
-		view.RegisterExporter(sde)
-		trace.RegisterExporter(sde)
+		signingKey, err := auth.LoadSigningKey(*smartSigningKey)
+		if err != nil {
+			log.Fatalf("Failed to load the SMART signing key: %v", err)
+		}
+		authConfig = auth.BackendServices(*serverURL, clients, signingKey)
 	}
-	if *enableJaegerTracing {
-		jaegerAgentEndpointURI := os.Getenv("JAEGER_AGENT_ENDPOINT_URI")
-		jaegerCollectorEndpointURI := os.Getenv("JAEGER_COLLECTOR_ENDPOINT_URI")
 
-		je, err := jaeger.NewExporter(jaeger.Options{
-			AgentEndpoint:     jaegerAgentEndpointURI,
-			CollectorEndpoint: jaegerCollectorEndpointURI,
-			ServiceName:       "gofhir",
-		})
+	var tlsConfig *tls.Config
+	if *tlsCert != "" {
+		if *tlsKey == "" {
+			log.Fatal("-tlsCert requires -tlsKey")
+		}
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	if *clientCAs != "" {
+		if tlsConfig == nil || *clientCertIdentities == "" {
+			log.Fatal("-clientCAs requires -tlsCert and -clientCertIdentities")
+		}
+		pool, err := auth.LoadCertificatePool(*clientCAs)
+		if err != nil {
+			log.Fatalf("Failed to load the client CAs: %v", err)
+		}
+		identities, err := auth.LoadCertificateIdentities(*clientCertIdentities)
 		if err != nil {
-			log.Fatalf("Failed to create the Jaeger exporter: %v", err)
+			log.Fatalf("Failed to load the client certificate identities: %v", err)
+		}
+		tlsConfig.ClientCAs = pool
+		if authConfig.Method == auth.AuthTypeNone {
+			authConfig = auth.MutualTLS(identities)
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			authConfig.CertificateIdentities = identities
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	var labelPolicy *server.SecurityLabelPolicy
+	if *securityLabelPolicy != "" {
+		var err error
+		if labelPolicy, err = server.LoadSecurityLabelPolicy(*securityLabelPolicy); err != nil {
+			log.Fatalf("Failed to load the security label policy: %v", err)
 		}
-		trace.RegisterExporter(je)
 	}
-	tracingEnabled := *enableJaegerTracing || *enableStackdriverTracing
-	trace.ApplyConfig(trace.Config{DefaultSampler: trace.AlwaysSample()})
+
+	var profilePackageFiles []string
+	if *profilePackages != "" {
+		profilePackageFiles = strings.Split(*profilePackages, ",")
+	}
 
 	var MyConfig = server.Config{
 		CreateIndexes:                !*dontCreateIndexes,
 		IndexConfigPath:              "config/indexes.conf",
+		CreateTextIndexes:            !*dontCreateTextIndexes,
+		CreateSearchIndexes:          *createSearchIndexes,
+		SearchIndexConfigPath:        "config/search_indexes.conf",
+		IndexHintsPath:               *indexHints,
+		SearchParametersDir:          *searchParametersDir,
 		DatabaseURI:                  *mongodbURI,
 		DefaultDatabaseName:          *databaseName,
 		EnableMultiDB:                *enableMultiDB,
 		DatabaseSuffix:               *databaseSuffix,
+		EnableTenancy:                *enableTenancy,
+		TenantHeader:                 *tenantHeader,
 		DatabaseSocketTimeout:        2 * time.Minute,
-		DatabaseOpTimeout:            90 * time.Second,
+		DatabaseOpTimeout:            *databaseOpTimeout,
 		DatabaseKillOpPeriod:         10 * time.Second,
-		Auth:                         auth.None(),
+		ServerURL:                    *serverURL,
+		Auth:                         authConfig,
+		OPAURL:                       *opaURL,
+		EnforceConsents:              *enforceConsents,
+		SecurityLabelPolicy:          labelPolicy,
+		EnableAudit:                  *enableAudit,
+		ClientRateLimit:              *clientRateLimit,
+		ClientRateBurst:              *clientRateBurst,
+		TenantRateLimit:              *tenantRateLimit,
+		TenantRateBurst:              *tenantRateBurst,
 		EnableCISearches:             true,
 		TokenParametersCaseSensitive: *tokenParametersCaseSensitive,
+		LowercaseSearchFields:        *lowercaseSearchFields,
+		PrecomputedCompartments:      *precomputedCompartments,
+		Collation:                    *collation,
+		CollationStrength:            *collationStrength,
+		DefaultTimezone:              *defaultTimezone,
+		MaxChainDepth:                *maxChainDepth,
+		MaxIncludeDepth:              *maxIncludeDepth,
+		DefaultCount:                 *defaultCount,
+		MaxCount:                     *maxCount,
+		MaxIncludes:                  *maxIncludes,
+		KeysetPaging:                 *keysetPaging,
+		ResultSetLifetime:            *resultSetLifetime,
+		ResultSetMaxResults:          *resultSetMaxResults,
 		CountTotalResults:            *disableSearchTotals == false,
+		CacheSearchCounts:            *cacheSearchCounts,
+		CountCacheTTL:                *countCacheTTL,
+		CountCacheMaxEntries:         *countCacheMaxEntries,
+		CountCacheRedisURL:           *countCacheRedisURL,
 		ReadOnly:                     false,
 		EnableXML:                    *enableXML,
+		EnableExplain:                *enableExplain,
+		EnableBulkExport:             *enableBulkExport,
+		BulkExportDestination:        *bulkExportDestination,
+		BulkExportLifetime:           *bulkExportLifetime,
+		EnableBulkImport:             *enableBulkImport,
+		EnableAsync:                  *enableAsync,
+		AsyncWorkers:                 *asyncWorkers,
+		AsyncJobLifetime:             *asyncJobLifetime,
+		EnableSubscriptions:          *enableSubscriptions,
+		SubscriptionRetries:          *subscriptionRetries,
+		SubscriptionRetryDelay:       *subscriptionRetryDelay,
+		SubscriptionEventLifetime:    *subscriptionEventLifetime,
+		EventBusURL:                  *eventBusURL,
+		EventTopic:                   *eventTopic,
+		EventPayloads:                *eventPayloads,
+		EnableChangeFeed:             *enableChangeFeed,
 		EnableHistory:                *enableHistory,
+		ConditionalDeleteMultiple:    *conditionalDeleteMultiple,
+		RequireIfMatch:               *requireIfMatch,
+		ValidateResources:            *validateResources,
+		ProfilePackages:              profilePackageFiles,
+		EnforceReferences:            *enforceReferences,
+		ReferencedDeletes:            *referencedDeletes,
+		ReferencedDeletesByType:      referencedDeletesOfTypes,
 		BatchConcurrency:             *batchConcurrency,
 		Debug:                        true,
 		ValidatorURL:                 *validatorURL,
@@ -134,6 +357,24 @@ func main() {
 		s.InitDB(*databaseName)
 		return
 	}
+	if onlyImport {
+		fmt.Printf("Importing into MongoDB database %s\n", *databaseName)
+		if err := s.ImportFiles(*databaseName, flag.Args()); err != nil {
+			log.Fatalf("Import failed: %+v", err)
+		}
+		return
+	}
+	if onlyCreateAPIKey {
+		if flag.NArg() != 1 {
+			log.Fatal("Usage: fhir-server apikey [flags] <name>")
+		}
+		key, err := s.CreateAdminAPIKey(*databaseName, flag.Arg(0))
+		if err != nil {
+			log.Fatalf("Creating the API key failed: %+v", err)
+		}
+		fmt.Printf("Created the admin API key %s (which isn't stored, so can't be shown again):\n%s\n", flag.Arg(0), key)
+		return
+	}
 
 	// Mutex middleware to work around the lack of proper transactions in MongoDB
 	// (unless using a MongoDB >= 4.0 replica set)
@@ -150,15 +391,24 @@ func main() {
 	if *requestsDumpDir != "" {
 		handler = middleware.FileLoggerMiddleware(*requestsDumpDir, *requestsDumpGET, handler)
 	}
-	if tracingEnabled {
-		// receives and propagates distributed trace context
-		handler = &ochttp.Handler{
-			Handler:     handler,
-			Propagation: &stackdriverPropagation.HTTPFormat{},
-		}
+	if *enableTracing {
+		// receives and propagates distributed trace context (W3C traceparent headers), naming the spans of requests
+		// by their method and first path segment (e.g. "GET /Patient") to keep their number bounded
+		handler = otelhttp.NewHandler(handler, "FHIR", otelhttp.WithSpanNameFormatter(func(operation string, r *http.Request) string {
+			segments := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+			return r.Method + " /" + segments[0]
+		}))
 	}
 
 	address := fmt.Sprintf(":%d", *port)
+	if tlsConfig != nil {
+		httpServer := &http.Server{Addr: address, Handler: handler, TLSConfig: tlsConfig}
+		err := httpServer.ListenAndServeTLS(*tlsCert, *tlsKey)
+		if err != nil {
+			panic("ListenAndServeTLS failed: " + err.Error())
+		}
+		return
+	}
 	err := http.ListenAndServe(address, handler)
 	if err != nil {
 		panic("ListenAndServe failed: " + err.Error())
@@ -207,3 +457,14 @@ func startMongoDB() {
 	}
 	time.Sleep(2 * time.Second)
 }
+
+// validReferencedDeletes returns whether a policy for deleting referenced resources is valid (see
+// server.Config.ReferencedDeletes)
+func validReferencedDeletes(policy string) bool {
+	switch policy {
+	case server.ReferencedDeletesAllow, server.ReferencedDeletesWarn, server.ReferencedDeletesBlock,
+		server.ReferencedDeletesNullify, server.ReferencedDeletesCascade:
+		return true
+	}
+	return false
+}
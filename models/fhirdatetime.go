@@ -42,9 +42,10 @@ func (f FHIRDateTime) GetBSON() (interface{}, error) {
 		return nil, errors.Wrap(err, "FHIRDateTime.GetBSON: ParseDate failed")
 	}
 
+	from, to := date.UTCRange()
 	doc := []bson.DocElem{
-		bson.DocElem{Name: "__from", Value: date.RangeLowIncl()},
-		bson.DocElem{Name: "__to", Value: date.RangeHighExcl()},
+		bson.DocElem{Name: "__from", Value: from},
+		bson.DocElem{Name: "__to", Value: to},
 		bson.DocElem{Name: "__strDate", Value: stringForm},
 	}
 	return doc, nil
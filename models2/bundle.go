@@ -12,6 +12,7 @@ type ShallowBundle struct {
 	Meta         *models.Meta                  `json:"meta,omitempty"`
 	Type         string                        `json:"type,omitempty"`
 	Id           string                        `json:"id,omitempty"`
+	Identifier   *models.Identifier            `json:"identifier,omitempty"`
 	Total        *uint32                       `json:"total,omitempty"`
 	Entry        []ShallowBundleEntryComponent `json:"entry,omitempty"`
 	Link         []models.BundleLinkComponent  `json:"link,omitempty"`
@@ -7,7 +7,9 @@ import (
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/eug48/fhir/utils"
 	"github.com/stretchr/testify/assert"
 	"go.mongodb.org/mongo-driver/bson"
 )
@@ -115,6 +117,91 @@ func TestEncryptionOfMedicareIdentifier(t *testing.T) {
 	}
 }
 
+func TestLowercaseCopies(t *testing.T) {
+	jsonBytes := []byte(`{"resourceType": "Patient", "id": "Abc", "gender": "Male", "active": true,
+		"name": [{"family": "McDonald", "given": ["Ronald", "J"]}],
+		"managingOrganization": {"reference": "Organization/1"}}`)
+
+	bsonDoc, err := ConvertJsonToGoFhirBSON(jsonBytes, WhatToEncrypt{}, map[string]string{})
+	assert.Nil(t, err)
+
+	fields := bsonDoc.Map()
+	assert.Equal(t, "male", fields["gender__lower"])
+	assert.Nil(t, fields["resourceType__lower"], "resource types aren't searched case-insensitively")
+	assert.Nil(t, fields["_id__lower"], "ids aren't searched case-insensitively")
+	assert.Nil(t, fields["active__lower"], "only strings have lowercase copies")
+
+	name := bson.D(fields["name"].([]interface{})[0].([]bson.E)).Map()
+	assert.Equal(t, "mcdonald", name["family__lower"])
+	assert.Equal(t, []interface{}{"ronald", "j"}, name["given__lower"])
+
+	organization := bson.D(fields["managingOrganization"].([]bson.E)).Map()
+	assert.Equal(t, "organization/1", organization["reference__lower"])
+
+	backToJson, _, err := ConvertGoFhirBSONToJSON(bsonDoc)
+	assert.Nil(t, err)
+	assert.JSONEq(t, string(jsonBytes), string(backToJson), "lowercase copies shouldn't be returned")
+}
+
+func TestCompartmentsField(t *testing.T) {
+	jsonBytes := []byte(`{"resourceType": "Observation", "id": "Abc", "subject": {"reference": "Patient/123"}}`)
+	resource, err := NewResourceFromJsonBytes(jsonBytes)
+	assert.Nil(t, err)
+
+	bsonDoc, err := resource.GetBSON()
+	assert.Nil(t, err)
+	assert.Nil(t, bson.D(bsonDoc.([]bson.E)).Map()[CompartmentsField], "compartments are only stored once set")
+
+	resource.SetCompartments([]string{"Patient/123"})
+	bsonDoc, err = resource.GetBSON()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Patient/123"}, bson.D(bsonDoc.([]bson.E)).Map()[CompartmentsField])
+
+	resource.SetCompartments(nil)
+	bsonDoc, err = resource.GetBSON()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{}, bson.D(bsonDoc.([]bson.E)).Map()[CompartmentsField], "resources in no compartments have an empty list")
+
+	backToJson, _, err := ConvertGoFhirBSONToJSON(bsonDoc.([]bson.E))
+	assert.Nil(t, err)
+	assert.JSONEq(t, string(jsonBytes), string(backToJson), "compartments shouldn't be returned")
+}
+
+func TestDateRanges(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	assert.Nil(t, err)
+	utils.SetDefaultLocation(newYork)
+	defer utils.SetDefaultLocation(nil)
+
+	jsonBytes := []byte(`{"resourceType": "Patient", "id": "Abc", "birthDate": "2012-01-01",
+		"deceasedDateTime": "2012-01-01T23:00:00-05:00"}`)
+
+	bsonDoc, err := ConvertJsonToGoFhirBSON(jsonBytes, WhatToEncrypt{}, map[string]string{})
+	assert.Nil(t, err)
+	fields := bsonDoc.Map()
+
+	// Dates without a time are in the default timezone, and all are stored as UTC ranges
+	birthDate := bson.D(fields["birthDate"].([]bson.E)).Map()
+	assert.Equal(t, time.Date(2012, time.January, 1, 5, 0, 0, 0, time.UTC), birthDate["__from"])
+	assert.Equal(t, time.Date(2012, time.January, 2, 5, 0, 0, 0, time.UTC), birthDate["__to"])
+	assert.Equal(t, "2012-01-01", birthDate["__strDate"])
+
+	deceased := bson.D(fields["deceasedDateTime"].([]bson.E)).Map()
+	assert.Equal(t, time.Date(2012, time.January, 2, 4, 0, 0, 0, time.UTC), deceased["__from"])
+	assert.Equal(t, time.Date(2012, time.January, 2, 4, 0, 1, 0, time.UTC), deceased["__to"])
+}
+
+func TestDecimalRangesTooPrecise(t *testing.T) {
+	// The ranges of decimals are stored as Decimal128s, which have at most 34 significant digits
+	jsonBytes := []byte(`{"resourceType": "RiskAssessment", "prediction": [{"probabilityDecimal": 0.5}]}`)
+	_, err := ConvertJsonToGoFhirBSON(jsonBytes, WhatToEncrypt{}, map[string]string{})
+	assert.Nil(t, err)
+
+	jsonBytes = []byte(`{"resourceType": "RiskAssessment", "prediction": [{"probabilityDecimal": 0.12345678901234567890123456789012345}]}`)
+	_, err = ConvertJsonToGoFhirBSON(jsonBytes, WhatToEncrypt{}, map[string]string{})
+	assert.NotNil(t, err)
+}
+
 func printBSON(bsonDoc *bson.D) {
 	bsonBytes, err := bson.Marshal(bsonDoc)
 	if err != nil {
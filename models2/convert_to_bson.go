@@ -20,6 +20,13 @@ const Gofhir__strDate = "__strDate"
 const Gofhir__num = "__num"
 const Gofhir__from = "__from"
 const Gofhir__to = "__to"
+const Gofhir__position = "__position"
+const Gofhir__textScore = "__textScore"
+const Gofhir__lower = "__lower"
+
+// CompartmentsField is the field of a stored resource that lists the compartments it's in
+// (e.g. ["Patient/123"]), so that compartment searches can use a single index
+const CompartmentsField = "_compartments"
 
 // Converts a FHIR JSON Resource into BSON for storage in MongoDB
 // Does several transformations:
@@ -28,6 +35,8 @@ const Gofhir__to = "__to"
 //   - converts extensions from { url, value } to { url: { value } } to enable better MongoDB queries
 //   - converts decimal numbers to { __from, __to, __num, __strNum } for FHIR conformance
 //   - converts dates to { __from, __to, __strDate } for FHIR conformance
+//   - adds a GeoJSON __position point for a Location's position (for near searches)
+//   - adds a lowercase <key>__lower copy of strings, codes and uris (for case-insensitive searches)
 //   - optionally encrypts certain fields
 func ConvertJsonToGoFhirBSON(jsonBytes []byte, whatToEncrypt WhatToEncrypt, transformReferencesMap map[string]string) (out bson.D, err error) {
 
@@ -51,6 +60,10 @@ func ConvertJsonToGoFhirBSON(jsonBytes []byte, whatToEncrypt WhatToEncrypt, tran
 		})
 	}
 
+	if err == nil && resourceType == "Location" {
+		addGeoJSONPosition(&bsonRoot, jsonBytes)
+	}
+
 	if err == nil {
 		err = encryptBSON(&bsonRoot, resourceType, whatToEncrypt)
 		if err != nil {
@@ -65,6 +78,24 @@ func ConvertJsonToGoFhirBSON(jsonBytes []byte, whatToEncrypt WhatToEncrypt, tran
 	}
 }
 
+// addGeoJSONPosition adds a Location's position as a GeoJSON point, which can be
+// indexed using a 2dsphere index.  Positions without both a latitude and a
+// longitude (or with coordinates out of range) are left out.
+func addGeoJSONPosition(output *[]bson.E, jsonBytes []byte) {
+	latitude, err := jsonparser.GetFloat(jsonBytes, "position", "latitude")
+	if err != nil || latitude < -90 || latitude > 90 {
+		return
+	}
+	longitude, err := jsonparser.GetFloat(jsonBytes, "position", "longitude")
+	if err != nil || longitude < -180 || longitude > 180 {
+		return
+	}
+	*output = append(*output, bson.E{Key: Gofhir__position, Value: bson.D{
+		bson.E{Key: "type", Value: "Point"},
+		bson.E{Key: "coordinates", Value: bson.A{longitude, latitude}},
+	}})
+}
+
 func addToBSONdoc(output *[]bson.E, pos positionInfo, key []byte, value []byte, dataType jsonparser.ValueType, offset int, refsMap refsMap) error {
 	strKey := string(key)
 	nextPos := pos.downTo(strKey, value)
@@ -89,6 +120,12 @@ func addToBSONdoc(output *[]bson.E, pos positionInfo, key []byte, value []byte,
 		*output = append(*output, elem)
 	}
 
+	if nextPos.atSearchableString() && strKey != "resourceType" {
+		if lowercase, ok := lowercaseValue(valueBson); ok {
+			*output = append(*output, bson.E{Key: bsonKey + Gofhir__lower, Value: lowercase})
+		}
+	}
+
 	if pos.atReference() && strKey == "reference" /* ignore the identifier and display fields */ {
 
 		// transform reference during transactions
@@ -146,6 +183,29 @@ func addToBSONdoc(output *[]bson.E, pos positionInfo, key []byte, value []byte,
 	return nil
 }
 
+// lowercaseValue returns a lowercase copy of a string (or of an array of strings), which
+// can be searched case-insensitively using an index (unlike a case-insensitive regex)
+func lowercaseValue(value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case string:
+		return strings.ToLower(v), true
+	case []interface{}:
+		lowercase := make([]interface{}, len(v))
+		for i, item := range v {
+			switch s := item.(type) {
+			case string:
+				lowercase[i] = strings.ToLower(s)
+			case nil:
+				lowercase[i] = nil
+			default:
+				return nil, false
+			}
+		}
+		return lowercase, true
+	}
+	return nil, false
+}
+
 func addToBSONarray(output *[]interface{}, pos positionInfo, value []byte, dataType jsonparser.ValueType, offset int, refsMap refsMap) error {
 
 	valueBson, err := convertValue(pos.intoArray(value), value, dataType, refsMap)
@@ -331,9 +391,10 @@ func convertDateValue(jsonBytes []byte, pos positionInfo) (elem interface{}, err
 		return nil, errors.Wrap(err, "ParseDate failed")
 	}
 
+	from, to := date.UTCRange()
 	elem = []bson.E{
-		bson.E{Key: Gofhir__from, Value: date.RangeLowIncl()},
-		bson.E{Key: Gofhir__to, Value: date.RangeHighExcl()},
+		bson.E{Key: Gofhir__from, Value: from},
+		bson.E{Key: Gofhir__to, Value: to},
 		bson.E{Key: Gofhir__strDate, Value: stringForm},
 	}
 	return
@@ -359,12 +420,19 @@ func convertNumberValue(jsonBytes []byte, pos positionInfo) (elem interface{}, e
 			return nil, errors.Wrapf(err, "GetFloat or GetInt failed for %s at %s", stringForm, pos.pathHere)
 		}
 
+		// The range is based on the precision of the number (e.g. 1.50 is [1.495, 1.505))
+		// and is stored as Decimal128 so that searches don't lose precision
 		num := utils.ParseNumber(stringForm)
-		numFrom, _ := num.RangeLowIncl().Float64()
-		numTo, _ := num.RangeHighExcl().Float64()
+		numFrom, err := num.RangeLowInclDecimal128()
+		if err != nil {
+			return nil, errors.Wrapf(err, "decimal %s at %s is too precise", stringForm, pos.pathHere)
+		}
+		numTo, err := num.RangeHighExclDecimal128()
+		if err != nil {
+			return nil, errors.Wrapf(err, "decimal %s at %s is too precise", stringForm, pos.pathHere)
+		}
 
 		elem = []bson.E{
-			// TODO: set ranges properly based on precision
 			bson.E{Key: Gofhir__from, Value: numFrom},
 			bson.E{Key: Gofhir__to, Value: numTo},
 			bson.E{Key: Gofhir__num, Value: numValue},
@@ -143,13 +143,16 @@ func processDocument(out *bytes.Buffer, bsonDoc []bson.E) (err error) {
 		debug("processDocument: %s", elem.Key)
 
 		switch elem.Key {
-		case "reference__id", "reference__type", "reference__external":
+		case "reference__id", "reference__type", "reference__external", Gofhir__position, Gofhir__textScore, CompartmentsField:
 			continue // i.e. skip
 		}
 
 		if docIncluded(elem.Key) {
 			continue // handled above
 		}
+		if strings.HasSuffix(elem.Key, Gofhir__lower) {
+			continue // lowercase copies are only for searches
+		}
 		if strings.HasPrefix(elem.Key, "_lookup") {
 			continue // handled above
 		}
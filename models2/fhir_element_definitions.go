@@ -0,0 +1,6448 @@
+package models2
+
+// fhirElementDefinitions lists the child elements of each FHIR resource, data type and backbone element (e.g.
+// Patient.contact) with their cardinalities, the types of choice elements, and the codes of required bindings
+// (as system|code for Codings and CodeableConcepts).
+//
+// They're taken from the FHIR STU3 definitions in fsharp-fhir-tools/PathsByType/STU3/profiles-resources.json and
+// profiles-types.json, with the codes of the value sets bundled with FHIR.js (server/format_conversion_javascript.go).
+var fhirElementDefinitions = map[string][]elementDefinition{
+	"Account": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "status", max: 1, codes: []string{"active", "inactive", "entered-in-error"}},
+		{name: "type", max: 1},
+		{name: "name", max: 1},
+		{name: "subject", max: 1},
+		{name: "period", max: 1},
+		{name: "active", max: 1},
+		{name: "balance", max: 1},
+		{name: "coverage", max: -1},
+		{name: "owner", max: 1},
+		{name: "description", max: 1},
+		{name: "guarantor", max: -1},
+	},
+	"Account.coverage": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "coverage", min: 1, max: 1},
+		{name: "priority", max: 1},
+	},
+	"Account.guarantor": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "party", min: 1, max: 1},
+		{name: "onHold", max: 1},
+		{name: "period", max: 1},
+	},
+	"ActivityDefinition": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "url", max: 1},
+		{name: "identifier", max: -1},
+		{name: "version", max: 1},
+		{name: "name", max: 1},
+		{name: "title", max: 1},
+		{name: "status", min: 1, max: 1, codes: []string{"draft", "active", "retired", "unknown"}},
+		{name: "experimental", max: 1},
+		{name: "date", max: 1},
+		{name: "publisher", max: 1},
+		{name: "description", max: 1},
+		{name: "purpose", max: 1},
+		{name: "usage", max: 1},
+		{name: "approvalDate", max: 1},
+		{name: "lastReviewDate", max: 1},
+		{name: "effectivePeriod", max: 1},
+		{name: "useContext", max: -1},
+		{name: "jurisdiction", max: -1},
+		{name: "topic", max: -1},
+		{name: "contributor", max: -1},
+		{name: "contact", max: -1},
+		{name: "copyright", max: 1},
+		{name: "relatedArtifact", max: -1},
+		{name: "library", max: -1},
+		{name: "kind", max: 1, codes: []string{"Account", "ActivityDefinition", "AdverseEvent", "AllergyIntolerance", "Appointment", "AppointmentResponse", "AuditEvent", "Basic", "Binary", "BiologicallyDerivedProduct", "BodyStructure", "Bundle", "CapabilityStatement", "CarePlan", "CareTeam", "ChargeItem", "Claim", "ClaimResponse", "ClinicalImpression", "CodeSystem", "Communication", "CommunicationRequest", "CompartmentDefinition", "Composition", "ConceptMap", "Condition", "Consent", "Contract", "Coverage", "DetectedIssue", "Device", "DeviceComponent", "DeviceMetric", "DeviceRequest", "DeviceUseStatement", "DiagnosticReport", "DocumentManifest", "DocumentReference", "DomainResource", "EligibilityRequest", "EligibilityResponse", "Encounter", "Endpoint", "EnrollmentRequest", "EnrollmentResponse", "EntryDefinition", "EpisodeOfCare", "EventDefinition", "ExampleScenario", "ExpansionProfile", "ExplanationOfBenefit", "FamilyMemberHistory", "Flag", "Goal", "GraphDefinition", "Group", "GuidanceResponse", "HealthcareService", "ImagingStudy", "Immunization", "ImmunizationEvaluation", "ImmunizationRecommendation", "ImplementationGuide", "ImplementationGuideInput", "ImplementationGuideOutput", "Invoice", "ItemInstance", "Library", "Linkage", "List", "Location", "Measure", "MeasureReport", "Media", "Medication", "MedicationAdministration", "MedicationDispense", "MedicationRequest", "MedicationStatement", "MedicinalProduct", "MedicinalProductAuthorization", "MedicinalProductClinicals", "MedicinalProductDeviceSpec", "MedicinalProductIngredient", "MedicinalProductPackaged", "MedicinalProductPharmaceutical", "MessageDefinition", "MessageHeader", "NamingSystem", "NutritionOrder", "Observation", "ObservationDefinition", "OccupationalData", "OperationDefinition", "OperationOutcome", "Organization", "OrganizationRole", "Parameters", "Patient", "PaymentNotice", "PaymentReconciliation", "Person", "PlanDefinition", "Practitioner", "PractitionerRole", "Procedure", "ProcessRequest", "ProcessResponse", "ProductPlan", "Provenance", "Questionnaire", "QuestionnaireResponse", "RelatedPerson", "RequestGroup", "ResearchStudy", "ResearchSubject", "Resource", "RiskAssessment", "Schedule", "SearchParameter", "Sequence", "ServiceDefinition", "ServiceRequest", "Slot", "Specimen", "SpecimenDefinition", "StructureDefinition", "StructureMap", "Subscription", "Substance", "SubstancePolymer", "SubstanceReferenceInformation", "SubstanceSpecification", "SupplyDelivery", "SupplyRequest", "Task", "TerminologyCapabilities", "TestReport", "TestScript", "UserSession", "ValueSet", "VerificationResult", "VisionPrescription"}},
+		{name: "code", max: 1},
+		{name: "timing[x]", max: 1, types: []string{"Timing", "dateTime", "Period", "Range"}},
+		{name: "location", max: 1},
+		{name: "participant", max: -1},
+		{name: "product[x]", max: 1, types: []string{"Reference", "CodeableConcept"}},
+		{name: "quantity", max: 1},
+		{name: "dosage", max: -1},
+		{name: "bodySite", max: -1},
+		{name: "transform", max: 1},
+		{name: "dynamicValue", max: -1},
+	},
+	"ActivityDefinition.dynamicValue": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "description", max: 1},
+		{name: "path", max: 1},
+		{name: "language", max: 1},
+		{name: "expression", max: 1},
+	},
+	"ActivityDefinition.participant": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "type", min: 1, max: 1, codes: []string{"patient", "practitioner", "related-person"}},
+		{name: "role", max: 1},
+	},
+	"Address": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "use", max: 1, codes: []string{"home", "work", "temp", "old", "billing"}},
+		{name: "type", max: 1, codes: []string{"postal", "physical", "both"}},
+		{name: "text", max: 1},
+		{name: "line", max: -1},
+		{name: "city", max: 1},
+		{name: "district", max: 1},
+		{name: "state", max: 1},
+		{name: "postalCode", max: 1},
+		{name: "country", max: 1},
+		{name: "period", max: 1},
+	},
+	"AdverseEvent": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: 1},
+		{name: "category", max: 1, codes: []string{"AE", "PAE", "ProductProblem", "ProductQuality", "ProductUseError", "MedicalDeviceUseError", "ProblemDifferentManufacturer", "UnsafePhysicalEnvironment"}},
+		{name: "type", max: 1},
+		{name: "subject", max: 1},
+		{name: "date", max: 1},
+		{name: "reaction", max: -1},
+		{name: "location", max: 1},
+		{name: "seriousness", max: 1},
+		{name: "outcome", max: 1, codes: []string{"http://hl7.org/fhir/adverse-event-outcome|resolved", "http://hl7.org/fhir/adverse-event-outcome|recovering", "http://hl7.org/fhir/adverse-event-outcome|ongoing", "http://hl7.org/fhir/adverse-event-outcome|resolvedWithSequelae", "http://hl7.org/fhir/adverse-event-outcome|fatal", "http://hl7.org/fhir/adverse-event-outcome|unknown"}},
+		{name: "recorder", max: 1},
+		{name: "eventParticipant", max: 1},
+		{name: "description", max: 1},
+		{name: "suspectEntity", max: -1},
+		{name: "subjectMedicalHistory", max: -1},
+		{name: "referenceDocument", max: -1},
+		{name: "study", max: -1},
+	},
+	"AdverseEvent.suspectEntity": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "instance", min: 1, max: 1},
+		{name: "causality", max: 1, codes: []string{"causality1", "causality2"}},
+		{name: "causalityAssessment", max: 1},
+		{name: "causalityProductRelatedness", max: 1},
+		{name: "causalityMethod", max: 1},
+		{name: "causalityAuthor", max: 1},
+		{name: "causalityResult", max: 1},
+	},
+	"Age": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "value", max: 1},
+		{name: "comparator", max: 1, codes: []string{"<", "<=", ">=", ">"}},
+		{name: "unit", max: 1},
+		{name: "system", max: 1},
+		{name: "code", max: 1},
+	},
+	"AllergyIntolerance": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "clinicalStatus", max: 1, codes: []string{"active", "inactive", "resolved"}},
+		{name: "verificationStatus", min: 1, max: 1, codes: []string{"unconfirmed", "confirmed", "refuted", "entered-in-error"}},
+		{name: "type", max: 1, codes: []string{"allergy", "intolerance"}},
+		{name: "category", max: -1, codes: []string{"food", "medication", "environment", "biologic"}},
+		{name: "criticality", max: 1, codes: []string{"low", "high", "unable-to-assess"}},
+		{name: "code", max: 1},
+		{name: "patient", min: 1, max: 1},
+		{name: "onset[x]", max: 1, types: []string{"dateTime", "Age", "Period", "Range", "string"}},
+		{name: "assertedDate", max: 1},
+		{name: "recorder", max: 1},
+		{name: "asserter", max: 1},
+		{name: "lastOccurrence", max: 1},
+		{name: "note", max: -1},
+		{name: "reaction", max: -1},
+	},
+	"AllergyIntolerance.reaction": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "substance", max: 1},
+		{name: "manifestation", min: 1, max: -1},
+		{name: "description", max: 1},
+		{name: "onset", max: 1},
+		{name: "severity", max: 1, codes: []string{"mild", "moderate", "severe"}},
+		{name: "exposureRoute", max: 1},
+		{name: "note", max: -1},
+	},
+	"Annotation": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "author[x]", max: 1, types: []string{"Reference", "string"}},
+		{name: "time", max: 1},
+		{name: "text", min: 1, max: 1},
+	},
+	"Appointment": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "status", min: 1, max: 1, codes: []string{"proposed", "pending", "booked", "arrived", "fulfilled", "cancelled", "noshow", "entered-in-error"}},
+		{name: "serviceCategory", max: 1},
+		{name: "serviceType", max: -1},
+		{name: "specialty", max: -1},
+		{name: "appointmentType", max: 1},
+		{name: "reason", max: -1},
+		{name: "indication", max: -1},
+		{name: "priority", max: 1},
+		{name: "description", max: 1},
+		{name: "supportingInformation", max: -1},
+		{name: "start", max: 1},
+		{name: "end", max: 1},
+		{name: "minutesDuration", max: 1},
+		{name: "slot", max: -1},
+		{name: "created", max: 1},
+		{name: "comment", max: 1},
+		{name: "incomingReferral", max: -1},
+		{name: "participant", min: 1, max: -1},
+		{name: "requestedPeriod", max: -1},
+	},
+	"Appointment.participant": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "type", max: -1},
+		{name: "actor", max: 1},
+		{name: "required", max: 1, codes: []string{"required", "optional", "information-only"}},
+		{name: "status", min: 1, max: 1, codes: []string{"accepted", "declined", "tentative", "needs-action"}},
+	},
+	"AppointmentResponse": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "appointment", min: 1, max: 1},
+		{name: "start", max: 1},
+		{name: "end", max: 1},
+		{name: "participantType", max: -1},
+		{name: "actor", max: 1},
+		{name: "participantStatus", min: 1, max: 1, codes: []string{"accepted", "declined", "tentative", "in-process", "completed", "needs-action", "entered-in-error"}},
+		{name: "comment", max: 1},
+	},
+	"Attachment": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "contentType", max: 1},
+		{name: "language", max: 1},
+		{name: "data", max: 1},
+		{name: "url", max: 1},
+		{name: "size", max: 1},
+		{name: "hash", max: 1},
+		{name: "title", max: 1},
+		{name: "creation", max: 1},
+	},
+	"AuditEvent": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "type", min: 1, max: 1},
+		{name: "subtype", max: -1},
+		{name: "action", max: 1, codes: []string{"C", "R", "U", "D", "E"}},
+		{name: "recorded", min: 1, max: 1},
+		{name: "outcome", max: 1, codes: []string{"0", "4", "8", "12"}},
+		{name: "outcomeDesc", max: 1},
+		{name: "purposeOfEvent", max: -1},
+		{name: "agent", min: 1, max: -1},
+		{name: "source", min: 1, max: 1},
+		{name: "entity", max: -1},
+	},
+	"AuditEvent.agent": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "role", max: -1},
+		{name: "reference", max: 1},
+		{name: "userId", max: 1},
+		{name: "altId", max: 1},
+		{name: "name", max: 1},
+		{name: "requestor", min: 1, max: 1},
+		{name: "location", max: 1},
+		{name: "policy", max: -1},
+		{name: "media", max: 1},
+		{name: "network", max: 1},
+		{name: "purposeOfUse", max: -1},
+	},
+	"AuditEvent.agent.network": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "address", max: 1},
+		{name: "type", max: 1, codes: []string{"1", "2", "3", "4", "5"}},
+	},
+	"AuditEvent.entity": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: 1},
+		{name: "reference", max: 1},
+		{name: "type", max: 1},
+		{name: "role", max: 1},
+		{name: "lifecycle", max: 1},
+		{name: "securityLabel", max: -1},
+		{name: "name", max: 1},
+		{name: "description", max: 1},
+		{name: "query", max: 1},
+		{name: "detail", max: -1},
+	},
+	"AuditEvent.entity.detail": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "type", min: 1, max: 1},
+		{name: "value", min: 1, max: 1},
+	},
+	"AuditEvent.source": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "site", max: 1},
+		{name: "identifier", min: 1, max: 1},
+		{name: "type", max: -1},
+	},
+	"Basic": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "code", min: 1, max: 1},
+		{name: "subject", max: 1},
+		{name: "created", max: 1},
+		{name: "author", max: 1},
+	},
+	"Binary": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "contentType", min: 1, max: 1},
+		{name: "securityContext", max: 1},
+		{name: "content", min: 1, max: 1},
+	},
+	"BodySite": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "active", max: 1},
+		{name: "code", max: 1},
+		{name: "qualifier", max: -1},
+		{name: "description", max: 1},
+		{name: "image", max: -1},
+		{name: "patient", min: 1, max: 1},
+	},
+	"Bundle": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "identifier", max: 1},
+		{name: "type", min: 1, max: 1, codes: []string{"document", "message", "transaction", "transaction-response", "batch", "batch-response", "history", "searchset", "collection"}},
+		{name: "total", max: 1},
+		{name: "link", max: -1},
+		{name: "entry", max: -1},
+		{name: "signature", max: 1},
+	},
+	"Bundle.entry": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "link", max: -1},
+		{name: "fullUrl", max: 1},
+		{name: "resource", max: 1},
+		{name: "search", max: 1},
+		{name: "request", max: 1},
+		{name: "response", max: 1},
+	},
+	"Bundle.entry.request": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "method", min: 1, max: 1, codes: []string{"GET", "POST", "PUT", "DELETE", "HEAD", "PATCH"}},
+		{name: "url", min: 1, max: 1},
+		{name: "ifNoneMatch", max: 1},
+		{name: "ifModifiedSince", max: 1},
+		{name: "ifMatch", max: 1},
+		{name: "ifNoneExist", max: 1},
+	},
+	"Bundle.entry.response": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "status", min: 1, max: 1},
+		{name: "location", max: 1},
+		{name: "etag", max: 1},
+		{name: "lastModified", max: 1},
+		{name: "outcome", max: 1},
+	},
+	"Bundle.entry.search": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "mode", max: 1, codes: []string{"match", "include", "outcome"}},
+		{name: "score", max: 1},
+	},
+	"Bundle.link": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "relation", min: 1, max: 1},
+		{name: "url", min: 1, max: 1},
+	},
+	"CapabilityStatement": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "url", max: 1},
+		{name: "version", max: 1},
+		{name: "name", max: 1},
+		{name: "title", max: 1},
+		{name: "status", min: 1, max: 1, codes: []string{"draft", "active", "retired", "unknown"}},
+		{name: "experimental", max: 1},
+		{name: "date", min: 1, max: 1},
+		{name: "publisher", max: 1},
+		{name: "contact", max: -1},
+		{name: "description", max: 1},
+		{name: "useContext", max: -1},
+		{name: "jurisdiction", max: -1},
+		{name: "purpose", max: 1},
+		{name: "copyright", max: 1},
+		{name: "kind", min: 1, max: 1, codes: []string{"instance", "capability", "requirements"}},
+		{name: "instantiates", max: -1},
+		{name: "software", max: 1},
+		{name: "implementation", max: 1},
+		{name: "fhirVersion", min: 1, max: 1},
+		{name: "acceptUnknown", min: 1, max: 1, codes: []string{"no", "extensions", "elements", "both"}},
+		{name: "format", min: 1, max: -1},
+		{name: "patchFormat", max: -1},
+		{name: "implementationGuide", max: -1},
+		{name: "profile", max: -1},
+		{name: "rest", max: -1},
+		{name: "messaging", max: -1},
+		{name: "document", max: -1},
+	},
+	"CapabilityStatement.document": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "mode", min: 1, max: 1, codes: []string{"producer", "consumer"}},
+		{name: "documentation", max: 1},
+		{name: "profile", min: 1, max: 1},
+	},
+	"CapabilityStatement.implementation": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "description", min: 1, max: 1},
+		{name: "url", max: 1},
+	},
+	"CapabilityStatement.messaging": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "endpoint", max: -1},
+		{name: "reliableCache", max: 1},
+		{name: "documentation", max: 1},
+		{name: "supportedMessage", max: -1},
+		{name: "event", max: -1},
+	},
+	"CapabilityStatement.messaging.endpoint": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "protocol", min: 1, max: 1},
+		{name: "address", min: 1, max: 1},
+	},
+	"CapabilityStatement.messaging.event": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "code", min: 1, max: 1},
+		{name: "category", max: 1, codes: []string{"Consequence", "Currency", "Notification"}},
+		{name: "mode", min: 1, max: 1, codes: []string{"sender", "receiver"}},
+		{name: "focus", min: 1, max: 1, codes: []string{"Account", "ActivityDefinition", "AdverseEvent", "AllergyIntolerance", "Appointment", "AppointmentResponse", "AuditEvent", "Basic", "Binary", "BiologicallyDerivedProduct", "BodyStructure", "Bundle", "CapabilityStatement", "CarePlan", "CareTeam", "ChargeItem", "Claim", "ClaimResponse", "ClinicalImpression", "CodeSystem", "Communication", "CommunicationRequest", "CompartmentDefinition", "Composition", "ConceptMap", "Condition", "Consent", "Contract", "Coverage", "DetectedIssue", "Device", "DeviceComponent", "DeviceMetric", "DeviceRequest", "DeviceUseStatement", "DiagnosticReport", "DocumentManifest", "DocumentReference", "DomainResource", "EligibilityRequest", "EligibilityResponse", "Encounter", "Endpoint", "EnrollmentRequest", "EnrollmentResponse", "EntryDefinition", "EpisodeOfCare", "EventDefinition", "ExampleScenario", "ExpansionProfile", "ExplanationOfBenefit", "FamilyMemberHistory", "Flag", "Goal", "GraphDefinition", "Group", "GuidanceResponse", "HealthcareService", "ImagingStudy", "Immunization", "ImmunizationEvaluation", "ImmunizationRecommendation", "ImplementationGuide", "ImplementationGuideInput", "ImplementationGuideOutput", "Invoice", "ItemInstance", "Library", "Linkage", "List", "Location", "Measure", "MeasureReport", "Media", "Medication", "MedicationAdministration", "MedicationDispense", "MedicationRequest", "MedicationStatement", "MedicinalProduct", "MedicinalProductAuthorization", "MedicinalProductClinicals", "MedicinalProductDeviceSpec", "MedicinalProductIngredient", "MedicinalProductPackaged", "MedicinalProductPharmaceutical", "MessageDefinition", "MessageHeader", "NamingSystem", "NutritionOrder", "Observation", "ObservationDefinition", "OccupationalData", "OperationDefinition", "OperationOutcome", "Organization", "OrganizationRole", "Parameters", "Patient", "PaymentNotice", "PaymentReconciliation", "Person", "PlanDefinition", "Practitioner", "PractitionerRole", "Procedure", "ProcessRequest", "ProcessResponse", "ProductPlan", "Provenance", "Questionnaire", "QuestionnaireResponse", "RelatedPerson", "RequestGroup", "ResearchStudy", "ResearchSubject", "Resource", "RiskAssessment", "Schedule", "SearchParameter", "Sequence", "ServiceDefinition", "ServiceRequest", "Slot", "Specimen", "SpecimenDefinition", "StructureDefinition", "StructureMap", "Subscription", "Substance", "SubstancePolymer", "SubstanceReferenceInformation", "SubstanceSpecification", "SupplyDelivery", "SupplyRequest", "Task", "TerminologyCapabilities", "TestReport", "TestScript", "UserSession", "ValueSet", "VerificationResult", "VisionPrescription"}},
+		{name: "request", min: 1, max: 1},
+		{name: "response", min: 1, max: 1},
+		{name: "documentation", max: 1},
+	},
+	"CapabilityStatement.messaging.supportedMessage": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "mode", min: 1, max: 1, codes: []string{"sender", "receiver"}},
+		{name: "definition", min: 1, max: 1},
+	},
+	"CapabilityStatement.rest": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "mode", min: 1, max: 1, codes: []string{"client", "server"}},
+		{name: "documentation", max: 1},
+		{name: "security", max: 1},
+		{name: "resource", max: -1},
+		{name: "interaction", max: -1},
+		{name: "searchParam", max: -1},
+		{name: "operation", max: -1},
+		{name: "compartment", max: -1},
+	},
+	"CapabilityStatement.rest.interaction": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "code", min: 1, max: 1, codes: []string{"transaction", "batch", "search-system", "history-system"}},
+		{name: "documentation", max: 1},
+	},
+	"CapabilityStatement.rest.operation": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "name", min: 1, max: 1},
+		{name: "definition", min: 1, max: 1},
+	},
+	"CapabilityStatement.rest.resource": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "type", min: 1, max: 1, codes: []string{"Account", "ActivityDefinition", "AdverseEvent", "AllergyIntolerance", "Appointment", "AppointmentResponse", "AuditEvent", "Basic", "Binary", "BiologicallyDerivedProduct", "BodyStructure", "Bundle", "CapabilityStatement", "CarePlan", "CareTeam", "ChargeItem", "Claim", "ClaimResponse", "ClinicalImpression", "CodeSystem", "Communication", "CommunicationRequest", "CompartmentDefinition", "Composition", "ConceptMap", "Condition", "Consent", "Contract", "Coverage", "DetectedIssue", "Device", "DeviceComponent", "DeviceMetric", "DeviceRequest", "DeviceUseStatement", "DiagnosticReport", "DocumentManifest", "DocumentReference", "DomainResource", "EligibilityRequest", "EligibilityResponse", "Encounter", "Endpoint", "EnrollmentRequest", "EnrollmentResponse", "EntryDefinition", "EpisodeOfCare", "EventDefinition", "ExampleScenario", "ExpansionProfile", "ExplanationOfBenefit", "FamilyMemberHistory", "Flag", "Goal", "GraphDefinition", "Group", "GuidanceResponse", "HealthcareService", "ImagingStudy", "Immunization", "ImmunizationEvaluation", "ImmunizationRecommendation", "ImplementationGuide", "ImplementationGuideInput", "ImplementationGuideOutput", "Invoice", "ItemInstance", "Library", "Linkage", "List", "Location", "Measure", "MeasureReport", "Media", "Medication", "MedicationAdministration", "MedicationDispense", "MedicationRequest", "MedicationStatement", "MedicinalProduct", "MedicinalProductAuthorization", "MedicinalProductClinicals", "MedicinalProductDeviceSpec", "MedicinalProductIngredient", "MedicinalProductPackaged", "MedicinalProductPharmaceutical", "MessageDefinition", "MessageHeader", "NamingSystem", "NutritionOrder", "Observation", "ObservationDefinition", "OccupationalData", "OperationDefinition", "OperationOutcome", "Organization", "OrganizationRole", "Parameters", "Patient", "PaymentNotice", "PaymentReconciliation", "Person", "PlanDefinition", "Practitioner", "PractitionerRole", "Procedure", "ProcessRequest", "ProcessResponse", "ProductPlan", "Provenance", "Questionnaire", "QuestionnaireResponse", "RelatedPerson", "RequestGroup", "ResearchStudy", "ResearchSubject", "Resource", "RiskAssessment", "Schedule", "SearchParameter", "Sequence", "ServiceDefinition", "ServiceRequest", "Slot", "Specimen", "SpecimenDefinition", "StructureDefinition", "StructureMap", "Subscription", "Substance", "SubstancePolymer", "SubstanceReferenceInformation", "SubstanceSpecification", "SupplyDelivery", "SupplyRequest", "Task", "TerminologyCapabilities", "TestReport", "TestScript", "UserSession", "ValueSet", "VerificationResult", "VisionPrescription"}},
+		{name: "profile", max: 1},
+		{name: "documentation", max: 1},
+		{name: "interaction", min: 1, max: -1},
+		{name: "versioning", max: 1, codes: []string{"no-version", "versioned", "versioned-update"}},
+		{name: "readHistory", max: 1},
+		{name: "updateCreate", max: 1},
+		{name: "conditionalCreate", max: 1},
+		{name: "conditionalRead", max: 1, codes: []string{"not-supported", "modified-since", "not-match", "full-support"}},
+		{name: "conditionalUpdate", max: 1},
+		{name: "conditionalDelete", max: 1, codes: []string{"not-supported", "single", "multiple"}},
+		{name: "referencePolicy", max: -1, codes: []string{"literal", "logical", "resolves", "enforced", "local"}},
+		{name: "searchInclude", max: -1},
+		{name: "searchRevInclude", max: -1},
+		{name: "searchParam", max: -1},
+	},
+	"CapabilityStatement.rest.resource.interaction": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "code", min: 1, max: 1, codes: []string{"read", "vread", "update", "patch", "delete", "history-instance", "history-type", "create", "search-type"}},
+		{name: "documentation", max: 1},
+	},
+	"CapabilityStatement.rest.resource.searchParam": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "name", min: 1, max: 1},
+		{name: "definition", max: 1},
+		{name: "type", min: 1, max: 1, codes: []string{"number", "date", "string", "token", "reference", "composite", "quantity", "uri"}},
+		{name: "documentation", max: 1},
+	},
+	"CapabilityStatement.rest.security": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "cors", max: 1},
+		{name: "service", max: -1},
+		{name: "description", max: 1},
+		{name: "certificate", max: -1},
+	},
+	"CapabilityStatement.rest.security.certificate": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "type", max: 1},
+		{name: "blob", max: 1},
+	},
+	"CapabilityStatement.software": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "name", min: 1, max: 1},
+		{name: "version", max: 1},
+		{name: "releaseDate", max: 1},
+	},
+	"CarePlan": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "definition", max: -1},
+		{name: "basedOn", max: -1},
+		{name: "replaces", max: -1},
+		{name: "partOf", max: -1},
+		{name: "status", min: 1, max: 1, codes: []string{"draft", "active", "suspended", "completed", "entered-in-error", "cancelled", "unknown"}},
+		{name: "intent", min: 1, max: 1, codes: []string{"proposal", "plan", "order", "option"}},
+		{name: "category", max: -1},
+		{name: "title", max: 1},
+		{name: "description", max: 1},
+		{name: "subject", min: 1, max: 1},
+		{name: "context", max: 1},
+		{name: "period", max: 1},
+		{name: "author", max: -1},
+		{name: "careTeam", max: -1},
+		{name: "addresses", max: -1},
+		{name: "supportingInfo", max: -1},
+		{name: "goal", max: -1},
+		{name: "activity", max: -1},
+		{name: "note", max: -1},
+	},
+	"CarePlan.activity": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "outcomeCodeableConcept", max: -1},
+		{name: "outcomeReference", max: -1},
+		{name: "progress", max: -1},
+		{name: "reference", max: 1},
+		{name: "detail", max: 1},
+	},
+	"CarePlan.activity.detail": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "category", max: 1},
+		{name: "definition", max: 1},
+		{name: "code", max: 1},
+		{name: "reasonCode", max: -1},
+		{name: "reasonReference", max: -1},
+		{name: "goal", max: -1},
+		{name: "status", min: 1, max: 1, codes: []string{"not-started", "scheduled", "in-progress", "on-hold", "completed", "cancelled", "unknown"}},
+		{name: "statusReason", max: 1},
+		{name: "prohibited", max: 1},
+		{name: "scheduled[x]", max: 1, types: []string{"Timing", "Period", "string"}},
+		{name: "location", max: 1},
+		{name: "performer", max: -1},
+		{name: "product[x]", max: 1, types: []string{"CodeableConcept", "Reference"}},
+		{name: "dailyAmount", max: 1},
+		{name: "quantity", max: 1},
+		{name: "description", max: 1},
+	},
+	"CareTeam": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "status", max: 1, codes: []string{"proposed", "active", "suspended", "inactive", "entered-in-error"}},
+		{name: "category", max: -1},
+		{name: "name", max: 1},
+		{name: "subject", max: 1},
+		{name: "context", max: 1},
+		{name: "period", max: 1},
+		{name: "participant", max: -1},
+		{name: "reasonCode", max: -1},
+		{name: "reasonReference", max: -1},
+		{name: "managingOrganization", max: -1},
+		{name: "note", max: -1},
+	},
+	"CareTeam.participant": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "role", max: 1},
+		{name: "member", max: 1},
+		{name: "onBehalfOf", max: 1},
+		{name: "period", max: 1},
+	},
+	"ChargeItem": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: 1},
+		{name: "definition", max: -1},
+		{name: "status", min: 1, max: 1, codes: []string{"planned", "billable", "not-billable", "aborted", "billed", "entered-in-error", "unknown"}},
+		{name: "partOf", max: -1},
+		{name: "code", min: 1, max: 1},
+		{name: "subject", min: 1, max: 1},
+		{name: "context", max: 1},
+		{name: "occurrence[x]", max: 1, types: []string{"dateTime", "Period", "Timing"}},
+		{name: "participant", max: -1},
+		{name: "performingOrganization", max: 1},
+		{name: "requestingOrganization", max: 1},
+		{name: "quantity", max: 1},
+		{name: "bodysite", max: -1},
+		{name: "factorOverride", max: 1},
+		{name: "priceOverride", max: 1},
+		{name: "overrideReason", max: 1},
+		{name: "enterer", max: 1},
+		{name: "enteredDate", max: 1},
+		{name: "reason", max: -1},
+		{name: "service", max: -1},
+		{name: "account", max: -1},
+		{name: "note", max: -1},
+		{name: "supportingInformation", max: -1},
+	},
+	"ChargeItem.participant": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "role", max: 1},
+		{name: "actor", min: 1, max: 1},
+	},
+	"Claim": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "status", max: 1, codes: []string{"active", "cancelled", "draft", "entered-in-error"}},
+		{name: "type", max: 1, codes: []string{"http://hl7.org/fhir/ex-claimtype|institutional", "http://hl7.org/fhir/ex-claimtype|oral", "http://hl7.org/fhir/ex-claimtype|pharmacy", "http://hl7.org/fhir/ex-claimtype|professional", "http://hl7.org/fhir/ex-claimtype|vision"}},
+		{name: "subType", max: -1},
+		{name: "use", max: 1, codes: []string{"complete", "proposed", "exploratory", "other"}},
+		{name: "patient", max: 1},
+		{name: "billablePeriod", max: 1},
+		{name: "created", max: 1},
+		{name: "enterer", max: 1},
+		{name: "insurer", max: 1},
+		{name: "provider", max: 1},
+		{name: "organization", max: 1},
+		{name: "priority", max: 1},
+		{name: "fundsReserve", max: 1},
+		{name: "related", max: -1},
+		{name: "prescription", max: 1},
+		{name: "originalPrescription", max: 1},
+		{name: "payee", max: 1},
+		{name: "referral", max: 1},
+		{name: "facility", max: 1},
+		{name: "careTeam", max: -1},
+		{name: "information", max: -1},
+		{name: "diagnosis", max: -1},
+		{name: "procedure", max: -1},
+		{name: "insurance", max: -1},
+		{name: "accident", max: 1},
+		{name: "employmentImpacted", max: 1},
+		{name: "hospitalization", max: 1},
+		{name: "item", max: -1},
+		{name: "total", max: 1},
+	},
+	"Claim.accident": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "date", min: 1, max: 1},
+		{name: "type", max: 1},
+		{name: "location[x]", max: 1, types: []string{"Address", "Reference"}},
+	},
+	"Claim.careTeam": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "sequence", min: 1, max: 1},
+		{name: "provider", min: 1, max: 1},
+		{name: "responsible", max: 1},
+		{name: "role", max: 1},
+		{name: "qualification", max: 1},
+	},
+	"Claim.diagnosis": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "sequence", min: 1, max: 1},
+		{name: "diagnosis[x]", min: 1, max: 1, types: []string{"CodeableConcept", "Reference"}},
+		{name: "type", max: -1},
+		{name: "packageCode", max: 1},
+	},
+	"Claim.information": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "sequence", min: 1, max: 1},
+		{name: "category", min: 1, max: 1},
+		{name: "code", max: 1},
+		{name: "timing[x]", max: 1, types: []string{"date", "Period"}},
+		{name: "value[x]", max: 1, types: []string{"string", "Quantity", "Attachment", "Reference"}},
+		{name: "reason", max: 1},
+	},
+	"Claim.insurance": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "sequence", min: 1, max: 1},
+		{name: "focal", min: 1, max: 1},
+		{name: "coverage", min: 1, max: 1},
+		{name: "businessArrangement", max: 1},
+		{name: "preAuthRef", max: -1},
+		{name: "claimResponse", max: 1},
+	},
+	"Claim.item": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "sequence", min: 1, max: 1},
+		{name: "careTeamLinkId", max: -1},
+		{name: "diagnosisLinkId", max: -1},
+		{name: "procedureLinkId", max: -1},
+		{name: "informationLinkId", max: -1},
+		{name: "revenue", max: 1},
+		{name: "category", max: 1},
+		{name: "service", max: 1},
+		{name: "modifier", max: -1},
+		{name: "programCode", max: -1},
+		{name: "serviced[x]", max: 1, types: []string{"date", "Period"}},
+		{name: "location[x]", max: 1, types: []string{"CodeableConcept", "Address", "Reference"}},
+		{name: "quantity", max: 1},
+		{name: "unitPrice", max: 1},
+		{name: "factor", max: 1},
+		{name: "net", max: 1},
+		{name: "udi", max: -1},
+		{name: "bodySite", max: 1},
+		{name: "subSite", max: -1},
+		{name: "encounter", max: -1},
+		{name: "detail", max: -1},
+	},
+	"Claim.item.detail": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "sequence", min: 1, max: 1},
+		{name: "revenue", max: 1},
+		{name: "category", max: 1},
+		{name: "service", max: 1},
+		{name: "modifier", max: -1},
+		{name: "programCode", max: -1},
+		{name: "quantity", max: 1},
+		{name: "unitPrice", max: 1},
+		{name: "factor", max: 1},
+		{name: "net", max: 1},
+		{name: "udi", max: -1},
+		{name: "subDetail", max: -1},
+	},
+	"Claim.item.detail.subDetail": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "sequence", min: 1, max: 1},
+		{name: "revenue", max: 1},
+		{name: "category", max: 1},
+		{name: "service", max: 1},
+		{name: "modifier", max: -1},
+		{name: "programCode", max: -1},
+		{name: "quantity", max: 1},
+		{name: "unitPrice", max: 1},
+		{name: "factor", max: 1},
+		{name: "net", max: 1},
+		{name: "udi", max: -1},
+	},
+	"Claim.payee": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "type", min: 1, max: 1},
+		{name: "resourceType", max: 1},
+		{name: "party", max: 1},
+	},
+	"Claim.procedure": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "sequence", min: 1, max: 1},
+		{name: "date", max: 1},
+		{name: "procedure[x]", min: 1, max: 1, types: []string{"CodeableConcept", "Reference"}},
+	},
+	"Claim.related": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "claim", max: 1},
+		{name: "relationship", max: 1},
+		{name: "reference", max: 1},
+	},
+	"ClaimResponse": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "status", max: 1, codes: []string{"active", "cancelled", "draft", "entered-in-error"}},
+		{name: "patient", max: 1},
+		{name: "created", max: 1},
+		{name: "insurer", max: 1},
+		{name: "requestProvider", max: 1},
+		{name: "requestOrganization", max: 1},
+		{name: "request", max: 1},
+		{name: "outcome", max: 1},
+		{name: "disposition", max: 1},
+		{name: "payeeType", max: 1},
+		{name: "item", max: -1},
+		{name: "addItem", max: -1},
+		{name: "error", max: -1},
+		{name: "totalCost", max: 1},
+		{name: "unallocDeductable", max: 1},
+		{name: "totalBenefit", max: 1},
+		{name: "payment", max: 1},
+		{name: "reserved", max: 1},
+		{name: "form", max: 1},
+		{name: "processNote", max: -1},
+		{name: "communicationRequest", max: -1},
+		{name: "insurance", max: -1},
+	},
+	"ClaimResponse.addItem": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "sequenceLinkId", max: -1},
+		{name: "revenue", max: 1},
+		{name: "category", max: 1},
+		{name: "service", max: 1},
+		{name: "modifier", max: -1},
+		{name: "fee", max: 1},
+		{name: "noteNumber", max: -1},
+		{name: "adjudication", max: -1},
+		{name: "detail", max: -1},
+	},
+	"ClaimResponse.addItem.detail": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "revenue", max: 1},
+		{name: "category", max: 1},
+		{name: "service", max: 1},
+		{name: "modifier", max: -1},
+		{name: "fee", max: 1},
+		{name: "noteNumber", max: -1},
+		{name: "adjudication", max: -1},
+	},
+	"ClaimResponse.error": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "sequenceLinkId", max: 1},
+		{name: "detailSequenceLinkId", max: 1},
+		{name: "subdetailSequenceLinkId", max: 1},
+		{name: "code", min: 1, max: 1},
+	},
+	"ClaimResponse.insurance": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "sequence", min: 1, max: 1},
+		{name: "focal", min: 1, max: 1},
+		{name: "coverage", min: 1, max: 1},
+		{name: "businessArrangement", max: 1},
+		{name: "preAuthRef", max: -1},
+		{name: "claimResponse", max: 1},
+	},
+	"ClaimResponse.item": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "sequenceLinkId", min: 1, max: 1},
+		{name: "noteNumber", max: -1},
+		{name: "adjudication", max: -1},
+		{name: "detail", max: -1},
+	},
+	"ClaimResponse.item.adjudication": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "category", min: 1, max: 1},
+		{name: "reason", max: 1},
+		{name: "amount", max: 1},
+		{name: "value", max: 1},
+	},
+	"ClaimResponse.item.detail": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "sequenceLinkId", min: 1, max: 1},
+		{name: "noteNumber", max: -1},
+		{name: "adjudication", max: -1},
+		{name: "subDetail", max: -1},
+	},
+	"ClaimResponse.item.detail.subDetail": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "sequenceLinkId", min: 1, max: 1},
+		{name: "noteNumber", max: -1},
+		{name: "adjudication", max: -1},
+	},
+	"ClaimResponse.payment": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "type", max: 1},
+		{name: "adjustment", max: 1},
+		{name: "adjustmentReason", max: 1},
+		{name: "date", max: 1},
+		{name: "amount", max: 1},
+		{name: "identifier", max: 1},
+	},
+	"ClaimResponse.processNote": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "number", max: 1},
+		{name: "type", max: 1, codes: []string{"http://hl7.org/fhir/note-type|display", "http://hl7.org/fhir/note-type|print", "http://hl7.org/fhir/note-type|printoper"}},
+		{name: "text", max: 1},
+		{name: "language", max: 1},
+	},
+	"ClinicalImpression": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "status", min: 1, max: 1, codes: []string{"draft", "completed", "entered-in-error"}},
+		{name: "code", max: 1},
+		{name: "description", max: 1},
+		{name: "subject", min: 1, max: 1},
+		{name: "context", max: 1},
+		{name: "effective[x]", max: 1, types: []string{"dateTime", "Period"}},
+		{name: "date", max: 1},
+		{name: "assessor", max: 1},
+		{name: "previous", max: 1},
+		{name: "problem", max: -1},
+		{name: "investigation", max: -1},
+		{name: "protocol", max: -1},
+		{name: "summary", max: 1},
+		{name: "finding", max: -1},
+		{name: "prognosisCodeableConcept", max: -1},
+		{name: "prognosisReference", max: -1},
+		{name: "action", max: -1},
+		{name: "note", max: -1},
+	},
+	"ClinicalImpression.finding": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "item[x]", min: 1, max: 1, types: []string{"CodeableConcept", "Reference"}},
+		{name: "basis", max: 1},
+	},
+	"ClinicalImpression.investigation": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "code", min: 1, max: 1},
+		{name: "item", max: -1},
+	},
+	"CodeSystem": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "url", max: 1},
+		{name: "identifier", max: 1},
+		{name: "version", max: 1},
+		{name: "name", max: 1},
+		{name: "title", max: 1},
+		{name: "status", min: 1, max: 1, codes: []string{"draft", "active", "retired", "unknown"}},
+		{name: "experimental", max: 1},
+		{name: "date", max: 1},
+		{name: "publisher", max: 1},
+		{name: "contact", max: -1},
+		{name: "description", max: 1},
+		{name: "useContext", max: -1},
+		{name: "jurisdiction", max: -1},
+		{name: "purpose", max: 1},
+		{name: "copyright", max: 1},
+		{name: "caseSensitive", max: 1},
+		{name: "valueSet", max: 1},
+		{name: "hierarchyMeaning", max: 1, codes: []string{"grouped-by", "is-a", "part-of", "classified-with"}},
+		{name: "compositional", max: 1},
+		{name: "versionNeeded", max: 1},
+		{name: "content", min: 1, max: 1, codes: []string{"not-present", "example", "fragment", "complete", "supplement"}},
+		{name: "count", max: 1},
+		{name: "filter", max: -1},
+		{name: "property", max: -1},
+		{name: "concept", max: -1},
+	},
+	"CodeSystem.concept": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "code", min: 1, max: 1},
+		{name: "display", max: 1},
+		{name: "definition", max: 1},
+		{name: "designation", max: -1},
+		{name: "property", max: -1},
+		{name: "concept", max: -1},
+	},
+	"CodeSystem.concept.designation": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "language", max: 1},
+		{name: "use", max: 1},
+		{name: "value", min: 1, max: 1},
+	},
+	"CodeSystem.concept.property": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "code", min: 1, max: 1},
+		{name: "value[x]", min: 1, max: 1, types: []string{"code", "Coding", "string", "integer", "boolean", "dateTime"}},
+	},
+	"CodeSystem.filter": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "code", min: 1, max: 1},
+		{name: "description", max: 1},
+		{name: "operator", min: 1, max: -1, codes: []string{"=", "is-a", "descendent-of", "is-not-a", "regex", "in", "not-in", "generalizes", "exists"}},
+		{name: "value", min: 1, max: 1},
+	},
+	"CodeSystem.property": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "code", min: 1, max: 1},
+		{name: "uri", max: 1},
+		{name: "description", max: 1},
+		{name: "type", min: 1, max: 1, codes: []string{"code", "Coding", "string", "integer", "boolean", "dateTime"}},
+	},
+	"CodeableConcept": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "coding", max: -1},
+		{name: "text", max: 1},
+	},
+	"Coding": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "system", max: 1},
+		{name: "version", max: 1},
+		{name: "code", max: 1},
+		{name: "display", max: 1},
+		{name: "userSelected", max: 1},
+	},
+	"Communication": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "definition", max: -1},
+		{name: "basedOn", max: -1},
+		{name: "partOf", max: -1},
+		{name: "status", min: 1, max: 1, codes: []string{"preparation", "in-progress", "suspended", "aborted", "completed", "entered-in-error", "not-done", "unknown"}},
+		{name: "notDone", max: 1},
+		{name: "notDoneReason", max: 1},
+		{name: "category", max: -1},
+		{name: "medium", max: -1},
+		{name: "subject", max: 1},
+		{name: "recipient", max: -1},
+		{name: "topic", max: -1},
+		{name: "context", max: 1},
+		{name: "sent", max: 1},
+		{name: "received", max: 1},
+		{name: "sender", max: 1},
+		{name: "reasonCode", max: -1},
+		{name: "reasonReference", max: -1},
+		{name: "payload", max: -1},
+		{name: "note", max: -1},
+	},
+	"Communication.payload": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "content[x]", min: 1, max: 1, types: []string{"string", "Attachment", "Reference"}},
+	},
+	"CommunicationRequest": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "basedOn", max: -1},
+		{name: "replaces", max: -1},
+		{name: "groupIdentifier", max: 1},
+		{name: "status", min: 1, max: 1, codes: []string{"draft", "active", "suspended", "cancelled", "completed", "entered-in-error", "unknown"}},
+		{name: "category", max: -1},
+		{name: "priority", max: 1, codes: []string{"routine", "urgent", "asap", "stat"}},
+		{name: "medium", max: -1},
+		{name: "subject", max: 1},
+		{name: "recipient", max: -1},
+		{name: "topic", max: -1},
+		{name: "context", max: 1},
+		{name: "payload", max: -1},
+		{name: "occurrence[x]", max: 1, types: []string{"dateTime", "Period"}},
+		{name: "authoredOn", max: 1},
+		{name: "sender", max: 1},
+		{name: "requester", max: 1},
+		{name: "reasonCode", max: -1},
+		{name: "reasonReference", max: -1},
+		{name: "note", max: -1},
+	},
+	"CommunicationRequest.payload": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "content[x]", min: 1, max: 1, types: []string{"string", "Attachment", "Reference"}},
+	},
+	"CommunicationRequest.requester": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "agent", min: 1, max: 1},
+		{name: "onBehalfOf", max: 1},
+	},
+	"CompartmentDefinition": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "url", min: 1, max: 1},
+		{name: "name", min: 1, max: 1},
+		{name: "title", max: 1},
+		{name: "status", min: 1, max: 1, codes: []string{"draft", "active", "retired", "unknown"}},
+		{name: "experimental", max: 1},
+		{name: "date", max: 1},
+		{name: "publisher", max: 1},
+		{name: "contact", max: -1},
+		{name: "description", max: 1},
+		{name: "purpose", max: 1},
+		{name: "useContext", max: -1},
+		{name: "jurisdiction", max: -1},
+		{name: "code", min: 1, max: 1, codes: []string{"Patient", "Encounter", "RelatedPerson", "Practitioner", "Device"}},
+		{name: "search", min: 1, max: 1},
+		{name: "resource", max: -1},
+	},
+	"CompartmentDefinition.resource": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "code", min: 1, max: 1, codes: []string{"Account", "ActivityDefinition", "AdverseEvent", "AllergyIntolerance", "Appointment", "AppointmentResponse", "AuditEvent", "Basic", "Binary", "BiologicallyDerivedProduct", "BodyStructure", "Bundle", "CapabilityStatement", "CarePlan", "CareTeam", "ChargeItem", "Claim", "ClaimResponse", "ClinicalImpression", "CodeSystem", "Communication", "CommunicationRequest", "CompartmentDefinition", "Composition", "ConceptMap", "Condition", "Consent", "Contract", "Coverage", "DetectedIssue", "Device", "DeviceComponent", "DeviceMetric", "DeviceRequest", "DeviceUseStatement", "DiagnosticReport", "DocumentManifest", "DocumentReference", "DomainResource", "EligibilityRequest", "EligibilityResponse", "Encounter", "Endpoint", "EnrollmentRequest", "EnrollmentResponse", "EntryDefinition", "EpisodeOfCare", "EventDefinition", "ExampleScenario", "ExpansionProfile", "ExplanationOfBenefit", "FamilyMemberHistory", "Flag", "Goal", "GraphDefinition", "Group", "GuidanceResponse", "HealthcareService", "ImagingStudy", "Immunization", "ImmunizationEvaluation", "ImmunizationRecommendation", "ImplementationGuide", "ImplementationGuideInput", "ImplementationGuideOutput", "Invoice", "ItemInstance", "Library", "Linkage", "List", "Location", "Measure", "MeasureReport", "Media", "Medication", "MedicationAdministration", "MedicationDispense", "MedicationRequest", "MedicationStatement", "MedicinalProduct", "MedicinalProductAuthorization", "MedicinalProductClinicals", "MedicinalProductDeviceSpec", "MedicinalProductIngredient", "MedicinalProductPackaged", "MedicinalProductPharmaceutical", "MessageDefinition", "MessageHeader", "NamingSystem", "NutritionOrder", "Observation", "ObservationDefinition", "OccupationalData", "OperationDefinition", "OperationOutcome", "Organization", "OrganizationRole", "Parameters", "Patient", "PaymentNotice", "PaymentReconciliation", "Person", "PlanDefinition", "Practitioner", "PractitionerRole", "Procedure", "ProcessRequest", "ProcessResponse", "ProductPlan", "Provenance", "Questionnaire", "QuestionnaireResponse", "RelatedPerson", "RequestGroup", "ResearchStudy", "ResearchSubject", "Resource", "RiskAssessment", "Schedule", "SearchParameter", "Sequence", "ServiceDefinition", "ServiceRequest", "Slot", "Specimen", "SpecimenDefinition", "StructureDefinition", "StructureMap", "Subscription", "Substance", "SubstancePolymer", "SubstanceReferenceInformation", "SubstanceSpecification", "SupplyDelivery", "SupplyRequest", "Task", "TerminologyCapabilities", "TestReport", "TestScript", "UserSession", "ValueSet", "VerificationResult", "VisionPrescription"}},
+		{name: "param", max: -1},
+		{name: "documentation", max: 1},
+	},
+	"Composition": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: 1},
+		{name: "status", min: 1, max: 1, codes: []string{"preliminary", "final", "amended", "entered-in-error"}},
+		{name: "type", min: 1, max: 1},
+		{name: "class", max: 1},
+		{name: "subject", min: 1, max: 1},
+		{name: "encounter", max: 1},
+		{name: "date", min: 1, max: 1},
+		{name: "author", min: 1, max: -1},
+		{name: "title", min: 1, max: 1},
+		{name: "confidentiality", max: 1},
+		{name: "attester", max: -1},
+		{name: "custodian", max: 1},
+		{name: "relatesTo", max: -1},
+		{name: "event", max: -1},
+		{name: "section", max: -1},
+	},
+	"Composition.attester": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "mode", min: 1, max: -1, codes: []string{"personal", "professional", "legal", "official"}},
+		{name: "time", max: 1},
+		{name: "party", max: 1},
+	},
+	"Composition.event": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "code", max: -1},
+		{name: "period", max: 1},
+		{name: "detail", max: -1},
+	},
+	"Composition.relatesTo": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "code", min: 1, max: 1, codes: []string{"replaces", "transforms", "signs", "appends"}},
+		{name: "target[x]", min: 1, max: 1, types: []string{"Identifier", "Reference"}},
+	},
+	"Composition.section": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "title", max: 1},
+		{name: "code", max: 1},
+		{name: "text", max: 1},
+		{name: "mode", max: 1, codes: []string{"working", "snapshot", "changes"}},
+		{name: "orderedBy", max: 1},
+		{name: "entry", max: -1},
+		{name: "emptyReason", max: 1},
+		{name: "section", max: -1},
+	},
+	"ConceptMap": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "url", max: 1},
+		{name: "identifier", max: 1},
+		{name: "version", max: 1},
+		{name: "name", max: 1},
+		{name: "title", max: 1},
+		{name: "status", min: 1, max: 1, codes: []string{"draft", "active", "retired", "unknown"}},
+		{name: "experimental", max: 1},
+		{name: "date", max: 1},
+		{name: "publisher", max: 1},
+		{name: "contact", max: -1},
+		{name: "description", max: 1},
+		{name: "useContext", max: -1},
+		{name: "jurisdiction", max: -1},
+		{name: "purpose", max: 1},
+		{name: "copyright", max: 1},
+		{name: "source[x]", max: 1, types: []string{"uri", "Reference"}},
+		{name: "target[x]", max: 1, types: []string{"uri", "Reference"}},
+		{name: "group", max: -1},
+	},
+	"ConceptMap.group": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "source", max: 1},
+		{name: "sourceVersion", max: 1},
+		{name: "target", max: 1},
+		{name: "targetVersion", max: 1},
+		{name: "element", min: 1, max: -1},
+		{name: "unmapped", max: 1},
+	},
+	"ConceptMap.group.element": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "code", max: 1},
+		{name: "display", max: 1},
+		{name: "target", max: -1},
+	},
+	"ConceptMap.group.element.target": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "code", max: 1},
+		{name: "display", max: 1},
+		{name: "equivalence", max: 1, codes: []string{"relatedto", "equivalent", "equal", "wider", "subsumes", "narrower", "specializes", "inexact", "unmatched", "disjoint"}},
+		{name: "comment", max: 1},
+		{name: "dependsOn", max: -1},
+		{name: "product", max: -1},
+	},
+	"ConceptMap.group.element.target.dependsOn": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "property", min: 1, max: 1},
+		{name: "system", max: 1},
+		{name: "code", min: 1, max: 1},
+		{name: "display", max: 1},
+	},
+	"ConceptMap.group.unmapped": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "mode", min: 1, max: 1, codes: []string{"provided", "fixed", "other-map"}},
+		{name: "code", max: 1},
+		{name: "display", max: 1},
+		{name: "url", max: 1},
+	},
+	"Condition": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "clinicalStatus", max: 1, codes: []string{"active", "recurrence", "inactive", "remission", "resolved"}},
+		{name: "verificationStatus", max: 1, codes: []string{"provisional", "differential", "confirmed", "refuted", "entered-in-error", "unknown", "unconfirmed"}},
+		{name: "category", max: -1},
+		{name: "severity", max: 1},
+		{name: "code", max: 1},
+		{name: "bodySite", max: -1},
+		{name: "subject", min: 1, max: 1},
+		{name: "context", max: 1},
+		{name: "onset[x]", max: 1, types: []string{"dateTime", "Age", "Period", "Range", "string"}},
+		{name: "abatement[x]", max: 1, types: []string{"dateTime", "Age", "boolean", "Period", "Range", "string"}},
+		{name: "assertedDate", max: 1},
+		{name: "asserter", max: 1},
+		{name: "stage", max: 1},
+		{name: "evidence", max: -1},
+		{name: "note", max: -1},
+	},
+	"Condition.evidence": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "code", max: -1},
+		{name: "detail", max: -1},
+	},
+	"Condition.stage": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "summary", max: 1},
+		{name: "assessment", max: -1},
+	},
+	"Consent": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: 1},
+		{name: "status", min: 1, max: 1, codes: []string{"draft", "proposed", "active", "rejected", "inactive", "entered-in-error"}},
+		{name: "category", max: -1},
+		{name: "patient", min: 1, max: 1},
+		{name: "period", max: 1},
+		{name: "dateTime", max: 1},
+		{name: "consentingParty", max: -1},
+		{name: "actor", max: -1},
+		{name: "action", max: -1},
+		{name: "organization", max: -1},
+		{name: "source[x]", max: 1, types: []string{"Attachment", "Identifier", "Reference"}},
+		{name: "policy", max: -1},
+		{name: "policyRule", max: 1},
+		{name: "securityLabel", max: -1},
+		{name: "purpose", max: -1},
+		{name: "dataPeriod", max: 1},
+		{name: "data", max: -1},
+		{name: "except", max: -1},
+	},
+	"Consent.actor": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "role", min: 1, max: 1},
+		{name: "reference", min: 1, max: 1},
+	},
+	"Consent.data": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "meaning", min: 1, max: 1, codes: []string{"instance", "related", "dependents", "authoredby"}},
+		{name: "reference", min: 1, max: 1},
+	},
+	"Consent.except": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "type", min: 1, max: 1, codes: []string{"deny", "permit"}},
+		{name: "period", max: 1},
+		{name: "actor", max: -1},
+		{name: "action", max: -1},
+		{name: "securityLabel", max: -1},
+		{name: "purpose", max: -1},
+		{name: "class", max: -1},
+		{name: "code", max: -1},
+		{name: "dataPeriod", max: 1},
+		{name: "data", max: -1},
+	},
+	"Consent.except.actor": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "role", min: 1, max: 1},
+		{name: "reference", min: 1, max: 1},
+	},
+	"Consent.except.data": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "meaning", min: 1, max: 1, codes: []string{"instance", "related", "dependents", "authoredby"}},
+		{name: "reference", min: 1, max: 1},
+	},
+	"Consent.policy": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "authority", max: 1},
+		{name: "uri", max: 1},
+	},
+	"ContactDetail": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "name", max: 1},
+		{name: "telecom", max: -1},
+	},
+	"ContactPoint": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "system", max: 1, codes: []string{"phone", "fax", "email", "pager", "url", "sms", "other"}},
+		{name: "value", max: 1},
+		{name: "use", max: 1, codes: []string{"home", "work", "temp", "old", "mobile"}},
+		{name: "rank", max: 1},
+		{name: "period", max: 1},
+	},
+	"Contract": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: 1},
+		{name: "status", max: 1, codes: []string{"amended", "appended", "cancelled", "disputed", "entered-in-error", "executable", "executed", "negotiable", "offered", "policy", "rejected", "renewed", "revoked", "resolved", "terminated"}},
+		{name: "issued", max: 1},
+		{name: "applies", max: 1},
+		{name: "subject", max: -1},
+		{name: "topic", max: -1},
+		{name: "authority", max: -1},
+		{name: "domain", max: -1},
+		{name: "type", max: 1},
+		{name: "subType", max: -1},
+		{name: "action", max: -1},
+		{name: "actionReason", max: -1},
+		{name: "decisionType", max: 1},
+		{name: "contentDerivative", max: 1},
+		{name: "securityLabel", max: -1},
+		{name: "agent", max: -1},
+		{name: "signer", max: -1},
+		{name: "valuedItem", max: -1},
+		{name: "term", max: -1},
+		{name: "binding[x]", max: 1, types: []string{"Attachment", "Reference"}},
+		{name: "friendly", max: -1},
+		{name: "legal", max: -1},
+		{name: "rule", max: -1},
+	},
+	"Contract.agent": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "actor", min: 1, max: 1},
+		{name: "role", max: -1},
+	},
+	"Contract.friendly": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "content[x]", min: 1, max: 1, types: []string{"Attachment", "Reference"}},
+	},
+	"Contract.legal": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "content[x]", min: 1, max: 1, types: []string{"Attachment", "Reference"}},
+	},
+	"Contract.rule": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "content[x]", min: 1, max: 1, types: []string{"Attachment", "Reference"}},
+	},
+	"Contract.signer": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "type", min: 1, max: 1},
+		{name: "party", min: 1, max: 1},
+		{name: "signature", min: 1, max: -1},
+	},
+	"Contract.term": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: 1},
+		{name: "issued", max: 1},
+		{name: "applies", max: 1},
+		{name: "type", max: 1},
+		{name: "subType", max: 1},
+		{name: "topic", max: -1},
+		{name: "action", max: -1},
+		{name: "actionReason", max: -1},
+		{name: "securityLabel", max: -1},
+		{name: "agent", max: -1},
+		{name: "text", max: 1},
+		{name: "valuedItem", max: -1},
+		{name: "group", max: -1},
+	},
+	"Contract.term.agent": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "actor", min: 1, max: 1},
+		{name: "role", max: -1},
+	},
+	"Contract.term.valuedItem": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "entity[x]", max: 1, types: []string{"CodeableConcept", "Reference"}},
+		{name: "identifier", max: 1},
+		{name: "effectiveTime", max: 1},
+		{name: "quantity", max: 1},
+		{name: "unitPrice", max: 1},
+		{name: "factor", max: 1},
+		{name: "points", max: 1},
+		{name: "net", max: 1},
+	},
+	"Contract.valuedItem": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "entity[x]", max: 1, types: []string{"CodeableConcept", "Reference"}},
+		{name: "identifier", max: 1},
+		{name: "effectiveTime", max: 1},
+		{name: "quantity", max: 1},
+		{name: "unitPrice", max: 1},
+		{name: "factor", max: 1},
+		{name: "points", max: 1},
+		{name: "net", max: 1},
+	},
+	"Contributor": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "type", min: 1, max: 1, codes: []string{"author", "editor", "reviewer", "endorser"}},
+		{name: "name", min: 1, max: 1},
+		{name: "contact", max: -1},
+	},
+	"Count": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "value", max: 1},
+		{name: "comparator", max: 1, codes: []string{"<", "<=", ">=", ">"}},
+		{name: "unit", max: 1},
+		{name: "system", max: 1},
+		{name: "code", max: 1},
+	},
+	"Coverage": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "status", max: 1, codes: []string{"active", "cancelled", "draft", "entered-in-error"}},
+		{name: "type", max: 1},
+		{name: "policyHolder", max: 1},
+		{name: "subscriber", max: 1},
+		{name: "subscriberId", max: 1},
+		{name: "beneficiary", max: 1},
+		{name: "relationship", max: 1},
+		{name: "period", max: 1},
+		{name: "payor", max: -1},
+		{name: "grouping", max: 1},
+		{name: "dependent", max: 1},
+		{name: "sequence", max: 1},
+		{name: "order", max: 1},
+		{name: "network", max: 1},
+		{name: "contract", max: -1},
+	},
+	"Coverage.grouping": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "group", max: 1},
+		{name: "groupDisplay", max: 1},
+		{name: "subGroup", max: 1},
+		{name: "subGroupDisplay", max: 1},
+		{name: "plan", max: 1},
+		{name: "planDisplay", max: 1},
+		{name: "subPlan", max: 1},
+		{name: "subPlanDisplay", max: 1},
+		{name: "class", max: 1},
+		{name: "classDisplay", max: 1},
+		{name: "subClass", max: 1},
+		{name: "subClassDisplay", max: 1},
+	},
+	"DataElement": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "url", max: 1},
+		{name: "identifier", max: -1},
+		{name: "version", max: 1},
+		{name: "status", min: 1, max: 1, codes: []string{"draft", "active", "retired", "unknown"}},
+		{name: "experimental", max: 1},
+		{name: "date", max: 1},
+		{name: "publisher", max: 1},
+		{name: "name", max: 1},
+		{name: "title", max: 1},
+		{name: "contact", max: -1},
+		{name: "useContext", max: -1},
+		{name: "jurisdiction", max: -1},
+		{name: "copyright", max: 1},
+		{name: "stringency", max: 1, codes: []string{"comparable", "fully-specified", "equivalent", "convertable", "scaleable", "flexible"}},
+		{name: "mapping", max: -1},
+		{name: "element", min: 1, max: -1},
+	},
+	"DataElement.mapping": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identity", min: 1, max: 1},
+		{name: "uri", max: 1},
+		{name: "name", max: 1},
+		{name: "comment", max: 1},
+	},
+	"DataRequirement": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "type", min: 1, max: 1, codes: []string{"Address", "Age", "Annotation", "Attachment", "BackboneElement", "CodeableConcept", "Coding", "ContactDetail", "ContactPoint", "Contributor", "Count", "DataRequirement", "Distance", "Dosage", "Duration", "Element", "ElementDefinition", "Extension", "HumanName", "Identifier", "MarketingStatus", "Meta", "Money", "Narrative", "ParameterDefinition", "Period", "ProdCharacteristic", "ProductShelfLife", "Quantity", "Range", "Ratio", "Reference", "RelatedArtifact", "SampledData", "Signature", "SimpleQuantity", "SubstanceAmount", "SubstanceMoiety", "Timing", "TriggerDefinition", "UsageContext", "base64Binary", "boolean", "code", "date", "dateTime", "decimal", "id", "instant", "integer", "markdown", "oid", "positiveInt", "string", "time", "unsignedInt", "uri", "uuid", "xhtml", "Account", "ActivityDefinition", "AdverseEvent", "AllergyIntolerance", "Appointment", "AppointmentResponse", "AuditEvent", "Basic", "Binary", "BiologicallyDerivedProduct", "BodyStructure", "Bundle", "CapabilityStatement", "CarePlan", "CareTeam", "ChargeItem", "Claim", "ClaimResponse", "ClinicalImpression", "CodeSystem", "Communication", "CommunicationRequest", "CompartmentDefinition", "Composition", "ConceptMap", "Condition", "Consent", "Contract", "Coverage", "DetectedIssue", "Device", "DeviceComponent", "DeviceMetric", "DeviceRequest", "DeviceUseStatement", "DiagnosticReport", "DocumentManifest", "DocumentReference", "DomainResource", "EligibilityRequest", "EligibilityResponse", "Encounter", "Endpoint", "EnrollmentRequest", "EnrollmentResponse", "EntryDefinition", "EpisodeOfCare", "EventDefinition", "ExampleScenario", "ExpansionProfile", "ExplanationOfBenefit", "FamilyMemberHistory", "Flag", "Goal", "GraphDefinition", "Group", "GuidanceResponse", "HealthcareService", "ImagingStudy", "Immunization", "ImmunizationEvaluation", "ImmunizationRecommendation", "ImplementationGuide", "ImplementationGuideInput", "ImplementationGuideOutput", "Invoice", "ItemInstance", "Library", "Linkage", "List", "Location", "Measure", "MeasureReport", "Media", "Medication", "MedicationAdministration", "MedicationDispense", "MedicationRequest", "MedicationStatement", "MedicinalProduct", "MedicinalProductAuthorization", "MedicinalProductClinicals", "MedicinalProductDeviceSpec", "MedicinalProductIngredient", "MedicinalProductPackaged", "MedicinalProductPharmaceutical", "MessageDefinition", "MessageHeader", "NamingSystem", "NutritionOrder", "Observation", "ObservationDefinition", "OccupationalData", "OperationDefinition", "OperationOutcome", "Organization", "OrganizationRole", "Parameters", "Patient", "PaymentNotice", "PaymentReconciliation", "Person", "PlanDefinition", "Practitioner", "PractitionerRole", "Procedure", "ProcessRequest", "ProcessResponse", "ProductPlan", "Provenance", "Questionnaire", "QuestionnaireResponse", "RelatedPerson", "RequestGroup", "ResearchStudy", "ResearchSubject", "Resource", "RiskAssessment", "Schedule", "SearchParameter", "Sequence", "ServiceDefinition", "ServiceRequest", "Slot", "Specimen", "SpecimenDefinition", "StructureDefinition", "StructureMap", "Subscription", "Substance", "SubstancePolymer", "SubstanceReferenceInformation", "SubstanceSpecification", "SupplyDelivery", "SupplyRequest", "Task", "TerminologyCapabilities", "TestReport", "TestScript", "UserSession", "ValueSet", "VerificationResult", "VisionPrescription", "Type", "Any"}},
+		{name: "profile", max: -1},
+		{name: "mustSupport", max: -1},
+		{name: "codeFilter", max: -1},
+		{name: "dateFilter", max: -1},
+	},
+	"DataRequirement.codeFilter": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "path", min: 1, max: 1},
+		{name: "valueSet[x]", max: 1, types: []string{"string", "Reference"}},
+		{name: "valueCode", max: -1},
+		{name: "valueCoding", max: -1},
+		{name: "valueCodeableConcept", max: -1},
+	},
+	"DataRequirement.dateFilter": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "path", min: 1, max: 1},
+		{name: "value[x]", max: 1, types: []string{"dateTime", "Period", "Duration"}},
+	},
+	"DetectedIssue": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: 1},
+		{name: "status", min: 1, max: 1},
+		{name: "category", max: 1},
+		{name: "severity", max: 1, codes: []string{"high", "moderate", "low"}},
+		{name: "patient", max: 1},
+		{name: "date", max: 1},
+		{name: "author", max: 1},
+		{name: "implicated", max: -1},
+		{name: "detail", max: 1},
+		{name: "reference", max: 1},
+		{name: "mitigation", max: -1},
+	},
+	"DetectedIssue.mitigation": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "action", min: 1, max: 1},
+		{name: "date", max: 1},
+		{name: "author", max: 1},
+	},
+	"Device": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "udi", max: 1},
+		{name: "status", max: 1, codes: []string{"active", "inactive", "entered-in-error", "unknown"}},
+		{name: "type", max: 1},
+		{name: "lotNumber", max: 1},
+		{name: "manufacturer", max: 1},
+		{name: "manufactureDate", max: 1},
+		{name: "expirationDate", max: 1},
+		{name: "model", max: 1},
+		{name: "version", max: 1},
+		{name: "patient", max: 1},
+		{name: "owner", max: 1},
+		{name: "contact", max: -1},
+		{name: "location", max: 1},
+		{name: "url", max: 1},
+		{name: "note", max: -1},
+		{name: "safety", max: -1},
+	},
+	"Device.udi": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "deviceIdentifier", max: 1},
+		{name: "name", max: 1},
+		{name: "jurisdiction", max: 1},
+		{name: "carrierHRF", max: 1},
+		{name: "carrierAIDC", max: 1},
+		{name: "issuer", max: 1},
+		{name: "entryType", max: 1},
+	},
+	"DeviceComponent": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", min: 1, max: 1},
+		{name: "type", min: 1, max: 1},
+		{name: "lastSystemChange", max: 1},
+		{name: "source", max: 1},
+		{name: "parent", max: 1},
+		{name: "operationalStatus", max: -1},
+		{name: "parameterGroup", max: 1},
+		{name: "measurementPrinciple", max: 1},
+		{name: "productionSpecification", max: -1},
+		{name: "languageCode", max: 1},
+	},
+	"DeviceComponent.productionSpecification": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "specType", max: 1},
+		{name: "componentId", max: 1},
+		{name: "productionSpec", max: 1},
+	},
+	"DeviceMetric": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", min: 1, max: 1},
+		{name: "type", min: 1, max: 1},
+		{name: "unit", max: 1},
+		{name: "source", max: 1},
+		{name: "parent", max: 1},
+		{name: "operationalStatus", max: 1, codes: []string{"on", "off", "standby", "entered-in-error"}},
+		{name: "color", max: 1, codes: []string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}},
+		{name: "category", min: 1, max: 1, codes: []string{"measurement", "setting", "calculation", "unspecified"}},
+		{name: "measurementPeriod", max: 1},
+		{name: "calibration", max: -1},
+	},
+	"DeviceMetric.calibration": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "type", max: 1, codes: []string{"unspecified", "offset", "gain", "two-point"}},
+		{name: "state", max: 1, codes: []string{"not-calibrated", "calibration-required", "calibrated", "unspecified"}},
+		{name: "time", max: 1},
+	},
+	"DeviceRequest": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "definition", max: -1},
+		{name: "basedOn", max: -1},
+		{name: "priorRequest", max: -1},
+		{name: "groupIdentifier", max: 1},
+		{name: "status", max: 1, codes: []string{"draft", "active", "suspended", "completed", "entered-in-error", "cancelled", "unknown"}},
+		{name: "intent", min: 1, max: 1},
+		{name: "priority", max: 1, codes: []string{"routine", "urgent", "asap", "stat"}},
+		{name: "code[x]", min: 1, max: 1, types: []string{"Reference", "CodeableConcept"}},
+		{name: "subject", min: 1, max: 1},
+		{name: "context", max: 1},
+		{name: "occurrence[x]", max: 1, types: []string{"dateTime", "Period", "Timing"}},
+		{name: "authoredOn", max: 1},
+		{name: "requester", max: 1},
+		{name: "performerType", max: 1},
+		{name: "performer", max: 1},
+		{name: "reasonCode", max: -1},
+		{name: "reasonReference", max: -1},
+		{name: "supportingInfo", max: -1},
+		{name: "note", max: -1},
+		{name: "relevantHistory", max: -1},
+	},
+	"DeviceRequest.requester": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "agent", min: 1, max: 1},
+		{name: "onBehalfOf", max: 1},
+	},
+	"DeviceUseStatement": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "status", min: 1, max: 1},
+		{name: "subject", min: 1, max: 1},
+		{name: "whenUsed", max: 1},
+		{name: "timing[x]", max: 1, types: []string{"Timing", "Period", "dateTime"}},
+		{name: "recordedOn", max: 1},
+		{name: "source", max: 1},
+		{name: "device", min: 1, max: 1},
+		{name: "indication", max: -1},
+		{name: "bodySite", max: 1},
+		{name: "note", max: -1},
+	},
+	"DiagnosticReport": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "basedOn", max: -1},
+		{name: "status", min: 1, max: 1},
+		{name: "category", max: 1},
+		{name: "code", min: 1, max: 1},
+		{name: "subject", max: 1},
+		{name: "context", max: 1},
+		{name: "effective[x]", max: 1, types: []string{"dateTime", "Period"}},
+		{name: "issued", max: 1},
+		{name: "performer", max: -1},
+		{name: "specimen", max: -1},
+		{name: "result", max: -1},
+		{name: "imagingStudy", max: -1},
+		{name: "image", max: -1},
+		{name: "conclusion", max: 1},
+		{name: "codedDiagnosis", max: -1},
+		{name: "presentedForm", max: -1},
+	},
+	"DiagnosticReport.image": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "comment", max: 1},
+		{name: "link", min: 1, max: 1},
+	},
+	"DiagnosticReport.performer": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "role", max: 1},
+		{name: "actor", min: 1, max: 1},
+	},
+	"Distance": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "value", max: 1},
+		{name: "comparator", max: 1, codes: []string{"<", "<=", ">=", ">"}},
+		{name: "unit", max: 1},
+		{name: "system", max: 1},
+		{name: "code", max: 1},
+	},
+	"DocumentManifest": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "masterIdentifier", max: 1},
+		{name: "identifier", max: -1},
+		{name: "status", min: 1, max: 1, codes: []string{"current", "superseded", "entered-in-error"}},
+		{name: "type", max: 1},
+		{name: "subject", max: 1},
+		{name: "created", max: 1},
+		{name: "author", max: -1},
+		{name: "recipient", max: -1},
+		{name: "source", max: 1},
+		{name: "description", max: 1},
+		{name: "content", min: 1, max: -1},
+		{name: "related", max: -1},
+	},
+	"DocumentManifest.content": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "p[x]", min: 1, max: 1, types: []string{"Attachment", "Reference"}},
+	},
+	"DocumentManifest.related": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: 1},
+		{name: "ref", max: 1},
+	},
+	"DocumentReference": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "masterIdentifier", max: 1},
+		{name: "identifier", max: -1},
+		{name: "status", min: 1, max: 1, codes: []string{"current", "superseded", "entered-in-error"}},
+		{name: "docStatus", max: 1, codes: []string{"preliminary", "final", "appended", "amended", "entered-in-error"}},
+		{name: "type", min: 1, max: 1},
+		{name: "class", max: 1},
+		{name: "subject", max: 1},
+		{name: "created", max: 1},
+		{name: "indexed", min: 1, max: 1},
+		{name: "author", max: -1},
+		{name: "authenticator", max: 1},
+		{name: "custodian", max: 1},
+		{name: "relatesTo", max: -1},
+		{name: "description", max: 1},
+		{name: "securityLabel", max: -1},
+		{name: "content", min: 1, max: -1},
+		{name: "context", max: 1},
+	},
+	"DocumentReference.content": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "attachment", min: 1, max: 1},
+		{name: "format", max: 1},
+	},
+	"DocumentReference.context": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "encounter", max: 1},
+		{name: "event", max: -1},
+		{name: "period", max: 1},
+		{name: "facilityType", max: 1},
+		{name: "practiceSetting", max: 1},
+		{name: "sourcePatientInfo", max: 1},
+		{name: "related", max: -1},
+	},
+	"DocumentReference.context.related": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: 1},
+		{name: "ref", max: 1},
+	},
+	"DocumentReference.relatesTo": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "code", min: 1, max: 1, codes: []string{"replaces", "transforms", "signs", "appends"}},
+		{name: "target", min: 1, max: 1},
+	},
+	"Dosage": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "sequence", max: 1},
+		{name: "text", max: 1},
+		{name: "additionalInstruction", max: -1},
+		{name: "patientInstruction", max: 1},
+		{name: "timing", max: 1},
+		{name: "asNeeded[x]", max: 1, types: []string{"boolean", "CodeableConcept"}},
+		{name: "site", max: 1},
+		{name: "route", max: 1},
+		{name: "method", max: 1},
+		{name: "dose[x]", max: 1, types: []string{"Range", "Quantity"}},
+		{name: "maxDosePerPeriod", max: 1},
+		{name: "maxDosePerAdministration", max: 1},
+		{name: "maxDosePerLifetime", max: 1},
+		{name: "rate[x]", max: 1, types: []string{"Ratio", "Range", "Quantity"}},
+	},
+	"Duration": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "value", max: 1},
+		{name: "comparator", max: 1, codes: []string{"<", "<=", ">=", ">"}},
+		{name: "unit", max: 1},
+		{name: "system", max: 1},
+		{name: "code", max: 1},
+	},
+	"ElementDefinition": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "path", min: 1, max: 1},
+		{name: "representation", max: -1, codes: []string{"xmlAttr", "xmlText", "typeAttr", "cdaText", "xhtml"}},
+		{name: "sliceName", max: 1},
+		{name: "label", max: 1},
+		{name: "code", max: -1},
+		{name: "slicing", max: 1},
+		{name: "short", max: 1},
+		{name: "definition", max: 1},
+		{name: "comment", max: 1},
+		{name: "requirements", max: 1},
+		{name: "alias", max: -1},
+		{name: "min", max: 1},
+		{name: "max", max: 1},
+		{name: "base", max: 1},
+		{name: "contentReference", max: 1},
+		{name: "type", max: -1},
+		{name: "defaultValue[x]", max: 1, types: []string{"base64Binary", "boolean", "code", "date", "dateTime", "decimal", "id", "instant", "integer", "markdown", "oid", "positiveInt", "string", "time", "unsignedInt", "uri", "Address", "Age", "Annotation", "Attachment", "CodeableConcept", "Coding", "ContactPoint", "Count", "Distance", "Duration", "HumanName", "Identifier", "Money", "Period", "Quantity", "Range", "Ratio", "Reference", "SampledData", "Signature", "Timing", "Meta"}},
+		{name: "meaningWhenMissing", max: 1},
+		{name: "orderMeaning", max: 1},
+		{name: "fixed[x]", max: 1, types: []string{"base64Binary", "boolean", "code", "date", "dateTime", "decimal", "id", "instant", "integer", "markdown", "oid", "positiveInt", "string", "time", "unsignedInt", "uri", "Address", "Age", "Annotation", "Attachment", "CodeableConcept", "Coding", "ContactPoint", "Count", "Distance", "Duration", "HumanName", "Identifier", "Money", "Period", "Quantity", "Range", "Ratio", "Reference", "SampledData", "Signature", "Timing", "Meta"}},
+		{name: "pattern[x]", max: 1, types: []string{"base64Binary", "boolean", "code", "date", "dateTime", "decimal", "id", "instant", "integer", "markdown", "oid", "positiveInt", "string", "time", "unsignedInt", "uri", "Address", "Age", "Annotation", "Attachment", "CodeableConcept", "Coding", "ContactPoint", "Count", "Distance", "Duration", "HumanName", "Identifier", "Money", "Period", "Quantity", "Range", "Ratio", "Reference", "SampledData", "Signature", "Timing", "Meta"}},
+		{name: "example", max: -1},
+		{name: "minValue[x]", max: 1, types: []string{"date", "dateTime", "instant", "time", "decimal", "integer", "positiveInt", "unsignedInt", "Quantity"}},
+		{name: "maxValue[x]", max: 1, types: []string{"date", "dateTime", "instant", "time", "decimal", "integer", "positiveInt", "unsignedInt", "Quantity"}},
+		{name: "maxLength", max: 1},
+		{name: "condition", max: -1},
+		{name: "constraint", max: -1},
+		{name: "mustSupport", max: 1},
+		{name: "isModifier", max: 1},
+		{name: "isSummary", max: 1},
+		{name: "binding", max: 1},
+		{name: "mapping", max: -1},
+	},
+	"ElementDefinition.base": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "path", min: 1, max: 1},
+		{name: "min", min: 1, max: 1},
+		{name: "max", min: 1, max: 1},
+	},
+	"ElementDefinition.binding": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "strength", min: 1, max: 1, codes: []string{"required", "extensible", "preferred", "example"}},
+		{name: "description", max: 1},
+		{name: "valueSet[x]", max: 1, types: []string{"uri", "Reference"}},
+	},
+	"ElementDefinition.constraint": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "key", min: 1, max: 1},
+		{name: "requirements", max: 1},
+		{name: "severity", min: 1, max: 1, codes: []string{"error", "warning"}},
+		{name: "human", min: 1, max: 1},
+		{name: "expression", min: 1, max: 1},
+		{name: "xpath", max: 1},
+		{name: "source", max: 1},
+	},
+	"ElementDefinition.example": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "label", min: 1, max: 1},
+		{name: "value[x]", min: 1, max: 1, types: []string{"base64Binary", "boolean", "code", "date", "dateTime", "decimal", "id", "instant", "integer", "markdown", "oid", "positiveInt", "string", "time", "unsignedInt", "uri", "Address", "Age", "Annotation", "Attachment", "CodeableConcept", "Coding", "ContactPoint", "Count", "Distance", "Duration", "HumanName", "Identifier", "Money", "Period", "Quantity", "Range", "Ratio", "Reference", "SampledData", "Signature", "Timing", "Meta"}},
+	},
+	"ElementDefinition.mapping": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "identity", min: 1, max: 1},
+		{name: "language", max: 1},
+		{name: "map", min: 1, max: 1},
+		{name: "comment", max: 1},
+	},
+	"ElementDefinition.slicing": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "discriminator", max: -1},
+		{name: "description", max: 1},
+		{name: "ordered", max: 1},
+		{name: "rules", min: 1, max: 1, codes: []string{"closed", "open", "openAtEnd"}},
+	},
+	"ElementDefinition.slicing.discriminator": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "type", min: 1, max: 1, codes: []string{"value", "exists", "pattern", "type", "profile"}},
+		{name: "path", min: 1, max: 1},
+	},
+	"ElementDefinition.type": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "code", min: 1, max: 1},
+		{name: "profile", max: 1},
+		{name: "targetProfile", max: 1},
+		{name: "aggregation", max: -1, codes: []string{"contained", "referenced", "bundled"}},
+		{name: "versioning", max: 1, codes: []string{"either", "independent", "specific"}},
+	},
+	"EligibilityRequest": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "status", max: 1, codes: []string{"active", "cancelled", "draft", "entered-in-error"}},
+		{name: "priority", max: 1},
+		{name: "patient", max: 1},
+		{name: "serviced[x]", max: 1, types: []string{"date", "Period"}},
+		{name: "created", max: 1},
+		{name: "enterer", max: 1},
+		{name: "provider", max: 1},
+		{name: "organization", max: 1},
+		{name: "insurer", max: 1},
+		{name: "facility", max: 1},
+		{name: "coverage", max: 1},
+		{name: "businessArrangement", max: 1},
+		{name: "benefitCategory", max: 1},
+		{name: "benefitSubCategory", max: 1},
+	},
+	"EligibilityResponse": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "status", max: 1, codes: []string{"active", "cancelled", "draft", "entered-in-error"}},
+		{name: "created", max: 1},
+		{name: "requestProvider", max: 1},
+		{name: "requestOrganization", max: 1},
+		{name: "request", max: 1},
+		{name: "outcome", max: 1, codes: []string{"http://hl7.org/fhir/remittance-outcome|complete", "http://hl7.org/fhir/remittance-outcome|error", "http://hl7.org/fhir/remittance-outcome|partial"}},
+		{name: "disposition", max: 1},
+		{name: "insurer", max: 1},
+		{name: "inforce", max: 1},
+		{name: "insurance", max: -1},
+		{name: "form", max: 1},
+		{name: "error", max: -1},
+	},
+	"EligibilityResponse.error": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "code", min: 1, max: 1},
+	},
+	"EligibilityResponse.insurance": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "coverage", max: 1},
+		{name: "contract", max: 1},
+		{name: "benefitBalance", max: -1},
+	},
+	"EligibilityResponse.insurance.benefitBalance": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "category", min: 1, max: 1},
+		{name: "subCategory", max: 1},
+		{name: "excluded", max: 1},
+		{name: "name", max: 1},
+		{name: "description", max: 1},
+		{name: "network", max: 1},
+		{name: "unit", max: 1},
+		{name: "term", max: 1},
+		{name: "financial", max: -1},
+	},
+	"EligibilityResponse.insurance.benefitBalance.financial": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "type", min: 1, max: 1},
+		{name: "allowed[x]", max: 1, types: []string{"unsignedInt", "string", "Money"}},
+		{name: "used[x]", max: 1, types: []string{"unsignedInt", "Money"}},
+	},
+	"Encounter": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "status", min: 1, max: 1},
+		{name: "statusHistory", max: -1},
+		{name: "class", max: 1},
+		{name: "classHistory", max: -1},
+		{name: "type", max: -1},
+		{name: "priority", max: 1},
+		{name: "subject", max: 1},
+		{name: "episodeOfCare", max: -1},
+		{name: "incomingReferral", max: -1},
+		{name: "participant", max: -1},
+		{name: "appointment", max: 1},
+		{name: "period", max: 1},
+		{name: "length", max: 1},
+		{name: "reason", max: -1},
+		{name: "diagnosis", max: -1},
+		{name: "account", max: -1},
+		{name: "hospitalization", max: 1},
+		{name: "location", max: -1},
+		{name: "serviceProvider", max: 1},
+		{name: "partOf", max: 1},
+	},
+	"Encounter.classHistory": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "class", min: 1, max: 1},
+		{name: "period", min: 1, max: 1},
+	},
+	"Encounter.diagnosis": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "condition", min: 1, max: 1},
+		{name: "role", max: 1},
+		{name: "rank", max: 1},
+	},
+	"Encounter.hospitalization": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "preAdmissionIdentifier", max: 1},
+		{name: "origin", max: 1},
+		{name: "admitSource", max: 1},
+		{name: "reAdmission", max: 1},
+		{name: "dietPreference", max: -1},
+		{name: "specialCourtesy", max: -1},
+		{name: "specialArrangement", max: -1},
+		{name: "destination", max: 1},
+		{name: "dischargeDisposition", max: 1},
+	},
+	"Encounter.location": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "location", min: 1, max: 1},
+		{name: "status", max: 1, codes: []string{"planned", "active", "reserved", "completed"}},
+		{name: "period", max: 1},
+	},
+	"Encounter.participant": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "type", max: -1},
+		{name: "period", max: 1},
+		{name: "individual", max: 1},
+	},
+	"Encounter.statusHistory": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "status", min: 1, max: 1},
+		{name: "period", min: 1, max: 1},
+	},
+	"Endpoint": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "status", min: 1, max: 1, codes: []string{"active", "suspended", "error", "off", "entered-in-error", "test"}},
+		{name: "connectionType", min: 1, max: 1},
+		{name: "name", max: 1},
+		{name: "managingOrganization", max: 1},
+		{name: "contact", max: -1},
+		{name: "period", max: 1},
+		{name: "payloadType", min: 1, max: -1},
+		{name: "payloadMimeType", max: -1},
+		{name: "address", min: 1, max: 1},
+		{name: "header", max: -1},
+	},
+	"EnrollmentRequest": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "status", max: 1, codes: []string{"active", "cancelled", "draft", "entered-in-error"}},
+		{name: "created", max: 1},
+		{name: "insurer", max: 1},
+		{name: "provider", max: 1},
+		{name: "organization", max: 1},
+		{name: "subject", max: 1},
+		{name: "coverage", max: 1},
+	},
+	"EnrollmentResponse": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "status", max: 1, codes: []string{"active", "cancelled", "draft", "entered-in-error"}},
+		{name: "request", max: 1},
+		{name: "outcome", max: 1, codes: []string{"http://hl7.org/fhir/remittance-outcome|complete", "http://hl7.org/fhir/remittance-outcome|error", "http://hl7.org/fhir/remittance-outcome|partial"}},
+		{name: "disposition", max: 1},
+		{name: "created", max: 1},
+		{name: "organization", max: 1},
+		{name: "requestProvider", max: 1},
+		{name: "requestOrganization", max: 1},
+	},
+	"EpisodeOfCare": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "status", min: 1, max: 1, codes: []string{"planned", "waitlist", "active", "onhold", "finished", "cancelled", "entered-in-error"}},
+		{name: "statusHistory", max: -1},
+		{name: "type", max: -1},
+		{name: "diagnosis", max: -1},
+		{name: "patient", min: 1, max: 1},
+		{name: "managingOrganization", max: 1},
+		{name: "period", max: 1},
+		{name: "referralRequest", max: -1},
+		{name: "careManager", max: 1},
+		{name: "team", max: -1},
+		{name: "account", max: -1},
+	},
+	"EpisodeOfCare.diagnosis": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "condition", min: 1, max: 1},
+		{name: "role", max: 1},
+		{name: "rank", max: 1},
+	},
+	"EpisodeOfCare.statusHistory": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "status", min: 1, max: 1, codes: []string{"planned", "waitlist", "active", "onhold", "finished", "cancelled", "entered-in-error"}},
+		{name: "period", min: 1, max: 1},
+	},
+	"ExpansionProfile": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "url", max: 1},
+		{name: "identifier", max: 1},
+		{name: "version", max: 1},
+		{name: "name", max: 1},
+		{name: "status", min: 1, max: 1, codes: []string{"draft", "active", "retired", "unknown"}},
+		{name: "experimental", max: 1},
+		{name: "date", max: 1},
+		{name: "publisher", max: 1},
+		{name: "contact", max: -1},
+		{name: "description", max: 1},
+		{name: "useContext", max: -1},
+		{name: "jurisdiction", max: -1},
+		{name: "fixedVersion", max: -1},
+		{name: "excludedSystem", max: 1},
+		{name: "includeDesignations", max: 1},
+		{name: "designation", max: 1},
+		{name: "includeDefinition", max: 1},
+		{name: "activeOnly", max: 1},
+		{name: "excludeNested", max: 1},
+		{name: "excludeNotForUI", max: 1},
+		{name: "excludePostCoordinated", max: 1},
+		{name: "displayLanguage", max: 1},
+		{name: "limitedExpansion", max: 1},
+	},
+	"ExpansionProfile.designation": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "include", max: 1},
+		{name: "exclude", max: 1},
+	},
+	"ExpansionProfile.designation.exclude": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "designation", max: -1},
+	},
+	"ExpansionProfile.designation.exclude.designation": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "language", max: 1},
+		{name: "use", max: 1},
+	},
+	"ExpansionProfile.designation.include": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "designation", max: -1},
+	},
+	"ExpansionProfile.designation.include.designation": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "language", max: 1},
+		{name: "use", max: 1},
+	},
+	"ExpansionProfile.excludedSystem": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "system", min: 1, max: 1},
+		{name: "version", max: 1},
+	},
+	"ExpansionProfile.fixedVersion": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "system", min: 1, max: 1},
+		{name: "version", min: 1, max: 1},
+		{name: "mode", min: 1, max: 1, codes: []string{"default", "check", "override"}},
+	},
+	"ExplanationOfBenefit": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "status", max: 1, codes: []string{"active", "cancelled", "draft", "entered-in-error"}},
+		{name: "type", max: 1, codes: []string{"http://hl7.org/fhir/ex-claimtype|institutional", "http://hl7.org/fhir/ex-claimtype|oral", "http://hl7.org/fhir/ex-claimtype|pharmacy", "http://hl7.org/fhir/ex-claimtype|professional", "http://hl7.org/fhir/ex-claimtype|vision"}},
+		{name: "subType", max: -1},
+		{name: "patient", max: 1},
+		{name: "billablePeriod", max: 1},
+		{name: "created", max: 1},
+		{name: "enterer", max: 1},
+		{name: "insurer", max: 1},
+		{name: "provider", max: 1},
+		{name: "organization", max: 1},
+		{name: "referral", max: 1},
+		{name: "facility", max: 1},
+		{name: "claim", max: 1},
+		{name: "claimResponse", max: 1},
+		{name: "outcome", max: 1},
+		{name: "disposition", max: 1},
+		{name: "related", max: -1},
+		{name: "prescription", max: 1},
+		{name: "originalPrescription", max: 1},
+		{name: "payee", max: 1},
+		{name: "information", max: -1},
+		{name: "careTeam", max: -1},
+		{name: "diagnosis", max: -1},
+		{name: "procedure", max: -1},
+		{name: "precedence", max: 1},
+		{name: "insurance", max: 1},
+		{name: "accident", max: 1},
+		{name: "employmentImpacted", max: 1},
+		{name: "hospitalization", max: 1},
+		{name: "item", max: -1},
+		{name: "addItem", max: -1},
+		{name: "totalCost", max: 1},
+		{name: "unallocDeductable", max: 1},
+		{name: "totalBenefit", max: 1},
+		{name: "payment", max: 1},
+		{name: "form", max: 1},
+		{name: "processNote", max: -1},
+		{name: "benefitBalance", max: -1},
+	},
+	"ExplanationOfBenefit.accident": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "date", max: 1},
+		{name: "type", max: 1},
+		{name: "location[x]", max: 1, types: []string{"Address", "Reference"}},
+	},
+	"ExplanationOfBenefit.addItem": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "sequenceLinkId", max: -1},
+		{name: "revenue", max: 1},
+		{name: "category", max: 1},
+		{name: "service", max: 1},
+		{name: "modifier", max: -1},
+		{name: "fee", max: 1},
+		{name: "noteNumber", max: -1},
+		{name: "adjudication", max: -1},
+		{name: "detail", max: -1},
+	},
+	"ExplanationOfBenefit.addItem.detail": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "revenue", max: 1},
+		{name: "category", max: 1},
+		{name: "service", max: 1},
+		{name: "modifier", max: -1},
+		{name: "fee", max: 1},
+		{name: "noteNumber", max: -1},
+		{name: "adjudication", max: -1},
+	},
+	"ExplanationOfBenefit.benefitBalance": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "category", min: 1, max: 1},
+		{name: "subCategory", max: 1},
+		{name: "excluded", max: 1},
+		{name: "name", max: 1},
+		{name: "description", max: 1},
+		{name: "network", max: 1},
+		{name: "unit", max: 1},
+		{name: "term", max: 1},
+		{name: "financial", max: -1},
+	},
+	"ExplanationOfBenefit.benefitBalance.financial": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "type", min: 1, max: 1},
+		{name: "allowed[x]", max: 1, types: []string{"unsignedInt", "string", "Money"}},
+		{name: "used[x]", max: 1, types: []string{"unsignedInt", "Money"}},
+	},
+	"ExplanationOfBenefit.careTeam": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "sequence", min: 1, max: 1},
+		{name: "provider", min: 1, max: 1},
+		{name: "responsible", max: 1},
+		{name: "role", max: 1},
+		{name: "qualification", max: 1},
+	},
+	"ExplanationOfBenefit.diagnosis": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "sequence", min: 1, max: 1},
+		{name: "diagnosis[x]", min: 1, max: 1, types: []string{"CodeableConcept", "Reference"}},
+		{name: "type", max: -1},
+		{name: "packageCode", max: 1},
+	},
+	"ExplanationOfBenefit.information": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "sequence", min: 1, max: 1},
+		{name: "category", min: 1, max: 1},
+		{name: "code", max: 1},
+		{name: "timing[x]", max: 1, types: []string{"date", "Period"}},
+		{name: "value[x]", max: 1, types: []string{"string", "Quantity", "Attachment", "Reference"}},
+		{name: "reason", max: 1},
+	},
+	"ExplanationOfBenefit.insurance": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "coverage", max: 1},
+		{name: "preAuthRef", max: -1},
+	},
+	"ExplanationOfBenefit.item": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "sequence", min: 1, max: 1},
+		{name: "careTeamLinkId", max: -1},
+		{name: "diagnosisLinkId", max: -1},
+		{name: "procedureLinkId", max: -1},
+		{name: "informationLinkId", max: -1},
+		{name: "revenue", max: 1},
+		{name: "category", max: 1},
+		{name: "service", max: 1},
+		{name: "modifier", max: -1},
+		{name: "programCode", max: -1},
+		{name: "serviced[x]", max: 1, types: []string{"date", "Period"}},
+		{name: "location[x]", max: 1, types: []string{"CodeableConcept", "Address", "Reference"}},
+		{name: "quantity", max: 1},
+		{name: "unitPrice", max: 1},
+		{name: "factor", max: 1},
+		{name: "net", max: 1},
+		{name: "udi", max: -1},
+		{name: "bodySite", max: 1},
+		{name: "subSite", max: -1},
+		{name: "encounter", max: -1},
+		{name: "noteNumber", max: -1},
+		{name: "adjudication", max: -1},
+		{name: "detail", max: -1},
+	},
+	"ExplanationOfBenefit.item.adjudication": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "category", min: 1, max: 1},
+		{name: "reason", max: 1},
+		{name: "amount", max: 1},
+		{name: "value", max: 1},
+	},
+	"ExplanationOfBenefit.item.detail": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "sequence", min: 1, max: 1},
+		{name: "type", min: 1, max: 1},
+		{name: "revenue", max: 1},
+		{name: "category", max: 1},
+		{name: "service", max: 1},
+		{name: "modifier", max: -1},
+		{name: "programCode", max: -1},
+		{name: "quantity", max: 1},
+		{name: "unitPrice", max: 1},
+		{name: "factor", max: 1},
+		{name: "net", max: 1},
+		{name: "udi", max: -1},
+		{name: "noteNumber", max: -1},
+		{name: "adjudication", max: -1},
+		{name: "subDetail", max: -1},
+	},
+	"ExplanationOfBenefit.item.detail.subDetail": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "sequence", min: 1, max: 1},
+		{name: "type", min: 1, max: 1},
+		{name: "revenue", max: 1},
+		{name: "category", max: 1},
+		{name: "service", max: 1},
+		{name: "modifier", max: -1},
+		{name: "programCode", max: -1},
+		{name: "quantity", max: 1},
+		{name: "unitPrice", max: 1},
+		{name: "factor", max: 1},
+		{name: "net", max: 1},
+		{name: "udi", max: -1},
+		{name: "noteNumber", max: -1},
+		{name: "adjudication", max: -1},
+	},
+	"ExplanationOfBenefit.payee": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "type", max: 1},
+		{name: "resourceType", max: 1, codes: []string{"http://hl7.org/fhir/resource-type-link|organization", "http://hl7.org/fhir/resource-type-link|patient", "http://hl7.org/fhir/resource-type-link|practitioner", "http://hl7.org/fhir/resource-type-link|relatedperson"}},
+		{name: "party", max: 1},
+	},
+	"ExplanationOfBenefit.payment": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "type", max: 1},
+		{name: "adjustment", max: 1},
+		{name: "adjustmentReason", max: 1},
+		{name: "date", max: 1},
+		{name: "amount", max: 1},
+		{name: "identifier", max: 1},
+	},
+	"ExplanationOfBenefit.procedure": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "sequence", min: 1, max: 1},
+		{name: "date", max: 1},
+		{name: "procedure[x]", min: 1, max: 1, types: []string{"CodeableConcept", "Reference"}},
+	},
+	"ExplanationOfBenefit.processNote": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "number", max: 1},
+		{name: "type", max: 1, codes: []string{"http://hl7.org/fhir/note-type|display", "http://hl7.org/fhir/note-type|print", "http://hl7.org/fhir/note-type|printoper"}},
+		{name: "text", max: 1},
+		{name: "language", max: 1},
+	},
+	"ExplanationOfBenefit.related": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "claim", max: 1},
+		{name: "relationship", max: 1},
+		{name: "reference", max: 1},
+	},
+	"Extension": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "url", min: 1, max: 1},
+		{name: "value[x]", max: 1, types: []string{"base64Binary", "boolean", "code", "date", "dateTime", "decimal", "id", "instant", "integer", "markdown", "oid", "positiveInt", "string", "time", "unsignedInt", "uri", "Address", "Age", "Annotation", "Attachment", "CodeableConcept", "Coding", "ContactPoint", "Count", "Distance", "Duration", "HumanName", "Identifier", "Money", "Period", "Quantity", "Range", "Ratio", "Reference", "SampledData", "Signature", "Timing", "Meta"}},
+	},
+	"FamilyMemberHistory": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "definition", max: -1},
+		{name: "status", min: 1, max: 1, codes: []string{"partial", "completed", "entered-in-error", "health-unknown"}},
+		{name: "notDone", max: 1},
+		{name: "notDoneReason", max: 1},
+		{name: "patient", min: 1, max: 1},
+		{name: "date", max: 1},
+		{name: "name", max: 1},
+		{name: "relationship", min: 1, max: 1},
+		{name: "gender", max: 1, codes: []string{"male", "female", "other", "unknown"}},
+		{name: "born[x]", max: 1, types: []string{"Period", "date", "string"}},
+		{name: "age[x]", max: 1, types: []string{"Age", "Range", "string"}},
+		{name: "estimatedAge", max: 1},
+		{name: "deceased[x]", max: 1, types: []string{"boolean", "Age", "Range", "date", "string"}},
+		{name: "reasonCode", max: -1},
+		{name: "reasonReference", max: -1},
+		{name: "note", max: -1},
+		{name: "condition", max: -1},
+	},
+	"FamilyMemberHistory.condition": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "code", min: 1, max: 1},
+		{name: "outcome", max: 1},
+		{name: "onset[x]", max: 1, types: []string{"Age", "Range", "Period", "string"}},
+		{name: "note", max: -1},
+	},
+	"Flag": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "status", min: 1, max: 1, codes: []string{"active", "inactive", "entered-in-error"}},
+		{name: "category", max: 1},
+		{name: "code", min: 1, max: 1},
+		{name: "subject", min: 1, max: 1},
+		{name: "period", max: 1},
+		{name: "encounter", max: 1},
+		{name: "author", max: 1},
+	},
+	"Goal": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "status", min: 1, max: 1, codes: []string{"proposed", "accepted", "planned", "in-progress", "on-target", "ahead-of-target", "behind-target", "sustaining", "achieved", "on-hold", "cancelled", "entered-in-error", "rejected"}},
+		{name: "category", max: -1},
+		{name: "priority", max: 1},
+		{name: "description", min: 1, max: 1},
+		{name: "subject", max: 1},
+		{name: "start[x]", max: 1, types: []string{"date", "CodeableConcept"}},
+		{name: "target", max: 1},
+		{name: "statusDate", max: 1},
+		{name: "statusReason", max: 1},
+		{name: "expressedBy", max: 1},
+		{name: "addresses", max: -1},
+		{name: "note", max: -1},
+		{name: "outcomeCode", max: -1},
+		{name: "outcomeReference", max: -1},
+	},
+	"Goal.target": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "measure", max: 1},
+		{name: "detail[x]", max: 1, types: []string{"Quantity", "Range", "CodeableConcept"}},
+		{name: "due[x]", max: 1, types: []string{"date", "Duration"}},
+	},
+	"GraphDefinition": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "url", max: 1},
+		{name: "version", max: 1},
+		{name: "name", min: 1, max: 1},
+		{name: "status", min: 1, max: 1, codes: []string{"draft", "active", "retired", "unknown"}},
+		{name: "experimental", max: 1},
+		{name: "date", max: 1},
+		{name: "publisher", max: 1},
+		{name: "contact", max: -1},
+		{name: "description", max: 1},
+		{name: "useContext", max: -1},
+		{name: "jurisdiction", max: -1},
+		{name: "purpose", max: 1},
+		{name: "start", min: 1, max: 1, codes: []string{"Account", "ActivityDefinition", "AdverseEvent", "AllergyIntolerance", "Appointment", "AppointmentResponse", "AuditEvent", "Basic", "Binary", "BiologicallyDerivedProduct", "BodyStructure", "Bundle", "CapabilityStatement", "CarePlan", "CareTeam", "ChargeItem", "Claim", "ClaimResponse", "ClinicalImpression", "CodeSystem", "Communication", "CommunicationRequest", "CompartmentDefinition", "Composition", "ConceptMap", "Condition", "Consent", "Contract", "Coverage", "DetectedIssue", "Device", "DeviceComponent", "DeviceMetric", "DeviceRequest", "DeviceUseStatement", "DiagnosticReport", "DocumentManifest", "DocumentReference", "DomainResource", "EligibilityRequest", "EligibilityResponse", "Encounter", "Endpoint", "EnrollmentRequest", "EnrollmentResponse", "EntryDefinition", "EpisodeOfCare", "EventDefinition", "ExampleScenario", "ExpansionProfile", "ExplanationOfBenefit", "FamilyMemberHistory", "Flag", "Goal", "GraphDefinition", "Group", "GuidanceResponse", "HealthcareService", "ImagingStudy", "Immunization", "ImmunizationEvaluation", "ImmunizationRecommendation", "ImplementationGuide", "ImplementationGuideInput", "ImplementationGuideOutput", "Invoice", "ItemInstance", "Library", "Linkage", "List", "Location", "Measure", "MeasureReport", "Media", "Medication", "MedicationAdministration", "MedicationDispense", "MedicationRequest", "MedicationStatement", "MedicinalProduct", "MedicinalProductAuthorization", "MedicinalProductClinicals", "MedicinalProductDeviceSpec", "MedicinalProductIngredient", "MedicinalProductPackaged", "MedicinalProductPharmaceutical", "MessageDefinition", "MessageHeader", "NamingSystem", "NutritionOrder", "Observation", "ObservationDefinition", "OccupationalData", "OperationDefinition", "OperationOutcome", "Organization", "OrganizationRole", "Parameters", "Patient", "PaymentNotice", "PaymentReconciliation", "Person", "PlanDefinition", "Practitioner", "PractitionerRole", "Procedure", "ProcessRequest", "ProcessResponse", "ProductPlan", "Provenance", "Questionnaire", "QuestionnaireResponse", "RelatedPerson", "RequestGroup", "ResearchStudy", "ResearchSubject", "Resource", "RiskAssessment", "Schedule", "SearchParameter", "Sequence", "ServiceDefinition", "ServiceRequest", "Slot", "Specimen", "SpecimenDefinition", "StructureDefinition", "StructureMap", "Subscription", "Substance", "SubstancePolymer", "SubstanceReferenceInformation", "SubstanceSpecification", "SupplyDelivery", "SupplyRequest", "Task", "TerminologyCapabilities", "TestReport", "TestScript", "UserSession", "ValueSet", "VerificationResult", "VisionPrescription"}},
+		{name: "profile", max: 1},
+		{name: "link", max: -1},
+	},
+	"GraphDefinition.link": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "path", min: 1, max: 1},
+		{name: "sliceName", max: 1},
+		{name: "min", max: 1},
+		{name: "max", max: 1},
+		{name: "description", max: 1},
+		{name: "target", min: 1, max: -1},
+	},
+	"GraphDefinition.link.target": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "type", min: 1, max: 1, codes: []string{"Account", "ActivityDefinition", "AdverseEvent", "AllergyIntolerance", "Appointment", "AppointmentResponse", "AuditEvent", "Basic", "Binary", "BiologicallyDerivedProduct", "BodyStructure", "Bundle", "CapabilityStatement", "CarePlan", "CareTeam", "ChargeItem", "Claim", "ClaimResponse", "ClinicalImpression", "CodeSystem", "Communication", "CommunicationRequest", "CompartmentDefinition", "Composition", "ConceptMap", "Condition", "Consent", "Contract", "Coverage", "DetectedIssue", "Device", "DeviceComponent", "DeviceMetric", "DeviceRequest", "DeviceUseStatement", "DiagnosticReport", "DocumentManifest", "DocumentReference", "DomainResource", "EligibilityRequest", "EligibilityResponse", "Encounter", "Endpoint", "EnrollmentRequest", "EnrollmentResponse", "EntryDefinition", "EpisodeOfCare", "EventDefinition", "ExampleScenario", "ExpansionProfile", "ExplanationOfBenefit", "FamilyMemberHistory", "Flag", "Goal", "GraphDefinition", "Group", "GuidanceResponse", "HealthcareService", "ImagingStudy", "Immunization", "ImmunizationEvaluation", "ImmunizationRecommendation", "ImplementationGuide", "ImplementationGuideInput", "ImplementationGuideOutput", "Invoice", "ItemInstance", "Library", "Linkage", "List", "Location", "Measure", "MeasureReport", "Media", "Medication", "MedicationAdministration", "MedicationDispense", "MedicationRequest", "MedicationStatement", "MedicinalProduct", "MedicinalProductAuthorization", "MedicinalProductClinicals", "MedicinalProductDeviceSpec", "MedicinalProductIngredient", "MedicinalProductPackaged", "MedicinalProductPharmaceutical", "MessageDefinition", "MessageHeader", "NamingSystem", "NutritionOrder", "Observation", "ObservationDefinition", "OccupationalData", "OperationDefinition", "OperationOutcome", "Organization", "OrganizationRole", "Parameters", "Patient", "PaymentNotice", "PaymentReconciliation", "Person", "PlanDefinition", "Practitioner", "PractitionerRole", "Procedure", "ProcessRequest", "ProcessResponse", "ProductPlan", "Provenance", "Questionnaire", "QuestionnaireResponse", "RelatedPerson", "RequestGroup", "ResearchStudy", "ResearchSubject", "Resource", "RiskAssessment", "Schedule", "SearchParameter", "Sequence", "ServiceDefinition", "ServiceRequest", "Slot", "Specimen", "SpecimenDefinition", "StructureDefinition", "StructureMap", "Subscription", "Substance", "SubstancePolymer", "SubstanceReferenceInformation", "SubstanceSpecification", "SupplyDelivery", "SupplyRequest", "Task", "TerminologyCapabilities", "TestReport", "TestScript", "UserSession", "ValueSet", "VerificationResult", "VisionPrescription"}},
+		{name: "profile", max: 1},
+		{name: "compartment", max: -1},
+		{name: "link", max: -1},
+	},
+	"GraphDefinition.link.target.compartment": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "code", min: 1, max: 1, codes: []string{"Patient", "Encounter", "RelatedPerson", "Practitioner", "Device"}},
+		{name: "rule", min: 1, max: 1, codes: []string{"identical", "matching", "different", "custom"}},
+		{name: "expression", max: 1},
+		{name: "description", max: 1},
+	},
+	"Group": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "active", max: 1},
+		{name: "type", min: 1, max: 1, codes: []string{"person", "animal", "practitioner", "device", "medication", "substance"}},
+		{name: "actual", min: 1, max: 1},
+		{name: "code", max: 1},
+		{name: "name", max: 1},
+		{name: "quantity", max: 1},
+		{name: "characteristic", max: -1},
+		{name: "member", max: -1},
+	},
+	"Group.characteristic": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "code", min: 1, max: 1},
+		{name: "value[x]", min: 1, max: 1, types: []string{"CodeableConcept", "boolean", "Quantity", "Range"}},
+		{name: "exclude", min: 1, max: 1},
+		{name: "period", max: 1},
+	},
+	"Group.member": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "entity", min: 1, max: 1},
+		{name: "period", max: 1},
+		{name: "inactive", max: 1},
+	},
+	"GuidanceResponse": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "requestId", max: 1},
+		{name: "identifier", max: 1},
+		{name: "module", min: 1, max: 1},
+		{name: "status", min: 1, max: 1, codes: []string{"success", "data-requested", "data-required", "in-progress", "failure", "entered-in-error"}},
+		{name: "subject", max: 1},
+		{name: "context", max: 1},
+		{name: "occurrenceDateTime", max: 1},
+		{name: "performer", max: 1},
+		{name: "reason[x]", max: 1, types: []string{"CodeableConcept", "Reference"}},
+		{name: "note", max: -1},
+		{name: "evaluationMessage", max: -1},
+		{name: "outputParameters", max: 1},
+		{name: "result", max: 1},
+		{name: "dataRequirement", max: -1},
+	},
+	"HealthcareService": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "active", max: 1},
+		{name: "providedBy", max: 1},
+		{name: "category", max: 1},
+		{name: "type", max: -1},
+		{name: "specialty", max: -1},
+		{name: "location", max: -1},
+		{name: "name", max: 1},
+		{name: "comment", max: 1},
+		{name: "extraDetails", max: 1},
+		{name: "photo", max: 1},
+		{name: "telecom", max: -1},
+		{name: "coverageArea", max: -1},
+		{name: "serviceProvisionCode", max: -1},
+		{name: "eligibility", max: 1},
+		{name: "eligibilityNote", max: 1},
+		{name: "programName", max: -1},
+		{name: "characteristic", max: -1},
+		{name: "referralMethod", max: -1},
+		{name: "appointmentRequired", max: 1},
+		{name: "availableTime", max: -1},
+		{name: "notAvailable", max: -1},
+		{name: "availabilityExceptions", max: 1},
+		{name: "endpoint", max: -1},
+	},
+	"HealthcareService.availableTime": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "daysOfWeek", max: -1, codes: []string{"mon", "tue", "wed", "thu", "fri", "sat", "sun"}},
+		{name: "allDay", max: 1},
+		{name: "availableStartTime", max: 1},
+		{name: "availableEndTime", max: 1},
+	},
+	"HealthcareService.notAvailable": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "description", min: 1, max: 1},
+		{name: "during", max: 1},
+	},
+	"HumanName": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "use", max: 1, codes: []string{"usual", "official", "temp", "nickname", "anonymous", "old", "maiden"}},
+		{name: "text", max: 1},
+		{name: "family", max: 1},
+		{name: "given", max: -1},
+		{name: "prefix", max: -1},
+		{name: "suffix", max: -1},
+		{name: "period", max: 1},
+	},
+	"Identifier": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "use", max: 1, codes: []string{"usual", "official", "temp", "secondary", "old"}},
+		{name: "type", max: 1},
+		{name: "system", max: 1},
+		{name: "value", max: 1},
+		{name: "period", max: 1},
+		{name: "assigner", max: 1},
+	},
+	"ImagingManifest": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: 1},
+		{name: "patient", min: 1, max: 1},
+		{name: "authoringTime", max: 1},
+		{name: "author", max: 1},
+		{name: "description", max: 1},
+		{name: "study", min: 1, max: -1},
+	},
+	"ImagingManifest.study": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "uid", min: 1, max: 1},
+		{name: "imagingStudy", max: 1},
+		{name: "endpoint", max: -1},
+		{name: "series", min: 1, max: -1},
+	},
+	"ImagingManifest.study.series": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "uid", min: 1, max: 1},
+		{name: "endpoint", max: -1},
+		{name: "instance", min: 1, max: -1},
+	},
+	"ImagingManifest.study.series.instance": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "sopClass", min: 1, max: 1},
+		{name: "uid", min: 1, max: 1},
+	},
+	"ImagingStudy": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "uid", min: 1, max: 1},
+		{name: "accession", max: 1},
+		{name: "identifier", max: -1},
+		{name: "availability", max: 1, codes: []string{"ONLINE", "OFFLINE", "NEARLINE", "UNAVAILABLE"}},
+		{name: "modalityList", max: -1},
+		{name: "patient", min: 1, max: 1},
+		{name: "context", max: 1},
+		{name: "started", max: 1},
+		{name: "basedOn", max: -1},
+		{name: "referrer", max: 1},
+		{name: "interpreter", max: -1},
+		{name: "endpoint", max: -1},
+		{name: "numberOfSeries", max: 1},
+		{name: "numberOfInstances", max: 1},
+		{name: "procedureReference", max: -1},
+		{name: "procedureCode", max: -1},
+		{name: "reason", max: 1},
+		{name: "description", max: 1},
+		{name: "series", max: -1},
+	},
+	"ImagingStudy.series": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "uid", min: 1, max: 1},
+		{name: "number", max: 1},
+		{name: "modality", min: 1, max: 1},
+		{name: "description", max: 1},
+		{name: "numberOfInstances", max: 1},
+		{name: "availability", max: 1, codes: []string{"ONLINE", "OFFLINE", "NEARLINE", "UNAVAILABLE"}},
+		{name: "endpoint", max: -1},
+		{name: "bodySite", max: 1},
+		{name: "laterality", max: 1},
+		{name: "started", max: 1},
+		{name: "performer", max: -1},
+		{name: "instance", max: -1},
+	},
+	"ImagingStudy.series.instance": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "uid", min: 1, max: 1},
+		{name: "number", max: 1},
+		{name: "sopClass", min: 1, max: 1},
+		{name: "title", max: 1},
+	},
+	"Immunization": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "status", min: 1, max: 1, codes: []string{"completed", "entered-in-error", "not-done"}},
+		{name: "notGiven", min: 1, max: 1},
+		{name: "vaccineCode", min: 1, max: 1},
+		{name: "patient", min: 1, max: 1},
+		{name: "encounter", max: 1},
+		{name: "date", max: 1},
+		{name: "primarySource", min: 1, max: 1},
+		{name: "reportOrigin", max: 1},
+		{name: "location", max: 1},
+		{name: "manufacturer", max: 1},
+		{name: "lotNumber", max: 1},
+		{name: "expirationDate", max: 1},
+		{name: "site", max: 1},
+		{name: "route", max: 1},
+		{name: "doseQuantity", max: 1},
+		{name: "practitioner", max: -1},
+		{name: "note", max: -1},
+		{name: "explanation", max: 1},
+		{name: "reaction", max: -1},
+		{name: "vaccinationProtocol", max: -1},
+	},
+	"Immunization.explanation": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "reason", max: -1},
+		{name: "reasonNotGiven", max: -1},
+	},
+	"Immunization.practitioner": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "role", max: 1},
+		{name: "actor", min: 1, max: 1},
+	},
+	"Immunization.reaction": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "date", max: 1},
+		{name: "detail", max: 1},
+		{name: "reported", max: 1},
+	},
+	"Immunization.vaccinationProtocol": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "doseSequence", max: 1},
+		{name: "description", max: 1},
+		{name: "authority", max: 1},
+		{name: "series", max: 1},
+		{name: "seriesDoses", max: 1},
+		{name: "targetDisease", min: 1, max: -1},
+		{name: "doseStatus", min: 1, max: 1},
+		{name: "doseStatusReason", max: 1},
+	},
+	"ImmunizationRecommendation": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "patient", min: 1, max: 1},
+		{name: "recommendation", min: 1, max: -1},
+	},
+	"ImmunizationRecommendation.recommendation": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "date", min: 1, max: 1},
+		{name: "vaccineCode", max: 1},
+		{name: "targetDisease", max: 1},
+		{name: "doseNumber", max: 1},
+		{name: "forecastStatus", min: 1, max: 1},
+		{name: "dateCriterion", max: -1},
+		{name: "protocol", max: 1},
+		{name: "supportingImmunization", max: -1},
+		{name: "supportingPatientInformation", max: -1},
+	},
+	"ImmunizationRecommendation.recommendation.dateCriterion": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "code", min: 1, max: 1},
+		{name: "value", min: 1, max: 1},
+	},
+	"ImmunizationRecommendation.recommendation.protocol": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "doseSequence", max: 1},
+		{name: "description", max: 1},
+		{name: "authority", max: 1},
+		{name: "series", max: 1},
+	},
+	"ImplementationGuide": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "url", min: 1, max: 1},
+		{name: "version", max: 1},
+		{name: "name", min: 1, max: 1},
+		{name: "status", min: 1, max: 1, codes: []string{"draft", "active", "retired", "unknown"}},
+		{name: "experimental", max: 1},
+		{name: "date", max: 1},
+		{name: "publisher", max: 1},
+		{name: "contact", max: -1},
+		{name: "description", max: 1},
+		{name: "useContext", max: -1},
+		{name: "jurisdiction", max: -1},
+		{name: "copyright", max: 1},
+		{name: "fhirVersion", max: 1},
+		{name: "dependency", max: -1},
+		{name: "package", max: -1},
+		{name: "global", max: -1},
+		{name: "binary", max: -1},
+		{name: "page", max: 1},
+	},
+	"ImplementationGuide.dependency": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "type", min: 1, max: 1, codes: []string{"reference", "inclusion"}},
+		{name: "uri", min: 1, max: 1},
+	},
+	"ImplementationGuide.global": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "type", min: 1, max: 1, codes: []string{"Account", "ActivityDefinition", "AdverseEvent", "AllergyIntolerance", "Appointment", "AppointmentResponse", "AuditEvent", "Basic", "Binary", "BiologicallyDerivedProduct", "BodyStructure", "Bundle", "CapabilityStatement", "CarePlan", "CareTeam", "ChargeItem", "Claim", "ClaimResponse", "ClinicalImpression", "CodeSystem", "Communication", "CommunicationRequest", "CompartmentDefinition", "Composition", "ConceptMap", "Condition", "Consent", "Contract", "Coverage", "DetectedIssue", "Device", "DeviceComponent", "DeviceMetric", "DeviceRequest", "DeviceUseStatement", "DiagnosticReport", "DocumentManifest", "DocumentReference", "DomainResource", "EligibilityRequest", "EligibilityResponse", "Encounter", "Endpoint", "EnrollmentRequest", "EnrollmentResponse", "EntryDefinition", "EpisodeOfCare", "EventDefinition", "ExampleScenario", "ExpansionProfile", "ExplanationOfBenefit", "FamilyMemberHistory", "Flag", "Goal", "GraphDefinition", "Group", "GuidanceResponse", "HealthcareService", "ImagingStudy", "Immunization", "ImmunizationEvaluation", "ImmunizationRecommendation", "ImplementationGuide", "ImplementationGuideInput", "ImplementationGuideOutput", "Invoice", "ItemInstance", "Library", "Linkage", "List", "Location", "Measure", "MeasureReport", "Media", "Medication", "MedicationAdministration", "MedicationDispense", "MedicationRequest", "MedicationStatement", "MedicinalProduct", "MedicinalProductAuthorization", "MedicinalProductClinicals", "MedicinalProductDeviceSpec", "MedicinalProductIngredient", "MedicinalProductPackaged", "MedicinalProductPharmaceutical", "MessageDefinition", "MessageHeader", "NamingSystem", "NutritionOrder", "Observation", "ObservationDefinition", "OccupationalData", "OperationDefinition", "OperationOutcome", "Organization", "OrganizationRole", "Parameters", "Patient", "PaymentNotice", "PaymentReconciliation", "Person", "PlanDefinition", "Practitioner", "PractitionerRole", "Procedure", "ProcessRequest", "ProcessResponse", "ProductPlan", "Provenance", "Questionnaire", "QuestionnaireResponse", "RelatedPerson", "RequestGroup", "ResearchStudy", "ResearchSubject", "Resource", "RiskAssessment", "Schedule", "SearchParameter", "Sequence", "ServiceDefinition", "ServiceRequest", "Slot", "Specimen", "SpecimenDefinition", "StructureDefinition", "StructureMap", "Subscription", "Substance", "SubstancePolymer", "SubstanceReferenceInformation", "SubstanceSpecification", "SupplyDelivery", "SupplyRequest", "Task", "TerminologyCapabilities", "TestReport", "TestScript", "UserSession", "ValueSet", "VerificationResult", "VisionPrescription"}},
+		{name: "profile", min: 1, max: 1},
+	},
+	"ImplementationGuide.package": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "name", min: 1, max: 1},
+		{name: "description", max: 1},
+		{name: "resource", min: 1, max: -1},
+	},
+	"ImplementationGuide.package.resource": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "example", min: 1, max: 1},
+		{name: "name", max: 1},
+		{name: "description", max: 1},
+		{name: "acronym", max: 1},
+		{name: "source[x]", min: 1, max: 1, types: []string{"uri", "Reference"}},
+		{name: "exampleFor", max: 1},
+	},
+	"ImplementationGuide.page": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "source", min: 1, max: 1},
+		{name: "title", min: 1, max: 1},
+		{name: "kind", min: 1, max: 1, codes: []string{"page", "example", "list", "include", "directory", "dictionary", "toc", "resource"}},
+		{name: "type", max: -1, codes: []string{"Account", "ActivityDefinition", "AdverseEvent", "AllergyIntolerance", "Appointment", "AppointmentResponse", "AuditEvent", "Basic", "Binary", "BiologicallyDerivedProduct", "BodyStructure", "Bundle", "CapabilityStatement", "CarePlan", "CareTeam", "ChargeItem", "Claim", "ClaimResponse", "ClinicalImpression", "CodeSystem", "Communication", "CommunicationRequest", "CompartmentDefinition", "Composition", "ConceptMap", "Condition", "Consent", "Contract", "Coverage", "DetectedIssue", "Device", "DeviceComponent", "DeviceMetric", "DeviceRequest", "DeviceUseStatement", "DiagnosticReport", "DocumentManifest", "DocumentReference", "DomainResource", "EligibilityRequest", "EligibilityResponse", "Encounter", "Endpoint", "EnrollmentRequest", "EnrollmentResponse", "EntryDefinition", "EpisodeOfCare", "EventDefinition", "ExampleScenario", "ExpansionProfile", "ExplanationOfBenefit", "FamilyMemberHistory", "Flag", "Goal", "GraphDefinition", "Group", "GuidanceResponse", "HealthcareService", "ImagingStudy", "Immunization", "ImmunizationEvaluation", "ImmunizationRecommendation", "ImplementationGuide", "ImplementationGuideInput", "ImplementationGuideOutput", "Invoice", "ItemInstance", "Library", "Linkage", "List", "Location", "Measure", "MeasureReport", "Media", "Medication", "MedicationAdministration", "MedicationDispense", "MedicationRequest", "MedicationStatement", "MedicinalProduct", "MedicinalProductAuthorization", "MedicinalProductClinicals", "MedicinalProductDeviceSpec", "MedicinalProductIngredient", "MedicinalProductPackaged", "MedicinalProductPharmaceutical", "MessageDefinition", "MessageHeader", "NamingSystem", "NutritionOrder", "Observation", "ObservationDefinition", "OccupationalData", "OperationDefinition", "OperationOutcome", "Organization", "OrganizationRole", "Parameters", "Patient", "PaymentNotice", "PaymentReconciliation", "Person", "PlanDefinition", "Practitioner", "PractitionerRole", "Procedure", "ProcessRequest", "ProcessResponse", "ProductPlan", "Provenance", "Questionnaire", "QuestionnaireResponse", "RelatedPerson", "RequestGroup", "ResearchStudy", "ResearchSubject", "Resource", "RiskAssessment", "Schedule", "SearchParameter", "Sequence", "ServiceDefinition", "ServiceRequest", "Slot", "Specimen", "SpecimenDefinition", "StructureDefinition", "StructureMap", "Subscription", "Substance", "SubstancePolymer", "SubstanceReferenceInformation", "SubstanceSpecification", "SupplyDelivery", "SupplyRequest", "Task", "TerminologyCapabilities", "TestReport", "TestScript", "UserSession", "ValueSet", "VerificationResult", "VisionPrescription"}},
+		{name: "package", max: -1},
+		{name: "format", max: 1},
+		{name: "page", max: -1},
+	},
+	"Library": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "url", max: 1},
+		{name: "identifier", max: -1},
+		{name: "version", max: 1},
+		{name: "name", max: 1},
+		{name: "title", max: 1},
+		{name: "status", min: 1, max: 1, codes: []string{"draft", "active", "retired", "unknown"}},
+		{name: "experimental", max: 1},
+		{name: "type", min: 1, max: 1},
+		{name: "date", max: 1},
+		{name: "publisher", max: 1},
+		{name: "description", max: 1},
+		{name: "purpose", max: 1},
+		{name: "usage", max: 1},
+		{name: "approvalDate", max: 1},
+		{name: "lastReviewDate", max: 1},
+		{name: "effectivePeriod", max: 1},
+		{name: "useContext", max: -1},
+		{name: "jurisdiction", max: -1},
+		{name: "topic", max: -1},
+		{name: "contributor", max: -1},
+		{name: "contact", max: -1},
+		{name: "copyright", max: 1},
+		{name: "relatedArtifact", max: -1},
+		{name: "parameter", max: -1},
+		{name: "dataRequirement", max: -1},
+		{name: "content", max: -1},
+	},
+	"Linkage": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "active", max: 1},
+		{name: "author", max: 1},
+		{name: "item", min: 1, max: -1},
+	},
+	"Linkage.item": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "type", min: 1, max: 1, codes: []string{"source", "alternate", "historical"}},
+		{name: "resource", min: 1, max: 1},
+	},
+	"List": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "status", min: 1, max: 1, codes: []string{"current", "retired", "entered-in-error"}},
+		{name: "mode", min: 1, max: 1, codes: []string{"working", "snapshot", "changes"}},
+		{name: "title", max: 1},
+		{name: "code", max: 1},
+		{name: "subject", max: 1},
+		{name: "encounter", max: 1},
+		{name: "date", max: 1},
+		{name: "source", max: 1},
+		{name: "orderedBy", max: 1},
+		{name: "note", max: -1},
+		{name: "entry", max: -1},
+		{name: "emptyReason", max: 1},
+	},
+	"List.entry": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "flag", max: 1},
+		{name: "deleted", max: 1},
+		{name: "date", max: 1},
+		{name: "item", min: 1, max: 1},
+	},
+	"Location": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "status", max: 1, codes: []string{"active", "suspended", "inactive"}},
+		{name: "operationalStatus", max: 1},
+		{name: "name", max: 1},
+		{name: "alias", max: -1},
+		{name: "description", max: 1},
+		{name: "mode", max: 1, codes: []string{"instance", "kind"}},
+		{name: "type", max: 1},
+		{name: "telecom", max: -1},
+		{name: "address", max: 1},
+		{name: "physicalType", max: 1},
+		{name: "position", max: 1},
+		{name: "managingOrganization", max: 1},
+		{name: "partOf", max: 1},
+		{name: "endpoint", max: -1},
+	},
+	"Location.position": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "longitude", min: 1, max: 1},
+		{name: "latitude", min: 1, max: 1},
+		{name: "altitude", max: 1},
+	},
+	"Measure": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "url", max: 1},
+		{name: "identifier", max: -1},
+		{name: "version", max: 1},
+		{name: "name", max: 1},
+		{name: "title", max: 1},
+		{name: "status", min: 1, max: 1, codes: []string{"draft", "active", "retired", "unknown"}},
+		{name: "experimental", max: 1},
+		{name: "date", max: 1},
+		{name: "publisher", max: 1},
+		{name: "description", max: 1},
+		{name: "purpose", max: 1},
+		{name: "usage", max: 1},
+		{name: "approvalDate", max: 1},
+		{name: "lastReviewDate", max: 1},
+		{name: "effectivePeriod", max: 1},
+		{name: "useContext", max: -1},
+		{name: "jurisdiction", max: -1},
+		{name: "topic", max: -1},
+		{name: "contributor", max: -1},
+		{name: "contact", max: -1},
+		{name: "copyright", max: 1},
+		{name: "relatedArtifact", max: -1},
+		{name: "library", max: -1},
+		{name: "disclaimer", max: 1},
+		{name: "scoring", max: 1},
+		{name: "compositeScoring", max: 1},
+		{name: "type", max: -1},
+		{name: "riskAdjustment", max: 1},
+		{name: "rateAggregation", max: 1},
+		{name: "rationale", max: 1},
+		{name: "clinicalRecommendationStatement", max: 1},
+		{name: "improvementNotation", max: 1},
+		{name: "definition", max: -1},
+		{name: "guidance", max: 1},
+		{name: "set", max: 1},
+		{name: "group", max: -1},
+		{name: "supplementalData", max: -1},
+	},
+	"Measure.group": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", min: 1, max: 1},
+		{name: "name", max: 1},
+		{name: "description", max: 1},
+		{name: "population", max: -1},
+		{name: "stratifier", max: -1},
+	},
+	"Measure.group.population": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: 1},
+		{name: "code", max: 1},
+		{name: "name", max: 1},
+		{name: "description", max: 1},
+		{name: "criteria", min: 1, max: 1},
+	},
+	"Measure.group.stratifier": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: 1},
+		{name: "criteria", max: 1},
+		{name: "path", max: 1},
+	},
+	"Measure.supplementalData": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: 1},
+		{name: "usage", max: -1},
+		{name: "criteria", max: 1},
+		{name: "path", max: 1},
+	},
+	"MeasureReport": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: 1},
+		{name: "status", min: 1, max: 1, codes: []string{"complete", "pending", "error"}},
+		{name: "type", min: 1, max: 1, codes: []string{"individual", "patient-list", "summary", "subject-list"}},
+		{name: "measure", min: 1, max: 1},
+		{name: "patient", max: 1},
+		{name: "date", max: 1},
+		{name: "reportingOrganization", max: 1},
+		{name: "period", min: 1, max: 1},
+		{name: "group", max: -1},
+		{name: "evaluatedResources", max: 1},
+	},
+	"MeasureReport.group": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", min: 1, max: 1},
+		{name: "population", max: -1},
+		{name: "measureScore", max: 1},
+		{name: "stratifier", max: -1},
+	},
+	"MeasureReport.group.population": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: 1},
+		{name: "code", max: 1},
+		{name: "count", max: 1},
+		{name: "patients", max: 1},
+	},
+	"MeasureReport.group.stratifier": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: 1},
+		{name: "stratum", max: -1},
+	},
+	"MeasureReport.group.stratifier.stratum": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "value", min: 1, max: 1},
+		{name: "population", max: -1},
+		{name: "measureScore", max: 1},
+	},
+	"MeasureReport.group.stratifier.stratum.population": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: 1},
+		{name: "code", max: 1},
+		{name: "count", max: 1},
+		{name: "patients", max: 1},
+	},
+	"Media": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "basedOn", max: -1},
+		{name: "type", min: 1, max: 1, codes: []string{"photo", "video", "audio"}},
+		{name: "subtype", max: 1},
+		{name: "view", max: 1},
+		{name: "subject", max: 1},
+		{name: "context", max: 1},
+		{name: "occurrence[x]", max: 1, types: []string{"dateTime", "Period"}},
+		{name: "operator", max: 1},
+		{name: "reasonCode", max: -1},
+		{name: "bodySite", max: 1},
+		{name: "device", max: 1},
+		{name: "height", max: 1},
+		{name: "width", max: 1},
+		{name: "frames", max: 1},
+		{name: "duration", max: 1},
+		{name: "content", min: 1, max: 1},
+		{name: "note", max: -1},
+	},
+	"Medication": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "code", max: 1},
+		{name: "status", max: 1, codes: []string{"active", "inactive", "entered-in-error"}},
+		{name: "isBrand", max: 1},
+		{name: "isOverTheCounter", max: 1},
+		{name: "manufacturer", max: 1},
+		{name: "form", max: 1},
+		{name: "ingredient", max: -1},
+		{name: "package", max: 1},
+		{name: "image", max: -1},
+	},
+	"Medication.ingredient": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "item[x]", min: 1, max: 1, types: []string{"CodeableConcept", "Reference"}},
+		{name: "isActive", max: 1},
+		{name: "amount", max: 1},
+	},
+	"Medication.package": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "container", max: 1},
+		{name: "content", max: -1},
+		{name: "batch", max: -1},
+	},
+	"Medication.package.batch": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "lotNumber", max: 1},
+		{name: "expirationDate", max: 1},
+	},
+	"Medication.package.content": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "item[x]", min: 1, max: 1, types: []string{"CodeableConcept", "Reference"}},
+		{name: "amount", max: 1},
+	},
+	"MedicationAdministration": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "definition", max: -1},
+		{name: "partOf", max: -1},
+		{name: "status", min: 1, max: 1, codes: []string{"in-progress", "on-hold", "completed", "entered-in-error", "stopped", "unknown", "not-done"}},
+		{name: "category", max: 1},
+		{name: "medication[x]", min: 1, max: 1, types: []string{"CodeableConcept", "Reference"}},
+		{name: "subject", min: 1, max: 1},
+		{name: "context", max: 1},
+		{name: "supportingInformation", max: -1},
+		{name: "effective[x]", min: 1, max: 1, types: []string{"dateTime", "Period"}},
+		{name: "performer", max: -1},
+		{name: "notGiven", max: 1},
+		{name: "reasonNotGiven", max: -1},
+		{name: "reasonCode", max: -1},
+		{name: "reasonReference", max: -1},
+		{name: "prescription", max: 1},
+		{name: "device", max: -1},
+		{name: "note", max: -1},
+		{name: "dosage", max: 1},
+		{name: "eventHistory", max: -1},
+	},
+	"MedicationAdministration.dosage": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "text", max: 1},
+		{name: "site", max: 1},
+		{name: "route", max: 1},
+		{name: "method", max: 1},
+		{name: "dose", max: 1},
+		{name: "rate[x]", max: 1, types: []string{"Ratio", "Quantity"}},
+	},
+	"MedicationAdministration.performer": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "actor", min: 1, max: 1},
+		{name: "onBehalfOf", max: 1},
+	},
+	"MedicationDispense": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "partOf", max: -1},
+		{name: "status", max: 1, codes: []string{"preparation", "in-progress", "on-hold", "completed", "entered-in-error", "stopped", "not-done"}},
+		{name: "category", max: 1},
+		{name: "medication[x]", min: 1, max: 1, types: []string{"CodeableConcept", "Reference"}},
+		{name: "subject", max: 1},
+		{name: "context", max: 1},
+		{name: "supportingInformation", max: -1},
+		{name: "performer", max: -1},
+		{name: "authorizingPrescription", max: -1},
+		{name: "type", max: 1},
+		{name: "quantity", max: 1},
+		{name: "daysSupply", max: 1},
+		{name: "whenPrepared", max: 1},
+		{name: "whenHandedOver", max: 1},
+		{name: "destination", max: 1},
+		{name: "receiver", max: -1},
+		{name: "note", max: -1},
+		{name: "dosageInstruction", max: -1},
+		{name: "substitution", max: 1},
+		{name: "detectedIssue", max: -1},
+		{name: "notDone", max: 1},
+		{name: "notDoneReason[x]", max: 1, types: []string{"CodeableConcept", "Reference"}},
+		{name: "eventHistory", max: -1},
+	},
+	"MedicationDispense.performer": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "actor", min: 1, max: 1},
+		{name: "onBehalfOf", max: 1},
+	},
+	"MedicationDispense.substitution": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "wasSubstituted", min: 1, max: 1},
+		{name: "type", max: 1},
+		{name: "reason", max: -1},
+		{name: "responsibleParty", max: -1},
+	},
+	"MedicationRequest": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "definition", max: -1},
+		{name: "basedOn", max: -1},
+		{name: "groupIdentifier", max: 1},
+		{name: "status", max: 1, codes: []string{"active", "on-hold", "cancelled", "completed", "entered-in-error", "stopped", "draft", "unknown"}},
+		{name: "intent", min: 1, max: 1, codes: []string{"proposal", "plan", "order", "instance-order", "option"}},
+		{name: "category", max: 1},
+		{name: "priority", max: 1, codes: []string{"routine", "urgent", "stat", "asap"}},
+		{name: "medication[x]", min: 1, max: 1, types: []string{"CodeableConcept", "Reference"}},
+		{name: "subject", min: 1, max: 1},
+		{name: "context", max: 1},
+		{name: "supportingInformation", max: -1},
+		{name: "authoredOn", max: 1},
+		{name: "requester", max: 1},
+		{name: "recorder", max: 1},
+		{name: "reasonCode", max: -1},
+		{name: "reasonReference", max: -1},
+		{name: "note", max: -1},
+		{name: "dosageInstruction", max: -1},
+		{name: "dispenseRequest", max: 1},
+		{name: "substitution", max: 1},
+		{name: "priorPrescription", max: 1},
+		{name: "detectedIssue", max: -1},
+		{name: "eventHistory", max: -1},
+	},
+	"MedicationRequest.dispenseRequest": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "validityPeriod", max: 1},
+		{name: "numberOfRepeatsAllowed", max: 1},
+		{name: "quantity", max: 1},
+		{name: "expectedSupplyDuration", max: 1},
+		{name: "performer", max: 1},
+	},
+	"MedicationRequest.requester": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "agent", min: 1, max: 1},
+		{name: "onBehalfOf", max: 1},
+	},
+	"MedicationRequest.substitution": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "allowed", min: 1, max: 1},
+		{name: "reason", max: 1},
+	},
+	"MedicationStatement": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "basedOn", max: -1},
+		{name: "partOf", max: -1},
+		{name: "context", max: 1},
+		{name: "status", min: 1, max: 1, codes: []string{"active", "completed", "entered-in-error", "intended", "stopped", "on-hold", "unknown", "not-taken"}},
+		{name: "category", max: 1},
+		{name: "medication[x]", min: 1, max: 1, types: []string{"CodeableConcept", "Reference"}},
+		{name: "effective[x]", max: 1, types: []string{"dateTime", "Period"}},
+		{name: "dateAsserted", max: 1},
+		{name: "informationSource", max: 1},
+		{name: "subject", min: 1, max: 1},
+		{name: "derivedFrom", max: -1},
+		{name: "taken", min: 1, max: 1, codes: []string{"y", "n", "unk", "na"}},
+		{name: "reasonNotTaken", max: -1},
+		{name: "reasonCode", max: -1},
+		{name: "reasonReference", max: -1},
+		{name: "note", max: -1},
+		{name: "dosage", max: -1},
+	},
+	"MessageDefinition": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "url", max: 1},
+		{name: "identifier", max: 1},
+		{name: "version", max: 1},
+		{name: "name", max: 1},
+		{name: "title", max: 1},
+		{name: "status", min: 1, max: 1, codes: []string{"draft", "active", "retired", "unknown"}},
+		{name: "experimental", max: 1},
+		{name: "date", min: 1, max: 1},
+		{name: "publisher", max: 1},
+		{name: "contact", max: -1},
+		{name: "description", max: 1},
+		{name: "useContext", max: -1},
+		{name: "jurisdiction", max: -1},
+		{name: "purpose", max: 1},
+		{name: "copyright", max: 1},
+		{name: "base", max: 1},
+		{name: "parent", max: -1},
+		{name: "replaces", max: -1},
+		{name: "event", min: 1, max: 1},
+		{name: "category", max: 1, codes: []string{"Consequence", "Currency", "Notification"}},
+		{name: "focus", max: -1},
+		{name: "responseRequired", max: 1},
+		{name: "allowedResponse", max: -1},
+	},
+	"MessageDefinition.allowedResponse": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "message", min: 1, max: 1},
+		{name: "situation", max: 1},
+	},
+	"MessageDefinition.focus": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "code", min: 1, max: 1, codes: []string{"Account", "ActivityDefinition", "AdverseEvent", "AllergyIntolerance", "Appointment", "AppointmentResponse", "AuditEvent", "Basic", "Binary", "BiologicallyDerivedProduct", "BodyStructure", "Bundle", "CapabilityStatement", "CarePlan", "CareTeam", "ChargeItem", "Claim", "ClaimResponse", "ClinicalImpression", "CodeSystem", "Communication", "CommunicationRequest", "CompartmentDefinition", "Composition", "ConceptMap", "Condition", "Consent", "Contract", "Coverage", "DetectedIssue", "Device", "DeviceComponent", "DeviceMetric", "DeviceRequest", "DeviceUseStatement", "DiagnosticReport", "DocumentManifest", "DocumentReference", "DomainResource", "EligibilityRequest", "EligibilityResponse", "Encounter", "Endpoint", "EnrollmentRequest", "EnrollmentResponse", "EntryDefinition", "EpisodeOfCare", "EventDefinition", "ExampleScenario", "ExpansionProfile", "ExplanationOfBenefit", "FamilyMemberHistory", "Flag", "Goal", "GraphDefinition", "Group", "GuidanceResponse", "HealthcareService", "ImagingStudy", "Immunization", "ImmunizationEvaluation", "ImmunizationRecommendation", "ImplementationGuide", "ImplementationGuideInput", "ImplementationGuideOutput", "Invoice", "ItemInstance", "Library", "Linkage", "List", "Location", "Measure", "MeasureReport", "Media", "Medication", "MedicationAdministration", "MedicationDispense", "MedicationRequest", "MedicationStatement", "MedicinalProduct", "MedicinalProductAuthorization", "MedicinalProductClinicals", "MedicinalProductDeviceSpec", "MedicinalProductIngredient", "MedicinalProductPackaged", "MedicinalProductPharmaceutical", "MessageDefinition", "MessageHeader", "NamingSystem", "NutritionOrder", "Observation", "ObservationDefinition", "OccupationalData", "OperationDefinition", "OperationOutcome", "Organization", "OrganizationRole", "Parameters", "Patient", "PaymentNotice", "PaymentReconciliation", "Person", "PlanDefinition", "Practitioner", "PractitionerRole", "Procedure", "ProcessRequest", "ProcessResponse", "ProductPlan", "Provenance", "Questionnaire", "QuestionnaireResponse", "RelatedPerson", "RequestGroup", "ResearchStudy", "ResearchSubject", "Resource", "RiskAssessment", "Schedule", "SearchParameter", "Sequence", "ServiceDefinition", "ServiceRequest", "Slot", "Specimen", "SpecimenDefinition", "StructureDefinition", "StructureMap", "Subscription", "Substance", "SubstancePolymer", "SubstanceReferenceInformation", "SubstanceSpecification", "SupplyDelivery", "SupplyRequest", "Task", "TerminologyCapabilities", "TestReport", "TestScript", "UserSession", "ValueSet", "VerificationResult", "VisionPrescription"}},
+		{name: "profile", max: 1},
+		{name: "min", max: 1},
+		{name: "max", max: 1},
+	},
+	"MessageHeader": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "event", min: 1, max: 1},
+		{name: "destination", max: -1},
+		{name: "receiver", max: 1},
+		{name: "sender", max: 1},
+		{name: "timestamp", min: 1, max: 1},
+		{name: "enterer", max: 1},
+		{name: "author", max: 1},
+		{name: "source", min: 1, max: 1},
+		{name: "responsible", max: 1},
+		{name: "reason", max: 1},
+		{name: "response", max: 1},
+		{name: "focus", max: -1},
+	},
+	"MessageHeader.destination": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "name", max: 1},
+		{name: "target", max: 1},
+		{name: "endpoint", min: 1, max: 1},
+	},
+	"MessageHeader.response": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", min: 1, max: 1},
+		{name: "code", min: 1, max: 1, codes: []string{"ok", "transient-error", "fatal-error"}},
+		{name: "details", max: 1},
+	},
+	"MessageHeader.source": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "name", max: 1},
+		{name: "software", max: 1},
+		{name: "version", max: 1},
+		{name: "contact", max: 1},
+		{name: "endpoint", min: 1, max: 1},
+	},
+	"Meta": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "versionId", max: 1},
+		{name: "lastUpdated", max: 1},
+		{name: "profile", max: -1},
+		{name: "security", max: -1},
+		{name: "tag", max: -1},
+	},
+	"Money": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "value", max: 1},
+		{name: "comparator", max: 1, codes: []string{"<", "<=", ">=", ">"}},
+		{name: "unit", max: 1},
+		{name: "system", max: 1},
+		{name: "code", max: 1},
+	},
+	"NamingSystem": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "name", min: 1, max: 1},
+		{name: "status", min: 1, max: 1, codes: []string{"draft", "active", "retired", "unknown"}},
+		{name: "kind", min: 1, max: 1, codes: []string{"codesystem", "identifier", "root"}},
+		{name: "date", min: 1, max: 1},
+		{name: "publisher", max: 1},
+		{name: "contact", max: -1},
+		{name: "responsible", max: 1},
+		{name: "type", max: 1},
+		{name: "description", max: 1},
+		{name: "useContext", max: -1},
+		{name: "jurisdiction", max: -1},
+		{name: "usage", max: 1},
+		{name: "uniqueId", min: 1, max: -1},
+		{name: "replacedBy", max: 1},
+	},
+	"NamingSystem.uniqueId": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "type", min: 1, max: 1, codes: []string{"oid", "uuid", "uri", "other"}},
+		{name: "value", min: 1, max: 1},
+		{name: "preferred", max: 1},
+		{name: "comment", max: 1},
+		{name: "period", max: 1},
+	},
+	"Narrative": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "status", min: 1, max: 1, codes: []string{"generated", "extensions", "additional", "empty"}},
+		{name: "div", min: 1, max: 1},
+	},
+	"NutritionOrder": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "status", max: 1, codes: []string{"proposed", "draft", "planned", "requested", "active", "on-hold", "completed", "cancelled", "entered-in-error"}},
+		{name: "patient", min: 1, max: 1},
+		{name: "encounter", max: 1},
+		{name: "dateTime", min: 1, max: 1},
+		{name: "orderer", max: 1},
+		{name: "allergyIntolerance", max: -1},
+		{name: "foodPreferenceModifier", max: -1},
+		{name: "excludeFoodModifier", max: -1},
+		{name: "oralDiet", max: 1},
+		{name: "supplement", max: -1},
+		{name: "enteralFormula", max: 1},
+	},
+	"NutritionOrder.enteralFormula": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "baseFormulaType", max: 1},
+		{name: "baseFormulaProductName", max: 1},
+		{name: "additiveType", max: 1},
+		{name: "additiveProductName", max: 1},
+		{name: "caloricDensity", max: 1},
+		{name: "routeofAdministration", max: 1},
+		{name: "administration", max: -1},
+		{name: "maxVolumeToDeliver", max: 1},
+		{name: "administrationInstruction", max: 1},
+	},
+	"NutritionOrder.enteralFormula.administration": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "schedule", max: 1},
+		{name: "quantity", max: 1},
+		{name: "rate[x]", max: 1, types: []string{"Quantity", "Ratio"}},
+	},
+	"NutritionOrder.oralDiet": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "type", max: -1},
+		{name: "schedule", max: -1},
+		{name: "nutrient", max: -1},
+		{name: "texture", max: -1},
+		{name: "fluidConsistencyType", max: -1},
+		{name: "instruction", max: 1},
+	},
+	"NutritionOrder.oralDiet.nutrient": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "modifier", max: 1},
+		{name: "amount", max: 1},
+	},
+	"NutritionOrder.oralDiet.texture": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "modifier", max: 1},
+		{name: "foodType", max: 1},
+	},
+	"NutritionOrder.supplement": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "type", max: 1},
+		{name: "productName", max: 1},
+		{name: "schedule", max: -1},
+		{name: "quantity", max: 1},
+		{name: "instruction", max: 1},
+	},
+	"Observation": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "basedOn", max: -1},
+		{name: "status", min: 1, max: 1},
+		{name: "category", max: -1},
+		{name: "code", min: 1, max: 1},
+		{name: "subject", max: 1},
+		{name: "context", max: 1},
+		{name: "effective[x]", max: 1, types: []string{"dateTime", "Period"}},
+		{name: "issued", max: 1},
+		{name: "performer", max: -1},
+		{name: "value[x]", max: 1, types: []string{"Quantity", "CodeableConcept", "string", "boolean", "Range", "Ratio", "SampledData", "Attachment", "time", "dateTime", "Period"}},
+		{name: "dataAbsentReason", max: 1},
+		{name: "interpretation", max: 1},
+		{name: "comment", max: 1},
+		{name: "bodySite", max: 1},
+		{name: "method", max: 1},
+		{name: "specimen", max: 1},
+		{name: "device", max: 1},
+		{name: "referenceRange", max: -1},
+		{name: "related", max: -1},
+		{name: "component", max: -1},
+	},
+	"Observation.component": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "code", min: 1, max: 1},
+		{name: "value[x]", max: 1, types: []string{"Quantity", "CodeableConcept", "string", "Range", "Ratio", "SampledData", "Attachment", "time", "dateTime", "Period"}},
+		{name: "dataAbsentReason", max: 1},
+		{name: "interpretation", max: 1},
+		{name: "referenceRange", max: -1},
+	},
+	"Observation.referenceRange": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "low", max: 1},
+		{name: "high", max: 1},
+		{name: "type", max: 1},
+		{name: "appliesTo", max: -1},
+		{name: "age", max: 1},
+		{name: "text", max: 1},
+	},
+	"Observation.related": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "type", max: 1, codes: []string{"has-member", "derived-from", "sequel-to", "replaces", "qualified-by", "interfered-by"}},
+		{name: "target", min: 1, max: 1},
+	},
+	"OperationDefinition": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "url", max: 1},
+		{name: "version", max: 1},
+		{name: "name", min: 1, max: 1},
+		{name: "status", min: 1, max: 1, codes: []string{"draft", "active", "retired", "unknown"}},
+		{name: "kind", min: 1, max: 1, codes: []string{"operation", "query"}},
+		{name: "experimental", max: 1},
+		{name: "date", max: 1},
+		{name: "publisher", max: 1},
+		{name: "contact", max: -1},
+		{name: "description", max: 1},
+		{name: "useContext", max: -1},
+		{name: "jurisdiction", max: -1},
+		{name: "purpose", max: 1},
+		{name: "idempotent", max: 1},
+		{name: "code", min: 1, max: 1},
+		{name: "comment", max: 1},
+		{name: "base", max: 1},
+		{name: "resource", max: -1, codes: []string{"Account", "ActivityDefinition", "AdverseEvent", "AllergyIntolerance", "Appointment", "AppointmentResponse", "AuditEvent", "Basic", "Binary", "BiologicallyDerivedProduct", "BodyStructure", "Bundle", "CapabilityStatement", "CarePlan", "CareTeam", "ChargeItem", "Claim", "ClaimResponse", "ClinicalImpression", "CodeSystem", "Communication", "CommunicationRequest", "CompartmentDefinition", "Composition", "ConceptMap", "Condition", "Consent", "Contract", "Coverage", "DetectedIssue", "Device", "DeviceComponent", "DeviceMetric", "DeviceRequest", "DeviceUseStatement", "DiagnosticReport", "DocumentManifest", "DocumentReference", "DomainResource", "EligibilityRequest", "EligibilityResponse", "Encounter", "Endpoint", "EnrollmentRequest", "EnrollmentResponse", "EntryDefinition", "EpisodeOfCare", "EventDefinition", "ExampleScenario", "ExpansionProfile", "ExplanationOfBenefit", "FamilyMemberHistory", "Flag", "Goal", "GraphDefinition", "Group", "GuidanceResponse", "HealthcareService", "ImagingStudy", "Immunization", "ImmunizationEvaluation", "ImmunizationRecommendation", "ImplementationGuide", "ImplementationGuideInput", "ImplementationGuideOutput", "Invoice", "ItemInstance", "Library", "Linkage", "List", "Location", "Measure", "MeasureReport", "Media", "Medication", "MedicationAdministration", "MedicationDispense", "MedicationRequest", "MedicationStatement", "MedicinalProduct", "MedicinalProductAuthorization", "MedicinalProductClinicals", "MedicinalProductDeviceSpec", "MedicinalProductIngredient", "MedicinalProductPackaged", "MedicinalProductPharmaceutical", "MessageDefinition", "MessageHeader", "NamingSystem", "NutritionOrder", "Observation", "ObservationDefinition", "OccupationalData", "OperationDefinition", "OperationOutcome", "Organization", "OrganizationRole", "Parameters", "Patient", "PaymentNotice", "PaymentReconciliation", "Person", "PlanDefinition", "Practitioner", "PractitionerRole", "Procedure", "ProcessRequest", "ProcessResponse", "ProductPlan", "Provenance", "Questionnaire", "QuestionnaireResponse", "RelatedPerson", "RequestGroup", "ResearchStudy", "ResearchSubject", "Resource", "RiskAssessment", "Schedule", "SearchParameter", "Sequence", "ServiceDefinition", "ServiceRequest", "Slot", "Specimen", "SpecimenDefinition", "StructureDefinition", "StructureMap", "Subscription", "Substance", "SubstancePolymer", "SubstanceReferenceInformation", "SubstanceSpecification", "SupplyDelivery", "SupplyRequest", "Task", "TerminologyCapabilities", "TestReport", "TestScript", "UserSession", "ValueSet", "VerificationResult", "VisionPrescription"}},
+		{name: "system", min: 1, max: 1},
+		{name: "type", min: 1, max: 1},
+		{name: "instance", min: 1, max: 1},
+		{name: "parameter", max: -1},
+		{name: "overload", max: -1},
+	},
+	"OperationDefinition.overload": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "parameterName", max: -1},
+		{name: "comment", max: 1},
+	},
+	"OperationDefinition.parameter": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "name", min: 1, max: 1},
+		{name: "use", min: 1, max: 1, codes: []string{"in", "out"}},
+		{name: "min", min: 1, max: 1},
+		{name: "max", min: 1, max: 1},
+		{name: "documentation", max: 1},
+		{name: "type", max: 1, codes: []string{"Address", "Age", "Annotation", "Attachment", "BackboneElement", "CodeableConcept", "Coding", "ContactDetail", "ContactPoint", "Contributor", "Count", "DataRequirement", "Distance", "Dosage", "Duration", "Element", "ElementDefinition", "Extension", "HumanName", "Identifier", "MarketingStatus", "Meta", "Money", "Narrative", "ParameterDefinition", "Period", "ProdCharacteristic", "ProductShelfLife", "Quantity", "Range", "Ratio", "Reference", "RelatedArtifact", "SampledData", "Signature", "SimpleQuantity", "SubstanceAmount", "SubstanceMoiety", "Timing", "TriggerDefinition", "UsageContext", "base64Binary", "boolean", "code", "date", "dateTime", "decimal", "id", "instant", "integer", "markdown", "oid", "positiveInt", "string", "time", "unsignedInt", "uri", "uuid", "xhtml", "Account", "ActivityDefinition", "AdverseEvent", "AllergyIntolerance", "Appointment", "AppointmentResponse", "AuditEvent", "Basic", "Binary", "BiologicallyDerivedProduct", "BodyStructure", "Bundle", "CapabilityStatement", "CarePlan", "CareTeam", "ChargeItem", "Claim", "ClaimResponse", "ClinicalImpression", "CodeSystem", "Communication", "CommunicationRequest", "CompartmentDefinition", "Composition", "ConceptMap", "Condition", "Consent", "Contract", "Coverage", "DetectedIssue", "Device", "DeviceComponent", "DeviceMetric", "DeviceRequest", "DeviceUseStatement", "DiagnosticReport", "DocumentManifest", "DocumentReference", "DomainResource", "EligibilityRequest", "EligibilityResponse", "Encounter", "Endpoint", "EnrollmentRequest", "EnrollmentResponse", "EntryDefinition", "EpisodeOfCare", "EventDefinition", "ExampleScenario", "ExpansionProfile", "ExplanationOfBenefit", "FamilyMemberHistory", "Flag", "Goal", "GraphDefinition", "Group", "GuidanceResponse", "HealthcareService", "ImagingStudy", "Immunization", "ImmunizationEvaluation", "ImmunizationRecommendation", "ImplementationGuide", "ImplementationGuideInput", "ImplementationGuideOutput", "Invoice", "ItemInstance", "Library", "Linkage", "List", "Location", "Measure", "MeasureReport", "Media", "Medication", "MedicationAdministration", "MedicationDispense", "MedicationRequest", "MedicationStatement", "MedicinalProduct", "MedicinalProductAuthorization", "MedicinalProductClinicals", "MedicinalProductDeviceSpec", "MedicinalProductIngredient", "MedicinalProductPackaged", "MedicinalProductPharmaceutical", "MessageDefinition", "MessageHeader", "NamingSystem", "NutritionOrder", "Observation", "ObservationDefinition", "OccupationalData", "OperationDefinition", "OperationOutcome", "Organization", "OrganizationRole", "Parameters", "Patient", "PaymentNotice", "PaymentReconciliation", "Person", "PlanDefinition", "Practitioner", "PractitionerRole", "Procedure", "ProcessRequest", "ProcessResponse", "ProductPlan", "Provenance", "Questionnaire", "QuestionnaireResponse", "RelatedPerson", "RequestGroup", "ResearchStudy", "ResearchSubject", "Resource", "RiskAssessment", "Schedule", "SearchParameter", "Sequence", "ServiceDefinition", "ServiceRequest", "Slot", "Specimen", "SpecimenDefinition", "StructureDefinition", "StructureMap", "Subscription", "Substance", "SubstancePolymer", "SubstanceReferenceInformation", "SubstanceSpecification", "SupplyDelivery", "SupplyRequest", "Task", "TerminologyCapabilities", "TestReport", "TestScript", "UserSession", "ValueSet", "VerificationResult", "VisionPrescription", "Type", "Any"}},
+		{name: "searchType", max: 1, codes: []string{"number", "date", "string", "token", "reference", "composite", "quantity", "uri"}},
+		{name: "profile", max: 1},
+		{name: "binding", max: 1},
+		{name: "part", max: -1},
+	},
+	"OperationDefinition.parameter.binding": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "strength", min: 1, max: 1, codes: []string{"required", "extensible", "preferred", "example"}},
+		{name: "valueSet[x]", min: 1, max: 1, types: []string{"uri", "Reference"}},
+	},
+	"OperationOutcome": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "issue", min: 1, max: -1},
+	},
+	"OperationOutcome.issue": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "severity", min: 1, max: 1, codes: []string{"fatal", "error", "warning", "information"}},
+		{name: "code", min: 1, max: 1, codes: []string{"invalid", "security", "processing", "transient", "informational"}},
+		{name: "details", max: 1},
+		{name: "diagnostics", max: 1},
+		{name: "location", max: -1},
+		{name: "expression", max: -1},
+	},
+	"Organization": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "active", max: 1},
+		{name: "type", max: -1},
+		{name: "name", max: 1},
+		{name: "alias", max: -1},
+		{name: "telecom", max: -1},
+		{name: "address", max: -1},
+		{name: "partOf", max: 1},
+		{name: "contact", max: -1},
+		{name: "endpoint", max: -1},
+	},
+	"Organization.contact": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "purpose", max: 1},
+		{name: "name", max: 1},
+		{name: "telecom", max: -1},
+		{name: "address", max: 1},
+	},
+	"ParameterDefinition": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "name", max: 1},
+		{name: "use", min: 1, max: 1, codes: []string{"in", "out"}},
+		{name: "min", max: 1},
+		{name: "max", max: 1},
+		{name: "documentation", max: 1},
+		{name: "type", min: 1, max: 1, codes: []string{"Address", "Age", "Annotation", "Attachment", "BackboneElement", "CodeableConcept", "Coding", "ContactDetail", "ContactPoint", "Contributor", "Count", "DataRequirement", "Distance", "Dosage", "Duration", "Element", "ElementDefinition", "Extension", "HumanName", "Identifier", "MarketingStatus", "Meta", "Money", "Narrative", "ParameterDefinition", "Period", "ProdCharacteristic", "ProductShelfLife", "Quantity", "Range", "Ratio", "Reference", "RelatedArtifact", "SampledData", "Signature", "SimpleQuantity", "SubstanceAmount", "SubstanceMoiety", "Timing", "TriggerDefinition", "UsageContext", "base64Binary", "boolean", "code", "date", "dateTime", "decimal", "id", "instant", "integer", "markdown", "oid", "positiveInt", "string", "time", "unsignedInt", "uri", "uuid", "xhtml", "Account", "ActivityDefinition", "AdverseEvent", "AllergyIntolerance", "Appointment", "AppointmentResponse", "AuditEvent", "Basic", "Binary", "BiologicallyDerivedProduct", "BodyStructure", "Bundle", "CapabilityStatement", "CarePlan", "CareTeam", "ChargeItem", "Claim", "ClaimResponse", "ClinicalImpression", "CodeSystem", "Communication", "CommunicationRequest", "CompartmentDefinition", "Composition", "ConceptMap", "Condition", "Consent", "Contract", "Coverage", "DetectedIssue", "Device", "DeviceComponent", "DeviceMetric", "DeviceRequest", "DeviceUseStatement", "DiagnosticReport", "DocumentManifest", "DocumentReference", "DomainResource", "EligibilityRequest", "EligibilityResponse", "Encounter", "Endpoint", "EnrollmentRequest", "EnrollmentResponse", "EntryDefinition", "EpisodeOfCare", "EventDefinition", "ExampleScenario", "ExpansionProfile", "ExplanationOfBenefit", "FamilyMemberHistory", "Flag", "Goal", "GraphDefinition", "Group", "GuidanceResponse", "HealthcareService", "ImagingStudy", "Immunization", "ImmunizationEvaluation", "ImmunizationRecommendation", "ImplementationGuide", "ImplementationGuideInput", "ImplementationGuideOutput", "Invoice", "ItemInstance", "Library", "Linkage", "List", "Location", "Measure", "MeasureReport", "Media", "Medication", "MedicationAdministration", "MedicationDispense", "MedicationRequest", "MedicationStatement", "MedicinalProduct", "MedicinalProductAuthorization", "MedicinalProductClinicals", "MedicinalProductDeviceSpec", "MedicinalProductIngredient", "MedicinalProductPackaged", "MedicinalProductPharmaceutical", "MessageDefinition", "MessageHeader", "NamingSystem", "NutritionOrder", "Observation", "ObservationDefinition", "OccupationalData", "OperationDefinition", "OperationOutcome", "Organization", "OrganizationRole", "Parameters", "Patient", "PaymentNotice", "PaymentReconciliation", "Person", "PlanDefinition", "Practitioner", "PractitionerRole", "Procedure", "ProcessRequest", "ProcessResponse", "ProductPlan", "Provenance", "Questionnaire", "QuestionnaireResponse", "RelatedPerson", "RequestGroup", "ResearchStudy", "ResearchSubject", "Resource", "RiskAssessment", "Schedule", "SearchParameter", "Sequence", "ServiceDefinition", "ServiceRequest", "Slot", "Specimen", "SpecimenDefinition", "StructureDefinition", "StructureMap", "Subscription", "Substance", "SubstancePolymer", "SubstanceReferenceInformation", "SubstanceSpecification", "SupplyDelivery", "SupplyRequest", "Task", "TerminologyCapabilities", "TestReport", "TestScript", "UserSession", "ValueSet", "VerificationResult", "VisionPrescription", "Type", "Any"}},
+		{name: "profile", max: 1},
+	},
+	"Parameters": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "parameter", max: -1},
+	},
+	"Parameters.parameter": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "name", min: 1, max: 1},
+		{name: "value[x]", max: 1, types: []string{"base64Binary", "boolean", "code", "date", "dateTime", "decimal", "id", "instant", "integer", "markdown", "oid", "positiveInt", "string", "time", "unsignedInt", "uri", "Address", "Age", "Annotation", "Attachment", "CodeableConcept", "Coding", "ContactPoint", "Count", "Distance", "Duration", "HumanName", "Identifier", "Money", "Period", "Quantity", "Range", "Ratio", "Reference", "SampledData", "Signature", "Timing", "Meta"}},
+		{name: "resource", max: 1},
+		{name: "part", max: -1},
+	},
+	"Patient": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "active", max: 1},
+		{name: "name", max: -1},
+		{name: "telecom", max: -1},
+		{name: "gender", max: 1, codes: []string{"male", "female", "other", "unknown"}},
+		{name: "birthDate", max: 1},
+		{name: "deceased[x]", max: 1, types: []string{"boolean", "dateTime"}},
+		{name: "address", max: -1},
+		{name: "maritalStatus", max: 1},
+		{name: "multipleBirth[x]", max: 1, types: []string{"boolean", "integer"}},
+		{name: "photo", max: -1},
+		{name: "contact", max: -1},
+		{name: "animal", max: 1},
+		{name: "communication", max: -1},
+		{name: "generalPractitioner", max: -1},
+		{name: "managingOrganization", max: 1},
+		{name: "link", max: -1},
+	},
+	"Patient.animal": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "species", min: 1, max: 1},
+		{name: "breed", max: 1},
+		{name: "genderStatus", max: 1},
+	},
+	"Patient.communication": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "language", min: 1, max: 1},
+		{name: "preferred", max: 1},
+	},
+	"Patient.contact": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "relationship", max: -1},
+		{name: "name", max: 1},
+		{name: "telecom", max: -1},
+		{name: "address", max: 1},
+		{name: "gender", max: 1, codes: []string{"male", "female", "other", "unknown"}},
+		{name: "organization", max: 1},
+		{name: "period", max: 1},
+	},
+	"Patient.link": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "other", min: 1, max: 1},
+		{name: "type", min: 1, max: 1, codes: []string{"replaced-by", "replaces", "refer", "seealso"}},
+	},
+	"PaymentNotice": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "status", max: 1, codes: []string{"active", "cancelled", "draft", "entered-in-error"}},
+		{name: "request", max: 1},
+		{name: "response", max: 1},
+		{name: "statusDate", max: 1},
+		{name: "created", max: 1},
+		{name: "target", max: 1},
+		{name: "provider", max: 1},
+		{name: "organization", max: 1},
+		{name: "paymentStatus", max: 1},
+	},
+	"PaymentReconciliation": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "status", max: 1, codes: []string{"active", "cancelled", "draft", "entered-in-error"}},
+		{name: "period", max: 1},
+		{name: "created", max: 1},
+		{name: "organization", max: 1},
+		{name: "request", max: 1},
+		{name: "outcome", max: 1, codes: []string{"http://hl7.org/fhir/remittance-outcome|complete", "http://hl7.org/fhir/remittance-outcome|error", "http://hl7.org/fhir/remittance-outcome|partial"}},
+		{name: "disposition", max: 1},
+		{name: "requestProvider", max: 1},
+		{name: "requestOrganization", max: 1},
+		{name: "detail", max: -1},
+		{name: "form", max: 1},
+		{name: "total", max: 1},
+		{name: "processNote", max: -1},
+	},
+	"PaymentReconciliation.detail": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "type", min: 1, max: 1},
+		{name: "request", max: 1},
+		{name: "response", max: 1},
+		{name: "submitter", max: 1},
+		{name: "payee", max: 1},
+		{name: "date", max: 1},
+		{name: "amount", max: 1},
+	},
+	"PaymentReconciliation.processNote": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "type", max: 1, codes: []string{"http://hl7.org/fhir/note-type|display", "http://hl7.org/fhir/note-type|print", "http://hl7.org/fhir/note-type|printoper"}},
+		{name: "text", max: 1},
+	},
+	"Period": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "start", max: 1},
+		{name: "end", max: 1},
+	},
+	"Person": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "name", max: -1},
+		{name: "telecom", max: -1},
+		{name: "gender", max: 1, codes: []string{"male", "female", "other", "unknown"}},
+		{name: "birthDate", max: 1},
+		{name: "address", max: -1},
+		{name: "photo", max: 1},
+		{name: "managingOrganization", max: 1},
+		{name: "active", max: 1},
+		{name: "link", max: -1},
+	},
+	"Person.link": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "target", min: 1, max: 1},
+		{name: "assurance", max: 1, codes: []string{"level1", "level2", "level3", "level4"}},
+	},
+	"PlanDefinition": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "url", max: 1},
+		{name: "identifier", max: -1},
+		{name: "version", max: 1},
+		{name: "name", max: 1},
+		{name: "title", max: 1},
+		{name: "type", max: 1},
+		{name: "status", min: 1, max: 1, codes: []string{"draft", "active", "retired", "unknown"}},
+		{name: "experimental", max: 1},
+		{name: "date", max: 1},
+		{name: "publisher", max: 1},
+		{name: "description", max: 1},
+		{name: "purpose", max: 1},
+		{name: "usage", max: 1},
+		{name: "approvalDate", max: 1},
+		{name: "lastReviewDate", max: 1},
+		{name: "effectivePeriod", max: 1},
+		{name: "useContext", max: -1},
+		{name: "jurisdiction", max: -1},
+		{name: "topic", max: -1},
+		{name: "contributor", max: -1},
+		{name: "contact", max: -1},
+		{name: "copyright", max: 1},
+		{name: "relatedArtifact", max: -1},
+		{name: "library", max: -1},
+		{name: "goal", max: -1},
+		{name: "action", max: -1},
+	},
+	"PlanDefinition.action": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "label", max: 1},
+		{name: "title", max: 1},
+		{name: "description", max: 1},
+		{name: "textEquivalent", max: 1},
+		{name: "code", max: -1},
+		{name: "reason", max: -1},
+		{name: "documentation", max: -1},
+		{name: "goalId", max: -1},
+		{name: "triggerDefinition", max: -1},
+		{name: "condition", max: -1},
+		{name: "input", max: -1},
+		{name: "output", max: -1},
+		{name: "relatedAction", max: -1},
+		{name: "timing[x]", max: 1, types: []string{"dateTime", "Period", "Duration", "Range", "Timing"}},
+		{name: "participant", max: -1},
+		{name: "type", max: 1, codes: []string{"http://hl7.org/fhir/action-type|create", "http://hl7.org/fhir/action-type|update", "http://hl7.org/fhir/action-type|remove", "http://hl7.org/fhir/action-type|fire-event"}},
+		{name: "groupingBehavior", max: 1, codes: []string{"visual-group", "logical-group", "sentence-group"}},
+		{name: "selectionBehavior", max: 1, codes: []string{"any", "all", "all-or-none", "exactly-one", "at-most-one", "one-or-more"}},
+		{name: "requiredBehavior", max: 1, codes: []string{"must", "could", "must-unless-documented"}},
+		{name: "precheckBehavior", max: 1, codes: []string{"yes", "no"}},
+		{name: "cardinalityBehavior", max: 1, codes: []string{"single", "multiple"}},
+		{name: "definition", max: 1},
+		{name: "transform", max: 1},
+		{name: "dynamicValue", max: -1},
+		{name: "action", max: -1},
+	},
+	"PlanDefinition.action.condition": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "kind", min: 1, max: 1, codes: []string{"applicability", "start", "stop"}},
+		{name: "description", max: 1},
+		{name: "language", max: 1},
+		{name: "expression", max: 1},
+	},
+	"PlanDefinition.action.dynamicValue": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "description", max: 1},
+		{name: "path", max: 1},
+		{name: "language", max: 1},
+		{name: "expression", max: 1},
+	},
+	"PlanDefinition.action.participant": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "type", min: 1, max: 1, codes: []string{"patient", "practitioner", "related-person"}},
+		{name: "role", max: 1},
+	},
+	"PlanDefinition.action.relatedAction": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "actionId", min: 1, max: 1},
+		{name: "relationship", min: 1, max: 1, codes: []string{"before-start", "before", "before-end", "concurrent-with-start", "concurrent", "concurrent-with-end", "after-start", "after", "after-end"}},
+		{name: "offset[x]", max: 1, types: []string{"Duration", "Range"}},
+	},
+	"PlanDefinition.goal": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "category", max: 1},
+		{name: "description", min: 1, max: 1},
+		{name: "priority", max: 1},
+		{name: "start", max: 1},
+		{name: "addresses", max: -1},
+		{name: "documentation", max: -1},
+		{name: "target", max: -1},
+	},
+	"PlanDefinition.goal.target": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "measure", max: 1},
+		{name: "detail[x]", max: 1, types: []string{"Quantity", "Range", "CodeableConcept"}},
+		{name: "due", max: 1},
+	},
+	"Practitioner": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "active", max: 1},
+		{name: "name", max: -1},
+		{name: "telecom", max: -1},
+		{name: "address", max: -1},
+		{name: "gender", max: 1, codes: []string{"male", "female", "other", "unknown"}},
+		{name: "birthDate", max: 1},
+		{name: "photo", max: -1},
+		{name: "qualification", max: -1},
+		{name: "communication", max: -1},
+	},
+	"Practitioner.qualification": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "code", min: 1, max: 1},
+		{name: "period", max: 1},
+		{name: "issuer", max: 1},
+	},
+	"PractitionerRole": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "active", max: 1},
+		{name: "period", max: 1},
+		{name: "practitioner", max: 1},
+		{name: "organization", max: 1},
+		{name: "code", max: -1},
+		{name: "specialty", max: -1},
+		{name: "location", max: -1},
+		{name: "healthcareService", max: -1},
+		{name: "telecom", max: -1},
+		{name: "availableTime", max: -1},
+		{name: "notAvailable", max: -1},
+		{name: "availabilityExceptions", max: 1},
+		{name: "endpoint", max: -1},
+	},
+	"PractitionerRole.availableTime": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "daysOfWeek", max: -1, codes: []string{"mon", "tue", "wed", "thu", "fri", "sat", "sun"}},
+		{name: "allDay", max: 1},
+		{name: "availableStartTime", max: 1},
+		{name: "availableEndTime", max: 1},
+	},
+	"PractitionerRole.notAvailable": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "description", min: 1, max: 1},
+		{name: "during", max: 1},
+	},
+	"Procedure": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "definition", max: -1},
+		{name: "basedOn", max: -1},
+		{name: "partOf", max: -1},
+		{name: "status", min: 1, max: 1, codes: []string{"preparation", "in-progress", "suspended", "aborted", "completed", "entered-in-error", "unknown", "not-done"}},
+		{name: "notDone", max: 1},
+		{name: "notDoneReason", max: 1},
+		{name: "category", max: 1},
+		{name: "code", max: 1},
+		{name: "subject", min: 1, max: 1},
+		{name: "context", max: 1},
+		{name: "performed[x]", max: 1, types: []string{"dateTime", "Period"}},
+		{name: "performer", max: -1},
+		{name: "location", max: 1},
+		{name: "reasonCode", max: -1},
+		{name: "reasonReference", max: -1},
+		{name: "bodySite", max: -1},
+		{name: "outcome", max: 1},
+		{name: "report", max: -1},
+		{name: "complication", max: -1},
+		{name: "complicationDetail", max: -1},
+		{name: "followUp", max: -1},
+		{name: "note", max: -1},
+		{name: "focalDevice", max: -1},
+		{name: "usedReference", max: -1},
+		{name: "usedCode", max: -1},
+	},
+	"Procedure.focalDevice": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "action", max: 1},
+		{name: "manipulated", min: 1, max: 1},
+	},
+	"Procedure.performer": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "role", max: 1},
+		{name: "actor", min: 1, max: 1},
+		{name: "onBehalfOf", max: 1},
+	},
+	"ProcedureRequest": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "definition", max: -1},
+		{name: "basedOn", max: -1},
+		{name: "replaces", max: -1},
+		{name: "requisition", max: 1},
+		{name: "status", min: 1, max: 1, codes: []string{"draft", "active", "suspended", "completed", "entered-in-error", "cancelled", "unknown"}},
+		{name: "intent", min: 1, max: 1},
+		{name: "priority", max: 1, codes: []string{"routine", "urgent", "asap", "stat"}},
+		{name: "doNotPerform", max: 1},
+		{name: "category", max: -1},
+		{name: "code", min: 1, max: 1},
+		{name: "subject", min: 1, max: 1},
+		{name: "context", max: 1},
+		{name: "occurrence[x]", max: 1, types: []string{"dateTime", "Period", "Timing"}},
+		{name: "asNeeded[x]", max: 1, types: []string{"boolean", "CodeableConcept"}},
+		{name: "authoredOn", max: 1},
+		{name: "requester", max: 1},
+		{name: "performerType", max: 1},
+		{name: "performer", max: 1},
+		{name: "reasonCode", max: -1},
+		{name: "reasonReference", max: -1},
+		{name: "supportingInfo", max: -1},
+		{name: "specimen", max: -1},
+		{name: "bodySite", max: -1},
+		{name: "note", max: -1},
+		{name: "relevantHistory", max: -1},
+	},
+	"ProcedureRequest.requester": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "agent", min: 1, max: 1},
+		{name: "onBehalfOf", max: 1},
+	},
+	"ProcessRequest": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "status", max: 1, codes: []string{"active", "cancelled", "draft", "entered-in-error"}},
+		{name: "action", max: 1, codes: []string{"cancel", "poll", "reprocess", "status"}},
+		{name: "target", max: 1},
+		{name: "created", max: 1},
+		{name: "provider", max: 1},
+		{name: "organization", max: 1},
+		{name: "request", max: 1},
+		{name: "response", max: 1},
+		{name: "nullify", max: 1},
+		{name: "reference", max: 1},
+		{name: "item", max: -1},
+		{name: "include", max: -1},
+		{name: "exclude", max: -1},
+		{name: "period", max: 1},
+	},
+	"ProcessRequest.item": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "sequenceLinkId", min: 1, max: 1},
+	},
+	"ProcessResponse": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "status", max: 1, codes: []string{"active", "cancelled", "draft", "entered-in-error"}},
+		{name: "created", max: 1},
+		{name: "organization", max: 1},
+		{name: "request", max: 1},
+		{name: "outcome", max: 1},
+		{name: "disposition", max: 1},
+		{name: "requestProvider", max: 1},
+		{name: "requestOrganization", max: 1},
+		{name: "form", max: 1},
+		{name: "processNote", max: -1},
+		{name: "error", max: -1},
+		{name: "communicationRequest", max: -1},
+	},
+	"ProcessResponse.processNote": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "type", max: 1, codes: []string{"http://hl7.org/fhir/note-type|display", "http://hl7.org/fhir/note-type|print", "http://hl7.org/fhir/note-type|printoper"}},
+		{name: "text", max: 1},
+	},
+	"Provenance": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "target", min: 1, max: -1},
+		{name: "period", max: 1},
+		{name: "recorded", min: 1, max: 1},
+		{name: "policy", max: -1},
+		{name: "location", max: 1},
+		{name: "reason", max: -1},
+		{name: "activity", max: 1},
+		{name: "agent", min: 1, max: -1},
+		{name: "entity", max: -1},
+		{name: "signature", max: -1},
+	},
+	"Provenance.agent": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "role", max: -1},
+		{name: "who[x]", min: 1, max: 1, types: []string{"uri", "Reference"}},
+		{name: "onBehalfOf[x]", max: 1, types: []string{"uri", "Reference"}},
+		{name: "relatedAgentType", max: 1},
+	},
+	"Provenance.entity": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "role", min: 1, max: 1, codes: []string{"derivation", "revision", "quotation", "source", "removal"}},
+		{name: "what[x]", min: 1, max: 1, types: []string{"uri", "Reference", "Identifier"}},
+		{name: "agent", max: -1},
+	},
+	"Quantity": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "value", max: 1},
+		{name: "comparator", max: 1, codes: []string{"<", "<=", ">=", ">"}},
+		{name: "unit", max: 1},
+		{name: "system", max: 1},
+		{name: "code", max: 1},
+	},
+	"Questionnaire": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "url", max: 1},
+		{name: "identifier", max: -1},
+		{name: "version", max: 1},
+		{name: "name", max: 1},
+		{name: "title", max: 1},
+		{name: "status", min: 1, max: 1, codes: []string{"draft", "active", "retired", "unknown"}},
+		{name: "experimental", max: 1},
+		{name: "date", max: 1},
+		{name: "publisher", max: 1},
+		{name: "description", max: 1},
+		{name: "purpose", max: 1},
+		{name: "approvalDate", max: 1},
+		{name: "lastReviewDate", max: 1},
+		{name: "effectivePeriod", max: 1},
+		{name: "useContext", max: -1},
+		{name: "jurisdiction", max: -1},
+		{name: "contact", max: -1},
+		{name: "copyright", max: 1},
+		{name: "code", max: -1},
+		{name: "subjectType", max: -1, codes: []string{"Account", "ActivityDefinition", "AdverseEvent", "AllergyIntolerance", "Appointment", "AppointmentResponse", "AuditEvent", "Basic", "Binary", "BiologicallyDerivedProduct", "BodyStructure", "Bundle", "CapabilityStatement", "CarePlan", "CareTeam", "ChargeItem", "Claim", "ClaimResponse", "ClinicalImpression", "CodeSystem", "Communication", "CommunicationRequest", "CompartmentDefinition", "Composition", "ConceptMap", "Condition", "Consent", "Contract", "Coverage", "DetectedIssue", "Device", "DeviceComponent", "DeviceMetric", "DeviceRequest", "DeviceUseStatement", "DiagnosticReport", "DocumentManifest", "DocumentReference", "DomainResource", "EligibilityRequest", "EligibilityResponse", "Encounter", "Endpoint", "EnrollmentRequest", "EnrollmentResponse", "EntryDefinition", "EpisodeOfCare", "EventDefinition", "ExampleScenario", "ExpansionProfile", "ExplanationOfBenefit", "FamilyMemberHistory", "Flag", "Goal", "GraphDefinition", "Group", "GuidanceResponse", "HealthcareService", "ImagingStudy", "Immunization", "ImmunizationEvaluation", "ImmunizationRecommendation", "ImplementationGuide", "ImplementationGuideInput", "ImplementationGuideOutput", "Invoice", "ItemInstance", "Library", "Linkage", "List", "Location", "Measure", "MeasureReport", "Media", "Medication", "MedicationAdministration", "MedicationDispense", "MedicationRequest", "MedicationStatement", "MedicinalProduct", "MedicinalProductAuthorization", "MedicinalProductClinicals", "MedicinalProductDeviceSpec", "MedicinalProductIngredient", "MedicinalProductPackaged", "MedicinalProductPharmaceutical", "MessageDefinition", "MessageHeader", "NamingSystem", "NutritionOrder", "Observation", "ObservationDefinition", "OccupationalData", "OperationDefinition", "OperationOutcome", "Organization", "OrganizationRole", "Parameters", "Patient", "PaymentNotice", "PaymentReconciliation", "Person", "PlanDefinition", "Practitioner", "PractitionerRole", "Procedure", "ProcessRequest", "ProcessResponse", "ProductPlan", "Provenance", "Questionnaire", "QuestionnaireResponse", "RelatedPerson", "RequestGroup", "ResearchStudy", "ResearchSubject", "Resource", "RiskAssessment", "Schedule", "SearchParameter", "Sequence", "ServiceDefinition", "ServiceRequest", "Slot", "Specimen", "SpecimenDefinition", "StructureDefinition", "StructureMap", "Subscription", "Substance", "SubstancePolymer", "SubstanceReferenceInformation", "SubstanceSpecification", "SupplyDelivery", "SupplyRequest", "Task", "TerminologyCapabilities", "TestReport", "TestScript", "UserSession", "ValueSet", "VerificationResult", "VisionPrescription"}},
+		{name: "item", max: -1},
+	},
+	"Questionnaire.item": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "linkId", min: 1, max: 1},
+		{name: "definition", max: 1},
+		{name: "code", max: -1},
+		{name: "prefix", max: 1},
+		{name: "text", max: 1},
+		{name: "type", min: 1, max: 1},
+		{name: "enableWhen", max: -1},
+		{name: "required", max: 1},
+		{name: "repeats", max: 1},
+		{name: "readOnly", max: 1},
+		{name: "maxLength", max: 1},
+		{name: "options", max: 1},
+		{name: "option", max: -1},
+		{name: "initial[x]", max: 1, types: []string{"boolean", "decimal", "integer", "date", "dateTime", "time", "string", "uri", "Attachment", "Coding", "Quantity", "Reference"}},
+		{name: "item", max: -1},
+	},
+	"Questionnaire.item.enableWhen": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "question", min: 1, max: 1},
+		{name: "hasAnswer", max: 1},
+		{name: "answer[x]", max: 1, types: []string{"boolean", "decimal", "integer", "date", "dateTime", "time", "string", "uri", "Attachment", "Coding", "Quantity", "Reference"}},
+	},
+	"Questionnaire.item.option": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "value[x]", min: 1, max: 1, types: []string{"integer", "date", "time", "string", "Coding"}},
+	},
+	"QuestionnaireResponse": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: 1},
+		{name: "basedOn", max: -1},
+		{name: "parent", max: -1},
+		{name: "questionnaire", max: 1},
+		{name: "status", min: 1, max: 1, codes: []string{"in-progress", "completed", "amended", "entered-in-error", "stopped"}},
+		{name: "subject", max: 1},
+		{name: "context", max: 1},
+		{name: "authored", max: 1},
+		{name: "author", max: 1},
+		{name: "source", max: 1},
+		{name: "item", max: -1},
+	},
+	"QuestionnaireResponse.item": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "linkId", min: 1, max: 1},
+		{name: "definition", max: 1},
+		{name: "text", max: 1},
+		{name: "subject", max: 1},
+		{name: "answer", max: -1},
+		{name: "item", max: -1},
+	},
+	"QuestionnaireResponse.item.answer": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "value[x]", max: 1, types: []string{"boolean", "decimal", "integer", "date", "dateTime", "time", "string", "uri", "Attachment", "Coding", "Quantity", "Reference"}},
+		{name: "item", max: -1},
+	},
+	"Range": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "low", max: 1},
+		{name: "high", max: 1},
+	},
+	"Ratio": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "numerator", max: 1},
+		{name: "denominator", max: 1},
+	},
+	"Reference": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "reference", max: 1},
+		{name: "identifier", max: 1},
+		{name: "display", max: 1},
+	},
+	"ReferralRequest": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "definition", max: -1},
+		{name: "basedOn", max: -1},
+		{name: "replaces", max: -1},
+		{name: "groupIdentifier", max: 1},
+		{name: "status", min: 1, max: 1, codes: []string{"draft", "active", "suspended", "cancelled", "completed", "entered-in-error", "unknown"}},
+		{name: "intent", min: 1, max: 1, codes: []string{"proposal", "plan", "order", "option"}},
+		{name: "type", max: 1},
+		{name: "priority", max: 1, codes: []string{"routine", "urgent", "asap", "stat"}},
+		{name: "serviceRequested", max: -1},
+		{name: "subject", min: 1, max: 1},
+		{name: "context", max: 1},
+		{name: "occurrence[x]", max: 1, types: []string{"dateTime", "Period"}},
+		{name: "authoredOn", max: 1},
+		{name: "requester", max: 1},
+		{name: "specialty", max: 1},
+		{name: "recipient", max: -1},
+		{name: "reasonCode", max: -1},
+		{name: "reasonReference", max: -1},
+		{name: "description", max: 1},
+		{name: "supportingInfo", max: -1},
+		{name: "note", max: -1},
+		{name: "relevantHistory", max: -1},
+	},
+	"ReferralRequest.requester": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "agent", min: 1, max: 1},
+		{name: "onBehalfOf", max: 1},
+	},
+	"RelatedArtifact": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "type", min: 1, max: 1, codes: []string{"documentation", "justification", "citation", "predecessor", "successor", "derived-from", "depends-on", "composed-of"}},
+		{name: "display", max: 1},
+		{name: "citation", max: 1},
+		{name: "url", max: 1},
+		{name: "document", max: 1},
+		{name: "resource", max: 1},
+	},
+	"RelatedPerson": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "active", max: 1},
+		{name: "patient", min: 1, max: 1},
+		{name: "relationship", max: 1},
+		{name: "name", max: -1},
+		{name: "telecom", max: -1},
+		{name: "gender", max: 1, codes: []string{"male", "female", "other", "unknown"}},
+		{name: "birthDate", max: 1},
+		{name: "address", max: -1},
+		{name: "photo", max: -1},
+		{name: "period", max: 1},
+	},
+	"RequestGroup": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "definition", max: -1},
+		{name: "basedOn", max: -1},
+		{name: "replaces", max: -1},
+		{name: "groupIdentifier", max: 1},
+		{name: "status", min: 1, max: 1, codes: []string{"draft", "active", "suspended", "cancelled", "completed", "entered-in-error", "unknown"}},
+		{name: "intent", min: 1, max: 1, codes: []string{"proposal", "plan", "order", "option"}},
+		{name: "priority", max: 1, codes: []string{"routine", "urgent", "asap", "stat"}},
+		{name: "subject", max: 1},
+		{name: "context", max: 1},
+		{name: "authoredOn", max: 1},
+		{name: "author", max: 1},
+		{name: "reason[x]", max: 1, types: []string{"CodeableConcept", "Reference"}},
+		{name: "note", max: -1},
+		{name: "action", max: -1},
+	},
+	"RequestGroup.action": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "label", max: 1},
+		{name: "title", max: 1},
+		{name: "description", max: 1},
+		{name: "textEquivalent", max: 1},
+		{name: "code", max: -1},
+		{name: "documentation", max: -1},
+		{name: "condition", max: -1},
+		{name: "relatedAction", max: -1},
+		{name: "timing[x]", max: 1, types: []string{"dateTime", "Period", "Duration", "Range", "Timing"}},
+		{name: "participant", max: -1},
+		{name: "type", max: 1},
+		{name: "groupingBehavior", max: 1, codes: []string{"visual-group", "logical-group", "sentence-group"}},
+		{name: "selectionBehavior", max: 1, codes: []string{"any", "all", "all-or-none", "exactly-one", "at-most-one", "one-or-more"}},
+		{name: "requiredBehavior", max: 1, codes: []string{"must", "could", "must-unless-documented"}},
+		{name: "precheckBehavior", max: 1, codes: []string{"yes", "no"}},
+		{name: "cardinalityBehavior", max: 1, codes: []string{"single", "multiple"}},
+		{name: "resource", max: 1},
+		{name: "action", max: -1},
+	},
+	"RequestGroup.action.condition": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "kind", min: 1, max: 1, codes: []string{"applicability", "start", "stop"}},
+		{name: "description", max: 1},
+		{name: "language", max: 1},
+		{name: "expression", max: 1},
+	},
+	"RequestGroup.action.relatedAction": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "actionId", min: 1, max: 1},
+		{name: "relationship", min: 1, max: 1, codes: []string{"before-start", "before", "before-end", "concurrent-with-start", "concurrent", "concurrent-with-end", "after-start", "after", "after-end"}},
+		{name: "offset[x]", max: 1, types: []string{"Duration", "Range"}},
+	},
+	"ResearchStudy": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "title", max: 1},
+		{name: "protocol", max: -1},
+		{name: "partOf", max: -1},
+		{name: "status", min: 1, max: 1, codes: []string{"draft", "in-progress", "suspended", "stopped", "completed", "entered-in-error", "active", "administratively-completed", "approved", "closed-to-accrual", "closed-to-accrual-and-intervention", "disapproved", "in-review", "temporarily-closed-to-accrual", "temporarily-closed-to-accrual-and-intervention", "withdrawn"}},
+		{name: "category", max: -1},
+		{name: "focus", max: -1},
+		{name: "contact", max: -1},
+		{name: "relatedArtifact", max: -1},
+		{name: "keyword", max: -1},
+		{name: "jurisdiction", max: -1},
+		{name: "description", max: 1},
+		{name: "enrollment", max: -1},
+		{name: "period", max: 1},
+		{name: "sponsor", max: 1},
+		{name: "principalInvestigator", max: 1},
+		{name: "site", max: -1},
+		{name: "reasonStopped", max: 1},
+		{name: "note", max: -1},
+		{name: "arm", max: -1},
+	},
+	"ResearchStudy.arm": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "name", min: 1, max: 1},
+		{name: "code", max: 1},
+		{name: "description", max: 1},
+	},
+	"ResearchSubject": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: 1},
+		{name: "status", min: 1, max: 1, codes: []string{"candidate", "enrolled", "active", "suspended", "withdrawn", "completed", "eligible", "follow-up", "ineligible", "not-registered", "off-study", "on-study", "on-study-intervention", "on-study-observation", "pending-on-study", "potential-candidate", "screening"}},
+		{name: "period", max: 1},
+		{name: "study", min: 1, max: 1},
+		{name: "individual", min: 1, max: 1},
+		{name: "assignedArm", max: 1},
+		{name: "actualArm", max: 1},
+		{name: "consent", max: 1},
+	},
+	"RiskAssessment": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: 1},
+		{name: "basedOn", max: 1},
+		{name: "parent", max: 1},
+		{name: "status", min: 1, max: 1},
+		{name: "method", max: 1},
+		{name: "code", max: 1},
+		{name: "subject", max: 1},
+		{name: "context", max: 1},
+		{name: "occurrence[x]", max: 1, types: []string{"dateTime", "Period"}},
+		{name: "condition", max: 1},
+		{name: "performer", max: 1},
+		{name: "reason[x]", max: 1, types: []string{"CodeableConcept", "Reference"}},
+		{name: "basis", max: -1},
+		{name: "prediction", max: -1},
+		{name: "mitigation", max: 1},
+		{name: "comment", max: 1},
+	},
+	"RiskAssessment.prediction": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "outcome", min: 1, max: 1},
+		{name: "probability[x]", max: 1, types: []string{"decimal", "Range"}},
+		{name: "qualitativeRisk", max: 1},
+		{name: "relativeRisk", max: 1},
+		{name: "when[x]", max: 1, types: []string{"Period", "Range"}},
+		{name: "rationale", max: 1},
+	},
+	"SampledData": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "origin", min: 1, max: 1},
+		{name: "period", min: 1, max: 1},
+		{name: "factor", max: 1},
+		{name: "lowerLimit", max: 1},
+		{name: "upperLimit", max: 1},
+		{name: "dimensions", min: 1, max: 1},
+		{name: "data", min: 1, max: 1},
+	},
+	"Schedule": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "active", max: 1},
+		{name: "serviceCategory", max: 1},
+		{name: "serviceType", max: -1},
+		{name: "specialty", max: -1},
+		{name: "actor", min: 1, max: -1},
+		{name: "planningHorizon", max: 1},
+		{name: "comment", max: 1},
+	},
+	"SearchParameter": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "url", min: 1, max: 1},
+		{name: "version", max: 1},
+		{name: "name", min: 1, max: 1},
+		{name: "status", min: 1, max: 1, codes: []string{"draft", "active", "retired", "unknown"}},
+		{name: "experimental", max: 1},
+		{name: "date", max: 1},
+		{name: "publisher", max: 1},
+		{name: "contact", max: -1},
+		{name: "useContext", max: -1},
+		{name: "jurisdiction", max: -1},
+		{name: "purpose", max: 1},
+		{name: "code", min: 1, max: 1},
+		{name: "base", min: 1, max: -1, codes: []string{"Account", "ActivityDefinition", "AdverseEvent", "AllergyIntolerance", "Appointment", "AppointmentResponse", "AuditEvent", "Basic", "Binary", "BiologicallyDerivedProduct", "BodyStructure", "Bundle", "CapabilityStatement", "CarePlan", "CareTeam", "ChargeItem", "Claim", "ClaimResponse", "ClinicalImpression", "CodeSystem", "Communication", "CommunicationRequest", "CompartmentDefinition", "Composition", "ConceptMap", "Condition", "Consent", "Contract", "Coverage", "DetectedIssue", "Device", "DeviceComponent", "DeviceMetric", "DeviceRequest", "DeviceUseStatement", "DiagnosticReport", "DocumentManifest", "DocumentReference", "DomainResource", "EligibilityRequest", "EligibilityResponse", "Encounter", "Endpoint", "EnrollmentRequest", "EnrollmentResponse", "EntryDefinition", "EpisodeOfCare", "EventDefinition", "ExampleScenario", "ExpansionProfile", "ExplanationOfBenefit", "FamilyMemberHistory", "Flag", "Goal", "GraphDefinition", "Group", "GuidanceResponse", "HealthcareService", "ImagingStudy", "Immunization", "ImmunizationEvaluation", "ImmunizationRecommendation", "ImplementationGuide", "ImplementationGuideInput", "ImplementationGuideOutput", "Invoice", "ItemInstance", "Library", "Linkage", "List", "Location", "Measure", "MeasureReport", "Media", "Medication", "MedicationAdministration", "MedicationDispense", "MedicationRequest", "MedicationStatement", "MedicinalProduct", "MedicinalProductAuthorization", "MedicinalProductClinicals", "MedicinalProductDeviceSpec", "MedicinalProductIngredient", "MedicinalProductPackaged", "MedicinalProductPharmaceutical", "MessageDefinition", "MessageHeader", "NamingSystem", "NutritionOrder", "Observation", "ObservationDefinition", "OccupationalData", "OperationDefinition", "OperationOutcome", "Organization", "OrganizationRole", "Parameters", "Patient", "PaymentNotice", "PaymentReconciliation", "Person", "PlanDefinition", "Practitioner", "PractitionerRole", "Procedure", "ProcessRequest", "ProcessResponse", "ProductPlan", "Provenance", "Questionnaire", "QuestionnaireResponse", "RelatedPerson", "RequestGroup", "ResearchStudy", "ResearchSubject", "Resource", "RiskAssessment", "Schedule", "SearchParameter", "Sequence", "ServiceDefinition", "ServiceRequest", "Slot", "Specimen", "SpecimenDefinition", "StructureDefinition", "StructureMap", "Subscription", "Substance", "SubstancePolymer", "SubstanceReferenceInformation", "SubstanceSpecification", "SupplyDelivery", "SupplyRequest", "Task", "TerminologyCapabilities", "TestReport", "TestScript", "UserSession", "ValueSet", "VerificationResult", "VisionPrescription"}},
+		{name: "type", min: 1, max: 1, codes: []string{"number", "date", "string", "token", "reference", "composite", "quantity", "uri"}},
+		{name: "derivedFrom", max: 1},
+		{name: "description", min: 1, max: 1},
+		{name: "expression", max: 1},
+		{name: "xpath", max: 1},
+		{name: "xpathUsage", max: 1, codes: []string{"normal", "phonetic", "nearby", "distance", "other"}},
+		{name: "target", max: -1, codes: []string{"Account", "ActivityDefinition", "AdverseEvent", "AllergyIntolerance", "Appointment", "AppointmentResponse", "AuditEvent", "Basic", "Binary", "BiologicallyDerivedProduct", "BodyStructure", "Bundle", "CapabilityStatement", "CarePlan", "CareTeam", "ChargeItem", "Claim", "ClaimResponse", "ClinicalImpression", "CodeSystem", "Communication", "CommunicationRequest", "CompartmentDefinition", "Composition", "ConceptMap", "Condition", "Consent", "Contract", "Coverage", "DetectedIssue", "Device", "DeviceComponent", "DeviceMetric", "DeviceRequest", "DeviceUseStatement", "DiagnosticReport", "DocumentManifest", "DocumentReference", "DomainResource", "EligibilityRequest", "EligibilityResponse", "Encounter", "Endpoint", "EnrollmentRequest", "EnrollmentResponse", "EntryDefinition", "EpisodeOfCare", "EventDefinition", "ExampleScenario", "ExpansionProfile", "ExplanationOfBenefit", "FamilyMemberHistory", "Flag", "Goal", "GraphDefinition", "Group", "GuidanceResponse", "HealthcareService", "ImagingStudy", "Immunization", "ImmunizationEvaluation", "ImmunizationRecommendation", "ImplementationGuide", "ImplementationGuideInput", "ImplementationGuideOutput", "Invoice", "ItemInstance", "Library", "Linkage", "List", "Location", "Measure", "MeasureReport", "Media", "Medication", "MedicationAdministration", "MedicationDispense", "MedicationRequest", "MedicationStatement", "MedicinalProduct", "MedicinalProductAuthorization", "MedicinalProductClinicals", "MedicinalProductDeviceSpec", "MedicinalProductIngredient", "MedicinalProductPackaged", "MedicinalProductPharmaceutical", "MessageDefinition", "MessageHeader", "NamingSystem", "NutritionOrder", "Observation", "ObservationDefinition", "OccupationalData", "OperationDefinition", "OperationOutcome", "Organization", "OrganizationRole", "Parameters", "Patient", "PaymentNotice", "PaymentReconciliation", "Person", "PlanDefinition", "Practitioner", "PractitionerRole", "Procedure", "ProcessRequest", "ProcessResponse", "ProductPlan", "Provenance", "Questionnaire", "QuestionnaireResponse", "RelatedPerson", "RequestGroup", "ResearchStudy", "ResearchSubject", "Resource", "RiskAssessment", "Schedule", "SearchParameter", "Sequence", "ServiceDefinition", "ServiceRequest", "Slot", "Specimen", "SpecimenDefinition", "StructureDefinition", "StructureMap", "Subscription", "Substance", "SubstancePolymer", "SubstanceReferenceInformation", "SubstanceSpecification", "SupplyDelivery", "SupplyRequest", "Task", "TerminologyCapabilities", "TestReport", "TestScript", "UserSession", "ValueSet", "VerificationResult", "VisionPrescription"}},
+		{name: "comparator", max: -1, codes: []string{"eq", "ne", "gt", "lt", "ge", "le", "sa", "eb", "ap"}},
+		{name: "modifier", max: -1, codes: []string{"missing", "exact", "contains", "not", "text", "in", "not-in", "below", "above", "type"}},
+		{name: "chain", max: -1},
+		{name: "component", max: -1},
+	},
+	"SearchParameter.component": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "definition", min: 1, max: 1},
+		{name: "expression", min: 1, max: 1},
+	},
+	"Sequence": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "type", max: 1},
+		{name: "coordinateSystem", min: 1, max: 1},
+		{name: "patient", max: 1},
+		{name: "specimen", max: 1},
+		{name: "device", max: 1},
+		{name: "performer", max: 1},
+		{name: "quantity", max: 1},
+		{name: "referenceSeq", max: 1},
+		{name: "variant", max: -1},
+		{name: "observedSeq", max: 1},
+		{name: "quality", max: -1},
+		{name: "readCoverage", max: 1},
+		{name: "repository", max: -1},
+		{name: "pointer", max: -1},
+	},
+	"Sequence.quality": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "type", min: 1, max: 1, codes: []string{"indel", "snp", "unknown"}},
+		{name: "standardSequence", max: 1},
+		{name: "start", max: 1},
+		{name: "end", max: 1},
+		{name: "score", max: 1},
+		{name: "method", max: 1},
+		{name: "truthTP", max: 1},
+		{name: "queryTP", max: 1},
+		{name: "truthFN", max: 1},
+		{name: "queryFP", max: 1},
+		{name: "gtFP", max: 1},
+		{name: "precision", max: 1},
+		{name: "recall", max: 1},
+		{name: "fScore", max: 1},
+	},
+	"Sequence.referenceSeq": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "chromosome", max: 1},
+		{name: "genomeBuild", max: 1},
+		{name: "referenceSeqId", max: 1},
+		{name: "referenceSeqPointer", max: 1},
+		{name: "referenceSeqString", max: 1},
+		{name: "strand", max: 1},
+		{name: "windowStart", min: 1, max: 1},
+		{name: "windowEnd", min: 1, max: 1},
+	},
+	"Sequence.repository": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "type", min: 1, max: 1, codes: []string{"directlink", "openapi", "login", "oauth", "other"}},
+		{name: "url", max: 1},
+		{name: "name", max: 1},
+		{name: "datasetId", max: 1},
+		{name: "variantsetId", max: 1},
+		{name: "readsetId", max: 1},
+	},
+	"Sequence.variant": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "start", max: 1},
+		{name: "end", max: 1},
+		{name: "observedAllele", max: 1},
+		{name: "referenceAllele", max: 1},
+		{name: "cigar", max: 1},
+		{name: "variantPointer", max: 1},
+	},
+	"ServiceDefinition": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "url", max: 1},
+		{name: "identifier", max: -1},
+		{name: "version", max: 1},
+		{name: "name", max: 1},
+		{name: "title", max: 1},
+		{name: "status", min: 1, max: 1, codes: []string{"draft", "active", "retired", "unknown"}},
+		{name: "experimental", max: 1},
+		{name: "date", max: 1},
+		{name: "publisher", max: 1},
+		{name: "description", max: 1},
+		{name: "purpose", max: 1},
+		{name: "usage", max: 1},
+		{name: "approvalDate", max: 1},
+		{name: "lastReviewDate", max: 1},
+		{name: "effectivePeriod", max: 1},
+		{name: "useContext", max: -1},
+		{name: "jurisdiction", max: -1},
+		{name: "topic", max: -1},
+		{name: "contributor", max: -1},
+		{name: "contact", max: -1},
+		{name: "copyright", max: 1},
+		{name: "relatedArtifact", max: -1},
+		{name: "trigger", max: -1},
+		{name: "dataRequirement", max: -1},
+		{name: "operationDefinition", max: 1},
+	},
+	"Signature": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "type", min: 1, max: -1},
+		{name: "when", min: 1, max: 1},
+		{name: "who[x]", min: 1, max: 1, types: []string{"uri", "Reference"}},
+		{name: "onBehalfOf[x]", max: 1, types: []string{"uri", "Reference"}},
+		{name: "contentType", max: 1},
+		{name: "blob", max: 1},
+	},
+	"Slot": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "serviceCategory", max: 1},
+		{name: "serviceType", max: -1},
+		{name: "specialty", max: -1},
+		{name: "appointmentType", max: 1},
+		{name: "schedule", min: 1, max: 1},
+		{name: "status", min: 1, max: 1, codes: []string{"busy", "free", "busy-unavailable", "busy-tentative", "entered-in-error"}},
+		{name: "start", min: 1, max: 1},
+		{name: "end", min: 1, max: 1},
+		{name: "overbooked", max: 1},
+		{name: "comment", max: 1},
+	},
+	"Specimen": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "accessionIdentifier", max: 1},
+		{name: "status", max: 1, codes: []string{"available", "unavailable", "unsatisfactory", "entered-in-error"}},
+		{name: "type", max: 1},
+		{name: "subject", min: 1, max: 1},
+		{name: "receivedTime", max: 1},
+		{name: "parent", max: -1},
+		{name: "request", max: -1},
+		{name: "collection", max: 1},
+		{name: "processing", max: -1},
+		{name: "container", max: -1},
+		{name: "note", max: -1},
+	},
+	"Specimen.collection": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "collector", max: 1},
+		{name: "collected[x]", max: 1, types: []string{"dateTime", "Period"}},
+		{name: "quantity", max: 1},
+		{name: "method", max: 1},
+		{name: "bodySite", max: 1},
+	},
+	"Specimen.container": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "description", max: 1},
+		{name: "type", max: 1},
+		{name: "capacity", max: 1},
+		{name: "specimenQuantity", max: 1},
+		{name: "additive[x]", max: 1, types: []string{"CodeableConcept", "Reference"}},
+	},
+	"Specimen.processing": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "description", max: 1},
+		{name: "procedure", max: 1},
+		{name: "additive", max: -1},
+		{name: "time[x]", max: 1, types: []string{"dateTime", "Period"}},
+	},
+	"StructureDefinition": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "url", min: 1, max: 1},
+		{name: "identifier", max: -1},
+		{name: "version", max: 1},
+		{name: "name", min: 1, max: 1},
+		{name: "title", max: 1},
+		{name: "status", min: 1, max: 1, codes: []string{"draft", "active", "retired", "unknown"}},
+		{name: "experimental", max: 1},
+		{name: "date", max: 1},
+		{name: "publisher", max: 1},
+		{name: "contact", max: -1},
+		{name: "description", max: 1},
+		{name: "useContext", max: -1},
+		{name: "jurisdiction", max: -1},
+		{name: "purpose", max: 1},
+		{name: "copyright", max: 1},
+		{name: "keyword", max: -1},
+		{name: "fhirVersion", max: 1},
+		{name: "mapping", max: -1},
+		{name: "kind", min: 1, max: 1, codes: []string{"primitive-type", "complex-type", "resource", "logical"}},
+		{name: "abstract", min: 1, max: 1},
+		{name: "contextType", max: 1, codes: []string{"resource", "datatype", "extension"}},
+		{name: "context", max: -1},
+		{name: "contextInvariant", max: -1},
+		{name: "type", min: 1, max: 1},
+		{name: "baseDefinition", max: 1},
+		{name: "derivation", max: 1, codes: []string{"specialization", "constraint"}},
+		{name: "snapshot", max: 1},
+		{name: "differential", max: 1},
+	},
+	"StructureDefinition.differential": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "element", min: 1, max: -1},
+	},
+	"StructureDefinition.mapping": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identity", min: 1, max: 1},
+		{name: "uri", max: 1},
+		{name: "name", max: 1},
+		{name: "comment", max: 1},
+	},
+	"StructureDefinition.snapshot": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "element", min: 1, max: -1},
+	},
+	"StructureMap": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "url", min: 1, max: 1},
+		{name: "identifier", max: -1},
+		{name: "version", max: 1},
+		{name: "name", min: 1, max: 1},
+		{name: "title", max: 1},
+		{name: "status", min: 1, max: 1, codes: []string{"draft", "active", "retired", "unknown"}},
+		{name: "experimental", max: 1},
+		{name: "date", max: 1},
+		{name: "publisher", max: 1},
+		{name: "contact", max: -1},
+		{name: "description", max: 1},
+		{name: "useContext", max: -1},
+		{name: "jurisdiction", max: -1},
+		{name: "purpose", max: 1},
+		{name: "copyright", max: 1},
+		{name: "structure", max: -1},
+		{name: "import", max: -1},
+		{name: "group", min: 1, max: -1},
+	},
+	"StructureMap.group": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "name", min: 1, max: 1},
+		{name: "extends", max: 1},
+		{name: "typeMode", min: 1, max: 1, codes: []string{"none", "types", "type-and-types"}},
+		{name: "documentation", max: 1},
+		{name: "input", min: 1, max: -1},
+		{name: "rule", min: 1, max: -1},
+	},
+	"StructureMap.group.input": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "name", min: 1, max: 1},
+		{name: "type", max: 1},
+		{name: "mode", min: 1, max: 1, codes: []string{"source", "target"}},
+		{name: "documentation", max: 1},
+	},
+	"StructureMap.group.rule": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "name", min: 1, max: 1},
+		{name: "source", min: 1, max: -1},
+		{name: "target", max: -1},
+		{name: "rule", max: -1},
+		{name: "dependent", max: -1},
+		{name: "documentation", max: 1},
+	},
+	"StructureMap.group.rule.dependent": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "name", min: 1, max: 1},
+		{name: "variable", min: 1, max: -1},
+	},
+	"StructureMap.group.rule.source": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "context", min: 1, max: 1},
+		{name: "min", max: 1},
+		{name: "max", max: 1},
+		{name: "type", max: 1},
+		{name: "defaultValue[x]", max: 1, types: []string{"base64Binary", "boolean", "code", "date", "dateTime", "decimal", "id", "instant", "integer", "markdown", "oid", "positiveInt", "string", "time", "unsignedInt", "uri", "Address", "Age", "Annotation", "Attachment", "CodeableConcept", "Coding", "ContactPoint", "Count", "Distance", "Duration", "HumanName", "Identifier", "Money", "Period", "Quantity", "Range", "Ratio", "Reference", "SampledData", "Signature", "Timing", "Meta"}},
+		{name: "element", max: 1},
+		{name: "listMode", max: 1, codes: []string{"first", "not_first", "last", "not_last", "only_one"}},
+		{name: "variable", max: 1},
+		{name: "condition", max: 1},
+		{name: "check", max: 1},
+	},
+	"StructureMap.group.rule.target": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "context", max: 1},
+		{name: "contextType", max: 1, codes: []string{"type", "variable"}},
+		{name: "element", max: 1},
+		{name: "variable", max: 1},
+		{name: "listMode", max: -1, codes: []string{"first", "share", "last", "collate"}},
+		{name: "listRuleId", max: 1},
+		{name: "transform", max: 1},
+		{name: "parameter", max: -1},
+	},
+	"StructureMap.group.rule.target.parameter": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "value[x]", min: 1, max: 1, types: []string{"id", "string", "boolean", "integer", "decimal"}},
+	},
+	"StructureMap.structure": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "url", min: 1, max: 1},
+		{name: "mode", min: 1, max: 1, codes: []string{"source", "queried", "target", "produced"}},
+		{name: "alias", max: 1},
+		{name: "documentation", max: 1},
+	},
+	"Subscription": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "status", min: 1, max: 1, codes: []string{"requested", "active", "error", "off"}},
+		{name: "contact", max: -1},
+		{name: "end", max: 1},
+		{name: "reason", min: 1, max: 1},
+		{name: "criteria", min: 1, max: 1},
+		{name: "error", max: 1},
+		{name: "channel", min: 1, max: 1},
+		{name: "tag", max: -1},
+	},
+	"Subscription.channel": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "type", min: 1, max: 1, codes: []string{"rest-hook", "websocket", "email", "sms", "message"}},
+		{name: "endpoint", max: 1},
+		{name: "payload", max: 1},
+		{name: "header", max: -1},
+	},
+	"Substance": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "status", max: 1, codes: []string{"active", "inactive", "entered-in-error"}},
+		{name: "category", max: -1},
+		{name: "code", min: 1, max: 1},
+		{name: "description", max: 1},
+		{name: "instance", max: -1},
+		{name: "ingredient", max: -1},
+	},
+	"Substance.ingredient": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "quantity", max: 1},
+		{name: "substance[x]", min: 1, max: 1, types: []string{"CodeableConcept", "Reference"}},
+	},
+	"Substance.instance": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: 1},
+		{name: "expiry", max: 1},
+		{name: "quantity", max: 1},
+	},
+	"SupplyDelivery": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: 1},
+		{name: "basedOn", max: -1},
+		{name: "partOf", max: -1},
+		{name: "status", max: 1, codes: []string{"in-progress", "completed", "abandoned", "entered-in-error"}},
+		{name: "patient", max: 1},
+		{name: "type", max: 1, codes: []string{"http://hl7.org/fhir/supply-item-type|medication", "http://hl7.org/fhir/supply-item-type|device"}},
+		{name: "suppliedItem", max: 1},
+		{name: "occurrence[x]", max: 1, types: []string{"dateTime", "Period", "Timing"}},
+		{name: "supplier", max: 1},
+		{name: "destination", max: 1},
+		{name: "receiver", max: -1},
+	},
+	"SupplyDelivery.suppliedItem": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "quantity", max: 1},
+		{name: "item[x]", max: 1, types: []string{"CodeableConcept", "Reference"}},
+	},
+	"SupplyRequest": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: 1},
+		{name: "status", max: 1},
+		{name: "category", max: 1},
+		{name: "priority", max: 1, codes: []string{"routine", "urgent", "asap", "stat"}},
+		{name: "orderedItem", max: 1},
+		{name: "occurrence[x]", max: 1, types: []string{"dateTime", "Period", "Timing"}},
+		{name: "authoredOn", max: 1},
+		{name: "requester", max: 1},
+		{name: "supplier", max: -1},
+		{name: "reason[x]", max: 1, types: []string{"CodeableConcept", "Reference"}},
+		{name: "deliverFrom", max: 1},
+		{name: "deliverTo", max: 1},
+	},
+	"SupplyRequest.orderedItem": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "quantity", min: 1, max: 1},
+		{name: "item[x]", max: 1, types: []string{"CodeableConcept", "Reference"}},
+	},
+	"SupplyRequest.requester": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "agent", min: 1, max: 1},
+		{name: "onBehalfOf", max: 1},
+	},
+	"Task": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "definition[x]", max: 1, types: []string{"uri", "Reference"}},
+		{name: "basedOn", max: -1},
+		{name: "groupIdentifier", max: 1},
+		{name: "partOf", max: -1},
+		{name: "status", min: 1, max: 1},
+		{name: "statusReason", max: 1},
+		{name: "businessStatus", max: 1},
+		{name: "intent", min: 1, max: 1},
+		{name: "priority", max: 1, codes: []string{"normal", "urgent", "asap", "stat", "routine"}},
+		{name: "code", max: 1},
+		{name: "description", max: 1},
+		{name: "focus", max: 1},
+		{name: "for", max: 1},
+		{name: "context", max: 1},
+		{name: "executionPeriod", max: 1},
+		{name: "authoredOn", max: 1},
+		{name: "lastModified", max: 1},
+		{name: "requester", max: 1},
+		{name: "performerType", max: -1},
+		{name: "owner", max: 1},
+		{name: "reason", max: 1},
+		{name: "note", max: -1},
+		{name: "relevantHistory", max: -1},
+		{name: "restriction", max: 1},
+		{name: "input", max: -1},
+		{name: "output", max: -1},
+	},
+	"Task.input": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "type", min: 1, max: 1},
+		{name: "value[x]", min: 1, max: 1, types: []string{"base64Binary", "boolean", "code", "date", "dateTime", "decimal", "id", "instant", "integer", "markdown", "oid", "positiveInt", "string", "time", "unsignedInt", "uri", "Address", "Age", "Annotation", "Attachment", "CodeableConcept", "Coding", "ContactPoint", "Count", "Distance", "Duration", "HumanName", "Identifier", "Money", "Period", "Quantity", "Range", "Ratio", "Reference", "SampledData", "Signature", "Timing", "Meta"}},
+	},
+	"Task.output": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "type", min: 1, max: 1},
+		{name: "value[x]", min: 1, max: 1, types: []string{"base64Binary", "boolean", "code", "date", "dateTime", "decimal", "id", "instant", "integer", "markdown", "oid", "positiveInt", "string", "time", "unsignedInt", "uri", "Address", "Age", "Annotation", "Attachment", "CodeableConcept", "Coding", "ContactPoint", "Count", "Distance", "Duration", "HumanName", "Identifier", "Money", "Period", "Quantity", "Range", "Ratio", "Reference", "SampledData", "Signature", "Timing", "Meta"}},
+	},
+	"Task.requester": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "agent", min: 1, max: 1},
+		{name: "onBehalfOf", max: 1},
+	},
+	"Task.restriction": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "repetitions", max: 1},
+		{name: "period", max: 1},
+		{name: "recipient", max: -1},
+	},
+	"TestReport": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: 1},
+		{name: "name", max: 1},
+		{name: "status", min: 1, max: 1, codes: []string{"completed", "in-progress", "waiting", "stopped", "entered-in-error"}},
+		{name: "testScript", min: 1, max: 1},
+		{name: "result", min: 1, max: 1, codes: []string{"pass", "fail", "pending"}},
+		{name: "score", max: 1},
+		{name: "tester", max: 1},
+		{name: "issued", max: 1},
+		{name: "participant", max: -1},
+		{name: "setup", max: 1},
+		{name: "test", max: -1},
+		{name: "teardown", max: 1},
+	},
+	"TestReport.participant": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "type", min: 1, max: 1, codes: []string{"test-engine", "client", "server"}},
+		{name: "uri", min: 1, max: 1},
+		{name: "display", max: 1},
+	},
+	"TestReport.setup": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "action", min: 1, max: -1},
+	},
+	"TestReport.setup.action": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "operation", max: 1},
+		{name: "assert", max: 1},
+	},
+	"TestReport.setup.action.assert": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "result", min: 1, max: 1, codes: []string{"pass", "skip", "fail", "warning", "error"}},
+		{name: "message", max: 1},
+		{name: "detail", max: 1},
+	},
+	"TestReport.setup.action.operation": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "result", min: 1, max: 1, codes: []string{"pass", "skip", "fail", "warning", "error"}},
+		{name: "message", max: 1},
+		{name: "detail", max: 1},
+	},
+	"TestReport.teardown": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "action", min: 1, max: -1},
+	},
+	"TestReport.teardown.action": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "operation", min: 1, max: 1},
+	},
+	"TestReport.test": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "name", max: 1},
+		{name: "description", max: 1},
+		{name: "action", min: 1, max: -1},
+	},
+	"TestReport.test.action": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "operation", max: 1},
+		{name: "assert", max: 1},
+	},
+	"TestScript": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "url", min: 1, max: 1},
+		{name: "identifier", max: 1},
+		{name: "version", max: 1},
+		{name: "name", min: 1, max: 1},
+		{name: "title", max: 1},
+		{name: "status", min: 1, max: 1, codes: []string{"draft", "active", "retired", "unknown"}},
+		{name: "experimental", max: 1},
+		{name: "date", max: 1},
+		{name: "publisher", max: 1},
+		{name: "contact", max: -1},
+		{name: "description", max: 1},
+		{name: "useContext", max: -1},
+		{name: "jurisdiction", max: -1},
+		{name: "purpose", max: 1},
+		{name: "copyright", max: 1},
+		{name: "origin", max: -1},
+		{name: "destination", max: -1},
+		{name: "metadata", max: 1},
+		{name: "fixture", max: -1},
+		{name: "profile", max: -1},
+		{name: "variable", max: -1},
+		{name: "rule", max: -1},
+		{name: "ruleset", max: -1},
+		{name: "setup", max: 1},
+		{name: "test", max: -1},
+		{name: "teardown", max: 1},
+	},
+	"TestScript.destination": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "index", min: 1, max: 1},
+		{name: "profile", min: 1, max: 1},
+	},
+	"TestScript.fixture": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "autocreate", max: 1},
+		{name: "autodelete", max: 1},
+		{name: "resource", max: 1},
+	},
+	"TestScript.metadata": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "link", max: -1},
+		{name: "capability", min: 1, max: -1},
+	},
+	"TestScript.metadata.capability": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "required", max: 1},
+		{name: "validated", max: 1},
+		{name: "description", max: 1},
+		{name: "origin", max: -1},
+		{name: "destination", max: 1},
+		{name: "link", max: -1},
+		{name: "capabilities", min: 1, max: 1},
+	},
+	"TestScript.metadata.link": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "url", min: 1, max: 1},
+		{name: "description", max: 1},
+	},
+	"TestScript.origin": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "index", min: 1, max: 1},
+		{name: "profile", min: 1, max: 1},
+	},
+	"TestScript.rule": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "resource", min: 1, max: 1},
+		{name: "param", max: -1},
+	},
+	"TestScript.rule.param": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "name", min: 1, max: 1},
+		{name: "value", max: 1},
+	},
+	"TestScript.ruleset": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "resource", min: 1, max: 1},
+		{name: "rule", min: 1, max: -1},
+	},
+	"TestScript.ruleset.rule": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "ruleId", min: 1, max: 1},
+		{name: "param", max: -1},
+	},
+	"TestScript.ruleset.rule.param": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "name", min: 1, max: 1},
+		{name: "value", max: 1},
+	},
+	"TestScript.setup": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "action", min: 1, max: -1},
+	},
+	"TestScript.setup.action": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "operation", max: 1},
+		{name: "assert", max: 1},
+	},
+	"TestScript.setup.action.assert": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "label", max: 1},
+		{name: "description", max: 1},
+		{name: "direction", max: 1, codes: []string{"response", "request"}},
+		{name: "compareToSourceId", max: 1},
+		{name: "compareToSourceExpression", max: 1},
+		{name: "compareToSourcePath", max: 1},
+		{name: "contentType", max: 1, codes: []string{"xml", "json", "ttl", "none"}},
+		{name: "expression", max: 1},
+		{name: "headerField", max: 1},
+		{name: "minimumId", max: 1},
+		{name: "navigationLinks", max: 1},
+		{name: "operator", max: 1, codes: []string{"equals", "notEquals", "in", "notIn", "greaterThan", "lessThan", "empty", "notEmpty", "contains", "notContains", "eval"}},
+		{name: "path", max: 1},
+		{name: "requestMethod", max: 1, codes: []string{"delete", "get", "options", "patch", "post", "put"}},
+		{name: "requestURL", max: 1},
+		{name: "resource", max: 1, codes: []string{"Address", "Age", "Annotation", "Attachment", "BackboneElement", "CodeableConcept", "Coding", "ContactDetail", "ContactPoint", "Contributor", "Count", "DataRequirement", "Distance", "Dosage", "Duration", "Element", "ElementDefinition", "Extension", "HumanName", "Identifier", "MarketingStatus", "Meta", "Money", "Narrative", "ParameterDefinition", "Period", "ProdCharacteristic", "ProductShelfLife", "Quantity", "Range", "Ratio", "Reference", "RelatedArtifact", "SampledData", "Signature", "SimpleQuantity", "SubstanceAmount", "SubstanceMoiety", "Timing", "TriggerDefinition", "UsageContext", "base64Binary", "boolean", "code", "date", "dateTime", "decimal", "id", "instant", "integer", "markdown", "oid", "positiveInt", "string", "time", "unsignedInt", "uri", "uuid", "xhtml", "Account", "ActivityDefinition", "AdverseEvent", "AllergyIntolerance", "Appointment", "AppointmentResponse", "AuditEvent", "Basic", "Binary", "BiologicallyDerivedProduct", "BodyStructure", "Bundle", "CapabilityStatement", "CarePlan", "CareTeam", "ChargeItem", "Claim", "ClaimResponse", "ClinicalImpression", "CodeSystem", "Communication", "CommunicationRequest", "CompartmentDefinition", "Composition", "ConceptMap", "Condition", "Consent", "Contract", "Coverage", "DetectedIssue", "Device", "DeviceComponent", "DeviceMetric", "DeviceRequest", "DeviceUseStatement", "DiagnosticReport", "DocumentManifest", "DocumentReference", "DomainResource", "EligibilityRequest", "EligibilityResponse", "Encounter", "Endpoint", "EnrollmentRequest", "EnrollmentResponse", "EntryDefinition", "EpisodeOfCare", "EventDefinition", "ExampleScenario", "ExpansionProfile", "ExplanationOfBenefit", "FamilyMemberHistory", "Flag", "Goal", "GraphDefinition", "Group", "GuidanceResponse", "HealthcareService", "ImagingStudy", "Immunization", "ImmunizationEvaluation", "ImmunizationRecommendation", "ImplementationGuide", "ImplementationGuideInput", "ImplementationGuideOutput", "Invoice", "ItemInstance", "Library", "Linkage", "List", "Location", "Measure", "MeasureReport", "Media", "Medication", "MedicationAdministration", "MedicationDispense", "MedicationRequest", "MedicationStatement", "MedicinalProduct", "MedicinalProductAuthorization", "MedicinalProductClinicals", "MedicinalProductDeviceSpec", "MedicinalProductIngredient", "MedicinalProductPackaged", "MedicinalProductPharmaceutical", "MessageDefinition", "MessageHeader", "NamingSystem", "NutritionOrder", "Observation", "ObservationDefinition", "OccupationalData", "OperationDefinition", "OperationOutcome", "Organization", "OrganizationRole", "Parameters", "Patient", "PaymentNotice", "PaymentReconciliation", "Person", "PlanDefinition", "Practitioner", "PractitionerRole", "Procedure", "ProcessRequest", "ProcessResponse", "ProductPlan", "Provenance", "Questionnaire", "QuestionnaireResponse", "RelatedPerson", "RequestGroup", "ResearchStudy", "ResearchSubject", "Resource", "RiskAssessment", "Schedule", "SearchParameter", "Sequence", "ServiceDefinition", "ServiceRequest", "Slot", "Specimen", "SpecimenDefinition", "StructureDefinition", "StructureMap", "Subscription", "Substance", "SubstancePolymer", "SubstanceReferenceInformation", "SubstanceSpecification", "SupplyDelivery", "SupplyRequest", "Task", "TerminologyCapabilities", "TestReport", "TestScript", "UserSession", "ValueSet", "VerificationResult", "VisionPrescription"}},
+		{name: "response", max: 1, codes: []string{"okay", "created", "noContent", "notModified", "bad", "forbidden", "notFound", "methodNotAllowed", "conflict", "gone", "preconditionFailed", "unprocessable"}},
+		{name: "responseCode", max: 1},
+		{name: "rule", max: 1},
+		{name: "ruleset", max: 1},
+		{name: "sourceId", max: 1},
+		{name: "validateProfileId", max: 1},
+		{name: "value", max: 1},
+		{name: "warningOnly", max: 1},
+	},
+	"TestScript.setup.action.assert.rule": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "ruleId", min: 1, max: 1},
+		{name: "param", max: -1},
+	},
+	"TestScript.setup.action.assert.rule.param": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "name", min: 1, max: 1},
+		{name: "value", min: 1, max: 1},
+	},
+	"TestScript.setup.action.assert.ruleset": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "rulesetId", min: 1, max: 1},
+		{name: "rule", max: -1},
+	},
+	"TestScript.setup.action.assert.ruleset.rule": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "ruleId", min: 1, max: 1},
+		{name: "param", max: -1},
+	},
+	"TestScript.setup.action.assert.ruleset.rule.param": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "name", min: 1, max: 1},
+		{name: "value", min: 1, max: 1},
+	},
+	"TestScript.setup.action.operation": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "type", max: 1},
+		{name: "resource", max: 1, codes: []string{"Address", "Age", "Annotation", "Attachment", "BackboneElement", "CodeableConcept", "Coding", "ContactDetail", "ContactPoint", "Contributor", "Count", "DataRequirement", "Distance", "Dosage", "Duration", "Element", "ElementDefinition", "Extension", "HumanName", "Identifier", "MarketingStatus", "Meta", "Money", "Narrative", "ParameterDefinition", "Period", "ProdCharacteristic", "ProductShelfLife", "Quantity", "Range", "Ratio", "Reference", "RelatedArtifact", "SampledData", "Signature", "SimpleQuantity", "SubstanceAmount", "SubstanceMoiety", "Timing", "TriggerDefinition", "UsageContext", "base64Binary", "boolean", "code", "date", "dateTime", "decimal", "id", "instant", "integer", "markdown", "oid", "positiveInt", "string", "time", "unsignedInt", "uri", "uuid", "xhtml", "Account", "ActivityDefinition", "AdverseEvent", "AllergyIntolerance", "Appointment", "AppointmentResponse", "AuditEvent", "Basic", "Binary", "BiologicallyDerivedProduct", "BodyStructure", "Bundle", "CapabilityStatement", "CarePlan", "CareTeam", "ChargeItem", "Claim", "ClaimResponse", "ClinicalImpression", "CodeSystem", "Communication", "CommunicationRequest", "CompartmentDefinition", "Composition", "ConceptMap", "Condition", "Consent", "Contract", "Coverage", "DetectedIssue", "Device", "DeviceComponent", "DeviceMetric", "DeviceRequest", "DeviceUseStatement", "DiagnosticReport", "DocumentManifest", "DocumentReference", "DomainResource", "EligibilityRequest", "EligibilityResponse", "Encounter", "Endpoint", "EnrollmentRequest", "EnrollmentResponse", "EntryDefinition", "EpisodeOfCare", "EventDefinition", "ExampleScenario", "ExpansionProfile", "ExplanationOfBenefit", "FamilyMemberHistory", "Flag", "Goal", "GraphDefinition", "Group", "GuidanceResponse", "HealthcareService", "ImagingStudy", "Immunization", "ImmunizationEvaluation", "ImmunizationRecommendation", "ImplementationGuide", "ImplementationGuideInput", "ImplementationGuideOutput", "Invoice", "ItemInstance", "Library", "Linkage", "List", "Location", "Measure", "MeasureReport", "Media", "Medication", "MedicationAdministration", "MedicationDispense", "MedicationRequest", "MedicationStatement", "MedicinalProduct", "MedicinalProductAuthorization", "MedicinalProductClinicals", "MedicinalProductDeviceSpec", "MedicinalProductIngredient", "MedicinalProductPackaged", "MedicinalProductPharmaceutical", "MessageDefinition", "MessageHeader", "NamingSystem", "NutritionOrder", "Observation", "ObservationDefinition", "OccupationalData", "OperationDefinition", "OperationOutcome", "Organization", "OrganizationRole", "Parameters", "Patient", "PaymentNotice", "PaymentReconciliation", "Person", "PlanDefinition", "Practitioner", "PractitionerRole", "Procedure", "ProcessRequest", "ProcessResponse", "ProductPlan", "Provenance", "Questionnaire", "QuestionnaireResponse", "RelatedPerson", "RequestGroup", "ResearchStudy", "ResearchSubject", "Resource", "RiskAssessment", "Schedule", "SearchParameter", "Sequence", "ServiceDefinition", "ServiceRequest", "Slot", "Specimen", "SpecimenDefinition", "StructureDefinition", "StructureMap", "Subscription", "Substance", "SubstancePolymer", "SubstanceReferenceInformation", "SubstanceSpecification", "SupplyDelivery", "SupplyRequest", "Task", "TerminologyCapabilities", "TestReport", "TestScript", "UserSession", "ValueSet", "VerificationResult", "VisionPrescription"}},
+		{name: "label", max: 1},
+		{name: "description", max: 1},
+		{name: "accept", max: 1, codes: []string{"xml", "json", "ttl", "none"}},
+		{name: "contentType", max: 1, codes: []string{"xml", "json", "ttl", "none"}},
+		{name: "destination", max: 1},
+		{name: "encodeRequestUrl", max: 1},
+		{name: "origin", max: 1},
+		{name: "params", max: 1},
+		{name: "requestHeader", max: -1},
+		{name: "requestId", max: 1},
+		{name: "responseId", max: 1},
+		{name: "sourceId", max: 1},
+		{name: "targetId", max: 1},
+		{name: "url", max: 1},
+	},
+	"TestScript.setup.action.operation.requestHeader": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "field", min: 1, max: 1},
+		{name: "value", min: 1, max: 1},
+	},
+	"TestScript.teardown": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "action", min: 1, max: -1},
+	},
+	"TestScript.teardown.action": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "operation", min: 1, max: 1},
+	},
+	"TestScript.test": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "name", max: 1},
+		{name: "description", max: 1},
+		{name: "action", min: 1, max: -1},
+	},
+	"TestScript.test.action": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "operation", max: 1},
+		{name: "assert", max: 1},
+	},
+	"TestScript.variable": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "name", min: 1, max: 1},
+		{name: "defaultValue", max: 1},
+		{name: "description", max: 1},
+		{name: "expression", max: 1},
+		{name: "headerField", max: 1},
+		{name: "hint", max: 1},
+		{name: "path", max: 1},
+		{name: "sourceId", max: 1},
+	},
+	"Timing": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "event", max: -1},
+		{name: "repeat", max: 1},
+		{name: "code", max: 1},
+	},
+	"Timing.repeat": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "bounds[x]", max: 1, types: []string{"Duration", "Range", "Period"}},
+		{name: "count", max: 1},
+		{name: "countMax", max: 1},
+		{name: "duration", max: 1},
+		{name: "durationMax", max: 1},
+		{name: "durationUnit", max: 1, codes: []string{"s", "min", "h", "d", "wk", "mo", "a"}},
+		{name: "frequency", max: 1},
+		{name: "frequencyMax", max: 1},
+		{name: "period", max: 1},
+		{name: "periodMax", max: 1},
+		{name: "periodUnit", max: 1, codes: []string{"s", "min", "h", "d", "wk", "mo", "a"}},
+		{name: "dayOfWeek", max: -1, codes: []string{"mon", "tue", "wed", "thu", "fri", "sat", "sun"}},
+		{name: "timeOfDay", max: -1},
+		{name: "when", max: -1},
+		{name: "offset", max: 1},
+	},
+	"TriggerDefinition": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "type", min: 1, max: 1, codes: []string{"named-event", "periodic", "data-added", "data-modified", "data-removed", "data-accessed", "data-access-ended", "data-changed"}},
+		{name: "eventName", max: 1},
+		{name: "eventTiming[x]", max: 1, types: []string{"Timing", "Reference", "date", "dateTime"}},
+		{name: "eventData", max: 1},
+	},
+	"UsageContext": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "code", min: 1, max: 1},
+		{name: "value[x]", min: 1, max: 1, types: []string{"CodeableConcept", "Quantity", "Range"}},
+	},
+	"ValueSet": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "url", max: 1},
+		{name: "identifier", max: -1},
+		{name: "version", max: 1},
+		{name: "name", max: 1},
+		{name: "title", max: 1},
+		{name: "status", min: 1, max: 1, codes: []string{"draft", "active", "retired", "unknown"}},
+		{name: "experimental", max: 1},
+		{name: "date", max: 1},
+		{name: "publisher", max: 1},
+		{name: "contact", max: -1},
+		{name: "description", max: 1},
+		{name: "useContext", max: -1},
+		{name: "jurisdiction", max: -1},
+		{name: "immutable", max: 1},
+		{name: "purpose", max: 1},
+		{name: "copyright", max: 1},
+		{name: "extensible", max: 1},
+		{name: "compose", max: 1},
+		{name: "expansion", max: 1},
+	},
+	"ValueSet.compose": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "lockedDate", max: 1},
+		{name: "inactive", max: 1},
+		{name: "include", min: 1, max: -1},
+		{name: "exclude", max: -1},
+	},
+	"ValueSet.compose.include": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "system", max: 1},
+		{name: "version", max: 1},
+		{name: "concept", max: -1},
+		{name: "filter", max: -1},
+		{name: "valueSet", max: -1},
+	},
+	"ValueSet.compose.include.concept": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "code", min: 1, max: 1},
+		{name: "display", max: 1},
+		{name: "designation", max: -1},
+	},
+	"ValueSet.compose.include.concept.designation": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "language", max: 1},
+		{name: "use", max: 1},
+		{name: "value", min: 1, max: 1},
+	},
+	"ValueSet.compose.include.filter": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "property", min: 1, max: 1},
+		{name: "op", min: 1, max: 1, codes: []string{"=", "is-a", "descendent-of", "is-not-a", "regex", "in", "not-in", "generalizes", "exists"}},
+		{name: "value", min: 1, max: 1},
+	},
+	"ValueSet.expansion": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", min: 1, max: 1},
+		{name: "timestamp", min: 1, max: 1},
+		{name: "total", max: 1},
+		{name: "offset", max: 1},
+		{name: "parameter", max: -1},
+		{name: "contains", max: -1},
+	},
+	"ValueSet.expansion.contains": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "system", max: 1},
+		{name: "abstract", max: 1},
+		{name: "inactive", max: 1},
+		{name: "version", max: 1},
+		{name: "code", max: 1},
+		{name: "display", max: 1},
+		{name: "designation", max: -1},
+		{name: "contains", max: -1},
+	},
+	"ValueSet.expansion.parameter": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "name", min: 1, max: 1},
+		{name: "value[x]", max: 1, types: []string{"string", "boolean", "integer", "decimal", "uri", "code"}},
+	},
+	"VisionPrescription": {
+		{name: "id", max: 1},
+		{name: "meta", max: 1},
+		{name: "implicitRules", max: 1},
+		{name: "language", max: 1},
+		{name: "text", max: 1},
+		{name: "contained", max: -1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "identifier", max: -1},
+		{name: "status", max: 1, codes: []string{"active", "cancelled", "draft", "entered-in-error"}},
+		{name: "patient", max: 1},
+		{name: "encounter", max: 1},
+		{name: "dateWritten", max: 1},
+		{name: "prescriber", max: 1},
+		{name: "reason[x]", max: 1, types: []string{"CodeableConcept", "Reference"}},
+		{name: "dispense", max: -1},
+	},
+	"VisionPrescription.dispense": {
+		{name: "id", max: 1},
+		{name: "extension", max: -1},
+		{name: "modifierExtension", max: -1},
+		{name: "product", max: 1},
+		{name: "eye", max: 1, codes: []string{"right", "left"}},
+		{name: "sphere", max: 1},
+		{name: "cylinder", max: 1},
+		{name: "axis", max: 1},
+		{name: "prism", max: 1},
+		{name: "base", max: 1, codes: []string{"up", "down", "in", "out"}},
+		{name: "add", max: 1},
+		{name: "power", max: 1},
+		{name: "backCurve", max: 1},
+		{name: "diameter", max: 1},
+		{name: "duration", max: 1},
+		{name: "color", max: 1},
+		{name: "brand", max: 1},
+		{name: "note", max: -1},
+	},
+}
@@ -0,0 +1,89 @@
+package models2
+
+import (
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/pkg/errors"
+)
+
+// LocalReference is a reference of a resource to another resource on the same server (e.g. Patient/123)
+type LocalReference struct {
+	// Expression is the FHIRPath of the reference in the referring resource, e.g. Observation.subject
+	Expression string
+	// Type and ID are those of the referenced resource
+	Type string
+	ID   string
+}
+
+// Target returns the referenced resource as Type/ID
+func (r LocalReference) Target() string {
+	return r.Type + "/" + r.ID
+}
+
+// LocalReferences returns the references of a resource (including those of its contained resources) to other
+// resources on the same server, i.e. not to contained resources, absolute URLs or a transaction's urn:uuid entries
+// (unless transformed by SetTransformReferencesMap).  The references of the resources in Bundles aren't returned,
+// as they're relative to their entries' fullUrls.
+func (r *Resource) LocalReferences() ([]LocalReference, error) {
+	if r.resourceType == "Bundle" {
+		return nil, nil
+	}
+	bsonDoc, err := r.GetBSON()
+	if err != nil {
+		return nil, errors.Wrap(err, "LocalReferences: GetBSON failed")
+	}
+	return LocalReferencesOfBSON(bsonDoc.([]bson.E), r.resourceType), nil
+}
+
+// LocalReferencesOfBSON returns the references of a resource stored in MongoDB to other resources on the same
+// server (see LocalReferences), using the reference__id and reference__type fields stored with its references
+func LocalReferencesOfBSON(doc []bson.E, resourceType string) []LocalReference {
+	if resourceType == "Bundle" {
+		return nil
+	}
+	var references []LocalReference
+	addLocalReferences(doc, resourceType, &references)
+	return references
+}
+
+func addLocalReferences(value interface{}, expression string, references *[]LocalReference) {
+	switch value := value.(type) {
+	case bson.D:
+		addLocalReferences([]bson.E(value), expression, references)
+	case []bson.E:
+		var reference LocalReference
+		external := true
+		for _, elem := range value {
+			switch elem.Key {
+			case "reference__id":
+				reference.ID, _ = elem.Value.(string)
+			case "reference__type":
+				reference.Type, _ = elem.Value.(string)
+			case "reference__external":
+				external, _ = elem.Value.(bool)
+			}
+		}
+		if reference.ID != "" && reference.Type != "" && !external {
+			reference.Expression = expression
+			*references = append(*references, reference)
+		}
+
+		for _, elem := range value {
+			key := elem.Key
+			if key == "_id" {
+				key = "id"
+			} else if key == "__id" {
+				key = "_id"
+			}
+			addLocalReferences(elem.Value, expression+"."+key, references)
+		}
+	case bson.A:
+		addLocalReferences([]interface{}(value), expression, references)
+	case []interface{}:
+		for i, item := range value {
+			addLocalReferences(item, expression+"["+strconv.Itoa(i)+"]", references)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package models2
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalReferences(t *testing.T) {
+	resource, err := NewResourceFromJsonBytes([]byte(`{"resourceType": "Observation", "status": "final", "code": {"text": "x"},
+		"subject": {"reference": "Patient/123", "display": "Patient/456"},
+		"performer": [
+			{"reference": "Practitioner/1/_history/2"},
+			{"reference": "http://example.com/fhir/Practitioner/2"},
+			{"reference": "urn:uuid:61ebe359-bfdc-4613-8bf2-c5e300945f0a"}
+		],
+		"specimen": {"reference": "#specimen"},
+		"contained": [{"resourceType": "Specimen", "id": "specimen", "subject": {"reference": "Group/7"}}]}`))
+	assert.Nil(t, err)
+
+	// urn:uuid references are transformed to the ids of the resources created by transactions
+	resource.SetTransformReferencesMap(map[string]string{"urn:uuid:61ebe359-bfdc-4613-8bf2-c5e300945f0a": "Practitioner/3"})
+
+	references, err := resource.LocalReferences()
+	assert.Nil(t, err)
+	assert.Equal(t, []LocalReference{
+		{Expression: "Observation.subject", Type: "Patient", ID: "123"},
+		{Expression: "Observation.performer[0]", Type: "Practitioner", ID: "1"},
+		{Expression: "Observation.performer[2]", Type: "Practitioner", ID: "3"},
+		{Expression: "Observation.contained[0].subject", Type: "Group", ID: "7"},
+	}, references)
+	assert.Equal(t, "Patient/123", references[0].Target())
+
+	// The references of Bundle entries are relative to their fullUrls
+	bundle, err := NewResourceFromJsonBytes([]byte(`{"resourceType": "Bundle", "type": "collection",
+		"entry": [{"fullUrl": "http://example.com/fhir/Observation/1",
+			"resource": {"resourceType": "Observation", "subject": {"reference": "Patient/123"}}}]}`))
+	assert.Nil(t, err)
+	references, err = bundle.LocalReferences()
+	assert.Nil(t, err)
+	assert.Nil(t, references)
+}
@@ -0,0 +1,403 @@
+package models2
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// profile is a StructureDefinition constraining a resource type, loaded from a package
+type profile struct {
+	url            string
+	name           string
+	resourceType   string
+	baseDefinition string
+	differential   bool // whether the elements are only those of the differential (there was no snapshot)
+	elements       []profileElement
+}
+
+// profileElement is an element definition of a profile
+type profileElement struct {
+	path     string      // e.g. Patient.name.family, or Observation.value[x]
+	min      int         // minimum cardinality
+	max      int         // maximum cardinality (-1 for * or when not constrained)
+	types    []string    // the allowed types of choice elements
+	fixed    interface{} // the value of a fixed[x] property
+	pattern  interface{} // the value of a pattern[x] property
+	valueSet string      // the canonical URL of the value set of a required binding
+}
+
+// valueSetDefinition is the part of a ValueSet resource needed to list its codes
+type valueSetDefinition struct {
+	URL     string `json:"url"`
+	Compose *struct {
+		Include []valueSetInclude `json:"include"`
+		Exclude []valueSetInclude `json:"exclude"`
+	} `json:"compose"`
+	Expansion *struct {
+		Contains []valueSetContains `json:"contains"`
+	} `json:"expansion"`
+}
+
+type valueSetInclude struct {
+	System  string `json:"system"`
+	Concept []struct {
+		Code string `json:"code"`
+	} `json:"concept"`
+	Filter   []json.RawMessage `json:"filter"`
+	ValueSet []string          `json:"valueSet"`
+}
+
+type valueSetContains struct {
+	System   string             `json:"system"`
+	Code     string             `json:"code"`
+	Contains []valueSetContains `json:"contains"`
+}
+
+// codeSystemDefinition is the part of a CodeSystem resource needed to list its codes
+type codeSystemDefinition struct {
+	URL     string              `json:"url"`
+	Content string              `json:"content"`
+	Concept []codeSystemConcept `json:"concept"`
+}
+
+type codeSystemConcept struct {
+	Code    string              `json:"code"`
+	Concept []codeSystemConcept `json:"concept"`
+}
+
+// packageManifest is the part of a package's package.json needed to check that it's for STU3
+type packageManifest struct {
+	Name         string            `json:"name"`
+	Version      string            `json:"version"`
+	FhirVersions []string          `json:"fhirVersions"`
+	Dependencies map[string]string `json:"dependencies"`
+}
+
+// packageResources are the conformance resources of the packages loaded with LoadPackage, by canonical URL
+var packageResources = struct {
+	sync.RWMutex
+	profiles    map[string]*profile
+	valueSets   map[string]*valueSetDefinition
+	codeSystems map[string]*codeSystemDefinition
+}{
+	profiles:    make(map[string]*profile),
+	valueSets:   make(map[string]*valueSetDefinition),
+	codeSystems: make(map[string]*codeSystemDefinition),
+}
+
+// LoadPackage loads the StructureDefinitions, ValueSets and CodeSystems of a FHIR NPM package (a package.tgz file,
+// e.g. of US Core), so that resources can be validated against its profiles (see ValidateResource).  It returns
+// the name and version of the package.  Only the profiles of resource types are used; others (e.g. of
+// extensions and data types) are ignored.
+func LoadPackage(fileName string) (string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", errors.Wrap(err, "LoadPackage: open failed")
+	}
+	defer file.Close()
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return "", errors.Wrap(err, "LoadPackage: not a gzipped package")
+	}
+	tarReader := tar.NewReader(gzipReader)
+
+	var manifest packageManifest
+	profiles := make(map[string]*profile)
+	valueSets := make(map[string]*valueSetDefinition)
+	codeSystems := make(map[string]*codeSystemDefinition)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", errors.Wrap(err, "LoadPackage: reading package failed")
+		}
+
+		// the resources are in the package folder (and examples, which aren't needed, in subfolders of it)
+		dir, name := path.Split(header.Name)
+		if header.Typeflag != tar.TypeReg || strings.TrimPrefix(dir, "./") != "package/" || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		data, err := ioutil.ReadAll(tarReader)
+		if err != nil {
+			return "", errors.Wrapf(err, "LoadPackage: reading %s failed", header.Name)
+		}
+		if name == "package.json" {
+			if err := json.Unmarshal(data, &manifest); err != nil {
+				return "", errors.Wrap(err, "LoadPackage: parsing package.json failed")
+			}
+			continue
+		}
+
+		var resource struct {
+			ResourceType string `json:"resourceType"`
+		}
+		if err := json.Unmarshal(data, &resource); err != nil {
+			return "", errors.Wrapf(err, "LoadPackage: parsing %s failed", header.Name)
+		}
+		switch resource.ResourceType {
+		case "StructureDefinition":
+			profile, err := parseProfile(data)
+			if err != nil {
+				return "", errors.Wrapf(err, "LoadPackage: parsing %s failed", header.Name)
+			}
+			if profile != nil {
+				profiles[profile.url] = profile
+			}
+		case "ValueSet":
+			var valueSet valueSetDefinition
+			if err := json.Unmarshal(data, &valueSet); err != nil {
+				return "", errors.Wrapf(err, "LoadPackage: parsing %s failed", header.Name)
+			}
+			valueSets[valueSet.URL] = &valueSet
+		case "CodeSystem":
+			var codeSystem codeSystemDefinition
+			if err := json.Unmarshal(data, &codeSystem); err != nil {
+				return "", errors.Wrapf(err, "LoadPackage: parsing %s failed", header.Name)
+			}
+			codeSystems[codeSystem.URL] = &codeSystem
+		}
+	}
+
+	if manifest.Name == "" {
+		return "", errors.New("LoadPackage: package/package.json not found")
+	}
+	fhirVersions := manifest.FhirVersions
+	if coreVersion, found := manifest.Dependencies["hl7.fhir.core"]; found && len(fhirVersions) == 0 {
+		fhirVersions = []string{coreVersion}
+	}
+	for _, fhirVersion := range fhirVersions {
+		if !strings.HasPrefix(fhirVersion, "3.0") {
+			return "", errors.Errorf("LoadPackage: %s@%s is for FHIR %s, not STU3", manifest.Name, manifest.Version, strings.Join(fhirVersions, ", "))
+		}
+	}
+
+	packageResources.Lock()
+	defer packageResources.Unlock()
+	for url, profile := range profiles {
+		packageResources.profiles[url] = profile
+	}
+	for url, valueSet := range valueSets {
+		packageResources.valueSets[url] = valueSet
+	}
+	for url, codeSystem := range codeSystems {
+		packageResources.codeSystems[url] = codeSystem
+	}
+	return manifest.Name + "@" + manifest.Version, nil
+}
+
+// ProfileURLs returns the canonical URLs of the profiles of resource types loaded from packages
+func ProfileURLs() []string {
+	packageResources.RLock()
+	defer packageResources.RUnlock()
+	urls := make([]string, 0, len(packageResources.profiles))
+	for url := range packageResources.profiles {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+// parseProfile parses a StructureDefinition, returning nil if it isn't a profile of a resource type
+func parseProfile(data []byte) (*profile, error) {
+	var definition struct {
+		URL            string `json:"url"`
+		Name           string `json:"name"`
+		Kind           string `json:"kind"`
+		Type           string `json:"type"`
+		Derivation     string `json:"derivation"`
+		BaseDefinition string `json:"baseDefinition"`
+		Snapshot       *struct {
+			Element []map[string]interface{} `json:"element"`
+		} `json:"snapshot"`
+		Differential *struct {
+			Element []map[string]interface{} `json:"element"`
+		} `json:"differential"`
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&definition); err != nil {
+		return nil, err
+	}
+	if definition.Kind != "resource" || definition.Derivation != "constraint" || fhirElementDefinitions[definition.Type] == nil {
+		return nil, nil
+	}
+
+	p := &profile{
+		url:            definition.URL,
+		name:           definition.Name,
+		resourceType:   definition.Type,
+		baseDefinition: definition.BaseDefinition,
+	}
+	var elements []map[string]interface{}
+	if definition.Snapshot != nil {
+		elements = definition.Snapshot.Element
+	} else if definition.Differential != nil {
+		elements = definition.Differential.Element
+		p.differential = true
+	}
+
+	for _, element := range elements {
+		id, _ := element["id"].(string)
+		elementPath, _ := element["path"].(string)
+		_, isSlice := element["sliceName"]
+		_, isContentReference := element["contentReference"]
+		if !strings.Contains(elementPath, ".") || strings.Contains(id, ":") || isSlice || isContentReference {
+			// the constraints of the resource itself and of slices aren't checked
+			continue
+		}
+
+		e := profileElement{path: elementPath, max: -1}
+		if min, ok := element["min"].(json.Number); ok {
+			minInt, _ := min.Int64()
+			e.min = int(minInt)
+		}
+		if max, ok := element["max"].(string); ok && max != "*" {
+			maxInt, err := strconv.Atoi(max)
+			if err != nil {
+				return nil, errors.Errorf("%s: invalid max %q", elementPath, max)
+			}
+			e.max = maxInt
+		}
+		if types, ok := element["type"].([]interface{}); ok {
+			for _, elementType := range types {
+				elementType, _ := elementType.(map[string]interface{})
+				if code, ok := elementType["code"].(string); ok {
+					e.types = append(e.types, code)
+				}
+			}
+		}
+		for name, value := range element {
+			if strings.HasPrefix(name, "fixed") {
+				e.fixed = value
+			} else if strings.HasPrefix(name, "pattern") {
+				e.pattern = value
+			}
+		}
+		if binding, ok := element["binding"].(map[string]interface{}); ok && binding["strength"] == "required" {
+			// STU3 profiles refer to value sets with valueSetReference or valueSetUri, and R4 ones with valueSet
+			reference, _ := binding["valueSetReference"].(map[string]interface{})
+			e.valueSet, _ = reference["reference"].(string)
+			if uri, ok := binding["valueSetUri"].(string); ok {
+				e.valueSet = uri
+			} else if canonical, ok := binding["valueSet"].(string); ok {
+				e.valueSet = canonical
+			}
+		}
+		p.elements = append(p.elements, e)
+	}
+	return p, nil
+}
+
+// lookupProfile returns a loaded profile by its canonical URL (with an optional |version)
+func lookupProfile(url string) *profile {
+	packageResources.RLock()
+	defer packageResources.RUnlock()
+	return packageResources.profiles[strings.SplitN(url, "|", 2)[0]]
+}
+
+// valueSetCodes returns the codes (as system|code) of a loaded value set, and false if it isn't loaded or its
+// codes can't be listed (e.g. its compose has filters, or includes a code system that isn't loaded)
+func valueSetCodes(url string) (map[string]bool, bool) {
+	packageResources.RLock()
+	defer packageResources.RUnlock()
+	return expandValueSet(strings.SplitN(url, "|", 2)[0], make(map[string]bool))
+}
+
+func expandValueSet(url string, expanding map[string]bool) (map[string]bool, bool) {
+	valueSet := packageResources.valueSets[url]
+	if valueSet == nil || expanding[url] {
+		return nil, false
+	}
+	expanding[url] = true
+	defer delete(expanding, url)
+
+	codes := make(map[string]bool)
+	if valueSet.Expansion != nil && len(valueSet.Expansion.Contains) > 0 {
+		var addContains func(contains []valueSetContains)
+		addContains = func(contains []valueSetContains) {
+			for _, c := range contains {
+				if c.Code != "" {
+					codes[c.System+"|"+c.Code] = true
+				}
+				addContains(c.Contains)
+			}
+		}
+		addContains(valueSet.Expansion.Contains)
+		return codes, true
+	}
+	if valueSet.Compose == nil {
+		return nil, false
+	}
+
+	for _, include := range valueSet.Compose.Include {
+		included, ok := includedCodes(include, expanding)
+		if !ok {
+			return nil, false
+		}
+		for code := range included {
+			codes[code] = true
+		}
+	}
+	for _, exclude := range valueSet.Compose.Exclude {
+		excluded, ok := includedCodes(exclude, expanding)
+		if !ok {
+			return nil, false
+		}
+		for code := range excluded {
+			delete(codes, code)
+		}
+	}
+	return codes, true
+}
+
+// includedCodes returns the codes of an include (or exclude) of the compose of a value set
+func includedCodes(include valueSetInclude, expanding map[string]bool) (map[string]bool, bool) {
+	if len(include.Filter) > 0 || (include.System != "" && len(include.ValueSet) > 0) {
+		return nil, false
+	}
+
+	codes := make(map[string]bool)
+	switch {
+	case len(include.Concept) > 0:
+		for _, concept := range include.Concept {
+			codes[include.System+"|"+concept.Code] = true
+		}
+	case include.System != "":
+		codeSystem := packageResources.codeSystems[include.System]
+		if codeSystem == nil || (codeSystem.Content != "" && codeSystem.Content != "complete") {
+			return nil, false
+		}
+		var addConcepts func(concepts []codeSystemConcept)
+		addConcepts = func(concepts []codeSystemConcept) {
+			for _, concept := range concepts {
+				codes[include.System+"|"+concept.Code] = true
+				addConcepts(concept.Concept)
+			}
+		}
+		addConcepts(codeSystem.Concept)
+	default:
+		for _, url := range include.ValueSet {
+			included, ok := expandValueSet(strings.SplitN(url, "|", 2)[0], expanding)
+			if !ok {
+				return nil, false
+			}
+			for code := range included {
+				codes[code] = true
+			}
+		}
+	}
+	return codes, true
+}
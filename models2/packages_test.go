@@ -0,0 +1,141 @@
+package models2
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writePackage writes a package.tgz with the given files (relative to its package folder)
+func writePackage(t *testing.T, dir string, files map[string]string) string {
+	fileName := filepath.Join(dir, "package.tgz")
+	file, err := os.Create(fileName)
+	assert.Nil(t, err)
+	defer file.Close()
+	gzipWriter := gzip.NewWriter(file)
+	defer gzipWriter.Close()
+	tarWriter := tar.NewWriter(gzipWriter)
+	defer tarWriter.Close()
+
+	for name, contents := range files {
+		err = tarWriter.WriteHeader(&tar.Header{Name: "package/" + name, Mode: 0644, Size: int64(len(contents)), Typeflag: tar.TypeReg})
+		assert.Nil(t, err)
+		_, err = tarWriter.Write([]byte(contents))
+		assert.Nil(t, err)
+	}
+	return fileName
+}
+
+const testProfileURL = "http://example.com/StructureDefinition/test-patient"
+
+func TestLoadPackageAndValidateProfiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "packages")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	fileName := writePackage(t, dir, map[string]string{
+		"package.json": `{"name": "example.profiles", "version": "1.0.0", "fhirVersions": ["3.0.1"]}`,
+		"StructureDefinition-test-patient.json": `{"resourceType": "StructureDefinition", "url": "` + testProfileURL + `",
+			"name": "TestPatient", "kind": "resource", "type": "Patient", "derivation": "constraint",
+			"baseDefinition": "http://hl7.org/fhir/StructureDefinition/Patient",
+			"snapshot": {"element": [
+				{"id": "Patient", "path": "Patient", "min": 0, "max": "*"},
+				{"id": "Patient.extension:race", "path": "Patient.extension", "sliceName": "race", "min": 1, "max": "1"},
+				{"id": "Patient.identifier", "path": "Patient.identifier", "min": 1, "max": "*"},
+				{"id": "Patient.identifier.system", "path": "Patient.identifier.system", "min": 1, "max": "1"},
+				{"id": "Patient.active", "path": "Patient.active", "min": 0, "max": "1", "fixedBoolean": true},
+				{"id": "Patient.name", "path": "Patient.name", "min": 0, "max": "1"},
+				{"id": "Patient.gender", "path": "Patient.gender", "min": 1, "max": "1",
+					"binding": {"strength": "required", "valueSetReference": {"reference": "http://example.com/ValueSet/test-genders"}}},
+				{"id": "Patient.deceased[x]", "path": "Patient.deceased[x]", "min": 0, "max": "1", "type": [{"code": "boolean"}]},
+				{"id": "Patient.maritalStatus", "path": "Patient.maritalStatus", "min": 0, "max": "1",
+					"binding": {"strength": "required", "valueSetUri": "http://example.com/ValueSet/marital|1.0.0"}},
+				{"id": "Patient.communication.language", "path": "Patient.communication.language", "min": 1, "max": "1",
+					"patternCodeableConcept": {"coding": [{"system": "urn:ietf:bcp:47"}]}}
+			]}}`,
+		"StructureDefinition-test-patient-born.json": `{"resourceType": "StructureDefinition",
+			"url": "http://example.com/StructureDefinition/test-patient-born", "name": "TestPatientBorn",
+			"kind": "resource", "type": "Patient", "derivation": "constraint", "baseDefinition": "` + testProfileURL + `",
+			"differential": {"element": [
+				{"id": "Patient", "path": "Patient"},
+				{"id": "Patient.birthDate", "path": "Patient.birthDate", "min": 1}
+			]}}`,
+		"StructureDefinition-test-extension.json": `{"resourceType": "StructureDefinition", "url": "http://example.com/StructureDefinition/test-extension",
+			"kind": "complex-type", "type": "Extension", "derivation": "constraint", "snapshot": {"element": []}}`,
+		"ValueSet-test-genders.json": `{"resourceType": "ValueSet", "url": "http://example.com/ValueSet/test-genders",
+			"compose": {"include": [{"system": "http://hl7.org/fhir/administrative-gender", "concept": [{"code": "male"}, {"code": "female"}]}]}}`,
+		"ValueSet-marital.json": `{"resourceType": "ValueSet", "url": "http://example.com/ValueSet/marital",
+			"compose": {"include": [{"system": "http://example.com/CodeSystem/marital"}]}}`,
+		"CodeSystem-marital.json": `{"resourceType": "CodeSystem", "url": "http://example.com/CodeSystem/marital", "content": "complete",
+			"concept": [{"code": "M"}, {"code": "U", "concept": [{"code": "S"}]}]}`,
+		"example/Patient-example.json": `{"resourceType": "Patient", "invalid": true}`,
+	})
+	name, err := LoadPackage(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, "example.profiles@1.0.0", name)
+	assert.Equal(t, []string{"http://example.com/StructureDefinition/test-patient", "http://example.com/StructureDefinition/test-patient-born"}, ProfileURLs())
+
+	issues := func(json string, profileURLs ...string) []ValidationIssue {
+		err := validateResourceJSON([]byte(json), profileURLs...)
+		if err == nil {
+			return nil
+		}
+		return err.(FhirValidationError).Issues
+	}
+
+	// Resources conforming to the profiles they claim
+	valid := `{"resourceType": "Patient", "meta": {"profile": ["` + testProfileURL + `", "http://example.com/not-loaded"]},
+		"identifier": [{"system": "http://example.com/mrn", "value": "1"}], "active": true, "name": [{"family": "Smith"}],
+		"gender": "female", "deceasedBoolean": false,
+		"maritalStatus": {"coding": [{"system": "http://example.com/CodeSystem/marital", "code": "S"}]},
+		"communication": [{"language": {"coding": [{"system": "urn:ietf:bcp:47", "code": "en"}]}}]}`
+	assert.Nil(t, issues(valid))
+	assert.Nil(t, issues(`{"resourceType": "Patient", "gender": "other"}`))
+
+	// and those that don't, listing each issue
+	invalid := `{"resourceType": "Patient", "meta": {"profile": ["` + testProfileURL + `"]},
+		"identifier": [{"value": "1"}], "active": false, "name": [{"family": "Smith"}, {"family": "Jones"}],
+		"gender": "other", "deceasedDateTime": "2019",
+		"maritalStatus": {"coding": [{"system": "http://example.com/CodeSystem/marital", "code": "X"}]},
+		"communication": [{"language": {"coding": [{"system": "urn:iso:std:iso:639", "code": "en"}]}}]}`
+	assert.Equal(t, []ValidationIssue{
+		{Code: "required", Diagnostics: "Patient.identifier.system is required by profile " + testProfileURL, Expression: "Patient.identifier[0].system"},
+		{Code: "value", Diagnostics: "Patient.active must be true (fixed by profile " + testProfileURL + ")", Expression: "Patient.active"},
+		{Code: "structure", Diagnostics: "Patient.name has 2 values (maximum cardinality 1 in profile " + testProfileURL + ")", Expression: "Patient.name"},
+		{Code: "code-invalid", Diagnostics: "other is not in the value set http://example.com/ValueSet/test-genders (required by profile " + testProfileURL + ")", Expression: "Patient.gender"},
+		{Code: "structure", Diagnostics: "deceasedDateTime is not one of the types allowed by profile " + testProfileURL + " (boolean)", Expression: "Patient.deceasedDateTime"},
+		{Code: "code-invalid", Diagnostics: "http://example.com/CodeSystem/marital|X is not in the value set http://example.com/ValueSet/marital|1.0.0 (required by profile " + testProfileURL + ")", Expression: "Patient.maritalStatus"},
+		{Code: "value", Diagnostics: `Patient.communication.language must match {"coding":[{"system":"urn:ietf:bcp:47"}]} (pattern of profile ` + testProfileURL + ")", Expression: "Patient.communication[0].language"},
+	}, issues(invalid))
+
+	// Structural issues are reported without checking profiles
+	assert.Equal(t, []ValidationIssue{
+		{Code: "code-invalid", Diagnostics: `"M" is not one of the codes of Patient.gender (male, female, other, unknown)`, Expression: "Patient.gender"},
+	}, issues(`{"resourceType": "Patient", "meta": {"profile": ["`+testProfileURL+`"]}, "gender": "M"}`))
+
+	// Explicitly requested profiles, including those derived from others with only a differential
+	assert.Equal(t, []ValidationIssue{
+		{Code: "required", Diagnostics: "Patient.identifier is required by profile " + testProfileURL, Expression: "Patient.identifier"},
+	}, issues(`{"resourceType": "Patient", "gender": "male"}`, testProfileURL+"|1.0.0"))
+	assert.Equal(t, []ValidationIssue{
+		{Code: "required", Diagnostics: "Patient.birthDate is required by profile http://example.com/StructureDefinition/test-patient-born", Expression: "Patient.birthDate"},
+	}, issues(valid, "http://example.com/StructureDefinition/test-patient-born"))
+	assert.Equal(t, []ValidationIssue{
+		{Code: "not-supported", Diagnostics: "profile http://example.com/not-loaded isn't loaded", Expression: "Patient"},
+	}, issues(valid, "http://example.com/not-loaded"))
+	assert.Equal(t, []ValidationIssue{
+		{Code: "invalid", Diagnostics: "profile " + testProfileURL + " is for Patient resources", Expression: "Observation.meta.profile[0]"},
+	}, issues(`{"resourceType": "Observation", "meta": {"profile": ["`+testProfileURL+`"]}, "status": "final", "code": {"text": "x"}}`))
+
+	// Packages for other versions of FHIR can't be loaded
+	fileName = writePackage(t, dir, map[string]string{
+		"package.json": `{"name": "example.r4", "version": "1.0.0", "fhirVersions": ["4.0.1"]}`,
+	})
+	_, err = LoadPackage(fileName)
+	assert.EqualError(t, err, "LoadPackage: example.r4@1.0.0 is for FHIR 4.0.1, not STU3")
+}
@@ -40,6 +40,13 @@ func (p *positionInfo) atDate() bool {
 func (p *positionInfo) atInstant() bool {
 	return p.element == "instant"
 }
+func (p *positionInfo) atSearchableString() bool {
+	switch p.element {
+	case "string", "code", "uri", "markdown":
+		return true
+	}
+	return false
+}
 func (p *positionInfo) downTo(key string, valueJson []byte) positionInfo {
 	result := p.__downTo(key, valueJson)
 	debug("downTo %s --> %#v", key, result)
@@ -0,0 +1,270 @@
+package models2
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ValidateResourceAgainstProfile validates a resource like ValidateResource, and also against a profile loaded
+// from a package (see LoadPackage), given by its canonical URL, whether or not its meta.profile claims it.
+func ValidateResourceAgainstProfile(resource *Resource, profileURL string) error {
+	return validateResourceJSON(resource.JsonBytes(), profileURL)
+}
+
+// elementValue is a value of an element of a resource, with its FHIRPath
+type elementValue struct {
+	value      interface{}
+	expression string
+}
+
+// validateProfiles validates a resource against the given profiles, and the loaded profiles that its meta.profile
+// claims it conforms to (others are ignored).  Profiles of contained resources and bundle entries aren't checked.
+func (v *validator) validateProfiles(obj map[string]interface{}, resourceType string, profileURLs []string) {
+	validated := make(map[string]bool)
+	for _, url := range profileURLs {
+		p := lookupProfile(url)
+		if p == nil {
+			v.addIssue("not-supported", resourceType, "profile %s isn't loaded", url)
+			continue
+		}
+		v.validateProfileOf(obj, resourceType, p, resourceType, validated)
+	}
+
+	meta, _ := obj["meta"].(map[string]interface{})
+	claimed, _ := meta["profile"].([]interface{})
+	for i, url := range claimed {
+		url, _ := url.(string)
+		if p := lookupProfile(url); p != nil {
+			v.validateProfileOf(obj, resourceType, p, resourceType+".meta.profile["+strconv.Itoa(i)+"]", validated)
+		}
+	}
+}
+
+func (v *validator) validateProfileOf(obj map[string]interface{}, resourceType string, p *profile, expression string, validated map[string]bool) {
+	if validated[p.url] {
+		return
+	}
+	validated[p.url] = true
+	if p.resourceType != resourceType {
+		v.addIssue("invalid", expression, "profile %s is for %s resources", p.url, p.resourceType)
+		return
+	}
+
+	if p.differential {
+		// the constraints of the profile it's derived from aren't repeated in its differential
+		if base := lookupProfile(p.baseDefinition); base != nil {
+			v.validateProfileOf(obj, resourceType, base, expression, validated)
+		}
+	}
+
+	for _, element := range p.elements {
+		i := strings.LastIndex(element.path, ".")
+		parents := childValues([]elementValue{{obj, resourceType}}, strings.Split(element.path[:i], ".")[1:])
+		for _, parent := range parents {
+			if parentObj, ok := parent.value.(map[string]interface{}); ok {
+				v.validateProfileElement(parentObj, parent.expression, element.path[i+1:], element, p)
+			}
+		}
+	}
+}
+
+// childValues returns the values of the descendants of values with a path of element names
+func childValues(values []elementValue, names []string) []elementValue {
+	for _, name := range names {
+		var children []elementValue
+		for _, value := range values {
+			obj, ok := value.value.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			for _, key := range elementKeys(obj, name) {
+				children = append(children, keyValues(obj, key, value.expression)...)
+			}
+		}
+		values = children
+	}
+	return values
+}
+
+// elementKeys returns the keys of an object's values of an element, e.g. valueQuantity for value[x]
+func elementKeys(obj map[string]interface{}, name string) []string {
+	choice := strings.TrimSuffix(name, "[x]")
+	if choice == name {
+		_, hasValue := obj[name]
+		_, hasExtension := obj["_"+name]
+		if hasValue || hasExtension {
+			return []string{name}
+		}
+		return nil
+	}
+
+	found := make(map[string]bool)
+	for key := range obj {
+		key = strings.TrimPrefix(key, "_")
+		if len(key) > len(choice) && strings.HasPrefix(key, choice) && strings.ToUpper(key[len(choice):len(choice)+1]) == key[len(choice):len(choice)+1] {
+			found[key] = true
+		}
+	}
+	keys := make([]string, 0, len(found))
+	for key := range found {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// keyValues returns the values of a key of an object (one, or each in an array)
+func keyValues(obj map[string]interface{}, key string, expression string) []elementValue {
+	value, found := obj[key]
+	if !found {
+		return nil
+	}
+	values, isArray := value.([]interface{})
+	if !isArray {
+		return []elementValue{{value, expression + "." + key}}
+	}
+	result := make([]elementValue, 0, len(values))
+	for i, item := range values {
+		result = append(result, elementValue{item, expression + "." + key + "[" + strconv.Itoa(i) + "]"})
+	}
+	return result
+}
+
+// validateProfileElement checks an element of an object against the constraints of a profile: its cardinality,
+// types, fixed value or pattern, and the value set of a required binding
+func (v *validator) validateProfileElement(obj map[string]interface{}, expression string, name string, element profileElement, p *profile) {
+	count := 0
+	var values []elementValue
+	for _, key := range elementKeys(obj, name) {
+		if choice := strings.TrimSuffix(name, "[x]"); choice != name && len(element.types) > 0 && !allowsType(element.types, key[len(choice):]) {
+			v.addIssue("structure", expression+"."+key, "%s is not one of the types allowed by profile %s (%s)", key, p.url, strings.Join(element.types, ", "))
+		}
+		found := keyValues(obj, key, expression)
+		if extensions := len(keyValues(obj, "_"+key, expression)); extensions > len(found) {
+			count += extensions // primitive values with only extensions
+		} else {
+			count += len(found)
+		}
+		values = append(values, found...)
+	}
+
+	if count < element.min {
+		if count == 0 {
+			v.addIssue("required", expression+"."+name, "%s is required by profile %s", element.path, p.url)
+		} else {
+			v.addIssue("required", expression+"."+name, "%s has %d values (minimum cardinality %d in profile %s)", element.path, count, element.min, p.url)
+		}
+	}
+	if element.max >= 0 && count > element.max {
+		if element.max == 0 {
+			v.addIssue("structure", expression+"."+name, "%s is not allowed by profile %s", element.path, p.url)
+		} else {
+			v.addIssue("structure", expression+"."+name, "%s has %d values (maximum cardinality %d in profile %s)", element.path, count, element.max, p.url)
+		}
+	}
+
+	for _, value := range values {
+		if element.fixed != nil && !reflect.DeepEqual(value.value, element.fixed) {
+			v.addIssue("value", value.expression, "%s must be %s (fixed by profile %s)", element.path, jsonString(element.fixed), p.url)
+		}
+		if element.pattern != nil && !matchesPattern(value.value, element.pattern) {
+			v.addIssue("value", value.expression, "%s must match %s (pattern of profile %s)", element.path, jsonString(element.pattern), p.url)
+		}
+		if element.valueSet != "" {
+			codes, listable := valueSetCodes(element.valueSet)
+			if found, valid := hasBindingCode(value.value, codes); listable && !valid {
+				v.addIssue("code-invalid", value.expression, "%s is not in the value set %s (required by profile %s)", found, element.valueSet, p.url)
+			}
+		}
+	}
+}
+
+// allowsType checks whether the type of the key of a choice element (e.g. DateTime for effectiveDateTime) is one of
+// the types of its definition
+func allowsType(types []string, keyType string) bool {
+	for _, t := range types {
+		if t != "" && strings.ToUpper(t[:1])+t[1:] == keyType {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesPattern checks whether a value has all the properties of a pattern, with arrays matching if each item of
+// the pattern matches an item of the value
+func matchesPattern(value interface{}, pattern interface{}) bool {
+	switch pattern := pattern.(type) {
+	case map[string]interface{}:
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for key, patternValue := range pattern {
+			if !matchesPattern(obj[key], patternValue) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		values, ok := value.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, patternItem := range pattern {
+			matched := false
+			for _, item := range values {
+				if matchesPattern(item, patternItem) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(value, pattern)
+	}
+}
+
+// hasBindingCode checks whether a code, Coding or one of the codings of a CodeableConcept is one of the codes
+// (as system|code) of a value set, returning the codes that were found
+func hasBindingCode(value interface{}, codes map[string]bool) (string, bool) {
+	if code, isCode := value.(string); isCode {
+		for systemAndCode := range codes {
+			if strings.HasSuffix(systemAndCode, "|"+code) {
+				return code, true
+			}
+		}
+		return code, false
+	}
+
+	obj, _ := value.(map[string]interface{})
+	codings := []interface{}{obj}
+	if _, isCodeableConcept := obj["coding"]; isCodeableConcept {
+		codings, _ = obj["coding"].([]interface{})
+	}
+	var found []string
+	for _, coding := range codings {
+		coding, _ := coding.(map[string]interface{})
+		system, _ := coding["system"].(string)
+		code, hasCode := coding["code"].(string)
+		if !hasCode {
+			continue
+		}
+		if codes[system+"|"+code] {
+			return "", true
+		}
+		found = append(found, system+"|"+code)
+	}
+	return strings.Join(found, ", "), len(found) == 0
+}
+
+func jsonString(value interface{}) string {
+	jsonBytes, _ := json.Marshal(value)
+	return string(jsonBytes)
+}
@@ -29,6 +29,7 @@ type Resource struct {
 	transformReferencesMap map[string]string
 	cachedBson             *[]bson.E
 	whatToEncrypt          WhatToEncrypt
+	compartments           []string
 }
 
 func (r *Resource) JsonBytes() []byte {
@@ -97,10 +98,48 @@ func (r *Resource) SetTransformReferencesMap(transformReferencesMap map[string]s
 	r.cachedBson = nil
 }
 
+// AddMetaTag appends a coding to meta.tag, e.g. to mark a search result as SUBSETTED
+func (r *Resource) AddMetaTag(system string, code string) error {
+	var tags []json.RawMessage
+	existing, dataType, _, err := jsonparser.Get(r.jsonBytes, "meta", "tag")
+	if err == nil && dataType == jsonparser.Array {
+		if err := json.Unmarshal(existing, &tags); err != nil {
+			return errors.Wrap(err, "AddMetaTag: failed to parse meta.tag")
+		}
+	}
+
+	tag, err := json.Marshal(map[string]string{"system": system, "code": code})
+	if err != nil {
+		return errors.Wrap(err, "AddMetaTag: json.Marshal failed")
+	}
+	tagsJson, err := json.Marshal(append(tags, tag))
+	if err != nil {
+		return errors.Wrap(err, "AddMetaTag: json.Marshal failed")
+	}
+
+	jsonBytes, err := jsonparser.Set(r.jsonBytes, tagsJson, "meta", "tag")
+	if err != nil {
+		return errors.Wrap(err, "AddMetaTag: jsonparser.Set failed")
+	}
+	r.jsonBytes = jsonBytes
+	r.cachedBson = nil
+	return nil
+}
+
 func (r *Resource) SetWhatToEncrypt(whatToEncrypt WhatToEncrypt) {
 	r.whatToEncrypt = whatToEncrypt
 }
 
+// SetCompartments sets the compartments the resource is in (e.g. ["Patient/123"]), which are
+// stored in its CompartmentsField (see search.SetResourceCompartments)
+func (r *Resource) SetCompartments(compartments []string) {
+	if compartments == nil {
+		compartments = []string{}
+	}
+	r.compartments = compartments
+	r.cachedBson = nil
+}
+
 func dumpMalformedJson(jsonBytes []byte, jsonError error, failedRequestsDir string) error {
 	currentTime := time.Now()
 	timestamp := currentTime.Format("2006-01-02-15-04-05.000000")
@@ -263,6 +302,9 @@ func (r *Resource) GetBSON() (interface{}, error) {
 		debug("setBson: meta now %+v", meta)
 		// debug("setBson: bsonDoc2 now %+v", bsonDoc2)
 	}
+	if r.compartments != nil {
+		setBsonValue(&bsonDoc2, CompartmentsField, r.compartments, len(bsonDoc2))
+	}
 
 	r.cachedBson = &bsonDoc2
 	return bsonDoc2, err
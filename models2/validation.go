@@ -0,0 +1,293 @@
+package models2
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// elementDefinition describes a child element of a resource, data type or backbone element
+type elementDefinition struct {
+	name  string   // e.g. gender, or value[x] for choice elements
+	min   int      // minimum cardinality
+	max   int      // maximum cardinality (-1 for *)
+	types []string // the types of choice elements
+	codes []string // the codes of a required binding (as system|code for Codings and CodeableConcepts)
+}
+
+// ValidationIssue is a problem with a resource found by ValidateResource
+type ValidationIssue struct {
+	Code        string // the type of the issue (e.g. required, structure, value or code-invalid)
+	Diagnostics string
+	Expression  string // the FHIRPath of the element, e.g. Patient.contact[0].gender
+}
+
+// FhirValidationError is the error for resources that aren't valid, listing each issue
+type FhirValidationError struct {
+	Issues []ValidationIssue
+}
+
+func (e FhirValidationError) Error() string {
+	messages := make([]string, 0, len(e.Issues))
+	for _, issue := range e.Issues {
+		messages = append(messages, issue.Expression+": "+issue.Diagnostics)
+	}
+	return "FHIR validation failed: " + strings.Join(messages, "; ")
+}
+
+// ValidateResource checks the structure of a resource against the FHIR definitions of its type: the cardinality
+// of each element, that values have the element's datatype, and that codes are from the value sets of required
+// bindings.  Valid resources are also checked against the profiles in their meta.profile that have been loaded
+// from packages (see LoadPackage).  It returns a FhirValidationError listing each issue if there are any.
+func ValidateResource(resource *Resource) error {
+	return validateResourceJSON(resource.JsonBytes())
+}
+
+func validateResourceJSON(jsonBytes []byte, profileURLs ...string) error {
+	decoder := json.NewDecoder(bytes.NewReader(jsonBytes))
+	decoder.UseNumber()
+	var obj map[string]interface{}
+	if err := decoder.Decode(&obj); err != nil {
+		return FhirValidationError{Issues: []ValidationIssue{{Code: "structure", Diagnostics: "invalid JSON: " + err.Error()}}}
+	}
+
+	var v validator
+	resourceType, _ := obj["resourceType"].(string)
+	v.validateResource(obj, resourceType)
+	if len(v.issues) == 0 {
+		v.validateProfiles(obj, resourceType, profileURLs)
+	}
+	if len(v.issues) > 0 {
+		return FhirValidationError{Issues: v.issues}
+	}
+	return nil
+}
+
+var (
+	// from the regular expressions of the FHIR STU3 primitive types
+	fhirDateRegex     = regexp.MustCompile(`^-?[0-9]{4}(-(0[1-9]|1[0-2])(-(0[0-9]|[1-2][0-9]|3[0-1]))?)?$`)
+	fhirDateTimeRegex = regexp.MustCompile(`^-?[0-9]{4}(-(0[1-9]|1[0-2])(-(0[0-9]|[1-2][0-9]|3[0-1])(T([01][0-9]|2[0-3]):[0-5][0-9]:([0-5][0-9]|60)(\.[0-9]+)?(Z|(\+|-)((0[0-9]|1[0-3]):[0-5][0-9]|14:00)))?)?)?$`)
+	fhirInstantRegex  = regexp.MustCompile(`^[0-9]{4}-(0[1-9]|1[0-2])-(0[1-9]|[1-2][0-9]|3[0-1])T([01][0-9]|2[0-3]):[0-5][0-9]:([0-5][0-9]|60)(\.[0-9]+)?(Z|(\+|-)((0[0-9]|1[0-3]):[0-5][0-9]|14:00))$`)
+	fhirTimeRegex     = regexp.MustCompile(`^([01][0-9]|2[0-3]):[0-5][0-9]:([0-5][0-9]|60)(\.[0-9]+)?$`)
+	fhirIdRegex       = regexp.MustCompile(`^[A-Za-z0-9\-\.]{1,64}$`)
+	fhirCodeRegex     = regexp.MustCompile(`^[^\s]+( [^\s]+)*$`)
+	fhirOidRegex      = regexp.MustCompile(`^urn:oid:[0-2](\.(0|[1-9][0-9]*))+$`)
+)
+
+type validator struct {
+	issues []ValidationIssue
+}
+
+func (v *validator) addIssue(code string, expression string, format string, a ...interface{}) {
+	v.issues = append(v.issues, ValidationIssue{
+		Code:        code,
+		Diagnostics: fmt.Sprintf(format, a...),
+		Expression:  expression,
+	})
+}
+
+func (v *validator) validateResource(obj map[string]interface{}, expression string) {
+	resourceType, _ := obj["resourceType"].(string)
+	if _, known := fhirElementDefinitions[resourceType]; !known || strings.Contains(resourceType, ".") {
+		v.addIssue("structure", expression, "unknown resourceType %q", resourceType)
+		return
+	}
+	v.validateElements(obj, resourceType, expression)
+}
+
+// validateElements validates the elements of a resource, data type or backbone element
+func (v *validator) validateElements(obj map[string]interface{}, definitionPath string, expression string) {
+	known := make(map[string]bool, len(obj))
+	if definitionPath == obj["resourceType"] {
+		known["resourceType"] = true
+	}
+
+	for _, definition := range fhirElementDefinitions[definitionPath] {
+		// a choice element is present as one of its types, e.g. valueQuantity for value[x]
+		names := []string{definition.name}
+		if choice := strings.TrimSuffix(definition.name, "[x]"); choice != definition.name {
+			names = names[:0]
+			for _, choiceType := range definition.types {
+				names = append(names, choice+strings.ToUpper(choiceType[:1])+choiceType[1:])
+			}
+		}
+
+		var present []string
+		for _, name := range names {
+			known[name] = true
+			known["_"+name] = true // extensions of primitive values
+			_, hasValue := obj[name]
+			_, hasExtension := obj["_"+name]
+			if hasValue {
+				v.validateElement(obj[name], definition, definitionPath+"."+name, expression+"."+name)
+			}
+			if hasValue || hasExtension {
+				present = append(present, name)
+			}
+		}
+
+		if len(present) > 1 {
+			v.addIssue("structure", expression+"."+definition.name, "only one of %s may be present", strings.Join(present, ", "))
+		}
+		if len(present) == 0 && definition.min > 0 {
+			v.addIssue("required", expression+"."+definition.name, "%s.%s is required (minimum cardinality %d)", definitionPath, definition.name, definition.min)
+		}
+	}
+
+	var unknown []string
+	for name := range obj {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		v.addIssue("structure", expression+"."+name, "unknown element %s.%s", definitionPath, name)
+	}
+}
+
+// validateElement checks the cardinality of an element and validates its values
+func (v *validator) validateElement(value interface{}, definition elementDefinition, path string, expression string) {
+	values, isArray := value.([]interface{})
+	if !isArray {
+		if definition.max != 1 {
+			v.addIssue("structure", expression, "%s must be an array (maximum cardinality %s)", path, maxCardinality(definition.max))
+		}
+		v.validateValue(value, definition, path, expression)
+		return
+	}
+
+	if definition.max == 1 {
+		v.addIssue("structure", expression, "%s must not be an array (maximum cardinality 1)", path)
+	} else if definition.max > 1 && len(values) > definition.max {
+		v.addIssue("structure", expression, "%s has %d values (maximum cardinality %d)", path, len(values), definition.max)
+	}
+	if len(values) < definition.min {
+		v.addIssue("required", expression, "%s has %d values (minimum cardinality %d)", path, len(values), definition.min)
+	}
+	for i, item := range values {
+		v.validateValue(item, definition, path, expression+"["+strconv.Itoa(i)+"]")
+	}
+}
+
+func maxCardinality(max int) string {
+	if max < 0 {
+		return "*"
+	}
+	return strconv.Itoa(max)
+}
+
+// validateValue checks that a value has the datatype of its element, and is from the value set of a required binding
+func (v *validator) validateValue(value interface{}, definition elementDefinition, path string, expression string) {
+	fhirType, found := fhirTypes[path]
+	if !found {
+		v.addIssue("structure", expression, "unknown element %s", path)
+		return
+	}
+
+	switch fhirType {
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			v.addIssue("value", expression, "%s must be a boolean", path)
+		}
+	case "integer", "unsignedInt", "positiveInt":
+		number, ok := value.(json.Number)
+		integer, err := strconv.ParseInt(string(number), 10, 32)
+		if !ok || err != nil {
+			v.addIssue("value", expression, "%s must be an integer", path)
+		} else if fhirType == "unsignedInt" && integer < 0 {
+			v.addIssue("value", expression, "%s must not be negative", path)
+		} else if fhirType == "positiveInt" && integer < 1 {
+			v.addIssue("value", expression, "%s must be positive", path)
+		}
+	case "decimal":
+		number, ok := value.(json.Number)
+		decimal, err := number.Float64()
+		if !ok || err != nil || math.IsInf(decimal, 0) {
+			v.addIssue("value", expression, "%s must be a decimal", path)
+		}
+	case "string", "markdown", "uri", "base64Binary", "xhtml", "code", "id", "oid", "date", "dateTime", "instant", "time":
+		str, ok := value.(string)
+		if !ok {
+			v.addIssue("value", expression, "%s must be a string", path)
+			return
+		}
+		formats := map[string]*regexp.Regexp{
+			"code":     fhirCodeRegex,
+			"id":       fhirIdRegex,
+			"oid":      fhirOidRegex,
+			"date":     fhirDateRegex,
+			"dateTime": fhirDateTimeRegex,
+			"instant":  fhirInstantRegex,
+			"time":     fhirTimeRegex,
+		}
+		if format := formats[fhirType]; format != nil && !format.MatchString(str) {
+			v.addIssue("value", expression, "%q is not a valid %s", str, fhirType)
+			return
+		}
+		if fhirType == "code" && len(definition.codes) > 0 && !containsString(definition.codes, str) {
+			if len(definition.codes) <= 10 {
+				v.addIssue("code-invalid", expression, "%q is not one of the codes of %s (%s)", str, path, strings.Join(definition.codes, ", "))
+			} else {
+				v.addIssue("code-invalid", expression, "%q is not one of the codes of %s", str, path)
+			}
+		}
+	default:
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			v.addIssue("structure", expression, "%s must be an object (%s)", path, fhirType)
+			return
+		}
+		switch fhirType {
+		case "Resource":
+			v.validateResource(obj, expression)
+			return
+		case "BackboneElement", "Element":
+			v.validateElements(obj, path, expression)
+		default:
+			// data types, and backbone elements referred to by others, e.g. Questionnaire.item.item
+			v.validateElements(obj, fhirType, expression)
+		}
+		if len(definition.codes) > 0 {
+			v.validateCodings(obj, fhirType, definition.codes, path, expression)
+		}
+	}
+}
+
+// validateCodings checks that a Coding, or one of the codings of a CodeableConcept, is from the value set of a
+// required binding
+func (v *validator) validateCodings(obj map[string]interface{}, fhirType string, codes []string, path string, expression string) {
+	codings := []interface{}{obj}
+	if fhirType == "CodeableConcept" {
+		codings, _ = obj["coding"].([]interface{})
+		if len(codings) == 0 {
+			return // text alone
+		}
+	}
+
+	var found []string
+	for _, coding := range codings {
+		coding, _ := coding.(map[string]interface{})
+		system, _ := coding["system"].(string)
+		code, _ := coding["code"].(string)
+		if containsString(codes, system+"|"+code) {
+			return
+		}
+		found = append(found, system+"|"+code)
+	}
+	v.addIssue("code-invalid", expression, "%s is not one of the codes of %s", strings.Join(found, ", "), path)
+}
+
+func containsString(strs []string, str string) bool {
+	for _, s := range strs {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package models2
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateResource(t *testing.T) {
+	issues := func(json string) []ValidationIssue {
+		err := validateResourceJSON([]byte(json))
+		if err == nil {
+			return nil
+		}
+		return err.(FhirValidationError).Issues
+	}
+
+	// Valid resources, including choice elements, primitive extensions, backbone and contained resources
+	assert.Nil(t, issues(`{"resourceType": "Patient", "id": "123", "gender": "female", "birthDate": "1970-01",
+		"deceasedDateTime": "2019-03-04T10:00:00+10:00", "_gender": {"extension": [{"url": "http://example.com", "valueString": "x"}]},
+		"contact": [{"gender": "male", "name": {"family": "Smith", "given": ["John"]}}],
+		"contained": [{"resourceType": "Organization", "id": "org", "active": true}]}`))
+	assert.Nil(t, issues(`{"resourceType": "Observation", "status": "final", "code": {"text": "weight"},
+		"valueQuantity": {"value": 72.5, "unit": "kg"}, "component": [{"code": {"text": "x"}, "valueString": "3"}]}`))
+	assert.Nil(t, issues(`{"resourceType": "Goal", "status": "in-progress", "description": {"text": "walk"}}`))
+	assert.Nil(t, issues(`{"resourceType": "Questionnaire", "status": "draft", "item": [{"linkId": "1", "type": "group",
+		"item": [{"linkId": "1.1", "type": "string"}]}]}`))
+
+	// Cardinality
+	assert.Equal(t, []ValidationIssue{
+		{Code: "required", Diagnostics: "Observation.status is required (minimum cardinality 1)", Expression: "Observation.status"},
+	}, issues(`{"resourceType": "Observation", "code": {"text": "weight"}}`))
+	assert.Equal(t, []ValidationIssue{
+		{Code: "structure", Diagnostics: "Patient.name must be an array (maximum cardinality *)", Expression: "Patient.name"},
+		{Code: "structure", Diagnostics: "Patient.gender must not be an array (maximum cardinality 1)", Expression: "Patient.gender"},
+	}, issues(`{"resourceType": "Patient", "gender": ["male"], "name": {"family": "Smith"}}`))
+	assert.Equal(t, []ValidationIssue{
+		{Code: "structure", Diagnostics: "only one of deceasedBoolean, deceasedDateTime may be present", Expression: "Patient.deceased[x]"},
+	}, issues(`{"resourceType": "Patient", "deceasedBoolean": true, "deceasedDateTime": "2019"}`))
+	assert.Equal(t, []ValidationIssue{
+		{Code: "required", Diagnostics: "Questionnaire.item.linkId is required (minimum cardinality 1)", Expression: "Questionnaire.item[0].item[1].linkId"},
+	}, issues(`{"resourceType": "Questionnaire", "status": "draft", "item": [{"linkId": "1", "type": "group",
+		"item": [{"linkId": "1.1", "type": "string"}, {"type": "string"}]}]}`))
+
+	// Datatypes
+	assert.Equal(t, []ValidationIssue{
+		{Code: "value", Diagnostics: "Patient.active must be a boolean", Expression: "Patient.active"},
+		{Code: "value", Diagnostics: "HumanName.family must be a string", Expression: "Patient.name[0].family"},
+		{Code: "value", Diagnostics: `"01/02/1970" is not a valid date`, Expression: "Patient.birthDate"},
+		{Code: "value", Diagnostics: "Patient.multipleBirthInteger must be an integer", Expression: "Patient.multipleBirthInteger"},
+		{Code: "structure", Diagnostics: "Patient.contact must be an object (BackboneElement)", Expression: "Patient.contact[0]"},
+	}, issues(`{"resourceType": "Patient", "active": "yes", "birthDate": "01/02/1970", "multipleBirthInteger": 1.5,
+		"contact": ["John"], "name": [{"family": 123}]}`))
+
+	// Unknown elements and resource types
+	assert.Equal(t, []ValidationIssue{
+		{Code: "structure", Diagnostics: `unknown resourceType "Wrong"`, Expression: "Patient.contained[0]"},
+	}, issues(`{"resourceType": "Patient", "contained": [{"resourceType": "Wrong"}]}`))
+	assert.Equal(t, []ValidationIssue{
+		{Code: "structure", Diagnostics: "unknown element Patient.contact.foo", Expression: "Patient.contact[0].foo"},
+		{Code: "structure", Diagnostics: "unknown element Patient.sex", Expression: "Patient.sex"},
+	}, issues(`{"resourceType": "Patient", "sex": "male", "contact": [{"foo": 1}]}`))
+
+	// Required bindings
+	assert.Equal(t, []ValidationIssue{
+		{Code: "code-invalid", Diagnostics: `"M" is not one of the codes of Patient.gender (male, female, other, unknown)`, Expression: "Patient.gender"},
+	}, issues(`{"resourceType": "Patient", "gender": "M"}`))
+	assert.Equal(t, []ValidationIssue{
+		{Code: "code-invalid", Diagnostics: "http://example.com|bad is not one of the codes of SupplyDelivery.type", Expression: "SupplyDelivery.type"},
+	}, issues(`{"resourceType": "SupplyDelivery", "type": {"coding": [{"system": "http://example.com", "code": "bad"}]}}`))
+	assert.Nil(t, issues(`{"resourceType": "SupplyDelivery", "type": {"coding": [{"system": "http://example.com", "code": "bad"},
+		{"system": "http://hl7.org/fhir/supply-item-type", "code": "medication"}]}}`))
+
+	// Resources in bundles
+	jsonBytes, err := ioutil.ReadFile("../fixtures/bundle-transaction.json")
+	assert.Nil(t, err)
+	assert.Nil(t, validateResourceJSON(jsonBytes))
+	assert.Equal(t, []ValidationIssue{
+		{Code: "required", Diagnostics: "Bundle.type is required (minimum cardinality 1)", Expression: "Bundle.type"},
+		{Code: "code-invalid", Diagnostics: `"M" is not one of the codes of Patient.gender (male, female, other, unknown)`, Expression: "Bundle.entry[0].resource.gender"},
+	}, issues(`{"resourceType": "Bundle", "entry": [{"resource": {"resourceType": "Patient", "gender": "M"}}]}`))
+
+	err = validateResourceJSON([]byte(`{"resourceType": "Patient", "gender": "M"}`))
+	assert.EqualError(t, err, `FHIR validation failed: Patient.gender: "M" is not one of the codes of Patient.gender (male, female, other, unknown)`)
+}
@@ -0,0 +1,42 @@
+package search
+
+import (
+	moptions "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SetCollation sets the collation (e.g. the ICU locale "fr") used to sort strings, so that accented
+// names sort correctly for non-English deployments.  If its strength is 1 or 2 (i.e. it ignores case),
+// the collation is also used for case-insensitive matches rather than case-insensitive regexes.
+// Indexes are only used by searches with the same collation, so they should be created with it too.
+// A nil collation restores MongoDB's binary comparison of strings.
+func (m *MongoSearcher) SetCollation(collation *moptions.Collation) {
+	m.collation = collation
+}
+
+// collationMatchesCaseInsensitively checks if the searcher's collation ignores case, so that
+// it can be used for case-insensitive matches of whole strings
+func (m *MongoSearcher) collationMatchesCaseInsensitively() bool {
+	return m.collation != nil && (m.collation.Strength == 1 || m.collation.Strength == 2) && !m.collation.CaseLevel
+}
+
+// searchCollation returns the collation to search with, or nil if the search doesn't need one:
+// searches sorted by strings need it, as do all searches if it's used for case-insensitive matches
+func (m *MongoSearcher) searchCollation(options *QueryOptions) *moptions.Collation {
+	if m.collation == nil {
+		return nil
+	}
+	if m.collationMatchesCaseInsensitively() || (options != nil && options.sortsByString()) {
+		return m.collation
+	}
+	return nil
+}
+
+// sortsByString checks if any of the sort parameters is a string
+func (o *QueryOptions) sortsByString() bool {
+	for _, sort := range o.Sort {
+		if sort.Parameter.Type == "string" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,218 @@
+package search
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/eug48/fhir/models2"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// CompartmentQueryParam represents the _compartment search parameter (not in the FHIR
+// spec), which matches the resources in a compartment, e.g. "Observation?_compartment=Patient/123"
+// matches the observations in the compartment of Patient/123.  Resources are in a compartment
+// if any of the search parameters in its CompartmentDefinitions refers to the resource whose
+// compartment it is, which is also in its own compartment.  It's used for compartment searches
+// (e.g. GET /Patient/123/Observation).
+type CompartmentQueryParam struct {
+	SearchParamInfo
+	Compartment string
+	ID          string
+}
+
+func (p *CompartmentQueryParam) getInfo() SearchParamInfo {
+	return p.SearchParamInfo
+}
+
+func (p *CompartmentQueryParam) setInfo(info SearchParamInfo) {
+	p.SearchParamInfo = info
+}
+
+func (p *CompartmentQueryParam) getQueryParamAndValue() (string, string) {
+	return queryParamAndValue(p.SearchParamInfo, p.Compartment+"/"+p.ID)
+}
+
+// ParseCompartmentQueryParam parses a _compartment value (e.g. "Patient/123") and returns
+// a pointer to a CompartmentQueryParam for the given resource, which must be one that may
+// be in the compartment.
+func ParseCompartmentQueryParam(resource, value string) *CompartmentQueryParam {
+	info := SearchParamInfo{Resource: resource, Name: CompartmentParam, Type: "compartment"}
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 || parts[1] == "" || CompartmentDefinitions[parts[0]] == nil {
+		panic(createInvalidSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", CompartmentParam)))
+	}
+	if !contains(CompartmentResourceTypes(parts[0]), resource) {
+		panic(createInvalidSearchError("MSG_PARAM_INVALID", fmt.Sprintf("%s resources aren't in the %s compartment", resource, parts[0])))
+	}
+	return &CompartmentQueryParam{SearchParamInfo: info, Compartment: parts[0], ID: parts[1]}
+}
+
+// CompartmentResourceTypes returns the types of resources that may be in a compartment (in name order)
+func CompartmentResourceTypes(compartment string) []string {
+	definition := CompartmentDefinitions[compartment]
+	if definition == nil {
+		return nil
+	}
+	types := make([]string, 0, len(definition)+1)
+	for resourceType := range definition {
+		types = append(types, resourceType)
+	}
+	if definition[compartment] == nil {
+		// The resource whose compartment it is
+		types = append(types, compartment)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// compartmentReferenceParams returns the reference search parameters of a resource that refer to the
+// resource whose compartment it's in.  The others in the CompartmentDefinitions (e.g. AuditEvent's
+// chained agent.patient, or {def} for the resource whose compartment it is) aren't used.
+func compartmentReferenceParams(compartment, resource string) []SearchParamInfo {
+	var params []SearchParamInfo
+	for _, name := range CompartmentDefinitions[compartment][resource] {
+		info, ok := SearchParameters()[resource][name]
+		if ok && info.Type == "reference" && isValidTarget(compartment, info) {
+			params = append(params, info)
+		}
+	}
+	return params
+}
+
+// SetPrecomputedCompartments makes compartment searches match the compartments stored with resources
+// (see SetResourceCompartments) rather than any of the compartment's search parameters, so that they
+// can use a single index.  It should only be enabled once every resource has been stored (or re-stored)
+// with its compartments, since resources without them won't be found.
+func (m *MongoSearcher) SetPrecomputedCompartments(enabled bool) {
+	m.precomputedCompartments = enabled
+}
+
+// SetResourceCompartments sets the compartments a resource is in (see ResourceCompartments),
+// which are stored with it
+func SetResourceCompartments(resource *models2.Resource) error {
+	compartments, err := ResourceCompartments(resource)
+	if err != nil {
+		return err
+	}
+	resource.SetCompartments(compartments)
+	return nil
+}
+
+// ResourceCompartments returns the compartments a resource is in (e.g. ["Patient/123", "Practitioner/456"]),
+// in order: those of the resources its compartment search parameters refer to and, for the resource whose
+// compartment it is, its own.  The references are those it would be stored with, i.e. after any transaction
+// has rewritten them.
+func ResourceCompartments(resource *models2.Resource) ([]string, error) {
+	document, err := resource.GetBSON()
+	if err != nil {
+		return nil, errors.Wrap(err, "ResourceCompartments: GetBSON failed")
+	}
+	resourceType := resource.ResourceType()
+
+	seen := make(map[string]bool)
+	compartments := []string{}
+	add := func(compartment string) {
+		if !seen[compartment] {
+			seen[compartment] = true
+			compartments = append(compartments, compartment)
+		}
+	}
+	for compartment := range CompartmentDefinitions {
+		if resourceType == compartment && resource.Id() != "" {
+			add(compartment + "/" + resource.Id())
+		}
+		for _, info := range compartmentReferenceParams(compartment, resourceType) {
+			for _, path := range info.Paths {
+				if path.Type == "Resource" {
+					continue
+				}
+				fields := strings.Split(convertSearchPathToMongoField(path.Path), ".")
+				for _, reference := range bsonValuesAtPath(document, fields) {
+					referenceDoc, ok := reference.([]bson.E)
+					if !ok {
+						continue
+					}
+					referenceFields := bson.D(referenceDoc).Map()
+					id, _ := referenceFields["reference__id"].(string)
+					if referenceFields["reference__type"] == compartment && id != "" {
+						add(compartment + "/" + id)
+					}
+				}
+			}
+		}
+	}
+	sort.Strings(compartments)
+	return compartments, nil
+}
+
+// bsonValuesAtPath returns the values of a stored resource's field with the given path (e.g.
+// ["participant", "individual"]), flattening any arrays along the way unless indexed (e.g. "0")
+func bsonValuesAtPath(value interface{}, fields []string) []interface{} {
+	if array, ok := value.([]interface{}); ok {
+		if len(fields) > 0 {
+			if i, err := strconv.Atoi(fields[0]); err == nil {
+				if i < len(array) {
+					return bsonValuesAtPath(array[i], fields[1:])
+				}
+				return nil
+			}
+		}
+		var values []interface{}
+		for _, item := range array {
+			values = append(values, bsonValuesAtPath(item, fields)...)
+		}
+		return values
+	}
+	if len(fields) == 0 {
+		return []interface{}{value}
+	}
+
+	var document []bson.E
+	switch v := value.(type) {
+	case []bson.E:
+		document = v
+	case bson.D:
+		document = v
+	}
+	for _, elem := range document {
+		if elem.Key == fields[0] {
+			return bsonValuesAtPath(elem.Value, fields[1:])
+		}
+	}
+	return nil
+}
+
+// CompartmentFilter returns a filter of the stored resources in the compartment of a resource (e.g.
+// "Patient/123"), which matches the compartments stored with them (see SetResourceCompartments).
+// It can be combined with other filters, e.g. to only let a user see the resources in their compartment.
+func CompartmentFilter(compartment string) bson.M {
+	return bson.M{models2.CompartmentsField: compartment}
+}
+
+// createCompartmentQueryObject matches the resources that refer to the resource whose compartment
+// it is using any of the compartment's search parameters, and that resource itself (or, if enabled,
+// the resources stored with that compartment, see SetPrecomputedCompartments)
+func (m *MongoSearcher) createCompartmentQueryObject(p *CompartmentQueryParam) bson.M {
+	if m.precomputedCompartments {
+		return CompartmentFilter(p.Compartment + "/" + p.ID)
+	}
+	var criteria []bson.M
+	if p.Resource == p.Compartment {
+		criteria = append(criteria, bson.M{"_id": p.ID})
+	}
+	for _, info := range compartmentReferenceParams(p.Compartment, p.Resource) {
+		r := &ReferenceParam{SearchParamInfo: info, Reference: LocalReference{ID: p.ID, Type: p.Compartment}}
+		criteria = append(criteria, m.createReferenceQueryObject(r))
+	}
+	switch len(criteria) {
+	case 0:
+		// None of the compartment's search parameters can be used, so nothing matches
+		return bson.M{"_id": bson.M{"$in": []string{}}}
+	case 1:
+		return criteria[0]
+	}
+	return bson.M{"$or": criteria}
+}
@@ -0,0 +1,230 @@
+package search
+
+// CompartmentDefinitions provides a mapping from the FHIR compartments to the resources that may be in them
+// and the search parameters that refer to the resource (e.g. the Patient) whose compartment they're in.
+// They're taken from the FHIR STU3 compartment definitions in
+// fsharp-fhir-tools/PathsByType/STU3/profiles-resources.json.
+var CompartmentDefinitions = map[string]map[string][]string{
+	"Device": map[string][]string{
+		"Account":                  []string{"subject"},
+		"Appointment":              []string{"actor"},
+		"AppointmentResponse":      []string{"actor"},
+		"AuditEvent":               []string{"agent"},
+		"ChargeItem":               []string{"enterer", "participant-actor"},
+		"Communication":            []string{"sender", "recipient"},
+		"CommunicationRequest":     []string{"sender", "recipient"},
+		"Composition":              []string{"author"},
+		"DetectedIssue":            []string{"author"},
+		"Device":                   []string{"{def}"},
+		"DeviceComponent":          []string{"source"},
+		"DeviceMetric":             []string{"source"},
+		"DeviceRequest":            []string{"device", "subject", "requester", "performer"},
+		"DeviceUseStatement":       []string{"device"},
+		"DiagnosticReport":         []string{"subject"},
+		"DocumentManifest":         []string{"subject", "author"},
+		"DocumentReference":        []string{"subject", "author"},
+		"Flag":                     []string{"author"},
+		"Group":                    []string{"member"},
+		"ImagingManifest":          []string{"author"},
+		"List":                     []string{"subject", "source"},
+		"Media":                    []string{"subject"},
+		"MedicationAdministration": []string{"device"},
+		"MessageHeader":            []string{"target"},
+		"Observation":              []string{"subject", "device"},
+		"ProcedureRequest":         []string{"performer", "requester"},
+		"Provenance":               []string{"agent"},
+		"QuestionnaireResponse":    []string{"author"},
+		"RequestGroup":             []string{"author"},
+		"RiskAssessment":           []string{"performer"},
+		"Schedule":                 []string{"actor"},
+		"Specimen":                 []string{"subject"},
+		"SupplyRequest":            []string{"requester"},
+	},
+	"Encounter": map[string][]string{
+		"ChargeItem":               []string{"context"},
+		"Claim":                    []string{"encounter"},
+		"ClinicalImpression":       []string{"context"},
+		"Communication":            []string{"context"},
+		"CommunicationRequest":     []string{"context"},
+		"Composition":              []string{"encounter"},
+		"Condition":                []string{"context"},
+		"DeviceRequest":            []string{"encounter"},
+		"DiagnosticReport":         []string{"encounter"},
+		"DocumentReference":        []string{"encounter"},
+		"Encounter":                []string{"{def}"},
+		"ExplanationOfBenefit":     []string{"encounter"},
+		"MedicationAdministration": []string{"context"},
+		"MedicationRequest":        []string{"context"},
+		"NutritionOrder":           []string{"encounter"},
+		"Observation":              []string{"encounter"},
+		"Procedure":                []string{"encounter"},
+		"ProcedureRequest":         []string{"context"},
+		"QuestionnaireResponse":    []string{"context"},
+		"RequestGroup":             []string{"encounter"},
+		"VisionPrescription":       []string{"encounter"},
+	},
+	"Patient": map[string][]string{
+		"Account":                    []string{"subject"},
+		"AdverseEvent":               []string{"subject"},
+		"AllergyIntolerance":         []string{"patient", "recorder", "asserter"},
+		"Appointment":                []string{"actor"},
+		"AppointmentResponse":        []string{"actor"},
+		"AuditEvent":                 []string{"patient", "agent.patient", "entity.patient"},
+		"Basic":                      []string{"patient", "author"},
+		"BodySite":                   []string{"patient"},
+		"CarePlan":                   []string{"patient", "performer"},
+		"CareTeam":                   []string{"patient", "participant"},
+		"ChargeItem":                 []string{"subject"},
+		"Claim":                      []string{"patient", "payee"},
+		"ClaimResponse":              []string{"patient"},
+		"ClinicalImpression":         []string{"subject"},
+		"Communication":              []string{"subject", "sender", "recipient"},
+		"CommunicationRequest":       []string{"subject", "sender", "recipient", "requester"},
+		"Composition":                []string{"subject", "author", "attester"},
+		"Condition":                  []string{"patient", "asserter"},
+		"Consent":                    []string{"patient"},
+		"Coverage":                   []string{"policy-holder", "subscriber", "beneficiary", "payor"},
+		"DetectedIssue":              []string{"patient"},
+		"DeviceRequest":              []string{"subject", "requester", "performer"},
+		"DeviceUseStatement":         []string{"subject"},
+		"DiagnosticReport":           []string{"subject"},
+		"DocumentManifest":           []string{"subject", "author", "recipient"},
+		"DocumentReference":          []string{"subject", "author"},
+		"EligibilityRequest":         []string{"patient"},
+		"Encounter":                  []string{"patient"},
+		"EnrollmentRequest":          []string{"subject"},
+		"EpisodeOfCare":              []string{"patient"},
+		"ExplanationOfBenefit":       []string{"patient", "payee"},
+		"FamilyMemberHistory":        []string{"patient"},
+		"Flag":                       []string{"patient"},
+		"Goal":                       []string{"patient"},
+		"Group":                      []string{"member"},
+		"ImagingManifest":            []string{"patient", "author"},
+		"ImagingStudy":               []string{"patient"},
+		"Immunization":               []string{"patient"},
+		"ImmunizationRecommendation": []string{"patient"},
+		"List":                       []string{"subject", "source"},
+		"MeasureReport":              []string{"patient"},
+		"Media":                      []string{"subject"},
+		"MedicationAdministration":   []string{"patient", "performer", "subject"},
+		"MedicationDispense":         []string{"subject", "patient", "receiver"},
+		"MedicationRequest":          []string{"subject"},
+		"MedicationStatement":        []string{"subject"},
+		"NutritionOrder":             []string{"patient"},
+		"Observation":                []string{"subject", "performer"},
+		"Patient":                    []string{"link"},
+		"Person":                     []string{"patient"},
+		"Procedure":                  []string{"patient", "performer"},
+		"ProcedureRequest":           []string{"subject", "performer"},
+		"Provenance":                 []string{"target.subject", "target.patient", "patient"},
+		"QuestionnaireResponse":      []string{"subject", "author"},
+		"ReferralRequest":            []string{"patient", "requester"},
+		"RelatedPerson":              []string{"patient"},
+		"RequestGroup":               []string{"subject", "participant"},
+		"ResearchSubject":            []string{"individual"},
+		"RiskAssessment":             []string{"subject"},
+		"Schedule":                   []string{"actor"},
+		"Specimen":                   []string{"subject"},
+		"SupplyDelivery":             []string{"patient"},
+		"SupplyRequest":              []string{"requester"},
+		"VisionPrescription":         []string{"patient"},
+	},
+	"Practitioner": map[string][]string{
+		"Account":                  []string{"subject"},
+		"AdverseEvent":             []string{"recorder"},
+		"AllergyIntolerance":       []string{"recorder", "asserter"},
+		"Appointment":              []string{"actor"},
+		"AppointmentResponse":      []string{"actor"},
+		"AuditEvent":               []string{"agent"},
+		"Basic":                    []string{"author"},
+		"CarePlan":                 []string{"performer"},
+		"CareTeam":                 []string{"participant"},
+		"ChargeItem":               []string{"enterer", "participant-actor"},
+		"Claim":                    []string{"enterer", "provider", "payee", "care-team"},
+		"ClaimResponse":            []string{"request-provider"},
+		"ClinicalImpression":       []string{"assessor"},
+		"Communication":            []string{"sender", "recipient"},
+		"CommunicationRequest":     []string{"sender", "recipient", "requester"},
+		"Composition":              []string{"subject", "author", "attester"},
+		"Condition":                []string{"asserter"},
+		"DetectedIssue":            []string{"author"},
+		"DeviceRequest":            []string{"requester", "performer"},
+		"DiagnosticReport":         []string{"performer"},
+		"DocumentManifest":         []string{"subject", "author", "recipient"},
+		"DocumentReference":        []string{"subject", "author", "authenticator"},
+		"EligibilityRequest":       []string{"enterer", "provider"},
+		"EligibilityResponse":      []string{"request-provider"},
+		"Encounter":                []string{"practitioner", "participant"},
+		"EpisodeOfCare":            []string{"care-manager"},
+		"ExplanationOfBenefit":     []string{"enterer", "provider", "payee", "care-team"},
+		"Flag":                     []string{"author"},
+		"Group":                    []string{"member"},
+		"ImagingManifest":          []string{"author"},
+		"Immunization":             []string{"practitioner"},
+		"Linkage":                  []string{"author"},
+		"List":                     []string{"source"},
+		"Media":                    []string{"subject", "operator"},
+		"MedicationAdministration": []string{"performer"},
+		"MedicationDispense":       []string{"performer", "receiver"},
+		"MedicationRequest":        []string{"requester"},
+		"MedicationStatement":      []string{"source"},
+		"MessageHeader":            []string{"receiver", "author", "responsible", "enterer"},
+		"NutritionOrder":           []string{"provider"},
+		"Observation":              []string{"performer"},
+		"Patient":                  []string{"general-practitioner"},
+		"PaymentNotice":            []string{"provider"},
+		"PaymentReconciliation":    []string{"request-provider"},
+		"Person":                   []string{"practitioner"},
+		"Practitioner":             []string{"{def}"},
+		"PractitionerRole":         []string{"practitioner"},
+		"Procedure":                []string{"performer"},
+		"ProcedureRequest":         []string{"performer", "requester"},
+		"ProcessRequest":           []string{"provider"},
+		"ProcessResponse":          []string{"request-provider"},
+		"Provenance":               []string{"agent"},
+		"QuestionnaireResponse":    []string{"author", "source"},
+		"ReferralRequest":          []string{"requester", "recipient"},
+		"RequestGroup":             []string{"participant", "author"},
+		"ResearchStudy":            []string{"principalinvestigator"},
+		"RiskAssessment":           []string{"performer"},
+		"Schedule":                 []string{"actor"},
+		"Specimen":                 []string{"collector"},
+		"SupplyDelivery":           []string{"supplier", "receiver"},
+		"SupplyRequest":            []string{"requester"},
+		"VisionPrescription":       []string{"prescriber"},
+	},
+	"RelatedPerson": map[string][]string{
+		"AdverseEvent":             []string{"recorder"},
+		"AllergyIntolerance":       []string{"asserter"},
+		"Appointment":              []string{"actor"},
+		"AppointmentResponse":      []string{"actor"},
+		"Basic":                    []string{"author"},
+		"CarePlan":                 []string{"performer"},
+		"CareTeam":                 []string{"participant"},
+		"ChargeItem":               []string{"enterer", "participant-actor"},
+		"Claim":                    []string{"payee"},
+		"Communication":            []string{"sender", "recipient"},
+		"CommunicationRequest":     []string{"sender", "recipient", "requester"},
+		"Composition":              []string{"author"},
+		"Condition":                []string{"asserter"},
+		"Coverage":                 []string{"policy-holder", "subscriber", "payor"},
+		"DocumentManifest":         []string{"author"},
+		"DocumentReference":        []string{"author"},
+		"Encounter":                []string{"participant"},
+		"ExplanationOfBenefit":     []string{"payee"},
+		"ImagingManifest":          []string{"author"},
+		"MedicationAdministration": []string{"performer"},
+		"MedicationStatement":      []string{"source"},
+		"Observation":              []string{"performer"},
+		"Patient":                  []string{"link"},
+		"Person":                   []string{"link"},
+		"Procedure":                []string{"performer"},
+		"ProcedureRequest":         []string{"performer"},
+		"Provenance":               []string{"agent"},
+		"QuestionnaireResponse":    []string{"author", "source"},
+		"RelatedPerson":            []string{"{def}"},
+		"RequestGroup":             []string{"participant"},
+		"Schedule":                 []string{"actor"},
+		"SupplyRequest":            []string{"requester"},
+	},
+}
@@ -0,0 +1,81 @@
+package search
+
+// compositeSearchParameters lists the composite search parameters defined by
+// the FHIR STU3 specification.  They are not emitted by the generator that
+// produces the SearchParameterDictionary, so they are registered separately.
+// Composites lists the names of the component parameters, in the order their
+// values appear in the query (e.g. code-value-quantity=[code]$[value-quantity]).
+var compositeSearchParameters = []SearchParamInfo{
+	SearchParamInfo{
+		Resource:   "DocumentReference",
+		Name:       "relationship",
+		Type:       "composite",
+		Composites: []string{"relatesto", "relation"},
+	},
+	SearchParamInfo{
+		Resource:   "Group",
+		Name:       "characteristic-value",
+		Type:       "composite",
+		Composites: []string{"characteristic", "value"},
+	},
+	SearchParamInfo{
+		Resource:   "Observation",
+		Name:       "code-value-concept",
+		Type:       "composite",
+		Composites: []string{"code", "value-concept"},
+	},
+	SearchParamInfo{
+		Resource:   "Observation",
+		Name:       "code-value-date",
+		Type:       "composite",
+		Composites: []string{"code", "value-date"},
+	},
+	SearchParamInfo{
+		Resource:   "Observation",
+		Name:       "code-value-quantity",
+		Type:       "composite",
+		Composites: []string{"code", "value-quantity"},
+	},
+	SearchParamInfo{
+		Resource:   "Observation",
+		Name:       "code-value-string",
+		Type:       "composite",
+		Composites: []string{"code", "value-string"},
+	},
+	SearchParamInfo{
+		Resource:   "Observation",
+		Name:       "combo-code-value-concept",
+		Type:       "composite",
+		Composites: []string{"combo-code", "combo-value-concept"},
+	},
+	SearchParamInfo{
+		Resource:   "Observation",
+		Name:       "combo-code-value-quantity",
+		Type:       "composite",
+		Composites: []string{"combo-code", "combo-value-quantity"},
+	},
+	SearchParamInfo{
+		Resource:   "Observation",
+		Name:       "component-code-value-concept",
+		Type:       "composite",
+		Composites: []string{"component-code", "component-value-concept"},
+	},
+	SearchParamInfo{
+		Resource:   "Observation",
+		Name:       "component-code-value-quantity",
+		Type:       "composite",
+		Composites: []string{"component-code", "component-value-quantity"},
+	},
+	SearchParamInfo{
+		Resource:   "Observation",
+		Name:       "related",
+		Type:       "composite",
+		Composites: []string{"related-target", "related-type"},
+	},
+}
+
+func init() {
+	for _, info := range compositeSearchParameters {
+		GlobalRegistry().RegisterParameterInfo(info)
+	}
+}
@@ -0,0 +1,246 @@
+package search
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/buger/jsonparser"
+	"github.com/eug48/fhir/models"
+	"github.com/eug48/fhir/models2"
+	"github.com/pkg/errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	moptions "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ContainedCollection is the collection in which the contained resources of every resource are
+// indexed (see IndexContainedResources), so that searches with _contained can find them
+const ContainedCollection = "containedresources"
+
+// containedIDSeparator separates the reference to the container of a contained resource from
+// its own id in its _id in the ContainedCollection, e.g. "Patient/123#med1"
+const containedIDSeparator = "#"
+
+// SplitContainedID splits the id of a resource found in the ContainedCollection into the
+// reference to its container (e.g. "Patient/123") and its own id.  ok is false if the id
+// isn't that of a contained resource.
+func SplitContainedID(id string) (container, containedID string, ok bool) {
+	parts := strings.SplitN(id, containedIDSeparator, 2)
+	if len(parts) < 2 || !strings.Contains(parts[0], "/") {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// IndexContainedResources replaces the contained resources indexed for a resource with its current
+// ones.  Each is stored in the ContainedCollection like a resource of its own, whose _id is the reference
+// to its container followed by "#" and its own id, with its own compartments.  Contained resources without
+// an id aren't indexed.
+// Resources stored before their contained resources were indexed are only found once they're stored again.
+func IndexContainedResources(ctx context.Context, db *mongo.Database, container *models2.Resource) error {
+	if err := RemoveContainedResources(ctx, db, container.ResourceType(), container.Id()); err != nil {
+		return err
+	}
+
+	containerRef := container.ResourceType() + "/" + container.Id()
+	var documents []interface{}
+	var parseErr error
+	_, err := jsonparser.ArrayEach(container.JsonBytes(), func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
+		if parseErr != nil || dataType != jsonparser.Object {
+			return
+		}
+		contained, err := models2.NewResourceFromJsonBytes(value)
+		if err != nil {
+			parseErr = err
+			return
+		}
+		if contained.Id() != "" {
+			contained.SetId(containerRef + containedIDSeparator + contained.Id())
+			if err := SetResourceCompartments(contained); err != nil {
+				parseErr = err
+				return
+			}
+			documents = append(documents, contained)
+		}
+	}, "contained")
+	if err == jsonparser.KeyPathNotFoundError {
+		return nil
+	}
+	if err == nil {
+		err = parseErr
+	}
+	if err != nil {
+		return errors.Wrapf(err, "IndexContainedResources: failed to parse the contained resources of %s", containerRef)
+	}
+	if len(documents) == 0 {
+		return nil
+	}
+
+	_, err = db.Collection(ContainedCollection).InsertMany(ctx, documents)
+	return errors.Wrap(err, "IndexContainedResources: InsertMany failed")
+}
+
+// RemoveContainedResources removes the contained resources indexed for resources of the given type
+func RemoveContainedResources(ctx context.Context, db *mongo.Database, resourceType string, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	prefixes := make([]interface{}, len(ids))
+	for i, id := range ids {
+		prefixes[i] = primitive.Regex{Pattern: "^" + regexp.QuoteMeta(resourceType+"/"+id+containedIDSeparator)}
+	}
+	_, err := db.Collection(ContainedCollection).DeleteMany(ctx, bson.M{"_id": bson.M{"$in": prefixes}})
+	return errors.Wrap(err, "RemoveContainedResources: DeleteMany failed")
+}
+
+// searchesContained checks if the results of a search are contained resources (or their containers)
+func (o *QueryOptions) searchesContained() bool {
+	return o.Contained == "true"
+}
+
+// returnsContainers checks if a search of contained resources returns their containers (the default)
+func (o *QueryOptions) returnsContainers() bool {
+	return o.searchesContained() && o.ContainedType != "contained"
+}
+
+// matchContainedResources makes a BSONQuery search the contained resources of the queried type
+func matchContainedResources(bsonQuery *BSONQuery) {
+	bsonQuery.Collection = ContainedCollection
+	if bsonQuery.usesPipeline() {
+		// The first stage is always a $match
+		bsonQuery.Pipeline[0]["$match"].(bson.M)["resourceType"] = bsonQuery.Resource
+	} else {
+		bsonQuery.Query["resourceType"] = bsonQuery.Resource
+	}
+}
+
+// searchWithContained returns a page of the results of a query with _contained=both: the matching
+// resources followed by the matching contained resources (or their containers), and their total.
+// Both parts are counted so that the page can be split between them.
+func (m *MongoSearcher) searchWithContained(query Query, options *QueryOptions) (resources []*models2.Resource, total uint32, err error) {
+	uncontainedQuery := query.withParams(map[string]string{
+		ContainedParam:  "false",
+		TotalParam:      "accurate",
+		CountParam:      strconv.Itoa(options.Count),
+		MaxResultsParam: "",
+	})
+	// Keyset paging isn't used as the next page may need to continue with the contained resources
+	resources, uncontainedTotal, _, err := m.searchPage(uncontainedQuery, uncontainedQuery.Options(), false)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// The contained resources come after all of the others
+	offset := options.Offset - int(uncontainedTotal)
+	if offset < 0 {
+		offset = 0
+	}
+	count := options.Count - len(resources)
+	containedParams := map[string]string{
+		ContainedParam:  "true",
+		TotalParam:      "accurate",
+		OffsetParam:     strconv.Itoa(offset),
+		CountParam:      strconv.Itoa(count),
+		MaxResultsParam: "",
+	}
+	if count == 0 {
+		containedParams[SummaryParam] = "count"
+	}
+	contained, containedTotal, _, err := m.SearchPage(query.withParams(containedParams))
+	if err != nil {
+		return nil, 0, err
+	}
+	return append(resources, contained...), uncontainedTotal + containedTotal, nil
+}
+
+// findContainers replaces the contained resources among the results of a search with their containers
+// (without duplicates), in the order of the first resource each contains.  Other results are kept.
+// The _elements and _summary options apply to the containers.
+func (m *MongoSearcher) findContainers(resources []*models2.Resource, options *QueryOptions) ([]*models2.Resource, error) {
+	idsByType := make(map[string][]string)
+	seen := make(map[string]bool)
+	for _, resource := range resources {
+		containerRef, _, ok := SplitContainedID(resource.Id())
+		if ok && !seen[containerRef] {
+			seen[containerRef] = true
+			parts := strings.SplitN(containerRef, "/", 2)
+			idsByType[parts[0]] = append(idsByType[parts[0]], parts[1])
+		}
+	}
+	if len(idsByType) == 0 {
+		return resources, nil
+	}
+
+	found := make(map[string]*models2.Resource, len(seen))
+	for resourceType, ids := range idsByType {
+		findOptions := moptions.Find()
+		if projection := createProjection(resourceType, options); projection != nil {
+			findOptions.SetProjection(projection)
+		}
+		c := m.db.Collection(models.PluralizeLowerResourceName(resourceType))
+		cursor, err := c.Find(m.ctx, bson.M{"_id": bson.M{"$in": ids}}, findOptions)
+		if err != nil {
+			return nil, errors.Wrap(err, "findContainers: Find failed")
+		}
+		for cursor.Next(m.ctx) {
+			var document bson.D
+			if err := cursor.Decode(&document); err != nil {
+				cursor.Close(m.ctx)
+				return nil, errors.Wrap(err, "findContainers: decoding failed")
+			}
+			resource, err := models2.NewResourceFromBSON(document)
+			if err != nil {
+				cursor.Close(m.ctx)
+				return nil, errors.Wrap(err, "findContainers: NewResourceFromBSON failed")
+			}
+			found[resourceType+"/"+resource.Id()] = resource
+		}
+		err = cursor.Err()
+		cursor.Close(m.ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "findContainers: cursor error")
+		}
+	}
+
+	results := make([]*models2.Resource, 0, len(resources))
+	for _, resource := range resources {
+		containerRef, _, ok := SplitContainedID(resource.Id())
+		if !ok {
+			results = append(results, resource)
+		} else if container, ok := found[containerRef]; ok {
+			results = append(results, container)
+			delete(found, containerRef)
+		}
+	}
+	return results, nil
+}
+
+// withParams returns a copy of the query with the given parameters replaced, or removed if their
+// value is empty
+func (q *Query) withParams(params map[string]string) Query {
+	queryParams, _ := ParseQuery(q.Query)
+	var newParams URLQueryParameters
+	for _, queryParam := range queryParams.All() {
+		if _, replaced := params[queryParam.Key]; !replaced {
+			newParams.Add(queryParam.Key, queryParam.Value)
+		}
+	}
+
+	// Sorted so that the query is always the same (e.g. for the count cache)
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if params[key] != "" {
+			newParams.Add(key, params[key])
+		}
+	}
+	return Query{Resource: q.Resource, Query: newParams.Encode()}
+}
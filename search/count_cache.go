@@ -0,0 +1,210 @@
+package search
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	moptions "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// countCacheCollection is the collection in which MongoCountCache caches the totals of searches
+const countCacheCollection = "countcache"
+
+// anyResource is the resource type of cached counts that may depend on any resource
+const anyResource = "*"
+
+// CountCache caches the total count of results of searches, so they don't have to be counted
+// again.  Keys are based on the query (see countCacheID) and each total is cached with the
+// resource types whose changes invalidate it ("*" for any resource type).
+type CountCache interface {
+	// Get returns the cached total with the given key, if it has one
+	Get(ctx context.Context, key string) (total uint32, found bool, err error)
+
+	// Set caches a total with the given key
+	Set(ctx context.Context, key string, total uint32, resources []string) error
+
+	// Invalidate deletes the cached totals that may have changed because a resource
+	// of the given type was created, updated or deleted.
+	Invalidate(ctx context.Context, resourceType string) error
+}
+
+// CachedCount is a total cached by MongoCountCache.
+// The Id is the md5 hash of the query string.  Resources are the resource types
+// whose changes invalidate the count and Created is when it was counted.
+type CachedCount struct {
+	Id        string    `bson:"_id"`
+	Count     uint32    `bson:"count"`
+	Resources []string  `bson:"resources,omitempty"`
+	Created   time.Time `bson:"created,omitempty"`
+}
+
+// SetCountCache sets where the totals of searches are cached (nil for the countcache collection
+// of the searcher's database) and enables caching them even though the searcher isn't readonly
+// (it's always enabled for readonly searchers).  Since searches can't tell when resources change,
+// servers that do this must invalidate the cache after every change.
+func (m *MongoSearcher) SetCountCache(cache CountCache, enabled bool) {
+	m.countCache = cache
+	m.cacheCounts = enabled
+}
+
+// usesCountCache checks if the totals of searches are cached
+func (m *MongoSearcher) usesCountCache() bool {
+	return m.readonly || m.cacheCounts
+}
+
+// getCountCache returns the cache of search totals
+func (m *MongoSearcher) getCountCache() CountCache {
+	if m.countCache == nil {
+		return NewMongoCountCache(m.db, 0, 0)
+	}
+	return m.countCache
+}
+
+// lookupCachedCount returns the cached total of a query, if it has one
+func (m *MongoSearcher) lookupCachedCount(queryHash string) (total uint32, found bool) {
+	total, found, err := m.getCountCache().Get(m.ctx, queryHash)
+	if err != nil {
+		glog.Warningf("count cache: %+v", err)
+		return 0, false
+	}
+	return total, found
+}
+
+// cacheCount caches the total of a query.  Caching is best-effort, so errors are only logged.
+func (m *MongoSearcher) cacheCount(query Query, queryHash string, total uint32) {
+	err := m.getCountCache().Set(m.ctx, queryHash, total, countCacheResources(query))
+	if err != nil {
+		glog.Warningf("count cache: %+v", err)
+	}
+}
+
+// countCacheID returns the key of a query's cached total
+func countCacheID(query Query) string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(query.Resource+"?"+query.Query)))
+}
+
+// countCacheResources returns the resource types whose changes invalidate the total of a query.
+// Queries that search other resources (e.g. chained, _has and _list parameters or :in codes)
+// are assumed to depend on all of them.
+func countCacheResources(query Query) []string {
+	queryParams, _ := ParseQuery(query.Query)
+	for _, queryParam := range queryParams.All() {
+		param, modifier, postfix := ParseParamNameModifierAndPostFix(queryParam.Key)
+		switch {
+		case isSearchResultParam(param):
+			// Options don't change the total
+		case postfix != "", param == HasParam, param == ListParam, param == QueryParam, param == FilterParam:
+			return []string{query.Resource, anyResource}
+		case modifier == "in", modifier == "not-in", modifier == "above", modifier == "below":
+			return []string{query.Resource, anyResource}
+		}
+	}
+	return []string{query.Resource}
+}
+
+// MongoCountCache caches the totals of searches in the countcache collection of a database
+type MongoCountCache struct {
+	db         *mongo.Database
+	ttl        time.Duration
+	maxEntries int
+}
+
+// NewMongoCountCache creates a cache of the totals of searches in the countcache collection of a database.
+// Cached totals are used for at most ttl and at most maxEntries of them are kept, evicting the oldest ones
+// (0 for no limits).
+func NewMongoCountCache(db *mongo.Database, ttl time.Duration, maxEntries int) *MongoCountCache {
+	return &MongoCountCache{
+		db:         db,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+	}
+}
+
+// Get returns the cached total with the given key, if it has one that hasn't expired
+func (mc *MongoCountCache) Get(ctx context.Context, key string) (total uint32, found bool, err error) {
+	filter := bson.M{"_id": key}
+	if mc.ttl > 0 {
+		filter["created"] = bson.M{"$gte": time.Now().Add(-mc.ttl)}
+	}
+
+	cachedCount := &CachedCount{}
+	err = mc.db.Collection(countCacheCollection).FindOne(ctx, filter).Decode(cachedCount)
+	if err != nil {
+		// Usually because there's no cached total
+		return 0, false, nil
+	}
+	return cachedCount.Count, true, nil
+}
+
+// Set caches a total, evicting expired and (if there are too many) the oldest totals
+func (mc *MongoCountCache) Set(ctx context.Context, key string, total uint32, resources []string) error {
+	cachedCount := &CachedCount{
+		Id:        key,
+		Count:     total,
+		Resources: resources,
+		Created:   time.Now(),
+	}
+	_, err := mc.db.Collection(countCacheCollection).ReplaceOne(ctx, bson.M{"_id": key}, cachedCount, moptions.Replace().SetUpsert(true))
+	if err != nil {
+		return errors.Wrap(err, "MongoCountCache.Set: ReplaceOne failed")
+	}
+	return mc.evict(ctx)
+}
+
+// evict deletes the expired cached totals, then the oldest ones beyond the maximum number of entries
+func (mc *MongoCountCache) evict(ctx context.Context) error {
+	c := mc.db.Collection(countCacheCollection)
+	if mc.ttl > 0 {
+		_, err := c.DeleteMany(ctx, bson.M{"created": bson.M{"$lt": time.Now().Add(-mc.ttl)}})
+		if err != nil {
+			return errors.Wrap(err, "MongoCountCache.evict: deleting expired counts failed")
+		}
+	}
+	if mc.maxEntries <= 0 {
+		return nil
+	}
+
+	entries, err := c.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return errors.Wrap(err, "MongoCountCache.evict: CountDocuments failed")
+	}
+	excess := entries - int64(mc.maxEntries)
+	if excess <= 0 {
+		return nil
+	}
+
+	oldest := moptions.Find().SetSort(bson.D{{Key: "created", Value: 1}}).SetLimit(excess).SetProjection(bson.M{"_id": 1})
+	cursor, err := c.Find(ctx, bson.M{}, oldest)
+	if err != nil {
+		return errors.Wrap(err, "MongoCountCache.evict: Find failed")
+	}
+	defer cursor.Close(ctx)
+
+	var ids []string
+	for cursor.Next(ctx) {
+		var entry CachedCount
+		if err := cursor.Decode(&entry); err != nil {
+			return errors.Wrap(err, "MongoCountCache.evict: Decode failed")
+		}
+		ids = append(ids, entry.Id)
+	}
+	if err := cursor.Err(); err != nil {
+		return errors.Wrap(err, "MongoCountCache.evict: cursor error")
+	}
+	_, err = c.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	return errors.Wrap(err, "MongoCountCache.evict: DeleteMany failed")
+}
+
+// Invalidate deletes the cached totals that depend on the given resource type
+func (mc *MongoCountCache) Invalidate(ctx context.Context, resourceType string) error {
+	filter := bson.M{"resources": bson.M{"$in": []string{resourceType, anyResource}}}
+	_, err := mc.db.Collection(countCacheCollection).DeleteMany(ctx, filter)
+	return errors.Wrap(err, "MongoCountCache.Invalidate")
+}
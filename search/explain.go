@@ -0,0 +1,103 @@
+package search
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Explanation describes how a search is run, to help diagnose slow searches: the BSONQuery the
+// query is converted to, the find or aggregate command that's run for it and MongoDB's explanation
+// of how it runs the command (see https://docs.mongodb.com/manual/reference/command/explain/).
+// Commands and explanations are in MongoDB's relaxed extended JSON.
+type Explanation struct {
+	Resource    string          `json:"resource"`
+	Query       string          `json:"query"`
+	DebugString string          `json:"bsonQuery"`
+	Command     json.RawMessage `json:"command"`
+	Explain     json.RawMessage `json:"explain"`
+}
+
+// Explain explains how a query is run (without counting its total results or running its
+// includes).  MongoDB runs the command to find out how long it takes, so explaining a slow
+// search is just as slow.
+func (m *MongoSearcher) Explain(query Query) (*Explanation, error) {
+	options, _ := m.searchOptions(query)
+	bsonQuery := m.convertToBSON(query)
+	collection := bsonQuery.collectionName()
+
+	var command bson.D
+	if bsonQuery.usesPipeline() {
+		command = bson.D{
+			{Key: "aggregate", Value: collection},
+			{Key: "pipeline", Value: m.createSearchPipeline(bsonQuery, options)},
+			{Key: "allowDiskUse", Value: true},
+			{Key: "cursor", Value: bson.M{}},
+		}
+		if bsonQuery.Hint != nil {
+			command = append(command, bson.E{Key: "hint", Value: bsonQuery.Hint})
+		}
+	} else {
+		filter, findOptions := m.createFindFilterAndOptions(bsonQuery, options)
+		if filter == nil {
+			filter = bson.M{}
+		}
+		command = bson.D{
+			{Key: "find", Value: collection},
+			{Key: "filter", Value: filter},
+		}
+		if findOptions.Sort != nil {
+			command = append(command, bson.E{Key: "sort", Value: findOptions.Sort})
+		}
+		if findOptions.Skip != nil {
+			command = append(command, bson.E{Key: "skip", Value: *findOptions.Skip})
+		}
+		if findOptions.Limit != nil {
+			command = append(command, bson.E{Key: "limit", Value: *findOptions.Limit})
+		}
+		if findOptions.Projection != nil {
+			command = append(command, bson.E{Key: "projection", Value: findOptions.Projection})
+		}
+		if findOptions.Hint != nil {
+			command = append(command, bson.E{Key: "hint", Value: findOptions.Hint})
+		}
+	}
+	if m.maxTime > 0 {
+		command = append(command, bson.E{Key: "maxTimeMS", Value: int64(m.maxTime / time.Millisecond)})
+	}
+	if bsonQuery.Collation != nil {
+		command = append(command, bson.E{Key: "collation", Value: bsonQuery.Collation.ToDocument()})
+	}
+
+	var explain bson.M
+	explainCommand := bson.D{
+		{Key: "explain", Value: command},
+		{Key: "verbosity", Value: "executionStats"},
+	}
+	err := m.db.RunCommand(m.ctx, explainCommand).Decode(&explain)
+	if err != nil {
+		if m.isInterrupted(err) {
+			panic(createOpInterruptedError("Long-running operation interrupted"))
+		}
+		return nil, errors.Wrap(err, "Explain: explain command failed")
+	}
+
+	commandJSON, err := bson.MarshalExtJSON(command, false, false)
+	if err != nil {
+		return nil, errors.Wrap(err, "Explain: failed to convert the command to JSON")
+	}
+	explainJSON, err := bson.MarshalExtJSON(explain, false, false)
+	if err != nil {
+		return nil, errors.Wrap(err, "Explain: failed to convert the explanation to JSON")
+	}
+
+	return &Explanation{
+		Resource:    query.Resource,
+		Query:       query.Query,
+		DebugString: bsonQuery.DebugString(),
+		Command:     commandJSON,
+		Explain:     explainJSON,
+	}, nil
+}
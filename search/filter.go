@@ -0,0 +1,275 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// FilterQueryParam represents the _filter search parameter.  The following
+// description is from the FHIR STU3 specification:
+//
+// The _filter parameter provides a syntax for expressing a set of query
+// expressions on the underlying resources. The filter syntax is designed to
+// be compact and expressive, for complex cases that cannot be expressed
+// using the base search parameters (e.g. "given eq "peter" and birthdate
+// ge 2014-10-10").
+type FilterQueryParam struct {
+	SearchParamInfo
+	Filter     FilterExpression
+	Expression string
+}
+
+func (f *FilterQueryParam) getInfo() SearchParamInfo {
+	return f.SearchParamInfo
+}
+
+func (f *FilterQueryParam) setInfo(info SearchParamInfo) {
+	f.SearchParamInfo = info
+}
+
+func (f *FilterQueryParam) getQueryParamAndValue() (string, string) {
+	return queryParamAndValue(f.SearchParamInfo, f.Expression)
+}
+
+// ParseFilterQueryParam parses a _filter expression and returns a pointer to a
+// FilterQueryParam for the given resource.
+func ParseFilterQueryParam(resource, expression string) *FilterQueryParam {
+	filter, err := ParseFilterExpression(expression)
+	if err != nil {
+		panic(createInvalidSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", FilterParam)))
+	}
+	info := SearchParamInfo{Resource: resource, Name: FilterParam, Type: "filter"}
+	return &FilterQueryParam{info, filter, expression}
+}
+
+// FilterExpression is a node of a parsed _filter expression: a
+// FilterLogical, FilterNot or FilterComparison.
+type FilterExpression interface {
+	String() string
+}
+
+// FilterLogical combines two filter expressions with "and" or "or".
+type FilterLogical struct {
+	Operator string
+	Left     FilterExpression
+	Right    FilterExpression
+}
+
+func (f *FilterLogical) String() string {
+	return fmt.Sprintf("(%s %s %s)", f.Left.String(), f.Operator, f.Right.String())
+}
+
+// FilterNot negates a filter expression, e.g. "not (status eq active)".
+type FilterNot struct {
+	Expression FilterExpression
+}
+
+func (f *FilterNot) String() string {
+	return fmt.Sprintf("not %s", f.Expression.String())
+}
+
+// FilterComparison compares a search parameter with a value, e.g.
+// "birthdate ge 2014-10-10".
+type FilterComparison struct {
+	Parameter string
+	Operator  string
+	Value     string
+}
+
+func (f *FilterComparison) String() string {
+	return fmt.Sprintf("%s %s %q", f.Parameter, f.Operator, f.Value)
+}
+
+// filterOperators are the comparison operators defined by the _filter grammar
+var filterOperators = map[string]bool{"eq": true, "ne": true, "co": true, "sw": true, "ew": true,
+	"gt": true, "lt": true, "ge": true, "le": true, "ap": true, "sa": true, "eb": true,
+	"pr": true, "po": true, "ss": true, "sb": true, "in": true, "ni": true, "re": true}
+
+// ParseFilterExpression parses a _filter expression into a FilterExpression.
+// "and" binds more tightly than "or", and parentheses may be used for grouping.
+func ParseFilterExpression(expression string) (FilterExpression, error) {
+	tokens, err := tokenizeFilter(expression)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	filter, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.atEnd() {
+		return nil, fmt.Errorf("unexpected %q in filter", p.peek().text)
+	}
+	return filter, nil
+}
+
+type filterTokenKind int
+
+const (
+	filterTokenWord filterTokenKind = iota
+	filterTokenString
+	filterTokenOpenParen
+	filterTokenCloseParen
+)
+
+type filterToken struct {
+	kind filterTokenKind
+	text string
+}
+
+func tokenizeFilter(expression string) ([]filterToken, error) {
+	var tokens []filterToken
+	runes := []rune(expression)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, filterToken{filterTokenOpenParen, "("})
+			i++
+		case r == ')':
+			tokens = append(tokens, filterToken{filterTokenCloseParen, ")"})
+			i++
+		case r == '"':
+			// Strings are quoted with " and may contain \" and \\ escapes
+			var value []rune
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				value = append(value, runes[i])
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated string in filter")
+			}
+			tokens = append(tokens, filterToken{filterTokenString, string(value)})
+			i++
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != '(' && runes[i] != ')' && runes[i] != '"' {
+				i++
+			}
+			tokens = append(tokens, filterToken{filterTokenWord, string(runes[start:i])})
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+func (p *filterParser) atEnd() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() (filterToken, error) {
+	if p.atEnd() {
+		return filterToken{}, fmt.Errorf("unexpected end of filter")
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	return t, nil
+}
+
+func (p *filterParser) peekKeyword(keyword string) bool {
+	return !p.atEnd() && p.peek().kind == filterTokenWord && strings.EqualFold(p.peek().text, keyword)
+}
+
+func (p *filterParser) parseOr() (FilterExpression, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("or") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &FilterLogical{Operator: "or", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (FilterExpression, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("and") {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &FilterLogical{Operator: "and", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (FilterExpression, error) {
+	if p.peekKeyword("not") && p.pos+1 < len(p.tokens) && p.tokens[p.pos+1].kind == filterTokenOpenParen {
+		p.pos++
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &FilterNot{Expression: inner}, nil
+	}
+
+	if !p.atEnd() && p.peek().kind == filterTokenOpenParen {
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		t, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if t.kind != filterTokenCloseParen {
+			return nil, fmt.Errorf("expected ) but found %q in filter", t.text)
+		}
+		return inner, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (FilterExpression, error) {
+	param, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if param.kind != filterTokenWord {
+		return nil, fmt.Errorf("expected a parameter name but found %q in filter", param.text)
+	}
+
+	op, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	operator := strings.ToLower(op.text)
+	if op.kind != filterTokenWord || !filterOperators[operator] {
+		return nil, fmt.Errorf("unknown operator %q in filter", op.text)
+	}
+
+	value, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if value.kind != filterTokenWord && value.kind != filterTokenString {
+		return nil, fmt.Errorf("expected a value but found %q in filter", value.text)
+	}
+
+	return &FilterComparison{Parameter: param.text, Operator: operator, Value: value.text}, nil
+}
@@ -0,0 +1,60 @@
+package search
+
+import (
+	"github.com/eug48/fhir/models"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+type FilterSuite struct{}
+
+var _ = Suite(&FilterSuite{})
+
+func (s *FilterSuite) SetUpSuite(c *C) {
+	models.DisableOperationOutcomeDiagnosticsFileLine()
+}
+
+func (s *FilterSuite) TestParseComparison(c *C) {
+	f, err := ParseFilterExpression(`birthdate ge 2014-10-10`)
+	util.CheckErr(err)
+	c.Assert(f, DeepEquals, &FilterComparison{Parameter: "birthdate", Operator: "ge", Value: "2014-10-10"})
+}
+
+func (s *FilterSuite) TestParseQuotedValue(c *C) {
+	f, err := ParseFilterExpression(`name co "van \"der\" Berg"`)
+	util.CheckErr(err)
+	c.Assert(f, DeepEquals, &FilterComparison{Parameter: "name", Operator: "co", Value: `van "der" Berg`})
+}
+
+func (s *FilterSuite) TestParseAndBindsTighterThanOr(c *C) {
+	f, err := ParseFilterExpression(`gender eq male or gender eq female and birthdate lt 1990`)
+	util.CheckErr(err)
+	c.Assert(f.String(), Equals, `(gender eq "male" or (gender eq "female" and birthdate lt "1990"))`)
+}
+
+func (s *FilterSuite) TestParseParenthesesAndNot(c *C) {
+	f, err := ParseFilterExpression(`not (gender eq male or gender eq female) AND (name sw "Pe")`)
+	util.CheckErr(err)
+	c.Assert(f.String(), Equals, `(not (gender eq "male" or gender eq "female") and name sw "Pe")`)
+}
+
+func (s *FilterSuite) TestParseInvalidExpressions(c *C) {
+	invalid := []string{
+		``,
+		`gender`,
+		`gender xx male`,
+		`gender eq`,
+		`(gender eq male`,
+		`gender eq male)`,
+		`gender eq "male`,
+		`gender eq male and`,
+	}
+	for _, expression := range invalid {
+		_, err := ParseFilterExpression(expression)
+		c.Assert(err, NotNil, Commentf("expression: %s", expression))
+	}
+}
+
+func (s *FilterSuite) TestParseFilterQueryParamPanicsOnInvalidExpression(c *C) {
+	c.Assert(func() { ParseFilterQueryParam("Patient", "gender eq") }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_filter\" content is invalid"))
+}
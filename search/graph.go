@@ -0,0 +1,172 @@
+package search
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/eug48/fhir/models"
+	"github.com/eug48/fhir/models2"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// graphDefinition is the part of a GraphDefinition resource that _graph follows: the links
+// from its start resource to others, and from those to yet others
+type graphDefinition struct {
+	Start string      `json:"start"`
+	Link  []graphLink `json:"link"`
+}
+
+// graphLink links resources using a reference (its path) or, without a path, links them
+// to the resources whose reference search parameter (in its target's params) refers to them
+type graphLink struct {
+	Path   string        `json:"path"`
+	Target []graphTarget `json:"target"`
+}
+
+type graphTarget struct {
+	Type   string      `json:"type"`
+	Params string      `json:"params"`
+	Link   []graphLink `json:"link"`
+}
+
+// withGraphIncludes returns a copy of a query whose _graph parameter is replaced by the _include and
+// _revinclude parameters that follow the links of its GraphDefinition, so that the resources the graph
+// links are included by the usual include stages.  Links from the start resource are plain includes and
+// those from linked resources are iterative (so they're limited by SetMaxIncludeDepth).
+func (m *MongoSearcher) withGraphIncludes(query Query, graphID string) (Query, error) {
+	graph, err := m.lookupGraphDefinition(graphID)
+	if err != nil {
+		return query, err
+	}
+	if graph.Start != query.Resource {
+		panic(createInvalidSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"_graph\" refers to a GraphDefinition that doesn't start at %s", query.Resource)))
+	}
+
+	queryParams, _ := ParseQuery(query.Query)
+	var newParams URLQueryParameters
+	for _, queryParam := range queryParams.All() {
+		if queryParam.Key != GraphParam {
+			newParams.Add(queryParam.Key, queryParam.Value)
+		}
+	}
+	err = addGraphIncludeParams(&newParams, graph.Start, graph.Link, false, make(map[string]bool))
+	if err != nil {
+		panic(createInvalidSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"_graph\" refers to an unsupported GraphDefinition: %s", err)))
+	}
+	return Query{Resource: query.Resource, Query: newParams.Encode()}, nil
+}
+
+// lookupGraphDefinition returns the stored GraphDefinition with the given id or canonical URL
+func (m *MongoSearcher) lookupGraphDefinition(graphID string) (*graphDefinition, error) {
+	c := m.db.Collection(models.PluralizeLowerResourceName("GraphDefinition"))
+	filter := bson.M{"$or": []bson.M{{"_id": graphID}, {"url": graphID}}}
+	var document bson.D
+	err := c.FindOne(m.ctx, filter).Decode(&document)
+	if err == mongo.ErrNoDocuments {
+		panic(createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_graph\" refers to an unknown GraphDefinition"))
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "lookupGraphDefinition: FindOne failed")
+	}
+
+	resource, err := models2.NewResourceFromBSON(document)
+	if err != nil {
+		return nil, errors.Wrap(err, "lookupGraphDefinition: NewResourceFromBSON failed")
+	}
+	graph := &graphDefinition{}
+	if err := resource.Unmarshal(graph); err != nil {
+		return nil, errors.Wrap(err, "lookupGraphDefinition: Unmarshal failed")
+	}
+	return graph, nil
+}
+
+// addGraphIncludeParams adds the _include (for links with a path) and _revinclude (for links with
+// target params) parameters that follow a graph's links from a source resource, and then those of
+// its targets (iteratively)
+func addGraphIncludeParams(params *URLQueryParameters, source string, links []graphLink, iterate bool, added map[string]bool) error {
+	for _, link := range links {
+		for _, target := range link.Target {
+			if _, ok := SearchParameters()[target.Type]; !ok {
+				return fmt.Errorf("unknown target type %s", target.Type)
+			}
+
+			var key, value string
+			if link.Path != "" {
+				param, ok := referenceParamForPath(source, link.Path, target.Type)
+				if !ok {
+					return fmt.Errorf("no reference search parameter of %s has the path %s", source, link.Path)
+				}
+				key, value = IncludeParam, source+":"+param+":"+target.Type
+			} else {
+				param, ok := referenceParamForGraphParams(target.Type, target.Params, source)
+				if !ok {
+					return fmt.Errorf("the params of target %s aren't a single reference search parameter set to {ref}", target.Type)
+				}
+				key, value = RevIncludeParam, target.Type+":"+param+":"+source
+			}
+			if iterate {
+				key += ":iterate"
+			}
+			if !added[key+"="+value] {
+				added[key+"="+value] = true
+				params.Add(key, value)
+			}
+
+			if err := addGraphIncludeParams(params, target.Type, target.Link, true, added); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// referenceParamForPath returns the name of a reference search parameter of a resource that has
+// the given path (e.g. "Patient.generalPractitioner") and may refer to the target
+func referenceParamForPath(resource, path, target string) (string, bool) {
+	path = strings.TrimPrefix(path, resource+".")
+	for _, name := range sortedParamNames(resource) {
+		info := SearchParameters()[resource][name]
+		if info.Type != "reference" || !isValidTarget(target, info) {
+			continue
+		}
+		for _, paramPath := range info.Paths {
+			if convertSearchPathToMongoField(paramPath.Path) == path {
+				return name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// referenceParamForGraphParams returns the name of the reference search parameter of a resource
+// in a graph link target's params (e.g. "patient={ref}"), which refers to the source
+func referenceParamForGraphParams(resource, graphParams, source string) (string, bool) {
+	values, err := url.ParseQuery(graphParams)
+	if err != nil || len(values) != 1 {
+		return "", false
+	}
+	for key, value := range values {
+		name, _, _ := ParseParamNameModifierAndPostFix(key)
+		info, ok := SearchParameters()[resource][name]
+		if !ok || info.Type != "reference" || !isValidTarget(source, info) || len(value) != 1 || value[0] != "{ref}" {
+			return "", false
+		}
+		return name, true
+	}
+	return "", false
+}
+
+// sortedParamNames returns the names of a resource's search parameters in order, so that the
+// same parameter is chosen whenever several match
+func sortedParamNames(resource string) []string {
+	names := make([]string, 0, len(SearchParameters()[resource]))
+	for name := range SearchParameters()[resource] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,72 @@
+package search
+
+import (
+	"fmt"
+)
+
+// Values of the "handling" preference of the Prefer header, which determines
+// what happens to unknown search parameters (see http://hl7.org/fhir/search.html#errors)
+const (
+	StrictHandling  = "strict"  // Unknown parameters are an error
+	LenientHandling = "lenient" // Unknown parameters are ignored
+)
+
+// UnknownParams returns the names of the query's parameters that aren't
+// supported for its resource (in the order they appear in the query).
+// These are the parameters that would otherwise cause MSG_PARAM_UNKNOWN or
+// SEARCH_NONE errors.
+func (q *Query) UnknownParams() []string {
+	queryParams, _ := ParseQuery(q.Query)
+
+	// A named query's own parameters are known to it
+	var namedQuery *NamedQueryParam
+	if name := queryParams.Get(QueryParam); name != "" {
+		if query, err := GlobalRegistry().LookupNamedQuery(name); err == nil {
+			namedQuery = &NamedQueryParam{Query: query}
+		}
+	}
+
+	var unknown []string
+	for _, queryParam := range queryParams.All() {
+		param, _, _ := ParseParamNameModifierAndPostFix(queryParam.Key)
+		switch {
+		case isSearchResultParam(param):
+		case param == FilterParam, param == ListParam, param == QueryParam, param == HasParam, param == CompartmentParam:
+		case namedQuery != nil && namedQuery.hasParameter(param):
+		default:
+			if _, ok := SearchParameters()[q.Resource][param]; !ok && !contains(unknown, param) {
+				unknown = append(unknown, param)
+			}
+		}
+	}
+	return unknown
+}
+
+// CheckUnknownParams panics with a MSG_PARAM_UNKNOWN search error (Bad Request)
+// naming the first of the query's unknown parameters (and suggesting similar ones),
+// as required for strict handling.
+func (q *Query) CheckUnknownParams() {
+	if unknown := q.UnknownParams(); len(unknown) > 0 {
+		panic(withParamSuggestions(createInvalidSearchError("MSG_PARAM_UNKNOWN", fmt.Sprintf("Parameter \"%s\" not understood", unknown[0])), q.Resource, unknown[0]))
+	}
+}
+
+// WithoutParams returns a copy of the query without the given parameters
+// (including any of their modifiers and chains), e.g. to ignore unknown
+// parameters for lenient handling.  Since the links of search results are
+// based on the query, they only contain the parameters that were used.
+func (q *Query) WithoutParams(params []string) Query {
+	if len(params) == 0 {
+		return *q
+	}
+
+	queryParams, _ := ParseQuery(q.Query)
+	newParams := URLQueryParameters{}
+	for _, queryParam := range queryParams.All() {
+		param, _, _ := ParseParamNameModifierAndPostFix(queryParam.Key)
+		if !contains(params, param) {
+			newParams.Add(queryParam.Key, queryParam.Value)
+		}
+	}
+	return Query{Resource: q.Resource, Query: newParams.Encode()}
+}
@@ -0,0 +1,58 @@
+package search
+
+import (
+	"sort"
+	"strings"
+)
+
+// IndexHints pins searches of particular shapes (see SearchShape) to particular indexes, for when
+// MongoDB's query planner picks a poor one (e.g. for token and date parameters on large collections).
+// Hints are index names or keys (a bson.D) and are used by the find or the first stage of the
+// aggregation pipeline of a search, and by the count of its total.
+type IndexHints map[string]interface{}
+
+// Add pins searches of a resource using exactly the given search parameters to an index
+func (hints IndexHints) Add(resource string, params []string, index interface{}) {
+	hints[searchShape(resource, params)] = index
+}
+
+// lookup returns the hint for a query, or nil if it doesn't have one
+func (hints IndexHints) lookup(query Query) interface{} {
+	if len(hints) == 0 {
+		return nil
+	}
+	return hints[SearchShape(query)]
+}
+
+// SearchShape returns the shape of a query: its resource and the sorted names of the search parameters
+// it uses (without modifiers, chains or values, and ignoring search result parameters), e.g. for
+// Observation?date=ge2019&code=1234-5&_count=10 it's Observation?code,date
+func SearchShape(query Query) string {
+	queryParams, _ := ParseQuery(query.Query)
+	var params []string
+	for _, queryParam := range queryParams.All() {
+		param, _, _ := ParseParamNameModifierAndPostFix(queryParam.Key)
+		if !isSearchResultParam(param) {
+			params = append(params, param)
+		}
+	}
+	return searchShape(query.Resource, params)
+}
+
+func searchShape(resource string, params []string) string {
+	distinct := make([]string, 0, len(params))
+	seen := make(map[string]bool)
+	for _, param := range params {
+		if !seen[param] {
+			seen[param] = true
+			distinct = append(distinct, param)
+		}
+	}
+	sort.Strings(distinct)
+	return resource + "?" + strings.Join(distinct, ",")
+}
+
+// SetIndexHints sets the indexes that searches of particular shapes use
+func (m *MongoSearcher) SetIndexHints(hints IndexHints) {
+	m.indexHints = hints
+}
@@ -0,0 +1,47 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ListQueryParam represents the _list search parameter.  The following
+// description is from the FHIR STU3 specification:
+//
+// The _list parameter allows for the retrieval of resources that are
+// referenced by a List resource (e.g. "Patient?_list=42" returns the Patient
+// resources referenced by List/42).
+//
+// Alternative lists (e.g. "_list=42,43") match the resources in any of them.
+type ListQueryParam struct {
+	SearchParamInfo
+	ListIDs []string
+}
+
+func (l *ListQueryParam) getInfo() SearchParamInfo {
+	return l.SearchParamInfo
+}
+
+func (l *ListQueryParam) setInfo(info SearchParamInfo) {
+	l.SearchParamInfo = info
+}
+
+func (l *ListQueryParam) getQueryParamAndValue() (string, string) {
+	return queryParamAndValue(l.SearchParamInfo, strings.Join(l.ListIDs, ","))
+}
+
+// ParseListQueryParam parses a _list value and returns a pointer to a
+// ListQueryParam for the given resource.
+func ParseListQueryParam(resource, value string) *ListQueryParam {
+	info := SearchParamInfo{Resource: resource, Name: ListParam, Type: "list"}
+	l := &ListQueryParam{SearchParamInfo: info}
+	for _, id := range strings.Split(value, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			l.ListIDs = append(l.ListIDs, id)
+		}
+	}
+	if len(l.ListIDs) == 0 {
+		panic(createInvalidSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", ListParam)))
+	}
+	return l
+}
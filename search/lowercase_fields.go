@@ -0,0 +1,89 @@
+package search
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/eug48/fhir/models2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// lowercaseMatch is a case-insensitive match that's rewritten by matchLowercaseFields to a
+// (case-sensitive and so indexable) match of the lowercase copy of the field it's matched against.
+// Its value is a lowercase string or a regex of one.
+type lowercaseMatch struct {
+	value interface{}
+}
+
+// SetLowercaseFields makes case-insensitive searches match the lowercase copies of strings, codes and
+// uris stored with resources (see models2.ConvertJsonToGoFhirBSON) rather than using case-insensitive
+// regexes, which can't use indexes efficiently.  It should only be enabled once every resource has been
+// stored (or re-stored) with lowercase copies, since resources without them won't be found.
+func (m *MongoSearcher) SetLowercaseFields(enabled bool) {
+	m.lowercaseFields = enabled
+}
+
+// usesLowercaseFields checks if case-insensitive searches match the lowercase copies of fields
+func (m *MongoSearcher) usesLowercaseFields() bool {
+	return m.lowercaseFields && m.enableCISearches
+}
+
+func lowercaseExactMatch(s string) lowercaseMatch {
+	return lowercaseMatch{value: strings.ToLower(s)}
+}
+
+func lowercaseRegexMatch(pattern string, s string) lowercaseMatch {
+	return lowercaseMatch{value: primitive.Regex{Pattern: fmt.Sprintf(pattern, regexp.QuoteMeta(strings.ToLower(s)))}}
+}
+
+// matchLowercaseFields rewrites the lowercaseMatches in a query (or pipeline) into matches of the
+// lowercase copies of the fields they're matched against, e.g. {"name.family": lowercaseMatch{"smith"}}
+// becomes {"name.family__lower": "smith"} and {"code": {"$in": [lowercaseMatch{"a"}, ...]}} becomes
+// {"code__lower": {"$in": ["a", ...]}}
+func matchLowercaseFields(query interface{}) interface{} {
+	rewritten, _ := rewriteLowercaseMatches(query)
+	return rewritten
+}
+
+// rewriteLowercaseMatches returns a copy of a value with its lowercaseMatches rewritten and whether
+// the field it's matched against (if any) should be replaced by its lowercase copy
+func rewriteLowercaseMatches(value interface{}) (rewritten interface{}, lowercase bool) {
+	switch v := value.(type) {
+	case lowercaseMatch:
+		return v.value, true
+	case bson.M:
+		result := make(bson.M, len(v))
+		for key, val := range v {
+			rewrittenVal, lowercaseVal := rewriteLowercaseMatches(val)
+			if !lowercaseVal {
+				result[key] = rewrittenVal
+			} else if strings.HasPrefix(key, "$") {
+				// An operator (e.g. $in) matching lowercase values means that
+				// the field it applies to needs to be the lowercase copy
+				result[key] = rewrittenVal
+				lowercase = true
+			} else {
+				result[key+models2.Gofhir__lower] = rewrittenVal
+			}
+		}
+		return result, lowercase
+	case []bson.M:
+		result := make([]bson.M, len(v))
+		for i, item := range v {
+			rewrittenItem, _ := rewriteLowercaseMatches(item)
+			result[i] = rewrittenItem.(bson.M)
+		}
+		return result, false
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			var lowercaseItem bool
+			result[i], lowercaseItem = rewriteLowercaseMatches(item)
+			lowercase = lowercase || lowercaseItem
+		}
+		return result, lowercase
+	}
+	return value, false
+}
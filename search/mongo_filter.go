@@ -0,0 +1,95 @@
+package search
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (m *MongoSearcher) createFilterQueryObject(f *FilterQueryParam) bson.M {
+	return m.createFilterExpressionObject(f.Resource, f.Filter)
+}
+
+func (m *MongoSearcher) createFilterExpressionObject(resource string, expression FilterExpression) bson.M {
+	switch e := expression.(type) {
+	case *FilterLogical:
+		left := m.createFilterExpressionObject(resource, e.Left)
+		right := m.createFilterExpressionObject(resource, e.Right)
+		return bson.M{"$" + e.Operator: []bson.M{left, right}}
+	case *FilterNot:
+		return bson.M{"$nor": []bson.M{m.createFilterExpressionObject(resource, e.Expression)}}
+	case *FilterComparison:
+		return m.createFilterComparisonObject(resource, e)
+	}
+	panic(createInternalServerError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", FilterParam)))
+}
+
+// createFilterComparisonObject converts a comparison such as "birthdate ge 2014-10-10" into
+// the equivalent search parameter (birthdate=ge2014-10-10) and builds its query object.
+func (m *MongoSearcher) createFilterComparisonObject(resource string, c *FilterComparison) bson.M {
+	info, ok := SearchParameters()[resource][c.Parameter]
+	if !ok {
+		panic(createInvalidSearchError("SEARCH_NONE", fmt.Sprintf("Error: no processable search found for %s search parameters \"%s\"", resource, c.Parameter)))
+	}
+	info = info.clone()
+
+	// The value is a single value, so commas must not be treated as an OR
+	value := strings.Replace(c.Value, ",", "\\,", -1)
+
+	var param SearchParam
+	switch c.Operator {
+	case "eq":
+		param = info.CreateSearchParam(value)
+	case "ne":
+		// Resources that don't have an equal value
+		eq := &FilterComparison{Parameter: c.Parameter, Operator: "eq", Value: c.Value}
+		return bson.M{"$nor": []bson.M{m.createFilterComparisonObject(resource, eq)}}
+	case "gt", "lt", "ge", "le", "sa", "eb", "ap":
+		if info.Type != "number" && info.Type != "date" && info.Type != "quantity" {
+			panic(createUnsupportedSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", FilterParam)))
+		}
+		param = info.CreateSearchParam(c.Operator + value)
+	case "co":
+		if info.Type != "string" {
+			panic(createUnsupportedSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", FilterParam)))
+		}
+		info.Modifier = "contains"
+		param = info.CreateSearchParam(value)
+	case "sw":
+		switch info.Type {
+		case "string":
+			// Strings (and the parts of HumanNames and Addresses) that start with the value, ignoring case
+			s := ParseStringParam(value, info)
+			startsWith := func(bool) interface{} {
+				if m.usesLowercaseFields() {
+					return lowercaseRegexMatch("^%s", s.String)
+				}
+				return primitive.Regex{Pattern: "^" + regexp.QuoteMeta(s.String), Options: "i"}
+			}
+			return m.createStringMatchObject(s, startsWith)
+		case "uri":
+			info.Modifier = "below"
+			param = info.CreateSearchParam(value)
+		default:
+			panic(createUnsupportedSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", FilterParam)))
+		}
+	case "pr":
+		// "pr true" is the opposite of ":missing=true"
+		switch c.Value {
+		case "true":
+			param = &MissingParam{info, false}
+		case "false":
+			param = &MissingParam{info, true}
+		default:
+			panic(createInvalidSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", FilterParam)))
+		}
+	default:
+		// ew, po, ss, sb, in, ni and re are not supported yet
+		panic(createUnsupportedSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" operator \"%s\" is not supported", FilterParam, c.Operator)))
+	}
+
+	return m.createParamObjects([]SearchParam{param})[0]
+}
@@ -2,6 +2,7 @@ package search
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -15,14 +16,20 @@ func GlobalMongoRegistry() *MongoRegistry {
 	mongoRegistryOnce.Do(func() {
 		mongoRegistry = new(MongoRegistry)
 		mongoRegistry.builders = make(map[string]BSONBuilder)
+		mongoRegistry.queryBuilders = make(map[string]NamedQueryBuilder)
+		mongoRegistry.stageBuilders = make(map[string]PipelineStageBuilder)
 	})
 	return mongoRegistry
 }
 
 // MongoRegistry supports the registration and lookup of Mongo search parameter implementations as BSON builders.
 type MongoRegistry struct {
-	buildersLock sync.RWMutex
-	builders     map[string]BSONBuilder
+	buildersLock      sync.RWMutex
+	builders          map[string]BSONBuilder
+	queryBuildersLock sync.RWMutex
+	queryBuilders     map[string]NamedQueryBuilder
+	stageBuildersLock sync.RWMutex
+	stageBuilders     map[string]PipelineStageBuilder
 }
 
 // RegisterBSONBuilder registers a BSON builder for a given parameter type.
@@ -46,3 +53,77 @@ func (r *MongoRegistry) LookupBSONBuilder(paramType string) (builder BSONBuilder
 // BSONBuilder returns a BSON object representing the passed in search parameter.  This BSON object is expected to be
 // merged with other objects and passed into Mongo's Find function.
 type BSONBuilder func(param SearchParam, searcher *MongoSearcher) (object bson.M, err error)
+
+// RegisterNamedQueryBuilder registers the implementation of a named query.
+func (r *MongoRegistry) RegisterNamedQueryBuilder(name string, builder NamedQueryBuilder) {
+	r.queryBuildersLock.Lock()
+	defer r.queryBuildersLock.Unlock()
+	r.queryBuilders[name] = builder
+}
+
+// LookupNamedQueryBuilder looks up the implementation of a named query.  If no builder is registered, it will return an
+// error.
+func (r *MongoRegistry) LookupNamedQueryBuilder(name string) (builder NamedQueryBuilder, err error) {
+	r.queryBuildersLock.RLock()
+	defer r.queryBuildersLock.RUnlock()
+	b, ok := r.queryBuilders[name]
+	if !ok {
+		return NamedQueryBuilder{}, fmt.Errorf("Could not find named query builder for %s", name)
+	}
+	return b, nil
+}
+
+// NamedQueryBuilder implements a named query.  BSON returns the criteria matched by the query's results, which are
+// merged with those of any other search parameters.  Pipeline (which is optional) returns aggregation pipeline stages
+// that are applied after those of the other search parameters, for queries that can't be expressed as criteria.
+type NamedQueryBuilder struct {
+	BSON     func(param *NamedQueryParam, searcher *MongoSearcher) (object bson.M, err error)
+	Pipeline func(param *NamedQueryParam, searcher *MongoSearcher) (stages []bson.M, err error)
+}
+
+// RegisterPipelineStageBuilder registers a pipeline stage builder with a given name, replacing any already registered
+// with that name.
+func (r *MongoRegistry) RegisterPipelineStageBuilder(name string, builder PipelineStageBuilder) {
+	r.stageBuildersLock.Lock()
+	defer r.stageBuildersLock.Unlock()
+	r.stageBuilders[name] = builder
+}
+
+// UnregisterPipelineStageBuilder removes the pipeline stage builder with a given name, if one is registered.
+func (r *MongoRegistry) UnregisterPipelineStageBuilder(name string) {
+	r.stageBuildersLock.Lock()
+	defer r.stageBuildersLock.Unlock()
+	delete(r.stageBuilders, name)
+}
+
+// LookupPipelineStageBuilder looks up a pipeline stage builder by name.  If no builder is registered, it will return
+// an error.
+func (r *MongoRegistry) LookupPipelineStageBuilder(name string) (builder PipelineStageBuilder, err error) {
+	r.stageBuildersLock.RLock()
+	defer r.stageBuildersLock.RUnlock()
+	b, ok := r.stageBuilders[name]
+	if !ok {
+		return nil, fmt.Errorf("Could not find pipeline stage builder for %s", name)
+	}
+	return b, nil
+}
+
+// PipelineStageBuilderNames returns the names of the registered pipeline stage builders, in the order their stages are
+// applied (sorted by name).
+func (r *MongoRegistry) PipelineStageBuilderNames() []string {
+	r.stageBuildersLock.RLock()
+	defer r.stageBuildersLock.RUnlock()
+	names := make([]string, 0, len(r.stageBuilders))
+	for name := range r.stageBuilders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PipelineStageBuilder returns aggregation pipeline stages (e.g. $lookup and $match stages) that are applied to the
+// results of every search after those of its search parameters, e.g. to filter them by tenant or to implement custom
+// chained searches.  Builders that don't apply to a query return no stages.  Queries that are given stages are always
+// searched using an aggregation pipeline.  Errors that are a *Error (e.g. for invalid queries) are reported as they
+// are, and others as internal server errors.
+type PipelineStageBuilder func(query Query, searcher *MongoSearcher) (stages []bson.M, err error)
@@ -30,3 +30,26 @@ func (s *MongoRegistrySuite) TestLookupNonExistingBSONBuilder(c *C) {
 	c.Assert(err, Not(IsNil))
 	c.Assert(obtained, IsNil)
 }
+
+func (s *MongoRegistrySuite) TestRegisterAndLookupPipelineStageBuilder(c *C) {
+	build := func(query Query, searcher *MongoSearcher) ([]bson.M, error) {
+		return []bson.M{{"$match": bson.M{"resourceType": query.Resource}}}, nil
+	}
+
+	GlobalMongoRegistry().RegisterPipelineStageBuilder("test-b", build)
+	GlobalMongoRegistry().RegisterPipelineStageBuilder("test-a", build)
+	c.Assert(GlobalMongoRegistry().PipelineStageBuilderNames(), DeepEquals, []string{"test-a", "test-b"})
+
+	obtained, err := GlobalMongoRegistry().LookupPipelineStageBuilder("test-a")
+	util.CheckErr(err)
+	stages, err := obtained(Query{Resource: "Patient"}, nil)
+	util.CheckErr(err)
+	c.Assert(stages, DeepEquals, []bson.M{{"$match": bson.M{"resourceType": "Patient"}}})
+
+	GlobalMongoRegistry().UnregisterPipelineStageBuilder("test-a")
+	GlobalMongoRegistry().UnregisterPipelineStageBuilder("test-b")
+	c.Assert(GlobalMongoRegistry().PipelineStageBuilderNames(), HasLen, 0)
+	obtained, err = GlobalMongoRegistry().LookupPipelineStageBuilder("test-a")
+	c.Assert(err, Not(IsNil))
+	c.Assert(obtained, IsNil)
+}
@@ -3,7 +3,6 @@ package search
 import (
 	"bytes"
 	"context"
-	"crypto/md5"
 	"fmt"
 	"net/http"
 	"regexp"
@@ -16,22 +15,36 @@ import (
 
 	"github.com/eug48/fhir/models"
 	"github.com/eug48/fhir/models2"
-	mongowrapper "github.com/opencensus-integrations/gomongowrapper"
+	"github.com/eug48/fhir/utils"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	moptions "go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/otel"
+	"golang.org/x/sync/errgroup"
 )
 
+// tracer traces searches with OpenTelemetry, as children of the spans of the contexts they're run with
+var tracer = otel.Tracer("github.com/eug48/fhir/search")
+
 // This is a MongoDB internal error code for an interrupted operation, see:
 // https://github.com/mongodb/mongo/blob/master/src/mongo/base/error_codes.err#L217
 var opInterruptedCode = 11601
 
+// This is the MongoDB error code for an operation that exceeded its maxTimeMS
+var maxTimeMSExpiredCode = 50
+
 // BSONQuery is a BSON document constructed from the original string search query.
+// The Hint (if any) is the index it should use (see IndexHints) and the Collation (if any)
+// is how it compares strings (see SetCollation).  The Collection is the one searched if it
+// isn't the resource's own (i.e. ContainedCollection, for searches of contained resources).
 type BSONQuery struct {
-	Resource string
-	Query    bson.M
-	Pipeline []bson.M
+	Resource   string
+	Query      bson.M
+	Pipeline   []bson.M
+	Hint       interface{}
+	Collation  *moptions.Collation
+	Collection string
 }
 
 // NewBSONQuery initializes a new BSONQuery and returns a pointer to that BSONQuery.
@@ -43,6 +56,14 @@ func (b *BSONQuery) usesPipeline() bool {
 	return b.Query == nil
 }
 
+// collectionName returns the name of the collection the query searches
+func (b *BSONQuery) collectionName() string {
+	if b.Collection != "" {
+		return b.Collection
+	}
+	return models.PluralizeLowerResourceName(b.Resource)
+}
+
 func (b *BSONQuery) DebugString() string {
 	out := bytes.Buffer{}
 	out.WriteString(fmt.Sprintf("Resource: %s; ", b.Resource))
@@ -65,19 +86,21 @@ func (b *BSONQuery) DebugString() string {
 		out.Write(pipelineJson)
 		out.WriteString("; ")
 	}
+	if b.Hint != nil {
+		out.WriteString(fmt.Sprintf("Hint: %v; ", b.Hint))
+	}
+	if b.Collation != nil {
+		out.WriteString(fmt.Sprintf("Collation: %s (strength %d); ", b.Collation.Locale, b.Collation.Strength))
+	}
+	if b.Collection != "" {
+		out.WriteString(fmt.Sprintf("Collection: %s; ", b.Collection))
+	}
 	return out.String()
 }
 
-// CountCache is used to cache the total count of results for a specific query.
-// The Id is the md5 hash of the query string.
-type CountCache struct {
-	Id    string `bson:"_id"`
-	Count uint32 `bson:"count"`
-}
-
 // MongoSearcher implements FHIR searches using the Mongo database.
 type MongoSearcher struct {
-	db                           *mongowrapper.WrappedDatabase
+	db                           *mongo.Database
 	ctx                          context.Context
 	client                       *mongo.Client // only non-nil for newly created sessions - Close() should be called
 	session                      mongo.Session // only non-nil for newly created sessions - Close() should be called
@@ -85,10 +108,29 @@ type MongoSearcher struct {
 	enableCISearches             bool
 	tokenParametersCaseSensitive bool
 	readonly                     bool
+	maxChainDepth                int
+	maxIncludeDepth              int
+	maxIncludes                  int
+	keysetPaging                 bool
+	maxTime                      time.Duration
+	cacheCounts                  bool
+	countCache                   CountCache
+	indexHints                   IndexHints
+	lowercaseFields              bool
+	precomputedCompartments      bool
+	collation                    *moptions.Collation
 }
 
+// DefaultMaxChainDepth is the default maximum number of references a chained
+// search parameter may traverse (e.g. "subject.organization.name" has a depth of 2)
+const DefaultMaxChainDepth = 3
+
+// DefaultMaxIncludeDepth is the default maximum number of times _include:iterate and
+// _revinclude:iterate parameters are applied to the resources included by the previous iteration
+const DefaultMaxIncludeDepth = 3
+
 // NewMongoSearcher creates a new instance of a MongoSearcher for an already open session
-func NewMongoSearcher(db *mongowrapper.WrappedDatabase, ctx context.Context, countTotalResults, enableCISearches, tokenParametersCaseSensitive, readonly bool) *MongoSearcher {
+func NewMongoSearcher(db *mongo.Database, ctx context.Context, countTotalResults, enableCISearches, tokenParametersCaseSensitive, readonly bool) *MongoSearcher {
 	return &MongoSearcher{
 		db:                           db,
 		ctx:                          ctx,
@@ -96,6 +138,8 @@ func NewMongoSearcher(db *mongowrapper.WrappedDatabase, ctx context.Context, cou
 		enableCISearches:             enableCISearches,
 		tokenParametersCaseSensitive: tokenParametersCaseSensitive,
 		readonly:                     readonly,
+		maxChainDepth:                DefaultMaxChainDepth,
+		maxIncludeDepth:              DefaultMaxIncludeDepth,
 	}
 }
 
@@ -103,7 +147,7 @@ func NewMongoSearcher(db *mongowrapper.WrappedDatabase, ctx context.Context, cou
 // Call Close()
 func NewMongoSearcherForUri(mongoUri string, mongoDatabaseName string, countTotalResults, enableCISearches, tokenParametersCaseSensitive, readonly bool) *MongoSearcher {
 
-	client, err := mongowrapper.Connect(context.Background(), moptions.Client().ApplyURI(mongoUri))
+	client, err := mongo.Connect(context.Background(), utils.MongoClientOptions(mongoUri))
 	if err != nil {
 		panic(errors.Wrap(err, "NewMongoSearcherForUri"))
 	}
@@ -123,6 +167,8 @@ func NewMongoSearcherForUri(mongoUri string, mongoDatabaseName string, countTota
 		enableCISearches:             enableCISearches,
 		tokenParametersCaseSensitive: tokenParametersCaseSensitive,
 		readonly:                     readonly,
+		maxChainDepth:                DefaultMaxChainDepth,
+		maxIncludeDepth:              DefaultMaxIncludeDepth,
 	}
 }
 
@@ -136,47 +182,139 @@ func (m *MongoSearcher) Close() {
 
 // GetDB returns a pointer to the Mongo database.  This is helpful for custom search
 // implementations.
-func (m *MongoSearcher) GetDB() *mongowrapper.WrappedDatabase {
+func (m *MongoSearcher) GetDB() *mongo.Database {
 	return m.db
 }
 
+// SetMaxChainDepth sets the maximum number of references a chained search
+// parameter may traverse.  Deeper chains are rejected as unsupported.  A depth
+// of 0 or less restores the DefaultMaxChainDepth.
+func (m *MongoSearcher) SetMaxChainDepth(depth int) {
+	if depth <= 0 {
+		depth = DefaultMaxChainDepth
+	}
+	m.maxChainDepth = depth
+}
+
+// SetMaxIncludeDepth sets the maximum number of times _include:iterate and
+// _revinclude:iterate parameters are applied to included resources.  A depth
+// of 0 or less restores the DefaultMaxIncludeDepth.
+func (m *MongoSearcher) SetMaxIncludeDepth(depth int) {
+	if depth <= 0 {
+		depth = DefaultMaxIncludeDepth
+	}
+	m.maxIncludeDepth = depth
+}
+
+// SetMaxIncludes limits the number of resources each _include or _revinclude may include for
+// each result (0 for no limit), so that the $lookups of a page of results can't pull in thousands
+// of resources.  One more resource than the limit is kept, so that truncation can be detected.
+func (m *MongoSearcher) SetMaxIncludes(maxIncludes int) {
+	m.maxIncludes = maxIncludes
+}
+
+// SetKeysetPaging enables keyset paging, where the results are ordered by _id
+// (after any _sort) and SearchPage returns a PageCursor for the next page of
+// results.  It is only used for queries that don't specify an _offset and
+// that SupportsKeysetPaging.  Queries with a _cursor always use keyset paging.
+func (m *MongoSearcher) SetKeysetPaging(enabled bool) {
+	m.keysetPaging = enabled
+}
+
+// SetMaxTime limits the time MongoDB may spend on each of the operations of a
+// search (using maxTimeMS).  Searches that take longer are interrupted with a
+// too-costly error.  A time of 0 or less means there is no limit.
+func (m *MongoSearcher) SetMaxTime(maxTime time.Duration) {
+	m.maxTime = maxTime
+}
+
 // Search takes a Query and returns a set of results (Resources).
 // If an error occurs during the search the corresponding mongo error
 // is returned and results will be nil.
 func (m *MongoSearcher) Search(query Query) (resources []*models2.Resource, total uint32, err error) {
+	resources, total, _, err = m.SearchPage(query)
+	return resources, total, err
+}
+
+// SearchPage is like Search, but also returns a cursor for the next page of
+// results if keyset paging was used.  The cursor is nil if keyset paging wasn't
+// used or if there are no more results.  Queries with a _resultset take their
+// results from the snapshot created by CreateResultSet.  Queries with _contained
+// search the resources contained by others (see IndexContainedResources).  Queries
+// without a Resource search all resource types (see IsSystemSearch).
+func (m *MongoSearcher) SearchPage(query Query) (resources []*models2.Resource, total uint32, next *PageCursor, err error) {
+
+	if query.IsSystemSearch() {
+		resources, total, err = m.searchSystem(query)
+		return resources, total, nil, err
+	}
+
+	if graphID := query.Options().Graph; graphID != "" {
+		// The resources a GraphDefinition links are included like those of _include and _revinclude
+		query, err = m.withGraphIncludes(query, graphID)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+	}
+
+	options, keyset := m.searchOptions(query)
+
+	if options.Contained == "both" {
+		resources, total, err = m.searchWithContained(query, options)
+		return resources, total, nil, err
+	}
+
+	resources, total, next, err = m.searchPage(query, options, keyset)
+	if err == nil && options.returnsContainers() {
+		// The total is still that of the matching contained resources
+		resources, err = m.findContainers(resources, options)
+	}
+	return resources, total, next, err
+}
+
+// searchPage returns a page of the results of a query with the given options (see SearchPage)
+func (m *MongoSearcher) searchPage(query Query, options *QueryOptions, keyset bool) (resources []*models2.Resource, total uint32, next *PageCursor, err error) {
+	if options.ResultSet != "" {
+		resources, total, err = m.searchResultSet(query, options)
+		return resources, total, nil, err
+	}
+
+	// The _total parameter (if present) overrides m.countTotalResults.
+	doCount := options.CountsTotal(m.countTotalResults)
 
 	// Check to see if we already have a count cached for this query. If so, use it
 	// and tell the searcher to skip doing the count. This can only be done reliably if
-	// the server is in -readonly mode.
-	doCount := true
+	// the server is in -readonly mode or invalidates the cache when resources change
+	// (see SetCountCache). Estimated counts are never cached, nor are those of contained
+	// resources (which change with their containers).
 	var queryHash string
+	cacheCount := m.usesCountCache() && doCount && options.Total != "estimate" && !options.searchesContained()
 
-	if m.readonly && m.countTotalResults {
-		queryHash = fmt.Sprintf("%x", md5.Sum([]byte(query.Resource+"?"+query.Query)))
-		countcacheQuery := bson.D{{Key: "_id", Value: queryHash}}
-		countcache := &CountCache{}
-		err = m.db.Collection("countcache").FindOne(m.ctx, countcacheQuery).Decode(&countcache)
-		if err == nil {
+	if cacheCount {
+		queryHash = countCacheID(query)
+		if cachedTotal, found := m.lookupCachedCount(queryHash); found {
 			// Use the cached total and don't bother recomputing it.
-			total = countcache.Count
+			total = cachedTotal
 			doCount = false
 		}
 	}
 
 	// There's no point in running the query if we already know it will return 0 results.
-	if m.readonly && !doCount && total == 0 {
-		return resources, 0, nil
+	if cacheCount && !doCount && total == 0 {
+		return resources, 0, nil, nil
 	}
 
-	// Don't do the count at all if m.countTotalResults is disabled.
-	if !m.countTotalResults {
-		doCount = false
+	// Pages after the first _maxresults results are empty, but may still need the total
+	if options.MaxResults > 0 && options.Count == 0 {
+		if !doCount {
+			return resources, total, nil, nil
+		}
+		options.Summary = "count"
 	}
 
 	var computedTotal uint32
 	var cursor *mongo.Cursor
 	var start time.Time
-	options := query.Options()
 	bsonQuery := m.convertToBSON(query) // build the BSON query (without any options)
 	usesPipeline := bsonQuery.usesPipeline()
 
@@ -211,133 +349,217 @@ func (m *MongoSearcher) Search(query Query) (resources []*models2.Resource, tota
 
 	// Check if the query returned any errors
 	if err != nil {
-		return nil, 0, errors.Wrap(err, "Search error")
-
-		// TODO?
-		// if e.Code == opInterruptedCode {
-		// 	// This query operation was interrupted
-		// 	panic(createOpInterruptedError("Long-running operation interrupted"))
-		// }
-		// return nil, 0, err
+		if m.isInterrupted(err) {
+			// This query operation took too long or was cancelled
+			panic(createOpInterruptedError("Long-running operation interrupted"))
+		}
+		return nil, 0, nil, errors.Wrap(err, "Search error")
 	}
 
 	// If the search was for _summary=count, don't collect the results
 	// and just return the total.
 	if options.Summary == "count" {
 		// results should be an empty slice
-		return resources, computedTotal, nil
+		return resources, computedTotal, nil, nil
 	}
 
 	// Collect the results
+	var last bson.D
 	if cursor != nil {
 		for cursor.Next(m.ctx) {
 			var document bson.D
 			err := cursor.Decode(&document)
 			if err != nil {
-				return nil, 0, errors.Wrap(err, "Search result decoding error")
+				return nil, 0, nil, errors.Wrap(err, "Search result decoding error")
 			}
 
 			resource, err := models2.NewResourceFromBSON(document)
 			if err != nil {
-				return nil, 0, errors.Wrap(err, "Search: NewResourceFromBSON failed")
+				return nil, 0, nil, errors.Wrap(err, "Search: NewResourceFromBSON failed")
 			}
 			resources = append(resources, resource)
+			last = document
 		}
 		if err := cursor.Err(); err != nil {
-			return nil, 0, errors.Wrap(err, "Search cursor error")
+			if m.isInterrupted(err) {
+				panic(createOpInterruptedError("Long-running operation interrupted"))
+			}
+			return nil, 0, nil, errors.Wrap(err, "Search cursor error")
 		}
 	}
 
 	// If the count wasn't already in cache, add it to cache.
-	if m.readonly && m.countTotalResults && doCount {
-		countcache := &CountCache{
-			Id:    queryHash,
-			Count: computedTotal,
-		}
-		// Don't collect the error here since this should fail silently.
-		m.db.Collection("countcache").InsertOne(m.ctx, countcache)
+	if cacheCount && doCount {
+		m.cacheCount(query, queryHash, computedTotal)
 	}
 
 	// The computed total will only be used if the server had no cached
-	// count for this search and countTotalResults is true.
+	// count for this search and a count was requested.
 	if doCount {
 		total = computedTotal
 	}
 
-	return resources, total, nil
+	// A full page means there may be more results after the last one
+	if keyset && options.Count > 0 && len(resources) == options.Count {
+		next = createNextPageCursor(last, options)
+	}
+
+	return resources, total, next, nil
+}
+
+// searchOptions returns the options of a query (with its _count limited by its _maxresults), and
+// whether its results are paged using a keyset
+func (m *MongoSearcher) searchOptions(query Query) (options *QueryOptions, keyset bool) {
+	options = query.Options()
+	options.limitToMaxResults()
+
+	// Keyset paging replaces _offset when resuming from a _cursor
+	keyset = options.Cursor != nil || (m.keysetPaging && options.Offset == 0 && options.ResultSet == "" && options.MaxResults == 0 && options.Contained == "" && options.SupportsKeysetPaging())
+	if keyset {
+		options.Offset = 0
+		createKeysetSort(query.Resource, options)
+	}
+	return options, keyset
 }
 
 // aggregate takes a BSONQuery and runs its Pipeline through the mongo aggregation framework. Any query options
 // will be added to the end of the pipeline.
 func (m *MongoSearcher) aggregate(bsonQuery *BSONQuery, options *QueryOptions, doCount bool) (cursor *mongo.Cursor, total uint32, err error) {
-	c := m.db.Collection(models.PluralizeLowerResourceName(bsonQuery.Resource))
-
-	// First get a count of the total results (doesn't apply any options)
-	if doCount || options.Summary == "count" {
-		if len(bsonQuery.Pipeline) == 1 {
-			// The pipeline is only being used for includes/revincludes, meaning the entire
-			// collection is being searched. It's faster just to get a total count from the
-			// collection after a find operation. The first stage in the Pipeline will
-			// always be a $match stage.
-			match := bsonQuery.Pipeline[0]["$match"]
-			intTotal, err := c.CountDocuments(m.ctx, match)
-			if err != nil {
-				return nil, 0, err
-			}
-			total = uint32(intTotal)
-		} else {
-			// Do the count in the aggregation framework
-			countStage := bson.M{"$group": bson.M{
-				"_id":   nil,
-				"total": bson.M{"$sum": 1},
-			}}
-			countPipeline := make([]bson.M, len(bsonQuery.Pipeline)+1)
-			copy(countPipeline, bsonQuery.Pipeline)
-			countPipeline[len(countPipeline)-1] = countStage
-
-			cursor, err := c.Aggregate(m.ctx, countPipeline)
-			if err != nil {
-				return nil, 0, errors.Wrap(err, "aggregate count failed")
-			}
-			if cursor.Next(m.ctx) {
-				result := struct {
-					Total float64 `bson:"total"`
-				}{}
-				err = cursor.Decode(&result)
-				if err != nil {
-					return nil, 0, errors.Wrap(err, "aggregate count decode failed")
-				}
-				if err := cursor.Err(); err != nil {
-					return nil, 0, errors.Wrap(err, "aggregate count cursor has an error")
-				}
-				total = uint32(result.Total)
-			} else {
-				glog.V(3).Infof("aggregate count --> cursor Next returned false")
-				err = cursor.Err()
-				if err != nil {
-					return nil, 0, errors.Wrap(err, "aggregate count cursor --> next failed")
-				}
-			}
-		}
-	}
+	c := m.db.Collection(bsonQuery.collectionName())
 
 	if options.Summary == "count" {
 		// Just return the count and don't do the search.
+		total, err = m.aggregateCount(m.ctx, c, bsonQuery, options)
+		if err != nil {
+			return nil, 0, err
+		}
 		glog.V(3).Infof("returning only total (%d)", total)
 		return nil, total, nil
 	}
 
 	// Now setup the search pipeline (applying options, if any)
+	searchPipeline := m.createSearchPipeline(bsonQuery, options)
+
+	// The count of the total results (which doesn't apply any options) is independent of the search
+	total, err = m.runWithCount(doCount, func(ctx context.Context) (uint32, error) {
+		return m.aggregateCount(ctx, c, bsonQuery, options)
+	}, func(ctx context.Context) error {
+		cursor, err = c.Aggregate(ctx, searchPipeline, m.aggregateOptions(bsonQuery).SetAllowDiskUse(true))
+		return errors.Wrap(err, "aggregate operation failed")
+	})
+	if err != nil {
+		if cursor != nil {
+			cursor.Close(m.ctx)
+		}
+		return nil, 0, err
+	}
+	glog.V(3).Infof("returning cursor")
+	return cursor, total, nil
+}
+
+// createSearchPipeline returns the pipeline of a BSONQuery with stages applying the query options (if any)
+func (m *MongoSearcher) createSearchPipeline(bsonQuery *BSONQuery, options *QueryOptions) []bson.M {
 	searchPipeline := bsonQuery.Pipeline
+	if options != nil && options.Cursor != nil {
+		// Only the results after the cursor (the first stage is always a $match)
+		searchPipeline = make([]bson.M, 0, len(bsonQuery.Pipeline)+1)
+		searchPipeline = append(searchPipeline, bsonQuery.Pipeline[0], bson.M{"$match": createKeysetQueryObject(options)})
+		searchPipeline = append(searchPipeline, bsonQuery.Pipeline[1:]...)
+	}
 	if options != nil {
 		searchPipeline = append(searchPipeline, m.convertOptionsToPipelineStages(bsonQuery.Resource, options)...)
 	}
-	cursor, err = c.Aggregate(m.ctx, searchPipeline, moptions.Aggregate().SetAllowDiskUse(true))
+	return searchPipeline
+}
+
+// aggregateCount counts the total results of a BSONQuery's Pipeline (without applying any options)
+func (m *MongoSearcher) aggregateCount(ctx context.Context, c *mongo.Collection, bsonQuery *BSONQuery, options *QueryOptions) (uint32, error) {
+	if len(bsonQuery.Pipeline) == 1 {
+		// The pipeline is only being used for includes/revincludes, meaning the entire
+		// collection is being searched. It's faster just to get a total count from the
+		// collection after a find operation. The first stage in the Pipeline will
+		// always be a $match stage.
+		match := bsonQuery.Pipeline[0]["$match"]
+		intTotal, err := m.countDocuments(ctx, c, match, bsonQuery, options)
+		if err != nil {
+			return 0, err
+		}
+		return uint32(intTotal), nil
+	}
+
+	// Do the count in the aggregation framework
+	countStage := bson.M{"$group": bson.M{
+		"_id":   nil,
+		"total": bson.M{"$sum": 1},
+	}}
+	countPipeline := make([]bson.M, len(bsonQuery.Pipeline)+1)
+	copy(countPipeline, bsonQuery.Pipeline)
+	countPipeline[len(countPipeline)-1] = countStage
+
+	cursor, err := c.Aggregate(ctx, countPipeline, m.aggregateOptions(bsonQuery))
+	if err != nil {
+		return 0, errors.Wrap(err, "aggregate count failed")
+	}
+	defer cursor.Close(ctx)
+	if cursor.Next(ctx) {
+		result := struct {
+			Total float64 `bson:"total"`
+		}{}
+		err = cursor.Decode(&result)
+		if err != nil {
+			return 0, errors.Wrap(err, "aggregate count decode failed")
+		}
+		if err := cursor.Err(); err != nil {
+			return 0, errors.Wrap(err, "aggregate count cursor has an error")
+		}
+		return uint32(result.Total), nil
+	}
+	glog.V(3).Infof("aggregate count --> cursor Next returned false")
+	err = cursor.Err()
 	if err != nil {
-		return nil, 0, errors.Wrap(err, "aggregate operation failed")
+		return 0, errors.Wrap(err, "aggregate count cursor --> next failed")
 	}
-	glog.V(3).Infof("returning cursor")
-	return cursor, total, nil
+	return 0, nil
+}
+
+// runWithCount runs a search and, if doCount is set, the count of its total results.  These are
+// independent operations, so they're run concurrently (halving the latency of searches with
+// totals) unless the searcher's context has a session, since sessions can't be used concurrently.
+// The first error of either operation is returned, after which the other one is cancelled.
+func (m *MongoSearcher) runWithCount(doCount bool, count func(ctx context.Context) (uint32, error), search func(ctx context.Context) error) (total uint32, err error) {
+	if !doCount {
+		return 0, search(m.ctx)
+	}
+	if !m.countsInParallel() {
+		total, err = count(m.ctx)
+		if err != nil {
+			return 0, err
+		}
+		return total, search(m.ctx)
+	}
+
+	group, ctx := errgroup.WithContext(m.ctx)
+	group.Go(func() error {
+		var err error
+		total, err = count(ctx)
+		return err
+	})
+	group.Go(func() error {
+		return search(ctx)
+	})
+	if err := group.Wait(); err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+// countsInParallel checks if searches can count their total results concurrently with the search
+func (m *MongoSearcher) countsInParallel() bool {
+	if m.ctx == nil {
+		return false
+	}
+	_, hasSession := m.ctx.(mongo.SessionContext)
+	return !hasSession
 }
 
 func bson1ArrayToBytes(bson1 []bson.M) []byte {
@@ -355,35 +577,134 @@ func bson1ToBytes(bson1 bson.M) []byte {
 	return bytes
 }
 
+// countDocuments counts the documents in the collection matching the filter (using the BSONQuery's
+// hint and collation, if any).  When _total=estimate was requested and the filter matches the entire
+// collection, the (much faster) collection metadata is used to estimate the count instead.
+func (m *MongoSearcher) countDocuments(ctx context.Context, c *mongo.Collection, filter interface{}, bsonQuery *BSONQuery, options *QueryOptions) (int64, error) {
+	if options != nil && options.Total == "estimate" && isEmptyFilter(filter) {
+		estimateOptions := moptions.EstimatedDocumentCount()
+		if m.maxTime > 0 {
+			estimateOptions.SetMaxTime(m.maxTime)
+		}
+		return c.EstimatedDocumentCount(ctx, estimateOptions)
+	}
+	// c.CountDocuments rather than c.Count works in transactions
+	countOptions := moptions.Count()
+	if m.maxTime > 0 {
+		countOptions.SetMaxTime(m.maxTime)
+	}
+	if bsonQuery.Hint != nil {
+		countOptions.SetHint(bsonQuery.Hint)
+	}
+	if bsonQuery.Collation != nil {
+		countOptions.SetCollation(bsonQuery.Collation)
+	}
+	return c.CountDocuments(ctx, filter, countOptions)
+}
+
+// aggregateOptions returns the options of the aggregations run by searches (using the BSONQuery's
+// hint and collation, if any)
+func (m *MongoSearcher) aggregateOptions(bsonQuery *BSONQuery) *moptions.AggregateOptions {
+	aggregateOptions := moptions.Aggregate()
+	if m.maxTime > 0 {
+		aggregateOptions.SetMaxTime(m.maxTime)
+	}
+	if bsonQuery.Hint != nil {
+		aggregateOptions.SetHint(bsonQuery.Hint)
+	}
+	if bsonQuery.Collation != nil {
+		aggregateOptions.SetCollation(bsonQuery.Collation)
+	}
+	return aggregateOptions
+}
+
+// isInterrupted checks if a search failed because it exceeded the searcher's maxTime,
+// was killed, or because its context was cancelled (e.g. the client went away).
+func (m *MongoSearcher) isInterrupted(err error) bool {
+	cause := errors.Cause(err)
+	if cause == context.Canceled || cause == context.DeadlineExceeded || (m.ctx != nil && m.ctx.Err() != nil) {
+		return true
+	}
+	if commandErr, ok := cause.(mongo.CommandError); ok {
+		return commandErr.Code == int32(opInterruptedCode) || commandErr.Code == int32(maxTimeMSExpiredCode)
+	}
+	return false
+}
+
+// isEmptyFilter checks if a query filter matches every document in a collection.
+func isEmptyFilter(filter interface{}) bool {
+	switch f := filter.(type) {
+	case nil:
+		return true
+	case bson.M:
+		return len(f) == 0
+	case bson.D:
+		return len(f) == 0
+	}
+	return false
+}
+
 // find takes a BSONQuery and runs a standard mongo search on that query. Any query options are applied
 // after the initial search is performed.
 func (m *MongoSearcher) find(bsonQuery *BSONQuery, queryOptions *QueryOptions, doCount bool) (cursor *mongo.Cursor, total uint32, err error) {
-	c := m.db.Collection(models.PluralizeLowerResourceName(bsonQuery.Resource))
+	c := m.db.Collection(bsonQuery.collectionName())
 
-	// First get a count of the total results (doesn't apply any options)
-	if doCount || queryOptions.Summary == "count" {
-		// c.CountDocuments rather than c.Count works in transactions
-		intTotal, err := c.CountDocuments(m.ctx, bsonQuery.Query)
+	count := func(ctx context.Context) (uint32, error) {
+		intTotal, err := m.countDocuments(ctx, c, bsonQuery.Query, bsonQuery, queryOptions)
 		if err != nil {
-			return nil, 0, errors.Wrap(err, "search count operation failed")
+			return 0, errors.Wrap(err, "search count operation failed")
 		}
-		total = uint32(intTotal)
+		return uint32(intTotal), nil
 	}
 
 	if queryOptions.Summary == "count" {
 		// Just return the count and don't do the search.
+		total, err = count(m.ctx)
+		if err != nil {
+			return nil, 0, err
+		}
 		return nil, total, nil
 	}
 
-	optionsBundle := moptions.Find()
+	filter, optionsBundle := m.createFindFilterAndOptions(bsonQuery, queryOptions)
+
+	// The count of the total results (which doesn't apply any options) is independent of the search
+	total, err = m.runWithCount(doCount, count, func(ctx context.Context) error {
+		cursor, err = c.Find(ctx, filter, optionsBundle)
+		return errors.Wrap(err, "search find operation failed")
+	})
+	if err != nil {
+		if cursor != nil {
+			cursor.Close(m.ctx)
+		}
+		return nil, 0, err
+	}
+	return cursor, total, nil
+}
+
+// createFindFilterAndOptions returns the filter and the options (e.g. sort and limit) of the find
+// operation for a BSONQuery
+func (m *MongoSearcher) createFindFilterAndOptions(bsonQuery *BSONQuery, queryOptions *QueryOptions) (filter bson.M, optionsBundle *moptions.FindOptions) {
+	optionsBundle = moptions.Find()
+	if m.maxTime > 0 {
+		optionsBundle = optionsBundle.SetMaxTime(m.maxTime)
+	}
+	if bsonQuery.Hint != nil {
+		optionsBundle = optionsBundle.SetHint(bsonQuery.Hint)
+	}
+	if bsonQuery.Collation != nil {
+		optionsBundle = optionsBundle.SetCollation(bsonQuery.Collation)
+	}
 	if queryOptions != nil {
-		removeParallelArraySorts(queryOptions)
 		if len(queryOptions.Sort) > 0 {
 			fields := bson.D{}
 			for i := range queryOptions.Sort {
-				// Note: If there are multiple paths, we only look at the first one -- not ideal, but otherwise it gets tricky
+				// Sorts needing a computed sort key use the aggregation pipeline instead (see usesComputedSort)
 				field := convertSearchPathToMongoField(queryOptions.Sort[i].Parameter.Paths[0].Path)
-				if queryOptions.Sort[i].Descending {
+				if queryOptions.Sort[i].Parameter.Type == "score" {
+					// The most relevant results are always first
+					fields = append(fields, bson.E{Key: field, Value: textScore})
+				} else if queryOptions.Sort[i].Descending {
 					fields = append(fields, bson.E{Key: field, Value: -1})
 				} else {
 					fields = append(fields, bson.E{Key: field, Value: 1})
@@ -395,16 +716,39 @@ func (m *MongoSearcher) find(bsonQuery *BSONQuery, queryOptions *QueryOptions, d
 			optionsBundle = optionsBundle.SetSkip(int64(queryOptions.Offset))
 		}
 		optionsBundle = optionsBundle.SetLimit(int64(queryOptions.Count))
+		projection := createProjection(bsonQuery.Resource, queryOptions)
+		if queryOptions.sortsByTextScore() {
+			// Sorting by the text score requires it to be projected
+			if projection == nil {
+				projection = bson.M{}
+			}
+			projection[textScoreField] = textScore
+		}
+		if projection != nil {
+			optionsBundle = optionsBundle.SetProjection(projection)
+		}
 	}
 
-	searchCursor, err := c.Find(m.ctx, bsonQuery.Query, optionsBundle)
-	if err != nil {
-		return nil, 0, errors.Wrap(err, "search find operation failed")
+	filter = bsonQuery.Query
+	if queryOptions != nil && queryOptions.Cursor != nil {
+		// Only the results after the cursor
+		keysetQuery := createKeysetQueryObject(queryOptions)
+		if len(filter) == 0 {
+			filter = keysetQuery
+		} else {
+			filter = bson.M{"$and": []bson.M{filter, keysetQuery}}
+		}
 	}
-	return searchCursor, total, nil
+	if queryOptions == nil || len(queryOptions.Sort) == 0 {
+		// Locations found using near are returned closest first
+		filter = orderByDistance(filter)
+	}
+	return filter, optionsBundle
 }
 
 func (m *MongoSearcher) convertToBSON(query Query) *BSONQuery {
+	_, span := tracer.Start(m.ctx, "translating search")
+	defer span.End()
 	bsonQuery := NewBSONQuery(query.Resource)
 
 	if query.UsesPipeline() {
@@ -412,6 +756,38 @@ func (m *MongoSearcher) convertToBSON(query Query) *BSONQuery {
 	} else {
 		bsonQuery.Query = m.createQueryObject(query)
 	}
+
+	// Named queries and registered pipeline stage builders may need their own pipeline stages
+	stages := m.createNamedQueryPipelineStages(query)
+	stages = append(stages, m.createRegisteredPipelineStages(query)...)
+	if len(stages) > 0 {
+		if !bsonQuery.usesPipeline() {
+			bsonQuery.Pipeline = []bson.M{{"$match": bsonQuery.Query}}
+			bsonQuery.Query = nil
+		}
+		bsonQuery.Pipeline = append(bsonQuery.Pipeline, stages...)
+	}
+
+	if m.usesLowercaseFields() {
+		// Case-insensitive matches use the lowercase copies of fields
+		if bsonQuery.Query != nil {
+			bsonQuery.Query = matchLowercaseFields(bsonQuery.Query).(bson.M)
+		}
+		if bsonQuery.Pipeline != nil {
+			bsonQuery.Pipeline = matchLowercaseFields(bsonQuery.Pipeline).([]bson.M)
+		}
+	}
+
+	options := query.Options()
+	if options.searchesContained() {
+		// Contained resources are searched in their own collection, whose indexes the hints don't apply to
+		matchContainedResources(bsonQuery)
+	} else {
+		bsonQuery.Hint = m.indexHints.lookup(query)
+	}
+	if m.collation != nil {
+		bsonQuery.Collation = m.searchCollation(options)
+	}
 	return bsonQuery
 }
 
@@ -429,6 +805,7 @@ func (m *MongoSearcher) createQueryObjectFromParams(params []SearchParam) bson.M
 
 func (m *MongoSearcher) createParamObjects(params []SearchParam) []bson.M {
 	results := make([]bson.M, len(params))
+	fullText := false
 	for i, p := range params {
 		panicOnUnsupportedFeatures(p)
 		switch p := p.(type) {
@@ -448,8 +825,27 @@ func (m *MongoSearcher) createParamObjects(params []SearchParam) []bson.M {
 			results[i] = m.createTokenQueryObject(p)
 		case *URIParam:
 			results[i] = m.createURIQueryObject(p)
+		case *NearParam:
+			results[i] = m.createNearQueryObject(p)
+		case *FullTextParam:
+			// MongoDB only allows one text search per query
+			if fullText {
+				panic(createUnsupportedSearchError("MSG_PARAM_NO_REPEAT", "Only one _text or _content parameter is supported"))
+			}
+			fullText = true
+			results[i] = m.createFullTextQueryObject(p)
 		case *OrParam:
 			results[i] = m.createOrQueryObject(p)
+		case *MissingParam:
+			results[i] = m.createMissingQueryObject(p)
+		case *FilterQueryParam:
+			results[i] = m.createFilterQueryObject(p)
+		case *ListQueryParam:
+			results[i] = m.createListQueryObject(p)
+		case *CompartmentQueryParam:
+			results[i] = m.createCompartmentQueryObject(p)
+		case *NamedQueryParam:
+			results[i] = m.createNamedQueryObject(p)
 		default:
 			// Check for custom search parameter implementations
 			builder, err := GlobalMongoRegistry().LookupBSONBuilder(p.getInfo().Type)
@@ -505,19 +901,37 @@ func (m *MongoSearcher) convertOptionsToPipelineStages(resource string, o *Query
 	p := []bson.M{}
 
 	// support for _sort
-	removeParallelArraySorts(o)
 	if len(o.Sort) > 0 {
 		var sortBSOND bson.D
-		for _, sort := range o.Sort {
-			// Note: If there are multiple paths, we only look at the first one -- not ideal, but otherwise it gets tricky
+		sortKeys := bson.M{}
+		for i, sort := range o.Sort {
 			field := convertSearchPathToMongoField(sort.Parameter.Paths[0].Path)
-			order := 1
-			if sort.Descending {
+			if needsSortKey(o.Sort, i) {
+				// Sort on a key computed from the parameter's paths (see createSortKeyExpression)
+				field = fmt.Sprintf("_sortKey%d", i)
+				sortKeys[field] = createSortKeyExpression(sort)
+			}
+			var order interface{} = 1
+			if sort.Parameter.Type == "score" {
+				// The most relevant results are always first
+				order = textScore
+			} else if sort.Descending {
 				order = -1
 			}
 			sortBSOND = append(sortBSOND, bson.E{Key: field, Value: order})
 		}
+		if len(sortKeys) > 0 {
+			p = append(p, bson.M{"$addFields": sortKeys})
+		}
 		p = append(p, bson.M{"$sort": sortBSOND})
+		if len(sortKeys) > 0 {
+			// The sort keys aren't part of the resources
+			removeKeys := bson.M{}
+			for key := range sortKeys {
+				removeKeys[key] = 0
+			}
+			p = append(p, bson.M{"$project": removeKeys})
+		}
 	}
 
 	// support for _offset
@@ -528,76 +942,190 @@ func (m *MongoSearcher) convertOptionsToPipelineStages(resource string, o *Query
 	p = append(p, bson.M{"$limit": o.Count})
 
 	// support for _include
-	if len(o.Include) > 0 {
-		for _, incl := range o.Include {
-			for _, inclPath := range incl.Parameter.Paths {
-				if inclPath.Type != "Reference" {
+	var included []includedField
+	for _, incl := range o.Include {
+		if incl.Iterate && incl.Resource != resource {
+			// only applies to included resources (see below)
+			continue
+		}
+		stages, fields := includeLookupStages(incl, "", "")
+		p = append(p, stages...)
+		p = append(p, m.limitIncludedStages(fields)...)
+		included = append(included, fields...)
+	}
+
+	// support for _revinclude
+	for _, incl := range o.RevInclude {
+		stages, fields := revIncludeLookupStages(incl, resource, "", "")
+		p = append(p, stages...)
+		p = append(p, m.limitIncludedStages(fields)...)
+		included = append(included, fields...)
+	}
+	allIncluded := included
+
+	// support for _include:iterate and _revinclude:iterate, which also apply to the resources
+	// included by the previous iteration. The depth is limited to prevent runaway pipelines.
+	iteration := 0
+	for depth := 1; depth <= m.maxIncludeDepth && len(included) > 0; depth++ {
+		var next []includedField
+		for _, source := range included {
+			for _, incl := range o.Include {
+				if !incl.Iterate || incl.Resource != source.Resource {
 					continue
 				}
-				// Mongo paths shouldn't have the array indicators, so remove them
-				localField := strings.Replace(inclPath.Path, "[]", "", -1) + ".reference__id"
-				for i, inclTarget := range incl.Parameter.Targets {
-					if inclTarget == "Any" {
-						continue
-					}
-					from := models.PluralizeLowerResourceName(inclTarget)
-					as := fmt.Sprintf("_included%sResourcesReferencedBy%s", inclTarget, strings.Title(incl.Parameter.Name))
-					// If there are multiple paths, we need to store each path separately
-					if len(incl.Parameter.Paths) > 1 {
-						as += fmt.Sprintf("Path%d", i+1)
-					}
-
-					p = append(p, bson.M{"$lookup": bson.M{
-						"from":         from,
-						"localField":   localField,
-						"foreignField": "_id",
-						"as":           as,
-					}})
+				iteration++
+				stages, fields := includeLookupStages(incl, source.Field+".", fmt.Sprintf("Iterate%d", iteration))
+				p = append(p, stages...)
+				p = append(p, m.limitIncludedStages(fields)...)
+				next = append(next, fields...)
+			}
+			for _, incl := range o.RevInclude {
+				if !incl.Iterate {
+					continue
 				}
+				iteration++
+				stages, fields := revIncludeLookupStages(incl, source.Resource, source.Field+".", fmt.Sprintf("Iterate%d", iteration))
+				p = append(p, stages...)
+				p = append(p, m.limitIncludedStages(fields)...)
+				next = append(next, fields...)
 			}
 		}
+		included = next
+		allIncluded = append(allIncluded, next...)
 	}
 
-	// support for _revinclude
-	if len(o.RevInclude) > 0 {
-		for _, incl := range o.RevInclude {
-			// we only want parameters that have the search resource as their target
-			targetsSearchResource := false
-			for _, inclTarget := range incl.Parameter.Targets {
-				if inclTarget == resource || inclTarget == "Any" {
-					targetsSearchResource = true
-					break
-				}
+	// support for _elements and _summary, keeping the included resources
+	if projection := createProjection(resource, o); projection != nil {
+		if o.Summary != "data" {
+			for _, field := range allIncluded {
+				projection[field.Field] = 1
 			}
-			if !targetsSearchResource {
+		}
+		p = append(p, bson.M{"$project": projection})
+	}
+	return p
+}
+
+// limitIncludedStages returns the stage limiting the resources in each included field to one more
+// than the searcher's maxIncludes (so that truncation can be detected), or none if there's no limit
+func (m *MongoSearcher) limitIncludedStages(fields []includedField) []bson.M {
+	if m.maxIncludes <= 0 || len(fields) == 0 {
+		return nil
+	}
+	limited := bson.M{}
+	for _, field := range fields {
+		limited[field.Field] = bson.M{"$slice": []interface{}{"$" + field.Field, m.maxIncludes + 1}}
+	}
+	return []bson.M{{"$addFields": limited}}
+}
+
+// createProjection returns the projection needed for the _elements or _summary options,
+// or nil if the whole resource should be returned.  _elements takes precedence over _summary.
+func createProjection(resource string, o *QueryOptions) bson.M {
+	if len(o.Elements) > 0 {
+		return elementsProjection(o.Elements)
+	}
+	switch o.Summary {
+	case "true":
+		return elementsProjection(SummaryElements[resource])
+	case "text":
+		return elementsProjection(append([]string{"text"}, MandatoryElements[resource]...))
+	case "data":
+		return bson.M{"text": 0}
+	}
+	return nil
+}
+
+// elementsProjection returns a projection of the requested elements, along with the
+// elements that are always returned (id, meta and resourceType)
+func elementsProjection(elements []string) bson.M {
+	projection := bson.M{"_id": 1, "resourceType": 1, "meta": 1}
+	for _, element := range elements {
+		projection[element] = 1
+	}
+	return projection
+}
+
+// includedField is a field added to the search results by an _include or _revinclude $lookup
+type includedField struct {
+	Resource string
+	Field    string
+}
+
+// includeLookupStages returns the $lookup stages for an _include, looking up the resources referenced
+// by the documents at localPrefix ("" for the search results).  The suffix is appended to the name of
+// each included field to keep it unique.
+func includeLookupStages(incl IncludeOption, localPrefix, suffix string) (stages []bson.M, fields []includedField) {
+	for _, inclPath := range incl.Parameter.Paths {
+		if inclPath.Type != "Reference" {
+			continue
+		}
+		// Mongo paths shouldn't have the array indicators, so remove them
+		localField := localPrefix + strings.Replace(inclPath.Path, "[]", "", -1) + ".reference__id"
+		for i, inclTarget := range incl.Parameter.Targets {
+			if inclTarget == "Any" {
 				continue
 			}
-			// it comes from the other resource collection
-			from := models.PluralizeLowerResourceName(incl.Parameter.Resource)
-			// iterate through the paths, adding a join to the pipeline for each one
-			for i, inclPath := range incl.Parameter.Paths {
-				if inclPath.Type != "Reference" {
-					continue
-				}
-				// Mongo paths shouldn't have the array indicators, so remove them
-				foreignField := strings.Replace(inclPath.Path, "[]", "", -1) + ".reference__id"
-				as := fmt.Sprintf("_revIncluded%sResourcesReferencing%s", incl.Parameter.Resource, strings.Title(incl.Parameter.Name))
-				// If there are multiple paths, we need to store each path separately
-				if len(incl.Parameter.Paths) > 1 {
-					as += fmt.Sprintf("Path%d", i+1)
-				}
+			from := models.PluralizeLowerResourceName(inclTarget)
+			as := fmt.Sprintf("_included%sResourcesReferencedBy%s", inclTarget, strings.Title(incl.Parameter.Name))
+			// If there are multiple paths, we need to store each path separately
+			if len(incl.Parameter.Paths) > 1 {
+				as += fmt.Sprintf("Path%d", i+1)
+			}
+			as += suffix
 
-				p = append(p, bson.M{"$lookup": bson.M{
-					"from":         from,
-					"localField":   "_id",
-					"foreignField": foreignField,
-					"as":           as,
-				}})
+			stages = append(stages, bson.M{"$lookup": bson.M{
+				"from":         from,
+				"localField":   localField,
+				"foreignField": "_id",
+				"as":           as,
+			}})
+			fields = append(fields, includedField{Resource: inclTarget, Field: as})
+		}
+	}
+	return
+}
 
-			}
+// revIncludeLookupStages returns the $lookup stages for a _revinclude, looking up the resources
+// referencing the documents (of the given resource type) at localPrefix ("" for the search results).
+// The suffix is appended to the name of each included field to keep it unique.
+func revIncludeLookupStages(incl RevIncludeOption, resource, localPrefix, suffix string) (stages []bson.M, fields []includedField) {
+	// we only want parameters that have the resource as their target
+	targetsSearchResource := false
+	for _, inclTarget := range incl.Parameter.Targets {
+		if inclTarget == resource || inclTarget == "Any" {
+			targetsSearchResource = true
+			break
+		}
+	}
+	if !targetsSearchResource {
+		return
+	}
+	// it comes from the other resource collection
+	from := models.PluralizeLowerResourceName(incl.Parameter.Resource)
+	// iterate through the paths, adding a join to the pipeline for each one
+	for i, inclPath := range incl.Parameter.Paths {
+		if inclPath.Type != "Reference" {
+			continue
+		}
+		// Mongo paths shouldn't have the array indicators, so remove them
+		foreignField := strings.Replace(inclPath.Path, "[]", "", -1) + ".reference__id"
+		as := fmt.Sprintf("_revIncluded%sResourcesReferencing%s", incl.Parameter.Resource, strings.Title(incl.Parameter.Name))
+		// If there are multiple paths, we need to store each path separately
+		if len(incl.Parameter.Paths) > 1 {
+			as += fmt.Sprintf("Path%d", i+1)
 		}
+		as += suffix
+
+		stages = append(stages, bson.M{"$lookup": bson.M{
+			"from":         from,
+			"localField":   localPrefix + "_id",
+			"foreignField": foreignField,
+			"as":           as,
+		}})
+		fields = append(fields, includedField{Resource: incl.Parameter.Resource, Field: as})
 	}
-	return p
+	return
 }
 
 // The SearchParam argument should be either a ReferenceParam or an OrParam.
@@ -605,6 +1133,22 @@ func (m *MongoSearcher) createChainedSearchPipelineStages(searchParam SearchPara
 	// This returns stages in the pipeline that represent a chained query reference:
 	// 1. One or more $lookup stages for the foreign Resource being referenced (one for each search path)
 	// 2. A $match on that foreign Resource
+	stages, match := m.createChainedLookupStages(searchParam, "", "_lookup", 1)
+	stages = append(stages, bson.M{"$match": match})
+
+	// TODO: Add a $project stage to remove the field after the $match (need Mongo 3.4)
+	return stages
+}
+
+// createChainedLookupStages returns the $lookup stages and the $match criteria for a
+// chained search. localPrefix is the location of the document holding the reference
+// ("" for the top-level resource) and lookupKey is the base name of the looked up fields.
+// Chains deeper than one reference (e.g. "subject:Patient.organization.name=HealthCo")
+// are resolved recursively, looking up from the results of the enclosing $lookup.
+func (m *MongoSearcher) createChainedLookupStages(searchParam SearchParam, localPrefix, lookupKey string, depth int) ([]bson.M, bson.M) {
+	if depth > m.maxChainDepth {
+		panic(createUnsupportedSearchError("MSG_PARAM_CHAINED", fmt.Sprintf("Parameter \"%s\" exceeds the maximum chain depth of %d", searchParam.getInfo().Name, m.maxChainDepth)))
+	}
 
 	// Build the $lookups. We need to get a ReferenceParam (of type ChainedQueryReference)
 	// that we can use to populate the $lookup. If it's an OR, any one of its Items
@@ -616,46 +1160,69 @@ func (m *MongoSearcher) createChainedSearchPipelineStages(searchParam SearchPara
 		panic(createInternalServerError("", "ReferenceParam is not of type ChainedQueryReference"))
 	}
 
-	// We need a $lookup stage for each path, followed by one $match stage
-	stages := make([]bson.M, len(lookupRef.getInfo().Paths)+1)
+	// We need a $lookup stage for each path
+	stages := make([]bson.M, len(lookupRef.getInfo().Paths))
 	collectionName := models.PluralizeLowerResourceName(chainedRef.Type)
 
 	for i, path := range lookupRef.Paths {
 		stages[i] = bson.M{"$lookup": bson.M{
 			"from":         collectionName,
-			"localField":   convertSearchPathToMongoField(path.Path) + ".reference__id",
+			"localField":   localPrefix + convertSearchPathToMongoField(path.Path) + ".reference__id",
 			"foreignField": "_id",
-			"as":           "_lookup" + strconv.Itoa(i),
+			"as":           lookupKey + strconv.Itoa(i),
 		}}
 	}
 
 	// Build the $match. This is based on each ReferenceParam's ChainedQuery, so we'll
 	// need to get the SearchParams from those queries first.
-	var matchableParams []SearchParam
+	var params []SearchParam
 
 	if isOr {
 		// This gets a little tricky - this is an OR of ReferenceParams, not SearchParams.
 		// We need to re-define the OR as an OR of each ReferenceParam's searchable
 		// ChainedQuery.Params() results. So let's do that.
 		orParam, _ := searchParam.(*OrParam)
-		searchableOrParam := buildSearchableOrFromChainedReferenceOr(orParam)
-		matchableParams = prependLookupKeyToSearchPaths([]SearchParam{searchableOrParam}, len(lookupRef.Paths))
-
+		params = []SearchParam{buildSearchableOrFromChainedReferenceOr(orParam)}
 	} else {
-		matchableParams = prependLookupKeyToSearchPaths(chainedRef.ChainedQuery.Params(), len(lookupRef.Paths))
+		params = chainedRef.ChainedQuery.Params()
 	}
 
-	stages[len(stages)-1] = bson.M{"$match": m.createQueryObjectFromParams(matchableParams)}
+	if len(params) == 1 && usesChainedSearch(params[0]) {
+		// The chain continues, so look up the resources referenced by each of our own $lookup results
+		var ors []bson.M
+		for i := range lookupRef.Paths {
+			nestedKey := lookupKey + strconv.Itoa(i)
+			nestedStages, nestedMatch := m.createChainedLookupStages(params[0], nestedKey+".", nestedKey+"_lookup", depth+1)
+			stages = append(stages, nestedStages...)
+			ors = append(ors, nestedMatch)
+		}
+		if len(ors) == 1 {
+			return stages, ors[0]
+		}
+		return stages, bson.M{"$or": ors}
+	}
 
-	// TODO: Add a $project stage to remove the field after the $match (need Mongo 3.4)
-	return stages
+	matchableParams := prependLookupKeyToSearchPaths(params, lookupKey, len(lookupRef.Paths))
+	return stages, m.createQueryObjectFromParams(matchableParams)
 }
 
 func (m *MongoSearcher) createReverseChainedSearchPipelineStages(searchParam SearchParam) []bson.M {
 	// This returns stages in the pipeline that represent a chained query reference:
 	// 1. One or more $lookup stages for the foreign Resource being referenced (one for each search path)
 	// 2. A $match on that foreign Resource
+	stages, match := m.createReverseChainedLookupStages(searchParam, "", "_lookup")
+	stages = append(stages, bson.M{"$match": match})
+
+	// TODO: Add a $project stage to remove the field after the $match (need Mongo 3.4)
+	return stages
+}
 
+// createReverseChainedLookupStages returns the $lookup stages and the $match criteria for a
+// reverse chained search. localPrefix is the location of the documents being referenced
+// ("" for the top-level resource) and lookupKey is the base name of the looked up fields.
+// Nested _has parameters (e.g. "_has:Observation:patient:_has:AuditEvent:entity:agent=x")
+// are resolved recursively, looking up from the results of the enclosing $lookup.
+func (m *MongoSearcher) createReverseChainedLookupStages(searchParam SearchParam, localPrefix, lookupKey string) ([]bson.M, bson.M) {
 	// Build the $lookup. We need to get a ReferenceParam (of type ReverseChainedQueryReference)
 	// that we can use to populate the $lookup. If it's an OR, any one of its Items
 	// should do.
@@ -666,39 +1233,50 @@ func (m *MongoSearcher) createReverseChainedSearchPipelineStages(searchParam Sea
 		panic(createInternalServerError("", "ReferenceParam is not of type ReverseChainedQueryReference"))
 	}
 
-	// We need a $lookup stage for each path, followed by one $match stage
-	stages := make([]bson.M, len(lookupRef.getInfo().Paths)+1)
+	// We need a $lookup stage for each path
+	stages := make([]bson.M, len(lookupRef.getInfo().Paths))
 	collectionName := models.PluralizeLowerResourceName(revChainedRef.Type)
 
 	for i, path := range lookupRef.Paths {
 		stages[i] = bson.M{"$lookup": bson.M{
 			"from":         collectionName,
-			"localField":   "_id",
+			"localField":   localPrefix + "_id",
 			"foreignField": convertSearchPathToMongoField(path.Path) + ".reference__id",
-			"as":           "_lookup" + strconv.Itoa(i),
+			"as":           lookupKey + strconv.Itoa(i),
 		}}
 	}
 
 	// Build the $match. This is based on each ReferenceParam's Query, so we'll
 	// need to get the SearchParams from those queries first.
-	var matchableParams []SearchParam
+	var params []SearchParam
 
 	if isOr {
 		// This gets a little tricky - this is an OR of ReferenceParams, not SearchParams.
 		// We need to re-define the OR as an OR of each ReferenceParam's searchable
 		// Query.Params() results. So let's do that.
 		orParam, _ := searchParam.(*OrParam)
-		searchableOrParam := buildSearchableOrFromChainedReferenceOr(orParam)
-		matchableParams = prependLookupKeyToSearchPaths([]SearchParam{searchableOrParam}, len(lookupRef.Paths))
-
+		params = []SearchParam{buildSearchableOrFromChainedReferenceOr(orParam)}
 	} else {
-		matchableParams = prependLookupKeyToSearchPaths(revChainedRef.Query.Params(), len(lookupRef.Paths))
+		params = revChainedRef.Query.Params()
 	}
 
-	stages[len(stages)-1] = bson.M{"$match": m.createQueryObjectFromParams(matchableParams)}
+	if len(params) == 1 && usesReverseChainedSearch(params[0]) {
+		// A nested _has, so look up the resources referencing each of our own $lookup results
+		var ors []bson.M
+		for i := range lookupRef.Paths {
+			nestedKey := lookupKey + strconv.Itoa(i)
+			nestedStages, nestedMatch := m.createReverseChainedLookupStages(params[0], nestedKey+".", nestedKey+"_lookup")
+			stages = append(stages, nestedStages...)
+			ors = append(ors, nestedMatch)
+		}
+		if len(ors) == 1 {
+			return stages, ors[0]
+		}
+		return stages, bson.M{"$or": ors}
+	}
 
-	// TODO: Add a $project stage to remove the field after the $match (need Mongo 3.4)
-	return stages
+	matchableParams := prependLookupKeyToSearchPaths(params, lookupKey, len(lookupRef.Paths))
+	return stages, m.createQueryObjectFromParams(matchableParams)
 }
 
 // getLookupReference gets a ReferenceParam needed to do the $lookup stage for a chained
@@ -720,13 +1298,13 @@ func getLookupReference(searchParam SearchParam) (lookupRef *ReferenceParam, isO
 	return
 }
 
-// Prepends "_lookup[i]." to the search path(s), where [i] >= 0. This mutates
+// Prepends "[lookupKey][i]." to the search path(s), where [i] >= 0. This mutates
 // the SearchParams by altering the paths in their SearchParamInfos. To prevent
 // modifying the SearchParameterDictionary each SearchParamInfo is cloned before
 // being mutated.
-func prependLookupKeyToSearchPaths(searchParams []SearchParam, numReferencePaths int) []SearchParam {
+func prependLookupKeyToSearchPaths(searchParams []SearchParam, lookupKey string, numReferencePaths int) []SearchParam {
 
-	prependStr := "_lookup"
+	prependStr := lookupKey
 
 	// Make a copy first so we can safely mutate the params
 	matchParams := make([]SearchParam, len(searchParams))
@@ -819,37 +1397,142 @@ func panicOnUnsupportedFeatures(p SearchParam) {
 		panic(createUnsupportedSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", p.getInfo().Name)))
 	}
 
-	// No modifiers are supported except for resource types in reference parameters
-	_, isRef := p.(*ReferenceParam)
+	// The :missing modifier is supported on all parameter types except composites
+	if mp, isMissing := p.(*MissingParam); isMissing {
+		if mp.Type == "composite" || len(mp.Paths) == 0 {
+			panic(createUnsupportedSearchError("MSG_PARAM_MODIFIER_INVALID", fmt.Sprintf("Parameter \"%s\" modifier is invalid", mp.Name)))
+		}
+		return
+	}
+
+	// Some parameter types support specific modifiers
 	modifier := p.getInfo().Modifier
+	switch p.(type) {
+	case *StringParam:
+		if modifier == "exact" || modifier == "contains" {
+			return
+		}
+	case *TokenParam:
+		if modifier == "not" || modifier == "text" || modifier == "above" || modifier == "below" {
+			return
+		}
+	case *URIParam:
+		if modifier == "above" || modifier == "below" {
+			return
+		}
+	}
+
+	// No other modifiers are supported except for resource types in reference parameters
+	_, isRef := p.(*ReferenceParam)
 	if modifier != "" {
-		if _, ok := SearchParameterDictionary[modifier]; !isRef || !ok {
+		if _, ok := SearchParameters()[modifier]; !isRef || !ok {
 			panic(createUnsupportedSearchError("MSG_PARAM_MODIFIER_INVALID", fmt.Sprintf("Parameter \"%s\" modifier is invalid", p.getInfo().Name)))
 		}
 	}
 }
 
 func (m *MongoSearcher) createCompositeQueryObject(c *CompositeParam) bson.M {
-	panic(createUnsupportedSearchError("MSG_PARAM_UNKNOWN", fmt.Sprintf("Parameter \"%s\" not understood", c.Name)))
-}
+	if len(c.Composites) == 0 || len(c.Composites) != len(c.CompositeValues) {
+		panic(createInvalidSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", c.Name)))
+	}
 
-func (m *MongoSearcher) createDateQueryObject(d *DateParam) bson.M {
-	single := func(p SearchParamPath) bson.M {
-		switch p.Type {
-		case "date", "dateTime":
-			return buildBSON(p.Path, dateSelector(d))
-		case "instant":
-			return buildBSON(p.Path, instantSelector(d))
-		case "Period":
-			return buildBSON(p.Path, periodSelector(d))
-		case "Timing":
-			return buildBSON(p.Path+".event", dateSelector(d))
-		default:
-			return bson.M{}
+	components := make([]SearchParamInfo, len(c.Composites))
+	for i, name := range c.Composites {
+		info, ok := SearchParameters()[c.Resource][name]
+		if !ok {
+			panic(createInternalServerError("MSG_PARAM_UNKNOWN", fmt.Sprintf("Parameter \"%s\" not understood", c.Name)))
 		}
+		if c.CompositeValues[i] == "" {
+			panic(createInvalidSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", c.Name)))
+		}
+		components[i] = info
 	}
 
-	return orPaths(single, d.Paths)
+	// The components must match within the same element when they share an array
+	// (e.g. the code and value of a single Observation.component), so group the
+	// component paths by the array element containing them and use an $elemMatch.
+	var elements []string
+	for _, p := range components[0].Paths {
+		element, _ := splitCompositePath(p.Path)
+		if !contains(elements, element) {
+			elements = append(elements, element)
+		}
+	}
+
+	var results []bson.M
+	for _, element := range elements {
+		criteria := bson.M{}
+		matched := true
+		for i, info := range components {
+			info = info.clone()
+			info.Paths = info.Paths[:0]
+			for _, p := range components[i].Paths {
+				if e, relative := splitCompositePath(p.Path); e == element {
+					info.Paths = append(info.Paths, SearchParamPath{Path: relative, Type: p.Type})
+				}
+			}
+			if len(info.Paths) == 0 {
+				matched = false
+				break
+			}
+			param := info.CreateSearchParam(c.CompositeValues[i])
+			merge(criteria, m.createParamObjects([]SearchParam{param})[0])
+		}
+		if !matched {
+			continue
+		}
+		if element != "" {
+			criteria = bson.M{convertSearchPathToMongoField(element): bson.M{"$elemMatch": criteria}}
+		}
+		results = append(results, criteria)
+	}
+
+	switch len(results) {
+	case 0:
+		panic(createInternalServerError("MSG_PARAM_UNKNOWN", fmt.Sprintf("Parameter \"%s\" not understood", c.Name)))
+	case 1:
+		return results[0]
+	default:
+		return bson.M{"$or": results}
+	}
+}
+
+// splitCompositePath splits a composite component path into the array element
+// containing it and the path relative to that element, so "[]component.code"
+// becomes "[]component" and "code".  Paths that are not within an array are
+// returned with an empty element.
+func splitCompositePath(path string) (element, relative string) {
+	parts := strings.Split(path, ".")
+	for i := 0; i < len(parts)-1; i++ {
+		if strings.HasPrefix(parts[i], "[]") {
+			return strings.Join(parts[:i+1], "."), strings.Join(parts[i+1:], ".")
+		}
+	}
+	return "", path
+}
+
+func (m *MongoSearcher) createDateQueryObject(d *DateParam) bson.M {
+	single := func(p SearchParamPath) bson.M {
+		switch p.Type {
+		case "date", "dateTime":
+			return buildBSON(p.Path, dateSelector(d))
+		case "instant":
+			return buildBSON(p.Path, instantSelector(d))
+		case "Period":
+			return buildBSON(p.Path, periodSelector(d))
+		case "Timing":
+			// A timing matches on its events or on the period bounding its repeats.  Repeats bounded by
+			// a duration (boundsDuration or boundsRange) are relative to when they start, so aren't matched.
+			return bson.M{"$or": []bson.M{
+				buildBSON(p.Path+".event", dateSelector(d)),
+				buildBSON(p.Path+".repeat.boundsPeriod", periodSelector(d)),
+			}}
+		default:
+			return bson.M{}
+		}
+	}
+
+	return orPaths(single, d.Paths)
 }
 
 func dateSelector(d *DateParam) bson.M {
@@ -929,10 +1612,58 @@ func dateSelector(d *DateParam) bson.M {
 				"$lt": d.Date.RangeLowIncl(),
 			},
 		}
+	case NE:
+		// "the range of the search value does not fully contain the range of the target value"
+		return bson.M{
+			"$or": []bson.M{
+				bson.M{
+					"__from": bson.M{
+						"$lt": d.Date.RangeLowIncl(),
+					},
+				},
+				bson.M{
+					"__to": bson.M{
+						"$gt": d.Date.RangeHighExcl(),
+					},
+				},
+			},
+		}
+	case AP:
+		// "the range of the search value overlaps with the range of the target value",
+		// after widening the search range (see approximateDateRange)
+		low, high := approximateDateRange(d.Date)
+		return bson.M{
+			"__from": bson.M{
+				"$lt": high,
+			},
+			"__to": bson.M{
+				"$gt": low,
+			},
+		}
 	}
 	panic(createUnsupportedSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", d.Name)))
 }
 
+// timeNow is used for approximate date searches, and can be replaced in tests
+var timeNow = time.Now
+
+// approximateDateRange returns the range of dates matched by the ap prefix.  This is the range
+// of the search value, widened on either side by 10% of the gap between now and the date (as
+// recommended by the spec), or by 10% of the range itself if that is larger.
+func approximateDateRange(d *utils.Date) (low time.Time, high time.Time) {
+	low, high = d.RangeLowIncl(), d.RangeHighExcl()
+
+	gap := timeNow().Sub(low)
+	if gap < 0 {
+		gap = -gap
+	}
+	margin := gap / 10
+	if rangeMargin := high.Sub(low) / 10; rangeMargin > margin {
+		margin = rangeMargin
+	}
+	return low.Add(-margin), high.Add(margin)
+}
+
 func instantSelector(p *DateParam) bson.M {
 	var timestamp bson.M
 	switch p.Prefix {
@@ -961,6 +1692,19 @@ func instantSelector(p *DateParam) bson.M {
 		timestamp = bson.M{
 			"$lt": p.Date.RangeHighExcl(),
 		}
+	case NE:
+		timestamp = bson.M{
+			"$or": []bson.M{
+				bson.M{"$lt": p.Date.RangeLowIncl()},
+				bson.M{"$gte": p.Date.RangeHighExcl()},
+			},
+		}
+	case AP:
+		low, high := approximateDateRange(p.Date)
+		timestamp = bson.M{
+			"$gte": low,
+			"$lt":  high,
+		}
 	default:
 		panic(createUnsupportedSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", p.Name)))
 	}
@@ -1072,6 +1816,65 @@ func periodSelector(d *DateParam) bson.M {
 				"$lt": d.Date.RangeLowIncl(),
 			},
 		}
+	case NE:
+		// "the range of the search value does not fully contain the range of the target value"
+		return bson.M{
+			"$or": []bson.M{
+				bson.M{
+					"start.__from": bson.M{
+						"$lt": d.Date.RangeLowIncl(),
+					},
+				},
+				bson.M{
+					"end.__to": bson.M{
+						"$gt": d.Date.RangeHighExcl(),
+					},
+				},
+				// Periods with no start or end (i.e. ongoing) are never fully contained
+				bson.M{
+					"$ne":   nil,
+					"start": nil,
+				},
+				bson.M{
+					"$ne": nil,
+					"end": nil,
+				},
+			},
+		}
+	case AP:
+		// "the range of the search value overlaps with the range of the target value",
+		// after widening the search range (see approximateDateRange)
+		low, high := approximateDateRange(d.Date)
+		return bson.M{
+			"$and": []bson.M{
+				bson.M{
+					"$or": []bson.M{
+						bson.M{
+							"start.__from": bson.M{
+								"$lt": high,
+							},
+						},
+						bson.M{
+							"$ne":   nil,
+							"start": nil,
+						},
+					},
+				},
+				bson.M{
+					"$or": []bson.M{
+						bson.M{
+							"end.__to": bson.M{
+								"$gt": low,
+							},
+						},
+						bson.M{
+							"$ne": nil,
+							"end": nil,
+						},
+					},
+				},
+			},
+		}
 	}
 	panic(createUnsupportedSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", d.Name)))
 }
@@ -1085,8 +1888,7 @@ func (m *MongoSearcher) createNumberQueryObject(n *NumberParam) bson.M {
 		var criteria bson.M
 
 		if p.Type == "decimal" {
-			// TODO
-			panic(createUnsupportedSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" (decimal type) is not yet supported", n.Name)))
+			return buildBSON(p.Path, decimalSelector(n))
 		}
 
 		switch n.Prefix {
@@ -1120,6 +1922,14 @@ func (m *MongoSearcher) createNumberQueryObject(n *NumberParam) bson.M {
 			criteria = bson.M{
 				"$lte": h,
 			}
+		case AP:
+			// Approximately, i.e. in the range [al, ah) (see utils.Number.ApproxRangeLowIncl)
+			al, _ := n.Number.ApproxRangeLowIncl().Float64()
+			ah, _ := n.Number.ApproxRangeHighExcl().Float64()
+			criteria = bson.M{
+				"$gte": al,
+				"$lt":  ah,
+			}
 		default:
 			// SA, EB are not supported for Number queries
 			panic(createUnsupportedSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", n.Name)))
@@ -1130,6 +1940,70 @@ func (m *MongoSearcher) createNumberQueryObject(n *NumberParam) bson.M {
 	return orPaths(single, n.Paths)
 }
 
+// Decimals are stored as a { __from, __to, __num, __strNum } range based on their
+// precision, so they are matched like dates by comparing the ranges.
+func decimalSelector(n *NumberParam) bson.M {
+	decimal128 := func(d primitive.Decimal128, err error) primitive.Decimal128 {
+		if err != nil {
+			panic(createInvalidSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", n.Name)))
+		}
+		return d
+	}
+	l := decimal128(n.Number.RangeLowInclDecimal128())
+	h := decimal128(n.Number.RangeHighExclDecimal128())
+	exact := decimal128(n.Number.ValueDecimal128())
+
+	switch n.Prefix {
+	case EQ:
+		// "the range of the search value fully contains the range of the target value"
+		return bson.M{
+			"__from": bson.M{"$gte": l},
+			"__to":   bson.M{"$lte": h},
+		}
+	case NE:
+		// "the range of the search value does not fully contain the range of the target value"
+		return bson.M{
+			"$or": []bson.M{
+				bson.M{"__from": bson.M{"$lt": l}},
+				bson.M{"__to": bson.M{"$gt": h}},
+			},
+		}
+	case GT:
+		// "the range above the search value intersects (i.e. overlaps) with the range of the target value"
+		return bson.M{
+			"__to": bson.M{"$gt": exact},
+		}
+	case LT:
+		// "the range below the search value intersects (i.e. overlaps) with the range of the target value"
+		return bson.M{
+			"__from": bson.M{"$lt": exact},
+		}
+	case GE:
+		return bson.M{
+			"$or": []bson.M{
+				bson.M{"__to": bson.M{"$gte": h}},
+				bson.M{"__from": bson.M{"$gte": l}},
+			},
+		}
+	case LE:
+		return bson.M{
+			"$or": []bson.M{
+				bson.M{"__from": bson.M{"$lte": l}},
+				bson.M{"__to": bson.M{"$lte": h}},
+			},
+		}
+	case AP:
+		// "the range of the search value overlaps with the range of the target value",
+		// after widening the search range (see utils.Number.ApproxRangeLowIncl)
+		return bson.M{
+			"__from": bson.M{"$lt": decimal128(n.Number.ApproxRangeHighExclDecimal128())},
+			"__to":   bson.M{"$gt": decimal128(n.Number.ApproxRangeLowInclDecimal128())},
+		}
+	}
+	// SA, EB are not supported for Number queries
+	panic(createUnsupportedSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", n.Name)))
+}
+
 func (m *MongoSearcher) createQuantityQueryObject(q *QuantityParam) bson.M {
 	single := func(p SearchParamPath) bson.M {
 		l, _ := q.Number.RangeLowIncl().Float64()
@@ -1191,36 +2065,40 @@ func (m *MongoSearcher) createQuantityQueryObject(q *QuantityParam) bson.M {
 					},
 				},
 			}
+		case AP:
+			// "the range of the search value overlaps with the range of the target value",
+			// after widening the search range (see utils.Number.ApproxRangeLowIncl)
+			al, _ := q.Number.ApproxRangeLowIncl().Float64()
+			ah, _ := q.Number.ApproxRangeHighExcl().Float64()
+			criteria = bson.M{
+				"value.__from": bson.M{"$lt": ah},
+				"value.__to":   bson.M{"$gt": al},
+			}
 		default:
 			// NE, SA, EB are not supported for Quantity queries
 			panic(createUnsupportedSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", q.Name)))
 		}
 
-		if q.System == "" {
-
-			// FIXME: need to search by both the 'units' and 'code' field...............
-			// (http://build.fhir.org/search.html#quantity)
-			// however query with $and is not working since the $and seems to need to be at the
-			// very top of the mongodb query
-			panic(createUnsupportedSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\": search by quantity with a code system not yet supported", q.Name)))
-
-			// orClause := []bson.M{
-			// 	bson.M{"code": m.ci(q.Code)},
-			// 	bson.M{"unit": m.ci(q.Code)},
-			// }
-
-			// _, haveExistingOr := criteria["$or"]
-			// if haveExistingOr {
-			// 	criteria = bson.M{
-			// 		"$and": []bson.M{ criteria, bson.M { "$or": orClause } },
-			// 	}
-			// } else {
-			// 	criteria["$or"] = orClause
-			// }
-
-		} else {
+		if q.System != "" {
 			criteria["code"] = m.ciToken(q.Code)
 			criteria["system"] = m.ciToken(q.System)
+		} else if q.Code != "" {
+			// Without a system the code may match either the 'code' or the 'unit'
+			// (http://hl7.org/fhir/STU3/search.html#quantity)
+			unitOrCode := []bson.M{
+				bson.M{"code": m.ciToken(q.Code)},
+				bson.M{"unit": m.ci(q.Code)},
+			}
+			if valueOr, haveExistingOr := criteria["$or"]; haveExistingOr {
+				// Both $or clauses must hold, so combine them with an $and
+				delete(criteria, "$or")
+				criteria["$and"] = []bson.M{
+					bson.M{"$or": valueOr},
+					bson.M{"$or": unitOrCode},
+				}
+			} else {
+				criteria["$or"] = unitOrCode
+			}
 		}
 		return buildBSON(p.Path, criteria)
 	}
@@ -1277,25 +2155,48 @@ func (m *MongoSearcher) createInlinedReferenceQueryObject(r *ReferenceParam, p S
 }
 
 func (m *MongoSearcher) createStringQueryObject(s *StringParam) bson.M {
+	// By default the parts of a HumanName or Address match if they start with the
+	// string, whereas other strings need to match fully (both case-insensitive)
+	match := func(startsWith bool) interface{} {
+		switch s.Modifier {
+		case "exact":
+			return s.String
+		case "contains":
+			return m.cicontains(s.String)
+		}
+		if startsWith {
+			return m.cisw(s.String)
+		}
+		return m.ci(s.String)
+	}
+	return m.createStringMatchObject(s, match)
+}
+
+// createStringMatchObject builds the query object of a string parameter, matching each string (or part of a HumanName
+// or Address) with the criteria returned by match, which is told whether the string matches by default if it starts
+// with the parameter's value
+func (m *MongoSearcher) createStringMatchObject(s *StringParam, match func(startsWith bool) interface{}) bson.M {
 	single := func(p SearchParamPath) bson.M {
 		switch p.Type {
 		case "HumanName":
+			criteria := match(true)
 			return buildBSON(p.Path, bson.M{
 				"$or": []bson.M{
-					bson.M{"text": m.cisw(s.String)},
-					bson.M{"family": m.cisw(s.String)},
-					bson.M{"given": m.cisw(s.String)},
+					bson.M{"text": criteria},
+					bson.M{"family": criteria},
+					bson.M{"given": criteria},
 				},
 			})
 		case "Address":
+			criteria := match(true)
 			return buildBSON(p.Path, bson.M{
 				"$or": []bson.M{
-					bson.M{"text": m.cisw(s.String)},
-					bson.M{"line": m.cisw(s.String)},
-					bson.M{"city": m.cisw(s.String)},
-					bson.M{"state": m.cisw(s.String)},
-					bson.M{"postalCode": m.cisw(s.String)},
-					bson.M{"country": m.cisw(s.String)},
+					bson.M{"text": criteria},
+					bson.M{"line": criteria},
+					bson.M{"city": criteria},
+					bson.M{"state": criteria},
+					bson.M{"postalCode": criteria},
+					bson.M{"country": criteria},
 				},
 			})
 		default:
@@ -1303,7 +2204,7 @@ func (m *MongoSearcher) createStringQueryObject(s *StringParam) bson.M {
 				return buildBSON(p.Path, s.String)
 			}
 
-			return buildBSON(p.Path, m.ci(s.String))
+			return buildBSON(p.Path, match(false))
 		}
 	}
 
@@ -1311,6 +2212,21 @@ func (m *MongoSearcher) createStringQueryObject(s *StringParam) bson.M {
 }
 
 func (m *MongoSearcher) createTokenQueryObject(t *TokenParam) bson.M {
+	if t.Modifier == "not" {
+		// [parameter]:not=[code] matches resources without a matching value (including
+		// those without the element at all), so for arrays no element may match
+		positive := *t
+		positive.Modifier = ""
+		return bson.M{"$nor": []bson.M{m.createTokenQueryObject(&positive)}}
+	}
+	if t.Modifier == "text" {
+		return m.createTokenTextQueryObject(t)
+	}
+	subsumption := t.Modifier == "above" || t.Modifier == "below"
+	if subsumption && (t.System == "" || t.Code == "") {
+		// The system is needed to find the CodeSystem defining the hierarchy
+		panic(createInvalidSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", t.Name)))
+	}
 
 	var systemCriteria interface{}
 	var codeCriteria interface{}
@@ -1330,6 +2246,10 @@ func (m *MongoSearcher) createTokenQueryObject(t *TokenParam) bson.M {
 		// [parameter]=[system]|[code]
 		codeCriteria = m.ciToken(t.Code)
 		systemCriteria = m.ciToken(t.System)
+		if subsumption {
+			// [parameter]:below=[system]|[code] also matches the code's descendants (and :above its ancestors)
+			codeCriteria = m.subsumedCodesCriteria(t)
+		}
 	}
 
 	single := func(p SearchParamPath) bson.M {
@@ -1378,6 +2298,9 @@ func (m *MongoSearcher) createTokenQueryObject(t *TokenParam) bson.M {
 		case "string":
 			return buildBSON(p.Path, m.ci(t.Code))
 		case "code":
+			if codeCriteria != nil {
+				return buildBSON(p.Path, codeCriteria)
+			}
 			return buildBSON(p.Path, m.ciToken(t.Code))
 		case "id":
 			// IDs do not need the case-insensitive match.
@@ -1390,14 +2313,406 @@ func (m *MongoSearcher) createTokenQueryObject(t *TokenParam) bson.M {
 	return orPaths(single, t.Paths)
 }
 
+// createTokenTextQueryObject handles [parameter]:text=[string], which matches the
+// text or display associated with a token rather than its code.
+func (m *MongoSearcher) createTokenTextQueryObject(t *TokenParam) bson.M {
+	text := m.cisw(t.Code)
+	textCriteria := func(p SearchParamPath) bson.M {
+		switch p.Type {
+		case "CodeableConcept":
+			return bson.M{
+				"$or": []bson.M{
+					bson.M{"text": text},
+					bson.M{"coding.display": text},
+				},
+			}
+		case "Coding":
+			return bson.M{"display": text}
+		case "Identifier":
+			return bson.M{"type.text": text}
+		}
+		return nil
+	}
+
+	// Only some token types have an associated text
+	var paths []SearchParamPath
+	for _, p := range t.Paths {
+		if textCriteria(p) != nil {
+			paths = append(paths, p)
+		}
+	}
+	if len(paths) == 0 {
+		panic(createUnsupportedSearchError("MSG_PARAM_MODIFIER_INVALID", fmt.Sprintf("Parameter \"%s\" modifier is invalid", t.Name)))
+	}
+
+	single := func(p SearchParamPath) bson.M {
+		return buildBSON(p.Path, textCriteria(p))
+	}
+
+	return orPaths(single, paths)
+}
+
+// subsumedCodesCriteria matches the token's code along with the codes it subsumes (:below)
+// or is subsumed by (:above).
+func (m *MongoSearcher) subsumedCodesCriteria(t *TokenParam) interface{} {
+	codes := m.subsumedCodes(t.System, t.Code, t.Modifier == "below")
+	if len(codes) == 1 {
+		return m.ciToken(codes[0])
+	}
+	criteria := make([]interface{}, len(codes))
+	for i, code := range codes {
+		criteria[i] = m.ciToken(code)
+	}
+	return bson.M{"$in": criteria}
+}
+
+// codeSystemConcept is a concept in a CodeSystem's (possibly nested) concept hierarchy
+type codeSystemConcept struct {
+	Code    string              `bson:"code"`
+	Concept []codeSystemConcept `bson:"concept"`
+}
+
+// subsumedCodes walks the concept hierarchy of the CodeSystem stored on the server for the
+// given system, returning the code along with all of its descendants (or its ancestors when
+// below is false).  Only hierarchies expressed by nesting concepts are followed.  If the
+// server doesn't have the CodeSystem, or it doesn't define the code, just the code is returned.
+func (m *MongoSearcher) subsumedCodes(system, code string, below bool) []string {
+	var codeSystem struct {
+		Concept []codeSystemConcept `bson:"concept"`
+	}
+	filter := bson.M{"url": system}
+	projection := moptions.FindOne().SetProjection(bson.M{"concept": 1})
+	err := m.db.Collection("codesystems").FindOne(m.ctx, filter, projection).Decode(&codeSystem)
+	if err == mongo.ErrNoDocuments {
+		return []string{code}
+	} else if err != nil {
+		panic(createInternalServerError("", fmt.Sprintf("Failed to load the CodeSystem %s: %s", system, err)))
+	}
+
+	var ancestors []string
+	var found *codeSystemConcept
+	var walk func(concepts []codeSystemConcept, path []string) bool
+	walk = func(concepts []codeSystemConcept, path []string) bool {
+		for i := range concepts {
+			if concepts[i].Code == code {
+				found = &concepts[i]
+				ancestors = path
+				return true
+			}
+			if walk(concepts[i].Concept, append(path, concepts[i].Code)) {
+				return true
+			}
+		}
+		return false
+	}
+	if !walk(codeSystem.Concept, nil) {
+		return []string{code}
+	}
+
+	codes := []string{code}
+	if !below {
+		return append(codes, ancestors...)
+	}
+	var addDescendants func(concepts []codeSystemConcept)
+	addDescendants = func(concepts []codeSystemConcept) {
+		for _, concept := range concepts {
+			codes = append(codes, concept.Code)
+			addDescendants(concept.Concept)
+		}
+	}
+	addDescendants(found.Concept)
+	return codes
+}
+
 func (m *MongoSearcher) createURIQueryObject(u *URIParam) bson.M {
+	var criteria interface{}
+	switch u.Modifier {
+	case "below":
+		// [parameter]:below=[uri] matches URIs starting with the value
+		criteria = primitive.Regex{Pattern: fmt.Sprintf("^%s", regexp.QuoteMeta(u.URI))}
+	case "above":
+		// [parameter]:above=[uri] matches URIs that are the value or one of its ancestors
+		criteria = bson.M{"$in": uriAncestors(u.URI)}
+	default:
+		criteria = u.URI
+	}
+
 	single := func(p SearchParamPath) bson.M {
-		return buildBSON(p.Path, u.URI)
+		return buildBSON(p.Path, criteria)
 	}
 
 	return orPaths(single, u.Paths)
 }
 
+func (m *MongoSearcher) createFullTextQueryObject(f *FullTextParam) bson.M {
+	search := bson.M{"$text": bson.M{"$search": strings.Join(f.Values, " ")}}
+	if len(f.Paths) == 0 {
+		// _content searches the whole resource
+		return search
+	}
+
+	// The text index covers the whole resource, so _text also checks that
+	// one of the words is in the narrative
+	var words []string
+	for _, value := range f.Values {
+		for _, word := range strings.Fields(value) {
+			words = append(words, regexp.QuoteMeta(word))
+		}
+	}
+	narrative := primitive.Regex{Pattern: strings.Join(words, "|"), Options: "i"}
+
+	single := func(p SearchParamPath) bson.M {
+		return buildBSON(p.Path, narrative)
+	}
+
+	criteria := orPaths(single, f.Paths)
+	criteria["$text"] = search["$text"]
+	return criteria
+}
+
+func (m *MongoSearcher) createNamedQueryObject(n *NamedQueryParam) bson.M {
+	builder, err := GlobalMongoRegistry().LookupNamedQueryBuilder(n.Query.Name)
+	if err != nil {
+		panic(createInternalServerError("MSG_PARAM_UNKNOWN", fmt.Sprintf("Parameter \"%s\" not understood", QueryParam)))
+	}
+	if builder.BSON == nil {
+		return bson.M{}
+	}
+	object, err := builder.BSON(n, m)
+	if err != nil {
+		panic(createInvalidSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", QueryParam)))
+	}
+	return object
+}
+
+func (m *MongoSearcher) createNamedQueryPipelineStages(query Query) []bson.M {
+	for _, p := range query.Params() {
+		n, ok := p.(*NamedQueryParam)
+		if !ok {
+			continue
+		}
+		builder, err := GlobalMongoRegistry().LookupNamedQueryBuilder(n.Query.Name)
+		if err != nil || builder.Pipeline == nil {
+			return nil
+		}
+		stages, err := builder.Pipeline(n, m)
+		if err != nil {
+			panic(createInvalidSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", QueryParam)))
+		}
+		return stages
+	}
+	return nil
+}
+
+// createRegisteredPipelineStages returns the stages of the pipeline stage builders registered with the
+// GlobalMongoRegistry, in the order of their names
+func (m *MongoSearcher) createRegisteredPipelineStages(query Query) []bson.M {
+	registry := GlobalMongoRegistry()
+	var stages []bson.M
+	for _, name := range registry.PipelineStageBuilderNames() {
+		builder, err := registry.LookupPipelineStageBuilder(name)
+		if err != nil {
+			// Unregistered since its name was listed
+			continue
+		}
+		builderStages, err := builder(query, m)
+		if err != nil {
+			if searchErr, ok := err.(*Error); ok {
+				panic(searchErr)
+			}
+			panic(createInternalServerError("", fmt.Sprintf("Pipeline stage builder %s failed: %s", name, err)))
+		}
+		stages = append(stages, builderStages...)
+	}
+	return stages
+}
+
+// createListQueryObject matches the resources referenced by the current entries
+// of the lists (i.e. those that aren't marked as deleted)
+func (m *MongoSearcher) createListQueryObject(l *ListQueryParam) bson.M {
+	filter := bson.M{"_id": bson.M{"$in": l.ListIDs}}
+	projection := moptions.Find().SetProjection(bson.M{"entry.deleted": 1, "entry.item": 1})
+	cursor, err := m.db.Collection("lists").Find(m.ctx, filter, projection)
+	if err != nil {
+		panic(createInternalServerError("", fmt.Sprintf("Failed to load the lists for %s: %s", ListParam, err)))
+	}
+	defer cursor.Close(m.ctx)
+
+	ids := []string{}
+	for cursor.Next(m.ctx) {
+		var list struct {
+			Entry []struct {
+				Deleted bool `bson:"deleted"`
+				Item    struct {
+					ID   string `bson:"reference__id"`
+					Type string `bson:"reference__type"`
+				} `bson:"item"`
+			} `bson:"entry"`
+		}
+		if err := cursor.Decode(&list); err != nil {
+			panic(createInternalServerError("", fmt.Sprintf("Failed to load the lists for %s: %s", ListParam, err)))
+		}
+		for _, entry := range list.Entry {
+			if !entry.Deleted && entry.Item.Type == l.Resource && entry.Item.ID != "" {
+				ids = append(ids, entry.Item.ID)
+			}
+		}
+	}
+	return bson.M{"_id": bson.M{"$in": ids}}
+}
+
+// earthRadiusKm is the (equatorial) radius MongoDB uses for spherical geometry
+const earthRadiusKm = 6378.1
+
+func (m *MongoSearcher) createNearQueryObject(n *NearParam) bson.M {
+	// $centerSphere takes its radius in radians
+	criteria := bson.M{
+		"$geoWithin": bson.M{
+			"$centerSphere": []interface{}{
+				[]float64{n.Longitude, n.Latitude},
+				n.Distance / earthRadiusKm,
+			},
+		},
+	}
+
+	single := func(p SearchParamPath) bson.M {
+		return buildBSON(p.Path, criteria)
+	}
+
+	return orPaths(single, n.Paths)
+}
+
+// orderByDistance rewrites the first near criteria in a find filter as $nearSphere, so that
+// unsorted results are returned closest first.  $nearSphere can't be used when counting, in
+// aggregation pipelines or within an $or, so the criteria are otherwise left as $geoWithin.
+func orderByDistance(filter bson.M) bson.M {
+	for key, value := range filter {
+		if key == "$and" {
+			if ands, ok := value.([]bson.M); ok {
+				newAnds := make([]bson.M, len(ands))
+				copy(newAnds, ands)
+				for i := range newAnds {
+					if newAnd, ok := nearSphereCriteria(newAnds[i]); ok {
+						newAnds[i] = newAnd
+						return replaceKey(filter, key, newAnds)
+					}
+				}
+			}
+			continue
+		}
+		if newCriteria, ok := nearSphereCriteria(bson.M{key: value}); ok {
+			return replaceKey(filter, key, newCriteria[key])
+		}
+	}
+	return filter
+}
+
+// nearSphereCriteria converts {field: {$geoWithin: {$centerSphere: [point, radius]}}} to the
+// equivalent $nearSphere criteria.
+func nearSphereCriteria(criteria bson.M) (bson.M, bool) {
+	if len(criteria) != 1 {
+		return nil, false
+	}
+	for field, value := range criteria {
+		within, ok := value.(bson.M)
+		if !ok || len(within) != 1 {
+			return nil, false
+		}
+		geoWithin, ok := within["$geoWithin"].(bson.M)
+		if !ok {
+			return nil, false
+		}
+		sphere, ok := geoWithin["$centerSphere"].([]interface{})
+		if !ok || len(sphere) != 2 {
+			return nil, false
+		}
+		return bson.M{
+			field: bson.M{
+				"$nearSphere": bson.M{
+					"$geometry":    bson.M{"type": "Point", "coordinates": sphere[0]},
+					"$maxDistance": sphere[1].(float64) * earthRadiusKm * 1000,
+				},
+			},
+		}, true
+	}
+	return nil, false
+}
+
+func replaceKey(m bson.M, key string, value interface{}) bson.M {
+	replaced := make(bson.M, len(m))
+	for k, v := range m {
+		replaced[k] = v
+	}
+	replaced[key] = value
+	return replaced
+}
+
+// uriAncestors returns the URI and each of its parent paths, both with and without
+// a trailing slash, so "http://acme.org/fhir/ValueSet" yields "http://acme.org/fhir/ValueSet",
+// "http://acme.org/fhir/ValueSet/", "http://acme.org/fhir", "http://acme.org/fhir/", etc.
+func uriAncestors(uri string) []string {
+	// Don't split up the scheme and authority (e.g. "http://acme.org")
+	authorityEnd := 0
+	if i := strings.Index(uri, "://"); i != -1 {
+		authorityEnd = i + len("://")
+	}
+	if i := strings.Index(uri[authorityEnd:], "/"); i != -1 {
+		authorityEnd += i
+	} else {
+		authorityEnd = len(uri)
+	}
+
+	var ancestors []string
+	current := strings.TrimSuffix(uri, "/")
+	for {
+		ancestors = append(ancestors, current, current+"/")
+		i := strings.LastIndex(current, "/")
+		if len(current) <= authorityEnd || i < authorityEnd {
+			break
+		}
+		current = current[:i]
+	}
+	return ancestors
+}
+
+func (m *MongoSearcher) createMissingQueryObject(mp *MissingParam) bson.M {
+	if mp.Missing {
+		// The element must be absent (or null) on every path
+		result := bson.M{}
+		var arrayPaths []bson.M
+		for _, p := range mp.Paths {
+			if array, element := splitMissingPath(p.Path); array != "" {
+				arrayPaths = append(arrayPaths, bson.M{array: bson.M{"$not": bson.M{"$elemMatch": bson.M{element: bson.M{"$exists": true}}}}})
+			} else {
+				result[convertSearchPathToMongoField(p.Path)] = nil
+			}
+		}
+		if len(arrayPaths) > 0 {
+			result["$and"] = arrayPaths
+		}
+		return result
+	}
+
+	// The element must be present on at least one of the paths
+	return orPaths(func(p SearchParamPath) bson.M {
+		if array, element := splitMissingPath(p.Path); array != "" {
+			return bson.M{array: bson.M{"$elemMatch": bson.M{element: bson.M{"$exists": true}}}}
+		}
+		return bson.M{convertSearchPathToMongoField(p.Path): bson.M{"$ne": nil}}
+	}, mp.Paths)
+}
+
+// splitMissingPath splits a path at its first array (e.g. "[]name.[]given" into "name" and "given"), as an element
+// within an array is only missing if every item of the array lacks it, whereas {"name.given": null} matches as soon as
+// one of them does.  The array is empty if there's no array above the element.
+func splitMissingPath(path string) (array, element string) {
+	m := regexp.MustCompile(`^(.*?\[\][^\.]*)\.(.+)$`).FindStringSubmatch(convertBracketIndexesToDotIndexes(path))
+	if m == nil {
+		return "", ""
+	}
+	return convertSearchPathToMongoField(m[1]), convertSearchPathToMongoField(m[2])
+}
+
 func (m *MongoSearcher) createOrQueryObject(o *OrParam) bson.M {
 	return bson.M{
 		"$or": m.createParamObjects(o.Items),
@@ -1504,28 +2819,88 @@ func convertBracketIndexesToDotIndexes(path string) string {
 	return re.ReplaceAllString(path, "$2.$1")
 }
 
-// MongoDB does not properly sort when keys are in parallel arrays ("Executor error: BadValue cannot sort with keys
-// that are parallel arrays"), so... remove any sort options that have parallel arrays (and log it)
-func removeParallelArraySorts(o *QueryOptions) {
-	npSorts := make([]SortOption, 0, len(o.Sort))
-	for i := range o.Sort {
-		sort := o.Sort[i]
-		isParallel := false
-		for _, npSort := range npSorts {
-			isParallel = isParallelArrayPath(sort.Parameter.Paths[0].Path, npSort.Parameter.Paths[0].Path)
-			if isParallel {
-				fmt.Printf("Cannot sub-sort on param '%s' because its path has parallel arrays with previous sort param '%s' (due to limitation in MongoDB)\n.", sort.Parameter.Name, npSort.Parameter.Name)
-				break
-			}
+// createSortKeyExpression returns an aggregation expression for a sort's key, evaluating to the
+// value of the first of the parameter's paths present in a resource.  Dates are compared using
+// the start of their range, so that e.g. dateTime and Period values sort together.  Like MongoDB's
+// own sorts, arrays are sorted on their smallest element (or largest, for descending sorts), but
+// the key itself is never an array, so it can't be parallel to the arrays of other sorts.
+func createSortKeyExpression(sort SortOption) interface{} {
+	param := sort.Parameter
+	var key interface{}
+	for i := len(param.Paths) - 1; i >= 0; i-- {
+		value := sortKeyValue(param.Type, param.Paths[i], sort.Descending)
+		if key == nil {
+			key = value
+		} else {
+			key = bson.M{"$ifNull": []interface{}{value, key}}
 		}
-		if !isParallel {
-			npSorts = append(npSorts, sort)
+	}
+	return key
+}
+
+func sortKeyValue(paramType string, path SearchParamPath, descending bool) interface{} {
+	var value interface{} = "$" + sortKeyField(paramType, path)
+	arrays := strings.Count(path.Path, "[]")
+	if paramType == "date" && path.Type == "Timing" {
+		arrays++ // Timing.event
+	}
+	if arrays == 0 {
+		return value
+	}
+
+	// Nested arrays (e.g. name.given) are flattened into a single array
+	for ; arrays > 1; arrays-- {
+		value = bson.M{"$reduce": bson.M{
+			"input":        value,
+			"initialValue": []interface{}{},
+			"in":           bson.M{"$concatArrays": []interface{}{"$$value", "$$this"}},
+		}}
+	}
+	if descending {
+		return bson.M{"$max": value}
+	}
+	return bson.M{"$min": value}
+}
+
+func sortKeyField(paramType string, path SearchParamPath) string {
+	field := convertSearchPathToMongoField(path.Path)
+	if paramType != "date" {
+		return field
+	}
+	switch path.Type {
+	case "Period":
+		return field + ".start.__from"
+	case "Timing":
+		return field + ".event.__from"
+	default:
+		return field + ".__from"
+	}
+}
+
+// usesComputedSort checks if any of the sorts need a computed sort key, which is only
+// supported by the aggregation pipeline.
+func (o *QueryOptions) usesComputedSort() bool {
+	for i := range o.Sort {
+		if needsSortKey(o.Sort, i) {
+			return true
 		}
 	}
-	// If we ended up removing sort options, update the Options object to reflect that
-	if len(o.Sort) != len(npSorts) {
-		o.Sort = npSorts
+	return false
+}
+
+// needsSortKey checks if the i'th sort needs a computed sort key, either because its parameter has
+// multiple paths or because its path has an array parallel to one in a previous sort, which MongoDB
+// can't sort on (see https://docs.mongodb.com/manual/core/index-multikey/#compound-multikey-indexes).
+func needsSortKey(sorts []SortOption, i int) bool {
+	if len(sorts[i].Parameter.Paths) > 1 {
+		return true
+	}
+	for j := 0; j < i; j++ {
+		if !needsSortKey(sorts, j) && isParallelArrayPath(sorts[i].Parameter.Paths[0].Path, sorts[j].Parameter.Paths[0].Path) {
+			return true
+		}
 	}
+	return false
 }
 
 func isParallelArrayPath(path1 string, path2 string) bool {
@@ -1558,6 +2933,8 @@ func processQueryOperatorCriteria(path string, key string, value interface{}, re
 	switch key {
 	case "$or":
 		processOrCriteria(path, value, result)
+	case "$and":
+		processAndCriteria(path, value, result)
 	default:
 		criteria, ok := result[path]
 		if !ok {
@@ -1580,9 +2957,26 @@ func processOrCriteria(path string, orValue interface{}, result bson.M) {
 	}
 }
 
+func processAndCriteria(path string, andValue interface{}, result bson.M) {
+	if ands, ok := andValue.([]bson.M); ok {
+		newAnds := make([]bson.M, len(ands))
+		for i := range ands {
+			newAnds[i] = buildBSON(path, ands[i])
+		}
+		result["$and"] = newAnds
+	} else {
+		panic(createInternalServerError("", ""))
+	}
+}
+
 // Case-insensitive match
-// TODO: consider case-insensitive indexes in MongoDB 3.4 (https://docs.mongodb.com/manual/core/index-case-insensitive/)
 func (m *MongoSearcher) ci(s string) interface{} {
+	if m.usesLowercaseFields() {
+		return lowercaseExactMatch(s)
+	}
+	if m.enableCISearches && m.collationMatchesCaseInsensitively() {
+		return s
+	}
 	if m.enableCISearches {
 		return primitive.Regex{Pattern: fmt.Sprintf("^%s$", regexp.QuoteMeta(s)), Options: "i"}
 	}
@@ -1595,6 +2989,12 @@ func (m *MongoSearcher) ciToken(s string) interface{} {
 	// R4 leans towards case-sensitive, whereas STU3 text suggests case-insensitive
 	// https://github.com/HL7/fhir/commit/13fb1c1f102caf7de7266d6e78ab261efac06a1f
 
+	if !m.tokenParametersCaseSensitive && m.usesLowercaseFields() {
+		return lowercaseExactMatch(s)
+	}
+	if !m.tokenParametersCaseSensitive && m.enableCISearches && m.collationMatchesCaseInsensitively() {
+		return s
+	}
 	if !m.tokenParametersCaseSensitive && m.enableCISearches {
 		return primitive.Regex{Pattern: fmt.Sprintf("^%s$", regexp.QuoteMeta(s)), Options: "i"}
 	}
@@ -1602,14 +3002,27 @@ func (m *MongoSearcher) ciToken(s string) interface{} {
 }
 
 // Case-insensitive starts-with
-// TODO: consider case-insensitive indexes in MongoDB 3.4 (https://docs.mongodb.com/manual/core/index-case-insensitive/)
 func (m *MongoSearcher) cisw(s string) interface{} {
+	if m.usesLowercaseFields() {
+		return lowercaseRegexMatch("^%s", s)
+	}
 	if m.enableCISearches {
 		return primitive.Regex{Pattern: fmt.Sprintf("^%s", regexp.QuoteMeta(s)), Options: "i"}
 	}
 	return s
 }
 
+// Case-insensitive contains
+func (m *MongoSearcher) cicontains(s string) interface{} {
+	if m.usesLowercaseFields() {
+		return lowercaseRegexMatch("%s", s)
+	}
+	if m.enableCISearches {
+		return primitive.Regex{Pattern: regexp.QuoteMeta(s), Options: "i"}
+	}
+	return primitive.Regex{Pattern: regexp.QuoteMeta(s)}
+}
+
 // When multiple paths are present, they should be represented as an OR.
 // objFunc is a function that generates a single query for a path
 func orPaths(objFunc func(SearchParamPath) bson.M, paths []SearchParamPath) bson.M {
@@ -1,12 +1,14 @@
 package search
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/url"
 	"os"
 	"reflect"
 	"sort"
@@ -15,9 +17,12 @@ import (
 	"time"
 
 	"github.com/eug48/fhir/models"
+	"github.com/eug48/fhir/models2"
 	"github.com/pebbe/util"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	moptions "go.mongodb.org/mongo-driver/mongo/options"
 	. "gopkg.in/check.v1"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/dbtest"
@@ -139,6 +144,154 @@ func (m *MongoSearchSuite) TestConditionCodeQueryByWrongCodeSystem(c *C) {
 	c.Assert(len(results), Equals, 0)
 }
 
+func (m *MongoSearchSuite) TestConditionCodeNotQueryObject(c *C) {
+	q := Query{"Condition", "code:not=http://snomed.info/sct|123641001"}
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"$nor": []bson.M{
+			bson.M{
+				"code.coding": bson.M{
+					"$elemMatch": bson.M{
+						"system": primitive.Regex{Pattern: "^http://snomed\\.info/sct$", Options: "i"},
+						"code":   primitive.Regex{Pattern: "^123641001$", Options: "i"},
+					},
+				},
+			},
+		},
+	})
+}
+
+func (m *MongoSearchSuite) TestConditionCodeNotQuery(c *C) {
+	q := Query{"Condition", "code:not=http://snomed.info/sct|123641001"}
+	results, _, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 4)
+
+	q = Query{"Patient", "gender:not=male"}
+	results, _, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 1)
+}
+
+func (m *MongoSearchSuite) TestConditionCodeTextQueryObject(c *C) {
+	q := Query{"Condition", "code:text=pertussis"}
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"$or": []bson.M{
+			bson.M{"code.text": primitive.Regex{Pattern: "^pertussis", Options: "i"}},
+			bson.M{"code.coding.display": primitive.Regex{Pattern: "^pertussis", Options: "i"}},
+		},
+	})
+}
+
+func (m *MongoSearchSuite) TestConditionCodeTextQuery(c *C) {
+	q := Query{"Condition", "code:text=pertussis"}
+	results, _, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 1)
+
+	q = Query{"Condition", "code:text=Diagnosis"}
+	results, _, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 5)
+
+	q = Query{"Condition", "code:text=headache"}
+	results, _, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 0)
+}
+
+func (m *MongoSearchSuite) TestTokenTextModifierPanicsWithoutText(c *C) {
+	q := Query{"Patient", "gender:text=male"}
+	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, createUnsupportedSearchError("MSG_PARAM_MODIFIER_INVALID", "Parameter \"gender\" modifier is invalid"))
+}
+
+// A fragment of the SNOMED CT heart failure hierarchy
+var heartFailureCodeSystem = map[string]interface{}{
+	"_id":          "heart-failure",
+	"resourceType": "CodeSystem",
+	"url":          "http://snomed.info/sct",
+	"concept": []interface{}{
+		map[string]interface{}{"code": "84114007", "concept": []interface{}{
+			map[string]interface{}{"code": "10091002"},
+			map[string]interface{}{"code": "42343007", "concept": []interface{}{
+				map[string]interface{}{"code": "981000124106"},
+			}},
+		}},
+	},
+}
+
+func (m *MongoSearchSuite) insertHeartFailureCodeSystem(c *C) (remove func()) {
+	codesystems := m.Session.DB("fhir-test").C("codesystems")
+	util.CheckErr(codesystems.Insert(heartFailureCodeSystem))
+	return func() { util.CheckErr(codesystems.RemoveId("heart-failure")) }
+}
+
+func (m *MongoSearchSuite) TestConditionCodeBelowQueryObject(c *C) {
+	defer m.insertHeartFailureCodeSystem(c)()
+
+	q := Query{"Condition", "code:below=http://snomed.info/sct|42343007"}
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"code.coding": bson.M{
+			"$elemMatch": bson.M{
+				"system": primitive.Regex{Pattern: "^http://snomed\\.info/sct$", Options: "i"},
+				"code": bson.M{"$in": []interface{}{
+					primitive.Regex{Pattern: "^42343007$", Options: "i"},
+					primitive.Regex{Pattern: "^981000124106$", Options: "i"},
+				}},
+			},
+		},
+	})
+}
+
+func (m *MongoSearchSuite) TestConditionCodeBelowQuery(c *C) {
+	defer m.insertHeartFailureCodeSystem(c)()
+
+	q := Query{"Condition", "code:below=http://snomed.info/sct|84114007"}
+	results, _, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 2)
+
+	q = Query{"Condition", "code:below=http://snomed.info/sct|42343007"}
+	results, _, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 1)
+
+	// Codes that aren't in the CodeSystem only match themselves
+	q = Query{"Condition", "code:below=http://snomed.info/sct|123641001"}
+	results, _, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 2)
+}
+
+func (m *MongoSearchSuite) TestConditionCodeAboveQuery(c *C) {
+	defer m.insertHeartFailureCodeSystem(c)()
+
+	q := Query{"Condition", "code:above=http://snomed.info/sct|981000124106"}
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o["code.coding"].(bson.M)["$elemMatch"].(bson.M)["code"], DeepEquals, bson.M{"$in": []interface{}{
+		primitive.Regex{Pattern: "^981000124106$", Options: "i"},
+		primitive.Regex{Pattern: "^84114007$", Options: "i"},
+		primitive.Regex{Pattern: "^42343007$", Options: "i"},
+	}})
+
+	results, _, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 1)
+
+	// Without a CodeSystem only the code itself matches
+	q = Query{"Condition", "code:above=http://hl7.org/fhir/sid/icd-9|428.0"}
+	results, _, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 1)
+}
+
+func (m *MongoSearchSuite) TestTokenSubsumptionModifierPanicsWithoutSystem(c *C) {
+	q := Query{"Condition", "code:below=84114007"}
+	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"code\" content is invalid"))
+}
+
 func (m *MongoSearchSuite) TestConditionCodeQueryObjectByCode(c *C) {
 	q := Query{"Condition", "code=123641001"}
 
@@ -630,6 +783,45 @@ func (m *MongoSearchSuite) TestChainedSearchPipelineObjectWithMultipleReferenceP
 	})
 }
 
+func (m *MongoSearchSuite) TestMultiLevelChainedSearchPipelineObject(c *C) {
+	q := Query{"DiagnosticReport", "subject:Patient.organization.name=HealthCo"}
+
+	bsonQuery := m.MongoSearcher.convertToBSON(q)
+	c.Assert(bsonQuery.Resource, Equals, "DiagnosticReport")
+	c.Assert(bsonQuery.Query, IsNil)
+	c.Assert(bsonQuery.usesPipeline(), Equals, true)
+
+	c.Assert(bsonQuery.Pipeline, DeepEquals, []bson.M{
+		bson.M{"$match": bson.M{}},
+		bson.M{"$lookup": bson.M{
+			"from":         "patients",
+			"localField":   "subject.reference__id",
+			"foreignField": "_id",
+			"as":           "_lookup0",
+		}},
+		bson.M{"$lookup": bson.M{
+			"from":         "organizations",
+			"localField":   "_lookup0.managingOrganization.reference__id",
+			"foreignField": "_id",
+			"as":           "_lookup0_lookup0",
+		}},
+		bson.M{"$match": bson.M{
+			"$or": []bson.M{
+				bson.M{"_lookup0_lookup0.alias": primitive.Regex{Pattern: "^HealthCo$", Options: "i"}},
+				bson.M{"_lookup0_lookup0.name": primitive.Regex{Pattern: "^HealthCo$", Options: "i"}},
+			},
+		}},
+	})
+}
+
+func (m *MongoSearchSuite) TestMultiLevelChainedSearchPanicsWhenTooDeep(c *C) {
+	m.MongoSearcher.SetMaxChainDepth(1)
+	defer m.MongoSearcher.SetMaxChainDepth(DefaultMaxChainDepth)
+
+	q := Query{"Condition", "patient.organization.name=HealthCo"}
+	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, createUnsupportedSearchError("MSG_PARAM_CHAINED", "Parameter \"organization\" exceeds the maximum chain depth of 1"))
+}
+
 func (m *MongoSearchSuite) TestConditionReferenceQueryByPatientGender(c *C) {
 	q := Query{"Condition", "patient.gender=male"}
 	results, _, err := m.MongoSearcher.Search(q)
@@ -734,6 +926,53 @@ func (m *MongoSearchSuite) TestReverseChainedSearchPipelineObjectWithMultipleRef
 	})
 }
 
+func (m *MongoSearchSuite) TestNestedReverseChainedSearchPipelineObject(c *C) {
+	q := Query{"Patient", "_has:Observation:subject:_has:AuditEvent:entity:outcome=foo"}
+
+	bsonQuery := m.MongoSearcher.convertToBSON(q)
+	c.Assert(bsonQuery.Resource, Equals, "Patient")
+	c.Assert(bsonQuery.Query, IsNil)
+	c.Assert(bsonQuery.Pipeline, HasLen, 4)
+	c.Assert(bsonQuery.usesPipeline(), Equals, true)
+
+	c.Assert(bsonQuery.Pipeline, DeepEquals, []bson.M{
+		bson.M{"$match": bson.M{}},
+		bson.M{"$lookup": bson.M{
+			"from":         "observations",
+			"localField":   "_id",
+			"foreignField": "subject.reference__id",
+			"as":           "_lookup0",
+		}},
+		bson.M{"$lookup": bson.M{
+			"from":         "auditevents",
+			"localField":   "_lookup0._id",
+			"foreignField": "entity.reference.reference__id",
+			"as":           "_lookup0_lookup0",
+		}},
+		bson.M{"$match": bson.M{"_lookup0_lookup0.outcome": primitive.Regex{Pattern: "^foo$", Options: "i"}}},
+	})
+}
+
+func (m *MongoSearchSuite) TestNestedReverseChainedSearchPipelineObjectWithOr(c *C) {
+	q := Query{"Patient", "_has:Observation:subject:_has:AuditEvent:entity:outcome=foo,bar"}
+
+	bsonQuery := m.MongoSearcher.convertToBSON(q)
+	c.Assert(bsonQuery.Pipeline, HasLen, 4)
+
+	c.Assert(bsonQuery.Pipeline[2], DeepEquals, bson.M{"$lookup": bson.M{
+		"from":         "auditevents",
+		"localField":   "_lookup0._id",
+		"foreignField": "entity.reference.reference__id",
+		"as":           "_lookup0_lookup0",
+	}})
+	c.Assert(bsonQuery.Pipeline[3], DeepEquals, bson.M{"$match": bson.M{
+		"$or": []bson.M{
+			bson.M{"_lookup0_lookup0.outcome": primitive.Regex{Pattern: "^foo$", Options: "i"}},
+			bson.M{"_lookup0_lookup0.outcome": primitive.Regex{Pattern: "^bar$", Options: "i"}},
+		},
+	}})
+}
+
 func (m *MongoSearchSuite) TestPatientReferenceQueryByObservationCode(c *C) {
 	q := Query{"Patient", "_has:Observation:subject:code=1234-5"}
 	results, total, err := m.MongoSearcher.Search(q)
@@ -925,6 +1164,24 @@ func (m *MongoSearchSuite) TestConditionOnsetSAQuery(c *C) {
 	c.Assert(len(results), Equals, 1)
 }
 
+func (m *MongoSearchSuite) TestConditionOnsetNEQuery(c *C) {
+	q := Query{"Condition", "onset-date=ne2012-03-01T07:05-05:00"}
+	results, _, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 3)
+}
+
+func (m *MongoSearchSuite) TestConditionOnsetAPQuery(c *C) {
+	// Five hours after the search date, so the range is widened by 30 minutes either side
+	defer func() { timeNow = time.Now }()
+	timeNow = func() time.Time { return time.Date(2012, time.March, 1, 12, 5, 0, 0, m.EST) }
+
+	q := Query{"Condition", "onset-date=ap2012-03-01T07:05-05:00"}
+	results, _, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 5)
+}
+
 func (m *MongoSearchSuite) TestConditionOnsetLTQueryObject(c *C) {
 	q := Query{"Condition", "onset-date=lt2012-03-01T07:00"}
 
@@ -1108,6 +1365,26 @@ func (m *MongoSearchSuite) TestConditionSortByOnsetDescending(c *C) {
 	}
 }
 
+func (m *MongoSearchSuite) TestConditionSortByOnsetPipelineStages(c *C) {
+	// onset-date has both dateTime and Period paths, so the sort uses a computed key
+	q := Query{"Condition", "_sort=onset-date"}
+	c.Assert(q.UsesPipeline(), Equals, true)
+
+	stages := m.MongoSearcher.convertOptionsToPipelineStages("Condition", q.Options())
+	c.Assert(stages, DeepEquals, []bson.M{
+		bson.M{"$addFields": bson.M{
+			"_sortKey0": bson.M{"$ifNull": []interface{}{"$onsetDateTime.__from", "$onsetPeriod.start.__from"}},
+		}},
+		bson.M{"$sort": bson.D{{Key: "_sortKey0", Value: 1}}},
+		bson.M{"$project": bson.M{"_sortKey0": 0}},
+		bson.M{"$limit": 100},
+	})
+
+	// Parameters with a single path are sorted on directly
+	q = Query{"Condition", "_sort=code"}
+	c.Assert(q.UsesPipeline(), Equals, false)
+}
+
 // Test date searches on Period
 
 func (m *MongoSearchSuite) TestEncounterPeriodQueryObject(c *C) {
@@ -1187,6 +1464,74 @@ func (m *MongoSearchSuite) TestEncounterPeriodSAQuery(c *C) {
 	c.Assert(len(results), Equals, 1)
 }
 
+func (m *MongoSearchSuite) TestEncounterPeriodNEQueryObject(c *C) {
+	q := Query{"Encounter", "date=ne2012-11-01T08:45"}
+
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"$or": []bson.M{
+			bson.M{
+				"period.start.__from": bson.M{
+					"$lt": time.Date(2012, time.November, 1, 8, 45, 0, 0, m.Local),
+				},
+			},
+			bson.M{
+				"period.end.__to": bson.M{
+					"$gt": time.Date(2012, time.November, 1, 8, 46, 0, 0, m.Local),
+				},
+			},
+			bson.M{
+				"period":       bson.M{"$ne": nil},
+				"period.start": nil,
+			},
+			bson.M{
+				"period":     bson.M{"$ne": nil},
+				"period.end": nil,
+			},
+		},
+	})
+}
+
+func (m *MongoSearchSuite) TestEncounterPeriodAPQueryObject(c *C) {
+	// Ten hours after the search date, so the range is widened by an hour either side
+	defer func() { timeNow = time.Now }()
+	timeNow = func() time.Time { return time.Date(2012, time.November, 1, 18, 45, 0, 0, m.Local) }
+
+	q := Query{"Encounter", "date=ap2012-11-01T08:45"}
+
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"$and": []bson.M{
+			bson.M{
+				"$or": []bson.M{
+					bson.M{
+						"period.start.__from": bson.M{
+							"$lt": time.Date(2012, time.November, 1, 9, 46, 0, 0, m.Local),
+						},
+					},
+					bson.M{
+						"period":       bson.M{"$ne": nil},
+						"period.start": nil,
+					},
+				},
+			},
+			bson.M{
+				"$or": []bson.M{
+					bson.M{
+						"period.end.__to": bson.M{
+							"$gt": time.Date(2012, time.November, 1, 7, 45, 0, 0, m.Local),
+						},
+					},
+					bson.M{
+						"period":     bson.M{"$ne": nil},
+						"period.end": nil,
+					},
+				},
+			},
+		},
+	})
+}
+
 func (m *MongoSearchSuite) TestEncounterPeriodLTQueryObject(c *C) {
 	q := Query{"Encounter", "date=lt2012-11-01T08:30"}
 
@@ -1343,7 +1688,92 @@ func (m *MongoSearchSuite) TestEncounterSortByPeriodDescending(c *C) {
 	}
 }
 
-// TODO: Test date searches on date, instant, and Timing
+// TODO: Test date searches on date and instant
+
+func (m *MongoSearchSuite) TestTimingBoundsDateSearch(c *C) {
+	carePlan, err := models2.NewResourceFromJsonBytes([]byte(`{
+		"resourceType": "CarePlan",
+		"id": "5d0b5bdf00000000000000d1",
+		"status": "active",
+		"intent": "plan",
+		"subject": {"reference": "Patient/4954037118555241963"},
+		"activity": [{"detail": {"status": "scheduled", "scheduledTiming": {"repeat": {
+			"boundsPeriod": {"start": "2023-03-01", "end": "2023-06-30"},
+			"frequency": 1, "period": 1, "periodUnit": "d"
+		}}}}]
+	}`))
+	util.CheckErr(err)
+	_, err = m.MongoSearcher.db.Collection("careplans").InsertOne(m.MongoSearcher.ctx, carePlan)
+	util.CheckErr(err)
+	defer func() { util.CheckErr(m.Session.DB("fhir-test").C("careplans").RemoveId("5d0b5bdf00000000000000d1")) }()
+
+	expected := map[string]int{
+		"activity-date=ge2023":       1,
+		"activity-date=2023":         1,
+		"activity-date=2023-04":      0,
+		"activity-date=lt2023":       0,
+		"activity-date=gt2023-07-01": 0,
+		"activity-date=sa2022-12-31": 1,
+		"activity-date=eb2023-07-02": 1,
+		"activity-date=le2023-03-01": 1,
+		"activity-date=ge2023-06-30": 1,
+		"activity-date=ap2023-05-01": 1,
+		"activity-date=eq2023-03-01": 0,
+		"activity-date=ne2023-03-01": 1,
+	}
+	for query, count := range expected {
+		results, _, err := m.MongoSearcher.Search(Query{"CarePlan", query})
+		util.CheckErr(err)
+		c.Assert(results, HasLen, count, Commentf("CarePlan?%s", query))
+	}
+}
+
+// Test number searches on decimal
+
+func (m *MongoSearchSuite) TestChargeItemFactorOverrideDecimalQueryObject(c *C) {
+	dec := func(s string) primitive.Decimal128 {
+		d, err := primitive.ParseDecimal128(s)
+		util.CheckErr(err)
+		return d
+	}
+
+	q := Query{"ChargeItem", "factor-override=0.8"}
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"factorOverride.__from": bson.M{"$gte": dec("0.75")},
+		"factorOverride.__to":   bson.M{"$lte": dec("0.85")},
+	})
+
+	q = Query{"ChargeItem", "factor-override=gt0.80"}
+	o = m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"factorOverride.__to": bson.M{"$gt": dec("0.80")},
+	})
+
+	q = Query{"ChargeItem", "factor-override=ne0.8"}
+	o = m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"$or": []bson.M{
+			bson.M{"factorOverride.__from": bson.M{"$lt": dec("0.75")}},
+			bson.M{"factorOverride.__to": bson.M{"$gt": dec("0.85")}},
+		},
+	})
+}
+
+func (m *MongoSearchSuite) TestRiskAssessmentProbabilityDecimalQueryObject(c *C) {
+	q := Query{"RiskAssessment", "probability=0.5"}
+	o := m.MongoSearcher.createQueryObject(q)
+	l, _ := primitive.ParseDecimal128("0.45")
+	h, _ := primitive.ParseDecimal128("0.55")
+	c.Assert(o, DeepEquals, bson.M{
+		"prediction": bson.M{
+			"$elemMatch": bson.M{
+				"probabilityDecimal.__from": bson.M{"$gte": l},
+				"probabilityDecimal.__to":   bson.M{"$lte": h},
+			},
+		},
+	})
+}
 
 // Test number searches on positiveInt
 
@@ -1596,14 +2026,74 @@ func (m *MongoSearchSuite) TestNonMatchingPatientNameStringQuery(c *C) {
 	c.Assert(len(results), Equals, 0)
 }
 
-func (m *MongoSearchSuite) TestPatientSortByNameAscending(c *C) {
-	q := Query{"Patient", "_sort=name"}
+func (m *MongoSearchSuite) TestPatientNameExactStringQueryObject(c *C) {
+	q := Query{"Patient", "name:exact=Peters"}
 
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"$or": []bson.M{
+			bson.M{"name.text": "Peters"},
+			bson.M{"name.family": "Peters"},
+			bson.M{"name.given": "Peters"},
+		},
+	})
+}
+
+func (m *MongoSearchSuite) TestPatientNameExactStringQuery(c *C) {
+	q := Query{"Patient", "name:exact=Peters"}
 	results, _, err := m.MongoSearcher.Search(q)
 	util.CheckErr(err)
 	c.Assert(len(results), Equals, 2)
 
-	// convert search results to patients
+	q = Query{"Patient", "name:exact=peters"}
+	results, _, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 0)
+
+	q = Query{"Patient", "name:exact=Pete"}
+	results, _, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 0)
+}
+
+func (m *MongoSearchSuite) TestPatientNameContainsStringQueryObject(c *C) {
+	q := Query{"Patient", "name:contains=eter"}
+
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"$or": []bson.M{
+			bson.M{"name.text": primitive.Regex{Pattern: "eter", Options: "i"}},
+			bson.M{"name.family": primitive.Regex{Pattern: "eter", Options: "i"}},
+			bson.M{"name.given": primitive.Regex{Pattern: "eter", Options: "i"}},
+		},
+	})
+}
+
+func (m *MongoSearchSuite) TestPatientNameContainsStringQuery(c *C) {
+	q := Query{"Patient", "name:contains=ETER"}
+	results, _, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 2)
+
+	q = Query{"Patient", "name:contains=ohn"}
+	results, _, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 1)
+
+	q = Query{"Patient", "name:contains=xyz"}
+	results, _, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 0)
+}
+
+func (m *MongoSearchSuite) TestPatientSortByNameAscending(c *C) {
+	q := Query{"Patient", "_sort=name"}
+
+	results, _, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 2)
+
+	// convert search results to patients
 	patients := make([]models.Patient, len(results))
 	for i := 0; i < len(results); i++ {
 		var resource models.Patient
@@ -1704,10 +2194,110 @@ func (m *MongoSearchSuite) TestNonMatchingPatientAddressStringQuery(c *C) {
 	c.Assert(len(results), Equals, 0)
 }
 
+// Tests composite searches
+
+func (m *MongoSearchSuite) TestObservationCodeValueQuantityQueryObject(c *C) {
+	q := Query{"Observation", "code-value-quantity=http://loinc.org|3141-9$lt186|http://unitsofmeasure.org|[lb_av]"}
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"code.coding": bson.M{
+			"$elemMatch": bson.M{
+				"system": primitive.Regex{Pattern: "^http://loinc\\.org$", Options: "i"},
+				"code":   primitive.Regex{Pattern: "^3141-9$", Options: "i"},
+			},
+		},
+		"valueQuantity.value.__from": bson.M{"$lt": float64(186)},
+		"valueQuantity.code":         primitive.Regex{Pattern: "^\\[lb_av\\]$", Options: "i"},
+		"valueQuantity.system":       primitive.Regex{Pattern: "^http://unitsofmeasure\\.org$", Options: "i"},
+	})
+}
+
+func (m *MongoSearchSuite) TestObservationCodeValueQuantityQuery(c *C) {
+	q := Query{"Observation", "code-value-quantity=http://loinc.org|3141-9$lt186|http://unitsofmeasure.org|[lb_av]"}
+	results, _, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 1)
+
+	q = Query{"Observation", "code-value-quantity=http://loinc.org|3141-9$gt186|http://unitsofmeasure.org|[lb_av]"}
+	results, _, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 0)
+
+	q = Query{"Observation", "code-value-quantity=http://loinc.org|17856-6$lt186|http://unitsofmeasure.org|[lb_av]"}
+	results, _, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 0)
+}
+
+func (m *MongoSearchSuite) TestObservationComponentCodeValueQuantityQueryObject(c *C) {
+	q := Query{"Observation", "component-code-value-quantity=http://loinc.org|8480-6$lt90|http://unitsofmeasure.org|mm[Hg]"}
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"component": bson.M{
+			"$elemMatch": bson.M{
+				"code.coding": bson.M{
+					"$elemMatch": bson.M{
+						"system": primitive.Regex{Pattern: "^http://loinc\\.org$", Options: "i"},
+						"code":   primitive.Regex{Pattern: "^8480-6$", Options: "i"},
+					},
+				},
+				"valueQuantity.value.__from": bson.M{"$lt": float64(90)},
+				"valueQuantity.code":         primitive.Regex{Pattern: "^mm\\[Hg\\]$", Options: "i"},
+				"valueQuantity.system":       primitive.Regex{Pattern: "^http://unitsofmeasure\\.org$", Options: "i"},
+			},
+		},
+	})
+}
+
+func (m *MongoSearchSuite) TestObservationComboCodeValueConceptQueryObject(c *C) {
+	q := Query{"Observation", "combo-code-value-concept=http://loinc.org|8480-6$http://snomed.info/sct|271650006"}
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"$or": []bson.M{
+			bson.M{
+				"component": bson.M{
+					"$elemMatch": bson.M{
+						"code.coding": bson.M{
+							"$elemMatch": bson.M{
+								"system": primitive.Regex{Pattern: "^http://loinc\\.org$", Options: "i"},
+								"code":   primitive.Regex{Pattern: "^8480-6$", Options: "i"},
+							},
+						},
+						"valueCodeableConcept.coding": bson.M{
+							"$elemMatch": bson.M{
+								"system": primitive.Regex{Pattern: "^http://snomed\\.info/sct$", Options: "i"},
+								"code":   primitive.Regex{Pattern: "^271650006$", Options: "i"},
+							},
+						},
+					},
+				},
+			},
+			bson.M{
+				"code.coding": bson.M{
+					"$elemMatch": bson.M{
+						"system": primitive.Regex{Pattern: "^http://loinc\\.org$", Options: "i"},
+						"code":   primitive.Regex{Pattern: "^8480-6$", Options: "i"},
+					},
+				},
+				"valueCodeableConcept.coding": bson.M{
+					"$elemMatch": bson.M{
+						"system": primitive.Regex{Pattern: "^http://snomed\\.info/sct$", Options: "i"},
+						"code":   primitive.Regex{Pattern: "^271650006$", Options: "i"},
+					},
+				},
+			},
+		},
+	})
+}
+
+func (m *MongoSearchSuite) TestCompositeSearchPanicsForMissingComponent(c *C) {
+	q := Query{"Observation", "code-value-quantity=http://loinc.org|3141-9"}
+	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"code-value-quantity\" content is invalid"))
+}
+
 // Test quantity searches on Quantity
 
 func (m *MongoSearchSuite) TestValueQuantityQueryObjectByValueAndUnit(c *C) {
-	c.Skip("quantity search without system not supported yet - see createQuantityQueryObject")
 	q := Query{"Observation", "value-quantity=185||lbs"}
 	o := m.MongoSearcher.createQueryObject(q)
 	c.Assert(o, DeepEquals, bson.M{
@@ -1721,7 +2311,6 @@ func (m *MongoSearchSuite) TestValueQuantityQueryObjectByValueAndUnit(c *C) {
 }
 
 func (m *MongoSearchSuite) TestValueQuantityQueryByValueAndUnit(c *C) {
-	c.Skip("quantity search without system not supported yet - see createQuantityQueryObject")
 	q := Query{"Observation", "value-quantity=185||lbs"}
 	results, _, err := m.MongoSearcher.Search(q)
 	util.CheckErr(err)
@@ -1729,15 +2318,39 @@ func (m *MongoSearchSuite) TestValueQuantityQueryByValueAndUnit(c *C) {
 }
 
 func (m *MongoSearchSuite) TestValueQuantityQueryByValueAndCode(c *C) {
-	c.Skip("quantity search without system not supported yet - see createQuantityQueryObject")
 	q := Query{"Observation", "value-quantity=185||[lb_av]"}
 	results, _, err := m.MongoSearcher.Search(q)
 	util.CheckErr(err)
 	c.Assert(len(results), Equals, 1)
 }
 
+func (m *MongoSearchSuite) TestValueQuantityQueryObjectApproximately(c *C) {
+	// Approximately is +/- 10%
+	q := Query{"Observation", "value-quantity=ap200||lbs"}
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"valueQuantity.value.__from": bson.M{"$lt": 220.0},
+		"valueQuantity.value.__to":   bson.M{"$gt": 180.0},
+		"$or": []bson.M{
+			bson.M{"valueQuantity.code": primitive.Regex{Pattern: "^lbs$", Options: "i"}},
+			bson.M{"valueQuantity.unit": primitive.Regex{Pattern: "^lbs$", Options: "i"}},
+		},
+	})
+}
+
+func (m *MongoSearchSuite) TestValueQuantityQueryApproximately(c *C) {
+	q := Query{"Observation", "value-quantity=ap170||lbs"}
+	results, _, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 1)
+
+	q = Query{"Observation", "value-quantity=ap160||lbs"}
+	results, _, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 0)
+}
+
 func (m *MongoSearchSuite) TestValueQuantityQueryByWrongValueAndUnit(c *C) {
-	c.Skip("quantity search without system not supported yet - see createQuantityQueryObject")
 	q := Query{"Observation", "value-quantity=186||lbs"}
 	results, _, err := m.MongoSearcher.Search(q)
 	util.CheckErr(err)
@@ -1745,7 +2358,6 @@ func (m *MongoSearchSuite) TestValueQuantityQueryByWrongValueAndUnit(c *C) {
 }
 
 func (m *MongoSearchSuite) TestValueQuantityQueryByValueAndWrongUnit(c *C) {
-	c.Skip("quantity search without system not supported yet - see createQuantityQueryObject")
 	q := Query{"Observation", "value-quantity=185||pounds"}
 	results, _, err := m.MongoSearcher.Search(q)
 	util.CheckErr(err)
@@ -1764,7 +2376,6 @@ func (m *MongoSearchSuite) TestValueQuantityQueryObjectByValueAndSystemAndCode(c
 }
 
 func (m *MongoSearchSuite) TestValueQuantityQueryObjectByValueAndUnitLT(c *C) {
-	c.Skip("quantity search without system not supported yet - see createQuantityQueryObject")
 	q := Query{"Observation", "value-quantity=lt186||lbs"}
 	o := m.MongoSearcher.createQueryObject(q)
 	c.Assert(o, DeepEquals, bson.M{
@@ -1786,7 +2397,6 @@ func (m *MongoSearchSuite) TestValueQuantityQueryObjectByValueAndUnitLT(c *C) {
 }
 
 func (m *MongoSearchSuite) TestValueQuantityQueryObjectByValueAndUnitGT(c *C) {
-	c.Skip("quantity search without system not supported yet - see createQuantityQueryObject")
 	q := Query{"Observation", "value-quantity=gt184||lbs"}
 	o := m.MongoSearcher.createQueryObject(q)
 	c.Assert(o, DeepEquals, bson.M{
@@ -1808,23 +2418,20 @@ func (m *MongoSearchSuite) TestValueQuantityQueryObjectByValueAndUnitGT(c *C) {
 }
 
 func (m *MongoSearchSuite) TestValueQuantityQueryObjectByValueAndUnitLE(c *C) {
-	c.Skip("quantity search without system not supported yet - see createQuantityQueryObject")
 	q := Query{"Observation", "value-quantity=le186||lbs"}
 	o := m.MongoSearcher.createQueryObject(q)
 	c.Assert(o, DeepEquals, bson.M{
-		"valueQuantity": bson.M{
-			"$and": []bson.M{
-				bson.M{
-					"$or": []bson.M{
-						bson.M{"value.__from": bson.M{"$lte": float64(185.5)}},
-						bson.M{"value.__to": bson.M{"$lte": float64(186.5)}},
-					},
+		"$and": []bson.M{
+			bson.M{
+				"$or": []bson.M{
+					bson.M{"valueQuantity.value.__from": bson.M{"$lte": float64(185.5)}},
+					bson.M{"valueQuantity.value.__to": bson.M{"$lte": float64(186.5)}},
 				},
-				bson.M{
-					"$or": []bson.M{
-						bson.M{"code": primitive.Regex{Pattern: "^lbs$", Options: "i"}},
-						bson.M{"unit": primitive.Regex{Pattern: "^lbs$", Options: "i"}},
-					},
+			},
+			bson.M{
+				"$or": []bson.M{
+					bson.M{"valueQuantity.code": primitive.Regex{Pattern: "^lbs$", Options: "i"}},
+					bson.M{"valueQuantity.unit": primitive.Regex{Pattern: "^lbs$", Options: "i"}},
 				},
 			},
 		},
@@ -1841,14 +2448,22 @@ func (m *MongoSearchSuite) TestValueQuantityQueryObjectByValueAndUnitLE(c *C) {
 }
 
 func (m *MongoSearchSuite) TestValueQuantityQueryObjectByValueAndUnitGE(c *C) {
-	c.Skip("quantity search without system not supported yet - see createQuantityQueryObject")
 	q := Query{"Observation", "value-quantity=ge184||lbs"}
 	o := m.MongoSearcher.createQueryObject(q)
 	c.Assert(o, DeepEquals, bson.M{
-		"valueQuantity.value.__from": bson.M{"$gte": float64(183.5)},
-		"$or": []bson.M{
-			bson.M{"valueQuantity.code": primitive.Regex{Pattern: "^lbs$", Options: "i"}},
-			bson.M{"valueQuantity.unit": primitive.Regex{Pattern: "^lbs$", Options: "i"}},
+		"$and": []bson.M{
+			bson.M{
+				"$or": []bson.M{
+					bson.M{"valueQuantity.value.__to": bson.M{"$gte": float64(184.5)}},
+					bson.M{"valueQuantity.value.__from": bson.M{"$gte": float64(183.5)}},
+				},
+			},
+			bson.M{
+				"$or": []bson.M{
+					bson.M{"valueQuantity.code": primitive.Regex{Pattern: "^lbs$", Options: "i"}},
+					bson.M{"valueQuantity.unit": primitive.Regex{Pattern: "^lbs$", Options: "i"}},
+				},
+			},
 		},
 	})
 
@@ -1862,6 +2477,19 @@ func (m *MongoSearchSuite) TestValueQuantityQueryObjectByValueAndUnitGE(c *C) {
 	c.Assert(len(results), Equals, 1)
 }
 
+func (m *MongoSearchSuite) TestValueQuantityQueryObjectByValueOnly(c *C) {
+	q := Query{"Observation", "value-quantity=185"}
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"valueQuantity.value.__from": bson.M{"$gte": 184.5},
+		"valueQuantity.value.__to":   bson.M{"$lte": 185.5},
+	})
+
+	results, _, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 1)
+}
+
 func (m *MongoSearchSuite) TestValueQuantityQueryByValueAndSystemAndCode(c *C) {
 	q := Query{"Observation", "value-quantity=185|http://unitsofmeasure.org|[lb_av]"}
 	results, _, err := m.MongoSearcher.Search(q)
@@ -2021,95 +2649,510 @@ func (m *MongoSearchSuite) TestSubscriptionURLQuery(c *C) {
 	c.Assert(len(results), Equals, 1)
 }
 
-// TODO: Test composite searches
+func (m *MongoSearchSuite) TestSubscriptionURLBelowQueryObject(c *C) {
+	q := Query{"Subscription", "url:below=https://biliwatch.com/customers/"}
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"channel.endpoint": primitive.Regex{Pattern: "^https://biliwatch\\.com/customers/"},
+	})
+}
 
-// Test custom search
+func (m *MongoSearchSuite) TestSubscriptionURLBelowQuery(c *C) {
+	q := Query{"Subscription", "url:below=https://biliwatch.com/customers/"}
+	results, _, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 1)
 
-type BroParam struct {
-	info  SearchParamInfo
-	IsBro bool
+	q = Query{"Subscription", "url:below=https://biliwatch.com/vendors/"}
+	results, _, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 0)
 }
 
-func (b *BroParam) getInfo() SearchParamInfo {
-	return b.info
+func (m *MongoSearchSuite) TestSubscriptionURLAboveQueryObject(c *C) {
+	q := Query{"Subscription", "url:above=https://biliwatch.com/customers/mount-auburn-miu"}
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"channel.endpoint": bson.M{
+			"$in": []string{
+				"https://biliwatch.com/customers/mount-auburn-miu",
+				"https://biliwatch.com/customers/mount-auburn-miu/",
+				"https://biliwatch.com/customers",
+				"https://biliwatch.com/customers/",
+				"https://biliwatch.com",
+				"https://biliwatch.com/",
+			},
+		},
+	})
 }
 
-func (b *BroParam) setInfo(info SearchParamInfo) {
-	b.info = info
-}
+func (m *MongoSearchSuite) TestSubscriptionURLAboveQuery(c *C) {
+	q := Query{"Subscription", "url:above=https://biliwatch.com/customers/mount-auburn-miu/on-result/123"}
+	results, _, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 1)
 
-func (b *BroParam) getQueryParamAndValue() (string, string) {
-	return b.info.Name, fmt.Sprint(b.IsBro)
+	q = Query{"Subscription", "url:above=https://biliwatch.com/customers/mount-auburn-miu"}
+	results, _, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 0)
 }
 
-func BroParser(info SearchParamInfo, data SearchParamData) (SearchParam, error) {
-	broParam := new(BroParam)
-	broParam.info = info
-	broParam.IsBro = (data.Value == "true")
-	return broParam, nil
+func (m *MongoSearchSuite) TestLocationNearQueryObject(c *C) {
+	q := Query{"Location", "near=42.256|-83.694|5|km"}
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"__position": bson.M{
+			"$geoWithin": bson.M{
+				"$centerSphere": []interface{}{
+					[]float64{-83.694, 42.256},
+					5 / earthRadiusKm,
+				},
+			},
+		},
+	})
 }
 
-func BroBSONBuilder(p SearchParam, m *MongoSearcher) (bson.M, error) {
-	bp, ok := p.(*BroParam)
-	if !ok {
-		return nil, errors.New("Expected BroParam")
-	}
-	if bp.IsBro {
-		return bson.M{
-			"gender": "male",
-		}, nil
-	}
-	return bson.M{
-		"gender": bson.M{
-			"$not": "male",
+func (m *MongoSearchSuite) TestLocationNearOrderByDistance(c *C) {
+	q := Query{"Location", "near=42.256|-83.694|5|km&name=Main"}
+	o := orderByDistance(m.MongoSearcher.createQueryObject(q))
+	c.Assert(o, HasLen, 2)
+	c.Assert(o["__position"], DeepEquals, bson.M{
+		"$nearSphere": bson.M{
+			"$geometry":    bson.M{"type": "Point", "coordinates": []float64{-83.694, 42.256}},
+			"$maxDistance": 5000.0,
 		},
-	}, nil
-}
+	})
 
-var broSearchParamInfo = SearchParamInfo{
-	Resource: "Patient",
-	Name:     "bro",
-	Type:     "test.bro",
+	// $nearSphere isn't allowed within an $or
+	q = Query{"Location", "near=42.256|-83.694|5|km,42.3|-83.7|1|km"}
+	o = m.MongoSearcher.createQueryObject(q)
+	c.Assert(orderByDistance(o), DeepEquals, o)
 }
 
-func (m *MongoSearchSuite) TestBroCustomQueryObject(c *C) {
-	GlobalRegistry().RegisterParameterInfo(broSearchParamInfo)
-	GlobalRegistry().RegisterParameterParser("test.bro", BroParser)
-	GlobalMongoRegistry().RegisterBSONBuilder("test.bro", BroBSONBuilder)
+// Test _query (named query) searches
 
-	q := Query{"Patient", "bro=true"}
-	o := m.MongoSearcher.createQueryObject(q)
-	c.Assert(o, DeepEquals, bson.M{
-		"gender": "male",
+func init() {
+	// Patients of a given sex, i.e. an alias for gender
+	GlobalRegistry().RegisterNamedQuery(NamedQuery{Name: "test-sex", Resource: "Patient", Parameters: []string{"sex"}})
+	GlobalMongoRegistry().RegisterNamedQueryBuilder("test-sex", NamedQueryBuilder{
+		BSON: func(param *NamedQueryParam, searcher *MongoSearcher) (bson.M, error) {
+			return bson.M{"gender": param.Parameters.Get("sex")}, nil
+		},
 	})
 
-	q = Query{"Patient", "bro=false"}
-	o = m.MongoSearcher.createQueryObject(q)
-	c.Assert(o, DeepEquals, bson.M{
-		"gender": bson.M{
-			"$not": "male",
+	// The youngest patient
+	GlobalRegistry().RegisterNamedQuery(NamedQuery{Name: "test-youngest", Resource: "Patient"})
+	GlobalMongoRegistry().RegisterNamedQueryBuilder("test-youngest", NamedQueryBuilder{
+		Pipeline: func(param *NamedQueryParam, searcher *MongoSearcher) ([]bson.M, error) {
+			return []bson.M{{"$sort": bson.M{"birthDate.__from": -1}}, {"$limit": 1}}, nil
 		},
 	})
 }
 
-func (m *MongoSearchSuite) TestBroCustomQuery(c *C) {
-	GlobalRegistry().RegisterParameterInfo(broSearchParamInfo)
-	GlobalRegistry().RegisterParameterParser("test.bro", BroParser)
-	GlobalMongoRegistry().RegisterBSONBuilder("test.bro", BroBSONBuilder)
+func (m *MongoSearchSuite) TestNamedQueryObject(c *C) {
+	q := Query{"Patient", "_query=test-sex&sex=male&_id=4954037118555241963"}
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"gender": "male",
+		"_id":    "4954037118555241963",
+	})
+}
 
-	q := Query{"Patient", "bro=true"}
+func (m *MongoSearchSuite) TestNamedQuery(c *C) {
+	q := Query{"Patient", "_query=test-sex&sex=female"}
 	results, _, err := m.MongoSearcher.Search(q)
 	util.CheckErr(err)
 	c.Assert(len(results), Equals, 1)
-}
-
-// Tests special searches on _id
-
-func (m *MongoSearchSuite) TestConditionIdQueryObject(c *C) {
-	q := Query{"Condition", "_id=123456789"}
+	c.Assert(results[0].Id(), Equals, "4954037118555579315")
 
-	o := m.MongoSearcher.createQueryObject(q)
-	c.Assert(o, DeepEquals, bson.M{"_id": "123456789"})
+	// The named query's parameters are kept in the reconstructed query
+	params := q.URLQueryParameters(false)
+	c.Assert(params.Encode(), Equals, "_query=test-sex&sex=female")
+}
+
+func (m *MongoSearchSuite) TestNamedQueryWithPipelineStages(c *C) {
+	q := Query{"Patient", "_query=test-youngest"}
+	bsonQuery := m.MongoSearcher.convertToBSON(q)
+	c.Assert(bsonQuery.Pipeline, DeepEquals, []bson.M{
+		{"$match": bson.M{}},
+		{"$sort": bson.M{"birthDate.__from": -1}},
+		{"$limit": 1},
+	})
+
+	results, _, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 1)
+}
+
+func (m *MongoSearchSuite) TestUnknownNamedQueryPanics(c *C) {
+	q := Query{"Patient", "_query=nope"}
+	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, createUnsupportedSearchError("MSG_PARAM_INVALID", "Parameter \"_query\" content is invalid"))
+
+	// test-sex only searches patients
+	q = Query{"Condition", "_query=test-sex&sex=male"}
+	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, createUnsupportedSearchError("MSG_PARAM_INVALID", "Parameter \"_query\" content is invalid"))
+}
+
+// Test pipeline stage builders
+
+func (m *MongoSearchSuite) TestRegisteredPipelineStages(c *C) {
+	// Only female patients are visible, e.g. as a tenant's filter would
+	GlobalMongoRegistry().RegisterPipelineStageBuilder("test-female", func(query Query, searcher *MongoSearcher) ([]bson.M, error) {
+		if query.Resource != "Patient" {
+			return nil, nil
+		}
+		return []bson.M{{"$match": bson.M{"gender": "female"}}}, nil
+	})
+	defer GlobalMongoRegistry().UnregisterPipelineStageBuilder("test-female")
+
+	q := Query{"Patient", ""}
+	bsonQuery := m.MongoSearcher.convertToBSON(q)
+	c.Assert(bsonQuery.Query, IsNil)
+	c.Assert(bsonQuery.Pipeline, DeepEquals, []bson.M{
+		{"$match": bson.M{}},
+		{"$match": bson.M{"gender": "female"}},
+	})
+
+	results, total, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(total, Equals, uint32(1))
+	c.Assert(results, HasLen, 1)
+	c.Assert(results[0].Id(), Equals, "4954037118555579315")
+
+	// Builders that don't apply to a query don't change it
+	bsonQuery = m.MongoSearcher.convertToBSON(Query{"Condition", "patient=4954037118555241963"})
+	c.Assert(bsonQuery.Pipeline, IsNil)
+
+	GlobalMongoRegistry().RegisterPipelineStageBuilder("test-failing", func(query Query, searcher *MongoSearcher) ([]bson.M, error) {
+		return nil, errors.New("failed")
+	})
+	defer GlobalMongoRegistry().UnregisterPipelineStageBuilder("test-failing")
+	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, createInternalServerError("", "Pipeline stage builder test-failing failed: failed"))
+}
+
+// Test custom search parameters
+
+func (m *MongoSearchSuite) TestCustomSearchParameter(c *C) {
+	err := RegisterSearchParameter(&models.SearchParameter{
+		Code:       "test-code-text",
+		Base:       []string{"Condition"},
+		Type:       "string",
+		Expression: "Condition.code.text",
+	})
+	util.CheckErr(err)
+
+	q := Query{"Condition", "test-code-text=Diagnosis"}
+	c.Assert(m.MongoSearcher.createQueryObject(q), DeepEquals, bson.M{
+		"code.text": primitive.Regex{Pattern: "^Diagnosis", Options: "i"},
+	})
+	results, _, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 5)
+}
+
+// Test _list searches
+
+func (m *MongoSearchSuite) insertPatientList(c *C) (remove func()) {
+	lists := m.Session.DB("fhir-test").C("lists")
+	util.CheckErr(lists.Insert(map[string]interface{}{
+		"_id":          "42",
+		"resourceType": "List",
+		"status":       "current",
+		"mode":         "working",
+		"entry": []interface{}{
+			map[string]interface{}{"item": map[string]interface{}{
+				"reference": "Patient/4954037118555241963", "reference__id": "4954037118555241963", "reference__type": "Patient",
+			}},
+			map[string]interface{}{"deleted": true, "item": map[string]interface{}{
+				"reference": "Patient/4954037118555579315", "reference__id": "4954037118555579315", "reference__type": "Patient",
+			}},
+			map[string]interface{}{"item": map[string]interface{}{
+				"reference": "Condition/8664777288161060797", "reference__id": "8664777288161060797", "reference__type": "Condition",
+			}},
+		},
+	}))
+	return func() { util.CheckErr(lists.RemoveId("42")) }
+}
+
+func (m *MongoSearchSuite) TestPatientListQueryObject(c *C) {
+	defer m.insertPatientList(c)()
+
+	q := Query{"Patient", "_list=42"}
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{"_id": bson.M{"$in": []string{"4954037118555241963"}}})
+
+	q = Query{"Patient", "_list=43"}
+	o = m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{"_id": bson.M{"$in": []string{}}})
+}
+
+func (m *MongoSearchSuite) TestPatientListQuery(c *C) {
+	defer m.insertPatientList(c)()
+
+	q := Query{"Patient", "_list=42"}
+	results, _, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 1)
+	c.Assert(results[0].Id(), Equals, "4954037118555241963")
+
+	q = Query{"Patient", "_list=42&gender=female"}
+	results, _, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 0)
+
+	q = Query{"Condition", "_list=43,42"}
+	results, _, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 1)
+}
+
+// Test _text and _content searches
+
+func (m *MongoSearchSuite) ensureConditionTextIndex() {
+	conditions := m.Session.DB("fhir-test").C("conditions")
+	util.CheckErr(conditions.EnsureIndex(mgo.Index{Key: []string{"$text:$**"}, LanguageOverride: "__textLanguage"}))
+}
+
+func (m *MongoSearchSuite) TestConditionContentQueryObject(c *C) {
+	q := Query{"Condition", "_content=heart,hypertension"}
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"$text": bson.M{"$search": "heart hypertension"},
+	})
+}
+
+func (m *MongoSearchSuite) TestConditionTextQueryObject(c *C) {
+	q := Query{"Condition", "_text=heart failure"}
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"$text":    bson.M{"$search": "heart failure"},
+		"text.div": primitive.Regex{Pattern: "heart|failure", Options: "i"},
+	})
+}
+
+func (m *MongoSearchSuite) TestConditionContentQuery(c *C) {
+	m.ensureConditionTextIndex()
+
+	q := Query{"Condition", "_content=failure"}
+	results, _, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 1)
+
+	q = Query{"Condition", "_content=heart,hypertension"}
+	results, _, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 2)
+
+	// The conditions don't have narratives
+	q = Query{"Condition", "_text=failure"}
+	results, _, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 0)
+}
+
+func (m *MongoSearchSuite) TestConditionContentQuerySortedByScore(c *C) {
+	m.ensureConditionTextIndex()
+
+	q := Query{"Condition", "_content=heart,hypertension&_sort=_score"}
+	results, _, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 2)
+
+	// The text score isn't part of the resources
+	for _, result := range results {
+		c.Assert(strings.Contains(string(result.JsonBytes()), "__textScore"), Equals, false)
+	}
+}
+
+func (m *MongoSearchSuite) TestContentScoreSortPipelineStages(c *C) {
+	q := Query{"Condition", "_content=heart&_sort=_score"}
+	stages := m.MongoSearcher.convertOptionsToPipelineStages("Condition", q.Options())
+	c.Assert(stages[0], DeepEquals, bson.M{"$sort": bson.D{
+		bson.E{Key: "__textScore", Value: bson.M{"$meta": "textScore"}},
+	}})
+}
+
+func (m *MongoSearchSuite) TestMultipleFullTextParamsPanics(c *C) {
+	q := Query{"Condition", "_content=heart&_text=failure"}
+	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, createUnsupportedSearchError("MSG_PARAM_NO_REPEAT", "Only one _text or _content parameter is supported"))
+}
+
+// TODO: Test composite searches
+
+// Test _filter searches
+
+func (m *MongoSearchSuite) TestPatientFilterQueryObject(c *C) {
+	q := Query{"Patient", `_filter=gender+eq+male+and+(name+co+"eter"+or+birthdate+ge+1990)`}
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"$and": []bson.M{
+			bson.M{"gender": primitive.Regex{Pattern: "^male$", Options: "i"}},
+			bson.M{
+				"$or": []bson.M{
+					bson.M{
+						"$or": []bson.M{
+							bson.M{"name.text": primitive.Regex{Pattern: "eter", Options: "i"}},
+							bson.M{"name.family": primitive.Regex{Pattern: "eter", Options: "i"}},
+							bson.M{"name.given": primitive.Regex{Pattern: "eter", Options: "i"}},
+						},
+					},
+					bson.M{
+						"$or": []bson.M{
+							bson.M{
+								"birthDate.__to": bson.M{
+									"$gte": time.Date(1991, time.January, 1, 0, 0, 0, 0, m.Local),
+								},
+							},
+							bson.M{
+								"birthDate.__from": bson.M{
+									"$gte": time.Date(1990, time.January, 1, 0, 0, 0, 0, m.Local),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+}
+
+func (m *MongoSearchSuite) TestPatientFilterStartsWithQueryObject(c *C) {
+	q := Query{"Patient", `_filter=name+sw+"Pe.t"`}
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"$or": []bson.M{
+			bson.M{"name.text": primitive.Regex{Pattern: `^Pe\.t`, Options: "i"}},
+			bson.M{"name.family": primitive.Regex{Pattern: `^Pe\.t`, Options: "i"}},
+			bson.M{"name.given": primitive.Regex{Pattern: `^Pe\.t`, Options: "i"}},
+		},
+	})
+}
+
+func (m *MongoSearchSuite) TestPatientFilterQuery(c *C) {
+	q := Query{"Patient", `_filter=gender+eq+male+and+birthdate+ge+1990`}
+	results, _, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 1)
+
+	q = Query{"Patient", `_filter=not+(gender+eq+male)+and+name+co+"peter"`}
+	results, _, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 1)
+
+	q = Query{"Patient", `_filter=gender+ne+male+or+birthdate+lt+1990`}
+	results, _, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 1)
+
+	q = Query{"Patient", `_filter=birthdate+pr+true`}
+	results, _, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 2)
+
+	q = Query{"Patient", `_filter=name+sw+"pet"`}
+	results, _, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 2)
+
+	q = Query{"Patient", `_filter=name+sw+"eters"`}
+	results, _, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 0)
+}
+
+func (m *MongoSearchSuite) TestFilterSearchPanicsForUnsupportedOperator(c *C) {
+	q := Query{"Patient", `_filter=name+ew+"ers"`}
+	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, createUnsupportedSearchError("MSG_PARAM_INVALID", "Parameter \"_filter\" operator \"ew\" is not supported"))
+}
+
+// Test custom search
+
+type BroParam struct {
+	info  SearchParamInfo
+	IsBro bool
+}
+
+func (b *BroParam) getInfo() SearchParamInfo {
+	return b.info
+}
+
+func (b *BroParam) setInfo(info SearchParamInfo) {
+	b.info = info
+}
+
+func (b *BroParam) getQueryParamAndValue() (string, string) {
+	return b.info.Name, fmt.Sprint(b.IsBro)
+}
+
+func BroParser(info SearchParamInfo, data SearchParamData) (SearchParam, error) {
+	broParam := new(BroParam)
+	broParam.info = info
+	broParam.IsBro = (data.Value == "true")
+	return broParam, nil
+}
+
+func BroBSONBuilder(p SearchParam, m *MongoSearcher) (bson.M, error) {
+	bp, ok := p.(*BroParam)
+	if !ok {
+		return nil, errors.New("Expected BroParam")
+	}
+	if bp.IsBro {
+		return bson.M{
+			"gender": "male",
+		}, nil
+	}
+	return bson.M{
+		"gender": bson.M{
+			"$not": "male",
+		},
+	}, nil
+}
+
+var broSearchParamInfo = SearchParamInfo{
+	Resource: "Patient",
+	Name:     "bro",
+	Type:     "test.bro",
+}
+
+func (m *MongoSearchSuite) TestBroCustomQueryObject(c *C) {
+	GlobalRegistry().RegisterParameterInfo(broSearchParamInfo)
+	GlobalRegistry().RegisterParameterParser("test.bro", BroParser)
+	GlobalMongoRegistry().RegisterBSONBuilder("test.bro", BroBSONBuilder)
+
+	q := Query{"Patient", "bro=true"}
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"gender": "male",
+	})
+
+	q = Query{"Patient", "bro=false"}
+	o = m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"gender": bson.M{
+			"$not": "male",
+		},
+	})
+}
+
+func (m *MongoSearchSuite) TestBroCustomQuery(c *C) {
+	GlobalRegistry().RegisterParameterInfo(broSearchParamInfo)
+	GlobalRegistry().RegisterParameterParser("test.bro", BroParser)
+	GlobalMongoRegistry().RegisterBSONBuilder("test.bro", BroBSONBuilder)
+
+	q := Query{"Patient", "bro=true"}
+	results, _, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 1)
+}
+
+// Tests special searches on _id
+
+func (m *MongoSearchSuite) TestConditionIdQueryObject(c *C) {
+	q := Query{"Condition", "_id=123456789"}
+
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{"_id": "123456789"})
 }
 
 func (m *MongoSearchSuite) TestConditionIdQuery(c *C) {
@@ -2206,7 +3249,92 @@ func (m *MongoSearchSuite) TestConditionTagQuery(c *C) {
 	c.Assert(cond, DeepEquals, cond2)
 }
 
-// TODO: Test special searches: _content, _lastUpdated, _profile, _query, _security, _text
+func (m *MongoSearchSuite) TestConditionTagCodeQuery(c *C) {
+	q := Query{"Condition", "_tag=bar"}
+	results, _, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 1)
+	c.Assert(results[0].Id(), Equals, "4072118967138896162")
+
+	q = Query{"Patient", "_tag=bar"}
+	results, _, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 0)
+}
+
+// Tests special searches on _profile and _security
+
+func (m *MongoSearchSuite) TestConditionProfileQueryObject(c *C) {
+	q := Query{"Condition", "_profile=http://example.org/fhir/StructureDefinition/tagged-condition"}
+
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"meta.profile": "http://example.org/fhir/StructureDefinition/tagged-condition",
+	})
+}
+
+func (m *MongoSearchSuite) TestConditionProfileQuery(c *C) {
+	q := Query{"Condition", "_profile=http://example.org/fhir/StructureDefinition/tagged-condition"}
+	results, _, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 1)
+	c.Assert(results[0].Id(), Equals, "4072118967138896162")
+
+	q = Query{"Condition", "_profile:below=http://example.org/fhir/StructureDefinition/"}
+	results, _, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 1)
+
+	q = Query{"Condition", "_profile=http://example.org/fhir/StructureDefinition/other"}
+	results, _, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 0)
+}
+
+func (m *MongoSearchSuite) TestConditionSecurityQueryObject(c *C) {
+	q := Query{"Condition", "_security=http://hl7.org/fhir/v3/Confidentiality|R"}
+
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"meta.security": bson.M{
+			"$elemMatch": bson.M{
+				"system": primitive.Regex{Pattern: "^http://hl7\\.org/fhir/v3/Confidentiality$", Options: "i"},
+				"code":   primitive.Regex{Pattern: "^R$", Options: "i"},
+			}},
+	})
+}
+
+func (m *MongoSearchSuite) TestConditionSecurityQuery(c *C) {
+	q := Query{"Condition", "_security=http://hl7.org/fhir/v3/Confidentiality|R"}
+	results, _, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 1)
+	c.Assert(results[0].Id(), Equals, "4072118967138896162")
+
+	q = Query{"Condition", "_security=http://hl7.org/fhir/v3/Confidentiality|N"}
+	results, _, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 0)
+}
+
+// Tests special searches on _lastUpdated
+
+func (m *MongoSearchSuite) TestLastUpdatedQueryObject(c *C) {
+	q := Query{"Condition", "_lastUpdated=gt2019-01-02T03:04:05Z"}
+
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, HasLen, 1)
+	criteria := o["meta.lastUpdated"].(bson.M)
+	c.Assert(criteria, HasLen, 1)
+	c.Assert(criteria["$gt"].(time.Time).UnixNano(), Equals, time.Date(2019, time.January, 2, 3, 4, 5, 0, time.UTC).UnixNano())
+
+	q = Query{"Condition", "_lastUpdated=2019-01-02T03:04:05Z"}
+	o = m.MongoSearcher.createQueryObject(q)
+	criteria = o["meta.lastUpdated"].(bson.M)
+	c.Assert(criteria, HasLen, 2)
+	c.Assert(criteria["$gte"].(time.Time).UnixNano(), Equals, time.Date(2019, time.January, 2, 3, 4, 5, 0, time.UTC).UnixNano())
+	c.Assert(criteria["$lt"].(time.Time).UnixNano(), Equals, time.Date(2019, time.January, 2, 3, 4, 6, 0, time.UTC).UnixNano())
+}
 
 // Test searches with multiple values
 func (m *MongoSearchSuite) TestConditionMultipleCodesQueryObject(c *C) {
@@ -2588,14 +3716,50 @@ func (m *MongoSearchSuite) TestConditionSortWithMultipleSortParamsDescending(c *
 }
 
 func (m *MongoSearchSuite) TestSortingOnParallelArrayPathsDoesntPanic(c *C) {
-	// NOTE: Sorting on family and patient normally causes MongoDB to balk because they have "parallel arrays", but we
-	// should just drop the second sort param instead of panicing
+	// NOTE: Sorting on family and given normally causes MongoDB to balk because they have "parallel arrays", but we
+	// should sort on a computed key for the second sort param instead of panicing
 	q := Query{"Patient", "_sort=family&_sort=given"}
 	results, _, err := m.MongoSearcher.Search(q)
 	util.CheckErr(err)
 	c.Assert(len(results), Equals, 2)
 }
 
+func (m *MongoSearchSuite) TestSortingOnParallelArrayPathsPipelineStages(c *C) {
+	q := Query{"Patient", "_sort=family&_sort:desc=given"}
+	c.Assert(q.UsesPipeline(), Equals, true)
+
+	stages := m.MongoSearcher.convertOptionsToPipelineStages("Patient", q.Options())
+	c.Assert(stages, DeepEquals, []bson.M{
+		bson.M{"$addFields": bson.M{
+			"_sortKey1": bson.M{"$max": bson.M{"$reduce": bson.M{
+				"input":        "$name.given",
+				"initialValue": []interface{}{},
+				"in":           bson.M{"$concatArrays": []interface{}{"$$value", "$$this"}},
+			}}},
+		}},
+		bson.M{"$sort": bson.D{{Key: "name.family", Value: 1}, {Key: "_sortKey1", Value: -1}}},
+		bson.M{"$project": bson.M{"_sortKey1": 0}},
+		bson.M{"$limit": 100},
+	})
+}
+
+func (m *MongoSearchSuite) TestSortingOnParallelArrayPathsHonoursSecondSort(c *C) {
+	// Both patients have the same family name, so the order depends on the given names
+	q := Query{"Patient", "_sort=family&_sort=given"}
+	results, _, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(results, HasLen, 2)
+	c.Assert(results[0].Id(), Equals, "4954037118555241963") // John
+	c.Assert(results[1].Id(), Equals, "4954037118555579315") // Sally
+
+	q = Query{"Patient", "_sort=family&_sort:desc=given"}
+	results, _, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(results, HasLen, 2)
+	c.Assert(results[0].Id(), Equals, "4954037118555579315")
+	c.Assert(results[1].Id(), Equals, "4954037118555241963")
+}
+
 func (m *MongoSearchSuite) TestObservationCodeQueryOptionsForInclude(c *C) {
 	q := Query{"Observation", "code=http://loinc.org|17856-6&_include=Observation:subject&_include=Observation:context"}
 
@@ -2692,6 +3856,106 @@ func (m *MongoSearchSuite) TestPatientGenderQueryOptionsForRevInclude(c *C) {
 	c.Assert(opt.RevInclude[1].Parameter.Name, Equals, "patient")
 }
 
+func (m *MongoSearchSuite) TestIncludeIteratePipelineStages(c *C) {
+	q := Query{"MedicationRequest", "_include=MedicationRequest:medication&_include:iterate=Medication:manufacturer"}
+
+	stages := m.MongoSearcher.convertOptionsToPipelineStages("MedicationRequest", q.Options())
+	c.Assert(stages, DeepEquals, []bson.M{
+		bson.M{"$limit": 100},
+		bson.M{"$lookup": bson.M{
+			"from":         "medications",
+			"localField":   "medicationReference.reference__id",
+			"foreignField": "_id",
+			"as":           "_includedMedicationResourcesReferencedByMedication",
+		}},
+		bson.M{"$lookup": bson.M{
+			"from":         "organizations",
+			"localField":   "_includedMedicationResourcesReferencedByMedication.manufacturer.reference__id",
+			"foreignField": "_id",
+			"as":           "_includedOrganizationResourcesReferencedByManufacturerIterate1",
+		}},
+	})
+}
+
+func (m *MongoSearchSuite) TestIncludeIteratePipelineStagesAreDepthLimited(c *C) {
+	m.MongoSearcher.SetMaxIncludeDepth(2)
+	defer m.MongoSearcher.SetMaxIncludeDepth(DefaultMaxIncludeDepth)
+
+	q := Query{"Organization", "_include:iterate=Organization:partof"}
+
+	stages := m.MongoSearcher.convertOptionsToPipelineStages("Organization", q.Options())
+	c.Assert(stages, DeepEquals, []bson.M{
+		bson.M{"$limit": 100},
+		bson.M{"$lookup": bson.M{
+			"from":         "organizations",
+			"localField":   "partOf.reference__id",
+			"foreignField": "_id",
+			"as":           "_includedOrganizationResourcesReferencedByPartof",
+		}},
+		bson.M{"$lookup": bson.M{
+			"from":         "organizations",
+			"localField":   "_includedOrganizationResourcesReferencedByPartof.partOf.reference__id",
+			"foreignField": "_id",
+			"as":           "_includedOrganizationResourcesReferencedByPartofIterate1",
+		}},
+		bson.M{"$lookup": bson.M{
+			"from":         "organizations",
+			"localField":   "_includedOrganizationResourcesReferencedByPartofIterate1.partOf.reference__id",
+			"foreignField": "_id",
+			"as":           "_includedOrganizationResourcesReferencedByPartofIterate2",
+		}},
+	})
+}
+
+func (m *MongoSearchSuite) TestRevIncludeIteratePipelineStages(c *C) {
+	q := Query{"Patient", "_revinclude=Encounter:patient&_revinclude:iterate=Observation:context"}
+
+	stages := m.MongoSearcher.convertOptionsToPipelineStages("Patient", q.Options())
+	c.Assert(stages, DeepEquals, []bson.M{
+		bson.M{"$limit": 100},
+		bson.M{"$lookup": bson.M{
+			"from":         "encounters",
+			"localField":   "_id",
+			"foreignField": "subject.reference__id",
+			"as":           "_revIncludedEncounterResourcesReferencingPatient",
+		}},
+		bson.M{"$lookup": bson.M{
+			"from":         "observations",
+			"localField":   "_revIncludedEncounterResourcesReferencingPatient._id",
+			"foreignField": "context.reference__id",
+			"as":           "_revIncludedObservationResourcesReferencingContextIterate1",
+		}},
+	})
+}
+
+func (m *MongoSearchSuite) TestElementsPipelineStageKeepsIncludes(c *C) {
+	q := Query{"Condition", "_include=Condition:patient&_elements=code"}
+
+	stages := m.MongoSearcher.convertOptionsToPipelineStages("Condition", q.Options())
+	c.Assert(stages, HasLen, 3)
+	c.Assert(stages[2], DeepEquals, bson.M{"$project": bson.M{
+		"_id":          1,
+		"resourceType": 1,
+		"meta":         1,
+		"code":         1,
+		"_includedPatientResourcesReferencedByPatient": 1,
+	}})
+}
+
+func (m *MongoSearchSuite) TestPatientQueryWithElements(c *C) {
+	q := Query{"Patient", "gender=male&_elements=gender"}
+	results, _, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 1)
+
+	var patient models.Patient
+	util.CheckErr(results[0].Unmarshal(&patient))
+	c.Assert(patient.Id, Equals, "4954037118555241963")
+	c.Assert(patient.Gender, Equals, "male")
+	c.Assert(patient.Name, HasLen, 0)
+	c.Assert(patient.BirthDate, IsNil)
+}
+
 func (m *MongoSearchSuite) TestPatientGenderQueryForRevInclude(c *C) {
 	q := Query{"Patient", "gender=male&_revinclude=Condition:patient&_revinclude=Encounter:patient"}
 
@@ -2729,49 +3993,518 @@ func (m *MongoSearchSuite) TestPatientGenderQueryForRevInclude(c *C) {
 	}
 }
 
-// Test that invalid search parameters PANIC (to ensure people know they are broken)
-func (m *MongoSearchSuite) TestInvalidSearchParameterPanics(c *C) {
-	q := Query{"Condition", "abatement=2012"}
-	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, createInvalidSearchError("SEARCH_NONE", "Error: no processable search found for Condition search parameters \"abatement\""))
+func (m *MongoSearchSuite) TestRevIncludeWithMaxIncludes(c *C) {
+	m.MongoSearcher.SetMaxIncludes(2)
+	defer m.MongoSearcher.SetMaxIncludes(0)
+
+	q := Query{"Patient", "gender=male&_revinclude=Condition:patient&_revinclude=Encounter:patient"}
+	results, _, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 1)
+
+	// One more than the maximum is looked up, so that truncation can be detected
+	c.Assert(results[0].SearchIncludesOfType("Condition"), HasLen, 3)
+	c.Assert(results[0].SearchIncludesOfType("Encounter"), HasLen, 3)
+}
+
+func (m *MongoSearchSuite) TestMaxResults(c *C) {
+	q := Query{"Patient", "_count=5&_maxresults=1"}
+	results, total, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(results, HasLen, 1)
+	c.Assert(total, Equals, uint32(2))
+
+	q = Query{"Patient", "_count=1&_offset=1&_maxresults=1"}
+	results, total, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(results, HasLen, 0)
+	c.Assert(total, Equals, uint32(2))
+
+	q = Query{"Patient", "_count=1&_offset=1&_maxresults=2"}
+	results, _, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(results, HasLen, 1)
+
+	// Keyset paging isn't used, since a cursor doesn't know how many results came before it
+	m.MongoSearcher.SetKeysetPaging(true)
+	defer m.MongoSearcher.SetKeysetPaging(false)
+	q = Query{"Patient", "_count=1&_maxresults=1"}
+	results, _, next, err := m.MongoSearcher.SearchPage(q)
+	util.CheckErr(err)
+	c.Assert(results, HasLen, 1)
+	c.Assert(next, IsNil)
+}
+
+// Test that invalid search parameters PANIC (to ensure people know they are broken)
+func (m *MongoSearchSuite) TestInvalidSearchParameterPanics(c *C) {
+	q := Query{"Condition", "abatement=2012"}
+	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, createInvalidSearchError("SEARCH_NONE", "Error: no processable search found for Condition search parameters \"abatement\""))
+}
+
+// Test that unimplemented features PANIC (to ensure people know they are broken)
+func (m *MongoSearchSuite) TestPrefixedDateSearchPanicsForUnsupportedPrefix(c *C) {
+	q := Query{"Condition", "onset-date=ap2012"}
+	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, createUnsupportedSearchError("MSG_PARAM_INVALID", "Parameter \"onset-date\" content is invalid"))
+}
+
+func (m *MongoSearchSuite) TestPrefixedNumberSearchPanicsForUnsupportedPrefix(c *C) {
+	q := Query{"Immunization", "dose-sequence=sa1"}
+	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, createUnsupportedSearchError("MSG_PARAM_INVALID", "Parameter \"dose-sequence\" content is invalid"))
+}
+
+func (m *MongoSearchSuite) TestPrefixedQuantitySearchPanicsForUnsupportedPrefix(c *C) {
+	q := Query{"Observation", "value-quantity=sa1||mg"}
+	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, createUnsupportedSearchError("MSG_PARAM_INVALID", "Parameter \"value-quantity\" content is invalid"))
+	q = Query{"Observation", "value-quantity=ne1||mg"}
+	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, createUnsupportedSearchError("MSG_PARAM_INVALID", "Parameter \"value-quantity\" content is invalid"))
+}
+
+func (m *MongoSearchSuite) TestMissingModifierQueryObject(c *C) {
+	q := Query{"Condition", "abatement-date:missing=true"}
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"abatementDateTime": nil,
+		"abatementPeriod":   nil,
+	})
+
+	q = Query{"Condition", "abatement-date:missing=false"}
+	o = m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"$or": []bson.M{
+			bson.M{"abatementDateTime": bson.M{"$ne": nil}},
+			bson.M{"abatementPeriod": bson.M{"$ne": nil}},
+		},
+	})
+}
+
+func (m *MongoSearchSuite) TestMissingModifierQuery(c *C) {
+	q := Query{"Condition", "abatement-date:missing=true"}
+	results, _, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 6)
+
+	q = Query{"Condition", "onset-date:missing=true"}
+	results, _, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 0)
+
+	q = Query{"Condition", "onset-date:missing=false"}
+	results, _, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 6)
+}
+
+func (m *MongoSearchSuite) TestMissingModifierOnArrayQueryObject(c *C) {
+	q := Query{"Patient", "given:missing=true"}
+	o := m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"$and": []bson.M{
+			bson.M{"name": bson.M{"$not": bson.M{"$elemMatch": bson.M{"given": bson.M{"$exists": true}}}}},
+		},
+	})
+
+	q = Query{"Patient", "given:missing=false"}
+	o = m.MongoSearcher.createQueryObject(q)
+	c.Assert(o, DeepEquals, bson.M{
+		"name": bson.M{"$elemMatch": bson.M{"given": bson.M{"$exists": true}}},
+	})
+}
+
+func (m *MongoSearchSuite) TestMissingModifierOnArrayQuery(c *C) {
+	// Only one of the names has a given name, so the given name isn't missing
+	patient, err := models2.NewResourceFromJsonBytes([]byte(`{
+		"resourceType": "Patient",
+		"id": "5d0b5bdf00000000000000c2",
+		"name": [{"family": "Nickname"}, {"family": "Missing", "given": ["Nora"]}]
+	}`))
+	util.CheckErr(err)
+	_, err = m.MongoSearcher.db.Collection("patients").InsertOne(m.MongoSearcher.ctx, patient)
+	util.CheckErr(err)
+	defer func() { util.CheckErr(m.Session.DB("fhir-test").C("patients").RemoveId("5d0b5bdf00000000000000c2")) }()
+
+	q := Query{"Patient", "family=Missing&given:missing=true"}
+	results, _, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(results, HasLen, 0)
+
+	q = Query{"Patient", "family=Missing&given:missing=false"}
+	results, _, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(results, HasLen, 1)
+}
+
+func (m *MongoSearchSuite) TestMissingModifierOnCompositePanics(c *C) {
+	q := Query{"Observation", "code-value-quantity:missing=true"}
+	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, createUnsupportedSearchError("MSG_PARAM_MODIFIER_INVALID", "Parameter \"code-value-quantity\" modifier is invalid"))
+}
+
+func (m *MongoSearchSuite) TestModifierSearchPanics(c *C) {
+	q := Query{"Condition", "code:in=http://hl7.org/fhir/ValueSet/condition-code"}
+	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, createUnsupportedSearchError("MSG_PARAM_MODIFIER_INVALID", "Parameter \"code\" modifier is invalid"))
+}
+
+func (m *MongoSearchSuite) TestInvalidContainedParameterPanics(c *C) {
+	q := Query{"Condition", "_contained=maybe"}
+	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_contained\" content is invalid"))
+
+	q = Query{"Condition", "_contained=true&_containedType=parent"}
+	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_containedType\" content is invalid"))
+}
+
+func (m *MongoSearchSuite) TestContainedSearch(c *C) {
+	patient, err := models2.NewResourceFromJsonBytes([]byte(`{
+		"resourceType": "Patient",
+		"id": "5d0b5bdf00000000000000c1",
+		"contained": [
+			{"resourceType": "Medication", "id": "med1", "code": {"coding": [{"system": "http://www.nlm.nih.gov/research/umls/rxnorm", "code": "1049221"}]}},
+			{"resourceType": "Observation", "id": "obs1", "status": "final", "code": {"coding": [{"system": "http://loinc.org", "code": "8302-2"}]}},
+			{"resourceType": "Observation", "status": "final", "code": {"text": "no id, so not indexed"}}
+		],
+		"name": [{"family": "Contained"}]
+	}`))
+	util.CheckErr(err)
+	ctx, db := m.MongoSearcher.ctx, m.MongoSearcher.db
+	_, err = db.Collection("patients").InsertOne(ctx, patient)
+	util.CheckErr(err)
+	util.CheckErr(IndexContainedResources(ctx, db, patient))
+	defer func() {
+		util.CheckErr(m.Session.DB("fhir-test").C("patients").RemoveId("5d0b5bdf00000000000000c1"))
+		util.CheckErr(RemoveContainedResources(ctx, db, "Patient", "5d0b5bdf00000000000000c1"))
+	}()
+
+	count, err := m.Session.DB("fhir-test").C(ContainedCollection).Count()
+	util.CheckErr(err)
+	c.Assert(count, Equals, 2)
+
+	// Contained resources aren't searched by default
+	q := Query{"Medication", "code=1049221"}
+	results, total, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(total, Equals, uint32(0))
+	c.Assert(results, HasLen, 0)
+
+	// Their containers are returned by default
+	q = Query{"Medication", "code=1049221&_contained=true"}
+	results, total, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(total, Equals, uint32(1))
+	c.Assert(results, HasLen, 1)
+	c.Assert(results[0].ResourceType(), Equals, "Patient")
+	c.Assert(results[0].Id(), Equals, "5d0b5bdf00000000000000c1")
+
+	// Or the contained resources themselves, identified within their containers
+	q = Query{"Medication", "code=1049221&_contained=true&_containedType=contained"}
+	results, total, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(total, Equals, uint32(1))
+	c.Assert(results, HasLen, 1)
+	c.Assert(results[0].ResourceType(), Equals, "Medication")
+	container, containedID, ok := SplitContainedID(results[0].Id())
+	c.Assert(ok, Equals, true)
+	c.Assert(container, Equals, "Patient/5d0b5bdf00000000000000c1")
+	c.Assert(containedID, Equals, "med1")
+
+	// Only contained resources of the searched type match
+	q = Query{"Observation", "code=1049221&_contained=true"}
+	results, total, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(total, Equals, uint32(0))
+	c.Assert(results, HasLen, 0)
+
+	// With both, the contained resources follow the others
+	q = Query{"Observation", "_contained=both&_containedType=contained"}
+	results, total, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(total, Equals, uint32(8))
+	c.Assert(results, HasLen, 8)
+	c.Assert(results[7].Id(), Equals, "Patient/5d0b5bdf00000000000000c1#obs1")
+
+	q = Query{"Observation", "_contained=both&_containedType=contained&_count=5&_offset=5"}
+	results, total, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(total, Equals, uint32(8))
+	c.Assert(results, HasLen, 3)
+	c.Assert(results[2].Id(), Equals, "Patient/5d0b5bdf00000000000000c1#obs1")
+
+	// Indexing them again replaces them
+	util.CheckErr(IndexContainedResources(ctx, db, patient))
+	count, err = m.Session.DB("fhir-test").C(ContainedCollection).Count()
+	util.CheckErr(err)
+	c.Assert(count, Equals, 2)
+}
+
+func (m *MongoSearchSuite) TestCompartmentSearch(c *C) {
+	q := Query{"Observation", "_compartment=Patient/4954037118555241963"}
+	results, total, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(total, Equals, uint32(5))
+	c.Assert(results, HasLen, 5)
+
+	// Other search parameters further limit the results
+	q = Query{"Observation", "_compartment=Patient/4954037118555241963&_id=7045604479745586371,7045604579745586371"}
+	results, total, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(total, Equals, uint32(1))
+	c.Assert(results[0].Id(), Equals, "7045604479745586371")
+
+	// The patient is in its own compartment
+	q = Query{"Patient", "_compartment=Patient/4954037118555241963"}
+	results, total, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(total, Equals, uint32(1))
+	c.Assert(results[0].Id(), Equals, "4954037118555241963")
+
+	// Matches of any of the compartment's search parameters are only found once
+	q = Query{"Condition", "_compartment=Patient/4954037118555241963"}
+	_, total, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(total, Equals, uint32(5))
+
+	// Searches of all resource types search those in the compartment
+	q = Query{"", "_compartment=Patient/4954037118555241963"}
+	results, total, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(total, Equals, uint32(20))
+	c.Assert(results, HasLen, 20)
+
+	q = Query{"", "_compartment=Patient/4954037118555241963&_type=Patient,Encounter"}
+	results, total, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(total, Equals, uint32(5))
+	c.Assert(results[0].ResourceType(), Equals, "Patient")
 }
 
-// Test that unimplemented features PANIC (to ensure people know they are broken)
-func (m *MongoSearchSuite) TestCompositeSearchPanics(c *C) {
-	q := Query{"Group", "characteristic-value=gender$male"}
-	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, createInvalidSearchError("SEARCH_NONE", "Error: no processable search found for Group search parameters \"characteristic-value\""))
-}
+func (m *MongoSearchSuite) TestCompartmentSearchPanics(c *C) {
+	q := Query{"Observation", "_compartment=Unknown/123"}
+	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_compartment\" content is invalid"))
 
-func (m *MongoSearchSuite) TestPrefixedDateSearchPanicsForUnsupportedPrefix(c *C) {
-	q := Query{"Condition", "onset-date=ap2012"}
-	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, createUnsupportedSearchError("MSG_PARAM_INVALID", "Parameter \"onset-date\" content is invalid"))
-}
+	q = Query{"Observation", "_compartment=Patient"}
+	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_compartment\" content is invalid"))
 
-func (m *MongoSearchSuite) TestPrefixedNumberSearchPanicsForUnsupportedPrefix(c *C) {
-	q := Query{"Immunization", "dose-sequence=sa1"}
-	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, createUnsupportedSearchError("MSG_PARAM_INVALID", "Parameter \"dose-sequence\" content is invalid"))
+	q = Query{"Organization", "_compartment=Patient/123"}
+	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Organization resources aren't in the Patient compartment"))
 }
 
-func (m *MongoSearchSuite) TestPrefixedQuantitySearchPanicsForUnsupportedPrefix(c *C) {
-	c.Skip("quantity search without system not supported yet - see createQuantityQueryObject")
-	q := Query{"Observation", "value-quantity=sa1||mg"}
-	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, createUnsupportedSearchError("MSG_PARAM_INVALID", "Parameter \"value-quantity\" content is invalid"))
-	q = Query{"Observation", "value-quantity=ne1||mg"}
-	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, createUnsupportedSearchError("MSG_PARAM_INVALID", "Parameter \"value-quantity\" content is invalid"))
-}
+func (m *MongoSearchSuite) TestResourceCompartments(c *C) {
+	resource, err := models2.NewResourceFromJsonBytes([]byte(`{
+		"resourceType": "Encounter",
+		"id": "5d0b5bdf00000000000000e2",
+		"subject": {"reference": "Patient/123"},
+		"participant": [
+			{"individual": {"reference": "Practitioner/456"}},
+			{"individual": {"reference": "RelatedPerson/789"}},
+			{"individual": {"reference": "Practitioner/456"}}
+		],
+		"serviceProvider": {"reference": "Organization/1"}
+	}`))
+	util.CheckErr(err)
+	compartments, err := ResourceCompartments(resource)
+	util.CheckErr(err)
+	c.Assert(compartments, DeepEquals, []string{"Encounter/5d0b5bdf00000000000000e2", "Patient/123", "Practitioner/456", "RelatedPerson/789"})
 
-func (m *MongoSearchSuite) TestModifierSearchPanics(c *C) {
-	q := Query{"Condition", "code:text=headache"}
-	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, createUnsupportedSearchError("MSG_PARAM_MODIFIER_INVALID", "Parameter \"code\" modifier is invalid"))
+	// References are those stored, i.e. after a transaction rewrites them
+	resource, err = models2.NewResourceFromJsonBytes([]byte(`{"resourceType": "Observation", "subject": {"reference": "urn:uuid:61ebe359-bfdc-4613-8bf2-c5e300945f0a"}}`))
+	util.CheckErr(err)
+	resource.SetTransformReferencesMap(map[string]string{"urn:uuid:61ebe359-bfdc-4613-8bf2-c5e300945f0a": "Patient/123"})
+	compartments, err = ResourceCompartments(resource)
+	util.CheckErr(err)
+	c.Assert(compartments, DeepEquals, []string{"Patient/123"})
+
+	resource, err = models2.NewResourceFromJsonBytes([]byte(`{"resourceType": "Organization", "id": "1"}`))
+	util.CheckErr(err)
+	compartments, err = ResourceCompartments(resource)
+	util.CheckErr(err)
+	c.Assert(compartments, DeepEquals, []string{})
 }
 
-func (m *MongoSearchSuite) TestUnsupportedSearchResultParameterPanics(c *C) {
-	q := Query{"Condition", "_contained=true"}
-	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, createUnsupportedSearchError("MSG_PARAM_UNKNOWN", "Parameter \"_contained\" not understood"))
+func (m *MongoSearchSuite) TestPrecomputedCompartmentSearch(c *C) {
+	observation, err := models2.NewResourceFromJsonBytes([]byte(`{
+		"resourceType": "Observation",
+		"id": "5d0b5bdf00000000000000e3",
+		"status": "final",
+		"code": {"text": "Weight"},
+		"subject": {"reference": "Patient/4954037118555241963"}
+	}`))
+	util.CheckErr(err)
+	util.CheckErr(SetResourceCompartments(observation))
+	_, err = m.MongoSearcher.db.Collection("observations").InsertOne(m.MongoSearcher.ctx, observation)
+	util.CheckErr(err)
+	m.MongoSearcher.SetPrecomputedCompartments(true)
+	defer func() {
+		m.MongoSearcher.SetPrecomputedCompartments(false)
+		util.CheckErr(m.Session.DB("fhir-test").C("observations").RemoveId("5d0b5bdf00000000000000e3"))
+	}()
+
+	q := Query{"Observation", "_compartment=Patient/4954037118555241963"}
+	c.Assert(m.MongoSearcher.createQueryObject(q), DeepEquals, bson.M{"_compartments": "Patient/4954037118555241963"})
+
+	// Only resources stored with their compartments are found
+	results, total, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(total, Equals, uint32(1))
+	c.Assert(results[0].Id(), Equals, "5d0b5bdf00000000000000e3")
+
+	q = Query{"Observation", "_compartment=Patient/4954037118555579315"}
+	_, total, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(total, Equals, uint32(0))
 }
 
-func (m *MongoSearchSuite) TestUsupportedGlobalSearchParameterPanics(c *C) {
-	q := Query{"Condition", "_text=diabetes"}
-	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, createUnsupportedSearchError("MSG_PARAM_UNKNOWN", "Parameter \"_text\" not understood"))
+func (m *MongoSearchSuite) TestSystemSearch(c *C) {
+	// The 2 patients are followed by the 7 observations
+	q := Query{"", "_type=Patient,Observation"}
+	c.Assert(q.IsSystemSearch(), Equals, true)
+	results, total, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(total, Equals, uint32(9))
+	c.Assert(results, HasLen, 9)
+	c.Assert(results[0].ResourceType(), Equals, "Patient")
+	c.Assert(results[1].ResourceType(), Equals, "Patient")
+	c.Assert(results[2].ResourceType(), Equals, "Observation")
+
+	// Pages are split between the types
+	q = Query{"", "_type=Patient,Observation&_count=3&_offset=1"}
+	results, total, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(total, Equals, uint32(9))
+	c.Assert(results, HasLen, 3)
+	c.Assert(results[0].ResourceType(), Equals, "Patient")
+	c.Assert(results[1].ResourceType(), Equals, "Observation")
+	c.Assert(results[2].ResourceType(), Equals, "Observation")
+
+	q = Query{"", "_type=Patient,Observation&_count=5&_offset=5"}
+	results, total, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(total, Equals, uint32(9))
+	c.Assert(results, HasLen, 4)
+	c.Assert(results[0].ResourceType(), Equals, "Observation")
+
+	q = Query{"", "_type=Observation,Patient&_summary=count"}
+	results, total, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(total, Equals, uint32(9))
+	c.Assert(results, HasLen, 0)
+
+	q = Query{"", "_type=Patient,Observation&_id=4954037118555241963"}
+	results, total, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(total, Equals, uint32(1))
+	c.Assert(results, HasLen, 1)
+	c.Assert(results[0].Id(), Equals, "4954037118555241963")
+
+	// Without _type every resource type is searched
+	q = Query{"", "_id=4954037118555241963"}
+	results, total, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(total, Equals, uint32(1))
+	c.Assert(results, HasLen, 1)
+	c.Assert(results[0].ResourceType(), Equals, "Patient")
+}
+
+func (m *MongoSearchSuite) TestSystemSearchPanics(c *C) {
+	// Search parameters must be supported by every type
+	q := Query{"", "_type=Patient,Observation&gender=male"}
+	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, withParamSuggestions(createInvalidSearchError("SEARCH_NONE", "Error: no processable search found for Observation search parameters \"gender\""), "Observation", "gender"))
+
+	q = Query{"", "_type=Patient,Unknown"}
+	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_type\" content is invalid"))
+
+	q = Query{"", "_type=Patient&_include=Patient:organization"}
+	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, createUnsupportedSearchError("MSG_PARAM_INVALID", "Parameter \"_include\" is not supported when searching all resource types"))
+
+	// _type is only used when searching all resource types
+	q = Query{"Patient", "_type=Patient"}
+	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, withParamSuggestions(createUnsupportedSearchError("MSG_PARAM_UNKNOWN", "Parameter \"_type\" not understood"), "Patient", "_type"))
+}
+
+func (m *MongoSearchSuite) TestGraphSearch(c *C) {
+	graph, err := models2.NewResourceFromJsonBytes([]byte(`{
+		"resourceType": "GraphDefinition",
+		"id": "5d0b5bdf00000000000000e1",
+		"url": "http://example.com/GraphDefinition/patient-record",
+		"name": "PatientRecord",
+		"status": "active",
+		"start": "Patient",
+		"link": [
+			{"path": "Patient.managingOrganization", "target": [{"type": "Organization"}]},
+			{"target": [{"type": "Condition", "params": "patient={ref}", "link": [
+				{"path": "Condition.context", "target": [{"type": "Encounter"}]}
+			]}]},
+			{"target": [{"type": "Encounter", "params": "patient={ref}"}]}
+		]
+	}`))
+	util.CheckErr(err)
+	_, err = m.MongoSearcher.db.Collection("graphdefinitions").InsertOne(m.MongoSearcher.ctx, graph)
+	util.CheckErr(err)
+	defer func() {
+		util.CheckErr(m.Session.DB("fhir-test").C("graphdefinitions").RemoveId("5d0b5bdf00000000000000e1"))
+	}()
+
+	// The graph's links become includes, with those of linked resources iterating
+	q, err := m.MongoSearcher.withGraphIncludes(Query{"Patient", "gender=male&_graph=5d0b5bdf00000000000000e1"}, "5d0b5bdf00000000000000e1")
+	util.CheckErr(err)
+	o := q.Options()
+	c.Assert(o.Graph, Equals, "")
+	c.Assert(o.Include, HasLen, 2)
+	c.Assert(o.Include[0].Resource, Equals, "Patient")
+	c.Assert(o.Include[0].Parameter.Name, Equals, "organization")
+	c.Assert(o.Include[0].Iterate, Equals, false)
+	c.Assert(o.Include[1].Resource, Equals, "Condition")
+	c.Assert(o.Include[1].Parameter.Name, Equals, "context")
+	c.Assert(o.Include[1].Iterate, Equals, true)
+	c.Assert(o.RevInclude, HasLen, 2)
+	c.Assert(o.RevInclude[0].Resource, Equals, "Condition")
+	c.Assert(o.RevInclude[0].Parameter.Name, Equals, "patient")
+	c.Assert(o.RevInclude[1].Resource, Equals, "Encounter")
+	c.Assert(o.RevInclude[1].Parameter.Name, Equals, "patient")
+
+	// Graphs can be referred to by id or canonical URL
+	for _, graphRef := range []string{"GraphDefinition/5d0b5bdf00000000000000e1", "http://example.com/GraphDefinition/patient-record"} {
+		q = Query{"Patient", "gender=male&_graph=" + url.QueryEscape(graphRef)}
+		results, total, err := m.MongoSearcher.Search(q)
+		util.CheckErr(err)
+		c.Assert(total, Equals, uint32(1))
+		c.Assert(results, HasLen, 1)
+		c.Assert(results[0].Id(), Equals, "4954037118555241963")
+		c.Assert(results[0].SearchIncludesOfType("Condition"), HasLen, 5)
+		c.Assert(results[0].SearchIncludesOfType("Encounter"), HasLen, 4)
+	}
+
+	// Graphs must start at the searched resource
+	q = Query{"Condition", "_graph=5d0b5bdf00000000000000e1"}
+	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_graph\" refers to a GraphDefinition that doesn't start at Condition"))
+
+	q = Query{"Patient", "_graph=unknown"}
+	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_graph\" refers to an unknown GraphDefinition"))
+}
+
+func (m *MongoSearchSuite) TestGraphIncludeParams(c *C) {
+	var params URLQueryParameters
+	links := []graphLink{{Target: []graphTarget{{Type: "Observation", Params: "subject={ref}"}}}}
+	util.CheckErr(addGraphIncludeParams(&params, "Patient", links, false, make(map[string]bool)))
+	c.Assert(params.All(), DeepEquals, []URLQueryParameter{{Key: RevIncludeParam, Value: "Observation:subject:Patient"}})
+
+	// Links to the same resources are only included once
+	links = append(links, links[0])
+	params = URLQueryParameters{}
+	util.CheckErr(addGraphIncludeParams(&params, "Patient", links, false, make(map[string]bool)))
+	c.Assert(params.All(), HasLen, 1)
+
+	// Only reference parameters (that may refer to the source) can link resources
+	for _, target := range []graphTarget{
+		{Type: "Observation", Params: "code=1234"},
+		{Type: "Observation", Params: "subject={ref}&code=1234"},
+		{Type: "Observation", Params: "subject=Patient/123"},
+		{Type: "Observation", Params: "performer={ref}&_count=1"},
+		{Type: "Unknown", Params: "subject={ref}"},
+	} {
+		err := addGraphIncludeParams(&URLQueryParameters{}, "Patient", []graphLink{{Target: []graphTarget{target}}}, false, make(map[string]bool))
+		c.Assert(err, NotNil)
+	}
+	err := addGraphIncludeParams(&URLQueryParameters{}, "Patient", []graphLink{{Path: "Patient.name", Target: []graphTarget{{Type: "Organization"}}}}, false, make(map[string]bool))
+	c.Assert(err, NotNil)
+	err = addGraphIncludeParams(&URLQueryParameters{}, "Patient", []graphLink{{Path: "Patient.managingOrganization", Target: []graphTarget{{Type: "Practitioner"}}}}, false, make(map[string]bool))
+	c.Assert(err, NotNil)
 }
 
 func (m *MongoSearchSuite) TestDisableTotalCount(c *C) {
@@ -2840,13 +4573,92 @@ func (m *MongoSearchSuite) TestCacheSearchCount(c *C) {
 	c.Assert(results, NotNil)
 
 	// Check that the total was cached.
-	cc := &CountCache{}
+	cc := &CachedCount{}
 	err = db.C("countcache").FindId(expectedHash).One(cc)
 	util.CheckErr(err)
 	c.Assert(cc.Id, Equals, expectedHash)
 	c.Assert(cc.Count, Equals, uint32(1))
 }
 
+func (m *MongoSearchSuite) TestCountCacheInvalidation(c *C) {
+	db := m.Session.DB("fhir-test")
+	db.C("countcache").RemoveAll(nil)
+	searcher := NewMongoSearcherForUri(m.MongoUri, db.Name, true, true, false, false) // countTotalResults = true, enableCISearches = true, readonly = false
+	defer searcher.Close()
+	searcher.SetCountCache(NewMongoCountCache(searcher.db, time.Minute, 0), true)
+
+	q := Query{"Device", "manufacturer=Acme"}
+	expectedHash := fmt.Sprintf("%x", md5.Sum([]byte("Device?manufacturer=Acme")))
+	_, total, err := searcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(total, Equals, uint32(1))
+
+	cc := &CachedCount{}
+	util.CheckErr(db.C("countcache").FindId(expectedHash).One(cc))
+	c.Assert(cc.Count, Equals, uint32(1))
+	c.Assert(cc.Resources, DeepEquals, []string{"Device"})
+
+	// Changes to other resources don't affect the count
+	util.CheckErr(searcher.getCountCache().Invalidate(context.Background(), "Patient"))
+	n, err := db.C("countcache").FindId(expectedHash).Count()
+	util.CheckErr(err)
+	c.Assert(n, Equals, 1)
+
+	util.CheckErr(searcher.getCountCache().Invalidate(context.Background(), "Device"))
+	n, err = db.C("countcache").FindId(expectedHash).Count()
+	util.CheckErr(err)
+	c.Assert(n, Equals, 0)
+}
+
+func (m *MongoSearchSuite) TestCountCacheResources(c *C) {
+	c.Assert(countCacheResources(Query{"Condition", "code=123&_count=10&_sort=onset"}), DeepEquals, []string{"Condition"})
+	c.Assert(countCacheResources(Query{"Condition", "patient.gender=male"}), DeepEquals, []string{"Condition", "*"})
+	c.Assert(countCacheResources(Query{"Patient", "_has:Observation:patient:code=1234-5"}), DeepEquals, []string{"Patient", "*"})
+	c.Assert(countCacheResources(Query{"Condition", "code:in=http://example.org/ValueSet/a"}), DeepEquals, []string{"Condition", "*"})
+}
+
+func (m *MongoSearchSuite) TestCountCacheExpiry(c *C) {
+	db := m.Session.DB("fhir-test")
+	db.C("countcache").RemoveAll(nil)
+	searcher := NewMongoSearcherForUri(m.MongoUri, db.Name, true, true, false, true) // countTotalResults = true, enableCISearches = true, readonly = true
+	defer searcher.Close()
+	searcher.SetCountCache(NewMongoCountCache(searcher.db, time.Minute, 0), false)
+
+	// An expired total isn't used (and is evicted)
+	expiredHash := fmt.Sprintf("%x", md5.Sum([]byte("Device?manufacturer=Acme")))
+	util.CheckErr(db.C("countcache").Insert(&CachedCount{Id: expiredHash, Count: 42, Resources: []string{"Device"}, Created: time.Now().Add(-time.Hour)}))
+
+	_, total, err := searcher.Search(Query{"Device", "manufacturer=Acme"})
+	util.CheckErr(err)
+	c.Assert(total, Equals, uint32(1))
+
+	cc := &CachedCount{}
+	util.CheckErr(db.C("countcache").FindId(expiredHash).One(cc))
+	c.Assert(cc.Count, Equals, uint32(1))
+}
+
+func (m *MongoSearchSuite) TestCountCacheMaxEntries(c *C) {
+	db := m.Session.DB("fhir-test")
+	db.C("countcache").RemoveAll(nil)
+	searcher := NewMongoSearcherForUri(m.MongoUri, db.Name, true, true, false, true) // countTotalResults = true, enableCISearches = true, readonly = true
+	defer searcher.Close()
+	searcher.SetCountCache(NewMongoCountCache(searcher.db, 0, 2), false)
+
+	for _, query := range []string{"gender=male", "gender=female", "name=Donald"} {
+		_, _, err := searcher.Search(Query{"Patient", query})
+		util.CheckErr(err)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The oldest total was evicted
+	n, err := db.C("countcache").Count()
+	util.CheckErr(err)
+	c.Assert(n, Equals, 2)
+	n, err = db.C("countcache").FindId(fmt.Sprintf("%x", md5.Sum([]byte("Patient?gender=male")))).Count()
+	util.CheckErr(err)
+	c.Assert(n, Equals, 0)
+}
+
 func (m *MongoSearchSuite) TestSummaryCount(c *C) {
 	q := Query{"Patient", "_summary=count"}
 	results, total, err := m.MongoSearcher.Search(q)
@@ -2868,6 +4680,409 @@ func (m *MongoSearchSuite) TestSummaryCountWithCountsDisabled(c *C) {
 	c.Assert(total, Equals, uint32(2))
 }
 
+func (m *MongoSearchSuite) TestTotalNone(c *C) {
+	q := Query{"Patient", "_total=none"}
+	results, total, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 2)
+	c.Assert(total, Equals, uint32(0))
+}
+
+func (m *MongoSearchSuite) TestTotalWithCountsDisabled(c *C) {
+	// _total=accurate and _total=estimate should return a count, even if counts are disabled.
+	db := m.Session.DB("fhir-test")
+	searcher := NewMongoSearcherForUri(m.MongoUri, db.Name, false, true, false, false) // countTotalResults = false, enableCISearches = true, readonly = false
+	defer searcher.Close()
+
+	q := Query{"Patient", "gender=male"}
+	results, total, err := searcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 1)
+	c.Assert(total, Equals, uint32(0))
+
+	q = Query{"Patient", "gender=male&_total=accurate"}
+	results, total, err = searcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 1)
+	c.Assert(total, Equals, uint32(1))
+
+	// The estimate is only used when searching the entire collection
+	q = Query{"Patient", "_total=estimate"}
+	results, total, err = searcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 2)
+	c.Assert(total, Equals, uint32(2))
+
+	q = Query{"Patient", "gender=male&_total=estimate"}
+	_, total, err = searcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(total, Equals, uint32(1))
+}
+
+func (m *MongoSearchSuite) TestCancelledSearchPanics(c *C) {
+	// A search whose request has gone away should be reported as interrupted
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	searcher := NewMongoSearcher(m.MongoSearcher.db, ctx, true, true, false, false) // countTotalResults = true, enableCISearches = true, readonly = false
+	searcher.SetMaxTime(time.Minute)
+
+	q := Query{"Patient", "gender=male"}
+	c.Assert(func() { searcher.Search(q) }, Panics, createOpInterruptedError("Long-running operation interrupted"))
+
+	q = Query{"Condition", "patient.gender=male"}
+	c.Assert(func() { searcher.Search(q) }, Panics, createOpInterruptedError("Long-running operation interrupted"))
+}
+
+func (m *MongoSearchSuite) TestSearchWithMaxTime(c *C) {
+	m.MongoSearcher.SetMaxTime(time.Minute)
+	defer m.MongoSearcher.SetMaxTime(0)
+
+	q := Query{"Patient", "gender=male"}
+	results, total, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 1)
+	c.Assert(total, Equals, uint32(1))
+
+	q = Query{"Condition", "patient.gender=male"}
+	_, _, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+}
+
+func (m *MongoSearchSuite) TestLowercaseFieldsQuery(c *C) {
+	m.MongoSearcher.SetLowercaseFields(true)
+	defer m.MongoSearcher.SetLowercaseFields(false)
+
+	bsonQuery := m.MongoSearcher.convertToBSON(Query{"Patient", "gender=Male"})
+	c.Assert(bsonQuery.Query, DeepEquals, bson.M{"gender__lower": "male"})
+
+	bsonQuery = m.MongoSearcher.convertToBSON(Query{"Patient", "gender=Male&_include=Patient:organization"})
+	c.Assert(bsonQuery.Pipeline[0], DeepEquals, bson.M{"$match": bson.M{"gender__lower": "male"}})
+
+	// IDs are always case-sensitive
+	bsonQuery = m.MongoSearcher.convertToBSON(Query{"Patient", "_id=Abc"})
+	c.Assert(bsonQuery.Query, DeepEquals, bson.M{"_id": "Abc"})
+}
+
+func (m *MongoSearchSuite) TestMatchLowercaseFields(c *C) {
+	query := bson.M{
+		"$or": []bson.M{
+			{"name.family": lowercaseRegexMatch("^%s", "Mc.")},
+			{"code.coding": bson.M{"$elemMatch": bson.M{
+				"system": lowercaseExactMatch("http://LOINC.org"),
+				"code":   bson.M{"$in": []interface{}{lowercaseExactMatch("ABC"), lowercaseExactMatch("Def")}},
+			}}},
+		},
+		"status": "final",
+	}
+	c.Assert(matchLowercaseFields(query), DeepEquals, bson.M{
+		"$or": []bson.M{
+			{"name.family__lower": primitive.Regex{Pattern: `^mc\.`}},
+			{"code.coding": bson.M{"$elemMatch": bson.M{
+				"system__lower": "http://loinc.org",
+				"code__lower":   bson.M{"$in": []interface{}{"abc", "def"}},
+			}}},
+		},
+		"status": "final",
+	})
+}
+
+func (m *MongoSearchSuite) TestCollationForStringSorts(c *C) {
+	collation := &moptions.Collation{Locale: "fr"}
+	m.MongoSearcher.SetCollation(collation)
+	defer m.MongoSearcher.SetCollation(nil)
+
+	bsonQuery := m.MongoSearcher.convertToBSON(Query{"Patient", "_sort=family"})
+	c.Assert(bsonQuery.Collation, Equals, collation)
+
+	// Only searches sorted by strings use a case-sensitive collation
+	bsonQuery = m.MongoSearcher.convertToBSON(Query{"Patient", "gender=male&_sort=birthdate"})
+	c.Assert(bsonQuery.Collation, IsNil)
+
+	results, total, err := m.MongoSearcher.Search(Query{"Patient", "_sort=family"})
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, int(total))
+}
+
+func (m *MongoSearchSuite) TestCollationForCaseInsensitiveSearches(c *C) {
+	collation := &moptions.Collation{Locale: "en", Strength: 2}
+	m.MongoSearcher.SetCollation(collation)
+	defer m.MongoSearcher.SetCollation(nil)
+
+	// The collation ignores case, so whole strings are matched exactly rather than using regexes
+	bsonQuery := m.MongoSearcher.convertToBSON(Query{"Patient", "gender=Male"})
+	c.Assert(bsonQuery.Query, DeepEquals, bson.M{"gender": "Male"})
+	c.Assert(bsonQuery.Collation, Equals, collation)
+
+	results, total, err := m.MongoSearcher.Search(Query{"Patient", "gender=Male"})
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 1)
+	c.Assert(total, Equals, uint32(1))
+}
+
+func (m *MongoSearchSuite) TestSearchShape(c *C) {
+	c.Assert(SearchShape(Query{"Observation", "date=ge2019&code=1234-5&_count=10&_sort=date"}), Equals, "Observation?code,date")
+	c.Assert(SearchShape(Query{"Observation", "code:not=1234-5&date=ge2019&date=lt2020"}), Equals, "Observation?code,date")
+	c.Assert(SearchShape(Query{"Condition", "patient.gender=male"}), Equals, "Condition?patient")
+	c.Assert(SearchShape(Query{"Patient", "_count=10"}), Equals, "Patient?")
+}
+
+func (m *MongoSearchSuite) TestSearchWithIndexHints(c *C) {
+	hints := make(IndexHints)
+	hints.Add("Patient", []string{"gender"}, bson.D{{Key: "_id", Value: 1}})
+	hints.Add("Patient", []string{"gender", "birthdate"}, "no_such_index")
+	m.MongoSearcher.SetIndexHints(hints)
+	defer m.MongoSearcher.SetIndexHints(nil)
+
+	q := Query{"Patient", "gender=male&_count=5"}
+	c.Assert(m.MongoSearcher.convertToBSON(q).Hint, DeepEquals, bson.D{{Key: "_id", Value: 1}})
+	results, total, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 1)
+	c.Assert(total, Equals, uint32(1))
+
+	// Searches of other shapes aren't hinted
+	q = Query{"Patient", "name=Donald"}
+	c.Assert(m.MongoSearcher.convertToBSON(q).Hint, IsNil)
+
+	// MongoDB rejects hints of indexes that don't exist, showing that the hint is used
+	q = Query{"Patient", "birthdate=1940&gender=male"}
+	_, _, err = m.MongoSearcher.Search(q)
+	c.Assert(err, NotNil)
+}
+
+func (m *MongoSearchSuite) TestRunWithCount(c *C) {
+	c.Assert(m.MongoSearcher.countsInParallel(), Equals, true)
+	c.Assert(NewMongoSearcher(nil, nil, true, true, false, false).countsInParallel(), Equals, false)
+
+	searched := false
+	total, err := m.MongoSearcher.runWithCount(true, func(ctx context.Context) (uint32, error) {
+		return 7, nil
+	}, func(ctx context.Context) error {
+		searched = true
+		return nil
+	})
+	util.CheckErr(err)
+	c.Assert(total, Equals, uint32(7))
+	c.Assert(searched, Equals, true)
+
+	// A failed count cancels the search
+	countErr := errors.New("count failed")
+	_, err = m.MongoSearcher.runWithCount(true, func(ctx context.Context) (uint32, error) {
+		return 0, countErr
+	}, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	c.Assert(err, Equals, countErr)
+
+	// Without a count only the search is run
+	total, err = m.MongoSearcher.runWithCount(false, func(ctx context.Context) (uint32, error) {
+		c.Fatal("unexpected count")
+		return 0, nil
+	}, func(ctx context.Context) error {
+		return nil
+	})
+	util.CheckErr(err)
+	c.Assert(total, Equals, uint32(0))
+}
+
+func (m *MongoSearchSuite) TestIsInterrupted(c *C) {
+	c.Assert(m.MongoSearcher.isInterrupted(mongo.CommandError{Code: 11601, Message: "operation was interrupted"}), Equals, true)
+	c.Assert(m.MongoSearcher.isInterrupted(mongo.CommandError{Code: 50, Message: "operation exceeded time limit"}), Equals, true)
+	c.Assert(m.MongoSearcher.isInterrupted(mongo.CommandError{Code: 2, Message: "bad value"}), Equals, false)
+	c.Assert(m.MongoSearcher.isInterrupted(errors.New("connection refused")), Equals, false)
+	c.Assert(m.MongoSearcher.isInterrupted(context.DeadlineExceeded), Equals, true)
+}
+
+func (m *MongoSearchSuite) TestExplain(c *C) {
+	explanation, err := m.MongoSearcher.Explain(Query{"Condition", "code=http://snomed.info/sct|123641001&_count=5"})
+	util.CheckErr(err)
+	c.Assert(explanation.Resource, Equals, "Condition")
+	c.Assert(explanation.DebugString, Matches, "Resource: Condition; Query: .*")
+
+	var command bson.M
+	util.CheckErr(json.Unmarshal(explanation.Command, &command))
+	c.Assert(command["find"], Equals, "conditions")
+	c.Assert(command["limit"], Equals, float64(5))
+
+	var explain bson.M
+	util.CheckErr(json.Unmarshal(explanation.Explain, &explain))
+	c.Assert(explain["queryPlanner"], NotNil)
+	c.Assert(explain["executionStats"], NotNil)
+
+	// Queries using a pipeline explain the aggregation
+	explanation, err = m.MongoSearcher.Explain(Query{"Condition", "patient.gender=male"})
+	util.CheckErr(err)
+	command = nil
+	util.CheckErr(json.Unmarshal(explanation.Command, &command))
+	c.Assert(command["aggregate"], Equals, "conditions")
+	c.Assert(command["pipeline"], NotNil)
+}
+
+func (m *MongoSearchSuite) TestKeysetPaging(c *C) {
+	m.MongoSearcher.SetKeysetPaging(true)
+	defer m.MongoSearcher.SetKeysetPaging(false)
+
+	q := Query{"Patient", "_sort=gender&_count=1"}
+	results, total, next, err := m.MongoSearcher.SearchPage(q)
+	util.CheckErr(err)
+	c.Assert(results, HasLen, 1)
+	c.Assert(total, Equals, uint32(2))
+	c.Assert(results[0].Id(), Equals, "4954037118555579315")
+	c.Assert(next, NotNil)
+	c.Assert(next.Value, Equals, "female")
+
+	q = Query{"Patient", "_sort=gender&_count=1&_cursor=" + next.Encode()}
+	results, total, next, err = m.MongoSearcher.SearchPage(q)
+	util.CheckErr(err)
+	c.Assert(results, HasLen, 1)
+	c.Assert(total, Equals, uint32(2))
+	c.Assert(results[0].Id(), Equals, "4954037118555241963")
+	c.Assert(next, NotNil)
+
+	q = Query{"Patient", "_sort=gender&_count=1&_cursor=" + next.Encode()}
+	results, _, next, err = m.MongoSearcher.SearchPage(q)
+	util.CheckErr(err)
+	c.Assert(results, HasLen, 0)
+	c.Assert(next, IsNil)
+}
+
+func (m *MongoSearchSuite) TestKeysetPagingIsNotUsedWithOffset(c *C) {
+	m.MongoSearcher.SetKeysetPaging(true)
+	defer m.MongoSearcher.SetKeysetPaging(false)
+
+	q := Query{"Patient", "_count=1&_offset=1"}
+	results, _, next, err := m.MongoSearcher.SearchPage(q)
+	util.CheckErr(err)
+	c.Assert(results, HasLen, 1)
+	c.Assert(next, IsNil)
+}
+
+func (m *MongoSearchSuite) TestKeysetQueryObject(c *C) {
+	o := &QueryOptions{Cursor: &PageCursor{ID: "123"}}
+	createKeysetSort("Patient", o)
+	c.Assert(createKeysetQueryObject(o), DeepEquals, bson.M{"_id": bson.M{"$gt": "123"}})
+
+	o = &QueryOptions{Cursor: &PageCursor{Value: "female", ID: "123"}}
+	o.Sort = []SortOption{SortOption{Descending: true, Parameter: SearchParameters()["Patient"]["gender"]}}
+	createKeysetSort("Patient", o)
+	c.Assert(o.Sort, HasLen, 2)
+	c.Assert(o.Sort[1].Descending, Equals, true)
+	c.Assert(createKeysetQueryObject(o), DeepEquals, bson.M{"$or": []bson.M{
+		bson.M{"gender": bson.M{"$lt": "female"}},
+		bson.M{"gender": "female", "_id": bson.M{"$lt": "123"}},
+		bson.M{"gender": nil},
+	}})
+}
+
+func (m *MongoSearchSuite) TestResultSetPaging(c *C) {
+	q := Query{"Patient", "_sort=gender&_count=1"}
+	id, err := m.MongoSearcher.CreateResultSet(q, time.Minute, 0)
+	util.CheckErr(err)
+	c.Assert(id, Not(Equals), "")
+
+	q = q.WithResultSet(id)
+	results, total, next, err := m.MongoSearcher.SearchPage(q)
+	util.CheckErr(err)
+	c.Assert(results, HasLen, 1)
+	c.Assert(total, Equals, uint32(2))
+	c.Assert(results[0].Id(), Equals, "4954037118555579315")
+	c.Assert(next, IsNil)
+
+	q = Query{"Patient", "_sort=gender&_count=1&_offset=1&_resultset=" + id}
+	results, total, _, err = m.MongoSearcher.SearchPage(q)
+	util.CheckErr(err)
+	c.Assert(results, HasLen, 1)
+	c.Assert(total, Equals, uint32(2))
+	c.Assert(results[0].Id(), Equals, "4954037118555241963")
+
+	// Results that fit on the first page aren't stored
+	id, err = m.MongoSearcher.CreateResultSet(Query{"Patient", "_count=2"}, time.Minute, 0)
+	util.CheckErr(err)
+	c.Assert(id, Equals, "")
+
+	// Nor are results beyond the maximum
+	id, err = m.MongoSearcher.CreateResultSet(Query{"Patient", "_count=1"}, time.Minute, 1)
+	util.CheckErr(err)
+	c.Assert(id, Equals, "")
+}
+
+func (m *MongoSearchSuite) TestResultSetPagingIsConsistent(c *C) {
+	// Results deleted since the snapshot are left out, and the others keep the stored order
+	resultSets := m.Session.DB("fhir-test").C(ResultSetsCollection)
+	util.CheckErr(resultSets.Insert(&ResultSet{
+		Id:       "5d0b5bdf0000000000000001",
+		Resource: "Patient",
+		IDs:      []string{"4954037118555241963", "deleted", "4954037118555579315"},
+		Expires:  time.Now().Add(time.Minute),
+	}))
+	defer func() { util.CheckErr(resultSets.RemoveId("5d0b5bdf0000000000000001")) }()
+
+	q := Query{"Patient", "_count=3&_resultset=5d0b5bdf0000000000000001"}
+	results, total, _, err := m.MongoSearcher.SearchPage(q)
+	util.CheckErr(err)
+	c.Assert(total, Equals, uint32(3))
+	c.Assert(results, HasLen, 2)
+	c.Assert(results[0].Id(), Equals, "4954037118555241963")
+	c.Assert(results[1].Id(), Equals, "4954037118555579315")
+
+	// Result sets only apply to their own resource
+	q = Query{"Condition", "_resultset=5d0b5bdf0000000000000001"}
+	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_resultset\" refers to an unknown or expired result set"))
+
+	q = Query{"Patient", "_resultset=abc"}
+	c.Assert(func() { m.MongoSearcher.Search(q) }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_resultset\" content is invalid"))
+}
+
+func (m *MongoSearchSuite) TestSummaryProjections(c *C) {
+	c.Assert(createProjection("Observation", &QueryOptions{Summary: "text"}), DeepEquals, bson.M{
+		"_id":          1,
+		"resourceType": 1,
+		"meta":         1,
+		"text":         1,
+		"status":       1,
+		"code":         1,
+	})
+	c.Assert(createProjection("Observation", &QueryOptions{Summary: "data"}), DeepEquals, bson.M{"text": 0})
+	c.Assert(createProjection("Observation", &QueryOptions{Summary: "false"}), IsNil)
+	c.Assert(createProjection("Observation", &QueryOptions{Summary: "count"}), IsNil)
+
+	projection := createProjection("Patient", &QueryOptions{Summary: "true"})
+	c.Assert(projection["name"], Equals, 1)
+	c.Assert(projection["deceasedBoolean"], Equals, 1)
+	c.Assert(projection["photo"], IsNil)
+	c.Assert(projection["text"], IsNil)
+
+	// _elements takes precedence
+	projection = createProjection("Patient", &QueryOptions{Summary: "true", Elements: []string{"photo"}})
+	c.Assert(projection, DeepEquals, bson.M{"_id": 1, "resourceType": 1, "meta": 1, "photo": 1})
+}
+
+func (m *MongoSearchSuite) TestSummaryTrueAndText(c *C) {
+	q := Query{"Patient", "gender=male&_summary=true"}
+	results, _, err := m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 1)
+
+	var patient models.Patient
+	util.CheckErr(results[0].Unmarshal(&patient))
+	c.Assert(patient.Id, Equals, "4954037118555241963")
+	c.Assert(patient.Name, HasLen, 1)
+	c.Assert(patient.Gender, Equals, "male")
+
+	// Patients have no mandatory elements, so only the id remains
+	q = Query{"Patient", "gender=male&_summary=text"}
+	results, _, err = m.MongoSearcher.Search(q)
+	util.CheckErr(err)
+	c.Assert(len(results), Equals, 1)
+
+	patient = models.Patient{}
+	util.CheckErr(results[0].Unmarshal(&patient))
+	c.Assert(patient.Id, Equals, "4954037118555241963")
+	c.Assert(patient.Name, HasLen, 0)
+	c.Assert(patient.Gender, Equals, "")
+}
+
 // Test internally used functions
 
 func (m *MongoSearchSuite) TestBuildBsonForCompositeCriteriaAndPathWithArrayAncestor(c *C) {
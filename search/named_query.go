@@ -0,0 +1,66 @@
+package search
+
+import (
+	"fmt"
+)
+
+// NamedQueryParam represents the _query search parameter.  The following
+// description is from the FHIR STU3 specification:
+//
+// The _query parameter names a custom search profile that describes a
+// specific client-server search behavior, e.g. "_query=current-high-risk".
+// The named query's own parameters (as defined by its OperationDefinition)
+// are then passed to it.
+//
+// Named queries are registered using Registry.RegisterNamedQuery, and are
+// implemented using MongoRegistry.RegisterNamedQueryBuilder.
+type NamedQueryParam struct {
+	SearchParamInfo
+	Query      NamedQuery
+	Parameters URLQueryParameters // The values of the named query's parameters
+}
+
+func (n *NamedQueryParam) getInfo() SearchParamInfo {
+	return n.SearchParamInfo
+}
+
+func (n *NamedQueryParam) setInfo(info SearchParamInfo) {
+	n.SearchParamInfo = info
+}
+
+func (n *NamedQueryParam) getQueryParamAndValue() (string, string) {
+	return queryParamAndValue(n.SearchParamInfo, n.Query.Name)
+}
+
+// hasParameter checks if a parameter is one of the named query's own parameters.
+func (n *NamedQueryParam) hasParameter(param string) bool {
+	for _, p := range n.Query.Parameters {
+		if p == param {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseNamedQueryParam looks up the named query invoked by the _query
+// parameter and returns a pointer to a NamedQueryParam holding the values of
+// the named query's parameters.
+func ParseNamedQueryParam(resource string, queryParams URLQueryParameters) *NamedQueryParam {
+	name := getSingletonParamValue(QueryParam, queryParams.GetMulti(QueryParam))
+	query, err := GlobalRegistry().LookupNamedQuery(name)
+	if err != nil || (query.Resource != "" && query.Resource != resource) {
+		panic(createUnsupportedSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", QueryParam)))
+	}
+
+	n := &NamedQueryParam{
+		SearchParamInfo: SearchParamInfo{Resource: resource, Name: QueryParam, Type: "query"},
+		Query:           query,
+	}
+	for _, queryParam := range queryParams.All() {
+		param, _, _ := ParseParamNameModifierAndPostFix(queryParam.Key)
+		if n.hasParameter(param) {
+			n.Parameters.Add(queryParam.Key, queryParam.Value)
+		}
+	}
+	return n
+}
@@ -0,0 +1,17 @@
+package search
+
+// nearSearchParameter is Location's near parameter, which is "special" in FHIR, so the generated
+// SearchParameterDictionary lists it without any paths.  It matches the GeoJSON point stored with each Location that
+// has a position (see models2.ConvertJsonToGoFhirBSON).
+var nearSearchParameter = SearchParamInfo{
+	Resource: "Location",
+	Name:     "near",
+	Type:     "special",
+	Paths: []SearchParamPath{
+		SearchParamPath{Path: "__position", Type: "Point"},
+	},
+}
+
+func init() {
+	GlobalRegistry().RegisterParameterInfo(nearSearchParameter)
+}
@@ -0,0 +1,184 @@
+package search
+
+import (
+	"encoding/base64"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PageCursor marks the position of the last result on a page of search results, allowing
+// the next page to be found without skipping over all of the previous results (keyset
+// paging).  Value is the value of the sort field in the last result (nil if the results
+// aren't sorted) and ID is its _id, which breaks ties between results with equal values.
+type PageCursor struct {
+	Value interface{} `bson:"v"`
+	ID    interface{} `bson:"id"`
+}
+
+// Encode returns the cursor as an opaque token suitable for the _cursor parameter.
+func (c *PageCursor) Encode() string {
+	data, err := bson.Marshal(c)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// ParsePageCursor parses a token created by PageCursor.Encode.
+func ParsePageCursor(token string) (*PageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errors.Wrap(err, "ParsePageCursor: invalid encoding")
+	}
+	cursor := &PageCursor{}
+	if err := bson.Unmarshal(data, cursor); err != nil {
+		return nil, errors.Wrap(err, "ParsePageCursor: invalid content")
+	}
+	if cursor.ID == nil {
+		return nil, errors.New("ParsePageCursor: missing id")
+	}
+	// Resources' ids are strings, and the cursor's values are used in queries, so they mustn't be operators
+	if _, ok := cursor.ID.(string); !ok {
+		return nil, errors.New("ParsePageCursor: invalid id")
+	}
+	return cursor, nil
+}
+
+// SupportsKeysetPaging checks if the results can be paged using a PageCursor.  This requires
+// the results to be unsorted (in which case they are ordered by _id) or sorted by a single
+// field that can't have multiple values, since MongoDB sorts arrays by their smallest (or
+// largest) element, which a cursor can't reliably resume from.  Relevance (_score) isn't
+// stored, so it can't be resumed from either.
+func (o *QueryOptions) SupportsKeysetPaging() bool {
+	if o.Summary == "count" {
+		return false
+	}
+	if len(o.Sort) == 0 {
+		return true
+	}
+	if len(o.Sort) > 1 || len(o.Sort[0].Parameter.Paths) != 1 || o.IsSubsetted() || o.sortsByTextScore() {
+		return false
+	}
+	return !strings.Contains(o.Sort[0].Parameter.Paths[0].Path, "[")
+}
+
+// createKeysetSort adds an _id sort to the query options, so that results sharing the same
+// sort value are always returned in the same order.
+func createKeysetSort(resource string, o *QueryOptions) {
+	desc := len(o.Sort) > 0 && o.Sort[0].Descending
+	o.Sort = append(o.Sort, SortOption{Descending: desc, Parameter: SearchParameters()[resource][IDParam]})
+}
+
+// createKeysetQueryObject returns the criteria matching the results that come after the
+// cursor, given query options that have been sorted with createKeysetSort.
+func createKeysetQueryObject(o *QueryOptions) bson.M {
+	c := o.Cursor
+	idOp := "$gt"
+	if o.Sort[0].Descending {
+		idOp = "$lt"
+	}
+	if len(o.Sort) == 1 {
+		// only sorted by _id
+		return bson.M{"_id": bson.M{idOp: c.ID}}
+	}
+
+	// The value is matched for equality, where a document of operators would be evaluated
+	if !isSortKeyValue(c.Value, o.Sort[0].Parameter.Type) {
+		panic(createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_cursor\" content is invalid"))
+	}
+
+	field := convertSearchPathToMongoField(o.Sort[0].Parameter.Paths[0].Path)
+	sameValue := bson.M{field: c.Value, "_id": bson.M{idOp: c.ID}}
+
+	// Missing (null) values are sorted before any other values
+	switch {
+	case c.Value == nil && o.Sort[0].Descending:
+		return sameValue
+	case c.Value == nil:
+		return bson.M{"$or": []bson.M{
+			bson.M{field: bson.M{"$ne": nil}},
+			sameValue,
+		}}
+	case o.Sort[0].Descending:
+		return bson.M{"$or": []bson.M{
+			bson.M{field: bson.M{"$lt": c.Value}},
+			sameValue,
+			bson.M{field: nil},
+		}}
+	default:
+		return bson.M{"$or": []bson.M{
+			bson.M{field: bson.M{"$gt": c.Value}},
+			sameValue,
+		}}
+	}
+}
+
+// isSortKeyValue checks if a cursor's value could be the value of the field sorted on by a parameter of a type.
+// Dates, decimals and quantities are stored as documents (e.g. the range of a date), which mustn't contain operators.
+func isSortKeyValue(value interface{}, paramType string) bool {
+	switch value.(type) {
+	case nil:
+		return true
+	case string:
+		return paramType != "date" && paramType != "number"
+	case bool:
+		return paramType == "token"
+	case int32, int64, float64, primitive.Decimal128:
+		return paramType == "number" || paramType == "quantity"
+	case primitive.DateTime:
+		return paramType == "date"
+	case bson.D:
+		return (paramType == "date" || paramType == "number" || paramType == "quantity") && isLiteralDocument(value)
+	}
+	return false
+}
+
+// isLiteralDocument checks if a value is a scalar, or a document of them without operators
+func isLiteralDocument(value interface{}) bool {
+	switch v := value.(type) {
+	case nil, string, bool, int32, int64, float64, primitive.Decimal128, primitive.DateTime:
+		return true
+	case bson.D:
+		for _, e := range v {
+			if strings.HasPrefix(e.Key, "$") || !isLiteralDocument(e.Value) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// createNextPageCursor returns a cursor positioned after the given document (the last
+// result on a page), given query options that have been sorted with createKeysetSort.
+func createNextPageCursor(document bson.D, o *QueryOptions) *PageCursor {
+	cursor := &PageCursor{ID: lookupDocumentField(document, "_id")}
+	if len(o.Sort) > 1 {
+		field := convertSearchPathToMongoField(o.Sort[0].Parameter.Paths[0].Path)
+		cursor.Value = lookupDocumentField(document, field)
+	}
+	return cursor
+}
+
+// lookupDocumentField returns the value of a (dot-separated) field in a document, or nil if
+// the document doesn't have that field.
+func lookupDocumentField(document bson.D, field string) interface{} {
+	var value interface{} = document
+	for _, key := range strings.Split(field, ".") {
+		doc, ok := value.(bson.D)
+		if !ok {
+			return nil
+		}
+		value = nil
+		for _, e := range doc {
+			if e.Key == key {
+				value = e.Value
+				break
+			}
+		}
+	}
+	return value
+}
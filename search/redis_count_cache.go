@@ -0,0 +1,142 @@
+package search
+
+import (
+	"context"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+// RedisCountCache caches the totals of searches in Redis, so that several servers
+// (e.g. horizontally scaled readonly replicas) can share them.  Each total is stored
+// under its key (with a prefix) and added to a set of the keys that depend on each of
+// its resource types, which is used to invalidate them.  The size of the cache is bounded
+// by the Redis server's maxmemory and eviction policy (e.g. volatile-lru).
+type RedisCountCache struct {
+	pool   *redis.Pool
+	prefix string
+	ttl    time.Duration
+}
+
+// NewRedisCountCache creates a cache of the totals of searches in Redis.  The prefix is added to
+// all of its keys, so it should be different for each database (e.g. "fhir:countcache:<db>:").
+// Cached totals expire after ttl (0 for no expiry).
+func NewRedisCountCache(pool *redis.Pool, prefix string, ttl time.Duration) *RedisCountCache {
+	return &RedisCountCache{
+		pool:   pool,
+		prefix: prefix,
+		ttl:    ttl,
+	}
+}
+
+// NewRedisPool creates a pool of connections to the Redis server at a URL, e.g. redis://localhost:6379/0
+func NewRedisPool(url string) *redis.Pool {
+	return &redis.Pool{
+		MaxIdle:     10,
+		IdleTimeout: 5 * time.Minute,
+		Dial: func() (redis.Conn, error) {
+			return redis.DialURL(url)
+		},
+		TestOnBorrow: func(conn redis.Conn, lastUsed time.Time) error {
+			if time.Since(lastUsed) < time.Minute {
+				return nil
+			}
+			_, err := conn.Do("PING")
+			return err
+		},
+	}
+}
+
+func (rc *RedisCountCache) totalKey(key string) string {
+	return rc.prefix + key
+}
+
+func (rc *RedisCountCache) resourceKey(resourceType string) string {
+	return rc.prefix + "resource:" + resourceType
+}
+
+// Get returns the cached total with the given key, if it has one
+func (rc *RedisCountCache) Get(ctx context.Context, key string) (total uint32, found bool, err error) {
+	conn := rc.pool.Get()
+	defer conn.Close()
+
+	count, err := redis.Uint64(conn.Do("GET", rc.totalKey(key)))
+	if err == redis.ErrNil {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, errors.Wrap(err, "RedisCountCache.Get")
+	}
+	return uint32(count), true, nil
+}
+
+// Set caches a total with the given key
+func (rc *RedisCountCache) Set(ctx context.Context, key string, total uint32, resources []string) error {
+	conn := rc.pool.Get()
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	if rc.ttl > 0 {
+		conn.Send("SET", rc.totalKey(key), total, "PX", int64(rc.ttl/time.Millisecond))
+	} else {
+		conn.Send("SET", rc.totalKey(key), total)
+	}
+	for _, resourceType := range resources {
+		conn.Send("SADD", rc.resourceKey(resourceType), key)
+		if rc.ttl > 0 {
+			// The set is only needed while its totals are cached
+			conn.Send("PEXPIRE", rc.resourceKey(resourceType), int64(rc.ttl/time.Millisecond))
+		}
+	}
+	_, err := conn.Do("EXEC")
+	return errors.Wrap(err, "RedisCountCache.Set")
+}
+
+// Invalidate deletes the cached totals that depend on the given resource type
+func (rc *RedisCountCache) Invalidate(ctx context.Context, resourceType string) error {
+	conn := rc.pool.Get()
+	defer conn.Close()
+
+	for _, resourceKey := range []string{rc.resourceKey(resourceType), rc.resourceKey(anyResource)} {
+		keys, err := redis.Strings(conn.Do("SMEMBERS", resourceKey))
+		if err != nil {
+			return errors.Wrap(err, "RedisCountCache.Invalidate: SMEMBERS failed")
+		}
+
+		args := redis.Args{}.Add(resourceKey)
+		for _, key := range keys {
+			args = args.Add(rc.totalKey(key))
+		}
+		_, err = conn.Do("DEL", args...)
+		if err != nil {
+			return errors.Wrap(err, "RedisCountCache.Invalidate: DEL failed")
+		}
+	}
+	return nil
+}
+
+// Clear deletes all of the cached totals (e.g. those of a database that's been dropped)
+func (rc *RedisCountCache) Clear(ctx context.Context) error {
+	conn := rc.pool.Get()
+	defer conn.Close()
+
+	cursor := "0"
+	for {
+		reply, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", rc.prefix+"*", "COUNT", 1000))
+		if err != nil {
+			return errors.Wrap(err, "RedisCountCache.Clear: SCAN failed")
+		}
+		var keys []string
+		if _, err := redis.Scan(reply, &cursor, &keys); err != nil {
+			return errors.Wrap(err, "RedisCountCache.Clear: SCAN failed")
+		}
+		if len(keys) > 0 {
+			if _, err := conn.Do("DEL", redis.Args{}.AddFlat(keys)...); err != nil {
+				return errors.Wrap(err, "RedisCountCache.Clear: DEL failed")
+			}
+		}
+		if cursor == "0" {
+			return nil
+		}
+	}
+}
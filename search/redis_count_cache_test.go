@@ -0,0 +1,113 @@
+package search
+
+import (
+	"context"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+type RedisCountCacheSuite struct {
+	pool *redis.Pool
+}
+
+var _ = Suite(&RedisCountCacheSuite{})
+
+func (s *RedisCountCacheSuite) SetUpSuite(c *C) {
+	s.pool = NewRedisPool("redis://localhost:6379/15")
+	conn := s.pool.Get()
+	defer conn.Close()
+	if _, err := conn.Do("PING"); err != nil {
+		c.Skip("Redis isn't available: " + err.Error())
+	}
+}
+
+func (s *RedisCountCacheSuite) TearDownSuite(c *C) {
+	s.pool.Close()
+}
+
+func (s *RedisCountCacheSuite) SetUpTest(c *C) {
+	conn := s.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("FLUSHDB")
+	util.CheckErr(err)
+}
+
+func (s *RedisCountCacheSuite) TestGetAndSet(c *C) {
+	cache := NewRedisCountCache(s.pool, "fhir:countcache:test:", time.Minute)
+	ctx := context.Background()
+
+	_, found, err := cache.Get(ctx, "abc")
+	util.CheckErr(err)
+	c.Assert(found, Equals, false)
+
+	util.CheckErr(cache.Set(ctx, "abc", 42, []string{"Patient"}))
+	total, found, err := cache.Get(ctx, "abc")
+	util.CheckErr(err)
+	c.Assert(found, Equals, true)
+	c.Assert(total, Equals, uint32(42))
+
+	// Caches with other prefixes (i.e. for other databases) are separate
+	other := NewRedisCountCache(s.pool, "fhir:countcache:other:", time.Minute)
+	_, found, err = other.Get(ctx, "abc")
+	util.CheckErr(err)
+	c.Assert(found, Equals, false)
+}
+
+func (s *RedisCountCacheSuite) TestExpiry(c *C) {
+	cache := NewRedisCountCache(s.pool, "fhir:countcache:test:", 50*time.Millisecond)
+	ctx := context.Background()
+
+	util.CheckErr(cache.Set(ctx, "abc", 42, []string{"Patient"}))
+	time.Sleep(100 * time.Millisecond)
+	_, found, err := cache.Get(ctx, "abc")
+	util.CheckErr(err)
+	c.Assert(found, Equals, false)
+}
+
+func (s *RedisCountCacheSuite) TestInvalidate(c *C) {
+	cache := NewRedisCountCache(s.pool, "fhir:countcache:test:", time.Minute)
+	ctx := context.Background()
+
+	util.CheckErr(cache.Set(ctx, "patients", 1, []string{"Patient"}))
+	util.CheckErr(cache.Set(ctx, "devices", 2, []string{"Device"}))
+	util.CheckErr(cache.Set(ctx, "chained", 3, []string{"Condition", "*"}))
+
+	util.CheckErr(cache.Invalidate(ctx, "Patient"))
+
+	_, found, err := cache.Get(ctx, "patients")
+	util.CheckErr(err)
+	c.Assert(found, Equals, false)
+	_, found, err = cache.Get(ctx, "chained")
+	util.CheckErr(err)
+	c.Assert(found, Equals, false)
+	total, found, err := cache.Get(ctx, "devices")
+	util.CheckErr(err)
+	c.Assert(found, Equals, true)
+	c.Assert(total, Equals, uint32(2))
+}
+
+func (s *RedisCountCacheSuite) TestClear(c *C) {
+	cache := NewRedisCountCache(s.pool, "fhir:countcache:test:", time.Minute)
+	other := NewRedisCountCache(s.pool, "fhir:countcache:other:", time.Minute)
+	ctx := context.Background()
+	util.CheckErr(cache.Set(ctx, "abc", 1, []string{"Patient"}))
+	util.CheckErr(cache.Set(ctx, "def", 2, []string{"*"}))
+	util.CheckErr(other.Set(ctx, "abc", 3, []string{"Patient"}))
+
+	util.CheckErr(cache.Clear(ctx))
+	_, found, err := cache.Get(ctx, "abc")
+	util.CheckErr(err)
+	c.Assert(found, Equals, false)
+	_, found, err = cache.Get(ctx, "def")
+	util.CheckErr(err)
+	c.Assert(found, Equals, false)
+
+	// Caches with other prefixes aren't cleared
+	total, found, err := other.Get(ctx, "abc")
+	util.CheckErr(err)
+	c.Assert(found, Equals, true)
+	c.Assert(total, Equals, uint32(3))
+}
@@ -3,6 +3,7 @@ package search
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
 )
 
 var registry *Registry
@@ -14,10 +15,20 @@ func GlobalRegistry() *Registry {
 		registry = new(Registry)
 		registry.infos = make(map[string]map[string]SearchParamInfo)
 		registry.parsers = make(map[string]ParameterParser)
+		registry.queries = make(map[string]NamedQuery)
+		registry.params.Store(SearchParameterDictionary)
 	})
 	return registry
 }
 
+// SearchParameters returns the search parameters of each resource type, i.e. those of the SearchParameterDictionary and
+// those registered with the GlobalRegistry.  Registrations replace rather than modify the returned map, so it can be
+// read while parameters are being registered (e.g. SearchParameter resources, while searches are running), but it must
+// not be modified.
+func SearchParameters() map[string]map[string]SearchParamInfo {
+	return GlobalRegistry().params.Load().(map[string]map[string]SearchParamInfo)
+}
+
 // Registry supports the registration and lookup of FHIR search parameters, both standard and custom.  For custom
 // search parameters, a parameter type implementation may also need to be registered.
 type Registry struct {
@@ -25,6 +36,10 @@ type Registry struct {
 	infos       map[string]map[string]SearchParamInfo
 	parsersLock sync.RWMutex
 	parsers     map[string]ParameterParser
+	queriesLock sync.RWMutex
+	queries     map[string]NamedQuery
+	// params holds the map returned by SearchParameters, which is copied on write (under infosLock)
+	params atomic.Value
 }
 
 // RegisterParameterInfo registers search param info for a given resource and name (as represented in the info).  If the
@@ -40,13 +55,19 @@ func (r *Registry) RegisterParameterInfo(param SearchParamInfo) {
 	}
 	rMap[param.Name] = param
 
-	// For now, also register in SearchParameterDictionary
-	rMap, ok = SearchParameterDictionary[param.Resource]
-	if !ok {
-		rMap = make(map[string]SearchParamInfo)
-		SearchParameterDictionary[param.Resource] = rMap
+	// Also add it to a copy of the search parameters, which searches may be reading
+	params := r.params.Load().(map[string]map[string]SearchParamInfo)
+	updated := make(map[string]map[string]SearchParamInfo, len(params)+1)
+	for resource, resourceParams := range params {
+		updated[resource] = resourceParams
 	}
-	rMap[param.Name] = param
+	resourceParams := make(map[string]SearchParamInfo, len(params[param.Resource])+1)
+	for name, info := range params[param.Resource] {
+		resourceParams[name] = info
+	}
+	resourceParams[param.Name] = param
+	updated[param.Resource] = resourceParams
+	r.params.Store(updated)
 }
 
 // LookupParameterInfo looks up search parameter info by resource and name.  If no parameter info is registered, it will
@@ -81,3 +102,30 @@ func (r *Registry) LookupParameterParser(paramType string) (parser ParameterPars
 
 // ParameterParser parses search parameter data into a SearchParam implementation.
 type ParameterParser func(info SearchParamInfo, data SearchParamData) (SearchParam, error)
+
+// RegisterNamedQuery registers a named query, which can then be invoked using the _query parameter.  A BSON builder for
+// the query should also be registered in the MongoRegistry.
+func (r *Registry) RegisterNamedQuery(query NamedQuery) {
+	r.queriesLock.Lock()
+	defer r.queriesLock.Unlock()
+	r.queries[query.Name] = query
+}
+
+// LookupNamedQuery looks up a named query by name.  If no named query is registered, it will return an error.
+func (r *Registry) LookupNamedQuery(name string) (query NamedQuery, err error) {
+	r.queriesLock.RLock()
+	defer r.queriesLock.RUnlock()
+	q, ok := r.queries[name]
+	if !ok {
+		return NamedQuery{}, fmt.Errorf("Could not find named query %s", name)
+	}
+	return q, nil
+}
+
+// NamedQuery describes a custom named query (an OperationDefinition of kind "query"), invoked using _query=[name].
+// The values of its Parameters are passed to its implementation rather than being treated as search parameters.
+type NamedQuery struct {
+	Name       string
+	Resource   string // The resource type searched by the query, or empty for any
+	Parameters []string
+}
@@ -1,6 +1,8 @@
 package search
 
 import (
+	"fmt"
+
 	"github.com/pebbe/util"
 	. "gopkg.in/check.v1"
 )
@@ -22,6 +24,26 @@ func (s *RegistrySuite) TestRegisterAndLookupParameterInfo(c *C) {
 	c.Assert(obtained, DeepEquals, info)
 }
 
+func (s *RegistrySuite) TestRegisterParameterInfoWhileSearching(c *C) {
+	// Registrations (e.g. of SearchParameter resources) don't modify the parameters that searches are reading
+	params := SearchParameters()
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			GlobalRegistry().RegisterParameterInfo(SearchParamInfo{Resource: "Blah", Name: fmt.Sprintf("bar-%d", i), Type: "string"})
+		}
+		close(done)
+	}()
+	for i := 0; i < 100; i++ {
+		_ = SearchParameters()["Patient"]["name"]
+	}
+	<-done
+
+	_, ok := params["Blah"]["bar-99"]
+	c.Assert(ok, Equals, false)
+	c.Assert(SearchParameters()["Blah"]["bar-99"].Name, Equals, "bar-99")
+}
+
 func (s *RegistrySuite) TestLookupNonExistingParameterInfo(c *C) {
 	obtained, err := GlobalRegistry().LookupParameterInfo("Foo", "Bar")
 	c.Assert(err, Not(IsNil))
@@ -54,3 +76,22 @@ func (s *RegistrySuite) TestLookupNonExistingParameterParser(c *C) {
 	c.Assert(err, Not(IsNil))
 	c.Assert(obtained, IsNil)
 }
+
+func (s *RegistrySuite) TestRegisterAndLookupNamedQuery(c *C) {
+	query := NamedQuery{
+		Name:       "test-query",
+		Resource:   "Patient",
+		Parameters: []string{"foo"},
+	}
+
+	GlobalRegistry().RegisterNamedQuery(query)
+	obtained, err := GlobalRegistry().LookupNamedQuery("test-query")
+	util.CheckErr(err)
+	c.Assert(obtained, DeepEquals, query)
+}
+
+func (s *RegistrySuite) TestLookupNonExistingNamedQuery(c *C) {
+	obtained, err := GlobalRegistry().LookupNamedQuery("nope")
+	c.Assert(err, Not(IsNil))
+	c.Assert(obtained, DeepEquals, NamedQuery{}) // Zero Object
+}
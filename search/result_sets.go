@@ -0,0 +1,218 @@
+package search
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/eug48/fhir/models2"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ResultSetsCollection is the collection in which CreateResultSet stores snapshots of search results
+const ResultSetsCollection = "resultsets"
+
+// DefaultResultSetMaxResults is the default maximum number of results a ResultSet may store
+const DefaultResultSetMaxResults = 10000
+
+// resultSetIDRegex matches the ids of result sets (hex ObjectIds)
+var resultSetIDRegex = regexp.MustCompile("^[0-9a-f]{24}$")
+
+// ResultSet is a snapshot of the results of a search: the _ids of its matches in the order they
+// were found.  Pages of a search with a _resultset are taken from its snapshot, so that paging
+// through the results while resources are created, updated or deleted doesn't skip or repeat any.
+// Result sets are deleted once they expire (using a TTL index on Expires).
+type ResultSet struct {
+	Id       string    `bson:"_id"`
+	Resource string    `bson:"resource"`
+	IDs      []string  `bson:"ids"`
+	Expires  time.Time `bson:"expires"`
+}
+
+// WithResultSet returns a copy of the query whose pages are taken from the given result set
+func (q *Query) WithResultSet(id string) Query {
+	queryParams, _ := ParseQuery(q.Query)
+	queryParams.Set(ResultSetParam, id)
+	return Query{Resource: q.Resource, Query: queryParams.Encode()}
+}
+
+// CreateResultSet stores a snapshot of the _ids of the matches of a query (see ResultSet) that
+// lasts for the given lifetime, and returns its id for the query's _resultset parameter.  Only
+// the results of queries that don't fit on their first page are stored, and only if there are
+// at most maxResults of them (DefaultResultSetMaxResults if 0 or less), otherwise the id is empty.
+func (m *MongoSearcher) CreateResultSet(query Query, lifetime time.Duration, maxResults int) (id string, err error) {
+	if query.IsSystemSearch() {
+		// Each type is searched separately (see searchSystem)
+		return "", nil
+	}
+	options := query.Options()
+	if options.Summary == "count" || options.ResultSet != "" || options.Cursor != nil || options.Offset > 0 || options.Contained == "both" {
+		return "", nil
+	}
+	if options.MaxResults > 0 && options.MaxResults <= options.Count {
+		// Every result that can be returned fits on the first page
+		return "", nil
+	}
+
+	// Only the _ids of the results are needed, ordered consistently by _id after any _sort
+	if maxResults <= 0 {
+		maxResults = DefaultResultSetMaxResults
+	}
+	idOptions := &QueryOptions{Sort: options.Sort, Count: maxResults + 1}
+	createKeysetSort(query.Resource, idOptions)
+	projection := bson.M{"_id": 1}
+	if idOptions.sortsByTextScore() {
+		projection[textScoreField] = textScore
+	}
+
+	bsonQuery := m.convertToBSON(query)
+	c := m.db.Collection(bsonQuery.collectionName())
+	var cursor *mongo.Cursor
+	if bsonQuery.usesPipeline() {
+		pipeline := m.createSearchPipeline(bsonQuery, idOptions)
+		pipeline = append(pipeline, bson.M{"$project": projection})
+		cursor, err = c.Aggregate(m.ctx, pipeline, m.aggregateOptions(bsonQuery).SetAllowDiskUse(true))
+	} else {
+		filter, findOptions := m.createFindFilterAndOptions(bsonQuery, idOptions)
+		cursor, err = c.Find(m.ctx, filter, findOptions.SetProjection(projection))
+	}
+	if err != nil {
+		if m.isInterrupted(err) {
+			panic(createOpInterruptedError("Long-running operation interrupted"))
+		}
+		return "", errors.Wrap(err, "CreateResultSet: search failed")
+	}
+	defer cursor.Close(m.ctx)
+
+	var ids []string
+	for cursor.Next(m.ctx) {
+		var result struct {
+			ID string `bson:"_id"`
+		}
+		if err := cursor.Decode(&result); err != nil {
+			return "", errors.Wrap(err, "CreateResultSet: decoding failed")
+		}
+		ids = append(ids, result.ID)
+	}
+	if err := cursor.Err(); err != nil {
+		if m.isInterrupted(err) {
+			panic(createOpInterruptedError("Long-running operation interrupted"))
+		}
+		return "", errors.Wrap(err, "CreateResultSet: cursor error")
+	}
+
+	if len(ids) <= options.Count {
+		// Every result fits on the first page
+		return "", nil
+	}
+	if len(ids) > maxResults {
+		glog.V(3).Infof("CreateResultSet: not storing the results of %s?%s as there are more than %d", query.Resource, query.Query, maxResults)
+		return "", nil
+	}
+
+	resultSet := &ResultSet{
+		Id:       primitive.NewObjectID().Hex(),
+		Resource: query.Resource,
+		IDs:      ids,
+		Expires:  time.Now().Add(lifetime),
+	}
+	_, err = m.db.Collection(ResultSetsCollection).InsertOne(m.ctx, resultSet)
+	if err != nil {
+		return "", errors.Wrap(err, "CreateResultSet: InsertOne failed")
+	}
+	return resultSet.Id, nil
+}
+
+// lookupResultSet returns a result set of the given resource that hasn't expired
+func (m *MongoSearcher) lookupResultSet(resource, id string) (*ResultSet, error) {
+	filter := bson.M{"_id": id, "resource": resource, "expires": bson.M{"$gt": time.Now()}}
+	resultSet := &ResultSet{}
+	err := m.db.Collection(ResultSetsCollection).FindOne(m.ctx, filter).Decode(resultSet)
+	if err == mongo.ErrNoDocuments {
+		panic(createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_resultset\" refers to an unknown or expired result set"))
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "lookupResultSet: FindOne failed")
+	}
+	return resultSet, nil
+}
+
+// searchResultSet returns a page of the results stored in the result set of a query, in their
+// stored order, and their total.  Resources that have since been deleted are left out, and
+// the others are returned as they are now.
+func (m *MongoSearcher) searchResultSet(query Query, options *QueryOptions) (resources []*models2.Resource, total uint32, err error) {
+	resultSet, err := m.lookupResultSet(query.Resource, options.ResultSet)
+	if err != nil {
+		return nil, 0, err
+	}
+	total = uint32(len(resultSet.IDs))
+	if options.Summary == "count" || options.Offset >= len(resultSet.IDs) || options.Count == 0 {
+		return resources, total, nil
+	}
+
+	end := options.Offset + options.Count
+	if end > len(resultSet.IDs) {
+		end = len(resultSet.IDs)
+	}
+	pageIDs := resultSet.IDs[options.Offset:end]
+
+	// The search criteria have already been applied, so only the page's results are found
+	// (and then ordered as stored) while the other options (e.g. _include) apply as usual
+	bsonQuery := NewBSONQuery(query.Resource)
+	if options.searchesContained() {
+		bsonQuery.Collection = ContainedCollection
+	}
+	idCriteria := bson.M{"_id": bson.M{"$in": pageIDs}}
+	if query.UsesIncludes() || query.UsesRevIncludes() {
+		bsonQuery.Pipeline = []bson.M{{"$match": idCriteria}}
+	} else {
+		bsonQuery.Query = idCriteria
+	}
+	pageOptions := *options
+	pageOptions.Sort = nil
+	pageOptions.Offset = 0
+	pageOptions.Count = len(pageIDs)
+
+	var cursor *mongo.Cursor
+	if bsonQuery.usesPipeline() {
+		cursor, _, err = m.aggregate(bsonQuery, &pageOptions, false)
+	} else {
+		cursor, _, err = m.find(bsonQuery, &pageOptions, false)
+	}
+	if err != nil {
+		if m.isInterrupted(err) {
+			panic(createOpInterruptedError("Long-running operation interrupted"))
+		}
+		return nil, 0, errors.Wrap(err, "Search error")
+	}
+	defer cursor.Close(m.ctx)
+
+	found := make(map[string]*models2.Resource, len(pageIDs))
+	for cursor.Next(m.ctx) {
+		var document bson.D
+		if err := cursor.Decode(&document); err != nil {
+			return nil, 0, errors.Wrap(err, "Search result decoding error")
+		}
+		resource, err := models2.NewResourceFromBSON(document)
+		if err != nil {
+			return nil, 0, errors.Wrap(err, "Search: NewResourceFromBSON failed")
+		}
+		found[resource.Id()] = resource
+	}
+	if err := cursor.Err(); err != nil {
+		if m.isInterrupted(err) {
+			panic(createOpInterruptedError("Long-running operation interrupted"))
+		}
+		return nil, 0, errors.Wrap(err, "Search cursor error")
+	}
+
+	for _, id := range pageIDs {
+		if resource, ok := found[id]; ok {
+			resources = append(resources, resource)
+		}
+	}
+	return resources, total, nil
+}
@@ -0,0 +1,120 @@
+package search
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/eug48/fhir/models2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// SearchIndex is an index that speeds up searches of a resource using one of its search parameters
+type SearchIndex struct {
+	Param string
+	Keys  bson.D
+}
+
+// KeysString returns the index's keys in the format used by indexes.conf, e.g. (code.coding.code_1, code.coding.system_1)
+func (si SearchIndex) KeysString() string {
+	keys := make([]string, len(si.Keys))
+	for i, key := range si.Keys {
+		keys[i] = key.Key + "_1"
+	}
+	return "(" + strings.Join(keys, ", ") + ")"
+}
+
+// RecommendedSearchIndexes derives indexes for searching a resource using its token, date and reference
+// search parameters (see SearchParameters, which includes custom ones registered so far).
+// Tokens are indexed on their code (or value) and system, dates on the __from and __to fields of their
+// ranges and references on their reference__id and reference__type.  Indexes are returned in order of
+// parameter name and those with the same keys as an earlier one (e.g. of another parameter) are omitted.
+// When case-insensitive searches match the lowercase copies of fields (see SetLowercaseFields), those of
+// strings and (unless tokens are case-sensitive) token codes and systems are indexed instead.
+func RecommendedSearchIndexes(resource string, lowercaseStrings, lowercaseTokens bool) []SearchIndex {
+	params := SearchParameters()[resource]
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var indexes []SearchIndex
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if name == "_id" || name == "_lastUpdated" {
+			// _id is always indexed and meta.lastUpdated already has an index of its own
+			continue
+		}
+		info := params[name]
+		for _, path := range info.Paths {
+			keys := searchIndexKeys(info.Type, path, lowercaseStrings, lowercaseTokens)
+			if len(keys) == 0 {
+				continue
+			}
+			index := SearchIndex{Param: name, Keys: keys}
+			if seen[index.KeysString()] {
+				continue
+			}
+			seen[index.KeysString()] = true
+			indexes = append(indexes, index)
+		}
+	}
+	return indexes
+}
+
+// searchIndexKeys returns the keys of an index for a path of a search parameter,
+// or nil if parameters of its type aren't indexed.  Compound keys start with the
+// field that's always searched, so searches without a system or type can use them too.
+func searchIndexKeys(paramType string, path SearchParamPath, lowercaseStrings, lowercaseTokens bool) bson.D {
+	field := convertSearchPathToMongoField(path.Path)
+	keys := func(suffixes ...string) bson.D {
+		d := make(bson.D, len(suffixes))
+		for i, suffix := range suffixes {
+			d[i] = bson.E{Key: field + suffix, Value: int32(1)}
+		}
+		return d
+	}
+	lowercaseKeys := func(lowercase bool, suffixes ...string) bson.D {
+		d := keys(suffixes...)
+		if lowercase {
+			for i := range d {
+				d[i].Key += models2.Gofhir__lower
+			}
+		}
+		return d
+	}
+
+	switch paramType {
+	case "token":
+		switch path.Type {
+		case "Coding":
+			return lowercaseKeys(lowercaseTokens, ".code", ".system")
+		case "CodeableConcept":
+			return lowercaseKeys(lowercaseTokens, ".coding.code", ".coding.system")
+		case "Identifier":
+			return lowercaseKeys(lowercaseTokens, ".value", ".system")
+		case "ContactPoint":
+			return lowercaseKeys(lowercaseStrings, ".value")
+		case "string":
+			return lowercaseKeys(lowercaseStrings, "")
+		case "code":
+			return lowercaseKeys(lowercaseTokens, "")
+		case "boolean", "id":
+			return keys("")
+		}
+	case "date":
+		switch path.Type {
+		case "date", "dateTime":
+			return keys(".__from", ".__to")
+		case "instant":
+			return keys("")
+		case "Period":
+			return keys(".start.__from", ".end.__to")
+		}
+	case "reference":
+		if path.Type == "Reference" {
+			return keys(".reference__id", ".reference__type")
+		}
+	}
+	return nil
+}
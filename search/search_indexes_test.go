@@ -0,0 +1,76 @@
+package search
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	. "gopkg.in/check.v1"
+)
+
+type SearchIndexesSuite struct{}
+
+var _ = Suite(&SearchIndexesSuite{})
+
+func (s *SearchIndexesSuite) TestRecommendedSearchIndexes(c *C) {
+	indexes := make(map[string]string)
+	for _, index := range RecommendedSearchIndexes("Patient", false, false) {
+		indexes[index.Param] = index.KeysString()
+	}
+
+	c.Assert(indexes["gender"], Equals, "(gender_1)")
+	c.Assert(indexes["birthdate"], Equals, "(birthDate.__from_1, birthDate.__to_1)")
+	c.Assert(indexes["identifier"], Equals, "(identifier.value_1, identifier.system_1)")
+	c.Assert(indexes["language"], Equals, "(communication.language.coding.code_1, communication.language.coding.system_1)")
+	c.Assert(indexes["general-practitioner"], Equals, "(generalPractitioner.reference__id_1, generalPractitioner.reference__type_1)")
+	c.Assert(indexes["_tag"], Equals, "(meta.tag.code_1, meta.tag.system_1)")
+
+	// Only token, date and reference parameters are indexed
+	c.Assert(indexes["family"], Equals, "")
+	c.Assert(indexes["name"], Equals, "")
+	// _id and _lastUpdated already have indexes
+	c.Assert(indexes["_id"], Equals, "")
+	c.Assert(indexes["_lastUpdated"], Equals, "")
+}
+
+func (s *SearchIndexesSuite) TestRecommendedSearchIndexesOfLowercaseFields(c *C) {
+	indexes := make(map[string]string)
+	for _, index := range RecommendedSearchIndexes("Patient", true, false) {
+		indexes[index.Param] = index.KeysString()
+	}
+	c.Assert(indexes["gender"], Equals, "(gender_1)")
+	c.Assert(indexes["identifier"], Equals, "(identifier.value_1, identifier.system_1)")
+	c.Assert(indexes["telecom"], Equals, "(telecom.value__lower_1)")
+
+	indexes = make(map[string]string)
+	for _, index := range RecommendedSearchIndexes("Patient", true, true) {
+		indexes[index.Param] = index.KeysString()
+	}
+	c.Assert(indexes["gender"], Equals, "(gender__lower_1)")
+	c.Assert(indexes["identifier"], Equals, "(identifier.value__lower_1, identifier.system__lower_1)")
+	c.Assert(indexes["birthdate"], Equals, "(birthDate.__from_1, birthDate.__to_1)")
+}
+
+func (s *SearchIndexesSuite) TestRecommendedSearchIndexesAreUnique(c *C) {
+	// Observation's code and combo-code parameters search the same field
+	seen := make(map[string]bool)
+	for _, index := range RecommendedSearchIndexes("Observation", false, false) {
+		c.Assert(seen[index.KeysString()], Equals, false, Commentf("duplicate index %s", index.KeysString()))
+		seen[index.KeysString()] = true
+	}
+	c.Assert(seen["(code.coding.code_1, code.coding.system_1)"], Equals, true)
+}
+
+func (s *SearchIndexesSuite) TestRecommendedSearchIndexesOfPeriods(c *C) {
+	for _, index := range RecommendedSearchIndexes("Account", false, false) {
+		if index.Param == "period" {
+			c.Assert(index.Keys, DeepEquals, bson.D{
+				{Key: "period.start.__from", Value: int32(1)},
+				{Key: "period.end.__to", Value: int32(1)},
+			})
+			return
+		}
+	}
+	c.Fatal("Account has no period index")
+}
+
+func (s *SearchIndexesSuite) TestRecommendedSearchIndexesOfUnknownResource(c *C) {
+	c.Assert(RecommendedSearchIndexes("Foo", false, false), HasLen, 0)
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/url"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -19,6 +20,7 @@ const (
 	TextParam          = "_text"
 	ContentParam       = "_content"
 	ListParam          = "_list"
+	CompartmentParam   = "_compartment" // Custom param, not in FHIR spec
 	QueryParam         = "_query"
 	HasParam           = "_has"
 	SortParam          = "_sort"
@@ -27,15 +29,24 @@ const (
 	RevIncludeParam    = "_revinclude"
 	SummaryParam       = "_summary"
 	ElementsParam      = "_elements"
+	TotalParam         = "_total"
+	MaxResultsParam    = "_maxresults"
 	ContainedParam     = "_contained"
 	ContainedTypeParam = "_containedType"
-	OffsetParam        = "_offset" // Custom param, not in FHIR spec
+	GraphParam         = "_graph"
+	TypeParam          = "_type"
+	OffsetParam        = "_offset"    // Custom param, not in FHIR spec
+	CursorParam        = "_cursor"    // Custom param, not in FHIR spec
+	ResultSetParam     = "_resultset" // Custom param, not in FHIR spec
 	FormatParam        = "_format"
+	PrettyParam        = "_pretty"
+	FilterParam        = "_filter"
+	ScoreSort          = "_score" // Sorts by relevance to the _text or _content search
 )
 
 var globalSearchParams = map[string]bool{IDParam: true, LastUpdatedParam: true, TagParam: true,
 	ProfileParam: true, SecurityParam: true, TextParam: true, ContentParam: true, ListParam: true,
-	QueryParam: true, HasParam: true}
+	QueryParam: true, HasParam: true, FilterParam: true, CompartmentParam: true}
 
 func isGlobalSearchParam(param string) bool {
 	_, found := globalSearchParams[param]
@@ -44,7 +55,8 @@ func isGlobalSearchParam(param string) bool {
 
 var searchResultParams = map[string]bool{SortParam: true, CountParam: true, IncludeParam: true,
 	RevIncludeParam: true, SummaryParam: true, ElementsParam: true, ContainedParam: true,
-	ContainedTypeParam: true, OffsetParam: true, FormatParam: true}
+	ContainedTypeParam: true, OffsetParam: true, FormatParam: true, PrettyParam: true, TotalParam: true,
+	CursorParam: true, ResultSetParam: true, MaxResultsParam: true, GraphParam: true}
 
 func isSearchResultParam(param string) bool {
 	_, found := searchResultParams[param]
@@ -65,11 +77,38 @@ type Query struct {
 // resource with the query string "patient=123&onset=2012" should return a
 // slice containing a ReferenceParam (for patient) and a DateParam (for onset).
 func (q *Query) Params() []SearchParam {
+	if q.IsSystemSearch() {
+		return q.systemSearchParams()
+	}
+
 	var results []SearchParam
 	queryParams, _ := ParseQuery(q.Query)
+
+	// A named query's own parameters are passed to it rather than being search parameters
+	var namedQuery *NamedQueryParam
+	if queryParams.Get(QueryParam) != "" {
+		namedQuery = ParseNamedQueryParam(q.Resource, queryParams)
+		results = append(results, namedQuery)
+	}
+
 	for _, queryParam := range queryParams.All() {
 		param, modifier, postfix := ParseParamNameModifierAndPostFix(queryParam.Key)
-		if isSearchResultParam(param) {
+		if isSearchResultParam(param) || (namedQuery != nil && (param == QueryParam || namedQuery.hasParameter(param))) {
+			continue
+		}
+
+		if param == FilterParam {
+			results = append(results, ParseFilterQueryParam(q.Resource, queryParam.Value))
+			continue
+		}
+
+		if param == ListParam {
+			results = append(results, ParseListQueryParam(q.Resource, queryParam.Value))
+			continue
+		}
+
+		if param == CompartmentParam {
+			results = append(results, ParseCompartmentQueryParam(q.Resource, queryParam.Value))
 			continue
 		}
 
@@ -84,7 +123,7 @@ func (q *Query) Params() []SearchParam {
 			// SearchParameterDictionary["Observation"], not SearchParameterDictionary["Patient"]
 			info = createReverseChainedQueryInfo(q.Resource, modifier)
 		} else {
-			info, ok = SearchParameterDictionary[q.Resource][param]
+			info, ok = SearchParameters()[q.Resource][param]
 		}
 
 		if ok {
@@ -94,26 +133,46 @@ func (q *Query) Params() []SearchParam {
 		} else {
 
 			if isGlobalSearchParam(param) {
-				panic(createUnsupportedSearchError("MSG_PARAM_UNKNOWN", fmt.Sprintf("Parameter \"%s\" not understood", param)))
+				panic(withParamSuggestions(createUnsupportedSearchError("MSG_PARAM_UNKNOWN", fmt.Sprintf("Parameter \"%s\" not understood", param)), q.Resource, param))
 			} else {
-				panic(createInvalidSearchError("SEARCH_NONE", fmt.Sprintf("Error: no processable search found for %s search parameters \"%s\"", q.Resource, param)))
+				panic(withParamSuggestions(createInvalidSearchError("SEARCH_NONE", fmt.Sprintf("Error: no processable search found for %s search parameters \"%s\"", q.Resource, param)), q.Resource, param))
 			}
 		}
 	}
 	return results
 }
 
+// hasFullTextSearch checks if the query has a _text or _content search parameter.
+func (q *Query) hasFullTextSearch() bool {
+	queryParams, _ := ParseQuery(q.Query)
+	for _, queryParam := range queryParams.All() {
+		param, modifier, _ := ParseParamNameModifierAndPostFix(queryParam.Key)
+		if (param == TextParam || param == ContentParam) && modifier != "missing" {
+			return true
+		}
+	}
+	return false
+}
+
 // Options parses the query string and returns the QueryOptions.
 func (q *Query) Options() *QueryOptions {
+	if q.IsSystemSearch() {
+		return q.systemSearchOptions()
+	}
+
 	options := NewQueryOptions()
 	queryParams, _ := ParseQuery(q.Query)
 
-	if strings.Contains(q.Query, "_include=*") {
-		options.IsIncludeAll = true
-	}
-
-	if strings.Contains(q.Query, "_revinclude=*") {
-		options.IsRevincludeAll = true
+	for _, queryParam := range queryParams.All() {
+		if queryParam.Value != "*" {
+			continue
+		}
+		switch queryParam.Key {
+		case IncludeParam:
+			options.IsIncludeAll = true
+		case RevIncludeParam:
+			options.IsRevincludeAll = true
+		}
 	}
 
 	for _, queryParam := range queryParams.All() {
@@ -142,12 +201,43 @@ func (q *Query) Options() *QueryOptions {
 				options.Offset = offset
 			}
 
+		case MaxResultsParam:
+			maxResults, err := strconv.Atoi(queryParam.Value)
+			if err != nil || maxResults < 1 {
+				panic(createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_maxresults\" content is invalid"))
+			}
+			options.MaxResults = maxResults
+
+		case GraphParam:
+			// The id (or canonical URL) of a stored GraphDefinition
+			graph := strings.TrimPrefix(queryParam.Value, "GraphDefinition/")
+			if graph == "" {
+				panic(createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_graph\" content is invalid"))
+			}
+			options.Graph = graph
+
+		case CursorParam:
+			cursor, err := ParsePageCursor(queryParam.Value)
+			if err != nil {
+				panic(createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_cursor\" content is invalid"))
+			}
+			options.Cursor = cursor
+
+		case ResultSetParam:
+			if !resultSetIDRegex.MatchString(queryParam.Value) {
+				panic(createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_resultset\" content is invalid"))
+			}
+			options.ResultSet = queryParam.Value
+
 		case SortParam:
 			// The following supports both DSTU2-style sorts and STU3-style sorts
 			keys := strings.Split(queryParam.Value, ",")
 			for _, key := range keys {
 				desc := strings.HasPrefix(key, "-") || modifier == "desc"
-				sortParam, ok := SearchParameterDictionary[q.Resource][strings.TrimPrefix(key, "-")]
+				sortParam, ok := SearchParameters()[q.Resource][strings.TrimPrefix(key, "-")]
+				if strings.TrimPrefix(key, "-") == ScoreSort {
+					sortParam, ok = textScoreSortParameter, q.hasFullTextSearch()
+				}
 				if !ok {
 					panic(createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_sort\" content is invalid"))
 				}
@@ -160,7 +250,8 @@ func (q *Query) Options() *QueryOptions {
 
 		case IncludeParam:
 
-			if options.IsIncludeAll {
+			iterate := isIterateModifier(modifier)
+			if options.IsIncludeAll && !iterate {
 				continue
 			}
 
@@ -168,7 +259,17 @@ func (q *Query) Options() *QueryOptions {
 			if len(incls) < 2 || len(incls) > 3 {
 				panic(createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_include\" content is invalid"))
 			}
-			inclParam, ok := SearchParameterDictionary[incls[0]][incls[1]]
+			if len(incls) == 2 && incls[1] == "*" {
+				// e.g. _include=Observation:* includes all of the resource's references
+				if _, ok := SearchParameters()[incls[0]]; !ok {
+					panic(createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_include\" content is invalid"))
+				}
+				for _, inclParam := range referenceParamsOf(incls[0]) {
+					options.Include = append(options.Include, IncludeOption{Resource: incls[0], Parameter: inclParam, Iterate: iterate})
+				}
+				continue
+			}
+			inclParam, ok := SearchParameters()[incls[0]][incls[1]]
 			if !ok {
 				panic(createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_include\" content is invalid"))
 			}
@@ -184,11 +285,12 @@ func (q *Query) Options() *QueryOptions {
 					panic(createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_include\" content is invalid"))
 				}
 			}
-			options.Include = append(options.Include, IncludeOption{Resource: incls[0], Parameter: inclParam})
+			options.Include = append(options.Include, IncludeOption{Resource: incls[0], Parameter: inclParam, Iterate: iterate})
 
 		case RevIncludeParam:
 
-			if options.IsRevincludeAll {
+			iterate := isIterateModifier(modifier)
+			if options.IsRevincludeAll && !iterate {
 				continue
 			}
 
@@ -196,7 +298,22 @@ func (q *Query) Options() *QueryOptions {
 			if len(incls) < 2 || len(incls) > 3 {
 				panic(createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_revinclude\" content is invalid"))
 			}
-			revInclParam, ok := SearchParameterDictionary[incls[0]][incls[1]]
+			if len(incls) == 2 && incls[1] == "*" {
+				// e.g. _revinclude=Observation:* includes the resource's references to the searched resource
+				if _, ok := SearchParameters()[incls[0]]; !ok {
+					panic(createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_revinclude\" content is invalid"))
+				}
+				for _, revInclParam := range referenceParamsOf(incls[0]) {
+					if iterate {
+						options.RevInclude = append(options.RevInclude, RevIncludeOption{Resource: incls[0], Parameter: revInclParam, Iterate: true})
+					} else if isValidTarget(q.Resource, revInclParam) {
+						revInclParam.Targets = []string{q.Resource}
+						options.RevInclude = append(options.RevInclude, RevIncludeOption{Resource: incls[0], Parameter: revInclParam})
+					}
+				}
+				continue
+			}
+			revInclParam, ok := SearchParameters()[incls[0]][incls[1]]
 			if !ok {
 				panic(createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_revinclude\" content is invalid"))
 			}
@@ -204,6 +321,18 @@ func (q *Query) Options() *QueryOptions {
 			if revInclParam.Type != "reference" {
 				panic(createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_revinclude\" content is invalid"))
 			}
+			if iterate {
+				// An iterative revinclude may reference any of the included resources
+				if len(incls) == 3 {
+					if isValidTarget(incls[2], revInclParam) {
+						revInclParam.Targets = []string{incls[2]}
+					} else {
+						panic(createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_revinclude\" content is invalid"))
+					}
+				}
+				options.RevInclude = append(options.RevInclude, RevIncludeOption{Resource: incls[0], Parameter: revInclParam, Iterate: true})
+				continue
+			}
 			// Only the currently searched on resource is a valid target (or "Any")
 			target := q.Resource
 			if len(incls) == 3 && incls[2] != target && incls[2] != "Any" {
@@ -219,42 +348,110 @@ func (q *Query) Options() *QueryOptions {
 			options.RevInclude = append(options.RevInclude, RevIncludeOption{Resource: incls[0], Parameter: revInclParam})
 
 		case FormatParam:
-			switch (queryParam.Value) {
+			// a + in a MIME type that isn't escaped is decoded as a space
+			format := strings.Replace(queryParam.Value, " ", "+", -1)
+			switch (format) {
 			// Currently we only support JSON and (if enabled) XML
 			// _format is processed closer to the HTTP code rather than here
-			case "json", "application/json", "application/json+fhir", "application/fhir+json":
-			case "xml", "application/xml", "application/xml+fhir", "application/fhir+xml":
+			case "json", "text/json", "application/json", "application/json+fhir", "application/fhir+json":
+			case "xml", "text/xml", "application/xml", "application/xml+fhir", "application/fhir+xml":
 			default:
 				panic(createUnsupportedSearchError("MSG_PARAM_INVALID", "Parameter \"_format\" content is invalid"))
 			}
+			// Remembered so that paging links preserve it
+			options.Format = format
+
+		case PrettyParam:
+			// Like _format this is processed by the HTTP code, and remembered for paging links
+			switch queryParam.Value {
+			case "true":
+				options.Pretty = true
+			case "false":
+			default:
+				panic(createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_pretty\" content is invalid"))
+			}
 
 		case SummaryParam:
-			if queryParam.Value != "count" && queryParam.Value != "false" {
-				// We only support "count", and the default (implicit) setting is "false".
-				panic(createUnsupportedSearchError("MSG_PARAM_INVALID", "Parameter \"_summary\" content is invalid"))
+			switch queryParam.Value {
+			case "true", "text", "data", "count", "false":
+				// "false" is the default (implicit) setting
+				options.Summary = queryParam.Value
+			default:
+				panic(createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_summary\" content is invalid"))
+			}
+
+		case ElementsParam:
+			for _, element := range strings.Split(queryParam.Value, ",") {
+				// Elements may be qualified by the resource type (e.g. "Patient.name")
+				element = strings.TrimPrefix(strings.TrimSpace(element), q.Resource+".")
+				if !elementNameRegex.MatchString(element) {
+					panic(createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_elements\" content is invalid"))
+				}
+				options.Elements = append(options.Elements, element)
+			}
+
+		case ContainedParam:
+			switch queryParam.Value {
+			case "true", "both":
+				options.Contained = queryParam.Value
+			case "false":
+				// the default: contained resources aren't searched
+			default:
+				panic(createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_contained\" content is invalid"))
+			}
+
+		case ContainedTypeParam:
+			switch queryParam.Value {
+			case "container", "contained":
+				options.ContainedType = queryParam.Value
+			default:
+				panic(createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_containedType\" content is invalid"))
+			}
+
+		case TotalParam:
+			switch queryParam.Value {
+			case "none", "estimate", "accurate":
+				options.Total = queryParam.Value
+			default:
+				panic(createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_total\" content is invalid"))
 			}
-			options.Summary = queryParam.Value
 
 		default:
-			panic(createUnsupportedSearchError("MSG_PARAM_UNKNOWN", fmt.Sprintf("Parameter \"%s\" not understood", param)))
+			panic(withParamSuggestions(createUnsupportedSearchError("MSG_PARAM_UNKNOWN", fmt.Sprintf("Parameter \"%s\" not understood", param)), q.Resource, param))
 		}
 	}
 
+	if options.Cursor != nil && !options.SupportsKeysetPaging() {
+		panic(createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_cursor\" cannot be used with the requested _sort or _summary"))
+	}
+	if options.Cursor != nil && options.ResultSet != "" {
+		panic(createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_cursor\" cannot be used with \"_resultset\""))
+	}
+	if options.Cursor != nil && options.Contained != "" {
+		panic(createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_cursor\" cannot be used with \"_contained\""))
+	}
+	if options.Cursor != nil && options.MaxResults > 0 {
+		// A cursor doesn't know how many results came before it
+		panic(createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_cursor\" cannot be used with \"_maxresults\""))
+	}
+
 	if options.IsIncludeAll {
 		// check if this resource has any includes
-		inclParams := SearchParameterDictionary[q.Resource]
-		for _, inclParam := range inclParams {
-			if inclParam.Type == "reference" {
-				options.Include = append(options.Include, IncludeOption{Resource: q.Resource, Parameter: inclParam})
-			}
+		for _, inclParam := range referenceParamsOf(q.Resource) {
+			options.Include = append(options.Include, IncludeOption{Resource: q.Resource, Parameter: inclParam})
 		}
 	}
 
 	if options.IsRevincludeAll {
 		// scan the search parameter dictionary for all revincludes referencing this resource
-		for resource, resourceSearchParams := range SearchParameterDictionary {
-			for _, revInclParam := range resourceSearchParams {
-				if revInclParam.Type == "reference" && contains(revInclParam.Targets, q.Resource) {
+		resources := make([]string, 0, len(SearchParameters()))
+		for resource := range SearchParameters() {
+			resources = append(resources, resource)
+		}
+		sort.Strings(resources)
+		for _, resource := range resources {
+			for _, revInclParam := range referenceParamsOf(resource) {
+				if contains(revInclParam.Targets, q.Resource) {
 					options.RevInclude = append(options.RevInclude, RevIncludeOption{Resource: resource, Parameter: revInclParam})
 				}
 			}
@@ -274,6 +471,11 @@ func (q *Query) UsesRevIncludes() bool {
 	return len(q.Options().RevInclude) > 0
 }
 
+// UsesGraph returns true if the query includes the resources linked by a GraphDefinition (_graph)
+func (q *Query) UsesGraph() bool {
+	return q.Options().Graph != ""
+}
+
 // UsesChainedSearch returns true if the query has any chained search parameters
 func (q *Query) UsesChainedSearch() bool {
 	for _, p := range q.Params() {
@@ -296,7 +498,7 @@ func (q *Query) UsesReverseChainedSearch() bool {
 
 // UsesPipeline returns true if the query requires a pipeline to execute
 func (q *Query) UsesPipeline() bool {
-	return q.UsesIncludes() || q.UsesRevIncludes() || q.UsesChainedSearch() || q.UsesReverseChainedSearch()
+	return q.UsesIncludes() || q.UsesRevIncludes() || q.UsesChainedSearch() || q.UsesReverseChainedSearch() || q.Options().usesComputedSort()
 }
 
 // SupportsPaging returns true if the query results can be paginated, false if not.
@@ -348,6 +550,11 @@ func (q *Query) URLQueryParameters(withOptions bool) URLQueryParameters {
 	for _, param := range q.Params() {
 		k, v := param.getQueryParamAndValue()
 		queryParams.Add(k, v)
+		if n, ok := param.(*NamedQueryParam); ok {
+			for _, p := range n.Parameters.All() {
+				queryParams.Add(p.Key, p.Value)
+			}
+		}
 	}
 
 	if withOptions {
@@ -390,11 +597,94 @@ type QueryOptions struct {
 	IsIncludeAll    bool
 	IsRevincludeAll bool
 	Summary         string
+	Elements        []string
+	Total           string
+	MaxResults      int
+	Contained       string
+	ContainedType   string
+	Graph           string
+	Types           []string
+	Cursor          *PageCursor
+	ResultSet       string
+	Format          string
+	Pretty          bool
+}
+
+// IsSubsetted checks if the _elements or _summary options limit the elements
+// returned, meaning the results are not complete resources.
+func (o *QueryOptions) IsSubsetted() bool {
+	return len(o.Elements) > 0 || o.Summary == "true" || o.Summary == "text" || o.Summary == "data"
+}
+
+// CountsTotal checks if the total number of matches should be computed for the
+// query.  The _total option overrides the server default (countTotalResults), and
+// _summary=count always requires a total.
+func (o *QueryOptions) CountsTotal(countTotalResults bool) bool {
+	switch {
+	case o.Summary == "count":
+		return true
+	case o.Total == "none":
+		return false
+	case o.Total == "estimate", o.Total == "accurate":
+		return true
+	}
+	return countTotalResults
 }
 
-// NewQueryOptions constructs a new QueryOptions with default values (offset = 0, Count = 100)
+// limitToMaxResults reduces the _count of a page of results so that no more than _maxresults
+// results are returned across all of the pages (leaving none for the pages after that)
+func (o *QueryOptions) limitToMaxResults() {
+	if o.MaxResults > 0 && o.Offset+o.Count > o.MaxResults {
+		o.Count = o.MaxResults - o.Offset
+		if o.Count < 0 {
+			o.Count = 0
+		}
+	}
+}
+
+// elementNameRegex matches the names of top-level resource elements, which _elements is limited to
+var elementNameRegex = regexp.MustCompile("^[a-zA-Z][a-zA-Z0-9]*$")
+
+// DefaultCount is the number of results per page of a query that doesn't specify a _count
+const DefaultCount = 100
+
+// NewQueryOptions constructs a new QueryOptions with default values (offset = 0, Count = DefaultCount)
 func NewQueryOptions() *QueryOptions {
-	return &QueryOptions{Offset: 0, Count: 100}
+	return &QueryOptions{Offset: 0, Count: DefaultCount}
+}
+
+// WithCountLimits returns a copy of the query whose _count is defaultCount if it
+// doesn't specify one, or maxCount if it requested more results per page than that.
+// A maxCount of 0 or less means there is no maximum, and a query without a _count is
+// left unchanged if the defaultCount is 0 or less or is the DefaultCount anyway.
+// capped is true if the requested _count was reduced to maxCount.
+func (q *Query) WithCountLimits(defaultCount, maxCount int) (limited Query, capped bool) {
+	queryParams, _ := ParseQuery(q.Query)
+	newParams := URLQueryParameters{}
+	hasCount := false
+	for _, queryParam := range queryParams.All() {
+		if queryParam.Key == CountParam {
+			hasCount = true
+			if count, err := strconv.Atoi(queryParam.Value); err == nil && maxCount > 0 && count > maxCount {
+				queryParam.Value = strconv.Itoa(maxCount)
+				capped = true
+			}
+		}
+		newParams.Add(queryParam.Key, queryParam.Value)
+	}
+
+	if defaultCount <= 0 {
+		defaultCount = DefaultCount
+	}
+	if maxCount > 0 && defaultCount > maxCount {
+		defaultCount = maxCount
+	}
+	if !hasCount && defaultCount != DefaultCount {
+		newParams.Add(CountParam, strconv.Itoa(defaultCount))
+	} else if !capped {
+		return *q, false
+	}
+	return Query{Resource: q.Resource, Query: newParams.Encode()}, capped
 }
 
 // URLQueryParameters returns URLQueryParameters representing the query options.
@@ -419,27 +709,100 @@ func (o *QueryOptions) URLQueryParameters() URLQueryParameters {
 			queryParams.Add(sortParamKey, sort.Parameter.Name)
 		}
 	}
-	queryParams.Set(OffsetParam, strconv.Itoa(o.Offset))
+	if o.Cursor != nil {
+		queryParams.Set(CursorParam, o.Cursor.Encode())
+	} else {
+		queryParams.Set(OffsetParam, strconv.Itoa(o.Offset))
+	}
+	if o.ResultSet != "" {
+		queryParams.Set(ResultSetParam, o.ResultSet)
+	}
 	queryParams.Set(CountParam, strconv.Itoa(o.Count))
 	for _, incl := range o.Include {
-		queryParams.Add(IncludeParam, fmt.Sprintf("%s:%s", incl.Resource, incl.Parameter.Name))
+		key := IncludeParam
+		if incl.Iterate {
+			key += ":iterate"
+		}
+		queryParams.Add(key, fmt.Sprintf("%s:%s", incl.Resource, incl.Parameter.Name))
 	}
 	for _, incl := range o.RevInclude {
-		queryParams.Add(RevIncludeParam, fmt.Sprintf("%s:%s", incl.Resource, incl.Parameter.Name))
+		key := RevIncludeParam
+		if incl.Iterate {
+			key += ":iterate"
+		}
+		queryParams.Add(key, fmt.Sprintf("%s:%s", incl.Resource, incl.Parameter.Name))
+	}
+	if o.Summary != "" && o.Summary != "false" {
+		queryParams.Add(SummaryParam, o.Summary)
+	}
+	if len(o.Elements) > 0 {
+		queryParams.Add(ElementsParam, strings.Join(o.Elements, ","))
+	}
+	if o.Total != "" {
+		queryParams.Add(TotalParam, o.Total)
+	}
+	if o.MaxResults > 0 {
+		queryParams.Add(MaxResultsParam, strconv.Itoa(o.MaxResults))
+	}
+	if o.Contained != "" {
+		queryParams.Add(ContainedParam, o.Contained)
+	}
+	if o.ContainedType != "" {
+		queryParams.Add(ContainedTypeParam, o.ContainedType)
+	}
+	if o.Graph != "" {
+		queryParams.Add(GraphParam, o.Graph)
+	}
+	if len(o.Types) > 0 {
+		queryParams.Add(TypeParam, strings.Join(o.Types, ","))
+	}
+	if o.Format != "" {
+		queryParams.Add(FormatParam, o.Format)
+	}
+	if o.Pretty {
+		queryParams.Add(PrettyParam, "true")
 	}
 	return queryParams
 }
 
-// IncludeOption describes the data that should be included in query results
+// IncludeOption describes the data that should be included in query results.
+// Iterate indicates the include also applies to included resources.
 type IncludeOption struct {
 	Resource  string
 	Parameter SearchParamInfo
+	Iterate   bool
 }
 
-// RevIncludeOption describes the data that should be included in query results
+// RevIncludeOption describes the data that should be included in query results.
+// Iterate indicates the revinclude also applies to included resources.
 type RevIncludeOption struct {
 	Resource  string
 	Parameter SearchParamInfo
+	Iterate   bool
+}
+
+// referenceParamsOf returns the reference search parameters of a resource, sorted
+// by name so the generated $lookup stages are deterministic.
+func referenceParamsOf(resource string) []SearchParamInfo {
+	var names []string
+	for name, info := range SearchParameters()[resource] {
+		if info.Type == "reference" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	params := make([]SearchParamInfo, len(names))
+	for i, name := range names {
+		params[i] = SearchParameters()[resource][name]
+	}
+	return params
+}
+
+// isIterateModifier checks for the _include/_revinclude modifier requesting iteration.
+// STU3 calls it "recurse", whereas R4 renamed it to "iterate".
+func isIterateModifier(modifier string) bool {
+	return modifier == "iterate" || modifier == "recurse"
 }
 
 // SortOption indicates what parameter to sort on and the sort order
@@ -549,7 +912,7 @@ func createReverseChainedQueryInfo(resource, modifier string) SearchParamInfo {
 	if len(parts) != 3 {
 		panic(createInternalServerError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", "_has")))
 	}
-	refInfo, ok := SearchParameterDictionary[parts[0]][parts[1]]
+	refInfo, ok := SearchParameters()[parts[0]][parts[1]]
 	if !ok {
 		panic(createInvalidSearchError("SEARCH_NONE", fmt.Sprintf("Error: no processable search found for %s search parameters \"%s\"", resource, "_has")))
 	}
@@ -564,10 +927,19 @@ func createReverseChainedQueryInfo(resource, modifier string) SearchParamInfo {
 // CreateSearchParam converts a singular string query value (e.g. "2012") into
 // a SearchParam object corresponding to the SearchParamInfo.
 func (s SearchParamInfo) CreateSearchParam(paramStr string) SearchParam {
+	if s.Type == "text" && s.Modifier != "missing" {
+		// Alternative values are combined into a single text search (see FullTextParam)
+		return ParseFullTextParam(paramStr, s)
+	}
+
 	if ors := escapeFriendlySplit(paramStr, ','); len(ors) > 1 {
 		return ParseOrParam(ors, s)
 	}
 
+	if s.Modifier == "missing" {
+		return ParseMissingParam(paramStr, s)
+	}
+
 	switch s.Type {
 	case "composite":
 		return ParseCompositeParam(paramStr, s)
@@ -585,6 +957,12 @@ func (s SearchParamInfo) CreateSearchParam(paramStr string) SearchParam {
 		return ParseTokenParam(paramStr, s)
 	case "uri":
 		return ParseURIParam(paramStr, s)
+	case "special":
+		// Location's near is the only special parameter that's supported
+		if s.Resource == "Location" && s.Name == "near" {
+			return ParseNearParam(paramStr, s)
+		}
+		panic(createUnsupportedSearchError("MSG_PARAM_UNKNOWN", fmt.Sprintf("Parameter \"%s\" not understood", s.Name)))
 	default:
 		// Check for a custom search parameter
 		if parser, err := GlobalRegistry().LookupParameterParser(s.Type); err == nil {
@@ -1019,6 +1397,14 @@ func ParseTokenParam(paramString string, info SearchParamInfo) *TokenParam {
 
 	t := &TokenParam{SearchParamInfo: info}
 
+	if info.Modifier == "text" {
+		// [parameter]:text=[string] searches the text associated with the code, so
+		// the whole value is used rather than being split into a system and code
+		t.AnySystem = true
+		t.Code = unescape(paramString)
+		return t
+	}
+
 	splitCode := escapeFriendlySplit(paramString, '|')
 	if len(splitCode) == 2 {
 		t.System = unescape(splitCode[0])
@@ -1064,6 +1450,166 @@ func ParseURIParam(paramStr string, info SearchParamInfo) *URIParam {
 	return &URIParam{info, unescape(paramStr)}
 }
 
+// FullTextParam represents the _text and _content search parameters, which
+// search the narrative and the entire resource (respectively) using the
+// collection's text index.  The following description is from the FHIR STU3
+// specification:
+//
+// The _content parameter searches on the entire content of the resource. The
+// _text parameter searches on the narrative of the resource.
+//
+// Since MongoDB allows a single text search per query, alternative values
+// (e.g. "_text=cancer,tumor") are searched for in one text search, which
+// matches any of their words.
+type FullTextParam struct {
+	SearchParamInfo
+	Values []string
+}
+
+func (f *FullTextParam) getInfo() SearchParamInfo {
+	return f.SearchParamInfo
+}
+
+func (f *FullTextParam) setInfo(info SearchParamInfo) {
+	f.SearchParamInfo = info
+}
+
+func (f *FullTextParam) getQueryParamAndValue() (string, string) {
+	escaped := make([]string, len(f.Values))
+	for i, value := range f.Values {
+		escaped[i] = escape(value)
+	}
+	return queryParamAndValue(f.SearchParamInfo, strings.Join(escaped, ","))
+}
+
+// ParseFullTextParam parses a _text or _content query string and returns a
+// pointer to a FullTextParam based on the query and the parameter definition.
+func ParseFullTextParam(paramStr string, info SearchParamInfo) *FullTextParam {
+	f := &FullTextParam{SearchParamInfo: info}
+	for _, value := range escapeFriendlySplit(paramStr, ',') {
+		if value = strings.TrimSpace(unescape(value)); value != "" {
+			f.Values = append(f.Values, value)
+		}
+	}
+	if len(f.Values) == 0 {
+		panic(createInvalidSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", info.Name)))
+	}
+	return f
+}
+
+// NearParam represents Location's special "near" search parameter, matching
+// locations within a distance of a point.  The following description is from
+// the FHIR R4 specification:
+//
+// Search for locations where the location.position is near to, or within a
+// specified distance of, the provided coordinates expressed as
+// [latitude]|[longitude]|[distance]|[units] (using the WGS84 datum).
+//
+// The distance is stored in kilometers.
+type NearParam struct {
+	SearchParamInfo
+	Latitude  float64
+	Longitude float64
+	Distance  float64
+}
+
+// DefaultNearDistance is the distance (in kilometers) used when a near search
+// doesn't specify one.
+const DefaultNearDistance = 10.0
+
+// nearDistanceUnits maps the supported UCUM distance units to kilometers
+var nearDistanceUnits = map[string]float64{
+	"km":     1,
+	"m":      0.001,
+	"[mi_i]": 1.609344,
+	"mi":     1.609344,
+}
+
+func (n *NearParam) getInfo() SearchParamInfo {
+	return n.SearchParamInfo
+}
+
+func (n *NearParam) setInfo(info SearchParamInfo) {
+	n.SearchParamInfo = info
+}
+
+func (n *NearParam) getQueryParamAndValue() (string, string) {
+	value := fmt.Sprintf("%s|%s|%s|km", strconv.FormatFloat(n.Latitude, 'f', -1, 64),
+		strconv.FormatFloat(n.Longitude, 'f', -1, 64), strconv.FormatFloat(n.Distance, 'f', -1, 64))
+	return queryParamAndValue(n.SearchParamInfo, value)
+}
+
+// ParseNearParam parses a near query string and returns a pointer to a
+// NearParam based on the query and the parameter definition.
+func ParseNearParam(paramStr string, info SearchParamInfo) *NearParam {
+	invalid := createInvalidSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", info.Name))
+
+	split := strings.Split(paramStr, "|")
+	if len(split) < 2 || len(split) > 4 {
+		panic(invalid)
+	}
+
+	n := &NearParam{SearchParamInfo: info, Distance: DefaultNearDistance}
+	var err error
+	if n.Latitude, err = strconv.ParseFloat(split[0], 64); err != nil || n.Latitude < -90 || n.Latitude > 90 {
+		panic(invalid)
+	}
+	if n.Longitude, err = strconv.ParseFloat(split[1], 64); err != nil || n.Longitude < -180 || n.Longitude > 180 {
+		panic(invalid)
+	}
+	if len(split) > 2 && split[2] != "" {
+		if n.Distance, err = strconv.ParseFloat(split[2], 64); err != nil || n.Distance <= 0 {
+			panic(invalid)
+		}
+		// The units default to kilometers
+		if len(split) > 3 && split[3] != "" {
+			factor, ok := nearDistanceUnits[split[3]]
+			if !ok {
+				panic(invalid)
+			}
+			n.Distance *= factor
+		}
+	}
+	return n
+}
+
+// MissingParam represents a search parameter using the :missing modifier.  The
+// following description is from the FHIR STU3 specification:
+//
+// For all parameters (except combination), searching for [name]:missing=true
+// will return all resources that do not have a value or have an extension in
+// the specified element, and [name]:missing=false will return all resources
+// that have a value for the specified element.
+type MissingParam struct {
+	SearchParamInfo
+	Missing bool
+}
+
+func (m *MissingParam) getInfo() SearchParamInfo {
+	return m.SearchParamInfo
+}
+
+func (m *MissingParam) setInfo(info SearchParamInfo) {
+	m.SearchParamInfo = info
+}
+
+func (m *MissingParam) getQueryParamAndValue() (string, string) {
+	return queryParam(m.SearchParamInfo), strconv.FormatBool(m.Missing)
+}
+
+// ParseMissingParam parses a :missing query string and returns a pointer to
+// a MissingParam based on the query and the parameter definition.
+func ParseMissingParam(paramStr string, info SearchParamInfo) *MissingParam {
+	switch paramStr {
+	case "true":
+		return &MissingParam{info, true}
+	case "false":
+		return &MissingParam{info, false}
+	default:
+		panic(createInvalidSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" content is invalid", info.Name)))
+	}
+}
+
 // OrParam represents a search parameter that has multiple OR values.  The
 // following description is from the FHIR DSTU2 specification:
 //
@@ -4,8 +4,11 @@ import (
 	"github.com/eug48/fhir/utils"
 	. "github.com/eug48/fhir/utils"
 	"fmt"
+	"sort"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	. "gopkg.in/check.v1"
 )
 
@@ -306,6 +309,29 @@ func (s *SearchPTSuite) TestLeapAndNonLeapYears(c *C) {
 	c.Assert(d.RangeHighExcl().UnixNano(), Equals, time.Date(2000, time.February, 29, 0, 0, 0, 0, time.Local).UnixNano())
 }
 
+func (s *SearchPTSuite) TestDatesInDefaultLocation(c *C) {
+	utils.SetDefaultLocation(s.MDT)
+	defer utils.SetDefaultLocation(nil)
+
+	// Dates without a time are in the default timezone
+	d := utils.MustParseDate("2013-01-02")
+	c.Assert(d.RangeLowIncl().UnixNano(), Equals, time.Date(2013, time.January, 2, 0, 0, 0, 0, s.MDT).UnixNano())
+	low, high := d.UTCRange()
+	c.Assert(low, Equals, time.Date(2013, time.January, 2, 7, 0, 0, 0, time.UTC))
+	c.Assert(high, Equals, time.Date(2013, time.January, 3, 7, 0, 0, 0, time.UTC))
+
+	// Times with a timezone keep it
+	d = utils.MustParseDate("2013-01-02T12:13:14Z")
+	c.Assert(d.RangeLowIncl().UnixNano(), Equals, time.Date(2013, time.January, 2, 12, 13, 14, 0, time.UTC).UnixNano())
+
+	// Days are a calendar day long in the default timezone, even when the clocks change
+	newYork, err := time.LoadLocation("America/New_York")
+	c.Assert(err, IsNil)
+	utils.SetDefaultLocation(newYork)
+	low, high = utils.MustParseDate("2013-03-10").UTCRange()
+	c.Assert(high.Sub(low), Equals, 23*time.Hour)
+}
+
 /******************************************************************************
  * DATE (Param)
  ******************************************************************************/
@@ -1484,6 +1510,146 @@ func (s *SearchPTSuite) TestURIReconstitution(c *C) {
 	c.Assert(v, Equals, "http://acme.org/fhir/ValueSet/123\\$45")
 }
 
+/******************************************************************************
+ * LIST
+ ******************************************************************************/
+
+func (s *SearchPTSuite) TestListQueryParam(c *C) {
+	q := Query{"Patient", "_list=42,43"}
+	params := q.Params()
+	c.Assert(params, HasLen, 1)
+	l, ok := params[0].(*ListQueryParam)
+	c.Assert(ok, Equals, true)
+	c.Assert(l.Name, Equals, "_list")
+	c.Assert(l.Resource, Equals, "Patient")
+	c.Assert(l.ListIDs, DeepEquals, []string{"42", "43"})
+
+	p, v := l.getQueryParamAndValue()
+	c.Assert(p, Equals, "_list")
+	c.Assert(v, Equals, "42,43")
+
+	c.Assert(func() { ParseListQueryParam("Patient", ",") }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_list\" content is invalid"))
+}
+
+/******************************************************************************
+ * FULL TEXT
+ ******************************************************************************/
+
+func (s *SearchPTSuite) TestFullTextParam(c *C) {
+	f := ParseFullTextParam("heart failure", SearchParameters()["Condition"][TextParam])
+
+	c.Assert(f.Name, Equals, "_text")
+	c.Assert(f.Type, Equals, "text")
+	c.Assert(f.Paths, DeepEquals, []SearchParamPath{SearchParamPath{Path: "text.div", Type: "string"}})
+	c.Assert(f.Values, DeepEquals, []string{"heart failure"})
+
+	f = ParseFullTextParam("heart,hypertension\\,essential", SearchParameters()["Condition"][ContentParam])
+	c.Assert(f.Name, Equals, "_content")
+	c.Assert(f.Paths, HasLen, 0)
+	c.Assert(f.Values, DeepEquals, []string{"heart", "hypertension,essential"})
+}
+
+func (s *SearchPTSuite) TestFullTextParamIsNotAnOrParam(c *C) {
+	q := Query{"Condition", "_content=heart,hypertension"}
+	params := q.Params()
+	c.Assert(params, HasLen, 1)
+	c.Assert(params[0], FitsTypeOf, &FullTextParam{})
+}
+
+func (s *SearchPTSuite) TestFullTextReconstitution(c *C) {
+	f := ParseFullTextParam("heart,hypertension\\,essential", SearchParameters()["Condition"][ContentParam])
+	p, v := f.getQueryParamAndValue()
+	c.Assert(p, Equals, "_content")
+	c.Assert(v, Equals, "heart,hypertension\\,essential")
+}
+
+/******************************************************************************
+ * NEAR
+ ******************************************************************************/
+
+var nearParamInfo = nearSearchParameter
+
+func (s *SearchPTSuite) TestNearParam(c *C) {
+	c.Assert(SearchParameters()["Location"]["near"], DeepEquals, nearParamInfo)
+
+	n := ParseNearParam("42.256|-83.694|5|km", nearParamInfo)
+
+	c.Assert(n.Name, Equals, "near")
+	c.Assert(n.Type, Equals, "special")
+	c.Assert(n.Latitude, Equals, 42.256)
+	c.Assert(n.Longitude, Equals, -83.694)
+	c.Assert(n.Distance, Equals, 5.0)
+}
+
+func (s *SearchPTSuite) TestNearParamUnits(c *C) {
+	n := ParseNearParam("42.256|-83.694|500|m", nearParamInfo)
+	c.Assert(n.Distance, Equals, 0.5)
+
+	n = ParseNearParam("42.256|-83.694|10|[mi_i]", nearParamInfo)
+	c.Assert(n.Distance, Equals, 16.09344)
+
+	// The units default to kilometers
+	n = ParseNearParam("42.256|-83.694|2", nearParamInfo)
+	c.Assert(n.Distance, Equals, 2.0)
+
+	// And the distance to DefaultNearDistance
+	n = ParseNearParam("42.256|-83.694", nearParamInfo)
+	c.Assert(n.Distance, Equals, DefaultNearDistance)
+}
+
+func (s *SearchPTSuite) TestNearParamInvalid(c *C) {
+	for _, value := range []string{"42.256", "foo|-83.694", "95|-83.694", "42.256|-190", "42.256|-83.694|-1", "42.256|-83.694|5|ft"} {
+		c.Assert(func() { ParseNearParam(value, nearParamInfo) }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"near\" content is invalid"))
+	}
+}
+
+func (s *SearchPTSuite) TestUnsupportedSpecialParam(c *C) {
+	info := SearchParamInfo{Resource: "Device", Name: "foo", Type: "special"}
+	c.Assert(func() { info.CreateSearchParam("bar") }, Panics, createUnsupportedSearchError("MSG_PARAM_UNKNOWN", "Parameter \"foo\" not understood"))
+}
+
+func (s *SearchPTSuite) TestNearReconstitution(c *C) {
+	n := ParseNearParam("42.256|-83.694|500|m", nearParamInfo)
+	p, v := n.getQueryParamAndValue()
+	c.Assert(p, Equals, "near")
+	c.Assert(v, Equals, "42.256|-83.694|0.5|km")
+}
+
+/******************************************************************************
+ * MISSING
+ ******************************************************************************/
+
+var missingParamInfo = SearchParamInfo{
+	Name:     "foo",
+	Type:     "date",
+	Paths:    []SearchParamPath{SearchParamPath{Path: "bar", Type: "dateTime"}},
+	Modifier: "missing",
+}
+
+func (s *SearchPTSuite) TestMissingParam(c *C) {
+	m := missingParamInfo.CreateSearchParam("true").(*MissingParam)
+
+	c.Assert(m.Name, Equals, "foo")
+	c.Assert(m.Type, Equals, "date")
+	c.Assert(m.Modifier, Equals, "missing")
+	c.Assert(m.Paths, HasLen, 1)
+	c.Assert(m.Missing, Equals, true)
+
+	m = missingParamInfo.CreateSearchParam("false").(*MissingParam)
+	c.Assert(m.Missing, Equals, false)
+}
+
+func (s *SearchPTSuite) TestMissingParamWithInvalidValuePanics(c *C) {
+	c.Assert(func() { ParseMissingParam("2012", missingParamInfo) }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"foo\" content is invalid"))
+}
+
+func (s *SearchPTSuite) TestMissingReconstitution(c *C) {
+	m := ParseMissingParam("true", missingParamInfo)
+	p, v := m.getQueryParamAndValue()
+	c.Assert(p, Equals, "foo:missing")
+	c.Assert(v, Equals, "true")
+}
+
 /******************************************************************************
  * OR
  ******************************************************************************/
@@ -1738,6 +1904,19 @@ func (s *SearchPTSuite) TestQueryOptionsInvalidSortParam(c *C) {
 	c.Assert(func() { q.Options() }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_sort\" content is invalid"))
 }
 
+func (s *SearchPTSuite) TestQueryOptionsWithScoreSort(c *C) {
+	q := Query{Resource: "Condition", Query: "_content=heart&_sort=_score"}
+	o := q.Options()
+	c.Assert(o.Sort, HasLen, 1)
+	c.Assert(o.Sort[0].Parameter.Name, Equals, "_score")
+	c.Assert(o.sortsByTextScore(), Equals, true)
+	c.Assert(o.SupportsKeysetPaging(), Equals, false)
+
+	// Relevance is only known for text searches
+	q = Query{Resource: "Condition", Query: "code=123641001&_sort=_score"}
+	c.Assert(func() { q.Options() }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_sort\" content is invalid"))
+}
+
 func (s *SearchPTSuite) TestQueryOptionsIncludeTargets(c *C) {
 	q := Query{Resource: "Patient", Query: "_include=Patient:general-practitioner:Organization"}
 	o := q.Options()
@@ -1781,6 +1960,38 @@ func (s *SearchPTSuite) TestQueryOptionsIncludeTargets(c *C) {
 	}
 }
 
+func (s *SearchPTSuite) TestQueryOptionsIncludeWildcards(c *C) {
+	// The wildcard may be URL-encoded and the includes are sorted by name
+	q := Query{Resource: "Patient", Query: "_include=%2A"}
+	o := q.Options()
+	c.Assert(o.IsIncludeAll, Equals, true)
+	c.Assert(o.Include, HasLen, 3)
+	c.Assert(o.Include[0].Parameter.Name, Equals, "general-practitioner")
+	c.Assert(o.Include[1].Parameter.Name, Equals, "link")
+	c.Assert(o.Include[2].Parameter.Name, Equals, "organization")
+
+	// A wildcard for a specific resource type
+	q = Query{Resource: "Patient", Query: "_include=Patient:*"}
+	o = q.Options()
+	c.Assert(o.IsIncludeAll, Equals, false)
+	c.Assert(o.Include, HasLen, 3)
+	c.Assert(o.Include[0].Resource, Equals, "Patient")
+	c.Assert(o.Include[0].Parameter.Name, Equals, "general-practitioner")
+
+	// Only the Observation references to a Patient are revincluded
+	q = Query{Resource: "Patient", Query: "_revinclude=Observation:*"}
+	o = q.Options()
+	c.Assert(o.IsRevincludeAll, Equals, false)
+	c.Assert(o.RevInclude, HasLen, 3)
+	c.Assert(o.RevInclude[0].Parameter.Name, Equals, "patient")
+	c.Assert(o.RevInclude[1].Parameter.Name, Equals, "performer")
+	c.Assert(o.RevInclude[1].Parameter.Targets, DeepEquals, []string{"Patient"})
+	c.Assert(o.RevInclude[2].Parameter.Name, Equals, "subject")
+
+	q = Query{Resource: "Patient", Query: "_include=Foo:*"}
+	c.Assert(func() { q.Options() }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_include\" content is invalid"))
+}
+
 func (s *SearchPTSuite) TestQueryOptionsInvalidIncludeParams(c *C) {
 	// Non-existent parameter
 	q := Query{Resource: "Patient", Query: "_include=Patient:foo"}
@@ -1863,6 +2074,210 @@ func (s *SearchPTSuite) TestQueryOptionsInvalidRevIncludeParams(c *C) {
 	c.Assert(func() { q.Options() }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_revinclude\" content is invalid"))
 }
 
+func (s *SearchPTSuite) TestQueryOptionsIncludeIterate(c *C) {
+	q := Query{Resource: "MedicationRequest", Query: "_include=MedicationRequest:medication&_include:iterate=Medication:manufacturer&_include:recurse=Organization:partof"}
+	o := q.Options()
+	c.Assert(o.Include, HasLen, 3)
+	c.Assert(o.Include[0].Iterate, Equals, false)
+	c.Assert(o.Include[1].Resource, Equals, "Medication")
+	c.Assert(o.Include[1].Parameter.Name, Equals, "manufacturer")
+	c.Assert(o.Include[1].Iterate, Equals, true)
+	c.Assert(o.Include[2].Iterate, Equals, true)
+
+	params := o.URLQueryParameters()
+	all := params.All()
+	c.Assert(all[3], DeepEquals, URLQueryParameter{Key: IncludeParam + ":iterate", Value: "Medication:manufacturer"})
+}
+
+func (s *SearchPTSuite) TestQueryOptionsRevIncludeIterate(c *C) {
+	// An iterative revinclude doesn't need to target the resource being searched
+	q := Query{Resource: "Patient", Query: "_revinclude:iterate=Observation:context:Encounter"}
+	o := q.Options()
+	c.Assert(o.RevInclude, HasLen, 1)
+	c.Assert(o.RevInclude[0].Resource, Equals, "Observation")
+	c.Assert(o.RevInclude[0].Parameter.Name, Equals, "context")
+	c.Assert(o.RevInclude[0].Parameter.Targets, DeepEquals, []string{"Encounter"})
+	c.Assert(o.RevInclude[0].Iterate, Equals, true)
+
+	q = Query{Resource: "Patient", Query: "_revinclude:iterate=Observation:context:Patient"}
+	c.Assert(func() { q.Options() }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_revinclude\" content is invalid"))
+}
+
+func (s *SearchPTSuite) TestQueryOptionsElements(c *C) {
+	q := Query{Resource: "Patient", Query: "_elements=identifier,%20Patient.name"}
+	o := q.Options()
+	c.Assert(o.Elements, DeepEquals, []string{"identifier", "name"})
+
+	params := o.URLQueryParameters()
+	c.Assert(params.Get(ElementsParam), Equals, "identifier,name")
+
+	q = Query{Resource: "Patient", Query: "_elements=name.given"}
+	c.Assert(func() { q.Options() }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_elements\" content is invalid"))
+
+	q = Query{Resource: "Patient", Query: "_elements=identifier,,name"}
+	c.Assert(func() { q.Options() }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_elements\" content is invalid"))
+}
+
+func (s *SearchPTSuite) TestQueryOptionsSummary(c *C) {
+	for _, summary := range []string{"true", "text", "data", "count", "false"} {
+		q := Query{Resource: "Patient", Query: "_summary=" + summary}
+		o := q.Options()
+		c.Assert(o.Summary, Equals, summary)
+		c.Assert(o.IsSubsetted(), Equals, summary == "true" || summary == "text" || summary == "data")
+	}
+
+	q := Query{Resource: "Patient", Query: "_summary=foo"}
+	c.Assert(func() { q.Options() }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_summary\" content is invalid"))
+}
+
+func (s *SearchPTSuite) TestQueryOptionsTotal(c *C) {
+	q := Query{Resource: "Patient", Query: "gender=male"}
+	o := q.Options()
+	c.Assert(o.Total, Equals, "")
+	c.Assert(o.CountsTotal(true), Equals, true)
+	c.Assert(o.CountsTotal(false), Equals, false)
+
+	q = Query{Resource: "Patient", Query: "_total=none"}
+	o = q.Options()
+	c.Assert(o.Total, Equals, "none")
+	c.Assert(o.CountsTotal(true), Equals, false)
+	params := o.URLQueryParameters()
+	c.Assert(params.Get(TotalParam), Equals, "none")
+
+	for _, total := range []string{"estimate", "accurate"} {
+		q = Query{Resource: "Patient", Query: "_total=" + total}
+		o = q.Options()
+		c.Assert(o.Total, Equals, total)
+		c.Assert(o.CountsTotal(false), Equals, true)
+	}
+
+	// _summary=count always needs a total
+	q = Query{Resource: "Patient", Query: "_total=none&_summary=count"}
+	c.Assert(q.Options().CountsTotal(false), Equals, true)
+
+	q = Query{Resource: "Patient", Query: "_total=foo"}
+	c.Assert(func() { q.Options() }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_total\" content is invalid"))
+}
+
+func (s *SearchPTSuite) TestQueryOptionsCursor(c *C) {
+	token := (&PageCursor{Value: "female", ID: "123"}).Encode()
+	q := Query{Resource: "Patient", Query: "_sort=gender&_cursor=" + token}
+	o := q.Options()
+	c.Assert(o.Cursor, DeepEquals, &PageCursor{Value: "female", ID: "123"})
+	params := o.URLQueryParameters()
+	c.Assert(params.Get(CursorParam), Equals, token)
+	c.Assert(params.Get(OffsetParam), Equals, "")
+
+	q = Query{Resource: "Patient", Query: "_cursor=foo"}
+	c.Assert(func() { q.Options() }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_cursor\" content is invalid"))
+
+	// Ids are strings, not e.g. operators
+	q = Query{Resource: "Patient", Query: "_cursor=" + (&PageCursor{ID: bson.D{{Key: "$ne", Value: nil}}}).Encode()}
+	c.Assert(func() { q.Options() }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_cursor\" content is invalid"))
+
+	// Cursors can't resume from a sort on multiple values
+	q = Query{Resource: "Patient", Query: "_sort=family&_cursor=" + token}
+	c.Assert(func() { q.Options() }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_cursor\" cannot be used with the requested _sort or _summary"))
+}
+
+func (s *SearchPTSuite) TestIsSortKeyValue(c *C) {
+	c.Assert(isSortKeyValue(nil, "string"), Equals, true)
+	c.Assert(isSortKeyValue("female", "token"), Equals, true)
+	c.Assert(isSortKeyValue(true, "token"), Equals, true)
+	c.Assert(isSortKeyValue(int32(1), "number"), Equals, true)
+	c.Assert(isSortKeyValue(int32(1), "string"), Equals, false)
+	c.Assert(isSortKeyValue("2012", "date"), Equals, false)
+
+	// Dates are stored as ranges, but the values mustn't be operators
+	c.Assert(isSortKeyValue(bson.D{{Key: "__from", Value: primitive.DateTime(0)}, {Key: "__strDate", Value: "1970"}}, "date"), Equals, true)
+	c.Assert(isSortKeyValue(bson.D{{Key: "$gt", Value: ""}}, "date"), Equals, false)
+	c.Assert(isSortKeyValue(bson.D{{Key: "__from", Value: bson.D{{Key: "$gt", Value: ""}}}}, "date"), Equals, false)
+	c.Assert(isSortKeyValue(bson.D{{Key: "__from", Value: primitive.DateTime(0)}}, "string"), Equals, false)
+	c.Assert(isSortKeyValue(bson.A{"female"}, "token"), Equals, false)
+}
+
+func (s *SearchPTSuite) TestQueryOptionsMaxResults(c *C) {
+	q := Query{Resource: "Patient", Query: "_count=10&_offset=10&_maxresults=15"}
+	o := q.Options()
+	c.Assert(o.MaxResults, Equals, 15)
+	params := o.URLQueryParameters()
+	c.Assert(params.Get(MaxResultsParam), Equals, "15")
+
+	// The last page is cut short, and those after it are empty
+	o.limitToMaxResults()
+	c.Assert(o.Count, Equals, 5)
+	o = &QueryOptions{Count: 10, Offset: 20, MaxResults: 15}
+	o.limitToMaxResults()
+	c.Assert(o.Count, Equals, 0)
+
+	q = Query{Resource: "Patient", Query: "_maxresults=0"}
+	c.Assert(func() { q.Options() }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_maxresults\" content is invalid"))
+
+	token := (&PageCursor{ID: "123"}).Encode()
+	q = Query{Resource: "Patient", Query: "_maxresults=10&_cursor=" + token}
+	c.Assert(func() { q.Options() }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_cursor\" cannot be used with \"_maxresults\""))
+}
+
+func (s *SearchPTSuite) TestQueryOptionsContained(c *C) {
+	q := Query{Resource: "Medication", Query: "_contained=true&_containedType=contained"}
+	o := q.Options()
+	c.Assert(o.Contained, Equals, "true")
+	c.Assert(o.ContainedType, Equals, "contained")
+	params := o.URLQueryParameters()
+	c.Assert(params.Get(ContainedParam), Equals, "true")
+	c.Assert(params.Get(ContainedTypeParam), Equals, "contained")
+
+	// false is the default
+	q = Query{Resource: "Medication", Query: "_contained=false"}
+	c.Assert(q.Options().Contained, Equals, "")
+
+	token := (&PageCursor{ID: "123"}).Encode()
+	q = Query{Resource: "Medication", Query: "_contained=both&_cursor=" + token}
+	c.Assert(func() { q.Options() }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_cursor\" cannot be used with \"_contained\""))
+}
+
+func (s *SearchPTSuite) TestQueryWithParams(c *C) {
+	q := Query{Resource: "Medication", Query: "code=123&_count=10&_contained=both"}
+	q = q.withParams(map[string]string{ContainedParam: "true", CountParam: "5", MaxResultsParam: ""})
+	c.Assert(q.Query, Equals, "code=123&_contained=true&_count=5")
+}
+
+func (s *SearchPTSuite) TestCompartmentQueryParam(c *C) {
+	q := Query{Resource: "Observation", Query: "code=123&_compartment=Patient%2F456"}
+	params := q.Params()
+	c.Assert(params, HasLen, 2)
+	p, ok := params[1].(*CompartmentQueryParam)
+	c.Assert(ok, Equals, true)
+	c.Assert(p.Compartment, Equals, "Patient")
+	c.Assert(p.ID, Equals, "456")
+	c.Assert(q.UnknownParams(), HasLen, 0)
+
+	queryParams := q.URLQueryParameters(false)
+	c.Assert(queryParams.Get(CompartmentParam), Equals, "Patient/456")
+
+	types := CompartmentResourceTypes("Patient")
+	c.Assert(contains(types, "Patient"), Equals, true)
+	c.Assert(contains(types, "Observation"), Equals, true)
+	c.Assert(contains(types, "Organization"), Equals, false)
+	c.Assert(sort.StringsAreSorted(types), Equals, true)
+	c.Assert(CompartmentResourceTypes("Unknown"), HasLen, 0)
+}
+
+func (s *SearchPTSuite) TestQueryOptionsGraph(c *C) {
+	q := Query{Resource: "Patient", Query: "_id=123&_graph=GraphDefinition/456"}
+	o := q.Options()
+	c.Assert(o.Graph, Equals, "456")
+	c.Assert(q.UsesGraph(), Equals, true)
+	params := o.URLQueryParameters()
+	c.Assert(params.Get(GraphParam), Equals, "456")
+
+	q = Query{Resource: "Patient", Query: "_graph=http://example.com/GraphDefinition/456"}
+	c.Assert(q.Options().Graph, Equals, "http://example.com/GraphDefinition/456")
+
+	q = Query{Resource: "Patient", Query: "_graph="}
+	c.Assert(func() { q.Options() }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_graph\" content is invalid"))
+}
+
 func (s *SearchPTSuite) TestQueryOptionsInvalidFormatParam(c *C) {
 	// Format that is not supported (Turtle)
 	q := Query{Resource: "Patient", Query: "_format=ttl"}
@@ -1875,6 +2290,37 @@ func (s *SearchPTSuite) TestQueryOptionsInvalidFormatParam(c *C) {
 	// Valid format (xml)
 	q = Query{Resource: "Patient", Query: "_format=xml"}
 	q.Options()
+
+	// A MIME type with an unescaped +
+	q = Query{Resource: "Patient", Query: "_format=application/fhir+xml"}
+	c.Assert(q.Options().Format, Equals, "application/fhir+xml")
+}
+
+func (s *SearchPTSuite) TestQueryOptionsPrettyParam(c *C) {
+	q := Query{Resource: "Patient", Query: "_pretty=true"}
+	c.Assert(q.Options().Pretty, Equals, true)
+	params := q.URLQueryParameters(true)
+	c.Assert(params.Get(PrettyParam), Equals, "true")
+
+	q = Query{Resource: "Patient", Query: "_pretty=false"}
+	c.Assert(q.Options().Pretty, Equals, false)
+	params = q.URLQueryParameters(true)
+	c.Assert(params.Get(PrettyParam), Equals, "")
+
+	q = Query{Resource: "Patient", Query: "_pretty=yes"}
+	c.Assert(func() { q.Options() }, Panics, createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_pretty\" content is invalid"))
+}
+
+func (s *SearchPTSuite) TestReconstructQueryWithFormat(c *C) {
+	q := Query{Resource: "Patient", Query: "gender=male&_format=application%2Ffhir%2Bjson&_count=10"}
+	params := q.URLQueryParameters(true)
+	c.Assert(params.Get(FormatParam), Equals, "application/fhir+json")
+	c.Assert(params.Get(CountParam), Equals, "10")
+	c.Assert(params.Get(OffsetParam), Equals, "0")
+
+	// Without options, _format isn't included
+	params = q.URLQueryParameters(false)
+	c.Assert(params.Get(FormatParam), Equals, "")
 }
 
 func (s *SearchPTSuite) TestReconstructQueryWithPassedInOptions(c *C) {
@@ -1914,14 +2360,14 @@ func (s *SearchPTSuite) TestQueryOptionsURLQueryParameters(c *C) {
 		Count:  123,
 		Offset: 456,
 		Include: []IncludeOption{
-			{Resource: "Patient", Parameter: SearchParameterDictionary["Patient"]["general-practitioner"]},
+			{Resource: "Patient", Parameter: SearchParameters()["Patient"]["general-practitioner"]},
 		},
 		RevInclude: []RevIncludeOption{
-			{Resource: "Encounter", Parameter: SearchParameterDictionary["Encounter"]["patient"]},
+			{Resource: "Encounter", Parameter: SearchParameters()["Encounter"]["patient"]},
 		},
 		Sort: []SortOption{
-			{Parameter: SearchParameterDictionary["Patient"]["name"]},
-			{Parameter: SearchParameterDictionary["Patient"]["birthdate"], Descending: true},
+			{Parameter: SearchParameters()["Patient"]["name"]},
+			{Parameter: SearchParameters()["Patient"]["birthdate"], Descending: true},
 		},
 	}
 	params := q.URLQueryParameters()
@@ -2023,7 +2469,7 @@ func (s *SearchPTSuite) TestCreateReverseChainedSearchInfo(c *C) {
 	info := createReverseChainedQueryInfo("Patient", "Observation:subject:code")
 
 	// The reference param this was based on
-	refInfo := SearchParameterDictionary["Observation"]["subject"]
+	refInfo := SearchParameters()["Observation"]["subject"]
 
 	c.Assert(info.Resource, Equals, "Observation")
 	c.Assert(info.Name, Equals, "_has")
@@ -2047,7 +2493,7 @@ func (s *SearchPTSuite) TestCreateReverseChainedSearchInfoPanics(c *C) {
 // _include=* from the SearchParameterDictionary
 func getAllIncludeNames(resourceName string) []string {
 	inclNames := []string{}
-	resourceSearchParamInfos, ok := SearchParameterDictionary[resourceName]
+	resourceSearchParamInfos, ok := SearchParameters()[resourceName]
 
 	if ok {
 		for _, param := range resourceSearchParamInfos {
@@ -2063,7 +2509,7 @@ func getAllIncludeNames(resourceName string) []string {
 // with _revinclude=* from the SearchParameterDictionary
 func getAllRevincludeNames(resourceName string) []string {
 	revinclNames := []string{}
-	for _, resourceSearchParams := range SearchParameterDictionary {
+	for _, resourceSearchParams := range SearchParameters() {
 		for _, revInclParam := range resourceSearchParams {
 			if revInclParam.Type == "reference" && contains(revInclParam.Targets, resourceName) {
 				revinclNames = append(revinclNames, revInclParam.Name)
@@ -2082,3 +2528,78 @@ func elementInSlice(element string, slice []string) bool {
 	}
 	return false
 }
+
+func (s *SearchPTSuite) TestUnknownParams(c *C) {
+	q := Query{"Patient", "name=foo&bar=baz&_pretty=true&gender:not=male&_count=10&bar=qux&organization.name=acme"}
+	c.Assert(q.UnknownParams(), DeepEquals, []string{"bar"})
+
+	q = Query{"Patient", "name=foo&_has:Observation:subject:code=1234&_filter=gender eq male"}
+	c.Assert(q.UnknownParams(), HasLen, 0)
+}
+
+func (s *SearchPTSuite) TestCheckUnknownParams(c *C) {
+	q := Query{"Patient", "name=foo&bar=baz"}
+	c.Assert(func() { q.CheckUnknownParams() }, Panics, createInvalidSearchError("MSG_PARAM_UNKNOWN", "Parameter \"bar\" not understood"))
+
+	q = Query{"Patient", "name=foo&_sort=name"}
+	q.CheckUnknownParams()
+}
+
+func (s *SearchPTSuite) TestWithoutParams(c *C) {
+	q := Query{"Patient", "name=foo&bar=baz&bar:exact=qux&_count=10"}
+	lenient := q.WithoutParams(q.UnknownParams())
+	c.Assert(lenient, DeepEquals, Query{"Patient", "name=foo&_count=10"})
+	c.Assert(lenient.Params(), HasLen, 1)
+}
+
+func (s *SearchPTSuite) TestEditDistance(c *C) {
+	c.Assert(editDistance("", ""), Equals, 0)
+	c.Assert(editDistance("gender", "gender"), Equals, 0)
+	c.Assert(editDistance("gender", "gendr"), Equals, 1)
+	c.Assert(editDistance("kitten", "sitting"), Equals, 3)
+	c.Assert(editDistance("", "name"), Equals, 4)
+}
+
+func (s *SearchPTSuite) TestSuggestParams(c *C) {
+	c.Assert(suggestParams("Patient", "birthDate"), DeepEquals, []string{"birthdate"})
+	c.Assert(suggestParams("Patient", "_cout"), DeepEquals, []string{"_count", "_sort"})
+	c.Assert(suggestParams("Patient", "xyzzy"), HasLen, 0)
+}
+
+func (s *SearchPTSuite) TestUnknownParamErrorsSuggestParams(c *C) {
+	q := Query{"Patient", "birthDate=2012"}
+	expected := createInvalidSearchError("SEARCH_NONE", "Error: no processable search found for Patient search parameters \"birthDate\"")
+	expected.OperationOutcome.Issue[0].Diagnostics = "Did you mean \"birthdate\"?"
+	c.Assert(func() { q.Params() }, Panics, expected)
+
+	q = Query{"Patient", "_cout=10"}
+	expected = createUnsupportedSearchError("MSG_PARAM_UNKNOWN", "Parameter \"_cout\" not understood")
+	expected.OperationOutcome.Issue[0].Diagnostics = "Did you mean \"_count\" or \"_sort\"?"
+	c.Assert(func() { q.Options() }, Panics, expected)
+}
+
+func (s *SearchPTSuite) TestWithCountLimits(c *C) {
+	q := Query{"Patient", "name=foo"}
+	limited, capped := q.WithCountLimits(DefaultCount, 0)
+	c.Assert(limited, DeepEquals, q)
+	c.Assert(capped, Equals, false)
+
+	limited, capped = q.WithCountLimits(20, 0)
+	c.Assert(limited, DeepEquals, Query{"Patient", "name=foo&_count=20"})
+	c.Assert(capped, Equals, false)
+
+	// The default can't exceed the maximum
+	limited, capped = q.WithCountLimits(DefaultCount, 50)
+	c.Assert(limited, DeepEquals, Query{"Patient", "name=foo&_count=50"})
+	c.Assert(capped, Equals, false)
+
+	q = Query{"Patient", "_count=500&name=foo"}
+	limited, capped = q.WithCountLimits(20, 200)
+	c.Assert(limited, DeepEquals, Query{"Patient", "_count=200&name=foo"})
+	c.Assert(capped, Equals, true)
+
+	q = Query{"Patient", "_count=150&name=foo"}
+	limited, capped = q.WithCountLimits(20, 200)
+	c.Assert(limited, DeepEquals, q)
+	c.Assert(capped, Equals, false)
+}
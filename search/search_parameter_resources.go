@@ -0,0 +1,190 @@
+package search
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+
+	"github.com/eug48/fhir/models"
+)
+
+// RegisterSearchParameter registers a custom search parameter defined by a SearchParameter resource (e.g. one that was
+// POSTed to the server), so that it can be used to search each of its base resources.  Composite and special
+// search parameters aren't supported.  Searches that are running keep using the parameters they started with.
+func RegisterSearchParameter(sp *models.SearchParameter) error {
+	infos, err := SearchParamInfosFromResource(sp)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		GlobalRegistry().RegisterParameterInfo(info)
+	}
+	return nil
+}
+
+// SearchParamInfosFromResource converts a SearchParameter resource to the SearchParamInfo of each of its base
+// resources, converting its FHIRPath expression to the paths of the elements to search.
+func SearchParamInfosFromResource(sp *models.SearchParameter) ([]SearchParamInfo, error) {
+	if sp.Code == "" {
+		return nil, fmt.Errorf("SearchParameter %s has no code", sp.Id)
+	}
+	switch sp.Type {
+	case "number", "date", "string", "token", "reference", "quantity", "uri":
+	default:
+		return nil, fmt.Errorf("SearchParameter %s has an unsupported type: %s", sp.Code, sp.Type)
+	}
+	if sp.Expression == "" {
+		return nil, fmt.Errorf("SearchParameter %s has no expression", sp.Code)
+	}
+
+	infos := make([]SearchParamInfo, 0, len(sp.Base))
+	for _, base := range sp.Base {
+		paths, err := convertFHIRPathToSearchParamPaths(base, sp.Type, sp.Expression)
+		if err != nil {
+			return nil, fmt.Errorf("SearchParameter %s: %s", sp.Code, err)
+		}
+		infos = append(infos, SearchParamInfo{
+			Resource: base,
+			Name:     sp.Code,
+			Type:     sp.Type,
+			Paths:    paths,
+			Targets:  sp.Target,
+		})
+	}
+	if len(infos) == 0 {
+		return nil, fmt.Errorf("SearchParameter %s has no base resources", sp.Code)
+	}
+	return infos, nil
+}
+
+// convertFHIRPathToSearchParamPaths converts the simple FHIRPath expressions used by most search parameters (e.g.
+// "Patient.name.family", "Observation.value as Quantity" or "Condition.onset.as(dateTime) | Condition.onset.as(Period)")
+// to the search paths of the given resource.  Functions such as where() and extension() aren't supported.
+func convertFHIRPathToSearchParamPaths(resource, paramType, expression string) ([]SearchParamPath, error) {
+	var paths []SearchParamPath
+	for _, expr := range strings.Split(expression, "|") {
+		expr = strings.TrimSpace(expr)
+		if strings.HasPrefix(expr, "(") && strings.HasSuffix(expr, ")") {
+			expr = strings.TrimSpace(expr[1 : len(expr)-1])
+		}
+
+		// Choice types, e.g. "Observation.value as Quantity" or "Observation.value.as(Quantity)"
+		asType := ""
+		if i := strings.Index(expr, " as "); i != -1 {
+			asType = strings.TrimSpace(expr[i+len(" as "):])
+			expr = strings.TrimSpace(expr[:i])
+		} else if i := strings.Index(expr, ".as("); i != -1 && strings.HasSuffix(expr, ")") {
+			asType = expr[i+len(".as(") : len(expr)-1]
+			expr = expr[:i]
+		}
+
+		elements := strings.Split(expr, ".")
+		switch elements[0] {
+		case resource, "Resource", "DomainResource":
+		default:
+			// An expression for one of the other base resources
+			continue
+		}
+		elements = elements[1:]
+		if len(elements) == 0 {
+			return nil, fmt.Errorf("unsupported expression: %s", expr)
+		}
+		if asType != "" {
+			runes := []rune(asType)
+			runes[0] = unicode.ToUpper(runes[0])
+			elements[len(elements)-1] += string(runes)
+		}
+
+		path, err := resolveSearchParamPath(resource, paramType, elements)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no expression for %s", resource)
+	}
+	return paths, nil
+}
+
+// resolveSearchParamPath finds the elements in the resource's model, marking those that are arrays (e.g. "[]name.given").
+func resolveSearchParamPath(resource, paramType string, elements []string) (SearchParamPath, error) {
+	model := models.StructForResourceName(resource)
+	if model == nil {
+		return SearchParamPath{}, fmt.Errorf("unknown resource: %s", resource)
+	}
+
+	t := reflect.TypeOf(model)
+	path := make([]string, len(elements))
+	for i, element := range elements {
+		if strings.ContainsAny(element, "()[]'= ") {
+			return SearchParamPath{}, fmt.Errorf("unsupported expression: %s", strings.Join(elements, "."))
+		}
+		field, ok := findModelField(t, element)
+		if !ok {
+			return SearchParamPath{}, fmt.Errorf("%s has no element %s", resource, strings.Join(elements[:i+1], "."))
+		}
+
+		path[i] = strings.Split(field.Tag.Get("bson"), ",")[0]
+		t = field.Type
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() == reflect.Slice {
+			path[i] = "[]" + path[i]
+			t = t.Elem()
+		}
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+	}
+	return SearchParamPath{Path: strings.Join(path, "."), Type: fhirTypeOfModel(t, paramType)}, nil
+}
+
+// findModelField finds the field of a model struct (including those of embedded structs such as DomainResource)
+// with the given JSON name.
+func findModelField(t reflect.Type, name string) (reflect.StructField, bool) {
+	if t.Kind() != reflect.Struct {
+		return reflect.StructField{}, false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous {
+			if embedded, ok := findModelField(field.Type, name); ok {
+				return embedded, true
+			}
+			continue
+		}
+		if strings.Split(field.Tag.Get("json"), ",")[0] == name {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// fhirTypeOfModel returns the FHIR type of an element from the type of its model.  The models use strings for
+// several FHIR types, so the type of the search parameter decides how those are searched.
+func fhirTypeOfModel(t reflect.Type, paramType string) string {
+	switch t.Kind() {
+	case reflect.String:
+		switch paramType {
+		case "token":
+			return "code"
+		case "uri":
+			return "uri"
+		default:
+			return "string"
+		}
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Float32, reflect.Float64:
+		return "decimal"
+	case reflect.Int, reflect.Int32, reflect.Int64, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	}
+	if t.Name() == "FHIRDateTime" {
+		return "dateTime"
+	}
+	return t.Name()
+}
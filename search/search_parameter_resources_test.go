@@ -0,0 +1,96 @@
+package search
+
+import (
+	"github.com/eug48/fhir/models"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+type SearchParameterResourcesSuite struct{}
+
+var _ = Suite(&SearchParameterResourcesSuite{})
+
+func (s *SearchParameterResourcesSuite) TestConvertSimpleExpressions(c *C) {
+	paths, err := convertFHIRPathToSearchParamPaths("Patient", "string", "Patient.name.family")
+	util.CheckErr(err)
+	c.Assert(paths, DeepEquals, []SearchParamPath{{Path: "[]name.family", Type: "string"}})
+
+	paths, err = convertFHIRPathToSearchParamPaths("Patient", "token", "Patient.gender")
+	util.CheckErr(err)
+	c.Assert(paths, DeepEquals, []SearchParamPath{{Path: "gender", Type: "code"}})
+
+	paths, err = convertFHIRPathToSearchParamPaths("Condition", "token", "Condition.code")
+	util.CheckErr(err)
+	c.Assert(paths, DeepEquals, []SearchParamPath{{Path: "code", Type: "CodeableConcept"}})
+
+	// Elements of the Resource and DomainResource base types
+	paths, err = convertFHIRPathToSearchParamPaths("Patient", "token", "Resource.id")
+	util.CheckErr(err)
+	c.Assert(paths, DeepEquals, []SearchParamPath{{Path: "_id", Type: "code"}})
+}
+
+func (s *SearchParameterResourcesSuite) TestConvertChoiceTypeExpressions(c *C) {
+	paths, err := convertFHIRPathToSearchParamPaths("Observation", "quantity", "Observation.value as Quantity")
+	util.CheckErr(err)
+	c.Assert(paths, DeepEquals, []SearchParamPath{{Path: "valueQuantity", Type: "Quantity"}})
+
+	paths, err = convertFHIRPathToSearchParamPaths("Condition", "date", "Condition.onset.as(dateTime) | Condition.onset.as(Period)")
+	util.CheckErr(err)
+	c.Assert(paths, DeepEquals, []SearchParamPath{
+		{Path: "onsetDateTime", Type: "dateTime"},
+		{Path: "onsetPeriod", Type: "Period"},
+	})
+}
+
+func (s *SearchParameterResourcesSuite) TestConvertExpressionsForSeveralResources(c *C) {
+	expression := "(Condition.subject) | (Observation.subject)"
+	paths, err := convertFHIRPathToSearchParamPaths("Observation", "reference", expression)
+	util.CheckErr(err)
+	c.Assert(paths, DeepEquals, []SearchParamPath{{Path: "subject", Type: "Reference"}})
+
+	_, err = convertFHIRPathToSearchParamPaths("Patient", "reference", expression)
+	c.Assert(err, ErrorMatches, "no expression for Patient")
+}
+
+func (s *SearchParameterResourcesSuite) TestConvertUnsupportedExpressions(c *C) {
+	_, err := convertFHIRPathToSearchParamPaths("Patient", "string", "Patient.telecom.where(system='email')")
+	c.Assert(err, ErrorMatches, "unsupported expression: .*")
+
+	_, err = convertFHIRPathToSearchParamPaths("Patient", "string", "Patient.nickname")
+	c.Assert(err, ErrorMatches, "Patient has no element nickname")
+
+	_, err = convertFHIRPathToSearchParamPaths("Blah", "string", "Blah.name")
+	c.Assert(err, ErrorMatches, "unknown resource: Blah")
+}
+
+func (s *SearchParameterResourcesSuite) TestSearchParamInfosFromResource(c *C) {
+	sp := &models.SearchParameter{
+		Code:       "subject-of",
+		Base:       []string{"Condition", "Observation"},
+		Type:       "reference",
+		Expression: "Condition.subject | Observation.subject",
+		Target:     []string{"Patient"},
+	}
+	infos, err := SearchParamInfosFromResource(sp)
+	util.CheckErr(err)
+	c.Assert(infos, DeepEquals, []SearchParamInfo{
+		{
+			Resource: "Condition",
+			Name:     "subject-of",
+			Type:     "reference",
+			Paths:    []SearchParamPath{{Path: "subject", Type: "Reference"}},
+			Targets:  []string{"Patient"},
+		},
+		{
+			Resource: "Observation",
+			Name:     "subject-of",
+			Type:     "reference",
+			Paths:    []SearchParamPath{{Path: "subject", Type: "Reference"}},
+			Targets:  []string{"Patient"},
+		},
+	})
+
+	sp.Type = "composite"
+	_, err = SearchParamInfosFromResource(sp)
+	c.Assert(err, ErrorMatches, "SearchParameter subject-of has an unsupported type: composite")
+}
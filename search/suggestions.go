@@ -0,0 +1,106 @@
+package search
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// maxSuggestionDistance is the largest number of single-character edits (ignoring case)
+	// that a suggested parameter name may be from an unknown parameter's name
+	maxSuggestionDistance = 2
+
+	// maxSuggestions is the largest number of parameter names suggested for an unknown parameter
+	maxSuggestions = 3
+)
+
+// suggestParams returns the names of the parameters that can be used to search a resource
+// that are closest to an unknown parameter (e.g. "birthdate" for "birthDate"), closest first.
+func suggestParams(resource, param string) []string {
+	candidates := make(map[string]bool)
+	for name := range SearchParameters()[resource] {
+		candidates[name] = true
+	}
+	for name := range searchResultParams {
+		candidates[name] = true
+	}
+	for name := range globalSearchParams {
+		candidates[name] = true
+	}
+
+	distances := make(map[string]int)
+	var suggestions []string
+	for name := range candidates {
+		distance := editDistance(strings.ToLower(param), strings.ToLower(name))
+		if name != param && distance <= maxSuggestionDistance {
+			distances[name] = distance
+			suggestions = append(suggestions, name)
+		}
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		a, b := suggestions[i], suggestions[j]
+		if distances[a] != distances[b] {
+			return distances[a] < distances[b]
+		}
+		return a < b
+	})
+	if len(suggestions) > maxSuggestions {
+		suggestions = suggestions[:maxSuggestions]
+	}
+	return suggestions
+}
+
+// editDistance returns the Levenshtein distance between two strings: the number of
+// single-character insertions, deletions and substitutions that change one into the other.
+func editDistance(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	previous := make([]int, len(br)+1)
+	current := make([]int, len(br)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ar); i++ {
+		current[0] = i
+		for j := 1; j <= len(br); j++ {
+			substitution := previous[j-1]
+			if ar[i-1] != br[j-1] {
+				substitution++
+			}
+			current[j] = minInt(substitution, minInt(previous[j]+1, current[j-1]+1))
+		}
+		previous, current = current, previous
+	}
+	return previous[len(br)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// withParamSuggestions adds the closest valid parameter names (if any) to the
+// diagnostics of an error about an unknown parameter, e.g.
+// `Did you mean "birthdate"?`
+func withParamSuggestions(err *Error, resource, param string) *Error {
+	suggestions := suggestParams(resource, param)
+	if len(suggestions) == 0 {
+		return err
+	}
+
+	quoted := make([]string, len(suggestions))
+	for i, suggestion := range suggestions {
+		quoted[i] = fmt.Sprintf("\"%s\"", suggestion)
+	}
+	didYouMean := quoted[0]
+	if len(quoted) > 1 {
+		didYouMean = strings.Join(quoted[:len(quoted)-1], ", ") + " or " + quoted[len(quoted)-1]
+	}
+
+	err.OperationOutcome.Issue[0].Diagnostics = fmt.Sprintf("Did you mean %s?", didYouMean)
+	return err
+}
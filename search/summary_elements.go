@@ -0,0 +1,248 @@
+package search
+
+// SummaryElements provides a mapping from FHIR resource names to their top-level
+// elements that are marked as summary elements, which are returned for _summary=true.
+// Choice elements (e.g. value[x]) are listed with each of their possible types.
+// They're the elements with isSummary set in the FHIR STU3 resource definitions
+// (fsharp-fhir-tools/PathsByType/STU3/profiles-resources.json).
+var SummaryElements = map[string][]string{
+	"Account":                    []string{"meta", "implicitRules", "identifier", "status", "type", "name", "subject", "period", "active", "coverage", "owner", "description"},
+	"ActivityDefinition":         []string{"meta", "implicitRules", "url", "identifier", "version", "name", "title", "status", "experimental", "date", "publisher", "description", "effectivePeriod", "useContext", "jurisdiction", "contact"},
+	"AdverseEvent":               []string{"meta", "implicitRules", "identifier", "category", "type", "subject", "date", "reaction", "location", "seriousness", "outcome", "recorder", "eventParticipant", "description", "suspectEntity", "subjectMedicalHistory", "referenceDocument", "study"},
+	"AllergyIntolerance":         []string{"meta", "implicitRules", "identifier", "clinicalStatus", "verificationStatus", "type", "category", "criticality", "code", "patient", "asserter"},
+	"Appointment":                []string{"meta", "implicitRules", "identifier", "status", "serviceCategory", "serviceType", "specialty", "appointmentType", "reason", "start", "end"},
+	"AppointmentResponse":        []string{"meta", "implicitRules", "identifier", "appointment", "participantType", "actor", "participantStatus"},
+	"AuditEvent":                 []string{"meta", "implicitRules", "type", "subtype", "action", "recorded", "outcome", "outcomeDesc", "purposeOfEvent"},
+	"Basic":                      []string{"meta", "implicitRules", "identifier", "code", "subject", "created", "author"},
+	"Binary":                     []string{"meta", "implicitRules", "contentType", "securityContext"},
+	"BodySite":                   []string{"meta", "implicitRules", "identifier", "active", "code", "description", "patient"},
+	"Bundle":                     []string{"meta", "implicitRules", "identifier", "type", "total", "link", "entry", "signature"},
+	"CapabilityStatement":        []string{"meta", "implicitRules", "url", "version", "name", "title", "status", "experimental", "date", "publisher", "contact", "useContext", "jurisdiction", "kind", "instantiates", "software", "implementation", "fhirVersion", "acceptUnknown", "format", "patchFormat", "implementationGuide", "profile", "rest", "messaging", "document"},
+	"CarePlan":                   []string{"meta", "implicitRules", "identifier", "definition", "basedOn", "replaces", "partOf", "status", "intent", "category", "title", "description", "subject", "context", "period", "author", "addresses"},
+	"CareTeam":                   []string{"meta", "implicitRules", "identifier", "status", "category", "name", "subject", "context", "period", "managingOrganization"},
+	"ChargeItem":                 []string{"meta", "implicitRules", "identifier", "status", "code", "subject", "context", "occurrenceDateTime", "occurrencePeriod", "occurrenceTiming", "quantity", "bodysite", "enterer", "enteredDate", "account"},
+	"Claim":                      []string{"meta", "implicitRules", "status"},
+	"ClaimResponse":              []string{"meta", "implicitRules", "status"},
+	"ClinicalImpression":         []string{"meta", "implicitRules", "identifier", "status", "code", "description", "subject", "context", "effectiveDateTime", "effectivePeriod", "date", "assessor", "problem"},
+	"CodeSystem":                 []string{"meta", "implicitRules", "url", "identifier", "version", "name", "title", "status", "experimental", "date", "publisher", "contact", "useContext", "jurisdiction", "caseSensitive", "valueSet", "hierarchyMeaning", "compositional", "versionNeeded", "content", "count", "filter", "property"},
+	"Communication":              []string{"meta", "implicitRules", "identifier", "definition", "basedOn", "partOf", "status", "notDone", "notDoneReason", "subject", "context", "reasonCode", "reasonReference"},
+	"CommunicationRequest":       []string{"meta", "implicitRules", "identifier", "basedOn", "replaces", "groupIdentifier", "status", "priority", "context", "occurrenceDateTime", "occurrencePeriod", "authoredOn", "requester", "reasonCode", "reasonReference"},
+	"CompartmentDefinition":      []string{"meta", "implicitRules", "url", "name", "title", "status", "experimental", "date", "publisher", "contact", "useContext", "jurisdiction", "code", "search", "resource"},
+	"Composition":                []string{"meta", "implicitRules", "identifier", "status", "type", "class", "subject", "encounter", "date", "author", "title", "confidentiality", "attester", "custodian", "relatesTo", "event"},
+	"ConceptMap":                 []string{"meta", "implicitRules", "url", "identifier", "version", "name", "title", "status", "experimental", "date", "publisher", "contact", "useContext", "jurisdiction", "sourceUri", "sourceReference", "targetUri", "targetReference"},
+	"Condition":                  []string{"meta", "implicitRules", "identifier", "clinicalStatus", "verificationStatus", "code", "bodySite", "subject", "context", "onsetDateTime", "onsetAge", "onsetPeriod", "onsetRange", "onsetString", "assertedDate", "asserter"},
+	"Consent":                    []string{"meta", "implicitRules", "identifier", "status", "category", "patient", "period", "dateTime", "consentingParty", "actor", "action", "organization", "sourceAttachment", "sourceIdentifier", "sourceReference", "sourceReference", "sourceReference", "sourceReference", "policyRule", "securityLabel", "purpose", "dataPeriod", "data", "except"},
+	"Contract":                   []string{"meta", "implicitRules", "identifier", "status", "issued", "applies", "subject", "topic", "type", "subType", "securityLabel"},
+	"Coverage":                   []string{"meta", "implicitRules", "identifier", "status", "type", "policyHolder", "subscriber", "subscriberId", "beneficiary", "period", "payor", "dependent", "sequence", "order", "network"},
+	"DataElement":                []string{"meta", "implicitRules", "url", "identifier", "version", "status", "experimental", "date", "publisher", "name", "title", "contact", "useContext", "jurisdiction", "stringency", "element"},
+	"DetectedIssue":              []string{"meta", "implicitRules", "identifier", "status", "category", "severity", "patient", "date", "author", "implicated"},
+	"Device":                     []string{"meta", "implicitRules", "udi", "status", "safety"},
+	"DeviceComponent":            []string{"meta", "implicitRules", "identifier", "type", "lastSystemChange", "source", "parent", "operationalStatus", "parameterGroup", "measurementPrinciple", "productionSpecification", "languageCode"},
+	"DeviceMetric":               []string{"meta", "implicitRules", "identifier", "type", "unit", "source", "parent", "operationalStatus", "color", "category", "measurementPeriod", "calibration"},
+	"DeviceRequest":              []string{"meta", "implicitRules", "identifier", "definition", "basedOn", "priorRequest", "groupIdentifier", "status", "intent", "priority", "codeReference", "codeCodeableConcept", "subject", "context", "occurrenceDateTime", "occurrencePeriod", "occurrenceTiming", "authoredOn", "requester", "performerType", "performer", "reasonCode", "reasonReference"},
+	"DeviceUseStatement":         []string{"meta", "implicitRules", "status"},
+	"DiagnosticReport":           []string{"meta", "implicitRules", "identifier", "status", "category", "code", "subject", "context", "effectiveDateTime", "effectivePeriod", "issued", "performer", "image"},
+	"DocumentManifest":           []string{"meta", "implicitRules", "masterIdentifier", "identifier", "status", "type", "subject", "created", "author", "recipient", "source", "description", "content", "related"},
+	"DocumentReference":          []string{"meta", "implicitRules", "masterIdentifier", "identifier", "status", "docStatus", "type", "class", "subject", "created", "indexed", "author", "authenticator", "custodian", "relatesTo", "description", "securityLabel", "content", "context"},
+	"EligibilityRequest":         []string{"meta", "implicitRules", "status"},
+	"EligibilityResponse":        []string{"meta", "implicitRules", "status"},
+	"Encounter":                  []string{"meta", "implicitRules", "identifier", "status", "class", "type", "subject", "episodeOfCare", "participant", "appointment", "reason", "diagnosis"},
+	"Endpoint":                   []string{"meta", "implicitRules", "identifier", "status", "connectionType", "name", "managingOrganization", "period", "payloadType", "payloadMimeType", "address"},
+	"EnrollmentRequest":          []string{"meta", "implicitRules", "status"},
+	"EnrollmentResponse":         []string{"meta", "implicitRules", "status"},
+	"EpisodeOfCare":              []string{"meta", "implicitRules", "status", "type", "diagnosis", "patient", "managingOrganization", "period"},
+	"ExpansionProfile":           []string{"meta", "implicitRules", "url", "identifier", "version", "name", "status", "experimental", "date", "publisher", "contact", "useContext", "jurisdiction", "fixedVersion", "excludedSystem", "includeDesignations", "designation", "includeDefinition", "activeOnly", "excludeNested", "excludeNotForUI", "excludePostCoordinated", "displayLanguage", "limitedExpansion"},
+	"ExplanationOfBenefit":       []string{"meta", "implicitRules", "status"},
+	"FamilyMemberHistory":        []string{"meta", "implicitRules", "identifier", "definition", "status", "notDone", "notDoneReason", "patient", "date", "name", "relationship", "gender", "ageAge", "ageRange", "ageString", "estimatedAge", "deceasedBoolean", "deceasedAge", "deceasedRange", "deceasedDate", "deceasedString", "reasonCode", "reasonReference"},
+	"Flag":                       []string{"meta", "implicitRules", "identifier", "status", "category", "code", "subject", "period", "encounter", "author"},
+	"Goal":                       []string{"meta", "implicitRules", "status", "category", "priority", "description", "subject", "startDate", "startCodeableConcept", "statusDate", "expressedBy"},
+	"GraphDefinition":            []string{"meta", "implicitRules", "url", "version", "name", "status", "experimental", "date", "publisher", "contact", "useContext", "jurisdiction"},
+	"Group":                      []string{"meta", "implicitRules", "identifier", "active", "type", "actual", "code", "name", "quantity"},
+	"GuidanceResponse":           []string{"meta", "implicitRules", "requestId", "identifier", "module", "status"},
+	"HealthcareService":          []string{"meta", "implicitRules", "identifier", "active", "providedBy", "category", "type", "specialty", "location", "name", "comment", "photo"},
+	"ImagingManifest":            []string{"meta", "implicitRules", "identifier", "patient", "authoringTime", "author", "description", "study"},
+	"ImagingStudy":               []string{"meta", "implicitRules", "uid", "accession", "identifier", "availability", "modalityList", "patient", "context", "started", "basedOn", "referrer", "interpreter", "endpoint", "numberOfSeries", "numberOfInstances", "procedureReference", "procedureCode", "reason", "description", "series"},
+	"Immunization":               []string{"meta", "implicitRules", "status", "notGiven", "practitioner", "note"},
+	"ImmunizationRecommendation": []string{"meta", "implicitRules", "identifier", "patient", "recommendation"},
+	"ImplementationGuide":        []string{"meta", "implicitRules", "url", "version", "name", "status", "experimental", "date", "publisher", "contact", "useContext", "jurisdiction", "fhirVersion", "dependency", "package", "global", "page"},
+	"Library":                    []string{"meta", "implicitRules", "url", "identifier", "version", "name", "title", "status", "experimental", "type", "date", "publisher", "description", "effectivePeriod", "useContext", "jurisdiction", "contact"},
+	"Linkage":                    []string{"meta", "implicitRules", "active", "author", "item"},
+	"List":                       []string{"meta", "implicitRules", "status", "mode", "title", "code", "subject", "date", "source"},
+	"Location":                   []string{"meta", "implicitRules", "identifier", "status", "operationalStatus", "name", "description", "mode", "type", "physicalType", "managingOrganization"},
+	"Measure":                    []string{"meta", "implicitRules", "url", "identifier", "version", "name", "title", "status", "experimental", "date", "publisher", "description", "effectivePeriod", "useContext", "jurisdiction", "contact", "disclaimer", "scoring", "compositeScoring", "type", "riskAdjustment", "rateAggregation", "rationale", "clinicalRecommendationStatement", "improvementNotation", "definition", "guidance", "set"},
+	"MeasureReport":              []string{"meta", "implicitRules", "identifier", "status", "type", "measure", "patient", "date", "reportingOrganization", "period"},
+	"Media":                      []string{"meta", "implicitRules", "identifier", "basedOn", "type", "subtype", "view", "subject", "context", "occurrenceDateTime", "occurrencePeriod", "operator", "reasonCode", "bodySite", "device", "height", "width", "frames", "duration"},
+	"Medication":                 []string{"meta", "implicitRules", "code", "status", "isBrand", "isOverTheCounter", "manufacturer"},
+	"MedicationAdministration":   []string{"meta", "implicitRules", "definition", "partOf", "status", "medicationCodeableConcept", "medicationReference", "subject", "effectiveDateTime", "effectivePeriod", "performer", "notGiven"},
+	"MedicationDispense":         []string{"meta", "implicitRules", "status", "medicationCodeableConcept", "medicationReference", "subject", "whenPrepared"},
+	"MedicationRequest":          []string{"meta", "implicitRules", "definition", "basedOn", "groupIdentifier", "status", "intent", "priority", "medicationCodeableConcept", "medicationReference", "subject", "authoredOn", "requester"},
+	"MedicationStatement":        []string{"meta", "implicitRules", "identifier", "basedOn", "partOf", "context", "status", "category", "medicationCodeableConcept", "medicationReference", "effectiveDateTime", "effectivePeriod", "dateAsserted", "subject", "taken"},
+	"MessageDefinition":          []string{"meta", "implicitRules", "url", "identifier", "version", "name", "title", "status", "experimental", "date", "publisher", "contact", "description", "useContext", "jurisdiction", "purpose", "base", "parent", "replaces", "event", "category", "focus"},
+	"MessageHeader":              []string{"meta", "implicitRules", "event", "destination", "receiver", "sender", "timestamp", "enterer", "author", "source", "responsible", "reason", "response", "focus"},
+	"NamingSystem":               []string{"meta", "implicitRules", "name", "status", "date", "publisher", "contact", "useContext", "jurisdiction"},
+	"NutritionOrder":             []string{"meta", "implicitRules", "status", "patient", "dateTime", "orderer"},
+	"Observation":                []string{"meta", "implicitRules", "identifier", "basedOn", "status", "code", "subject", "effectiveDateTime", "effectivePeriod", "issued", "performer", "valueQuantity", "valueCodeableConcept", "valueString", "valueBoolean", "valueRange", "valueRatio", "valueSampledData", "valueAttachment", "valueTime", "valueDateTime", "valuePeriod", "related", "component"},
+	"OperationDefinition":        []string{"meta", "implicitRules", "url", "version", "name", "status", "experimental", "date", "publisher", "contact", "useContext", "jurisdiction", "idempotent", "code", "base", "resource", "system", "type", "instance"},
+	"OperationOutcome":           []string{"meta", "implicitRules", "issue"},
+	"Organization":               []string{"meta", "implicitRules", "identifier", "active", "type", "name", "partOf"},
+	"Parameters":                 []string{"meta", "implicitRules", "parameter"},
+	"Patient":                    []string{"meta", "implicitRules", "identifier", "active", "name", "telecom", "gender", "birthDate", "deceasedBoolean", "deceasedDateTime", "address", "animal", "managingOrganization", "link"},
+	"PaymentNotice":              []string{"meta", "implicitRules", "status"},
+	"PaymentReconciliation":      []string{"meta", "implicitRules", "status"},
+	"Person":                     []string{"meta", "implicitRules", "name", "telecom", "gender", "birthDate", "managingOrganization", "active"},
+	"PlanDefinition":             []string{"meta", "implicitRules", "url", "identifier", "version", "name", "title", "type", "status", "experimental", "date", "publisher", "description", "effectivePeriod", "useContext", "jurisdiction", "contact"},
+	"Practitioner":               []string{"meta", "implicitRules", "identifier", "active", "name", "telecom", "address", "gender", "birthDate"},
+	"PractitionerRole":           []string{"meta", "implicitRules", "identifier", "active", "period", "practitioner", "organization", "code", "specialty", "location", "telecom"},
+	"Procedure":                  []string{"meta", "implicitRules", "identifier", "definition", "basedOn", "partOf", "status", "notDone", "notDoneReason", "category", "code", "subject", "context", "performedDateTime", "performedPeriod", "performer", "location", "reasonCode", "reasonReference", "bodySite", "outcome"},
+	"ProcedureRequest":           []string{"meta", "implicitRules", "identifier", "definition", "basedOn", "replaces", "requisition", "status", "intent", "priority", "doNotPerform", "category", "code", "subject", "context", "occurrenceDateTime", "occurrencePeriod", "occurrenceTiming", "asNeededBoolean", "asNeededCodeableConcept", "authoredOn", "requester", "performerType", "performer", "reasonCode", "reasonReference", "specimen", "bodySite"},
+	"ProcessRequest":             []string{"meta", "implicitRules", "status"},
+	"ProcessResponse":            []string{"meta", "implicitRules", "status"},
+	"Provenance":                 []string{"meta", "implicitRules", "target", "recorded"},
+	"Questionnaire":              []string{"meta", "implicitRules", "url", "identifier", "version", "name", "title", "status", "experimental", "date", "publisher", "effectivePeriod", "useContext", "jurisdiction", "contact", "code", "subjectType"},
+	"QuestionnaireResponse":      []string{"meta", "implicitRules", "identifier", "basedOn", "parent", "questionnaire", "status", "subject", "context", "authored", "author", "source"},
+	"ReferralRequest":            []string{"meta", "implicitRules", "identifier", "definition", "basedOn", "replaces", "groupIdentifier", "status", "intent", "type", "priority", "serviceRequested", "subject", "context", "occurrenceDateTime", "occurrencePeriod", "authoredOn", "requester", "recipient", "reasonCode", "reasonReference"},
+	"RelatedPerson":              []string{"meta", "implicitRules", "identifier", "active", "patient", "relationship", "name", "telecom", "gender", "birthDate", "address"},
+	"RequestGroup":               []string{"meta", "implicitRules", "identifier", "groupIdentifier", "status", "intent", "priority"},
+	"ResearchStudy":              []string{"meta", "implicitRules", "identifier", "title", "protocol", "partOf", "status", "category", "focus", "contact", "keyword", "jurisdiction", "enrollment", "period", "sponsor", "principalInvestigator", "site", "reasonStopped"},
+	"ResearchSubject":            []string{"meta", "implicitRules", "identifier", "status", "period", "study", "individual"},
+	"RiskAssessment":             []string{"meta", "implicitRules", "identifier", "method", "code", "subject", "context", "occurrenceDateTime", "occurrencePeriod", "condition", "performer"},
+	"Schedule":                   []string{"meta", "implicitRules", "identifier", "active", "serviceCategory", "serviceType", "specialty", "actor", "planningHorizon"},
+	"SearchParameter":            []string{"meta", "implicitRules", "url", "version", "name", "status", "experimental", "date", "publisher", "contact", "useContext", "jurisdiction", "code", "base", "type", "description"},
+	"Sequence":                   []string{"meta", "implicitRules", "identifier", "type", "coordinateSystem", "patient", "specimen", "device", "performer", "quantity", "referenceSeq", "variant", "observedSeq", "quality", "readCoverage", "repository", "pointer"},
+	"ServiceDefinition":          []string{"meta", "implicitRules", "url", "identifier", "version", "name", "title", "status", "experimental", "date", "publisher", "effectivePeriod", "useContext", "jurisdiction", "contact"},
+	"Slot":                       []string{"meta", "implicitRules", "identifier", "serviceCategory", "serviceType", "specialty", "appointmentType", "schedule", "status", "start", "end"},
+	"Specimen":                   []string{"meta", "implicitRules", "identifier", "accessionIdentifier", "status", "type", "subject", "receivedTime"},
+	"StructureDefinition":        []string{"meta", "implicitRules", "url", "identifier", "version", "name", "title", "status", "experimental", "date", "publisher", "contact", "useContext", "jurisdiction", "keyword", "fhirVersion", "kind", "abstract", "contextType", "context", "contextInvariant", "type", "baseDefinition", "derivation"},
+	"StructureMap":               []string{"meta", "implicitRules", "url", "identifier", "version", "name", "title", "status", "experimental", "date", "publisher", "contact", "useContext", "jurisdiction", "structure", "import", "group"},
+	"Subscription":               []string{"meta", "implicitRules", "status", "contact", "end", "reason", "criteria", "error", "channel", "tag"},
+	"Substance":                  []string{"meta", "implicitRules", "identifier", "status", "category", "code", "description", "instance", "ingredient"},
+	"SupplyDelivery":             []string{"meta", "implicitRules", "basedOn", "partOf", "status", "occurrenceDateTime", "occurrencePeriod", "occurrenceTiming"},
+	"SupplyRequest":              []string{"meta", "implicitRules", "identifier", "status", "category", "priority", "orderedItem", "occurrenceDateTime", "occurrencePeriod", "occurrenceTiming", "authoredOn", "requester", "supplier"},
+	"Task":                       []string{"meta", "implicitRules", "definitionUri", "definitionReference", "basedOn", "groupIdentifier", "partOf", "status", "statusReason", "businessStatus", "intent", "code", "description", "focus", "for", "context", "executionPeriod", "lastModified", "requester", "owner"},
+	"TestReport":                 []string{"meta", "implicitRules", "identifier", "name", "status", "testScript", "result", "score", "tester", "issued"},
+	"TestScript":                 []string{"meta", "implicitRules", "url", "identifier", "version", "name", "title", "status", "experimental", "date", "publisher", "contact", "useContext", "jurisdiction"},
+	"ValueSet":                   []string{"meta", "implicitRules", "url", "identifier", "version", "name", "title", "status", "experimental", "date", "publisher", "contact", "useContext", "jurisdiction", "immutable", "extensible"},
+	"VisionPrescription":         []string{"meta", "implicitRules", "status"},
+}
+
+// MandatoryElements provides a mapping from FHIR resource names to their top-level
+// elements with a minimum cardinality of 1, which are returned for _summary=text.
+var MandatoryElements = map[string][]string{
+	"Account":                    []string{},
+	"ActivityDefinition":         []string{"status"},
+	"AdverseEvent":               []string{},
+	"AllergyIntolerance":         []string{"verificationStatus", "patient"},
+	"Appointment":                []string{"status", "participant"},
+	"AppointmentResponse":        []string{"appointment", "participantStatus"},
+	"AuditEvent":                 []string{"type", "recorded", "agent", "source"},
+	"Basic":                      []string{"code"},
+	"Binary":                     []string{"contentType", "content"},
+	"BodySite":                   []string{"patient"},
+	"Bundle":                     []string{"type"},
+	"CapabilityStatement":        []string{"status", "date", "kind", "fhirVersion", "acceptUnknown", "format"},
+	"CarePlan":                   []string{"status", "intent", "subject"},
+	"CareTeam":                   []string{},
+	"ChargeItem":                 []string{"status", "code", "subject"},
+	"Claim":                      []string{},
+	"ClaimResponse":              []string{},
+	"ClinicalImpression":         []string{"status", "subject"},
+	"CodeSystem":                 []string{"status", "content"},
+	"Communication":              []string{"status"},
+	"CommunicationRequest":       []string{"status"},
+	"CompartmentDefinition":      []string{"url", "name", "status", "code", "search"},
+	"Composition":                []string{"status", "type", "subject", "date", "author", "title"},
+	"ConceptMap":                 []string{"status"},
+	"Condition":                  []string{"subject"},
+	"Consent":                    []string{"status", "patient"},
+	"Contract":                   []string{},
+	"Coverage":                   []string{},
+	"DataElement":                []string{"status", "element"},
+	"DetectedIssue":              []string{"status"},
+	"Device":                     []string{},
+	"DeviceComponent":            []string{"identifier", "type"},
+	"DeviceMetric":               []string{"identifier", "type", "category"},
+	"DeviceRequest":              []string{"intent", "codeReference", "codeCodeableConcept", "subject"},
+	"DeviceUseStatement":         []string{"status", "subject", "device"},
+	"DiagnosticReport":           []string{"status", "code"},
+	"DocumentManifest":           []string{"status", "content"},
+	"DocumentReference":          []string{"status", "type", "indexed", "content"},
+	"EligibilityRequest":         []string{},
+	"EligibilityResponse":        []string{},
+	"Encounter":                  []string{"status"},
+	"Endpoint":                   []string{"status", "connectionType", "payloadType", "address"},
+	"EnrollmentRequest":          []string{},
+	"EnrollmentResponse":         []string{},
+	"EpisodeOfCare":              []string{"status", "patient"},
+	"ExpansionProfile":           []string{"status"},
+	"ExplanationOfBenefit":       []string{},
+	"FamilyMemberHistory":        []string{"status", "patient", "relationship"},
+	"Flag":                       []string{"status", "code", "subject"},
+	"Goal":                       []string{"status", "description"},
+	"GraphDefinition":            []string{"name", "status", "start"},
+	"Group":                      []string{"type", "actual"},
+	"GuidanceResponse":           []string{"module", "status"},
+	"HealthcareService":          []string{},
+	"ImagingManifest":            []string{"patient", "study"},
+	"ImagingStudy":               []string{"uid", "patient"},
+	"Immunization":               []string{"status", "notGiven", "vaccineCode", "patient", "primarySource"},
+	"ImmunizationRecommendation": []string{"patient", "recommendation"},
+	"ImplementationGuide":        []string{"url", "name", "status"},
+	"Library":                    []string{"status", "type"},
+	"Linkage":                    []string{"item"},
+	"List":                       []string{"status", "mode"},
+	"Location":                   []string{},
+	"Measure":                    []string{"status"},
+	"MeasureReport":              []string{"status", "type", "measure", "period"},
+	"Media":                      []string{"type", "content"},
+	"Medication":                 []string{},
+	"MedicationAdministration":   []string{"status", "medicationCodeableConcept", "medicationReference", "subject", "effectiveDateTime", "effectivePeriod"},
+	"MedicationDispense":         []string{"medicationCodeableConcept", "medicationReference"},
+	"MedicationRequest":          []string{"intent", "medicationCodeableConcept", "medicationReference", "subject"},
+	"MedicationStatement":        []string{"status", "medicationCodeableConcept", "medicationReference", "subject", "taken"},
+	"MessageDefinition":          []string{"status", "date", "event"},
+	"MessageHeader":              []string{"event", "timestamp", "source"},
+	"NamingSystem":               []string{"name", "status", "kind", "date", "uniqueId"},
+	"NutritionOrder":             []string{"patient", "dateTime"},
+	"Observation":                []string{"status", "code"},
+	"OperationDefinition":        []string{"name", "status", "kind", "code", "system", "type", "instance"},
+	"OperationOutcome":           []string{"issue"},
+	"Organization":               []string{},
+	"Parameters":                 []string{},
+	"Patient":                    []string{},
+	"PaymentNotice":              []string{},
+	"PaymentReconciliation":      []string{},
+	"Person":                     []string{},
+	"PlanDefinition":             []string{"status"},
+	"Practitioner":               []string{},
+	"PractitionerRole":           []string{},
+	"Procedure":                  []string{"status", "subject"},
+	"ProcedureRequest":           []string{"status", "intent", "code", "subject"},
+	"ProcessRequest":             []string{},
+	"ProcessResponse":            []string{},
+	"Provenance":                 []string{"target", "recorded", "agent"},
+	"Questionnaire":              []string{"status"},
+	"QuestionnaireResponse":      []string{"status"},
+	"ReferralRequest":            []string{"status", "intent", "subject"},
+	"RelatedPerson":              []string{"patient"},
+	"RequestGroup":               []string{"status", "intent"},
+	"ResearchStudy":              []string{"status"},
+	"ResearchSubject":            []string{"status", "study", "individual"},
+	"RiskAssessment":             []string{"status"},
+	"Schedule":                   []string{"actor"},
+	"SearchParameter":            []string{"url", "name", "status", "code", "base", "type", "description"},
+	"Sequence":                   []string{"coordinateSystem"},
+	"ServiceDefinition":          []string{"status"},
+	"Slot":                       []string{"schedule", "status", "start", "end"},
+	"Specimen":                   []string{"subject"},
+	"StructureDefinition":        []string{"url", "name", "status", "kind", "abstract", "type"},
+	"StructureMap":               []string{"url", "name", "status", "group"},
+	"Subscription":               []string{"status", "reason", "criteria", "channel"},
+	"Substance":                  []string{"code"},
+	"SupplyDelivery":             []string{},
+	"SupplyRequest":              []string{},
+	"Task":                       []string{"status", "intent"},
+	"TestReport":                 []string{"status", "testScript", "result"},
+	"TestScript":                 []string{"url", "name", "status"},
+	"ValueSet":                   []string{"status"},
+	"VisionPrescription":         []string{},
+}
@@ -0,0 +1,157 @@
+package search
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/eug48/fhir/models2"
+)
+
+// A search of all resource types (e.g. GET [base]?_type=Patient,Observation&_lastUpdated=gt2019) is a Query
+// without a Resource.  Each of the types it searches (see Types) is searched in turn by the same query, so
+// its search parameters must be supported by all of them.  The results are grouped by type, in the order
+// of the types (so a _sort only orders those of each type).
+
+// IsSystemSearch checks if the query searches all resource types (or those of its _type parameter)
+func (q *Query) IsSystemSearch() bool {
+	return q.Resource == ""
+}
+
+// Types returns the resource types searched by a search of all resource types: those of its _type
+// parameters in the order given, or else those that may be in its _compartment, or else every
+// resource type that can be searched (in name order)
+func (q *Query) Types() []string {
+	types := q.typeParams()
+	if len(types) == 0 {
+		queryParams, _ := ParseQuery(q.Query)
+		if compartment := queryParams.Get(CompartmentParam); compartment != "" {
+			types = CompartmentResourceTypes(strings.SplitN(compartment, "/", 2)[0])
+		}
+	}
+	if len(types) == 0 {
+		types = make([]string, 0, len(SearchParameters()))
+		for resourceType := range SearchParameters() {
+			types = append(types, resourceType)
+		}
+		sort.Strings(types)
+	}
+	return types
+}
+
+// typeParams returns the resource types in the _type parameters of a query, without duplicates
+func (q *Query) typeParams() []string {
+	queryParams, _ := ParseQuery(q.Query)
+	var types []string
+	seen := make(map[string]bool)
+	for _, value := range queryParams.GetMulti(TypeParam) {
+		for _, resourceType := range strings.Split(value, ",") {
+			resourceType = strings.TrimSpace(resourceType)
+			if _, ok := SearchParameters()[resourceType]; !ok {
+				panic(createInvalidSearchError("MSG_PARAM_INVALID", "Parameter \"_type\" content is invalid"))
+			}
+			if !seen[resourceType] {
+				seen[resourceType] = true
+				types = append(types, resourceType)
+			}
+		}
+	}
+	return types
+}
+
+// ForType returns the query that searches one of the resource types of a search of all resource types
+func (q *Query) ForType(resourceType string) Query {
+	queryParams, _ := ParseQuery(q.Query)
+	var newParams URLQueryParameters
+	for _, queryParam := range queryParams.All() {
+		if queryParam.Key != TypeParam {
+			newParams.Add(queryParam.Key, queryParam.Value)
+		}
+	}
+	return Query{Resource: resourceType, Query: newParams.Encode()}
+}
+
+// systemSearchParams returns the search parameters of a search of all resource types, which are
+// checked against each of its types
+func (q *Query) systemSearchParams() []SearchParam {
+	var params []SearchParam
+	for i, resourceType := range q.Types() {
+		typeQuery := q.ForType(resourceType)
+		typeParams := typeQuery.Params()
+		if i == 0 {
+			params = typeParams
+		}
+	}
+	return params
+}
+
+// systemSearchOptions returns the options of a search of all resource types, which are checked
+// against each of its types.  Options that depend on the searched type aren't supported.
+func (q *Query) systemSearchOptions() *QueryOptions {
+	queryParams, _ := ParseQuery(q.Query)
+	for _, queryParam := range queryParams.All() {
+		param, _, _ := ParseParamNameModifierAndPostFix(queryParam.Key)
+		switch param {
+		case IncludeParam, RevIncludeParam, ContainedParam, ContainedTypeParam, GraphParam, CursorParam, ResultSetParam:
+			panic(createUnsupportedSearchError("MSG_PARAM_INVALID", fmt.Sprintf("Parameter \"%s\" is not supported when searching all resource types", param)))
+		}
+	}
+
+	var options *QueryOptions
+	for i, resourceType := range q.Types() {
+		typeQuery := q.ForType(resourceType)
+		typeOptions := typeQuery.Options()
+		if i == 0 {
+			options = typeOptions
+		}
+	}
+	options.Types = q.typeParams()
+	return options
+}
+
+// searchSystem returns a page of the results of a search of all resource types and their total.
+// Each type is counted so that the page can be split between them.
+func (m *MongoSearcher) searchSystem(query Query) (resources []*models2.Resource, total uint32, err error) {
+	options := query.Options()
+	offset, count := options.Offset, options.Count
+	if options.Summary == "count" {
+		count = 0
+	}
+	if options.MaxResults > 0 && offset+count > options.MaxResults {
+		// Only the first _maxresults matches (of all types) are returned
+		count = options.MaxResults - offset
+		if count < 0 {
+			count = 0
+		}
+	}
+
+	for _, resourceType := range query.Types() {
+		typeParams := map[string]string{
+			TotalParam:      "accurate",
+			OffsetParam:     strconv.Itoa(offset),
+			CountParam:      strconv.Itoa(count),
+			MaxResultsParam: "",
+		}
+		if count == 0 {
+			typeParams[SummaryParam] = "count"
+		}
+		typeQuery := query.ForType(resourceType)
+		typeQuery = typeQuery.withParams(typeParams)
+		// Keyset paging isn't used as the next page may need to continue with the next type
+		typeResources, typeTotal, _, err := m.searchPage(typeQuery, typeQuery.Options(), false)
+		if err != nil {
+			return nil, 0, err
+		}
+		resources = append(resources, typeResources...)
+		total += typeTotal
+
+		// The results of the next type come after all of these
+		offset -= int(typeTotal)
+		if offset < 0 {
+			offset = 0
+		}
+		count -= len(typeResources)
+	}
+	return resources, total, nil
+}
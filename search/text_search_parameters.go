@@ -0,0 +1,56 @@
+package search
+
+import "go.mongodb.org/mongo-driver/bson"
+
+// textScoreField is the field that the relevance of a resource to a _text or
+// _content search is projected to when sorting by _score.  It isn't converted to JSON.
+const textScoreField = "__textScore"
+
+// textScoreSortParameter is used for _sort=_score, which sorts the results of a
+// _text or _content search by relevance (most relevant first)
+var textScoreSortParameter = SearchParamInfo{
+	Name: ScoreSort,
+	Type: "score",
+	Paths: []SearchParamPath{
+		SearchParamPath{Path: textScoreField, Type: "score"},
+	},
+}
+
+// The _text and _content parameters apply to every resource, but aren't part of
+// the generated SearchParameterDictionary.  Both are backed by a single text index
+// on each collection (see the server's Indexer), so _text additionally restricts
+// the matches to the narrative.
+func init() {
+	var resources []string
+	for resource := range SearchParameters() {
+		resources = append(resources, resource)
+	}
+	for _, resource := range resources {
+		GlobalRegistry().RegisterParameterInfo(SearchParamInfo{
+			Resource: resource,
+			Name:     TextParam,
+			Type:     "text",
+			Paths: []SearchParamPath{
+				SearchParamPath{Path: "text.div", Type: "string"},
+			},
+		})
+		GlobalRegistry().RegisterParameterInfo(SearchParamInfo{
+			Resource: resource,
+			Name:     ContentParam,
+			Type:     "text",
+		})
+	}
+}
+
+// textScore is the $meta expression for the relevance of a resource to the query's text search
+var textScore = bson.M{"$meta": "textScore"}
+
+// sortsByTextScore checks if the results are sorted by _score.
+func (o *QueryOptions) sortsByTextScore() bool {
+	for _, sort := range o.Sort {
+		if sort.Parameter.Type == "score" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/eug48/fhir/auth"
+	"github.com/eug48/fhir/models"
+	"github.com/eug48/fhir/search"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// API keys (see auth.AuthTypeAPIKey) are stored in the default database, and managed by the clients with admin keys
+// with the /$api-keys API.  The first admin key is created with the "apikey" command of the server.
+
+// APIKeysCollection is the collection of the default database in which API keys are stored
+const APIKeysCollection = "apikeys"
+
+// apiKeyLookup returns the lookup of API keys for auth.APIKeyHandler
+func apiKeyLookup(dal DataAccessLayer) auth.APIKeyLookup {
+	return func(ctx context.Context, hash string) (*auth.APIKey, error) {
+		session := dal.StartSession(ctx, "")
+		defer session.Finish()
+		apiKey, err := session.GetAPIKeyByHash(hash)
+		if err == ErrNotFound {
+			return nil, nil
+		}
+		return apiKey, err
+	}
+}
+
+// APIKeysHandler handles GET /$api-keys with every API key (without the keys themselves)
+func APIKeysHandler(dal DataAccessLayer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer handlePanics(c)
+		c.Set("Action", "operation")
+
+		session := dal.StartSession(c.Request.Context(), "")
+		defer session.Finish()
+		apiKeys, err := session.APIKeys()
+		if err != nil {
+			panic(errors.Wrap(err, "APIKeys failed"))
+		}
+		c.JSON(http.StatusOK, apiKeys)
+	}
+}
+
+// CreateAPIKeyHandler handles POST /$api-keys with the permissions of an API key (its name, and optionally whether
+// it's read-only or an admin key and the resource types and tenants it's limited to), generating it.  It responds with 201
+// Created and the API key, along with the key itself, which can't be retrieved later.
+func CreateAPIKeyHandler(dal DataAccessLayer, config Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer handlePanics(c)
+		c.Set("Action", "operation")
+
+		var permissions auth.APIKey
+		if err := c.ShouldBindJSON(&permissions); err != nil {
+			oo := models.NewOperationOutcome("fatal", "structure", err.Error())
+			c.Render(http.StatusBadRequest, CustomFhirRenderer{oo, c})
+			return
+		}
+		if permissions.Name == "" {
+			oo := models.NewOperationOutcome("error", "required", "API keys need a name")
+			c.Render(http.StatusBadRequest, CustomFhirRenderer{oo, c})
+			return
+		}
+		for _, resourceType := range permissions.ResourceTypes {
+			if search.SearchParameters()[resourceType] == nil {
+				oo := models.NewOperationOutcome("error", "value", fmt.Sprintf("Unknown resource type %s", resourceType))
+				c.Render(http.StatusBadRequest, CustomFhirRenderer{oo, c})
+				return
+			}
+		}
+		for _, tenant := range permissions.Tenants {
+			if tenant != "*" && !tenantIDPattern.MatchString(tenant) {
+				oo := models.NewOperationOutcome("error", "value", fmt.Sprintf("Invalid tenant id %q", tenant))
+				c.Render(http.StatusBadRequest, CustomFhirRenderer{oo, c})
+				return
+			}
+		}
+		apiKey, key, err := auth.NewAPIKey(permissions.Name, permissions.ReadOnly, permissions.ResourceTypes, permissions.Admin)
+		if err != nil {
+			panic(errors.Wrap(err, "NewAPIKey failed"))
+		}
+		apiKey.Tenants = permissions.Tenants
+
+		session := dal.StartSession(c.Request.Context(), "")
+		defer session.Finish()
+		if err := session.CreateAPIKey(apiKey); err != nil {
+			panic(errors.Wrap(err, "CreateAPIKey failed"))
+		}
+		c.Header("Location", config.responseURL(c.Request, "$api-keys", apiKey.ID).String())
+		c.JSON(http.StatusCreated, struct {
+			*auth.APIKey
+			Key string `json:"key"`
+		}{apiKey, key})
+	}
+}
+
+// RevokeAPIKeyHandler handles DELETE /$api-keys/:id, deleting an API key so that it's no longer accepted
+func RevokeAPIKeyHandler(dal DataAccessLayer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer handlePanics(c)
+		c.Set("Action", "operation")
+
+		session := dal.StartSession(c.Request.Context(), "")
+		defer session.Finish()
+		err := session.RevokeAPIKey(c.Param("id"))
+		if err == ErrNotFound {
+			c.Status(http.StatusNotFound)
+			return
+		} else if err != nil {
+			panic(errors.Wrap(err, "RevokeAPIKey failed"))
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/eug48/fhir/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *ServerSuite) TestAPIKeys(c *C) {
+	config := DefaultConfig
+	config.Auth = auth.APIKeys()
+	config.CreateIndexes = false
+	dal := NewMongoDataAccessLayer(s.client, s.dbname, false, "", nil, config)
+	engine := gin.New()
+	RegisterRoutes(engine, make(map[string][]gin.HandlerFunc), dal, config)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+	defer s.DB().C(APIKeysCollection).DropCollection()
+
+	admin, adminKey, err := auth.NewAPIKey("ops", false, nil, true)
+	util.CheckErr(err)
+	session := dal.StartSession(context.Background(), "")
+	util.CheckErr(session.CreateAPIKey(admin))
+	session.Finish()
+
+	do := func(method, path, body, key string) *http.Response {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		util.CheckErr(err)
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set(auth.APIKeyHeader, key)
+		}
+		res, err := http.DefaultClient.Do(req)
+		util.CheckErr(err)
+		return res
+	}
+
+	// Admin keys create keys, which are only stored as their hashes
+	res := do("POST", "/$api-keys", `{"name": "reporting", "readOnly": true, "resourceTypes": ["Patient"]}`, adminKey)
+	c.Assert(res.StatusCode, Equals, http.StatusCreated)
+	var created struct {
+		ID  string `json:"id"`
+		Key string `json:"key"`
+	}
+	util.CheckErr(json.NewDecoder(res.Body).Decode(&created))
+	res.Body.Close()
+	count, err := s.DB().C(APIKeysCollection).Find(bson.M{"hash": auth.HashAPIKey(created.Key)}).Count()
+	util.CheckErr(err)
+	c.Assert(count, Equals, 1)
+	res = do("POST", "/$api-keys", `{"resourceTypes": ["Patient"]}`, adminKey)
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusBadRequest)
+
+	// Keys are authorized with their permissions
+	res = do("GET", "/Patient", "", created.Key)
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	res = do("POST", "/Patient", `{"resourceType": "Patient"}`, created.Key)
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusForbidden)
+	res = do("GET", "/Observation", "", created.Key)
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusForbidden)
+	res = do("GET", "/$api-keys", "", created.Key)
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusForbidden)
+	res = do("GET", "/Patient", "", "")
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusUnauthorized)
+	res = do("GET", "/metadata", "", "")
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+
+	// Revoked keys are no longer accepted
+	res = do("GET", "/$api-keys", "", adminKey)
+	var apiKeys []map[string]interface{}
+	util.CheckErr(json.NewDecoder(res.Body).Decode(&apiKeys))
+	res.Body.Close()
+	c.Assert(apiKeys, HasLen, 2)
+	c.Assert(apiKeys[0]["hash"], IsNil)
+	res = do("DELETE", "/$api-keys/"+created.ID, "", adminKey)
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusNoContent)
+	res = do("GET", "/Patient", "", created.Key)
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusUnauthorized)
+	res = do("DELETE", "/$api-keys/"+created.ID, "", adminKey)
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusNotFound)
+}
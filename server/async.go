@@ -0,0 +1,423 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eug48/fhir/auth"
+	"github.com/eug48/fhir/models"
+	"github.com/eug48/fhir/models2"
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AsyncJobsCollection is the collection of the default database in which requests run asynchronously are queued
+const AsyncJobsCollection = "asyncjobs"
+
+// The states of asynchronous jobs
+const (
+	AsyncJobQueued    = "queued"
+	AsyncJobRunning   = "running"
+	AsyncJobCompleted = "completed"
+	AsyncJobFailed    = "failed"
+)
+
+// asyncJobLease is how long a worker has to run a job before it's queued again (e.g. if its server stopped), which
+// is extended while it's running
+const asyncJobLease = 2 * time.Minute
+
+// asyncPollInterval is how often idle workers check for jobs queued by other servers (or left by stopped ones)
+const asyncPollInterval = 5 * time.Second
+
+// AsyncJob is a request run asynchronously (see AsyncRequestsMiddleware), which is queued in the AsyncJobsCollection
+// until a worker runs it and stores its response.  Jobs are deleted once they expire (using a TTL index on Expires).
+type AsyncJob struct {
+	Id     string `bson:"_id"`
+	Status string `bson:"status"`
+	// Owner is the client that queued the job (see asyncJobOwner), which is the only one that can get its status and
+	// response or delete it
+	Owner    string         `bson:"owner,omitempty"`
+	Request  AsyncRequest   `bson:"request"`
+	Response *AsyncResponse `bson:"response,omitempty"`
+	// Error is why a failed job couldn't be run
+	Error   string    `bson:"error,omitempty"`
+	Created time.Time `bson:"created"`
+	// LeaseExpires is when a running job is queued again, unless its worker completes it or extends its lease first
+	LeaseExpires time.Time  `bson:"leaseExpires"`
+	Expires      *time.Time `bson:"expires,omitempty"`
+}
+
+// AsyncRequest is a request run asynchronously.  Its credentials aren't stored (see asyncCredentialHeaders): it's run
+// with the identity that the auth middleware authenticated it with when it was queued.  Its headers are only kept
+// until it's run.
+type AsyncRequest struct {
+	Method   string              `bson:"method"`
+	Host     string              `bson:"host"`
+	URL      string              `bson:"url"`
+	Header   map[string][]string `bson:"header,omitempty"`
+	Body     []byte              `bson:"body,omitempty"`
+	Identity AsyncIdentity       `bson:"identity"`
+}
+
+// AsyncIdentity is the identity that the auth middleware set in the gin.Context of a request run asynchronously when
+// it was queued, which is set again when it's run (see restoreAsyncIdentity)
+type AsyncIdentity struct {
+	Scopes       []string `bson:"scopes,omitempty"`
+	Subject      string   `bson:"subject,omitempty"`
+	ClientID     string   `bson:"clientID,omitempty"`
+	Patient      string   `bson:"patient,omitempty"`
+	FHIRUser     string   `bson:"fhirUser,omitempty"`
+	Tenants      []string `bson:"tenants,omitempty"`
+	PurposeOfUse []string `bson:"purposeOfUse,omitempty"`
+}
+
+// asyncCredentialHeaders are the headers of requests run asynchronously that aren't stored, as they may have
+// credentials
+var asyncCredentialHeaders = []string{"Authorization", APIKeyHeader, "Cookie"}
+
+// AsyncResponse is the response to a request run asynchronously
+type AsyncResponse struct {
+	Status       int    `bson:"status"`
+	Location     string `bson:"location,omitempty"`
+	ETag         string `bson:"etag,omitempty"`
+	LastModified string `bson:"lastModified,omitempty"`
+	Body         []byte `bson:"body,omitempty"`
+}
+
+// runningAsyncJobs are the cancel functions of the jobs being run by this server's workers, by id, so that they're
+// cancelled when they're deleted
+var runningAsyncJobs = struct {
+	sync.Mutex
+	cancels map[string]context.CancelFunc
+}{cancels: make(map[string]context.CancelFunc)}
+
+// AsyncRequestsMiddleware returns middleware that runs searches and operations with a Prefer: respond-async header
+// asynchronously (see http://hl7.org/fhir/STU3/async.html): it queues them as AsyncJobs, which workers run (see
+// StartAsyncWorkers), and responds with 202 Accepted and the URL of their status in the Content-Location header (see
+// AsyncStatusHandler).  Bulk exports and imports, which are always asynchronous, are left to their handlers.  So are
+// the requests of OIDC and HEART sessions, whose identities can't be run with again, which are run synchronously.
+func AsyncRequestsMiddleware(dal DataAccessLayer, config Config, wake chan<- struct{}) gin.HandlerFunc {
+	sessions := config.Auth.Method == auth.AuthTypeOIDC || config.Auth.Method == auth.AuthTypeHEART
+	return func(c *gin.Context) {
+		if sessions || !strings.Contains(c.GetHeader("Prefer"), "respond-async") || !runsAsync(c.Request) {
+			c.Next()
+			return
+		}
+
+		body, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			outcome := models.NewOperationOutcome("fatal", "exception", fmt.Sprintf("failed to read the request: %s", err))
+			c.Render(http.StatusBadRequest, CustomFhirRenderer{outcome, c})
+			c.Abort()
+			return
+		}
+		// The request is run with its other preferences (e.g. handling=strict)
+		header := make(map[string][]string, len(c.Request.Header))
+		for name, values := range c.Request.Header {
+			header[name] = values
+		}
+		for _, name := range asyncCredentialHeaders {
+			delete(header, http.CanonicalHeaderKey(name))
+		}
+		var preferences []string
+		for _, preference := range strings.Split(c.GetHeader("Prefer"), ",") {
+			if preference = strings.TrimSpace(preference); preference != "" && preference != "respond-async" {
+				preferences = append(preferences, preference)
+			}
+		}
+		if len(preferences) > 0 {
+			header["Prefer"] = []string{strings.Join(preferences, ", ")}
+		} else {
+			delete(header, "Prefer")
+		}
+		identity := AsyncIdentity{
+			Scopes:       c.GetStringSlice("scopes"),
+			Subject:      c.GetString("subject"),
+			ClientID:     c.GetString("clientID"),
+			Patient:      c.GetString("patient"),
+			FHIRUser:     c.GetString("fhirUser"),
+			Tenants:      c.GetStringSlice("tenants"),
+			PurposeOfUse: c.GetStringSlice("purposeOfUse"),
+		}
+		job := &AsyncJob{
+			Id:      primitive.NewObjectID().Hex(),
+			Status:  AsyncJobQueued,
+			Owner:   asyncJobOwner(c),
+			Request: AsyncRequest{Method: c.Request.Method, Host: c.Request.Host, URL: c.Request.URL.RequestURI(), Header: header, Body: body, Identity: identity},
+			Created: time.Now(),
+		}
+
+		session := dal.StartSession(c.Request.Context(), "")
+		defer session.Finish()
+		if err := session.EnqueueAsyncJob(job); err != nil {
+			statusCode, outcome := ErrorToOpOutcome(errors.Wrap(err, "EnqueueAsyncJob failed"))
+			c.Render(statusCode, CustomFhirRenderer{outcome, c})
+			c.Abort()
+			return
+		}
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+
+		c.Header("Content-Location", config.responseURL(c.Request, "$async-status", job.Id).String())
+		c.AbortWithStatus(http.StatusAccepted)
+	}
+}
+
+// asyncJobOwner returns the client of a request that queues, or gets or deletes, an asynchronous job: its client id,
+// or else its subject, which are empty without auth
+func asyncJobOwner(c *gin.Context) string {
+	if clientID := c.GetString("clientID"); clientID != "" {
+		return clientID
+	}
+	return c.GetString("subject")
+}
+
+// restoreAsyncIdentity sets the identity that a request run asynchronously was authenticated with when it was
+// queued in its gin.Context, returning whether it's such a request (which the auth middleware doesn't authenticate
+// again, as its credentials aren't stored)
+func restoreAsyncIdentity(c *gin.Context) bool {
+	identity, isReplay := c.Request.Context().Value(asyncReplayKey{}).(*AsyncIdentity)
+	if !isReplay {
+		return false
+	}
+	if identity.Scopes != nil {
+		c.Set("scopes", identity.Scopes)
+	}
+	if identity.Tenants != nil {
+		c.Set("tenants", identity.Tenants)
+	}
+	if identity.PurposeOfUse != nil {
+		c.Set("purposeOfUse", identity.PurposeOfUse)
+	}
+	for key, value := range map[string]string{"subject": identity.Subject, "clientID": identity.ClientID, "patient": identity.Patient, "fhirUser": identity.FHIRUser} {
+		if value != "" {
+			c.Set(key, value)
+		}
+	}
+	return true
+}
+
+// runsAsync returns whether a request may be run asynchronously: searches and reads, and operations
+func runsAsync(r *http.Request) bool {
+	path := r.URL.Path
+	if strings.Contains(path, "/$export") || strings.Contains(path, "/$import") || strings.Contains(path, "/$async-status") || strings.Contains(path, "/$changes") || strings.HasPrefix(path, "/$api-keys") {
+		return false
+	}
+	switch r.Method {
+	case http.MethodGet:
+		return true
+	case http.MethodPost:
+		return strings.HasSuffix(path, "/_search") || strings.HasPrefix(path[strings.LastIndex(path, "/")+1:], "$")
+	}
+	return false
+}
+
+// StartAsyncWorkers starts the workers that run the requests queued by AsyncRequestsMiddleware (and by other
+// servers) through the engine, as many as Config.AsyncWorkers.  They're woken by the middleware when it queues a
+// request, and otherwise check for them every asyncPollInterval.
+func StartAsyncWorkers(e *gin.Engine, dal DataAccessLayer, config Config, wake <-chan struct{}) {
+	for i := 0; i < config.AsyncWorkers; i++ {
+		go func() {
+			for {
+				for runNextAsyncJob(e, dal, config) {
+				}
+				select {
+				case <-wake:
+				case <-time.After(asyncPollInterval):
+				}
+			}
+		}()
+	}
+}
+
+// runNextAsyncJob runs the next queued job, if there is one, returning whether there was
+func runNextAsyncJob(e *gin.Engine, dal DataAccessLayer, config Config) bool {
+	session := dal.StartSession(context.Background(), "")
+	defer session.Finish()
+	job, err := session.ClaimAsyncJob(asyncJobLease)
+	if err != nil {
+		glog.Errorf("failed to claim an async job: %+v", err)
+		return false
+	} else if job == nil {
+		return false
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runningAsyncJobs.Lock()
+	runningAsyncJobs.cancels[job.Id] = cancel
+	runningAsyncJobs.Unlock()
+	defer func() {
+		runningAsyncJobs.Lock()
+		delete(runningAsyncJobs.cancels, job.Id)
+		runningAsyncJobs.Unlock()
+		cancel()
+	}()
+
+	// The lease is extended (in a session of its own, as sessions can't be used concurrently) until the job is done
+	done := make(chan struct{})
+	go func() {
+		leaseSession := dal.StartSession(ctx, "")
+		defer leaseSession.Finish()
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(asyncJobLease / 2):
+				if err := leaseSession.ExtendAsyncJobLease(job.Id, asyncJobLease); err == ErrNotFound {
+					cancel() // deleted by another server
+				} else if err != nil {
+					glog.Errorf("failed to extend the lease of async job %s: %+v", job.Id, err)
+				}
+			}
+		}
+	}()
+	response, err := replayAsyncRequest(ctx, e, job.Request)
+	close(done)
+
+	if err != nil {
+		job.Status = AsyncJobFailed
+		job.Error = err.Error()
+	} else {
+		job.Status = AsyncJobCompleted
+		job.Response = response
+	}
+	expires := time.Now().Add(config.AsyncJobLifetime)
+	job.Expires = &expires
+	job.Request.Header = nil
+	if err := session.FinishAsyncJob(job); err != nil && err != ErrNotFound {
+		glog.Errorf("failed to store the response of async job %s: %+v", job.Id, err)
+	}
+	return true
+}
+
+// asyncReplayKey marks the contexts of the requests run by replayAsyncRequest, with their *AsyncIdentity
+type asyncReplayKey struct{}
+
+// replayAsyncRequest runs a request through the engine, with a JSON response whatever format it was requested in
+// (which AsyncStatusHandler renders its response in)
+func replayAsyncRequest(ctx context.Context, e *gin.Engine, request AsyncRequest) (*AsyncResponse, error) {
+	requestURL, err := url.Parse(request.URL)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse the URL of the request")
+	}
+	if query := requestURL.Query(); query.Get("_format") != "" {
+		query.Del("_format")
+		requestURL.RawQuery = query.Encode()
+	}
+	req, err := http.NewRequest(request.Method, requestURL.String(), bytes.NewReader(request.Body))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create the request")
+	}
+	req.Host = request.Host
+	for name, values := range request.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Accept", "application/fhir+json")
+
+	recorder := httptest.NewRecorder()
+	e.ServeHTTP(recorder, req.WithContext(context.WithValue(ctx, asyncReplayKey{}, &request.Identity)))
+	if ctx.Err() != nil {
+		return nil, errors.Wrap(ctx.Err(), "the request was cancelled")
+	}
+	return &AsyncResponse{
+		Status:       recorder.Code,
+		Location:     recorder.Header().Get("Location"),
+		ETag:         recorder.Header().Get("ETag"),
+		LastModified: recorder.Header().Get("Last-Modified"),
+		Body:         recorder.Body.Bytes(),
+	}, nil
+}
+
+// AsyncStatusHandler returns a handler of requests for the status of asynchronous requests (GET
+// /$async-status/[id]), responding with 202 Accepted while they're queued or running, or else with a batch-response
+// Bundle with their response.  Only the clients that queued them can get them, as for others they're not found.
+func AsyncStatusHandler(dal DataAccessLayer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer handlePanics(c)
+		c.Set("Action", "operation")
+
+		session := dal.StartSession(c.Request.Context(), "")
+		defer session.Finish()
+		job, err := session.GetAsyncJob(c.Param("id"))
+		if err == ErrNotFound || (err == nil && job.Owner != asyncJobOwner(c)) {
+			c.Status(http.StatusNotFound)
+			return
+		} else if err != nil {
+			panic(errors.Wrap(err, "GetAsyncJob failed"))
+		}
+
+		switch job.Status {
+		case AsyncJobQueued, AsyncJobRunning:
+			c.Header("X-Progress", job.Status)
+			c.Header("Retry-After", strconv.Itoa(bulkExportRetryAfter))
+			c.Status(http.StatusAccepted)
+			return
+		case AsyncJobFailed:
+			outcome := models.NewOperationOutcome("fatal", "exception", fmt.Sprintf("The request failed: %s", job.Error))
+			c.Render(http.StatusInternalServerError, CustomFhirRenderer{outcome, c})
+			return
+		}
+
+		// The response's body is its resource, or its outcome if it failed
+		response := job.Response
+		entry := models2.ShallowBundleEntryComponent{
+			Response: &models.BundleEntryResponseComponent{
+				Status:   strconv.Itoa(response.Status),
+				Location: response.Location,
+				Etag:     response.ETag,
+			},
+		}
+		if lastModified, err := http.ParseTime(response.LastModified); err == nil {
+			entry.Response.LastModified = &models.FHIRDateTime{Time: lastModified.UTC(), Precision: models.Timestamp}
+		}
+		if resource, err := models2.NewResourceFromJsonBytes(response.Body); err == nil && len(response.Body) > 0 {
+			if response.Status >= 400 {
+				entry.Response.Outcome = resource
+			} else {
+				entry.Resource = resource
+			}
+		}
+		bundle := &models2.ShallowBundle{Type: "batch-response", Entry: []models2.ShallowBundleEntryComponent{entry}}
+		c.Render(http.StatusOK, CustomFhirRenderer{bundle, c})
+	}
+}
+
+// AsyncDeleteHandler returns a handler of requests to cancel asynchronous requests, or delete their responses
+// (DELETE /$async-status/[id]), which only the clients that queued them can
+func AsyncDeleteHandler(dal DataAccessLayer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer handlePanics(c)
+		c.Set("Action", "operation")
+
+		session := dal.StartSession(c.Request.Context(), "")
+		defer session.Finish()
+		err := session.DeleteAsyncJob(c.Param("id"), asyncJobOwner(c))
+		if err == ErrNotFound {
+			c.Status(http.StatusNotFound)
+			return
+		} else if err != nil {
+			panic(errors.Wrap(err, "DeleteAsyncJob failed"))
+		}
+
+		// Jobs being run by other servers are cancelled when they next extend their leases
+		runningAsyncJobs.Lock()
+		if cancel, running := runningAsyncJobs.cancels[c.Param("id")]; running {
+			cancel()
+		}
+		runningAsyncJobs.Unlock()
+		c.Status(http.StatusAccepted)
+	}
+}
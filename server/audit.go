@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/eug48/fhir/models"
+	"github.com/eug48/fhir/models2"
+	"github.com/gin-gonic/gin"
+)
+
+// Audit events (see Config.EnableAudit) are queued by the middleware and written by a background goroutine in
+// batches, so that requests don't wait for them.  Requests only wait if the queue is full.
+const (
+	auditQueueSize = 10000
+	auditBatchSize = 100
+)
+
+// auditor writes the AuditEvents of requests to the databases they accessed
+type auditor struct {
+	dal    DataAccessLayer
+	source string
+	events chan auditRecord
+}
+
+type auditRecord struct {
+	dbname string
+	event  *models.AuditEvent
+}
+
+func newAuditor(dal DataAccessLayer, source string) *auditor {
+	a := &auditor{dal: dal, source: source, events: make(chan auditRecord, auditQueueSize)}
+	go a.run()
+	return a
+}
+
+func (a *auditor) run() {
+	for record := range a.events {
+		// write the events that are queued along with this one together
+		batches := map[string][]*models.AuditEvent{record.dbname: {record.event}}
+		for pending := len(a.events); pending > 0 && pending < auditBatchSize; pending-- {
+			record := <-a.events
+			batches[record.dbname] = append(batches[record.dbname], record.event)
+		}
+		for dbname, events := range batches {
+			a.write(dbname, events)
+		}
+	}
+}
+
+func (a *auditor) write(dbname string, events []*models.AuditEvent) {
+	resources := make([]*models2.Resource, 0, len(events))
+	for _, event := range events {
+		eventJSON, err := json.Marshal(event)
+		if err == nil {
+			var resource *models2.Resource
+			if resource, err = models2.NewResourceFromJsonBytes(eventJSON); err == nil {
+				resources = append(resources, resource)
+				continue
+			}
+		}
+		log.Printf("Audit: failed to encode an AuditEvent: %+v\n", err)
+	}
+
+	session := a.dal.StartSession(context.Background(), dbname)
+	defer session.Finish()
+	errs, err := session.InsertMany("AuditEvent", resources)
+	if err != nil {
+		log.Printf("Audit: failed to write %d AuditEvents: %+v\n", len(resources), err)
+		return
+	}
+	for _, err := range errs {
+		if err != nil {
+			log.Printf("Audit: failed to write an AuditEvent: %+v\n", err)
+		}
+	}
+}
+
+// auditActions are the AuditEvent actions of interactions (the others are executions)
+var auditActions = map[string]string{
+	"create":  "C",
+	"read":    "R",
+	"vread":   "R",
+	"history": "R",
+	"update":  "U",
+	"patch":   "U",
+	"delete":  "D",
+}
+
+// middleware records an AuditEvent for each interaction of the server (who made it, what it accessed, when, its
+// query and its outcome), except reads of the CapabilityStatement.  The interactions are those that the handlers
+// set as the Action in the gin.Context.
+func (a *auditor) middleware(c *gin.Context) {
+	recorded := time.Now()
+	c.Next()
+
+	interaction := c.GetString("Action")
+	if interaction == "" || interaction == "capabilities" || c.GetBool("audited") {
+		return
+	}
+	// requests that are handled again (e.g. those with a Db in their path) are only audited once
+	c.Set("audited", true)
+
+	requestor := true
+	agent := models.AuditEventAgentComponent{
+		Requestor: &requestor,
+		AltId:     c.GetString("clientID"),
+		Network:   &models.AuditEventAgentNetworkComponent{Address: c.ClientIP(), Type: "2"},
+	}
+	if user := c.GetString("fhirUser"); user != "" {
+		agent.Reference = &models.Reference{Reference: user}
+	}
+	if subject := c.GetString("subject"); subject != "" {
+		agent.UserId = &models.Identifier{Value: subject}
+	}
+
+	status := c.Writer.Status()
+	outcome := "0"
+	if status >= 500 {
+		outcome = "8"
+	} else if status >= 400 {
+		outcome = "4"
+	}
+	action := auditActions[interaction]
+	if action == "" {
+		action = "E"
+	}
+	event := &models.AuditEvent{
+		Type:        &models.Coding{System: "http://hl7.org/fhir/audit-event-type", Code: "rest", Display: "RESTful Operation"},
+		Subtype:     []models.Coding{{System: "http://hl7.org/fhir/restful-interaction", Code: interaction}},
+		Action:      action,
+		Recorded:    &models.FHIRDateTime{Time: recorded, Precision: models.Timestamp},
+		Outcome:     outcome,
+		OutcomeDesc: http.StatusText(status),
+		Agent:       []models.AuditEventAgentComponent{agent},
+		Source:      &models.AuditEventSourceComponent{Identifier: &models.Identifier{Value: a.source}},
+	}
+
+	entity := models.AuditEventEntityComponent{
+		Description: c.Request.Method + " " + c.Request.URL.Path,
+	}
+	resourceType := c.GetString("Resource")
+	if resourceType != "" {
+		entity.Type = &models.Coding{System: "http://hl7.org/fhir/resource-types", Code: resourceType}
+	}
+	if id := auditedID(c); resourceType != "" && id != "" {
+		entity.Reference = &models.Reference{Reference: resourceType + "/" + id}
+	}
+	if c.Request.URL.RawQuery != "" {
+		entity.Query = base64.StdEncoding.EncodeToString([]byte(c.Request.URL.RawQuery))
+	}
+	event.Entity = []models.AuditEventEntityComponent{entity}
+
+	// this only blocks if the queue is full
+	a.events <- auditRecord{dbname: c.GetHeader("Db"), event: event}
+}
+
+// auditedID returns the id of the resource that a request accessed, which is that of its Location if it created one
+func auditedID(c *gin.Context) string {
+	if id := c.Param("id"); id != "" {
+		return id
+	}
+	location := c.Writer.Header().Get("Location")
+	if i := strings.Index(location, "/_history/"); i >= 0 {
+		location = location[:i]
+	}
+	if i := strings.LastIndex(location, "/"); i >= 0 {
+		return location[i+1:]
+	}
+	return ""
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/eug48/fhir/models"
+	"github.com/gin-gonic/gin"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *ServerSuite) TestAuditEvents(c *C) {
+	config := DefaultConfig
+	config.EnableAudit = true
+	engine := gin.New()
+	RegisterRoutes(engine, make(map[string][]gin.HandlerFunc), NewMongoDataAccessLayer(s.client, s.dbname, true, "_fhir", nil, config), config)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+	defer s.DB().C("auditevents").DropCollection()
+
+	res, err := http.Post(server.URL+"/Patient", "application/fhir+json", strings.NewReader(`{"resourceType": "Patient"}`))
+	util.CheckErr(err)
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusCreated)
+	id := resourceIdFromLocation(res)
+	res, err = http.Get(server.URL + "/Patient?gender=male")
+	util.CheckErr(err)
+	res.Body.Close()
+	res, err = http.Get(server.URL + "/Patient/not-there")
+	util.CheckErr(err)
+	res.Body.Close()
+	res, err = http.Get(server.URL + "/metadata")
+	util.CheckErr(err)
+	res.Body.Close()
+
+	// The events are written in the background
+	events := s.DB().C("auditevents")
+	for i := 0; i < 50; i++ {
+		if count, _ := events.Count(); count >= 3 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	count, err := events.Count()
+	util.CheckErr(err)
+	c.Assert(count, Equals, 3)
+
+	var created, searched, notFound models.AuditEvent
+	util.CheckErr(events.Find(bson.M{"subtype.code": "create"}).One(&created))
+	c.Assert(created.Action, Equals, "C")
+	c.Assert(created.Outcome, Equals, "0")
+	c.Assert(created.Entity[0].Reference.Reference, Equals, "Patient/"+id)
+
+	util.CheckErr(events.Find(bson.M{"subtype.code": "search"}).One(&searched))
+	c.Assert(searched.Action, Equals, "E")
+	query, err := base64.StdEncoding.DecodeString(searched.Entity[0].Query)
+	util.CheckErr(err)
+	c.Assert(string(query), Equals, "gender=male")
+
+	util.CheckErr(events.Find(bson.M{"subtype.code": "read"}).One(&notFound))
+	c.Assert(notFound.Outcome, Equals, "4")
+	c.Assert(notFound.Entity[0].Reference.Reference, Equals, "Patient/not-there")
+}
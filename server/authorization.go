@@ -0,0 +1,371 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/eug48/fhir/auth"
+	"github.com/eug48/fhir/models2"
+	"github.com/eug48/fhir/search"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// Access that's only granted by SMART patient scopes (see auth.SMARTScopesHandler) is limited to the resources in the
+// compartment of the launch context's patient.  With SMART authorization, RegisterRoutes wraps the data access layer
+// so that the sessions of these requests only access those resources: searches (and conditional interactions) have
+// the compartment added to their MongoDB queries, reads of other resources (and the resources included in searches)
+// aren't found, and writes of them are forbidden (ErrForbidden).
+
+type compartmentContextKey struct{}
+
+// restrictToCompartment is middleware that limits the sessions of a request to the patient compartment that
+// auth.SMARTScopesHandler set in its gin.Context, if any
+func restrictToCompartment(c *gin.Context) {
+	if patient := c.GetString("patientCompartment"); patient != "" {
+		ctx := context.WithValue(c.Request.Context(), compartmentContextKey{}, "Patient/"+patient)
+		c.Request = c.Request.WithContext(ctx)
+	}
+}
+
+// compartmentDataAccessLayer starts compartmentSessions for the requests that restrictToCompartment limited to a
+// compartment
+type compartmentDataAccessLayer struct {
+	DataAccessLayer
+}
+
+func (dal compartmentDataAccessLayer) StartSession(ctx context.Context, dbname string) DataAccessSession {
+	session := dal.DataAccessLayer.StartSession(ctx, dbname)
+	if compartment, restricted := ctx.Value(compartmentContextKey{}).(string); restricted {
+		return &compartmentSession{DataAccessSession: session, compartment: compartment}
+	}
+	return session
+}
+
+// compartmentSession is a session that only accesses the resources in a compartment (e.g. Patient/123)
+type compartmentSession struct {
+	DataAccessSession
+	compartment string
+}
+
+// inCompartment returns whether a resource is in the session's compartment
+func (s *compartmentSession) inCompartment(resource *models2.Resource) (bool, error) {
+	compartments, err := search.ResourceCompartments(resource)
+	if err != nil {
+		return false, errors.Wrap(err, "inCompartment: ResourceCompartments failed")
+	}
+	for _, compartment := range compartments {
+		if compartment == s.compartment {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// checkWrite returns an ErrForbidden error unless a resource being written is in the session's compartment
+func (s *compartmentSession) checkWrite(resource *models2.Resource) error {
+	in, err := s.inCompartment(resource)
+	if err != nil {
+		return err
+	} else if !in {
+		return ErrForbidden{fmt.Sprintf("%s resources can only be written in the compartment of %s", resource.ResourceType(), s.compartment)}
+	}
+	return nil
+}
+
+// checkExisting returns an ErrForbidden error if a resource that's being updated or deleted exists and isn't in the
+// session's compartment
+func (s *compartmentSession) checkExisting(id, resourceType string) error {
+	existing, err := s.DataAccessSession.Get(id, resourceType)
+	if err == ErrNotFound || err == ErrDeleted {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	in, err := s.inCompartment(existing)
+	if err != nil {
+		return err
+	} else if !in {
+		return ErrForbidden{fmt.Sprintf("%s/%s isn't in the compartment of %s", resourceType, id, s.compartment)}
+	}
+	return nil
+}
+
+// restrict adds the session's compartment to a search query, returning an ErrForbidden error if the resources it
+// searches for can't be in it
+func (s *compartmentSession) restrict(query search.Query) (search.Query, error) {
+	compartmentType := strings.SplitN(s.compartment, "/", 2)[0]
+	for _, resourceType := range search.CompartmentResourceTypes(compartmentType) {
+		if resourceType == query.Resource {
+			compartmentParam := url.Values{search.CompartmentParam: {s.compartment}}
+			query.Query = mergeRawQueries(query.Query, compartmentParam.Encode())
+			return query, nil
+		}
+	}
+	if query.Resource == "" {
+		return query, ErrForbidden{fmt.Sprintf("Searches of all types can't be limited to the compartment of %s", s.compartment)}
+	}
+	return query, ErrForbidden{fmt.Sprintf("%s resources can't be in the compartment of %s", query.Resource, s.compartment)}
+}
+
+// filterEntries removes the entries of a bundle whose resources aren't in the session's compartment (or that have no
+// resources, e.g. those of deletions in histories)
+func (s *compartmentSession) filterEntries(bundle *models2.ShallowBundle) error {
+	entries := bundle.Entry[:0]
+	for _, entry := range bundle.Entry {
+		if entry.Resource == nil {
+			continue
+		}
+		in, err := s.inCompartment(entry.Resource)
+		if err != nil {
+			return err
+		} else if in {
+			entries = append(entries, entry)
+		}
+	}
+	bundle.Entry = entries
+	return nil
+}
+
+func (s *compartmentSession) Get(id, resourceType string) (*models2.Resource, error) {
+	resource, err := s.DataAccessSession.Get(id, resourceType)
+	if err != nil {
+		return resource, err
+	}
+	if in, err := s.inCompartment(resource); err != nil {
+		return nil, err
+	} else if !in {
+		return nil, ErrNotFound
+	}
+	return resource, nil
+}
+
+func (s *compartmentSession) GetVersion(id, versionId, resourceType string) (*models2.Resource, error) {
+	resource, err := s.DataAccessSession.GetVersion(id, versionId, resourceType)
+	if err != nil {
+		return resource, err
+	}
+	if in, err := s.inCompartment(resource); err != nil {
+		return nil, err
+	} else if !in {
+		return nil, ErrNotFound
+	}
+	return resource, nil
+}
+
+func (s *compartmentSession) Post(resource *models2.Resource) (string, error) {
+	if err := s.checkWrite(resource); err != nil {
+		return "", err
+	}
+	return s.DataAccessSession.Post(resource)
+}
+
+func (s *compartmentSession) ConditionalPost(query search.Query, resource *models2.Resource) (int, string, *models2.Resource, error) {
+	query, err := s.restrict(query)
+	if err == nil {
+		err = s.checkWrite(resource)
+	}
+	if err != nil {
+		return 0, "", nil, err
+	}
+	return s.DataAccessSession.ConditionalPost(query, resource)
+}
+
+func (s *compartmentSession) PostWithID(id string, resource *models2.Resource) error {
+	if err := s.checkWrite(resource); err != nil {
+		return err
+	}
+	return s.DataAccessSession.PostWithID(id, resource)
+}
+
+func (s *compartmentSession) InsertMany(resourceType string, resources []*models2.Resource) ([]error, error) {
+	return nil, ErrForbidden{"Bulk imports can't be limited to a compartment"}
+}
+
+func (s *compartmentSession) Put(id string, conditionalVersionId string, resource *models2.Resource) (bool, error) {
+	if err := s.checkWrite(resource); err != nil {
+		return false, err
+	}
+	if err := s.checkExisting(id, resource.ResourceType()); err != nil {
+		return false, err
+	}
+	return s.DataAccessSession.Put(id, conditionalVersionId, resource)
+}
+
+func (s *compartmentSession) ConditionalPut(query search.Query, conditionalVersionId string, resource *models2.Resource) (string, bool, error) {
+	query, err := s.restrict(query)
+	if err == nil {
+		err = s.checkWrite(resource)
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return s.DataAccessSession.ConditionalPut(query, conditionalVersionId, resource)
+}
+
+func (s *compartmentSession) Delete(id, resourceType string) (string, []ReferenceChange, error) {
+	if err := s.checkExisting(id, resourceType); err != nil {
+		return "", nil, err
+	}
+	return s.DataAccessSession.Delete(id, resourceType)
+}
+
+func (s *compartmentSession) ConditionalDelete(query search.Query) (int64, []ReferenceChange, error) {
+	query, err := s.restrict(query)
+	if err != nil {
+		return 0, nil, err
+	}
+	return s.DataAccessSession.ConditionalDelete(query)
+}
+
+func (s *compartmentSession) Search(baseURL url.URL, searchQuery search.Query) (*models2.ShallowBundle, error) {
+	searchQuery, err := s.restrict(searchQuery)
+	if err != nil {
+		return nil, err
+	}
+	bundle, err := s.DataAccessSession.Search(baseURL, searchQuery)
+	if err != nil {
+		return bundle, err
+	}
+	// the resources that the matches refer to (or that refer to them) may be in other compartments
+	return bundle, s.filterEntries(bundle)
+}
+
+func (s *compartmentSession) FindIDs(searchQuery search.Query) ([]string, error) {
+	searchQuery, err := s.restrict(searchQuery)
+	if err != nil {
+		return nil, err
+	}
+	return s.DataAccessSession.FindIDs(searchQuery)
+}
+
+func (s *compartmentSession) Explain(searchQuery search.Query) (*search.Explanation, error) {
+	searchQuery, err := s.restrict(searchQuery)
+	if err != nil {
+		return nil, err
+	}
+	return s.DataAccessSession.Explain(searchQuery)
+}
+
+func (s *compartmentSession) History(baseURL url.URL, resourceType string, id string, options HistoryOptions) (*models2.ShallowBundle, error) {
+	if id != "" {
+		if _, err := s.Get(id, resourceType); err != nil && err != ErrDeleted {
+			return nil, err
+		}
+	}
+	bundle, err := s.DataAccessSession.History(baseURL, resourceType, id, options)
+	if err != nil {
+		return bundle, err
+	}
+	return bundle, s.filterEntries(bundle)
+}
+
+func (s *compartmentSession) ReferencesTo(resourceType string, ids []string, max int) ([]ResourceReference, error) {
+	return nil, ErrForbidden{"References can't be limited to a compartment"}
+}
+
+func (s *compartmentSession) DanglingReferences(resourceTypes []string, max int) ([]ResourceReference, error) {
+	return nil, ErrForbidden{"References can't be limited to a compartment"}
+}
+
+func (s *compartmentSession) WatchChanges(resourceType string, resumeToken string) (*ChangeFeed, error) {
+	return nil, ErrForbidden{"Change feeds can't be limited to a compartment"}
+}
+
+// With SMART scopes (of bearer tokens, API keys or client certificates), requests to the routes of a resource type
+// are authorized by the scopes of that type (see RegisterController).  The resources included in searches (by
+// _include, _revinclude, $everything or $graph) and those of compartment searches (e.g. GET /Patient/123/Observation)
+// can be of other types, so the controllers also check the scopes of those.
+
+// usesSMARTScopes returns whether requests are authorized by the SMART scopes that the auth middleware sets in their
+// gin.Context
+func usesSMARTScopes(config Config) bool {
+	switch config.Auth.Method {
+	case auth.AuthTypeSMART, auth.AuthTypeAPIKey, auth.AuthTypeMutualTLS:
+		return true
+	}
+	return false
+}
+
+// scopesAllow returns whether the SMART scopes of a request grant a permission on a resource: user and system scopes
+// on any resource of their types, and patient scopes on those in the compartment of the launch context's patient
+func scopesAllow(c *gin.Context, resource *models2.Resource, permission byte) (bool, error) {
+	granted, grantedForPatient := auth.SMARTScopesGrant(c, resource.ResourceType(), permission)
+	patient := c.GetString("patient")
+	if granted || !grantedForPatient || patient == "" {
+		return granted, nil
+	}
+	compartments, err := search.ResourceCompartments(resource)
+	if err != nil {
+		return false, errors.Wrap(err, "scopesAllow: ResourceCompartments failed")
+	}
+	for _, compartment := range compartments {
+		if compartment == "Patient/"+patient {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// filterIncludedByScopes removes the included entries of a search's bundle whose resources the SMART scopes of the
+// request don't grant reads of
+func filterIncludedByScopes(c *gin.Context, config Config, bundle *models2.ShallowBundle) error {
+	if !usesSMARTScopes(config) {
+		return nil
+	}
+	entries := bundle.Entry[:0]
+	for _, entry := range bundle.Entry {
+		if entry.Resource != nil && entry.Search != nil && entry.Search.Mode == "include" {
+			if allowed, err := scopesAllow(c, entry.Resource, 'r'); err != nil {
+				return err
+			} else if !allowed {
+				continue
+			}
+		}
+		entries = append(entries, entry)
+	}
+	bundle.Entry = entries
+	return nil
+}
+
+// authorizeCompartmentSearch returns an ErrForbidden error unless the SMART scopes of a request grant searches of the
+// resources of a type in a compartment (e.g. Patient/123).  If only patient scopes grant them, and the compartment
+// isn't the patient's, the request is also limited to the patient's compartment (see restrictToCompartment).
+func authorizeCompartmentSearch(c *gin.Context, config Config, resourceType, compartment string) error {
+	if !usesSMARTScopes(config) {
+		return nil
+	}
+	granted, grantedForPatient := auth.SMARTScopesGrant(c, resourceType, 's')
+	patient := c.GetString("patient")
+	switch {
+	case granted:
+	case !grantedForPatient:
+		return ErrForbidden{fmt.Sprintf("You do not have permission to search %s resources", resourceType)}
+	case patient == "":
+		return ErrForbidden{"Patient scopes require a patient in the launch context"}
+	case compartment != "Patient/"+patient:
+		ctx := context.WithValue(c.Request.Context(), compartmentContextKey{}, "Patient/"+patient)
+		c.Request = c.Request.WithContext(ctx)
+	}
+	return nil
+}
+
+// searchableCompartmentTypes returns those of the types of a search of all the resources in a compartment that the
+// SMART scopes of a request grant searches of.  Those that only patient scopes grant searches of are left out unless
+// the compartment is the patient's.
+func searchableCompartmentTypes(c *gin.Context, config Config, types []string, compartment string) []string {
+	if !usesSMARTScopes(config) {
+		return types
+	}
+	patient := c.GetString("patient")
+	var searchable []string
+	for _, resourceType := range types {
+		granted, grantedForPatient := auth.SMARTScopesGrant(c, resourceType, 's')
+		if granted || (grantedForPatient && patient != "" && compartment == "Patient/"+patient) {
+			searchable = append(searchable, resourceType)
+		}
+	}
+	return searchable
+}
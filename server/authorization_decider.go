@@ -0,0 +1,241 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/eug48/fhir/models2"
+	"github.com/eug48/fhir/search"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// AuthorizationDecider decides whether the interactions of requests are allowed, so that access policies can be
+// centralized (e.g. in Open Policy Agent, see OPAAuthorizationDecider).  It's invoked by the sessions of requests
+// (see Config.AuthorizationDecider) for every interaction with the database, including those of batches,
+// transactions and operations, and denied interactions are rejected with a 403 and an OperationOutcome.
+type AuthorizationDecider interface {
+	Decide(ctx context.Context, request *AuthorizationRequest) (AuthorizationDecision, error)
+}
+
+// AuthorizationDeciderFunc is a function that is an AuthorizationDecider, for custom hooks
+type AuthorizationDeciderFunc func(ctx context.Context, request *AuthorizationRequest) (AuthorizationDecision, error)
+
+func (f AuthorizationDeciderFunc) Decide(ctx context.Context, request *AuthorizationRequest) (AuthorizationDecision, error) {
+	return f(ctx, request)
+}
+
+// AuthorizationRequest is an interaction that an AuthorizationDecider decides whether to allow
+type AuthorizationRequest struct {
+	// The authenticated subject of the request, if any (see the auth package), and the client it's using
+	Subject  string   `json:"subject,omitempty"`
+	ClientID string   `json:"clientId,omitempty"`
+	Scopes   []string `json:"scopes,omitempty"`
+	// The patient of a SMART launch context
+	Patient string `json:"patient,omitempty"`
+
+	// Action is the interaction: read, vread, search, create, update, delete or history
+	Action string `json:"action"`
+	// ResourceType is empty for interactions with all types (e.g. system-wide histories)
+	ResourceType string `json:"resourceType,omitempty"`
+	ID           string `json:"id,omitempty"`
+	// Query is the query of searches and of conditional creates, updates and deletes
+	Query *search.Query `json:"-"`
+}
+
+// AuthorizationDecision is an AuthorizationDecider's decision, with the reason for denying an interaction that's
+// returned to the client
+type AuthorizationDecision struct {
+	Allow  bool   `json:"allow"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// The actions of AuthorizationRequests
+const (
+	ActionRead    = "read"
+	ActionVRead   = "vread"
+	ActionSearch  = "search"
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionHistory = "history"
+)
+
+type authorizationSubjectKey struct{}
+
+// authorizationSubject is middleware that adds the subject that the auth package set in a request's gin.Context to
+// its context, so that its sessions' interactions are decided by the AuthorizationDecider.  Sessions that aren't
+// started with the context of a request (e.g. those of background jobs) aren't decided.
+func authorizationSubject(c *gin.Context) {
+	request := &AuthorizationRequest{
+		Subject:  c.GetString("subject"),
+		ClientID: c.GetString("clientID"),
+		Scopes:   c.GetStringSlice("scopes"),
+		Patient:  c.GetString("patient"),
+	}
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), authorizationSubjectKey{}, request))
+}
+
+// decidingDataAccessLayer starts decidingSessions for the requests that authorizationSubject added a subject to
+type decidingDataAccessLayer struct {
+	DataAccessLayer
+	decider AuthorizationDecider
+}
+
+func (dal decidingDataAccessLayer) StartSession(ctx context.Context, dbname string) DataAccessSession {
+	session := dal.DataAccessLayer.StartSession(ctx, dbname)
+	if subject, ok := ctx.Value(authorizationSubjectKey{}).(*AuthorizationRequest); ok {
+		return &decidingSession{DataAccessSession: session, ctx: ctx, decider: dal.decider, subject: *subject}
+	}
+	return session
+}
+
+// decidingSession is a session whose interactions are decided by an AuthorizationDecider
+type decidingSession struct {
+	DataAccessSession
+	ctx     context.Context
+	decider AuthorizationDecider
+	subject AuthorizationRequest
+}
+
+// decide returns an ErrForbidden error if the decider denies an interaction
+func (s *decidingSession) decide(action, resourceType, id string, query *search.Query) error {
+	request := s.subject
+	request.Action = action
+	request.ResourceType = resourceType
+	request.ID = id
+	request.Query = query
+	decision, err := s.decider.Decide(s.ctx, &request)
+	if err != nil {
+		return errors.Wrapf(err, "deciding whether to allow the %s of %s", action, resourceType)
+	}
+	if !decision.Allow {
+		reason := decision.Reason
+		if reason == "" {
+			reason = fmt.Sprintf("The %s interaction isn't allowed", action)
+		}
+		return ErrForbidden{reason}
+	}
+	return nil
+}
+
+func (s *decidingSession) Get(id, resourceType string) (*models2.Resource, error) {
+	if err := s.decide(ActionRead, resourceType, id, nil); err != nil {
+		return nil, err
+	}
+	return s.DataAccessSession.Get(id, resourceType)
+}
+
+func (s *decidingSession) GetVersion(id, versionId, resourceType string) (*models2.Resource, error) {
+	if err := s.decide(ActionVRead, resourceType, id, nil); err != nil {
+		return nil, err
+	}
+	return s.DataAccessSession.GetVersion(id, versionId, resourceType)
+}
+
+func (s *decidingSession) Post(resource *models2.Resource) (string, error) {
+	if err := s.decide(ActionCreate, resource.ResourceType(), "", nil); err != nil {
+		return "", err
+	}
+	return s.DataAccessSession.Post(resource)
+}
+
+func (s *decidingSession) ConditionalPost(query search.Query, resource *models2.Resource) (int, string, *models2.Resource, error) {
+	if err := s.decide(ActionCreate, resource.ResourceType(), "", &query); err != nil {
+		return 0, "", nil, err
+	}
+	return s.DataAccessSession.ConditionalPost(query, resource)
+}
+
+func (s *decidingSession) PostWithID(id string, resource *models2.Resource) error {
+	if err := s.decide(ActionCreate, resource.ResourceType(), id, nil); err != nil {
+		return err
+	}
+	return s.DataAccessSession.PostWithID(id, resource)
+}
+
+func (s *decidingSession) InsertMany(resourceType string, resources []*models2.Resource) ([]error, error) {
+	if err := s.decide(ActionCreate, resourceType, "", nil); err != nil {
+		return nil, err
+	}
+	return s.DataAccessSession.InsertMany(resourceType, resources)
+}
+
+func (s *decidingSession) Put(id string, conditionalVersionId string, resource *models2.Resource) (bool, error) {
+	if err := s.decide(ActionUpdate, resource.ResourceType(), id, nil); err != nil {
+		return false, err
+	}
+	return s.DataAccessSession.Put(id, conditionalVersionId, resource)
+}
+
+func (s *decidingSession) ConditionalPut(query search.Query, conditionalVersionId string, resource *models2.Resource) (string, bool, error) {
+	if err := s.decide(ActionUpdate, resource.ResourceType(), resource.Id(), &query); err != nil {
+		return "", false, err
+	}
+	return s.DataAccessSession.ConditionalPut(query, conditionalVersionId, resource)
+}
+
+func (s *decidingSession) Delete(id, resourceType string) (string, []ReferenceChange, error) {
+	if err := s.decide(ActionDelete, resourceType, id, nil); err != nil {
+		return "", nil, err
+	}
+	return s.DataAccessSession.Delete(id, resourceType)
+}
+
+func (s *decidingSession) ConditionalDelete(query search.Query) (int64, []ReferenceChange, error) {
+	if err := s.decide(ActionDelete, query.Resource, "", &query); err != nil {
+		return 0, nil, err
+	}
+	return s.DataAccessSession.ConditionalDelete(query)
+}
+
+func (s *decidingSession) Search(baseURL url.URL, searchQuery search.Query) (*models2.ShallowBundle, error) {
+	if err := s.decide(ActionSearch, searchQuery.Resource, "", &searchQuery); err != nil {
+		return nil, err
+	}
+	return s.DataAccessSession.Search(baseURL, searchQuery)
+}
+
+func (s *decidingSession) FindIDs(searchQuery search.Query) ([]string, error) {
+	if err := s.decide(ActionSearch, searchQuery.Resource, "", &searchQuery); err != nil {
+		return nil, err
+	}
+	return s.DataAccessSession.FindIDs(searchQuery)
+}
+
+func (s *decidingSession) Explain(searchQuery search.Query) (*search.Explanation, error) {
+	if err := s.decide(ActionSearch, searchQuery.Resource, "", &searchQuery); err != nil {
+		return nil, err
+	}
+	return s.DataAccessSession.Explain(searchQuery)
+}
+
+func (s *decidingSession) History(baseURL url.URL, resourceType string, id string, options HistoryOptions) (*models2.ShallowBundle, error) {
+	if err := s.decide(ActionHistory, resourceType, id, nil); err != nil {
+		return nil, err
+	}
+	return s.DataAccessSession.History(baseURL, resourceType, id, options)
+}
+
+func (s *decidingSession) ReferencesTo(resourceType string, ids []string, max int) ([]ResourceReference, error) {
+	// the references are found by searching resources of every type
+	if err := s.decide(ActionSearch, "", "", nil); err != nil {
+		return nil, err
+	}
+	return s.DataAccessSession.ReferencesTo(resourceType, ids, max)
+}
+
+func (s *decidingSession) DanglingReferences(resourceTypes []string, max int) ([]ResourceReference, error) {
+	if err := s.decide(ActionSearch, "", "", nil); err != nil {
+		return nil, err
+	}
+	return s.DataAccessSession.DanglingReferences(resourceTypes, max)
+}
+
+func (s *decidingSession) WatchChanges(resourceType string, resumeToken string) (*ChangeFeed, error) {
+	if err := s.decide(ActionSearch, resourceType, "", nil); err != nil {
+		return nil, err
+	}
+	return s.DataAccessSession.WatchChanges(resourceType, resumeToken)
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/eug48/fhir/search"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+func (s *ServerSuite) TestAuthorizationDecider(c *C) {
+	var requests []AuthorizationRequest
+	decider := AuthorizationDeciderFunc(func(ctx context.Context, request *AuthorizationRequest) (AuthorizationDecision, error) {
+		requests = append(requests, *request)
+		if request.Action == ActionDelete {
+			return AuthorizationDecision{Reason: "Nothing can be deleted"}, nil
+		}
+		return AuthorizationDecision{Allow: true}, nil
+	})
+	dal := decidingDataAccessLayer{NewMongoDataAccessLayer(s.client, s.dbname, true, "_fhir", nil, DefaultConfig), decider}
+
+	// Sessions that aren't started by requests aren't decided
+	session := dal.StartSession(context.TODO(), s.dbname)
+	defer session.Finish()
+	_, err := session.Get(s.FixtureID, "Patient")
+	util.CheckErr(err)
+	c.Assert(requests, HasLen, 0)
+
+	subject := &AuthorizationRequest{Subject: "alex", ClientID: "growth-chart", Scopes: []string{"user/*.*"}}
+	session = dal.StartSession(context.WithValue(context.TODO(), authorizationSubjectKey{}, subject), s.dbname)
+	defer session.Finish()
+	_, err = session.Get(s.FixtureID, "Patient")
+	util.CheckErr(err)
+	_, err = session.Search(url.URL{}, search.Query{Resource: "Patient", Query: "gender=male"})
+	util.CheckErr(err)
+	_, _, err = session.Delete(s.FixtureID, "Patient")
+	c.Assert(err, DeepEquals, ErrForbidden{"Nothing can be deleted"})
+
+	c.Assert(requests, HasLen, 3)
+	c.Assert(requests[0].Subject, Equals, "alex")
+	c.Assert(requests[0].ClientID, Equals, "growth-chart")
+	c.Assert(requests[0].Action, Equals, ActionRead)
+	c.Assert(requests[0].ResourceType, Equals, "Patient")
+	c.Assert(requests[0].ID, Equals, s.FixtureID)
+	c.Assert(requests[1].Action, Equals, ActionSearch)
+	c.Assert(requests[1].Query.Query, Equals, "gender=male")
+	c.Assert(requests[2].Action, Equals, ActionDelete)
+
+	// The patient wasn't deleted
+	_, err = session.Get(s.FixtureID, "Patient")
+	c.Assert(err, IsNil)
+}
+
+func (s *ServerSuite) TestOPAAuthorizationDecider(c *C) {
+	var input map[string]interface{}
+	result := `true`
+	opa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Assert(r.URL.Path, Equals, "/v1/data/fhir/authz")
+		var body map[string]map[string]interface{}
+		util.CheckErr(json.NewDecoder(r.Body).Decode(&body))
+		input = body["input"]
+		w.Write([]byte(`{"result": ` + result + `}`))
+	}))
+	defer opa.Close()
+	decider := NewOPAAuthorizationDecider(opa.URL + "/v1/data/fhir/authz")
+
+	query := search.Query{Resource: "Observation", Query: "patient=123&code=8302-2"}
+	decision, err := decider.Decide(context.TODO(), &AuthorizationRequest{Subject: "alex", Action: ActionSearch,
+		ResourceType: "Observation", Query: &query})
+	util.CheckErr(err)
+	c.Assert(decision.Allow, Equals, true)
+	c.Assert(input["subject"], Equals, "alex")
+	c.Assert(input["action"], Equals, "search")
+	c.Assert(input["resourceType"], Equals, "Observation")
+	c.Assert(input["query"], Equals, "patient=123&code=8302-2")
+	c.Assert(input["parameters"], DeepEquals, map[string]interface{}{
+		"patient": []interface{}{"123"},
+		"code":    []interface{}{"8302-2"},
+	})
+
+	result = `{"allow": false, "reason": "Alex can't read observations"}`
+	request := &AuthorizationRequest{Subject: "alex", Action: ActionRead, ResourceType: "Observation", ID: "1"}
+	decision, err = decider.Decide(context.TODO(), request)
+	util.CheckErr(err)
+	c.Assert(decision, DeepEquals, AuthorizationDecision{Allow: false, Reason: "Alex can't read observations"})
+	c.Assert(input["id"], Equals, "1")
+
+	// Undefined decisions deny interactions
+	result = `null`
+	decision, err = decider.Decide(context.TODO(), request)
+	util.CheckErr(err)
+	c.Assert(decision.Allow, Equals, false)
+}
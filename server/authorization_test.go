@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/eug48/fhir/auth"
+	"github.com/eug48/fhir/models"
+	"github.com/eug48/fhir/models2"
+	"github.com/eug48/fhir/search"
+	"github.com/gin-gonic/gin"
+	"github.com/pebbe/util"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+func (s *ServerSuite) TestCompartmentSessions(c *C) {
+	dal := compartmentDataAccessLayer{NewMongoDataAccessLayer(s.client, s.dbname, true, "_fhir", nil, DefaultConfig)}
+	resource := func(json string) *models2.Resource {
+		resource, err := models2.NewResourceFromJsonBytes([]byte(json))
+		util.CheckErr(err)
+		return resource
+	}
+
+	// Two patients with an observation each
+	defer s.DB().C("observations").DropCollection()
+	session := dal.StartSession(context.TODO(), s.dbname)
+	defer session.Finish()
+	c.Assert(session.PostWithID("alex", resource(`{"resourceType": "Patient", "gender": "male"}`)), IsNil)
+	c.Assert(session.PostWithID("sam", resource(`{"resourceType": "Patient", "gender": "female"}`)), IsNil)
+	c.Assert(session.PostWithID("alex-weight", resource(`{"resourceType": "Observation", "status": "final",
+		"code": {"text": "weight"}, "subject": {"reference": "Patient/alex"}}`)), IsNil)
+	c.Assert(session.PostWithID("sam-weight", resource(`{"resourceType": "Observation", "status": "final",
+		"code": {"text": "weight"}, "subject": {"reference": "Patient/sam"}}`)), IsNil)
+
+	ctx := context.WithValue(context.TODO(), compartmentContextKey{}, "Patient/alex")
+	restricted := dal.StartSession(ctx, s.dbname)
+	defer restricted.Finish()
+	_, isCompartmentSession := restricted.(*compartmentSession)
+	c.Assert(isCompartmentSession, Equals, true)
+
+	// Searches only find the resources in the compartment
+	baseURL := url.URL{Scheme: "http", Host: "fhir.example.com"}
+	bundle, err := restricted.Search(baseURL, search.Query{Resource: "Observation"})
+	util.CheckErr(err)
+	c.Assert(bundle.Entry, HasLen, 1)
+	c.Assert(bundle.Entry[0].Resource.Id(), Equals, "alex-weight")
+	bundle, err = restricted.Search(baseURL, search.Query{Resource: "Patient"})
+	util.CheckErr(err)
+	c.Assert(bundle.Entry, HasLen, 1)
+	c.Assert(bundle.Entry[0].Resource.Id(), Equals, "alex")
+	_, err = restricted.Search(baseURL, search.Query{Resource: "Medication"})
+	c.Assert(errors.Cause(err), FitsTypeOf, ErrForbidden{})
+
+	// Other resources aren't found
+	_, err = restricted.Get("alex-weight", "Observation")
+	c.Assert(err, IsNil)
+	_, err = restricted.Get("sam-weight", "Observation")
+	c.Assert(err, Equals, ErrNotFound)
+	_, err = restricted.Get("sam", "Patient")
+	c.Assert(err, Equals, ErrNotFound)
+
+	// and can't be written
+	_, err = restricted.Post(resource(`{"resourceType": "Observation", "status": "final",
+		"code": {"text": "height"}, "subject": {"reference": "Patient/sam"}}`))
+	c.Assert(err, FitsTypeOf, ErrForbidden{})
+	_, err = restricted.Put("sam-weight", "", resource(`{"resourceType": "Observation", "id": "sam-weight",
+		"status": "final", "code": {"text": "weight"}, "subject": {"reference": "Patient/alex"}}`))
+	c.Assert(err, FitsTypeOf, ErrForbidden{})
+	_, _, err = restricted.Delete("sam-weight", "Observation")
+	c.Assert(err, FitsTypeOf, ErrForbidden{})
+	_, err = restricted.Post(resource(`{"resourceType": "Observation", "status": "final",
+		"code": {"text": "height"}, "subject": {"reference": "Patient/alex"}}`))
+	c.Assert(err, IsNil)
+}
+
+func (s *ServerSuite) TestScopesOfCompartmentSearchesAndIncludes(c *C) {
+	config := DefaultConfig
+	config.Auth = auth.APIKeys()
+	config.CreateIndexes = false
+	dal := NewMongoDataAccessLayer(s.client, s.dbname, false, "_fhir", nil, config)
+	engine := gin.New()
+	RegisterRoutes(engine, make(map[string][]gin.HandlerFunc), dal, config)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+	defer s.DB().C(APIKeysCollection).DropCollection()
+	defer s.DB().C("observations").DropCollection()
+	defer s.DB().C("conditions").DropCollection()
+
+	session := dal.StartSession(context.Background(), "")
+	resource := func(json string) *models2.Resource {
+		resource, err := models2.NewResourceFromJsonBytes([]byte(json))
+		util.CheckErr(err)
+		return resource
+	}
+	util.CheckErr(session.PostWithID("alex", resource(`{"resourceType": "Patient", "gender": "male"}`)))
+	util.CheckErr(session.PostWithID("alex-weight", resource(`{"resourceType": "Observation", "status": "final",
+		"code": {"text": "weight"}, "subject": {"reference": "Patient/alex"}}`)))
+	util.CheckErr(session.PostWithID("alex-asthma", resource(`{"resourceType": "Condition",
+		"code": {"text": "asthma"}, "subject": {"reference": "Patient/alex"}}`)))
+	newKey := func(resourceTypes ...string) string {
+		apiKey, key, err := auth.NewAPIKey("test", true, resourceTypes, false)
+		util.CheckErr(err)
+		util.CheckErr(session.CreateAPIKey(apiKey))
+		return key
+	}
+	patientKey := newKey("Patient")
+	observationKey := newKey("Observation")
+	patientAndObservationKey := newKey("Patient", "Observation")
+	session.Finish()
+
+	get := func(path, key string) (int, []string) {
+		req, err := http.NewRequest("GET", server.URL+path, nil)
+		util.CheckErr(err)
+		req.Header.Set(auth.APIKeyHeader, key)
+		res, err := http.DefaultClient.Do(req)
+		util.CheckErr(err)
+		defer res.Body.Close()
+		var ids []string
+		if res.StatusCode == http.StatusOK {
+			var bundle models.Bundle
+			util.CheckErr(json.NewDecoder(res.Body).Decode(&bundle))
+			for _, entry := range bundle.Entry {
+				ids = append(ids, strings.TrimPrefix(entry.FullUrl, server.URL+"/"))
+			}
+		}
+		return res.StatusCode, ids
+	}
+
+	// The types searched in compartments need scopes of their own
+	status, _ := get("/Patient/alex/Observation", patientKey)
+	c.Assert(status, Equals, http.StatusForbidden)
+	status, ids := get("/Patient/alex/Observation", patientAndObservationKey)
+	c.Assert(status, Equals, http.StatusOK)
+	c.Assert(ids, DeepEquals, []string{"Observation/alex-weight"})
+
+	// and searches of all the types in them only search those
+	status, ids = get("/Patient/alex/*", patientKey)
+	c.Assert(status, Equals, http.StatusOK)
+	c.Assert(ids, DeepEquals, []string{"Patient/alex"})
+	status, ids = get("/Patient/alex/*", patientAndObservationKey)
+	c.Assert(status, Equals, http.StatusOK)
+	c.Assert(ids, DeepEquals, []string{"Observation/alex-weight", "Patient/alex"})
+
+	// Included resources are left out of searches unless they can be read
+	status, ids = get("/Observation?_include=Observation:subject", observationKey)
+	c.Assert(status, Equals, http.StatusOK)
+	c.Assert(ids, DeepEquals, []string{"Observation/alex-weight"})
+	status, ids = get("/Observation?_include=Observation:subject", patientAndObservationKey)
+	c.Assert(status, Equals, http.StatusOK)
+	c.Assert(ids, DeepEquals, []string{"Observation/alex-weight", "Patient/alex"})
+}
@@ -1,7 +1,6 @@
 package server
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"net/http"
@@ -13,7 +12,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/buger/jsonparser"
 	"github.com/eug48/fhir/utils"
 	"github.com/pkg/errors"
 
@@ -24,7 +22,8 @@ import (
 	"github.com/eug48/fhir/search"
 	"github.com/gin-gonic/gin"
 	"github.com/golang/glog"
-	"go.opencensus.io/trace"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // BatchController handles FHIR batch operations via input bundles
@@ -80,6 +79,26 @@ func internalError(err error) *response {
 	outcome := models.CreateOpOutcome("fatal", "exception", "", err.Error())
 	return newFailureResponse(http.StatusInternalServerError, err, outcome)
 }
+
+// conditionalFailure returns the failure of a conditional create or update whose search failed or
+// matched more than one resource
+func conditionalFailure(err error) *response {
+	if _, ok := err.(*ErrMultipleMatches); ok {
+		return multipleMatches(err)
+	}
+	return internalError(err)
+}
+
+// failEntry records the failure of an entry of a batch in its response, as an OperationOutcome, so that
+// the other entries still proceed.  Its request is kept until the response is sent.
+func failEntry(entry *models2.ShallowBundleEntryComponent, failure *response) {
+	entry.Resource = nil
+	entry.Response = &models.BundleEntryResponseComponent{
+		Status:  strconv.Itoa(failure.httpStatus),
+		Outcome: failure.errOutcome,
+	}
+}
+
 func internalErrorWithStatus(httpStatus int, err error) *response {
 	outcome := models.CreateOpOutcome("fatal", "exception", "", err.Error())
 	return newFailureResponse(httpStatus, err, outcome)
@@ -91,7 +110,7 @@ func (b *BatchController) Post(c *gin.Context) {
 	req := c.Request
 
 	// Start trace span
-	ctx, span := trace.StartSpan(req.Context(), "FHIR POST")
+	ctx, span := tracer.Start(req.Context(), "FHIR POST")
 	defer span.End()
 
 	// Get HTTP headers
@@ -112,6 +131,7 @@ func (b *BatchController) Post(c *gin.Context) {
 		c.JSON(response.httpStatus, response.reply)
 		return
 	}
+	c.Set("Action", bundle.Type)
 
 	// retry if transaction
 	attemptsLeft := 1
@@ -128,13 +148,7 @@ func (b *BatchController) Post(c *gin.Context) {
 
 		if response.reply != nil {
 			// success
-			if c.GetBool("SendXML") {
-				converterInt := c.MustGet("FhirFormatConverter")
-				converter := converterInt.(*FhirFormatConverter)
-				converter.SendXML(response.httpStatus, response.reply, c)
-			} else {
-				c.JSON(response.httpStatus, response.reply)
-			}
+			c.Render(response.httpStatus, CustomFhirRenderer{response.reply, c})
 			return
 		}
 
@@ -162,7 +176,7 @@ func (b *BatchController) Post(c *gin.Context) {
 }
 
 // Handles batch and transaction requests
-func (b *BatchController) postInner(ctx context.Context, span *trace.Span, c *gin.Context, bundle *models2.ShallowBundle, customDbName string, provenanceHeader string) *response {
+func (b *BatchController) postInner(ctx context.Context, span trace.Span, c *gin.Context, bundle *models2.ShallowBundle, customDbName string, provenanceHeader string) *response {
 
 	req := c.Request
 
@@ -181,6 +195,11 @@ func (b *BatchController) postInner(ctx context.Context, span *trace.Span, c *gi
 	case "transaction":
 		glog.V(2).Info("starting transaction")
 		transaction = true
+		if provenanceHeader != "" {
+			if err := checkProvenanceHeader(provenanceHeader); err != nil {
+				return badValue(err)
+			}
+		}
 		err := session.StartTransaction()
 		if err != nil {
 			return internalError(errors.Wrap(err, "error starting MongoDB transaction"))
@@ -199,11 +218,11 @@ func (b *BatchController) postInner(ctx context.Context, span *trace.Span, c *gi
 		return badValue(fmt.Errorf("Bundle type is neither 'batch' nor 'transaction'"))
 	}
 
-	span.AddAttributes(trace.BoolAttribute("transaction", transaction))
+	span.SetAttributes(attribute.Bool("transaction", transaction))
 
 	// Now loop through the entries, assigning new IDs to those that are POST or Conditional PUT and fixing any
 	// references to reference the new ID.
-	_, spanForResolvingIDs := trace.StartSpan(ctx, "resolving IDs")
+	_, spanForResolvingIDs := tracer.Start(ctx, "resolving IDs")
 	defer spanForResolvingIDs.End()
 	refMap := make(map[string]string)
 	newIDs := make([]string, len(entries))
@@ -215,9 +234,12 @@ func (b *BatchController) postInner(ctx context.Context, span *trace.Span, c *gi
 
 			if len(entry.Request.IfNoneExist) > 0 {
 				// Conditional Create
-				query := search.Query{Resource: entry.Request.Url, Query: entry.Request.IfNoneExist}
+				query := search.Query{Resource: entry.Request.Url, Query: strings.TrimPrefix(entry.Request.IfNoneExist, "?")}
 				existingIds, err := session.FindIDs(query)
-				if err != nil {
+				if err != nil && !transaction {
+					failEntry(entry, internalError(err))
+					continue
+				} else if err != nil {
 					return internalError(err)
 				}
 				glog.V(3).Infof("  conditional create (%s?%s): existing: %v", entry.Request.Url, entry.Request.IfNoneExist, existingIds)
@@ -250,6 +272,14 @@ func (b *BatchController) postInner(ctx context.Context, span *trace.Span, c *gi
 				entry.FullUrl = b.Config.responseURL(req, entry.Request.Url, id).String()
 			}
 
+		} else if entry.Request.Method == "PUT" && !isConditional(entry) {
+
+			// References to a temporary fullUrl refer to the resource at the URL being PUT
+			if strings.HasPrefix(entry.FullUrl, "urn:") && entry.Response == nil {
+				refMap[entry.FullUrl] = strings.TrimPrefix(entry.Request.Url, "/")
+				glog.V(3).Infof("    need to rewrite %s --> %s", entry.FullUrl, refMap[entry.FullUrl])
+			}
+
 		} else if entry.Request.Method == "PUT" && isConditional(entry) {
 
 			glog.V(3).Infof("  conditional PUT: %s", entry.Request.Url)
@@ -260,7 +290,10 @@ func (b *BatchController) postInner(ctx context.Context, span *trace.Span, c *gi
 				continue
 			}
 
-			if err := b.resolveConditionalPut(req, session, i, entry, newIDs, refMap); err != nil {
+			if err := b.resolveConditionalPut(req, session, i, entry, newIDs, refMap); err != nil && !transaction {
+				failEntry(entry, conditionalFailure(err))
+				continue
+			} else if err != nil {
 				return internalError(err)
 			}
 			glog.V(3).Infof("    resolved to: %s", entry.Request.Url)
@@ -272,10 +305,10 @@ func (b *BatchController) postInner(ctx context.Context, span *trace.Span, c *gi
 	// Second pass to take care of conditionals referencing temporary IDs.  Known limitation: if a conditional
 	// references a temp ID also defined by a conditional, we error out if it hasn't been resolved yet -- too many
 	// rabbit holes.
-	_, spanForConditionalTemporaryIDs := trace.StartSpan(ctx, "resolving conditional temporary IDs")
+	_, spanForConditionalTemporaryIDs := tracer.Start(ctx, "resolving conditional temporary IDs")
 	defer spanForConditionalTemporaryIDs.End()
 	for i, entry := range entries {
-		if entry.Request.Method == "PUT" && isConditional(entry) {
+		if entry.Request.Method == "PUT" && isConditional(entry) && entry.Response == nil {
 			// Use a regex to swap out the temp IDs with the new IDs
 			for oldID, ref := range refMap {
 				re := regexp.MustCompile("([=,])(" + oldID + "|" + url.QueryEscape(oldID) + ")(&|,|$)")
@@ -288,7 +321,10 @@ func (b *BatchController) postInner(ctx context.Context, span *trace.Span, c *gi
 				return internalErrorWithStatus(http.StatusNotImplemented, errors.New("Cannot resolve conditionals referencing other conditionals"))
 			}
 
-			if err := b.resolveConditionalPut(req, session, i, entry, newIDs, refMap); err != nil {
+			if err := b.resolveConditionalPut(req, session, i, entry, newIDs, refMap); err != nil && !transaction {
+				failEntry(entry, conditionalFailure(err))
+				continue
+			} else if err != nil {
 				return internalError(err)
 			}
 			glog.V(3).Infof("    resolved to %s", entry.Request.Url)
@@ -298,7 +334,7 @@ func (b *BatchController) postInner(ctx context.Context, span *trace.Span, c *gi
 	spanForConditionalTemporaryIDs = nil // gracefully handled by deferred End()
 
 	// Process references
-	_, spanForResolvingReferences := trace.StartSpan(ctx, "resolving references")
+	_, spanForResolvingReferences := tracer.Start(ctx, "resolving references")
 	defer spanForResolvingReferences.End()
 	references, err := bundle.GetAllReferences()
 	if err != nil {
@@ -346,15 +382,15 @@ func (b *BatchController) postInner(ctx context.Context, span *trace.Span, c *gi
 	spanForResolvingReferences = nil // gracefully handled by deferred End()
 
 	// Handle If-Match
-	var spanForIfMatch *trace.Span
+	var spanForIfMatch trace.Span
 	for _, entry := range entries {
 		switch entry.Request.Method {
 		case "PUT":
-			if entry.Request.IfMatch != "" {
+			if entry.Request.IfMatch != "" && entry.Response == nil {
 				glog.V(3).Infof(" PUT %s, If-Match: %s", entry.Request.Url, entry.Request.IfMatch)
 
 				if spanForIfMatch == nil {
-					_, spanForIfMatch = trace.StartSpan(ctx, "handling If-Match")
+					_, spanForIfMatch = tracer.Start(ctx, "handling If-Match")
 					defer spanForIfMatch.End()
 				}
 
@@ -383,7 +419,7 @@ func (b *BatchController) postInner(ctx context.Context, span *trace.Span, c *gi
 				} else if conditionalVersionId != currentResource.VersionId() {
 					glog.V(3).Infof("   conflict with current resource")
 					entry.Response = &models.BundleEntryResponseComponent{
-						Status:  "409",
+						Status:  "412",
 						Outcome: models.CreateOpOutcome("error", "conflict", "", fmt.Sprintf("Version mismatch when handling If-Match (current=%s wanted=%s)", currentResource.VersionId(), conditionalVersionId)),
 					}
 					entry.Resource = nil
@@ -391,8 +427,10 @@ func (b *BatchController) postInner(ctx context.Context, span *trace.Span, c *gi
 			}
 		}
 	}
-	spanForIfMatch.End()
-	spanForIfMatch = nil // gracefully handled by deferred End()
+	if spanForIfMatch != nil {
+		spanForIfMatch.End()
+		spanForIfMatch = nil // gracefully handled by deferred End()
+	}
 
 	// If have an error for a transaction, do not proceeed
 	proceed := true
@@ -418,6 +456,8 @@ func (b *BatchController) postInner(ctx context.Context, span *trace.Span, c *gi
 	if len(entries) <= 1 {
 		concurrency = 1
 	}
+	_, spanForExecuting := tracer.Start(ctx, "executing entries")
+	defer spanForExecuting.End()
 	if proceed {
 		if concurrency == 1 {
 			glog.V(4).Info(" executing serially")
@@ -461,26 +501,35 @@ func (b *BatchController) postInner(ctx context.Context, span *trace.Span, c *gi
 			wg.Wait()
 		}
 	}
+	spanForExecuting.End()
 
 	if transaction {
 		for _, entry := range entries {
 			// For failing transactions return a single operation-outcome
 			if entry.Response != nil && entry.Response.Outcome != nil {
-
-				glog.V(3).Infof("  transaction failing due to: %v", entry.Response)
-
 				status, err := strconv.Atoi(entry.Response.Status)
 				if err != nil {
 					panic(fmt.Errorf("bad Response.Status (%s)", entry.Response.Status))
 				}
+				if status < 400 {
+					continue // e.g. the changes made to the resources referring to a deleted one
+				}
 
+				glog.V(3).Infof("  transaction failing due to: %v", entry.Response)
 				return sendReply(status, entry.Response.Outcome)
 			}
 		}
 
-		response = b.processProvenanceHeader(provenanceHeader, c, entries, session)
-		if response != nil {
-			return response
+		if provenanceHeader != "" {
+			var targets []*models2.Resource
+			for _, entry := range entries {
+				if entry.Resource != nil {
+					targets = append(targets, entry.Resource)
+				}
+			}
+			if err := storeProvenance(c, session, provenanceHeader, targets, b.Config.EnableHistory); err != nil {
+				return internalError(err)
+			}
 		}
 
 		var start time.Time
@@ -488,14 +537,28 @@ func (b *BatchController) postInner(ctx context.Context, span *trace.Span, c *gi
 			start = time.Now()
 			glog.V(4).Infof("    starting transaction commit")
 		}
-		session.CommmitIfTransaction()
+		err = session.CommmitIfTransaction()
+		if err != nil {
+			switch errors.Cause(err).(type) {
+			case ErrDanglingReferences, ErrReferenced:
+				// the references of the transaction's resources are checked when it's committed
+				statusCode, outcome := ErrorToOpOutcome(err)
+				return newFailureResponse(statusCode, err, outcome)
+			}
+			// e.g. a WriteConflict, in which case the transaction is retried
+			return internalError(errors.Wrap(err, "failed to commit transaction"))
+		}
 		if glog.V(4) {
 			glog.V(4).Infof("    finished transaction commit in %v", time.Since(start))
 		}
 	}
 
 	if proceed {
-		total := uint32(len(entries))
+		// The requests of failed batch entries are kept until now
+		for i := range bundle.Entry {
+			bundle.Entry[i].Request = nil
+		}
+		total := uint32(len(bundle.Entry))
 		bundle.Total = &total
 		bundle.Type = fmt.Sprintf("%s-response", bundle.Type)
 
@@ -507,7 +570,7 @@ func (b *BatchController) postInner(ctx context.Context, span *trace.Span, c *gi
 }
 
 func (b *BatchController) doRequest(req *http.Request, transaction bool, session DataAccessSession, i int, entry *models2.ShallowBundleEntryComponent, createStatus []string, newIDs []string) *response {
-	err := b.doRequestInner(req, session, i, entry, createStatus, newIDs)
+	err := b.doRequestInner(req, transaction, session, i, entry, createStatus, newIDs)
 
 	if err != nil {
 		glog.V(4).Infof("  --> ERROR %+v", err)
@@ -542,7 +605,7 @@ func (b *BatchController) doRequest(req *http.Request, transaction bool, session
 	return nil
 }
 
-func (b *BatchController) doRequestInner(req *http.Request, session DataAccessSession, i int, entry *models2.ShallowBundleEntryComponent, createStatus []string, newIDs []string) error {
+func (b *BatchController) doRequestInner(req *http.Request, transaction bool, session DataAccessSession, i int, entry *models2.ShallowBundleEntryComponent, createStatus []string, newIDs []string) error {
 	glog.V(3).Infof("  doRequest %s %s", entry.Request.Method, entry.Request.Url)
 	if entry.Response != nil {
 		// already handled (e.g. conditional update returned 409)
@@ -552,6 +615,7 @@ func (b *BatchController) doRequestInner(req *http.Request, session DataAccessSe
 
 	switch entry.Request.Method {
 	case "DELETE":
+		var issues []models.OperationOutcomeIssueComponent
 		if !isConditional(entry) {
 			// It's a normal DELETE
 			parts := strings.SplitN(entry.Request.Url, "/", 2)
@@ -559,23 +623,41 @@ func (b *BatchController) doRequestInner(req *http.Request, session DataAccessSe
 				return fmt.Errorf("Couldn't identify resource and id to delete from %s", entry.Request.Url)
 			}
 			glog.V(3).Infof("    normal delete")
-			if _, err := session.Delete(parts[1], parts[0]); err != nil && err != ErrNotFound {
+			_, changes, err := session.Delete(parts[1], parts[0])
+			if err != nil && err != ErrNotFound {
 				return errors.Wrapf(err, "failed to delete %s", entry.Request.Url)
 			}
+			issues = referenceChangeIssues(changes)
+
+			// Transactions' deletes are checked when they're committed, as the resources referring to those deleted
+			// may be deleted too, so only batches' entries have warnings
+			if err == nil && !transaction {
+				warnings, err := referenceWarnings(session, b.Config, parts[0], []string{parts[1]})
+				if err != nil {
+					return errors.Wrapf(err, "failed to delete %s", entry.Request.Url)
+				}
+				issues = append(issues, warnings...)
+			}
 		} else {
 			// It's a conditional (query-based) delete
 			parts := strings.SplitN(entry.Request.Url, "?", 2)
 			query := search.Query{Resource: parts[0], Query: parts[1]}
 			glog.V(3).Infof("    conditional delete")
-			if _, err := session.ConditionalDelete(query); err != nil {
+			_, changes, err := session.ConditionalDelete(query)
+			if err != nil {
 				return errors.Wrapf(err, "failed to conditional-delete %s", entry.Request.Url)
 			}
+			issues = referenceChangeIssues(changes)
 		}
 
 		entry.Request = nil
 		entry.Response = &models.BundleEntryResponseComponent{
 			Status: "204",
 		}
+		if len(issues) > 0 {
+			entry.Response.Status = "200"
+			entry.Response.Outcome = &models.OperationOutcome{Issue: issues}
+		}
 	case "POST":
 
 		entry.Response = &models.BundleEntryResponseComponent{
@@ -615,8 +697,16 @@ func (b *BatchController) doRequestInner(req *http.Request, session DataAccessSe
 			return fmt.Errorf("Couldn't identify resource and id to put from %s", entry.Request.Url)
 		}
 
-		// Write
-		createdNew, err := session.Put(parts[1], "", entry.Resource)
+		// Write, checking the If-Match again as the resource may have been updated since it was checked
+		conditionalVersionId := ""
+		if entry.Request.IfMatch != "" {
+			var err error
+			conditionalVersionId, err = utils.ETagToVersionId(entry.Request.IfMatch)
+			if err != nil {
+				return errors.Wrapf(err, "Couldn't parse If-Match: %s", entry.Request.IfMatch)
+			}
+		}
+		createdNew, err := session.Put(parts[1], conditionalVersionId, entry.Resource)
 		if err != nil {
 			return errors.Wrapf(err, "failed to update %s", entry.Request.Url)
 		}
@@ -637,6 +727,7 @@ func (b *BatchController) doRequestInner(req *http.Request, session DataAccessSe
 				1 /Patient
 				2 /Patient/_search
 				2 /Patient/12345
+				2 /Patient/_history
 				3 /Patient/12345/_history
 				4 /Patient/12345/_history/55
 		*/
@@ -665,10 +756,14 @@ func (b *BatchController) doRequestInner(req *http.Request, session DataAccessSe
 				id = ""
 			}
 			if id == "_history" {
-				return errors.Errorf("resource-level history not supported in request: %s", entry.Request.Url)
+				if len(segments) > 2 {
+					return errors.Errorf("failed to parse request path: %s", entry.Request.Url)
+				}
+				id = ""
+				historyRequest = true
 			}
 
-			if len(segments) >= 3 {
+			if len(segments) >= 3 && !historyRequest {
 				op := segments[2]
 				glog.V(3).Infof("  op = %s", op)
 				if op != "_history" {
@@ -686,8 +781,16 @@ func (b *BatchController) doRequestInner(req *http.Request, session DataAccessSe
 		}
 
 		if historyRequest {
-			baseURL := b.Config.responseURL(req, resourceType)
-			bundle, err := session.History(*baseURL, resourceType, id)
+			query, err := url.ParseQuery(queryString)
+			if err != nil {
+				return errors.Wrapf(err, "failed to parse query string: %s", entry.Request.Url)
+			}
+			historyOptions, err := ParseHistoryOptions(query)
+			if err != nil {
+				return errors.Wrapf(err, "invalid history request: %s", entry.Request.Url)
+			}
+			baseURL := b.Config.responseURL(req)
+			bundle, err := session.History(*baseURL, resourceType, id, historyOptions)
 			glog.V(3).Infof("  history request (%s/%s) --> err %+v", resourceType, id, err)
 			if err != nil && err != ErrNotFound {
 				return errors.Wrapf(err, "History request failed: %s", entry.Request.Url)
@@ -817,103 +920,6 @@ func (b *BatchController) resolveConditionalPut(request *http.Request, session D
 	return nil
 }
 
-func (b *BatchController) processProvenanceHeader(provenanceHeader string, c *gin.Context, entries []*models2.ShallowBundleEntryComponent, session DataAccessSession) *response {
-	// spec: http://www.hl7.org/fhir/provenance.html#header
-
-	if provenanceHeader != "" {
-		headerBytes := []byte(provenanceHeader)
-
-		// check resourceType
-		resourceType, dataType, _, err := jsonparser.Get(headerBytes, "resourceType")
-		if err != nil {
-			err = errors.Wrap(err, "error parsing X-Provenance header resourceType")
-			return badValue(err)
-		}
-		if string(resourceType) != "Provenance" {
-			err = errors.Errorf("error parsing X-Provenance header: invalid resourceType")
-			return badValue(err)
-		}
-
-		// make sure "target" is not set
-		_, dataType, _, err = jsonparser.Get(headerBytes, "target")
-		if dataType == jsonparser.NotExist {
-		} else if err != nil {
-			err = errors.Wrap(err, "error parsing X-Provenance header")
-			return badValue(err)
-		} else {
-			err = errors.Errorf("error parsing X-Provenance header: target should not be set")
-			return badValue(err)
-		}
-
-		if headerBytes[len(headerBytes)-1] != '}' {
-			err = errors.Errorf("error parsing X-Provenance header: doesn't end with }")
-			return badValue(err)
-		}
-
-		// generate targets field
-		var sb bytes.Buffer
-		sb.Write(headerBytes[:len(headerBytes)-1]) // remove final '{'}
-		sb.WriteString(", \"target\": [")
-		addComma := false
-		for _, entry := range entries {
-			if entry.Resource == nil {
-				continue
-			}
-			if addComma {
-				sb.WriteString(", ")
-			}
-			addComma = true
-
-			if entry.Resource.ResourceType() == "" {
-				err = errors.Errorf("processProvenanceHeader: missing resourceType for %s", entry.FullUrl)
-				return internalError(err)
-			}
-			if entry.Resource.Id() == "" {
-				err = errors.Errorf("processProvenanceHeader: missing id for %s", entry.FullUrl)
-				return internalError(err)
-			}
-			if b.Config.EnableHistory && entry.Resource.VersionId() == "" {
-				err = errors.Errorf("processProvenanceHeader: missing versionId for %s", entry.FullUrl)
-				return internalError(err)
-			}
-
-			sb.WriteString("{ \"reference\": \"")
-			sb.WriteString(entry.Resource.ResourceType())
-			sb.WriteString("/")
-			sb.WriteString(entry.Resource.Id())
-			if b.Config.EnableHistory {
-				sb.WriteString("/_history/")
-				sb.WriteString(entry.Resource.VersionId())
-			}
-			sb.WriteString("\" }")
-		}
-
-		sb.WriteString("] }")
-
-		if glog.V(8) {
-			glog.V(8).Info("  saving X-Provenance ", sb.String())
-		}
-
-		// load resource with target
-		provenanceResource, err := models2.NewResourceFromJsonBytes(sb.Bytes())
-		if err != nil {
-			err = errors.Wrap(err, "error loading X-Provenance header")
-			return badValue(err)
-		}
-
-		// save
-		newId := bson.NewObjectId().Hex()
-		err = session.PostWithID(newId, provenanceResource)
-		if err != nil {
-			err = errors.Wrapf(err, "failed to create provenanceResource")
-			return internalError(err)
-		}
-
-		c.Header("X-GoFHIR-Provenance-Location", "Provenance/"+newId)
-	}
-	return nil
-}
-
 func isConditional(entry *models2.ShallowBundleEntryComponent) bool {
 	if entry.Request == nil {
 		return false
@@ -940,45 +946,58 @@ func hasTempID(str string) bool {
 
 func sortBundleEntries(bundle *models2.ShallowBundle) ([]*models2.ShallowBundleEntryComponent, *response) {
 	// Validate bundle entries, ensuring they have a request and that we support the method,
-	// while also creating a new entries array that can be sorted by method.
-	entries := make([]*models2.ShallowBundleEntryComponent, len(bundle.Entry))
+	// while also creating a new entries array that can be sorted by method.  Invalid entries
+	// fail a transaction, whereas in a batch they fail by themselves (and are left out).
+	entries := make([]*models2.ShallowBundleEntryComponent, 0, len(bundle.Entry))
 	for i := range bundle.Entry {
-		if bundle.Entry[i].Request == nil {
-			return nil, brokenInvariant(errors.New("Entries in a batch operation require a request"))
-		}
-
-		switch bundle.Entry[i].Request.Method {
-		default:
-			return nil, badValue(errors.New("Operation currently unsupported in batch requests: " + bundle.Entry[i].Request.Method))
-		case "DELETE":
-			if bundle.Entry[i].Request.Url == "" {
-				return nil, brokenInvariant(errors.New("Batch DELETE must have a URL"))
-			}
-		case "POST":
-			if bundle.Entry[i].Resource == nil {
-				return nil, brokenInvariant(errors.New("Batch POST must have a resource body"))
-			}
-		case "PUT":
-			if bundle.Entry[i].Resource == nil {
-				return nil, brokenInvariant(errors.New("Batch PUT must have a resource body"))
-			}
-			if !strings.Contains(bundle.Entry[i].Request.Url, "/") && !strings.Contains(bundle.Entry[i].Request.Url, "?") {
-				return nil, brokenInvariant(errors.New("Batch PUT URL must have an id or a condition"))
-			}
-		case "GET":
-			if bundle.Entry[i].Request.Url == "" {
-				return nil, brokenInvariant(errors.New("Batch GET must have a URL"))
+		if failure := validateBundleEntry(&bundle.Entry[i]); failure != nil {
+			if bundle.Type != "batch" {
+				return nil, failure
 			}
+			failEntry(&bundle.Entry[i], failure)
+			continue
 		}
-		entries[i] = &bundle.Entry[i]
+		entries = append(entries, &bundle.Entry[i])
 	}
 
-	// sort entries by request method as per FHIR spec
-	sort.Sort(byRequestMethod(entries))
+	// sort entries by request method as per FHIR spec, keeping the order of those with the same method
+	sort.Stable(byRequestMethod(entries))
 
 	return entries, nil
 }
 
+// validateBundleEntry checks that an entry has a request with a supported method and what it requires
+func validateBundleEntry(entry *models2.ShallowBundleEntryComponent) *response {
+	if entry.Request == nil {
+		return brokenInvariant(errors.New("Entries in a batch operation require a request"))
+	}
+
+	switch entry.Request.Method {
+	default:
+		return badValue(errors.New("Operation currently unsupported in batch requests: " + entry.Request.Method))
+	case "DELETE":
+		if entry.Request.Url == "" {
+			return brokenInvariant(errors.New("Batch DELETE must have a URL"))
+		}
+	case "POST":
+		if entry.Resource == nil {
+			return brokenInvariant(errors.New("Batch POST must have a resource body"))
+		}
+	case "PUT":
+		if entry.Resource == nil {
+			return brokenInvariant(errors.New("Batch PUT must have a resource body"))
+		}
+		if !strings.Contains(entry.Request.Url, "/") && !strings.Contains(entry.Request.Url, "?") {
+			return brokenInvariant(errors.New("Batch PUT URL must have an id or a condition"))
+		}
+	case "GET":
+		if entry.Request.Url == "" {
+			return brokenInvariant(errors.New("Batch GET must have a URL"))
+		}
+	}
+	return nil
+}
+
 // Support sorting by request method, as defined in the spec
 type byRequestMethod []*models2.ShallowBundleEntryComponent
 
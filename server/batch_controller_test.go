@@ -14,9 +14,10 @@ import (
 	"time"
 
 	"github.com/eug48/fhir/models"
+	"github.com/eug48/fhir/models2"
 	"github.com/gin-gonic/gin"
-	mongowrapper "github.com/opencensus-integrations/gomongowrapper"
 	"github.com/pebbe/util"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	. "gopkg.in/check.v1"
 	"gopkg.in/mgo.v2"
@@ -26,7 +27,7 @@ import (
 type BatchControllerSuite struct {
 	initialSession *mgo.Session
 
-	MongoClient *mongowrapper.WrappedClient
+	MongoClient *mongo.Client
 	DbName      string
 
 	Engine       *gin.Engine
@@ -47,7 +48,7 @@ func (s *BatchControllerSuite) SetUpSuite(c *C) {
 	s.initialSession.SetSafe(&mgo.Safe{})
 	util.CheckErr(err)
 	s.DbName = "fhir-test"
-	s.MongoClient, err = mongowrapper.Connect(context.TODO(), options.Client().ApplyURI("mongodb://localhost"))
+	s.MongoClient, err = mongo.Connect(context.TODO(), options.Client().ApplyURI("mongodb://localhost"))
 	if err != nil {
 		panic(err)
 	}
@@ -592,11 +593,11 @@ func (s *BatchControllerSuite) TestPutEntriesBundle(c *C) {
 	c.Assert(cond3.Code.Coding[0].Code, Equals, "Bat")
 }
 
-func (s *BatchControllerSuite) TestVersionedPutEntriesTransaction409(c *C) {
+func (s *BatchControllerSuite) TestVersionedPutEntriesTransaction412(c *C) {
 
 	s.addMongoRecords1()
 	oo := &models.OperationOutcome{}
-	s.sendRequest(c, "../fixtures/put_versioned_entries_transaction_409.json", 409, oo)
+	s.sendRequest(c, "../fixtures/put_versioned_entries_transaction_412.json", 412, oo)
 
 	c.Assert(oo.Issue[0].Severity, Equals, "error")
 	c.Assert(oo.Issue[0].Code, Equals, "conflict")
@@ -730,11 +731,11 @@ func (s *BatchControllerSuite) TestVersionedPutEntriesTransaction200(c *C) {
 	c.Assert(cond3.Code.Coding[0].Code, Equals, "Bat")
 }
 
-func (s *BatchControllerSuite) TestVersionedPutEntriesBatch409(c *C) {
+func (s *BatchControllerSuite) TestVersionedPutEntriesBatch412(c *C) {
 
 	s.addMongoRecords1()
 	responseBundle := &models.Bundle{}
-	s.sendRequest(c, "../fixtures/put_versioned_entries_batch_409.json", 200, responseBundle)
+	s.sendRequest(c, "../fixtures/put_versioned_entries_batch_412.json", 200, responseBundle)
 
 	c.Assert(responseBundle.Type, Equals, "batch-response")
 	c.Assert(*responseBundle.Total, Equals, uint32(4))
@@ -746,8 +747,8 @@ func (s *BatchControllerSuite) TestVersionedPutEntriesBatch409(c *C) {
 	// full URLs and IDs should contain correct ID in response
 	c.Assert(patEntry.FullUrl, Equals, s.Server.URL+"/Patient/56afe6b85cdc7ec329dfe6a0")
 
-	// response should have 409 status and location
-	c.Assert(patEntry.Response.Status, Equals, "409")
+	// response should have 412 status and location
+	c.Assert(patEntry.Response.Status, Equals, "412")
 	c.Assert(patEntry.Response.Location, Equals, "")
 
 	oo := patEntry.Response.Outcome.(*models.OperationOutcome)
@@ -1158,6 +1159,88 @@ func (s *BatchControllerSuite) TestAllSupportedMethodsBundle(c *C) {
 	s.checkReference(c, responseBundle.Entry[4].Resource.(*models.Condition).Subject, patientID, "Patient")
 }
 
+func (s *BatchControllerSuite) TestBatchEntryFailures(c *C) {
+	responseBundle := &models.Bundle{}
+	s.sendRequest(c, "../fixtures/batch_entry_failures.json", 200, responseBundle)
+
+	c.Assert(responseBundle.Type, Equals, "batch-response")
+	c.Assert(*responseBundle.Total, Equals, uint32(4))
+	c.Assert(responseBundle.Entry, HasLen, 4)
+
+	// Invalid entries fail by themselves
+	for _, i := range []int{0, 2} {
+		entry := responseBundle.Entry[i]
+		c.Assert(entry.Resource, IsNil)
+		c.Assert(entry.Request, IsNil)
+		c.Assert(entry.Response.Status, Equals, "400")
+		oo := entry.Response.Outcome.(*models.OperationOutcome)
+		c.Assert(oo.Issue[0].Code, Equals, "invariant")
+	}
+
+	// The others proceed
+	created := responseBundle.Entry[1]
+	c.Assert(created.Response.Status, Equals, "201")
+	c.Assert(created.Resource, FitsTypeOf, &models.Patient{})
+	c.Assert(created.Request, IsNil)
+	c.Assert(responseBundle.Entry[3].Response.Status, Equals, "404")
+
+	count, err := s.MgoDB().C("patients").Count()
+	util.CheckErr(err)
+	c.Assert(count, Equals, 1)
+}
+
+func (s *BatchControllerSuite) TestTransactionPutTemporaryIDs(c *C) {
+	responseBundle := &models.Bundle{}
+	s.sendRequest(c, "../fixtures/transaction_put_temporary_ids.json", 200, responseBundle)
+
+	c.Assert(responseBundle.Type, Equals, "transaction-response")
+	c.Assert(responseBundle.Entry, HasLen, 2)
+	c.Assert(responseBundle.Entry[0].Response.Status, Equals, "201")
+	c.Assert(responseBundle.Entry[1].Response.Status, Equals, "201")
+
+	// The reference to the PUT's temporary fullUrl refers to the resource it puts
+	s.checkReference(c, responseBundle.Entry[1].Resource.(*models.Condition).Subject, "56afe6b85cdc7ec329dfe6f1", "Patient")
+
+	cond := models.Condition{}
+	err := s.MgoDB().C("conditions").Find(bson.M{"subject.reference": "Patient/56afe6b85cdc7ec329dfe6f1"}).One(&cond)
+	util.CheckErr(err)
+}
+
+func (s *BatchControllerSuite) TestSortBundleEntries(c *C) {
+	patient, err := models2.NewResourceFromJsonBytes([]byte(`{"resourceType": "Patient"}`))
+	util.CheckErr(err)
+	entry := func(method, url string) models2.ShallowBundleEntryComponent {
+		e := models2.ShallowBundleEntryComponent{Request: &models.BundleEntryRequestComponent{Method: method, Url: url}}
+		if method == "POST" || method == "PUT" {
+			e.Resource = patient
+		}
+		return e
+	}
+	bundle := &models2.ShallowBundle{Type: "transaction", Entry: []models2.ShallowBundleEntryComponent{
+		entry("GET", "Patient/1"),
+		entry("PUT", "Patient/2"),
+		entry("POST", "Patient"),
+		entry("DELETE", "Patient/3"),
+		entry("GET", "Patient/4"),
+		entry("DELETE", "Patient/5"),
+		entry("PUT", "Patient/6"),
+	}}
+
+	// DELETEs, then POSTs, PUTs and GETs, each in the order of the bundle
+	entries, response := sortBundleEntries(bundle)
+	c.Assert(response, IsNil)
+	var urls []string
+	for _, e := range entries {
+		urls = append(urls, e.Request.Method+" "+e.Request.Url)
+	}
+	c.Assert(urls, DeepEquals, []string{
+		"DELETE Patient/3", "DELETE Patient/5", "POST Patient", "PUT Patient/2", "PUT Patient/6", "GET Patient/1", "GET Patient/4",
+	})
+
+	// The entries of the bundle (and so its response) keep their order
+	c.Assert(bundle.Entry[0].Request.Url, Equals, "Patient/1")
+}
+
 func (s *BatchControllerSuite) checkReference(c *C, ref *models.Reference, id string, typ string) {
 	c.Assert(ref.ReferencedID, Equals, id)
 	c.Assert(ref.Type, Equals, typ)
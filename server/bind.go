@@ -62,16 +62,11 @@ func FHIRBind(c *gin.Context, validatorURL string) (resource *models2.Resource,
 	}
 
 	// XML
-	if strings.Contains(contentType, "application/fhir+xml") || strings.Contains(contentType, "application/xml+fhir") {
+	if strings.Contains(contentType, "xml") {
 		converterInterface, enabled := c.Get("FhirFormatConverter")
 		if enabled {
 			converter := converterInterface.(*FhirFormatConverter)
-			var jsonStr string
-			jsonStr, err = converter.XmlToJson(string(bodyBytes))
-			if err != nil {
-				return nil, err
-			}
-			resource, err = models2.NewResourceFromJsonBytes([]byte(jsonStr))
+			resource, err = converter.NewResourceFromXml(bodyBytes)
 			if encryptPatientDetails && resource != nil {
 				resource.SetWhatToEncrypt(models2.WhatToEncrypt { PatientDetails: true })
 			}
@@ -0,0 +1,562 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eug48/fhir/models"
+	"github.com/eug48/fhir/models2"
+	"github.com/eug48/fhir/search"
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// bulkExportPageSize is the _count of the searches of bulk exports
+const bulkExportPageSize = 500
+
+// bulkExportRetryAfter is how many seconds clients are asked to wait before checking on a bulk export again
+const bulkExportRetryAfter = 5
+
+// bulkExportJobs are the bulk exports that have been started (and not deleted or expired), by id
+var bulkExportJobs = struct {
+	sync.Mutex
+	byID map[string]*exportJob
+}{byID: make(map[string]*exportJob)}
+
+// exportJob is a bulk export of resources to NDJSON files, which runs in the background
+type exportJob struct {
+	id              string
+	request         string
+	transactionTime time.Time
+	destination     ExportDestination
+	lifetime        time.Duration
+	cancel          context.CancelFunc
+
+	mutex     sync.Mutex
+	progress  string
+	completed time.Time
+	err       error
+	files     []exportFile
+}
+
+// exportFile is an NDJSON file of the resources of a type exported by a bulk export
+type exportFile struct {
+	resourceType string
+	name         string
+	count        int
+}
+
+// exportRequest is what a bulk export exports
+type exportRequest struct {
+	// level is "" to export all resources, "Patient" for those in the compartments of all patients, or "Group" for
+	// those in the compartments of the patients in a group
+	level   string
+	groupID string
+	types   []string
+	since   string
+	// typeFilters are the search queries that resources of each type must match any of to be exported
+	typeFilters map[string][]string
+}
+
+// exportManifest is the response to a request for the status of a completed bulk export (see
+// https://hl7.org/fhir/uv/bulkdata/export/index.html#response---complete-status)
+type exportManifest struct {
+	TransactionTime     string               `json:"transactionTime"`
+	Request             string               `json:"request"`
+	RequiresAccessToken bool                 `json:"requiresAccessToken"`
+	Output              []exportManifestFile `json:"output"`
+	Error               []exportManifestFile `json:"error"`
+}
+
+type exportManifestFile struct {
+	Type  string `json:"type"`
+	URL   string `json:"url"`
+	Count int    `json:"count,omitempty"`
+}
+
+// BulkExportHandler returns a handler of requests to start bulk exports (see
+// https://hl7.org/fhir/uv/bulkdata/export/index.html) of all resources (GET /$export), of those in the compartments of
+// all patients (GET /Patient/$export) or of the patients in a group (GET /Group/123/$export), as given by the level.
+// The resources of each type (limited by _type, _since and _typeFilter) are exported to an NDJSON file in the
+// background, while clients check on the export at the URL in the Content-Location header (see
+// BulkExportStatusHandler).
+func BulkExportHandler(dal DataAccessLayer, config Config, level string) gin.HandlerFunc {
+	destination, err := NewExportDestination(config.BulkExportDestination)
+	if err != nil {
+		panic(errors.Wrap(err, "setting up the bulk export destination"))
+	}
+	return func(c *gin.Context) {
+		defer handlePanics(c)
+		c.Set("Resource", level)
+		c.Set("Action", "operation")
+
+		badRequest := func(format string, args ...interface{}) {
+			outcome := models.NewOperationOutcome("fatal", "value", fmt.Sprintf(format, args...))
+			c.Render(http.StatusBadRequest, CustomFhirRenderer{outcome, c})
+		}
+		if !strings.Contains(c.GetHeader("Prefer"), "respond-async") {
+			badRequest("bulk exports require a Prefer: respond-async header")
+			return
+		}
+		switch outputFormat := c.Query("_outputFormat"); outputFormat {
+		case "", "application/fhir+ndjson", "application/fhir ndjson", "application/ndjson", "ndjson":
+		default:
+			badRequest("unsupported _outputFormat: %q", outputFormat)
+			return
+		}
+
+		request := exportRequest{level: level, typeFilters: make(map[string][]string)}
+		exportableTypes := exportableResourceTypes(level)
+		exporting := make(map[string]bool)
+		if types := c.Query("_type"); types != "" {
+			exportable := make(map[string]bool, len(exportableTypes))
+			for _, resourceType := range exportableTypes {
+				exportable[resourceType] = true
+			}
+			for _, resourceType := range strings.Split(types, ",") {
+				if !exportable[resourceType] {
+					badRequest("resources of type %q can't be exported", resourceType)
+					return
+				}
+				if !exporting[resourceType] {
+					exporting[resourceType] = true
+					request.types = append(request.types, resourceType)
+				}
+			}
+		} else {
+			request.types = exportableTypes
+			for _, resourceType := range exportableTypes {
+				exporting[resourceType] = true
+			}
+		}
+		if since := c.Query("_since"); since != "" {
+			sinceTime, err := time.Parse(time.RFC3339, since)
+			if err != nil {
+				badRequest("invalid _since (it must be an instant, e.g. 2020-01-02T03:04:05Z): %q", since)
+				return
+			}
+			request.since = sinceTime.UTC().Format(time.RFC3339Nano)
+		}
+		for _, typeFilters := range c.QueryArray("_typeFilter") {
+			for _, typeFilter := range strings.Split(typeFilters, ",") {
+				typeAndQuery := strings.SplitN(typeFilter, "?", 2)
+				if len(typeAndQuery) != 2 || !exporting[typeAndQuery[0]] {
+					badRequest("invalid _typeFilter (it must be a search of one of the exported types, e.g. Observation?status=final): %q", typeFilter)
+					return
+				}
+				// Invalid searches panic with a search.Error, which is rendered with its status (e.g. 400 Bad Request)
+				filterQuery := search.Query{Resource: typeAndQuery[0], Query: typeAndQuery[1]}
+				filterQuery.Params()
+				filterQuery.Options()
+				request.typeFilters[typeAndQuery[0]] = append(request.typeFilters[typeAndQuery[0]], typeAndQuery[1])
+			}
+		}
+
+		db := c.GetHeader("Db")
+		if level == "Group" {
+			request.groupID = c.Param("id")
+			session := dal.StartSession(c.Request.Context(), db)
+			_, err := session.Get(request.groupID, "Group")
+			session.Finish()
+			switch err {
+			case nil:
+			case ErrNotFound:
+				c.Status(http.StatusNotFound)
+				return
+			case ErrDeleted:
+				c.Status(http.StatusGone)
+				return
+			default:
+				panic(errors.Wrap(err, "Get (export) failed"))
+			}
+		}
+
+		purgeExpiredExportJobs()
+		ctx, cancel := context.WithCancel(context.Background())
+		job := &exportJob{
+			id:              uuid.New().String(),
+			request:         config.responseURL(c.Request).String() + strings.TrimPrefix(c.Request.URL.RequestURI(), "/"),
+			transactionTime: time.Now().UTC(),
+			destination:     destination,
+			lifetime:        config.BulkExportLifetime,
+			cancel:          cancel,
+			progress:        "Starting the export",
+		}
+		bulkExportJobs.Lock()
+		bulkExportJobs.byID[job.id] = job
+		bulkExportJobs.Unlock()
+
+		go job.run(ctx, dal, db, request)
+
+		c.Header("Content-Location", config.responseURL(c.Request, "$export-status", job.id).String())
+		c.Status(http.StatusAccepted)
+	}
+}
+
+// exportableResourceTypes returns the types of resources that bulk exports of a level can export
+func exportableResourceTypes(level string) []string {
+	if level != "" {
+		return search.CompartmentResourceTypes("Patient")
+	}
+	types := make([]string, 0, len(search.SearchParameters()))
+	for resourceType := range search.SearchParameters() {
+		types = append(types, resourceType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// BulkExportStatusHandler handles requests for the status of bulk exports (GET /$export-status/[id]), responding
+// with 202 Accepted while they're running, with the manifest of their files once they're complete, or with an
+// OperationOutcome if they failed
+func BulkExportStatusHandler(config Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer handlePanics(c)
+		c.Set("Action", "operation")
+
+		job := findExportJob(c.Param("job"))
+		if job == nil {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		job.mutex.Lock()
+		progress, completed, jobErr, files := job.progress, job.completed, job.err, job.files
+		job.mutex.Unlock()
+
+		if completed.IsZero() {
+			c.Header("X-Progress", progress)
+			c.Header("Retry-After", strconv.Itoa(bulkExportRetryAfter))
+			c.Status(http.StatusAccepted)
+			return
+		}
+		if jobErr != nil {
+			outcome := models.NewOperationOutcome("fatal", "exception", fmt.Sprintf("The export failed: %s", jobErr))
+			c.Render(http.StatusInternalServerError, CustomFhirRenderer{outcome, c})
+			return
+		}
+
+		manifest := exportManifest{
+			TransactionTime: job.transactionTime.Format(time.RFC3339Nano),
+			Request:         job.request,
+			Output:          []exportManifestFile{},
+			Error:           []exportManifestFile{},
+		}
+		baseURL := config.responseURL(c.Request).String()
+		for _, file := range files {
+			fileURL, requiresAccessToken, err := job.destination.URL(baseURL, job.id, file.name)
+			if err != nil {
+				panic(errors.Wrap(err, "failed to get the URL of an exported file"))
+			}
+			manifest.RequiresAccessToken = manifest.RequiresAccessToken || requiresAccessToken
+			manifest.Output = append(manifest.Output, exportManifestFile{Type: file.resourceType, URL: fileURL, Count: file.count})
+		}
+		c.Header("Expires", completed.Add(job.lifetime).UTC().Format(http.TimeFormat))
+		c.JSON(http.StatusOK, manifest)
+	}
+}
+
+// BulkExportDeleteHandler handles requests to cancel bulk exports, or delete their files once they're complete
+// (DELETE /$export-status/[id])
+func BulkExportDeleteHandler(c *gin.Context) {
+	defer handlePanics(c)
+	c.Set("Action", "operation")
+
+	bulkExportJobs.Lock()
+	job := bulkExportJobs.byID[c.Param("job")]
+	delete(bulkExportJobs.byID, c.Param("job"))
+	bulkExportJobs.Unlock()
+	if job == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	job.cancel()
+	if err := job.deleteFiles(); err != nil {
+		panic(errors.Wrap(err, "failed to delete the exported files"))
+	}
+	c.Status(http.StatusAccepted)
+}
+
+// BulkExportFileHandler handles requests for the files of completed bulk exports stored in a local directory (GET
+// /$export-file/[id]/[name], see LocalExportDestination)
+func BulkExportFileHandler(c *gin.Context) {
+	defer handlePanics(c)
+	c.Set("Action", "operation")
+
+	job := findExportJob(c.Param("job"))
+	if job == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	local, isLocal := job.destination.(LocalExportDestination)
+	job.mutex.Lock()
+	completed := !job.completed.IsZero()
+	job.mutex.Unlock()
+	if !isLocal || !completed {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	file, err := local.Open(job.id, c.Param("file"))
+	if os.IsNotExist(err) {
+		c.Status(http.StatusNotFound)
+		return
+	} else if err != nil {
+		panic(errors.Wrap(err, "failed to open an exported file"))
+	}
+	defer file.Close()
+	c.Header("Content-Type", "application/fhir+ndjson")
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, file); err != nil {
+		glog.Errorf("failed to send exported file %s: %v", file.Name(), err)
+	}
+}
+
+// findExportJob returns the bulk export with an id, or nil if there's none
+func findExportJob(id string) *exportJob {
+	bulkExportJobs.Lock()
+	defer bulkExportJobs.Unlock()
+	return bulkExportJobs.byID[id]
+}
+
+// purgeExpiredExportJobs forgets the bulk exports that completed longer ago than their lifetime, deleting their files
+func purgeExpiredExportJobs() {
+	var expired []*exportJob
+	bulkExportJobs.Lock()
+	for id, job := range bulkExportJobs.byID {
+		job.mutex.Lock()
+		if !job.completed.IsZero() && time.Since(job.completed) > job.lifetime {
+			expired = append(expired, job)
+			delete(bulkExportJobs.byID, id)
+		}
+		job.mutex.Unlock()
+	}
+	bulkExportJobs.Unlock()
+
+	for _, job := range expired {
+		if err := job.deleteFiles(); err != nil {
+			glog.Errorf("failed to delete the files of expired export %s: %v", job.id, err)
+		}
+	}
+}
+
+// deleteFiles deletes the files of a bulk export
+func (job *exportJob) deleteFiles() error {
+	job.mutex.Lock()
+	names := make([]string, len(job.files))
+	for i, file := range job.files {
+		names[i] = file.name
+	}
+	job.mutex.Unlock()
+	return job.destination.Delete(job.id, names)
+}
+
+func (job *exportJob) setProgress(progress string) {
+	job.mutex.Lock()
+	job.progress = progress
+	job.mutex.Unlock()
+}
+
+// run exports the resources of a bulk export, one type at a time
+func (job *exportJob) run(ctx context.Context, dal DataAccessLayer, db string, request exportRequest) {
+	err := job.export(ctx, dal, db, request)
+	if err != nil && ctx.Err() == nil {
+		glog.Errorf("bulk export %s failed: %+v", job.id, err)
+	}
+	job.mutex.Lock()
+	job.completed = time.Now()
+	job.err = err
+	job.mutex.Unlock()
+}
+
+func (job *exportJob) export(ctx context.Context, dal DataAccessLayer, db string, request exportRequest) error {
+	session := dal.StartSession(ctx, db)
+	defer session.Finish()
+
+	// Patient and group exports search the compartment of each of the patients
+	var patientIDs []string
+	var err error
+	switch request.level {
+	case "Patient":
+		patientIDs, err = allPatientIDs(session)
+	case "Group":
+		patientIDs, err = groupPatientIDs(session, request.groupID)
+	}
+	if err != nil {
+		return err
+	}
+
+	for i, resourceType := range request.types {
+		job.setProgress(fmt.Sprintf("Exporting %s resources (%d of %d types)", resourceType, i+1, len(request.types)))
+
+		var queries []string
+		filters := request.typeFilters[resourceType]
+		if len(filters) == 0 {
+			filters = []string{""}
+		}
+		for _, filter := range filters {
+			params := []string{fmt.Sprintf("_count=%d&_total=none", bulkExportPageSize)}
+			if request.since != "" {
+				params = append(params, "_lastUpdated=gt"+url.QueryEscape(request.since))
+			}
+			if filter != "" {
+				params = append(params, filter)
+			}
+			if request.level == "" {
+				queries = append(queries, strings.Join(params, "&"))
+			}
+			for _, patientID := range patientIDs {
+				queries = append(queries, strings.Join(append(params, "_compartment=Patient/"+url.QueryEscape(patientID)), "&"))
+			}
+		}
+
+		file, err := job.exportType(session, resourceType, queries)
+		if err != nil {
+			return errors.Wrapf(err, "failed to export %s resources", resourceType)
+		}
+		if file.count > 0 {
+			job.mutex.Lock()
+			job.files = append(job.files, file)
+			job.mutex.Unlock()
+		}
+	}
+	return nil
+}
+
+// exportType exports the resources of a type that match any of the queries to an NDJSON file, which is only stored
+// if there are any
+func (job *exportJob) exportType(session DataAccessSession, resourceType string, queries []string) (file exportFile, err error) {
+	file = exportFile{resourceType: resourceType, name: resourceType + ".ndjson"}
+	tempFile, err := ioutil.TempFile("", "fhir-export-*.ndjson")
+	if err != nil {
+		return file, errors.Wrap(err, "failed to create a temporary file")
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+	writer := bufio.NewWriter(tempFile)
+
+	// Resources matching several queries (e.g. in the compartments of several patients) are only exported once
+	var exported map[string]bool
+	if len(queries) > 1 {
+		exported = make(map[string]bool)
+	}
+	var line bytes.Buffer
+	for _, query := range queries {
+		searchQuery := search.Query{Resource: resourceType, Query: query}
+		for {
+			bundle, err := session.Search(url.URL{}, searchQuery)
+			if err != nil {
+				return file, errors.Wrapf(err, "search failed (%s?%s)", resourceType, searchQuery.Query)
+			}
+			for _, entry := range bundle.Entry {
+				if entry.Resource == nil || (entry.Search != nil && entry.Search.Mode != "match") {
+					continue
+				}
+				if exported != nil {
+					if exported[entry.Resource.Id()] {
+						continue
+					}
+					exported[entry.Resource.Id()] = true
+				}
+				jsonBytes, err := entry.Resource.MarshalJSON()
+				if err != nil {
+					return file, errors.Wrap(err, "MarshalJSON failed")
+				}
+				line.Reset()
+				if err := json.Compact(&line, jsonBytes); err != nil {
+					return file, errors.Wrap(err, "failed to compact resource JSON")
+				}
+				line.WriteByte('\n')
+				if _, err := writer.Write(line.Bytes()); err != nil {
+					return file, errors.Wrap(err, "failed to write to a temporary file")
+				}
+				file.count++
+			}
+
+			next := nextLink(bundle)
+			if next == "" {
+				break
+			}
+			nextURL, err := url.Parse(next)
+			if err != nil {
+				return file, errors.Wrap(err, "failed to parse next link")
+			}
+			searchQuery = search.Query{Resource: resourceType, Query: nextURL.RawQuery}
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		return file, errors.Wrap(err, "failed to write to a temporary file")
+	}
+	if file.count == 0 {
+		return file, nil
+	}
+	if err := tempFile.Close(); err != nil {
+		return file, errors.Wrap(err, "failed to write to a temporary file")
+	}
+	return file, job.destination.Store(job.id, file.name, tempFile.Name())
+}
+
+// nextLink returns the URL of the next page of a search's results, or "" if it's the last
+func nextLink(bundle *models2.ShallowBundle) string {
+	for _, link := range bundle.Link {
+		if link.Relation == "next" {
+			return link.Url
+		}
+	}
+	return ""
+}
+
+// allPatientIDs returns the IDs of every patient
+func allPatientIDs(session DataAccessSession) ([]string, error) {
+	const pageSize = 1000
+	var IDs []string
+	for offset := 0; ; offset += pageSize {
+		query := search.Query{Resource: "Patient", Query: fmt.Sprintf("_sort=_id&_count=%d&_offset=%d", pageSize, offset)}
+		page, err := session.FindIDs(query)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to find the patients")
+		}
+		IDs = append(IDs, page...)
+		if len(page) < pageSize {
+			return IDs, nil
+		}
+	}
+}
+
+// groupPatientIDs returns the IDs of the patients that are members of a group
+func groupPatientIDs(session DataAccessSession, groupID string) ([]string, error) {
+	group, err := session.Get(groupID, "Group")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get the group")
+	}
+	references, err := group.LocalReferences()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find the group's members")
+	}
+	var IDs []string
+	member := make(map[string]bool)
+	for _, reference := range references {
+		isMember := strings.HasPrefix(reference.Expression, "Group.member[") && strings.HasSuffix(reference.Expression, "].entity")
+		if isMember && reference.Type == "Patient" && !member[reference.ID] {
+			member[reference.ID] = true
+			IDs = append(IDs, reference.ID)
+		}
+	}
+	return IDs, nil
+}
@@ -0,0 +1,400 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/eug48/fhir/models"
+	"github.com/eug48/fhir/models2"
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// importBatchSize is how many resources of a type bulk imports insert at a time
+const importBatchSize = 1000
+
+// maxReportedImportErrors is the most errors reported for each file of a bulk import
+const maxReportedImportErrors = 10
+
+// importJobLifetime is how long the results of completed bulk imports are kept for
+const importJobLifetime = 24 * time.Hour
+
+// ImportResult is the outcome of importing an NDJSON file (see ImportNDJSON)
+type ImportResult struct {
+	// Imported is how many resources were created
+	Imported int
+	// Failed is how many lines weren't imported, and Errors are the first of their errors (with their line numbers)
+	Failed int
+	Errors []string
+}
+
+func (r *ImportResult) fail(line int, err error) {
+	r.Failed++
+	if len(r.Errors) < maxReportedImportErrors {
+		r.Errors = append(r.Errors, fmt.Sprintf("line %d: %s", line, err))
+	}
+}
+
+// importBatch is the resources of a type read from an NDJSON file that are yet to be inserted
+type importBatch struct {
+	resources []*models2.Resource
+	lines     []int
+}
+
+// ImportNDJSON imports the resources of an NDJSON file (one per line) into a database, inserting those of each type
+// in batches (see DataAccessSession.InsertMany).  Resources keep their IDs, which must be unique ObjectIds, or else
+// are given new ones.  Lines that can't be parsed, resources of other types than resourceType (unless it's empty)
+// and resources that can't be created are counted as failures rather than stopping the import.
+func ImportNDJSON(session DataAccessSession, reader io.Reader, resourceType string) (result ImportResult, err error) {
+	batches := make(map[string]*importBatch)
+	insert := func(batchType string) error {
+		batch := batches[batchType]
+		errs, err := session.InsertMany(batchType, batch.resources)
+		if err != nil {
+			return errors.Wrapf(err, "failed to insert %s resources", batchType)
+		}
+		for i, err := range errs {
+			if err != nil {
+				result.fail(batch.lines[i], err)
+			} else {
+				result.Imported++
+			}
+		}
+		delete(batches, batchType)
+		return nil
+	}
+
+	// Lines are read whole, as resources may be longer than a bufio.Scanner's maximum token size
+	bufferedReader := bufio.NewReader(reader)
+	for line := 1; ; line++ {
+		lineBytes, readErr := bufferedReader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return result, errors.Wrapf(readErr, "failed to read line %d", line)
+		}
+		if lineBytes = bytes.TrimSpace(lineBytes); len(lineBytes) > 0 {
+			resource, err := models2.NewResourceFromJsonBytes(lineBytes)
+			if err != nil {
+				result.fail(line, err)
+			} else if models.StructForResourceName(resource.ResourceType()) == nil {
+				result.fail(line, errors.Errorf("unknown resource type: %q", resource.ResourceType()))
+			} else if resourceType != "" && resource.ResourceType() != resourceType {
+				result.fail(line, errors.Errorf("expected a %s resource but got a %s", resourceType, resource.ResourceType()))
+			} else {
+				batch := batches[resource.ResourceType()]
+				if batch == nil {
+					batch = &importBatch{}
+					batches[resource.ResourceType()] = batch
+				}
+				batch.resources = append(batch.resources, resource)
+				batch.lines = append(batch.lines, line)
+				if len(batch.resources) == importBatchSize {
+					if err := insert(resource.ResourceType()); err != nil {
+						return result, err
+					}
+				}
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+	}
+
+	remainingTypes := make([]string, 0, len(batches))
+	for batchType := range batches {
+		remainingTypes = append(remainingTypes, batchType)
+	}
+	sort.Strings(remainingTypes)
+	for _, batchType := range remainingTypes {
+		if err := insert(batchType); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// bulkImportJobs are the bulk imports that have been started (and not deleted or expired), by id
+var bulkImportJobs = struct {
+	sync.Mutex
+	byID map[string]*importJob
+}{byID: make(map[string]*importJob)}
+
+// importJob is a bulk import of NDJSON files, which runs in the background
+type importJob struct {
+	id              string
+	request         string
+	transactionTime time.Time
+	cancel          context.CancelFunc
+
+	mutex     sync.Mutex
+	progress  string
+	completed time.Time
+	err       error
+	results   []importFileResult
+}
+
+// importInput is an NDJSON file of a bulk import, downloaded from a URL
+type importInput struct {
+	resourceType string
+	url          string
+}
+
+type importFileResult struct {
+	importInput
+	ImportResult
+}
+
+// importManifest is the response to a request for the status of a completed bulk import, with an output for each
+// file and an error for each file with resources that weren't imported
+type importManifest struct {
+	TransactionTime string               `json:"transactionTime"`
+	Request         string               `json:"request"`
+	Output          []importManifestFile `json:"output"`
+	Error           []importManifestFile `json:"error"`
+}
+
+type importManifestFile struct {
+	Type     string   `json:"type"`
+	InputURL string   `json:"inputUrl"`
+	Count    int      `json:"count"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// BulkImportHandler returns a handler of requests to start bulk imports (POST /$import) of NDJSON files, which are
+// downloaded from the URLs of the input parameters of a Parameters resource, each with a type part (e.g. a valueCode
+// of Patient) and a url part (a valueUri), and optionally an inputFormat parameter of application/fhir+ndjson.
+// The files are imported in the background (see ImportNDJSON), while clients check on the import at the URL in the
+// Content-Location header (see BulkImportStatusHandler).
+func BulkImportHandler(dal DataAccessLayer, config Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer handlePanics(c)
+		c.Set("Action", "operation")
+
+		badRequest := func(code string, format string, args ...interface{}) {
+			outcome := models.NewOperationOutcome("fatal", code, fmt.Sprintf(format, args...))
+			c.Render(http.StatusBadRequest, CustomFhirRenderer{outcome, c})
+		}
+		resource, err := FHIRBind(c, config.ValidatorURL)
+		if err == nil && resource.ResourceType() != "Parameters" {
+			err = fmt.Errorf("Expected a Parameters but got a %s", resource.ResourceType())
+		}
+		if err != nil {
+			badRequest("structure", "%s", err)
+			return
+		}
+		var parameters struct {
+			Parameter []struct {
+				Name      string `json:"name"`
+				ValueCode string `json:"valueCode"`
+				Part      []struct {
+					Name      string `json:"name"`
+					ValueCode string `json:"valueCode"`
+					ValueURI  string `json:"valueUri"`
+				} `json:"part"`
+			} `json:"parameter"`
+		}
+		if err := json.Unmarshal(resource.JsonBytes(), &parameters); err != nil {
+			panic(errors.Wrap(err, "BulkImportHandler: failed to parse Parameters"))
+		}
+
+		var inputs []importInput
+		for _, parameter := range parameters.Parameter {
+			switch parameter.Name {
+			case "inputFormat":
+				if parameter.ValueCode != "application/fhir+ndjson" && parameter.ValueCode != "application/ndjson" && parameter.ValueCode != "ndjson" {
+					badRequest("value", "unsupported inputFormat: %q", parameter.ValueCode)
+					return
+				}
+			case "input":
+				var input importInput
+				for _, part := range parameter.Part {
+					switch part.Name {
+					case "type":
+						input.resourceType = part.ValueCode
+					case "url":
+						input.url = part.ValueURI
+					}
+				}
+				if input.resourceType != "" && models.StructForResourceName(input.resourceType) == nil {
+					badRequest("value", "unknown resource type of input: %q", input.resourceType)
+					return
+				}
+				if input.url == "" {
+					badRequest("value", "an input has no url")
+					return
+				}
+				inputs = append(inputs, input)
+			}
+		}
+		if len(inputs) == 0 {
+			badRequest("value", "there are no inputs to import")
+			return
+		}
+
+		purgeExpiredImportJobs()
+		ctx, cancel := context.WithCancel(context.Background())
+		job := &importJob{
+			id:              uuid.New().String(),
+			request:         config.responseURL(c.Request, "$import").String(),
+			transactionTime: time.Now().UTC(),
+			cancel:          cancel,
+			progress:        "Starting the import",
+		}
+		bulkImportJobs.Lock()
+		bulkImportJobs.byID[job.id] = job
+		bulkImportJobs.Unlock()
+
+		go job.run(ctx, dal, c.GetHeader("Db"), inputs)
+
+		c.Header("Content-Location", config.responseURL(c.Request, "$import-status", job.id).String())
+		c.Status(http.StatusAccepted)
+	}
+}
+
+// BulkImportStatusHandler handles requests for the status of bulk imports (GET /$import-status/[id]), responding
+// with 202 Accepted while they're running, with how many resources of each file were imported (and the errors of
+// those that weren't) once they're complete, or with an OperationOutcome if they failed
+func BulkImportStatusHandler(c *gin.Context) {
+	defer handlePanics(c)
+	c.Set("Action", "operation")
+
+	bulkImportJobs.Lock()
+	job := bulkImportJobs.byID[c.Param("job")]
+	bulkImportJobs.Unlock()
+	if job == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	job.mutex.Lock()
+	progress, completed, jobErr, results := job.progress, job.completed, job.err, job.results
+	job.mutex.Unlock()
+
+	if completed.IsZero() {
+		c.Header("X-Progress", progress)
+		c.Header("Retry-After", strconv.Itoa(bulkExportRetryAfter))
+		c.Status(http.StatusAccepted)
+		return
+	}
+	if jobErr != nil {
+		outcome := models.NewOperationOutcome("fatal", "exception", fmt.Sprintf("The import failed: %s", jobErr))
+		c.Render(http.StatusInternalServerError, CustomFhirRenderer{outcome, c})
+		return
+	}
+
+	manifest := importManifest{
+		TransactionTime: job.transactionTime.Format(time.RFC3339Nano),
+		Request:         job.request,
+		Output:          []importManifestFile{},
+		Error:           []importManifestFile{},
+	}
+	for _, result := range results {
+		manifest.Output = append(manifest.Output, importManifestFile{Type: result.resourceType, InputURL: result.url, Count: result.Imported})
+		if result.Failed > 0 || len(result.Errors) > 0 {
+			manifest.Error = append(manifest.Error, importManifestFile{Type: result.resourceType, InputURL: result.url, Count: result.Failed, Errors: result.Errors})
+		}
+	}
+	c.JSON(http.StatusOK, manifest)
+}
+
+// BulkImportDeleteHandler handles requests to cancel bulk imports, or forget them once they're complete (DELETE
+// /$import-status/[id]).  The resources that were already imported aren't deleted.
+func BulkImportDeleteHandler(c *gin.Context) {
+	defer handlePanics(c)
+	c.Set("Action", "operation")
+
+	bulkImportJobs.Lock()
+	job := bulkImportJobs.byID[c.Param("job")]
+	delete(bulkImportJobs.byID, c.Param("job"))
+	bulkImportJobs.Unlock()
+	if job == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	job.cancel()
+	c.Status(http.StatusAccepted)
+}
+
+// purgeExpiredImportJobs forgets the bulk imports that completed longer ago than importJobLifetime
+func purgeExpiredImportJobs() {
+	bulkImportJobs.Lock()
+	defer bulkImportJobs.Unlock()
+	for id, job := range bulkImportJobs.byID {
+		job.mutex.Lock()
+		if !job.completed.IsZero() && time.Since(job.completed) > importJobLifetime {
+			delete(bulkImportJobs.byID, id)
+		}
+		job.mutex.Unlock()
+	}
+}
+
+// run imports the files of a bulk import, one at a time.  Files that can't be downloaded are reported as errors of
+// their own, while the import continues with the others.
+func (job *importJob) run(ctx context.Context, dal DataAccessLayer, db string, inputs []importInput) {
+	session := dal.StartSession(ctx, db)
+	defer session.Finish()
+
+	var err error
+	for i, input := range inputs {
+		job.mutex.Lock()
+		job.progress = fmt.Sprintf("Importing %s (%d of %d files)", input.url, i+1, len(inputs))
+		job.mutex.Unlock()
+
+		var result ImportResult
+		result, err = importURL(ctx, session, input)
+		if _, isDownloadErr := err.(importDownloadError); isDownloadErr {
+			result.Errors = append(result.Errors, err.Error())
+			err = nil
+		}
+		job.mutex.Lock()
+		job.results = append(job.results, importFileResult{importInput: input, ImportResult: result})
+		job.mutex.Unlock()
+		if err != nil {
+			break
+		}
+	}
+	if err != nil && ctx.Err() == nil {
+		glog.Errorf("bulk import %s failed: %+v", job.id, err)
+	}
+
+	job.mutex.Lock()
+	job.completed = time.Now()
+	job.err = err
+	job.mutex.Unlock()
+}
+
+// importDownloadError is the error of a file of a bulk import that can't be downloaded
+type importDownloadError struct {
+	cause string
+}
+
+func (e importDownloadError) Error() string {
+	return "failed to download the file: " + e.cause
+}
+
+// importURL downloads and imports an NDJSON file of a bulk import
+func importURL(ctx context.Context, session DataAccessSession, input importInput) (ImportResult, error) {
+	req, err := http.NewRequest(http.MethodGet, input.url, nil)
+	if err != nil {
+		return ImportResult{}, importDownloadError{err.Error()}
+	}
+	req.Header.Set("Accept", "application/fhir+ndjson")
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return ImportResult{}, importDownloadError{err.Error()}
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return ImportResult{}, importDownloadError{res.Status}
+	}
+	return ImportNDJSON(session, res.Body, input.resourceType)
+}
@@ -0,0 +1,299 @@
+package server
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/eug48/fhir/auth"
+	"github.com/eug48/fhir/models"
+	"github.com/eug48/fhir/models2"
+	"github.com/eug48/fhir/search"
+	"github.com/gin-gonic/gin"
+)
+
+// fhirVersion is the version of FHIR of the models
+const fhirVersion = "3.0.1"
+
+// fhirMimeTypeVersion is the version of FHIR in the fhirVersion parameters of MIME types, e.g.
+// application/fhir+json; fhirVersion=3.0
+const fhirMimeTypeVersion = "3.0"
+
+// supportsFhirVersion checks whether a fhirVersion MIME type parameter is one that the server supports
+func supportsFhirVersion(version string) bool {
+	return version == fhirMimeTypeVersion || version == fhirVersion
+}
+
+// CapabilityStatementHandler handles GET /metadata with a CapabilityStatement describing the resource types whose
+// routes are registered with the engine, their search parameters, and the interactions and operations that the
+// routes and configuration enable (and the URL of the WebSocket of Subscriptions, if enabled).  It's generated for
+// each request so that it includes search parameters registered since the server started.
+func CapabilityStatementHandler(e *gin.Engine, config Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer handlePanics(c)
+		c.Set("Action", "capabilities")
+
+		statement := newCapabilityStatement(engineRoutes(e), config)
+		statement.Implementation.Url = config.responseURL(c.Request).String()
+		if tenant, isTenant := c.Get("tenant"); isTenant {
+			restrictToTenant(statement, tenant.(*Tenant))
+		}
+		if config.EnableSubscriptions {
+			websocketURL := config.responseURL(c.Request, "websocket")
+			websocketURL.Scheme = strings.Replace(websocketURL.Scheme, "http", "ws", 1)
+			statement.Rest[0].Extension = append(statement.Rest[0].Extension, models.Extension{
+				Url:      "http://hl7.org/fhir/StructureDefinition/capabilitystatement-websocket",
+				ValueUri: websocketURL.String(),
+			})
+		}
+		c.Render(http.StatusOK, CustomFhirRenderer{statement, c})
+	}
+}
+
+// restrictToTenant limits a CapabilityStatement to the resource types of a tenant (see Tenant.ResourceTypes)
+func restrictToTenant(statement *models.CapabilityStatement, tenant *Tenant) {
+	if tenant.Name != "" {
+		statement.Implementation.Description = tenant.Name
+	}
+	if len(tenant.ResourceTypes) == 0 {
+		return
+	}
+	resources := statement.Rest[0].Resource[:0]
+	for _, resource := range statement.Rest[0].Resource {
+		if stringsInclude(tenant.ResourceTypes, resource.Type) {
+			resources = append(resources, resource)
+		}
+	}
+	statement.Rest[0].Resource = resources
+}
+
+// VersionsHandler handles GET /$versions with the versions of FHIR that the server supports, and the default
+func VersionsHandler(c *gin.Context) {
+	defer handlePanics(c)
+	c.Set("Action", "operation")
+
+	parameters := &models.Parameters{
+		Parameter: []models.ParametersParameterComponent{
+			{Name: "version", ValueCode: fhirMimeTypeVersion},
+			{Name: "default", ValueCode: fhirMimeTypeVersion},
+		},
+	}
+	c.Render(http.StatusOK, CustomFhirRenderer{parameters, c})
+}
+
+// resourceTypeRoute matches the path of the route of a resource type's searches, e.g. /Patient
+var resourceTypeRoute = regexp.MustCompile(`^/([A-Z][A-Za-z]+)$`)
+
+// operationRoute matches the path of an operation's route, e.g. /Patient/:id/$everything or /$explain
+var operationRoute = regexp.MustCompile(`^(?:/([A-Z][A-Za-z]+))?(?:/:id)?/\$([A-Za-z-]+)$`)
+
+// standardOperations are the canonical URLs of the definitions of the standard operations that the server supports
+// (those of every resource type under Resource)
+var standardOperations = map[string]string{
+	"/versions":            "http://hl7.org/fhir/OperationDefinition/CapabilityStatement-versions",
+	"Resource/validate":    "http://hl7.org/fhir/OperationDefinition/Resource-validate",
+	"/graphql":             "http://hl7.org/fhir/OperationDefinition/Resource-graphql",
+	"Resource/graphql":     "http://hl7.org/fhir/OperationDefinition/Resource-graphql",
+	"Patient/everything":   "http://hl7.org/fhir/OperationDefinition/Patient-everything",
+	"Encounter/everything": "http://hl7.org/fhir/OperationDefinition/Encounter-everything",
+	"Composition/document": "http://hl7.org/fhir/OperationDefinition/Composition-document",
+	"/export":              "http://hl7.org/fhir/uv/bulkdata/OperationDefinition/export",
+	"Group/export":         "http://hl7.org/fhir/uv/bulkdata/OperationDefinition/group-export",
+	"Subscription/status":  "http://hl7.org/fhir/uv/subscriptions-backport/OperationDefinition/backport-subscription-status",
+	"Subscription/events":  "http://hl7.org/fhir/uv/subscriptions-backport/OperationDefinition/backport-subscription-events",
+}
+
+func newCapabilityStatement(routes gin.RoutesInfo, config Config) *models.CapabilityStatement {
+	registered := make(map[string]bool, len(routes))
+	var resourceTypes []string
+	for _, route := range routes {
+		registered[route.Method+" "+route.Path] = true
+		if match := resourceTypeRoute.FindStringSubmatch(route.Path); match != nil && route.Method == "GET" {
+			if search.SearchParameters()[match[1]] != nil {
+				resourceTypes = append(resourceTypes, match[1])
+			}
+		}
+	}
+	sort.Strings(resourceTypes)
+
+	formats := []string{"application/fhir+json", "json"}
+	patchFormats := []string{"application/json-patch+json", "application/fhir+json"}
+	if config.EnableXML {
+		formats = append(formats, "application/fhir+xml", "xml")
+		patchFormats = append(patchFormats, "application/fhir+xml")
+	}
+
+	rest := models.CapabilityStatementRestComponent{
+		Mode: "server",
+		Security: &models.CapabilityStatementRestSecurityComponent{
+			Cors: boolPtr(true),
+		},
+	}
+	if config.Auth.Method == auth.AuthTypeSMART {
+		// the authorization server's endpoints are in .well-known/smart-configuration, as the oauth-uris extension
+		// (with nested extensions) can't be represented
+		rest.Security.Service = []models.CodeableConcept{{
+			Coding: []models.Coding{{System: "http://hl7.org/fhir/restful-security-service", Code: "SMART-on-FHIR"}},
+		}}
+		rest.Security.Description = "SMART on FHIR bearer tokens (see .well-known/smart-configuration)"
+	} else if config.Auth.Method != auth.AuthTypeNone {
+		rest.Security.Service = []models.CodeableConcept{{
+			Coding: []models.Coding{{System: "http://hl7.org/fhir/restful-security-service", Code: "OAuth"}},
+		}}
+	}
+	for _, resourceType := range resourceTypes {
+		rest.Resource = append(rest.Resource, capabilityStatementResource(resourceType, registered, config))
+	}
+
+	systemInteractions := []struct {
+		code  string
+		route string
+	}{
+		{"transaction", "POST /"},
+		{"batch", "POST /"},
+		{"search-system", "POST /_search"},
+		{"history-system", "GET /_history"},
+	}
+	for _, interaction := range systemInteractions {
+		if registered[interaction.route] {
+			rest.Interaction = append(rest.Interaction, models.CapabilityStatementSystemInteractionComponent{Code: interaction.code})
+		}
+	}
+
+	// Standard operations are listed with the definition for each type, and others once
+	var operationRoutes []string
+	for route := range registered {
+		operationRoutes = append(operationRoutes, route)
+	}
+	sort.Strings(operationRoutes)
+	listed := make(map[string]bool)
+	for _, route := range operationRoutes {
+		match := operationRoute.FindStringSubmatch(strings.SplitN(route, " ", 2)[1])
+		if match == nil {
+			continue
+		}
+		name := match[2]
+		definition := &models.Reference{Display: "$" + name}
+		if canonicalURL, isStandard := standardOperations[match[1]+"/"+name]; isStandard {
+			definition = &models.Reference{Reference: canonicalURL}
+		} else if canonicalURL, isStandard := standardOperations["Resource/"+name]; isStandard && match[1] != "" {
+			definition = &models.Reference{Reference: canonicalURL}
+		}
+		key := definition.Reference + definition.Display
+		if listed[key] {
+			continue
+		}
+		listed[key] = true
+		rest.Operation = append(rest.Operation, models.CapabilityStatementRestOperationComponent{Name: name, Definition: definition})
+	}
+
+	// The profiles loaded from packages that resources may be validated against
+	var profiles []models.Reference
+	for _, url := range models2.ProfileURLs() {
+		profiles = append(profiles, models.Reference{Reference: url})
+	}
+
+	return &models.CapabilityStatement{
+		Name:           "GoFHIR Capability Statement",
+		Description:    "GoFHIR capability statement",
+		Publisher:      "PAT Pty Ltd, The MITRE Corporation",
+		Status:         "active",
+		Date:           &models.FHIRDateTime{Time: time.Now(), Precision: models.Timestamp},
+		Kind:           "instance",
+		Software:       &models.CapabilityStatementSoftwareComponent{Name: "GoFHIR"},
+		Implementation: &models.CapabilityStatementImplementationComponent{Description: "GoFHIR"},
+		FhirVersion:    fhirVersion,
+		AcceptUnknown:  "extensions",
+		Format:         formats,
+		PatchFormat:    patchFormats,
+		Profile:        profiles,
+		Rest:           []models.CapabilityStatementRestComponent{rest},
+	}
+}
+
+// capabilityStatementResource describes the interactions and searches of a resource type
+func capabilityStatementResource(resourceType string, registered map[string]bool, config Config) models.CapabilityStatementRestResourceComponent {
+	resource := models.CapabilityStatementRestResourceComponent{
+		Type:              resourceType,
+		Versioning:        "no-version",
+		ReadHistory:       boolPtr(config.EnableHistory),
+		UpdateCreate:      boolPtr(true),
+		ConditionalCreate: boolPtr(true),
+		ConditionalRead:   "full-support",
+		ConditionalUpdate: boolPtr(true),
+		ConditionalDelete: "multiple",
+	}
+	if config.EnableHistory {
+		resource.Versioning = "versioned-update"
+	}
+	if !config.ConditionalDeleteMultiple {
+		resource.ConditionalDelete = "single"
+	}
+
+	interactions := []struct {
+		code  string
+		route string
+	}{
+		{"read", "GET /" + resourceType + "/:id"},
+		{"vread", "GET /" + resourceType + "/:id/_history/:vid"},
+		{"update", "PUT /" + resourceType + "/:id"},
+		{"patch", "PATCH /" + resourceType + "/:id"},
+		{"delete", "DELETE /" + resourceType + "/:id"},
+		{"history-instance", "GET /" + resourceType + "/:id/_history"},
+		{"create", "POST /" + resourceType},
+		{"search-type", "GET /" + resourceType},
+		{"history-type", "GET /" + resourceType + "/_history"},
+	}
+	for _, interaction := range interactions {
+		if registered[interaction.route] {
+			resource.Interaction = append(resource.Interaction, models.CapabilityStatementResourceInteractionComponent{Code: interaction.code})
+		}
+	}
+
+	params := search.SearchParameters()[resourceType]
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		param := params[name]
+		resource.SearchParam = append(resource.SearchParam, models.CapabilityStatementRestResourceSearchParamComponent{
+			Name: name,
+			Type: param.Type,
+		})
+		if param.Type == "reference" {
+			resource.SearchInclude = append(resource.SearchInclude, resourceType+":"+name)
+		}
+	}
+	resource.SearchInclude = append(resource.SearchInclude, "*")
+	resource.SearchRevInclude = searchRevIncludes(resourceType)
+	return resource
+}
+
+// searchRevIncludes returns the _revinclude parameters of searches of a resource type: the reference search
+// parameters of other types that may refer to it
+func searchRevIncludes(resourceType string) []string {
+	var revIncludes []string
+	for otherType, params := range search.SearchParameters() {
+		for name, param := range params {
+			if param.Type != "reference" {
+				continue
+			}
+			for _, target := range param.Targets {
+				if target == resourceType || target == "Any" {
+					revIncludes = append(revIncludes, otherType+":"+name)
+					break
+				}
+			}
+		}
+	}
+	sort.Strings(revIncludes)
+	return revIncludes
+}
+
+func boolPtr(value bool) *bool {
+	return &value
+}
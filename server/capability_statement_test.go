@@ -0,0 +1,133 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/eug48/fhir/models"
+	"github.com/gin-gonic/gin"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+func (s *ServerSuite) TestCapabilityStatement(c *C) {
+	res, err := http.Get(s.Server.URL + "/metadata")
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, 200)
+	statement := &models.CapabilityStatement{}
+	util.CheckErr(json.NewDecoder(res.Body).Decode(statement))
+
+	c.Assert(statement.FhirVersion, Equals, "3.0.1")
+	c.Assert(statement.Kind, Equals, "instance")
+	c.Assert(statement.Format, DeepEquals, []string{"application/fhir+json", "json", "application/fhir+xml", "xml"})
+	c.Assert(statement.Implementation.Url, Equals, s.Server.URL+"/")
+	c.Assert(statement.Rest, HasLen, 1)
+	rest := statement.Rest[0]
+	c.Assert(rest.Mode, Equals, "server")
+
+	var systemInteractions []string
+	for _, interaction := range rest.Interaction {
+		systemInteractions = append(systemInteractions, interaction.Code)
+	}
+	c.Assert(systemInteractions, DeepEquals, []string{"transaction", "batch", "search-system", "history-system"})
+
+	var operations []string
+	for _, operation := range rest.Operation {
+		operations = append(operations, operation.Name+" "+operation.Definition.Reference)
+	}
+	c.Assert(operations, DeepEquals, []string{
+		"versions http://hl7.org/fhir/OperationDefinition/CapabilityStatement-versions",
+		"graph ",
+		"document http://hl7.org/fhir/OperationDefinition/Composition-document",
+		"everything http://hl7.org/fhir/OperationDefinition/Encounter-everything",
+		"everything http://hl7.org/fhir/OperationDefinition/Patient-everything",
+		"validate http://hl7.org/fhir/OperationDefinition/Resource-validate",
+	})
+
+	// Every registered resource type is described
+	c.Assert(len(rest.Resource) > 100, Equals, true)
+	var patient, observation *models.CapabilityStatementRestResourceComponent
+	for i := range rest.Resource {
+		switch rest.Resource[i].Type {
+		case "Patient":
+			patient = &rest.Resource[i]
+		case "Observation":
+			observation = &rest.Resource[i]
+		}
+	}
+	c.Assert(patient, NotNil)
+	c.Assert(observation, NotNil)
+
+	var interactions []string
+	for _, interaction := range patient.Interaction {
+		interactions = append(interactions, interaction.Code)
+	}
+	c.Assert(interactions, DeepEquals, []string{"read", "vread", "update", "patch", "delete", "history-instance", "create", "search-type", "history-type"})
+	c.Assert(patient.Versioning, Equals, "versioned-update")
+	c.Assert(*patient.ReadHistory, Equals, true)
+	c.Assert(patient.ConditionalDelete, Equals, "multiple")
+	c.Assert(patient.ConditionalRead, Equals, "full-support")
+
+	params := make(map[string]string)
+	for _, param := range patient.SearchParam {
+		params[param.Name] = param.Type
+	}
+	c.Assert(params["_id"], Equals, "token")
+	c.Assert(params["gender"], Equals, "token")
+	c.Assert(params["birthdate"], Equals, "date")
+	c.Assert(params["organization"], Equals, "reference")
+	c.Assert(patient.SearchInclude, DeepEquals, []string{"Patient:general-practitioner", "Patient:link", "Patient:organization", "*"})
+
+	revIncludes := make(map[string]bool)
+	for _, revInclude := range patient.SearchRevInclude {
+		revIncludes[revInclude] = true
+	}
+	c.Assert(revIncludes["Observation:subject"], Equals, true)
+	c.Assert(revIncludes["Encounter:patient"], Equals, true)
+	c.Assert(revIncludes["Organization:partof"], Equals, false)
+
+	// Composite parameters are listed too
+	observationParams := make(map[string]string)
+	for _, param := range observation.SearchParam {
+		observationParams[param.Name] = param.Type
+	}
+	c.Assert(observationParams["code-value-quantity"], Equals, "composite")
+}
+
+func (s *ServerSuite) TestCapabilityStatementWithoutHistory(c *C) {
+	// Only the interactions with routes are listed
+	config := DefaultConfig
+	config.EnableHistory = false
+	engine := gin.New()
+	RegisterRoutes(engine, make(map[string][]gin.HandlerFunc), NewMongoDataAccessLayer(s.client, s.dbname, true, "_fhir", nil, config), config)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/metadata")
+	util.CheckErr(err)
+	statement := &models.CapabilityStatement{}
+	util.CheckErr(json.NewDecoder(res.Body).Decode(statement))
+	var interactions []string
+	for _, resource := range statement.Rest[0].Resource {
+		if resource.Type == "Patient" {
+			for _, interaction := range resource.Interaction {
+				interactions = append(interactions, interaction.Code)
+			}
+		}
+	}
+	c.Assert(interactions, DeepEquals, []string{"read", "update", "patch", "delete", "create", "search-type"})
+}
+
+func (s *ServerSuite) TestVersions(c *C) {
+	res, err := http.Get(s.Server.URL + "/$versions")
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, 200)
+	parameters := &models.Parameters{}
+	util.CheckErr(json.NewDecoder(res.Body).Decode(parameters))
+	c.Assert(parameters.Parameter, HasLen, 2)
+	c.Assert(parameters.Parameter[0].Name, Equals, "version")
+	c.Assert(parameters.Parameter[0].ValueCode, Equals, "3.0")
+	c.Assert(parameters.Parameter[1].Name, Equals, "default")
+	c.Assert(parameters.Parameter[1].ValueCode, Equals, "3.0")
+}
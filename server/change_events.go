@@ -0,0 +1,225 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/golang/glog"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+// ChangeEventsCollection is the outbox of the change events of each database, which are recorded along with the
+// changes (in the same transaction, if there is one) and deleted once they've been published
+const ChangeEventsCollection = "changeevents"
+
+// The actions of change events
+const (
+	ChangeEventCreate = "create"
+	ChangeEventUpdate = "update"
+	ChangeEventDelete = "delete"
+)
+
+const (
+	// changeEventBatchSize is the maximum number of change events published at once
+	changeEventBatchSize = 100
+	// changeEventLease is how long a server has to publish the change events it's claimed before other servers
+	// may claim them
+	changeEventLease = time.Minute
+	// changeEventPollInterval is how often the outboxes are checked for change events that weren't published when
+	// they were recorded (e.g. those recorded by other servers, or whose publishing failed)
+	changeEventPollInterval = 5 * time.Second
+)
+
+// ChangeEvent is a created, updated or deleted resource, published to the event bus as JSON (or returned by a
+// ChangeFeed)
+type ChangeEvent struct {
+	Id           string          `bson:"_id" json:"eventId"`
+	Action       string          `bson:"action" json:"action"`
+	Database     string          `bson:"database" json:"database"`
+	ResourceType string          `bson:"resourceType" json:"resourceType"`
+	ResourceId   string          `bson:"resourceId" json:"id"`
+	VersionId    string          `bson:"versionId,omitempty" json:"versionId,omitempty"`
+	Timestamp    time.Time       `bson:"timestamp" json:"timestamp"`
+	Resource     json.RawMessage `bson:"resource,omitempty" json:"resource,omitempty"`
+	LeaseExpires time.Time       `bson:"leaseExpires" json:"-"`
+}
+
+// eventPublisher publishes change events to an event bus, returning once it's received them
+type eventPublisher interface {
+	publish(events []*ChangeEvent) error
+}
+
+// newEventPublisher returns a publisher of change events to the event bus at a URL: a Kafka cluster
+// (kafka://broker1:9092,broker2:9092), to whose topic events are published with their resources' Type/id as their
+// keys (so that the events of each resource are ordered), or a NATS server (nats://host:4222), to whose
+// [topic].[resource type] subjects events are published
+func newEventPublisher(busURL string, topic string) (eventPublisher, error) {
+	parsed, err := url.Parse(busURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid event bus URL")
+	}
+	switch parsed.Scheme {
+	case "kafka":
+		config := sarama.NewConfig()
+		config.Producer.RequiredAcks = sarama.WaitForAll
+		config.Producer.Return.Successes = true
+		producer, err := sarama.NewSyncProducer(strings.Split(parsed.Host, ","), config)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to connect to Kafka")
+		}
+		return &kafkaPublisher{producer: producer, topic: topic}, nil
+	case "nats":
+		conn, err := nats.Connect(busURL, nats.MaxReconnects(-1))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to connect to NATS")
+		}
+		return &natsPublisher{conn: conn, subject: topic}, nil
+	default:
+		return nil, errors.Errorf("unsupported event bus: %s (expected kafka:// or nats://)", busURL)
+	}
+}
+
+// kafkaPublisher publishes change events to a Kafka topic
+type kafkaPublisher struct {
+	producer sarama.SyncProducer
+	topic    string
+}
+
+func (p *kafkaPublisher) publish(events []*ChangeEvent) error {
+	messages := make([]*sarama.ProducerMessage, len(events))
+	for i, event := range events {
+		value, err := json.Marshal(event)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal the event")
+		}
+		messages[i] = &sarama.ProducerMessage{
+			Topic: p.topic,
+			Key:   sarama.StringEncoder(event.ResourceType + "/" + event.ResourceId),
+			Value: sarama.ByteEncoder(value),
+		}
+	}
+	return errors.Wrap(p.producer.SendMessages(messages), "failed to send the events to Kafka")
+}
+
+// natsPublisher publishes change events to NATS subjects
+type natsPublisher struct {
+	conn    *nats.Conn
+	subject string
+}
+
+func (p *natsPublisher) publish(events []*ChangeEvent) error {
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal the event")
+		}
+		if err := p.conn.Publish(p.subject+"."+event.ResourceType, data); err != nil {
+			return errors.Wrap(err, "failed to publish the event to NATS")
+		}
+	}
+	// the server has received the events once it's responded to a ping
+	return errors.Wrap(p.conn.FlushTimeout(subscriptionTimeout), "failed to flush the events to NATS")
+}
+
+// changeEventRelay publishes the change events recorded in the outboxes of the databases, deleting them once the
+// event bus has received them, so that each event is published at least once: events are claimed (see
+// claimChangeEvents) so that they're only published by one server at a time, and those that aren't published (e.g.
+// as the event bus is unavailable, or the server stopped) are retried.
+type changeEventRelay struct {
+	dal       *mongoDataAccessLayer
+	publisher eventPublisher
+	payloads  bool
+	wake      chan string
+
+	// the databases whose outboxes are checked for events
+	dbNames struct {
+		sync.Mutex
+		names map[string]bool
+	}
+}
+
+func newChangeEventRelay(dal *mongoDataAccessLayer, publisher eventPublisher, payloads bool) *changeEventRelay {
+	r := &changeEventRelay{
+		dal:       dal,
+		publisher: publisher,
+		payloads:  payloads,
+		wake:      make(chan string, 1),
+	}
+	r.dbNames.names = map[string]bool{dal.defaultDbName: true}
+	go r.run()
+	return r
+}
+
+// eventsRecorded wakes the relay to publish the events recorded in a database
+func (r *changeEventRelay) eventsRecorded(dbName string) {
+	r.dbNames.Lock()
+	r.dbNames.names[dbName] = true
+	r.dbNames.Unlock()
+	select {
+	case r.wake <- dbName:
+	default:
+		// the relay's already been woken
+	}
+}
+
+func (r *changeEventRelay) run() {
+	ticker := time.NewTicker(changeEventPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case dbName := <-r.wake:
+			r.publishEvents(dbName)
+		case <-ticker.C:
+			r.dbNames.Lock()
+			var dbNames []string
+			for dbName := range r.dbNames.names {
+				dbNames = append(dbNames, dbName)
+			}
+			r.dbNames.Unlock()
+			for _, dbName := range dbNames {
+				r.publishEvents(dbName)
+			}
+		}
+	}
+}
+
+// publishEvents publishes the events in the outbox of a database, in batches, until there are none left to claim
+// or publishing fails
+func (r *changeEventRelay) publishEvents(dbName string) {
+	if dbName == r.dal.defaultDbName {
+		dbName = ""
+	}
+	session := r.dal.StartSession(context.Background(), dbName).(*mongoSession)
+	defer session.Finish()
+	for {
+		events, err := session.claimChangeEvents(changeEventBatchSize, changeEventLease)
+		if err != nil {
+			glog.Errorf("failed to claim change events: %+v", err)
+			return
+		} else if len(events) == 0 {
+			return
+		}
+
+		if err := r.publisher.publish(events); err != nil {
+			// the events are retried when the outbox is next checked
+			glog.Errorf("failed to publish %d change events: %+v", len(events), err)
+			if err := session.releaseChangeEvents(events); err != nil {
+				glog.Errorf("failed to release change events: %+v", err)
+			}
+			return
+		}
+		if err := session.deleteChangeEvents(events); err != nil {
+			// the events will be published again once their leases expire
+			glog.Errorf("failed to delete published change events: %+v", err)
+			return
+		} else if len(events) < changeEventBatchSize {
+			return
+		}
+	}
+}
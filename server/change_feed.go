@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/eug48/fhir/models"
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// ChangeFeedHandler returns a handler of Server-Sent Events streams of the changes to the resources of a type (GET
+// /[type]/$changes, see ChangeFeed), for consumers that want to be notified of changes in order without polling
+// _history.  Each event's type is its action (create, update or delete), its data is a ChangeEvent (with the
+// resource, unless it was deleted) and its id is its resume token, so that EventSource clients that reconnect
+// continue after the last change they received (with the Last-Event-ID header).
+func ChangeFeedHandler(dal DataAccessLayer, resourceType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer handlePanics(c)
+		c.Set("Action", "operation")
+
+		session := dal.StartSession(c.Request.Context(), c.GetHeader("Db"))
+		defer session.Finish()
+		feed, err := session.WatchChanges(resourceType, c.GetHeader("Last-Event-ID"))
+		if err == ErrInvalidResumeToken {
+			outcome := models.NewOperationOutcome("fatal", "value", "The Last-Event-ID header isn't a valid resume token")
+			c.Render(http.StatusBadRequest, CustomFhirRenderer{outcome, c})
+			return
+		} else if err != nil {
+			panic(errors.Wrap(err, "WatchChanges failed"))
+		}
+		defer feed.Close(c.Request.Context())
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Status(http.StatusOK)
+		c.Writer.Flush()
+		for {
+			change, err := feed.Next(c.Request.Context())
+			if err != nil {
+				// the client has disconnected, unless the stream failed
+				if c.Request.Context().Err() == nil {
+					glog.Errorf("the change feed of %s failed: %+v", resourceType, err)
+				}
+				return
+			}
+			data, err := json.Marshal(change)
+			if err != nil {
+				glog.Errorf("failed to marshal a change to %s/%s: %+v", change.ResourceType, change.ResourceId, err)
+				return
+			}
+			if _, err := fmt.Fprintf(c.Writer, "id: %s\nevent: %s\ndata: %s\n\n", change.Id, change.Action, data); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
@@ -8,18 +8,54 @@ import (
 	"time"
 
 	"github.com/eug48/fhir/auth"
+	"github.com/eug48/fhir/search"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // Config is used to hold information about the configuration of the FHIR server.
 type Config struct {
-	// ServerURL is the full URL for the root of the server. This may be used
-	// by other middleware to compute redirect URLs
+	// ServerURL is the full URL for the root of the server (e.g. https://fhir.example.com/ behind a load balancer or
+	// TLS terminator), used for Location headers, bundle fullUrls and paging links.  If it's empty they're derived
+	// from the request's Host, X-Forwarded-Host and X-Forwarded-Proto headers.  This may also be used by other
+	// middleware to compute redirect URLs.
 	ServerURL string
 
 	// Auth determines what, if any authentication and authorization will be used
 	// by the FHIR server
 	Auth auth.Config
 
+	// AuthorizationDecider, if not nil, decides whether each interaction of the requests to the server (e.g. a read
+	// or search of a resource type) is allowed, after any Auth authorization (see AuthorizationDecider)
+	AuthorizationDecider AuthorizationDecider
+
+	// OPAURL is the URL of an Open Policy Agent decision (e.g. http://localhost:8181/v1/data/fhir/authz) that
+	// decides whether each interaction is allowed, unless an AuthorizationDecider is set (see OPAAuthorizationDecider)
+	OPAURL string
+
+	// EnforceConsents toggles the enforcement of patients' Consent resources: the resources that active Consents deny
+	// a request's actor and purposes of use access to are filtered from searches and histories and can't be read, and
+	// the denials are recorded as AuditEvents (see consentSession)
+	EnforceConsents bool
+
+	// SecurityLabelPolicy, if not nil, configures which meta.security labels callers are cleared for: the resources
+	// with others are filtered from searches and histories and can't be read (see securityLabelSession)
+	SecurityLabelPolicy *SecurityLabelPolicy
+
+	// EnableAudit toggles the recording of an AuditEvent resource for every interaction (e.g. read, search, create
+	// or operation), which are written in the background
+	EnableAudit bool
+
+	// ClientRateLimit is the number of requests per second that each client (identified by the client id or API key
+	// that the auth middleware authenticated, or else its IP address) can make, with bursts of up to ClientRateBurst requests (0 for a
+	// burst of one second's requests).  Requests beyond it fail with 429 Too Many Requests.  0 disables the limit.
+	ClientRateLimit float64
+	ClientRateBurst int
+
+	// TenantRateLimit is the number of requests per second that the clients of each tenant (see EnableTenancy) can
+	// make together, with bursts of up to TenantRateBurst requests.  0 disables the limit.
+	TenantRateLimit float64
+	TenantRateBurst int
+
 	// Whether to create indexes on startup
 	CreateIndexes bool
 
@@ -27,6 +63,26 @@ type Config struct {
 	// what mongo indexes the server should create (or verify) on startup
 	IndexConfigPath string
 
+	// Whether to also create a text index on every resource collection on startup,
+	// which the _text and _content search parameters require
+	CreateTextIndexes bool
+
+	// Whether to also create indexes for the token, date and reference search parameters of
+	// every resource on startup (see search.RecommendedSearchIndexes)
+	CreateSearchIndexes bool
+
+	// SearchIndexConfigPath is the path to a search_indexes.conf configuration file, specifying
+	// which search parameters' indexes to include or exclude
+	SearchIndexConfigPath string
+
+	// IndexHintsPath is an optional path to an index_hints.conf configuration file, pinning
+	// searches using particular search parameters to particular indexes
+	IndexHintsPath string
+
+	// SearchParametersDir is an optional directory of JSON files containing custom SearchParameter
+	// resources (or Bundles of them) to register on startup, in addition to those stored in the database
+	SearchParametersDir string
+
 	// DatabaseURI is the url of the mongo replica set to use for the FHIR database.
 	// A replica set is required for transactions support
 	// e.g. mongodb://db1:27017,db2:27017/?replicaSet=rs1
@@ -43,12 +99,23 @@ type Config struct {
 	// All custom database names should end with this suffix (default is "_fhir")
 	DatabaseSuffix string
 
+	// EnableTenancy stores the resources of each tenant in a database of its own (see Tenant), which requests select
+	// with the tenant's id in their path (e.g. http://fhir-server/tenants/acme/Patient) or in the TenantHeader.
+	// Tenants are provisioned and deprovisioned with the /$tenants API.
+	EnableTenancy bool
+
+	// TenantHeader is the header with the id of the tenant of requests without one in their path (default is
+	// "X-Tenant-ID")
+	TenantHeader string
+
 	// DatabaseSocketTimeout is the amount of time the mgo driver will wait for a response
 	// from mongo before timing out.
 	DatabaseSocketTimeout time.Duration
 
 	// DatabaseOpTimeout is the amount of time GoFHIR will wait before killing a long-running
-	// database process. This defaults to a reasonable upper bound for slow, pipelined queries: 30s.
+	// database process. This defaults to a reasonable upper bound for slow, pipelined queries: 90s.
+	// Searches pass it to MongoDB as maxTimeMS and report searches that exceed it as too costly.
+	// Zero means no limit.
 	DatabaseOpTimeout time.Duration
 
 	// DatabaseKillOpPeriod is the length of time between scans of the database to kill long-running ops.
@@ -59,6 +126,24 @@ type Config struct {
 	// for large datasets.
 	CountTotalResults bool
 
+	// CacheSearchCounts toggles whether the totals of searches are cached (in the countcache
+	// collection of each database or in Redis, see CountCacheRedisURL), invalidating them
+	// whenever resources of the types a search depends on change.  Read-only servers always
+	// cache them.
+	CacheSearchCounts bool
+
+	// CountCacheTTL is how long a cached search total is used for (0 for no expiry)
+	CountCacheTTL time.Duration
+
+	// CountCacheMaxEntries is the largest number of search totals cached per database,
+	// evicting the oldest ones (0 for no maximum)
+	CountCacheMaxEntries int
+
+	// CountCacheRedisURL is the URL of a Redis server in which to cache the totals of searches
+	// (e.g. redis://localhost:6379/0), so that several servers can share them.  When empty,
+	// they are cached in the database.  Redis' own eviction policy bounds its size.
+	CountCacheRedisURL string
+
 	// EnableCISearches toggles whether the mongo searches uses regexes to maintain
 	// case-insesitivity when performing searches on string fields, codes, etc.
 	EnableCISearches bool
@@ -68,9 +153,113 @@ type Config struct {
 	// R4 leans towards case-sensitive, whereas STU3 text suggests case-insensitive (https://github.com/HL7/fhir/commit/13fb1c1f102caf7de7266d6e78ab261efac06a1f)
 	TokenParametersCaseSensitive bool
 
+	// LowercaseSearchFields makes case-insensitive searches match the lowercase copies of strings,
+	// codes and uris stored with every resource rather than using case-insensitive regexes (which
+	// can't use indexes efficiently).  Only enable it once every resource has been stored (or
+	// re-stored) by a version of the server that adds lowercase copies.
+	LowercaseSearchFields bool
+
+	// PrecomputedCompartments makes compartment searches (e.g. GET /Patient/123/Observation) match the
+	// compartments stored with every resource (e.g. ["Patient/123"]) rather than any of the compartment's
+	// search parameters, so that they use a single index.  Only enable it once every resource has been
+	// stored (or re-stored) by a version of the server that stores their compartments.
+	PrecomputedCompartments bool
+
+	// Collation is an optional ICU locale (e.g. "fr" or "de@collation=phonebook") used to sort
+	// strings, so that accented names sort correctly.  With a CollationStrength of 1 or 2 (which
+	// ignore case) it's also used for case-insensitive searches.  Indexes are created with it, since
+	// searches can only use indexes with the same collation.
+	Collation string
+
+	// CollationStrength is the ICU comparison level of the Collation, from 1 (base characters only)
+	// to 5 (identical).  0 uses MongoDB's default of 3 (case and accent sensitive).
+	CollationStrength int
+
+	// DefaultTimezone is the IANA timezone (e.g. "Australia/Sydney") of dates without a time, both
+	// stored and searched, e.g. which instants birthDate=2012-01-01 covers.  When empty, the server's
+	// local timezone is used.  Resources stored with a different timezone need to be stored again.
+	DefaultTimezone string
+
+	// MaxChainDepth is the maximum number of references a chained search parameter
+	// may traverse, e.g. "subject.organization.name" has a depth of 2
+	MaxChainDepth int
+
+	// MaxIncludeDepth is the maximum number of times _include:iterate and _revinclude:iterate
+	// parameters are applied to the resources included by the previous iteration
+	MaxIncludeDepth int
+
+	// DefaultCount is the number of results per page of searches that don't specify a _count
+	DefaultCount int
+
+	// MaxCount is the largest _count searches may use (0 for no maximum).  Searches
+	// requesting more results per page get MaxCount results and a warning
+	MaxCount int
+
+	// MaxIncludes is the largest number of resources a page of search results may include
+	// using _include and _revinclude (0 for no maximum).  Any others are left out with a warning.
+	// The resources each _include and _revinclude looks up for a result are limited likewise.
+	MaxIncludes int
+
+	// KeysetPaging toggles whether the "next" links of search results use an opaque
+	// _cursor (based on the sort value and _id of the last result) rather than an _offset.
+	// This keeps deep pages fast and consistent under concurrent writes.  Offset paging
+	// is still used for queries that can't be paged that way (e.g. multiple sorts).
+	KeysetPaging bool
+
+	// ResultSetLifetime is how long the snapshot of the results of a search lasts (0 to not use
+	// snapshots).  If enabled, searches with more results than fit on their first page store the
+	// _ids of all of them, and the paging links refer to this _resultset, so that later pages are
+	// consistent with the first even if resources are created, updated or deleted in the meantime.
+	ResultSetLifetime time.Duration
+
+	// ResultSetMaxResults is the largest number of results a snapshot may store.  Searches
+	// with more results are paged as usual (without a snapshot)
+	ResultSetMaxResults int
+
 	// Whether to support storing previous versions of each resource
 	EnableHistory bool
 
+	// ConditionalDeleteMultiple toggles whether conditional deletes (e.g. DELETE /Observation?status=entered-in-error)
+	// delete every matching resource, or else only a single match, failing with 412 Precondition Failed
+	// if several resources match
+	ConditionalDeleteMultiple bool
+
+	// RequireIfMatch toggles whether updates of existing resources must have an If-Match header with their current
+	// version (e.g. If-Match: W/"3"), failing with 412 Precondition Failed otherwise, so that concurrent
+	// updates can't overwrite each other.  It requires EnableHistory.
+	RequireIfMatch bool
+
+	// ValidateResources toggles whether resources are validated against the FHIR definitions of their types before
+	// they're stored (see models2.ValidateResource), failing with 422 Unprocessable Entity and an OperationOutcome
+	// listing each issue if they aren't valid
+	ValidateResources bool
+
+	// ProfilePackages are the paths of FHIR NPM packages (package.tgz files, e.g. of US Core) to load on startup,
+	// so that resources are also validated against the profiles in their meta.profile (see models2.LoadPackage), and
+	// so that the $validate operation can validate resources against a requested profile
+	ProfilePackages []string
+
+	// EnforceReferences toggles whether creates and updates of resources that refer to other resources on the server
+	// that don't exist (e.g. a subject of Patient/123 when there's no such patient) fail with 422 Unprocessable
+	// Entity.  The references of a transaction's resources are checked when it's committed, so they may refer to
+	// each other.  With it, or ReferencedDeletes other than ReferencedDeletesAllow, GET /$dangling-references
+	// reports the references of stored resources to resources that don't exist (e.g. those stored before).
+	EnforceReferences bool
+
+	// ReferencedDeletes is what happens when resources that other resources refer to are deleted (as found by the
+	// reference search parameters that may refer to them): ReferencedDeletesAllow (the default, also if empty),
+	// ReferencedDeletesWarn to list the references as warnings in an OperationOutcome (except for the entries of
+	// transactions), ReferencedDeletesBlock to fail with 409 Conflict, ReferencedDeletesNullify to remove the
+	// references from the referring resources, or ReferencedDeletesCascade to delete the referring resources too
+	// (as their types' policies allow).  Deletes in transactions are checked when they're committed, so resources may
+	// be deleted along with those referring to them.  The changes made to the referring resources are listed in an
+	// OperationOutcome, and are made in a transaction.
+	ReferencedDeletes string
+
+	// ReferencedDeletesByType overrides ReferencedDeletes for the resources of particular types, e.g.
+	// {"Patient": ReferencedDeletesCascade, "Practitioner": ReferencedDeletesNullify}
+	ReferencedDeletesByType map[string]string
+
 	// Number of concurrent operations to do during batch bundle processing
 	BatchConcurrency int
 
@@ -82,6 +271,73 @@ type Config struct {
 	// ValidatorURL is an endpoint to which validation requests will be sent
 	ValidatorURL string
 
+	// EnableExplain toggles the GET /$explain?resource=...&query=... endpoint, which explains
+	// how searches are run.  It reveals how the data is stored, so is meant for operators.
+	EnableExplain bool
+
+	// EnableBulkExport toggles the bulk data export operations (GET /$export, /Patient/$export and
+	// /Group/[id]/$export), which export resources to NDJSON files in the background (see BulkExportHandler)
+	EnableBulkExport bool
+
+	// BulkExportDestination is where the files of bulk exports are stored: a local directory that clients download
+	// them from through the server (fhir-bulk-export in the temporary directory if empty), or an S3 bucket and prefix
+	// (e.g. s3://my-bucket/exports, see NewS3ExportDestination)
+	BulkExportDestination string
+
+	// BulkExportLifetime is how long the files of completed bulk exports are kept for
+	BulkExportLifetime time.Duration
+
+	// EnableBulkImport toggles the POST /$import operation, which imports NDJSON files downloaded from the given URLs
+	// in the background (see BulkImportHandler).  The server may download files from any URL, so it's meant for
+	// operators.
+	EnableBulkImport bool
+
+	// EnableAsync toggles the asynchronous request pattern: searches and operations with a Prefer: respond-async
+	// header are queued (in the default database) and run in the background, and clients poll for their responses
+	// (see AsyncRequestsMiddleware)
+	EnableAsync bool
+
+	// AsyncWorkers is how many asynchronous requests each server runs at once
+	AsyncWorkers int
+
+	// AsyncJobLifetime is how long the responses of asynchronous requests are kept for
+	AsyncJobLifetime time.Duration
+
+	// EnableSubscriptions toggles support for rest-hook and websocket Subscriptions, whose endpoints (or bound
+	// WebSocket connections) are notified of created and updated resources matching their criteria or SubscriptionTopics
+	// (see subscriptionNotifier).  Requested Subscriptions are activated when they're stored, and those the server
+	// can't support are rejected.
+	EnableSubscriptions bool
+
+	// SubscriptionRetries is how many times failed notifications of Subscriptions are retried before the
+	// Subscriptions are put in the error state
+	SubscriptionRetries int
+
+	// SubscriptionRetryDelay is how long the first retry of a failed notification is delayed, which is doubled for
+	// each further retry
+	SubscriptionRetryDelay time.Duration
+
+	// SubscriptionEventLifetime is how long the events of topic-based Subscriptions are kept for, to be returned by
+	// the $events operation
+	SubscriptionEventLifetime time.Duration
+
+	// EventBusURL is the URL of a Kafka cluster (e.g. kafka://broker1:9092,broker2:9092) or NATS server (e.g.
+	// nats://localhost:4222) that the events of created, updated and deleted resources are published to (see
+	// changeEventRelay), or empty to not publish them.  Events are recorded in an outbox along with the changes and
+	// published at least once.
+	EventBusURL string
+
+	// EventTopic is the Kafka topic that events are published to, or the prefix of the NATS subjects that they're
+	// published to (followed by the resource type, e.g. fhir.resources.Patient)
+	EventTopic string
+
+	// EventPayloads toggles whether the events of created and updated resources include the resources
+	EventPayloads bool
+
+	// EnableChangeFeed toggles the Server-Sent Events streams of the changes to the resources of each type (GET
+	// /[type]/$changes, see ChangeFeedHandler), from MongoDB change streams
+	EnableChangeFeed bool
+
 	// ReadOnly toggles whether the server is in read-only mode. In read-only
 	// mode any HTTP verb other than GET, HEAD or OPTIONS is rejected.
 	ReadOnly bool
@@ -96,32 +352,85 @@ type Config struct {
 	FailedRequestsDir string
 }
 
+// The ways that deletes of resources that other resources refer to are handled (see Config.ReferencedDeletes)
+const (
+	ReferencedDeletesAllow   = "allow"
+	ReferencedDeletesWarn    = "warn"
+	ReferencedDeletesBlock   = "block"
+	ReferencedDeletesNullify = "nullify"
+	ReferencedDeletesCascade = "cascade"
+)
+
 // DefaultConfig is the default server configuration
 var DefaultConfig = Config{
 	ServerURL:                    "",
 	IndexConfigPath:              "config/indexes.conf",
+	SearchIndexConfigPath:        "config/search_indexes.conf",
 	DatabaseURI:                  "mongodb://localhost:27017/?replicaSet=rs0",
 	DatabaseSuffix:               "_fhir",
+	TenantHeader:                 "X-Tenant-ID",
 	DatabaseSocketTimeout:        2 * time.Minute,
 	DatabaseOpTimeout:            90 * time.Second,
 	DatabaseKillOpPeriod:         10 * time.Second,
 	Auth:                         auth.None(),
 	EnableCISearches:             true,
 	TokenParametersCaseSensitive: false,
+	MaxChainDepth:                search.DefaultMaxChainDepth,
+	MaxIncludeDepth:              search.DefaultMaxIncludeDepth,
+	DefaultCount:                 search.DefaultCount,
 	EnableHistory:                true,
+	ConditionalDeleteMultiple:    true,
+	ReferencedDeletes:            ReferencedDeletesAllow,
 	BatchConcurrency:             1,
 	EnableXML:                    true,
 	CountTotalResults:            true,
+	CountCacheTTL:                10 * time.Minute,
+	CountCacheMaxEntries:         10000,
+	ResultSetMaxResults:          search.DefaultResultSetMaxResults,
+	BulkExportLifetime:           24 * time.Hour,
+	AsyncWorkers:                 2,
+	AsyncJobLifetime:             24 * time.Hour,
+	SubscriptionRetries:          3,
+	SubscriptionRetryDelay:       time.Second,
+	SubscriptionEventLifetime:    24 * time.Hour,
+	EventTopic:                   "fhir.resources",
 	ReadOnly:                     false,
 	Debug:                        false,
 }
 
+// checksReferences returns whether the references between resources are checked (see EnforceReferences and
+// ReferencedDeletes)
+func (config *Config) checksReferences() bool {
+	if config.EnforceReferences || (config.ReferencedDeletes != "" && config.ReferencedDeletes != ReferencedDeletesAllow) {
+		return true
+	}
+	for _, policy := range config.ReferencedDeletesByType {
+		if policy != ReferencedDeletesAllow {
+			return true
+		}
+	}
+	return false
+}
+
+// referencedDeletesOf returns what happens when resources of a type that other resources refer to are deleted (see
+// ReferencedDeletes and ReferencedDeletesByType)
+func (config *Config) referencedDeletesOf(resourceType string) string {
+	if policy, found := config.ReferencedDeletesByType[resourceType]; found {
+		return policy
+	}
+	return config.ReferencedDeletes
+}
+
+// responseURL returns the URL of a path of the server that a request was sent to
 func (config *Config) responseURL(r *http.Request, paths ...string) *url.URL {
 
 	dbPrefix := r.Header.Get("db")
 	if dbPrefix != "" {
 		dbPrefix = "/db/" + dbPrefix
 	}
+	if tenant := r.Header.Get(config.TenantHeader); config.EnableTenancy && tenant != "" {
+		dbPrefix = "/tenants/" + tenant
+	}
 
 	if config.ServerURL != "" {
 		theURL := fmt.Sprintf("%s%s/%s", strings.TrimSuffix(config.ServerURL, "/"), dbPrefix, strings.Join(paths, "/"))
@@ -134,13 +443,34 @@ func (config *Config) responseURL(r *http.Request, paths ...string) *url.URL {
 
 	responseURL := url.URL{}
 
-	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+	if r.TLS != nil || strings.EqualFold(forwardedHeader(r, "X-Forwarded-Proto"), "https") {
 		responseURL.Scheme = "https"
 	} else {
 		responseURL.Scheme = "http"
 	}
 	responseURL.Host = r.Host
+	if forwardedHost := forwardedHeader(r, "X-Forwarded-Host"); forwardedHost != "" {
+		responseURL.Host = forwardedHost
+	}
 	responseURL.Path = fmt.Sprintf("%s/%s", dbPrefix, strings.Join(paths, "/"))
 
 	return &responseURL
 }
+
+// forwardedHeader returns the value of an X-Forwarded-* header set by the proxy closest to the client (the first
+// of the values added by a chain of proxies)
+func forwardedHeader(r *http.Request, name string) string {
+	value := strings.SplitN(r.Header.Get(name), ",", 2)[0]
+	return strings.TrimSpace(value)
+}
+
+// collation returns the MongoDB collation of the configured ICU locale, or nil if there isn't one
+func (config *Config) collation() *options.Collation {
+	if config.Collation == "" {
+		return nil
+	}
+	return &options.Collation{
+		Locale:   config.Collation,
+		Strength: config.CollationStrength,
+	}
+}
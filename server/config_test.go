@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+func (s *ServerSuite) TestResponseURL(c *C) {
+	responseURL := func(config Config, headers map[string]string, paths ...string) string {
+		r := httptest.NewRequest("GET", "http://fhir:3001/Patient", nil)
+		for header, value := range headers {
+			r.Header.Set(header, value)
+		}
+		return config.responseURL(r, paths...).String()
+	}
+
+	// Derived from the request
+	config := DefaultConfig
+	c.Assert(responseURL(config, nil, "Patient", "123"), Equals, "http://fhir:3001/Patient/123")
+	c.Assert(responseURL(config, nil), Equals, "http://fhir:3001/")
+	c.Assert(responseURL(config, map[string]string{"Db": "test_fhir"}, "Patient"), Equals, "http://fhir:3001/db/test_fhir/Patient")
+
+	// Behind proxies
+	c.Assert(responseURL(config, map[string]string{"X-Forwarded-Proto": "https"}, "Patient"), Equals, "https://fhir:3001/Patient")
+	c.Assert(responseURL(config, map[string]string{
+		"X-Forwarded-Proto": "https, http",
+		"X-Forwarded-Host":  "fhir.example.com, proxy:8080",
+	}, "Patient"), Equals, "https://fhir.example.com/Patient")
+
+	// Configured
+	config.ServerURL = "https://example.com/fhir/"
+	c.Assert(responseURL(config, map[string]string{"X-Forwarded-Host": "proxy:8080"}, "Patient", "123"), Equals, "https://example.com/fhir/Patient/123")
+	c.Assert(responseURL(config, map[string]string{"Db": "test_fhir"}, "Patient"), Equals, "https://example.com/fhir/db/test_fhir/Patient")
+
+	// Used for Location headers
+	req, err := http.NewRequest("POST", s.Server.URL+"/Patient", strings.NewReader(`{"resourceType": "Patient"}`))
+	util.CheckErr(err)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("X-Forwarded-Proto", "https")
+	req.Header.Add("X-Forwarded-Host", "fhir.example.com")
+	res, err := http.DefaultClient.Do(req)
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, 201)
+	c.Assert(res.Header.Get("Location"), Matches, "https://fhir\\.example\\.com/Patient/[0-9a-f]{24}/_history/1")
+}
@@ -0,0 +1,550 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/eug48/fhir/models"
+	"github.com/eug48/fhir/models2"
+	"github.com/eug48/fhir/search"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// Consent enforcement (see Config.EnforceConsents): the resources in the compartments of patients whose active Consent
+// resources deny access to them are filtered from search results and histories, and reads of them are forbidden.
+// Consents whose policyRule is an opt-out deny access to everything that their actor, purpose, securityLabel and
+// data elements match, except what their permit exceptions do, while other consents deny access to what their deny
+// exceptions match.  Besides those elements, exceptions can match resource types (class), the codes of resources'
+// categories (code) and periods.  The actor of a request is its authenticated fhirUser (e.g. Practitioner/123) and
+// its purposes of use are those that the auth middleware authenticated (e.g. the purpose_of_use claim of a SMART
+// token, such as http://hl7.org/fhir/v3/ActReason|HMARKT).  Every denial is recorded in an AuditEvent.  The ids and
+// references found by searches, and the resources that conditional creates match, are filtered too, but searches
+// can't be explained.  Consents themselves are never filtered, and writes aren't restricted.
+
+// The limit on the number of Consents of a patient that are enforced
+const maxConsentsPerPatient = 100
+
+type consentContextKey struct{}
+
+// consentRequest is who is accessing resources, and why
+type consentRequest struct {
+	actor    string
+	purposes []models.Coding
+}
+
+// consentContext is middleware that adds the actor and purposes of use of a request (which the auth middleware
+// authenticated) to its context, so that its sessions enforce Consents
+func consentContext(c *gin.Context) {
+	request := consentRequest{actor: c.GetString("fhirUser")}
+	for _, purpose := range c.GetStringSlice("purposeOfUse") {
+		request.purposes = append(request.purposes, parseCoding(purpose))
+	}
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), consentContextKey{}, request))
+}
+
+// parseCoding parses a token such as http://loinc.org|1234-5 or 1234-5
+func parseCoding(token string) models.Coding {
+	if i := strings.LastIndex(token, "|"); i >= 0 {
+		return models.Coding{System: token[:i], Code: token[i+1:]}
+	}
+	return models.Coding{Code: token}
+}
+
+// consentDataAccessLayer starts consentSessions for the requests that consentContext added a consentRequest to
+type consentDataAccessLayer struct {
+	DataAccessLayer
+	// the observer of the AuditEvents of denials
+	source string
+}
+
+func (dal consentDataAccessLayer) StartSession(ctx context.Context, dbname string) DataAccessSession {
+	session := dal.DataAccessLayer.StartSession(ctx, dbname)
+	if request, ok := ctx.Value(consentContextKey{}).(consentRequest); ok {
+		return &consentSession{
+			DataAccessSession: session,
+			dal:               dal,
+			dbname:            dbname,
+			request:           request,
+			consents:          make(map[string][]consentRule),
+		}
+	}
+	return session
+}
+
+// consentSession is a session that enforces the Consents of patients
+type consentSession struct {
+	DataAccessSession
+	dal     consentDataAccessLayer
+	dbname  string
+	request consentRequest
+	// the rules of the Consents of patients (e.g. Patient/123), which are loaded when they're first needed
+	consents map[string][]consentRule
+}
+
+// consentRule is a Consent's denial, or an exception that permits or denies access.  The elements that are empty
+// match everything.
+type consentRule struct {
+	consent        string
+	deny           bool
+	exceptions     []consentRule
+	period         *models.Period
+	actors         []string
+	purposes       []models.Coding
+	classes        []models.Coding
+	codes          []models.Coding
+	securityLabels []models.Coding
+	data           []string
+}
+
+// consentedResource is what consentRules are matched against
+type consentedResource struct {
+	reference      string
+	resourceType   string
+	codes          []models.Coding
+	securityLabels []models.Coding
+}
+
+func newConsentedResource(resource *models2.Resource) (*consentedResource, error) {
+	var elements struct {
+		Meta struct {
+			Security []models.Coding `json:"security"`
+		} `json:"meta"`
+		Category json.RawMessage `json:"category"`
+	}
+	if err := resource.Unmarshal(&elements); err != nil {
+		return nil, errors.Wrap(err, "newConsentedResource: Unmarshal failed")
+	}
+	consented := &consentedResource{
+		reference:      resource.ResourceType() + "/" + resource.Id(),
+		resourceType:   resource.ResourceType(),
+		securityLabels: elements.Meta.Security,
+	}
+	// a category is a CodeableConcept or an array of them, depending on the resource type
+	var categories []models.CodeableConcept
+	var category models.CodeableConcept
+	if json.Unmarshal(elements.Category, &categories) != nil && json.Unmarshal(elements.Category, &category) == nil {
+		categories = append(categories, category)
+	}
+	for _, concept := range categories {
+		consented.codes = append(consented.codes, concept.Coding...)
+	}
+	return consented, nil
+}
+
+func newConsentRule(consent *models.Consent) consentRule {
+	rule := consentRule{
+		consent:        "Consent/" + consent.Id,
+		deny:           strings.HasSuffix(consent.PolicyRule, "opt-out") || strings.HasSuffix(consent.PolicyRule, "deny"),
+		period:         consent.Period,
+		purposes:       consent.Purpose,
+		securityLabels: consent.SecurityLabel,
+	}
+	for _, actor := range consent.Actor {
+		if actor.Reference != nil {
+			rule.actors = append(rule.actors, actor.Reference.Reference)
+		}
+	}
+	for _, data := range consent.Data {
+		if data.Reference != nil {
+			rule.data = append(rule.data, data.Reference.Reference)
+		}
+	}
+	for _, except := range consent.Except {
+		exception := consentRule{
+			consent:        rule.consent,
+			deny:           except.Type == "deny",
+			period:         except.Period,
+			purposes:       except.Purpose,
+			classes:        except.Class,
+			codes:          except.Code,
+			securityLabels: except.SecurityLabel,
+		}
+		for _, actor := range except.Actor {
+			if actor.Reference != nil {
+				exception.actors = append(exception.actors, actor.Reference.Reference)
+			}
+		}
+		for _, data := range except.Data {
+			if data.Reference != nil {
+				exception.data = append(exception.data, data.Reference.Reference)
+			}
+		}
+		rule.exceptions = append(rule.exceptions, exception)
+	}
+	return rule
+}
+
+// denies returns whether a Consent's rule denies a request access to a resource
+func (rule *consentRule) denies(request consentRequest, resource *consentedResource, now time.Time) bool {
+	if !periodIncludes(rule.period, now) {
+		return false
+	}
+	if rule.deny {
+		if !rule.matches(request, resource) {
+			return false
+		}
+		for _, exception := range rule.exceptions {
+			if !exception.deny && periodIncludes(exception.period, now) && exception.matches(request, resource) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, exception := range rule.exceptions {
+		if exception.deny && periodIncludes(exception.period, now) && exception.matches(request, resource) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rule *consentRule) matches(request consentRequest, resource *consentedResource) bool {
+	if len(rule.actors) > 0 && !referencesInclude(rule.actors, request.actor) {
+		return false
+	}
+	if len(rule.data) > 0 && !referencesInclude(rule.data, resource.reference) {
+		return false
+	}
+	if len(rule.classes) > 0 && !codingsIntersect(rule.classes, []models.Coding{{Code: resource.resourceType}}) {
+		return false
+	}
+	return (len(rule.purposes) == 0 || codingsIntersect(rule.purposes, request.purposes)) &&
+		(len(rule.codes) == 0 || codingsIntersect(rule.codes, resource.codes)) &&
+		(len(rule.securityLabels) == 0 || codingsIntersect(rule.securityLabels, resource.securityLabels))
+}
+
+func periodIncludes(period *models.Period, now time.Time) bool {
+	return period == nil ||
+		((period.Start == nil || !now.Before(period.Start.Time)) && (period.End == nil || !now.After(period.End.Time)))
+}
+
+// referencesInclude returns whether references include a relative reference (e.g. Practitioner/123), including as
+// the end of absolute references
+func referencesInclude(references []string, reference string) bool {
+	if reference == "" {
+		return false
+	}
+	for _, r := range references {
+		if r == reference || strings.HasSuffix(r, "/"+reference) {
+			return true
+		}
+	}
+	return false
+}
+
+// codingsIntersect returns whether any of two lists of codings match, ignoring the systems of codings without them
+func codingsIntersect(codings, others []models.Coding) bool {
+	for _, coding := range codings {
+		for _, other := range others {
+			if coding.Code == other.Code && (coding.System == "" || other.System == "" || coding.System == other.System) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// patientConsents returns the rules of the active Consents of a patient
+func (s *consentSession) patientConsents(patient string) ([]consentRule, error) {
+	if rules, loaded := s.consents[patient]; loaded {
+		return rules, nil
+	}
+	query := url.Values{"patient": {patient}, "status": {"active"}, "_count": {fmt.Sprint(maxConsentsPerPatient)}}
+	bundle, err := s.DataAccessSession.Search(url.URL{}, search.Query{Resource: "Consent", Query: query.Encode()})
+	if err != nil {
+		return nil, errors.Wrap(err, "patientConsents: Search failed")
+	}
+	rules := []consentRule{}
+	for _, entry := range bundle.Entry {
+		var consent models.Consent
+		if err := entry.Resource.Unmarshal(&consent); err != nil {
+			return nil, errors.Wrap(err, "patientConsents: Unmarshal failed")
+		}
+		rules = append(rules, newConsentRule(&consent))
+	}
+	s.consents[patient] = rules
+	return rules, nil
+}
+
+// denyingConsent returns the Consent that denies access to a resource, if any
+func (s *consentSession) denyingConsent(resource *models2.Resource) (string, error) {
+	if resource.ResourceType() == "Consent" {
+		return "", nil
+	}
+	compartments, err := search.ResourceCompartments(resource)
+	if err != nil {
+		return "", errors.Wrap(err, "denyingConsent: ResourceCompartments failed")
+	}
+	var consented *consentedResource
+	now := time.Now()
+	for _, compartment := range compartments {
+		if !strings.HasPrefix(compartment, "Patient/") {
+			continue
+		}
+		rules, err := s.patientConsents(compartment)
+		if err != nil {
+			return "", err
+		}
+		for _, rule := range rules {
+			if consented == nil {
+				if consented, err = newConsentedResource(resource); err != nil {
+					return "", err
+				}
+			}
+			if rule.denies(s.request, consented, now) {
+				return rule.consent, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// filterEntries removes the entries of a bundle whose resources Consents deny access to, auditing the denials
+func (s *consentSession) filterEntries(bundle *models2.ShallowBundle, interaction, query string) error {
+	entries := bundle.Entry[:0]
+	denials := make(map[string]string)
+	for _, entry := range bundle.Entry {
+		if entry.Resource != nil {
+			consent, err := s.denyingConsent(entry.Resource)
+			if err != nil {
+				return err
+			} else if consent != "" {
+				denials[entry.Resource.ResourceType()+"/"+entry.Resource.Id()] = consent
+				continue
+			}
+		}
+		entries = append(entries, entry)
+	}
+	bundle.Entry = entries
+	if len(denials) > 0 {
+		s.audit(interaction, query, denials)
+	}
+	return nil
+}
+
+// checkRead returns an ErrForbidden error if Consents deny access to a resource, auditing the denial
+func (s *consentSession) checkRead(resource *models2.Resource, interaction string) error {
+	consent, err := s.denyingConsent(resource)
+	if err != nil {
+		return err
+	} else if consent == "" {
+		return nil
+	}
+	reference := resource.ResourceType() + "/" + resource.Id()
+	s.audit(interaction, "", map[string]string{reference: consent})
+	return ErrForbidden{fmt.Sprintf("Access to %s is denied by %s", reference, consent)}
+}
+
+// audit records the denials of an interaction (of resources by Consents) in an AuditEvent, in a session of its own so
+// that it's kept even if the request fails
+func (s *consentSession) audit(interaction, query string, denials map[string]string) {
+	requestor := true
+	agent := models.AuditEventAgentComponent{Requestor: &requestor}
+	if s.request.actor != "" {
+		agent.Reference = &models.Reference{Reference: s.request.actor}
+	}
+	for _, purpose := range s.request.purposes {
+		agent.PurposeOfUse = append(agent.PurposeOfUse, models.CodeableConcept{Coding: []models.Coding{purpose}})
+	}
+	event := models.AuditEvent{
+		Type:        &models.Coding{System: "http://hl7.org/fhir/audit-event-type", Code: "rest", Display: "RESTful Operation"},
+		Subtype:     []models.Coding{{System: "http://hl7.org/fhir/restful-interaction", Code: interaction}},
+		Action:      "R",
+		Recorded:    &models.FHIRDateTime{Time: time.Now(), Precision: models.Timestamp},
+		Outcome:     "4",
+		OutcomeDesc: "Denied by consent",
+		Agent:       []models.AuditEventAgentComponent{agent},
+		Source:      &models.AuditEventSourceComponent{Identifier: &models.Identifier{Value: s.dal.source}},
+	}
+	if query != "" {
+		event.Entity = append(event.Entity, models.AuditEventEntityComponent{Query: query})
+	}
+	for reference, consent := range denials {
+		event.Entity = append(event.Entity, models.AuditEventEntityComponent{
+			Reference:   &models.Reference{Reference: reference},
+			Description: "Denied by " + consent,
+		})
+	}
+
+	err := func() error {
+		eventJSON, err := json.Marshal(&event)
+		if err != nil {
+			return err
+		}
+		resource, err := models2.NewResourceFromJsonBytes(eventJSON)
+		if err != nil {
+			return err
+		}
+		session := s.dal.DataAccessLayer.StartSession(context.Background(), s.dbname)
+		defer session.Finish()
+		_, err = session.Post(resource)
+		return err
+	}()
+	if err != nil {
+		log.Printf("Consent: failed to audit the denial of %v: %+v\n", denials, err)
+	}
+}
+
+func (s *consentSession) Get(id, resourceType string) (*models2.Resource, error) {
+	resource, err := s.DataAccessSession.Get(id, resourceType)
+	if err != nil {
+		return resource, err
+	}
+	if err := s.checkRead(resource, "read"); err != nil {
+		return nil, err
+	}
+	return resource, nil
+}
+
+func (s *consentSession) GetVersion(id, versionId, resourceType string) (*models2.Resource, error) {
+	resource, err := s.DataAccessSession.GetVersion(id, versionId, resourceType)
+	if err != nil {
+		return resource, err
+	}
+	if err := s.checkRead(resource, "vread"); err != nil {
+		return nil, err
+	}
+	return resource, nil
+}
+
+func (s *consentSession) Search(baseURL url.URL, searchQuery search.Query) (*models2.ShallowBundle, error) {
+	bundle, err := s.DataAccessSession.Search(baseURL, searchQuery)
+	if err != nil {
+		return bundle, err
+	}
+	interaction := "search-type"
+	if searchQuery.Resource == "" {
+		interaction = "search-system"
+	}
+	return bundle, s.filterEntries(bundle, interaction, searchQuery.Resource+"?"+searchQuery.Query)
+}
+
+// ConditionalPost returns an ErrForbidden error instead of an existing resource that the query matched if Consents
+// deny access to it
+func (s *consentSession) ConditionalPost(query search.Query, resource *models2.Resource) (int, string, *models2.Resource, error) {
+	httpStatus, id, outputResource, err := s.DataAccessSession.ConditionalPost(query, resource)
+	if err != nil || httpStatus == http.StatusCreated || outputResource == nil {
+		return httpStatus, id, outputResource, err
+	}
+	if err := s.checkRead(outputResource, "create"); err != nil {
+		return 0, "", nil, err
+	}
+	return httpStatus, id, outputResource, nil
+}
+
+func (s *consentSession) FindIDs(searchQuery search.Query) ([]string, error) {
+	ids, err := s.DataAccessSession.FindIDs(searchQuery)
+	if err != nil || searchQuery.Resource == "" {
+		return ids, err
+	}
+	references := make([]string, len(ids))
+	for i, id := range ids {
+		references[i] = searchQuery.Resource + "/" + id
+	}
+	allowed, err := s.filterReferences(references, "search-type", searchQuery.Resource+"?"+searchQuery.Query)
+	if err != nil {
+		return nil, err
+	}
+	for i, reference := range allowed {
+		allowed[i] = strings.TrimPrefix(reference, searchQuery.Resource+"/")
+	}
+	return allowed, nil
+}
+
+func (s *consentSession) Explain(searchQuery search.Query) (*search.Explanation, error) {
+	return nil, ErrForbidden{"Searches can't be explained with Consents, as the explanations count all resources"}
+}
+
+func (s *consentSession) ReferencesTo(resourceType string, ids []string, max int) ([]ResourceReference, error) {
+	references, err := s.DataAccessSession.ReferencesTo(resourceType, ids, max)
+	if err != nil {
+		return nil, err
+	}
+	return s.filterResourceReferences(references)
+}
+
+func (s *consentSession) DanglingReferences(resourceTypes []string, max int) ([]ResourceReference, error) {
+	references, err := s.DataAccessSession.DanglingReferences(resourceTypes, max)
+	if err != nil {
+		return nil, err
+	}
+	return s.filterResourceReferences(references)
+}
+
+// filterResourceReferences removes the references of resources that Consents deny access to
+func (s *consentSession) filterResourceReferences(references []ResourceReference) ([]ResourceReference, error) {
+	sources := make([]string, len(references))
+	for i, reference := range references {
+		sources[i] = reference.Source
+	}
+	allowed, err := s.filterReferences(sources, "search-system", "")
+	if err != nil {
+		return nil, err
+	}
+	isAllowed := make(map[string]bool)
+	for _, source := range allowed {
+		isAllowed[source] = true
+	}
+	filtered := references[:0]
+	for _, reference := range references {
+		if isAllowed[reference.Source] {
+			filtered = append(filtered, reference)
+		}
+	}
+	return filtered, nil
+}
+
+// filterReferences removes the references (e.g. Observation/123) of resources that Consents deny access to, auditing
+// the denials.  The references of resources that don't exist are kept.
+func (s *consentSession) filterReferences(references []string, interaction, query string) ([]string, error) {
+	var allowed []string
+	denials := make(map[string]string)
+	for _, reference := range references {
+		parts := strings.SplitN(reference, "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		resource, err := s.DataAccessSession.Get(parts[1], parts[0])
+		if err == ErrNotFound || err == ErrDeleted {
+			allowed = append(allowed, reference)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		consent, err := s.denyingConsent(resource)
+		if err != nil {
+			return nil, err
+		} else if consent != "" {
+			denials[reference] = consent
+			continue
+		}
+		allowed = append(allowed, reference)
+	}
+	if len(denials) > 0 {
+		s.audit(interaction, query, denials)
+	}
+	return allowed, nil
+}
+
+func (s *consentSession) History(baseURL url.URL, resourceType string, id string, options HistoryOptions) (*models2.ShallowBundle, error) {
+	bundle, err := s.DataAccessSession.History(baseURL, resourceType, id, options)
+	if err != nil {
+		return bundle, err
+	}
+	interaction := "history-instance"
+	if id == "" {
+		interaction = "history-type"
+	}
+	return bundle, s.filterEntries(bundle, interaction, "")
+}
+
+func (s *consentSession) WatchChanges(resourceType string, resumeToken string) (*ChangeFeed, error) {
+	return nil, ErrForbidden{"Change feeds can't be limited by Consents"}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/eug48/fhir/models"
+	"github.com/eug48/fhir/models2"
+	"github.com/eug48/fhir/search"
+	"github.com/gin-gonic/gin"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *ServerSuite) TestConsentEnforcement(c *C) {
+	dal := consentDataAccessLayer{NewMongoDataAccessLayer(s.client, s.dbname, true, "_fhir", nil, DefaultConfig), "FHIR server"}
+	resource := func(json string) *models2.Resource {
+		resource, err := models2.NewResourceFromJsonBytes([]byte(json))
+		util.CheckErr(err)
+		return resource
+	}
+	defer s.DB().C("observations").DropCollection()
+	defer s.DB().C("consents").DropCollection()
+	defer s.DB().C("auditevents").DropCollection()
+
+	session := dal.StartSession(context.TODO(), s.dbname)
+	defer session.Finish()
+	c.Assert(session.PostWithID("consenting", resource(`{"resourceType": "Patient"}`)), IsNil)
+	c.Assert(session.PostWithID("vitals", resource(`{"resourceType": "Observation", "status": "final",
+		"category": [{"coding": [{"system": "http://hl7.org/fhir/observation-category", "code": "vital-signs"}]}],
+		"code": {"text": "weight"}, "subject": {"reference": "Patient/consenting"}}`)), IsNil)
+	c.Assert(session.PostWithID("smoking", resource(`{"resourceType": "Observation", "status": "final",
+		"category": [{"coding": [{"system": "http://hl7.org/fhir/observation-category", "code": "social-history"}]}],
+		"code": {"text": "smoking status"}, "subject": {"reference": "Patient/consenting"}}`)), IsNil)
+	c.Assert(session.PostWithID("hiv", resource(`{"resourceType": "Observation", "status": "final",
+		"meta": {"security": [{"system": "http://hl7.org/fhir/v3/ActCode", "code": "HIV"}]},
+		"code": {"text": "HIV test"}, "subject": {"reference": "Patient/consenting"}}`)), IsNil)
+
+	// Social history is denied to everyone, and HIV results for marketing
+	c.Assert(session.PostWithID("deny-social-history", resource(`{"resourceType": "Consent", "status": "active",
+		"patient": {"reference": "Patient/consenting"}, "policyRule": "http://hl7.org/fhir/ConsentPolicy/opt-in",
+		"except": [{"type": "deny", "code": [{"code": "social-history"}]}]}`)), IsNil)
+	c.Assert(session.PostWithID("deny-marketing", resource(`{"resourceType": "Consent", "status": "active",
+		"patient": {"reference": "Patient/consenting"}, "policyRule": "http://hl7.org/fhir/ConsentPolicy/opt-out",
+		"purpose": [{"system": "http://hl7.org/fhir/v3/ActReason", "code": "HMARKT"}],
+		"securityLabel": [{"code": "HIV"}]}`)), IsNil)
+
+	searchObservations := func(request consentRequest) []string {
+		session := dal.StartSession(context.WithValue(context.TODO(), consentContextKey{}, request), s.dbname)
+		defer session.Finish()
+		bundle, err := session.Search(url.URL{}, search.Query{Resource: "Observation", Query: "subject=Patient/consenting&_sort=_id"})
+		util.CheckErr(err)
+		var ids []string
+		for _, entry := range bundle.Entry {
+			ids = append(ids, entry.Resource.Id())
+		}
+		return ids
+	}
+	treatment := consentRequest{actor: "Practitioner/1", purposes: []models.Coding{{Code: "TREAT"}}}
+	marketing := consentRequest{actor: "Practitioner/1", purposes: []models.Coding{{System: "http://hl7.org/fhir/v3/ActReason", Code: "HMARKT"}}}
+	c.Assert(searchObservations(treatment), DeepEquals, []string{"hiv", "vitals"})
+	c.Assert(searchObservations(marketing), DeepEquals, []string{"vitals"})
+
+	// Reads are forbidden
+	restricted := dal.StartSession(context.WithValue(context.TODO(), consentContextKey{}, marketing), s.dbname)
+	defer restricted.Finish()
+	_, err := restricted.Get("vitals", "Observation")
+	c.Assert(err, IsNil)
+	_, err = restricted.Get("hiv", "Observation")
+	c.Assert(err, DeepEquals, ErrForbidden{"Access to Observation/hiv is denied by Consent/deny-marketing"})
+	_, err = restricted.Get("deny-marketing", "Consent")
+	c.Assert(err, IsNil)
+
+	// as are change feeds, whose changes aren't checked
+	_, err = restricted.WatchChanges("Observation", "")
+	c.Assert(err, DeepEquals, ErrForbidden{"Change feeds can't be limited by Consents"})
+
+	// The denials were audited
+	count, err := s.DB().C("auditevents").Count()
+	util.CheckErr(err)
+	c.Assert(count, Equals, 3)
+	var event models.AuditEvent
+	util.CheckErr(s.DB().C("auditevents").Find(bson.M{"subtype.code": "read"}).One(&event))
+	c.Assert(event.Agent[0].Reference.Reference, Equals, "Practitioner/1")
+	c.Assert(event.Entity, HasLen, 1)
+	c.Assert(event.Entity[0].Reference.Reference, Equals, "Observation/hiv")
+	c.Assert(event.Entity[0].Description, Equals, "Denied by Consent/deny-marketing")
+
+	// Conditional creates don't return the resources they match if they're denied
+	_, _, _, err = restricted.ConditionalPost(search.Query{Resource: "Observation", Query: "code:text=HIV"},
+		resource(`{"resourceType": "Observation", "status": "final", "code": {"text": "HIV test"}}`))
+	c.Assert(err, DeepEquals, ErrForbidden{"Access to Observation/hiv is denied by Consent/deny-marketing"})
+
+	// nor are their ids found
+	ids, err := restricted.FindIDs(search.Query{Resource: "Observation", Query: "subject=Patient/consenting&_sort=_id"})
+	util.CheckErr(err)
+	c.Assert(ids, DeepEquals, []string{"vitals"})
+}
+
+func (s *ServerSuite) TestConsentPurposesOfUse(c *C) {
+	// Purposes of use are those the auth middleware authenticated, and not the request's headers
+	purposes := func(header string, purposeOfUse []string) []models.Coding {
+		ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+		ctx.Request = httptest.NewRequest("GET", "/Observation", nil)
+		ctx.Request.Header.Set("X-Purpose-Of-Use", header)
+		if purposeOfUse != nil {
+			ctx.Set("purposeOfUse", purposeOfUse)
+		}
+		consentContext(ctx)
+		return ctx.Request.Context().Value(consentContextKey{}).(consentRequest).purposes
+	}
+	c.Assert(purposes("HMARKT", nil), HasLen, 0)
+	c.Assert(purposes("HMARKT", []string{"http://hl7.org/fhir/v3/ActReason|TREAT"}), DeepEquals,
+		[]models.Coding{{System: "http://hl7.org/fhir/v3/ActReason", Code: "TREAT"}})
+}
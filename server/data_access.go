@@ -3,10 +3,15 @@ package server
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/url"
+	"strings"
+	"time"
 
+	"github.com/eug48/fhir/auth"
 	"github.com/eug48/fhir/models2"
 	"github.com/eug48/fhir/search"
+	"github.com/eug48/fhir/utils"
 )
 
 type DataAccessLayer interface {
@@ -32,25 +37,127 @@ type DataAccessSession interface {
 	ConditionalPost(query search.Query, resource *models2.Resource) (httpStatus int, id string, outputResource *models2.Resource, err error)
 	// PostWithID creates a resource instance with the given ID.
 	PostWithID(id string, resource *models2.Resource) error
+	// InsertMany creates resources of a type with their IDs (or new ones if they have none) in a single insert, for
+	// bulk imports (see ImportNDJSON), returning the error of each resource that wasn't created (or nil if it was).
+	// Unlike Post, it doesn't check their references, as they may refer to resources that are yet to be imported.
+	InsertMany(resourceType string, resources []*models2.Resource) (errs []error, err error)
 	// Put creates or updates a resource instance with the given ID.
 	Put(id string, conditionalVersionId string, resource *models2.Resource) (createdNew bool, err error)
 	// ConditionalPut creates or updates a resource based on search criteria.  If the criteria results in zero matches,
-	// the resource is created.  If the criteria results in one match, it is updated.  Otherwise, a ErrMultipleMatches
+	// the resource is created.  If the criteria results in one match, it is updated (and the resource's id, if any,
+	// must be that of the match, or else an ErrIDMismatch error is returned).  Otherwise, a ErrMultipleMatches
 	// error is returned.
 	ConditionalPut(query search.Query, conditionalVersionId string, resource *models2.Resource) (id string, createdNew bool, err error)
-	// Delete removes the resource instance with the given ID.  This operation cannot be undone.
-	Delete(id, resourceType string) (newVersionId string, err error)
-	// ConditionalDelete removes zero or more resources matching the passed in search criteria.  This operation cannot
-	// be undone.
-	ConditionalDelete(query search.Query) (count int64, err error)
+	// Delete removes the resource instance with the given ID, returning the changes made to the resources referring
+	// to it (see Config.ReferencedDeletes).  This operation cannot be undone.
+	Delete(id, resourceType string) (newVersionId string, changes []ReferenceChange, err error)
+	// ConditionalDelete removes zero or more resources matching the passed in search criteria, returning how many
+	// it removed and the changes made to the resources referring to them.  Unless several may be removed (see
+	// Config.ConditionalDeleteMultiple), an ErrMultipleMatches error is returned if more than one matches.  This
+	// operation cannot be undone.
+	ConditionalDelete(query search.Query) (count int64, changes []ReferenceChange, err error)
 	// Search executes a search given the baseURL and searchQuery.
 	Search(baseURL url.URL, searchQuery search.Query) (bundle *models2.ShallowBundle, err error)
 	// FindIDs executes a search given the searchQuery and returns only the matching IDs.  This function ignores
 	// search options that don't make sense in this context: _include, _revinclude, _summary, _elements, _contained,
 	// and _containedType.  It honors search options such as _count, _sort, and _offset.
 	FindIDs(searchQuery search.Query) (result []string, err error)
-	// History executes the history operation (partial support)
-	History(baseURL url.URL, resoureType string, id string) (bundle *models2.ShallowBundle, err error)
+	// Explain explains how a search is run (the generated query and MongoDB's explain() output)
+	Explain(searchQuery search.Query) (explanation *search.Explanation, err error)
+	// History returns a page of the versions (including deletions) of a resource instance, or else of all the
+	// resources of a type (if id is empty) or of all types (if resourceType is also empty), newest first.  The
+	// baseURL is that of the server.
+	History(baseURL url.URL, resourceType string, id string, options HistoryOptions) (bundle *models2.ShallowBundle, err error)
+	// ReferencesTo returns up to max of the references of other resources to the resources of a type with the given
+	// IDs, found by searching with the reference search parameters that may refer to them
+	ReferencesTo(resourceType string, ids []string, max int) (references []ResourceReference, err error)
+	// DanglingReferences returns up to max of the references of the resources of the given types (or of every type)
+	// to resources on the server that don't exist
+	DanglingReferences(resourceTypes []string, max int) (references []ResourceReference, err error)
+
+	// EnqueueAsyncJob queues a request to be run asynchronously (see AsyncRequestsMiddleware)
+	EnqueueAsyncJob(job *AsyncJob) error
+	// ClaimAsyncJob returns the next queued job (or nil if there's none), marking it as running until its lease
+	// expires, when it's queued again unless its lease has been extended
+	ClaimAsyncJob(lease time.Duration) (job *AsyncJob, err error)
+	// ExtendAsyncJobLease extends the lease of a running job, returning ErrNotFound if it's been deleted
+	ExtendAsyncJobLease(id string, lease time.Duration) error
+	// GetAsyncJob returns a job, or ErrNotFound if there's no such job
+	GetAsyncJob(id string) (job *AsyncJob, err error)
+	// FinishAsyncJob stores a completed or failed job, returning ErrNotFound if it's been deleted
+	FinishAsyncJob(job *AsyncJob) error
+	// DeleteAsyncJob deletes a job of an owner (see AsyncJob.Owner), returning ErrNotFound if there's no such job
+	DeleteAsyncJob(id string, owner string) error
+	// SubscriptionEventCount returns the number of events of a topic-based Subscription since it started
+	SubscriptionEventCount(subscription string) (count int64, err error)
+	// SubscriptionEvents returns the unexpired events of a topic-based Subscription numbered from since to until
+	SubscriptionEvents(subscription string, since int64, until int64) (events []SubscriptionEvent, err error)
+	// WatchChanges returns a feed of the changes to the resources of a type, starting after the change with a resume
+	// token (or now if it's empty), returning ErrInvalidResumeToken if the token is invalid
+	WatchChanges(resourceType string, resumeToken string) (feed *ChangeFeed, err error)
+	// Tenants returns every tenant (see Config.EnableTenancy), by id
+	Tenants() (tenants []Tenant, err error)
+	// GetTenant returns a tenant, or ErrNotFound if there's no such tenant
+	GetTenant(id string) (tenant *Tenant, err error)
+	// ProvisionTenant stores a tenant and creates its database, returning ErrConflict if there's already a tenant
+	// with its id
+	ProvisionTenant(tenant *Tenant) error
+	// DeprovisionTenant deletes a tenant and drops its database, returning ErrNotFound if there's no such tenant
+	DeprovisionTenant(id string) error
+	// APIKeys returns every API key (see auth.AuthTypeAPIKey), by name
+	APIKeys() (apiKeys []auth.APIKey, err error)
+	// GetAPIKeyByHash returns the API key with a hash (see auth.HashAPIKey), or ErrNotFound if there's no such key
+	GetAPIKeyByHash(hash string) (apiKey *auth.APIKey, err error)
+	// CreateAPIKey stores an API key
+	CreateAPIKey(apiKey *auth.APIKey) error
+	// RevokeAPIKey deletes an API key, returning ErrNotFound if there's no such key
+	RevokeAPIKey(id string) error
+	// CheckDatabase checks the database server for the readiness of the server (see ReadinessHandler)
+	CheckDatabase() (status *DatabaseStatus, err error)
+}
+
+// HistoryOptions are the parameters of a history request (see ParseHistoryOptions)
+type HistoryOptions struct {
+	// Count is the number of versions per page (_count), or 0 for the default
+	Count int
+	// Offset is the number of (newer) versions on the previous pages (_offset)
+	Offset int
+	// Since restricts the versions to those stored at or after it (_since), unless it's zero
+	Since time.Time
+	// At restricts the versions to those that were current during it (_at), unless it's nil
+	At *utils.Date
+
+	// query is the request's query, for the paging links
+	query url.Values
+}
+
+// ResourceReference is a reference of one resource to another, e.g. of Observation/456 to Patient/123
+type ResourceReference struct {
+	// Source is the referring resource, e.g. Observation/456
+	Source string
+	// Target is the referenced resource, e.g. Patient/123
+	Target string
+	// Element is where the reference is in the referring resource: its FHIRPath (e.g. Observation.subject), or else
+	// the search parameter that found it (e.g. Observation:subject)
+	Element string
+}
+
+func (r ResourceReference) String() string {
+	return fmt.Sprintf("%s refers to %s (%s)", r.Source, r.Target, r.Element)
+}
+
+// ReferenceChange is a change made to a resource that referred to a deleted resource (see Config.ReferencedDeletes)
+type ReferenceChange struct {
+	ResourceReference
+	// Deleted is whether the referring resource was deleted, or else its references were removed
+	Deleted bool
+}
+
+func (c ReferenceChange) String() string {
+	if c.Deleted {
+		return fmt.Sprintf("Deleted %s, which referred to %s (%s)", c.Source, c.Target, c.Element)
+	}
+	return fmt.Sprintf("Removed the references of %s to %s (%s)", c.Source, c.Target, c.Element)
 }
 
 // ErrNotFound indicates that the resource was not found (HTTP 404)
@@ -59,7 +166,11 @@ var ErrNotFound = errors.New("Resource Not Found")
 // ErrDeleted indicates that the resource has been deleted (HTTP 410)
 var ErrDeleted = errors.New("Resource deleted")
 
-// ErrMultipleMatches indicates that the conditional update query returned multiple matches
+// ErrInvalidResumeToken indicates that a ChangeFeed can't be started after a change, as its resume token is invalid
+// (HTTP 400)
+var ErrInvalidResumeToken = errors.New("Invalid resume token")
+
+// ErrMultipleMatches indicates that the conditional update or delete query returned multiple matches
 type ErrMultipleMatches struct {
 	msg string
 }
@@ -68,9 +179,29 @@ func (e ErrMultipleMatches) Error() string {
 	return e.msg
 }
 
+// ErrIDMismatch indicates that the id of a resource given to a conditional update isn't that of the resource
+// its query matched (HTTP 400)
+type ErrIDMismatch struct {
+	msg string
+}
+
+func (e ErrIDMismatch) Error() string {
+	return e.msg
+}
+
 // ErrOpInterrupted indicates that the query was interrupted by a killOp() operation
 var ErrOpInterrupted = errors.New("Operation Interrupted")
 
+// ErrVersionMismatch indicates that an update's If-Match header doesn't match the current version of the resource,
+// or that one is required and wasn't given (HTTP 412)
+type ErrVersionMismatch struct {
+	msg string
+}
+
+func (e ErrVersionMismatch) Error() string {
+	return e.msg
+}
+
 type ErrConflict struct {
 	msg string
 }
@@ -78,3 +209,41 @@ type ErrConflict struct {
 func (e ErrConflict) Error() string {
 	return e.msg
 }
+
+// ErrDanglingReferences indicates that a resource refers to resources that don't exist (HTTP 422, see
+// Config.EnforceReferences)
+type ErrDanglingReferences struct {
+	References []ResourceReference
+}
+
+func (e ErrDanglingReferences) Error() string {
+	return "references to resources that don't exist: " + joinReferences(e.References)
+}
+
+// ErrReferenced indicates that resources can't be deleted as other resources refer to them (HTTP 409, see
+// Config.ReferencedDeletes)
+type ErrReferenced struct {
+	References []ResourceReference
+}
+
+func (e ErrReferenced) Error() string {
+	return "other resources refer to the resources being deleted: " + joinReferences(e.References)
+}
+
+// ErrForbidden indicates that a request isn't authorized to access or change a resource (HTTP 403, see
+// compartmentSession)
+type ErrForbidden struct {
+	msg string
+}
+
+func (e ErrForbidden) Error() string {
+	return e.msg
+}
+
+func joinReferences(references []ResourceReference) string {
+	strs := make([]string, 0, len(references))
+	for _, reference := range references {
+		strs = append(strs, reference.String())
+	}
+	return strings.Join(strs, "; ")
+}
@@ -20,13 +20,65 @@ func ErrorToOpOutcome(err interface{}) (statusCode int, outcome *models.Operatio
 	case error:
 		cause := errors.Cause(x)
 		_, isSchemaError := cause.(models2.FhirSchemaError)
+		validationError, isValidationError := cause.(models2.FhirValidationError)
 		_, isVersionConflict := cause.(ErrConflict)
+		_, isVersionMismatch := cause.(ErrVersionMismatch)
+		_, isMultipleMatches1 := cause.(ErrMultipleMatches)
+		_, isMultipleMatches2 := cause.(*ErrMultipleMatches)
+		danglingReferences, isDanglingReferences := cause.(ErrDanglingReferences)
+		referenced, isReferenced := cause.(ErrReferenced)
+		_, isInvalidGraphQL := cause.(ErrInvalidGraphQL)
+		_, isForbidden := cause.(ErrForbidden)
 		if isSchemaError {
 			outcome := models.NewOperationOutcome("fatal", "structure", cause.Error())
 			return http.StatusBadRequest, outcome
+		} else if isValidationError {
+			outcome := &models.OperationOutcome{}
+			for _, issue := range validationError.Issues {
+				outcome.Issue = append(outcome.Issue, models.OperationOutcomeIssueComponent{
+					Severity:    "error",
+					Code:        issue.Code,
+					Diagnostics: issue.Diagnostics,
+					Expression:  []string{issue.Expression},
+				})
+			}
+			return http.StatusUnprocessableEntity, outcome
+		} else if isDanglingReferences {
+			outcome := &models.OperationOutcome{}
+			for _, reference := range danglingReferences.References {
+				outcome.Issue = append(outcome.Issue, models.OperationOutcomeIssueComponent{
+					Severity:    "error",
+					Code:        "not-found",
+					Diagnostics: fmt.Sprintf("%s doesn't exist", reference.Target),
+					Expression:  []string{reference.Element},
+				})
+			}
+			return http.StatusUnprocessableEntity, outcome
+		} else if isReferenced {
+			outcome := &models.OperationOutcome{}
+			for _, reference := range referenced.References {
+				outcome.Issue = append(outcome.Issue, models.OperationOutcomeIssueComponent{
+					Severity:    "error",
+					Code:        "conflict",
+					Diagnostics: reference.String(),
+				})
+			}
+			return http.StatusConflict, outcome
 		} else if isVersionConflict {
 			outcome := models.NewOperationOutcome("error", "conflict", cause.Error())
 			return http.StatusConflict, outcome // TODO (FHIR R4): changed to 412
+		} else if isVersionMismatch {
+			outcome := models.NewOperationOutcome("error", "conflict", cause.Error())
+			return http.StatusPreconditionFailed, outcome
+		} else if isForbidden {
+			outcome := models.NewOperationOutcome("error", "forbidden", cause.Error())
+			return http.StatusForbidden, outcome
+		} else if isInvalidGraphQL {
+			outcome := models.NewOperationOutcome("fatal", "invalid", cause.Error())
+			return http.StatusBadRequest, outcome
+		} else if isMultipleMatches1 || isMultipleMatches2 {
+			outcome := models.NewOperationOutcome("error", "multiple-matches", cause.Error())
+			return http.StatusPreconditionFailed, outcome
 		} else {
 			stacktrace := string(runtime_debug.Stack())
 			glog.Errorf("ErrorToOpOutcome: %+v\n%s", x, stacktrace)
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/eug48/fhir/models"
+	"github.com/eug48/fhir/search"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// ExplainHandler handles requests to explain how a search is run, e.g.
+// GET /$explain?resource=Patient&query=name%3Dalex%26_sort%3Dbirthdate
+// It responds with the query or pipeline generated for the search and MongoDB's
+// explain() output (see search.Explanation), so operators can diagnose slow searches.
+func ExplainHandler(dal DataAccessLayer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer handlePanics(c)
+
+		resource := c.Query("resource")
+		if models.StructForResourceName(resource) == nil {
+			outcome := models.NewOperationOutcome("fatal", "value", fmt.Sprintf("unknown resource: %q", resource))
+			c.Render(http.StatusBadRequest, CustomFhirRenderer{outcome, c})
+			return
+		}
+
+		session := dal.StartSession(c.Request.Context(), c.GetHeader("Db"))
+		defer session.Finish()
+
+		explanation, err := session.Explain(search.Query{Resource: resource, Query: c.Query("query")})
+		if err != nil {
+			panic(errors.Wrap(err, "Explain failed"))
+		}
+		if c.Query("_pretty") == "true" {
+			c.IndentedJSON(http.StatusOK, explanation)
+		} else {
+			c.JSON(http.StatusOK, explanation)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ExportDestination is where the NDJSON files of bulk exports are stored (see Config.BulkExportDestination)
+type ExportDestination interface {
+	// Store stores a file of an export job (e.g. Patient.ndjson), copying it from a local file
+	Store(jobID, name string, localPath string) error
+	// URL returns where clients download a file of an export job, given the server's base URL, and whether they
+	// need the access token they use with the server to do so
+	URL(baseURL string, jobID, name string) (fileURL string, requiresAccessToken bool, err error)
+	// Delete deletes the files of an export job
+	Delete(jobID string, names []string) error
+}
+
+// NewExportDestination returns the destination of the files of bulk exports described by Config.BulkExportDestination:
+// a local directory (by default fhir-bulk-export in the temporary directory), or an S3 bucket and prefix (e.g.
+// s3://my-bucket/exports)
+func NewExportDestination(destination string) (ExportDestination, error) {
+	if strings.HasPrefix(destination, "s3://") {
+		bucketAndPrefix := strings.SplitN(strings.TrimPrefix(destination, "s3://"), "/", 2)
+		if bucketAndPrefix[0] == "" {
+			return nil, fmt.Errorf("invalid S3 export destination: %q", destination)
+		}
+		prefix := ""
+		if len(bucketAndPrefix) == 2 {
+			prefix = bucketAndPrefix[1]
+		}
+		return NewS3ExportDestination(bucketAndPrefix[0], prefix), nil
+	}
+	if destination == "" {
+		destination = filepath.Join(os.TempDir(), "fhir-bulk-export")
+	}
+	if err := os.MkdirAll(destination, 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create the export directory")
+	}
+	return LocalExportDestination{Dir: destination}, nil
+}
+
+// LocalExportDestination stores the files of bulk exports in a local directory, which clients download from the
+// server (see BulkExportFileHandler)
+type LocalExportDestination struct {
+	Dir string
+}
+
+func (d LocalExportDestination) Store(jobID, name string, localPath string) error {
+	if err := os.MkdirAll(filepath.Join(d.Dir, jobID), 0700); err != nil {
+		return errors.Wrap(err, "LocalExportDestination: failed to create directory")
+	}
+	source, err := os.Open(localPath)
+	if err != nil {
+		return errors.Wrap(err, "LocalExportDestination: failed to open file")
+	}
+	defer source.Close()
+	file, err := os.OpenFile(d.path(jobID, name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.Wrap(err, "LocalExportDestination: failed to create file")
+	}
+	if _, err := io.Copy(file, source); err != nil {
+		file.Close()
+		return errors.Wrap(err, "LocalExportDestination: failed to copy file")
+	}
+	return errors.Wrap(file.Close(), "LocalExportDestination: failed to write file")
+}
+
+func (d LocalExportDestination) URL(baseURL string, jobID, name string) (string, bool, error) {
+	return strings.TrimSuffix(baseURL, "/") + "/$export-file/" + jobID + "/" + name, true, nil
+}
+
+func (d LocalExportDestination) Delete(jobID string, names []string) error {
+	return errors.Wrap(os.RemoveAll(filepath.Join(d.Dir, jobID)), "LocalExportDestination: failed to delete files")
+}
+
+// Open opens a file of an export job, or returns an error satisfying os.IsNotExist if it doesn't exist
+func (d LocalExportDestination) Open(jobID, name string) (*os.File, error) {
+	if jobID != filepath.Base(jobID) || name != filepath.Base(name) {
+		return nil, os.ErrNotExist
+	}
+	return os.Open(d.path(jobID, name))
+}
+
+func (d LocalExportDestination) path(jobID, name string) string {
+	return filepath.Join(d.Dir, jobID, name)
+}
+
+// S3ExportDestination stores the files of bulk exports in an S3 bucket (or a compatible service), which clients
+// download with presigned URLs.  Requests are signed with AWS Signature Version 4.
+type S3ExportDestination struct {
+	Bucket string
+	// Prefix is prepended to the keys of the files, which are [job id]/[name]
+	Prefix string
+	Region string
+	// Endpoint is the URL of the service, e.g. https://s3.us-east-1.amazonaws.com, whose host the bucket is a
+	// subdomain of unless PathStyle is set
+	Endpoint  string
+	PathStyle bool
+
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// URLLifetime is how long presigned URLs are valid for (at most 7 days)
+	URLLifetime time.Duration
+	// Client is used for requests to S3
+	Client *http.Client
+}
+
+// NewS3ExportDestination returns an S3ExportDestination configured by the AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN and AWS_REGION environment variables, and AWS_S3_ENDPOINT for
+// services other than AWS (whose buckets are then addressed in the path)
+func NewS3ExportDestination(bucket, prefix string) *S3ExportDestination {
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := os.Getenv("AWS_S3_ENDPOINT")
+	pathStyle := endpoint != ""
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	return &S3ExportDestination{
+		Bucket:          bucket,
+		Prefix:          prefix,
+		Region:          region,
+		Endpoint:        endpoint,
+		PathStyle:       pathStyle,
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		URLLifetime:     time.Hour,
+		Client:          http.DefaultClient,
+	}
+}
+
+func (d *S3ExportDestination) Store(jobID, name string, localPath string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return errors.Wrap(err, "S3ExportDestination: failed to open file")
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return errors.Wrap(err, "S3ExportDestination: failed to stat file")
+	}
+
+	req, err := http.NewRequest("PUT", d.objectURL(jobID, name).String(), file)
+	if err != nil {
+		return errors.Wrap(err, "S3ExportDestination: failed to create request")
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", "application/fhir+ndjson")
+	return d.do(req)
+}
+
+func (d *S3ExportDestination) URL(baseURL string, jobID, name string) (string, bool, error) {
+	lifetime := d.URLLifetime
+	if lifetime > 7*24*time.Hour {
+		lifetime = 7 * 24 * time.Hour
+	}
+	return d.presign("GET", d.objectURL(jobID, name), lifetime, time.Now()), false, nil
+}
+
+func (d *S3ExportDestination) Delete(jobID string, names []string) error {
+	for _, name := range names {
+		req, err := http.NewRequest("DELETE", d.objectURL(jobID, name).String(), nil)
+		if err != nil {
+			return errors.Wrap(err, "S3ExportDestination: failed to create request")
+		}
+		if err := d.do(req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// objectURL returns the URL of the object of a file of an export job
+func (d *S3ExportDestination) objectURL(jobID, name string) *url.URL {
+	objectURL, _ := url.Parse(d.Endpoint)
+	key := path.Join(d.Prefix, jobID, name)
+	if d.PathStyle {
+		objectURL.Path = "/" + d.Bucket + "/" + key
+	} else {
+		objectURL.Host = d.Bucket + "." + objectURL.Host
+		objectURL.Path = "/" + key
+	}
+	return objectURL
+}
+
+// do signs and sends a request, returning an error unless it succeeds
+func (d *S3ExportDestination) do(req *http.Request) error {
+	d.sign(req, time.Now())
+	res, err := d.Client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "S3ExportDestination: %s %s failed", req.Method, req.URL.Path)
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("S3ExportDestination: %s %s failed with %s: %s", req.Method, req.URL.Path, res.Status, body)
+	}
+	return nil
+}
+
+// sign adds the Authorization header of AWS Signature Version 4 to a request, without signing its payload
+func (d *S3ExportDestination) sign(req *http.Request, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	if d.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", d.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") || lower == "content-type" {
+			headers[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	scope := d.scope(now)
+	signature := d.signature(now, req.Method, req.URL, canonicalHeaders.String(), signedHeaders)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", d.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// presign returns a URL of an object that can be used without credentials until it expires (see
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-query-string-auth.html)
+func (d *S3ExportDestination) presign(method string, objectURL *url.URL, lifetime time.Duration, now time.Time) string {
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", d.AccessKeyID+"/"+d.scope(now))
+	query.Set("X-Amz-Date", now.UTC().Format("20060102T150405Z"))
+	query.Set("X-Amz-Expires", fmt.Sprint(int(lifetime.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+	if d.SessionToken != "" {
+		query.Set("X-Amz-Security-Token", d.SessionToken)
+	}
+	presigned := *objectURL
+	presigned.RawQuery = awsQueryString(query)
+
+	signature := d.signature(now, method, &presigned, "host:"+presigned.Host+"\n", "host")
+	presigned.RawQuery += "&X-Amz-Signature=" + signature
+	return presigned.String()
+}
+
+// scope returns the credential scope of requests signed at a time
+func (d *S3ExportDestination) scope(now time.Time) string {
+	return now.UTC().Format("20060102") + "/" + d.Region + "/s3/aws4_request"
+}
+
+// signature returns the signature of a request with an unsigned payload
+func (d *S3ExportDestination) signature(now time.Time, method string, requestURL *url.URL, canonicalHeaders, signedHeaders string) string {
+	canonicalRequest := strings.Join([]string{
+		method,
+		awsURIEncode(requestURL.Path, false),
+		awsQueryString(requestURL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	hashedRequest := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		now.UTC().Format("20060102T150405Z"),
+		d.scope(now),
+		hex.EncodeToString(hashedRequest[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+d.SecretAccessKey), now.UTC().Format("20060102"))
+	for _, part := range []string{d.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsQueryString returns a canonical query string for AWS Signature Version 4, sorted by key
+func awsQueryString(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var params []string
+	for _, key := range keys {
+		for _, value := range query[key] {
+			params = append(params, awsURIEncode(key, true)+"="+awsURIEncode(value, true))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// awsURIEncode percent-encodes all but the unreserved characters of a string, and slashes unless encodeSlash is set
+func awsURIEncode(s string, encodeSlash bool) string {
+	var encoded strings.Builder
+	for _, b := range []byte(s) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', b == '-', b == '_', b == '.', b == '~':
+			encoded.WriteByte(b)
+		case b == '/' && !encodeSlash:
+			encoded.WriteByte(b)
+		default:
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+	return encoded.String()
+}
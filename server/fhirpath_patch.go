@@ -0,0 +1,536 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/eug48/fhir/models"
+)
+
+// A FHIRPath Patch (https://www.hl7.org/fhir/fhirpatch.html) is a Parameters resource with an operation
+// parameter for each change, whose parts give its type and the FHIRPath of the element(s) it changes.
+// Only simple paths are supported: child elements (e.g. Patient.name.given, or Observation.value for
+// one of its choice elements), indexers (e.g. Patient.name[0]), first(), last() and where() with
+// comparisons of child elements to literals (e.g. Patient.identifier.where(system = 'urn:x' and use = 'usual')).
+// The models package gives the elements each type has, e.g. to know whether an added element repeats.
+
+// fhirPathPatch is a FHIRPath Patch: a list of operations applied in turn
+type fhirPathPatch []fhirPathPatchOperation
+
+type fhirPathPatchOperation struct {
+	opType      string
+	path        string
+	name        string
+	value       interface{}
+	valueType   string // the type of a value[x] part (e.g. Quantity), for choice elements
+	hasValue    bool
+	index       *int
+	source      *int
+	destination *int
+}
+
+// parseFHIRPathPatch parses and checks a FHIRPath Patch Parameters resource
+func parseFHIRPathPatch(jsonBytes []byte) (fhirPathPatch, error) {
+	var parameters struct {
+		Parameter []map[string]interface{} `json:"parameter"`
+	}
+	if err := decodeJSON(jsonBytes, &parameters); err != nil {
+		return nil, ErrInvalidPatch{msg: fmt.Sprintf("Invalid FHIRPath Patch: %s", err)}
+	}
+
+	var patch fhirPathPatch
+	for i, parameter := range parameters.Parameter {
+		if parameter["name"] != "operation" {
+			return nil, ErrInvalidPatch{msg: fmt.Sprintf("FHIRPath Patch parameter %d isn't an operation", i)}
+		}
+		var operation fhirPathPatchOperation
+		parts, _ := parameter["part"].([]interface{})
+		for _, part := range parts {
+			part, _ := part.(map[string]interface{})
+			value, valueType, hasValue := fhirPathPatchPartValue(part)
+			switch part["name"] {
+			case "type":
+				operation.opType, _ = value.(string)
+			case "path":
+				operation.path, _ = value.(string)
+			case "name":
+				operation.name, _ = value.(string)
+			case "value":
+				operation.value, operation.valueType, operation.hasValue = value, valueType, hasValue
+			case "index":
+				operation.index = fhirPathPatchInteger(value)
+			case "source":
+				operation.source = fhirPathPatchInteger(value)
+			case "destination":
+				operation.destination = fhirPathPatchInteger(value)
+			}
+		}
+
+		missing := ""
+		switch {
+		case operation.path == "":
+			missing = "path"
+		case operation.opType == "add" && operation.name == "":
+			missing = "name"
+		case (operation.opType == "add" || operation.opType == "insert" || operation.opType == "replace") && !operation.hasValue:
+			missing = "value"
+		case operation.opType == "insert" && operation.index == nil:
+			missing = "index"
+		case operation.opType == "move" && operation.source == nil:
+			missing = "source"
+		case operation.opType == "move" && operation.destination == nil:
+			missing = "destination"
+		}
+		switch operation.opType {
+		case "add", "insert", "delete", "replace", "move":
+		default:
+			return nil, ErrInvalidPatch{msg: fmt.Sprintf("FHIRPath Patch operation %d has an unknown type: %q", i, operation.opType)}
+		}
+		if missing != "" {
+			return nil, ErrInvalidPatch{msg: fmt.Sprintf("FHIRPath Patch operation %d (%s) has no %s", i, operation.opType, missing)}
+		}
+		if _, err := parseFHIRPath(operation.path); err != nil {
+			return nil, err
+		}
+		patch = append(patch, operation)
+	}
+	return patch, nil
+}
+
+// fhirPathPatchPartValue returns the value of a part: that of its value[x] (with its type), or else an
+// element made of its own parts (for elements without a type of their own, e.g. Patient.contact)
+func fhirPathPatchPartValue(part map[string]interface{}) (value interface{}, valueType string, ok bool) {
+	for key, value := range part {
+		if strings.HasPrefix(key, "value") && len(key) > len("value") {
+			return value, key[len("value"):], true
+		}
+	}
+	parts, ok := part["part"].([]interface{})
+	if !ok {
+		return nil, "", false
+	}
+	element := make(map[string]interface{})
+	for _, child := range parts {
+		child, _ := child.(map[string]interface{})
+		name, _ := child["name"].(string)
+		childValue, _, _ := fhirPathPatchPartValue(child)
+		if existing, repeated := element[name]; repeated {
+			if list, isList := existing.([]interface{}); isList {
+				element[name] = append(list, childValue)
+			} else {
+				element[name] = []interface{}{existing, childValue}
+			}
+		} else {
+			element[name] = childValue
+		}
+	}
+	return element, "", true
+}
+
+func fhirPathPatchInteger(value interface{}) *int {
+	number, ok := value.(json.Number)
+	if !ok {
+		return nil
+	}
+	integer, err := number.Int64()
+	if err != nil {
+		return nil
+	}
+	i := int(integer)
+	return &i
+}
+
+func (p fhirPathPatch) apply(doc map[string]interface{}, resourceType string) (interface{}, error) {
+	for _, operation := range p {
+		if err := operation.apply(doc, resourceType); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+func (o fhirPathPatchOperation) apply(doc map[string]interface{}, resourceType string) error {
+	steps, err := parseFHIRPath(o.path)
+	if err != nil {
+		return err
+	}
+
+	switch o.opType {
+	case "add":
+		node, err := singleFHIRPathNode(o.path, evaluateFHIRPath(doc, resourceType, steps))
+		if err != nil {
+			return err
+		}
+		owner, ok := node.value.(map[string]interface{})
+		if !ok {
+			return ErrPatchFailed{msg: fmt.Sprintf("Can't add %s to the primitive value at %s", o.name, o.path)}
+		}
+		name := o.name
+		_, repeats, found := fhirElementType(node.typ, name)
+		if !found && o.valueType != "" {
+			// e.g. Observation.value given a valueQuantity is the valueQuantity element
+			if _, _, isChoice := fhirElementType(node.typ, name+o.valueType); isChoice {
+				name = name + o.valueType
+			}
+		}
+		if list, isList := owner[name].([]interface{}); isList {
+			owner[name] = append(list, o.value)
+		} else if _, exists := owner[name]; exists {
+			return ErrPatchFailed{msg: fmt.Sprintf("%s already has a %s element", o.path, name)}
+		} else if repeats {
+			owner[name] = []interface{}{o.value}
+		} else {
+			owner[name] = o.value
+		}
+
+	case "insert", "move":
+		owner, name, err := fhirPathList(doc, resourceType, o.path, steps)
+		if err != nil {
+			return err
+		}
+		list, _ := owner[name].([]interface{})
+		value := o.value
+		index := 0
+		if o.opType == "insert" {
+			index = *o.index
+		} else {
+			if *o.source < 0 || *o.source >= len(list) {
+				return ErrPatchFailed{msg: fmt.Sprintf("Source index %d is out of bounds of %s", *o.source, o.path)}
+			}
+			value = list[*o.source]
+			list = append(list[:*o.source:*o.source], list[*o.source+1:]...)
+			index = *o.destination
+		}
+		if index < 0 || index > len(list) {
+			return ErrPatchFailed{msg: fmt.Sprintf("Index %d is out of bounds of %s", index, o.path)}
+		}
+		list = append(list, nil)
+		copy(list[index+1:], list[index:])
+		list[index] = value
+		owner[name] = list
+
+	case "delete":
+		nodes := evaluateFHIRPath(doc, resourceType, steps)
+		if len(nodes) == 0 {
+			return nil
+		}
+		node, err := singleFHIRPathNode(o.path, nodes)
+		if err != nil {
+			return err
+		}
+		if node.owner == nil {
+			return ErrPatchFailed{msg: "Can't delete the whole resource"}
+		}
+		if node.index < 0 {
+			delete(node.owner, node.name)
+		} else if list := node.owner[node.name].([]interface{}); len(list) == 1 {
+			delete(node.owner, node.name)
+		} else {
+			node.owner[node.name] = append(list[:node.index:node.index], list[node.index+1:]...)
+		}
+
+	case "replace":
+		node, err := singleFHIRPathNode(o.path, evaluateFHIRPath(doc, resourceType, steps))
+		if err != nil {
+			return err
+		}
+		if node.owner == nil {
+			return ErrPatchFailed{msg: "Can't replace the whole resource"}
+		}
+		if node.index >= 0 {
+			node.owner[node.name].([]interface{})[node.index] = o.value
+		} else if node.name != node.member && o.valueType != "" {
+			// A choice element can be replaced with one of another type, e.g. valueQuantity with valueString
+			delete(node.owner, node.name)
+			node.owner[node.member+o.valueType] = o.value
+		} else {
+			node.owner[node.name] = o.value
+		}
+	}
+	return nil
+}
+
+func singleFHIRPathNode(path string, nodes []fhirPathNode) (fhirPathNode, error) {
+	if len(nodes) == 0 {
+		return fhirPathNode{}, ErrPatchFailed{msg: fmt.Sprintf("No element matches %s", path)}
+	}
+	if len(nodes) > 1 {
+		return fhirPathNode{}, ErrPatchFailed{msg: fmt.Sprintf("Multiple elements match %s", path)}
+	}
+	return nodes[0], nil
+}
+
+// fhirPathList returns the element and name of the list of elements of an insert or move, whose path must
+// end with the name of the list (e.g. Patient.identifier), which may not exist yet
+func fhirPathList(doc map[string]interface{}, resourceType string, path string, steps []fhirPathStep) (owner map[string]interface{}, name string, err error) {
+	last := steps[len(steps)-1]
+	if last.function != "" || len(last.indexes) > 0 || len(steps) < 2 {
+		return nil, "", ErrInvalidPatch{msg: fmt.Sprintf("%s isn't a list of elements", path)}
+	}
+	node, err := singleFHIRPathNode(path, evaluateFHIRPath(doc, resourceType, steps[:len(steps)-1]))
+	if err != nil {
+		return nil, "", err
+	}
+	owner, ok := node.value.(map[string]interface{})
+	if !ok {
+		return nil, "", ErrPatchFailed{msg: fmt.Sprintf("%s isn't a list of elements", path)}
+	}
+	if _, isList := owner[last.name].([]interface{}); !isList {
+		if _, exists := owner[last.name]; exists {
+			return nil, "", ErrPatchFailed{msg: fmt.Sprintf("%s isn't a list of elements", path)}
+		}
+	}
+	return owner, last.name, nil
+}
+
+// fhirPathNode is an element of a resource found by a FHIRPath
+type fhirPathNode struct {
+	owner  map[string]interface{} // the element it's in (nil for the resource itself)
+	name   string                 // its name in the owner
+	member string                 // the name it was found by (e.g. value for valueQuantity)
+	index  int                    // its index in the owner's list of these elements (-1 if it doesn't repeat)
+	value  interface{}
+	typ    reflect.Type // its type in the models package (nil if unknown)
+}
+
+// fhirPathStep is a child element name or a function (first, last or where), followed by any indexers
+type fhirPathStep struct {
+	name     string
+	function string
+	criteria []fhirPathCriterion
+	indexes  []int
+}
+
+// fhirPathCriterion is a comparison of a where() function, e.g. system = 'urn:x'
+type fhirPathCriterion struct {
+	path     []string
+	notEqual bool
+	literal  interface{}
+}
+
+var fhirPathStepRegex = regexp.MustCompile(`(?s)^([A-Za-z_][A-Za-z0-9_]*)(\((.*)\))?((?:\[\d+\])*)$`)
+var fhirPathIndexRegex = regexp.MustCompile(`\[(\d+)\]`)
+var fhirPathCriterionRegex = regexp.MustCompile(`(?s)^\s*([A-Za-z_][A-Za-z0-9_.]*)\s*(=|!=)\s*(.+?)\s*$`)
+var fhirPathNumberRegex = regexp.MustCompile(`^-?\d+(\.\d+)?$`)
+
+// parseFHIRPath parses the (supported subset of) FHIRPath of a FHIRPath Patch operation
+func parseFHIRPath(path string) ([]fhirPathStep, error) {
+	var steps []fhirPathStep
+	for _, segment := range splitFHIRPath(path, ".") {
+		match := fhirPathStepRegex.FindStringSubmatch(strings.TrimSpace(segment))
+		if match == nil {
+			return nil, ErrInvalidPatch{msg: fmt.Sprintf("Unsupported FHIRPath: %s", path)}
+		}
+		step := fhirPathStep{name: match[1]}
+		if match[2] != "" {
+			step.name, step.function = "", match[1]
+			switch step.function {
+			case "first", "last":
+				if strings.TrimSpace(match[3]) != "" {
+					return nil, ErrInvalidPatch{msg: fmt.Sprintf("%s() doesn't take arguments: %s", step.function, path)}
+				}
+			case "where":
+				for _, comparison := range splitFHIRPath(match[3], " and ") {
+					criterion, err := parseFHIRPathCriterion(comparison)
+					if err != nil {
+						return nil, ErrInvalidPatch{msg: fmt.Sprintf("%s: %s", err, path)}
+					}
+					step.criteria = append(step.criteria, criterion)
+				}
+			default:
+				return nil, ErrInvalidPatch{msg: fmt.Sprintf("Unsupported FHIRPath function %s(): %s", step.function, path)}
+			}
+		}
+		for _, index := range fhirPathIndexRegex.FindAllStringSubmatch(match[4], -1) {
+			i, err := strconv.Atoi(index[1])
+			if err != nil {
+				return nil, ErrInvalidPatch{msg: fmt.Sprintf("Invalid index %s: %s", index[1], path)}
+			}
+			step.indexes = append(step.indexes, i)
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+func parseFHIRPathCriterion(comparison string) (fhirPathCriterion, error) {
+	match := fhirPathCriterionRegex.FindStringSubmatch(comparison)
+	if match == nil {
+		return fhirPathCriterion{}, fmt.Errorf("Unsupported where() criteria %q", comparison)
+	}
+	criterion := fhirPathCriterion{path: strings.Split(match[1], "."), notEqual: match[2] == "!="}
+	literal := match[3]
+	switch {
+	case len(literal) >= 2 && strings.HasPrefix(literal, "'") && strings.HasSuffix(literal, "'"):
+		criterion.literal = strings.NewReplacer(`\'`, `'`, `\\`, `\`).Replace(literal[1 : len(literal)-1])
+	case literal == "true" || literal == "false":
+		criterion.literal = literal == "true"
+	case fhirPathNumberRegex.MatchString(literal):
+		criterion.literal = json.Number(literal)
+	default:
+		return fhirPathCriterion{}, fmt.Errorf("Unsupported literal %s", literal)
+	}
+	return criterion, nil
+}
+
+// splitFHIRPath splits a FHIRPath at the separators that aren't in brackets or strings
+func splitFHIRPath(path string, separator string) []string {
+	var parts []string
+	depth, inString, start := 0, false, 0
+	for i := 0; i < len(path); i++ {
+		switch {
+		case inString && path[i] == '\\':
+			i++
+		case path[i] == '\'':
+			inString = !inString
+		case inString:
+		case path[i] == '(' || path[i] == '[':
+			depth++
+		case path[i] == ')' || path[i] == ']':
+			depth--
+		case depth == 0 && strings.HasPrefix(path[i:], separator):
+			parts = append(parts, path[start:i])
+			start = i + len(separator)
+			i = start - 1
+		}
+	}
+	return append(parts, path[start:])
+}
+
+// evaluateFHIRPath returns the elements of a resource that a FHIRPath finds, which may start with the resource type
+func evaluateFHIRPath(doc map[string]interface{}, resourceType string, steps []fhirPathStep) []fhirPathNode {
+	nodes := []fhirPathNode{{value: doc, index: -1, typ: reflect.TypeOf(models.StructForResourceName(resourceType))}}
+	if len(steps) > 0 && steps[0].name == resourceType && steps[0].function == "" {
+		nodes = applyFHIRPathIndexes(nodes, steps[0].indexes)
+		steps = steps[1:]
+	}
+
+	for _, step := range steps {
+		switch step.function {
+		case "":
+			nodes = fhirPathChildren(nodes, step.name)
+		case "first":
+			if len(nodes) > 1 {
+				nodes = nodes[:1]
+			}
+		case "last":
+			if len(nodes) > 1 {
+				nodes = nodes[len(nodes)-1:]
+			}
+		case "where":
+			var matches []fhirPathNode
+			for _, node := range nodes {
+				if node.matches(step.criteria) {
+					matches = append(matches, node)
+				}
+			}
+			nodes = matches
+		}
+		nodes = applyFHIRPathIndexes(nodes, step.indexes)
+	}
+	return nodes
+}
+
+func applyFHIRPathIndexes(nodes []fhirPathNode, indexes []int) []fhirPathNode {
+	for _, index := range indexes {
+		if index >= len(nodes) {
+			return nil
+		}
+		nodes = nodes[index : index+1]
+	}
+	return nodes
+}
+
+// fhirPathChildren returns the child elements with a name of each of the nodes
+func fhirPathChildren(nodes []fhirPathNode, name string) []fhirPathNode {
+	var children []fhirPathNode
+	for _, node := range nodes {
+		element, ok := node.value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		key := name
+		childType, _, found := fhirElementType(node.typ, name)
+		if _, exists := element[name]; !exists && !found && node.typ != nil {
+			// Choice elements (e.g. Observation.value) are stored with their type (e.g. valueQuantity)
+			for elementKey := range element {
+				if strings.HasPrefix(elementKey, name) && len(elementKey) > len(name) && elementKey[len(name)] >= 'A' && elementKey[len(name)] <= 'Z' {
+					if choiceType, _, isChoice := fhirElementType(node.typ, elementKey); isChoice {
+						key, childType = elementKey, choiceType
+						break
+					}
+				}
+			}
+		}
+
+		switch value := element[key].(type) {
+		case nil:
+		case []interface{}:
+			for i, item := range value {
+				children = append(children, fhirPathNode{owner: element, name: key, member: name, index: i, value: item, typ: childType})
+			}
+		default:
+			children = append(children, fhirPathNode{owner: element, name: key, member: name, index: -1, value: value, typ: childType})
+		}
+	}
+	return children
+}
+
+// matches checks if all the criteria of a where() are true of a node
+func (n fhirPathNode) matches(criteria []fhirPathCriterion) bool {
+	for _, criterion := range criteria {
+		nodes := []fhirPathNode{n}
+		for _, name := range criterion.path {
+			nodes = fhirPathChildren(nodes, name)
+		}
+		equal := false
+		for _, node := range nodes {
+			if jsonEqual(node.value, criterion.literal) {
+				equal = true
+			}
+		}
+		if equal == criterion.notEqual {
+			return false
+		}
+	}
+	return true
+}
+
+// fhirElementType returns the type (in the models package) of the element of a type with a name, and
+// whether it repeats.  found is false if the type is unknown or has no such element.
+func fhirElementType(typ reflect.Type, name string) (elementType reflect.Type, repeats bool, found bool) {
+	if typ == nil {
+		return nil, false, false
+	}
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return nil, false, false
+	}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.Anonymous {
+			if elementType, repeats, found := fhirElementType(field.Type, name); found {
+				return elementType, repeats, true
+			}
+			continue
+		}
+		if strings.Split(field.Tag.Get("json"), ",")[0] != name {
+			continue
+		}
+		elementType = field.Type
+		if elementType.Kind() == reflect.Slice && elementType.Elem().Kind() != reflect.Uint8 {
+			elementType, repeats = elementType.Elem(), true
+		}
+		for elementType.Kind() == reflect.Ptr {
+			elementType = elementType.Elem()
+		}
+		return elementType, repeats, true
+	}
+	return nil, false, false
+}
@@ -1,10 +1,17 @@
 package server
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"strings"
+	"sync"
 	"encoding/json"
+	"encoding/xml"
 	"github.com/dop251/goja"
 	"github.com/gin-gonic/gin"
+	"github.com/eug48/fhir/models2"
+	"github.com/pkg/errors"
 )
 
 // Converts between FHIR JSON and XML encodings using the
@@ -13,6 +20,7 @@ import (
 // It is executed using the goja JavaScript engine
 type FhirFormatConverter struct {
 	runtime *goja.Runtime
+	mutex   sync.Mutex // goja runtimes can't be used by concurrent requests
 }
 
 func NewFhirFormatConverter() *FhirFormatConverter {
@@ -41,6 +49,8 @@ func NewFhirFormatConverter() *FhirFormatConverter {
 }
 
 func (c *FhirFormatConverter) XmlToJson(xml string) (json string, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 
 	c.runtime.Set("strXML", c.runtime.ToValue(xml))
 	jsonVal, err := c.runtime.RunString("fhir.xmlToJson(strXML);")
@@ -60,6 +70,8 @@ func (c *FhirFormatConverter) JsonToXml(json string) (xml string, err error) {
 	if json == "" {
 		return "", nil
 	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	// fmt.Printf("[JsonToXML] json: %s\n", json)
 	c.runtime.Set("strJSON", c.runtime.ToValue(json))
 	// FIXME: JSON.parse doesn't correctly parse FHIR decimals..
@@ -86,4 +98,108 @@ func (c *FhirFormatConverter) SendXML(statusCode int, obj interface{}, context *
 	}
 	context.Data(statusCode, "application/fhir+xml; charset=utf-8", []byte(xml))
 	return err
-}
\ No newline at end of file
+}
+
+// NewResourceFromXml unmarshals a resource from its FHIR XML encoding
+func (c *FhirFormatConverter) NewResourceFromXml(xml []byte) (*models2.Resource, error) {
+	jsonStr, err := c.XmlToJson(string(xml))
+	if err != nil {
+		return nil, errors.Wrap(err, "NewResourceFromXml: XmlToJson failed")
+	}
+	return models2.NewResourceFromJsonBytes([]byte(jsonStr))
+}
+
+// ResourceToXml marshals a resource to its FHIR XML encoding
+func (c *FhirFormatConverter) ResourceToXml(resource *models2.Resource) ([]byte, error) {
+	jsonBytes, err := json.Marshal(resource)
+	if err != nil {
+		return nil, errors.Wrap(err, "ResourceToXml: json.Marshal failed")
+	}
+	xml, err := c.JsonToXml(string(jsonBytes))
+	if err != nil {
+		return nil, errors.Wrap(err, "ResourceToXml: JsonToXml failed")
+	}
+	return []byte(xml), nil
+}
+
+// indentXML pretty-prints XML with each element on its own line, keeping the prefixes and namespace declarations
+// as they are
+func indentXML(data []byte) ([]byte, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	var out bytes.Buffer
+	depth := 0
+	startOpen := false // the last start tag is awaiting its > or />
+	hasText := false   // the current element has text, so its end tag follows it on the same line
+
+	name := func(n xml.Name) string {
+		if n.Space != "" {
+			return n.Space + ":" + n.Local
+		}
+		return n.Local
+	}
+	newLine := func() {
+		if out.Len() > 0 {
+			out.WriteString("\n" + strings.Repeat("  ", depth))
+		}
+	}
+	closeStart := func() {
+		if startOpen {
+			out.WriteString(">")
+			startOpen = false
+		}
+	}
+
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			closeStart()
+			newLine()
+			out.WriteString("<" + name(t.Name))
+			for _, attr := range t.Attr {
+				out.WriteString(" " + name(attr.Name) + `="`)
+				xml.EscapeText(&out, []byte(attr.Value))
+				out.WriteString(`"`)
+			}
+			startOpen = true
+			hasText = false
+			depth++
+		case xml.EndElement:
+			depth--
+			if startOpen {
+				out.WriteString("/>")
+				startOpen = false
+			} else {
+				if !hasText {
+					newLine()
+				}
+				out.WriteString("</" + name(t.Name) + ">")
+			}
+			hasText = false
+		case xml.CharData:
+			if len(bytes.TrimSpace(t)) == 0 {
+				continue
+			}
+			closeStart()
+			xml.EscapeText(&out, t)
+			hasText = true
+		case xml.Comment:
+			closeStart()
+			newLine()
+			out.WriteString("<!--" + string(t) + "-->")
+		case xml.ProcInst:
+			newLine()
+			out.WriteString("<?" + t.Target + " " + string(t.Inst) + "?>")
+		case xml.Directive:
+			newLine()
+			out.WriteString("<!" + string(t) + ">")
+		}
+	}
+	return out.Bytes(), nil
+}
@@ -38,6 +38,43 @@ func (s *FormatConversionSuite) TestBundle(c *C) {
 	// c.Assert(strings.Replace(result, "\n", "\r\n", -1), Equals, string(xml))
 }
 
+func (s *FormatConversionSuite) TestResourceXml(c *C) {
+	converter := NewFhirFormatConverter()
+
+	resource, err := converter.NewResourceFromXml([]byte(`<Patient xmlns="http://hl7.org/fhir"><id value="123"/><gender value="female"/></Patient>`))
+	c.Assert(err, IsNil)
+	c.Assert(resource.ResourceType(), Equals, "Patient")
+	c.Assert(resource.Id(), Equals, "123")
+	patient := &models.Patient{}
+	c.Assert(resource.Unmarshal(patient), IsNil)
+	c.Assert(patient.Gender, Equals, "female")
+
+	xmlBytes, err := converter.ResourceToXml(resource)
+	c.Assert(err, IsNil)
+	areEqual, err := areEqualXML(string(xmlBytes), `<Patient xmlns="http://hl7.org/fhir"><id value="123"/><gender value="female"/></Patient>`)
+	c.Assert(err, IsNil)
+	c.Assert(areEqual, Equals, true)
+}
+
+func (s *FormatConversionSuite) TestIndentXml(c *C) {
+	indented, err := indentXML([]byte(`<?xml version="1.0" encoding="UTF-8"?><Patient xmlns="http://hl7.org/fhir"><id value="1"/>` +
+		`<text><div xmlns="http://www.w3.org/1999/xhtml">Smith &amp; Jones</div></text><name><family value="Smith &quot;Jr&quot;"/></name></Patient>`))
+	c.Assert(err, IsNil)
+	c.Assert(string(indented), Equals, `<?xml version="1.0" encoding="UTF-8"?>
+<Patient xmlns="http://hl7.org/fhir">
+  <id value="1"/>
+  <text>
+    <div xmlns="http://www.w3.org/1999/xhtml">Smith &amp; Jones</div>
+  </text>
+  <name>
+    <family value="Smith &#34;Jr&#34;"/>
+  </name>
+</Patient>`)
+
+	_, err = indentXML([]byte(`<Patient><id value=1/></Patient>`))
+	c.Assert(err, NotNil)
+}
+
 func (s *FormatConversionSuite) TestObservation(c *C) {
 	jsonBytes, err := ioutil.ReadFile("../fixtures/bundle-crucible-1-observation.json")
 	c.Assert(err, IsNil)
@@ -0,0 +1,525 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/eug48/fhir/models2"
+	"github.com/eug48/fhir/search"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// The FHIR GraphQL interface (http://hl7.org/fhir/STU3/graphql.html) runs GraphQL queries of resources: at the
+// system level, each field of a query is a read (e.g. Patient(id: 123)) or search (e.g. PatientList(name: "smith"),
+// whose arguments are search parameters with _ instead of -), while at the instance level the query selects the
+// fields of the resource.  The fields of resources are their elements, with arguments that filter lists by their
+// elements' values (e.g. name(use: official)), and the @first, @singleton and @flatten directives.  References are
+// followed by selecting their resource field (with inline fragments for each type of resource, e.g.
+// generalPractitioner { resource { ... on Practitioner { name { family } } } }), and the resources referring to a
+// resource are searched for by [type]List fields with a _reference argument giving the search parameter (e.g.
+// ConditionList(_reference: patient)).  Mutations and connections aren't supported.
+
+// GraphQLHandler returns a handler of GraphQL queries at the system level (GET or POST /$graphql), or of a resource
+// of a type if it isn't empty (GET or POST /[type]/[id]/$graphql).  Queries are given by the query parameter (with
+// the variables and operationName parameters), or by POST bodies of GraphQL (application/graphql) or of JSON
+// objects with these parameters (application/json).
+func GraphQLHandler(dal DataAccessLayer, config Config, resourceType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer handlePanics(c)
+		c.Set("Action", "operation")
+
+		var request struct {
+			Query         string                 `json:"query"`
+			Variables     map[string]interface{} `json:"variables"`
+			OperationName string                 `json:"operationName"`
+		}
+		request.Query = c.Query("query")
+		request.OperationName = c.Query("operationName")
+		if variables := c.Query("variables"); variables != "" {
+			if err := decodeJSON([]byte(variables), &request.Variables); err != nil {
+				panic(ErrInvalidGraphQL{msg: fmt.Sprintf("The variables parameter isn't a JSON object: %s", err)})
+			}
+		}
+		if c.Request.Method == http.MethodPost {
+			body, err := ioutil.ReadAll(c.Request.Body)
+			if err != nil {
+				panic(errors.Wrap(err, "failed to read the request body"))
+			}
+			if strings.HasPrefix(c.ContentType(), "application/graphql") {
+				request.Query = string(body)
+			} else if err := decodeJSON(body, &request); err != nil {
+				panic(ErrInvalidGraphQL{msg: fmt.Sprintf("The request body isn't a JSON object: %s", err)})
+			}
+		}
+		if request.Query == "" {
+			panic(ErrInvalidGraphQL{msg: "The query parameter is required"})
+		}
+
+		document, err := parseGraphQL(request.Query)
+		if err != nil {
+			panic(err)
+		}
+		session := dal.StartSession(c.Request.Context(), c.GetHeader("Db"))
+		defer session.Finish()
+		executor := &graphQLExecutor{
+			session:   session,
+			baseURL:   *config.responseURL(c.Request),
+			document:  document,
+			resources: make(map[string]map[string]interface{}),
+		}
+		operation := executor.operation(request.OperationName, request.Variables)
+
+		var data graphQLResult
+		if resourceType == "" {
+			data = executor.selectQuery(operation.selections)
+		} else {
+			resource := executor.read(resourceType, c.Param("id"), "")
+			if resource == nil {
+				c.Status(http.StatusNotFound)
+				return
+			}
+			data = executor.selectObject(resource, operation.selections, resourceType, resource)
+		}
+
+		response := map[string]interface{}{"data": data}
+		if len(executor.errors) > 0 {
+			var errorsJSON []map[string]string
+			for _, message := range executor.errors {
+				errorsJSON = append(errorsJSON, map[string]string{"message": message})
+			}
+			response["errors"] = errorsJSON
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// graphQLResult is the result of a selection set: its fields in the order they were selected
+type graphQLResult []graphQLArgument
+
+func (r graphQLResult) MarshalJSON() ([]byte, error) {
+	var buffer bytes.Buffer
+	buffer.WriteByte('{')
+	for i, field := range r {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		key, _ := json.Marshal(field.name)
+		value, err := json.Marshal(field.value)
+		if err != nil {
+			return nil, err
+		}
+		buffer.Write(key)
+		buffer.WriteByte(':')
+		buffer.Write(value)
+	}
+	buffer.WriteByte('}')
+	return buffer.Bytes(), nil
+}
+
+// graphQLExecutor runs a GraphQL operation, reading and searching for resources with a session.  Problems that don't
+// stop the operation (e.g. references that can't be resolved) are reported in errors.
+type graphQLExecutor struct {
+	session   DataAccessSession
+	baseURL   url.URL
+	document  *graphQLDocument
+	variables map[string]interface{}
+	// the resources that have been read, by their Type/id (or nil if they weren't found)
+	resources map[string]map[string]interface{}
+	errors    []string
+}
+
+// operation returns the operation of the document to run (the one with a name, if there's more than one), setting
+// the executor's variables from their values and defaults
+func (e *graphQLExecutor) operation(name string, values map[string]interface{}) *graphQLOperation {
+	var operation *graphQLOperation
+	for _, candidate := range e.document.operations {
+		if candidate.name == name || (name == "" && len(e.document.operations) == 1) {
+			operation = candidate
+		}
+	}
+	if operation == nil && name == "" {
+		panic(ErrInvalidGraphQL{msg: "The operationName parameter is required, as the query has more than one operation"})
+	} else if operation == nil {
+		panic(ErrInvalidGraphQL{msg: fmt.Sprintf("The query has no operation named %s", name)})
+	} else if operation.opType != "query" {
+		panic(ErrInvalidGraphQL{msg: fmt.Sprintf("Only queries are supported, not %ss", operation.opType)})
+	}
+
+	e.variables = make(map[string]interface{})
+	for _, definition := range operation.variables {
+		if value, given := values[definition.name]; given {
+			e.variables[definition.name] = value
+		} else if definition.hasDefault {
+			e.variables[definition.name] = definition.defaultValue
+		} else if definition.required {
+			panic(ErrInvalidGraphQL{msg: fmt.Sprintf("The variable $%s is required", definition.name)})
+		}
+	}
+	return operation
+}
+
+// selectQuery returns the result of the fields of a query: reads and searches of resources
+func (e *graphQLExecutor) selectQuery(selections []*graphQLSelection) graphQLResult {
+	var result graphQLResult
+	for _, selection := range e.fields(selections, "Query") {
+		var value interface{}
+		switch name := selection.name; {
+		case name == "__typename":
+			value = "Query"
+		case strings.HasSuffix(name, "Connection") && isGraphQLResourceType(strings.TrimSuffix(name, "Connection")):
+			panic(ErrInvalidGraphQL{msg: fmt.Sprintf("Connections aren't supported (%s)", name)})
+		case strings.HasSuffix(name, "List") && isGraphQLResourceType(strings.TrimSuffix(name, "List")):
+			value = e.selectResources(e.search(strings.TrimSuffix(name, "List"), selection.arguments, nil), selection)
+		case isGraphQLResourceType(name):
+			id, _ := e.argument(selection.arguments, "id").(string)
+			if id == "" {
+				panic(ErrInvalidGraphQL{msg: fmt.Sprintf("The id argument of %s is required", name)})
+			}
+			if resource := e.read(name, id, ""); resource != nil {
+				value = e.selectObject(resource, selection.selections, name, resource)
+			}
+		default:
+			panic(ErrInvalidGraphQL{msg: fmt.Sprintf("Unknown field: %s", name)})
+		}
+		result = e.addField(result, selection, value)
+	}
+	return result
+}
+
+// selectObject returns the result of the fields of an object (a resource, or an element of one) of a type (or ""
+// if it's an element, whose type isn't known), in a resource
+func (e *graphQLExecutor) selectObject(object map[string]interface{}, selections []*graphQLSelection, typeName string, resource map[string]interface{}) graphQLResult {
+	if resourceType, isResource := object["resourceType"].(string); isResource {
+		typeName, resource = resourceType, object
+	}
+	result := graphQLResult{}
+	for _, selection := range e.fields(selections, typeName) {
+		name := selection.name
+		var value interface{}
+		var present bool
+		switch {
+		case name == "__typename":
+			value, present = typeName, typeName != ""
+		case name == "resource" && object["reference"] != nil:
+			reference, _ := object["reference"].(string)
+			if referenced := e.resolve(reference, resource); referenced != nil {
+				value, present = e.selectObject(referenced, selection.selections, "", resource), true
+			} else {
+				e.errors = append(e.errors, fmt.Sprintf("Failed to resolve the reference %s", reference))
+			}
+		case strings.HasSuffix(name, "List") && isGraphQLResourceType(strings.TrimSuffix(name, "List")) && e.argument(selection.arguments, "_reference") != nil:
+			// the resources referring to this one
+			id, _ := object["id"].(string)
+			value, present = e.selectResources(e.search(strings.TrimSuffix(name, "List"), selection.arguments, &graphQLArgument{name: typeName + "/" + id}), selection), true
+		default:
+			var element interface{}
+			if element, present = object[name]; present {
+				value = e.selectElement(element, selection, resource)
+			}
+		}
+		if present {
+			result = e.addField(result, selection, value)
+		}
+	}
+	return result
+}
+
+// selectElement returns the result of a field of an element: a list (filtered by the field's arguments), an object
+// (with the field's selections) or a primitive value
+func (e *graphQLExecutor) selectElement(element interface{}, selection *graphQLSelection, resource map[string]interface{}) interface{} {
+	list, isList := element.([]interface{})
+	if !isList {
+		if object, isObject := element.(map[string]interface{}); isObject && len(selection.selections) > 0 {
+			return e.selectObject(object, selection.selections, "", resource)
+		}
+		return element
+	}
+
+	values := []interface{}{}
+	for _, item := range list {
+		if e.matchesArguments(item, selection.arguments) {
+			values = append(values, e.selectElement(item, &graphQLSelection{selections: selection.selections}, resource))
+		}
+	}
+	return e.applyListDirectives(values, selection)
+}
+
+// selectResources returns the results of the field of a search
+func (e *graphQLExecutor) selectResources(resources []map[string]interface{}, selection *graphQLSelection) interface{} {
+	values := []interface{}{}
+	for _, resource := range resources {
+		values = append(values, e.selectObject(resource, selection.selections, "", resource))
+	}
+	return e.applyListDirectives(values, selection)
+}
+
+// applyListDirectives applies the @first and @singleton directives of a field to its list of values
+func (e *graphQLExecutor) applyListDirectives(values []interface{}, selection *graphQLSelection) interface{} {
+	for _, directive := range selection.directives {
+		switch directive.name {
+		case "first":
+			if len(values) == 0 {
+				return nil
+			}
+			return values[0]
+		case "singleton":
+			if len(values) > 1 {
+				panic(ErrInvalidGraphQL{msg: fmt.Sprintf("%s has %d values, but is a @singleton", selection.key(), len(values))})
+			} else if len(values) == 0 {
+				return nil
+			}
+			return values[0]
+		}
+	}
+	return values
+}
+
+// addField adds the value of a field to a result, or with the @flatten directive, the fields of its value (or of
+// each of its values, whose fields are collected in lists)
+func (e *graphQLExecutor) addField(result graphQLResult, selection *graphQLSelection, value interface{}) graphQLResult {
+	if !hasGraphQLDirective(selection.directives, "flatten") {
+		return append(result, graphQLArgument{name: selection.key(), value: value})
+	}
+	switch value := value.(type) {
+	case graphQLResult:
+		return append(result, value...)
+	case []interface{}:
+		for _, item := range value {
+			fields, _ := item.(graphQLResult)
+			for _, field := range fields {
+				found := false
+				for i := range result {
+					if result[i].name == field.name {
+						list, _ := result[i].value.([]interface{})
+						result[i].value, found = append(list, field.value), true
+					}
+				}
+				if !found {
+					result = append(result, graphQLArgument{name: field.name, value: []interface{}{field.value}})
+				}
+			}
+		}
+	}
+	return result
+}
+
+// fields returns the fields of a selection set on an object of a type, with those of the fragments that apply to it,
+// skipping those with @skip(if: true) or @include(if: false)
+func (e *graphQLExecutor) fields(selections []*graphQLSelection, typeName string) []*graphQLSelection {
+	var fields []*graphQLSelection
+	for _, selection := range selections {
+		if skip, _ := e.directiveArgument(selection.directives, "skip", "if").(bool); skip {
+			continue
+		}
+		if include, isBool := e.directiveArgument(selection.directives, "include", "if").(bool); isBool && !include {
+			continue
+		}
+		fragment := selection
+		if selection.fragmentName != "" {
+			if fragment = e.document.fragments[selection.fragmentName]; fragment == nil {
+				panic(ErrInvalidGraphQL{msg: fmt.Sprintf("Unknown fragment: %s", selection.fragmentName)})
+			}
+		}
+		if fragment.name != "" {
+			fields = append(fields, selection)
+		} else if graphQLTypeMatches(fragment.typeCondition, typeName) {
+			fields = append(fields, e.fields(fragment.selections, typeName)...)
+		}
+	}
+	return fields
+}
+
+// graphQLTypeMatches returns whether a fragment with a type condition applies to an object of a type (which
+// elements' fragments are assumed to, as their types aren't known)
+func graphQLTypeMatches(typeCondition, typeName string) bool {
+	switch typeCondition {
+	case "", typeName:
+		return true
+	case "Resource", "DomainResource":
+		return isGraphQLResourceType(typeName)
+	}
+	return typeName == "" && !isGraphQLResourceType(typeCondition)
+}
+
+func isGraphQLResourceType(name string) bool {
+	return search.SearchParameters()[name] != nil
+}
+
+func hasGraphQLDirective(directives []graphQLDirective, name string) bool {
+	for _, directive := range directives {
+		if directive.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *graphQLExecutor) directiveArgument(directives []graphQLDirective, directiveName, name string) interface{} {
+	for _, directive := range directives {
+		if directive.name == directiveName {
+			return e.argument(directive.arguments, name)
+		}
+	}
+	return nil
+}
+
+// argument returns the value of an argument (with its variables' values), or nil if there's no such argument
+func (e *graphQLExecutor) argument(arguments []graphQLArgument, name string) interface{} {
+	for _, argument := range arguments {
+		if argument.name == name {
+			return e.value(argument.value)
+		}
+	}
+	return nil
+}
+
+// value returns a value with its variables' values, and enums as strings
+func (e *graphQLExecutor) value(value interface{}) interface{} {
+	switch value := value.(type) {
+	case graphQLVariable:
+		return e.variables[string(value)]
+	case graphQLEnum:
+		return string(value)
+	case []interface{}:
+		values := make([]interface{}, len(value))
+		for i, item := range value {
+			values[i] = e.value(item)
+		}
+		return values
+	case graphQLObject:
+		object := make(map[string]interface{})
+		for _, field := range value {
+			object[field.name] = e.value(field.value)
+		}
+		return object
+	}
+	return value
+}
+
+// matchesArguments returns whether an item of a list has the values of a field's arguments (e.g. name(use: official))
+func (e *graphQLExecutor) matchesArguments(item interface{}, arguments []graphQLArgument) bool {
+	object, _ := item.(map[string]interface{})
+	for _, argument := range arguments {
+		if object == nil || graphQLString(object[argument.name]) != graphQLString(e.value(argument.value)) {
+			return false
+		}
+	}
+	return true
+}
+
+// graphQLString returns the string form of a value, for comparisons and search parameters
+func graphQLString(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case []interface{}:
+		values := make([]string, len(value))
+		for i, item := range value {
+			values[i] = graphQLString(item)
+		}
+		return strings.Join(values, ",")
+	}
+	return fmt.Sprint(value)
+}
+
+// search returns the resources of a type found by a search with a field's arguments as its parameters (with _
+// instead of -), and if reverse is given, the _reference argument as a search parameter referring to its resource
+func (e *graphQLExecutor) search(resourceType string, arguments []graphQLArgument, reverse *graphQLArgument) []map[string]interface{} {
+	params := search.URLQueryParameters{}
+	for _, argument := range arguments {
+		value := graphQLString(e.value(argument.value))
+		if reverse != nil && argument.name == "_reference" {
+			params.Add(strings.Replace(value, "_", "-", -1), reverse.name)
+			continue
+		}
+		name := argument.name
+		if strings.HasPrefix(name, "_") {
+			name = "_" + strings.Replace(name[1:], "_", "-", -1)
+		} else {
+			name = strings.Replace(name, "_", "-", -1)
+		}
+		params.Add(name, value)
+	}
+
+	bundle, err := e.session.Search(e.baseURL, search.Query{Resource: resourceType, Query: params.Encode()})
+	if err != nil {
+		panic(errors.Wrap(err, "GraphQL search failed"))
+	}
+	var resources []map[string]interface{}
+	for _, entry := range bundle.Entry {
+		if entry.Search != nil && entry.Search.Mode != "" && entry.Search.Mode != "match" {
+			continue
+		}
+		resources = append(resources, e.decode(entry.Resource))
+	}
+	return resources
+}
+
+// read returns a resource (or a version of it), or nil if it doesn't exist
+func (e *graphQLExecutor) read(resourceType, id, versionId string) map[string]interface{} {
+	key := resourceType + "/" + id
+	if versionId != "" {
+		key += "/_history/" + versionId
+	}
+	if resource, read := e.resources[key]; read {
+		return resource
+	}
+
+	var resource *models2.Resource
+	var err error
+	if versionId != "" {
+		resource, err = e.session.GetVersion(id, versionId, resourceType)
+	} else {
+		resource, err = e.session.Get(id, resourceType)
+	}
+	if err == ErrNotFound || err == ErrDeleted {
+		e.resources[key] = nil
+		return nil
+	} else if err != nil {
+		panic(errors.Wrapf(err, "failed to read %s", key))
+	}
+	e.resources[key] = e.decode(resource)
+	return e.resources[key]
+}
+
+var graphQLReference = regexp.MustCompile(`(?:^|/)([A-Z][A-Za-z]+)/([A-Za-z0-9\-.]{1,64})(?:/_history/([A-Za-z0-9\-.]{1,64}))?$`)
+
+// resolve returns the resource that a reference in a resource refers to (a contained resource, or one on this
+// server), or nil if it can't be resolved
+func (e *graphQLExecutor) resolve(reference string, resource map[string]interface{}) map[string]interface{} {
+	if strings.HasPrefix(reference, "#") {
+		contained, _ := resource["contained"].([]interface{})
+		for _, item := range contained {
+			if item, _ := item.(map[string]interface{}); item != nil && item["id"] == reference[1:] {
+				return item
+			}
+		}
+		return nil
+	}
+	match := graphQLReference.FindStringSubmatch(reference)
+	if match == nil || !isGraphQLResourceType(match[1]) {
+		return nil
+	}
+	return e.read(match[1], match[2], match[3])
+}
+
+func (e *graphQLExecutor) decode(resource *models2.Resource) map[string]interface{} {
+	jsonBytes, err := resource.MarshalJSON()
+	if err != nil {
+		panic(errors.Wrap(err, "failed to marshal a resource"))
+	}
+	var object map[string]interface{}
+	if err := decodeJSON(jsonBytes, &object); err != nil {
+		panic(errors.Wrap(err, "failed to decode a resource"))
+	}
+	return object
+}
@@ -0,0 +1,439 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A GraphQL document (https://spec.graphql.org/June2018/) is parsed into its operations and fragments.  Everything
+// but type system definitions (which FHIR GraphQL doesn't use) and block strings is supported.
+
+// graphQLDocument is a parsed GraphQL document
+type graphQLDocument struct {
+	operations []*graphQLOperation
+	fragments  map[string]*graphQLSelection
+}
+
+// graphQLOperation is a query, mutation or subscription
+type graphQLOperation struct {
+	opType     string
+	name       string
+	variables  []graphQLVariableDefinition
+	selections []*graphQLSelection
+}
+
+type graphQLVariableDefinition struct {
+	name         string
+	required     bool
+	defaultValue interface{}
+	hasDefault   bool
+}
+
+// graphQLSelection is a field (with a name), an inline fragment (with a type condition, or neither) or a fragment
+// spread (with a fragment name).  Fragment definitions are also inline fragments.
+type graphQLSelection struct {
+	alias         string
+	name          string
+	arguments     []graphQLArgument
+	directives    []graphQLDirective
+	selections    []*graphQLSelection
+	typeCondition string
+	fragmentName  string
+}
+
+// key returns the key of a field in results: its alias, or else its name
+func (s *graphQLSelection) key() string {
+	if s.alias != "" {
+		return s.alias
+	}
+	return s.name
+}
+
+type graphQLArgument struct {
+	name  string
+	value interface{}
+}
+
+type graphQLDirective struct {
+	name      string
+	arguments []graphQLArgument
+}
+
+// The values of arguments are strings, int64s, float64s, bools, nil, graphQLVariables, graphQLEnums,
+// []interface{}s and graphQLObjects
+type (
+	graphQLVariable string
+	graphQLEnum     string
+	graphQLObject   []graphQLArgument
+)
+
+// ErrInvalidGraphQL indicates that a GraphQL request can't be parsed or run (HTTP 400)
+type ErrInvalidGraphQL struct {
+	msg string
+}
+
+func (e ErrInvalidGraphQL) Error() string {
+	return e.msg
+}
+
+type graphQLToken struct {
+	kind  byte // 'n' (name), 'i' (int), 'f' (float), 's' (string), 'p' (punctuator) or 0 at the end
+	text  string
+	value interface{}
+	pos   int
+}
+
+type graphQLParser struct {
+	source string
+	pos    int
+	token  graphQLToken
+}
+
+// parseGraphQL parses a GraphQL document, returning an ErrInvalidGraphQL if it's invalid
+func parseGraphQL(source string) (document *graphQLDocument, err error) {
+	p := &graphQLParser{source: source}
+	defer func() {
+		if r := recover(); r != nil {
+			if invalid, ok := r.(ErrInvalidGraphQL); ok {
+				document, err = nil, invalid
+			} else {
+				panic(r)
+			}
+		}
+	}()
+
+	p.next()
+	document = &graphQLDocument{fragments: make(map[string]*graphQLSelection)}
+	for p.token.kind != 0 {
+		switch {
+		case p.isPunctuator("{"):
+			document.operations = append(document.operations, &graphQLOperation{opType: "query", selections: p.parseSelectionSet()})
+		case p.isName("query") || p.isName("mutation") || p.isName("subscription"):
+			document.operations = append(document.operations, p.parseOperation())
+		case p.isName("fragment"):
+			p.next()
+			name := p.expectName()
+			if name == "on" {
+				p.fail("a fragment can't be named on")
+			} else if document.fragments[name] != nil {
+				p.fail("there's more than one fragment named %s", name)
+			}
+			p.expectKeyword("on")
+			fragment := &graphQLSelection{typeCondition: p.expectName()}
+			fragment.directives = p.parseDirectives()
+			fragment.selections = p.parseSelectionSet()
+			document.fragments[name] = fragment
+		default:
+			p.fail("expected a query or fragment")
+		}
+	}
+	if len(document.operations) == 0 {
+		p.fail("the document has no operations")
+	}
+	return document, nil
+}
+
+func (p *graphQLParser) parseOperation() *graphQLOperation {
+	operation := &graphQLOperation{opType: p.expectName()}
+	if p.token.kind == 'n' {
+		operation.name = p.expectName()
+	}
+	if p.skipPunctuator("(") {
+		for !p.skipPunctuator(")") {
+			p.expectPunctuator("$")
+			definition := graphQLVariableDefinition{name: p.expectName()}
+			p.expectPunctuator(":")
+			definition.required = p.parseType()
+			if p.skipPunctuator("=") {
+				definition.defaultValue, definition.hasDefault = p.parseValue(true), true
+			}
+			operation.variables = append(operation.variables, definition)
+		}
+	}
+	p.parseDirectives()
+	operation.selections = p.parseSelectionSet()
+	return operation
+}
+
+// parseType parses the type of a variable, returning whether it's non-null (the types themselves aren't checked)
+func (p *graphQLParser) parseType() bool {
+	if p.skipPunctuator("[") {
+		p.parseType()
+		p.expectPunctuator("]")
+	} else {
+		p.expectName()
+	}
+	return p.skipPunctuator("!")
+}
+
+func (p *graphQLParser) parseSelectionSet() []*graphQLSelection {
+	p.expectPunctuator("{")
+	var selections []*graphQLSelection
+	for !p.skipPunctuator("}") {
+		selections = append(selections, p.parseSelection())
+	}
+	if len(selections) == 0 {
+		p.fail("empty selection set")
+	}
+	return selections
+}
+
+func (p *graphQLParser) parseSelection() *graphQLSelection {
+	selection := &graphQLSelection{}
+	if p.skipPunctuator("...") {
+		if p.isName("on") {
+			p.next()
+			selection.typeCondition = p.expectName()
+		} else if p.token.kind == 'n' {
+			selection.fragmentName = p.expectName()
+			selection.directives = p.parseDirectives()
+			return selection
+		}
+		selection.directives = p.parseDirectives()
+		selection.selections = p.parseSelectionSet()
+		return selection
+	}
+
+	selection.name = p.expectName()
+	if p.skipPunctuator(":") {
+		selection.alias, selection.name = selection.name, p.expectName()
+	}
+	if p.skipPunctuator("(") {
+		selection.arguments = p.parseArguments(false)
+	}
+	selection.directives = p.parseDirectives()
+	if p.isPunctuator("{") {
+		selection.selections = p.parseSelectionSet()
+	}
+	return selection
+}
+
+// parseArguments parses arguments after their opening parenthesis
+func (p *graphQLParser) parseArguments(constant bool) []graphQLArgument {
+	var arguments []graphQLArgument
+	for !p.skipPunctuator(")") {
+		name := p.expectName()
+		p.expectPunctuator(":")
+		arguments = append(arguments, graphQLArgument{name: name, value: p.parseValue(constant)})
+	}
+	return arguments
+}
+
+func (p *graphQLParser) parseDirectives() []graphQLDirective {
+	var directives []graphQLDirective
+	for p.skipPunctuator("@") {
+		directive := graphQLDirective{name: p.expectName()}
+		if p.skipPunctuator("(") {
+			directive.arguments = p.parseArguments(false)
+		}
+		directives = append(directives, directive)
+	}
+	return directives
+}
+
+// parseValue parses the value of an argument (or the default value of a variable, which is constant)
+func (p *graphQLParser) parseValue(constant bool) interface{} {
+	token := p.token
+	switch {
+	case token.kind == 'i' || token.kind == 'f' || token.kind == 's':
+		p.next()
+		return token.value
+	case token.kind == 'n':
+		p.next()
+		switch token.text {
+		case "true":
+			return true
+		case "false":
+			return false
+		case "null":
+			return nil
+		}
+		return graphQLEnum(token.text)
+	case p.isPunctuator("$") && !constant:
+		p.next()
+		return graphQLVariable(p.expectName())
+	case p.skipPunctuator("["):
+		list := []interface{}{}
+		for !p.skipPunctuator("]") {
+			list = append(list, p.parseValue(constant))
+		}
+		return list
+	case p.skipPunctuator("{"):
+		object := graphQLObject{}
+		for !p.skipPunctuator("}") {
+			name := p.expectName()
+			p.expectPunctuator(":")
+			object = append(object, graphQLArgument{name: name, value: p.parseValue(constant)})
+		}
+		return object
+	}
+	p.fail("expected a value")
+	return nil
+}
+
+func (p *graphQLParser) isPunctuator(punctuator string) bool {
+	return p.token.kind == 'p' && p.token.text == punctuator
+}
+
+func (p *graphQLParser) isName(name string) bool {
+	return p.token.kind == 'n' && p.token.text == name
+}
+
+func (p *graphQLParser) skipPunctuator(punctuator string) bool {
+	if p.isPunctuator(punctuator) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *graphQLParser) expectPunctuator(punctuator string) {
+	if !p.skipPunctuator(punctuator) {
+		p.fail("expected %s", punctuator)
+	}
+}
+
+func (p *graphQLParser) expectKeyword(keyword string) {
+	if !p.isName(keyword) {
+		p.fail("expected %s", keyword)
+	}
+	p.next()
+}
+
+func (p *graphQLParser) expectName() string {
+	if p.token.kind != 'n' {
+		p.fail("expected a name")
+	}
+	name := p.token.text
+	p.next()
+	return name
+}
+
+// fail panics with an ErrInvalidGraphQL at the current token
+func (p *graphQLParser) fail(format string, args ...interface{}) {
+	found := "the end"
+	if p.token.kind != 0 {
+		found = fmt.Sprintf("%q", p.token.text)
+	}
+	line := strings.Count(p.source[:p.token.pos], "\n") + 1
+	panic(ErrInvalidGraphQL{msg: fmt.Sprintf("Invalid GraphQL: %s at line %d, but found %s", fmt.Sprintf(format, args...), line, found)})
+}
+
+// next reads the next token, skipping whitespace, commas, comments and byte order marks
+func (p *graphQLParser) next() {
+	for p.pos < len(p.source) {
+		if ch := p.source[p.pos]; ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' || ch == ',' {
+			p.pos++
+		} else if ch == '#' {
+			for p.pos < len(p.source) && p.source[p.pos] != '\n' {
+				p.pos++
+			}
+		} else if strings.HasPrefix(p.source[p.pos:], "\uFEFF") {
+			p.pos += len("\uFEFF")
+		} else {
+			break
+		}
+	}
+	start := p.pos
+	p.token = graphQLToken{pos: start}
+	if start == len(p.source) {
+		return
+	}
+
+	ch := p.source[start]
+	switch {
+	case strings.HasPrefix(p.source[start:], "..."):
+		p.pos += 3
+		p.token.kind = 'p'
+	case strings.IndexByte("!$():=@[]{}|", ch) >= 0:
+		p.pos++
+		p.token.kind = 'p'
+	case ch == '_' || ch >= 'A' && ch <= 'Z' || ch >= 'a' && ch <= 'z':
+		for p.pos < len(p.source) && isGraphQLNameChar(p.source[p.pos]) {
+			p.pos++
+		}
+		p.token.kind = 'n'
+	case ch == '-' || ch >= '0' && ch <= '9':
+		p.scanNumber()
+	case ch == '"':
+		p.scanString()
+	default:
+		r, _ := utf8.DecodeRuneInString(p.source[start:])
+		p.token.text = string(r)
+		p.token.kind = 'p' // for the error message
+		p.fail("unexpected character")
+	}
+	p.token.text = p.source[start:p.pos]
+}
+
+func isGraphQLNameChar(ch byte) bool {
+	return ch == '_' || ch >= 'A' && ch <= 'Z' || ch >= 'a' && ch <= 'z' || ch >= '0' && ch <= '9'
+}
+
+func (p *graphQLParser) scanNumber() {
+	start := p.pos
+	digits := func() {
+		for p.pos < len(p.source) && p.source[p.pos] >= '0' && p.source[p.pos] <= '9' {
+			p.pos++
+		}
+	}
+	if p.source[p.pos] == '-' {
+		p.pos++
+	}
+	digits()
+	p.token.kind = 'i'
+	if p.pos < len(p.source) && p.source[p.pos] == '.' {
+		p.pos++
+		digits()
+		p.token.kind = 'f'
+	}
+	if p.pos < len(p.source) && (p.source[p.pos] == 'e' || p.source[p.pos] == 'E') {
+		p.pos++
+		if p.pos < len(p.source) && (p.source[p.pos] == '+' || p.source[p.pos] == '-') {
+			p.pos++
+		}
+		digits()
+		p.token.kind = 'f'
+	}
+
+	var err error
+	if p.token.kind == 'i' {
+		p.token.value, err = strconv.ParseInt(p.source[start:p.pos], 10, 64)
+	} else {
+		p.token.value, err = strconv.ParseFloat(p.source[start:p.pos], 64)
+	}
+	if err != nil {
+		p.token.text = p.source[start:p.pos]
+		p.fail("invalid number")
+	}
+}
+
+func (p *graphQLParser) scanString() {
+	start := p.pos
+	if strings.HasPrefix(p.source[start:], `"""`) {
+		p.fail("block strings aren't supported")
+	}
+	p.pos++
+	for p.pos < len(p.source) && p.source[p.pos] != '"' && p.source[p.pos] != '\n' {
+		if p.source[p.pos] == '\\' {
+			p.pos++
+		}
+		p.pos++
+	}
+	if p.pos >= len(p.source) || p.source[p.pos] != '"' {
+		p.fail("unterminated string")
+	}
+	p.pos++
+	p.token.kind = 's'
+
+	// GraphQL's escape sequences are JSON's
+	var value string
+	if err := json.Unmarshal([]byte(p.source[start:p.pos]), &value); err != nil {
+		p.token.text = p.source[start:p.pos]
+		p.fail("invalid string")
+	}
+	p.token.value = value
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *ServerSuite) TestGraphQLParser(c *C) {
+	document, err := parseGraphQL(`
+		# a comment
+		query Patients($name: String = "smith", $count: Int!) {
+			list: PatientList(name: $name, _count: $count) @flatten {
+				id, name(use: official) @first { given family }
+				...names
+				... on Patient @include(if: true) { gender }
+			}
+		}
+		fragment names on Patient { text: name { text } }`)
+	c.Assert(err, IsNil)
+	c.Assert(document.operations, HasLen, 1)
+	operation := document.operations[0]
+	c.Assert(operation.opType, Equals, "query")
+	c.Assert(operation.name, Equals, "Patients")
+	c.Assert(operation.variables, DeepEquals, []graphQLVariableDefinition{
+		{name: "name", defaultValue: "smith", hasDefault: true},
+		{name: "count", required: true},
+	})
+	c.Assert(operation.selections, HasLen, 1)
+	list := operation.selections[0]
+	c.Assert(list.key(), Equals, "list")
+	c.Assert(list.name, Equals, "PatientList")
+	c.Assert(list.arguments, DeepEquals, []graphQLArgument{
+		{name: "name", value: graphQLVariable("name")},
+		{name: "_count", value: graphQLVariable("count")},
+	})
+	c.Assert(list.directives, DeepEquals, []graphQLDirective{{name: "flatten"}})
+	c.Assert(list.selections, HasLen, 4)
+	c.Assert(list.selections[1].arguments, DeepEquals, []graphQLArgument{{name: "use", value: graphQLEnum("official")}})
+	c.Assert(list.selections[2].fragmentName, Equals, "names")
+	c.Assert(list.selections[3].typeCondition, Equals, "Patient")
+	c.Assert(document.fragments["names"].typeCondition, Equals, "Patient")
+
+	// Anonymous queries
+	document, err = parseGraphQL(`{ Patient(id: "123") { id } }`)
+	c.Assert(err, IsNil)
+	c.Assert(document.operations[0].opType, Equals, "query")
+
+	// Invalid documents
+	for _, invalid := range []string{
+		``,
+		`{ Patient(id: "123") { id }`,
+		`{ Patient(id: ) { id } }`,
+		`query Q($id) { Patient(id: $id) { id } }`,
+		`{ Patient(id: "123) { id } }`,
+		`fragment f on Patient { id }`,
+	} {
+		_, err := parseGraphQL(invalid)
+		c.Assert(err, FitsTypeOf, ErrInvalidGraphQL{}, Commentf(invalid))
+	}
+}
+
+func (s *ServerSuite) TestGraphQL(c *C) {
+	post := func(resourceType, body string) string {
+		res, err := http.Post(s.Server.URL+"/"+resourceType, "application/fhir+json", strings.NewReader(body))
+		util.CheckErr(err)
+		c.Assert(res.StatusCode, Equals, 201)
+		return resourceIdFromLocationStr(res.Header.Get("Location"))
+	}
+	practitionerId := post("Practitioner", `{"resourceType": "Practitioner", "name": [{"family": "Jones"}]}`)
+	patientId := post("Patient", `{"resourceType": "Patient", "gender": "female",
+		"name": [{"use": "official", "family": "Graphson", "given": ["Ann"]}, {"use": "nickname", "given": ["Annie"]}],
+		"generalPractitioner": [{"reference": "Practitioner/`+practitionerId+`"}]}`)
+	post("Condition", `{"resourceType": "Condition", "code": {"text": "asthma"}, "subject": {"reference": "Patient/`+patientId+`"}}`)
+
+	// query returns the status and JSON response of a query
+	query := func(method, path, contentType, body string) (int, map[string]interface{}) {
+		var res *http.Response
+		var err error
+		if method == "GET" {
+			res, err = http.Get(s.Server.URL + path)
+		} else {
+			res, err = http.Post(s.Server.URL+path, contentType, strings.NewReader(body))
+		}
+		util.CheckErr(err)
+		defer res.Body.Close()
+		var response map[string]interface{}
+		util.CheckErr(json.NewDecoder(res.Body).Decode(&response))
+		return res.StatusCode, response
+	}
+	assertData := func(response map[string]interface{}, expectedJSON string) {
+		var expected interface{}
+		util.CheckErr(json.Unmarshal([]byte(expectedJSON), &expected))
+		c.Assert(response["data"], DeepEquals, expected)
+	}
+
+	// Reads, with list filters, directives and references followed through resource fields
+	status, response := query("GET", "/$graphql?query="+url.QueryEscape(`{
+		Patient(id: "`+patientId+`") {
+			gender
+			official: name(use: official) @first { family }
+			generalPractitioner { resource { ... on Practitioner { name { family } } } }
+		}
+	}`), "", "")
+	c.Assert(status, Equals, 200)
+	c.Assert(response["errors"], IsNil)
+	assertData(response, `{"Patient": {"gender": "female", "official": {"family": "Graphson"},
+		"generalPractitioner": [{"resource": {"name": [{"family": "Jones"}]}}]}}`)
+
+	// Searches with variables, and the resources referring to them
+	status, response = query("POST", "/$graphql", "application/json", `{
+		"query": "query ($family: String) { PatientList(family: $family) { id ConditionList(_reference: subject) { code { text } } } }",
+		"variables": {"family": "Graphson"}
+	}`)
+	c.Assert(status, Equals, 200)
+	assertData(response, `{"PatientList": [{"id": "`+patientId+`", "ConditionList": [{"code": {"text": "asthma"}}]}]}`)
+
+	// Instance-level queries, with @flatten
+	status, response = query("POST", "/Patient/"+patientId+"/$graphql", "application/graphql",
+		`{ name @flatten { given } }`)
+	c.Assert(status, Equals, 200)
+	assertData(response, `{"given": [["Ann"], ["Annie"]]}`)
+	res, err := http.Get(s.Server.URL + "/Patient/" + bson.NewObjectId().Hex() + "/$graphql?query=" + url.QueryEscape(`{ id }`))
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, 404)
+
+	// Invalid queries
+	for _, invalid := range []string{
+		`{ Patient(id: "` + patientId + `") { id }`,
+		`mutation { PatientCreate(resource: {}) { id } }`,
+		`{ PatientConnection { count } }`,
+		`{ Patient { id } }`,
+		`query ($id: ID!) { Patient(id: $id) { id } }`,
+	} {
+		status, response = query("GET", "/$graphql?query="+url.QueryEscape(invalid), "", "")
+		c.Assert(status, Equals, 400, Commentf(invalid))
+		c.Assert(response["resourceType"], Equals, "OperationOutcome")
+	}
+}
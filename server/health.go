@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Health checks for the probes of Kubernetes (or load balancers): GET /health/live responds as long as the server is
+// handling requests, and GET /health/ready also checks that MongoDB is reachable, that it's a replica set (or sharded
+// cluster) supporting transactions, and whether migrations of databases are running.  Both respond with JSON in the
+// format of the draft "Health Check Response Format for HTTP APIs" (application/health+json), with a 503 Service
+// Unavailable if the server isn't ready.  They're registered before the auth middleware, so probes don't need
+// credentials.  The server only listens once the collections and indexes of the default database have been created.
+
+// HealthStatus is the status of the server, or of one of its checks
+type HealthStatus string
+
+const (
+	HealthPass HealthStatus = "pass"
+	// HealthWarn is the status of a server that is ready, but whose checks found something to look into
+	HealthWarn HealthStatus = "warn"
+	HealthFail HealthStatus = "fail"
+)
+
+// HealthCheck is the result of one of the checks of the readiness of the server
+type HealthCheck struct {
+	Status        HealthStatus `json:"status"`
+	ObservedValue interface{}  `json:"observedValue,omitempty"`
+	ObservedUnit  string       `json:"observedUnit,omitempty"`
+	Output        string       `json:"output,omitempty"`
+	Time          string       `json:"time"`
+}
+
+// Health is the response of the health endpoints, whose checks are keyed by their component and measurement (e.g.
+// "mongodb:responseTime")
+type Health struct {
+	Status HealthStatus             `json:"status"`
+	Checks map[string][]HealthCheck `json:"checks,omitempty"`
+}
+
+// readinessTimeout is how long the checks of readiness wait for MongoDB, e.g. to select a primary
+const readinessTimeout = 5 * time.Second
+
+// DatabaseStatus is the status of MongoDB that the readiness of the server depends on
+type DatabaseStatus struct {
+	// Latency is the round-trip time of a ping of the primary
+	Latency time.Duration
+	// ReplicaSet is the name of the replica set, if MongoDB is one
+	ReplicaSet string
+	// Sharded is whether the server is connected to the mongos of a sharded cluster
+	Sharded bool
+	// FeatureCompatibilityVersion is MongoDB's featureCompatibilityVersion (e.g. "4.2"), or empty if it can't be read
+	// (e.g. from mongos, or without the permissions to)
+	FeatureCompatibilityVersion string
+	// PendingMigrations are the migrations of databases that are running (see migrations)
+	PendingMigrations []string
+}
+
+// SupportsTransactions returns whether MongoDB supports multi-document transactions, which replica sets do from 4.0
+// and sharded clusters from 4.2
+func (s *DatabaseStatus) SupportsTransactions() bool {
+	if s.Sharded {
+		return s.FeatureCompatibilityVersion == "" || versionAtLeast(s.FeatureCompatibilityVersion, 4, 2)
+	} else if s.ReplicaSet != "" {
+		return s.FeatureCompatibilityVersion == "" || versionAtLeast(s.FeatureCompatibilityVersion, 4, 0)
+	}
+	return false
+}
+
+// versionAtLeast returns whether a major.minor version (e.g. "4.2") is at least another
+func versionAtLeast(version string, major, minor int) bool {
+	var versionMajor, versionMinor int
+	if _, err := fmt.Sscanf(version, "%d.%d", &versionMajor, &versionMinor); err != nil {
+		return false
+	}
+	return versionMajor > major || (versionMajor == major && versionMinor >= minor)
+}
+
+// migrations tracks the migrations of databases that the server runs while it's handling requests, i.e. creating the
+// collections and indexes of the databases of provisioned tenants.  They're reported as warnings by the readiness
+// checks rather than failures, as the server still handles the requests of the other databases.
+type migrations struct {
+	mutex   sync.Mutex
+	running map[string]int
+}
+
+// start records that a migration is running, returning the function that records that it's finished
+func (m *migrations) start(name string) (finish func()) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.running == nil {
+		m.running = make(map[string]int)
+	}
+	m.running[name]++
+	return func() {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		if m.running[name]--; m.running[name] == 0 {
+			delete(m.running, name)
+		}
+	}
+}
+
+// pending returns the names of the running migrations, sorted
+func (m *migrations) pending() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	names := make([]string, 0, len(m.running))
+	for name := range m.running {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LivenessHandler handles GET /health/live, which passes as long as the server is handling requests.  It doesn't
+// check MongoDB, as restarting the server wouldn't make MongoDB reachable.
+func LivenessHandler(c *gin.Context) {
+	c.Header("Content-Type", "application/health+json")
+	c.JSON(http.StatusOK, Health{Status: HealthPass})
+}
+
+// ReadinessHandler handles GET /health/ready, checking that MongoDB is reachable and supports transactions, and
+// whether migrations are running
+func ReadinessHandler(dal DataAccessLayer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer handlePanics(c)
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+		defer cancel()
+		session := dal.StartSession(ctx, "")
+		defer session.Finish()
+		status, err := session.CheckDatabase()
+		now := time.Now().UTC().Format(time.RFC3339)
+
+		health := Health{Status: HealthPass, Checks: make(map[string][]HealthCheck)}
+		check := func(name string, result HealthCheck) {
+			result.Time = now
+			health.Checks[name] = []HealthCheck{result}
+			if result.Status == HealthFail || (result.Status == HealthWarn && health.Status == HealthPass) {
+				health.Status = result.Status
+			}
+		}
+		if err != nil {
+			check("mongodb:connection", HealthCheck{Status: HealthFail, Output: err.Error()})
+		} else {
+			check("mongodb:connection", HealthCheck{Status: HealthPass})
+			check("mongodb:responseTime", HealthCheck{
+				Status:        HealthPass,
+				ObservedValue: float64(status.Latency) / float64(time.Millisecond),
+				ObservedUnit:  "ms",
+			})
+
+			transactions := HealthCheck{Status: HealthPass}
+			switch {
+			case status.Sharded:
+				transactions.ObservedValue = "sharded cluster"
+			case status.ReplicaSet != "":
+				transactions.ObservedValue = "replica set " + status.ReplicaSet
+			default:
+				transactions.ObservedValue = "standalone"
+			}
+			if !status.SupportsTransactions() {
+				transactions.Status = HealthFail
+				transactions.Output = "MongoDB doesn't support transactions, which need a replica set (of 4.0 or later) or a sharded cluster (of 4.2 or later)"
+				if status.FeatureCompatibilityVersion != "" {
+					transactions.Output += fmt.Sprintf(" (its featureCompatibilityVersion is %s)", status.FeatureCompatibilityVersion)
+				}
+			}
+			check("mongodb:transactions", transactions)
+
+			migrations := HealthCheck{Status: HealthPass, ObservedValue: len(status.PendingMigrations)}
+			if len(status.PendingMigrations) > 0 {
+				migrations.Status = HealthWarn
+				migrations.Output = fmt.Sprintf("Running migrations: %v", status.PendingMigrations)
+			}
+			check("migrations:pending", migrations)
+		}
+
+		statusCode := http.StatusOK
+		if health.Status == HealthFail {
+			statusCode = http.StatusServiceUnavailable
+		}
+		c.Header("Content-Type", "application/health+json")
+		c.Header("Cache-Control", "no-store")
+		c.JSON(statusCode, health)
+	}
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/eug48/fhir/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+func (s *ServerSuite) TestHealth(c *C) {
+	// Probes don't need credentials
+	config := DefaultConfig
+	config.Auth = auth.APIKeys()
+	config.CreateIndexes = false
+	dal := NewMongoDataAccessLayer(s.client, s.dbname, false, "", nil, config)
+	engine := gin.New()
+	RegisterRoutes(engine, make(map[string][]gin.HandlerFunc), dal, config)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	get := func(path string) (*http.Response, Health) {
+		res, err := http.Get(server.URL + path)
+		util.CheckErr(err)
+		defer res.Body.Close()
+		var health Health
+		util.CheckErr(json.NewDecoder(res.Body).Decode(&health))
+		return res, health
+	}
+
+	res, health := get("/health/live")
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	c.Assert(res.Header.Get("Content-Type"), Equals, "application/health+json")
+	c.Assert(health.Status, Equals, HealthPass)
+
+	// The tests' MongoDB is a replica set
+	res, health = get("/health/ready")
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	c.Assert(health.Status, Equals, HealthPass)
+	c.Assert(health.Checks["mongodb:connection"][0].Status, Equals, HealthPass)
+	c.Assert(health.Checks["mongodb:transactions"][0].Status, Equals, HealthPass)
+	c.Assert(health.Checks["migrations:pending"][0].ObservedValue, Equals, float64(0))
+
+	// Running migrations are warnings, as the server is still ready
+	finish := dal.(*mongoDataAccessLayer).migrations.start("database of tenant acme")
+	res, health = get("/health/ready")
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	c.Assert(health.Status, Equals, HealthWarn)
+	c.Assert(health.Checks["migrations:pending"][0].Output, Matches, ".*database of tenant acme.*")
+	finish()
+	_, health = get("/health/ready")
+	c.Assert(health.Status, Equals, HealthPass)
+}
+
+func (s *ServerSuite) TestDatabaseStatusSupportsTransactions(c *C) {
+	c.Assert((&DatabaseStatus{}).SupportsTransactions(), Equals, false)
+	c.Assert((&DatabaseStatus{ReplicaSet: "rs0"}).SupportsTransactions(), Equals, true)
+	c.Assert((&DatabaseStatus{ReplicaSet: "rs0", FeatureCompatibilityVersion: "3.6"}).SupportsTransactions(), Equals, false)
+	c.Assert((&DatabaseStatus{ReplicaSet: "rs0", FeatureCompatibilityVersion: "4.0"}).SupportsTransactions(), Equals, true)
+	c.Assert((&DatabaseStatus{Sharded: true, FeatureCompatibilityVersion: "4.0"}).SupportsTransactions(), Equals, false)
+	c.Assert((&DatabaseStatus{Sharded: true, FeatureCompatibilityVersion: "10.0"}).SupportsTransactions(), Equals, true)
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/eug48/fhir/models"
+	"github.com/eug48/fhir/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// History requests return the versions of a resource instance (GET /Patient/123/_history), of all the resources
+// of a type (GET /Patient/_history) or of all types (GET /_history), newest first.  Deletions are entries without
+// a resource and with a DELETE request.  The versions are paged with _count and _offset, and can be restricted
+// to those stored since an instant (_since) or that were current at a time (_at).
+
+// ParseHistoryOptions parses the parameters of a history request
+func ParseHistoryOptions(query url.Values) (options HistoryOptions, err error) {
+	options.query = query
+	if count := query.Get("_count"); count != "" {
+		if options.Count, err = strconv.Atoi(count); err != nil || options.Count < 1 {
+			return options, fmt.Errorf("Parameter \"_count\" content is invalid: %s", count)
+		}
+	}
+	if offset := query.Get("_offset"); offset != "" {
+		if options.Offset, err = strconv.Atoi(offset); err != nil || options.Offset < 0 {
+			return options, fmt.Errorf("Parameter \"_offset\" content is invalid: %s", offset)
+		}
+	}
+	if since := query.Get("_since"); since != "" {
+		date, err := utils.ParseDate(since)
+		if err != nil {
+			return options, fmt.Errorf("Parameter \"_since\" content is invalid: %s", since)
+		}
+		options.Since = date.RangeLowIncl()
+	}
+	if at := query.Get("_at"); at != "" {
+		if options.At, err = utils.ParseDate(at); err != nil {
+			return options, fmt.Errorf("Parameter \"_at\" content is invalid: %s", at)
+		}
+	}
+	return options, nil
+}
+
+// historyLinks returns the paging links of a page of a history
+func historyLinks(historyURL url.URL, query url.Values, offset int, count int, total int) []models.BundleLinkComponent {
+	link := func(relation string, offset int, count int) models.BundleLinkComponent {
+		params := url.Values{}
+		for key, values := range query {
+			params[key] = values
+		}
+		params.Set("_offset", strconv.Itoa(offset))
+		params.Set("_count", strconv.Itoa(count))
+		historyURL.RawQuery = params.Encode()
+		return models.BundleLinkComponent{Relation: relation, Url: historyURL.String()}
+	}
+
+	links := []models.BundleLinkComponent{link("self", offset, count), link("first", 0, count)}
+	if offset > 0 {
+		prevOffset := offset - count
+		if prevOffset < 0 {
+			prevOffset = 0
+		}
+		links = append(links, link("previous", prevOffset, offset-prevOffset))
+	}
+	if offset+count < total {
+		links = append(links, link("next", offset+count, count))
+	}
+	lastOffset := 0
+	if total > 0 {
+		lastOffset = (total - 1) / count * count
+	}
+	return append(links, link("last", lastOffset, count))
+}
+
+// HistoryHandler handles requests for the history of a resource instance
+func (rc *ResourceController) HistoryHandler(c *gin.Context) {
+	handleHistory(c, rc.DAL, rc.Config, rc.Name, c.Param("id"))
+}
+
+// TypeHistoryHandler handles requests for the history of all the resources of a type
+func (rc *ResourceController) TypeHistoryHandler(c *gin.Context) {
+	handleHistory(c, rc.DAL, rc.Config, rc.Name, "")
+}
+
+// SystemHistoryHandler handles requests for the history of all resources
+func SystemHistoryHandler(dal DataAccessLayer, config Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		handleHistory(c, dal, config, "", "")
+	}
+}
+
+func handleHistory(c *gin.Context, dal DataAccessLayer, config Config, resourceType string, id string) {
+	defer handlePanics(c)
+	c.Set("Action", "history")
+
+	options, err := ParseHistoryOptions(c.Request.URL.Query())
+	if err != nil {
+		oo := models.NewOperationOutcome("error", "invalid", err.Error())
+		c.Render(http.StatusBadRequest, CustomFhirRenderer{oo, c})
+		return
+	}
+
+	session := dal.StartSession(c.Request.Context(), c.GetHeader("Db"))
+	defer session.Finish()
+
+	baseURL := config.responseURL(c.Request)
+	bundle, err := session.History(*baseURL, resourceType, id, options)
+	if err == ErrNotFound {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		panic(errors.Wrap(err, "History request failed"))
+	}
+	c.Render(http.StatusOK, CustomFhirRenderer{bundle, c})
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/eug48/fhir/models"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// historyTestTime returns the current time in a form for _since and _at parameters, between two updates
+func historyTestTime() string {
+	time.Sleep(50 * time.Millisecond)
+	now := time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
+	time.Sleep(50 * time.Millisecond)
+	return now
+}
+
+func historyTestRequest(method string, url string, body string) *http.Response {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	util.CheckErr(err)
+	req.Header.Add("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	util.CheckErr(err)
+	return res
+}
+
+func (s *ServerSuite) TestInstanceHistory(c *C) {
+	res := historyTestRequest("POST", s.Server.URL+"/Patient", `{"resourceType": "Patient", "gender": "male"}`)
+	c.Assert(res.StatusCode, Equals, 201)
+	id := resourceIdFromLocation(res)
+	created := historyTestTime()
+
+	res = historyTestRequest("PUT", s.Server.URL+"/Patient/"+id, `{"resourceType": "Patient", "id": "`+id+`", "gender": "female"}`)
+	c.Assert(res.StatusCode, Equals, 200)
+	updated := historyTestTime()
+
+	res = historyTestRequest("DELETE", s.Server.URL+"/Patient/"+id, "")
+	c.Assert(res.StatusCode, Equals, 204)
+
+	historyURL := s.Server.URL + "/Patient/" + id + "/_history"
+	bundle := assertBundleCount(c, historyURL, 3, 3)
+	c.Assert(bundle.Type, Equals, "history")
+
+	// Newest first, with the deletion as an entry without a resource
+	deletion := bundle.Entry[0]
+	c.Assert(deletion.Resource, IsNil)
+	c.Assert(deletion.Request.Method, Equals, "DELETE")
+	c.Assert(deletion.Request.Url, Equals, "Patient/"+id)
+	c.Assert(deletion.Response.Status, Equals, "204")
+	c.Assert(deletion.Response.Etag, Equals, `W/"3"`)
+
+	update := bundle.Entry[1]
+	c.Assert(update.Request.Method, Equals, "PUT")
+	c.Assert(update.Response.Etag, Equals, `W/"2"`)
+	patient, ok := update.Resource.(*models.Patient)
+	c.Assert(ok, Equals, true)
+	c.Assert(patient.Gender, Equals, "female")
+	c.Assert(patient.Meta.VersionId, Equals, "2")
+
+	creation := bundle.Entry[2]
+	c.Assert(creation.Request.Method, Equals, "POST")
+	c.Assert(creation.Request.Url, Equals, "Patient")
+	c.Assert(creation.FullUrl, Equals, s.Server.URL+"/Patient/"+id)
+	patient, ok = creation.Resource.(*models.Patient)
+	c.Assert(ok, Equals, true)
+	c.Assert(patient.Gender, Equals, "male")
+
+	// Paging
+	bundle = assertBundleCount(c, historyURL+"?_count=2", 2, 3)
+	c.Assert(bundle.Entry[1].Response.Etag, Equals, `W/"2"`)
+	c.Assert(bundle.Link, HasLen, 4)
+	assertPagingLink(c, bundle.Link[0], "self", 2, 0)
+	assertPagingLink(c, bundle.Link[1], "first", 2, 0)
+	assertPagingLink(c, bundle.Link[2], "next", 2, 2)
+	assertPagingLink(c, bundle.Link[3], "last", 2, 2)
+
+	bundle = assertBundleCount(c, historyURL+"?_count=2&_offset=2", 1, 3)
+	c.Assert(bundle.Entry[0].Response.Etag, Equals, `W/"1"`)
+	assertPagingLink(c, bundle.Link[2], "previous", 2, 0)
+
+	// _since returns the versions stored since the time, and _at the versions current at it
+	bundle = assertBundleCount(c, historyURL+"?_since="+created, 2, 2)
+	c.Assert(bundle.Entry[1].Response.Etag, Equals, `W/"2"`)
+	bundle = assertBundleCount(c, historyURL+"?_since="+updated, 1, 1)
+	c.Assert(bundle.Entry[0].Request.Method, Equals, "DELETE")
+
+	bundle = assertBundleCount(c, historyURL+"?_at="+created, 1, 1)
+	c.Assert(bundle.Entry[0].Response.Etag, Equals, `W/"1"`)
+	bundle = assertBundleCount(c, historyURL+"?_at="+updated, 1, 1)
+	c.Assert(bundle.Entry[0].Response.Etag, Equals, `W/"2"`)
+	assertBundleCount(c, historyURL+"?_at=2000-01-01", 0, 0)
+
+	// Errors
+	res, err := http.Get(historyURL + "?_count=abc")
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, 400)
+	res, err = http.Get(historyURL + "?_since=yesterday")
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, 400)
+	res, err = http.Get(s.Server.URL + "/Patient/" + bson.NewObjectId().Hex() + "/_history")
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, 404)
+}
+
+func (s *ServerSuite) TestTypeAndSystemHistory(c *C) {
+	s.DB().C("basics").DropCollection()
+	s.DB().C("basics_prev").DropCollection()
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		res := historyTestRequest("POST", s.Server.URL+"/Basic", `{"resourceType": "Basic", "code": {"text": "test"}}`)
+		c.Assert(res.StatusCode, Equals, 201)
+		ids = append(ids, resourceIdFromLocation(res))
+		time.Sleep(10 * time.Millisecond) // so they're ordered by lastUpdated
+	}
+	res := historyTestRequest("DELETE", s.Server.URL+"/Basic/"+ids[1], "")
+	c.Assert(res.StatusCode, Equals, 204)
+
+	bundle := assertBundleCount(c, s.Server.URL+"/Basic/_history", 4, 4)
+	c.Assert(bundle.Entry[0].Request.Method, Equals, "DELETE")
+	c.Assert(bundle.Entry[0].Request.Url, Equals, "Basic/"+ids[1])
+	c.Assert(bundle.Entry[1].FullUrl, Equals, s.Server.URL+"/Basic/"+ids[2])
+	c.Assert(bundle.Entry[3].FullUrl, Equals, s.Server.URL+"/Basic/"+ids[0])
+
+	bundle = assertBundleCount(c, s.Server.URL+"/Basic/_history?_count=3&_offset=3", 1, 4)
+	assertPagingLink(c, bundle.Link[0], "self", 3, 3)
+	c.Assert(bundle.Link[0].Url, Matches, ".*/Basic/_history\\?.*")
+
+	// The newest versions of all types
+	bundle = performSearch(c, s.Server.URL+"/_history?_count=2")
+	c.Assert(bundle.Type, Equals, "history")
+	c.Assert(bundle.Entry, HasLen, 2)
+	c.Assert(*bundle.Total >= 4, Equals, true)
+	c.Assert(bundle.Entry[0].Request.Url, Equals, "Basic/"+ids[1])
+	c.Assert(bundle.Entry[1].Request.Url, Equals, "Basic")
+	c.Assert(bundle.Link[0].Url, Matches, ".*/_history\\?.*")
+}
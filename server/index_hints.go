@@ -0,0 +1,82 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/eug48/fhir/search"
+)
+
+// loadIndexHints reads an index_hints.conf file, each line of which pins the searches of a
+// resource using exactly the given search parameters (in any order) to an index, e.g.
+//
+//	Observation?code,date (code.coding.code_1, effectiveDateTime.__from_1)
+//	Observation?patient,code code.coding.code_1_code.coding.system_1
+//
+// Indexes are specified by their keys (in the compound format of indexes.conf) or by their name.
+func loadIndexHints(path string) (search.IndexHints, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hints := make(search.IndexHints)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		// Skip blank lines or lines with bash-style comments
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		resource, params, index, err := parseIndexHint(line)
+		if err != nil {
+			return nil, err
+		}
+		hints.Add(resource, params, index)
+	}
+	return hints, scanner.Err()
+}
+
+// parseIndexHint parses a line of an index_hints.conf file, of the format:
+// <resource>?<param>[,<param>...] <index_name>|(<key1>_(-)1, <key2>_(-)1, ...)
+func parseIndexHint(line string) (resource string, params []string, index interface{}, err error) {
+	fields := strings.SplitN(line, " ", 2)
+	if len(fields) < 2 {
+		return "", nil, nil, newParseIndexHintError(line, "Not of format <resource>?<params> <index>")
+	}
+
+	shape := strings.SplitN(fields[0], "?", 2)
+	if len(shape) < 2 {
+		return "", nil, nil, newParseIndexHintError(line, "Not of format <resource>?<params> <index>")
+	}
+	resource = shape[0]
+	if search.SearchParameters()[resource] == nil {
+		return "", nil, nil, newParseIndexHintError(line, "Unknown resource "+resource)
+	}
+	if shape[1] != "" {
+		params = strings.Split(shape[1], ",")
+	}
+
+	indexSpec := strings.TrimSpace(fields[1])
+	if strings.HasPrefix(indexSpec, "(") {
+		compoundIndex, err := parseCompoundIndex(indexSpec)
+		if err != nil {
+			return "", nil, nil, newParseIndexHintError(line, err.Error())
+		}
+		index = compoundIndex.Keys
+	} else if strings.ContainsAny(indexSpec, " \t") {
+		return "", nil, nil, newParseIndexHintError(line, "Index names can't contain spaces")
+	} else {
+		index = indexSpec
+	}
+	return resource, params, index, nil
+}
+
+func newParseIndexHintError(line, reason string) error {
+	return fmt.Errorf("Index hint '%s' is invalid: %s", line, reason)
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func (s *MongoIndexesTestSuite) TestParseIndexHintKeys() {
+	resource, params, index, err := parseIndexHint("Observation?code,date (code.coding.code_1, effectiveDateTime.__from_-1)")
+	s.Nil(err, "Should return without error")
+	s.Equal("Observation", resource)
+	s.Equal([]string{"code", "date"}, params)
+	s.Equal(bson.D{
+		{Key: "code.coding.code", Value: int32(1)},
+		{Key: "effectiveDateTime.__from", Value: int32(-1)},
+	}, index)
+}
+
+func (s *MongoIndexesTestSuite) TestParseIndexHintName() {
+	resource, params, index, err := parseIndexHint("Patient?gender gender_1")
+	s.Nil(err, "Should return without error")
+	s.Equal("Patient", resource)
+	s.Equal([]string{"gender"}, params)
+	s.Equal("gender_1", index)
+}
+
+func (s *MongoIndexesTestSuite) TestParseIndexHintErrors() {
+	_, _, _, err := parseIndexHint("Patient?gender")
+	s.Equal("Index hint 'Patient?gender' is invalid: Not of format <resource>?<params> <index>", err.Error())
+
+	_, _, _, err = parseIndexHint("Patient gender_1")
+	s.Equal("Index hint 'Patient gender_1' is invalid: Not of format <resource>?<params> <index>", err.Error())
+
+	_, _, _, err = parseIndexHint("Foo?bar bar_1")
+	s.Equal("Index hint 'Foo?bar bar_1' is invalid: Unknown resource Foo", err.Error())
+
+	_, _, _, err = parseIndexHint("Patient?gender (gender)")
+	s.Equal("Index hint 'Patient?gender (gender)' is invalid: Compound key sub-key not of format: <key>_(-)1", err.Error())
+
+	_, _, _, err = parseIndexHint("Patient?gender gender 1")
+	s.Equal("Index hint 'Patient?gender gender 1' is invalid: Index names can't contain spaces", err.Error())
+}
@@ -1,9 +1,12 @@
 package server
 
 import (
+	"fmt"
+	"mime"
 	"net/http"
 	"strings"
 
+	"github.com/eug48/fhir/models"
 	"github.com/gin-gonic/gin"
 )
 
@@ -19,22 +22,26 @@ func EnableXmlToJsonConversionMiddleware() gin.HandlerFunc {
 // other than JSON (or a JSON flavor) with a 406 Not Acceptable status.
 func AbortNonJSONRequestsMiddleware(c *gin.Context) {
 	acceptHeader := c.Request.Header.Get("Accept")
-	formatOption := c.DefaultQuery("_format", "")
+	formatOption := formatQueryParameter(c)
 	hasJSON := hasJsonMimeType(acceptHeader, formatOption) > 0 || strings.Contains(acceptHeader, "json") // allowing non-FHIR MIME types as per previous version
-	if acceptHeader != "" && !hasJSON && !strings.Contains(acceptHeader, "*/*") {
+	if formatOption != "" && hasJsonMimeType("", formatOption) == 0 {
+		c.AbortWithStatus(http.StatusNotAcceptable)
+	} else if acceptHeader != "" && !hasJSON && !strings.Contains(acceptHeader, "*/*") {
 		c.AbortWithStatus(http.StatusNotAcceptable)
 	}
 	c.Next()
 }
 
 // AbortNonFhirXMLorJSONRequestsMiddleware is middleware that responds to any request that Accepts a Content-Type
-// other than FHIR JSON or XML with a 406 Not Acceptable status.
+// other than FHIR JSON or XML (or NDJSON, for the files of bulk exports) with a 406 Not Acceptable status.
 func AbortNonFhirXMLorJSONRequestsMiddleware(c *gin.Context) {
 	acceptHeader := c.Request.Header.Get("Accept")
-	formatOption := c.DefaultQuery("_format", "")
+	formatOption := formatQueryParameter(c)
 	hasJSON := hasJsonMimeType(acceptHeader, formatOption)
 	hasXML := hasXmlMimeType(acceptHeader, formatOption)
-	if acceptHeader != "" && hasXML == 0 && hasJSON == 0 && !strings.Contains(acceptHeader, "*/*") {
+	if formatOption != "" && hasXML < 2 && hasJSON < 2 {
+		c.AbortWithStatus(http.StatusNotAcceptable)
+	} else if acceptHeader != "" && hasXML == 0 && hasJSON == 0 && !strings.Contains(acceptHeader, "*/*") && !strings.Contains(acceptHeader, "ndjson") {
 		c.AbortWithStatus(http.StatusNotAcceptable)
 	}
 	if hasXML > hasJSON { // integer comparison so that _format overrides an Accept header
@@ -43,10 +50,16 @@ func AbortNonFhirXMLorJSONRequestsMiddleware(c *gin.Context) {
 	c.Next()
 }
 
+// formatQueryParameter returns the _format parameter of a request, which is either json or xml, or a MIME type
+func formatQueryParameter(c *gin.Context) string {
+	// a + in a MIME type that isn't escaped is decoded as a space
+	return strings.Replace(c.Query("_format"), " ", "+", -1)
+}
+
 func hasJsonMimeType(acceptHeader string, formatOption string) int {
 	// _format overrides the Accept header according to the spec
 	switch formatOption {
-	case "json", "text/json", "application/json", "application/fhir+json":
+	case "json", "text/json", "application/json", "application/json+fhir", "application/fhir+json":
 		return 2
 	}
 	if strings.Contains(acceptHeader, "application/fhir+json") || strings.Contains(acceptHeader, "application/json+fhir") {
@@ -58,14 +71,55 @@ func hasJsonMimeType(acceptHeader string, formatOption string) int {
 func hasXmlMimeType(acceptHeader string, formatOption string) int {
 	// _format overrides the Accept header according to the spec
 	switch formatOption {
-	case "xml", "text/xml", "application/xml", "application/fhir+xml":
+	case "xml", "text/xml", "application/xml", "application/xml+fhir", "application/fhir+xml":
 		return 2
 	}
 	if strings.Contains(acceptHeader, "application/fhir+xml") || strings.Contains(acceptHeader, "application/xml+fhir") {
 		return 1
-	} else {
-		return 0
 	}
+	// plain XML types too, as legacy integration engines send them, but not from browsers that also accept HTML
+	if (strings.Contains(acceptHeader, "application/xml") || strings.Contains(acceptHeader, "text/xml")) && !strings.Contains(acceptHeader, "text/html") {
+		return 1
+	}
+	return 0
+}
+
+// AbortUnsupportedFhirVersionRequestsMiddleware is middleware that responds to requests for other versions of FHIR,
+// which are specified with the fhirVersion parameter of MIME types.  Requests that only Accept other versions get a
+// 406 Not Acceptable status, and those with content in other versions a 415 Unsupported Media Type status.
+func AbortUnsupportedFhirVersionRequestsMiddleware(c *gin.Context) {
+	if version, specified := mimeTypeFhirVersion(c.Request.Header.Get("Content-Type")); specified && !supportsFhirVersion(version) {
+		outcome := models.NewOperationOutcome("fatal", "not-supported", fmt.Sprintf("FHIR version %s is not supported (supported: %s)", version, fhirMimeTypeVersion))
+		c.AbortWithStatusJSON(http.StatusUnsupportedMediaType, outcome)
+		return
+	}
+
+	acceptHeader := c.Request.Header.Get("Accept")
+	if acceptHeader != "" {
+		acceptable := false
+		for _, mediaRange := range strings.Split(acceptHeader, ",") {
+			if version, specified := mimeTypeFhirVersion(mediaRange); !specified || supportsFhirVersion(version) {
+				acceptable = true
+				break
+			}
+		}
+		if !acceptable {
+			outcome := models.NewOperationOutcome("fatal", "not-supported", fmt.Sprintf("None of the accepted FHIR versions are supported (supported: %s)", fhirMimeTypeVersion))
+			c.AbortWithStatusJSON(http.StatusNotAcceptable, outcome)
+			return
+		}
+	}
+	c.Next()
+}
+
+// mimeTypeFhirVersion returns the fhirVersion parameter of a MIME type, e.g. application/fhir+json; fhirVersion=3.0
+func mimeTypeFhirVersion(mimeType string) (version string, specified bool) {
+	_, params, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return "", false
+	}
+	version, specified = params["fhirversion"] // parameter names are lowercased
+	return
 }
 
 // ReadOnlyMiddleware makes the API read-only and responds to any requests that are not
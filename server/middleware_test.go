@@ -2,23 +2,25 @@ package server
 
 import (
 	"context"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 
 	"gopkg.in/mgo.v2/dbtest"
 
 	"github.com/gin-gonic/gin"
-	mongowrapper "github.com/opencensus-integrations/gomongowrapper"
 	"github.com/stretchr/testify/suite"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type MiddlewareTestSuite struct {
 	suite.Suite
 	DBServer *dbtest.DBServer
-	client   *mongowrapper.WrappedClient
+	client   *mongo.Client
 	dbname   string
 }
 
@@ -42,7 +44,7 @@ func (m *MiddlewareTestSuite) SetupSuite() {
 	mgoSession := m.DBServer.Session()
 	defer mgoSession.Close()
 	serverUri := mgoSession.LiveServers()[0]
-	m.client, err = mongowrapper.Connect(context.TODO(), options.Client().ApplyURI("mongodb://"+serverUri))
+	m.client, err = mongo.Connect(context.TODO(), options.Client().ApplyURI("mongodb://"+serverUri))
 	m.dbname = "fhir-test"
 	if err != nil {
 		panic(err)
@@ -86,6 +88,158 @@ func (m *MiddlewareTestSuite) TestRejectXML() {
 	m.Equal(http.StatusNotAcceptable, resp.StatusCode)
 }
 
+func (m *MiddlewareTestSuite) TestXMLContentNegotiation() {
+	e := gin.New()
+	e.Use(EnableXmlToJsonConversionMiddleware())
+	e.Use(AbortNonFhirXMLorJSONRequestsMiddleware)
+	RegisterRoutes(e, nil, NewMongoDataAccessLayer(m.client, m.dbname, true, "", nil, DefaultConfig), DefaultConfig)
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	do := func(method string, url string, contentType string, accept string, body string) (*http.Response, string) {
+		req, err := http.NewRequest(method, url, strings.NewReader(body))
+		m.NoError(err)
+		if contentType != "" {
+			req.Header.Add("Content-Type", contentType)
+		}
+		if accept != "" {
+			req.Header.Add("Accept", accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		m.NoError(err)
+		defer resp.Body.Close()
+		respBody, err := ioutil.ReadAll(resp.Body)
+		m.NoError(err)
+		return resp, string(respBody)
+	}
+
+	// Create from XML, replying with XML
+	resp, body := do("POST", server.URL+"/Patient", "application/xml", "application/fhir+xml",
+		`<Patient xmlns="http://hl7.org/fhir"><gender value="male"/></Patient>`)
+	m.Equal(http.StatusCreated, resp.StatusCode)
+	m.Equal("application/fhir+xml; charset=utf-8", resp.Header.Get("Content-Type"))
+	m.Contains(body, `<gender value="male"/>`)
+	location := resp.Header.Get("Location")
+	patientURL := location[:strings.Index(location, "/_history")]
+	id := patientURL[strings.LastIndex(patientURL, "/")+1:]
+
+	// Update from XML, replying with JSON
+	resp, body = do("PUT", patientURL, "text/xml", "",
+		`<Patient xmlns="http://hl7.org/fhir"><id value="`+id+`"/><gender value="female"/></Patient>`)
+	m.Equal(http.StatusOK, resp.StatusCode)
+	m.Equal("application/fhir+json; charset=utf-8", resp.Header.Get("Content-Type"))
+	m.Contains(body, `"gender":"female"`)
+
+	// Reads requested with _format or the Accept header
+	resp, body = do("GET", patientURL+"?_format=xml", "", "application/fhir+json", "")
+	m.Equal("application/fhir+xml; charset=utf-8", resp.Header.Get("Content-Type"))
+	m.Contains(body, `<gender value="female"/>`)
+	resp, body = do("GET", patientURL, "", "application/xml", "")
+	m.Equal("application/fhir+xml; charset=utf-8", resp.Header.Get("Content-Type"))
+	m.Contains(body, `<id value="`+id+`"/>`)
+
+	// Browsers get JSON
+	resp, _ = do("GET", patientURL, "", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", "")
+	m.Equal("application/fhir+json; charset=utf-8", resp.Header.Get("Content-Type"))
+
+	// Searches
+	resp, body = do("GET", server.URL+"/Patient?_id="+id, "", "application/fhir+xml", "")
+	m.Equal(http.StatusOK, resp.StatusCode)
+	m.Equal("application/fhir+xml; charset=utf-8", resp.Header.Get("Content-Type"))
+	m.Contains(body, "<Bundle")
+	m.Contains(body, `<gender value="female"/>`)
+}
+
+func (m *MiddlewareTestSuite) TestFormatAndPretty() {
+	e := gin.New()
+	e.Use(EnableXmlToJsonConversionMiddleware())
+	e.Use(AbortNonFhirXMLorJSONRequestsMiddleware)
+	RegisterRoutes(e, nil, NewMongoDataAccessLayer(m.client, m.dbname, true, "", nil, DefaultConfig), DefaultConfig)
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	get := func(url string) (*http.Response, string) {
+		resp, err := http.Get(url)
+		m.NoError(err)
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		m.NoError(err)
+		return resp, string(body)
+	}
+
+	resp, err := http.Post(server.URL+"/Patient", "application/fhir+json", strings.NewReader(`{"resourceType": "Patient", "gender": "male"}`))
+	m.NoError(err)
+	m.Equal(http.StatusCreated, resp.StatusCode)
+	location := resp.Header.Get("Location")
+	patientURL := location[:strings.Index(location, "/_history")]
+
+	// MIME types with an unescaped +
+	resp, body := get(patientURL + "?_format=application/fhir+xml")
+	m.Equal("application/fhir+xml; charset=utf-8", resp.Header.Get("Content-Type"))
+	m.Contains(body, `<gender value="male"/>`)
+	resp, body = get(server.URL + "/Patient?gender=male&_format=application/fhir+json")
+	m.Equal(http.StatusOK, resp.StatusCode)
+	m.Equal("application/fhir+json; charset=utf-8", resp.Header.Get("Content-Type"))
+	m.Contains(body, "_format=application%2Ffhir%2Bjson")
+
+	resp, _ = get(patientURL + "?_format=ttl")
+	m.Equal(http.StatusNotAcceptable, resp.StatusCode)
+
+	// Pretty-printed
+	_, body = get(patientURL + "?_pretty=true")
+	m.Contains(body, "\n  \"gender\": \"male\"")
+	_, body = get(patientURL + "?_format=xml&_pretty=true")
+	m.Contains(body, "<Patient xmlns=\"http://hl7.org/fhir\">\n  <id value=")
+	_, body = get(patientURL)
+	m.NotContains(body, "\n")
+	resp, body = get(server.URL + "/Patient?gender=male&_pretty=true")
+	m.Equal(http.StatusOK, resp.StatusCode)
+	m.Contains(body, "_pretty=true")
+	m.Contains(body, "\n  \"type\": \"searchset\"")
+
+	// JSON-only servers reject requests for XML
+	e = gin.New()
+	e.Use(AbortNonJSONRequestsMiddleware)
+	RegisterRoutes(e, nil, NewMongoDataAccessLayer(m.client, m.dbname, true, "", nil, DefaultConfig), DefaultConfig)
+	jsonServer := httptest.NewServer(e)
+	defer jsonServer.Close()
+	resp, _ = get(jsonServer.URL + "/Patient?_format=xml")
+	m.Equal(http.StatusNotAcceptable, resp.StatusCode)
+	resp, _ = get(jsonServer.URL + "/Patient?_format=json")
+	m.Equal(http.StatusOK, resp.StatusCode)
+}
+
+func (m *MiddlewareTestSuite) TestFhirVersionNegotiation() {
+	e := gin.New()
+	e.Use(AbortUnsupportedFhirVersionRequestsMiddleware)
+	RegisterRoutes(e, nil, NewMongoDataAccessLayer(m.client, m.dbname, true, "", nil, DefaultConfig), DefaultConfig)
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	do := func(method string, url string, contentType string, accept string) int {
+		req, err := http.NewRequest(method, url, strings.NewReader(`{"resourceType": "Patient"}`))
+		m.NoError(err)
+		if contentType != "" {
+			req.Header.Add("Content-Type", contentType)
+		}
+		if accept != "" {
+			req.Header.Add("Accept", accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		m.NoError(err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	m.Equal(http.StatusOK, do("GET", server.URL+"/Patient", "", "application/fhir+json; fhirVersion=3.0"))
+	m.Equal(http.StatusOK, do("GET", server.URL+"/Patient", "", "application/fhir+json; fhirVersion=4.0, application/fhir+json; fhirVersion=3.0; q=0.9"))
+	m.Equal(http.StatusOK, do("GET", server.URL+"/Patient", "", "application/fhir+json; fhirVersion=4.0, */*"))
+	m.Equal(http.StatusNotAcceptable, do("GET", server.URL+"/Patient", "", "application/fhir+json; fhirVersion=4.0"))
+
+	m.Equal(http.StatusCreated, do("POST", server.URL+"/Patient", "application/fhir+json; fhirVersion=3.0.1", ""))
+	m.Equal(http.StatusUnsupportedMediaType, do("POST", server.URL+"/Patient", "application/fhir+json; fhirVersion=4.0", ""))
+}
+
 func (m *MiddlewareTestSuite) TestReadOnlyMode() {
 	e := gin.New()
 	e.Use(ReadOnlyMiddleware)
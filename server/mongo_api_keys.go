@@ -0,0 +1,51 @@
+package server
+
+import (
+	"github.com/eug48/fhir/auth"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// APIKeys returns every API key, by name
+func (ms *mongoSession) APIKeys() ([]auth.APIKey, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := ms.db.Collection(APIKeysCollection).Find(ms.context, bson.M{}, opts)
+	if err != nil {
+		return nil, errors.Wrap(convertMongoErr(err), "failed to find the API keys")
+	}
+	defer cursor.Close(ms.context)
+
+	apiKeys := []auth.APIKey{}
+	if err := cursor.All(ms.context, &apiKeys); err != nil {
+		return nil, errors.Wrap(convertMongoErr(err), "failed to read the API keys")
+	}
+	return apiKeys, nil
+}
+
+// GetAPIKeyByHash returns the API key with a hash, or ErrNotFound if there's no such key
+func (ms *mongoSession) GetAPIKeyByHash(hash string) (*auth.APIKey, error) {
+	var apiKey auth.APIKey
+	err := ms.db.Collection(APIKeysCollection).FindOne(ms.context, bson.M{"hash": hash}).Decode(&apiKey)
+	if err = convertMongoErr(err); err != nil && err != ErrNotFound {
+		return nil, errors.Wrap(err, "failed to get the API key")
+	}
+	return &apiKey, err
+}
+
+// CreateAPIKey stores an API key
+func (ms *mongoSession) CreateAPIKey(apiKey *auth.APIKey) error {
+	_, err := ms.db.Collection(APIKeysCollection).InsertOne(ms.context, apiKey)
+	return errors.Wrap(convertMongoErr(err), "failed to store the API key")
+}
+
+// RevokeAPIKey deletes an API key, or returns ErrNotFound if there's no such key
+func (ms *mongoSession) RevokeAPIKey(id string) error {
+	result, err := ms.db.Collection(APIKeysCollection).DeleteOne(ms.context, bson.M{"_id": id})
+	if err != nil {
+		return errors.Wrap(convertMongoErr(err), "failed to delete the API key")
+	} else if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnqueueAsyncJob queues a request to be run asynchronously
+func (ms *mongoSession) EnqueueAsyncJob(job *AsyncJob) error {
+	_, err := ms.db.Collection(AsyncJobsCollection).InsertOne(ms.context, job)
+	return errors.Wrap(convertMongoErr(err), "failed to queue the job")
+}
+
+// ClaimAsyncJob returns the job queued first, or a running one whose lease has expired (as its server may have
+// stopped), marking it as running until its lease expires.  It returns nil if there are no such jobs.
+func (ms *mongoSession) ClaimAsyncJob(lease time.Duration) (*AsyncJob, error) {
+	now := time.Now()
+	filter := bson.M{"$or": bson.A{
+		bson.M{"status": AsyncJobQueued},
+		bson.M{"status": AsyncJobRunning, "leaseExpires": bson.M{"$lt": now}},
+	}}
+	update := bson.M{"$set": bson.M{"status": AsyncJobRunning, "leaseExpires": now.Add(lease)}}
+	opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "created", Value: 1}}).SetReturnDocument(options.After)
+
+	var job AsyncJob
+	err := ms.db.Collection(AsyncJobsCollection).FindOneAndUpdate(ms.context, filter, update, opts).Decode(&job)
+	if err = convertMongoErr(err); err == ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to claim a job")
+	}
+	return &job, nil
+}
+
+// ExtendAsyncJobLease extends the lease of a running job, returning ErrNotFound if it's been deleted
+func (ms *mongoSession) ExtendAsyncJobLease(id string, lease time.Duration) error {
+	filter := bson.M{"_id": id, "status": AsyncJobRunning}
+	update := bson.M{"$set": bson.M{"leaseExpires": time.Now().Add(lease)}}
+	result, err := ms.db.Collection(AsyncJobsCollection).UpdateOne(ms.context, filter, update)
+	if err != nil {
+		return errors.Wrap(convertMongoErr(err), "failed to extend the lease of the job")
+	} else if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetAsyncJob returns a job, or ErrNotFound if there's no such job (or it's expired)
+func (ms *mongoSession) GetAsyncJob(id string) (*AsyncJob, error) {
+	var job AsyncJob
+	err := ms.db.Collection(AsyncJobsCollection).FindOne(ms.context, bson.M{"_id": id}).Decode(&job)
+	if err = convertMongoErr(err); err == ErrNotFound {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to get the job")
+	}
+	return &job, nil
+}
+
+// FinishAsyncJob stores a job that's completed or failed, returning ErrNotFound if it's been deleted
+func (ms *mongoSession) FinishAsyncJob(job *AsyncJob) error {
+	result, err := ms.db.Collection(AsyncJobsCollection).ReplaceOne(ms.context, bson.M{"_id": job.Id}, job)
+	if err != nil {
+		return errors.Wrap(convertMongoErr(err), "failed to store the job")
+	} else if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteAsyncJob deletes a job of an owner, returning ErrNotFound if there's no such job
+func (ms *mongoSession) DeleteAsyncJob(id string, owner string) error {
+	filter := bson.M{"_id": id}
+	if owner != "" {
+		filter["owner"] = owner
+	} else {
+		filter["owner"] = bson.M{"$exists": false}
+	}
+	result, err := ms.db.Collection(AsyncJobsCollection).DeleteOne(ms.context, filter)
+	if err != nil {
+		return errors.Wrap(convertMongoErr(err), "failed to delete the job")
+	} else if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
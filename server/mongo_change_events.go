@@ -0,0 +1,142 @@
+package server
+
+import (
+	"time"
+
+	"github.com/eug48/fhir/models2"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// recordChangeEvent records the change event of a created or updated resource in the outbox (if change events are
+// enabled), with its contents if payloads are enabled.  Changes made in a transaction have their events recorded in
+// it, so that they're only published once it's committed.
+func (ms *mongoSession) recordChangeEvent(action string, resource *models2.Resource) error {
+	if ms.dal.changeEvents == nil {
+		return nil
+	}
+	event := ms.newChangeEvent(action, resource.ResourceType(), resource.Id(), resource.VersionId())
+	if ms.dal.changeEvents.payloads {
+		jsonBytes, err := resource.MarshalJSON()
+		if err != nil {
+			return errors.Wrap(err, "recordChangeEvent: MarshalJSON failed")
+		}
+		event.Resource = jsonBytes
+	}
+	return ms.insertChangeEvents([]interface{}{event})
+}
+
+// recordDeletionEvents records the change events of deleted resources in the outbox (if change events are enabled),
+// with the versions of their deletions (if known)
+func (ms *mongoSession) recordDeletionEvents(resourceType string, ids []string, versionId string) error {
+	if ms.dal.changeEvents == nil || len(ids) == 0 {
+		return nil
+	}
+	events := make([]interface{}, len(ids))
+	for i, id := range ids {
+		events[i] = ms.newChangeEvent(ChangeEventDelete, resourceType, id, versionId)
+	}
+	return ms.insertChangeEvents(events)
+}
+
+// recordConditionalDeletionEvents records the change events of the resources that a conditional delete deleted, of
+// those it tried to, whose deletions' versions aren't known
+func (ms *mongoSession) recordConditionalDeletionEvents(resourceType string, ids []string) error {
+	if ms.dal.changeEvents == nil {
+		return nil
+	}
+	opts := options.Find().SetProjection(bson.M{"_id": 1})
+	cursor, err := ms.CurrentVersionCollection(resourceType).Find(ms.context, bson.M{"_id": bson.M{"$in": ids}}, opts)
+	if err != nil {
+		return errors.Wrap(convertMongoErr(err), "recordConditionalDeletionEvents: Find failed")
+	}
+	defer cursor.Close(ms.context)
+	notDeleted := make(map[string]bool)
+	for cursor.Next(ms.context) {
+		var doc struct {
+			Id string `bson:"_id"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return errors.Wrap(err, "recordConditionalDeletionEvents: Decode failed")
+		}
+		notDeleted[doc.Id] = true
+	}
+	if err := cursor.Err(); err != nil {
+		return errors.Wrap(err, "recordConditionalDeletionEvents: cursor failed")
+	}
+	var deleted []string
+	for _, id := range ids {
+		if !notDeleted[id] {
+			deleted = append(deleted, id)
+		}
+	}
+	return ms.recordDeletionEvents(resourceType, deleted, "")
+}
+
+func (ms *mongoSession) newChangeEvent(action, resourceType, id, versionId string) *ChangeEvent {
+	return &ChangeEvent{
+		Id:           uuid.New().String(),
+		Action:       action,
+		Database:     ms.dbName,
+		ResourceType: resourceType,
+		ResourceId:   id,
+		VersionId:    versionId,
+		Timestamp:    time.Now(),
+	}
+}
+
+func (ms *mongoSession) insertChangeEvents(events []interface{}) error {
+	if _, err := ms.db.Collection(ChangeEventsCollection).InsertMany(ms.context, events); err != nil {
+		return errors.Wrap(convertMongoErr(err), "failed to record the change events")
+	}
+	if ms.inTransaction {
+		ms.recordedChangeEvents = true
+	} else {
+		ms.dal.changeEvents.eventsRecorded(ms.dbName)
+	}
+	return nil
+}
+
+// claimChangeEvents returns up to limit of the oldest change events in the outbox that aren't claimed by another
+// server, claiming them until their lease expires
+func (ms *mongoSession) claimChangeEvents(limit int, lease time.Duration) ([]*ChangeEvent, error) {
+	var events []*ChangeEvent
+	opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "timestamp", Value: 1}}).SetReturnDocument(options.After)
+	for len(events) < limit {
+		now := time.Now()
+		filter := bson.M{"leaseExpires": bson.M{"$lt": now}}
+		update := bson.M{"$set": bson.M{"leaseExpires": now.Add(lease)}}
+		var event ChangeEvent
+		err := ms.db.Collection(ChangeEventsCollection).FindOneAndUpdate(ms.context, filter, update, opts).Decode(&event)
+		if err = convertMongoErr(err); err == ErrNotFound {
+			break
+		} else if err != nil {
+			return nil, errors.Wrap(err, "failed to claim a change event")
+		}
+		events = append(events, &event)
+	}
+	return events, nil
+}
+
+// releaseChangeEvents releases the claims of change events that weren't published, so that they can be claimed again
+func (ms *mongoSession) releaseChangeEvents(events []*ChangeEvent) error {
+	update := bson.M{"$set": bson.M{"leaseExpires": time.Time{}}}
+	_, err := ms.db.Collection(ChangeEventsCollection).UpdateMany(ms.context, changeEventsFilter(events), update)
+	return errors.Wrap(convertMongoErr(err), "failed to release the change events")
+}
+
+// deleteChangeEvents deletes published change events from the outbox
+func (ms *mongoSession) deleteChangeEvents(events []*ChangeEvent) error {
+	_, err := ms.db.Collection(ChangeEventsCollection).DeleteMany(ms.context, changeEventsFilter(events))
+	return errors.Wrap(convertMongoErr(err), "failed to delete the change events")
+}
+
+func changeEventsFilter(events []*ChangeEvent) bson.M {
+	ids := make(bson.A, len(events))
+	for i, event := range events {
+		ids[i] = event.Id
+	}
+	return bson.M{"_id": bson.M{"$in": ids}}
+}
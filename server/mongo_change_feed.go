@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"time"
+
+	"github.com/eug48/fhir/models2"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ChangeFeed is a feed of the changes to the resources of a type, in the order they were made, from a MongoDB change
+// stream of the collection of their current versions (which requires a replica set, as transactions do).  Each
+// change has a resume token (its Id), which a feed can be started after to continue from it, e.g. once a consumer
+// restarts, for as long as the change is in the replica set's oplog.
+type ChangeFeed struct {
+	stream       *mongo.ChangeStream
+	resourceType string
+	dbName       string
+	// filter returns whether a change is returned by Next (e.g. whether its caller is cleared for its resource), if
+	// it isn't nil
+	filter func(change *ChangeEvent) (bool, error)
+}
+
+// changeStreamEvent is an event of a change stream of a collection of resources
+type changeStreamEvent struct {
+	OperationType string              `bson:"operationType"`
+	FullDocument  bson.D              `bson:"fullDocument"`
+	ClusterTime   primitive.Timestamp `bson:"clusterTime"`
+	DocumentKey   struct {
+		Id string `bson:"_id"`
+	} `bson:"documentKey"`
+}
+
+// WatchChanges returns a feed of the changes to the resources of a type that are made after it's started, or after
+// the change with a resume token if it isn't empty
+func (ms *mongoSession) WatchChanges(resourceType string, resumeToken string) (*ChangeFeed, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"operationType": bson.M{"$in": bson.A{"insert", "replace", "update", "delete"}}}}},
+	}
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if resumeToken != "" {
+		token, err := base64.RawURLEncoding.DecodeString(resumeToken)
+		if err != nil || bson.Raw(token).Validate() != nil {
+			return nil, ErrInvalidResumeToken
+		}
+		opts.SetResumeAfter(bson.Raw(token))
+	}
+
+	stream, err := ms.CurrentVersionCollection(resourceType).Watch(ms.context, pipeline, opts)
+	if err != nil {
+		return nil, errors.Wrap(convertMongoErr(err), "WatchChanges: Watch failed")
+	}
+	return &ChangeFeed{stream: stream, resourceType: resourceType, dbName: ms.dbName}, nil
+}
+
+// Next waits for the next change (that the feed's filter returns), returning it as a ChangeEvent whose Id is its
+// resume token and whose Timestamp is when it was made, with the resource unless it was deleted.  Its VersionId is
+// empty for deletions, as the versions of deleted resources aren't in their current versions' collections.
+func (f *ChangeFeed) Next(ctx context.Context) (*ChangeEvent, error) {
+	for {
+		change, err := f.next(ctx)
+		if err != nil || change == nil || f.filter == nil {
+			return change, err
+		}
+		if returned, err := f.filter(change); err != nil {
+			return nil, errors.Wrap(err, "ChangeFeed.Next: filter failed")
+		} else if returned {
+			return change, nil
+		}
+	}
+}
+
+// next waits for the next change of the stream
+func (f *ChangeFeed) next(ctx context.Context) (*ChangeEvent, error) {
+	if !f.stream.Next(ctx) {
+		if err := f.stream.Err(); err != nil {
+			return nil, errors.Wrap(convertMongoErr(err), "ChangeFeed.Next failed")
+		}
+		return nil, ctx.Err()
+	}
+	var event changeStreamEvent
+	if err := f.stream.Decode(&event); err != nil {
+		return nil, errors.Wrap(err, "ChangeFeed.Next: Decode failed")
+	}
+
+	change := &ChangeEvent{
+		Id:           base64.RawURLEncoding.EncodeToString(f.stream.ResumeToken()),
+		Database:     f.dbName,
+		ResourceType: f.resourceType,
+		ResourceId:   event.DocumentKey.Id,
+		Timestamp:    time.Unix(int64(event.ClusterTime.T), 0),
+	}
+	switch event.OperationType {
+	case "insert":
+		change.Action = ChangeEventCreate
+	case "delete":
+		change.Action = ChangeEventDelete
+	default:
+		change.Action = ChangeEventUpdate
+	}
+	// updated resources that have since been deleted have no full documents
+	if change.Action != ChangeEventDelete && event.FullDocument != nil {
+		resource, err := models2.NewResourceFromBSON(event.FullDocument)
+		if err != nil {
+			return nil, errors.Wrap(err, "ChangeFeed.Next: NewResourceFromBSON failed")
+		}
+		change.VersionId = resource.VersionId()
+		if change.Resource, err = resource.MarshalJSON(); err != nil {
+			return nil, errors.Wrap(err, "ChangeFeed.Next: MarshalJSON failed")
+		}
+	}
+	return change, nil
+}
+
+// Close stops the feed
+func (f *ChangeFeed) Close(ctx context.Context) error {
+	return f.stream.Close(ctx)
+}
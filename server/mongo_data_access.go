@@ -1,10 +1,13 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/url"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -12,6 +15,7 @@ import (
 	"github.com/eug48/fhir/models"
 	"github.com/eug48/fhir/models2"
 	"github.com/eug48/fhir/search"
+	"github.com/garyburd/redigo/redis"
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
 
@@ -22,29 +26,73 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/readconcern"
 	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
-
-	mongowrapper "github.com/opencensus-integrations/gomongowrapper"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type mongoDataAccessLayer struct {
-	client                       *mongowrapper.WrappedClient
+	client                       *mongo.Client
 	defaultDbName                string
 	enableMultiDB                bool
 	dbSuffix                     string
 	Interceptors                 map[string]InterceptorList
 	countTotalResults            bool
+	cacheCounts                  bool
+	countCacheTTL                time.Duration
+	countCacheMaxEntries         int
+	countCacheRedisPool          *redis.Pool
 	enableCISearches             bool
 	tokenParametersCaseSensitive bool
+	lowercaseSearchFields        bool
+	precomputedCompartments      bool
+	collation                    *options.Collation
+	maxChainDepth                int
+	maxIncludeDepth              int
+	defaultCount                 int
+	maxCount                     int
+	maxIncludes                  int
+	keysetPaging                 bool
+	resultSetLifetime            time.Duration
+	resultSetMaxResults          int
+	searchMaxTime                time.Duration
+	indexHints                   search.IndexHints
 	enableHistory                bool
+	conditionalDeleteMultiple    bool
+	requireIfMatch               bool
+	validateResources            bool
+	enforceReferences            bool
+	referencedDeletes            string
+	referencedDeletesByType      map[string]string
 	readonly                     bool
+	subscriptions                *subscriptionNotifier
+	changeEvents                 *changeEventRelay
+	// tenantIndexer creates the indexes of the databases of provisioned tenants (nil if indexes aren't created)
+	tenantIndexer func(dbName string) *Indexer
+	// migrations are the running migrations of databases, which the readiness checks report
+	migrations migrations
 }
 
 type mongoSession struct {
-	session       mongo.Session
-	context       mongo.SessionContext
-	db            *mongowrapper.WrappedDatabase
-	dal           *mongoDataAccessLayer
-	inTransaction bool
+	session        mongo.Session
+	context        mongo.SessionContext
+	requestContext context.Context
+	db             *mongo.Database
+	dbName         string
+	dal            *mongoDataAccessLayer
+	inTransaction  bool
+
+	// resource types changed by the current transaction, whose cached counts are invalidated when it's committed
+	changedResourceTypes map[string]bool
+
+	// referential integrity checks of the current transaction, which are run when it's committed (as its resources
+	// may refer to each other)
+	pendingChecks []func() error
+
+	// resources created or updated by the current transaction, whose Subscriptions are notified when it's committed
+	storedResources []storedResource
+
+	// whether the current transaction recorded change events, which are published once it's committed
+	recordedChangeEvents bool
 }
 
 func (dal *mongoDataAccessLayer) StartSession(ctx context.Context, customDbName string) DataAccessSession {
@@ -73,28 +121,21 @@ func (dal *mongoDataAccessLayer) StartSession(ctx context.Context, customDbName
 		panic(errors.Wrap(err, "client.Database failed"))
 	}
 
-	var contextWithSession mongo.SessionContext
-	wrappedSession := session.(*mongowrapper.WrappedSession) // unwrap - mongo's sessionFromContext wants its own session impl
-	mongo.WithSession(ctx, wrappedSession.Session, func(sc mongo.SessionContext) error {
-		// hack to work around this closure-based API
-		// WithSession just calls the callback and does nothing else.. (at least in MongoDB Go Driver 1.0.3)
-		contextWithSession = sc
-		return nil
-	})
-
 	return &mongoSession{
-		session:       session,
-		context:       contextWithSession,
-		db:            db,
-		inTransaction: false,
-		dal:           dal,
+		session:        session,
+		context:        mongo.NewSessionContext(ctx, session),
+		requestContext: ctx,
+		db:             db,
+		dbName:         dbName,
+		inTransaction:  false,
+		dal:            dal,
 	}
 }
 
-func (ms *mongoSession) CurrentVersionCollection(resourceType string) *mongowrapper.WrappedCollection {
+func (ms *mongoSession) CurrentVersionCollection(resourceType string) *mongo.Collection {
 	return ms.db.Collection(models.PluralizeLowerResourceName(resourceType))
 }
-func (ms *mongoSession) PreviousVersionsCollection(resourceType string) *mongowrapper.WrappedCollection {
+func (ms *mongoSession) PreviousVersionsCollection(resourceType string) *mongo.Collection {
 	return ms.db.Collection(models.PluralizeLowerResourceName(resourceType) + "_prev")
 }
 
@@ -113,9 +154,32 @@ func (ms *mongoSession) StartTransaction() error {
 }
 func (ms *mongoSession) CommmitIfTransaction() error {
 	if ms.inTransaction {
+		pendingChecks := ms.pendingChecks
+		ms.pendingChecks = nil
+		for _, check := range pendingChecks {
+			if err := check(); err != nil {
+				// the transaction is aborted by Finish
+				return errors.Wrap(err, "mongoSession.CommmitIfTransaction")
+			}
+		}
+
 		glog.V(3).Infof("CommmitTransaction")
 		err := ms.session.CommitTransaction(ms.context)
 		ms.inTransaction = false
+		if err == nil {
+			for resourceType := range ms.changedResourceTypes {
+				ms.invalidateCountCache(resourceType)
+			}
+			if len(ms.storedResources) > 0 {
+				ms.dal.subscriptions.notify(ms.dbName, ms.storedResources)
+			}
+			if ms.recordedChangeEvents {
+				ms.dal.changeEvents.eventsRecorded(ms.dbName)
+			}
+		}
+		ms.changedResourceTypes = nil
+		ms.storedResources = nil
+		ms.recordedChangeEvents = false
 		return errors.Wrap(err, "mongoSession.CommmitIfTransaction")
 	} else {
 		return nil
@@ -149,19 +213,72 @@ func (ms *mongoSession) Finish() {
 }
 
 // NewMongoDataAccessLayer returns an implementation of DataAccessLayer that is backed by a Mongo database
-func NewMongoDataAccessLayer(client *mongowrapper.WrappedClient, defaultDbName string, enableMultiDB bool, dbSuffix string, interceptors map[string]InterceptorList, config Config) DataAccessLayer {
-	return &mongoDataAccessLayer{
+func NewMongoDataAccessLayer(client *mongo.Client, defaultDbName string, enableMultiDB bool, dbSuffix string, interceptors map[string]InterceptorList, config Config) DataAccessLayer {
+	var countCacheRedisPool *redis.Pool
+	if config.CountCacheRedisURL != "" {
+		countCacheRedisPool = search.NewRedisPool(config.CountCacheRedisURL)
+	}
+
+	var indexHints search.IndexHints
+	if config.IndexHintsPath != "" {
+		var err error
+		indexHints, err = loadIndexHints(config.IndexHintsPath)
+		if err != nil {
+			panic(errors.Wrap(err, "loading index hints"))
+		}
+	}
+
+	dal := &mongoDataAccessLayer{
 		client:                       client,
 		defaultDbName:                defaultDbName,
-		enableMultiDB:                enableMultiDB,
+		enableMultiDB:                enableMultiDB || config.EnableTenancy, // tenants select their databases
 		dbSuffix:                     dbSuffix,
 		Interceptors:                 interceptors,
 		countTotalResults:            config.CountTotalResults,
+		cacheCounts:                  config.CacheSearchCounts && !config.ReadOnly,
+		countCacheTTL:                config.CountCacheTTL,
+		countCacheMaxEntries:         config.CountCacheMaxEntries,
+		countCacheRedisPool:          countCacheRedisPool,
 		enableCISearches:             config.EnableCISearches,
 		tokenParametersCaseSensitive: config.TokenParametersCaseSensitive,
+		lowercaseSearchFields:        config.LowercaseSearchFields,
+		precomputedCompartments:      config.PrecomputedCompartments,
+		collation:                    config.collation(),
+		maxChainDepth:                config.MaxChainDepth,
+		maxIncludeDepth:              config.MaxIncludeDepth,
+		defaultCount:                 config.DefaultCount,
+		maxCount:                     config.MaxCount,
+		maxIncludes:                  config.MaxIncludes,
+		keysetPaging:                 config.KeysetPaging,
+		resultSetLifetime:            config.ResultSetLifetime,
+		resultSetMaxResults:          config.ResultSetMaxResults,
+		searchMaxTime:                config.DatabaseOpTimeout,
+		indexHints:                   indexHints,
 		enableHistory:                config.EnableHistory,
+		conditionalDeleteMultiple:    config.ConditionalDeleteMultiple,
+		requireIfMatch:               config.RequireIfMatch && config.EnableHistory,
+		validateResources:            config.ValidateResources,
+		enforceReferences:            config.EnforceReferences,
+		referencedDeletes:            config.ReferencedDeletes,
+		referencedDeletesByType:      config.ReferencedDeletesByType,
 		readonly:                     config.ReadOnly,
 	}
+	if config.EnableSubscriptions {
+		dal.subscriptions = newSubscriptionNotifier(dal, config)
+	}
+	if config.EnableTenancy && config.CreateIndexes {
+		dal.tenantIndexer = func(dbName string) *Indexer {
+			return NewIndexer(dbName, config)
+		}
+	}
+	if config.EventBusURL != "" {
+		publisher, err := newEventPublisher(config.EventBusURL, config.EventTopic)
+		if err != nil {
+			panic(errors.Wrap(err, "connecting to the event bus"))
+		}
+		dal.changeEvents = newChangeEventRelay(dal, publisher, config.EventPayloads)
+	}
+	return dal
 }
 
 // InterceptorList is a list of interceptors registered for a given database operation
@@ -231,6 +348,44 @@ func (ms *mongoSession) hasInterceptorsForOpAndType(op, resourceType string) boo
 	return false
 }
 
+// resourcesChanged invalidates the cached search totals that may have changed because
+// resources of the given type were created, updated or deleted.  Changes made in a
+// transaction are only invalidated once it has been committed.
+func (ms *mongoSession) resourcesChanged(resourceType string) {
+	if !ms.dal.cacheCounts {
+		return
+	}
+	if ms.inTransaction {
+		if ms.changedResourceTypes == nil {
+			ms.changedResourceTypes = make(map[string]bool)
+		}
+		ms.changedResourceTypes[resourceType] = true
+		return
+	}
+	ms.invalidateCountCache(resourceType)
+}
+
+func (ms *mongoSession) invalidateCountCache(resourceType string) {
+	// The count cache isn't part of transactions, so the session isn't used
+	err := ms.countCache().Invalidate(ms.requestContext, resourceType)
+	if err != nil {
+		glog.Warningf("failed to invalidate the count cache of %s: %+v", resourceType, err)
+	}
+}
+
+// countCache returns the cache of the totals of searches of the session's database
+func (ms *mongoSession) countCache() search.CountCache {
+	if ms.dal.countCacheRedisPool != nil {
+		return ms.dal.redisCountCache(ms.dbName)
+	}
+	return search.NewMongoCountCache(ms.db, ms.dal.countCacheTTL, ms.dal.countCacheMaxEntries)
+}
+
+// redisCountCache returns the cache in Redis of the totals of searches of a database
+func (dal *mongoDataAccessLayer) redisCountCache(dbName string) *search.RedisCountCache {
+	return search.NewRedisCountCache(dal.countCacheRedisPool, "fhir:countcache:"+dbName+":", dal.countCacheTTL)
+}
+
 func (ms *mongoSession) Get(id, resourceType string) (resource *models2.Resource, err error) {
 	bsonID, err := convertIDToBsonID(id)
 	if err != nil {
@@ -285,7 +440,8 @@ func (ms *mongoSession) GetVersion(id, versionIdStr, resourceType string) (resou
 
 	versionIdInt, err := strconv.Atoi(versionIdStr)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to convert versionId to an integer (%s)", versionIdStr)
+		// versionIds are always integers, so there's no such version
+		return nil, ErrNotFound
 	}
 
 	// First assume versionId is for the current version
@@ -437,8 +593,27 @@ func (ms *mongoSession) PostWithID(id string, resource *models2.Resource) error
 		return convertMongoErr(err)
 	}
 
+	if ms.dal.validateResources {
+		if err := models2.ValidateResource(resource); err != nil {
+			return err
+		}
+	}
+	if ms.dal.subscriptions != nil && resource.ResourceType() == "Subscription" {
+		if err := activateSubscription(resource, ms.subscriptionTopic); err != nil {
+			return err
+		}
+	}
+
 	resource.SetId(bsonID.Hex())
 	updateResourceMeta(resource, 1)
+	if err := search.SetResourceCompartments(resource); err != nil {
+		return errors.Wrap(err, "PostWithID: failed to set compartments")
+	}
+	if ms.dal.enforceReferences {
+		if err := ms.checkReferences(resource); err != nil {
+			return err
+		}
+	}
 	resourceType := resource.ResourceType()
 	curCollection := ms.CurrentVersionCollection(resourceType)
 
@@ -446,8 +621,16 @@ func (ms *mongoSession) PostWithID(id string, resource *models2.Resource) error
 
 	glog.V(3).Infof("PostWithID: inserting %s/%s", resourceType, id)
 	_, err = curCollection.InsertOne(ms.context, resource)
+	if err == nil {
+		err = search.IndexContainedResources(ms.context, ms.db, resource)
+	}
+	if err == nil {
+		err = ms.recordChangeEvent(ChangeEventCreate, resource)
+	}
 
 	if err == nil {
+		ms.resourcesChanged(resourceType)
+		ms.resourceStored(resource, true)
 		ms.invokeInterceptorsAfter("Create", resourceType, resource)
 	} else {
 		ms.invokeInterceptorsOnError("Create", resourceType, err, resource)
@@ -456,15 +639,111 @@ func (ms *mongoSession) PostWithID(id string, resource *models2.Resource) error
 	return convertMongoErr(err)
 }
 
+func (ms *mongoSession) InsertMany(resourceType string, resources []*models2.Resource) (errs []error, err error) {
+	errs = make([]error, len(resources))
+	var documents []interface{}
+	var inserting []int // the indexes of the documents' resources
+	for i, resource := range resources {
+		if resource.ResourceType() != resourceType {
+			errs[i] = errors.Errorf("a %s resource isn't a %s resource", resource.ResourceType(), resourceType)
+			continue
+		}
+		id := resource.Id()
+		if id == "" {
+			id = primitive.NewObjectID().Hex()
+		}
+		bsonID, err := convertIDToBsonID(id)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		if ms.dal.validateResources {
+			if err := models2.ValidateResource(resource); err != nil {
+				errs[i] = err
+				continue
+			}
+		}
+		if ms.dal.subscriptions != nil && resourceType == "Subscription" {
+			if err := activateSubscription(resource, ms.subscriptionTopic); err != nil {
+				errs[i] = err
+				continue
+			}
+		}
+		resource.SetId(bsonID.Hex())
+		updateResourceMeta(resource, 1)
+		if err := search.SetResourceCompartments(resource); err != nil {
+			errs[i] = errors.Wrap(err, "InsertMany: failed to set compartments")
+			continue
+		}
+		ms.invokeInterceptorsBefore("Create", resourceType, resource)
+		documents = append(documents, resource)
+		inserting = append(inserting, i)
+	}
+	if len(documents) == 0 {
+		return errs, nil
+	}
+
+	// The documents are inserted unordered, so that those after any that fail (e.g. with IDs that exist) are too
+	glog.V(3).Infof("InsertMany: inserting %d %s resources", len(documents), resourceType)
+	_, err = ms.CurrentVersionCollection(resourceType).InsertMany(ms.context, documents, options.InsertMany().SetOrdered(false))
+	if bulkErr, isBulkErr := err.(mongo.BulkWriteException); isBulkErr && bulkErr.WriteConcernError == nil {
+		for _, writeErr := range bulkErr.WriteErrors {
+			errs[inserting[writeErr.Index]] = errors.Errorf("failed to insert %s/%s: %s", resourceType, resources[inserting[writeErr.Index]].Id(), writeErr.Message)
+		}
+	} else if err != nil {
+		return nil, convertMongoErr(err)
+	}
+
+	ms.resourcesChanged(resourceType)
+	for _, i := range inserting {
+		resource := resources[i]
+		if errs[i] != nil {
+			ms.invokeInterceptorsOnError("Create", resourceType, errs[i], resource)
+			continue
+		}
+		// Only resources with contained resources need them indexed
+		if bytes.Contains(resource.JsonBytes(), []byte(`"contained"`)) {
+			if err := search.IndexContainedResources(ms.context, ms.db, resource); err != nil {
+				return nil, convertMongoErr(err)
+			}
+		}
+		if err := ms.recordChangeEvent(ChangeEventCreate, resource); err != nil {
+			return nil, err
+		}
+		ms.resourceStored(resource, true)
+		ms.invokeInterceptorsAfter("Create", resourceType, resource)
+	}
+	return errs, nil
+}
+
 func (ms *mongoSession) Put(id string, conditionalVersionId string, resource *models2.Resource) (createdNew bool, err error) {
 	bsonID, err := convertIDToBsonID(id)
 	if err != nil {
 		return false, convertMongoErr(err)
 	}
 
+	if ms.dal.validateResources {
+		if err := models2.ValidateResource(resource); err != nil {
+			return false, err
+		}
+	}
+	if ms.dal.subscriptions != nil && resource.ResourceType() == "Subscription" {
+		if err := activateSubscription(resource, ms.subscriptionTopic); err != nil {
+			return false, err
+		}
+	}
+
 	resourceType := resource.ResourceType()
 	curCollection := ms.CurrentVersionCollection(resourceType)
 	resource.SetId(bsonID.Hex())
+	if err := search.SetResourceCompartments(resource); err != nil {
+		return false, errors.Wrap(err, "PUT handler: failed to set compartments")
+	}
+	if ms.dal.enforceReferences {
+		if err := ms.checkReferences(resource); err != nil {
+			return false, err
+		}
+	}
 	if conditionalVersionId != "" {
 		glog.V(3).Infof("PUT %s/%s (If-Match %s)", resourceType, resource.Id(), conditionalVersionId)
 	} else {
@@ -498,7 +777,7 @@ func (ms *mongoSession) Put(id string, conditionalVersionId string, resource *mo
 
 		if err == mongo.ErrNoDocuments {
 			if conditionalVersionId != "" {
-				return false, ErrConflict{msg: "If-Match specified for a resource that doesn't exist"}
+				return false, ErrVersionMismatch{msg: "If-Match specified for a resource that doesn't exist"}
 			}
 			glog.V(3).Infof("  versionIds: no current; new %d", newVersionId)
 		} else {
@@ -519,8 +798,11 @@ func (ms *mongoSession) Put(id string, conditionalVersionId string, resource *mo
 			curVersionId = &curVersionIdTemp
 			glog.V(3).Infof("  versionIds: current %d; new %d", *curVersionId, newVersionId)
 
+			if conditionalVersionId == "" && ms.dal.requireIfMatch {
+				return false, ErrVersionMismatch{msg: fmt.Sprintf("If-Match is required to update %s/%s", resourceType, id)}
+			}
 			if conditionalVersionId != "" && conditionalVersionId != curVersionIdStr {
-				return false, ErrConflict{msg: "If-Match doesn't match current versionId"}
+				return false, ErrVersionMismatch{msg: fmt.Sprintf("If-Match doesn't match current versionId (current=%s wanted=%s)", curVersionIdStr, conditionalVersionId)}
 			}
 
 			// store current document in the previous version collection, adding its versionId to
@@ -607,9 +889,19 @@ func (ms *mongoSession) Put(id string, conditionalVersionId string, resource *mo
 	} else {
 		glog.V(3).Infof("      updated %d", updated)
 	}
+	if err == nil {
+		err = search.IndexContainedResources(ms.context, ms.db, resource)
+	}
+	if err == nil && updated == 0 {
+		err = ms.recordChangeEvent(ChangeEventCreate, resource)
+	} else if err == nil {
+		err = ms.recordChangeEvent(ChangeEventUpdate, resource)
+	}
 
 	if err == nil {
+		ms.resourcesChanged(resourceType)
 		createdNew = (updated == 0)
+		ms.resourceStored(resource, createdNew)
 		if createdNew {
 			ms.invokeInterceptorsAfter("Create", resourceType, resource)
 		} else {
@@ -666,6 +958,9 @@ func (ms *mongoSession) ConditionalPut(query search.Query, conditionalVersionId
 			id = primitive.NewObjectID().Hex()
 		case 1:
 			id = IDs[0]
+			if resource.Id() != "" && resource.Id() != id {
+				return "", false, &ErrIDMismatch{msg: fmt.Sprintf("Resource id %s doesn't match the id of the resource matching %s?%s (%s)", resource.Id(), query.Resource, query.Query, id)}
+			}
 		default:
 			return "", false, &ErrMultipleMatches{msg: fmt.Sprintf("Multiple matches for %s?%s", query.Resource, query.Query)}
 		}
@@ -677,10 +972,21 @@ func (ms *mongoSession) ConditionalPut(query search.Query, conditionalVersionId
 	return id, createdNew, err
 }
 
-func (ms *mongoSession) Delete(id, resourceType string) (newVersionId string, err error) {
+func (ms *mongoSession) Delete(id, resourceType string) (newVersionId string, changes []ReferenceChange, err error) {
+	return ms.delete(id, resourceType, make(map[string]bool))
+}
+
+// delete deletes a resource, along with the resources referring to it that are deleted by a cascade (see
+// handleReferencedDeletes), and those being deleted as Type/id
+func (ms *mongoSession) delete(id, resourceType string, deleting map[string]bool) (newVersionId string, changes []ReferenceChange, err error) {
 	bsonID, err := convertIDToBsonID(id)
 	if err != nil {
-		return "", ErrNotFound
+		return "", nil, ErrNotFound
+	}
+
+	changes, err = ms.handleReferencedDeletes(resourceType, []string{bsonID.Hex()}, deleting)
+	if err != nil {
+		return "", nil, err
 	}
 
 	curCollection := ms.CurrentVersionCollection(resourceType)
@@ -689,9 +995,9 @@ func (ms *mongoSession) Delete(id, resourceType string) (newVersionId string, er
 	if ms.dal.enableHistory {
 		newVersionId, err = saveDeletionIntoHistory(resourceType, bsonID.Hex(), curCollection, prevCollection, ms)
 		if err == mongo.ErrNoDocuments {
-			return "", ErrNotFound
+			return "", nil, ErrNotFound
 		} else if err != nil {
-			return "", errors.Wrap(err, "failed to save deletion into history")
+			return "", nil, errors.Wrap(err, "failed to save deletion into history")
 		}
 	}
 
@@ -711,6 +1017,15 @@ func (ms *mongoSession) Delete(id, resourceType string) (newVersionId string, er
 	if deleteInfo.DeletedCount == 0 && err == nil {
 		err = mongo.ErrNoDocuments
 	}
+	if err == nil {
+		err = search.RemoveContainedResources(ms.context, ms.db, resourceType, bsonID.Hex())
+	}
+	if err == nil {
+		err = ms.recordDeletionEvents(resourceType, []string{bsonID.Hex()}, newVersionId)
+	}
+	if err == nil {
+		ms.resourcesChanged(resourceType)
+	}
 
 	if hasInterceptor {
 		if err == nil && getError == nil {
@@ -724,7 +1039,7 @@ func (ms *mongoSession) Delete(id, resourceType string) (newVersionId string, er
 	return
 }
 
-func saveDeletionIntoHistory(resourceType string, id string, curCollection *mongowrapper.WrappedCollection, prevCollection *mongowrapper.WrappedCollection, ms *mongoSession) (newVersionIdStr string, err error) {
+func saveDeletionIntoHistory(resourceType string, id string, curCollection *mongo.Collection, prevCollection *mongo.Collection, ms *mongoSession) (newVersionIdStr string, err error) {
 	// get current version of this document
 	var currentDoc bson.D
 	var currentDocRaw bson.Raw
@@ -808,11 +1123,52 @@ func saveDeletionIntoHistory(resourceType string, id string, curCollection *mong
 	return
 }
 
-func (ms *mongoSession) ConditionalDelete(query search.Query) (count int64, err error) {
+func (ms *mongoSession) ConditionalDelete(query search.Query) (count int64, changes []ReferenceChange, err error) {
+	if !ms.dal.conditionalDeleteMultiple {
+		IDs, err := ms.FindIDs(query)
+		if err != nil {
+			return 0, nil, err
+		}
+		if len(IDs) > 1 {
+			return 0, nil, &ErrMultipleMatches{msg: fmt.Sprintf("Multiple matches for %s?%s", query.Resource, query.Query)}
+		}
+	}
+
+	// Matches are found a page at a time, so the search is repeated until no more are deleted
+	deleting := make(map[string]bool)
+	for {
+		deleted, pageChanges, err := ms.conditionalDeletePage(query, deleting)
+		count += deleted
+		changes = append(changes, pageChanges...)
+		if err != nil || deleted == 0 {
+			return count, changes, err
+		}
+	}
+}
+
+// conditionalDeletePage deletes the resources on the first page of matches of a conditional delete (see delete)
+func (ms *mongoSession) conditionalDeletePage(query search.Query, deleting map[string]bool) (count int64, changes []ReferenceChange, err error) {
+	var IDsToDelete []string
+	defer func() {
+		if count > 0 {
+			ms.resourcesChanged(query.Resource)
+			removeErr := search.RemoveContainedResources(ms.context, ms.db, query.Resource, IDsToDelete...)
+			if removeErr == nil {
+				removeErr = ms.recordConditionalDeletionEvents(query.Resource, IDsToDelete)
+			}
+			if err == nil {
+				err = removeErr
+			}
+		}
+	}()
 
-	IDsToDelete, err := ms.FindIDs(query)
+	IDsToDelete, err = ms.FindIDs(query)
+	if err != nil || len(IDsToDelete) == 0 {
+		return 0, nil, err
+	}
+	changes, err = ms.handleReferencedDeletes(query.Resource, IDsToDelete, deleting)
 	if err != nil {
-		return 0, err
+		return 0, nil, err
 	}
 	// There is the potential here for the delete to fail if the slice of IDs
 	// is too large (exceeding Mongo's 16MB document size limit).
@@ -852,7 +1208,7 @@ func (ms *mongoSession) ConditionalDelete(query search.Query) (count int64, err
 					id := elem.Resource.Id()
 					_, err = saveDeletionIntoHistory(resourceType, id, curCollection, prevCollection, ms)
 					if err != nil {
-						return count, errors.Wrapf(err, "failed to save deletion into history (%s/%s)", resourceType, id)
+						return count, changes, errors.Wrapf(err, "failed to save deletion into history (%s/%s)", resourceType, id)
 					}
 				}
 			}
@@ -870,9 +1226,9 @@ func (ms *mongoSession) ConditionalDelete(query search.Query) (count int64, err
 						ms.invokeInterceptorsOnError("Delete", resourceType, err, elem.Resource)
 					}
 				}
-				return count, convertMongoErr(err)
+				return count, changes, convertMongoErr(err)
 			} else if hasInterceptors == false {
-				return count, nil
+				return count, changes, nil
 			}
 
 			var searchErr error
@@ -903,128 +1259,73 @@ func (ms *mongoSession) ConditionalDelete(query search.Query) (count int64, err
 				}
 			}
 		}
-		return count, convertMongoErr(err)
+		return count, changes, convertMongoErr(err)
 	} else {
 		// do the bulk delete the usual way
 		info, err := curCollection.DeleteMany(ms.context, deleteQuery)
 		if info != nil {
 			count = info.DeletedCount
 		}
-		return count, convertMongoErr(err)
+		return count, changes, convertMongoErr(err)
 	}
 }
 
-func (ms *mongoSession) History(baseURL url.URL, resourceType string, id string) (bundle *models2.ShallowBundle, err error) {
-
-	// check id
-	_, err = convertIDToBsonID(id)
-	if err != nil {
-		return nil, ErrNotFound
-	}
-
-	baseURLstr := baseURL.String()
-	if !strings.HasSuffix(baseURLstr, "/") {
-		baseURLstr = baseURLstr + "/"
-	}
-	fullUrl := baseURLstr + id
-
-	curCollection := ms.CurrentVersionCollection(resourceType)
-	prevCollection := ms.PreviousVersionsCollection(resourceType)
-
-	var entryList []models2.ShallowBundleEntryComponent
-	makeEntryRequest := func(method string) *models.BundleEntryRequestComponent {
-		return &models.BundleEntryRequestComponent{
-			Url:    resourceType + "/" + id,
-			Method: method,
-		}
-	}
-
-	// add current version
-	var curDoc bson.D
-	curDocQuery := bson.D{{"_id", id}}
-	err = curCollection.FindOne(ms.context, curDocQuery).Decode(&curDoc)
-	if err == nil {
-		var entry models2.ShallowBundleEntryComponent
-		entry.FullUrl = fullUrl
-		entry.Resource, err = models2.NewResourceFromBSON(curDoc)
-		if err != nil {
-			return nil, errors.Wrap(err, "History: NewResourceFromBSON failed")
-		}
-		entry.Request = makeEntryRequest("PUT")
-		entryList = append(entryList, entry)
-	} else if err != mongo.ErrNoDocuments {
-		return nil, err
-	}
-
-	// sort - oldest versions last
-	prevDocsQuery := bson.D{{"_id._id", id}}
-	prevDocsSort := options.Find().SetSort(bson.D{{"_id._version", -1}})
-	cursor, err := prevCollection.Find(ms.context, prevDocsQuery, prevDocsSort)
-	if err != nil {
-		return nil, errors.Wrap(err, "History: prevCollection.Find failed")
-	}
-
-	for cursor.Next(ms.context) {
-
-		var prevDocBson bson.Raw
-		err = cursor.Decode(&prevDocBson)
-		glog.V(8).Infof("History: decoded prev document: %s", prevDocBson.String())
-		if err != nil {
-			return nil, errors.Wrap(err, "History: cursor.Decode failed")
-		}
-
-		var entry models2.ShallowBundleEntryComponent
-		entry.FullUrl = fullUrl
+func (ms *mongoSession) Search(baseURL url.URL, searchQuery search.Query) (*models2.ShallowBundle, error) {
 
-		deleted, resource, err := unmarshalPreviousVersion(&prevDocBson)
+	var warnings []string
+	searchQuery, countCapped := searchQuery.WithCountLimits(ms.dal.defaultCount, ms.dal.maxCount)
+	if countCapped {
+		warnings = append(warnings, fmt.Sprintf("_count was reduced to the maximum of %d results per page", ms.dal.maxCount))
+	}
+
+	// Searches outside of transactions don't need the session, which lets them count their total
+	// results concurrently with the search (sessions can't be used concurrently)
+	var searchContext context.Context = ms.context
+	if !ms.inTransaction {
+		searchContext = ms.requestContext
+	}
+	searchContext, span := tracer.Start(searchContext, "search", trace.WithAttributes(attribute.String("fhir.resource_type", searchQuery.Resource)))
+	defer span.End()
+
+	searcher := search.NewMongoSearcher(ms.db, searchContext, ms.dal.countTotalResults, ms.dal.enableCISearches, ms.dal.tokenParametersCaseSensitive, ms.dal.readonly)
+	searcher.SetMaxChainDepth(ms.dal.maxChainDepth)
+	searcher.SetMaxIncludeDepth(ms.dal.maxIncludeDepth)
+	searcher.SetMaxIncludes(ms.dal.maxIncludes)
+	searcher.SetKeysetPaging(ms.dal.keysetPaging)
+	searcher.SetMaxTime(ms.dal.searchMaxTime)
+	searcher.SetIndexHints(ms.dal.indexHints)
+	searcher.SetLowercaseFields(ms.dal.lowercaseSearchFields)
+	searcher.SetPrecomputedCompartments(ms.dal.precomputedCompartments)
+	searcher.SetCollation(ms.dal.collation)
+	// Totals counted in a transaction may include its uncommitted changes, so aren't cached
+	searcher.SetCountCache(ms.countCache(), ms.dal.cacheCounts && !ms.inTransaction)
+
+	// Paging through a snapshot of the results keeps the later pages consistent with the first.
+	// Snapshots taken in a transaction may include its uncommitted changes, so aren't used.
+	if ms.dal.resultSetLifetime > 0 && !ms.inTransaction && searchQuery.SupportsPaging() {
+		resultSet, err := searcher.CreateResultSet(searchQuery, ms.dal.resultSetLifetime, ms.dal.resultSetMaxResults)
 		if err != nil {
-			return nil, errors.Wrap(err, "History: unmarshalPreviousVersion failed")
+			return nil, convertMongoErr(err)
 		}
-		if deleted {
-			entry.Request = makeEntryRequest("DELETE")
-		} else {
-			entry.Resource = resource
-			entry.Request = makeEntryRequest("PUT")
+		if resultSet != "" {
+			searchQuery = searchQuery.WithResultSet(resultSet)
 		}
-
-		entryList = append(entryList, entry)
-	}
-	if err := cursor.Err(); err != nil {
-		return nil, errors.Wrap(err, "History: MongoDB query for previous versions failed")
-	}
-
-	totalDocs := uint32(len(entryList))
-	if totalDocs == 0 {
-		return nil, ErrNotFound
-	}
-
-	// last entry should be a POST
-	entryList[len(entryList)-1].Request.Method = "POST"
-	entryList[len(entryList)-1].Request.Url = resourceType
-
-	// output a Bundle
-	bundle = &models2.ShallowBundle{
-		Id:    primitive.NewObjectID().Hex(),
-		Type:  "history",
-		Entry: entryList,
-		Total: &totalDocs,
 	}
 
-	// TODO: use paging
-	// bundle.Link = dal.generatePagingLinks(baseURL, searchQuery, total, uint32(numResults))
-
-	return bundle, nil
-}
-
-func (ms *mongoSession) Search(baseURL url.URL, searchQuery search.Query) (*models2.ShallowBundle, error) {
-
-	searcher := search.NewMongoSearcher(ms.db, ms.context, ms.dal.countTotalResults, ms.dal.enableCISearches, ms.dal.tokenParametersCaseSensitive, ms.dal.readonly)
-
-	resources, total, err := searcher.Search(searchQuery)
+	resources, total, next, err := searcher.SearchPage(searchQuery)
 	if err != nil {
 		return nil, convertMongoErr(err)
 	}
 
+	// Only the first _maxresults matches are returned (across all pages), so the total is
+	// reduced to match (which keeps the paging links within them)
+	if maxResults := searchQuery.Options().MaxResults; maxResults > 0 && total > uint32(maxResults) {
+		warnings = append(warnings, fmt.Sprintf("Only the first %d of the %d matching resources are returned (_maxresults)", maxResults, total))
+		total = uint32(maxResults)
+	}
+
+	_, spanForBundle := tracer.Start(searchContext, "assembling bundle", trace.WithAttributes(attribute.Int("results", len(resources))))
+	defer spanForBundle.End()
 	includesMap := make(map[string]*models2.Resource)
 	var entryList []models2.ShallowBundleEntryComponent
 	numResults := len(resources)
@@ -1033,14 +1334,42 @@ func (ms *mongoSession) Search(baseURL url.URL, searchQuery search.Query) (*mode
 		baseURLstr = baseURLstr + "/"
 	}
 
+	// Included resources aren't necessarily of the searched type, so their full URLs are relative to the server's base
+	// (which is the base URL of searches of all resource types)
+	serverBaseURLstr := baseURLstr
+	if !searchQuery.IsSystemSearch() {
+		serverBaseURLstr = strings.TrimSuffix(baseURLstr, searchQuery.Resource+"/")
+	}
+
+	// Results limited by _elements or _summary are incomplete, so they need to be tagged as such
+	subsetted := searchQuery.Options().IsSubsetted()
+	matches := make(map[string]bool, numResults)
+
 	for i := 0; i < numResults; i++ {
+		if subsetted {
+			if err := resources[i].AddMetaTag("http://hl7.org/fhir/v3/ObservationValue", "SUBSETTED"); err != nil {
+				return nil, errors.Wrap(err, "Search: failed to tag a subsetted resource")
+			}
+		}
+
 		var entry models2.ShallowBundleEntryComponent
 		entry.Resource = resources[i]
-		entry.FullUrl = baseURLstr + resources[i].Id()
+		matchKey := resources[i].ResourceType() + "/" + resources[i].Id()
+		if container, containedID, ok := search.SplitContainedID(resources[i].Id()); ok {
+			// Contained resources (_containedType=contained) are identified within their container
+			resources[i].SetId(containedID)
+			entry.FullUrl = serverBaseURLstr + container + "#" + containedID
+		} else if resources[i].ResourceType() != searchQuery.Resource {
+			// The containers of contained resources can be of any type
+			entry.FullUrl = serverBaseURLstr + matchKey
+		} else {
+			entry.FullUrl = baseURLstr + resources[i].Id()
+		}
 		entry.Search = &models.BundleEntrySearchComponent{Mode: "match"}
 		entryList = append(entryList, entry)
+		matches[matchKey] = true
 
-		if searchQuery.UsesIncludes() || searchQuery.UsesRevIncludes() {
+		if searchQuery.UsesIncludes() || searchQuery.UsesRevIncludes() || searchQuery.UsesGraph() {
 
 			for _, included := range entry.Resource.SearchIncludes() {
 				includesMap[included.ResourceType()+"/"+included.Id()] = included
@@ -1049,32 +1378,95 @@ func (ms *mongoSession) Search(baseURL url.URL, searchQuery search.Query) (*mode
 		}
 	}
 
-	for _, v := range includesMap {
+	// Included resources that are also matches only appear once (as matches), and the
+	// rest are sorted so that the order of the entries is consistent between requests
+	includedKeys := make([]string, 0, len(includesMap))
+	for k := range includesMap {
+		if !matches[k] {
+			includedKeys = append(includedKeys, k)
+		}
+	}
+	sort.Strings(includedKeys)
+	if ms.dal.maxIncludes > 0 && len(includedKeys) > ms.dal.maxIncludes {
+		// The $lookups of includes are limited too, so the number of included resources may be larger
+		warnings = append(warnings, fmt.Sprintf("Only %d of the included resources were returned", ms.dal.maxIncludes))
+		includedKeys = includedKeys[:ms.dal.maxIncludes]
+	}
+
+	for _, k := range includedKeys {
+		v := includesMap[k]
 		if glog.V(4) {
 			glog.V(4).Infof("includesMap: %s/%s/_history/%s\n", v.ResourceType(), v.Id(), v.VersionId())
 		}
 		var entry models2.ShallowBundleEntryComponent
 		entry.Resource = v
+		entry.FullUrl = serverBaseURLstr + k
 		entry.Search = &models.BundleEntrySearchComponent{Mode: "include"}
 		entryList = append(entryList, entry)
 	}
 
+	if len(warnings) > 0 {
+		entry, err := newSearchOutcomeEntry(warnings)
+		if err != nil {
+			return nil, errors.Wrap(err, "Search: failed to create the OperationOutcome entry")
+		}
+		entryList = append(entryList, entry)
+	}
+
 	bundle := models2.ShallowBundle{
 		Id:    primitive.NewObjectID().Hex(),
 		Type:  "searchset",
 		Entry: entryList,
 	}
 
-	// Only include the total if counts are enabled, or if _summary=count was applied.
-	if ms.dal.countTotalResults || searchQuery.Options().Summary == "count" {
+	// Only include the total if counts are enabled (or requested with _total), or if _summary=count was applied.
+	if searchQuery.Options().CountsTotal(ms.dal.countTotalResults) {
 		bundle.Total = &total
 	}
 
-	bundle.Link = ms.generatePagingLinks(baseURL, searchQuery, total, uint32(numResults))
+	bundle.Link = ms.generatePagingLinks(baseURL, searchQuery, total, uint32(numResults), next)
 
 	return &bundle, nil
 }
 
+// newSearchOutcomeEntry returns a search results entry for an OperationOutcome with warnings about the search
+func newSearchOutcomeEntry(warnings []string) (entry models2.ShallowBundleEntryComponent, err error) {
+	outcome := &models.OperationOutcome{}
+	for _, warning := range warnings {
+		outcome.Issue = append(outcome.Issue, models.OperationOutcomeIssueComponent{
+			Severity:    "warning",
+			Code:        "informational",
+			Diagnostics: warning,
+		})
+	}
+
+	outcomeJSON, err := json.Marshal(outcome)
+	if err != nil {
+		return entry, err
+	}
+	entry.Resource, err = models2.NewResourceFromJsonBytes(outcomeJSON)
+	entry.Search = &models.BundleEntrySearchComponent{Mode: "outcome"}
+	return entry, err
+}
+
+func (ms *mongoSession) Explain(searchQuery search.Query) (*search.Explanation, error) {
+	searchQuery, _ = searchQuery.WithCountLimits(ms.dal.defaultCount, ms.dal.maxCount)
+
+	searcher := search.NewMongoSearcher(ms.db, ms.context, ms.dal.countTotalResults, ms.dal.enableCISearches, ms.dal.tokenParametersCaseSensitive, ms.dal.readonly)
+	searcher.SetMaxChainDepth(ms.dal.maxChainDepth)
+	searcher.SetMaxIncludeDepth(ms.dal.maxIncludeDepth)
+	searcher.SetMaxIncludes(ms.dal.maxIncludes)
+	searcher.SetKeysetPaging(ms.dal.keysetPaging)
+	searcher.SetMaxTime(ms.dal.searchMaxTime)
+	searcher.SetIndexHints(ms.dal.indexHints)
+	searcher.SetLowercaseFields(ms.dal.lowercaseSearchFields)
+	searcher.SetPrecomputedCompartments(ms.dal.precomputedCompartments)
+	searcher.SetCollation(ms.dal.collation)
+
+	explanation, err := searcher.Explain(searchQuery)
+	return explanation, convertMongoErr(err)
+}
+
 func (ms *mongoSession) FindIDs(searchQuery search.Query) (IDs []string, err error) {
 
 	// First create a new query with the unsupported query options filtered out
@@ -1083,7 +1475,7 @@ func (ms *mongoSession) FindIDs(searchQuery search.Query) (IDs []string, err err
 	for _, param := range oldParams.All() {
 		switch param.Key {
 		case search.ContainedParam, search.ContainedTypeParam, search.ElementsParam, search.IncludeParam,
-			search.RevIncludeParam, search.SummaryParam:
+			search.RevIncludeParam, search.SummaryParam, search.TotalParam:
 			continue
 		default:
 			newParams.Add(param.Key, param.Value)
@@ -1093,6 +1485,13 @@ func (ms *mongoSession) FindIDs(searchQuery search.Query) (IDs []string, err err
 
 	// Now search on that query, unmarshaling to a temporary struct and converting results to []string
 	searcher := search.NewMongoSearcher(ms.db, ms.context, ms.dal.countTotalResults, ms.dal.enableCISearches, ms.dal.tokenParametersCaseSensitive, ms.dal.readonly)
+	searcher.SetMaxChainDepth(ms.dal.maxChainDepth)
+	searcher.SetMaxIncludeDepth(ms.dal.maxIncludeDepth)
+	searcher.SetMaxTime(ms.dal.searchMaxTime)
+	searcher.SetIndexHints(ms.dal.indexHints)
+	searcher.SetLowercaseFields(ms.dal.lowercaseSearchFields)
+	searcher.SetPrecomputedCompartments(ms.dal.precomputedCompartments)
+	searcher.SetCollation(ms.dal.collation)
 	results, _, err := searcher.Search(newQuery)
 	if err != nil {
 		return nil, convertMongoErr(err)
@@ -1106,10 +1505,16 @@ func (ms *mongoSession) FindIDs(searchQuery search.Query) (IDs []string, err err
 	return IDs, nil
 }
 
-func (ms *mongoSession) generatePagingLinks(baseURL url.URL, query search.Query, total uint32, numResults uint32) []models.BundleLinkComponent {
+func (ms *mongoSession) generatePagingLinks(baseURL url.URL, query search.Query, total uint32, numResults uint32, next *search.PageCursor) []models.BundleLinkComponent {
 
 	links := make([]models.BundleLinkComponent, 0, 5)
 	params := query.URLQueryParameters(true)
+
+	// The _cursor of a keyset-paged query only applies to its own page
+	cursor := params.Get(search.CursorParam)
+	if cursor != "" {
+		params = withoutParam(params, search.CursorParam)
+	}
 	offset := 0
 	if pOffset := params.Get(search.OffsetParam); pOffset != "" {
 		offset, _ = strconv.Atoi(pOffset)
@@ -1124,6 +1529,7 @@ func (ms *mongoSession) generatePagingLinks(baseURL url.URL, query search.Query,
 			count = search.NewQueryOptions().Count
 		}
 	}
+	maxResults := query.Options().MaxResults
 
 	// For queries that don't support paging, only return the "self" link created directly from the original query.
 	if !query.SupportsPaging() {
@@ -1132,7 +1538,11 @@ func (ms *mongoSession) generatePagingLinks(baseURL url.URL, query search.Query,
 	}
 
 	// Self link
-	links = append(links, newLink("self", baseURL, params, offset, count))
+	if cursor != "" {
+		links = append(links, newCursorLink("self", baseURL, params, cursor, count))
+	} else {
+		links = append(links, newLink("self", baseURL, params, offset, count))
+	}
 
 	// First link
 	links = append(links, newLink("first", baseURL, params, 0, count))
@@ -1148,10 +1558,16 @@ func (ms *mongoSession) generatePagingLinks(baseURL url.URL, query search.Query,
 		links = append(links, newLink("previous", baseURL, params, prevOffset, prevCount))
 	}
 
+	// With keyset paging, the next link resumes after the last result.  Otherwise _offset is used.
+	keyset := next != nil || cursor != ""
+	if next != nil {
+		links = append(links, newCursorLink("next", baseURL, params, next.Encode(), count))
+	}
+
 	// If counts are enabled, the total is accurate and can be used to compute the links.
-	if ms.dal.countTotalResults {
+	if query.Options().CountsTotal(ms.dal.countTotalResults) {
 		// Next Link
-		if total > uint32(offset+count) {
+		if !keyset && total > uint32(offset+count) {
 			nextOffset := offset + count
 			links = append(links, newLink("next", baseURL, params, nextOffset, count))
 		}
@@ -1172,7 +1588,7 @@ func (ms *mongoSession) generatePagingLinks(baseURL url.URL, query search.Query,
 		// it to the expected paging count to determine if we've exhaused the search results or not.
 
 		// Next Link
-		if int(numResults) == count {
+		if !keyset && int(numResults) == count && (maxResults == 0 || offset+count < maxResults) {
 			nextOffset := offset + count
 			links = append(links, newLink("next", baseURL, params, nextOffset, count))
 		}
@@ -1204,6 +1620,24 @@ func newLink(relation string, baseURL url.URL, params search.URLQueryParameters,
 	return models.BundleLinkComponent{Relation: relation, Url: baseURL.String()}
 }
 
+func newCursorLink(relation string, baseURL url.URL, params search.URLQueryParameters, cursor string, count int) models.BundleLinkComponent {
+	params = withoutParam(params, search.OffsetParam)
+	params.Set(search.CursorParam, cursor)
+	params.Set(search.CountParam, strconv.Itoa(count))
+	baseURL.RawQuery = params.Encode()
+	return models.BundleLinkComponent{Relation: relation, Url: baseURL.String()}
+}
+
+func withoutParam(params search.URLQueryParameters, key string) search.URLQueryParameters {
+	var result search.URLQueryParameters
+	for _, param := range params.All() {
+		if param.Key != key {
+			result.Add(param.Key, param.Value)
+		}
+	}
+	return result
+}
+
 func convertIDToBsonID(id string) (primitive.ObjectID, error) {
 	objId, err := primitive.ObjectIDFromHex(id)
 	if err == nil {
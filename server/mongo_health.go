@@ -0,0 +1,42 @@
+package server
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// CheckDatabase pings the primary of MongoDB and finds out whether it's a replica set or sharded cluster, and which
+// migrations of databases are running
+func (ms *mongoSession) CheckDatabase() (*DatabaseStatus, error) {
+	client := ms.dal.client
+	started := time.Now()
+	if err := client.Ping(ms.requestContext, readpref.Primary()); err != nil {
+		return nil, errors.Wrap(err, "failed to ping the MongoDB primary")
+	}
+	status := &DatabaseStatus{Latency: time.Since(started), PendingMigrations: ms.dal.migrations.pending()}
+
+	var isMaster struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	admin := client.Database("admin")
+	err := admin.RunCommand(ms.requestContext, bson.D{{Key: "isMaster", Value: 1}}).Decode(&isMaster)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to run isMaster")
+	}
+	status.ReplicaSet = isMaster.SetName
+	status.Sharded = isMaster.Msg == "isdbgrid"
+
+	// mongos doesn't have a featureCompatibilityVersion, and users may not be allowed to read it
+	getFCV := bson.D{{Key: "getParameter", Value: 1}, {Key: "featureCompatibilityVersion", Value: 1}}
+	var fcvDoc bson.Raw
+	if err := admin.RunCommand(ms.requestContext, getFCV).Decode(&fcvDoc); err == nil {
+		if fcv, ok := fcvDoc.Lookup("featureCompatibilityVersion", "version").StringValueOK(); ok {
+			status.FeatureCompatibilityVersion = fcv
+		}
+	}
+	return status, nil
+}
@@ -0,0 +1,281 @@
+package server
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eug48/fhir/models"
+	"github.com/eug48/fhir/models2"
+	"github.com/eug48/fhir/search"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// historyVersion is a version of a resource in a history
+type historyVersion struct {
+	resourceType string
+	id           string
+	versionId    int
+	lastUpdated  time.Time
+	resource     *models2.Resource // nil for deletions
+}
+
+func (ms *mongoSession) History(baseURL url.URL, resourceType string, id string, historyOptions HistoryOptions) (bundle *models2.ShallowBundle, err error) {
+	if id != "" {
+		if _, err := convertIDToBsonID(id); err != nil {
+			return nil, ErrNotFound
+		}
+	}
+
+	count := historyOptions.Count
+	if count <= 0 {
+		count = ms.dal.defaultCount
+	}
+	if ms.dal.maxCount > 0 && count > ms.dal.maxCount {
+		count = ms.dal.maxCount
+	}
+	offset := historyOptions.Offset
+
+	resourceTypes := []string{resourceType}
+	if resourceType == "" {
+		resourceTypes = make([]string, 0, len(search.SearchParameters()))
+		for resourceType := range search.SearchParameters() {
+			resourceTypes = append(resourceTypes, resourceType)
+		}
+		sort.Strings(resourceTypes)
+	}
+
+	// The newest versions of each type are merged, except for _at which needs all the versions before it
+	limit := offset + count
+	if historyOptions.At != nil {
+		limit = 0
+	}
+	var versions []historyVersion
+	total := 0
+	for _, resourceType := range resourceTypes {
+		typeVersions, typeTotal, err := ms.historyVersions(resourceType, id, historyOptions, limit)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, typeVersions...)
+		total += typeTotal
+	}
+	if historyOptions.At != nil {
+		versions = versionsCurrentAt(versions, historyOptions.At.RangeLowIncl())
+		total = len(versions)
+	}
+	sort.SliceStable(versions, func(i, j int) bool {
+		if !versions[i].lastUpdated.Equal(versions[j].lastUpdated) {
+			return versions[i].lastUpdated.After(versions[j].lastUpdated)
+		}
+		return versions[i].versionId > versions[j].versionId
+	})
+
+	if id != "" && total == 0 {
+		// The history of a resource that doesn't exist isn't found, but an empty page of one that does is
+		_, existing, err := ms.historyVersions(resourceType, id, HistoryOptions{}, 1)
+		if err != nil {
+			return nil, err
+		}
+		if existing == 0 {
+			return nil, ErrNotFound
+		}
+	}
+
+	if offset > len(versions) {
+		offset = len(versions)
+	}
+	if offset+count < len(versions) {
+		versions = versions[:offset+count]
+	}
+	versions = versions[offset:]
+
+	baseURLstr := strings.TrimSuffix(baseURL.String(), "/")
+	entries := make([]models2.ShallowBundleEntryComponent, 0, len(versions))
+	for _, version := range versions {
+		entries = append(entries, version.bundleEntry(baseURLstr))
+	}
+
+	historyURL := baseURL
+	historyURL.Path = strings.TrimSuffix(historyURL.Path, "/")
+	if resourceType != "" {
+		historyURL.Path += "/" + resourceType
+		if id != "" {
+			historyURL.Path += "/" + id
+		}
+	}
+	historyURL.Path += "/_history"
+
+	totalVersions := uint32(total)
+	bundle = &models2.ShallowBundle{
+		Id:    primitive.NewObjectID().Hex(),
+		Type:  "history",
+		Entry: entries,
+		Total: &totalVersions,
+		Link:  historyLinks(historyURL, historyOptions.query, offset, count, total),
+	}
+	return bundle, nil
+}
+
+// historyVersions returns the newest versions (up to the limit, if it isn't 0) of a resource instance (or of all
+// the resources of a type if the id is empty) that the _since and _at options allow, and how many there are
+func (ms *mongoSession) historyVersions(resourceType string, id string, historyOptions HistoryOptions, limit int) (versions []historyVersion, total int, err error) {
+	lastUpdated := bson.M{}
+	if !historyOptions.Since.IsZero() {
+		lastUpdated["$gte"] = historyOptions.Since
+	}
+	if historyOptions.At != nil {
+		lastUpdated["$lt"] = historyOptions.At.RangeHighExcl()
+	}
+
+	for _, previous := range []bool{false, true} {
+		collection := ms.CurrentVersionCollection(resourceType)
+		filter := bson.M{}
+		if id != "" {
+			filter["_id"] = id
+		}
+		if previous {
+			collection = ms.PreviousVersionsCollection(resourceType)
+			if id != "" {
+				filter = bson.M{"_id._id": id}
+			}
+		}
+		if len(lastUpdated) > 0 {
+			filter["meta.lastUpdated"] = lastUpdated
+		}
+
+		findOptions := options.Find().SetSort(bson.D{{"meta.lastUpdated", -1}})
+		if limit > 0 {
+			findOptions.SetLimit(int64(limit))
+		}
+		cursor, err := collection.Find(ms.context, filter, findOptions)
+		if err != nil {
+			return nil, 0, errors.Wrap(convertMongoErr(err), "History: Find failed")
+		}
+		found := 0
+		for cursor.Next(ms.context) {
+			var doc bson.Raw
+			if err := cursor.Decode(&doc); err != nil {
+				cursor.Close(ms.context)
+				return nil, 0, errors.Wrap(err, "History: cursor.Decode failed")
+			}
+			version, err := newHistoryVersion(resourceType, doc, previous)
+			if err != nil {
+				cursor.Close(ms.context)
+				return nil, 0, err
+			}
+			versions = append(versions, version)
+			found++
+		}
+		err = cursor.Err()
+		cursor.Close(ms.context)
+		if err != nil {
+			return nil, 0, errors.Wrap(convertMongoErr(err), "History: cursor error")
+		}
+
+		if limit > 0 && found == limit {
+			// There may be more versions than were found
+			count, err := collection.CountDocuments(ms.context, filter)
+			if err != nil {
+				return nil, 0, errors.Wrap(convertMongoErr(err), "History: CountDocuments failed")
+			}
+			total += int(count)
+		} else {
+			total += found
+		}
+	}
+	return versions, total, nil
+}
+
+// newHistoryVersion unmarshals a current version of a resource, or one in a _prev collection
+func newHistoryVersion(resourceType string, doc bson.Raw, previous bool) (version historyVersion, err error) {
+	version.resourceType = resourceType
+	version.lastUpdated, _ = doc.Lookup("meta", "lastUpdated").TimeOK()
+	versionId, _ := doc.Lookup("meta", "versionId").StringValueOK()
+	version.versionId, _ = strconv.Atoi(versionId)
+
+	if previous {
+		var deleted bool
+		deleted, version.resource, err = unmarshalPreviousVersion(&doc)
+		if err != nil {
+			return version, errors.Wrap(err, "History: unmarshalPreviousVersion failed")
+		}
+		version.id, _ = doc.Lookup("_id", "_id").StringValueOK()
+		if versionId, ok := doc.Lookup("_id", "_version").Int32OK(); ok {
+			version.versionId = int(versionId)
+		}
+		if deleted {
+			version.resource = nil
+		}
+		return version, nil
+	}
+
+	var currentDoc bson.D
+	if err = bson.Unmarshal(doc, &currentDoc); err != nil {
+		return version, errors.Wrap(err, "History: failed to unmarshal current version")
+	}
+	if version.resource, err = models2.NewResourceFromBSON(currentDoc); err != nil {
+		return version, errors.Wrap(err, "History: NewResourceFromBSON failed")
+	}
+	version.id = version.resource.Id()
+	return version, nil
+}
+
+// versionsCurrentAt returns the versions of each resource that were current at some point since a time (the start
+// of an _at period), given all those stored before its end.  These are the newest of each resource's versions,
+// and the older ones stored until it was replaced after the time.
+func versionsCurrentAt(versions []historyVersion, since time.Time) []historyVersion {
+	byResource := make(map[string][]historyVersion)
+	for _, version := range versions {
+		key := version.resourceType + "/" + version.id
+		byResource[key] = append(byResource[key], version)
+	}
+
+	var current []historyVersion
+	for _, resourceVersions := range byResource {
+		sort.Slice(resourceVersions, func(i, j int) bool {
+			return resourceVersions[i].versionId > resourceVersions[j].versionId
+		})
+		for i, version := range resourceVersions {
+			if i > 0 && !resourceVersions[i-1].lastUpdated.After(since) {
+				// replaced before the time
+				break
+			}
+			current = append(current, version)
+		}
+	}
+	return current
+}
+
+// bundleEntry returns the entry of a version in a history bundle
+func (v historyVersion) bundleEntry(baseURL string) models2.ShallowBundleEntryComponent {
+	entry := models2.ShallowBundleEntryComponent{
+		FullUrl:  baseURL + "/" + v.resourceType + "/" + v.id,
+		Resource: v.resource,
+		Request: &models.BundleEntryRequestComponent{
+			Method: "PUT",
+			Url:    v.resourceType + "/" + v.id,
+		},
+		Response: &models.BundleEntryResponseComponent{
+			Status: "200",
+			Etag:   "W/\"" + strconv.Itoa(v.versionId) + "\"",
+		},
+	}
+	if v.resource == nil {
+		entry.Request.Method = "DELETE"
+		entry.Response.Status = "204"
+	} else if v.versionId <= 1 {
+		entry.Request.Method = "POST"
+		entry.Request.Url = v.resourceType
+		entry.Response.Status = "201"
+	}
+	if !v.lastUpdated.IsZero() {
+		entry.Response.LastModified = &models.FHIRDateTime{Time: v.lastUpdated, Precision: models.Timestamp}
+	}
+	return entry
+}
@@ -9,7 +9,10 @@ import (
 	"os"
 	"strings"
 
-	mongowrapper "github.com/opencensus-integrations/gomongowrapper"
+	"github.com/eug48/fhir/auth"
+	"github.com/eug48/fhir/models"
+	"github.com/eug48/fhir/models2"
+	"github.com/eug48/fhir/search"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -17,17 +20,39 @@ import (
 
 // Indexer is the top-level interface for managing MongoDB indexes.
 type Indexer struct {
-	idxPath string
-	dbName  string
-	debug   bool
+	idxPath          string
+	searchIdxPath    string
+	dbName           string
+	debug            bool
+	textIndexes      bool
+	searchIndexes    bool
+	resultSets       bool
+	asyncJobs        bool
+	apiKeys          bool
+	subscriptions    bool
+	changeEvents     bool
+	lowercaseStrings bool
+	lowercaseTokens  bool
+	collation        *options.Collation
 }
 
 // NewIndexer returns a pointer to a newly configured Indexer.
 func NewIndexer(dbName string, config Config) *Indexer {
 	return &Indexer{
-		idxPath: config.IndexConfigPath,
-		dbName:  dbName,
-		debug:   config.Debug,
+		idxPath:          config.IndexConfigPath,
+		searchIdxPath:    config.SearchIndexConfigPath,
+		dbName:           dbName,
+		debug:            config.Debug,
+		textIndexes:      config.CreateTextIndexes,
+		searchIndexes:    config.CreateSearchIndexes,
+		resultSets:       config.ResultSetLifetime > 0,
+		asyncJobs:        config.EnableAsync,
+		apiKeys:          config.Auth.Method == auth.AuthTypeAPIKey,
+		subscriptions:    config.EnableSubscriptions,
+		changeEvents:     config.EventBusURL != "",
+		lowercaseStrings: config.LowercaseSearchFields && config.EnableCISearches,
+		lowercaseTokens:  config.LowercaseSearchFields && config.EnableCISearches && !config.TokenParametersCaseSensitive,
+		collation:        config.collation(),
 	}
 }
 
@@ -40,14 +65,45 @@ type IndexMap map[string][]mongo.IndexModel
 // creates a new index in the background using mgo.collection.EnsureIndex(). Depending
 // on the size of the collection it may take some time before the index is created.
 // This will block the current thread until the indexing completes, but will not block
-// other connections to the mongo database.
-func (i *Indexer) ConfigureIndexes(db *mongowrapper.WrappedDatabase) {
+// other connections to the mongo database.  Text indexes (if enabled) and an index on
+// meta.lastUpdated (for _lastUpdated searches and sorts) of every resource are also created, as are
+// indexes on the compartments stored with resources (for compartment searches), indexes for its search
+// parameters (if enabled, see ensureSearchIndexes) and a TTL index that deletes expired search result sets (if enabled, see search.ResultSet).  The indexes of the queue of asynchronous requests
+// (if enabled, see AsyncJob), a unique index on the hashes of API keys (if they're used, see auth.APIKey) and an
+// index on the resourceType of the indexed contained resources (see search.ContainedCollection) are also created.  If a collation is
+// configured, the indexes of indexes.conf and of search parameters are created with it.
+func (i *Indexer) ConfigureIndexes(db *mongo.Database) {
 	var err error
 	fmt.Println("Indexer: Ensuring indexes")
 
 	// TODO?
 	// worker.SetTimeout(5 * time.Minute) // Some indexes take a long time to build
 
+	i.ensureLastUpdatedIndexes(db)
+	i.ensureCompartmentsIndexes(db)
+	i.ensureContainedResourcesIndex(db)
+	if i.textIndexes {
+		i.ensureTextIndexes(db)
+	}
+	if i.searchIndexes {
+		i.ensureSearchIndexes(db)
+	}
+	if i.resultSets {
+		i.ensureResultSetsIndex(db)
+	}
+	if i.asyncJobs {
+		i.ensureAsyncJobsIndexes(db)
+	}
+	if i.apiKeys {
+		i.ensureAPIKeysIndex(db)
+	}
+	if i.subscriptions {
+		i.ensureSubscriptionEventsIndexes(db)
+	}
+	if i.changeEvents {
+		i.ensureChangeEventsIndex(db)
+	}
+
 	// Read the config file
 	f, err := os.Open(i.idxPath)
 	if err != nil {
@@ -73,6 +129,7 @@ func (i *Indexer) ConfigureIndexes(db *mongowrapper.WrappedDatabase) {
 				panic(err)
 			}
 
+			index.Options.Collation = i.collation
 			indexMap[collectionName] = append(indexMap[collectionName], *index)
 		}
 	}
@@ -94,6 +151,221 @@ func (i *Indexer) ConfigureIndexes(db *mongowrapper.WrappedDatabase) {
 	}
 }
 
+// ensureLastUpdatedIndexes creates an index on meta.lastUpdated on each resource collection, so that
+// clients can efficiently poll for changes using the _lastUpdated search parameter
+func (i *Indexer) ensureLastUpdatedIndexes(db *mongo.Database) {
+	for resource := range search.SearchParameters() {
+		collectionName := models.PluralizeLowerResourceName(resource)
+		index := lastUpdatedIndex()
+		i.log(fmt.Sprintf("Ensuring index: %s.%s: %s", i.dbName, collectionName, sprintIndexKeys(&index)))
+
+		_, err := db.Collection(collectionName).Indexes().CreateOne(context.Background(), index)
+		if err != nil {
+			i.log(fmt.Sprintf("[WARNING] Could not ensure lastUpdated index for: %s.%s: %s\n", i.dbName, collectionName, err.Error()))
+		}
+	}
+}
+
+// lastUpdatedIndex returns an index on meta.lastUpdated, which is used by the _lastUpdated search parameter
+func lastUpdatedIndex() mongo.IndexModel {
+	backgroundIndex := true
+	return mongo.IndexModel{
+		Keys:    bson.D{{Key: "meta.lastUpdated", Value: int32(1)}},
+		Options: &options.IndexOptions{Background: &backgroundIndex},
+	}
+}
+
+// ensureCompartmentsIndexes creates an index on the compartments stored with resources (see
+// search.SetResourceCompartments) on the collection of each resource type that may be in a compartment,
+// and on that of the indexed contained resources, for compartment searches
+func (i *Indexer) ensureCompartmentsIndexes(db *mongo.Database) {
+	collectionNames := map[string]bool{search.ContainedCollection: true}
+	for compartment := range search.CompartmentDefinitions {
+		for _, resource := range search.CompartmentResourceTypes(compartment) {
+			collectionNames[models.PluralizeLowerResourceName(resource)] = true
+		}
+	}
+	for collectionName := range collectionNames {
+		index := compartmentsIndex()
+		i.log(fmt.Sprintf("Ensuring index: %s.%s: %s", i.dbName, collectionName, sprintIndexKeys(&index)))
+
+		_, err := db.Collection(collectionName).Indexes().CreateOne(context.Background(), index)
+		if err != nil {
+			i.log(fmt.Sprintf("[WARNING] Could not ensure compartments index for: %s.%s: %s\n", i.dbName, collectionName, err.Error()))
+		}
+	}
+}
+
+// compartmentsIndex returns an index on the compartments stored with resources
+func compartmentsIndex() mongo.IndexModel {
+	backgroundIndex := true
+	return mongo.IndexModel{
+		Keys:    bson.D{{Key: models2.CompartmentsField, Value: int32(1)}},
+		Options: &options.IndexOptions{Background: &backgroundIndex},
+	}
+}
+
+// ensureResultSetsIndex creates the TTL index that deletes the snapshots of search results
+// stored for paging through them once they expire
+func (i *Indexer) ensureResultSetsIndex(db *mongo.Database) {
+	index := resultSetsExpiryIndex()
+	i.log(fmt.Sprintf("Ensuring index: %s.%s: %s", i.dbName, search.ResultSetsCollection, sprintIndexKeys(&index)))
+
+	_, err := db.Collection(search.ResultSetsCollection).Indexes().CreateOne(context.Background(), index)
+	if err != nil {
+		i.log(fmt.Sprintf("[WARNING] Could not ensure expiry index for: %s.%s: %s\n", i.dbName, search.ResultSetsCollection, err.Error()))
+	}
+}
+
+// resultSetsExpiryIndex returns a TTL index that deletes result sets at their expiry time
+func resultSetsExpiryIndex() mongo.IndexModel {
+	backgroundIndex := true
+	var expireAfterSeconds int32
+	return mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires", Value: int32(1)}},
+		Options: &options.IndexOptions{Background: &backgroundIndex, ExpireAfterSeconds: &expireAfterSeconds},
+	}
+}
+
+// ensureAsyncJobsIndexes creates the indexes of the queue of asynchronous requests: one for claiming the next job,
+// and a TTL index that deletes jobs once their responses expire
+func (i *Indexer) ensureAsyncJobsIndexes(db *mongo.Database) {
+	for _, index := range asyncJobsIndexes() {
+		i.log(fmt.Sprintf("Ensuring index: %s.%s: %s", i.dbName, AsyncJobsCollection, sprintIndexKeys(&index)))
+
+		_, err := db.Collection(AsyncJobsCollection).Indexes().CreateOne(context.Background(), index)
+		if err != nil {
+			i.log(fmt.Sprintf("[WARNING] Could not ensure index for: %s.%s: %s\n", i.dbName, AsyncJobsCollection, err.Error()))
+		}
+	}
+}
+
+// asyncJobsIndexes returns the indexes of the queue of asynchronous requests
+func asyncJobsIndexes() []mongo.IndexModel {
+	backgroundIndex := true
+	return []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "status", Value: int32(1)}, {Key: "created", Value: int32(1)}},
+			Options: &options.IndexOptions{Background: &backgroundIndex},
+		},
+		resultSetsExpiryIndex(),
+	}
+}
+
+// ensureAPIKeysIndex creates the unique index on the hashes of API keys, with which requests' keys are looked up
+func (i *Indexer) ensureAPIKeysIndex(db *mongo.Database) {
+	index := apiKeysIndex()
+	i.log(fmt.Sprintf("Ensuring index: %s.%s: %s", i.dbName, APIKeysCollection, sprintIndexKeys(&index)))
+
+	_, err := db.Collection(APIKeysCollection).Indexes().CreateOne(context.Background(), index)
+	if err != nil {
+		i.log(fmt.Sprintf("[WARNING] Could not ensure index for: %s.%s: %s\n", i.dbName, APIKeysCollection, err.Error()))
+	}
+}
+
+// apiKeysIndex returns the unique index on the hashes of API keys
+func apiKeysIndex() mongo.IndexModel {
+	backgroundIndex := true
+	unique := true
+	return mongo.IndexModel{
+		Keys:    bson.D{{Key: "hash", Value: int32(1)}},
+		Options: &options.IndexOptions{Background: &backgroundIndex, Unique: &unique},
+	}
+}
+
+// ensureSubscriptionEventsIndexes creates the indexes of the events of topic-based Subscriptions: one for the $events
+// operation, and a TTL index that deletes events once they expire
+func (i *Indexer) ensureSubscriptionEventsIndexes(db *mongo.Database) {
+	for _, index := range subscriptionEventsIndexes() {
+		i.log(fmt.Sprintf("Ensuring index: %s.%s: %s", i.dbName, SubscriptionEventsCollection, sprintIndexKeys(&index)))
+
+		_, err := db.Collection(SubscriptionEventsCollection).Indexes().CreateOne(context.Background(), index)
+		if err != nil {
+			i.log(fmt.Sprintf("[WARNING] Could not ensure index for: %s.%s: %s\n", i.dbName, SubscriptionEventsCollection, err.Error()))
+		}
+	}
+}
+
+// subscriptionEventsIndexes returns the indexes of the events of topic-based Subscriptions
+func subscriptionEventsIndexes() []mongo.IndexModel {
+	backgroundIndex := true
+	return []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "subscription", Value: int32(1)}, {Key: "number", Value: int32(1)}},
+			Options: &options.IndexOptions{Background: &backgroundIndex},
+		},
+		resultSetsExpiryIndex(),
+	}
+}
+
+// ensureChangeEventsIndex creates an index for claiming the oldest unclaimed events in the outbox of change events
+func (i *Indexer) ensureChangeEventsIndex(db *mongo.Database) {
+	backgroundIndex := true
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "leaseExpires", Value: int32(1)}, {Key: "timestamp", Value: int32(1)}},
+		Options: &options.IndexOptions{Background: &backgroundIndex},
+	}
+	i.log(fmt.Sprintf("Ensuring index: %s.%s: %s", i.dbName, ChangeEventsCollection, sprintIndexKeys(&index)))
+
+	_, err := db.Collection(ChangeEventsCollection).Indexes().CreateOne(context.Background(), index)
+	if err != nil {
+		i.log(fmt.Sprintf("[WARNING] Could not ensure index for: %s.%s: %s\n", i.dbName, ChangeEventsCollection, err.Error()))
+	}
+}
+
+// ensureContainedResourcesIndex creates an index on the resourceType of the contained resources indexed
+// for searches with _contained, all of which are in the same collection
+func (i *Indexer) ensureContainedResourcesIndex(db *mongo.Database) {
+	index := containedResourcesIndex(i.collation)
+	i.log(fmt.Sprintf("Ensuring index: %s.%s: %s", i.dbName, search.ContainedCollection, sprintIndexKeys(&index)))
+
+	_, err := db.Collection(search.ContainedCollection).Indexes().CreateOne(context.Background(), index)
+	if err != nil {
+		i.log(fmt.Sprintf("[WARNING] Could not ensure resourceType index for: %s.%s: %s\n", i.dbName, search.ContainedCollection, err.Error()))
+	}
+}
+
+// containedResourcesIndex returns an index on the resourceType of contained resources
+func containedResourcesIndex(collation *options.Collation) mongo.IndexModel {
+	backgroundIndex := true
+	return mongo.IndexModel{
+		Keys:    bson.D{{Key: "resourceType", Value: int32(1)}},
+		Options: &options.IndexOptions{Background: &backgroundIndex, Collation: collation},
+	}
+}
+
+// ensureTextIndexes creates a text index on each resource collection
+func (i *Indexer) ensureTextIndexes(db *mongo.Database) {
+	for resource := range search.SearchParameters() {
+		collectionName := models.PluralizeLowerResourceName(resource)
+		index := textIndex()
+		i.log(fmt.Sprintf("Ensuring index: %s.%s: %s", i.dbName, collectionName, sprintIndexKeys(&index)))
+
+		_, err := db.Collection(collectionName).Indexes().CreateOne(context.Background(), index)
+		if err != nil {
+			i.log(fmt.Sprintf("[WARNING] Could not ensure text index for: %s.%s: %s\n", i.dbName, collectionName, err.Error()))
+		}
+	}
+}
+
+// textIndex returns a text index on the whole resource, which is used by the _text and
+// _content search parameters.  A collection can only have one text index, so the narrative
+// is weighted more heavily rather than having an index of its own.
+func textIndex() mongo.IndexModel {
+	backgroundIndex := true
+	// By default MongoDB takes each document's language from its "language" field,
+	// but FHIR languages (e.g. en-US) aren't ones that MongoDB supports
+	languageOverride := "__textLanguage"
+	return mongo.IndexModel{
+		Keys: bson.D{{Key: "$**", Value: "text"}},
+		Options: &options.IndexOptions{
+			Background:       &backgroundIndex,
+			Weights:          bson.M{"text.div": 5},
+			LanguageOverride: &languageOverride,
+		},
+	}
+}
+
 func (i *Indexer) log(msg string) {
 	if i.debug {
 		log.Printf("Indexer: %s\n", msg)
@@ -185,16 +457,21 @@ func parseCompoundIndex(indexSpec string) (*mongo.IndexModel, error) {
 }
 
 // parseIndexKey converts the standard mongo index key format: "<key>_(-)1"
-// to the format used by mongo.IndexModel: "(-)<key>"
-func parseIndexKey(spec string) (key string, direction int32) {
-
-	if strings.HasSuffix(spec, "_1") {
+// to the format used by mongo.IndexModel: "(-)<key>".  Geospatial indexes
+// use the format "<key>_2dsphere".
+func parseIndexKey(spec string) (key string, direction interface{}) {
+
+	if strings.HasSuffix(spec, "_2dsphere") {
+		// geospatial
+		direction = "2dsphere"
+		key = strings.TrimSuffix(spec, "_2dsphere")
+	} else if strings.HasSuffix(spec, "_1") {
 		// ascending
-		direction = 1
+		direction = int32(1)
 		key = strings.TrimSuffix(spec, "_1")
 	} else if strings.HasSuffix(spec, "_-1") {
 		// descending
-		direction = -1
+		direction = int32(-1)
 		key = strings.TrimSuffix(spec, "_-1")
 	} else {
 		return "", 0 // error
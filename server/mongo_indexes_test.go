@@ -11,9 +11,9 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	mongowrapper "github.com/opencensus-integrations/gomongowrapper"
 	"github.com/stretchr/testify/suite"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/dbtest"
@@ -31,7 +31,7 @@ var expectedIndexes = []mgo.Index{
 type MongoIndexesTestSuite struct {
 	suite.Suite
 	DBServer       *dbtest.DBServer
-	client         *mongowrapper.WrappedClient
+	client         *mongo.Client
 	EST            *time.Location
 	Local          *time.Location
 	initialSession *mgo.Session
@@ -68,7 +68,7 @@ func (s *MongoIndexesTestSuite) SetupSuite() {
 	mgoSession := s.DBServer.Session()
 	defer mgoSession.Close()
 	serverUri := mgoSession.LiveServers()[0]
-	s.client, err = mongowrapper.Connect(context.TODO(), options.Client().ApplyURI("mongodb://"+serverUri))
+	s.client, err = mongo.Connect(context.TODO(), options.Client().ApplyURI("mongodb://"+serverUri))
 	if err != nil {
 		panic(err)
 	}
@@ -139,6 +139,19 @@ func (s *MongoIndexesTestSuite) TestParseIndexStandardIndexDesc() {
 	s.Equal(keys[0].Value.(int32), int32(-1), "The index key should be -1")
 }
 
+func (s *MongoIndexesTestSuite) TestParseIndexGeospatialIndex() {
+
+	indexStr := "locations.__position_2dsphere"
+	collectionName, index, err := parseIndex(indexStr)
+	keys := index.Keys.(bson.D)
+
+	s.Nil(err, "Should return without error")
+	s.Equal(collectionName, "locations", "Collection name should be 'locations'")
+	s.Equal(len(keys), 1, "The created index should contain one key")
+	s.Equal(keys[0].Key, "__position", "The index key should be '__position'")
+	s.Equal(keys[0].Value.(string), "2dsphere", "The index key should be 2dsphere")
+}
+
 func (s *MongoIndexesTestSuite) TestParseIndexCompoundIndexAsc() {
 
 	indexStr := "testcollection.(foo_1, bar_1)"
@@ -0,0 +1,317 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/eug48/fhir/models2"
+	"github.com/eug48/fhir/search"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxReportedReferences is the most references reported by an ErrReferenced error
+const maxReportedReferences = 10
+
+// checkReferences returns an ErrDanglingReferences error if a resource being created or updated refers to
+// resources that don't exist (or, in a transaction, when it's committed, as they may be created by it)
+func (ms *mongoSession) checkReferences(resource *models2.Resource) error {
+	localReferences, err := resource.LocalReferences()
+	if err != nil {
+		return errors.Wrap(err, "checkReferences: failed to find references")
+	}
+	if len(localReferences) == 0 {
+		return nil
+	}
+
+	source := resource.ResourceType() + "/" + resource.Id()
+	check := func() error {
+		dangling, err := ms.danglingReferences(source, localReferences, make(map[string]bool))
+		if err != nil {
+			return err
+		}
+		if len(dangling) > 0 {
+			return ErrDanglingReferences{References: dangling}
+		}
+		return nil
+	}
+	if ms.inTransaction {
+		ms.pendingChecks = append(ms.pendingChecks, check)
+		return nil
+	}
+	return check()
+}
+
+// referencedDeletesOf returns what happens when resources of a type that other resources refer to are deleted (see
+// Config.ReferencedDeletes)
+func (dal *mongoDataAccessLayer) referencedDeletesOf(resourceType string) string {
+	if policy, found := dal.referencedDeletesByType[resourceType]; found {
+		return policy
+	}
+	return dal.referencedDeletes
+}
+
+// handleReferencedDeletes applies the policy for deleting resources of a type that other resources refer to (see
+// Config.ReferencedDeletes) before those with the given IDs are deleted, returning the changes made to the resources
+// referring to them.  The resources being deleted (as Type/id) are added to deleting, and those already in it are
+// left alone, so that resources referring to each other are only deleted once by a cascade.
+func (ms *mongoSession) handleReferencedDeletes(resourceType string, ids []string, deleting map[string]bool) (changes []ReferenceChange, err error) {
+	for _, id := range ids {
+		deleting[resourceType+"/"+id] = true
+	}
+	policy := ms.dal.referencedDeletesOf(resourceType)
+	if policy != ReferencedDeletesBlock && policy != ReferencedDeletesNullify && policy != ReferencedDeletesCascade {
+		return nil, nil
+	}
+
+	// The references to resources that don't exist are already dangling
+	existing, err := ms.existingIDs(resourceType, ids)
+	if err != nil || len(existing) == 0 {
+		return nil, err
+	}
+	if policy == ReferencedDeletesBlock {
+		return nil, ms.checkNotReferenced(resourceType, existing)
+	}
+
+	// The referring resources are found a page at a time, until the search only finds those already handled
+	handled := make(map[string]bool)
+	for {
+		references, err := ms.ReferencesTo(resourceType, existing, maxReportedReferences)
+		if err != nil {
+			return changes, err
+		}
+		progressed := false
+		for _, reference := range references {
+			if deleting[reference.Source] || handled[reference.Source] {
+				continue
+			}
+			progressed = true
+			handled[reference.Source] = true
+			sourceTypeAndID := strings.SplitN(reference.Source, "/", 2)
+
+			if policy == ReferencedDeletesCascade {
+				_, cascaded, err := ms.delete(sourceTypeAndID[1], sourceTypeAndID[0], deleting)
+				if err == ErrNotFound {
+					continue
+				} else if err != nil {
+					return changes, errors.Wrapf(err, "failed to delete %s", reference.Source)
+				}
+				changes = append(changes, ReferenceChange{ResourceReference: reference, Deleted: true})
+				changes = append(changes, cascaded...)
+			} else {
+				removed, err := ms.removeReferences(sourceTypeAndID[0], sourceTypeAndID[1], resourceType, existing)
+				if err != nil {
+					return changes, errors.Wrapf(err, "failed to remove the references of %s", reference.Source)
+				}
+				if removed {
+					changes = append(changes, ReferenceChange{ResourceReference: reference})
+				}
+			}
+		}
+		if !progressed {
+			return changes, nil
+		}
+	}
+}
+
+// existingIDs returns those of the IDs of resources of a type that exist
+func (ms *mongoSession) existingIDs(resourceType string, ids []string) (existing []string, err error) {
+	idOnly := bson.D{{"_id", 1}}
+	cursor, err := ms.CurrentVersionCollection(resourceType).Find(ms.context, bson.D{{"_id", bson.D{{"$in", ids}}}}, options.Find().SetProjection(idOnly))
+	if err != nil {
+		return nil, errors.Wrap(convertMongoErr(err), "existingIDs: Find failed")
+	}
+	for cursor.Next(ms.context) {
+		if id, isString := cursor.Current.Lookup("_id").StringValueOK(); isString {
+			existing = append(existing, id)
+		}
+	}
+	err = cursor.Err()
+	cursor.Close(ms.context)
+	if err != nil {
+		return nil, errors.Wrap(convertMongoErr(err), "existingIDs: cursor error")
+	}
+	return existing, nil
+}
+
+// checkNotReferenced returns an ErrReferenced error if other resources refer to those of a type that are being
+// deleted (or, in a transaction, when it's committed, as they may be deleted by it too)
+func (ms *mongoSession) checkNotReferenced(resourceType string, ids []string) error {
+	check := func() error {
+		references, err := ms.ReferencesTo(resourceType, ids, maxReportedReferences)
+		if err != nil {
+			return err
+		}
+		if len(references) > 0 {
+			return ErrReferenced{References: references}
+		}
+		return nil
+	}
+	if ms.inTransaction {
+		ms.pendingChecks = append(ms.pendingChecks, check)
+		return nil
+	}
+	return check()
+}
+
+// removeReferences removes the references of a resource to those of a type with the given IDs (see
+// withoutReferences), returning whether it had any
+func (ms *mongoSession) removeReferences(sourceType string, sourceID string, resourceType string, ids []string) (removed bool, err error) {
+	source, err := ms.Get(sourceID, sourceType)
+	if err != nil {
+		return false, err
+	}
+	targets := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		targets[resourceType+"/"+id] = true
+	}
+	updated, count, err := withoutReferences(source, targets)
+	if err != nil || count == 0 {
+		return false, err
+	}
+
+	var conditionalVersionId string
+	if ms.dal.enableHistory {
+		conditionalVersionId = source.VersionId()
+	}
+	if _, err := ms.Put(sourceID, conditionalVersionId, updated); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (ms *mongoSession) ReferencesTo(resourceType string, ids []string, max int) (references []ResourceReference, err error) {
+	targets := make([]string, len(ids))
+	isTarget := make(map[string]bool, len(ids))
+	for i, id := range ids {
+		targets[i] = resourceType + "/" + id
+		isTarget[targets[i]] = true
+	}
+	if len(targets) == 0 {
+		return nil, nil
+	}
+
+	// Each resource referring to a target is reported once, with the first search parameter that found it
+	reported := make(map[ResourceReference]bool)
+	for _, revInclude := range searchRevIncludes(resourceType) {
+		sourceTypeAndParam := strings.SplitN(revInclude, ":", 2)
+		sourceType, param := sourceTypeAndParam[0], sourceTypeAndParam[1]
+
+		// The resources referring to any of the targets are searched for together, and then those referring to
+		// each of them if there are several
+		sourceIDs, err := ms.findReferringIDs(sourceType, param, targets, max)
+		if err != nil {
+			return nil, err
+		}
+		if len(sourceIDs) == 0 {
+			continue
+		}
+		for _, target := range targets {
+			if len(targets) > 1 {
+				if sourceIDs, err = ms.findReferringIDs(sourceType, param, []string{target}, max); err != nil {
+					return nil, err
+				}
+			}
+			for _, sourceID := range sourceIDs {
+				source := sourceType + "/" + sourceID
+				if isTarget[source] {
+					continue // refers to itself or is being deleted too
+				}
+				if reported[ResourceReference{Source: source, Target: target}] {
+					continue
+				}
+				reported[ResourceReference{Source: source, Target: target}] = true
+				references = append(references, ResourceReference{Source: source, Target: target, Element: revInclude})
+				if len(references) == max {
+					return references, nil
+				}
+			}
+		}
+	}
+	return references, nil
+}
+
+// findReferringIDs returns the IDs of the resources of a type whose reference search parameter refers to any of the
+// targets, finding enough to have max of them besides the targets themselves
+func (ms *mongoSession) findReferringIDs(sourceType string, param string, targets []string, max int) ([]string, error) {
+	query := search.Query{Resource: sourceType, Query: fmt.Sprintf("%s=%s&_count=%d", param, strings.Join(targets, ","), max+len(targets))}
+	IDs, err := ms.FindIDs(query)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to search for references (%s?%s)", query.Resource, query.Query)
+	}
+	return IDs, nil
+}
+
+func (ms *mongoSession) DanglingReferences(resourceTypes []string, max int) (references []ResourceReference, err error) {
+	if len(resourceTypes) == 0 {
+		resourceTypes = make([]string, 0, len(search.SearchParameters()))
+		for resourceType := range search.SearchParameters() {
+			resourceTypes = append(resourceTypes, resourceType)
+		}
+		sort.Strings(resourceTypes)
+	}
+
+	exists := make(map[string]bool)
+	for _, resourceType := range resourceTypes {
+		cursor, err := ms.CurrentVersionCollection(resourceType).Find(ms.context, bson.D{}, options.Find().SetSort(bson.D{{"_id", 1}}))
+		if err != nil {
+			return nil, errors.Wrap(convertMongoErr(err), "DanglingReferences: Find failed")
+		}
+		for cursor.Next(ms.context) {
+			var doc bson.D
+			if err := cursor.Decode(&doc); err != nil {
+				cursor.Close(ms.context)
+				return nil, errors.Wrap(err, "DanglingReferences: cursor.Decode failed")
+			}
+			var id string
+			for _, elem := range doc {
+				if elem.Key == "_id" {
+					id, _ = elem.Value.(string)
+				}
+			}
+
+			dangling, err := ms.danglingReferences(resourceType+"/"+id, models2.LocalReferencesOfBSON(doc, resourceType), exists)
+			if err != nil {
+				cursor.Close(ms.context)
+				return nil, err
+			}
+			references = append(references, dangling...)
+			if len(references) >= max {
+				cursor.Close(ms.context)
+				return references[:max], nil
+			}
+		}
+		err = cursor.Err()
+		cursor.Close(ms.context)
+		if err != nil {
+			return nil, errors.Wrap(convertMongoErr(err), "DanglingReferences: cursor error")
+		}
+	}
+	return references, nil
+}
+
+// danglingReferences returns the references of a resource to resources that don't exist, caching whether each
+// resource exists
+func (ms *mongoSession) danglingReferences(source string, localReferences []models2.LocalReference, exists map[string]bool) (dangling []ResourceReference, err error) {
+	for _, reference := range localReferences {
+		target := reference.Target()
+		if target == source {
+			continue
+		}
+		found, checked := exists[target]
+		if !checked {
+			count, err := ms.CurrentVersionCollection(reference.Type).CountDocuments(ms.context, bson.D{{"_id", reference.ID}}, options.Count().SetLimit(1))
+			if err != nil {
+				return nil, errors.Wrapf(convertMongoErr(err), "failed to check whether %s exists", target)
+			}
+			found = count > 0
+			exists[target] = found
+		}
+		if !found {
+			dangling = append(dangling, ResourceReference{Source: source, Target: target, Element: reference.Expression})
+		}
+	}
+	return dangling, nil
+}
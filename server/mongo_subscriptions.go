@@ -0,0 +1,299 @@
+package server
+
+import (
+	"strings"
+	"time"
+
+	"github.com/eug48/fhir/models2"
+	"github.com/eug48/fhir/search"
+	"github.com/golang/glog"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The collections of the events of topic-based Subscriptions, and of their numbers of events and when they were last
+// notified
+const (
+	SubscriptionEventsCollection = "subscriptionevents"
+	SubscriptionStatusCollection = "subscriptionstatus"
+)
+
+// SubscriptionEvent is an event of a topic-based Subscription: its focus is the resource that triggered its topic
+// (e.g. Encounter/123), and its number is its position in the events of the Subscription, starting from 1
+type SubscriptionEvent struct {
+	Id           string    `bson:"_id"`
+	Subscription string    `bson:"subscription"`
+	Number       int64     `bson:"number"`
+	Timestamp    time.Time `bson:"timestamp"`
+	Focus        string    `bson:"focus"`
+	Expires      time.Time `bson:"expires"`
+}
+
+// resourceStored notifies the Subscriptions (if enabled) of a created (or else updated) resource, or if it was
+// stored in a transaction, once it's committed.  Notifications are best-effort, so failures are logged rather than
+// failing the change.
+func (ms *mongoSession) resourceStored(resource *models2.Resource, created bool) {
+	if ms.dal.subscriptions == nil {
+		return
+	}
+	jsonBytes, err := resource.MarshalJSON()
+	if err != nil {
+		glog.Errorf("failed to notify the Subscriptions of %s/%s: %+v", resource.ResourceType(), resource.Id(), err)
+		return
+	}
+	stored := storedResource{resourceType: resource.ResourceType(), id: resource.Id(), json: jsonBytes, created: created}
+	if ms.inTransaction {
+		ms.storedResources = append(ms.storedResources, stored)
+		return
+	}
+	ms.dal.subscriptions.notify(ms.dbName, []storedResource{stored})
+}
+
+// activeSubscriptions returns the rest-hook and websocket Subscriptions that are notified of changes (including
+// those in the error state, which are retried), turning off those that have ended.  Topic-based Subscriptions whose
+// topics no longer exist are skipped.
+func (ms *mongoSession) activeSubscriptions() ([]*activeSubscription, error) {
+	filter := bson.M{
+		"status":       bson.M{"$in": bson.A{SubscriptionActive, SubscriptionError}},
+		"channel.type": bson.M{"$in": bson.A{"rest-hook", "websocket"}},
+	}
+	cursor, err := ms.CurrentVersionCollection("Subscription").Find(ms.context, filter)
+	if err != nil {
+		return nil, errors.Wrap(convertMongoErr(err), "activeSubscriptions: Find failed")
+	}
+	defer cursor.Close(ms.context)
+
+	var subscriptions []*activeSubscription
+	topics := make(map[string]*subscriptionTopic)
+	for cursor.Next(ms.context) {
+		var doc bson.D
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, errors.Wrap(err, "activeSubscriptions: Decode failed")
+		}
+		resource, err := models2.NewResourceFromBSON(doc)
+		if err != nil {
+			return nil, errors.Wrap(err, "activeSubscriptions: NewResourceFromBSON failed")
+		}
+		subscription := &activeSubscription{}
+		if err := resource.Unmarshal(&subscription.Subscription); err != nil {
+			return nil, errors.Wrap(err, "activeSubscriptions: failed to parse a Subscription")
+		}
+		subscription.Id = resource.Id()
+
+		if subscription.End != nil && subscription.End.Time.Before(time.Now()) {
+			update := bson.M{"$set": bson.M{"status": SubscriptionOff}}
+			if _, err := ms.CurrentVersionCollection("Subscription").UpdateOne(ms.context, bson.M{"_id": subscription.Id}, update); err != nil {
+				return nil, errors.Wrap(convertMongoErr(err), "activeSubscriptions: failed to turn off an ended Subscription")
+			}
+			continue
+		}
+
+		if isTopicCriteria(subscription.Criteria) {
+			if subscription.backport, err = parseBackportSubscription(resource); err != nil {
+				glog.Errorf("skipping Subscription/%s: %s", subscription.Id, err)
+				continue
+			}
+			topic, found := topics[subscription.Criteria]
+			if !found {
+				if topic, err = ms.subscriptionTopic(subscription.Criteria); err != nil {
+					return nil, errors.Wrap(err, "activeSubscriptions: failed to get a SubscriptionTopic")
+				}
+				topics[subscription.Criteria] = topic
+			}
+			if topic == nil {
+				glog.Errorf("skipping Subscription/%s: SubscriptionTopic %s doesn't exist", subscription.Id, subscription.Criteria)
+				continue
+			}
+			subscription.backport.topic = topic
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, errors.Wrap(cursor.Err(), "activeSubscriptions: cursor failed")
+}
+
+// matchesSubscription returns whether a stored resource matches the criteria of a Subscription, by searching for
+// it with them
+func (ms *mongoSession) matchesSubscription(subscription *activeSubscription, resource storedResource) (bool, error) {
+	query, err := parseSubscriptionCriteria(subscription.Criteria)
+	if err != nil || query.Resource != resource.resourceType {
+		return false, nil
+	}
+	if query.Query != "" {
+		query.Query += "&"
+	}
+	query.Query += "_id=" + resource.id
+	ids, err := ms.FindIDs(query)
+	if err != nil {
+		return false, err
+	}
+	return len(ids) > 0, nil
+}
+
+// subscriptionTopic returns the SubscriptionTopic with a canonical URL, or nil if there's no such topic
+func (ms *mongoSession) subscriptionTopic(url string) (*subscriptionTopic, error) {
+	filter := bson.M{"code.coding.code": "SubscriptionTopic"}
+	cursor, err := ms.CurrentVersionCollection("Basic").Find(ms.context, filter)
+	if err != nil {
+		return nil, errors.Wrap(convertMongoErr(err), "subscriptionTopic: Find failed")
+	}
+	defer cursor.Close(ms.context)
+
+	for cursor.Next(ms.context) {
+		var doc bson.D
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, errors.Wrap(err, "subscriptionTopic: Decode failed")
+		}
+		resource, err := models2.NewResourceFromBSON(doc)
+		if err != nil {
+			return nil, errors.Wrap(err, "subscriptionTopic: NewResourceFromBSON failed")
+		}
+		topic, err := parseSubscriptionTopic(resource)
+		if err != nil {
+			return nil, err
+		} else if topic != nil && topic.URL == url {
+			return topic, nil
+		}
+	}
+	return nil, errors.Wrap(cursor.Err(), "subscriptionTopic: cursor failed")
+}
+
+// matchesSubscriptionTopic returns whether a stored resource triggers the topic of a topic-based Subscription: whether
+// it was created or updated as one of the topic's triggers allows, and matches its criteria and the Subscription's
+// filters of its type, by searching for it with them
+func (ms *mongoSession) matchesSubscriptionTopic(subscription *activeSubscription, resource storedResource) (bool, error) {
+	interaction := "update"
+	if resource.created {
+		interaction = "create"
+	}
+	for _, trigger := range subscription.backport.topic.Triggers {
+		if trigger.ResourceType != resource.resourceType {
+			continue
+		}
+		supported := len(trigger.Interactions) == 0
+		for _, supportedInteraction := range trigger.Interactions {
+			supported = supported || supportedInteraction == interaction
+		}
+		if !supported {
+			continue
+		}
+
+		params := []string{"_id=" + resource.id}
+		if trigger.Criteria != "" {
+			params = append(params, trigger.Criteria)
+		}
+		for _, filter := range subscription.backport.filters {
+			if query, err := parseSubscriptionCriteria(filter); err == nil && query.Resource == resource.resourceType && query.Query != "" {
+				params = append(params, query.Query)
+			}
+		}
+		ids, err := ms.FindIDs(search.Query{Resource: resource.resourceType, Query: strings.Join(params, "&")})
+		if err != nil {
+			return false, err
+		} else if len(ids) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// setSubscriptionError puts an active Subscription whose notification failed in the error state, or an errored one
+// whose notification succeeded back in the active state (if message is empty).  Only the status and error of the
+// Subscription are updated, as they're managed by the server, and Subscriptions whose status has since been changed
+// (e.g. turned off) are left alone.
+func (ms *mongoSession) setSubscriptionError(id string, message string) error {
+	var filter, update bson.M
+	if message != "" {
+		filter = bson.M{"_id": id, "status": bson.M{"$in": bson.A{SubscriptionActive, SubscriptionError}}}
+		update = bson.M{"$set": bson.M{"status": SubscriptionError, "error": message}}
+	} else {
+		filter = bson.M{"_id": id, "status": SubscriptionError}
+		update = bson.M{"$set": bson.M{"status": SubscriptionActive}, "$unset": bson.M{"error": ""}}
+	}
+	_, err := ms.CurrentVersionCollection("Subscription").UpdateOne(ms.context, filter, update)
+	return errors.Wrap(convertMongoErr(err), "setSubscriptionError: UpdateOne failed")
+}
+
+// recordSubscriptionEvent records the next event of a topic-based Subscription, which is kept for lifetime (so that it
+// can be returned by the $events operation)
+func (ms *mongoSession) recordSubscriptionEvent(subscription string, focus string, lifetime time.Duration) (SubscriptionEvent, error) {
+	update := bson.M{"$inc": bson.M{"events": int64(1)}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var status struct {
+		Events int64 `bson:"events"`
+	}
+	err := ms.db.Collection(SubscriptionStatusCollection).FindOneAndUpdate(ms.context, bson.M{"_id": subscription}, update, opts).Decode(&status)
+	if err != nil {
+		return SubscriptionEvent{}, errors.Wrap(convertMongoErr(err), "recordSubscriptionEvent: failed to number the event")
+	}
+
+	now := time.Now()
+	event := SubscriptionEvent{
+		Id:           uuid.New().String(),
+		Subscription: subscription,
+		Number:       status.Events,
+		Timestamp:    now,
+		Focus:        focus,
+		Expires:      now.Add(lifetime),
+	}
+	_, err = ms.db.Collection(SubscriptionEventsCollection).InsertOne(ms.context, event)
+	return event, errors.Wrap(convertMongoErr(err), "recordSubscriptionEvent: InsertOne failed")
+}
+
+// SubscriptionEventCount returns the number of events of a topic-based Subscription since it started
+func (ms *mongoSession) SubscriptionEventCount(subscription string) (int64, error) {
+	var status struct {
+		Events int64 `bson:"events"`
+	}
+	err := ms.db.Collection(SubscriptionStatusCollection).FindOne(ms.context, bson.M{"_id": subscription}).Decode(&status)
+	if err = convertMongoErr(err); err == ErrNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, errors.Wrap(err, "SubscriptionEventCount: FindOne failed")
+	}
+	return status.Events, nil
+}
+
+// SubscriptionEvents returns the events of a topic-based Subscription numbered from since to until (inclusive) that
+// haven't expired, in order
+func (ms *mongoSession) SubscriptionEvents(subscription string, since int64, until int64) ([]SubscriptionEvent, error) {
+	filter := bson.M{"subscription": subscription, "number": bson.M{"$gte": since, "$lte": until}}
+	opts := options.Find().SetSort(bson.D{{Key: "number", Value: 1}})
+	cursor, err := ms.db.Collection(SubscriptionEventsCollection).Find(ms.context, filter, opts)
+	if err != nil {
+		return nil, errors.Wrap(convertMongoErr(err), "SubscriptionEvents: Find failed")
+	}
+	defer cursor.Close(ms.context)
+
+	var events []SubscriptionEvent
+	for cursor.Next(ms.context) {
+		var event SubscriptionEvent
+		if err := cursor.Decode(&event); err != nil {
+			return nil, errors.Wrap(err, "SubscriptionEvents: Decode failed")
+		}
+		events = append(events, event)
+	}
+	return events, errors.Wrap(cursor.Err(), "SubscriptionEvents: cursor failed")
+}
+
+// subscriptionNotified records that a topic-based Subscription was sent a notification, so that it isn't sent a
+// heartbeat until its heartbeat period has passed
+func (ms *mongoSession) subscriptionNotified(subscription string) error {
+	update := bson.M{"$set": bson.M{"lastNotified": time.Now()}}
+	_, err := ms.db.Collection(SubscriptionStatusCollection).UpdateOne(ms.context, bson.M{"_id": subscription}, update, options.Update().SetUpsert(true))
+	return errors.Wrap(convertMongoErr(err), "subscriptionNotified: UpdateOne failed")
+}
+
+// claimSubscriptionHeartbeat returns whether a topic-based Subscription is due a heartbeat, as it hasn't been
+// notified for its heartbeat period, recording that it's been notified if it is (so that only one server sends it)
+func (ms *mongoSession) claimSubscriptionHeartbeat(subscription string, period time.Duration) (bool, error) {
+	now := time.Now()
+	filter := bson.M{"_id": subscription, "lastNotified": bson.M{"$lte": now.Add(-period)}}
+	update := bson.M{"$set": bson.M{"lastNotified": now}}
+	result, err := ms.db.Collection(SubscriptionStatusCollection).UpdateOne(ms.context, filter, update)
+	if err != nil {
+		return false, errors.Wrap(convertMongoErr(err), "claimSubscriptionHeartbeat: UpdateOne failed")
+	}
+	return result.ModifiedCount > 0, nil
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Tenants returns every tenant, by id
+func (ms *mongoSession) Tenants() ([]Tenant, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := ms.db.Collection(TenantsCollection).Find(ms.context, bson.M{}, opts)
+	if err != nil {
+		return nil, errors.Wrap(convertMongoErr(err), "failed to find the tenants")
+	}
+	defer cursor.Close(ms.context)
+
+	tenants := []Tenant{}
+	if err := cursor.All(ms.context, &tenants); err != nil {
+		return nil, errors.Wrap(convertMongoErr(err), "failed to read the tenants")
+	}
+	return tenants, nil
+}
+
+// GetTenant returns a tenant, or ErrNotFound if there's no such tenant
+func (ms *mongoSession) GetTenant(id string) (*Tenant, error) {
+	var tenant Tenant
+	err := ms.db.Collection(TenantsCollection).FindOne(ms.context, bson.M{"_id": id}).Decode(&tenant)
+	if err = convertMongoErr(err); err != nil && err != ErrNotFound {
+		return nil, errors.Wrap(err, "failed to get the tenant")
+	}
+	return &tenant, err
+}
+
+// ProvisionTenant stores a tenant and then creates its database, with the collections that transactions need and
+// (if the server creates them) its indexes, so that the tenant can't be provisioned twice at once
+func (ms *mongoSession) ProvisionTenant(tenant *Tenant) error {
+	_, err := ms.db.Collection(TenantsCollection).InsertOne(ms.context, tenant)
+	if writeErr, ok := err.(mongo.WriteException); ok && len(writeErr.WriteErrors) > 0 && writeErr.WriteErrors[0].Code == 11000 {
+		return ErrConflict{msg: fmt.Sprintf("tenant %s already exists", tenant.Id)}
+	} else if err != nil {
+		return errors.Wrap(convertMongoErr(err), "failed to store the tenant")
+	}
+
+	err = func() (err error) {
+		// CreateCollectionsWithCollation panics when it fails, as it's run when the server starts
+		defer func() {
+			if r := recover(); r != nil {
+				err = errors.Errorf("%v", r)
+			}
+		}()
+		defer ms.dal.migrations.start("database of tenant " + tenant.Id)()
+		db := ms.dal.client.Database(tenant.Database)
+		CreateCollectionsWithCollation(db, ms.dal.collation)
+		if ms.dal.tenantIndexer != nil {
+			ms.dal.tenantIndexer(tenant.Database).ConfigureIndexes(db)
+		}
+		return nil
+	}()
+	if err != nil {
+		// the tenant can be provisioned again
+		ms.db.Collection(TenantsCollection).DeleteOne(ms.context, bson.M{"_id": tenant.Id})
+		return errors.Wrapf(err, "failed to create the database of tenant %s", tenant.Id)
+	}
+	return nil
+}
+
+// DeprovisionTenant deletes a tenant and then drops its database and its cached search totals
+func (ms *mongoSession) DeprovisionTenant(id string) error {
+	var tenant Tenant
+	err := ms.db.Collection(TenantsCollection).FindOneAndDelete(ms.context, bson.M{"_id": id}).Decode(&tenant)
+	if err = convertMongoErr(err); err == ErrNotFound {
+		return err
+	} else if err != nil {
+		return errors.Wrap(err, "failed to delete the tenant")
+	}
+
+	if err := ms.dal.client.Database(tenant.Database).Drop(ms.requestContext); err != nil {
+		return errors.Wrapf(convertMongoErr(err), "failed to drop the database of tenant %s", id)
+	}
+	if ms.dal.countCacheRedisPool != nil {
+		// those cached in MongoDB were dropped with the database
+		if err := ms.dal.redisCountCache(tenant.Database).Clear(ms.requestContext); err != nil {
+			return errors.Wrapf(err, "failed to clear the count cache of tenant %s", id)
+		}
+	}
+	return nil
+}
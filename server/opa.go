@@ -0,0 +1,79 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// How long OPAAuthorizationDecider waits for a decision
+const opaTimeout = 5 * time.Second
+
+// OPAAuthorizationDecider is an AuthorizationDecider that queries a decision of Open Policy Agent
+// (https://www.openpolicyagent.org/docs/latest/rest-api/#get-a-document-with-input), e.g.
+// http://localhost:8181/v1/data/fhir/authz, with the AuthorizationRequest as its input (and the parameters of its
+// query, if any, as input.parameters).  The decision's result is either a boolean or an object like
+// {"allow": false, "reason": "..."}, and interactions are denied if it's undefined.
+type OPAAuthorizationDecider struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewOPAAuthorizationDecider(decisionURL string) *OPAAuthorizationDecider {
+	return &OPAAuthorizationDecider{URL: decisionURL, Client: &http.Client{Timeout: opaTimeout}}
+}
+
+type opaInput struct {
+	*AuthorizationRequest
+	Query      string     `json:"query,omitempty"`
+	Parameters url.Values `json:"parameters,omitempty"`
+}
+
+func (d *OPAAuthorizationDecider) Decide(ctx context.Context, request *AuthorizationRequest) (AuthorizationDecision, error) {
+	input := opaInput{AuthorizationRequest: request}
+	if request.Query != nil && request.Query.Query != "" {
+		input.Query = request.Query.Query
+		input.Parameters, _ = url.ParseQuery(request.Query.Query)
+	}
+	body, err := json.Marshal(map[string]interface{}{"input": input})
+	if err != nil {
+		return AuthorizationDecision{}, errors.Wrap(err, "OPAAuthorizationDecider: failed to encode the input")
+	}
+	httpRequest, err := http.NewRequest("POST", d.URL, bytes.NewReader(body))
+	if err != nil {
+		return AuthorizationDecision{}, errors.Wrap(err, "OPAAuthorizationDecider: invalid URL")
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	response, err := d.Client.Do(httpRequest.WithContext(ctx))
+	if err != nil {
+		return AuthorizationDecision{}, errors.Wrap(err, "OPAAuthorizationDecider: request failed")
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return AuthorizationDecision{}, fmt.Errorf("OPAAuthorizationDecider: Open Policy Agent responded with %s", response.Status)
+	}
+
+	var decision struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&decision); err != nil {
+		return AuthorizationDecision{}, errors.Wrap(err, "OPAAuthorizationDecider: failed to decode the decision")
+	}
+	var allow bool
+	if len(decision.Result) == 0 || string(decision.Result) == "null" {
+		return AuthorizationDecision{Reason: "No access policy applies"}, nil
+	} else if err := json.Unmarshal(decision.Result, &allow); err == nil {
+		return AuthorizationDecision{Allow: allow}, nil
+	}
+	var result AuthorizationDecision
+	if err := json.Unmarshal(decision.Result, &result); err != nil {
+		return AuthorizationDecision{}, errors.Wrap(err, "OPAAuthorizationDecider: the decision isn't a boolean or an object with allow")
+	}
+	return result, nil
+}
@@ -0,0 +1,408 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/eug48/fhir/models2"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// A PATCH request (see ResourceController.PatchHandler) changes a resource with either a JSON Patch
+// (https://tools.ietf.org/html/rfc6902) or a FHIRPath Patch (see fhirpath_patch.go).  Both are applied
+// to the resource's JSON, decoded into maps and slices (with numbers kept as json.Number so that
+// decimals aren't changed), which are changed in place where possible.
+
+// ErrInvalidPatch indicates that a patch document is malformed (HTTP 400)
+type ErrInvalidPatch struct {
+	msg string
+}
+
+func (e ErrInvalidPatch) Error() string {
+	return e.msg
+}
+
+// ErrPatchFailed indicates that a patch can't be applied to a resource, e.g. as an element it
+// changes doesn't exist or one of its tests failed (HTTP 422)
+type ErrPatchFailed struct {
+	msg string
+}
+
+func (e ErrPatchFailed) Error() string {
+	return e.msg
+}
+
+// resourcePatch is a parsed patch document
+type resourcePatch interface {
+	// apply changes the decoded JSON of a resource, returning the changed JSON
+	apply(doc map[string]interface{}, resourceType string) (interface{}, error)
+}
+
+// bindPatch parses the patch document of a PATCH request: a JSON Patch if its content type is
+// application/json-patch+json, or else a FHIRPath Patch (a Parameters resource in JSON or XML)
+func bindPatch(c *gin.Context, validatorURL string) (resourcePatch, error) {
+	if strings.Contains(c.ContentType(), "json-patch") {
+		body, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			return nil, errors.Wrap(err, "bindPatch: failed to read request body")
+		}
+		return parseJSONPatch(body)
+	}
+
+	parameters, err := FHIRBind(c, validatorURL)
+	if err != nil {
+		return nil, err
+	}
+	if parameters.ResourceType() != "Parameters" {
+		return nil, ErrInvalidPatch{msg: "A PATCH must be a JSON Patch (application/json-patch+json) or a FHIRPath Patch Parameters resource"}
+	}
+	return parseFHIRPathPatch(parameters.JsonBytes())
+}
+
+// applyPatch returns a copy of a resource changed by a patch, which mustn't change its type or id
+func applyPatch(resource *models2.Resource, patch resourcePatch) (*models2.Resource, error) {
+	jsonBytes, err := resource.MarshalJSON()
+	if err != nil {
+		return nil, errors.Wrap(err, "applyPatch: MarshalJSON failed")
+	}
+	var doc map[string]interface{}
+	if err := decodeJSON(jsonBytes, &doc); err != nil {
+		return nil, errors.Wrap(err, "applyPatch: failed to decode resource")
+	}
+
+	patched, err := patch.apply(doc, resource.ResourceType())
+	if err != nil {
+		return nil, err
+	}
+	patchedDoc, ok := patched.(map[string]interface{})
+	if !ok || patchedDoc["resourceType"] != resource.ResourceType() {
+		return nil, ErrPatchFailed{msg: "A patch can't change the resourceType of a resource"}
+	}
+	if patchedDoc["id"] != resource.Id() {
+		return nil, ErrPatchFailed{msg: "A patch can't change the id of a resource"}
+	}
+
+	var patchedBytes bytes.Buffer
+	encoder := json.NewEncoder(&patchedBytes)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(patchedDoc); err != nil {
+		return nil, errors.Wrap(err, "applyPatch: failed to encode patched resource")
+	}
+	return models2.NewResourceFromJsonBytes(patchedBytes.Bytes())
+}
+
+// decodeJSON decodes JSON keeping numbers as json.Number
+func decodeJSON(jsonBytes []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(jsonBytes))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// jsonEqual checks if two decoded JSON values are equal, comparing numbers by value
+func jsonEqual(a, b interface{}) bool {
+	switch a := a.(type) {
+	case json.Number:
+		bNumber, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		aFloat, aErr := a.Float64()
+		bFloat, bErr := bNumber.Float64()
+		return aErr == nil && bErr == nil && aFloat == bFloat
+	case map[string]interface{}:
+		bMap, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(bMap) {
+			return false
+		}
+		for key, value := range a {
+			bValue, ok := bMap[key]
+			if !ok || !jsonEqual(value, bValue) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		bSlice, ok := b.([]interface{})
+		if !ok || len(a) != len(bSlice) {
+			return false
+		}
+		for i := range a {
+			if !jsonEqual(a[i], bSlice[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+// copyJSON returns a deep copy of a decoded JSON value
+func copyJSON(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(value))
+		for key, v := range value {
+			copied[key] = copyJSON(v)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(value))
+		for i, v := range value {
+			copied[i] = copyJSON(v)
+		}
+		return copied
+	default:
+		return value
+	}
+}
+
+// jsonPatch is a JSON Patch: a list of operations applied in turn
+type jsonPatch []jsonPatchOperation
+
+type jsonPatchOperation struct {
+	op       string
+	path     []string // the reference tokens of the JSON Pointer
+	from     []string
+	value    interface{}
+	pointer  string // the path as given, for error messages
+	hasValue bool
+}
+
+// parseJSONPatch parses and checks a JSON Patch document
+func parseJSONPatch(body []byte) (jsonPatch, error) {
+	var operations []map[string]interface{}
+	if err := decodeJSON(body, &operations); err != nil {
+		return nil, ErrInvalidPatch{msg: fmt.Sprintf("A JSON Patch must be an array of operations: %s", err)}
+	}
+
+	patch := make(jsonPatch, 0, len(operations))
+	for i, operation := range operations {
+		op, _ := operation["op"].(string)
+		path, hasPath := operation["path"].(string)
+		if !hasPath {
+			return nil, ErrInvalidPatch{msg: fmt.Sprintf("JSON Patch operation %d has no path", i)}
+		}
+		parsed := jsonPatchOperation{op: op, pointer: path}
+		parsed.value, parsed.hasValue = operation["value"]
+
+		var err error
+		if parsed.path, err = parseJSONPointer(path); err != nil {
+			return nil, err
+		}
+		switch op {
+		case "add", "replace", "test":
+			if !parsed.hasValue {
+				return nil, ErrInvalidPatch{msg: fmt.Sprintf("JSON Patch operation %d (%s) has no value", i, op)}
+			}
+		case "remove":
+		case "move", "copy":
+			from, hasFrom := operation["from"].(string)
+			if !hasFrom {
+				return nil, ErrInvalidPatch{msg: fmt.Sprintf("JSON Patch operation %d (%s) has no from", i, op)}
+			}
+			if parsed.from, err = parseJSONPointer(from); err != nil {
+				return nil, err
+			}
+			if op == "move" && strings.HasPrefix(path, from+"/") {
+				return nil, ErrInvalidPatch{msg: fmt.Sprintf("JSON Patch operation %d can't move %s into one of its children", i, from)}
+			}
+		default:
+			return nil, ErrInvalidPatch{msg: fmt.Sprintf("JSON Patch operation %d has an unknown op: %q", i, op)}
+		}
+		patch = append(patch, parsed)
+	}
+	return patch, nil
+}
+
+// parseJSONPointer returns the reference tokens of a JSON Pointer (https://tools.ietf.org/html/rfc6901)
+func parseJSONPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, ErrInvalidPatch{msg: fmt.Sprintf("JSON Pointer %q doesn't start with /", pointer)}
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+func (p jsonPatch) apply(doc map[string]interface{}, resourceType string) (interface{}, error) {
+	var patched interface{} = doc
+	for _, operation := range p {
+		var err error
+		if patched, err = operation.apply(patched); err != nil {
+			return nil, err
+		}
+	}
+	return patched, nil
+}
+
+func (o jsonPatchOperation) apply(doc interface{}) (interface{}, error) {
+	switch o.op {
+	case "add":
+		return jsonPointerAdd(doc, o.path, o.value)
+	case "remove":
+		_, doc, err := jsonPointerRemove(doc, o.path)
+		return doc, err
+	case "replace":
+		if len(o.path) == 0 {
+			return o.value, nil
+		}
+		return jsonPointerUpdate(doc, o.path, func(container interface{}, token string) (interface{}, error) {
+			if _, err := jsonChild(container, token); err != nil {
+				return nil, err
+			}
+			return jsonSetChild(container, token, o.value), nil
+		})
+	case "move":
+		value, doc, err := jsonPointerRemove(doc, o.from)
+		if err != nil {
+			return nil, err
+		}
+		return jsonPointerAdd(doc, o.path, value)
+	case "copy":
+		value, err := jsonPointerGet(doc, o.from)
+		if err != nil {
+			return nil, err
+		}
+		return jsonPointerAdd(doc, o.path, copyJSON(value))
+	case "test":
+		value, err := jsonPointerGet(doc, o.path)
+		if err != nil {
+			return nil, err
+		}
+		if !jsonEqual(value, o.value) {
+			return nil, ErrPatchFailed{msg: fmt.Sprintf("JSON Patch test of %s failed", o.pointer)}
+		}
+		return doc, nil
+	}
+	panic(fmt.Sprintf("unknown JSON Patch op %s", o.op))
+}
+
+// jsonChild returns a member of an object or an item of an array
+func jsonChild(container interface{}, token string) (interface{}, error) {
+	switch container := container.(type) {
+	case map[string]interface{}:
+		child, ok := container[token]
+		if !ok {
+			return nil, ErrPatchFailed{msg: fmt.Sprintf("No %s element to patch", token)}
+		}
+		return child, nil
+	case []interface{}:
+		index, err := jsonArrayIndex(token, len(container))
+		if err != nil {
+			return nil, err
+		}
+		return container[index], nil
+	default:
+		return nil, ErrPatchFailed{msg: fmt.Sprintf("Can't patch %s of a primitive value", token)}
+	}
+}
+
+// jsonSetChild sets a member of an object or an (existing) item of an array
+func jsonSetChild(container interface{}, token string, value interface{}) interface{} {
+	switch container := container.(type) {
+	case map[string]interface{}:
+		container[token] = value
+	case []interface{}:
+		index, _ := strconv.Atoi(token)
+		container[index] = value
+	}
+	return container
+}
+
+// jsonArrayIndex parses an array index of a JSON Pointer, which must be less than the given limit
+func jsonArrayIndex(token string, limit int) (int, error) {
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || (len(token) > 1 && token[0] == '0') || strings.HasPrefix(token, "+") {
+		return 0, ErrPatchFailed{msg: fmt.Sprintf("Invalid array index: %s", token)}
+	}
+	if index >= limit {
+		return 0, ErrPatchFailed{msg: fmt.Sprintf("Array index %d is out of bounds", index)}
+	}
+	return index, nil
+}
+
+func jsonPointerGet(doc interface{}, tokens []string) (value interface{}, err error) {
+	value = doc
+	for _, token := range tokens {
+		if value, err = jsonChild(value, token); err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+// jsonPointerUpdate replaces the container (object or array) of the value a JSON Pointer refers to with
+// that returned by the update function, which is passed the container and the last token of the pointer
+func jsonPointerUpdate(doc interface{}, tokens []string, update func(container interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(tokens) == 1 {
+		return update(doc, tokens[0])
+	}
+	child, err := jsonChild(doc, tokens[0])
+	if err != nil {
+		return nil, err
+	}
+	updated, err := jsonPointerUpdate(child, tokens[1:], update)
+	if err != nil {
+		return nil, err
+	}
+	return jsonSetChild(doc, tokens[0], updated), nil
+}
+
+func jsonPointerAdd(doc interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return jsonPointerUpdate(doc, tokens, func(container interface{}, token string) (interface{}, error) {
+		switch container := container.(type) {
+		case map[string]interface{}:
+			container[token] = value
+			return container, nil
+		case []interface{}:
+			if token == "-" {
+				return append(container, value), nil
+			}
+			index, err := jsonArrayIndex(token, len(container)+1)
+			if err != nil {
+				return nil, err
+			}
+			container = append(container, nil)
+			copy(container[index+1:], container[index:])
+			container[index] = value
+			return container, nil
+		default:
+			return nil, ErrPatchFailed{msg: fmt.Sprintf("Can't add %s to a primitive value", token)}
+		}
+	})
+}
+
+func jsonPointerRemove(doc interface{}, tokens []string) (removed interface{}, patched interface{}, err error) {
+	if len(tokens) == 0 {
+		return nil, nil, ErrPatchFailed{msg: "Can't remove the whole resource"}
+	}
+	patched, err = jsonPointerUpdate(doc, tokens, func(container interface{}, token string) (interface{}, error) {
+		if removed, err = jsonChild(container, token); err != nil {
+			return nil, err
+		}
+		switch container := container.(type) {
+		case map[string]interface{}:
+			delete(container, token)
+			return container, nil
+		default:
+			index, _ := strconv.Atoi(token)
+			slice := container.([]interface{})
+			return append(slice[:index:index], slice[index+1:]...), nil
+		}
+	})
+	return removed, patched, err
+}
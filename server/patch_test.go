@@ -0,0 +1,137 @@
+package server
+
+import (
+	"fmt"
+
+	. "gopkg.in/check.v1"
+)
+
+const patchTestPatient = `{"resourceType": "Patient", "id": "123", "gender": "male",
+	"identifier": [{"system": "urn:a", "value": "1"}, {"system": "urn:b", "value": "2"}],
+	"name": [{"family": "Smith", "given": ["Alex"]}]}`
+
+func applyTestPatch(c *C, patch resourcePatch, resourceJSON string) (interface{}, error) {
+	var doc map[string]interface{}
+	c.Assert(decodeJSON([]byte(resourceJSON), &doc), IsNil)
+	return patch.apply(doc, "Patient")
+}
+
+func assertPatchedJSON(c *C, patched interface{}, expectedJSON string) {
+	var expected interface{}
+	c.Assert(decodeJSON([]byte(expectedJSON), &expected), IsNil)
+	c.Assert(jsonEqual(patched, expected), Equals, true, Commentf("patched: %v", patched))
+}
+
+func (s *ServerSuite) TestJSONPatch(c *C) {
+	patch, err := parseJSONPatch([]byte(`[
+		{"op": "test", "path": "/gender", "value": "male"},
+		{"op": "replace", "path": "/gender", "value": "female"},
+		{"op": "add", "path": "/name/0/given/-", "value": "J"},
+		{"op": "add", "path": "/identifier/0", "value": {"system": "urn:c", "value": "3"}},
+		{"op": "remove", "path": "/identifier/2"},
+		{"op": "copy", "from": "/name/0", "path": "/name/1"},
+		{"op": "move", "from": "/name/1/family", "path": "/name/1/text"},
+		{"op": "add", "path": "/birthDate", "value": "1980-01-01"}
+	]`))
+	c.Assert(err, IsNil)
+	patched, err := applyTestPatch(c, patch, patchTestPatient)
+	c.Assert(err, IsNil)
+	assertPatchedJSON(c, patched, `{"resourceType": "Patient", "id": "123", "gender": "female", "birthDate": "1980-01-01",
+		"identifier": [{"system": "urn:c", "value": "3"}, {"system": "urn:a", "value": "1"}],
+		"name": [{"family": "Smith", "given": ["Alex", "J"]}, {"text": "Smith", "given": ["Alex", "J"]}]}`)
+
+	// Failures
+	for _, failing := range []string{
+		`[{"op": "test", "path": "/gender", "value": "female"}]`,
+		`[{"op": "replace", "path": "/birthDate", "value": "1980-01-01"}]`,
+		`[{"op": "remove", "path": "/identifier/2"}]`,
+		`[{"op": "add", "path": "/name/0/given/3", "value": "J"}]`,
+	} {
+		patch, err := parseJSONPatch([]byte(failing))
+		c.Assert(err, IsNil)
+		_, err = applyTestPatch(c, patch, patchTestPatient)
+		c.Assert(err, FitsTypeOf, ErrPatchFailed{}, Commentf(failing))
+	}
+
+	// Malformed patches
+	for _, invalid := range []string{
+		`{"op": "remove", "path": "/gender"}`,
+		`[{"op": "remove"}]`,
+		`[{"op": "delete", "path": "/gender"}]`,
+		`[{"op": "add", "path": "/gender"}]`,
+		`[{"op": "move", "path": "/gender"}]`,
+		`[{"op": "remove", "path": "gender"}]`,
+		`[{"op": "move", "from": "/name", "path": "/name/0"}]`,
+	} {
+		_, err := parseJSONPatch([]byte(invalid))
+		c.Assert(err, FitsTypeOf, ErrInvalidPatch{}, Commentf(invalid))
+	}
+}
+
+func fhirPathPatchJSON(operations ...string) []byte {
+	parameters := `{"resourceType": "Parameters", "parameter": [`
+	for i, operation := range operations {
+		if i > 0 {
+			parameters += ","
+		}
+		parameters += fmt.Sprintf(`{"name": "operation", "part": [%s]}`, operation)
+	}
+	return []byte(parameters + `]}`)
+}
+
+func (s *ServerSuite) TestFHIRPathPatch(c *C) {
+	patch, err := parseFHIRPathPatch(fhirPathPatchJSON(
+		`{"name": "type", "valueCode": "replace"}, {"name": "path", "valueString": "Patient.gender"}, {"name": "value", "valueCode": "female"}`,
+		`{"name": "type", "valueCode": "add"}, {"name": "path", "valueString": "Patient.name[0]"}, {"name": "name", "valueString": "given"}, {"name": "value", "valueString": "J"}`,
+		`{"name": "type", "valueCode": "add"}, {"name": "path", "valueString": "Patient"}, {"name": "name", "valueString": "telecom"}, {"name": "value", "valueContactPoint": {"system": "phone", "value": "555"}}`,
+		`{"name": "type", "valueCode": "add"}, {"name": "path", "valueString": "Patient"}, {"name": "name", "valueString": "deceased"}, {"name": "value", "valueBoolean": false}`,
+		`{"name": "type", "valueCode": "add"}, {"name": "path", "valueString": "Patient"}, {"name": "name", "valueString": "contact"}, {"name": "value", "part": [{"name": "gender", "valueCode": "female"}]}`,
+		`{"name": "type", "valueCode": "delete"}, {"name": "path", "valueString": "Patient.identifier.where(system = 'urn:a')"}`,
+		`{"name": "type", "valueCode": "insert"}, {"name": "path", "valueString": "Patient.identifier"}, {"name": "index", "valueInteger": 0}, {"name": "value", "valueIdentifier": {"system": "urn:c", "value": "3"}}`,
+		`{"name": "type", "valueCode": "move"}, {"name": "path", "valueString": "Patient.identifier"}, {"name": "source", "valueInteger": 0}, {"name": "destination", "valueInteger": 1}`,
+		`{"name": "type", "valueCode": "delete"}, {"name": "path", "valueString": "Patient.maritalStatus"}`,
+	))
+	c.Assert(err, IsNil)
+	patched, err := applyTestPatch(c, patch, patchTestPatient)
+	c.Assert(err, IsNil)
+	assertPatchedJSON(c, patched, `{"resourceType": "Patient", "id": "123", "gender": "female",
+		"identifier": [{"system": "urn:b", "value": "2"}, {"system": "urn:c", "value": "3"}],
+		"name": [{"family": "Smith", "given": ["Alex", "J"]}],
+		"telecom": [{"system": "phone", "value": "555"}],
+		"deceasedBoolean": false,
+		"contact": [{"gender": "female"}]}`)
+
+	// Choice elements are found and replaced by their name without a type
+	patch, err = parseFHIRPathPatch(fhirPathPatchJSON(
+		`{"name": "type", "valueCode": "replace"}, {"name": "path", "valueString": "Patient.deceased"}, {"name": "value", "valueDateTime": "2019-01-01"}`,
+	))
+	c.Assert(err, IsNil)
+	patched, err = applyTestPatch(c, patch, `{"resourceType": "Patient", "id": "123", "deceasedBoolean": true}`)
+	c.Assert(err, IsNil)
+	assertPatchedJSON(c, patched, `{"resourceType": "Patient", "id": "123", "deceasedDateTime": "2019-01-01"}`)
+
+	// Failures
+	for _, failing := range []string{
+		`{"name": "type", "valueCode": "replace"}, {"name": "path", "valueString": "Patient.birthDate"}, {"name": "value", "valueDate": "1980-01-01"}`,
+		`{"name": "type", "valueCode": "replace"}, {"name": "path", "valueString": "Patient.identifier.value"}, {"name": "value", "valueString": "4"}`,
+		`{"name": "type", "valueCode": "add"}, {"name": "path", "valueString": "Patient"}, {"name": "name", "valueString": "gender"}, {"name": "value", "valueCode": "other"}`,
+		`{"name": "type", "valueCode": "insert"}, {"name": "path", "valueString": "Patient.identifier"}, {"name": "index", "valueInteger": 3}, {"name": "value", "valueIdentifier": {}}`,
+	} {
+		patch, err := parseFHIRPathPatch(fhirPathPatchJSON(failing))
+		c.Assert(err, IsNil)
+		_, err = applyTestPatch(c, patch, patchTestPatient)
+		c.Assert(err, FitsTypeOf, ErrPatchFailed{}, Commentf(failing))
+	}
+
+	// Malformed patches
+	for _, invalid := range []string{
+		`{"name": "type", "valueCode": "remove"}, {"name": "path", "valueString": "Patient.gender"}`,
+		`{"name": "type", "valueCode": "add"}, {"name": "path", "valueString": "Patient"}, {"name": "value", "valueCode": "other"}`,
+		`{"name": "type", "valueCode": "insert"}, {"name": "path", "valueString": "Patient.identifier"}, {"name": "value", "valueIdentifier": {}}`,
+		`{"name": "type", "valueCode": "delete"}, {"name": "path", "valueString": "Patient.name.exists()"}`,
+		`{"name": "type", "valueCode": "delete"}, {"name": "path", "valueString": "Patient.name.where(family.startsWith('S'))"}`,
+	} {
+		_, err := parseFHIRPathPatch(fhirPathPatchJSON(invalid))
+		c.Assert(err, FitsTypeOf, ErrInvalidPatch{}, Commentf(invalid))
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"github.com/buger/jsonparser"
+	"github.com/eug48/fhir/models"
+	"github.com/eug48/fhir/models2"
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// The X-Provenance header of a write (spec: http://www.hl7.org/fhir/provenance.html#header) is a Provenance
+// resource without targets.  It's stored in the same transaction as the write, targeting the versions of the
+// resources that the write stored, and its location is returned in the X-GoFHIR-Provenance-Location header.
+
+// checkProvenanceHeader returns an error unless an X-Provenance header is a Provenance without targets
+func checkProvenanceHeader(provenanceHeader string) error {
+	headerBytes := []byte(provenanceHeader)
+
+	// check resourceType
+	resourceType, _, _, err := jsonparser.Get(headerBytes, "resourceType")
+	if err != nil {
+		return errors.Wrap(err, "error parsing X-Provenance header resourceType")
+	}
+	if string(resourceType) != "Provenance" {
+		return errors.Errorf("error parsing X-Provenance header: invalid resourceType")
+	}
+
+	// make sure "target" is not set
+	_, dataType, _, err := jsonparser.Get(headerBytes, "target")
+	if dataType == jsonparser.NotExist {
+	} else if err != nil {
+		return errors.Wrap(err, "error parsing X-Provenance header")
+	} else {
+		return errors.Errorf("error parsing X-Provenance header: target should not be set")
+	}
+
+	if headerBytes[len(headerBytes)-1] != '}' {
+		return errors.Errorf("error parsing X-Provenance header: doesn't end with }")
+	}
+
+	if _, err := models2.NewResourceFromJsonBytes(headerBytes); err != nil {
+		return errors.Wrap(err, "error loading X-Provenance header")
+	}
+	return nil
+}
+
+// storeProvenance stores the Provenance of a checked X-Provenance header with the versions of resources as its
+// targets
+func storeProvenance(c *gin.Context, session DataAccessSession, provenanceHeader string, targets []*models2.Resource, enableHistory bool) error {
+	headerBytes := []byte(provenanceHeader)
+
+	// generate targets field
+	var sb bytes.Buffer
+	sb.Write(headerBytes[:len(headerBytes)-1]) // remove final '}'
+	sb.WriteString(", \"target\": [")
+	for i, target := range targets {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+
+		if target.ResourceType() == "" {
+			return errors.Errorf("storeProvenance: missing resourceType of a target")
+		}
+		if target.Id() == "" {
+			return errors.Errorf("storeProvenance: missing id of a %s target", target.ResourceType())
+		}
+		if enableHistory && target.VersionId() == "" {
+			return errors.Errorf("storeProvenance: missing versionId of %s/%s", target.ResourceType(), target.Id())
+		}
+
+		sb.WriteString("{ \"reference\": \"")
+		sb.WriteString(target.ResourceType())
+		sb.WriteString("/")
+		sb.WriteString(target.Id())
+		if enableHistory {
+			sb.WriteString("/_history/")
+			sb.WriteString(target.VersionId())
+		}
+		sb.WriteString("\" }")
+	}
+	sb.WriteString("] }")
+
+	if glog.V(8) {
+		glog.V(8).Info("  saving X-Provenance ", sb.String())
+	}
+
+	// load resource with target
+	provenanceResource, err := models2.NewResourceFromJsonBytes(sb.Bytes())
+	if err != nil {
+		return errors.Wrap(err, "error loading X-Provenance header")
+	}
+
+	// save
+	newId := bson.NewObjectId().Hex()
+	err = session.PostWithID(newId, provenanceResource)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create provenanceResource")
+	}
+
+	c.Header("X-GoFHIR-Provenance-Location", "Provenance/"+newId)
+	return nil
+}
+
+// provenanceHeader returns the X-Provenance header of a write, if it has one, and starts a transaction to store its
+// Provenance with the write.  It responds with 400 Bad Request and returns false if the header isn't valid.
+func (rc *ResourceController) provenanceHeader(c *gin.Context, session DataAccessSession) (string, bool) {
+	provenanceHeader := strings.TrimSpace(c.GetHeader("X-Provenance"))
+	if provenanceHeader == "" {
+		return "", true
+	}
+	if err := checkProvenanceHeader(provenanceHeader); err != nil {
+		oo := models.NewOperationOutcome("fatal", "value", err.Error())
+		c.Render(http.StatusBadRequest, CustomFhirRenderer{oo, c})
+		return "", false
+	}
+	if err := session.StartTransaction(); err != nil {
+		panic(errors.Wrap(err, "failed to start a transaction for the X-Provenance header"))
+	}
+	return provenanceHeader, true
+}
+
+// commitWithProvenance stores the Provenance of a write's X-Provenance header (if it has one) targeting the version
+// of the resource that it stored, and commits them
+func (rc *ResourceController) commitWithProvenance(c *gin.Context, session DataAccessSession, provenanceHeader string, resource *models2.Resource) {
+	if provenanceHeader != "" {
+		if err := storeProvenance(c, session, provenanceHeader, []*models2.Resource{resource}, rc.Config.EnableHistory); err != nil {
+			panic(errors.Wrap(err, "failed to store the X-Provenance header"))
+		}
+	}
+	if err := session.CommmitIfTransaction(); err != nil {
+		panic(errors.Wrap(err, "failed to commit the X-Provenance header"))
+	}
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eug48/fhir/auth"
+	"github.com/eug48/fhir/models"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Rate limits (see Config.ClientRateLimit and Config.TenantRateLimit) protect the database from runaway clients.
+// Each client and tenant has a bucket of tokens, which is refilled at the rate of its limit up to its burst, and
+// each of their requests takes a token from it.  Requests fail with 429 Too Many Requests and a Retry-After header
+// if there's no token left.
+
+// APIKeyHeader is the header of the API keys with which clients without OAuth tokens authenticate
+const APIKeyHeader = auth.APIKeyHeader
+
+// rateLimitRequests counts the requests checked by the rate limits, with their limit (client or tenant) and outcome
+// (allowed or limited) as attributes.  It's an OpenTelemetry metric of the global MeterProvider, which servers set
+// to export it (see the -enableMetrics flag of fhir-server).
+var rateLimitRequests, _ = otel.Meter("github.com/eug48/fhir/server").Int64Counter("fhir.rate_limit.requests",
+	metric.WithDescription("Requests checked by rate limits, by limit and outcome"), metric.WithUnit("{request}"))
+
+// rateLimiter is a set of token buckets with the same rate and burst
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	sync.Mutex
+	buckets map[string]*tokenBucket
+	pruned  time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// rateLimiterPruneInterval is how often the buckets that have been refilled are deleted, so that the buckets of
+// clients that have stopped making requests aren't kept
+const rateLimiterPruneInterval = time.Minute
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst <= 0 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+	return &rateLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*tokenBucket)}
+}
+
+// take takes a token from the bucket of a key, or else returns how long it'll be until the bucket has one
+func (l *rateLimiter) take(key string, now time.Time) (taken bool, retryAfter time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+
+	if now.Sub(l.pruned) >= rateLimiterPruneInterval {
+		for key, bucket := range l.buckets {
+			if l.refill(bucket, now) >= l.burst {
+				delete(l.buckets, key)
+			}
+		}
+		l.pruned = now
+	}
+
+	bucket := l.buckets[key]
+	if bucket == nil {
+		bucket = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[key] = bucket
+	}
+	if l.refill(bucket, now) >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+}
+
+// refill adds the tokens of the time since a bucket was last refilled, returning how many it has
+func (l *rateLimiter) refill(bucket *tokenBucket, now time.Time) float64 {
+	if elapsed := now.Sub(bucket.updated).Seconds(); elapsed > 0 {
+		bucket.tokens = math.Min(l.burst, bucket.tokens+elapsed*l.rate)
+		bucket.updated = now
+	}
+	return bucket.tokens
+}
+
+// rateLimitMiddleware returns middleware that limits the rates of requests of each client (after the auth
+// middleware has authenticated them) and of each tenant (after tenantContext has selected them), either of which
+// may be nil
+func rateLimitMiddleware(clients, tenants *rateLimiter, config Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if isRedispatched(c.Request.URL.Path, config) {
+			// these are limited when they're handled again, without the tenant or database in their path
+			return
+		}
+		if c.Request.Context().Value(asyncReplayKey{}) != nil {
+			// asynchronous requests were limited when they were queued
+			return
+		}
+
+		now := time.Now()
+		if clients != nil {
+			// Only the clients that the auth middleware authenticated (whose API keys' ids are their client ids) are
+			// limited by their ids, as unverified credentials could be varied to get a bucket per request
+			key := "ip:" + c.ClientIP()
+			if clientID := c.GetString("clientID"); clientID != "" {
+				key = "client:" + clientID
+			}
+			if !checkRateLimit(c, clients, "client", key, now) {
+				return
+			}
+		}
+		if tenant, isTenant := c.Get("tenant"); isTenant && tenants != nil {
+			checkRateLimit(c, tenants, "tenant", tenant.(*Tenant).Id, now)
+		}
+	}
+}
+
+// isRedispatched returns whether requests for a path are handled again without the tenant or database in their path
+// (see the MultiDB and tenancy routes), so that middleware can skip them until they are
+func isRedispatched(path string, config Config) bool {
+	return config.EnableTenancy && strings.HasPrefix(path, "/tenants/") || config.EnableMultiDB && strings.HasPrefix(path, "/db/")
+}
+
+// checkRateLimit takes a token for a request from the bucket of a key, or else fails the request with 429 Too Many
+// Requests, returning whether it took one
+func checkRateLimit(c *gin.Context, limiter *rateLimiter, limit string, key string, now time.Time) bool {
+	taken, retryAfter := limiter.take(key, now)
+	recordRateLimit(c.Request.Context(), limit, taken)
+	if taken {
+		return true
+	}
+
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	oo := models.NewOperationOutcome("error", "throttled", fmt.Sprintf("The %s rate limit of %g requests per second has been exceeded", limit, limiter.rate))
+	c.Render(http.StatusTooManyRequests, CustomFhirRenderer{oo, c})
+	c.Abort()
+	return false
+}
+
+func recordRateLimit(ctx context.Context, limit string, allowed bool) {
+	outcome := "limited"
+	if allowed {
+		outcome = "allowed"
+	}
+	rateLimitRequests.Add(ctx, 1, metric.WithAttributes(attribute.String("limit", limit), attribute.String("outcome", outcome)))
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "gopkg.in/check.v1"
+)
+
+func (s *ServerSuite) TestRateLimiter(c *C) {
+	limiter := newRateLimiter(2, 3)
+	now := time.Now()
+
+	// Bursts are allowed, and then the bucket is refilled at the rate
+	for i := 0; i < 3; i++ {
+		taken, _ := limiter.take("client", now)
+		c.Assert(taken, Equals, true)
+	}
+	taken, retryAfter := limiter.take("client", now)
+	c.Assert(taken, Equals, false)
+	c.Assert(retryAfter, Equals, 500*time.Millisecond)
+	taken, _ = limiter.take("other", now)
+	c.Assert(taken, Equals, true)
+
+	taken, _ = limiter.take("client", now.Add(500*time.Millisecond))
+	c.Assert(taken, Equals, true)
+	taken, _ = limiter.take("client", now.Add(500*time.Millisecond))
+	c.Assert(taken, Equals, false)
+
+	// The buckets that have been refilled are pruned
+	taken, _ = limiter.take("client", now.Add(2*time.Minute))
+	c.Assert(taken, Equals, true)
+	c.Assert(limiter.buckets, HasLen, 1)
+}
+
+func (s *ServerSuite) TestRateLimitMiddleware(c *C) {
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) {
+		// the auth middleware
+		if clientID := c.GetHeader("Client-ID"); clientID != "" {
+			c.Set("clientID", clientID)
+		}
+	})
+	engine.Use(rateLimitMiddleware(newRateLimiter(1, 1), nil, DefaultConfig))
+	engine.GET("/Patient", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	request := func(clientID, apiKey, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/Patient", nil)
+		req.Header.Set("Client-ID", clientID)
+		req.Header.Set(APIKeyHeader, apiKey)
+		req.RemoteAddr = ip + ":1234"
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, req)
+		return recorder
+	}
+	c.Assert(request("a", "", "10.0.0.1").Code, Equals, http.StatusOK)
+	limited := request("a", "", "10.0.0.2")
+	c.Assert(limited.Code, Equals, http.StatusTooManyRequests)
+	c.Assert(limited.Header().Get("Retry-After"), Equals, "1")
+	c.Assert(request("b", "", "10.0.0.1").Code, Equals, http.StatusOK)
+
+	// Unauthenticated clients are limited by their IP addresses, whatever API keys they send
+	c.Assert(request("", "key1", "10.0.0.3").Code, Equals, http.StatusOK)
+	c.Assert(request("", "key2", "10.0.0.3").Code, Equals, http.StatusTooManyRequests)
+	c.Assert(request("", "key2", "10.0.0.4").Code, Equals, http.StatusOK)
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/eug48/fhir/models"
+	"github.com/eug48/fhir/models2"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// defaultDanglingReferencesCount is the most dangling references reported by GET /$dangling-references without a
+// _count
+const defaultDanglingReferencesCount = 100
+
+// DanglingReferencesHandler handles requests to report the references of stored resources to resources on the
+// server that don't exist, e.g. GET /$dangling-references?_type=Observation,Encounter&_count=50
+// It responds with an OperationOutcome with a warning for each of them (up to the _count), in the order of the types.
+func DanglingReferencesHandler(dal DataAccessLayer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer handlePanics(c)
+
+		var resourceTypes []string
+		if types := c.Query("_type"); types != "" {
+			resourceTypes = strings.Split(types, ",")
+			for _, resourceType := range resourceTypes {
+				if models.StructForResourceName(resourceType) == nil {
+					outcome := models.NewOperationOutcome("fatal", "value", fmt.Sprintf("unknown resource: %q", resourceType))
+					c.Render(http.StatusBadRequest, CustomFhirRenderer{outcome, c})
+					return
+				}
+			}
+		}
+		count := defaultDanglingReferencesCount
+		if countStr := c.Query("_count"); countStr != "" {
+			var err error
+			if count, err = strconv.Atoi(countStr); err != nil || count <= 0 {
+				outcome := models.NewOperationOutcome("fatal", "value", fmt.Sprintf("invalid _count: %q", countStr))
+				c.Render(http.StatusBadRequest, CustomFhirRenderer{outcome, c})
+				return
+			}
+		}
+
+		session := dal.StartSession(c.Request.Context(), c.GetHeader("Db"))
+		defer session.Finish()
+
+		references, err := session.DanglingReferences(resourceTypes, count)
+		if err != nil {
+			panic(errors.Wrap(err, "DanglingReferences failed"))
+		}
+
+		if len(references) == 0 {
+			outcome := models.NewOperationOutcome("information", "informational", "No dangling references found")
+			c.Render(http.StatusOK, CustomFhirRenderer{outcome, c})
+			return
+		}
+		outcome := &models.OperationOutcome{}
+		for _, reference := range references {
+			outcome.Issue = append(outcome.Issue, models.OperationOutcomeIssueComponent{
+				Severity:    "warning",
+				Code:        "not-found",
+				Diagnostics: fmt.Sprintf("%s, which doesn't exist", reference),
+				Expression:  []string{reference.Element},
+			})
+		}
+		c.Render(http.StatusOK, CustomFhirRenderer{outcome, c})
+	}
+}
+
+// referenceWarnings returns the references of other resources to deleted resources, when they're only warned about
+// (see Config.ReferencedDeletes), as the warnings of an OperationOutcome
+func referenceWarnings(session DataAccessSession, config Config, resourceType string, ids []string) ([]models.OperationOutcomeIssueComponent, error) {
+	if config.referencedDeletesOf(resourceType) != ReferencedDeletesWarn || len(ids) == 0 {
+		return nil, nil
+	}
+	references, err := session.ReferencesTo(resourceType, ids, maxReportedReferences)
+	if err != nil {
+		return nil, errors.Wrap(err, "ReferencesTo failed")
+	}
+
+	var issues []models.OperationOutcomeIssueComponent
+	for _, reference := range references {
+		issues = append(issues, models.OperationOutcomeIssueComponent{
+			Severity:    "warning",
+			Code:        "conflict",
+			Diagnostics: reference.String(),
+		})
+	}
+	return issues, nil
+}
+
+// changesReferringResources returns whether deleting resources of a type changes the resources referring to them
+// (see Config.ReferencedDeletes), which is done in a transaction so that the changes are made together or not at all
+func changesReferringResources(config Config, resourceType string) bool {
+	policy := config.referencedDeletesOf(resourceType)
+	return policy == ReferencedDeletesNullify || policy == ReferencedDeletesCascade
+}
+
+// referenceChangeIssues returns the changes made to the resources referring to deleted resources as the information
+// issues of an OperationOutcome
+func referenceChangeIssues(changes []ReferenceChange) []models.OperationOutcomeIssueComponent {
+	var issues []models.OperationOutcomeIssueComponent
+	for _, change := range changes {
+		issues = append(issues, models.OperationOutcomeIssueComponent{
+			Severity:    "information",
+			Code:        "informational",
+			Diagnostics: change.String(),
+		})
+	}
+	return issues
+}
+
+// withoutReferences returns a copy of a resource without its relative references to the targets (as Type/id, with
+// or without a _history version), and how many it removed.  References left without any elements are removed too.
+func withoutReferences(resource *models2.Resource, targets map[string]bool) (*models2.Resource, int, error) {
+	jsonBytes, err := resource.MarshalJSON()
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "withoutReferences: MarshalJSON failed")
+	}
+	var doc map[string]interface{}
+	if err := decodeJSON(jsonBytes, &doc); err != nil {
+		return nil, 0, errors.Wrap(err, "withoutReferences: failed to decode resource")
+	}
+
+	_, removed := removeReferencesFromJSON(doc, targets)
+	if removed == 0 {
+		return resource, 0, nil
+	}
+
+	var updatedBytes bytes.Buffer
+	encoder := json.NewEncoder(&updatedBytes)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(doc); err != nil {
+		return nil, 0, errors.Wrap(err, "withoutReferences: failed to encode resource")
+	}
+	updated, err := models2.NewResourceFromJsonBytes(updatedBytes.Bytes())
+	return updated, removed, err
+}
+
+// removeReferencesFromJSON removes the references to the targets from decoded JSON in place, returning the changed
+// value (or nil if nothing is left of it) and how many it removed
+func removeReferencesFromJSON(value interface{}, targets map[string]bool) (interface{}, int) {
+	removed := 0
+	switch value := value.(type) {
+	case map[string]interface{}:
+		if reference, isString := value["reference"].(string); isString && targets[relativeReferenceTarget(reference)] {
+			delete(value, "reference")
+			removed++
+		}
+		for key, child := range value {
+			changed, childRemoved := removeReferencesFromJSON(child, targets)
+			if childRemoved == 0 {
+				continue
+			}
+			removed += childRemoved
+			if changed == nil {
+				delete(value, key)
+			} else {
+				value[key] = changed
+			}
+		}
+		if removed > 0 && len(value) == 0 {
+			return nil, removed
+		}
+		return value, removed
+	case []interface{}:
+		items := value[:0]
+		for _, item := range value {
+			changed, itemRemoved := removeReferencesFromJSON(item, targets)
+			removed += itemRemoved
+			if changed != nil || itemRemoved == 0 {
+				items = append(items, changed)
+			}
+		}
+		if removed > 0 && len(items) == 0 {
+			return nil, removed
+		}
+		return items, removed
+	}
+	return value, 0
+}
+
+// relativeReferenceTarget returns the resource a relative reference refers to as Type/id (e.g. Patient/123 for
+// Patient/123/_history/2), or "" if it's not a relative reference
+func relativeReferenceTarget(reference string) string {
+	if strings.Contains(reference, ":") {
+		return ""
+	}
+	parts := strings.Split(reference, "/")
+	if len(parts) == 4 && parts[2] == "_history" {
+		parts = parts[:2]
+	}
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return ""
+	}
+	return parts[0] + "/" + parts[1]
+}
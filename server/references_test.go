@@ -0,0 +1,38 @@
+package server
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (s *ServerSuite) TestRemoveReferencesFromJSON(c *C) {
+	var doc map[string]interface{}
+	c.Assert(decodeJSON([]byte(`{"resourceType": "Observation", "id": "1", "status": "final",
+		"subject": {"reference": "Patient/123", "display": "Alex Smith"},
+		"context": {"reference": "Encounter/7"},
+		"performer": [
+			{"reference": "Practitioner/1/_history/2"},
+			{"reference": "http://example.com/fhir/Practitioner/1"},
+			{"reference": "Practitioner/2"}
+		],
+		"basedOn": [{"reference": "Practitioner/1"}],
+		"specimen": {"reference": "#1"}}`), &doc), IsNil)
+
+	targets := map[string]bool{"Patient/123": true, "Practitioner/1": true, "Encounter/7": true}
+	changed, removed := removeReferencesFromJSON(doc, targets)
+	c.Assert(removed, Equals, 4)
+
+	// References without any other elements are removed, as are arrays left empty
+	var expected interface{}
+	c.Assert(decodeJSON([]byte(`{"resourceType": "Observation", "id": "1", "status": "final",
+		"subject": {"display": "Alex Smith"},
+		"performer": [
+			{"reference": "http://example.com/fhir/Practitioner/1"},
+			{"reference": "Practitioner/2"}
+		],
+		"specimen": {"reference": "#1"}}`), &expected), IsNil)
+	c.Assert(jsonEqual(changed, expected), Equals, true, Commentf("changed: %v", changed))
+
+	c.Assert(relativeReferenceTarget("Patient/123/_history/4"), Equals, "Patient/123")
+	c.Assert(relativeReferenceTarget("urn:uuid:61ebe359-bfdc-4613-8bf2-c5e300945f0a"), Equals, "")
+	c.Assert(relativeReferenceTarget("Patient"), Equals, "")
+}
@@ -7,7 +7,11 @@ import (
 	"io/ioutil"
 	"mime"
 	"net/http"
+	"net/url"
 	"reflect"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/eug48/fhir/utils"
 
@@ -15,6 +19,7 @@ import (
 	"github.com/eug48/fhir/models2"
 	"github.com/eug48/fhir/search"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
 )
 
@@ -46,9 +51,43 @@ func handlePanics(c *gin.Context) {
 func (rc *ResourceController) IndexHandler(c *gin.Context) {
 	defer handlePanics(c)
 
-	rawQuery := c.Request.URL.RawQuery
+	rawQuery, ok := searchRequestQuery(c)
+	if !ok {
+		return
+	}
+
+	session := rc.DAL.StartSession(c.Request.Context(), c.GetHeader("Db"))
+	defer session.Finish()
+
+	searchQuery := search.Query{Resource: rc.Name, Query: rawQuery}
+	switch preference(c, "handling") {
+	case search.StrictHandling:
+		searchQuery.CheckUnknownParams()
+	case search.LenientHandling:
+		searchQuery = searchQuery.WithoutParams(searchQuery.UnknownParams())
+	}
+	baseURL := rc.Config.responseURL(c.Request, rc.Name)
+	bundle, err := session.Search(*baseURL, searchQuery)
+	if err != nil {
+		panic(errors.Wrap(err, "Search failed"))
+	}
+	if err := filterIncludedByScopes(c, rc.Config, bundle); err != nil {
+		panic(err)
+	}
+
+	c.Set("bundle", bundle)
+	c.Set("Resource", rc.Name)
+	c.Set("Action", "search")
+
+	c.Render(http.StatusOK, CustomFhirRenderer{bundle, c})
+}
+
+// searchRequestQuery returns the parameters of a search request, which are in its URL and, for
+// POSTed searches (http://hl7.org/fhir/http.html#search), also in its form body.  If the body
+// can't be used an OperationOutcome is rendered and ok is false.
+func searchRequestQuery(c *gin.Context) (rawQuery string, ok bool) {
+	rawQuery = c.Request.URL.RawQuery
 	if c.Request.Method == "POST" {
-		// handle _search (http://hl7.org/fhir/http.html#search)
 		// reading urlencoded form values similarly to http/request.go
 		ct := c.Request.Header.Get("Content-Type")
 		if ct == "" {
@@ -60,32 +99,49 @@ func (rc *ResourceController) IndexHandler(c *gin.Context) {
 		if err != nil {
 			outcome := models.NewOperationOutcome("fatal", "structure", "failed to parse Content-Type")
 			c.Render(http.StatusUnsupportedMediaType, CustomFhirRenderer{outcome, c})
-			return
+			return "", false
+		}
+		bodyBytes, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			panic(fmt.Errorf("failed to read POSTed form body: %#v", err))
 		}
+		body := strings.TrimSpace(string(bodyBytes))
 		if ct == "application/x-www-form-urlencoded" {
-			bodyBytes, err := ioutil.ReadAll(c.Request.Body)
-			if err != nil {
-				panic(fmt.Errorf("failed to read POSTed form body: %#v", err))
-			}
-			rawQuery = string(bodyBytes)
+			// The parameters in the body and the URL are combined, as if they'd all been in the URL
+			rawQuery = mergeRawQueries(rawQuery, body)
+		} else if body != "" {
+			outcome := models.NewOperationOutcome("fatal", "structure", "The parameters of a POSTed search must be application/x-www-form-urlencoded")
+			c.Render(http.StatusUnsupportedMediaType, CustomFhirRenderer{outcome, c})
+			return "", false
 		}
 	}
+	return rawQuery, true
+}
 
-	session := rc.DAL.StartSession(c.Request.Context(), c.GetHeader("Db"))
-	defer session.Finish()
-
-	searchQuery := search.Query{Resource: rc.Name, Query: rawQuery}
-	baseURL := rc.Config.responseURL(c.Request, rc.Name)
-	bundle, err := session.Search(*baseURL, searchQuery)
-	if err != nil {
-		panic(errors.Wrap(err, "Search failed"))
+// mergeRawQueries joins URL-encoded queries, keeping their parameters in order (so any that appear
+// in more than one are repeated)
+func mergeRawQueries(queries ...string) string {
+	var parts []string
+	for _, query := range queries {
+		if query != "" {
+			parts = append(parts, query)
+		}
 	}
+	return strings.Join(parts, "&")
+}
 
-	c.Set("bundle", bundle)
-	c.Set("Resource", rc.Name)
-	c.Set("Action", "search")
-
-	c.Render(http.StatusOK, CustomFhirRenderer{bundle, c})
+// preference returns the value of a preference in the request's Prefer header
+// (e.g. "strict" for "Prefer: handling=strict"), or "" if it wasn't requested.
+func preference(c *gin.Context, name string) string {
+	for _, header := range c.Request.Header["Prefer"] {
+		for _, pref := range strings.FieldsFunc(header, func(r rune) bool { return r == ',' || r == ';' }) {
+			keyValue := strings.SplitN(strings.TrimSpace(pref), "=", 2)
+			if len(keyValue) == 2 && strings.EqualFold(keyValue[0], name) {
+				return strings.Trim(strings.TrimSpace(keyValue[1]), "\"")
+			}
+		}
+	}
+	return ""
 }
 
 // LoadResource uses the resource id in the request to get a resource from the DataAccessLayer and store it in the
@@ -110,10 +166,12 @@ func (rc *ResourceController) LoadResource(c *gin.Context) (resourceId string, r
 	return
 }
 
-// ShowHandler handles requests to get a particular resource by ID.
+// ShowHandler handles requests to get a particular resource by ID.  Conditional reads (with If-None-Match or
+// If-Modified-Since headers) of a version the client already has return 304 Not Modified.
 func (rc *ResourceController) ShowHandler(c *gin.Context) {
 	defer handlePanics(c)
 	c.Set("Action", "read")
+	c.Set("Resource", rc.Name)
 	resourceId, resource, err := rc.LoadResource(c)
 	if err == nil {
 		err = setHeaders(c, rc, false, resource, resourceId)
@@ -124,6 +182,10 @@ func (rc *ResourceController) ShowHandler(c *gin.Context) {
 
 	switch err {
 	case nil:
+		if notModified(c, resource) {
+			c.Status(http.StatusNotModified)
+			return
+		}
 		c.Render(http.StatusOK, CustomFhirRenderer{resource, c})
 	case ErrNotFound:
 		c.Status(http.StatusNotFound)
@@ -134,41 +196,159 @@ func (rc *ResourceController) ShowHandler(c *gin.Context) {
 	}
 }
 
-func (rc *ResourceController) HistoryHandler(c *gin.Context) {
+// notModified returns whether the If-None-Match or If-Modified-Since header of a conditional read shows that the
+// client already has the version of a resource.  As in RFC 7232, If-Modified-Since is ignored if If-None-Match
+// is given, and ETags are compared weakly.
+func notModified(c *gin.Context, resource *models2.Resource) bool {
+	if ifNoneMatch := c.GetHeader("If-None-Match"); ifNoneMatch != "" {
+		versionId := resource.VersionId()
+		for _, etag := range strings.Split(ifNoneMatch, ",") {
+			etag = strings.TrimSpace(etag)
+			if etag == "*" {
+				return true
+			}
+			if versionId != "" && strings.Trim(strings.TrimPrefix(etag, "W/"), "\"") == versionId {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ifModifiedSince := c.GetHeader("If-Modified-Since"); ifModifiedSince != "" && resource.LastUpdated() != "" {
+		since, err := http.ParseTime(ifModifiedSince)
+		if err != nil {
+			return false
+		}
+		// Last-Modified only has a precision of seconds
+		return !resource.LastUpdatedTime().Truncate(time.Second).After(since)
+	}
+	return false
+}
+
+// EverythingHandler handles requests for everything related to a Patient or Encounter resource: the resource, the
+// resources it refers to (e.g. an encounter's patient) and the resources referring to it (e.g. the observations made
+// during an encounter).  It responds with 404 Not Found or 410 Gone if the resource doesn't exist.
+func (rc *ResourceController) EverythingHandler(c *gin.Context) {
 	defer handlePanics(c)
 	session := rc.DAL.StartSession(c.Request.Context(), c.GetHeader("Db"))
 	defer session.Finish()
 
-	c.Set("Action", "history")
+	id := c.Param("id")
+	switch _, err := session.Get(id, rc.Name); err {
+	case nil:
+	case ErrNotFound:
+		c.Status(http.StatusNotFound)
+		return
+	case ErrDeleted:
+		c.Status(http.StatusGone)
+		return
+	default:
+		panic(errors.Wrap(err, "Get (everything) failed"))
+	}
+
+	// For now we interpret $everything as the union of _include and _revinclude
+	query := fmt.Sprintf("_id=%s&_include=*&_revinclude=*", id)
 
+	searchQuery := search.Query{Resource: rc.Name, Query: query}
 	baseURL := rc.Config.responseURL(c.Request, rc.Name)
-	resourceId := c.Param("id")
-	bundle, err := session.History(*baseURL, rc.Name, resourceId)
-	if err != nil && err != ErrNotFound {
-		panic(errors.Wrap(err, "History request failed"))
+	bundle, err := session.Search(*baseURL, searchQuery)
+	if err != nil {
+		panic(errors.Wrap(err, "Search (everything) failed"))
 	}
-
-	if err == ErrNotFound {
-		c.Status(http.StatusNotFound)
-		return
+	if err := filterIncludedByScopes(c, rc.Config, bundle); err != nil {
+		panic(err)
 	}
+
+	c.Set("bundle", bundle)
+	c.Set("Resource", rc.Name)
+	c.Set("Action", "search")
+
 	c.Render(http.StatusOK, CustomFhirRenderer{bundle, c})
 }
 
-// EverythingHandler handles requests for everything related to a Patient or Encounter resource.
-func (rc *ResourceController) EverythingHandler(c *gin.Context) {
+// CompartmentSearchHandler returns a handler of searches of the resources of a type in the compartment of a
+// resource (e.g. GET /Patient/123/Observation?code=abc), or of all those in it if the type is "*" (e.g.
+// GET /Patient/123/*).  They're searched using the _compartment parameter, which is kept in the paging links.
+func (rc *ResourceController) CompartmentSearchHandler(resourceType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer handlePanics(c)
+
+		rawQuery, ok := searchRequestQuery(c)
+		if !ok {
+			return
+		}
+		compartmentParam := url.Values{search.CompartmentParam: {rc.Name + "/" + c.Param("id")}}
+		rawQuery = mergeRawQueries(compartmentParam.Encode(), rawQuery)
+
+		var searchQuery search.Query
+		var baseURL *url.URL
+		compartment := rc.Name + "/" + c.Param("id")
+		if resourceType == "*" {
+			searchQuery = search.Query{Query: rawQuery}
+			baseURL = rc.Config.responseURL(c.Request)
+			// Only the types that the caller can search are searched
+			types := searchQuery.Types()
+			searchable := searchableCompartmentTypes(c, rc.Config, types, compartment)
+			if len(searchable) == 0 {
+				panic(ErrForbidden{"You do not have permission to search any of the resources in the compartment"})
+			} else if len(searchable) < len(types) {
+				queryParams, _ := search.ParseQuery(rawQuery)
+				queryParams.Set(search.TypeParam, strings.Join(searchable, ","))
+				searchQuery.Query = queryParams.Encode()
+			}
+		} else {
+			if err := authorizeCompartmentSearch(c, rc.Config, resourceType, compartment); err != nil {
+				panic(err)
+			}
+			searchQuery = search.Query{Resource: resourceType, Query: rawQuery}
+			baseURL = rc.Config.responseURL(c.Request, resourceType)
+		}
+
+		session := rc.DAL.StartSession(c.Request.Context(), c.GetHeader("Db"))
+		defer session.Finish()
+		bundle, err := session.Search(*baseURL, searchQuery)
+		if err != nil {
+			panic(errors.Wrap(err, "Search (compartment) failed"))
+		}
+		if err := filterIncludedByScopes(c, rc.Config, bundle); err != nil {
+			panic(err)
+		}
+
+		c.Set("bundle", bundle)
+		c.Set("Resource", rc.Name)
+		c.Set("Action", "search")
+
+		c.Render(http.StatusOK, CustomFhirRenderer{bundle, c})
+	}
+}
+
+// GraphHandler handles requests for a resource and the resources linked to it by a GraphDefinition,
+// given by the graph parameter (its id or canonical URL).
+func (rc *ResourceController) GraphHandler(c *gin.Context) {
 	defer handlePanics(c)
+
+	graph := c.Query("graph")
+	if graph == "" {
+		oo := models.NewOperationOutcome("fatal", "required", "The graph parameter is required")
+		c.Render(http.StatusBadRequest, CustomFhirRenderer{oo, c})
+		return
+	}
+
 	session := rc.DAL.StartSession(c.Request.Context(), c.GetHeader("Db"))
 	defer session.Finish()
 
-	// For now we interpret $everything as the union of _include and _revinclude
-	query := fmt.Sprintf("_id=%s&_include=*&_revinclude=*", c.Param("id"))
+	queryParams := search.URLQueryParameters{}
+	queryParams.Add("_id", c.Param("id"))
+	queryParams.Add(search.GraphParam, graph)
 
-	searchQuery := search.Query{Resource: rc.Name, Query: query}
+	searchQuery := search.Query{Resource: rc.Name, Query: queryParams.Encode()}
 	baseURL := rc.Config.responseURL(c.Request, rc.Name)
 	bundle, err := session.Search(*baseURL, searchQuery)
 	if err != nil {
-		panic(errors.Wrap(err, "Search (everything) failed"))
+		panic(errors.Wrap(err, "Search (graph) failed"))
+	}
+	if err := filterIncludedByScopes(c, rc.Config, bundle); err != nil {
+		panic(err)
 	}
 
 	c.Set("bundle", bundle)
@@ -178,6 +358,195 @@ func (rc *ResourceController) EverythingHandler(c *gin.Context) {
 	c.Render(http.StatusOK, CustomFhirRenderer{bundle, c})
 }
 
+// DocumentHandler handles Composition $document requests, responding with a document Bundle of the Composition
+// followed by the resources it refers to, and those they refer to in turn (see documentBundle).  With persist=true
+// (in the query or a Parameters body) the Bundle is also stored, and its location returned in the Location header.
+func (rc *ResourceController) DocumentHandler(c *gin.Context) {
+	defer handlePanics(c)
+	c.Set("Resource", rc.Name)
+	c.Set("Action", "operation")
+
+	persist := c.Query("persist")
+	if c.Request.Method == http.MethodPost && c.Request.ContentLength > 0 {
+		resource, err := FHIRBind(c, rc.Config.ValidatorURL)
+		if err == nil && resource.ResourceType() != "Parameters" {
+			err = fmt.Errorf("Expected a Parameters but got a %s", resource.ResourceType())
+		}
+		if err != nil {
+			oo := models.NewOperationOutcome("fatal", "structure", err.Error())
+			c.Render(http.StatusBadRequest, CustomFhirRenderer{oo, c})
+			return
+		}
+		var parameters struct {
+			Parameter []struct {
+				Name         string `json:"name"`
+				ValueBoolean *bool  `json:"valueBoolean"`
+			} `json:"parameter"`
+		}
+		if err := json.Unmarshal(resource.JsonBytes(), &parameters); err != nil {
+			panic(errors.Wrap(err, "DocumentHandler: failed to parse Parameters"))
+		}
+		for _, parameter := range parameters.Parameter {
+			if parameter.Name == "persist" && parameter.ValueBoolean != nil {
+				persist = strconv.FormatBool(*parameter.ValueBoolean)
+			}
+		}
+	}
+	if persist != "" && persist != "true" && persist != "false" {
+		oo := models.NewOperationOutcome("fatal", "value", fmt.Sprintf("invalid persist parameter: %q", persist))
+		c.Render(http.StatusBadRequest, CustomFhirRenderer{oo, c})
+		return
+	}
+
+	session := rc.DAL.StartSession(c.Request.Context(), c.GetHeader("Db"))
+	defer session.Finish()
+
+	composition, err := session.Get(c.Param("id"), rc.Name)
+	switch err {
+	case nil:
+	case ErrNotFound:
+		c.Status(http.StatusNotFound)
+		return
+	case ErrDeleted:
+		c.Status(http.StatusGone)
+		return
+	default:
+		panic(errors.Wrap(err, "Get (document) failed"))
+	}
+
+	bundle, err := documentBundle(session, composition, func(resourceType, id string) string {
+		return rc.Config.responseURL(c.Request, resourceType, id).String()
+	})
+	if err != nil {
+		panic(errors.Wrap(err, "documentBundle failed"))
+	}
+	if persist != "true" {
+		c.Render(http.StatusOK, CustomFhirRenderer{bundle, c})
+		return
+	}
+
+	resource, err := bundle.ToResource()
+	if err != nil {
+		panic(errors.Wrap(err, "DocumentHandler: ToResource failed"))
+	}
+	id, err := session.Post(resource)
+	if err != nil {
+		panic(errors.Wrap(err, "Post (document) failed"))
+	}
+	c.Header("Location", rc.Config.responseURL(c.Request, "Bundle", id).String())
+	c.Render(http.StatusOK, CustomFhirRenderer{resource, c})
+}
+
+// documentBundle returns a document Bundle of a Composition followed by the resources on the server it refers to,
+// directly or through the others, each once and nearest first.  The references to resources that don't exist are
+// left out.
+func documentBundle(session DataAccessSession, composition *models2.Resource, fullURL func(resourceType, id string) string) (*models2.ShallowBundle, error) {
+	bundle := &models2.ShallowBundle{
+		Type:       "document",
+		Identifier: &models.Identifier{System: "urn:ietf:rfc:3986", Value: "urn:uuid:" + uuid.New().String()},
+	}
+	added := map[string]bool{composition.ResourceType() + "/" + composition.Id(): true}
+	pending := []*models2.Resource{composition}
+	for len(pending) > 0 {
+		resource := pending[0]
+		pending = pending[1:]
+		bundle.Entry = append(bundle.Entry, models2.ShallowBundleEntryComponent{
+			FullUrl:  fullURL(resource.ResourceType(), resource.Id()),
+			Resource: resource,
+		})
+
+		references, err := resource.LocalReferences()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to find the references of %s/%s", resource.ResourceType(), resource.Id())
+		}
+		for _, reference := range references {
+			if added[reference.Target()] {
+				continue
+			}
+			added[reference.Target()] = true
+			referenced, err := session.Get(reference.ID, reference.Type)
+			if err == ErrNotFound || err == ErrDeleted {
+				continue
+			} else if err != nil {
+				return nil, errors.Wrapf(err, "failed to get %s", reference.Target())
+			}
+			pending = append(pending, referenced)
+		}
+	}
+	return bundle, nil
+}
+
+// ValidateHandler handles $validate requests, checking a resource (or the resource parameter of a Parameters
+// resource) against the definition of its type and its profiles, or else the profile given by the profile parameter.
+// Its issues are returned in an OperationOutcome, whether or not it's valid.
+func (rc *ResourceController) ValidateHandler(c *gin.Context) {
+	defer handlePanics(c)
+	c.Set("Resource", rc.Name)
+	c.Set("Action", "operation")
+
+	resource, err := FHIRBind(c, rc.Config.ValidatorURL)
+	if err != nil {
+		oo := models.NewOperationOutcome("fatal", "structure", err.Error())
+		c.Render(http.StatusBadRequest, CustomFhirRenderer{oo, c})
+		return
+	}
+
+	profile := c.Query("profile")
+	if resource.ResourceType() == "Parameters" && rc.Name != "Parameters" {
+		var parameters struct {
+			Parameter []struct {
+				Name     string          `json:"name"`
+				Resource json.RawMessage `json:"resource"`
+				ValueUri string          `json:"valueUri"`
+			} `json:"parameter"`
+		}
+		err = json.Unmarshal(resource.JsonBytes(), &parameters)
+		if err != nil {
+			panic(errors.Wrap(err, "ValidateHandler: failed to parse Parameters"))
+		}
+		resource = nil
+		for _, parameter := range parameters.Parameter {
+			switch parameter.Name {
+			case "resource":
+				resource, err = models2.NewResourceFromJsonBytes(parameter.Resource)
+				if err != nil {
+					oo := models.NewOperationOutcome("fatal", "structure", err.Error())
+					c.Render(http.StatusBadRequest, CustomFhirRenderer{oo, c})
+					return
+				}
+			case "profile":
+				profile = parameter.ValueUri
+			}
+		}
+		if resource == nil {
+			oo := models.NewOperationOutcome("fatal", "required", "The resource parameter is required")
+			c.Render(http.StatusBadRequest, CustomFhirRenderer{oo, c})
+			return
+		}
+	}
+	if resource.ResourceType() != rc.Name {
+		oo := models.NewOperationOutcome("fatal", "invalid", fmt.Sprintf("Expected a %s but got a %s", rc.Name, resource.ResourceType()))
+		c.Render(http.StatusBadRequest, CustomFhirRenderer{oo, c})
+		return
+	}
+
+	if profile != "" {
+		err = models2.ValidateResourceAgainstProfile(resource, profile)
+	} else {
+		err = models2.ValidateResource(resource)
+	}
+	if err == nil {
+		oo := models.NewOperationOutcome("information", "informational", "No issues found")
+		c.Render(http.StatusOK, CustomFhirRenderer{oo, c})
+		return
+	}
+	if _, isValidationError := err.(models2.FhirValidationError); !isValidationError {
+		panic(errors.Wrap(err, "ValidateHandler failed"))
+	}
+	_, outcome := ErrorToOpOutcome(err)
+	c.Render(http.StatusOK, CustomFhirRenderer{outcome, c})
+}
+
 // CreateHandler handles requests to create a new resource instance, assigning it a new ID.
 func (rc *ResourceController) CreateHandler(c *gin.Context) {
 	defer handlePanics(c)
@@ -191,8 +560,13 @@ func (rc *ResourceController) CreateHandler(c *gin.Context) {
 		return
 	}
 
-	// check for conditional create
-	ifNoneExist := c.GetHeader("If-None-Exist")
+	provenanceHeader, ok := rc.provenanceHeader(c, session)
+	if !ok {
+		return
+	}
+
+	// check for conditional create (e.g. If-None-Exist: identifier=http://acme.org/mrns|12345)
+	ifNoneExist := strings.TrimPrefix(c.GetHeader("If-None-Exist"), "?")
 	var httpStatus int
 	var resourceId string
 	if len(ifNoneExist) > 0 {
@@ -205,19 +579,27 @@ func (rc *ResourceController) CreateHandler(c *gin.Context) {
 	if err != nil {
 		panic(errors.Wrap(err, "CreateHandler Post/ConditionalPost failed"))
 	}
+	if httpStatus == http.StatusPreconditionFailed {
+		oo := models.NewOperationOutcome("error", "multiple-matches", "If-None-Exist search criteria were not selective enough")
+		c.Render(httpStatus, CustomFhirRenderer{oo, c})
+		return
+	}
+	if httpStatus != http.StatusCreated {
+		// an existing resource matched, so nothing was stored
+		provenanceHeader = ""
+	}
+	rc.commitWithProvenance(c, session, provenanceHeader, resource)
 
 	c.Set(rc.Name, resource)
 	c.Set("Resource", rc.Name)
 	c.Set("Action", "create")
 
-	if resource != nil { // nil when e.g. HTTP status from ConditionalPost 412
-		err = setHeaders(c, rc, true, resource, resourceId)
-		if err != nil {
-			panic(errors.Wrap(err, "CreateHandler setHeaders failed"))
-		}
+	err = setHeaders(c, rc, true, resource, resourceId)
+	if err != nil {
+		panic(errors.Wrap(err, "CreateHandler setHeaders failed"))
 	}
 
-	c.Render(httpStatus, CustomFhirRenderer{resource, c})
+	renderChangedResource(c, httpStatus, resource)
 }
 
 // UpdateHandler handles requests to update a resource having a given ID.  If the resource with that ID does not
@@ -246,12 +628,18 @@ func (rc *ResourceController) UpdateHandler(c *gin.Context) {
 		}
 	}
 
+	provenanceHeader, ok := rc.provenanceHeader(c, session)
+	if !ok {
+		return
+	}
+
 	// Perform update
 	resourceId := c.Param("id")
 	createdNew, err := session.Put(resourceId, conditionalVersionId, resource)
 	if err != nil {
 		panic(errors.Wrap(err, "Put failed"))
 	}
+	rc.commitWithProvenance(c, session, provenanceHeader, resource)
 
 	c.Set(rc.Name, resource)
 	c.Set("Resource", rc.Name)
@@ -262,10 +650,10 @@ func (rc *ResourceController) UpdateHandler(c *gin.Context) {
 
 	if createdNew {
 		c.Set("Action", "create")
-		c.Render(http.StatusCreated, CustomFhirRenderer{resource, c})
+		renderChangedResource(c, http.StatusCreated, resource)
 	} else {
 		c.Set("Action", "update")
-		c.Render(http.StatusOK, CustomFhirRenderer{resource, c})
+		renderChangedResource(c, http.StatusOK, resource)
 	}
 }
 
@@ -297,33 +685,166 @@ func (rc *ResourceController) ConditionalUpdateHandler(c *gin.Context) {
 		}
 	}
 
+	// Without search criteria every resource would match
+	if c.Request.URL.RawQuery == "" {
+		oo := models.NewOperationOutcome("error", "required", "Conditional update requires search criteria")
+		c.Render(http.StatusBadRequest, CustomFhirRenderer{oo, c})
+		return
+	}
+
+	provenanceHeader, ok := rc.provenanceHeader(c, session)
+	if !ok {
+		return
+	}
+
 	// Perform update
 	query := search.Query{Resource: rc.Name, Query: c.Request.URL.RawQuery}
 	resourceId, createdNew, err := session.ConditionalPut(query, conditionalVersionId, resource)
 
 	_, isErrMultipleMatches1 := err.(ErrMultipleMatches)
 	_, isErrMultipleMatches2 := err.(*ErrMultipleMatches)
+	_, isErrIDMismatch := err.(*ErrIDMismatch)
 	if isErrMultipleMatches1 || isErrMultipleMatches2 {
-		c.AbortWithStatus(http.StatusPreconditionFailed)
+		oo := models.NewOperationOutcome("error", "multiple-matches", err.Error())
+		c.Render(http.StatusPreconditionFailed, CustomFhirRenderer{oo, c})
+		return
+	} else if isErrIDMismatch {
+		oo := models.NewOperationOutcome("error", "invalid", err.Error())
+		c.Render(http.StatusBadRequest, CustomFhirRenderer{oo, c})
 		return
 	} else if err != nil {
 		panic(errors.Wrap(err, "ConditionalPut failed"))
 	}
+	rc.commitWithProvenance(c, session, provenanceHeader, resource)
 
 	c.Set("Resource", rc.Name)
 
 	err = setHeaders(c, rc, true, resource, resourceId)
+	if err != nil {
+		panic(errors.Wrap(err, "ConditionalUpdateHandler setHeaders failed"))
+	}
 
 	if createdNew {
 		c.Set("Action", "create")
-		c.Render(http.StatusCreated, CustomFhirRenderer{resource, c})
+		renderChangedResource(c, http.StatusCreated, resource)
 	} else {
 		c.Set("Action", "update")
-		c.Render(http.StatusOK, CustomFhirRenderer{resource, c})
+		renderChangedResource(c, http.StatusOK, resource)
 	}
 }
 
-// DeleteHandler handles requests to delete a resource instance identified by its ID.
+// PatchHandler handles requests to change a resource instance with a JSON Patch or a FHIRPath Patch (see
+// patch.go).  The patched resource is stored like an update, with the version that was patched as its If-Match
+// (unless one is given, or histories are disabled), and is patched again if another update was stored first.  If-Match
+// can't be given when histories are disabled, as there are no versions to match.
+func (rc *ResourceController) PatchHandler(c *gin.Context) {
+	defer handlePanics(c)
+	session := rc.DAL.StartSession(c.Request.Context(), c.GetHeader("Db"))
+	defer session.Finish()
+
+	patch, err := bindPatch(c, rc.Config.ValidatorURL)
+	if err != nil {
+		oo := models.NewOperationOutcome("fatal", "structure", err.Error())
+		if _, isInvalid := errors.Cause(err).(ErrInvalidPatch); isInvalid {
+			oo = models.NewOperationOutcome("error", "invalid", err.Error())
+		}
+		c.Render(http.StatusBadRequest, CustomFhirRenderer{oo, c})
+		return
+	}
+
+	ifMatchVersionId := ""
+	if ifMatch := c.GetHeader("If-Match"); ifMatch != "" {
+		ifMatchVersionId, err = utils.ETagToVersionId(ifMatch)
+		if err != nil {
+			oo := models.NewOperationOutcome("fatal", "structure", err.Error())
+			c.Render(http.StatusBadRequest, CustomFhirRenderer{oo, c})
+			return
+		}
+		// Versions aren't tracked without histories, so there's nothing to check it against
+		if !rc.Config.EnableHistory {
+			oo := models.NewOperationOutcome("error", "not-supported", "If-Match can't be used when version histories are disabled")
+			c.Render(http.StatusBadRequest, CustomFhirRenderer{oo, c})
+			return
+		}
+	} else if rc.Config.RequireIfMatch && rc.Config.EnableHistory {
+		oo := models.NewOperationOutcome("error", "conflict", "If-Match is required to patch "+rc.Name+"/"+c.Param("id"))
+		c.Render(http.StatusPreconditionFailed, CustomFhirRenderer{oo, c})
+		return
+	}
+
+	provenanceHeader, ok := rc.provenanceHeader(c, session)
+	if !ok {
+		return
+	}
+
+	resourceId := c.Param("id")
+	var patched *models2.Resource
+	for attempt := 1; ; attempt++ {
+		resource, err := session.Get(resourceId, rc.Name)
+		switch err {
+		case nil:
+		case ErrNotFound:
+			c.Status(http.StatusNotFound)
+			return
+		case ErrDeleted:
+			c.Status(http.StatusGone)
+			return
+		default:
+			panic(errors.Wrap(err, "Get failed"))
+		}
+
+		patched, err = applyPatch(resource, patch)
+		if err != nil {
+			switch cause := errors.Cause(err).(type) {
+			case ErrInvalidPatch:
+				oo := models.NewOperationOutcome("error", "invalid", cause.Error())
+				c.Render(http.StatusBadRequest, CustomFhirRenderer{oo, c})
+			case ErrPatchFailed:
+				oo := models.NewOperationOutcome("error", "processing", cause.Error())
+				c.Render(http.StatusUnprocessableEntity, CustomFhirRenderer{oo, c})
+			default:
+				panic(errors.Wrap(err, "applyPatch failed"))
+			}
+			return
+		}
+		if shouldEncryptPatientDetails(c) {
+			patched.SetWhatToEncrypt(models2.WhatToEncrypt{PatientDetails: true})
+		}
+
+		conditionalVersionId := ifMatchVersionId
+		if conditionalVersionId == "" && rc.Config.EnableHistory {
+			conditionalVersionId = resource.VersionId()
+		}
+		_, err = session.Put(resourceId, conditionalVersionId, patched)
+		_, isConflict := errors.Cause(err).(ErrConflict)
+		_, isVersionMismatch := errors.Cause(err).(ErrVersionMismatch)
+		if (isConflict || isVersionMismatch) && ifMatchVersionId == "" && attempt < maxPatchAttempts {
+			continue
+		}
+		if err != nil {
+			panic(errors.Wrap(err, "Put failed"))
+		}
+		break
+	}
+	rc.commitWithProvenance(c, session, provenanceHeader, patched)
+
+	c.Set(rc.Name, patched)
+	c.Set("Resource", rc.Name)
+	c.Set("Action", "update")
+
+	if err := setHeaders(c, rc, false, patched, resourceId); err != nil {
+		panic(errors.Wrap(err, "PatchHandler setHeaders failed"))
+	}
+	renderChangedResource(c, http.StatusOK, patched)
+}
+
+// maxPatchAttempts limits how many times a PATCH is retried when other updates of the resource are stored
+// while it's being patched
+const maxPatchAttempts = 3
+
+// DeleteHandler handles requests to delete a resource instance identified by its ID.  It responds with 204 No Content,
+// or else with an OperationOutcome of the changes made to the resources referring to it, or of warnings about their
+// references (see Config.ReferencedDeletes).
 func (rc *ResourceController) DeleteHandler(c *gin.Context) {
 	defer handlePanics(c)
 	session := rc.DAL.StartSession(c.Request.Context(), c.GetHeader("Db"))
@@ -331,11 +852,28 @@ func (rc *ResourceController) DeleteHandler(c *gin.Context) {
 
 	id := c.Param("id")
 
-	newVersionId, err := session.Delete(id, rc.Name)
+	if changesReferringResources(rc.Config, rc.Name) {
+		if err := session.StartTransaction(); err != nil {
+			panic(errors.Wrap(err, "Delete failed"))
+		}
+	}
+	newVersionId, changes, err := session.Delete(id, rc.Name)
 	if err != nil && err != ErrNotFound {
 		panic(errors.Wrap(err, "Delete failed"))
 	}
 
+	issues := referenceChangeIssues(changes)
+	if err == nil {
+		warnings, err := referenceWarnings(session, rc.Config, rc.Name, []string{id})
+		if err != nil {
+			panic(errors.Wrap(err, "Delete failed"))
+		}
+		issues = append(issues, warnings...)
+	}
+	if err := session.CommmitIfTransaction(); err != nil {
+		panic(errors.Wrap(err, "Delete failed"))
+	}
+
 	c.Set(rc.Name, id)
 	c.Set("Resource", rc.Name)
 	c.Set("Action", "delete")
@@ -343,26 +881,81 @@ func (rc *ResourceController) DeleteHandler(c *gin.Context) {
 	if newVersionId != "" {
 		c.Header("ETag", "W/\""+newVersionId+"\"")
 	}
+	if len(issues) > 0 {
+		c.Render(http.StatusOK, CustomFhirRenderer{&models.OperationOutcome{Issue: issues}, c})
+		return
+	}
 	c.Status(http.StatusNoContent)
 }
 
 // ConditionalDeleteHandler handles requests to delete resources identified by search criteria.  All resources
-// matching the search criteria will be deleted.
+// matching the search criteria will be deleted (or, unless enabled by Config.ConditionalDeleteMultiple, the single
+// match, failing with 412 Precondition Failed if several match).  The response's OperationOutcome reports how
+// many were deleted, the changes made to the resources referring to them, and any warnings about the references of
+// other resources to the first page of them (see Config.ReferencedDeletes).
 func (rc *ResourceController) ConditionalDeleteHandler(c *gin.Context) {
 	defer handlePanics(c)
 	session := rc.DAL.StartSession(c.Request.Context(), c.GetHeader("Db"))
 	defer session.Finish()
 
+	// Without search criteria every resource would match
+	if c.Request.URL.RawQuery == "" {
+		oo := models.NewOperationOutcome("error", "required", "Conditional delete requires search criteria")
+		c.Render(http.StatusBadRequest, CustomFhirRenderer{oo, c})
+		return
+	}
+
 	query := search.Query{Resource: rc.Name, Query: c.Request.URL.RawQuery}
-	_, err := session.ConditionalDelete(query)
+	var IDs []string
+	if rc.Config.referencedDeletesOf(rc.Name) == ReferencedDeletesWarn {
+		var err error
+		if IDs, err = session.FindIDs(query); err != nil {
+			panic(errors.Wrap(err, "ConditionalDelete failed"))
+		}
+	}
+	if changesReferringResources(rc.Config, rc.Name) {
+		if err := session.StartTransaction(); err != nil {
+			panic(errors.Wrap(err, "ConditionalDelete failed"))
+		}
+	}
+	count, changes, err := session.ConditionalDelete(query)
+	if err != nil {
+		panic(errors.Wrap(err, "ConditionalDelete failed"))
+	}
+	warnings, err := referenceWarnings(session, rc.Config, rc.Name, IDs)
 	if err != nil {
 		panic(errors.Wrap(err, "ConditionalDelete failed"))
 	}
+	if err := session.CommmitIfTransaction(); err != nil {
+		panic(errors.Wrap(err, "ConditionalDelete failed"))
+	}
 
 	c.Set("Resource", rc.Name)
 	c.Set("Action", "delete")
 
-	c.Status(http.StatusNoContent)
+	oo := models.NewOperationOutcome("information", "informational", fmt.Sprintf("Deleted %d %s resource(s)", count, rc.Name))
+	oo.Issue = append(oo.Issue, referenceChangeIssues(changes)...)
+	oo.Issue = append(oo.Issue, warnings...)
+	c.Render(http.StatusOK, CustomFhirRenderer{oo, c})
+}
+
+// renderChangedResource renders the response to a create, update or patch as its Prefer: return preference asks, with
+// the stored resource (return=representation, the default), an empty body (return=minimal) or an OperationOutcome
+// (return=OperationOutcome).
+func renderChangedResource(c *gin.Context, httpStatus int, resource *models2.Resource) {
+	switch preference(c, "return") {
+	case "minimal":
+		c.Status(httpStatus)
+	case "OperationOutcome":
+		message := fmt.Sprintf("Stored %s/%s", resource.ResourceType(), resource.Id())
+		if versionId := resource.VersionId(); versionId != "" {
+			message += " version " + versionId
+		}
+		oo := models.NewOperationOutcome("information", "informational", message)
+		c.Render(httpStatus, CustomFhirRenderer{oo, c})
+	default:
+		c.Render(httpStatus, CustomFhirRenderer{resource, c})
+	}
 }
 
 func setHeaders(c *gin.Context, rc *ResourceController, setLocationHeader bool, resource *models2.Resource, id string) error {
@@ -391,7 +984,7 @@ func setHeaders(c *gin.Context, rc *ResourceController, setLocationHeader bool,
 // that the special characters "<", ">", and "&" are not escaped after the
 // the JSON is marshaled. Escaping these special HTML characters is the default
 // behavior of Go's json.Marshal().
-// It also outputs XML if that is required
+// It also outputs XML if that is required, and indents the output for _pretty=true
 type CustomFhirRenderer struct {
 	obj interface{}
 	c   *gin.Context
@@ -424,14 +1017,27 @@ func (u CustomFhirRenderer) Render(w http.ResponseWriter) (err error) {
 			fmt.Printf("ERROR: JsonToXml failed for data: %+v %s\n", u.obj, string(data))
 			return
 		}
+		data = []byte(xml)
+		if u.c.Query("_pretty") == "true" {
+			if data, err = indentXML(data); err != nil {
+				return errors.Wrap(err, "CustomFhirRenderer: indentXML failed")
+			}
+		}
 		writeContentType(w, fhirXMLContentType)
-		_, err = w.Write([]byte(xml))
+		_, err = w.Write(data)
 	} else {
 		// Replace the escaped characters in the data
 		data = bytes.Replace(data, []byte("\\u003c"), []byte("<"), -1)
 		data = bytes.Replace(data, []byte("\\u003e"), []byte(">"), -1)
 		data = bytes.Replace(data, []byte("\\u0026"), []byte("&"), -1)
 
+		if u.c.Query("_pretty") == "true" {
+			var indented bytes.Buffer
+			if err = json.Indent(&indented, data, "", "  "); err != nil {
+				return errors.Wrap(err, "CustomFhirRenderer: json.Indent failed")
+			}
+			data = indented.Bytes()
+		}
 		writeContentType(w, fhirJSONContentType)
 		_, err = w.Write(data)
 	}
@@ -439,7 +1045,11 @@ func (u CustomFhirRenderer) Render(w http.ResponseWriter) (err error) {
 }
 
 func (u CustomFhirRenderer) WriteContentType(w http.ResponseWriter) {
-	writeContentType(w, fhirJSONContentType)
+	if u.c.GetBool("SendXML") {
+		writeContentType(w, fhirXMLContentType)
+	} else {
+		writeContentType(w, fhirJSONContentType)
+	}
 }
 
 func writeContentType(w http.ResponseWriter, value []string) {
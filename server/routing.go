@@ -5,10 +5,14 @@ package server
 import (
 	"fmt"
 	"net/http"
+	"regexp"
+	"strings"
+	"sync"
 
 	"github.com/gin-gonic/contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"github.com/eug48/fhir/auth"
+	"github.com/eug48/fhir/search"
 	"github.com/mitre/heart"
 	"golang.org/x/oauth2"
 )
@@ -29,32 +33,152 @@ func RegisterController(name string, e *gin.Engine, m []gin.HandlerFunc, dal Dat
 		rcBase.Use(auth.HEARTScopesHandler(name))
 	case auth.AuthTypeHEART:
 		rcBase.Use(auth.HEARTScopesHandler(name))
+	case auth.AuthTypeSMART:
+		rcBase.Use(auth.SMARTScopesHandler(name), restrictToCompartment)
+	case auth.AuthTypeAPIKey, auth.AuthTypeMutualTLS:
+		rcBase.Use(auth.SMARTScopesHandler(name))
 	}
 
 	rcBase.GET("", rc.IndexHandler)
-	rcBase.POST("/_search", rc.IndexHandler)
 	rcBase.POST("", rc.CreateHandler)
 	rcBase.PUT("", rc.ConditionalUpdateHandler)
 	rcBase.DELETE("", rc.ConditionalDeleteHandler)
 
 	rcItem := rcBase.Group("/:id")
-	rcItem.GET("", rc.ShowHandler)
+	// GET /Patient/_history, /Patient/$export and /Patient/$changes are routed by the id for the same reason as POST
+	// /Patient/_search
+	staticGETs := make(map[string]gin.HandlerFunc)
+	if config.EnableHistory {
+		staticGETs["_history"] = rc.TypeHistoryHandler
+	}
+	if config.EnableBulkExport && name == "Patient" {
+		staticGETs["$export"] = BulkExportHandler(dal, config, "Patient")
+	}
+	if config.EnableChangeFeed {
+		staticGETs["$changes"] = ChangeFeedHandler(dal, name)
+	}
+	if len(staticGETs) > 0 {
+		rcItem.GET("", routeStaticIDs(staticGETs, rc.ShowHandler))
+		addStaticIDRoutes(e, "GET", "/"+name, staticGETs)
+	} else {
+		rcItem.GET("", rc.ShowHandler)
+	}
+	// POST /Patient/_search and /Patient/$validate are routed by the id, as gin can't route them alongside the
+	// compartment searches below
+	staticPOSTs := map[string]gin.HandlerFunc{"_search": rc.IndexHandler, "$validate": rc.ValidateHandler}
+	rcItem.POST("", routeStaticIDs(staticPOSTs, nil))
+	addStaticIDRoutes(e, "POST", "/"+name, staticPOSTs)
 	if config.EnableHistory {
 		rcItem.GET("/_history/:vid", rc.ShowHandler)
 		rcItem.GET("/_history", rc.HistoryHandler)
 	}
 	rcItem.PUT("", rc.UpdateHandler)
+	rcItem.PATCH("", rc.PatchHandler)
 	rcItem.DELETE("", rc.DeleteHandler)
+	rcItem.GET("/$graph", rc.GraphHandler)
+	rcItem.GET("/$graphql", GraphQLHandler(dal, config, name))
+	rcItem.POST("/$graphql", GraphQLHandler(dal, config, name))
+	rcItem.POST("/$validate", rc.ValidateHandler)
 
 	if name == "Patient" || name == "Encounter" {
 		everythingItem := rcItem.Group("/$everything")
 		everythingItem.GET("", rc.EverythingHandler)
 	}
+	if name == "Composition" {
+		rcItem.GET("/$document", rc.DocumentHandler)
+		rcItem.POST("/$document", rc.DocumentHandler)
+	}
+	if name == "Group" && config.EnableBulkExport {
+		rcItem.GET("/$export", BulkExportHandler(dal, config, "Group"))
+	}
+	if name == "Subscription" && config.EnableSubscriptions {
+		rcItem.GET("/$status", SubscriptionStatusHandler(dal, config))
+		rcItem.GET("/$events", SubscriptionEventsHandler(dal, config))
+	}
+
+	// Compartment searches, e.g. GET /Patient/123/Observation
+	compartmentTypes := search.CompartmentResourceTypes(name)
+	for _, resourceType := range compartmentTypes {
+		rcItem.GET("/"+resourceType, rc.CompartmentSearchHandler(resourceType))
+		rcItem.POST("/"+resourceType+"/_search", rc.CompartmentSearchHandler(resourceType))
+	}
+	if len(compartmentTypes) > 0 {
+		// GET /Patient/123/* is routed here by the NoRoute handler (see compartmentSearchAllHandler)
+		rcItem.GET("/"+compartmentSearchAllRoute, rc.CompartmentSearchHandler("*"))
+	}
+}
+
+// routeStaticIDs returns a handler of /[type]/:id routes that handles requests with a static path segment in place
+// of the id (e.g. POST /Patient/_search) with the handler of the segment, as gin can't route static segments alongside
+// :id wildcards.  Other requests are handled by the id handler, or else aren't found.
+func routeStaticIDs(staticHandlers map[string]gin.HandlerFunc, idHandler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if handler, isStatic := staticHandlers[c.Param("id")]; isStatic {
+			handler(c)
+		} else if idHandler != nil {
+			idHandler(c)
+		} else {
+			c.String(http.StatusNotFound, "404 page not found")
+		}
+	}
+}
+
+// staticIDRoutes are the routes of each engine that are routed by the id (see routeStaticIDs), which gin doesn't list
+// in its Routes
+var staticIDRoutes = struct {
+	sync.Mutex
+	routes map[*gin.Engine]gin.RoutesInfo
+}{routes: make(map[*gin.Engine]gin.RoutesInfo)}
+
+// addStaticIDRoutes records the routes of an engine that are routed by the id of the routes of a base path
+func addStaticIDRoutes(e *gin.Engine, method string, basePath string, staticHandlers map[string]gin.HandlerFunc) {
+	staticIDRoutes.Lock()
+	defer staticIDRoutes.Unlock()
+	for segment := range staticHandlers {
+		staticIDRoutes.routes[e] = append(staticIDRoutes.routes[e], gin.RouteInfo{Method: method, Path: basePath + "/" + segment})
+	}
+}
+
+// engineRoutes returns the routes registered with an engine, including those that are routed by the id
+func engineRoutes(e *gin.Engine) gin.RoutesInfo {
+	staticIDRoutes.Lock()
+	defer staticIDRoutes.Unlock()
+	return append(e.Routes(), staticIDRoutes.routes[e]...)
+}
+
+// compartmentSearchAllRoute is the route of searches of all the resources in a compartment (e.g. GET /Patient/123/*),
+// as gin routes can't end with "*"
+const compartmentSearchAllRoute = "_compartment"
+
+var compartmentSearchAllPath = regexp.MustCompile(`^/([A-Za-z]+)/([^/]+)/\*$`)
+
+// compartmentSearchAllHandler routes searches of all the resources in a compartment (e.g. GET /Patient/123/*)
+// to compartmentSearchAllRoute, and otherwise responds that there's no route like gin does
+func compartmentSearchAllHandler(e *gin.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		match := compartmentSearchAllPath.FindStringSubmatch(c.Request.URL.Path)
+		if c.Request.Method == "GET" && match != nil && search.CompartmentDefinitions[match[1]] != nil {
+			c.Request.URL.Path = "/" + match[1] + "/" + match[2] + "/" + compartmentSearchAllRoute
+			e.HandleContext(c)
+			return
+		}
+		c.String(http.StatusNotFound, "404 page not found")
+	}
 }
 
 // RegisterRoutes registers the routes for each of the FHIR resources
 func RegisterRoutes(e *gin.Engine, config map[string][]gin.HandlerFunc, dal DataAccessLayer, serverConfig Config) {
 
+	// Health checks for the probes of Kubernetes, registered before any of the middleware below so that they don't
+	// need credentials and aren't rate limited or audited
+	e.GET("/health/live", LivenessHandler)
+	e.GET("/health/ready", ReadinessHandler(dal))
+
+	// The databases of tenants' requests, selected before any of the middleware below uses them
+	if serverConfig.EnableTenancy {
+		e.Use(tenantContext(dal, serverConfig))
+	}
+
 	switch serverConfig.Auth.Method {
 	case auth.AuthTypeNone:
 		// do nothing
@@ -92,6 +216,167 @@ func RegisterRoutes(e *gin.Engine, config map[string][]gin.HandlerFunc, dal Data
 		heart.SetUpRoutes(serverConfig.Auth.JWKPath, serverConfig.Auth.ClientID, serverConfig.Auth.OPURL,
 			serverConfig.ServerURL, serverConfig.Auth.SessionSecret, e)
 
+	case auth.AuthTypeSMART:
+		// Requests with only patient scopes are limited to the patient's compartment (see restrictToCompartment)
+		dal = compartmentDataAccessLayer{dal}
+
+		// Every request needs a bearer token, except those for the discovery documents that SMART apps read first,
+		// those of the authorization server of backend services and those that are handled again without the tenant
+		// or database in their path.  Those for the routes of resource types are authorized by their scopes (see
+		// RegisterController), bulk exports by the types their scopes can read, those that manage tenants by the
+		// TenantAdminScope, and the others (e.g. system-wide searches and transactions) need scopes for all resource
+		// types.
+		bearerTokenHandler := auth.SMARTBearerTokenHandler(serverConfig.Auth)
+		if len(serverConfig.Auth.CertificateIdentities) > 0 {
+			// or client certificates, which are authorized by their identities' scopes
+			bearerTokenHandler = auth.ClientCertificateHandler(serverConfig.Auth, bearerTokenHandler)
+		}
+		systemScopesHandler := auth.SMARTScopesHandler("*")
+		e.Use(func(c *gin.Context) {
+			path := c.Request.URL.Path
+			if path == "/metadata" || path == "/.well-known/smart-configuration" || strings.HasPrefix(path, "/auth/") ||
+				isRedispatched(path, serverConfig) {
+				return
+			}
+			if !restoreAsyncIdentity(c) {
+				bearerTokenHandler(c)
+			}
+			if !c.IsAborted() {
+				auth.SMARTBulkExportHandler(c)
+			}
+			isBulkExport := strings.HasPrefix(path, "/$export")
+			isAdmin := strings.HasPrefix(path, "/$tenants")
+			if !c.IsAborted() && !isBulkExport && !isAdmin && search.SearchParameters()[strings.Split(path, "/")[1]] == nil {
+				systemScopesHandler(c)
+			}
+		})
+		if serverConfig.Auth.SigningKey != nil {
+			// the authorization server of SMART Backend Services
+			e.POST("/auth/token", auth.SMARTTokenHandler(serverConfig.Auth))
+			e.GET("/auth/jwks", auth.SMARTJWKSHandler(serverConfig.Auth))
+		}
+		e.GET("/.well-known/smart-configuration", auth.SMARTConfigurationHandler(serverConfig.Auth))
+
+	case auth.AuthTypeAPIKey:
+		// Every request needs an API key, except those for the CapabilityStatement and those that are handled again
+		// without the tenant or database in their path.  Those for the routes of resource types are authorized by
+		// the SMART system scopes of their keys (see RegisterController), bulk exports by the types their keys can
+		// read, those that manage API keys or tenants by their keys being admin keys, and the others need keys for all
+		// types.
+		apiKeyHandler := auth.APIKeyHandler(apiKeyLookup(dal))
+		if len(serverConfig.Auth.CertificateIdentities) > 0 {
+			// or client certificates, which are authorized by their identities' scopes
+			apiKeyHandler = auth.ClientCertificateHandler(serverConfig.Auth, apiKeyHandler)
+		}
+		systemScopesHandler := auth.SMARTScopesHandler("*")
+		e.Use(func(c *gin.Context) {
+			path := c.Request.URL.Path
+			if path == "/metadata" || isRedispatched(path, serverConfig) {
+				return
+			}
+			if !restoreAsyncIdentity(c) {
+				apiKeyHandler(c)
+			}
+			if !c.IsAborted() {
+				auth.SMARTBulkExportHandler(c)
+			}
+			isBulkExport := strings.HasPrefix(path, "/$export")
+			isAdmin := strings.HasPrefix(path, "/$api-keys") || strings.HasPrefix(path, "/$tenants")
+			if !c.IsAborted() && !isBulkExport && !isAdmin && search.SearchParameters()[strings.Split(path, "/")[1]] == nil {
+				systemScopesHandler(c)
+			}
+		})
+		e.GET("/$api-keys", auth.APIKeyAdminHandler, APIKeysHandler(dal))
+		e.POST("/$api-keys", auth.APIKeyAdminHandler, CreateAPIKeyHandler(dal, serverConfig))
+		e.DELETE("/$api-keys/:id", auth.APIKeyAdminHandler, RevokeAPIKeyHandler(dal))
+
+	case auth.AuthTypeMutualTLS:
+		// Every request needs a client certificate with an identity, except those for the CapabilityStatement and
+		// those that are handled again without the tenant or database in their path.  They're authorized by the
+		// SMART system scopes of their identities, as for API keys, and those that manage tenants by the
+		// TenantAdminScope.
+		clientCertificateHandler := auth.ClientCertificateHandler(serverConfig.Auth, nil)
+		systemScopesHandler := auth.SMARTScopesHandler("*")
+		e.Use(func(c *gin.Context) {
+			path := c.Request.URL.Path
+			if path == "/metadata" || isRedispatched(path, serverConfig) {
+				return
+			}
+			if !restoreAsyncIdentity(c) {
+				clientCertificateHandler(c)
+			}
+			if !c.IsAborted() {
+				auth.SMARTBulkExportHandler(c)
+			}
+			isBulkExport := strings.HasPrefix(path, "/$export")
+			isAdmin := strings.HasPrefix(path, "/$tenants")
+			if !c.IsAborted() && !isBulkExport && !isAdmin && search.SearchParameters()[strings.Split(path, "/")[1]] == nil {
+				systemScopesHandler(c)
+			}
+		})
+
+	}
+
+	// The tenants that the clients that the auth middleware authenticated can access
+	if serverConfig.EnableTenancy && serverConfig.Auth.Method != auth.AuthTypeNone {
+		e.Use(tenantAuthorization(serverConfig))
+	}
+
+	// Rate limits of the clients that the auth middleware authenticated, and of tenants
+	if serverConfig.ClientRateLimit > 0 || serverConfig.TenantRateLimit > 0 {
+		var clients, tenants *rateLimiter
+		if serverConfig.ClientRateLimit > 0 {
+			clients = newRateLimiter(serverConfig.ClientRateLimit, serverConfig.ClientRateBurst)
+		}
+		if serverConfig.TenantRateLimit > 0 {
+			tenants = newRateLimiter(serverConfig.TenantRateLimit, serverConfig.TenantRateBurst)
+		}
+		e.Use(rateLimitMiddleware(clients, tenants, serverConfig))
+	}
+
+	// AuditEvents of every interaction, with the agents that the auth middleware authenticated
+	if serverConfig.EnableAudit {
+		source := serverConfig.ServerURL
+		if source == "" {
+			source = "FHIR server"
+		}
+		e.Use(newAuditor(dal, source).middleware)
+	}
+
+	// Patients' Consents, which are enforced with the actors that the auth middleware authenticated
+	if serverConfig.EnforceConsents {
+		source := serverConfig.ServerURL
+		if source == "" {
+			source = "FHIR server"
+		}
+		e.Use(consentContext)
+		dal = consentDataAccessLayer{dal, source}
+	}
+
+	// Data segmentation by the security labels of resources, with the clearances of the authenticated callers
+	if serverConfig.SecurityLabelPolicy != nil {
+		e.Use(securityLabelContext(serverConfig.SecurityLabelPolicy))
+		dal = securityLabelDataAccessLayer{dal}
+	}
+
+	// Centralized access policies, which decide each interaction of the requests' sessions
+	decider := serverConfig.AuthorizationDecider
+	if decider == nil && serverConfig.OPAURL != "" {
+		decider = NewOPAAuthorizationDecider(serverConfig.OPAURL)
+	}
+	if decider != nil {
+		e.Use(authorizationSubject)
+		dal = decidingDataAccessLayer{dal, decider}
+	}
+
+	// Asynchronous requests (Prefer: respond-async), which are queued before any of the routes below and run by the
+	// workers through the engine
+	if serverConfig.EnableAsync {
+		wake := make(chan struct{}, 1)
+		e.Use(AsyncRequestsMiddleware(dal, serverConfig, wake))
+		e.GET("/$async-status/:id", AsyncStatusHandler(dal))
+		e.DELETE("/$async-status/:id", AsyncDeleteHandler(dal))
+		StartAsyncWorkers(e, dal, serverConfig, wake)
 	}
 
 	// Custom MongoDB database support (e.g. http://fhir-server/db/customer123_fhir/Patient?name=alex)
@@ -121,6 +406,22 @@ func RegisterRoutes(e *gin.Engine, config map[string][]gin.HandlerFunc, dal Data
 		})
 	}
 
+	// Tenants (e.g. http://fhir-server/tenants/acme/Patient?name=alex), and their provisioning by admins: those with
+	// admin API keys, or else with the TenantAdminScope
+	if serverConfig.EnableTenancy {
+		tenantRoute := tenantRouteHandler(e, serverConfig)
+		e.POST("/tenants/:tenant", tenantRoute)
+		e.Any("/tenants/:tenant/*rest", tenantRoute)
+		admin := auth.TenantAdminHandler
+		if serverConfig.Auth.Method == auth.AuthTypeNone {
+			admin = func(c *gin.Context) {}
+		}
+		e.GET("/$tenants", admin, TenantsHandler(dal))
+		e.POST("/$tenants", admin, ProvisionTenantHandler(dal, serverConfig))
+		e.GET("/$tenants/:id", admin, TenantHandler(dal))
+		e.DELETE("/$tenants/:id", admin, DeprovisionTenantHandler(dal))
+	}
+
 	// Batch Support
 	batch := NewBatchController(dal, serverConfig)
 	batchHandlers := make([]gin.HandlerFunc, len(config["Batch"]))
@@ -128,13 +429,62 @@ func RegisterRoutes(e *gin.Engine, config map[string][]gin.HandlerFunc, dal Data
 	batchHandlers = append(batchHandlers, batch.Post)
 	e.POST("/", batchHandlers...)
 
-	// Conformance Statement
-	e.StaticFile("metadata", "conformance/capability_statement.json")
+	// Search explanations for diagnosing slow searches
+	if serverConfig.EnableExplain {
+		e.GET("/$explain", ExplainHandler(dal))
+	}
+
+	// Reports of the references of resources to others that don't exist
+	if serverConfig.checksReferences() {
+		e.GET("/$dangling-references", DanglingReferencesHandler(dal))
+	}
 
-	// Redirect server root to /metadata
+	// Bulk data exports, whose status is checked (and whose files are downloaded, when stored locally) separately
+	if serverConfig.EnableBulkExport {
+		e.GET("/$export", BulkExportHandler(dal, serverConfig, ""))
+		e.GET("/$export-status/:job", BulkExportStatusHandler(serverConfig))
+		e.DELETE("/$export-status/:job", BulkExportDeleteHandler)
+		e.GET("/$export-file/:job/:file", BulkExportFileHandler)
+	}
+
+	// Bulk imports of NDJSON files, whose status is checked separately
+	if serverConfig.EnableBulkImport {
+		e.POST("/$import", BulkImportHandler(dal, serverConfig))
+		e.GET("/$import-status/:job", BulkImportStatusHandler)
+		e.DELETE("/$import-status/:job", BulkImportDeleteHandler)
+	}
+
+	// The connections of clients of websocket Subscriptions
+	if serverConfig.EnableSubscriptions {
+		e.GET("/websocket", SubscriptionWebSocketHandler(dal, serverConfig))
+	}
+
+	// GraphQL queries of all resource types
+	e.GET("/$graphql", GraphQLHandler(dal, serverConfig, ""))
+	e.POST("/$graphql", GraphQLHandler(dal, serverConfig, ""))
+
+	// Compartment searches of all resource types
+	e.NoRoute(compartmentSearchAllHandler(e))
+
+	// Capability Statement and the supported versions of FHIR
+	e.GET("/metadata", CapabilityStatementHandler(e, serverConfig))
+	e.GET("/$versions", VersionsHandler)
+
+	// Searches of all resource types, otherwise redirect server root to /metadata
+	systemSearch := SystemSearchHandler(dal, serverConfig)
 	e.GET("/", func(c *gin.Context) {
+		if c.Request.URL.RawQuery != "" {
+			systemSearch(c)
+			return
+		}
 		c.Redirect(http.StatusPermanentRedirect, "/metadata")
 	})
+	e.POST("/_search", systemSearch)
+
+	// History of all resource types
+	if serverConfig.EnableHistory {
+		e.GET("/_history", SystemHistoryHandler(dal, serverConfig))
+	}
 
 	// Resources
 	RegisterController("Account", e, config["Account"], dal, serverConfig)
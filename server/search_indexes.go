@@ -0,0 +1,155 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/eug48/fhir/models"
+	"github.com/eug48/fhir/search"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxSearchIndexesPerCollection limits how many search indexes are created on each collection.
+// MongoDB allows 64 indexes per collection, so this leaves room for those in indexes.conf.
+const maxSearchIndexesPerCollection = 48
+
+// searchIndexRule includes or excludes the search indexes of a resource's search parameter,
+// where "*" matches any resource or parameter
+type searchIndexRule struct {
+	include  bool
+	resource string
+	param    string
+}
+
+// searchIndexRules are the rules in a search_indexes.conf file.  Indexes are included
+// unless excluded and the last rule that matches an index wins.
+type searchIndexRules []searchIndexRule
+
+// includes checks if the rules include the search indexes of a resource's parameter
+func (rules searchIndexRules) includes(resource, param string) bool {
+	included := true
+	for _, rule := range rules {
+		if (rule.resource == "*" || rule.resource == resource) && (rule.param == "*" || rule.param == param) {
+			included = rule.include
+		}
+	}
+	return included
+}
+
+// parseSearchIndexRule parses a line of a search_indexes.conf file, of the format:
+// include|exclude <resource>.<param>
+func parseSearchIndexRule(line string) (rule searchIndexRule, err error) {
+	fields := strings.Fields(line)
+	if len(fields) != 2 {
+		return rule, newParseSearchIndexRuleError(line, "Not of format include|exclude <resource>.<param>")
+	}
+
+	switch fields[0] {
+	case "include":
+		rule.include = true
+	case "exclude":
+		rule.include = false
+	default:
+		return rule, newParseSearchIndexRuleError(line, "Must start with include or exclude")
+	}
+
+	target := strings.SplitN(fields[1], ".", 2)
+	if len(target) < 2 || target[0] == "" || target[1] == "" {
+		return rule, newParseSearchIndexRuleError(line, "Not of format include|exclude <resource>.<param>")
+	}
+	rule.resource = target[0]
+	rule.param = target[1]
+
+	if rule.resource != "*" && search.SearchParameters()[rule.resource] == nil {
+		return rule, newParseSearchIndexRuleError(line, "Unknown resource "+rule.resource)
+	}
+	return rule, nil
+}
+
+func newParseSearchIndexRuleError(line, reason string) error {
+	return fmt.Errorf("Search index rule '%s' is invalid: %s", line, reason)
+}
+
+// loadSearchIndexRules reads the rules in a search_indexes.conf file
+func loadSearchIndexRules(path string) (searchIndexRules, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules searchIndexRules
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		// Skip blank lines or lines with bash-style comments
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule, err := parseSearchIndexRule(line)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// searchIndexModels returns the indexes to create on a resource's collection for searches
+// using its parameters, as included by the rules and limited to maxSearchIndexesPerCollection
+func (i *Indexer) searchIndexModels(resource string, rules searchIndexRules) []mongo.IndexModel {
+	collectionName := models.PluralizeLowerResourceName(resource)
+
+	var indexes []mongo.IndexModel
+	for _, searchIndex := range search.RecommendedSearchIndexes(resource, i.lowercaseStrings, i.lowercaseTokens) {
+		if !rules.includes(resource, searchIndex.Param) {
+			continue
+		}
+		if len(indexes) >= maxSearchIndexesPerCollection {
+			i.log(fmt.Sprintf("[WARNING] Skipping search index %s.%s: %s (%s) as there are already %d", i.dbName, collectionName, searchIndex.KeysString(), searchIndex.Param, maxSearchIndexesPerCollection))
+			continue
+		}
+
+		backgroundIndex := true
+		indexes = append(indexes, mongo.IndexModel{
+			Keys:    searchIndex.Keys,
+			Options: &options.IndexOptions{Background: &backgroundIndex, Collation: i.collation},
+		})
+	}
+	return indexes
+}
+
+// ensureSearchIndexes creates the indexes recommended for searching each resource using its token,
+// date and reference search parameters (see search.RecommendedSearchIndexes), except those excluded
+// by the search_indexes.conf file
+func (i *Indexer) ensureSearchIndexes(db *mongo.Database) {
+	rules, err := loadSearchIndexRules(i.searchIdxPath)
+	if os.IsNotExist(err) {
+		i.log("[WARNING] Could not find search indexes configuration file, creating all search indexes")
+	} else if err != nil {
+		i.log(fmt.Sprintf("[ERROR] %s\n", err.Error()))
+		panic(err)
+	}
+
+	for resource := range search.SearchParameters() {
+		collectionName := models.PluralizeLowerResourceName(resource)
+		collection := db.Collection(collectionName)
+
+		// Indexes are created one at a time so that one failure (e.g. due to an existing index
+		// with the same name but different options) doesn't prevent the others being created
+		for _, index := range i.searchIndexModels(resource, rules) {
+			i.log(fmt.Sprintf("Ensuring index: %s.%s: %s", i.dbName, collectionName, sprintIndexKeys(&index)))
+
+			_, err := collection.Indexes().CreateOne(context.Background(), index)
+			if err != nil {
+				i.log(fmt.Sprintf("[WARNING] Could not ensure search index for: %s.%s: %s\n", i.dbName, collectionName, err.Error()))
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"os"
+)
+
+func (s *MongoIndexesTestSuite) TestParseSearchIndexRule() {
+	rule, err := parseSearchIndexRule("exclude Patient._tag")
+	s.Nil(err, "Should return without error")
+	s.Equal(searchIndexRule{include: false, resource: "Patient", param: "_tag"}, rule)
+
+	rule, err = parseSearchIndexRule("include  *.*")
+	s.Nil(err, "Should return without error")
+	s.Equal(searchIndexRule{include: true, resource: "*", param: "*"}, rule)
+}
+
+func (s *MongoIndexesTestSuite) TestParseSearchIndexRuleErrors() {
+	_, err := parseSearchIndexRule("Patient._tag")
+	s.Equal("Search index rule 'Patient._tag' is invalid: Not of format include|exclude <resource>.<param>", err.Error())
+
+	_, err = parseSearchIndexRule("ignore Patient._tag")
+	s.Equal("Search index rule 'ignore Patient._tag' is invalid: Must start with include or exclude", err.Error())
+
+	_, err = parseSearchIndexRule("exclude Patient")
+	s.Equal("Search index rule 'exclude Patient' is invalid: Not of format include|exclude <resource>.<param>", err.Error())
+
+	_, err = parseSearchIndexRule("exclude Foo.bar")
+	s.Equal("Search index rule 'exclude Foo.bar' is invalid: Unknown resource Foo", err.Error())
+}
+
+func (s *MongoIndexesTestSuite) TestSearchIndexRulesLastMatchWins() {
+	rules := searchIndexRules{
+		{include: false, resource: "*", param: "_tag"},
+		{include: false, resource: "AuditEvent", param: "*"},
+		{include: true, resource: "AuditEvent", param: "date"},
+	}
+
+	s.True(rules.includes("Patient", "gender"), "Indexes are included by default")
+	s.False(rules.includes("Patient", "_tag"))
+	s.False(rules.includes("AuditEvent", "agent"))
+	s.True(rules.includes("AuditEvent", "date"))
+}
+
+func (s *MongoIndexesTestSuite) TestConfigureSearchIndexes() {
+	config := s.Config
+	config.IndexConfigPath = "./does_not_exist.conf"
+	config.CreateSearchIndexes = true
+	config.SearchIndexConfigPath = "../fixtures/test_search_indexes.conf"
+	NewIndexer("fhir", config).ConfigureIndexes(s.client.Database("fhir"))
+
+	indexes, err := s.initialSession.DB("fhir").C("patients").Indexes()
+	s.Nil(err)
+	keys := make(map[string]bool)
+	for _, index := range indexes {
+		keys[index.Name] = true
+	}
+	s.True(keys["gender_1"], "Patients should have an index for gender")
+	s.True(keys["birthDate.__from_1_birthDate.__to_1"], "Patients should have an index for birthdate")
+	s.True(keys["generalPractitioner.reference__id_1_generalPractitioner.reference__type_1"], "Patients should have an index for general-practitioner")
+	s.False(keys["meta.tag.code_1_meta.tag.system_1"], "Patients' _tag index should be excluded")
+
+	indexes, err = s.initialSession.DB("fhir").C("conditions").Indexes()
+	s.Nil(err)
+	for _, index := range indexes {
+		s.Contains([]string{"_id_", "meta.lastUpdated_1", "$**_text"}, index.Name, "Conditions should only have their default indexes")
+	}
+}
+
+func (s *MongoIndexesTestSuite) TestLoadSearchIndexRules() {
+	rules, err := loadSearchIndexRules("../fixtures/test_search_indexes.conf")
+	s.Nil(err, "Should return without error")
+	s.Len(rules, 3, "Comments should be skipped")
+	s.True(rules.includes("Patient", "gender"))
+	s.False(rules.includes("Patient", "_tag"))
+	s.False(rules.includes("Condition", "code"))
+
+	_, err = loadSearchIndexRules("./does_not_exist.conf")
+	s.True(os.IsNotExist(err), "A missing config file should be reported as not existing")
+}
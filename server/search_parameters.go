@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+
+	"github.com/eug48/fhir/models"
+	"github.com/eug48/fhir/models2"
+	"github.com/eug48/fhir/search"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// searchParameterRegistrar is an interceptor that registers SearchParameter resources with the search
+// layer as they are created or updated, so custom search parameters can be used without restarting.
+// Deleted SearchParameters remain registered until the server is restarted.
+type searchParameterRegistrar struct{}
+
+func (s *searchParameterRegistrar) Before(resource interface{}) {}
+
+func (s *searchParameterRegistrar) After(resource interface{}) {
+	res, ok := resource.(*models2.Resource)
+	if !ok {
+		return
+	}
+	var sp models.SearchParameter
+	err := res.Unmarshal(&sp)
+	if err == nil {
+		err = registerSearchParameter(&sp)
+	}
+	if err != nil {
+		log.Printf("SearchParameters: not registering SearchParameter/%s: %s\n", res.Id(), err)
+	}
+}
+
+func (s *searchParameterRegistrar) OnError(err error, resource interface{}) {}
+
+// registerSearchParameter registers a SearchParameter resource with the search layer, unless it has been retired
+func registerSearchParameter(sp *models.SearchParameter) error {
+	if sp.Status == "retired" {
+		return nil
+	}
+	err := search.RegisterSearchParameter(sp)
+	if err == nil {
+		log.Printf("SearchParameters: registered %s for %v\n", sp.Code, sp.Base)
+	}
+	return err
+}
+
+// bundleOfSearchParameters is the part of a SearchParameter file needed to find its SearchParameters
+type bundleOfSearchParameters struct {
+	ResourceType string `json:"resourceType"`
+	Entry        []struct {
+		Resource json.RawMessage `json:"resource"`
+	} `json:"entry"`
+}
+
+// LoadSearchParametersFromDir registers the SearchParameter resources in the JSON files of a directory.
+// Each file may contain a single SearchParameter or a Bundle of them.
+func LoadSearchParametersFromDir(dir string) error {
+	fileNames, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return errors.Wrapf(err, "listing %s", dir)
+	}
+
+	for _, fileName := range fileNames {
+		data, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			return errors.Wrapf(err, "reading %s", fileName)
+		}
+
+		var resource bundleOfSearchParameters
+		err = json.Unmarshal(data, &resource)
+		if err != nil {
+			return errors.Wrapf(err, "parsing %s", fileName)
+		}
+
+		var spJSONs []json.RawMessage
+		switch resource.ResourceType {
+		case "SearchParameter":
+			spJSONs = append(spJSONs, data)
+		case "Bundle":
+			for _, entry := range resource.Entry {
+				var entryResource bundleOfSearchParameters
+				err = json.Unmarshal(entry.Resource, &entryResource)
+				if err != nil {
+					return errors.Wrapf(err, "parsing %s", fileName)
+				}
+				if entryResource.ResourceType == "SearchParameter" {
+					spJSONs = append(spJSONs, entry.Resource)
+				}
+			}
+		default:
+			return errors.Errorf("%s: expected a SearchParameter or a Bundle but got a %s", fileName, resource.ResourceType)
+		}
+
+		for _, spJSON := range spJSONs {
+			var sp models.SearchParameter
+			err = json.Unmarshal(spJSON, &sp)
+			if err != nil {
+				return errors.Wrapf(err, "parsing a SearchParameter in %s", fileName)
+			}
+			err = registerSearchParameter(&sp)
+			if err != nil {
+				return errors.Wrapf(err, "registering a SearchParameter in %s", fileName)
+			}
+		}
+	}
+	return nil
+}
+
+// loadStoredSearchParameters registers the SearchParameter resources that have been stored in a database,
+// logging those that can't be used
+func loadStoredSearchParameters(db *mongo.Database) error {
+	collection := db.Collection(models.PluralizeLowerResourceName("SearchParameter"))
+	cursor, err := collection.Find(context.Background(), bson.D{})
+	if err != nil {
+		return errors.Wrap(err, "loadStoredSearchParameters: Find failed")
+	}
+	defer cursor.Close(context.Background())
+
+	for cursor.Next(context.Background()) {
+		var doc bson.D
+		err = cursor.Decode(&doc)
+		if err != nil {
+			return errors.Wrap(err, "loadStoredSearchParameters: Decode failed")
+		}
+		resource, err := models2.NewResourceFromBSON(doc)
+		if err != nil {
+			return errors.Wrap(err, "loadStoredSearchParameters: NewResourceFromBSON failed")
+		}
+		var sp models.SearchParameter
+		err = resource.Unmarshal(&sp)
+		if err == nil {
+			err = registerSearchParameter(&sp)
+		}
+		if err != nil {
+			log.Printf("SearchParameters: not registering SearchParameter/%s: %s\n", resource.Id(), err)
+		}
+	}
+	return cursor.Err()
+}
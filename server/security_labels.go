@@ -0,0 +1,387 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/eug48/fhir/models"
+	"github.com/eug48/fhir/models2"
+	"github.com/eug48/fhir/search"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// Security label based data segmentation (see Config.SecurityLabelPolicy): the resources whose meta.security labels
+// the caller isn't cleared for are filtered from search results, histories and change feeds (and the ids and
+// references found by searches), and reads of them are forbidden, including by conditional creates that match them.
+// Searches can't be explained, as MongoDB's explain() output counts all the resources.
+// Clearances allow a confidentiality code (http://hl7.org/fhir/v3/Confidentiality, from U to V), and so the codes
+// below it, and sensitivity labels (http://hl7.org/fhir/v3/ActCode, e.g. ETH or PSY).  Other labels (e.g. handling
+// caveats) aren't restricted.  The labels of returned bundles include the highest confidentiality code of their
+// resources (the high-water mark).
+
+const (
+	ConfidentialitySystem = "http://hl7.org/fhir/v3/Confidentiality"
+	ActCodeSystem         = "http://hl7.org/fhir/v3/ActCode"
+)
+
+// The confidentiality codes, from the least to the most restricted
+var confidentialityCodes = []string{"U", "L", "M", "N", "R", "V"}
+
+func confidentialityLevel(code string) int {
+	for level, c := range confidentialityCodes {
+		if c == code {
+			return level
+		}
+	}
+	return -1
+}
+
+// SecurityLabelPolicy configures which security labels callers are cleared for: those of the default clearance, and
+// those of each clearance that applies to them
+type SecurityLabelPolicy struct {
+	Default    SecurityLabelClearance   `json:"default"`
+	Clearances []SecurityLabelClearance `json:"clearances"`
+}
+
+// SecurityLabelClearance is the security labels that callers with any of its client IDs, users (their fhirUser or
+// subject) or scopes are cleared for
+type SecurityLabelClearance struct {
+	ClientIDs []string `json:"clientIds,omitempty"`
+	Users     []string `json:"users,omitempty"`
+	Scopes    []string `json:"scopes,omitempty"`
+
+	// Confidentiality is the highest confidentiality code allowed (N if it's empty)
+	Confidentiality string `json:"confidentiality,omitempty"`
+	// Labels are the sensitivity labels allowed, as codes or system|code tokens, or * for all of them
+	Labels []string `json:"labels,omitempty"`
+}
+
+// LoadSecurityLabelPolicy loads a SecurityLabelPolicy from a JSON file
+func LoadSecurityLabelPolicy(fileName string) (*SecurityLabelPolicy, error) {
+	policyJSON, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var policy SecurityLabelPolicy
+	if err := json.Unmarshal(policyJSON, &policy); err != nil {
+		return nil, errors.Wrap(err, "LoadSecurityLabelPolicy: failed to decode the policy")
+	}
+	for _, clearance := range append([]SecurityLabelClearance{policy.Default}, policy.Clearances...) {
+		if clearance.Confidentiality != "" && confidentialityLevel(clearance.Confidentiality) < 0 {
+			return nil, fmt.Errorf("LoadSecurityLabelPolicy: invalid confidentiality code %q", clearance.Confidentiality)
+		}
+	}
+	return &policy, nil
+}
+
+func (clearance *SecurityLabelClearance) appliesTo(clientID, user, subject string, scopes []string) bool {
+	return stringsInclude(clearance.ClientIDs, clientID) || stringsInclude(clearance.Users, user) ||
+		stringsInclude(clearance.Users, subject) || stringsIntersect(clearance.Scopes, scopes)
+}
+
+func stringsInclude(values []string, value string) bool {
+	return value != "" && stringsIntersect(values, []string{value})
+}
+
+func stringsIntersect(values, others []string) bool {
+	for _, value := range values {
+		for _, other := range others {
+			if value == other {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// securityClearance is what a caller is cleared for
+type securityClearance struct {
+	confidentiality int
+	allLabels       bool
+	labels          []models.Coding
+}
+
+func (clearance *securityClearance) add(c SecurityLabelClearance) {
+	confidentiality := c.Confidentiality
+	if confidentiality == "" {
+		confidentiality = "N"
+	}
+	if level := confidentialityLevel(confidentiality); level > clearance.confidentiality {
+		clearance.confidentiality = level
+	}
+	for _, label := range c.Labels {
+		if label == "*" {
+			clearance.allLabels = true
+		} else {
+			clearance.labels = append(clearance.labels, parseCoding(label))
+		}
+	}
+}
+
+// allows returns whether a clearance allows access to a resource with security labels
+func (clearance *securityClearance) allows(labels []models.Coding) bool {
+	for _, label := range labels {
+		switch label.System {
+		case ConfidentialitySystem:
+			if confidentialityLevel(label.Code) > clearance.confidentiality {
+				return false
+			}
+		case ActCodeSystem, "":
+			if !clearance.allLabels && !codingsIntersect(clearance.labels, []models.Coding{label}) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+type securityClearanceKey struct{}
+
+// securityLabelContext returns middleware that adds the clearance of the caller of a request (which the auth
+// middleware authenticated) to its context, so that its sessions filter resources by their security labels
+func securityLabelContext(policy *SecurityLabelPolicy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientID, user, subject := c.GetString("clientID"), c.GetString("fhirUser"), c.GetString("subject")
+		scopes := c.GetStringSlice("scopes")
+		clearance := &securityClearance{confidentiality: -1}
+		clearance.add(policy.Default)
+		for _, applicable := range policy.Clearances {
+			if applicable.appliesTo(clientID, user, subject, scopes) {
+				clearance.add(applicable)
+			}
+		}
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), securityClearanceKey{}, clearance))
+	}
+}
+
+// securityLabelDataAccessLayer starts securityLabelSessions for the requests that securityLabelContext added a
+// clearance to
+type securityLabelDataAccessLayer struct {
+	DataAccessLayer
+}
+
+func (dal securityLabelDataAccessLayer) StartSession(ctx context.Context, dbname string) DataAccessSession {
+	session := dal.DataAccessLayer.StartSession(ctx, dbname)
+	if clearance, ok := ctx.Value(securityClearanceKey{}).(*securityClearance); ok {
+		return &securityLabelSession{DataAccessSession: session, clearance: clearance}
+	}
+	return session
+}
+
+// securityLabelSession is a session that only returns the resources whose security labels a caller is cleared for
+type securityLabelSession struct {
+	DataAccessSession
+	clearance *securityClearance
+}
+
+func securityLabels(resource *models2.Resource) ([]models.Coding, error) {
+	var elements struct {
+		Meta struct {
+			Security []models.Coding `json:"security"`
+		} `json:"meta"`
+	}
+	if err := resource.Unmarshal(&elements); err != nil {
+		return nil, errors.Wrap(err, "securityLabels: Unmarshal failed")
+	}
+	return elements.Meta.Security, nil
+}
+
+// checkRead returns an ErrForbidden error unless the caller is cleared for a resource's labels
+func (s *securityLabelSession) checkRead(resource *models2.Resource) error {
+	labels, err := securityLabels(resource)
+	if err != nil {
+		return err
+	}
+	if !s.clearance.allows(labels) {
+		return ErrForbidden{fmt.Sprintf("%s/%s has security labels that the request isn't cleared for", resource.ResourceType(), resource.Id())}
+	}
+	return nil
+}
+
+// filterEntries removes the entries of a bundle with resources that the caller isn't cleared for, and labels the
+// bundle with the highest confidentiality code of the others
+func (s *securityLabelSession) filterEntries(bundle *models2.ShallowBundle) error {
+	entries := bundle.Entry[:0]
+	highWaterMark := -1
+	for _, entry := range bundle.Entry {
+		if entry.Resource != nil {
+			labels, err := securityLabels(entry.Resource)
+			if err != nil {
+				return err
+			}
+			if !s.clearance.allows(labels) {
+				continue
+			}
+			for _, label := range labels {
+				if level := confidentialityLevel(label.Code); label.System == ConfidentialitySystem && level > highWaterMark {
+					highWaterMark = level
+				}
+			}
+		}
+		entries = append(entries, entry)
+	}
+	bundle.Entry = entries
+	if highWaterMark >= 0 {
+		if bundle.Meta == nil {
+			bundle.Meta = &models.Meta{}
+		}
+		bundle.Meta.Security = append(bundle.Meta.Security, models.Coding{
+			System: ConfidentialitySystem,
+			Code:   confidentialityCodes[highWaterMark],
+		})
+	}
+	return nil
+}
+
+func (s *securityLabelSession) Get(id, resourceType string) (*models2.Resource, error) {
+	resource, err := s.DataAccessSession.Get(id, resourceType)
+	if err != nil {
+		return resource, err
+	}
+	if err := s.checkRead(resource); err != nil {
+		return nil, err
+	}
+	return resource, nil
+}
+
+func (s *securityLabelSession) GetVersion(id, versionId, resourceType string) (*models2.Resource, error) {
+	resource, err := s.DataAccessSession.GetVersion(id, versionId, resourceType)
+	if err != nil {
+		return resource, err
+	}
+	if err := s.checkRead(resource); err != nil {
+		return nil, err
+	}
+	return resource, nil
+}
+
+func (s *securityLabelSession) Search(baseURL url.URL, searchQuery search.Query) (*models2.ShallowBundle, error) {
+	bundle, err := s.DataAccessSession.Search(baseURL, searchQuery)
+	if err != nil {
+		return bundle, err
+	}
+	return bundle, s.filterEntries(bundle)
+}
+
+// ConditionalPost returns an ErrForbidden error instead of an existing resource that the query matched if the caller
+// isn't cleared for it
+func (s *securityLabelSession) ConditionalPost(query search.Query, resource *models2.Resource) (int, string, *models2.Resource, error) {
+	httpStatus, id, outputResource, err := s.DataAccessSession.ConditionalPost(query, resource)
+	if err != nil || httpStatus == http.StatusCreated || outputResource == nil {
+		return httpStatus, id, outputResource, err
+	}
+	if err := s.checkRead(outputResource); err != nil {
+		return 0, "", nil, err
+	}
+	return httpStatus, id, outputResource, nil
+}
+
+func (s *securityLabelSession) FindIDs(searchQuery search.Query) ([]string, error) {
+	ids, err := s.DataAccessSession.FindIDs(searchQuery)
+	if err != nil || searchQuery.Resource == "" {
+		return ids, err
+	}
+	cleared := ids[:0]
+	for _, id := range ids {
+		if ok, err := s.clearedFor(searchQuery.Resource, id); err != nil {
+			return nil, err
+		} else if ok {
+			cleared = append(cleared, id)
+		}
+	}
+	return cleared, nil
+}
+
+func (s *securityLabelSession) Explain(searchQuery search.Query) (*search.Explanation, error) {
+	return nil, ErrForbidden{"Searches can't be explained with security labels, as the explanations count all resources"}
+}
+
+func (s *securityLabelSession) ReferencesTo(resourceType string, ids []string, max int) ([]ResourceReference, error) {
+	references, err := s.DataAccessSession.ReferencesTo(resourceType, ids, max)
+	if err != nil {
+		return nil, err
+	}
+	return s.filterReferences(references)
+}
+
+func (s *securityLabelSession) DanglingReferences(resourceTypes []string, max int) ([]ResourceReference, error) {
+	references, err := s.DataAccessSession.DanglingReferences(resourceTypes, max)
+	if err != nil {
+		return nil, err
+	}
+	return s.filterReferences(references)
+}
+
+// filterReferences removes the references of resources that the caller isn't cleared for
+func (s *securityLabelSession) filterReferences(references []ResourceReference) ([]ResourceReference, error) {
+	cleared := references[:0]
+	for _, reference := range references {
+		parts := strings.SplitN(reference.Source, "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if ok, err := s.clearedFor(parts[0], parts[1]); err != nil {
+			return nil, err
+		} else if ok {
+			cleared = append(cleared, reference)
+		}
+	}
+	return cleared, nil
+}
+
+// clearedFor returns whether the caller is cleared for a resource, which it is if the resource doesn't exist
+func (s *securityLabelSession) clearedFor(resourceType, id string) (bool, error) {
+	resource, err := s.DataAccessSession.Get(id, resourceType)
+	if err == ErrNotFound || err == ErrDeleted {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	labels, err := securityLabels(resource)
+	if err != nil {
+		return false, err
+	}
+	return s.clearance.allows(labels), nil
+}
+
+func (s *securityLabelSession) History(baseURL url.URL, resourceType string, id string, options HistoryOptions) (*models2.ShallowBundle, error) {
+	bundle, err := s.DataAccessSession.History(baseURL, resourceType, id, options)
+	if err != nil {
+		return bundle, err
+	}
+	return bundle, s.filterEntries(bundle)
+}
+
+// WatchChanges returns a feed of the changes to the resources that the caller is cleared for.  Deletions are returned
+// without their resources, as they are in histories.
+func (s *securityLabelSession) WatchChanges(resourceType string, resumeToken string) (*ChangeFeed, error) {
+	feed, err := s.DataAccessSession.WatchChanges(resourceType, resumeToken)
+	if err != nil {
+		return feed, err
+	}
+	feed.filter = s.allowsChange
+	return feed, nil
+}
+
+// allowsChange returns whether the caller is cleared for the resource of a change, which it is for changes without
+// resources (i.e. deletions)
+func (s *securityLabelSession) allowsChange(change *ChangeEvent) (bool, error) {
+	if len(change.Resource) == 0 {
+		return true, nil
+	}
+	resource, err := models2.NewResourceFromJsonBytes(change.Resource)
+	if err != nil {
+		return false, errors.Wrap(err, "allowsChange: NewResourceFromJsonBytes failed")
+	}
+	labels, err := securityLabels(resource)
+	if err != nil {
+		return false, err
+	}
+	return s.clearance.allows(labels), nil
+}
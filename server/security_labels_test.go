@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+
+	"github.com/eug48/fhir/models"
+	"github.com/eug48/fhir/models2"
+	"github.com/eug48/fhir/search"
+	"github.com/gin-gonic/gin"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+func (s *ServerSuite) TestSecurityLabelPolicy(c *C) {
+	file, err := ioutil.TempFile("", "security-labels")
+	util.CheckErr(err)
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(`{
+		"default": {"confidentiality": "N"},
+		"clearances": [
+			{"scopes": ["user/*.*"], "confidentiality": "R", "labels": ["PSY"]},
+			{"clientIds": ["ethics-board"], "labels": ["http://hl7.org/fhir/v3/ActCode|ETH"]}
+		]
+	}`)
+	util.CheckErr(err)
+	file.Close()
+	policy, err := LoadSecurityLabelPolicy(file.Name())
+	util.CheckErr(err)
+
+	clearance := func(clientID string, scopes ...string) *securityClearance {
+		ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+		ctx.Request = httptest.NewRequest("GET", "/Observation", nil)
+		ctx.Set("clientID", clientID)
+		ctx.Set("scopes", scopes)
+		securityLabelContext(policy)(ctx)
+		return ctx.Request.Context().Value(securityClearanceKey{}).(*securityClearance)
+	}
+	restricted := []models.Coding{{System: ConfidentialitySystem, Code: "R"}}
+	psychiatry := []models.Coding{{System: ActCodeSystem, Code: "PSY"}, {System: ConfidentialitySystem, Code: "N"}}
+	ethics := []models.Coding{{System: ActCodeSystem, Code: "ETH"}}
+
+	c.Assert(clearance("app").allows(nil), Equals, true)
+	c.Assert(clearance("app").allows(restricted), Equals, false)
+	c.Assert(clearance("app").allows(psychiatry), Equals, false)
+	c.Assert(clearance("app", "user/*.*").allows(restricted), Equals, true)
+	c.Assert(clearance("app", "user/*.*").allows(psychiatry), Equals, true)
+	c.Assert(clearance("app", "user/*.*").allows(ethics), Equals, false)
+	c.Assert(clearance("ethics-board").allows(ethics), Equals, true)
+	c.Assert(clearance("ethics-board").allows(restricted), Equals, false)
+}
+
+func (s *ServerSuite) TestSecurityLabelSessions(c *C) {
+	dal := securityLabelDataAccessLayer{NewMongoDataAccessLayer(s.client, s.dbname, true, "_fhir", nil, DefaultConfig)}
+	resource := func(json string) *models2.Resource {
+		resource, err := models2.NewResourceFromJsonBytes([]byte(json))
+		util.CheckErr(err)
+		return resource
+	}
+	defer s.DB().C("observations").DropCollection()
+
+	session := dal.StartSession(context.TODO(), s.dbname)
+	defer session.Finish()
+	c.Assert(session.PostWithID("unlabelled", resource(`{"resourceType": "Observation", "status": "final",
+		"code": {"text": "weight"}}`)), IsNil)
+	c.Assert(session.PostWithID("moderate", resource(`{"resourceType": "Observation", "status": "final",
+		"meta": {"security": [{"system": "http://hl7.org/fhir/v3/Confidentiality", "code": "M"}]},
+		"code": {"text": "height"}}`)), IsNil)
+	c.Assert(session.PostWithID("restricted", resource(`{"resourceType": "Observation", "status": "final",
+		"meta": {"security": [{"system": "http://hl7.org/fhir/v3/Confidentiality", "code": "R"}]},
+		"code": {"text": "HIV test"}}`)), IsNil)
+
+	normal := &securityClearance{confidentiality: confidentialityLevel("N")}
+	cleared := dal.StartSession(context.WithValue(context.TODO(), securityClearanceKey{}, normal), s.dbname)
+	defer cleared.Finish()
+
+	// Searches only return the resources that the caller is cleared for, with the high-water mark of their labels
+	bundle, err := cleared.Search(url.URL{}, search.Query{Resource: "Observation", Query: "_sort=_id"})
+	util.CheckErr(err)
+	c.Assert(bundle.Entry, HasLen, 2)
+	c.Assert(bundle.Entry[0].Resource.Id(), Equals, "moderate")
+	c.Assert(bundle.Entry[1].Resource.Id(), Equals, "unlabelled")
+	c.Assert(bundle.Meta.Security, DeepEquals, []models.Coding{{System: ConfidentialitySystem, Code: "M"}})
+
+	// and reads of the others are forbidden
+	_, err = cleared.Get("moderate", "Observation")
+	c.Assert(err, IsNil)
+	_, err = cleared.Get("restricted", "Observation")
+	c.Assert(err, FitsTypeOf, ErrForbidden{})
+
+	// including by conditional creates that match them
+	status, id, _, err := cleared.ConditionalPost(search.Query{Resource: "Observation", Query: "code:text=height"},
+		resource(`{"resourceType": "Observation", "status": "final", "code": {"text": "height"}}`))
+	util.CheckErr(err)
+	c.Assert(status, Equals, http.StatusOK)
+	c.Assert(id, Equals, "moderate")
+	_, _, _, err = cleared.ConditionalPost(search.Query{Resource: "Observation", Query: "code:text=HIV"},
+		resource(`{"resourceType": "Observation", "status": "final", "code": {"text": "HIV test"}}`))
+	c.Assert(err, FitsTypeOf, ErrForbidden{})
+
+	// Nor are their ids found
+	ids, err := cleared.FindIDs(search.Query{Resource: "Observation", Query: "_sort=_id"})
+	util.CheckErr(err)
+	c.Assert(ids, DeepEquals, []string{"moderate", "unlabelled"})
+
+	// Change feeds only return the changes of the resources that the caller is cleared for, and deletions
+	allowsChange := cleared.(*securityLabelSession).allowsChange
+	allowed, err := allowsChange(&ChangeEvent{Action: ChangeEventUpdate, Resource: []byte(`{"resourceType": "Observation",
+		"id": "moderate", "meta": {"security": [{"system": "http://hl7.org/fhir/v3/Confidentiality", "code": "M"}]}}`)})
+	util.CheckErr(err)
+	c.Assert(allowed, Equals, true)
+	allowed, err = allowsChange(&ChangeEvent{Action: ChangeEventUpdate, Resource: []byte(`{"resourceType": "Observation",
+		"id": "restricted", "meta": {"security": [{"system": "http://hl7.org/fhir/v3/Confidentiality", "code": "R"}]}}`)})
+	util.CheckErr(err)
+	c.Assert(allowed, Equals, false)
+	allowed, err = allowsChange(&ChangeEvent{Action: ChangeEventDelete, ResourceId: "restricted"})
+	util.CheckErr(err)
+	c.Assert(allowed, Equals, true)
+}
@@ -4,17 +4,21 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/eug48/fhir/auth"
 	"github.com/eug48/fhir/models2"
+	"github.com/eug48/fhir/search"
+	"github.com/eug48/fhir/utils"
 	"github.com/gin-gonic/gin"
 	cors "github.com/itsjamie/gin-cors"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-
-	mongowrapper "github.com/opencensus-integrations/gomongowrapper"
 )
 
 type AfterRoutes func(*gin.Engine)
@@ -56,6 +60,18 @@ func NewServer(config Config) *FHIRServer {
 	}
 	server.Engine = gin.Default()
 
+	if config.DefaultTimezone != "" {
+		loc, err := time.LoadLocation(config.DefaultTimezone)
+		if err != nil {
+			panic(errors.Wrap(err, "loading the default timezone"))
+		}
+		utils.SetDefaultLocation(loc)
+	}
+
+	// Custom search parameters are registered as they are created or updated
+	server.AddInterceptor("Create", "SearchParameter", &searchParameterRegistrar{})
+	server.AddInterceptor("Update", "SearchParameter", &searchParameterRegistrar{})
+
 	if config.Debug {
 		gin.SetMode(gin.DebugMode)
 	} else {
@@ -65,14 +81,16 @@ func NewServer(config Config) *FHIRServer {
 
 	server.Engine.Use(cors.Middleware(cors.Config{
 		Origins:         "*",
-		Methods:         "GET, PUT, POST, DELETE",
-		RequestHeaders:  "Origin, Authorization, Content-Type, If-Match, If-None-Exist",
-		ExposedHeaders:  "Location, ETag, Last-Modified",
+		Methods:         "GET, PUT, PATCH, POST, DELETE",
+		RequestHeaders:  "Origin, Authorization, Content-Type, If-Match, If-None-Exist, Prefer",
+		ExposedHeaders:  "Location, Content-Location, ETag, Last-Modified, Retry-After, X-Progress",
 		MaxAge:          86400 * time.Second, // Preflight expires after 1 day
 		Credentials:     true,
 		ValidateHeaders: false,
 	}))
 
+	server.Engine.Use(AbortUnsupportedFhirVersionRequestsMiddleware)
+
 	if config.EnableXML {
 		server.Engine.Use(EnableXmlToJsonConversionMiddleware())
 		server.Engine.Use(AbortNonFhirXMLorJSONRequestsMiddleware)
@@ -90,14 +108,8 @@ func NewServer(config Config) *FHIRServer {
 func (f *FHIRServer) InitEngine() {
 	var err error
 
-	// TODO: Register OpenCensus metrics
-	// TODO: StackDriver currently throwing up errors like  InvalidArgument desc = Field timeSeries[0].points[0].distributionValue had an invalid value: Distribution value has 34 |bucket_counts| fields, which is more than the 33 buckets allowed by the bucketing options.
-	// if err := mongowrapper.RegisterAllViews(); err != nil {
-	// log.Fatalf("Failed to register all OpenCensus views: %v\n", err)
-	// }
-
 	// Establish initial connection to mongo
-	client, err := mongowrapper.Connect(context.Background(), options.Client().ApplyURI(f.Config.DatabaseURI))
+	client, err := mongo.Connect(context.Background(), utils.MongoClientOptions(f.Config.DatabaseURI))
 	if err != nil {
 		panic(errors.Wrap(err, "connecting to MongoDB"))
 	}
@@ -128,9 +140,30 @@ func (f *FHIRServer) InitEngine() {
 
 	// Pre-create collections for transactions
 	db := client.Database(f.Config.DefaultDatabaseName)
-	CreateCollections(db)
+	CreateCollectionsWithCollation(db, f.Config.collation())
 
-	// Ensure all indexes
+	// Register custom search parameters, first those from files and then those stored in the database
+	if f.Config.SearchParametersDir != "" {
+		err = LoadSearchParametersFromDir(f.Config.SearchParametersDir)
+		if err != nil {
+			panic(errors.Wrap(err, "loading search parameters"))
+		}
+	}
+	err = loadStoredSearchParameters(db)
+	if err != nil {
+		panic(errors.Wrap(err, "loading stored search parameters"))
+	}
+
+	// Load the profiles of packages (e.g. US Core) to validate resources against
+	for _, fileName := range f.Config.ProfilePackages {
+		name, err := models2.LoadPackage(fileName)
+		if err != nil {
+			panic(errors.Wrapf(err, "loading package %s", fileName))
+		}
+		log.Printf("Profiles: loaded package %s from %s\n", name, fileName)
+	}
+
+	// Ensure all indexes (after registering custom search parameters, so they get search indexes too)
 	if f.Config.CreateIndexes {
 		NewIndexer(f.Config.DefaultDatabaseName, f.Config).ConfigureIndexes(db)
 	}
@@ -187,14 +220,14 @@ func (f *FHIRServer) Run(port int, localhostOnly bool) {
 
 func (f *FHIRServer) InitDB(databaseName string) {
 	// Connect
-	client, err := mongowrapper.Connect(context.Background(), options.Client().ApplyURI(f.Config.DatabaseURI))
+	client, err := mongo.Connect(context.Background(), utils.MongoClientOptions(f.Config.DatabaseURI))
 	if err != nil {
 		panic(errors.Wrap(err, "connecting to MongoDB"))
 	}
 
 	// Pre-create collections for transactions
 	db := client.Database(databaseName)
-	CreateCollections(db)
+	CreateCollectionsWithCollation(db, f.Config.collation())
 
 	// Ensure all indexes
 	if f.Config.CreateIndexes {
@@ -202,15 +235,107 @@ func (f *FHIRServer) InitDB(databaseName string) {
 	}
 }
 
-func CreateCollections(db *mongowrapper.WrappedDatabase) {
+// ImportFiles imports the resources of NDJSON files (or of the .ndjson files in directories) into a database (see
+// ImportNDJSON), printing how many of each file's resources were imported and the first errors of those that weren't
+func (f *FHIRServer) ImportFiles(databaseName string, paths []string) error {
+	client, err := mongo.Connect(context.Background(), utils.MongoClientOptions(f.Config.DatabaseURI))
+	if err != nil {
+		return errors.Wrap(err, "connecting to MongoDB")
+	}
+	CreateCollectionsWithCollation(client.Database(databaseName), f.Config.collation())
+
+	var fileNames []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			fileNames = append(fileNames, path)
+			continue
+		}
+		dirFileNames, err := filepath.Glob(filepath.Join(path, "*.ndjson"))
+		if err != nil {
+			return err
+		}
+		fileNames = append(fileNames, dirFileNames...)
+	}
+
+	dal := NewMongoDataAccessLayer(client, databaseName, false, f.Config.DatabaseSuffix, f.Interceptors, f.Config)
+	session := dal.StartSession(context.Background(), "")
+	defer session.Finish()
+	for _, fileName := range fileNames {
+		file, err := os.Open(fileName)
+		if err != nil {
+			return err
+		}
+		result, err := ImportNDJSON(session, file, "")
+		file.Close()
+		if err != nil {
+			return errors.Wrapf(err, "importing %s", fileName)
+		}
+		log.Printf("Import: imported %d resources from %s (%d failed)\n", result.Imported, fileName, result.Failed)
+		for _, message := range result.Errors {
+			log.Printf("Import:   %s\n", message)
+		}
+	}
+	return nil
+}
+
+// CreateAdminAPIKey creates an admin API key in a database (see auth.AuthTypeAPIKey), with which further keys can be
+// created with the /$api-keys API, returning the key itself
+func (f *FHIRServer) CreateAdminAPIKey(databaseName string, name string) (string, error) {
+	client, err := mongo.Connect(context.Background(), utils.MongoClientOptions(f.Config.DatabaseURI))
+	if err != nil {
+		return "", errors.Wrap(err, "connecting to MongoDB")
+	}
+	if f.Config.CreateIndexes {
+		NewIndexer(databaseName, f.Config).ensureAPIKeysIndex(client.Database(databaseName))
+	}
+
+	apiKey, key, err := auth.NewAPIKey(name, false, nil, true)
+	if err != nil {
+		return "", err
+	}
+	dal := NewMongoDataAccessLayer(client, databaseName, false, f.Config.DatabaseSuffix, f.Interceptors, f.Config)
+	session := dal.StartSession(context.Background(), "")
+	defer session.Finish()
+	if err := session.CreateAPIKey(apiKey); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+func CreateCollections(db *mongo.Database) {
+	CreateCollectionsWithCollation(db, nil)
+}
+
+// CreateCollectionsWithCollation pre-creates the resource collections with a default collation (if not
+// nil), so that their _id indexes have it too and searches with the collation can use them.  The collation
+// of existing collections can't be changed.  The collection of contained resources (see search.ContainedCollection)
+// is created too.
+func CreateCollectionsWithCollation(db *mongo.Database, collation *options.Collation) {
 	// MongoDB transactions require that collections be pre-created
+	createCommand := bson.D{{"create", search.ContainedCollection}}
+	if collation != nil {
+		createCommand = append(createCommand, bson.E{Key: "collation", Value: collation.ToDocument()})
+	}
+	res := db.RunCommand(context.Background(), createCommand)
+	if res.Err() != nil && !strings.Contains(res.Err().Error(), "already exists") {
+		panic(res.Err())
+	}
+
 	for _, name := range models2.AllFhirResourceCollectionNames() {
 		// fmt.Printf("pre-creating collection %s, %s\n", name, name+"_prev")
 		res := db.RunCommand(context.Background(), bson.D{{"create", name + "_prev"}})
 		if res.Err() != nil && !strings.Contains(res.Err().Error(), "already exists") {
 			panic(res.Err())
 		}
-		res = db.RunCommand(context.Background(), bson.D{{"create", name}})
+		createCommand := bson.D{{"create", name}}
+		if collation != nil {
+			createCommand = append(createCommand, bson.E{Key: "collation", Value: collation.ToDocument()})
+		}
+		res = db.RunCommand(context.Background(), createCommand)
 		if res.Err() != nil && !strings.Contains(res.Err().Error(), "already exists") {
 			panic(res.Err())
 		}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -13,17 +14,22 @@ import (
 	"os"
 	"path"
 	"runtime"
+	"sort"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/eug48/fhir/auth"
 	"github.com/eug48/fhir/models"
+	"github.com/eug48/fhir/models2"
 	"github.com/eug48/fhir/search"
 	"github.com/gin-gonic/gin"
-	mongowrapper "github.com/opencensus-integrations/gomongowrapper"
 	"github.com/pebbe/util"
 	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/net/websocket"
 	. "gopkg.in/check.v1"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
@@ -31,7 +37,7 @@ import (
 
 type ServerSuite struct {
 	initialSession *mgo.Session
-	client         *mongowrapper.WrappedClient
+	client         *mongo.Client
 	dbname         string
 	Engine         *gin.Engine
 	Server         *httptest.Server
@@ -55,7 +61,7 @@ func (s *ServerSuite) SetUpSuite(c *C) {
 	var err error
 	s.initialSession, err = mgo.Dial("localhost")
 	util.CheckErr(err)
-	s.client, err = mongowrapper.Connect(context.TODO(), options.Client().ApplyURI("mongodb://localhost"))
+	s.client, err = mongo.Connect(context.TODO(), options.Client().ApplyURI("mongodb://localhost"))
 	util.CheckErr(err)
 
 	// Set gin to release mode (less verbose output)
@@ -230,7 +236,7 @@ func (s *ServerSuite) TestPatientPagingWithCountsDisabled(c *C) {
 	}
 	session := dal.StartSession(context.TODO(), s.dbname).(*mongoSession)
 	defer session.Finish()
-	links := session.generatePagingLinks(u, search.Query{Resource: "Patient"}, 0, 100)
+	links := session.generatePagingLinks(u, search.Query{Resource: "Patient"}, 0, 100, nil)
 	c.Assert(len(links), Equals, 3)
 	c.Assert(links[0].Relation, Equals, "self")
 	c.Assert(links[1].Relation, Equals, "first")
@@ -241,12 +247,198 @@ func (s *ServerSuite) TestPatientPagingWithCountsDisabled(c *C) {
 	c.Assert(next.Url, Equals, "https://fhir.example.com/fhir/Patient?_offset=100&_count=100")
 
 	// There should be no next link if numResults < count
-	links = session.generatePagingLinks(u, search.Query{Resource: "Patient"}, 0, 75)
+	links = session.generatePagingLinks(u, search.Query{Resource: "Patient"}, 0, 75, nil)
 	c.Assert(len(links), Equals, 2)
 	c.Assert(links[0].Relation, Equals, "self")
 	c.Assert(links[1].Relation, Equals, "first")
 }
 
+func (s *ServerSuite) TestSearchCountLimits(c *C) {
+	s.insertPatientFromFixture("../fixtures/patient-example-b.json")
+
+	config := DefaultConfig
+	config.DefaultCount = 1
+	config.MaxCount = 1
+	dal, ok := NewMongoDataAccessLayer(s.client, s.dbname, true, "_fhir", nil, config).(*mongoDataAccessLayer)
+	c.Assert(ok, Equals, true)
+
+	u := url.URL{
+		Scheme: "https",
+		Host:   "fhir.example.com",
+		Path:   "fhir/Patient",
+	}
+	session := dal.StartSession(context.TODO(), s.dbname).(*mongoSession)
+	defer session.Finish()
+
+	// The default page size applies to searches without a _count
+	bundle, err := session.Search(u, search.Query{Resource: "Patient"})
+	util.CheckErr(err)
+	c.Assert(bundle.Entry, HasLen, 1)
+	c.Assert(bundle.Entry[0].Search.Mode, Equals, "match")
+	assertPagingLink(c, bundle.Link[0], "self", 1, 0)
+
+	// A larger _count is reduced to the maximum, with a warning
+	bundle, err = session.Search(u, search.Query{Resource: "Patient", Query: "_count=5"})
+	util.CheckErr(err)
+	c.Assert(bundle.Entry, HasLen, 2)
+	c.Assert(bundle.Entry[0].Search.Mode, Equals, "match")
+	c.Assert(bundle.Entry[1].Search.Mode, Equals, "outcome")
+	c.Assert(bundle.Entry[1].Resource.ResourceType(), Equals, "OperationOutcome")
+	var outcome models.OperationOutcome
+	util.CheckErr(bundle.Entry[1].Resource.Unmarshal(&outcome))
+	c.Assert(outcome.Issue, HasLen, 1)
+	c.Assert(outcome.Issue[0].Severity, Equals, "warning")
+	c.Assert(outcome.Issue[0].Diagnostics, Equals, "_count was reduced to the maximum of 1 results per page")
+	assertPagingLink(c, bundle.Link[0], "self", 1, 0)
+}
+
+func (s *ServerSuite) TestSearchMaxResults(c *C) {
+	s.insertPatientFromFixture("../fixtures/patient-example-b.json")
+
+	dal, ok := NewMongoDataAccessLayer(s.client, s.dbname, true, "_fhir", nil, DefaultConfig).(*mongoDataAccessLayer)
+	c.Assert(ok, Equals, true)
+
+	u := url.URL{
+		Scheme: "https",
+		Host:   "fhir.example.com",
+		Path:   "fhir/Patient",
+	}
+	session := dal.StartSession(context.TODO(), s.dbname).(*mongoSession)
+	defer session.Finish()
+
+	// Only the first match is returned, with a warning, and there's no next page
+	bundle, err := session.Search(u, search.Query{Resource: "Patient", Query: "_count=2&_maxresults=1"})
+	util.CheckErr(err)
+	c.Assert(bundle.Entry, HasLen, 2)
+	c.Assert(bundle.Entry[0].Search.Mode, Equals, "match")
+	c.Assert(bundle.Entry[1].Search.Mode, Equals, "outcome")
+	var outcome models.OperationOutcome
+	util.CheckErr(bundle.Entry[1].Resource.Unmarshal(&outcome))
+	c.Assert(outcome.Issue, HasLen, 1)
+	c.Assert(outcome.Issue[0].Severity, Equals, "warning")
+	c.Assert(outcome.Issue[0].Diagnostics, Equals, "Only the first 1 of the 2 matching resources are returned (_maxresults)")
+	c.Assert(*bundle.Total, Equals, uint32(1))
+	c.Assert(bundle.Link, HasLen, 3)
+	assertPagingLink(c, bundle.Link[0], "self", 2, 0)
+	assertPagingLink(c, bundle.Link[1], "first", 2, 0)
+	assertPagingLink(c, bundle.Link[2], "last", 2, 0)
+	selfURL, err := url.Parse(bundle.Link[0].Url)
+	util.CheckErr(err)
+	c.Assert(selfURL.Query().Get(search.MaxResultsParam), Equals, "1")
+}
+
+func (s *ServerSuite) TestExplain(c *C) {
+	config := DefaultConfig
+	config.EnableExplain = true
+	engine := gin.New()
+	RegisterRoutes(engine, make(map[string][]gin.HandlerFunc), NewMongoDataAccessLayer(s.client, s.dbname, true, "_fhir", nil, config), config)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/$explain?resource=Patient&query=" + url.QueryEscape("gender=male&_sort=birthdate"))
+	util.CheckErr(err)
+	defer res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+
+	var explanation struct {
+		Resource  string                 `json:"resource"`
+		Query     string                 `json:"query"`
+		BSONQuery string                 `json:"bsonQuery"`
+		Command   map[string]interface{} `json:"command"`
+		Explain   map[string]interface{} `json:"explain"`
+	}
+	util.CheckErr(json.NewDecoder(res.Body).Decode(&explanation))
+	c.Assert(explanation.Resource, Equals, "Patient")
+	c.Assert(explanation.Query, Equals, "gender=male&_sort=birthdate")
+	c.Assert(explanation.BSONQuery, Matches, ".*gender.*")
+	c.Assert(explanation.Command["find"], Equals, "patients")
+	c.Assert(explanation.Explain["queryPlanner"], NotNil)
+	c.Assert(explanation.Explain["executionStats"], NotNil)
+
+	// Unknown resources are rejected
+	res, err = http.Get(server.URL + "/$explain?resource=Foo&query=bar=baz")
+	util.CheckErr(err)
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusBadRequest)
+
+	// The endpoint is disabled by default
+	res, err = http.Get(s.Server.URL + "/$explain?resource=Patient")
+	util.CheckErr(err)
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusNotFound)
+}
+
+func (s *ServerSuite) TestKeysetPagingLinks(c *C) {
+	config := DefaultConfig
+	config.KeysetPaging = true
+	dal, ok := NewMongoDataAccessLayer(s.client, s.dbname, true, "_fhir", nil, config).(*mongoDataAccessLayer)
+	c.Assert(ok, Equals, true)
+
+	u := url.URL{
+		Scheme: "https",
+		Host:   "fhir.example.com",
+		Path:   "fhir/Patient",
+	}
+	session := dal.StartSession(context.TODO(), s.dbname).(*mongoSession)
+	defer session.Finish()
+
+	// First page, with a cursor for the next page
+	next := &search.PageCursor{ID: "123"}
+	links := session.generatePagingLinks(u, search.Query{Resource: "Patient", Query: "_count=10"}, 40, 10, next)
+	c.Assert(links, HasLen, 4)
+	c.Assert(links[0].Relation, Equals, "self")
+	c.Assert(links[1].Relation, Equals, "first")
+	c.Assert(links[2].Relation, Equals, "next")
+	c.Assert(links[2].Url, Equals, "https://fhir.example.com/fhir/Patient?_count=10&_cursor="+next.Encode())
+	assertPagingLink(c, links[3], "last", 10, 30)
+
+	// Following the cursor, there is no previous link and the self link keeps the cursor
+	query := search.Query{Resource: "Patient", Query: "_count=10&_cursor=" + next.Encode()}
+	links = session.generatePagingLinks(u, query, 40, 5, nil)
+	c.Assert(links, HasLen, 3)
+	c.Assert(links[0].Relation, Equals, "self")
+	c.Assert(links[0].Url, Equals, "https://fhir.example.com/fhir/Patient?_count=10&_cursor="+next.Encode())
+	assertPagingLink(c, links[1], "first", 10, 0)
+	assertPagingLink(c, links[2], "last", 10, 30)
+}
+
+func (s *ServerSuite) TestResultSetPaging(c *C) {
+	s.insertPatientFromFixture("../fixtures/patient-example-b.json")
+
+	config := DefaultConfig
+	config.ResultSetLifetime = time.Minute
+	dal, ok := NewMongoDataAccessLayer(s.client, s.dbname, true, "_fhir", nil, config).(*mongoDataAccessLayer)
+	c.Assert(ok, Equals, true)
+
+	u := url.URL{
+		Scheme: "https",
+		Host:   "fhir.example.com",
+		Path:   "fhir/Patient",
+	}
+	session := dal.StartSession(context.TODO(), s.dbname).(*mongoSession)
+	defer session.Finish()
+
+	// The paging links of the first page refer to the snapshot of its results
+	bundle, err := session.Search(u, search.Query{Resource: "Patient", Query: "_count=1"})
+	util.CheckErr(err)
+	c.Assert(bundle.Entry, HasLen, 1)
+	c.Assert(*bundle.Total, Equals, uint32(2))
+	c.Assert(bundle.Link, HasLen, 4)
+	assertPagingLink(c, bundle.Link[2], "next", 1, 1)
+	nextURL, err := url.Parse(bundle.Link[2].Url)
+	util.CheckErr(err)
+	c.Assert(nextURL.Query().Get(search.ResultSetParam), Not(Equals), "")
+
+	// Patients created since then aren't part of the later pages
+	s.insertPatientFromFixture("../fixtures/patient-example-b.json")
+	bundle, err = session.Search(u, search.Query{Resource: "Patient", Query: nextURL.RawQuery})
+	util.CheckErr(err)
+	c.Assert(bundle.Entry, HasLen, 1)
+	c.Assert(*bundle.Total, Equals, uint32(2))
+	c.Assert(bundle.Link, HasLen, 4)
+	assertPagingLink(c, bundle.Link[3], "last", 1, 1)
+}
+
 func (s *ServerSuite) TestGetPatientSearchPagingPreservesSearchParams(c *C) {
 	// Add 39 more patients
 	for i := 0; i < 39; i++ {
@@ -280,6 +472,13 @@ func (s *ServerSuite) TestGetPatientSearchPagingPreservesSearchParams(c *C) {
 	assertPagingLinkWithParams(c, bundle.Link[2], "previous", v, 10, 10)
 	assertPagingLinkWithParams(c, bundle.Link[3], "next", v, 10, 30)
 	assertPagingLinkWithParams(c, bundle.Link[4], "last", v, 10, 30)
+
+	// The _format is preserved too
+	bundle = performSearch(c, s.Server.URL+"/Patient?gender=male&name=Donald&name=Duck&_format=json&_count=10")
+	v.Set("_format", "json")
+	c.Assert(bundle.Link, HasLen, 4)
+	assertPagingLinkWithParams(c, bundle.Link[0], "self", v, 10, 0)
+	assertPagingLinkWithParams(c, bundle.Link[2], "next", v, 10, 10)
 }
 
 func (s *ServerSuite) TestGetPatient(c *C) {
@@ -445,6 +644,33 @@ func (s *ServerSuite) TestCreatePatientConditionalMultiple(c *C) {
 	c.Assert(res.StatusCode, Equals, 412)
 	c.Assert(res.Header["Location"], IsNil)
 	s.checkPatientCount(3, c)
+
+	decoder := json.NewDecoder(res.Body)
+	outcome := &models.OperationOutcome{}
+	err = decoder.Decode(outcome)
+	util.CheckErr(err)
+	c.Assert(outcome.Issue, HasLen, 1)
+	c.Assert(outcome.Issue[0].Code, Equals, "multiple-matches")
+}
+
+func (s *ServerSuite) TestCreatePatientConditionalQuestionMark(c *C) {
+	s.TestCreatePatient987(c)
+
+	data, err := os.Open("../fixtures/patient-example-b.json")
+	util.CheckErr(err)
+	defer data.Close()
+
+	// The search may start with a "?"
+	client := &http.Client{}
+	req, err := http.NewRequest("POST", s.Server.URL+"/Patient", data)
+	util.CheckErr(err)
+	req.Header.Add("If-None-Exist", "?identifier=urn:oid:0.1.2.3.4.5.6.7|987")
+	req.Header.Add("Content-Type", "application/json")
+	res, err := client.Do(req)
+	util.CheckErr(err)
+
+	c.Assert(res.StatusCode, Equals, 200)
+	s.checkPatientCount(2, c)
 }
 
 func (s *ServerSuite) TestCreatePatientByPut(c *C) {
@@ -517,6 +743,7 @@ func (s *ServerSuite) TestGetConditionsWithIncludes(c *C) {
 	c.Assert(b.Entry[0].Search.Mode, Equals, "match")
 	c.Assert(b.Entry[1].Resource, FitsTypeOf, &models.Patient{})
 	c.Assert(b.Entry[1].Search.Mode, Equals, "include")
+	c.Assert(b.Entry[1].FullUrl, Equals, s.Server.URL+"/Patient/"+patient.Id)
 }
 
 func (s *ServerSuite) TestWrongResource(c *C) {
@@ -554,6 +781,71 @@ func (s *ServerSuite) TestUpdatePatient(c *C) {
 	c.Assert(time.Since(patient.Meta.LastUpdated.Time).Minutes() < float64(1), Equals, true)
 }
 
+func (s *ServerSuite) TestProvenanceHeader(c *C) {
+	defer s.DB().C("provenances").DropCollection()
+	provenance := `{"resourceType": "Provenance", "recorded": "2018-01-01T00:00:00Z",
+		"agent": [{"whoReference": {"reference": "Practitioner/1"}}]}`
+
+	req, err := http.NewRequest("PUT", s.Server.URL+"/Patient/"+s.FixtureID, strings.NewReader(`{"resourceType": "Patient"}`))
+	util.CheckErr(err)
+	req.Header.Add("Content-Type", "application/fhir+json")
+	req.Header.Add("X-Provenance", provenance)
+	res, err := http.DefaultClient.Do(req)
+	util.CheckErr(err)
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, 200)
+
+	location := res.Header.Get("X-GoFHIR-Provenance-Location")
+	c.Assert(strings.HasPrefix(location, "Provenance/"), Equals, true)
+	var stored models.Provenance
+	util.CheckErr(s.DB().C("provenances").FindId(strings.TrimPrefix(location, "Provenance/")).One(&stored))
+	c.Assert(stored.Target, HasLen, 1)
+	c.Assert(stored.Target[0].Reference, Equals, "Patient/"+s.FixtureID+"/_history/"+strings.Trim(res.Header.Get("ETag"), `W/"`))
+
+	// Invalid headers are rejected before anything is stored
+	req, err = http.NewRequest("POST", s.Server.URL+"/Patient", strings.NewReader(`{"resourceType": "Patient"}`))
+	util.CheckErr(err)
+	req.Header.Add("Content-Type", "application/fhir+json")
+	req.Header.Add("X-Provenance", `{"resourceType": "Provenance", "target": [{"reference": "Patient/1"}]}`)
+	res, err = http.DefaultClient.Do(req)
+	util.CheckErr(err)
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, 400)
+	count, err := s.DB().C("patients").Count()
+	util.CheckErr(err)
+	c.Assert(count, Equals, 1)
+}
+
+func (s *ServerSuite) TestLastUpdatedSearch(c *C) {
+	before := time.Now().UTC().Truncate(time.Second).Format(time.RFC3339)
+
+	// The fixture wasn't created through the server, so only the new patient has been updated since
+	data, err := os.Open("../fixtures/patient-example-b.json")
+	util.CheckErr(err)
+	defer data.Close()
+	res, err := http.Post(s.Server.URL+"/Patient", "application/json", data)
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, 201)
+	createdPatientID := resourceIdFromLocation(res)
+
+	b := assertBundleCount(c, s.Server.URL+"/Patient?_lastUpdated=ge"+before, 1, 1)
+	c.Assert(b.Entry[0].Resource.(*models.Patient).Id, Equals, createdPatientID)
+	assertBundleCount(c, s.Server.URL+"/Patient?_lastUpdated=lt"+before, 0, 0)
+
+	// Updating the fixture also sets its meta.lastUpdated
+	data2, err := os.Open("../fixtures/patient-example-c.json")
+	util.CheckErr(err)
+	defer data2.Close()
+	req, err := http.NewRequest("PUT", s.Server.URL+"/Patient/"+s.FixtureID, data2)
+	util.CheckErr(err)
+	req.Header.Add("Content-Type", "application/json")
+	res, err = http.DefaultClient.Do(req)
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, 200)
+
+	assertBundleCount(c, s.Server.URL+"/Patient?_lastUpdated=ge"+before, 2, 2)
+}
+
 func (s *ServerSuite) TestConditionalUpdatePatientNoMatch(c *C) {
 
 	data, err := os.Open("../fixtures/patient-example-c.json")
@@ -645,7 +937,7 @@ func (s *ServerSuite) TestVersionedConditionalUpdatePatientOneMatch200(c *C) {
 	c.Assert(time.Since(patient.Meta.LastUpdated.Time).Minutes() < float64(1), Equals, true)
 }
 
-func (s *ServerSuite) TestVersionedConditionalUpdatePatientOneMatch409(c *C) {
+func (s *ServerSuite) TestVersionedConditionalUpdatePatientOneMatch412(c *C) {
 
 	data, err := os.Open("../fixtures/patient-example-c.json")
 	util.CheckErr(err)
@@ -658,7 +950,7 @@ func (s *ServerSuite) TestVersionedConditionalUpdatePatientOneMatch409(c *C) {
 	res, err := http.DefaultClient.Do(req)
 	util.CheckErr(err)
 	logBody(res)
-	c.Assert(res.StatusCode, Equals, 409)
+	c.Assert(res.StatusCode, Equals, 412)
 
 	patientCollection := s.DB().C("patients")
 	count, err := patientCollection.Count()
@@ -699,7 +991,7 @@ func (s *ServerSuite) TestVersionedUpdatePatientOneMatch200(c *C) {
 	c.Assert(time.Since(patient.Meta.LastUpdated.Time).Minutes() < float64(1), Equals, true)
 }
 
-func (s *ServerSuite) TestVersionedUpdatePatientOneMatch409(c *C) {
+func (s *ServerSuite) TestVersionedUpdatePatientOneMatch412(c *C) {
 
 	data, err := os.Open("../fixtures/patient-example-c.json")
 	util.CheckErr(err)
@@ -712,7 +1004,7 @@ func (s *ServerSuite) TestVersionedUpdatePatientOneMatch409(c *C) {
 	res, err := http.DefaultClient.Do(req)
 	util.CheckErr(err)
 	logBody(res)
-	c.Assert(res.StatusCode, Equals, 409)
+	c.Assert(res.StatusCode, Equals, 412)
 
 	patientCollection := s.DB().C("patients")
 	count, err := patientCollection.Count()
@@ -725,6 +1017,403 @@ func (s *ServerSuite) TestVersionedUpdatePatientOneMatch409(c *C) {
 	c.Assert(patient.Meta, NotNil)
 }
 
+func (s *ServerSuite) TestRequireIfMatch(c *C) {
+	config := DefaultConfig
+	config.RequireIfMatch = true
+	engine := gin.New()
+	RegisterRoutes(engine, make(map[string][]gin.HandlerFunc), NewMongoDataAccessLayer(s.client, s.dbname, true, "_fhir", nil, config), config)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	doRequest := func(method, id, contentType, ifMatch, body string) *http.Response {
+		req, err := http.NewRequest(method, server.URL+"/Patient/"+id, strings.NewReader(body))
+		util.CheckErr(err)
+		req.Header.Add("Content-Type", contentType)
+		if ifMatch != "" {
+			req.Header.Add("If-Match", ifMatch)
+		}
+		res, err := http.DefaultClient.Do(req)
+		util.CheckErr(err)
+		return res
+	}
+	assertPreconditionFailed := func(res *http.Response) {
+		c.Assert(res.StatusCode, Equals, 412)
+		outcome := &models.OperationOutcome{}
+		util.CheckErr(json.NewDecoder(res.Body).Decode(outcome))
+		c.Assert(outcome.Issue[0].Code, Equals, "conflict")
+	}
+	update := `{"resourceType": "Patient", "id": "` + s.FixtureID + `", "gender": "female"}`
+
+	// Reads return the version to update
+	res := doRequest("GET", s.FixtureID, "", "", "")
+	c.Assert(res.StatusCode, Equals, 200)
+	c.Assert(res.Header.Get("ETag"), Equals, `W/"1"`)
+
+	// Updates and patches of existing resources need it
+	assertPreconditionFailed(doRequest("PUT", s.FixtureID, "application/json", "", update))
+	assertPreconditionFailed(doRequest("PATCH", s.FixtureID, "application/json-patch+json", "", `[{"op": "replace", "path": "/gender", "value": "female"}]`))
+
+	res = doRequest("PUT", s.FixtureID, "application/json", `W/"1"`, update)
+	c.Assert(res.StatusCode, Equals, 200)
+	c.Assert(res.Header.Get("ETag"), Equals, `W/"2"`)
+	res = doRequest("GET", s.FixtureID, "", "", "")
+	c.Assert(res.Header.Get("ETag"), Equals, `W/"2"`)
+	c.Assert(res.Header.Get("Last-Modified"), Not(Equals), "")
+
+	// A concurrent editor with the previous version can't overwrite it
+	assertPreconditionFailed(doRequest("PUT", s.FixtureID, "application/json", `W/"1"`, update))
+
+	// Creates don't
+	id := bson.NewObjectId().Hex()
+	res = doRequest("PUT", id, "application/json", "", `{"resourceType": "Patient", "id": "`+id+`", "gender": "male"}`)
+	c.Assert(res.StatusCode, Equals, 201)
+}
+
+func (s *ServerSuite) TestValidateResources(c *C) {
+	config := DefaultConfig
+	config.ValidateResources = true
+	engine := gin.New()
+	RegisterRoutes(engine, make(map[string][]gin.HandlerFunc), NewMongoDataAccessLayer(s.client, s.dbname, true, "_fhir", nil, config), config)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	doRequest := func(method, path, body string) *http.Response {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		util.CheckErr(err)
+		req.Header.Add("Content-Type", "application/json")
+		res, err := http.DefaultClient.Do(req)
+		util.CheckErr(err)
+		return res
+	}
+	assertInvalid := func(res *http.Response, expressions ...string) {
+		c.Assert(res.StatusCode, Equals, 422)
+		outcome := &models.OperationOutcome{}
+		util.CheckErr(json.NewDecoder(res.Body).Decode(outcome))
+		var found []string
+		for _, issue := range outcome.Issue {
+			c.Assert(issue.Severity, Equals, "error")
+			found = append(found, issue.Expression...)
+		}
+		c.Assert(found, DeepEquals, expressions)
+	}
+
+	// Creates and updates of invalid resources fail, listing each issue
+	invalid := `{"resourceType": "Patient", "gender": "bogus", "birthDate": "yesterday", "contact": [{"foo": "bar"}]}`
+	assertInvalid(doRequest("POST", "/Patient", invalid), "Patient.gender", "Patient.birthDate", "Patient.contact[0].foo")
+	assertInvalid(doRequest("PUT", "/Patient/"+s.FixtureID, `{"resourceType": "Patient", "id": "`+s.FixtureID+`", "active": "yes"}`), "Patient.active")
+	assertInvalid(doRequest("POST", "/Observation", `{"resourceType": "Observation", "code": {"text": "weight"}}`), "Observation.status")
+
+	// Including the entries of batches
+	res := doRequest("POST", "/", `{"resourceType": "Bundle", "type": "batch", "entry": [
+		{"resource": {"resourceType": "Patient", "gender": "bogus"}, "request": {"method": "POST", "url": "Patient"}},
+		{"resource": {"resourceType": "Patient", "gender": "male"}, "request": {"method": "POST", "url": "Patient"}}
+	]}`)
+	c.Assert(res.StatusCode, Equals, 200)
+	bundle := &models.Bundle{}
+	util.CheckErr(json.NewDecoder(res.Body).Decode(bundle))
+	c.Assert(bundle.Entry, HasLen, 2)
+	c.Assert(bundle.Entry[0].Response.Status, Equals, "422")
+	c.Assert(bundle.Entry[1].Response.Status, Equals, "201")
+
+	// Valid resources are stored
+	res = doRequest("POST", "/Patient", `{"resourceType": "Patient", "gender": "female", "birthDate": "1970-01-01"}`)
+	c.Assert(res.StatusCode, Equals, 201)
+}
+
+func (s *ServerSuite) TestValidateOperationAndProfiles(c *C) {
+	name, err := models2.LoadPackage("../fixtures/example-profiles-package.tgz")
+	util.CheckErr(err)
+	c.Assert(name, Equals, "example.profiles@1.0.0")
+	profile := "http://example.com/fhir/StructureDefinition/example-patient"
+
+	doRequest := func(server *httptest.Server, path, body string) *http.Response {
+		req, err := http.NewRequest("POST", server.URL+path, strings.NewReader(body))
+		util.CheckErr(err)
+		req.Header.Add("Content-Type", "application/json")
+		res, err := http.DefaultClient.Do(req)
+		util.CheckErr(err)
+		return res
+	}
+	issues := func(res *http.Response) []string {
+		outcome := &models.OperationOutcome{}
+		util.CheckErr(json.NewDecoder(res.Body).Decode(outcome))
+		var found []string
+		for _, issue := range outcome.Issue {
+			found = append(found, issue.Code+" "+strings.Join(issue.Expression, ","))
+		}
+		return found
+	}
+
+	// $validate returns the issues of the resource, whether or not it's valid
+	res := doRequest(s.Server, "/Patient/$validate", `{"resourceType": "Patient", "gender": "other"}`)
+	c.Assert(res.StatusCode, Equals, 200)
+	c.Assert(issues(res), DeepEquals, []string{"informational "})
+	res = doRequest(s.Server, "/Patient/$validate", `{"resourceType": "Patient", "gender": "M"}`)
+	c.Assert(res.StatusCode, Equals, 200)
+	c.Assert(issues(res), DeepEquals, []string{"code-invalid Patient.gender"})
+
+	// against the profiles it claims or is requested
+	res = doRequest(s.Server, "/Patient/$validate", `{"resourceType": "Patient", "meta": {"profile": ["`+profile+`"]}, "gender": "other"}`)
+	c.Assert(issues(res), DeepEquals, []string{"required Patient.identifier", "code-invalid Patient.gender"})
+	res = doRequest(s.Server, "/Patient/$validate?profile="+profile, `{"resourceType": "Patient", "gender": "male"}`)
+	c.Assert(issues(res), DeepEquals, []string{"required Patient.identifier"})
+	res = doRequest(s.Server, "/Patient/"+s.FixtureID+"/$validate", `{"resourceType": "Parameters", "parameter": [
+		{"name": "resource", "resource": {"resourceType": "Patient", "gender": "male"}},
+		{"name": "profile", "valueUri": "`+profile+`"}
+	]}`)
+	c.Assert(res.StatusCode, Equals, 200)
+	c.Assert(issues(res), DeepEquals, []string{"required Patient.identifier"})
+	res = doRequest(s.Server, "/Patient/$validate?profile=http://example.com/unknown", `{"resourceType": "Patient"}`)
+	c.Assert(issues(res), DeepEquals, []string{"not-supported Patient"})
+
+	// Resources of other types are rejected
+	res = doRequest(s.Server, "/Observation/$validate", `{"resourceType": "Patient"}`)
+	c.Assert(res.StatusCode, Equals, 400)
+
+	// Resources that don't conform to the profiles they claim aren't stored
+	config := DefaultConfig
+	config.ValidateResources = true
+	engine := gin.New()
+	RegisterRoutes(engine, make(map[string][]gin.HandlerFunc), NewMongoDataAccessLayer(s.client, s.dbname, true, "_fhir", nil, config), config)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	res = doRequest(server, "/Patient", `{"resourceType": "Patient", "meta": {"profile": ["`+profile+`"]}, "gender": "male"}`)
+	c.Assert(res.StatusCode, Equals, 422)
+	c.Assert(issues(res), DeepEquals, []string{"required Patient.identifier"})
+	res = doRequest(server, "/Patient", `{"resourceType": "Patient", "meta": {"profile": ["`+profile+`"]}, "gender": "male",
+		"identifier": [{"system": "http://example.com/mrn", "value": "123"}]}`)
+	c.Assert(res.StatusCode, Equals, 201)
+
+	// The profiles are listed in the CapabilityStatement
+	res, err = http.Get(s.Server.URL + "/metadata")
+	util.CheckErr(err)
+	statement := &models.CapabilityStatement{}
+	util.CheckErr(json.NewDecoder(res.Body).Decode(statement))
+	c.Assert(statement.Profile, DeepEquals, []models.Reference{{Reference: profile}})
+}
+
+func (s *ServerSuite) TestReferentialIntegrity(c *C) {
+	newServer := func(enforceReferences bool, referencedDeletes string) *httptest.Server {
+		config := DefaultConfig
+		config.EnforceReferences = enforceReferences
+		config.ReferencedDeletes = referencedDeletes
+		engine := gin.New()
+		RegisterRoutes(engine, make(map[string][]gin.HandlerFunc), NewMongoDataAccessLayer(s.client, s.dbname, true, "_fhir", nil, config), config)
+		return httptest.NewServer(engine)
+	}
+	server := newServer(true, ReferencedDeletesBlock)
+	defer server.Close()
+
+	doRequest := func(server *httptest.Server, method, path, body string) *http.Response {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		util.CheckErr(err)
+		req.Header.Add("Content-Type", "application/json")
+		res, err := http.DefaultClient.Do(req)
+		util.CheckErr(err)
+		return res
+	}
+	create := func(server *httptest.Server, resourceType, body string) string {
+		res := doRequest(server, "POST", "/"+resourceType, body)
+		c.Assert(res.StatusCode, Equals, 201)
+		var created struct {
+			Id string `json:"id"`
+		}
+		util.CheckErr(json.NewDecoder(res.Body).Decode(&created))
+		return created.Id
+	}
+	issues := func(res *http.Response) []string {
+		outcome := &models.OperationOutcome{}
+		util.CheckErr(json.NewDecoder(res.Body).Decode(outcome))
+		var found []string
+		for _, issue := range outcome.Issue {
+			found = append(found, issue.Code+" "+issue.Diagnostics)
+		}
+		return found
+	}
+	observation := func(patientID string) string {
+		return `{"resourceType": "Observation", "status": "final", "code": {"text": "weight"}, "subject": {"reference": "Patient/` + patientID + `"}}`
+	}
+
+	// Resources can't refer to resources that don't exist
+	missingID := bson.NewObjectId().Hex()
+	res := doRequest(server, "POST", "/Observation", observation(missingID))
+	c.Assert(res.StatusCode, Equals, 422)
+	c.Assert(issues(res), DeepEquals, []string{"not-found Patient/" + missingID + " doesn't exist"})
+	patientID := create(server, "Patient", `{"resourceType": "Patient"}`)
+	observationID := create(server, "Observation", observation(patientID))
+	res = doRequest(server, "PUT", "/Observation/"+observationID, strings.Replace(observation(missingID), "{", `{"id": "`+observationID+`", `, 1))
+	c.Assert(res.StatusCode, Equals, 422)
+
+	// and resources that others refer to can't be deleted
+	res = doRequest(server, "DELETE", "/Patient/"+patientID, "")
+	c.Assert(res.StatusCode, Equals, 409)
+	c.Assert(issues(res), DeepEquals, []string{"conflict Observation/" + observationID + " refers to Patient/" + patientID + " (Observation:patient)"})
+	res = doRequest(server, "DELETE", "/Patient?_id="+patientID, "")
+	c.Assert(res.StatusCode, Equals, 409)
+
+	// The references of transactions' resources are checked when they're committed
+	res = doRequest(server, "POST", "/", `{"resourceType": "Bundle", "type": "transaction", "entry": [
+		{"fullUrl": "urn:uuid:61ebe359-bfdc-4613-8bf2-c5e300945f0a", "resource": {"resourceType": "Patient"}, "request": {"method": "POST", "url": "Patient"}},
+		{"resource": `+observation("61ebe359")+`, "request": {"method": "POST", "url": "Observation"}}
+	]}`)
+	c.Assert(res.StatusCode, Equals, 422)
+	res = doRequest(server, "POST", "/", `{"resourceType": "Bundle", "type": "transaction", "entry": [
+		{"resource": `+strings.Replace(observation(""), "Patient/", "urn:uuid:61ebe359-bfdc-4613-8bf2-c5e300945f0a", 1)+`, "request": {"method": "POST", "url": "Observation"}},
+		{"fullUrl": "urn:uuid:61ebe359-bfdc-4613-8bf2-c5e300945f0a", "resource": {"resourceType": "Patient"}, "request": {"method": "POST", "url": "Patient"}}
+	]}`)
+	c.Assert(res.StatusCode, Equals, 200)
+	res = doRequest(server, "POST", "/", `{"resourceType": "Bundle", "type": "transaction", "entry": [
+		{"request": {"method": "DELETE", "url": "Patient/`+patientID+`"}}
+	]}`)
+	c.Assert(res.StatusCode, Equals, 409)
+	res = doRequest(server, "POST", "/", `{"resourceType": "Bundle", "type": "transaction", "entry": [
+		{"request": {"method": "DELETE", "url": "Patient/`+patientID+`"}},
+		{"request": {"method": "DELETE", "url": "Observation/`+observationID+`"}}
+	]}`)
+	c.Assert(res.StatusCode, Equals, 200)
+
+	// Otherwise deletes may only warn about the references to the deleted resources
+	warningServer := newServer(false, ReferencedDeletesWarn)
+	defer warningServer.Close()
+	patientID = create(warningServer, "Patient", `{"resourceType": "Patient"}`)
+	observationID = create(warningServer, "Observation", observation(patientID))
+	res = doRequest(warningServer, "DELETE", "/Patient/"+patientID, "")
+	c.Assert(res.StatusCode, Equals, 200)
+	c.Assert(issues(res), DeepEquals, []string{"conflict Observation/" + observationID + " refers to Patient/" + patientID + " (Observation:patient)"})
+	otherPatientID := create(warningServer, "Patient", `{"resourceType": "Patient"}`)
+	create(warningServer, "Observation", observation(otherPatientID))
+	res = doRequest(warningServer, "DELETE", "/Patient?_id="+otherPatientID, "")
+	c.Assert(res.StatusCode, Equals, 200)
+	c.Assert(issues(res), HasLen, 2)
+
+	// or allow them, as by default
+	otherPatientID = create(s.Server, "Patient", `{"resourceType": "Patient"}`)
+	create(s.Server, "Observation", observation(otherPatientID))
+	res = doRequest(s.Server, "DELETE", "/Patient/"+otherPatientID, "")
+	c.Assert(res.StatusCode, Equals, 204)
+
+	// The references to deleted resources are then reported as dangling
+	res = doRequest(warningServer, "GET", "/$dangling-references?_type=Observation&_count=1000", "")
+	c.Assert(res.StatusCode, Equals, 200)
+	dangling := "not-found Observation/" + observationID + " refers to Patient/" + patientID + " (Observation.subject), which doesn't exist"
+	found := false
+	for _, issue := range issues(res) {
+		found = found || issue == dangling
+	}
+	c.Assert(found, Equals, true)
+	res = doRequest(warningServer, "GET", "/$dangling-references?_type=Bogus", "")
+	c.Assert(res.StatusCode, Equals, 400)
+	res = doRequest(s.Server, "GET", "/$dangling-references", "")
+	c.Assert(res.StatusCode, Equals, 404)
+}
+
+func (s *ServerSuite) TestReferencedDeletePolicies(c *C) {
+	config := DefaultConfig
+	config.ReferencedDeletesByType = map[string]string{
+		"Patient":      ReferencedDeletesCascade,
+		"Observation":  ReferencedDeletesCascade,
+		"Practitioner": ReferencedDeletesNullify,
+		"Encounter":    ReferencedDeletesBlock,
+	}
+	engine := gin.New()
+	RegisterRoutes(engine, make(map[string][]gin.HandlerFunc), NewMongoDataAccessLayer(s.client, s.dbname, true, "_fhir", nil, config), config)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	doRequest := func(method, path, body string) *http.Response {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		util.CheckErr(err)
+		req.Header.Add("Content-Type", "application/json")
+		res, err := http.DefaultClient.Do(req)
+		util.CheckErr(err)
+		return res
+	}
+	create := func(resourceType, body string) string {
+		res := doRequest("POST", "/"+resourceType, body)
+		c.Assert(res.StatusCode, Equals, 201)
+		var created struct {
+			Id string `json:"id"`
+		}
+		util.CheckErr(json.NewDecoder(res.Body).Decode(&created))
+		return created.Id
+	}
+	issues := func(outcome *models.OperationOutcome) []string {
+		var found []string
+		for _, issue := range outcome.Issue {
+			found = append(found, issue.Code+" "+issue.Diagnostics)
+		}
+		return found
+	}
+	responseIssues := func(res *http.Response) []string {
+		outcome := &models.OperationOutcome{}
+		util.CheckErr(json.NewDecoder(res.Body).Decode(outcome))
+		return issues(outcome)
+	}
+
+	// Deleting a practitioner removes the references to them
+	patientID := create("Patient", `{"resourceType": "Patient"}`)
+	practitionerID := create("Practitioner", `{"resourceType": "Practitioner"}`)
+	observationID := create("Observation", `{"resourceType": "Observation", "status": "final", "code": {"text": "weight"},
+		"subject": {"reference": "Patient/`+patientID+`"}, "performer": [{"reference": "Practitioner/`+practitionerID+`"}]}`)
+	res := doRequest("DELETE", "/Practitioner/"+practitionerID, "")
+	c.Assert(res.StatusCode, Equals, 200)
+	c.Assert(responseIssues(res), DeepEquals, []string{"informational Removed the references of Observation/" + observationID + " to Practitioner/" + practitionerID + " (Observation:performer)"})
+	res = doRequest("GET", "/Observation/"+observationID, "")
+	c.Assert(res.StatusCode, Equals, 200)
+	observation := &models.Observation{}
+	util.CheckErr(json.NewDecoder(res.Body).Decode(observation))
+	c.Assert(observation.Performer, HasLen, 0)
+	c.Assert(observation.Subject.Reference, Equals, "Patient/"+patientID)
+	c.Assert(observation.Meta.VersionId, Equals, "2")
+
+	// and deleting a patient deletes the resources referring to them, and those referring to them in turn
+	reportID := create("DiagnosticReport", `{"resourceType": "DiagnosticReport", "status": "final", "code": {"text": "weight"},
+		"result": [{"reference": "Observation/`+observationID+`"}]}`)
+	res = doRequest("DELETE", "/Patient/"+patientID, "")
+	c.Assert(res.StatusCode, Equals, 200)
+	c.Assert(responseIssues(res), DeepEquals, []string{
+		"informational Deleted Observation/" + observationID + ", which referred to Patient/" + patientID + " (Observation:patient)",
+		"informational Deleted DiagnosticReport/" + reportID + ", which referred to Observation/" + observationID + " (DiagnosticReport:result)",
+	})
+	res = doRequest("GET", "/Observation/"+observationID, "")
+	c.Assert(res.StatusCode, Equals, 410)
+	res = doRequest("GET", "/DiagnosticReport/"+reportID, "")
+	c.Assert(res.StatusCode, Equals, 410)
+
+	// unless the policies of the cascaded deletes block them, in which case nothing is deleted
+	patientID = create("Patient", `{"resourceType": "Patient"}`)
+	encounterID := create("Encounter", `{"resourceType": "Encounter", "status": "finished", "subject": {"reference": "Patient/`+patientID+`"}}`)
+	create("Procedure", `{"resourceType": "Procedure", "status": "completed", "subject": {"reference": "Group/1"},
+		"context": {"reference": "Encounter/`+encounterID+`"}}`)
+	res = doRequest("DELETE", "/Patient/"+patientID, "")
+	c.Assert(res.StatusCode, Equals, 409)
+	res = doRequest("GET", "/Patient/"+patientID, "")
+	c.Assert(res.StatusCode, Equals, 200)
+	res = doRequest("GET", "/Encounter/"+encounterID, "")
+	c.Assert(res.StatusCode, Equals, 200)
+
+	// The changes made by the deletes of batches and transactions are their entries' outcomes
+	patientID = create("Patient", `{"resourceType": "Patient"}`)
+	observationID = create("Observation", `{"resourceType": "Observation", "status": "final", "code": {"text": "weight"}, "subject": {"reference": "Patient/`+patientID+`"}}`)
+	res = doRequest("POST", "/", `{"resourceType": "Bundle", "type": "transaction", "entry": [
+		{"request": {"method": "DELETE", "url": "Patient/`+patientID+`"}}
+	]}`)
+	c.Assert(res.StatusCode, Equals, 200)
+	var bundle struct {
+		Entry []struct {
+			Response struct {
+				Status  string
+				Outcome models.OperationOutcome
+			}
+		}
+	}
+	util.CheckErr(json.NewDecoder(res.Body).Decode(&bundle))
+	c.Assert(bundle.Entry, HasLen, 1)
+	c.Assert(bundle.Entry[0].Response.Status, Equals, "200")
+	c.Assert(issues(&bundle.Entry[0].Response.Outcome), DeepEquals, []string{"informational Deleted Observation/" + observationID + ", which referred to Patient/" + patientID + " (Observation:patient)"})
+}
+
 func (s *ServerSuite) TestBatchConditionalUpdatePatientUUIDIdentifier(c *C) {
 
 	testPatient := s.insertPatientFromFixture("../fixtures/patient-example-uuid-identifier.json")
@@ -988,6 +1677,9 @@ func (s *ServerSuite) TestConditionalUpdateMultipleMatches(c *C) {
 
 	// Should return an HTTP 412 Precondition Failed
 	c.Assert(res.StatusCode, Equals, 412)
+	outcome := &models.OperationOutcome{}
+	util.CheckErr(json.NewDecoder(res.Body).Decode(outcome))
+	c.Assert(outcome.Issue[0].Code, Equals, "multiple-matches")
 
 	// Ensure there are still only two
 	patientCollection := s.DB().C("patients")
@@ -1006,20 +1698,130 @@ func (s *ServerSuite) TestConditionalUpdateMultipleMatches(c *C) {
 	c.Assert(patient2.Name[0].Given[0], Equals, "Don")
 }
 
-func (s *ServerSuite) TestDeletePatient(c *C) {
+func (s *ServerSuite) TestConditionalUpdateIDMismatch(c *C) {
+	doUpdate := func(query, body string) *http.Response {
+		req, err := http.NewRequest("PUT", s.Server.URL+"/Patient"+query, strings.NewReader(body))
+		util.CheckErr(err)
+		req.Header.Add("Content-Type", "application/json")
+		res, err := http.DefaultClient.Do(req)
+		util.CheckErr(err)
+		return res
+	}
 
-	data, err := os.Open("../fixtures/patient-example-d.json")
-	util.CheckErr(err)
-	defer data.Close()
+	// The id of the resource (if any) must be that of the match
+	res := doUpdate("?name=Donald", `{"resourceType": "Patient", "id": "5d0b5bdf00000000000000f1", "gender": "female"}`)
+	c.Assert(res.StatusCode, Equals, 400)
+	outcome := &models.OperationOutcome{}
+	util.CheckErr(json.NewDecoder(res.Body).Decode(outcome))
+	c.Assert(outcome.Issue[0].Code, Equals, "invalid")
 
-	res, err := http.Post(s.Server.URL+"/Patient", "application/json", data)
-	util.CheckErr(err)
+	res = doUpdate("?name=Donald", `{"resourceType": "Patient", "id": "`+s.FixtureID+`", "gender": "female"}`)
+	c.Assert(res.StatusCode, Equals, 200)
 
-	createdPatientID := resourceIdFromLocation(res)
+	// Search criteria are required
+	res = doUpdate("", `{"resourceType": "Patient", "gender": "female"}`)
+	c.Assert(res.StatusCode, Equals, 400)
 
-	req, err := http.NewRequest("DELETE", s.Server.URL+"/Patient/"+createdPatientID, nil)
-	util.CheckErr(err)
-	res, err = http.DefaultClient.Do(req)
+	patientCollection := s.DB().C("patients")
+	count, err := patientCollection.Count()
+	util.CheckErr(err)
+	c.Assert(count, Equals, 1)
+	patient := models.Patient{}
+	util.CheckErr(patientCollection.FindId(s.FixtureID).One(&patient))
+	c.Assert(patient.Gender, Equals, "female")
+}
+
+func (s *ServerSuite) TestPatchPatient(c *C) {
+	doPatch := func(id, contentType, ifMatch, body string) *http.Response {
+		req, err := http.NewRequest("PATCH", s.Server.URL+"/Patient/"+id, strings.NewReader(body))
+		util.CheckErr(err)
+		req.Header.Add("Content-Type", contentType)
+		if ifMatch != "" {
+			req.Header.Add("If-Match", ifMatch)
+		}
+		res, err := http.DefaultClient.Do(req)
+		util.CheckErr(err)
+		return res
+	}
+	assertOutcome := func(res *http.Response, statusCode int, code string) {
+		c.Assert(res.StatusCode, Equals, statusCode)
+		outcome := &models.OperationOutcome{}
+		util.CheckErr(json.NewDecoder(res.Body).Decode(outcome))
+		c.Assert(outcome.Issue[0].Code, Equals, code)
+	}
+	patientCollection := s.DB().C("patients")
+
+	// JSON Patch
+	res := doPatch(s.FixtureID, "application/json-patch+json", "", `[{"op": "replace", "path": "/gender", "value": "female"}]`)
+	c.Assert(res.StatusCode, Equals, 200)
+	c.Assert(res.Header.Get("ETag"), Equals, `W/"2"`)
+	patient := models.Patient{}
+	util.CheckErr(json.NewDecoder(res.Body).Decode(&patient))
+	c.Assert(patient.Gender, Equals, "female")
+	c.Assert(patient.Name[0].Given[0], Equals, "Donald")
+
+	// FHIRPath Patch
+	res = doPatch(s.FixtureID, "application/fhir+json", `W/"2"`, `{"resourceType": "Parameters", "parameter": [{"name": "operation", "part": [
+		{"name": "type", "valueCode": "replace"}, {"name": "path", "valueString": "Patient.name[0].given[0]"}, {"name": "value", "valueString": "Donny"}]}]}`)
+	c.Assert(res.StatusCode, Equals, 200)
+	c.Assert(res.Header.Get("ETag"), Equals, `W/"3"`)
+	patient = models.Patient{}
+	util.CheckErr(patientCollection.FindId(s.FixtureID).One(&patient))
+	c.Assert(patient.Gender, Equals, "female")
+	c.Assert(patient.Name[0].Given[0], Equals, "Donny")
+	c.Assert(patient.Meta.VersionId, Equals, "3")
+
+	// A stale If-Match fails rather than overwriting the newer version
+	res = doPatch(s.FixtureID, "application/json-patch+json", `W/"2"`, `[{"op": "replace", "path": "/gender", "value": "male"}]`)
+	assertOutcome(res, 412, "conflict")
+
+	// Patches that can't be applied, malformed ones, and ones of resources that don't exist
+	res = doPatch(s.FixtureID, "application/json-patch+json", "", `[{"op": "test", "path": "/gender", "value": "male"}]`)
+	assertOutcome(res, 422, "processing")
+	res = doPatch(s.FixtureID, "application/json-patch+json", "", `[{"op": "remove", "path": "/id"}]`)
+	assertOutcome(res, 422, "processing")
+	res = doPatch(s.FixtureID, "application/json-patch+json", "", `[{"op": "unknown", "path": "/gender"}]`)
+	assertOutcome(res, 400, "invalid")
+	res = doPatch(s.FixtureID, "application/fhir+json", "", `{"resourceType": "Patient", "gender": "male"}`)
+	assertOutcome(res, 400, "invalid")
+	res = doPatch("5d0b5bdf00000000000000f1", "application/json-patch+json", "", `[{"op": "replace", "path": "/gender", "value": "male"}]`)
+	c.Assert(res.StatusCode, Equals, 404)
+
+	patient = models.Patient{}
+	util.CheckErr(patientCollection.FindId(s.FixtureID).One(&patient))
+	c.Assert(patient.Gender, Equals, "female")
+	c.Assert(patient.Meta.VersionId, Equals, "3")
+
+	// Without histories there are no versions for If-Match to match
+	config := DefaultConfig
+	config.EnableHistory = false
+	engine := gin.New()
+	RegisterRoutes(engine, make(map[string][]gin.HandlerFunc), NewMongoDataAccessLayer(s.client, s.dbname, true, "_fhir", nil, config), config)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+	req, err := http.NewRequest("PATCH", server.URL+"/Patient/"+s.FixtureID, strings.NewReader(`[{"op": "replace", "path": "/gender", "value": "male"}]`))
+	util.CheckErr(err)
+	req.Header.Add("Content-Type", "application/json-patch+json")
+	req.Header.Add("If-Match", `W/"3"`)
+	res, err = http.DefaultClient.Do(req)
+	util.CheckErr(err)
+	assertOutcome(res, 400, "not-supported")
+}
+
+func (s *ServerSuite) TestDeletePatient(c *C) {
+
+	data, err := os.Open("../fixtures/patient-example-d.json")
+	util.CheckErr(err)
+	defer data.Close()
+
+	res, err := http.Post(s.Server.URL+"/Patient", "application/json", data)
+	util.CheckErr(err)
+
+	createdPatientID := resourceIdFromLocation(res)
+
+	req, err := http.NewRequest("DELETE", s.Server.URL+"/Patient/"+createdPatientID, nil)
+	util.CheckErr(err)
+	res, err = http.DefaultClient.Do(req)
 	util.CheckErr(err)
 
 	c.Assert(res.StatusCode, Equals, 204)
@@ -1028,11 +1830,107 @@ func (s *ServerSuite) TestDeletePatient(c *C) {
 	c.Assert(count, Equals, 0)
 }
 
+func (s *ServerSuite) TestVreadPatient(c *C) {
+	res, err := http.Post(s.Server.URL+"/Patient", "application/json", strings.NewReader(`{"resourceType": "Patient", "gender": "male"}`))
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, 201)
+	id := resourceIdFromLocation(res)
+
+	req, err := http.NewRequest("PUT", s.Server.URL+"/Patient/"+id, strings.NewReader(`{"resourceType": "Patient", "id": "`+id+`", "gender": "female"}`))
+	util.CheckErr(err)
+	req.Header.Add("Content-Type", "application/json")
+	res, err = http.DefaultClient.Do(req)
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, 200)
+	c.Assert(res.Header.Get("ETag"), Equals, `W/"2"`)
+
+	vread := func(versionId string) *http.Response {
+		res, err := http.Get(s.Server.URL + "/Patient/" + id + "/_history/" + versionId)
+		util.CheckErr(err)
+		return res
+	}
+
+	// Both the previous and current versions can be read
+	for versionId, gender := range map[string]string{"1": "male", "2": "female"} {
+		res = vread(versionId)
+		c.Assert(res.StatusCode, Equals, 200)
+		c.Assert(res.Header.Get("ETag"), Equals, `W/"`+versionId+`"`)
+		patient := models.Patient{}
+		util.CheckErr(json.NewDecoder(res.Body).Decode(&patient))
+		c.Assert(patient.Meta.VersionId, Equals, versionId)
+		c.Assert(patient.Gender, Equals, gender)
+	}
+	c.Assert(vread("3").StatusCode, Equals, 404)
+	c.Assert(vread("abc").StatusCode, Equals, 404)
+
+	// Deleting stores a deleted version, and keeps the previous ones
+	req, err = http.NewRequest("DELETE", s.Server.URL+"/Patient/"+id, nil)
+	util.CheckErr(err)
+	res, err = http.DefaultClient.Do(req)
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, 204)
+	c.Assert(res.Header.Get("ETag"), Equals, `W/"3"`)
+
+	c.Assert(vread("3").StatusCode, Equals, 410)
+	c.Assert(vread("2").StatusCode, Equals, 200)
+	c.Assert(vread("1").StatusCode, Equals, 200)
+}
+
+func (s *ServerSuite) TestConditionalReadPatient(c *C) {
+	res, err := http.Post(s.Server.URL+"/Patient", "application/json", strings.NewReader(`{"resourceType": "Patient", "gender": "male"}`))
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, 201)
+	id := resourceIdFromLocation(res)
+	lastModified := res.Header.Get("Last-Modified")
+	c.Assert(lastModified, Not(Equals), "")
+
+	conditionalRead := func(path string, headers map[string]string) *http.Response {
+		req, err := http.NewRequest("GET", s.Server.URL+"/Patient/"+id+path, nil)
+		util.CheckErr(err)
+		for header, value := range headers {
+			req.Header.Add(header, value)
+		}
+		res, err := http.DefaultClient.Do(req)
+		util.CheckErr(err)
+		return res
+	}
+
+	// The client's version is current
+	for _, ifNoneMatch := range []string{`W/"1"`, `"1"`, `W/"0", W/"1"`, `*`} {
+		res = conditionalRead("", map[string]string{"If-None-Match": ifNoneMatch})
+		c.Assert(res.StatusCode, Equals, 304, Commentf(ifNoneMatch))
+		c.Assert(res.Header.Get("ETag"), Equals, `W/"1"`)
+		body, err := ioutil.ReadAll(res.Body)
+		util.CheckErr(err)
+		c.Assert(body, HasLen, 0)
+	}
+	res = conditionalRead("", map[string]string{"If-Modified-Since": lastModified})
+	c.Assert(res.StatusCode, Equals, 304)
+	c.Assert(res.Header.Get("Last-Modified"), Equals, lastModified)
+	res = conditionalRead("/_history/1", map[string]string{"If-None-Match": `W/"1"`})
+	c.Assert(res.StatusCode, Equals, 304)
+
+	// The client's version isn't current
+	res = conditionalRead("", map[string]string{"If-None-Match": `W/"2"`})
+	c.Assert(res.StatusCode, Equals, 200)
+	patient := models.Patient{}
+	util.CheckErr(json.NewDecoder(res.Body).Decode(&patient))
+	c.Assert(patient.Gender, Equals, "male")
+
+	anHourBefore := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	res = conditionalRead("", map[string]string{"If-Modified-Since": anHourBefore})
+	c.Assert(res.StatusCode, Equals, 200)
+
+	// If-Modified-Since is ignored if there's an If-None-Match
+	res = conditionalRead("", map[string]string{"If-None-Match": `W/"2"`, "If-Modified-Since": lastModified})
+	c.Assert(res.StatusCode, Equals, 200)
+}
+
 func (s *ServerSuite) TestConditionalDelete(c *C) {
 
-	// Add 39 more patients (with total 32 male and 8 female)
+	// Add 139 more patients (with total 112 male and 28 female), more than a page of matches
 	patientCollection := s.DB().C("patients")
-	for i := 0; i < 39; i++ {
+	for i := 0; i < 139; i++ {
 		fix := loadFixture("Patient", "../fixtures/patient-example-a.json")
 		patient := fix.(*models.Patient)
 		patient.Id = bson.NewObjectId().Hex()
@@ -1043,20 +1941,75 @@ func (s *ServerSuite) TestConditionalDelete(c *C) {
 		util.CheckErr(err)
 	}
 
-	// First make sure there are really 40 patients
+	// First make sure there are really 140 patients
 	count, err := patientCollection.Count()
-	c.Assert(count, Equals, 40)
+	c.Assert(count, Equals, 140)
 
 	req, err := http.NewRequest("DELETE", s.Server.URL+"/Patient?gender=male", nil)
 	util.CheckErr(err)
 	res, err := http.DefaultClient.Do(req)
 	util.CheckErr(err)
 
-	c.Assert(res.StatusCode, Equals, 204)
+	c.Assert(res.StatusCode, Equals, 200)
+	outcome := &models.OperationOutcome{}
+	util.CheckErr(json.NewDecoder(res.Body).Decode(outcome))
+	c.Assert(outcome.Issue[0].Diagnostics, Equals, "Deleted 112 Patient resource(s)")
 
-	// Only the 8 females should be left
+	// Only the 28 females should be left
+	count, err = patientCollection.Count()
+	c.Assert(count, Equals, 28)
+
+	// Search criteria are required
+	req, err = http.NewRequest("DELETE", s.Server.URL+"/Patient", nil)
+	util.CheckErr(err)
+	res, err = http.DefaultClient.Do(req)
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, 400)
 	count, err = patientCollection.Count()
-	c.Assert(count, Equals, 8)
+	c.Assert(count, Equals, 28)
+}
+
+func (s *ServerSuite) TestConditionalDeleteSingleMatch(c *C) {
+	config := DefaultConfig
+	config.ConditionalDeleteMultiple = false
+	engine := gin.New()
+	RegisterRoutes(engine, make(map[string][]gin.HandlerFunc), NewMongoDataAccessLayer(s.client, s.dbname, true, "_fhir", nil, config), config)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	patientCollection := s.DB().C("patients")
+	fix := loadFixture("Patient", "../fixtures/patient-example-a.json")
+	patient := fix.(*models.Patient)
+	patient.Id = bson.NewObjectId().Hex()
+	util.CheckErr(patientCollection.Insert(patient))
+
+	doDelete := func(query string) *http.Response {
+		req, err := http.NewRequest("DELETE", server.URL+"/Patient?"+query, nil)
+		util.CheckErr(err)
+		res, err := http.DefaultClient.Do(req)
+		util.CheckErr(err)
+		return res
+	}
+
+	// Nothing is deleted if several resources match
+	res := doDelete("gender=male")
+	c.Assert(res.StatusCode, Equals, 412)
+	outcome := &models.OperationOutcome{}
+	util.CheckErr(json.NewDecoder(res.Body).Decode(outcome))
+	c.Assert(outcome.Issue[0].Code, Equals, "multiple-matches")
+	count, err := patientCollection.Count()
+	util.CheckErr(err)
+	c.Assert(count, Equals, 2)
+
+	res = doDelete("_id=" + patient.Id)
+	c.Assert(res.StatusCode, Equals, 200)
+	count, err = patientCollection.Count()
+	util.CheckErr(err)
+	c.Assert(count, Equals, 1)
+
+	// No matches isn't an error
+	res = doDelete("_id=" + patient.Id)
+	c.Assert(res.StatusCode, Equals, 200)
 }
 
 func (s *ServerSuite) TestUnescapedLinksInJSONResponse(c *C) {
@@ -1173,6 +2126,192 @@ func (s *ServerSuite) TestSummaryCount(c *C) {
 	c.Assert(self.Url, Equals, s.Server.URL+"/Patient?_summary=count")
 }
 
+func (s *ServerSuite) TestSearchHandling(c *C) {
+	doSearch := func(prefer string) *http.Response {
+		req, err := http.NewRequest("GET", s.Server.URL+"/Patient?gender=male&foo=bar", nil)
+		util.CheckErr(err)
+		req.Header.Add("Prefer", prefer)
+		res, err := http.DefaultClient.Do(req)
+		util.CheckErr(err)
+		return res
+	}
+
+	// Strict handling rejects unknown parameters
+	res := doSearch("handling=strict")
+	defer res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusBadRequest)
+	outcome := &models.OperationOutcome{}
+	util.CheckErr(json.NewDecoder(res.Body).Decode(outcome))
+	c.Assert(outcome.Issue, HasLen, 1)
+	c.Assert(outcome.Issue[0].Details.Text, Equals, "Parameter \"foo\" not understood")
+
+	// Lenient handling ignores them, so they aren't in the self link
+	res = doSearch("respond-async, handling=lenient")
+	defer res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	bundle := &models.Bundle{}
+	util.CheckErr(json.NewDecoder(res.Body).Decode(bundle))
+	c.Assert(bundle.Entry, HasLen, 1)
+	c.Assert(bundle.Link[0].Relation, Equals, "self")
+	c.Assert(strings.Contains(bundle.Link[0].Url, "foo"), Equals, false)
+}
+
+func (s *ServerSuite) TestPreferReturn(c *C) {
+	doRequest := func(method, path, prefer, body string) *http.Response {
+		req, err := http.NewRequest(method, s.Server.URL+"/Patient"+path, strings.NewReader(body))
+		util.CheckErr(err)
+		req.Header.Add("Content-Type", "application/json")
+		if prefer != "" {
+			req.Header.Add("Prefer", prefer)
+		}
+		res, err := http.DefaultClient.Do(req)
+		util.CheckErr(err)
+		return res
+	}
+	readBody := func(res *http.Response) []byte {
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		util.CheckErr(err)
+		return body
+	}
+
+	// The stored resource is returned by default
+	for _, prefer := range []string{"", "return=representation"} {
+		res := doRequest("POST", "", prefer, `{"resourceType": "Patient", "gender": "male"}`)
+		c.Assert(res.StatusCode, Equals, 201)
+		patient := models.Patient{}
+		util.CheckErr(json.Unmarshal(readBody(res), &patient))
+		c.Assert(patient.Gender, Equals, "male")
+		c.Assert(patient.Meta.VersionId, Equals, "1")
+	}
+
+	// Minimal responses have no body, just the headers
+	res := doRequest("POST", "", "return=minimal", `{"resourceType": "Patient", "gender": "male"}`)
+	c.Assert(res.StatusCode, Equals, 201)
+	c.Assert(res.Header.Get("Location"), Not(Equals), "")
+	c.Assert(readBody(res), HasLen, 0)
+	id := resourceIdFromLocation(res)
+
+	res = doRequest("PUT", "/"+id, "return=minimal", `{"resourceType": "Patient", "id": "`+id+`", "gender": "female"}`)
+	c.Assert(res.StatusCode, Equals, 200)
+	c.Assert(res.Header.Get("ETag"), Equals, `W/"2"`)
+	c.Assert(readBody(res), HasLen, 0)
+
+	// Or an OperationOutcome
+	res = doRequest("PUT", "?_id="+id, "return=OperationOutcome", `{"resourceType": "Patient", "id": "`+id+`", "gender": "other"}`)
+	c.Assert(res.StatusCode, Equals, 200)
+	outcome := &models.OperationOutcome{}
+	util.CheckErr(json.Unmarshal(readBody(res), outcome))
+	c.Assert(outcome.Issue[0].Severity, Equals, "information")
+	c.Assert(outcome.Issue[0].Details.Text, Equals, "Stored Patient/"+id+" version 3")
+
+	req, err := http.NewRequest("PATCH", s.Server.URL+"/Patient/"+id, strings.NewReader(`[{"op": "replace", "path": "/gender", "value": "male"}]`))
+	util.CheckErr(err)
+	req.Header.Add("Content-Type", "application/json-patch+json")
+	req.Header.Add("Prefer", "return=minimal")
+	res, err = http.DefaultClient.Do(req)
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, 200)
+	c.Assert(readBody(res), HasLen, 0)
+
+	patient := models.Patient{}
+	util.CheckErr(s.DB().C("patients").FindId(id).One(&patient))
+	c.Assert(patient.Gender, Equals, "male")
+}
+
+func (s *ServerSuite) TestPostSearch(c *C) {
+	doSearch := func(query, contentType, body string) *http.Response {
+		res, err := http.Post(s.Server.URL+"/Patient/_search"+query, contentType, strings.NewReader(body))
+		util.CheckErr(err)
+		return res
+	}
+	decodeBundle := func(res *http.Response) *models.Bundle {
+		defer res.Body.Close()
+		c.Assert(res.StatusCode, Equals, http.StatusOK)
+		bundle := &models.Bundle{}
+		util.CheckErr(json.NewDecoder(res.Body).Decode(bundle))
+		return bundle
+	}
+
+	bundle := decodeBundle(doSearch("", "application/x-www-form-urlencoded", "gender=male"))
+	c.Assert(bundle.Entry, HasLen, 1)
+	bundle = decodeBundle(doSearch("", "application/x-www-form-urlencoded", "gender=female"))
+	c.Assert(bundle.Entry, HasLen, 0)
+
+	// The parameters in the URL and the body are combined
+	bundle = decodeBundle(doSearch("?gender=male", "application/x-www-form-urlencoded", "_id="+s.FixtureID))
+	c.Assert(bundle.Entry, HasLen, 1)
+	c.Assert(bundle.Link[0].Relation, Equals, "self")
+	c.Assert(strings.Contains(bundle.Link[0].Url, "gender=male"), Equals, true)
+	c.Assert(strings.Contains(bundle.Link[0].Url, "_id="+s.FixtureID), Equals, true)
+	bundle = decodeBundle(doSearch("?gender=female", "application/x-www-form-urlencoded", "_id="+s.FixtureID))
+	c.Assert(bundle.Entry, HasLen, 0)
+
+	// Without a body only the URL's parameters are used
+	bundle = decodeBundle(doSearch("?gender=male", "", ""))
+	c.Assert(bundle.Entry, HasLen, 1)
+
+	// Parameters can only be POSTed as a form
+	res := doSearch("", "application/json", `{"gender": "male"}`)
+	defer res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusUnsupportedMediaType)
+}
+
+func (s *ServerSuite) TestSystemSearch(c *C) {
+	b := assertBundleCount(c, s.Server.URL+"/?_type=Patient,Observation&_id="+s.FixtureID, 1, 1)
+	c.Assert(b.Entry[0].FullUrl, Equals, s.Server.URL+"/Patient/"+s.FixtureID)
+	c.Assert(b.Link[0].Relation, Equals, "self")
+	c.Assert(strings.HasPrefix(b.Link[0].Url, s.Server.URL+"/?"), Equals, true)
+	c.Assert(strings.Contains(b.Link[0].Url, "_type=Patient%2CObservation"), Equals, true)
+
+	// Searches can also be POSTed
+	res, err := http.Post(s.Server.URL+"/_search", "application/x-www-form-urlencoded", strings.NewReader("_type=Patient&_id="+s.FixtureID))
+	util.CheckErr(err)
+	defer res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	bundle := &models.Bundle{}
+	util.CheckErr(json.NewDecoder(res.Body).Decode(bundle))
+	c.Assert(bundle.Entry, HasLen, 1)
+
+	// Search parameters must be supported by every type
+	res, err = http.Get(s.Server.URL + "/?_type=Patient,Observation&gender=male")
+	util.CheckErr(err)
+	defer res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusBadRequest)
+}
+
+func (s *ServerSuite) TestCompartmentSearch(c *C) {
+	b := assertBundleCount(c, s.Server.URL+"/Patient/"+s.FixtureID+"/Patient", 1, 1)
+	c.Assert(b.Entry[0].FullUrl, Equals, s.Server.URL+"/Patient/"+s.FixtureID)
+	// The paging links search with the _compartment parameter
+	c.Assert(strings.HasPrefix(b.Link[0].Url, s.Server.URL+"/Patient?_compartment=Patient%2F"+s.FixtureID), Equals, true)
+	assertBundleCount(c, s.Server.URL+"/Patient/"+s.FixtureID+"/Patient?gender=female", 0, 0)
+	assertBundleCount(c, s.Server.URL+"/Patient/"+s.FixtureID+"/Observation", 0, 0)
+
+	b = assertBundleCount(c, s.Server.URL+"/Patient/"+s.FixtureID+"/*", 1, 1)
+	c.Assert(b.Entry[0].FullUrl, Equals, s.Server.URL+"/Patient/"+s.FixtureID)
+
+	// Resources that can't be in the compartment have no route
+	res, err := http.Get(s.Server.URL + "/Patient/" + s.FixtureID + "/Organization")
+	util.CheckErr(err)
+	defer res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusNotFound)
+}
+
+func (s *ServerSuite) TestElements(c *C) {
+	b := assertBundleCount(c, s.Server.URL+"/Patient?_elements=gender", 1, 1)
+	c.Assert(b.Entry[0].Resource, FitsTypeOf, &models.Patient{})
+	patient := b.Entry[0].Resource.(*models.Patient)
+	c.Assert(patient.Gender, Equals, "male")
+	c.Assert(patient.Name, HasLen, 0)
+	c.Assert(patient.Identifier, HasLen, 0)
+
+	// The incomplete resource is tagged as SUBSETTED
+	c.Assert(patient.Meta.Tag, HasLen, 1)
+	c.Assert(patient.Meta.Tag[0].System, Equals, "http://hl7.org/fhir/v3/ObservationValue")
+	c.Assert(patient.Meta.Tag[0].Code, Equals, "SUBSETTED")
+}
+
 func (s *ServerSuite) TestPatientEverything(c *C) {
 
 	data, err := os.Open("../fixtures/patient-example-d.json")
@@ -1207,6 +2346,231 @@ func (s *ServerSuite) TestPatientEverything(c *C) {
 	c.Assert(self.Url, Equals, s.Server.URL+"/Patient?_id="+createdPatientID+"&_include=*&_revinclude=*")
 }
 
+func (s *ServerSuite) TestEncounterEverything(c *C) {
+	create := func(resourceType, body string) string {
+		res, err := http.Post(s.Server.URL+"/"+resourceType, "application/json", strings.NewReader(body))
+		util.CheckErr(err)
+		c.Assert(res.StatusCode, Equals, 201)
+		return resourceIdFromLocation(res)
+	}
+	patientID := create("Patient", `{"resourceType": "Patient"}`)
+	encounterID := create("Encounter", `{"resourceType": "Encounter", "status": "finished", "subject": {"reference": "Patient/`+patientID+`"}}`)
+	observationID := create("Observation", `{"resourceType": "Observation", "status": "final", "code": {"text": "weight"},
+		"subject": {"reference": "Patient/`+patientID+`"}, "context": {"reference": "Encounter/`+encounterID+`"}}`)
+	conditionID := create("Condition", `{"resourceType": "Condition", "subject": {"reference": "Patient/`+patientID+`"},
+		"context": {"reference": "Encounter/`+encounterID+`"}}`)
+	create("Observation", `{"resourceType": "Observation", "status": "final", "code": {"text": "height"}, "subject": {"reference": "Patient/`+patientID+`"}}`)
+
+	// The encounter is returned with its patient and the resources referring to it, but not the patient's others
+	bundle := performSearch(c, s.Server.URL+"/Encounter/"+encounterID+"/$everything")
+	c.Assert(*bundle.Total, Equals, uint32(1))
+	var found []string
+	for _, entry := range bundle.Entry {
+		found = append(found, resourceIdFromLocationStr(entry.FullUrl))
+	}
+	c.Assert(found, HasLen, 4)
+	c.Assert(found[0], Equals, encounterID)
+	sort.Strings(found[1:])
+	expected := []string{patientID, observationID, conditionID}
+	sort.Strings(expected)
+	c.Assert(found[1:], DeepEquals, expected)
+
+	res, err := http.Get(s.Server.URL + "/Encounter/" + bson.NewObjectId().Hex() + "/$everything")
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, 404)
+}
+
+func (s *ServerSuite) TestCompositionDocument(c *C) {
+	create := func(resourceType, body string) string {
+		res, err := http.Post(s.Server.URL+"/"+resourceType, "application/json", strings.NewReader(body))
+		util.CheckErr(err)
+		c.Assert(res.StatusCode, Equals, 201)
+		return resourceIdFromLocation(res)
+	}
+	organizationID := create("Organization", `{"resourceType": "Organization", "name": "Hospital"}`)
+	patientID := create("Patient", `{"resourceType": "Patient", "managingOrganization": {"reference": "Organization/`+organizationID+`"}}`)
+	practitionerID := create("Practitioner", `{"resourceType": "Practitioner"}`)
+	observationID := create("Observation", `{"resourceType": "Observation", "status": "final", "code": {"text": "weight"},
+		"subject": {"reference": "Patient/`+patientID+`"}, "performer": [{"reference": "Practitioner/`+practitionerID+`"}]}`)
+	compositionID := create("Composition", `{"resourceType": "Composition", "status": "final", "type": {"text": "Discharge summary"},
+		"date": "2019-06-01", "title": "Discharge summary",
+		"subject": {"reference": "Patient/`+patientID+`"}, "author": [{"reference": "Practitioner/`+practitionerID+`"}],
+		"encounter": {"reference": "Encounter/`+bson.NewObjectId().Hex()+`"},
+		"section": [{"title": "Results", "entry": [{"reference": "Observation/`+observationID+`"}]}]}`)
+
+	// The document has the composition first, and each resource it refers to (directly or not) that exists once
+	res, err := http.Get(s.Server.URL + "/Composition/" + compositionID + "/$document")
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, 200)
+	bundle := &models.Bundle{}
+	util.CheckErr(json.NewDecoder(res.Body).Decode(bundle))
+	c.Assert(bundle.Type, Equals, "document")
+	c.Assert(bundle.Identifier, NotNil)
+	c.Assert(bundle.Identifier.System, Equals, "urn:ietf:rfc:3986")
+	var found []string
+	for _, entry := range bundle.Entry {
+		found = append(found, resourceIdFromLocationStr(entry.FullUrl))
+	}
+	c.Assert(found, HasLen, 5)
+	c.Assert(found[0], Equals, compositionID)
+	c.Assert(bundle.Entry[0].FullUrl, Equals, s.Server.URL+"/Composition/"+compositionID)
+	sort.Strings(found[1:])
+	expected := []string{organizationID, patientID, practitionerID, observationID}
+	sort.Strings(expected)
+	c.Assert(found[1:], DeepEquals, expected)
+
+	// With persist=true it's stored as a Bundle
+	res, err = http.Post(s.Server.URL+"/Composition/"+compositionID+"/$document", "application/json",
+		strings.NewReader(`{"resourceType": "Parameters", "parameter": [{"name": "persist", "valueBoolean": true}]}`))
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, 200)
+	location := res.Header.Get("Location")
+	c.Assert(strings.HasPrefix(location, s.Server.URL+"/Bundle/"), Equals, true)
+	res, err = http.Get(location)
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, 200)
+	bundle = &models.Bundle{}
+	util.CheckErr(json.NewDecoder(res.Body).Decode(bundle))
+	c.Assert(bundle.Type, Equals, "document")
+	c.Assert(bundle.Entry, HasLen, 5)
+
+	res, err = http.Get(s.Server.URL + "/Composition/" + compositionID + "/$document?persist=maybe")
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, 400)
+	res, err = http.Get(s.Server.URL + "/Composition/" + bson.NewObjectId().Hex() + "/$document")
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, 404)
+}
+
+func (s *ServerSuite) TestBulkExport(c *C) {
+	config := DefaultConfig
+	config.EnableBulkExport = true
+	config.BulkExportDestination = c.MkDir()
+	engine := gin.New()
+	RegisterRoutes(engine, make(map[string][]gin.HandlerFunc), NewMongoDataAccessLayer(s.client, s.dbname, true, "_fhir", nil, config), config)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	doRequest := func(method, path string, prefer bool) *http.Response {
+		if !strings.HasPrefix(path, "http") {
+			path = server.URL + path
+		}
+		req, err := http.NewRequest(method, path, nil)
+		util.CheckErr(err)
+		req.Header.Set("Accept", "application/fhir+json")
+		if prefer {
+			req.Header.Set("Prefer", "respond-async")
+		}
+		res, err := http.DefaultClient.Do(req)
+		util.CheckErr(err)
+		return res
+	}
+	create := func(resourceType, body string) string {
+		res, err := http.Post(server.URL+"/"+resourceType, "application/json", strings.NewReader(body))
+		util.CheckErr(err)
+		c.Assert(res.StatusCode, Equals, 201)
+		return resourceIdFromLocation(res)
+	}
+	// export waits for an export to complete, returning the counts of the files in its manifest and their contents
+	export := func(path string) (counts map[string]int, files map[string]string) {
+		res := doRequest("GET", path, true)
+		c.Assert(res.StatusCode, Equals, 202)
+		statusURL := res.Header.Get("Content-Location")
+		c.Assert(statusURL, Matches, server.URL+`/\$export-status/.+`)
+
+		deadline := time.Now().Add(30 * time.Second)
+		for {
+			res = doRequest("GET", statusURL, false)
+			if res.StatusCode != 202 {
+				break
+			}
+			c.Assert(res.Header.Get("Retry-After"), Not(Equals), "")
+			c.Assert(time.Now().Before(deadline), Equals, true, Commentf("export didn't complete"))
+			time.Sleep(50 * time.Millisecond)
+		}
+		c.Assert(res.StatusCode, Equals, 200)
+		var manifest exportManifest
+		util.CheckErr(json.NewDecoder(res.Body).Decode(&manifest))
+		c.Assert(manifest.Request, Equals, server.URL+path)
+		c.Assert(manifest.RequiresAccessToken, Equals, len(manifest.Output) > 0)
+
+		counts = make(map[string]int)
+		files = make(map[string]string)
+		for _, output := range manifest.Output {
+			counts[output.Type] = output.Count
+			res := doRequest("GET", output.URL, false)
+			c.Assert(res.StatusCode, Equals, 200)
+			c.Assert(res.Header.Get("Content-Type"), Equals, "application/fhir+ndjson")
+			body, err := ioutil.ReadAll(res.Body)
+			util.CheckErr(err)
+			files[output.Type] = string(body)
+		}
+		return counts, files
+	}
+
+	patientID := create("Patient", `{"resourceType": "Patient", "name": [{"family": "Export"}]}`)
+	otherPatientID := create("Patient", `{"resourceType": "Patient"}`)
+	observationID := create("Observation", `{"resourceType": "Observation", "status": "final", "code": {"text": "weight"},
+		"subject": {"reference": "Patient/`+patientID+`"}}`)
+	otherObservationID := create("Observation", `{"resourceType": "Observation", "status": "preliminary", "code": {"text": "weight"},
+		"subject": {"reference": "Patient/`+otherPatientID+`"}}`)
+	groupID := create("Group", `{"resourceType": "Group", "type": "person", "actual": true,
+		"member": [{"entity": {"reference": "Patient/`+patientID+`"}}]}`)
+
+	// Exports must be asynchronous, and of types that can be exported
+	c.Assert(doRequest("GET", "/$export", false).StatusCode, Equals, 400)
+	c.Assert(doRequest("GET", "/$export?_type=Unknown", true).StatusCode, Equals, 400)
+	c.Assert(doRequest("GET", "/Group/"+groupID+"/$export?_type=Organization", true).StatusCode, Equals, 400)
+	c.Assert(doRequest("GET", "/Group/"+bson.NewObjectId().Hex()+"/$export", true).StatusCode, Equals, 404)
+	c.Assert(doRequest("GET", "/$export-status/unknown", false).StatusCode, Equals, 404)
+
+	// A group's export only has the resources in the compartments of its patients, with one per line
+	counts, files := export("/Group/" + groupID + "/$export?_type=Patient,Observation")
+	c.Assert(counts, DeepEquals, map[string]int{"Patient": 1, "Observation": 1})
+	var observation struct {
+		Id string `json:"id"`
+	}
+	util.CheckErr(json.Unmarshal([]byte(strings.TrimSuffix(files["Observation"], "\n")), &observation))
+	c.Assert(observation.Id, Equals, observationID)
+	c.Assert(strings.Count(files["Patient"], "\n"), Equals, 1)
+	c.Assert(files["Patient"], Matches, `\{.*"id":"`+patientID+`".*\}\n`)
+
+	// Patient exports have the resources of every patient
+	counts, _ = export("/Patient/$export?_type=Observation")
+	c.Assert(counts, DeepEquals, map[string]int{"Observation": 2})
+
+	// The resources of each type must match any of its _typeFilter searches
+	typeFilters := url.QueryEscape("Observation?status=final&subject=Patient/"+patientID) + "," +
+		url.QueryEscape("Observation?status=preliminary&subject=Patient/"+otherPatientID)
+	counts, files = export("/$export?_type=Observation,Patient&_typeFilter=" + typeFilters)
+	c.Assert(counts["Observation"], Equals, 2)
+	c.Assert(files["Observation"], Matches, `(?s).*"id":"`+otherObservationID+`".*`)
+	c.Assert(counts["Patient"] >= 2, Equals, true)
+	counts, _ = export("/$export?_type=Observation&_typeFilter=" + url.QueryEscape("Observation?status=final&subject=Patient/"+otherPatientID))
+	c.Assert(counts, DeepEquals, map[string]int{})
+	c.Assert(doRequest("GET", "/$export?_typeFilter="+url.QueryEscape("Patient?unknown=1"), true).StatusCode, Equals, 501)
+
+	// Only resources updated after _since are exported
+	counts, _ = export("/$export?_type=Observation&_since=" + url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339)))
+	c.Assert(counts, DeepEquals, map[string]int{})
+
+	// Deleting an export deletes its files
+	res := doRequest("GET", "/$export?_type=Patient", true)
+	c.Assert(res.StatusCode, Equals, 202)
+	statusURL := res.Header.Get("Content-Location")
+	for doRequest("GET", statusURL, false).StatusCode == 202 {
+		time.Sleep(50 * time.Millisecond)
+	}
+	jobID := path.Base(statusURL)
+	_, err := os.Stat(config.BulkExportDestination + "/" + jobID + "/Patient.ndjson")
+	c.Assert(err, IsNil)
+	c.Assert(doRequest("DELETE", statusURL, false).StatusCode, Equals, 202)
+	c.Assert(doRequest("GET", statusURL, false).StatusCode, Equals, 404)
+	c.Assert(doRequest("GET", "/$export-file/"+jobID+"/Patient.ndjson", false).StatusCode, Equals, 404)
+	_, err = os.Stat(config.BulkExportDestination + "/" + jobID)
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
 func performSearch(c *C, url string) *models.Bundle {
 	res, err := http.Get(url)
 	util.CheckErr(err)
@@ -1304,3 +2668,728 @@ func logBody(res *http.Response) io.Reader {
 	// fmt.Printf("[logBody] %d bytes: %s\n", len(bodyBytes), string(bodyBytes))
 	return bytes.NewReader(bodyBytes)
 }
+
+func (s *ServerSuite) TestBulkImport(c *C) {
+	config := DefaultConfig
+	config.EnableBulkImport = true
+	engine := gin.New()
+	RegisterRoutes(engine, make(map[string][]gin.HandlerFunc), NewMongoDataAccessLayer(s.client, s.dbname, true, "_fhir", nil, config), config)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	patientID := bson.NewObjectId().Hex()
+	observationID := bson.NewObjectId().Hex()
+	files := map[string]string{
+		"/Patient.ndjson": `{"resourceType": "Patient", "id": "` + patientID + `", "name": [{"family": "Import"}]}
+{"resourceType": "Patient", "name": [{"family": "Import"}]}
+
+{"resourceType": "Patient", "id": "` + patientID + `"}
+{"resourceType": "Observation", "status": "final", "code": {"text": "weight"}}
+{"resourceType": "Patient", "id": "not-an-object-id"}
+not json
+`,
+		"/Observation.ndjson": `{"resourceType": "Observation", "id": "` + observationID + `", "status": "final", "code": {"text": "weight"}, "subject": {"reference": "Patient/` + patientID + `"}}`,
+	}
+	fileServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if content, found := files[r.URL.Path]; found {
+			w.Header().Set("Content-Type", "application/fhir+ndjson")
+			io.WriteString(w, content)
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer fileServer.Close()
+
+	startImport := func(parameters string) *http.Response {
+		res, err := http.Post(server.URL+"/$import", "application/fhir+json", strings.NewReader(parameters))
+		util.CheckErr(err)
+		return res
+	}
+	input := func(resourceType, path string) string {
+		return `{"name": "input", "part": [{"name": "type", "valueCode": "` + resourceType + `"}, {"name": "url", "valueUri": "` + fileServer.URL + path + `"}]}`
+	}
+
+	c.Assert(startImport(`{"resourceType": "Parameters", "parameter": []}`).StatusCode, Equals, 400)
+	c.Assert(startImport(`{"resourceType": "Parameters", "parameter": [{"name": "inputFormat", "valueCode": "text/csv"}, `+input("Patient", "/Patient.ndjson")+`]}`).StatusCode, Equals, 400)
+	c.Assert(startImport(`{"resourceType": "Patient"}`).StatusCode, Equals, 400)
+
+	res := startImport(`{"resourceType": "Parameters", "parameter": [{"name": "inputFormat", "valueCode": "application/fhir+ndjson"}, ` +
+		input("Patient", "/Patient.ndjson") + `, ` + input("Observation", "/Observation.ndjson") + `, ` + input("Encounter", "/Encounter.ndjson") + `]}`)
+	c.Assert(res.StatusCode, Equals, 202)
+	statusURL := res.Header.Get("Content-Location")
+	c.Assert(statusURL, Matches, server.URL+`/\$import-status/.+`)
+
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		res, err := http.Get(statusURL)
+		util.CheckErr(err)
+		if res.StatusCode != 202 {
+			break
+		}
+		c.Assert(res.Header.Get("Retry-After"), Not(Equals), "")
+		c.Assert(time.Now().Before(deadline), Equals, true, Commentf("import didn't complete"))
+		time.Sleep(50 * time.Millisecond)
+	}
+	res, err := http.Get(statusURL)
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, 200)
+	var manifest importManifest
+	util.CheckErr(json.NewDecoder(res.Body).Decode(&manifest))
+
+	// Each file's resources are counted, and the errors of those that weren't imported are listed by line
+	counts := make(map[string]int)
+	for _, output := range manifest.Output {
+		counts[output.Type] = output.Count
+	}
+	c.Assert(counts, DeepEquals, map[string]int{"Patient": 2, "Observation": 1, "Encounter": 0})
+	c.Assert(manifest.Error, HasLen, 2)
+	c.Assert(manifest.Error[0].Type, Equals, "Patient")
+	c.Assert(manifest.Error[0].Count, Equals, 4)
+	c.Assert(manifest.Error[0].Errors, HasLen, 4)
+	// (the errors of lines that can't be read come before those of resources that can't be inserted)
+	c.Assert(manifest.Error[0].Errors[0], Equals, "line 5: expected a Patient resource but got a Observation")
+	c.Assert(manifest.Error[0].Errors[1], Matches, "line 7: .*")
+	c.Assert(manifest.Error[0].Errors[2], Matches, "line 4: failed to insert Patient/"+patientID+": .*duplicate key.*")
+	c.Assert(manifest.Error[0].Errors[3], Matches, "line 6: .*ObjectId.*")
+	c.Assert(manifest.Error[1].Type, Equals, "Encounter")
+	c.Assert(manifest.Error[1].Errors, DeepEquals, []string{"failed to download the file: 404 Not Found"})
+
+	// Resources keep their IDs, so references between them are kept too
+	res, err = http.Get(server.URL + "/Observation?subject=Patient/" + patientID)
+	util.CheckErr(err)
+	bundle := &models.Bundle{}
+	util.CheckErr(json.NewDecoder(res.Body).Decode(bundle))
+	c.Assert(bundle.Entry, HasLen, 1)
+	c.Assert(resourceIdFromLocationStr(bundle.Entry[0].FullUrl), Equals, observationID)
+
+	req, err := http.NewRequest("DELETE", statusURL, nil)
+	util.CheckErr(err)
+	res, err = http.DefaultClient.Do(req)
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, 202)
+	res, err = http.Get(statusURL)
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, 404)
+}
+
+func (s *ServerSuite) TestAsyncRequests(c *C) {
+	config := DefaultConfig
+	config.EnableAsync = true
+	engine := gin.New()
+	RegisterRoutes(engine, make(map[string][]gin.HandlerFunc), NewMongoDataAccessLayer(s.client, s.dbname, true, "_fhir", nil, config), config)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	family := "Async" + bson.NewObjectId().Hex()
+	res, err := http.Post(server.URL+"/Patient", "application/fhir+json", strings.NewReader(`{"resourceType": "Patient", "name": [{"family": "`+family+`"}]}`))
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, 201)
+	patientID := resourceIdFromLocationStr(res.Header.Get("Location"))
+
+	request := func(method, url, contentType, body, prefer string) *http.Response {
+		req, err := http.NewRequest(method, url, strings.NewReader(body))
+		util.CheckErr(err)
+		req.Header.Set("Prefer", prefer)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		res, err := http.DefaultClient.Do(req)
+		util.CheckErr(err)
+		return res
+	}
+	// polls for the response of an asynchronous request, returning its entry in the batch-response Bundle
+	response := func(statusURL string) models.BundleEntryComponent {
+		deadline := time.Now().Add(30 * time.Second)
+		for {
+			res, err := http.Get(statusURL)
+			util.CheckErr(err)
+			if res.StatusCode != 202 {
+				c.Assert(res.StatusCode, Equals, 200)
+				bundle := &models.Bundle{}
+				util.CheckErr(json.NewDecoder(res.Body).Decode(bundle))
+				c.Assert(bundle.Type, Equals, "batch-response")
+				c.Assert(bundle.Entry, HasLen, 1)
+				return bundle.Entry[0]
+			}
+			c.Assert(res.Header.Get("Retry-After"), Not(Equals), "")
+			c.Assert(time.Now().Before(deadline), Equals, true, Commentf("request didn't complete"))
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	// Searches are queued, and their responses are polled for
+	res = request("GET", server.URL+"/Patient?family="+family, "", "", "respond-async")
+	c.Assert(res.StatusCode, Equals, 202)
+	statusURL := res.Header.Get("Content-Location")
+	c.Assert(statusURL, Matches, server.URL+`/\$async-status/.+`)
+	entry := response(statusURL)
+	c.Assert(entry.Response.Status, Equals, "200")
+	searchset, ok := entry.Resource.(*models.Bundle)
+	c.Assert(ok, Equals, true)
+	c.Assert(searchset.Entry, HasLen, 1)
+	c.Assert(resourceIdFromLocationStr(searchset.Entry[0].FullUrl), Equals, patientID)
+
+	// So are searches with POST, with their bodies
+	res = request("POST", server.URL+"/Patient/_search", "application/x-www-form-urlencoded", "family="+family, "respond-async")
+	c.Assert(res.StatusCode, Equals, 202)
+	entry = response(res.Header.Get("Content-Location"))
+	c.Assert(entry.Response.Status, Equals, "200")
+	searchset, ok = entry.Resource.(*models.Bundle)
+	c.Assert(ok, Equals, true)
+	c.Assert(searchset.Entry, HasLen, 1)
+
+	// Requests are run with their other preferences, and their failures are returned as outcomes
+	res = request("GET", server.URL+"/Patient?family="+family+"&foo=bar", "", "", "respond-async, handling=strict")
+	c.Assert(res.StatusCode, Equals, 202)
+	entry = response(res.Header.Get("Content-Location"))
+	c.Assert(entry.Response.Status, Equals, "400")
+	c.Assert(entry.Resource, IsNil)
+	_, ok = entry.Response.Outcome.(*models.OperationOutcome)
+	c.Assert(ok, Equals, true)
+
+	// Creates aren't run asynchronously
+	res = request("POST", server.URL+"/Patient", "application/fhir+json", `{"resourceType": "Patient", "name": [{"family": "`+family+`"}]}`, "respond-async")
+	c.Assert(res.StatusCode, Equals, 201)
+
+	// Responses are deleted
+	req, err := http.NewRequest("DELETE", statusURL, nil)
+	util.CheckErr(err)
+	res, err = http.DefaultClient.Do(req)
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, 202)
+	res, err = http.Get(statusURL)
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, 404)
+	res, err = http.DefaultClient.Do(req)
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, 404)
+}
+
+func (s *ServerSuite) TestAsyncRequestsOfClients(c *C) {
+	config := DefaultConfig
+	config.EnableAsync = true
+	config.Auth = auth.APIKeys()
+	dal := NewMongoDataAccessLayer(s.client, s.dbname, true, "_fhir", nil, config)
+	engine := gin.New()
+	RegisterRoutes(engine, make(map[string][]gin.HandlerFunc), dal, config)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+	defer s.DB().C(APIKeysCollection).DropCollection()
+
+	session := dal.StartSession(context.Background(), "")
+	reporting, reportingKey, err := auth.NewAPIKey("reporting", true, []string{"Patient"}, false)
+	util.CheckErr(err)
+	util.CheckErr(session.CreateAPIKey(reporting))
+	other, otherKey, err := auth.NewAPIKey("other", false, nil, false)
+	util.CheckErr(err)
+	util.CheckErr(session.CreateAPIKey(other))
+	session.Finish()
+
+	request := func(method, url, key string) *http.Response {
+		req, err := http.NewRequest(method, url, nil)
+		util.CheckErr(err)
+		req.Header.Set("Prefer", "respond-async")
+		req.Header.Set(auth.APIKeyHeader, key)
+		res, err := http.DefaultClient.Do(req)
+		util.CheckErr(err)
+		return res
+	}
+	res := request("GET", server.URL+"/Patient", reportingKey)
+	c.Assert(res.StatusCode, Equals, 202)
+	statusURL := res.Header.Get("Content-Location")
+	id := path.Base(statusURL)
+
+	// The keys of requests aren't stored, as they're run with the identities they were authenticated with
+	var job AsyncJob
+	util.CheckErr(s.DB().C(AsyncJobsCollection).FindId(id).One(&job))
+	c.Assert(job.Owner, Equals, reporting.ID)
+	c.Assert(job.Request.Identity.Scopes, DeepEquals, []string{"system/Patient.read"})
+	c.Assert(job.Request.Header[auth.APIKeyHeader], IsNil)
+	// polls for the response of an asynchronous request, returning its entry in the batch-response Bundle
+	response := func(statusURL string) models.BundleEntryComponent {
+		deadline := time.Now().Add(30 * time.Second)
+		for {
+			res := request("GET", statusURL, reportingKey)
+			if res.StatusCode != 202 {
+				c.Assert(res.StatusCode, Equals, 200)
+				bundle := &models.Bundle{}
+				util.CheckErr(json.NewDecoder(res.Body).Decode(bundle))
+				c.Assert(bundle.Entry, HasLen, 1)
+				return bundle.Entry[0]
+			}
+			c.Assert(time.Now().Before(deadline), Equals, true, Commentf("request didn't complete"))
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	c.Assert(response(statusURL).Response.Status, Equals, "200")
+
+	// and they're authorized with them
+	res = request("GET", server.URL+"/Observation", reportingKey)
+	c.Assert(res.StatusCode, Equals, 202)
+	c.Assert(response(res.Header.Get("Content-Location")).Response.Status, Equals, "403")
+
+	// Other clients can't get or delete them
+	res = request("GET", statusURL, otherKey)
+	c.Assert(res.StatusCode, Equals, 404)
+	res = request("DELETE", statusURL, otherKey)
+	c.Assert(res.StatusCode, Equals, 404)
+	res = request("DELETE", statusURL, reportingKey)
+	c.Assert(res.StatusCode, Equals, 202)
+}
+
+func (s *ServerSuite) TestSubscriptions(c *C) {
+	config := DefaultConfig
+	config.EnableSubscriptions = true
+	config.SubscriptionRetries = 1
+	config.SubscriptionRetryDelay = 10 * time.Millisecond
+	engine := gin.New()
+	RegisterRoutes(engine, make(map[string][]gin.HandlerFunc), NewMongoDataAccessLayer(s.client, s.dbname, true, "_fhir", nil, config), config)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	type notification struct {
+		method, path, authorization string
+		body                        []byte
+	}
+	notifications := make(chan notification, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		notifications <- notification{r.Method, r.URL.Path, r.Header.Get("Authorization"), body}
+	}))
+	defer hook.Close()
+	var failingStatus int32 = 500
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(atomic.LoadInt32(&failingStatus)))
+	}))
+	defer failing.Close()
+
+	post := func(resourceType, body string) string {
+		res, err := http.Post(server.URL+"/"+resourceType, "application/fhir+json", strings.NewReader(body))
+		util.CheckErr(err)
+		c.Assert(res.StatusCode, Equals, 201, Commentf("creating %s", body))
+		return resourceIdFromLocationStr(res.Header.Get("Location"))
+	}
+	getSubscription := func(id string) *models.Subscription {
+		res, err := http.Get(server.URL + "/Subscription/" + id)
+		util.CheckErr(err)
+		c.Assert(res.StatusCode, Equals, 200)
+		subscription := &models.Subscription{}
+		util.CheckErr(json.NewDecoder(res.Body).Decode(subscription))
+		return subscription
+	}
+	waitForStatus := func(id, status string) *models.Subscription {
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			subscription := getSubscription(id)
+			if subscription.Status == status {
+				return subscription
+			}
+			c.Assert(time.Now().Before(deadline), Equals, true, Commentf("Subscription is %s, not %s", subscription.Status, status))
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	// Subscriptions the server can't support are rejected
+	res, err := http.Post(server.URL+"/Subscription", "application/fhir+json", strings.NewReader(`{"resourceType": "Subscription", "status": "requested",
+		"criteria": "Observation?foo=bar", "channel": {"type": "email", "endpoint": "mailto:someone@example.com"}}`))
+	util.CheckErr(err)
+	c.Assert(res.StatusCode, Equals, 422)
+	outcome := &models.OperationOutcome{}
+	util.CheckErr(json.NewDecoder(res.Body).Decode(outcome))
+	c.Assert(outcome.Issue, HasLen, 2)
+	c.Assert(outcome.Issue[0].Expression, DeepEquals, []string{"Subscription.criteria"})
+	c.Assert(outcome.Issue[1].Expression, DeepEquals, []string{"Subscription.channel.type"})
+
+	// Requested Subscriptions are activated, and notified of matching resources with their payloads and headers
+	code := bson.NewObjectId().Hex()
+	subscriptionID := post("Subscription", `{"resourceType": "Subscription", "status": "requested", "criteria": "Observation?code=http://example.com|`+code+`",
+		"channel": {"type": "rest-hook", "endpoint": "`+hook.URL+`/fhir", "payload": "application/fhir+json", "header": ["Authorization: Bearer secret"]}}`)
+	c.Assert(getSubscription(subscriptionID).Status, Equals, SubscriptionActive)
+
+	post("Observation", `{"resourceType": "Observation", "status": "final", "code": {"coding": [{"system": "http://example.com", "code": "other"}]}}`)
+	observationID := post("Observation", `{"resourceType": "Observation", "status": "final", "code": {"coding": [{"system": "http://example.com", "code": "`+code+`"}]}}`)
+	select {
+	case received := <-notifications:
+		c.Assert(received.method, Equals, "PUT")
+		c.Assert(received.path, Equals, "/fhir/Observation/"+observationID)
+		c.Assert(received.authorization, Equals, "Bearer secret")
+		observation := &models.Observation{}
+		util.CheckErr(json.Unmarshal(received.body, observation))
+		c.Assert(observation.Id, Equals, observationID)
+	case <-time.After(10 * time.Second):
+		c.Fatal("the Subscription wasn't notified")
+	}
+
+	// Subscriptions whose notifications fail (after retrying) are put in the error state until they succeed again
+	family := "Subscription" + bson.NewObjectId().Hex()
+	failingID := post("Subscription", `{"resourceType": "Subscription", "status": "active", "criteria": "Patient?family=`+family+`",
+		"channel": {"type": "rest-hook", "endpoint": "`+failing.URL+`"}}`)
+	post("Patient", `{"resourceType": "Patient", "name": [{"family": "`+family+`"}]}`)
+	subscription := waitForStatus(failingID, SubscriptionError)
+	c.Assert(subscription.Error, Matches, "Failed to notify "+failing.URL+": .*500.*")
+
+	atomic.StoreInt32(&failingStatus, 200)
+	post("Patient", `{"resourceType": "Patient", "name": [{"family": "`+family+`"}]}`)
+	subscription = waitForStatus(failingID, SubscriptionActive)
+	c.Assert(subscription.Error, Equals, "")
+	c.Assert(notifications, HasLen, 0)
+}
+
+func (s *ServerSuite) TestSubscriptionWebSockets(c *C) {
+	config := DefaultConfig
+	config.EnableSubscriptions = true
+	config.SubscriptionRetries = 0
+	config.DefaultDatabaseName = s.dbname
+	engine := gin.New()
+	RegisterRoutes(engine, make(map[string][]gin.HandlerFunc), NewMongoDataAccessLayer(s.client, s.dbname, true, "_fhir", nil, config), config)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+	websocketURL := strings.Replace(server.URL, "http", "ws", 1) + "/websocket"
+
+	post := func(resourceType, body string) string {
+		res, err := http.Post(server.URL+"/"+resourceType, "application/fhir+json", strings.NewReader(body))
+		util.CheckErr(err)
+		c.Assert(res.StatusCode, Equals, 201, Commentf("creating %s", body))
+		return resourceIdFromLocationStr(res.Header.Get("Location"))
+	}
+
+	// The URL of the WebSocket is in the CapabilityStatement
+	res, err := http.Get(server.URL + "/metadata")
+	util.CheckErr(err)
+	statement := &models.CapabilityStatement{}
+	util.CheckErr(json.NewDecoder(res.Body).Decode(statement))
+	c.Assert(statement.Rest[0].Extension, HasLen, 1)
+	c.Assert(statement.Rest[0].Extension[0].ValueUri, Equals, websocketURL)
+
+	code := bson.NewObjectId().Hex()
+	subscriptionID := post("Subscription", `{"resourceType": "Subscription", "status": "requested", "criteria": "Observation?code=http://example.com|`+code+`",
+		"channel": {"type": "websocket"}}`)
+	restHookID := post("Subscription", `{"resourceType": "Subscription", "status": "active", "criteria": "Observation?code=http://example.com|`+code+`",
+		"channel": {"type": "rest-hook", "endpoint": "http://localhost:1/hook"}}`)
+
+	conn, err := websocket.Dial(websocketURL, "", server.URL)
+	util.CheckErr(err)
+	defer conn.Close()
+	util.CheckErr(conn.SetDeadline(time.Now().Add(10 * time.Second)))
+	exchange := func(message string) string {
+		util.CheckErr(websocket.Message.Send(conn, message))
+		var response string
+		util.CheckErr(websocket.Message.Receive(conn, &response))
+		return response
+	}
+
+	// Connections are only bound to websocket Subscriptions
+	c.Assert(exchange("hello"), Equals, "error expected bind [id]")
+	c.Assert(exchange("bind "+bson.NewObjectId().Hex()), Matches, "error Subscription/.* doesn't exist")
+	c.Assert(exchange("bind "+restHookID), Equals, "error Subscription/"+restHookID+" doesn't have a websocket channel")
+	c.Assert(exchange("bind "+subscriptionID), Equals, "bound "+subscriptionID)
+
+	// and are pinged when matching resources are created or updated
+	post("Observation", `{"resourceType": "Observation", "status": "final", "code": {"coding": [{"system": "http://example.com", "code": "other"}]}}`)
+	post("Observation", `{"resourceType": "Observation", "status": "final", "code": {"coding": [{"system": "http://example.com", "code": "`+code+`"}]}}`)
+	var ping string
+	util.CheckErr(websocket.Message.Receive(conn, &ping))
+	c.Assert(ping, Equals, "ping "+subscriptionID)
+}
+
+func (s *ServerSuite) TestTopicSubscriptions(c *C) {
+	config := DefaultConfig
+	config.EnableSubscriptions = true
+	config.SubscriptionRetries = 0
+	engine := gin.New()
+	RegisterRoutes(engine, make(map[string][]gin.HandlerFunc), NewMongoDataAccessLayer(s.client, s.dbname, true, "_fhir", nil, config), config)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	type notificationBundle struct {
+		Type  string `json:"type"`
+		Entry []struct {
+			FullUrl  string          `json:"fullUrl"`
+			Resource json.RawMessage `json:"resource"`
+		} `json:"entry"`
+	}
+	notifications := make(chan notificationBundle, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var bundle notificationBundle
+		util.CheckErr(json.NewDecoder(r.Body).Decode(&bundle))
+		notifications <- bundle
+	}))
+	defer hook.Close()
+
+	post := func(resourceType, body string) (int, string) {
+		res, err := http.Post(server.URL+"/"+resourceType, "application/fhir+json", strings.NewReader(body))
+		util.CheckErr(err)
+		return res.StatusCode, resourceIdFromLocationStr(res.Header.Get("Location"))
+	}
+	get := func(path string) notificationBundle {
+		res, err := http.Get(server.URL + path)
+		util.CheckErr(err)
+		c.Assert(res.StatusCode, Equals, 200, Commentf("GET %s", path))
+		var bundle notificationBundle
+		util.CheckErr(json.NewDecoder(res.Body).Decode(&bundle))
+		return bundle
+	}
+	// statusParameter returns a parameter of the status (the first entry) of a notification Bundle
+	statusParameter := func(bundle notificationBundle, name string) *models.ParametersParameterComponent {
+		status := &models.Parameters{}
+		util.CheckErr(json.Unmarshal(bundle.Entry[0].Resource, status))
+		for i := range status.Parameter {
+			if status.Parameter[i].Name == name {
+				return &status.Parameter[i]
+			}
+		}
+		return nil
+	}
+	// next returns the next notification of a type, skipping heartbeats
+	next := func(notificationType string) notificationBundle {
+		for {
+			select {
+			case bundle := <-notifications:
+				c.Assert(bundle.Type, Equals, "history")
+				if statusType := statusParameter(bundle, "type").ValueCode; statusType == notificationType {
+					return bundle
+				} else if statusType != "heartbeat" {
+					c.Fatalf("expected a %s notification, not %s", notificationType, statusType)
+				}
+			case <-time.After(10 * time.Second):
+				c.Fatalf("the Subscription wasn't sent a %s notification", notificationType)
+			}
+		}
+	}
+
+	// SubscriptionTopics are Basic resources
+	topicURL := "http://example.com/SubscriptionTopic/" + bson.NewObjectId().Hex()
+	status, _ := post("Basic", `{"resourceType": "Basic",
+		"code": {"coding": [{"system": "http://hl7.org/fhir/fhir-types", "code": "SubscriptionTopic"}]},
+		"extension": [
+			{"url": "http://hl7.org/fhir/5.0/StructureDefinition/extension-SubscriptionTopic.url", "valueUri": "`+topicURL+`"},
+			{"url": "http://hl7.org/fhir/5.0/StructureDefinition/extension-SubscriptionTopic.resourceTrigger", "extension": [
+				{"url": "resource", "valueUri": "http://hl7.org/fhir/StructureDefinition/Encounter"},
+				{"url": "supportedInteraction", "valueCode": "create"},
+				{"url": "queryCriteria", "extension": [{"url": "current", "valueString": "Encounter?status=finished"}]}
+			]}
+		]}`)
+	c.Assert(status, Equals, 201)
+
+	// Subscriptions to unknown topics, or with filters of other types of resources, are rejected
+	subscription := func(topic, filter string) string {
+		return `{"resourceType": "Subscription", "status": "requested", "criteria": "` + topic + `",
+			"_criteria": {"extension": [{"url": "http://hl7.org/fhir/uv/subscriptions-backport/StructureDefinition/backport-filter-criteria", "valueString": "` + filter + `"}]},
+			"channel": {"type": "rest-hook", "endpoint": "` + hook.URL + `", "payload": "application/fhir+json",
+				"extension": [{"url": "http://hl7.org/fhir/uv/subscriptions-backport/StructureDefinition/backport-heartbeat-period", "valueUnsignedInt": 1}],
+				"_payload": {"extension": [{"url": "http://hl7.org/fhir/uv/subscriptions-backport/StructureDefinition/backport-payload-content", "valueCode": "full-resource"}]}}}`
+	}
+	status, _ = post("Subscription", subscription(topicURL+"-unknown", "Encounter?class=inpatient"))
+	c.Assert(status, Equals, 422)
+	status, _ = post("Subscription", subscription(topicURL, "Observation?code=123"))
+	c.Assert(status, Equals, 422)
+
+	// Subscriptions are sent a handshake once they're active
+	patientID := bson.NewObjectId().Hex()
+	status, subscriptionID := post("Subscription", subscription(topicURL, "Encounter?subject=Patient/"+patientID))
+	c.Assert(status, Equals, 201)
+	handshake := next("handshake")
+	c.Assert(handshake.Entry, HasLen, 1)
+	c.Assert(statusParameter(handshake, "subscription").ValueReference.Reference, Equals, "Subscription/"+subscriptionID)
+	c.Assert(statusParameter(handshake, "topic").ValueUri, Equals, topicURL)
+	c.Assert(statusParameter(handshake, "events-since-subscription-start").ValueString, Equals, "0")
+
+	// and are notified of the resources that trigger their topics and match their filters
+	status, _ = post("Encounter", `{"resourceType": "Encounter", "status": "finished", "subject": {"reference": "Patient/other"}}`)
+	c.Assert(status, Equals, 201)
+	status, _ = post("Encounter", `{"resourceType": "Encounter", "status": "planned", "subject": {"reference": "Patient/`+patientID+`"}}`)
+	c.Assert(status, Equals, 201)
+	status, encounterID := post("Encounter", `{"resourceType": "Encounter", "status": "finished", "subject": {"reference": "Patient/`+patientID+`"}}`)
+	c.Assert(status, Equals, 201)
+	event := next("event-notification")
+	c.Assert(statusParameter(event, "events-since-subscription-start").ValueString, Equals, "1")
+	notificationEvent := statusParameter(event, "notification-event")
+	c.Assert(notificationEvent.Part[0].ValueString, Equals, "1")
+	c.Assert(notificationEvent.Part[2].ValueReference.Reference, Equals, "Encounter/"+encounterID)
+	c.Assert(event.Entry, HasLen, 2)
+	c.Assert(event.Entry[1].FullUrl, Equals, "Encounter/"+encounterID)
+	encounter := &models.Encounter{}
+	util.CheckErr(json.Unmarshal(event.Entry[1].Resource, encounter))
+	c.Assert(encounter.Id, Equals, encounterID)
+
+	// and heartbeats when they haven't been notified for their heartbeat periods
+	heartbeat := next("heartbeat")
+	c.Assert(heartbeat.Entry, HasLen, 1)
+
+	// Clients can get the statuses of Subscriptions, and the events they've missed
+	statusBundle := get("/Subscription/" + subscriptionID + "/$status")
+	c.Assert(statusBundle.Type, Equals, "searchset")
+	c.Assert(statusParameter(statusBundle, "type").ValueCode, Equals, "query-status")
+	c.Assert(statusParameter(statusBundle, "events-since-subscription-start").ValueString, Equals, "1")
+
+	events := get("/Subscription/" + subscriptionID + "/$events?eventsSinceNumber=1&content=id-only")
+	c.Assert(statusParameter(events, "type").ValueCode, Equals, "query-event")
+	c.Assert(events.Entry, HasLen, 2)
+	c.Assert(events.Entry[1].FullUrl, Equals, "Encounter/"+encounterID)
+	c.Assert(events.Entry[1].Resource, IsNil)
+	c.Assert(get("/Subscription/"+subscriptionID+"/$events?eventsSinceNumber=2").Entry, HasLen, 1)
+}
+
+// testEventPublisher receives published change events, or fails to if failing is set
+type testEventPublisher struct {
+	events  chan *ChangeEvent
+	failing int32
+}
+
+func (p *testEventPublisher) publish(events []*ChangeEvent) error {
+	if atomic.LoadInt32(&p.failing) != 0 {
+		return errors.New("the event bus is unavailable")
+	}
+	for _, event := range events {
+		p.events <- event
+	}
+	return nil
+}
+
+func (s *ServerSuite) TestChangeEvents(c *C) {
+	config := DefaultConfig
+	dal := NewMongoDataAccessLayer(s.client, s.dbname, true, "_fhir", nil, config)
+	publisher := &testEventPublisher{events: make(chan *ChangeEvent, 10)}
+	mongoDal := dal.(*mongoDataAccessLayer)
+	mongoDal.changeEvents = newChangeEventRelay(mongoDal, publisher, true)
+	engine := gin.New()
+	RegisterRoutes(engine, make(map[string][]gin.HandlerFunc), dal, config)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	request := func(method, path, body string, expectedStatus int) {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		util.CheckErr(err)
+		req.Header.Set("Content-Type", "application/fhir+json")
+		res, err := http.DefaultClient.Do(req)
+		util.CheckErr(err)
+		c.Assert(res.StatusCode, Equals, expectedStatus, Commentf("%s %s", method, path))
+	}
+	nextEvent := func() *ChangeEvent {
+		select {
+		case event := <-publisher.events:
+			return event
+		case <-time.After(10 * time.Second):
+			c.Fatal("no change event was published")
+			return nil
+		}
+	}
+
+	// Created, updated and deleted resources are published, with the resources if payloads are enabled
+	id := bson.NewObjectId().Hex()
+	request("PUT", "/Patient/"+id, `{"resourceType": "Patient", "id": "`+id+`", "gender": "male"}`, 201)
+	event := nextEvent()
+	c.Assert(event.Action, Equals, ChangeEventCreate)
+	c.Assert(event.ResourceType, Equals, "Patient")
+	c.Assert(event.ResourceId, Equals, id)
+	c.Assert(event.VersionId, Equals, "1")
+	patient := &models.Patient{}
+	util.CheckErr(json.Unmarshal(event.Resource, patient))
+	c.Assert(patient.Gender, Equals, "male")
+
+	request("PUT", "/Patient/"+id, `{"resourceType": "Patient", "id": "`+id+`", "gender": "female"}`, 200)
+	event = nextEvent()
+	c.Assert(event.Action, Equals, ChangeEventUpdate)
+	c.Assert(event.VersionId, Equals, "2")
+	util.CheckErr(json.Unmarshal(event.Resource, patient))
+	c.Assert(patient.Gender, Equals, "female")
+
+	request("DELETE", "/Patient/"+id, "", 204)
+	event = nextEvent()
+	c.Assert(event.Action, Equals, ChangeEventDelete)
+	c.Assert(event.ResourceId, Equals, id)
+	c.Assert(event.Resource, IsNil)
+
+	// Events that fail to be published stay in the outbox until they are
+	atomic.StoreInt32(&publisher.failing, 1)
+	request("POST", "/Patient", `{"resourceType": "Patient"}`, 201)
+	select {
+	case <-publisher.events:
+		c.Fatal("a change event was published while the event bus was unavailable")
+	case <-time.After(500 * time.Millisecond):
+	}
+	atomic.StoreInt32(&publisher.failing, 0)
+	c.Assert(nextEvent().Action, Equals, ChangeEventCreate)
+}
+
+func (s *ServerSuite) TestChangeFeed(c *C) {
+	config := DefaultConfig
+	config.EnableChangeFeed = true
+	dal := NewMongoDataAccessLayer(s.client, s.dbname, true, "_fhir", nil, config)
+	engine := gin.New()
+	RegisterRoutes(engine, make(map[string][]gin.HandlerFunc), dal, config)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	session := dal.StartSession(context.Background(), "")
+	defer session.Finish()
+	feed, err := session.WatchChanges("Patient", "")
+	util.CheckErr(err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	defer feed.Close(ctx)
+	// nextChange returns the next change to a Patient (ignoring those of other tests)
+	nextChange := func(feed *ChangeFeed, id string) *ChangeEvent {
+		for {
+			change, err := feed.Next(ctx)
+			util.CheckErr(err)
+			if change.ResourceId == id {
+				return change
+			}
+		}
+	}
+
+	// Changes are returned in order, with the resources unless they were deleted
+	id := bson.NewObjectId().Hex()
+	for _, gender := range []string{"male", "female"} {
+		resource, err := models2.NewResourceFromJsonBytes([]byte(`{"resourceType": "Patient", "gender": "` + gender + `"}`))
+		util.CheckErr(err)
+		_, err = session.Put(id, "", resource)
+		util.CheckErr(err)
+	}
+	_, _, err = session.Delete(id, "Patient")
+	util.CheckErr(err)
+
+	created := nextChange(feed, id)
+	c.Assert(created.Action, Equals, ChangeEventCreate)
+	c.Assert(created.VersionId, Equals, "1")
+	patient := &models.Patient{}
+	util.CheckErr(json.Unmarshal(created.Resource, patient))
+	c.Assert(patient.Gender, Equals, "male")
+	updated := nextChange(feed, id)
+	c.Assert(updated.Action, Equals, ChangeEventUpdate)
+	c.Assert(updated.VersionId, Equals, "2")
+	deleted := nextChange(feed, id)
+	c.Assert(deleted.Action, Equals, ChangeEventDelete)
+	c.Assert(deleted.Resource, IsNil)
+
+	// Feeds can resume after a change
+	resumed, err := session.WatchChanges("Patient", created.Id)
+	util.CheckErr(err)
+	defer resumed.Close(ctx)
+	c.Assert(nextChange(resumed, id).Id, Equals, updated.Id)
+	_, err = session.WatchChanges("Patient", "not-a-token")
+	c.Assert(err, Equals, ErrInvalidResumeToken)
+
+	// and are streamed as Server-Sent Events
+	req, err := http.NewRequest("GET", server.URL+"/Patient/$changes", nil)
+	util.CheckErr(err)
+	req.Header.Set("Last-Event-ID", created.Id)
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	util.CheckErr(err)
+	defer res.Body.Close()
+	c.Assert(res.StatusCode, Equals, 200)
+	c.Assert(res.Header.Get("Content-Type"), Equals, "text/event-stream")
+	scanner := bufio.NewScanner(res.Body)
+	var lines []string
+	for len(lines) < 3 && scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	c.Assert(lines, HasLen, 3)
+	c.Assert(lines[0], Equals, "id: "+updated.Id)
+	c.Assert(lines[1], Equals, "event: update")
+	util.CheckErr(json.Unmarshal([]byte(strings.TrimPrefix(lines[2], "data: ")), patient))
+	c.Assert(patient.Gender, Equals, "female")
+}
@@ -0,0 +1,488 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eug48/fhir/models"
+	"github.com/eug48/fhir/models2"
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// Topic-based Subscriptions are supported as in the Subscriptions R5 Backport IG
+// (http://hl7.org/fhir/uv/subscriptions-backport/): STU3 has no SubscriptionTopic or SubscriptionStatus resources, so
+// topics are Basic resources with the extensions of R5's SubscriptionTopic elements, Subscriptions refer to them by
+// their canonical URLs in their criteria, and statuses are Parameters.
+const (
+	backportExtensions      = "http://hl7.org/fhir/uv/subscriptions-backport/StructureDefinition/"
+	subscriptionTopicPrefix = "http://hl7.org/fhir/5.0/StructureDefinition/extension-SubscriptionTopic."
+	subscriptionStatusURL   = "http://hl7.org/fhir/uv/subscriptions-backport/StructureDefinition/backport-subscription-status-r4"
+)
+
+// The contents of the notifications of topic-based Subscriptions (see the backport-payload-content extension)
+const (
+	SubscriptionContentEmpty        = "empty"
+	SubscriptionContentIDOnly       = "id-only"
+	SubscriptionContentFullResource = "full-resource"
+)
+
+// heartbeatCheckInterval is how often topic-based Subscriptions are checked for heartbeats that are due
+const heartbeatCheckInterval = time.Second
+
+// fhirExtension is an extension (with the types of values used by topic-based Subscriptions), as the models'
+// Extension can't have extensions
+type fhirExtension struct {
+	URL              string          `json:"url"`
+	Extension        []fhirExtension `json:"extension,omitempty"`
+	ValueString      string          `json:"valueString,omitempty"`
+	ValueCode        string          `json:"valueCode,omitempty"`
+	ValueUri         string          `json:"valueUri,omitempty"`
+	ValueUnsignedInt *uint32         `json:"valueUnsignedInt,omitempty"`
+}
+
+// extensionsWithURL returns the extensions with a URL
+func extensionsWithURL(extensions []fhirExtension, url string) []fhirExtension {
+	var found []fhirExtension
+	for _, extension := range extensions {
+		if extension.URL == url {
+			found = append(found, extension)
+		}
+	}
+	return found
+}
+
+// subscriptionTopic is a SubscriptionTopic, the changes to resources that topic-based Subscriptions are notified of
+type subscriptionTopic struct {
+	URL      string
+	Triggers []subscriptionTopicTrigger
+}
+
+// subscriptionTopicTrigger is a resourceTrigger of a SubscriptionTopic: the interactions with resources of a type
+// (create and update, as resources are only matched as they're stored) that match its query criteria
+type subscriptionTopicTrigger struct {
+	ResourceType string
+	Interactions []string
+	// Criteria is a query of the resources after they've changed (its queryCriteria.current), without the type
+	Criteria string
+}
+
+// parseSubscriptionTopic parses a SubscriptionTopic, a Basic resource with the SubscriptionTopic code, returning nil
+// if the resource isn't one
+func parseSubscriptionTopic(resource *models2.Resource) (*subscriptionTopic, error) {
+	var basic struct {
+		Code struct {
+			Coding []models.Coding `json:"coding"`
+		} `json:"code"`
+		Extension []fhirExtension `json:"extension"`
+	}
+	if err := resource.Unmarshal(&basic); err != nil {
+		return nil, errors.Wrap(err, "parseSubscriptionTopic: failed to parse the Basic resource")
+	}
+	isTopic := false
+	for _, coding := range basic.Code.Coding {
+		isTopic = isTopic || coding.Code == "SubscriptionTopic"
+	}
+	urls := extensionsWithURL(basic.Extension, subscriptionTopicPrefix+"url")
+	if !isTopic || len(urls) == 0 {
+		return nil, nil
+	}
+
+	topic := &subscriptionTopic{URL: urls[0].ValueUri}
+	for _, triggerExtension := range extensionsWithURL(basic.Extension, subscriptionTopicPrefix+"resourceTrigger") {
+		var trigger subscriptionTopicTrigger
+		for _, resourceExtension := range extensionsWithURL(triggerExtension.Extension, "resource") {
+			// the resource is a URL in R5 (e.g. http://hl7.org/fhir/StructureDefinition/Encounter) or just a type
+			trigger.ResourceType = resourceExtension.ValueUri[strings.LastIndex(resourceExtension.ValueUri, "/")+1:]
+		}
+		for _, interaction := range extensionsWithURL(triggerExtension.Extension, "supportedInteraction") {
+			trigger.Interactions = append(trigger.Interactions, interaction.ValueCode)
+		}
+		for _, queryCriteria := range extensionsWithURL(triggerExtension.Extension, "queryCriteria") {
+			for _, current := range extensionsWithURL(queryCriteria.Extension, "current") {
+				trigger.Criteria = strings.TrimPrefix(current.ValueString, trigger.ResourceType+"?")
+			}
+		}
+		if trigger.ResourceType != "" {
+			topic.Triggers = append(topic.Triggers, trigger)
+		}
+	}
+	return topic, nil
+}
+
+// isTopicCriteria returns whether the criteria of a Subscription is the canonical URL of a SubscriptionTopic, rather
+// than a search
+func isTopicCriteria(criteria string) bool {
+	return strings.HasPrefix(criteria, "http://") || strings.HasPrefix(criteria, "https://")
+}
+
+// backportSubscription is how a topic-based Subscription is notified, from the extensions of the Backport IG
+type backportSubscription struct {
+	// topic is the SubscriptionTopic, once it's been found
+	topic *subscriptionTopic
+	// filters are searches (e.g. Encounter?patient=Patient/123) that the resources of their types must also match
+	filters []string
+	// heartbeatPeriod is how long the Subscription may go without notifications before it's sent a heartbeat (or 0
+	// if it isn't)
+	heartbeatPeriod time.Duration
+	// content is what notifications contain of the resources that changed (SubscriptionContentIDOnly by default)
+	content string
+}
+
+// parseBackportSubscription parses the extensions of a topic-based Subscription
+func parseBackportSubscription(resource *models2.Resource) (*backportSubscription, error) {
+	var subscription struct {
+		CriteriaElement struct {
+			Extension []fhirExtension `json:"extension"`
+		} `json:"_criteria"`
+		Channel struct {
+			Extension      []fhirExtension `json:"extension"`
+			PayloadElement struct {
+				Extension []fhirExtension `json:"extension"`
+			} `json:"_payload"`
+		} `json:"channel"`
+	}
+	if err := resource.Unmarshal(&subscription); err != nil {
+		return nil, errors.Wrap(err, "parseBackportSubscription: failed to parse the Subscription")
+	}
+
+	backport := &backportSubscription{content: SubscriptionContentIDOnly}
+	for _, filter := range extensionsWithURL(subscription.CriteriaElement.Extension, backportExtensions+"backport-filter-criteria") {
+		backport.filters = append(backport.filters, filter.ValueString)
+	}
+	for _, period := range extensionsWithURL(subscription.Channel.Extension, backportExtensions+"backport-heartbeat-period") {
+		if period.ValueUnsignedInt != nil {
+			backport.heartbeatPeriod = time.Duration(*period.ValueUnsignedInt) * time.Second
+		}
+	}
+	for _, content := range extensionsWithURL(subscription.Channel.PayloadElement.Extension, backportExtensions+"backport-payload-content") {
+		switch content.ValueCode {
+		case SubscriptionContentEmpty, SubscriptionContentIDOnly, SubscriptionContentFullResource:
+			backport.content = content.ValueCode
+		default:
+			return nil, errors.Errorf("unknown payload content: %q", content.ValueCode)
+		}
+	}
+	return backport, nil
+}
+
+// checkFilters returns an error unless the filters of a Subscription are valid searches of the types of resources
+// of its topic
+func (b *backportSubscription) checkFilters(topic *subscriptionTopic) error {
+	for _, filter := range b.filters {
+		query, err := parseSubscriptionCriteria(filter)
+		if err != nil {
+			return errors.Wrap(err, "invalid filter")
+		}
+		triggered := false
+		for _, trigger := range topic.Triggers {
+			triggered = triggered || trigger.ResourceType == query.Resource
+		}
+		if !triggered {
+			return errors.Errorf("the SubscriptionTopic has no %s resources to filter", query.Resource)
+		}
+	}
+	return nil
+}
+
+// notifyTopicSubscription records an event for a topic-based Subscription if a stored resource matches its topic
+// (and filters), and sends it a notification of it, or a handshake if it's the Subscription itself
+func (n *subscriptionNotifier) notifyTopicSubscription(session *mongoSession, dbName string, subscription *activeSubscription, resource storedResource) {
+	if resource.resourceType == "Subscription" && resource.id == subscription.Id {
+		if subscription.Status == SubscriptionActive {
+			go n.deliverNotification(dbName, subscription, "handshake", nil, nil)
+		}
+		return
+	}
+
+	matches, err := session.matchesSubscriptionTopic(subscription, resource)
+	if err != nil {
+		glog.Errorf("failed to match %s/%s against Subscription/%s: %+v", resource.resourceType, resource.id, subscription.Id, err)
+		return
+	} else if !matches {
+		return
+	}
+	event, err := session.recordSubscriptionEvent(subscription.Id, resource.resourceType+"/"+resource.id, n.eventLifetime)
+	if err != nil {
+		glog.Errorf("failed to record the event of %s/%s for Subscription/%s: %+v", resource.resourceType, resource.id, subscription.Id, err)
+		return
+	}
+	resources := map[string]json.RawMessage{event.Focus: resource.json}
+	go n.deliverNotification(dbName, subscription, "event-notification", []SubscriptionEvent{event}, resources)
+}
+
+// deliverNotification sends a notification Bundle of a type (handshake, heartbeat or event-notification) to a
+// topic-based Subscription (see deliver), with its events and the resources they're focused on
+func (n *subscriptionNotifier) deliverNotification(dbName string, subscription *activeSubscription, notificationType string, events []SubscriptionEvent, resources map[string]json.RawMessage) {
+	session := n.startSession(dbName)
+	eventCount, err := session.SubscriptionEventCount(subscription.Id)
+	if err == nil {
+		err = session.subscriptionNotified(subscription.Id)
+	}
+	session.Finish()
+	if err != nil {
+		glog.Errorf("failed to get the status of Subscription/%s: %+v", subscription.Id, err)
+		return
+	}
+	if len(events) > 0 && events[len(events)-1].Number > eventCount {
+		eventCount = events[len(events)-1].Number
+	}
+
+	bundle := subscriptionNotificationBundle(n.baseURL, subscription, notificationType, eventCount, events, resources)
+	body, err := json.Marshal(bundle)
+	if err != nil {
+		glog.Errorf("failed to marshal the notification of Subscription/%s: %+v", subscription.Id, err)
+		return
+	}
+	what := notificationType
+	if len(events) > 0 {
+		what = fmt.Sprintf("event %d (%s)", events[0].Number, events[0].Focus)
+	}
+	n.deliver(dbName, subscription, what, func() error {
+		return n.request(subscription, http.MethodPost, subscription.Channel.Endpoint, body)
+	})
+}
+
+// sendHeartbeats sends heartbeats to the active topic-based Subscriptions with heartbeat periods that haven't been
+// notified for that long, in each database that this server has notified Subscriptions in.  Heartbeats are claimed
+// in the database, so they're only sent by one server.
+func (n *subscriptionNotifier) sendHeartbeats() {
+	for range time.Tick(heartbeatCheckInterval) {
+		n.dbNames.Lock()
+		var dbNames []string
+		for dbName := range n.dbNames.names {
+			dbNames = append(dbNames, dbName)
+		}
+		n.dbNames.Unlock()
+
+		for _, dbName := range dbNames {
+			session := n.startSession(dbName)
+			subscriptions, err := session.activeSubscriptions()
+			if err != nil {
+				glog.Errorf("failed to get the active Subscriptions: %+v", err)
+			}
+			for _, subscription := range subscriptions {
+				if subscription.backport == nil || subscription.backport.heartbeatPeriod == 0 || subscription.Status != SubscriptionActive {
+					continue
+				}
+				due, err := session.claimSubscriptionHeartbeat(subscription.Id, subscription.backport.heartbeatPeriod)
+				if err != nil {
+					glog.Errorf("failed to claim the heartbeat of Subscription/%s: %+v", subscription.Id, err)
+				} else if due {
+					go n.deliverNotification(dbName, subscription, "heartbeat", nil, nil)
+				}
+			}
+			session.Finish()
+		}
+	}
+}
+
+// subscriptionNotificationBundle returns a notification Bundle of a topic-based Subscription: a history Bundle whose
+// first entry is its status (see subscriptionStatus), followed by the resources its events are focused on (unless
+// its content is empty), with their IDs (and their contents if its content is full-resource)
+func subscriptionNotificationBundle(baseURL string, subscription *activeSubscription, notificationType string, eventCount int64, events []SubscriptionEvent, resources map[string]json.RawMessage) *models.Bundle {
+	status := subscriptionStatus(baseURL, subscription, notificationType, eventCount, events)
+	bundle := &models.Bundle{
+		Type: "history",
+		Entry: []models.BundleEntryComponent{{
+			FullUrl:  "urn:uuid:" + uuid.New().String(),
+			Resource: status,
+			Request:  &models.BundleEntryRequestComponent{Method: "GET", Url: "Subscription/" + subscription.Id + "/$status"},
+			Response: &models.BundleEntryResponseComponent{Status: "200"},
+		}},
+	}
+	if subscription.backport.content == SubscriptionContentEmpty {
+		return bundle
+	}
+	listed := make(map[string]bool)
+	for _, event := range events {
+		if listed[event.Focus] {
+			continue
+		}
+		listed[event.Focus] = true
+		entry := models.BundleEntryComponent{
+			FullUrl: fullURL(baseURL, event.Focus),
+			Request: &models.BundleEntryRequestComponent{Method: "PUT", Url: event.Focus},
+		}
+		if resource, found := resources[event.Focus]; found && subscription.backport.content == SubscriptionContentFullResource {
+			entry.Resource = resource
+		}
+		bundle.Entry = append(bundle.Entry, entry)
+	}
+	return bundle
+}
+
+// subscriptionStatus returns the status of a topic-based Subscription (a SubscriptionStatus) sent in notifications
+// of a type, and returned by the $status (query-status) and $events (query-event) operations
+func subscriptionStatus(baseURL string, subscription *activeSubscription, statusType string, eventCount int64, events []SubscriptionEvent) *models.Parameters {
+	status := &models.Parameters{
+		Parameter: []models.ParametersParameterComponent{
+			{Name: "subscription", ValueReference: &models.Reference{Reference: fullURL(baseURL, "Subscription/"+subscription.Id)}},
+			{Name: "topic", ValueUri: subscription.Criteria},
+			{Name: "status", ValueCode: subscription.Status},
+			{Name: "type", ValueCode: statusType},
+			{Name: "events-since-subscription-start", ValueString: strconv.FormatInt(eventCount, 10)},
+		},
+	}
+	status.Meta = &models.Meta{Profile: []string{subscriptionStatusURL}}
+	if subscription.Error != "" {
+		status.Parameter = append(status.Parameter, models.ParametersParameterComponent{
+			Name:                 "error",
+			ValueCodeableConcept: &models.CodeableConcept{Text: subscription.Error},
+		})
+	}
+	for _, event := range events {
+		notificationEvent := models.ParametersParameterComponent{
+			Name: "notification-event",
+			Part: []models.ParametersParameterComponent{
+				{Name: "event-number", ValueString: strconv.FormatInt(event.Number, 10)},
+				{Name: "timestamp", ValueInstant: &models.FHIRDateTime{Time: event.Timestamp, Precision: models.Timestamp}},
+			},
+		}
+		if subscription.backport.content != SubscriptionContentEmpty {
+			notificationEvent.Part = append(notificationEvent.Part, models.ParametersParameterComponent{
+				Name:           "focus",
+				ValueReference: &models.Reference{Reference: fullURL(baseURL, event.Focus)},
+			})
+		}
+		status.Parameter = append(status.Parameter, notificationEvent)
+	}
+	return status
+}
+
+// fullURL returns the URL of a resource on the server (or its relative URL if the server's URL isn't configured)
+func fullURL(baseURL, path string) string {
+	if baseURL == "" {
+		return path
+	}
+	return baseURL + "/" + path
+}
+
+// getTopicSubscription returns a topic-based Subscription for the $status and $events operations, rendering a
+// response and returning nil if there's no such Subscription
+func getTopicSubscription(c *gin.Context, session DataAccessSession) *activeSubscription {
+	resource, err := session.Get(c.Param("id"), "Subscription")
+	if err == ErrNotFound || err == ErrDeleted {
+		c.Status(http.StatusNotFound)
+		return nil
+	} else if err != nil {
+		panic(errors.Wrap(err, "failed to get the Subscription"))
+	}
+	subscription := &activeSubscription{}
+	if err := resource.Unmarshal(&subscription.Subscription); err != nil {
+		panic(errors.Wrap(err, "failed to parse the Subscription"))
+	}
+	subscription.Id = resource.Id()
+	if isTopicCriteria(subscription.Criteria) {
+		subscription.backport, err = parseBackportSubscription(resource)
+	}
+	if subscription.backport == nil || err != nil {
+		outcome := models.NewOperationOutcome("fatal", "not-supported", "The Subscription isn't a valid topic-based Subscription")
+		c.Render(http.StatusBadRequest, CustomFhirRenderer{outcome, c})
+		return nil
+	}
+	return subscription
+}
+
+// SubscriptionStatusHandler returns a handler of the $status operation of topic-based Subscriptions (GET
+// /Subscription/[id]/$status), which returns a searchset Bundle with the status of the Subscription, including the
+// number of events since it started (so that clients can tell whether they've missed any)
+func SubscriptionStatusHandler(dal DataAccessLayer, config Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer handlePanics(c)
+		c.Set("Action", "operation")
+
+		session := dal.StartSession(c.Request.Context(), c.GetHeader("Db"))
+		defer session.Finish()
+		subscription := getTopicSubscription(c, session)
+		if subscription == nil {
+			return
+		}
+		eventCount, err := session.SubscriptionEventCount(subscription.Id)
+		if err != nil {
+			panic(errors.Wrap(err, "SubscriptionEventCount failed"))
+		}
+
+		baseURL := strings.TrimSuffix(config.responseURL(c.Request).String(), "/")
+		total := uint32(1)
+		bundle := &models.Bundle{
+			Type:  "searchset",
+			Total: &total,
+			Entry: []models.BundleEntryComponent{{
+				FullUrl:  "urn:uuid:" + uuid.New().String(),
+				Resource: subscriptionStatus(baseURL, subscription, "query-status", eventCount, nil),
+				Search:   &models.BundleEntrySearchComponent{Mode: "match"},
+			}},
+		}
+		c.Render(http.StatusOK, CustomFhirRenderer{bundle, c})
+	}
+}
+
+// SubscriptionEventsHandler returns a handler of the $events operation of topic-based Subscriptions (GET
+// /Subscription/[id]/$events), which returns a query-event notification Bundle of the recorded events (that
+// haven't expired) from eventsSinceNumber to eventsUntilNumber, with the content parameter's content (or else the
+// Subscription's), so that clients can recover events that they missed
+func SubscriptionEventsHandler(dal DataAccessLayer, config Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer handlePanics(c)
+		c.Set("Action", "operation")
+
+		session := dal.StartSession(c.Request.Context(), c.GetHeader("Db"))
+		defer session.Finish()
+		subscription := getTopicSubscription(c, session)
+		if subscription == nil {
+			return
+		}
+		eventCount, err := session.SubscriptionEventCount(subscription.Id)
+		if err != nil {
+			panic(errors.Wrap(err, "SubscriptionEventCount failed"))
+		}
+
+		since, until := int64(1), eventCount
+		for name, value := range map[string]*int64{"eventsSinceNumber": &since, "eventsUntilNumber": &until} {
+			if param := c.Query(name); param != "" {
+				if *value, err = strconv.ParseInt(param, 10, 64); err != nil {
+					outcome := models.NewOperationOutcome("fatal", "value", fmt.Sprintf("%s must be an integer", name))
+					c.Render(http.StatusBadRequest, CustomFhirRenderer{outcome, c})
+					return
+				}
+			}
+		}
+		switch content := c.Query("content"); content {
+		case "":
+		case SubscriptionContentEmpty, SubscriptionContentIDOnly, SubscriptionContentFullResource:
+			subscription.backport.content = content
+		default:
+			outcome := models.NewOperationOutcome("fatal", "value", fmt.Sprintf("unknown content: %q", content))
+			c.Render(http.StatusBadRequest, CustomFhirRenderer{outcome, c})
+			return
+		}
+
+		events, err := session.SubscriptionEvents(subscription.Id, since, until)
+		if err != nil {
+			panic(errors.Wrap(err, "SubscriptionEvents failed"))
+		}
+		// The resources are their current versions
+		resources := make(map[string]json.RawMessage)
+		if subscription.backport.content == SubscriptionContentFullResource {
+			for _, event := range events {
+				parts := strings.SplitN(event.Focus, "/", 2)
+				if resource, err := session.Get(parts[1], parts[0]); err == nil {
+					if resources[event.Focus], err = resource.MarshalJSON(); err != nil {
+						panic(errors.Wrap(err, "failed to marshal a resource"))
+					}
+				}
+			}
+		}
+
+		baseURL := strings.TrimSuffix(config.responseURL(c.Request).String(), "/")
+		bundle := subscriptionNotificationBundle(baseURL, subscription, "query-event", eventCount, events, resources)
+		c.Render(http.StatusOK, CustomFhirRenderer{bundle, c})
+	}
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/eug48/fhir/models"
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"golang.org/x/net/websocket"
+)
+
+// subscriptionSocket is a WebSocket connection that's bound to websocket Subscriptions
+type subscriptionSocket struct {
+	sync.Mutex // serializes sends
+	conn       *websocket.Conn
+}
+
+func (s *subscriptionSocket) send(message string) error {
+	s.Lock()
+	defer s.Unlock()
+	return websocket.Message.Send(s.conn, message)
+}
+
+// boundSubscriptionSockets are the connections bound to each websocket Subscription (by its database and id) on this
+// server
+var boundSubscriptionSockets = struct {
+	sync.Mutex
+	bySubscription map[string]map[*subscriptionSocket]bool
+}{bySubscription: make(map[string]map[*subscriptionSocket]bool)}
+
+func subscriptionSocketKey(dbName, id string) string {
+	return dbName + "/" + id
+}
+
+// SubscriptionWebSocketHandler returns a handler of the WebSocket connections of clients of websocket Subscriptions
+// (GET /websocket, see http://hl7.org/fhir/STU3/subscription.html#2.46.7.2).  Clients bind connections to
+// Subscriptions by sending "bind [id]", to which the server responds with "bound [id]", and are then sent
+// "ping [id]" whenever a resource matching the Subscription's criteria is created or updated, which clients
+// typically respond to by searching for the changes.  Bind requests that fail are responded to with
+// "error [message]".
+func SubscriptionWebSocketHandler(dal DataAccessLayer, config Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		customDbName := c.GetHeader("Db")
+		dbName := customDbName
+		if dbName == "" || !config.EnableMultiDB {
+			dbName = config.DefaultDatabaseName
+		}
+
+		// The handshake doesn't check the Origin header, as clients are authorized by the bound Subscriptions' IDs
+		server := websocket.Server{Handler: func(conn *websocket.Conn) {
+			socket := &subscriptionSocket{conn: conn}
+			var bound []string
+			defer func() {
+				boundSubscriptionSockets.Lock()
+				for _, key := range bound {
+					delete(boundSubscriptionSockets.bySubscription[key], socket)
+					if len(boundSubscriptionSockets.bySubscription[key]) == 0 {
+						delete(boundSubscriptionSockets.bySubscription, key)
+					}
+				}
+				boundSubscriptionSockets.Unlock()
+				conn.Close()
+			}()
+
+			for {
+				var message string
+				if err := websocket.Message.Receive(conn, &message); err != nil {
+					return
+				}
+				fields := strings.Fields(message)
+				if len(fields) != 2 || fields[0] != "bind" {
+					socket.send("error expected bind [id]")
+					continue
+				}
+				id := fields[1]
+				if err := checkWebSocketSubscription(dal, c, customDbName, id); err != "" {
+					socket.send("error " + err)
+					continue
+				}
+
+				key := subscriptionSocketKey(dbName, id)
+				boundSubscriptionSockets.Lock()
+				if boundSubscriptionSockets.bySubscription[key] == nil {
+					boundSubscriptionSockets.bySubscription[key] = make(map[*subscriptionSocket]bool)
+				}
+				boundSubscriptionSockets.bySubscription[key][socket] = true
+				boundSubscriptionSockets.Unlock()
+				bound = append(bound, key)
+				if err := socket.send("bound " + id); err != nil {
+					return
+				}
+			}
+		}}
+		server.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// checkWebSocketSubscription returns why a connection can't be bound to a Subscription, or "" if it can
+func checkWebSocketSubscription(dal DataAccessLayer, c *gin.Context, customDbName string, id string) string {
+	session := dal.StartSession(c.Request.Context(), customDbName)
+	defer session.Finish()
+	resource, err := session.Get(id, "Subscription")
+	if err == ErrNotFound || err == ErrDeleted {
+		return "Subscription/" + id + " doesn't exist"
+	} else if err != nil {
+		glog.Errorf("failed to get Subscription/%s: %+v", id, err)
+		return "failed to get Subscription/" + id
+	}
+	var subscription models.Subscription
+	if err := resource.Unmarshal(&subscription); err != nil {
+		return "failed to parse Subscription/" + id
+	}
+	if subscription.Channel == nil || subscription.Channel.Type != "websocket" {
+		return "Subscription/" + id + " doesn't have a websocket channel"
+	} else if subscription.Status != SubscriptionActive {
+		return "Subscription/" + id + " isn't active"
+	}
+	return ""
+}
+
+// pingSubscriptionSockets sends a ping to the connections bound to a websocket Subscription
+func pingSubscriptionSockets(dbName, id string) {
+	boundSubscriptionSockets.Lock()
+	var sockets []*subscriptionSocket
+	for socket := range boundSubscriptionSockets.bySubscription[subscriptionSocketKey(dbName, id)] {
+		sockets = append(sockets, socket)
+	}
+	boundSubscriptionSockets.Unlock()
+
+	for _, socket := range sockets {
+		if err := socket.send("ping " + id); err != nil {
+			glog.V(2).Infof("failed to ping a WebSocket bound to Subscription/%s: %s", id, err)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/eug48/fhir/models"
+	"github.com/eug48/fhir/models2"
+	"github.com/eug48/fhir/search"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// The states of Subscriptions (see http://hl7.org/fhir/STU3/subscription.html)
+const (
+	SubscriptionRequested = "requested"
+	SubscriptionActive    = "active"
+	SubscriptionError     = "error"
+	SubscriptionOff       = "off"
+)
+
+// subscriptionTimeout is how long the endpoint of a Subscription has to respond to a notification
+const subscriptionTimeout = 30 * time.Second
+
+// storedResource is a created or updated resource whose Subscriptions are notified (see subscriptionNotifier)
+type storedResource struct {
+	resourceType string
+	id           string
+	json         []byte
+	created      bool
+}
+
+// activateSubscription checks that the server supports a created or updated Subscription (getting the topics of
+// topic-based Subscriptions with topic), returning a FhirValidationError if it doesn't, and activates it if it's
+// been requested
+func activateSubscription(resource *models2.Resource, topic func(url string) (*subscriptionTopic, error)) error {
+	var subscription models.Subscription
+	if err := resource.Unmarshal(&subscription); err != nil {
+		return errors.Wrap(err, "activateSubscription: failed to parse the Subscription")
+	}
+
+	var issues []models2.ValidationIssue
+	invalid := func(expression, format string, args ...interface{}) {
+		issues = append(issues, models2.ValidationIssue{Code: "not-supported", Expression: expression, Diagnostics: fmt.Sprintf(format, args...)})
+	}
+	topicBased := isTopicCriteria(subscription.Criteria)
+	if topicBased {
+		if backport, err := parseBackportSubscription(resource); err != nil {
+			invalid("Subscription", "%s", err)
+		} else if subscriptionTopic, err := topic(subscription.Criteria); err != nil {
+			return errors.Wrap(err, "activateSubscription: failed to get the SubscriptionTopic")
+		} else if subscriptionTopic == nil {
+			invalid("Subscription.criteria", "SubscriptionTopic %s doesn't exist", subscription.Criteria)
+		} else if err := backport.checkFilters(subscriptionTopic); err != nil {
+			invalid("Subscription.criteria", "%s", err)
+		}
+	} else if _, err := parseSubscriptionCriteria(subscription.Criteria); err != nil {
+		invalid("Subscription.criteria", "%s", err)
+	}
+	if subscription.Channel == nil || (subscription.Channel.Type != "rest-hook" && subscription.Channel.Type != "websocket") {
+		invalid("Subscription.channel.type", "only rest-hook and websocket channels are supported")
+	} else if topicBased && subscription.Channel.Type != "rest-hook" {
+		invalid("Subscription.channel.type", "only rest-hook channels are supported for topic-based Subscriptions")
+	} else if subscription.Channel.Type == "rest-hook" {
+		if endpoint, err := url.Parse(subscription.Channel.Endpoint); err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+			invalid("Subscription.channel.endpoint", "the endpoint must be an http or https URL")
+		}
+		switch subscription.Channel.Payload {
+		case "", "application/fhir+json", "application/json":
+		default:
+			invalid("Subscription.channel.payload", "the payload must be empty or application/fhir+json")
+		}
+		for _, header := range subscription.Channel.Header {
+			if !strings.Contains(header, ":") {
+				invalid("Subscription.channel.header", "headers must be of the form 'Name: value'")
+			}
+		}
+	}
+	if len(issues) > 0 {
+		return models2.FhirValidationError{Issues: issues}
+	}
+
+	if subscription.Status != SubscriptionRequested {
+		return nil
+	}
+	jsonBytes, err := jsonparser.Set(resource.JsonBytes(), []byte(`"`+SubscriptionActive+`"`), "status")
+	if err != nil {
+		return errors.Wrap(err, "activateSubscription: jsonparser.Set failed")
+	}
+	activated, err := models2.NewResourceFromJsonBytes(jsonBytes)
+	if err != nil {
+		return errors.Wrap(err, "activateSubscription: NewResourceFromJsonBytes failed")
+	}
+	*resource = *activated
+	return nil
+}
+
+// parseSubscriptionCriteria parses the criteria of a Subscription, a search of a resource type (e.g.
+// Observation?code=http://loinc.org|1975-2), returning an error if it isn't a valid search
+func parseSubscriptionCriteria(criteria string) (query search.Query, err error) {
+	parts := strings.SplitN(criteria, "?", 2)
+	query.Resource = parts[0]
+	if len(parts) > 1 {
+		query.Query = parts[1]
+	}
+	if _, known := search.SearchParameters()[query.Resource]; !known {
+		return query, errors.Errorf("%q isn't a search of a resource type", criteria)
+	}
+
+	// invalid searches panic
+	defer func() {
+		if r := recover(); r != nil {
+			if searchErr, ok := r.(*search.Error); ok {
+				err = errors.Errorf("invalid criteria: %s", searchErr.OperationOutcome.Issue[0].Diagnostics)
+			} else {
+				panic(r)
+			}
+		}
+	}()
+	query.CheckUnknownParams()
+	query.Params()
+	query.Options()
+	return query, nil
+}
+
+// activeSubscription is a Subscription that's notified of changes
+type activeSubscription struct {
+	models.Subscription
+	// backport is how a topic-based Subscription is notified, or nil if the Subscription has criteria
+	backport *backportSubscription
+}
+
+// subscriptionNotifier notifies the endpoints of the rest-hook Subscriptions whose criteria match created and
+// updated resources, retrying failed notifications with increasing delays.  Subscriptions whose notifications
+// fail are put in the error state, and are active again once a notification succeeds.  The connections bound to
+// websocket Subscriptions are pinged instead (see SubscriptionWebSocketHandler), and topic-based Subscriptions are
+// sent notification Bundles (see notifyTopicSubscription).
+type subscriptionNotifier struct {
+	dal           *mongoDataAccessLayer
+	retries       int
+	retryDelay    time.Duration
+	eventLifetime time.Duration
+	baseURL       string
+	client        *http.Client
+
+	// the databases whose topic-based Subscriptions are sent heartbeats (see sendHeartbeats)
+	dbNames struct {
+		sync.Mutex
+		names map[string]bool
+	}
+}
+
+func newSubscriptionNotifier(dal *mongoDataAccessLayer, config Config) *subscriptionNotifier {
+	n := &subscriptionNotifier{
+		dal:           dal,
+		retries:       config.SubscriptionRetries,
+		retryDelay:    config.SubscriptionRetryDelay,
+		eventLifetime: config.SubscriptionEventLifetime,
+		baseURL:       strings.TrimSuffix(config.ServerURL, "/"),
+		client:        &http.Client{Timeout: subscriptionTimeout},
+	}
+	n.dbNames.names = map[string]bool{dal.defaultDbName: true}
+	go n.sendHeartbeats()
+	return n
+}
+
+// notify matches resources stored in a database against its Subscriptions in the background, notifying those that
+// match
+func (n *subscriptionNotifier) notify(dbName string, resources []storedResource) {
+	go func() {
+		n.dbNames.Lock()
+		n.dbNames.names[dbName] = true
+		n.dbNames.Unlock()
+
+		session := n.startSession(dbName)
+		defer session.Finish()
+
+		subscriptions, err := session.activeSubscriptions()
+		if err != nil {
+			glog.Errorf("failed to get the active Subscriptions: %+v", err)
+			return
+		}
+		for _, subscription := range subscriptions {
+			for _, resource := range resources {
+				if subscription.backport != nil {
+					n.notifyTopicSubscription(session, dbName, subscription, resource)
+					continue
+				}
+				matches, err := session.matchesSubscription(subscription, resource)
+				if err != nil {
+					glog.Errorf("failed to match %s/%s against Subscription/%s: %+v", resource.resourceType, resource.id, subscription.Id, err)
+				} else if matches && subscription.Channel.Type == "websocket" {
+					pingSubscriptionSockets(dbName, subscription.Id)
+				} else if matches {
+					subscription, resource := subscription, resource
+					go n.deliver(dbName, subscription, resource.resourceType+"/"+resource.id, func() error {
+						return n.send(subscription, resource)
+					})
+				}
+			}
+		}
+	}()
+}
+
+// deliver sends a notification to the endpoint of a Subscription (of what, for logging), retrying if it fails, and
+// updates the Subscription's status
+func (n *subscriptionNotifier) deliver(dbName string, subscription *activeSubscription, what string, send func() error) {
+	err := send()
+	delay := n.retryDelay
+	for retry := 0; err != nil && retry < n.retries; retry++ {
+		glog.Warningf("failed to notify Subscription/%s of %s (retrying in %s): %s", subscription.Id, what, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+		err = send()
+	}
+
+	session := n.startSession(dbName)
+	defer session.Finish()
+	if err != nil {
+		glog.Errorf("failed to notify Subscription/%s of %s: %s", subscription.Id, what, err)
+		err = session.setSubscriptionError(subscription.Id, fmt.Sprintf("Failed to notify %s: %s", subscription.Channel.Endpoint, err))
+	} else if subscription.Status == SubscriptionError {
+		err = session.setSubscriptionError(subscription.Id, "")
+	}
+	if err != nil {
+		glog.Errorf("failed to update the status of Subscription/%s: %+v", subscription.Id, err)
+	}
+}
+
+// startSession starts a session of a database
+func (n *subscriptionNotifier) startSession(dbName string) *mongoSession {
+	if dbName == n.dal.defaultDbName {
+		dbName = ""
+	}
+	return n.dal.StartSession(context.Background(), dbName).(*mongoSession)
+}
+
+// send sends a notification of a resource to the endpoint of a Subscription: a POST without a body, or a PUT of the
+// resource to [endpoint]/[type]/[id] if it has a payload
+func (n *subscriptionNotifier) send(subscription *activeSubscription, resource storedResource) error {
+	if subscription.Channel.Payload == "" {
+		return n.request(subscription, http.MethodPost, subscription.Channel.Endpoint, nil)
+	}
+	endpoint := strings.TrimSuffix(subscription.Channel.Endpoint, "/") + "/" + resource.resourceType + "/" + resource.id
+	return n.request(subscription, http.MethodPut, endpoint, resource.json)
+}
+
+// request sends a request to the endpoint of a Subscription, with its headers (and its payload's Content-Type, or
+// FHIR JSON's, if it has a body), returning an error unless it succeeds
+func (n *subscriptionNotifier) request(subscription *activeSubscription, method, endpoint string, body []byte) error {
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, endpoint, bodyReader)
+	if err != nil {
+		return errors.Wrap(err, "failed to create the request")
+	}
+	if body != nil {
+		contentType := subscription.Channel.Payload
+		if contentType == "" {
+			contentType = "application/fhir+json"
+		}
+		req.Header.Set("Content-Type", contentType)
+	}
+	for _, header := range subscription.Channel.Header {
+		parts := strings.SplitN(header, ":", 2)
+		if len(parts) == 2 {
+			req.Header.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+		}
+	}
+
+	res, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return errors.Errorf("the endpoint responded with %s", res.Status)
+	}
+	return nil
+}
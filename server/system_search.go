@@ -0,0 +1,38 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/eug48/fhir/search"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// SystemSearchHandler handles searches of all resource types, or those of the _type parameter, e.g.
+// GET /?_type=Patient,Observation&_lastUpdated=gt2019-01-01
+// The results are grouped by type, in the order of the _type parameter (or of the types' names).
+func SystemSearchHandler(dal DataAccessLayer, config Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer handlePanics(c)
+
+		rawQuery, ok := searchRequestQuery(c)
+		if !ok {
+			return
+		}
+
+		session := dal.StartSession(c.Request.Context(), c.GetHeader("Db"))
+		defer session.Finish()
+
+		searchQuery := search.Query{Query: rawQuery}
+		baseURL := config.responseURL(c.Request)
+		bundle, err := session.Search(*baseURL, searchQuery)
+		if err != nil {
+			panic(errors.Wrap(err, "Search (all resource types) failed"))
+		}
+
+		c.Set("bundle", bundle)
+		c.Set("Action", "search")
+
+		c.Render(http.StatusOK, CustomFhirRenderer{bundle, c})
+	}
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/eug48/fhir/models"
+	"github.com/eug48/fhir/search"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// Multi-tenancy (see Config.EnableTenancy): each tenant's resources are stored in a database of its own, so that
+// their searches, caches of search totals, histories and transactions are separate.  Requests select a tenant with
+// its id, either in their path (e.g. /tenants/acme/Patient, which is handled as /Patient) or in the
+// Config.TenantHeader, and fail with 404 Not Found if it hasn't been provisioned.  With auth, clients can only access
+// the tenants of their API keys, client certificates' identities or tokens (see tenantAuthorization), and tenants
+// are provisioned by admins.
+
+// TenantsCollection is the collection of the default database in which tenants are stored
+const TenantsCollection = "tenants"
+
+// Tenant is a tenant of the server, provisioned with the /$tenants API
+type Tenant struct {
+	Id   string `bson:"_id" json:"id"`
+	Name string `bson:"name,omitempty" json:"name,omitempty"`
+	// ResourceTypes limits the tenant to these resource types (if it has any), which are the only ones in its
+	// CapabilityStatement
+	ResourceTypes []string `bson:"resourceTypes,omitempty" json:"resourceTypes,omitempty"`
+	// Database is the database in which the tenant's resources are stored
+	Database string    `bson:"database" json:"database"`
+	Created  time.Time `bson:"created" json:"created"`
+}
+
+// tenantIDPattern matches the ids of tenants, which are part of the names of their databases
+var tenantIDPattern = regexp.MustCompile(`^[A-Za-z0-9-]{1,32}$`)
+
+// tenantDatabase returns the name of the database of a tenant
+func (config *Config) tenantDatabase(id string) string {
+	return "tenant_" + id + config.DatabaseSuffix
+}
+
+// tenantContext returns middleware that selects the database of a request's tenant, if it has one, by setting its
+// Db header, and adds the tenant to the gin.Context.  It fails requests for tenants that haven't been provisioned
+// or for resource types that their tenants are limited from, and ignores the Db header of other requests unless
+// Config.EnableMultiDB allows them to select a database.
+func tenantContext(dal DataAccessLayer, config Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(config.TenantHeader)
+		if id == "" {
+			if !config.EnableMultiDB {
+				c.Request.Header.Del("Db")
+			}
+			return
+		}
+
+		session := dal.StartSession(c.Request.Context(), "")
+		defer session.Finish()
+		tenant, err := session.GetTenant(id)
+		if err == ErrNotFound {
+			oo := models.NewOperationOutcome("error", "not-found", fmt.Sprintf("Tenant %s doesn't exist", id))
+			c.Render(http.StatusNotFound, CustomFhirRenderer{oo, c})
+			c.Abort()
+			return
+		} else if err != nil {
+			statusCode, outcome := ErrorToOpOutcome(errors.Wrap(err, "GetTenant failed"))
+			c.Render(statusCode, CustomFhirRenderer{outcome, c})
+			c.Abort()
+			return
+		}
+
+		resourceType := strings.Split(c.Request.URL.Path, "/")[1]
+		if len(tenant.ResourceTypes) > 0 && search.SearchParameters()[resourceType] != nil && !stringsInclude(tenant.ResourceTypes, resourceType) {
+			oo := models.NewOperationOutcome("error", "not-supported", fmt.Sprintf("Tenant %s doesn't support %s", id, resourceType))
+			c.Render(http.StatusNotFound, CustomFhirRenderer{oo, c})
+			c.Abort()
+			return
+		}
+
+		c.Request.Header.Set("Db", tenant.Database)
+		c.Set("tenant", tenant)
+	}
+}
+
+// tenantAuthorization returns middleware that forbids the requests for tenants that their clients (which the auth
+// middleware authenticated) can't access: those that aren't among the tenants of their API keys, client
+// certificates' identities or tokens, any of which may be * for all tenants
+func tenantAuthorization(config Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		tenant, isTenant := c.Get("tenant")
+		if !isTenant || path == "/metadata" || isRedispatched(path, config) {
+			// these are authorized when they're handled again, without the tenant or database in their path
+			return
+		}
+		id := tenant.(*Tenant).Id
+		if tenants := c.GetStringSlice("tenants"); !stringsInclude(tenants, "*") && !stringsInclude(tenants, id) {
+			oo := models.NewOperationOutcome("error", "forbidden", fmt.Sprintf("You do not have permission to access tenant %s", id))
+			c.Render(http.StatusForbidden, CustomFhirRenderer{oo, c})
+			c.Abort()
+		}
+	}
+}
+
+// tenantRouteHandler handles requests with a tenant in their path (e.g. /tenants/acme/Patient) as requests with the
+// tenant in their header
+func tenantRouteHandler(e *gin.Engine, config Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// gin can't route "/:tenant/Patient" etc alongside the other routes (see the MultiDB routes)
+		c.Request.Header.Set(config.TenantHeader, c.Param("tenant"))
+		c.Request.URL.Path = "/" + strings.TrimPrefix(c.Param("rest"), "/")
+		e.HandleContext(c)
+	}
+}
+
+// TenantsHandler handles GET /$tenants with every tenant
+func TenantsHandler(dal DataAccessLayer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer handlePanics(c)
+		c.Set("Action", "operation")
+
+		session := dal.StartSession(c.Request.Context(), "")
+		defer session.Finish()
+		tenants, err := session.Tenants()
+		if err != nil {
+			panic(errors.Wrap(err, "Tenants failed"))
+		}
+		c.JSON(http.StatusOK, tenants)
+	}
+}
+
+// TenantHandler handles GET /$tenants/:id with a tenant
+func TenantHandler(dal DataAccessLayer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer handlePanics(c)
+		c.Set("Action", "operation")
+
+		session := dal.StartSession(c.Request.Context(), "")
+		defer session.Finish()
+		tenant, err := session.GetTenant(c.Param("id"))
+		if err == ErrNotFound {
+			c.Status(http.StatusNotFound)
+			return
+		} else if err != nil {
+			panic(errors.Wrap(err, "GetTenant failed"))
+		}
+		c.JSON(http.StatusOK, tenant)
+	}
+}
+
+// ProvisionTenantHandler handles POST /$tenants with a tenant (its id, and optionally its name and resource
+// types), creating its database.  It responds with 201 Created and the tenant, or with 409 Conflict if there's
+// already a tenant with its id.
+func ProvisionTenantHandler(dal DataAccessLayer, config Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer handlePanics(c)
+		c.Set("Action", "operation")
+
+		var tenant Tenant
+		if err := c.ShouldBindJSON(&tenant); err != nil {
+			oo := models.NewOperationOutcome("fatal", "structure", err.Error())
+			c.Render(http.StatusBadRequest, CustomFhirRenderer{oo, c})
+			return
+		}
+		if !tenantIDPattern.MatchString(tenant.Id) {
+			oo := models.NewOperationOutcome("error", "value", fmt.Sprintf("Invalid tenant id %q (letters, digits and -, up to 32 of them)", tenant.Id))
+			c.Render(http.StatusBadRequest, CustomFhirRenderer{oo, c})
+			return
+		}
+		for _, resourceType := range tenant.ResourceTypes {
+			if search.SearchParameters()[resourceType] == nil {
+				oo := models.NewOperationOutcome("error", "value", fmt.Sprintf("Unknown resource type %s", resourceType))
+				c.Render(http.StatusBadRequest, CustomFhirRenderer{oo, c})
+				return
+			}
+		}
+		tenant.Database = config.tenantDatabase(tenant.Id)
+		tenant.Created = time.Now().UTC()
+
+		session := dal.StartSession(c.Request.Context(), "")
+		defer session.Finish()
+		if err := session.ProvisionTenant(&tenant); err != nil {
+			statusCode, outcome := ErrorToOpOutcome(errors.Wrap(err, "ProvisionTenant failed"))
+			c.Render(statusCode, CustomFhirRenderer{outcome, c})
+			return
+		}
+		c.Header("Location", config.responseURL(c.Request, "$tenants", tenant.Id).String())
+		c.JSON(http.StatusCreated, tenant)
+	}
+}
+
+// DeprovisionTenantHandler handles DELETE /$tenants/:id, deleting a tenant and dropping its database
+func DeprovisionTenantHandler(dal DataAccessLayer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer handlePanics(c)
+		c.Set("Action", "operation")
+
+		session := dal.StartSession(c.Request.Context(), "")
+		defer session.Finish()
+		err := session.DeprovisionTenant(c.Param("id"))
+		if err == ErrNotFound {
+			c.Status(http.StatusNotFound)
+			return
+		} else if err != nil {
+			panic(errors.Wrap(err, "DeprovisionTenant failed"))
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/eug48/fhir/auth"
+	"github.com/eug48/fhir/models"
+	"github.com/gin-gonic/gin"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+func (s *ServerSuite) TestTenancy(c *C) {
+	config := DefaultConfig
+	config.EnableTenancy = true
+	config.CreateIndexes = false
+	engine := gin.New()
+	RegisterRoutes(engine, make(map[string][]gin.HandlerFunc), NewMongoDataAccessLayer(s.client, s.dbname, false, "_fhir", nil, config), config)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+	defer s.DB().C(TenantsCollection).DropCollection()
+	defer s.initialSession.DB("tenant_acme_fhir").DropDatabase()
+
+	do := func(method, path string, body string, header ...string) *http.Response {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		util.CheckErr(err)
+		req.Header.Set("Content-Type", "application/json")
+		if len(header) > 0 {
+			req.Header.Set(header[0], header[1])
+		}
+		res, err := http.DefaultClient.Do(req)
+		util.CheckErr(err)
+		return res
+	}
+
+	// Tenants are provisioned once
+	res := do("POST", "/$tenants", `{"id": "acme", "name": "Acme Clinic", "resourceTypes": ["Patient"]}`)
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusCreated)
+	res = do("POST", "/$tenants", `{"id": "acme"}`)
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusConflict)
+	res = do("POST", "/$tenants", `{"id": "no/slashes"}`)
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusBadRequest)
+
+	// Their resources are stored in their databases, which requests select by path or header
+	res = do("POST", "/tenants/acme/Patient", `{"resourceType": "Patient"}`)
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusCreated)
+	c.Assert(strings.HasPrefix(res.Header.Get("Location"), server.URL+"/tenants/acme/Patient/"), Equals, true)
+	id := resourceIdFromLocation(res)
+	count, err := s.initialSession.DB("tenant_acme_fhir").C("patients").FindId(id).Count()
+	util.CheckErr(err)
+	c.Assert(count, Equals, 1)
+	count, err = s.DB().C("patients").FindId(id).Count()
+	util.CheckErr(err)
+	c.Assert(count, Equals, 0)
+
+	res = do("GET", "/Patient/"+id, "", "X-Tenant-ID", "acme")
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	res = do("GET", "/Patient/"+id, "")
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusNotFound)
+
+	// Only the tenant's resource types are supported, and are in its CapabilityStatement
+	res = do("GET", "/tenants/acme/Observation", "")
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusNotFound)
+	res = do("GET", "/tenants/acme/metadata", "")
+	var statement models.CapabilityStatement
+	util.CheckErr(json.NewDecoder(res.Body).Decode(&statement))
+	res.Body.Close()
+	c.Assert(statement.Implementation.Description, Equals, "Acme Clinic")
+	c.Assert(statement.Rest[0].Resource, HasLen, 1)
+	c.Assert(statement.Rest[0].Resource[0].Type, Equals, "Patient")
+
+	// Deprovisioned tenants' databases are dropped
+	res = do("DELETE", "/$tenants/acme", "")
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusNoContent)
+	res = do("GET", "/tenants/acme/Patient/"+id, "")
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusNotFound)
+	names, err := s.initialSession.DatabaseNames()
+	util.CheckErr(err)
+	c.Assert(stringsInclude(names, "tenant_acme_fhir"), Equals, false)
+}
+
+func (s *ServerSuite) TestTenantsOfAPIKeys(c *C) {
+	config := DefaultConfig
+	config.EnableTenancy = true
+	config.Auth = auth.APIKeys()
+	config.CreateIndexes = false
+	dal := NewMongoDataAccessLayer(s.client, s.dbname, false, "_fhir", nil, config)
+	engine := gin.New()
+	RegisterRoutes(engine, make(map[string][]gin.HandlerFunc), dal, config)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+	defer s.DB().C(APIKeysCollection).DropCollection()
+	defer s.DB().C(TenantsCollection).DropCollection()
+	defer s.initialSession.DB("tenant_acme_fhir").DropDatabase()
+	defer s.initialSession.DB("tenant_globex_fhir").DropDatabase()
+
+	admin, adminKey, err := auth.NewAPIKey("ops", false, nil, true)
+	util.CheckErr(err)
+	session := dal.StartSession(context.Background(), "")
+	util.CheckErr(session.CreateAPIKey(admin))
+	session.Finish()
+
+	do := func(method, path, body, key string) *http.Response {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		util.CheckErr(err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(auth.APIKeyHeader, key)
+		res, err := http.DefaultClient.Do(req)
+		util.CheckErr(err)
+		res.Body.Close()
+		return res
+	}
+	createKey := func(permissions string) string {
+		req, err := http.NewRequest("POST", server.URL+"/$api-keys", strings.NewReader(permissions))
+		util.CheckErr(err)
+		req.Header.Set(auth.APIKeyHeader, adminKey)
+		res, err := http.DefaultClient.Do(req)
+		util.CheckErr(err)
+		defer res.Body.Close()
+		c.Assert(res.StatusCode, Equals, http.StatusCreated)
+		var created struct {
+			Key string `json:"key"`
+		}
+		util.CheckErr(json.NewDecoder(res.Body).Decode(&created))
+		return created.Key
+	}
+	acmeKey := createKey(`{"name": "acme", "tenants": ["acme"]}`)
+	allKey := createKey(`{"name": "all", "tenants": ["*"]}`)
+	defaultKey := createKey(`{"name": "default"}`)
+
+	// Tenants are provisioned by admins
+	c.Assert(do("POST", "/$tenants", `{"id": "acme"}`, acmeKey).StatusCode, Equals, http.StatusForbidden)
+	c.Assert(do("POST", "/$tenants", `{"id": "acme"}`, adminKey).StatusCode, Equals, http.StatusCreated)
+	c.Assert(do("POST", "/$tenants", `{"id": "globex"}`, adminKey).StatusCode, Equals, http.StatusCreated)
+	c.Assert(do("GET", "/$tenants", "", acmeKey).StatusCode, Equals, http.StatusForbidden)
+	c.Assert(do("DELETE", "/$tenants/globex", "", acmeKey).StatusCode, Equals, http.StatusForbidden)
+
+	// and keys can only access their tenants, whether they're selected by path or header
+	c.Assert(do("GET", "/tenants/acme/Patient", "", acmeKey).StatusCode, Equals, http.StatusOK)
+	c.Assert(do("GET", "/tenants/globex/Patient", "", acmeKey).StatusCode, Equals, http.StatusForbidden)
+	req, err := http.NewRequest("GET", server.URL+"/Patient", nil)
+	util.CheckErr(err)
+	req.Header.Set(auth.APIKeyHeader, acmeKey)
+	req.Header.Set(config.TenantHeader, "globex")
+	res, err := http.DefaultClient.Do(req)
+	util.CheckErr(err)
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusForbidden)
+	c.Assert(do("GET", "/tenants/globex/Patient", "", allKey).StatusCode, Equals, http.StatusOK)
+	c.Assert(do("GET", "/tenants/acme/Patient", "", defaultKey).StatusCode, Equals, http.StatusForbidden)
+	c.Assert(do("GET", "/Patient", "", defaultKey).StatusCode, Equals, http.StatusOK)
+}
@@ -0,0 +1,10 @@
+package server
+
+import (
+	"go.opentelemetry.io/otel"
+)
+
+// tracer traces the handling of requests with OpenTelemetry.  The spans of batches and transactions, of searches and
+// of the assembly of their bundles are children of the spans of their requests (see the otelhttp handler of
+// fhir-server), and the spans of the MongoDB commands they run (see utils.MongoClientOptions) are children of theirs.
+var tracer = otel.Tracer("github.com/eug48/fhir/server")
@@ -8,6 +8,24 @@ import (
 	"time"
 )
 
+// defaultLocation is the timezone of dates without a time (see SetDefaultLocation)
+var defaultLocation = time.Local
+
+// SetDefaultLocation sets the timezone in which dates without a time (e.g. "2012-01-01") and times
+// without a timezone are interpreted, both when resources are stored and when they are searched, so
+// that date-only comparisons don't depend on where the server runs.  It defaults to the local timezone.
+// It should be set on startup, and resources stored with a different default need to be stored again.
+func SetDefaultLocation(loc *time.Location) {
+	if loc == nil {
+		loc = time.Local
+	}
+	defaultLocation = loc
+}
+
+// DefaultLocation returns the timezone of dates without a time (see SetDefaultLocation)
+func DefaultLocation() *time.Location {
+	return defaultLocation
+}
 
 // Date represents a date in a search query.  FHIR search params may define
 // dates to varying levels of precision, and the amount of precision affects
@@ -34,6 +52,13 @@ func (d *Date) RangeLowIncl() time.Time {
 	return d.Value
 }
 
+// UTCRange returns the range of instants a date covers (see RangeLowIncl and RangeHighExcl) in UTC.
+// Dates are stored as such ranges, so that values with different timezones (and precisions) compare
+// correctly.  The end is computed before converting to UTC, so days spanning DST changes are correct.
+func (d *Date) UTCRange() (lowIncl time.Time, highExcl time.Time) {
+	return d.RangeLowIncl().UTC(), d.RangeHighExcl().UTC()
+}
+
 // RangeHighExcl represents the high end of a date range to match against.  As
 // the name suggests, the high end of the range is exclusive.
 func (d *Date) RangeHighExcl() time.Time {
@@ -104,8 +129,8 @@ func ParseDate(dateStr string) (*Date, error) {
 			dt.Precision = Millisecond
 		}
 
-		// Get the location (if no time components or no location, use local)
-		loc := time.Local
+		// Get the location (if no time components or no location, use the default)
+		loc := defaultLocation
 		if h != "" {
 			if tzZu == "Z" {
 				loc, _ = time.LoadLocation("UTC")
@@ -0,0 +1,12 @@
+package utils
+
+import (
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
+)
+
+// MongoClientOptions returns the options of a MongoDB client for a URI, whose commands are traced with OpenTelemetry
+// (as spans of the requests whose contexts they're run with)
+func MongoClientOptions(uri string) *options.ClientOptions {
+	return options.Client().ApplyURI(uri).SetMonitor(otelmongo.NewMonitor())
+}
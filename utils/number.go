@@ -1,8 +1,11 @@
 package utils
 
 import (
+	"fmt"
 	"strings"
 	"math/big"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // Number represents a number in a search query.  FHIR search params may define
@@ -33,6 +36,58 @@ func (n *Number) RangeHighExcl() *big.Rat {
 	return new(big.Rat).Add(n.Value, n.rangeDelta())
 }
 
+// ApproxRangeLowIncl represents the low end of the range matched by the "ap"
+// (approximately) prefix, which is 10% of the value below it, or the low end of
+// the normal range if that is lower.
+func (n *Number) ApproxRangeLowIncl() *big.Rat {
+	return new(big.Rat).Sub(n.Value, n.approxDelta())
+}
+
+// ApproxRangeHighExcl represents the high end of the range matched by the "ap"
+// (approximately) prefix, which is 10% of the value above it, or the high end of
+// the normal range if that is higher.
+func (n *Number) ApproxRangeHighExcl() *big.Rat {
+	return new(big.Rat).Add(n.Value, n.approxDelta())
+}
+
+// ValueDecimal128 returns the value as a BSON Decimal128, so that it can be
+// compared in MongoDB without the loss of precision of a float64.  It fails for
+// numbers with more significant digits than a Decimal128 has (34).
+func (n *Number) ValueDecimal128() (primitive.Decimal128, error) {
+	return toDecimal128(n.Value, n.Precision)
+}
+
+// RangeLowInclDecimal128 returns RangeLowIncl as a BSON Decimal128.
+func (n *Number) RangeLowInclDecimal128() (primitive.Decimal128, error) {
+	return toDecimal128(n.RangeLowIncl(), n.Precision+1)
+}
+
+// RangeHighExclDecimal128 returns RangeHighExcl as a BSON Decimal128.
+func (n *Number) RangeHighExclDecimal128() (primitive.Decimal128, error) {
+	return toDecimal128(n.RangeHighExcl(), n.Precision+1)
+}
+
+// ApproxRangeLowInclDecimal128 returns ApproxRangeLowIncl as a BSON Decimal128.
+func (n *Number) ApproxRangeLowInclDecimal128() (primitive.Decimal128, error) {
+	return toDecimal128(n.ApproxRangeLowIncl(), n.Precision+1)
+}
+
+// ApproxRangeHighExclDecimal128 returns ApproxRangeHighExcl as a BSON Decimal128.
+func (n *Number) ApproxRangeHighExclDecimal128() (primitive.Decimal128, error) {
+	return toDecimal128(n.ApproxRangeHighExcl(), n.Precision+1)
+}
+
+// The range delta has one more decimal place than the number itself, so
+// formatting with that many places is exact.
+func toDecimal128(r *big.Rat, decimalPlaces int) (primitive.Decimal128, error) {
+	str := r.FloatString(decimalPlaces)
+	d, err := primitive.ParseDecimal128(str)
+	if err != nil {
+		return primitive.Decimal128{}, fmt.Errorf("number can't be represented as a Decimal128: %s", str)
+	}
+	return d, nil
+}
+
 // The FHIR spec defines equality for 100 to be the range [99.5, 100.5) so we
 // must support min/max using rounding semantics. The basic algorithm for
 // determining low/high is:
@@ -48,6 +103,17 @@ func (n *Number) rangeDelta() *big.Rat {
 	return new(big.Rat).Quo(new(big.Rat).SetInt64(5), denomRat)
 }
 
+// The spec recommends 10% of the value as the approximation, which (like the range
+// delta) has one more decimal place than the number itself.
+func (n *Number) approxDelta() *big.Rat {
+	delta := new(big.Rat).Abs(n.Value)
+	delta.Quo(delta, new(big.Rat).SetInt64(10))
+	if rangeDelta := n.rangeDelta(); delta.Cmp(rangeDelta) < 0 {
+		return rangeDelta
+	}
+	return delta
+}
+
 // ParseNumber parses a numeric string into a Number object, maintaining the
 // value and precision supplied.
 func ParseNumber(numStr string) *Number {